@@ -0,0 +1,176 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/elastic/go-ucfg"
+	"github.com/spf13/cobra"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/build"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ver"
+)
+
+const (
+	kConnect = "connect"
+	kQuiet   = "quiet"
+)
+
+var errCheckFailed = errors.New("configuration check failed")
+
+// checkReport writes the result of each check, only failures are written when quiet is set.
+type checkReport struct {
+	out    io.Writer
+	errOut io.Writer
+	quiet  bool
+	failed bool
+}
+
+func (r *checkReport) ok(format string, args ...interface{}) {
+	if !r.quiet {
+		fmt.Fprintf(r.out, "OK    "+format+"\n", args...)
+	}
+}
+
+func (r *checkReport) warn(format string, args ...interface{}) {
+	if !r.quiet {
+		fmt.Fprintf(r.out, "WARN  "+format+"\n", args...)
+	}
+}
+
+func (r *checkReport) fail(format string, args ...interface{}) {
+	r.failed = true
+	fmt.Fprintf(r.errOut, "FAIL  "+format+"\n", args...)
+}
+
+func getCheckConfigCommand(bi build.Info) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		quiet, err := cmd.Flags().GetBool(kQuiet)
+		if err != nil {
+			return err
+		}
+		connect, err := cmd.Flags().GetBool(kConnect)
+		if err != nil {
+			return err
+		}
+		strict, err := cmd.Flags().GetBool(kStrictConfig)
+		if err != nil {
+			return err
+		}
+		r := &checkReport{out: cmd.OutOrStdout(), errOut: cmd.ErrOrStderr(), quiet: quiet}
+
+		cfgObject := cmd.Flags().Lookup("E").Value.(*config.Flag) //nolint:errcheck // we know the flag exists
+		cfg := checkConfigFile(cmd, cfgObject.Config(), strict, r)
+		if cfg != nil && connect {
+			checkElasticsearch(cmd.Context(), cfg, bi, r)
+		}
+		if r.failed {
+			return errCheckFailed
+		}
+		return nil
+	}
+}
+
+// checkConfigFile reports the problems found by the validation of the configuration.
+// It returns nil if the configuration can not be used.
+func checkConfigFile(cmd *cobra.Command, cliCfg *ucfg.Config, strict bool, r *checkReport) *config.Config {
+	cfgPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		r.fail("config: %v", err)
+		return nil
+	}
+	cfgData, err := readConfigFile(cmd, cliCfg)
+	if err != nil {
+		r.fail("config: unable to read %s: %v", cfgPath, err)
+		return nil
+	}
+	cfg, warnings, err := config.ValidateConfig(cfgData, strict)
+	for _, w := range warnings {
+		r.warn("config: %s", w.Error())
+	}
+	var vErr *config.ValidationError
+	switch {
+	case errors.As(err, &vErr):
+		for _, fe := range vErr.Errors {
+			r.fail("config: %s", fe.Error())
+		}
+		return nil
+	case err != nil:
+		r.fail("config: %v", err)
+		return nil
+	}
+	r.ok("config: %s is valid", cfgPath)
+	return cfg
+}
+
+// checkElasticsearch connects to the configured Elasticsearch output to check that it is reachable,
+// that its version is compatible and that the credentials have the privileges fleet-server needs.
+func checkElasticsearch(ctx context.Context, cfg *config.Config, bi build.Info, r *checkReport) {
+	hosts := strings.Join(cfg.Output.Elasticsearch.Hosts, ", ")
+	cli, err := es.NewClient(ctx, cfg, false, es.WithUserAgent(build.ServiceName, bi))
+	if err != nil {
+		r.fail("elasticsearch: unable to create client: %v", err)
+		return
+	}
+
+	res, err := cli.Ping(cli.Ping.WithContext(ctx))
+	if err != nil {
+		r.fail("elasticsearch: unable to reach %s: %v", hosts, err)
+		return
+	}
+	res.Body.Close()
+	if res.IsError() {
+		r.fail("elasticsearch: ping %s failed: %s", hosts, res.Status())
+		return
+	}
+	r.ok("elasticsearch: %s is reachable", hosts)
+
+	esVersion, err := ver.CheckCompatibility(ctx, cli, bi.Version)
+	if err == nil {
+		err = ver.CheckMinimumVersion(esVersion, cfg.Output.Elasticsearch.Verification.MinVersion)
+	}
+	switch {
+	case esVersion == "":
+		r.fail("elasticsearch: unable to fetch the version: %v", err)
+	case err != nil:
+		r.fail("elasticsearch: version %s is not compatible with fleet-server %s: %v", esVersion, bi.Version, err)
+	default:
+		r.ok("elasticsearch: version %s is compatible with fleet-server %s", esVersion, bi.Version)
+	}
+
+	missing, err := es.CheckPrivileges(ctx, cli)
+	switch {
+	case err != nil:
+		r.fail("elasticsearch: unable to check privileges: %v", err)
+	case len(missing) > 0:
+		r.fail("elasticsearch: missing privileges: %s", strings.Join(missing, ", "))
+	default:
+		r.ok("elasticsearch: credentials have the required privileges")
+	}
+}
+
+func newCheckConfigCommand(bi build.Info) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check-config",
+		Short: "Validate the configuration and optionally the connection to Elasticsearch",
+		RunE:  getCheckConfigCommand(bi),
+		// The report already describes the failures.
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	cmd.Flags().StringP("config", "c", "fleet-server.yml", "Configuration for Fleet Server")
+	cmd.Flags().Bool(kStrictConfig, false, "Reject unknown keys in the configuration file")
+	cmd.Flags().Bool(kConnect, false, "Connect to Elasticsearch to check its version and the privileges of the credentials")
+	cmd.Flags().Bool(kQuiet, false, "Only print failures")
+	cmd.Flags().VarP(config.NewFlag(), "E", "E", "Overwrite configuration value")
+	return cmd
+}
@@ -0,0 +1,113 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleet
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/build"
+)
+
+// runCheckConfig runs the check-config subcommand with the configuration file data.
+func runCheckConfig(t *testing.T, data string, args ...string) (string, string, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fleet-server.yml")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+	var stdout, stderr bytes.Buffer
+	cmd := NewCommand(build.Info{Version: "8.5.0"})
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs(append([]string{"check-config", "-c", path}, args...))
+	err := cmd.Execute()
+	return stdout.String(), stderr.String(), err
+}
+
+func TestCheckConfig(t *testing.T) {
+	valid := fmt.Sprintf(reloadTestConfig, "1m")
+
+	t.Run("valid config", func(t *testing.T) {
+		stdout, stderr, err := runCheckConfig(t, valid)
+		require.NoError(t, err)
+		require.Contains(t, stdout, "OK    config:")
+		require.Empty(t, stderr)
+	})
+
+	t.Run("quiet", func(t *testing.T) {
+		stdout, stderr, err := runCheckConfig(t, valid+"unknown_key: true\n", "--quiet")
+		require.NoError(t, err)
+		require.Empty(t, stdout)
+		require.Empty(t, stderr)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		stdout, _, err := runCheckConfig(t, valid+"unknown_key: true\n")
+		require.NoError(t, err)
+		require.Contains(t, stdout, "WARN  config: unknown_key: unknown configuration key")
+
+		_, stderr, err := runCheckConfig(t, valid+"unknown_key: true\n", "--strict-config")
+		require.ErrorIs(t, err, errCheckFailed)
+		require.Contains(t, stderr, "FAIL  config: unknown_key: unknown configuration key")
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		_, stderr, err := runCheckConfig(t, fmt.Sprintf(reloadTestConfig, "soon")+"  - type: fleet-server\n    server:\n      port: -1\n", "--quiet")
+		require.ErrorIs(t, err, errCheckFailed)
+		require.Contains(t, stderr, "FAIL  config: inputs.0.server.timeouts.checkin_long_poll: must be a duration")
+	})
+
+	t.Run("unreadable file", func(t *testing.T) {
+		_, stderr, err := runCheckConfig(t, "inputs: [")
+		require.ErrorIs(t, err, errCheckFailed)
+		require.Contains(t, stderr, "FAIL  config: unable to read")
+	})
+}
+
+func TestCheckConfigConnect(t *testing.T) {
+	var privileges string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `{"version":{"number":"8.5.0"}}`)
+		case "/_security/user/_has_privileges":
+			fmt.Fprint(w, privileges)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	cfg := fmt.Sprintf(`output:
+  elasticsearch:
+    hosts: [%q]
+    service_token: "test-token"
+`, server.URL)
+
+	t.Run("all privileges", func(t *testing.T) {
+		privileges = `{"has_all_requested":true}`
+		stdout, stderr, err := runCheckConfig(t, cfg, "--connect")
+		require.NoError(t, err, stderr)
+		require.Contains(t, stdout, "OK    elasticsearch: "+server.URL+" is reachable")
+		require.Contains(t, stdout, "OK    elasticsearch: version 8.5.0 is compatible")
+		require.Contains(t, stdout, "OK    elasticsearch: credentials have the required privileges")
+	})
+
+	t.Run("missing privileges", func(t *testing.T) {
+		privileges = `{"has_all_requested":false,"cluster":{"monitor":true,"manage_own_api_key":false},"index":{".fleet-*":{"read":true,"write":true,"monitor":true,"create_index":true,"auto_configure":true,"maintenance":true}}}`
+		stdout, stderr, err := runCheckConfig(t, cfg, "--connect", "--quiet")
+		require.ErrorIs(t, err, errCheckFailed)
+		require.Empty(t, stdout)
+		require.Equal(t, "FAIL  elasticsearch: missing privileges: cluster:manage_own_api_key\n", stderr)
+	})
+}
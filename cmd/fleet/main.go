@@ -12,7 +12,7 @@ import (
 
 	"go.elastic.co/apm/v2"
 
-	"github.com/elastic/go-ucfg/yaml"
+	"github.com/elastic/go-ucfg"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/build"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
@@ -27,7 +27,10 @@ import (
 )
 
 const (
-	kAgentMode = "agent-mode"
+	kAgentMode        = "agent-mode"
+	kStrictConfig     = "strict-config"
+	kSkipVerification = "skip-verification"
+	kForce            = "force"
 )
 
 func init() {
@@ -40,25 +43,73 @@ func installSignalHandler() context.Context {
 	return signal.HandleInterrupt(rootCtx)
 }
 
-func initLogger(cfg *config.Config, version, commit string) (*logger.Logger, error) {
+func initLogger(cfg *config.Config, bi build.Info) (*logger.Logger, error) {
 	l, err := logger.Init(cfg, build.ServiceName)
 	if err != nil {
 		return nil, err
 	}
 
 	log.Info().
-		Str("version", version).
-		Str("commit", commit).
+		Str("version", bi.Version).
+		Str("commit", bi.Commit).
+		Time("build_time", bi.BuildTime).
 		Int("pid", os.Getpid()).
 		Int("ppid", os.Getppid()).
 		Str("exe", os.Args[0]).
 		Strs("args", os.Args[1:]).
 		Msg("Boot fleet-server")
 	log.Debug().Strs("env", os.Environ()).Msg("environment variables")
+	// the configuration is marshalled with its secrets redacted
+	log.Debug().Interface("config", cfg).Msg("Loaded configuration")
 
 	return l, err
 }
 
+// loadStandaloneConfig reads the configuration file referenced by the config flag,
+// expanding environment variables and applying their overrides,
+// merges the CLI overrides, validates the result and loads the stand-alone agent metadata.
+// Unknown keys are logged, or rejected when the strict-config flag is set.
+func loadStandaloneConfig(cmd *cobra.Command, cliCfg *ucfg.Config) (*config.Config, error) {
+	cfgData, err := readConfigFile(cmd, cliCfg)
+	if err != nil {
+		return nil, err
+	}
+	strict, err := cmd.Flags().GetBool(kStrictConfig)
+	if err != nil {
+		return nil, err
+	}
+	cfg, warnings, err := config.ValidateConfig(cfgData, strict)
+	for _, w := range warnings {
+		log.Warn().Str("key", w.Path).Msg(w.Msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.LoadStandaloneAgentMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readConfigFile reads the configuration file referenced by the config flag and merges the CLI overrides.
+func readConfigFile(cmd *cobra.Command, cliCfg *ucfg.Config) (*ucfg.Config, error) {
+	cfgPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return nil, err
+	}
+	cfgData, err := config.ReadFile(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	err = cfgData.Merge(cliCfg, config.DefaultOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return cfgData, nil
+}
+
 func getRunCommand(bi build.Info) func(cmd *cobra.Command, args []string) error {
 	return func(cmd *cobra.Command, args []string) error {
 		cfgObject := cmd.Flags().Lookup("E").Value.(*config.Flag) //nolint:errcheck // we know the flag exists
@@ -68,6 +119,26 @@ func getRunCommand(bi build.Info) func(cmd *cobra.Command, args []string) error
 		if err != nil {
 			return err
 		}
+		skipVerification, err := cmd.Flags().GetBool(kSkipVerification)
+		if err != nil {
+			return err
+		}
+		if skipVerification {
+			// set as a CLI override so it is kept when the configuration is reloaded
+			if err := cliCfg.SetBool("output.elasticsearch.verification.skip", -1, true, config.DefaultOptions...); err != nil {
+				return err
+			}
+		}
+
+		force, err := cmd.Flags().GetBool(kForce)
+		if err != nil {
+			return err
+		}
+		if force {
+			if err := cliCfg.SetBool("output.elasticsearch.verification.force", -1, true, config.DefaultOptions...); err != nil {
+				return err
+			}
+		}
 
 		var l *logger.Logger
 		if agentMode {
@@ -75,7 +146,7 @@ func getRunCommand(bi build.Info) func(cmd *cobra.Command, args []string) error
 			if err != nil {
 				return err
 			}
-			l, err = initLogger(cfg, bi.Version, bi.Commit)
+			l, err = initLogger(cfg, bi)
 			if err != nil {
 				return err
 			}
@@ -91,38 +162,27 @@ func getRunCommand(bi build.Info) func(cmd *cobra.Command, args []string) error
 				return err
 			}
 		} else {
-			cfgPath, err := cmd.Flags().GetString("config")
-			if err != nil {
-				return err
-			}
-			cfgData, err := yaml.NewConfigWithFile(cfgPath, config.DefaultOptions...)
-			if err != nil {
-				return err
-			}
-			err = cfgData.Merge(cliCfg, config.DefaultOptions...)
-			if err != nil {
-				return err
-			}
-			cfg, err := config.FromConfig(cfgData)
+			cfg, err := loadStandaloneConfig(cmd, cliCfg)
 			if err != nil {
 				return err
 			}
 
-			err = cfg.LoadStandaloneAgentMetadata()
+			l, err = initLogger(cfg, bi)
 			if err != nil {
 				return err
 			}
 
-			l, err = initLogger(cfg, bi.Version, bi.Commit)
+			ctx := installSignalHandler()
+			srv, err := server.NewFleet(bi, state.NewLog(zerolog.Ctx(ctx)), true)
 			if err != nil {
 				return err
 			}
 
-			ctx := installSignalHandler()
-			srv, err := server.NewFleet(bi, state.NewLog(zerolog.Ctx(ctx)), true)
+			watch, err := cmd.Flags().GetBool(kWatchConfig)
 			if err != nil {
 				return err
 			}
+			go runConfigReloader(ctx, cmd, cliCfg, srv, l, watch)
 
 			if err := srv.Run(ctx, cfg); err != nil && !errors.Is(err, context.Canceled) {
 				log.Error().Err(err).Msg("Exiting")
@@ -145,6 +205,13 @@ func NewCommand(bi build.Info) *cobra.Command {
 	}
 	cmd.Flags().StringP("config", "c", "fleet-server.yml", "Configuration for Fleet Server")
 	cmd.Flags().Bool(kAgentMode, false, "Running under execution of the Elastic Agent")
+	cmd.Flags().Bool(kStrictConfig, false, "Reject unknown keys in the configuration file")
+	cmd.Flags().Bool(kWatchConfig, false, "Reload the configuration when the configuration file changes")
+	cmd.Flags().Bool(kSkipVerification, false, "Start without verifying the Elasticsearch version and privileges")
+	cmd.Flags().Bool(kForce, false, "Start in degraded mode when the mappings of the Fleet indices are incompatible")
 	cmd.Flags().VarP(config.NewFlag(), "E", "E", "Overwrite configuration value")
+	cmd.AddCommand(newSetupCommand(bi))
+	cmd.AddCommand(newCheckConfigCommand(bi))
+	cmd.AddCommand(newVersionCommand(bi))
 	return cmd
 }
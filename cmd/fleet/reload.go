@@ -0,0 +1,97 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleet
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/signal"
+	"github.com/elastic/go-ucfg"
+)
+
+const kWatchConfig = "watch-config"
+
+// configWatchInterval is how often the configuration file is checked for changes when watching is enabled.
+var configWatchInterval = 5 * time.Second
+
+// configReloadable applies a reloaded configuration to the running server.
+type configReloadable interface {
+	ReloadConfig(context.Context, *config.Config) (*config.Config, error)
+}
+
+// loggerReloadable applies a reloaded configuration to the logger.
+type loggerReloadable interface {
+	Reload(context.Context, *config.Config) error
+}
+
+// runConfigReloader re-reads the stand-alone configuration when the process receives a SIGHUP,
+// or when watch is set, when the modification time of the configuration file changes.
+// It blocks until ctx is cancelled.
+func runConfigReloader(ctx context.Context, cmd *cobra.Command, cliCfg *ucfg.Config, srv configReloadable, l loggerReloadable, watch bool) {
+	log := zerolog.Ctx(ctx)
+	hup := signal.HandleHangup(ctx)
+
+	var tick <-chan time.Time
+	var modTime time.Time
+	cfgPath, _ := cmd.Flags().GetString("config")
+	if watch {
+		t := time.NewTicker(configWatchInterval)
+		defer t.Stop()
+		tick = t.C
+		modTime = fileModTime(cfgPath)
+		log.Info().Str("path", cfgPath).Msg("Watching configuration file for changes")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-tick:
+			mt := fileModTime(cfgPath)
+			if mt.Equal(modTime) {
+				continue
+			}
+			modTime = mt
+			log.Info().Str("path", cfgPath).Msg("Configuration file changed")
+		}
+		reloadConfig(ctx, cmd, cliCfg, srv, l)
+	}
+}
+
+// reloadConfig loads the configuration file and applies it.
+// An invalid configuration is rejected as a whole and the running configuration is kept.
+func reloadConfig(ctx context.Context, cmd *cobra.Command, cliCfg *ucfg.Config, srv configReloadable, l loggerReloadable) {
+	log := zerolog.Ctx(ctx)
+	log.Info().Msg("Reloading configuration")
+
+	cfg, err := loadStandaloneConfig(cmd, cliCfg)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to load configuration, keeping the running configuration")
+		return
+	}
+	cfg, err = srv.ReloadConfig(ctx, cfg)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to reload configuration, keeping the running configuration")
+		return
+	}
+	if err := l.Reload(ctx, cfg); err != nil {
+		log.Error().Err(err).Msg("Unable to reload logger configuration")
+	}
+}
+
+func fileModTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
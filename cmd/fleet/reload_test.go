@@ -0,0 +1,96 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleet
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/build"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+const reloadTestConfig = `output:
+  elasticsearch:
+    hosts: ["localhost:9200"]
+    service_token: "test-token"
+inputs:
+  - type: fleet-server
+    server:
+      timeouts:
+        checkin_long_poll: %s
+`
+
+type mockReloadable struct {
+	cfgs chan *config.Config
+}
+
+func (m *mockReloadable) ReloadConfig(_ context.Context, cfg *config.Config) (*config.Config, error) {
+	m.cfgs <- cfg
+	return cfg, nil
+}
+
+func (m *mockReloadable) Reload(context.Context, *config.Config) error {
+	return nil
+}
+
+func writeReloadConfig(t *testing.T, path, data string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+}
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fleet-server.yml")
+	cmd := NewCommand(build.Info{})
+	require.NoError(t, cmd.Flags().Set("config", path))
+	cliCfg := cmd.Flags().Lookup("E").Value.(*config.Flag).Config() //nolint:errcheck // same as in main
+	m := &mockReloadable{cfgs: make(chan *config.Config, 1)}
+
+	// valid configuration is passed to the server
+	writeReloadConfig(t, path, fmt.Sprintf(reloadTestConfig, "1m"))
+	reloadConfig(context.Background(), cmd, cliCfg, m, m)
+	cfg := <-m.cfgs
+	require.Equal(t, time.Minute, cfg.Inputs[0].Server.Timeouts.CheckinLongPoll)
+
+	// invalid configuration is rejected
+	writeReloadConfig(t, path, "inputs: [")
+	reloadConfig(context.Background(), cmd, cliCfg, m, m)
+	require.Empty(t, m.cfgs)
+}
+
+func TestRunConfigReloaderWatch(t *testing.T) {
+	interval := configWatchInterval
+	configWatchInterval = 10 * time.Millisecond
+	t.Cleanup(func() { configWatchInterval = interval })
+
+	path := filepath.Join(t.TempDir(), "fleet-server.yml")
+	writeReloadConfig(t, path, fmt.Sprintf(reloadTestConfig, "1m"))
+	cmd := NewCommand(build.Info{})
+	require.NoError(t, cmd.Flags().Set("config", path))
+	cliCfg := cmd.Flags().Lookup("E").Value.(*config.Flag).Config() //nolint:errcheck // same as in main
+	m := &mockReloadable{cfgs: make(chan *config.Config, 1)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runConfigReloader(ctx, cmd, cliCfg, m, m, true)
+
+	// wait for the watcher to record the initial modification time before changing the file
+	time.Sleep(50 * time.Millisecond)
+	writeReloadConfig(t, path, fmt.Sprintf(reloadTestConfig, "2m"))
+	require.NoError(t, os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+
+	select {
+	case cfg := <-m.cfgs:
+		require.Equal(t, 2*time.Minute, cfg.Inputs[0].Server.Timeouts.CheckinLongPoll)
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration was not reloaded")
+	}
+}
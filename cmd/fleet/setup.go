@@ -0,0 +1,86 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/build"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/setup"
+)
+
+const kStandalone = "standalone"
+
+func getSetupCommand(bi build.Info) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		standalone, err := cmd.Flags().GetBool(kStandalone)
+		if err != nil {
+			return err
+		}
+		if !standalone {
+			return errors.New("setup is only supported with --standalone")
+		}
+
+		cfgObject := cmd.Flags().Lookup("E").Value.(*config.Flag) //nolint:errcheck // we know the flag exists
+		cfg, err := loadStandaloneConfig(cmd, cfgObject.Config())
+		if err != nil {
+			return err
+		}
+
+		l, err := initLogger(cfg, bi)
+		if err != nil {
+			return err
+		}
+		defer l.Sync()
+
+		ctx := installSignalHandler()
+		cli, err := es.NewClient(ctx, cfg, false)
+		if err != nil {
+			return err
+		}
+
+		bulkCtx, bulkCancel := context.WithCancel(ctx)
+		defer bulkCancel()
+		bulkOpts := bulk.BulkOptsFromCfg(cfg)
+		bulkOpts = append(bulkOpts, bulk.WithBi(bi))
+		bulker := bulk.NewBulker(cli, nil, bulkOpts...)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- bulker.Run(bulkCtx)
+		}()
+
+		res, err := setup.Run(ctx, bulker, cfg)
+		bulkCancel()
+		if bErr := <-errCh; bErr != nil && !errors.Is(bErr, context.Canceled) {
+			log.Error().Err(bErr).Msg("Bulker exited")
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Policy ID: %s\nEnrollment token: %s\n", res.PolicyID, res.EnrollmentToken)
+		return nil
+	}
+}
+
+func newSetupCommand(bi build.Info) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "setup",
+		Short: "Bootstrap the documents Fleet Server needs to run without Kibana",
+		RunE:  getSetupCommand(bi),
+	}
+	cmd.Flags().StringP("config", "c", "fleet-server.yml", "Configuration for Fleet Server")
+	cmd.Flags().Bool(kStandalone, false, "Create a default policy and enrollment key for a stand-alone Fleet Server")
+	cmd.Flags().VarP(config.NewFlag(), "E", "E", "Overwrite configuration value")
+	return cmd
+}
@@ -0,0 +1,66 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleet
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/build"
+)
+
+const (
+	kFormat = "format"
+
+	formatText = "text"
+	formatJSON = "json"
+)
+
+func getVersionCommand(bi build.Info) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		format, err := cmd.Flags().GetString(kFormat)
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		switch format {
+		case formatJSON:
+			return json.NewEncoder(out).Encode(bi)
+		case formatText:
+			_, err = fmt.Fprintf(out, "%s version %s (commit: %s, build time: %s)\n", build.ServiceName, bi.Version, unknownIfEmpty(bi.Commit), buildTimeString(bi))
+			return err
+		default:
+			return fmt.Errorf("unsupported format %q, expected %s or %s", format, formatText, formatJSON)
+		}
+	}
+}
+
+func unknownIfEmpty(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+func buildTimeString(bi build.Info) string {
+	if bi.BuildTime.IsZero() {
+		return "unknown"
+	}
+	return bi.BuildTime.Format(time.RFC3339)
+}
+
+func newVersionCommand(bi build.Info) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print the version, commit and build time of Fleet Server",
+		Args:  cobra.NoArgs,
+		RunE:  getVersionCommand(bi),
+	}
+	cmd.Flags().String(kFormat, formatText, "Output format, text or json")
+	return cmd
+}
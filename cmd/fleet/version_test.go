@@ -0,0 +1,54 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleet
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/build"
+)
+
+func runVersion(t *testing.T, bi build.Info, args ...string) (string, error) {
+	t.Helper()
+	var stdout bytes.Buffer
+	cmd := NewCommand(bi)
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(append([]string{"version"}, args...))
+	err := cmd.Execute()
+	return stdout.String(), err
+}
+
+func TestVersionCommand(t *testing.T) {
+	bi := build.Info{Version: "8.18.0", Commit: "31668e0", BuildTime: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)}
+
+	t.Run("text", func(t *testing.T) {
+		out, err := runVersion(t, bi)
+		require.NoError(t, err)
+		require.Equal(t, "fleet-server version 8.18.0 (commit: 31668e0, build time: 2025-03-01T12:00:00Z)\n", out)
+
+		out, err = runVersion(t, build.Info{Version: "8.18.0"})
+		require.NoError(t, err)
+		require.Equal(t, "fleet-server version 8.18.0 (commit: unknown, build time: unknown)\n", out)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		out, err := runVersion(t, bi, "--format", "json")
+		require.NoError(t, err)
+		var got build.Info
+		require.NoError(t, json.Unmarshal([]byte(out), &got))
+		require.Equal(t, bi, got)
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		_, err := runVersion(t, bi, "--format", "yaml")
+		require.ErrorContains(t, err, `unsupported format "yaml"`)
+	})
+}
@@ -0,0 +1,62 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// loadtest simulates agents that enroll with an enrollment token, check in and ack the actions they receive against
+// a Fleet Server, then reports the latency percentiles and the errors of the enroll, checkin and ack requests.
+// It is not part of the fleet-server binary, run it with go run ./dev-tools/loadtest.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/loadtest"
+	"github.com/elastic/fleet-server/v7/version"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout io.Writer) error {
+	var cfg loadtest.Config
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.StringVar(&cfg.URL, "url", "https://localhost:8220", "URL of the Fleet Server")
+	fs.StringVar(&cfg.EnrollmentToken, "enrollment-token", "", "Enrollment token the agents enroll with")
+	fs.IntVar(&cfg.Agents, "agents", 100, "Number of simulated agents")
+	fs.StringVar(&cfg.AgentVersion, "agent-version", version.DefaultVersion, "Version reported by the agents")
+	fs.DurationVar(&cfg.Duration, "duration", time.Minute, "Duration of the test")
+	fs.DurationVar(&cfg.RampUp, "ramp-up", 10*time.Second, "Time the enrollments of the agents are spread over")
+	fs.DurationVar(&cfg.PollTimeout, "poll-timeout", 0, "Long poll timeout requested by the agents, the server default if 0")
+	fs.DurationVar(&cfg.CheckinInterval, "checkin-interval", time.Second, "Time an agent waits between its checkins")
+	insecure := fs.Bool("insecure", false, "Do not verify the certificate of the Fleet Server")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:errcheck // the default transport is a *http.Transport
+	// each simulated agent keeps its connection, as the agents do
+	transport.MaxIdleConnsPerHost = cfg.Agents
+	if *insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec // requested to test servers with self-signed certificates
+	}
+	cfg.Client = &http.Client{Transport: transport}
+
+	report, err := loadtest.Run(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	return report.Write(stdout)
+}
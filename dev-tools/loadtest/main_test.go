@@ -0,0 +1,33 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	var stdout bytes.Buffer
+	err := run(context.Background(), []string{"-url", srv.URL, "-enrollment-token", "token", "-agents", "2", "-duration", "100ms", "-ramp-up", "0s"}, &stdout)
+	require.NoError(t, err)
+	require.Contains(t, stdout.String(), "enroll error: status 401 (2)")
+
+	err = run(context.Background(), []string{"-url", srv.URL, "-agents", "2"}, io.Discard)
+	require.ErrorContains(t, err, "the enrollment token is not set")
+}
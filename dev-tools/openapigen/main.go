@@ -0,0 +1,26 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// openapigen generates the JSON OpenAPI document served by fleet-server from model/openapi.yml.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/openapigen"
+)
+
+func main() {
+	root := flag.String("root", ".", "root of the repository")
+	flag.Parse()
+
+	p, err := openapigen.Generate(*root)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := openapigen.Write(*root, p); err != nil {
+		log.Fatal(err)
+	}
+}
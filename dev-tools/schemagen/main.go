@@ -0,0 +1,26 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// schemagen generates the model structs, the dl field constants and the es mappings from the files of model/schema.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/schemagen"
+)
+
+func main() {
+	root := flag.String("root", ".", "root of the repository")
+	flag.Parse()
+
+	files, err := schemagen.Generate(*root)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := schemagen.Write(*root, files); err != nil {
+		log.Fatal(err)
+	}
+}
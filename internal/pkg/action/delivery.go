@@ -0,0 +1,280 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package action
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	estypes "github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/scriptlanguage"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+)
+
+const (
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusStuck     = "stuck"
+
+	deliveryCacheSize = 10000
+)
+
+//go:embed delivery.painless
+var deliveryScript string
+
+type deliveryKey struct {
+	actionID string
+	agentID  string
+}
+
+// DeliveryTracker records a marker in the action results index each time an action is delivered to an agent.
+// Markers are batched and written at the flush interval so recording a delivery never delays a checkin response.
+// Redeliveries increment the attempt count of the marker, the delivery is marked as stuck once the count exceeds the configured max.
+// The markers that fail to be written, or are dropped by the bulker, are requeued to the next flush. They are counted as
+// lost when the pending set is full or on shutdown.
+type DeliveryTracker struct {
+	bulker          bulk.Bulk
+	flushInterval   time.Duration
+	maxRedeliveries int
+
+	mut     sync.Mutex
+	pending map[deliveryKey]int
+
+	// attempts holds the deliveries seen by this instance, it is used to log the stuck transition.
+	attempts *lru.Cache[deliveryKey, int]
+
+	written  monitoring.Uint
+	requeued monitoring.Uint
+	lost     monitoring.Uint
+}
+
+// NewDeliveryTracker returns a DeliveryTracker, or nil if delivery tracking is disabled.
+func NewDeliveryTracker(bulker bulk.Bulk, cfg config.DeliveryTracking) (*DeliveryTracker, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	attempts, err := lru.New[deliveryKey, int](deliveryCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	return &DeliveryTracker{
+		bulker:          bulker,
+		flushInterval:   cfg.FlushInterval,
+		maxRedeliveries: cfg.MaxRedeliveries,
+		pending:         make(map[deliveryKey]int),
+		attempts:        attempts,
+	}, nil
+}
+
+// Delivered adds the actions delivered to the agent to the pending set.
+// It is safe to call on a nil DeliveryTracker.
+func (t *DeliveryTracker) Delivered(log zerolog.Logger, agentID string, actionIDs ...string) {
+	if t == nil {
+		return
+	}
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	for _, actionID := range actionIDs {
+		key := deliveryKey{actionID: actionID, agentID: agentID}
+		t.pending[key]++
+
+		n, _ := t.attempts.Get(key)
+		n++
+		t.attempts.Add(key, n)
+		if t.maxRedeliveries > 0 && n == t.maxRedeliveries+1 {
+			log.Warn().
+				Str(logger.ActionID, actionID).
+				Str(logger.AgentID, agentID).
+				Int("attempts", n).
+				Msg("Action delivery is stuck, the action was delivered again without being acknowledged")
+		}
+	}
+}
+
+// Register registers the counts of the written, requeued and lost delivery markers in reg.
+func (t *DeliveryTracker) Register(reg *monitoring.Registry) {
+	reg.Add("written", &t.written, monitoring.Full)
+	reg.Add("requeued", &t.requeued, monitoring.Full)
+	reg.Add("lost", &t.lost, monitoring.Full)
+}
+
+// Schedule returns the schedule that writes the pending delivery markers at the flush interval, and a last time on shutdown.
+// Like the checkin flushes, the writes start with the scheduler and are spread by 10%.
+func (t *DeliveryTracker) Schedule() scheduler.Schedule {
+	return scheduler.Schedule{
+		Name:       "action delivery tracker",
+		Interval:   t.flushInterval,
+		Jitter:     0.1,
+		RunOnStart: true,
+		WorkFn:     t.flush,
+		StopFn: func(ctx context.Context) error {
+			// there is no next flush to requeue the markers that are not written to
+			failed := t.write(ctx)
+			t.mut.Lock()
+			defer t.mut.Unlock()
+			t.lost.Add(uint64(len(failed) + len(t.pending))) //nolint:gosec // disable G115
+			t.pending = make(map[deliveryKey]int)
+			return nil
+		},
+	}
+}
+
+// flush upserts the pending delivery markers, and requeues the markers that are not written.
+func (t *DeliveryTracker) flush(ctx context.Context) error {
+	t.requeue(zerolog.Ctx(ctx), t.write(ctx))
+	return nil
+}
+
+// requeue adds the failed markers back to the pending set, merged with the deliveries recorded since they were taken.
+// The markers that do not fit in the pending set are counted as lost.
+func (t *DeliveryTracker) requeue(log *zerolog.Logger, failed map[deliveryKey]int) {
+	if len(failed) == 0 {
+		return
+	}
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	var lost int
+	for key, count := range failed {
+		if _, ok := t.pending[key]; !ok && len(t.pending) >= deliveryCacheSize {
+			lost++
+			continue
+		}
+		t.pending[key] += count
+	}
+	t.requeued.Add(uint64(len(failed) - lost)) //nolint:gosec // disable G115
+	if lost > 0 {
+		t.lost.Add(uint64(lost)) //nolint:gosec // disable G115
+		log.Warn().Int("cnt", lost).Msg("Delivery markers lost, too many markers are waiting to be written")
+	}
+}
+
+// write upserts the pending delivery markers, and returns the markers that are not written.
+func (t *DeliveryTracker) write(ctx context.Context) map[deliveryKey]int {
+	start := time.Now()
+
+	t.mut.Lock()
+	pending := t.pending
+	t.pending = make(map[deliveryKey]int, len(pending))
+	t.mut.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	now := ftime.Format(start)
+	scriptedUpsert := true
+	keys := make([]deliveryKey, 0, len(pending))
+	ops := make([]bulk.MultiOp, 0, len(pending))
+	for key, count := range pending {
+		params, err := encodeDeliveryParams(now, key, count, t.maxRedeliveries)
+		if err != nil {
+			// the parameters are strings and numbers, they are always encoded
+			t.lost.Inc()
+			zerolog.Ctx(ctx).Error().Err(err).Msg("could not encode delivery marker")
+			continue
+		}
+		action := &estypes.UpdateAction{
+			Script: &estypes.Script{
+				Lang:    &scriptlanguage.Painless,
+				Source:  &deliveryScript,
+				Options: map[string]string{},
+				Params:  params,
+			},
+			ScriptedUpsert: &scriptedUpsert,
+			Upsert:         json.RawMessage(`{}`),
+		}
+		body, err := json.Marshal(action)
+		if err != nil {
+			t.lost.Inc()
+			zerolog.Ctx(ctx).Error().Err(fmt.Errorf("could not marshal delivery script action: %w", err)).Send()
+			continue
+		}
+		keys = append(keys, key)
+		ops = append(ops, bulk.MultiOp{
+			ID:    deliveryID(key),
+			Body:  body,
+			Index: dl.FleetActionsResults,
+		})
+	}
+
+	items, err := t.bulker.MUpdate(ctx, ops, bulk.WithDroppable())
+	failed := make(map[deliveryKey]int)
+	var written, lost int
+	for i, key := range keys {
+		var status int
+		if i < len(items) {
+			status = items[i].Status
+		}
+		switch {
+		case status >= 200 && status < 300:
+			written++
+		case status == 0 && errors.Is(err, bulk.ErrSpooled):
+			// the markers spooled while elasticsearch is unavailable are written once it is back
+			written++
+		case status == 0, status == http.StatusTooManyRequests, status >= 500:
+			// the request failed or the marker was dropped from the low priority lane of the bulker
+			failed[key] = pending[key]
+		default:
+			// the update is rejected, it would be rejected again
+			lost++
+		}
+	}
+	t.written.Add(uint64(written)) //nolint:gosec // disable G115
+	t.lost.Add(uint64(lost))       //nolint:gosec // disable G115
+
+	zerolog.Ctx(ctx).Trace().
+		Err(err).
+		Dur("rtt", time.Since(start)).
+		Int("cnt", len(ops)).
+		Int("failed", len(failed)).
+		Int("lost", lost).
+		Msg("Flush delivery markers")
+
+	return failed
+}
+
+// deliveryID is distinct from the id of the result written when the agent acks the action.
+// It is shared with the delivery records of the targeted actions so an action has one marker per agent.
+func deliveryID(key deliveryKey) string {
+	return dl.ActionDeliveryID(key.actionID, key.agentID)
+}
+
+func encodeDeliveryParams(now string, key deliveryKey, count, maxRedeliveries int) (map[string]json.RawMessage, error) {
+	values := map[string]any{
+		"Now":             now,
+		"ActionID":        key.actionID,
+		"AgentID":         key.agentID,
+		"Count":           count,
+		"MaxRedeliveries": maxRedeliveries,
+		"StatusDelivered": DeliveryStatusDelivered,
+		"StatusStuck":     DeliveryStatusStuck,
+	}
+	params := make(map[string]json.RawMessage, len(values))
+	for k, v := range values {
+		p, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		params[k] = p
+	}
+	return params, nil
+}
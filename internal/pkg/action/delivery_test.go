@@ -0,0 +1,201 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package action
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+)
+
+type deliveryUpdate struct {
+	Script struct {
+		Params struct {
+			ActionID        string `json:"ActionID"`
+			AgentID         string `json:"AgentID"`
+			Count           int    `json:"Count"`
+			MaxRedeliveries int    `json:"MaxRedeliveries"`
+		} `json:"params"`
+	} `json:"script"`
+	ScriptedUpsert bool `json:"scripted_upsert"`
+}
+
+func newTestDeliveryTracker(t *testing.T, bulker bulk.Bulk, maxRedeliveries int) *DeliveryTracker {
+	t.Helper()
+	dt, err := NewDeliveryTracker(bulker, config.DeliveryTracking{Enabled: true, MaxRedeliveries: maxRedeliveries, FlushInterval: time.Second})
+	require.NoError(t, err)
+	require.NotNil(t, dt)
+	return dt
+}
+
+// flushOps flushes the tracker and returns the update bodies keyed by document id.
+func flushOps(t *testing.T, dt *DeliveryTracker) map[string]deliveryUpdate {
+	t.Helper()
+	bulker := ftesting.NewMockBulk()
+	ops := make(map[string]deliveryUpdate)
+	bulker.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		for _, op := range args.Get(1).([]bulk.MultiOp) {
+			require.Equal(t, dl.FleetActionsResults, op.Index)
+			var u deliveryUpdate
+			require.NoError(t, json.Unmarshal(op.Body, &u))
+			ops[op.ID] = u
+		}
+	}).Return(itemsWithStatus(len(dt.pending), http.StatusOK), nil)
+	dt.bulker = bulker
+	require.NoError(t, dt.flush(context.Background()))
+	return ops
+}
+
+func TestDeliveryTrackerDisabled(t *testing.T) {
+	dt, err := NewDeliveryTracker(ftesting.NewMockBulk(), config.DeliveryTracking{Enabled: false})
+	require.NoError(t, err)
+	require.Nil(t, dt)
+	// nil tracker is a no-op
+	dt.Delivered(zerolog.Nop(), "agent-1", "action-1")
+}
+
+func TestDeliveryTrackerFirstDelivery(t *testing.T) {
+	dt := newTestDeliveryTracker(t, nil, 3)
+	dt.Delivered(zerolog.Nop(), "agent-1", "action-1", "action-2")
+
+	ops := flushOps(t, dt)
+	require.Len(t, ops, 2)
+	u, ok := ops["action-1:agent-1:delivery"]
+	require.True(t, ok)
+	require.True(t, u.ScriptedUpsert)
+	require.Equal(t, "action-1", u.Script.Params.ActionID)
+	require.Equal(t, "agent-1", u.Script.Params.AgentID)
+	require.Equal(t, 1, u.Script.Params.Count)
+	require.Equal(t, 3, u.Script.Params.MaxRedeliveries)
+
+	// Nothing is pending after a flush
+	require.Empty(t, flushOps(t, dt))
+}
+
+func TestDeliveryTrackerRedelivery(t *testing.T) {
+	dt := newTestDeliveryTracker(t, nil, 3)
+
+	// Deliveries between flushes are combined
+	dt.Delivered(zerolog.Nop(), "agent-1", "action-1")
+	dt.Delivered(zerolog.Nop(), "agent-1", "action-1")
+	ops := flushOps(t, dt)
+	require.Len(t, ops, 1)
+	require.Equal(t, 2, ops["action-1:agent-1:delivery"].Script.Params.Count)
+
+	dt.Delivered(zerolog.Nop(), "agent-1", "action-1")
+	ops = flushOps(t, dt)
+	require.Equal(t, 1, ops["action-1:agent-1:delivery"].Script.Params.Count)
+
+	n, ok := dt.attempts.Get(deliveryKey{actionID: "action-1", agentID: "agent-1"})
+	require.True(t, ok)
+	require.Equal(t, 3, n)
+}
+
+func TestDeliveryTrackerStuck(t *testing.T) {
+	var buf bytes.Buffer
+	log := zerolog.New(&buf)
+	dt := newTestDeliveryTracker(t, nil, 2)
+
+	// first delivery and two redeliveries
+	for i := 0; i < 3; i++ {
+		dt.Delivered(log, "agent-1", "action-1")
+		if i < 2 {
+			require.Empty(t, buf.String(), "delivery %d should not be stuck", i)
+		}
+	}
+	require.Equal(t, 1, strings.Count(buf.String(), "Action delivery is stuck"))
+
+	// The transition is only logged once
+	dt.Delivered(log, "agent-1", "action-1")
+	require.Equal(t, 1, strings.Count(buf.String(), "Action delivery is stuck"))
+
+	// Other agents are tracked separately
+	dt.Delivered(log, "agent-2", "action-1")
+	require.Equal(t, 1, strings.Count(buf.String(), "Action delivery is stuck"))
+}
+
+func itemsWithStatus(n, status int) []bulk.BulkIndexerResponseItem {
+	items := make([]bulk.BulkIndexerResponseItem, n)
+	for i := range items {
+		items[i].Status = status
+	}
+	return items
+}
+
+func TestDeliveryTrackerDropped(t *testing.T) {
+	dt := newTestDeliveryTracker(t, nil, 0)
+	dt.Delivered(zerolog.Nop(), "agent-1", "action-1")
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Return(itemsWithStatus(1, 0), bulk.ErrDropped)
+	dt.bulker = bulker
+	require.NoError(t, dt.flush(context.Background()))
+
+	// the dropped marker is written by the next flush
+	require.Equal(t, uint64(1), dt.requeued.Get())
+	ops := flushOps(t, dt)
+	require.Equal(t, 1, ops["action-1:agent-1:delivery"].Script.Params.Count)
+	require.Equal(t, uint64(1), dt.written.Get())
+	require.Zero(t, dt.lost.Get())
+}
+
+func TestDeliveryTrackerRequeue(t *testing.T) {
+	dt := newTestDeliveryTracker(t, nil, 0)
+	dt.Delivered(zerolog.Nop(), "agent-1", "action-1")
+	dt.Delivered(zerolog.Nop(), "agent-2", "action-1")
+	dt.Delivered(zerolog.Nop(), "agent-3", "action-1")
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Return(func() []bulk.BulkIndexerResponseItem {
+		items := itemsWithStatus(3, http.StatusServiceUnavailable)
+		items[1].Status = http.StatusBadRequest
+		items[2].Status = http.StatusOK
+		return items
+	}(), nil).Once()
+	dt.bulker = bulker
+	require.NoError(t, dt.flush(context.Background()))
+	require.Equal(t, uint64(1), dt.written.Get())
+	require.Equal(t, uint64(1), dt.requeued.Get())
+	require.Equal(t, uint64(1), dt.lost.Get())
+
+	// the attempts of the requeued marker are merged with the new deliveries
+	dt.mut.Lock()
+	require.Len(t, dt.pending, 1)
+	var key deliveryKey
+	for k := range dt.pending {
+		key = k
+	}
+	dt.mut.Unlock()
+	dt.Delivered(zerolog.Nop(), key.agentID, key.actionID)
+	ops := flushOps(t, dt)
+	require.Len(t, ops, 1)
+	require.Equal(t, 2, ops[deliveryID(key)].Script.Params.Count)
+}
+
+func TestDeliveryTrackerStopLost(t *testing.T) {
+	dt := newTestDeliveryTracker(t, nil, 0)
+	dt.Delivered(zerolog.Nop(), "agent-1", "action-1")
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Return([]bulk.BulkIndexerResponseItem(nil), errors.New("unavailable"))
+	dt.bulker = bulker
+	require.NoError(t, dt.Schedule().StopFn(context.Background()))
+	require.Equal(t, uint64(1), dt.lost.Get())
+	require.Empty(t, dt.pending)
+}
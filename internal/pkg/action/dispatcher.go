@@ -7,14 +7,17 @@ package action
 
 import (
 	"context"
-	"sync"
 	"time"
 
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/monitor"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	"github.com/elastic/fleet-server/v7/internal/pkg/waiters"
 
 	"github.com/rs/zerolog"
 	"golang.org/x/time/rate"
@@ -24,12 +27,17 @@ import (
 type Sub struct {
 	agentID string
 	seqNo   sqn.SeqNo
-	ch      chan []model.Action
+	w       *waiters.Waiter[[]model.Action]
 }
 
 // Ch returns the emitter channel for actions.
-func (s Sub) Ch() chan []model.Action {
-	return s.ch
+func (s *Sub) Ch() <-chan []model.Action {
+	return s.w.C()
+}
+
+// Preempted returns a channel that is closed when a newer subscription of the same agent replaces this one.
+func (s *Sub) Preempted() <-chan struct{} {
+	return s.w.Preempted()
 }
 
 // Dispatcher tracks agent subscriptions and emits actions to the subscriptions.
@@ -37,21 +45,63 @@ type Dispatcher struct {
 	am    monitor.SimpleMonitor
 	limit *rate.Limiter
 
-	mx   sync.RWMutex
-	subs map[string]Sub
+	maxSubs  int
+	subs     *waiters.Registry[[]model.Action]
+	cache    cache.Cache
+	targeted *TargetedActions
 }
 
-// NewDispatcher creates a Dispatcher using the provided monitor.
-func NewDispatcher(am monitor.SimpleMonitor, throttle time.Duration, i int) *Dispatcher {
-	r := rate.Inf
-	if throttle > 0 {
-		r = rate.Every(throttle)
+// DispatcherOpt is an optional setting for Dispatcher.
+type DispatcherOpt func(*Dispatcher)
+
+// WithMaxSubscriptions limits the number of agents subscribed at the same time, the number is not limited if max is not positive.
+func WithMaxSubscriptions(max int) DispatcherOpt {
+	return func(d *Dispatcher) {
+		d.maxSubs = max
 	}
-	return &Dispatcher{
+}
+
+// WithCache adds the actions read by the monitor to c, and invalidates their not found lookups.
+// After a restore of the actions index the monitor reads it again, so c holds the restored actions.
+func WithCache(c cache.Cache) DispatcherOpt {
+	return func(d *Dispatcher) {
+		d.cache = c
+	}
+}
+
+// WithTargetedActions invalidates t when the monitor reads an action that targets a filter of agents.
+func WithTargetedActions(t *TargetedActions) DispatcherOpt {
+	return func(d *Dispatcher) {
+		d.targeted = t
+	}
+}
+
+// NewDispatcher creates a Dispatcher using the provided monitor.
+func NewDispatcher(am monitor.SimpleMonitor, throttle time.Duration, i int, opts ...DispatcherOpt) *Dispatcher {
+	d := &Dispatcher{
 		am:    am,
-		limit: rate.NewLimiter(r, i),
-		subs:  make(map[string]Sub),
+		limit: rate.NewLimiter(throttleLimit(throttle), i),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.subs = waiters.New[[]model.Action](d.maxSubs)
+	return d
+}
+
+// Reload applies the action limit and the max number of connections of cfg, the subscribed agents are kept.
+func (d *Dispatcher) Reload(cfg *config.Server) {
+	d.limit.SetLimit(throttleLimit(cfg.Limits.ActionLimit.Interval))
+	d.limit.SetBurst(cfg.Limits.ActionLimit.Burst)
+	d.subs.SetMax(cfg.Limits.MaxConnections)
+}
+
+// throttleLimit returns the rate of one event per throttle, the rate is not limited if throttle is not positive.
+func throttleLimit(throttle time.Duration) rate.Limit {
+	if throttle > 0 {
+		return rate.Every(throttle)
 	}
+	return rate.Inf
 }
 
 // Run starts the Dispatcher.
@@ -69,24 +119,21 @@ func (d *Dispatcher) Run(ctx context.Context) error {
 }
 
 // Subscribe generates a new subscription with the Dispatcher using the provided agentID and seqNo.
-// There is no check to ensure that the agentID has not been used; using the same one twice results in undefined behaviour.
-func (d *Dispatcher) Subscribe(log zerolog.Logger, agentID string, seqNo sqn.SeqNo) *Sub {
-	cbCh := make(chan []model.Action, 1)
-
-	sub := Sub{
-		agentID: agentID,
-		seqNo:   seqNo,
-		ch:      cbCh,
+// The subscription is removed when ctx is done or by Unsubscribe. A previous subscription of the agent is preempted.
+// An error wrapping limit.ErrMaxLimit is returned if the max number of subscriptions is reached.
+func (d *Dispatcher) Subscribe(ctx context.Context, log zerolog.Logger, agentID string, seqNo sqn.SeqNo) (*Sub, error) {
+	w, err := d.subs.Register(ctx, agentID)
+	if err != nil {
+		return nil, err
 	}
 
-	d.mx.Lock()
-	d.subs[agentID] = sub
-	sz := len(d.subs)
-	d.mx.Unlock()
-
-	log.Trace().Str(logger.AgentID, agentID).Int("sz", sz).Msg("Subscribed to action dispatcher")
+	log.Trace().Str(logger.AgentID, agentID).Int("sz", d.subs.Len()).Msg("Subscribed to action dispatcher")
 
-	return &sub
+	return &Sub{
+		agentID: agentID,
+		seqNo:   seqNo,
+		w:       w,
+	}, nil
 }
 
 // Unsubscribe removes the given subscription from the dispatcher.
@@ -96,12 +143,9 @@ func (d *Dispatcher) Unsubscribe(log zerolog.Logger, sub *Sub) {
 		return
 	}
 
-	d.mx.Lock()
-	delete(d.subs, sub.agentID)
-	sz := len(d.subs)
-	d.mx.Unlock()
+	d.subs.Unregister(sub.w)
 
-	log.Trace().Str(logger.AgentID, sub.agentID).Int("sz", sz).Msg("Unsubscribed from action dispatcher")
+	log.Trace().Str(logger.AgentID, sub.agentID).Int("sz", d.subs.Len()).Msg("Unsubscribed from action dispatcher")
 }
 
 // process gathers actions from the monitor and dispatches them to the corresponding subscriptions.
@@ -117,6 +161,13 @@ func (d *Dispatcher) process(ctx context.Context, hits []es.HitT) {
 			zerolog.Ctx(ctx).Error().Err(err).Msg("Failed to unmarshal action document")
 			break
 		}
+		if d.cache != nil {
+			d.cache.InvalidateNotFound(cache.KindAction, action.ActionID)
+			d.cache.SetAction(action)
+		}
+		if d.targeted != nil && action.Target != nil {
+			d.targeted.Invalidate()
+		}
 		numAgents := len(action.Agents)
 		for i, agentID := range action.Agents {
 			arr := agentActions[agentID]
@@ -144,38 +195,24 @@ func offsetStartTime(ctx context.Context, start string, dur int64, i, total int)
 	if start == "" {
 		return ""
 	}
-	startTS, err := time.Parse(time.RFC3339, start)
+	startTS, err := ftime.Parse(start)
 	if err != nil {
 		zerolog.Ctx(ctx).Error().Err(err).Msg("unable to parse start_time string")
 		return ""
 	}
 	d := time.Second * time.Duration(dur)
 	startTS = startTS.Add((d * time.Duration(i)) / time.Duration(total)) // adjust start to a position within the range
-	return startTS.Format(time.RFC3339)
-}
-
-// getSub returns the subscription (if any) for the specified agentID.
-func (d *Dispatcher) getSub(agentID string) (Sub, bool) {
-	d.mx.RLock()
-	sub, ok := d.subs[agentID]
-	d.mx.RUnlock()
-	return sub, ok
+	return ftime.Format(startTS)
 }
 
 // dispatch passes the actions into the subscription channel as a non-blocking operation.
 // It may drop actions that will be re-sent to the agent on its next check in.
+// This prevents action dispatch blocking when the agent subscription channel is full
+// in the case when the agent request loop received the actions on long poll but didn't unsubscribe
+// from the dispatcher.
+// It is safe to drop them since the agent already has actions and will come around on the next check-in to pick up these new actions.
 func (d *Dispatcher) dispatch(ctx context.Context, agentID string, acdocs []model.Action) {
-	sub, ok := d.getSub(agentID)
-	if !ok {
-		zerolog.Ctx(ctx).Debug().Str(logger.AgentID, agentID).Msg("Agent is not currently connected. Not dispatching actions.")
-		return
-	}
-	select {
-	case sub.Ch() <- acdocs:
-	default:
-		// This prevents action dispatch blocking when the agent subscription channel is full
-		// in the case when the agent request loop received the actions on long poll but didn't unsubscribe
-		// from the dispatcher.
-		// It is safe to drop them since the agent already has actions and will come around on the next check-in to pick up these new actions.
+	if !d.subs.Notify(agentID, acdocs) {
+		zerolog.Ctx(ctx).Debug().Str(logger.AgentID, agentID).Msg("Agent is not currently connected or has pending actions. Not dispatching actions.")
 	}
 }
@@ -10,11 +10,17 @@ import (
 	"testing"
 	"time"
 
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	testcache "github.com/elastic/fleet-server/v7/internal/pkg/testing/cache"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/time/rate"
 )
 
@@ -45,6 +51,57 @@ func TestNewDispatcher(t *testing.T) {
 	assert.NotNil(t, d.subs)
 }
 
+func TestDispatcherSubscribe(t *testing.T) {
+	d := NewDispatcher(&mockMonitor{}, 0, 0, WithMaxSubscriptions(2))
+	ctx := context.Background()
+
+	older, err := d.Subscribe(ctx, zerolog.Nop(), "agent1", nil)
+	require.NoError(t, err)
+	_, err = d.Subscribe(ctx, zerolog.Nop(), "agent2", nil)
+	require.NoError(t, err)
+	_, err = d.Subscribe(ctx, zerolog.Nop(), "agent3", nil)
+	require.ErrorIs(t, err, limit.ErrMaxLimit)
+
+	// a new check in of the agent replaces its subscription
+	newer, err := d.Subscribe(ctx, zerolog.Nop(), "agent1", nil)
+	require.NoError(t, err)
+	<-older.Preempted()
+	d.Unsubscribe(zerolog.Nop(), older)
+
+	actions := []model.Action{{ActionID: "test-action"}}
+	d.dispatch(ctx, "agent1", actions)
+	require.Equal(t, actions, <-newer.Ch())
+	require.Empty(t, older.Ch())
+
+	// subscriptions are removed when the context of the check in is done
+	cctx, cancel := context.WithCancel(ctx)
+	_, err = d.Subscribe(cctx, zerolog.Nop(), "agent1", nil)
+	require.NoError(t, err)
+	cancel()
+	require.Eventually(t, func() bool { return d.subs.Len() == 1 }, time.Second, time.Millisecond)
+}
+
+func TestDispatcherReload(t *testing.T) {
+	d := NewDispatcher(&mockMonitor{}, 0, 0, WithMaxSubscriptions(1))
+	ctx := context.Background()
+
+	_, err := d.Subscribe(ctx, zerolog.Nop(), "agent1", nil)
+	require.NoError(t, err)
+	_, err = d.Subscribe(ctx, zerolog.Nop(), "agent2", nil)
+	require.ErrorIs(t, err, limit.ErrMaxLimit)
+
+	var cfg config.Server
+	cfg.Limits.MaxConnections = 2
+	cfg.Limits.ActionLimit = config.Limit{Interval: time.Second, Burst: 5}
+	d.Reload(&cfg)
+
+	_, err = d.Subscribe(ctx, zerolog.Nop(), "agent2", nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, d.subs.Len())
+	assert.Equal(t, rate.Every(time.Second), d.limit.Limit())
+	assert.Equal(t, 5, d.limit.Burst())
+}
+
 func compareActions(t *testing.T, expects, results []model.Action) {
 	t.Helper()
 	assert.Equal(t, len(expects), len(results))
@@ -184,7 +241,7 @@ func Test_Dispatcher_Run(t *testing.T) {
 				Data:                   json.RawMessage(`{"key":"value"}`),
 				Expiration:             "2022-01-02T13:00:00Z",
 				RolloutDurationSeconds: 600,
-				StartTime:              "2022-01-02T12:00:00Z",
+				StartTime:              "2022-01-02T12:00:00.000Z",
 				Type:                   "upgrade",
 			}},
 		},
@@ -209,7 +266,7 @@ func Test_Dispatcher_Run(t *testing.T) {
 				Data:                   json.RawMessage(`{"key":"value"}`),
 				Expiration:             "2022-01-02T13:00:00Z",
 				RolloutDurationSeconds: 600,
-				StartTime:              "2022-01-02T12:00:00Z",
+				StartTime:              "2022-01-02T12:00:00.000Z",
 				Type:                   "upgrade",
 			}},
 			"agent2": []model.Action{model.Action{
@@ -218,7 +275,7 @@ func Test_Dispatcher_Run(t *testing.T) {
 				Data:                   json.RawMessage(`{"key":"value"}`),
 				Expiration:             "2022-01-02T13:00:00Z",
 				RolloutDurationSeconds: 600,
-				StartTime:              "2022-01-02T12:03:20Z",
+				StartTime:              "2022-01-02T12:03:20.000Z",
 				Type:                   "upgrade",
 			}},
 			"agent3": []model.Action{model.Action{
@@ -227,7 +284,7 @@ func Test_Dispatcher_Run(t *testing.T) {
 				Data:                   json.RawMessage(`{"key":"value"}`),
 				Expiration:             "2022-01-02T13:00:00Z",
 				RolloutDurationSeconds: 600,
-				StartTime:              "2022-01-02T12:06:40Z",
+				StartTime:              "2022-01-02T12:06:40.000Z",
 				Type:                   "upgrade",
 			}},
 		},
@@ -235,27 +292,12 @@ func Test_Dispatcher_Run(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			m := tt.getMock()
-			throttle := rate.Inf
-			if tt.throttle > 0 {
-				throttle = rate.Every(tt.throttle)
-			}
-			d := &Dispatcher{
-				am:    m,
-				limit: rate.NewLimiter(throttle, 1),
-				subs: map[string]Sub{
-					"agent1": Sub{
-						agentID: "agent1",
-						ch:      make(chan []model.Action, 1),
-					},
-					"agent2": Sub{
-						agentID: "agent2",
-						ch:      make(chan []model.Action, 1),
-					},
-					"agent3": Sub{
-						agentID: "agent3",
-						ch:      make(chan []model.Action, 1),
-					},
-				},
+			d := NewDispatcher(m, tt.throttle, 1)
+			subs := make(map[string]*Sub)
+			for _, agentID := range []string{"agent1", "agent2", "agent3"} {
+				sub, err := d.Subscribe(context.Background(), zerolog.Nop(), agentID, nil)
+				require.NoError(t, err)
+				subs[agentID] = sub
 			}
 
 			now := time.Now()
@@ -269,7 +311,7 @@ func Test_Dispatcher_Run(t *testing.T) {
 			ticker := time.NewTicker(time.Second * 5)
 
 			select {
-			case actions := <-d.subs["agent1"].Ch():
+			case actions := <-subs["agent1"].Ch():
 				compareActions(t, tt.expect["agent1"], actions)
 				// NOTE: agent1 is not rate limited if the action limmiter is enabled for these tests.
 			case <-ticker.C:
@@ -279,7 +321,7 @@ func Test_Dispatcher_Run(t *testing.T) {
 			if expect, ok := tt.expect["agent2"]; ok {
 				ticker.Reset(time.Second * 5)
 				select {
-				case actions := <-d.subs["agent2"].Ch():
+				case actions := <-subs["agent2"].Ch():
 					compareActions(t, expect, actions)
 					if tt.throttle != 0 {
 						assert.GreaterOrEqual(t, time.Now(), now.Add(1*tt.throttle))
@@ -292,7 +334,7 @@ func Test_Dispatcher_Run(t *testing.T) {
 			if expect, ok := tt.expect["agent3"]; ok {
 				ticker.Reset(time.Second * 5)
 				select {
-				case actions := <-d.subs["agent3"].Ch():
+				case actions := <-subs["agent3"].Ch():
 					compareActions(t, expect, actions)
 					if tt.throttle != 0 {
 						assert.GreaterOrEqual(t, time.Now(), now.Add(2*tt.throttle))
@@ -324,21 +366,21 @@ func Test_offsetStartTime(t *testing.T) {
 		end:    "2022-01-02T13:00:00Z",
 		i:      0,
 		total:  10,
-		result: "2022-01-02T12:00:00Z",
+		result: "2022-01-02T12:00:00.000Z",
 	}, {
 		name:   "mid agent no dur",
 		start:  "2022-01-02T12:00:00Z",
 		end:    "2022-01-02T13:00:00Z",
 		i:      4,
 		total:  10,
-		result: "2022-01-02T12:00:00Z",
+		result: "2022-01-02T12:00:00.000Z",
 	}, {
 		name:   "last agent no dur",
 		start:  "2022-01-02T12:00:00Z",
 		end:    "2022-01-02T13:00:00Z",
 		i:      9,
 		total:  10,
-		result: "2022-01-02T12:00:00Z",
+		result: "2022-01-02T12:00:00.000Z",
 	}, {
 		name:   "first agent 10m dur",
 		start:  "2022-01-02T12:00:00Z",
@@ -346,7 +388,7 @@ func Test_offsetStartTime(t *testing.T) {
 		dur:    600,
 		i:      0,
 		total:  10,
-		result: "2022-01-02T12:00:00Z",
+		result: "2022-01-02T12:00:00.000Z",
 	}, {
 		name:   "mid agent 10m dur",
 		start:  "2022-01-02T12:00:00Z",
@@ -354,7 +396,7 @@ func Test_offsetStartTime(t *testing.T) {
 		dur:    600,
 		i:      4,
 		total:  10,
-		result: "2022-01-02T12:04:00Z",
+		result: "2022-01-02T12:04:00.000Z",
 	}, {
 		name:   "last agent 10m dur",
 		start:  "2022-01-02T12:00:00Z",
@@ -362,7 +404,7 @@ func Test_offsetStartTime(t *testing.T) {
 		dur:    600,
 		i:      9,
 		total:  10,
-		result: "2022-01-02T12:09:00Z",
+		result: "2022-01-02T12:09:00.000Z",
 	}}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -371,3 +413,33 @@ func Test_offsetStartTime(t *testing.T) {
 		})
 	}
 }
+
+func TestDispatcherInvalidatesNotFound(t *testing.T) {
+	c := testcache.NewMockCache()
+	c.On("InvalidateNotFound", cache.KindAction, "test-action").Return().Once()
+	c.On("SetAction", mock.MatchedBy(func(a model.Action) bool { return a.ActionID == "test-action" })).Return().Once()
+
+	// the action acked before the monitor read it is found once it is created
+	d := NewDispatcher(&mockMonitor{}, 0, 0, WithCache(c))
+	d.process(context.Background(), []es.HitT{{
+		Source: json.RawMessage(`{"action_id":"test-action","agents":["agent1"],"type":"UPGRADE"}`),
+	}})
+	c.AssertExpectations(t)
+}
+
+func TestDispatcherInvalidatesTargetedActions(t *testing.T) {
+	targeted, err := NewTargetedActions(nil)
+	require.NoError(t, err)
+	targeted.loadedAt = time.Now()
+
+	d := NewDispatcher(&mockMonitor{}, 0, 0, WithTargetedActions(targeted))
+	d.process(context.Background(), []es.HitT{{
+		Source: json.RawMessage(`{"action_id":"test-action","agents":["agent1"],"type":"UPGRADE"}`),
+	}})
+	require.False(t, targeted.loadedAt.IsZero(), "an action addressed to agents does not invalidate the targeted actions")
+
+	d.process(context.Background(), []es.HitT{{
+		Source: json.RawMessage(`{"action_id":"targeted","type":"SETTINGS","target":{"tags":["canary"]}}`),
+	}})
+	require.True(t, targeted.loadedAt.IsZero())
+}
@@ -0,0 +1,184 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package action
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+// ActionResultStatusSignatureInvalid is the status of the result recorded for an action that failed signature verification.
+const ActionResultStatusSignatureInvalid = "signature_invalid"
+
+var (
+	ErrSignatureMissing = errors.New("action is not signed")
+	ErrSignatureInvalid = errors.New("action signature is invalid")
+)
+
+// signedActionTypes are the privileged action types that are verified before delivery.
+var signedActionTypes = map[string]bool{
+	"UNENROLL": true,
+	"UPGRADE":  true,
+}
+
+// Verifier checks the detached signatures of privileged actions.
+// A Verifier without a key accepts all actions.
+type Verifier struct {
+	key     crypto.PublicKey
+	require bool
+}
+
+// NewVerifier returns a Verifier using the public key from the configuration.
+func NewVerifier(cfg config.ActionSigning) (*Verifier, error) {
+	p, err := cfg.Key()
+	if err != nil {
+		return nil, err
+	}
+	v := &Verifier{require: cfg.RequireSignedActions}
+	if p == nil {
+		return v, nil
+	}
+	v.key, err = parsePublicKey(p)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func parsePublicKey(p []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(p)
+	if block == nil {
+		return nil, errors.New("action signing public key is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse action signing public key: %w", err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported action signing public key type %T", key)
+	}
+}
+
+// Verify checks the signature of a privileged action.
+// The signed data is the base64 encoded action document, it must describe the same action as the document it is attached
+// to: the action id, type, data, agents, expiration and start time of the document must be those that were signed.
+func (v *Verifier) Verify(action model.Action) error {
+	if v == nil || v.key == nil || !signedActionTypes[action.Type] {
+		return nil
+	}
+	if action.Signed == nil {
+		if v.require {
+			return ErrSignatureMissing
+		}
+		return nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(action.Signed.Data)
+	if err != nil {
+		return fmt.Errorf("%w: unable to decode data: %w", ErrSignatureInvalid, err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(action.Signed.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: unable to decode signature: %w", ErrSignatureInvalid, err)
+	}
+	if !v.verify(data, sig) {
+		return ErrSignatureInvalid
+	}
+
+	// Prevent a valid signature from being attached to a different or modified action.
+	var signed model.Action
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return fmt.Errorf("%w: unable to parse data: %w", ErrSignatureInvalid, err)
+	}
+	if field := signedMismatch(signed, action); field != "" {
+		return fmt.Errorf("%w: signed %s does not match action", ErrSignatureInvalid, field)
+	}
+	return nil
+}
+
+// signedMismatch returns the name of the first field of the action document that differs from the signed action,
+// or an empty string if the document is the signed action.
+func signedMismatch(signed, action model.Action) string {
+	switch {
+	case signed.ActionID != action.ActionID:
+		return "action_id"
+	case signed.Type != action.Type:
+		return "type"
+	case !jsonEqual(signed.Data, action.Data):
+		return "data"
+	case !slices.Equal(signed.Agents, action.Agents):
+		return "agents"
+	case !timeEqual(signed.Expiration, action.Expiration):
+		return "expiration"
+	case !timeEqual(signed.StartTime, action.StartTime):
+		return "start_time"
+	}
+	return ""
+}
+
+// jsonEqual returns true if a and b are the same JSON value, regardless of their formatting or the order of their keys.
+// A missing value is equal to null.
+func jsonEqual(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	if err := decodeJSON(a, &va); err != nil {
+		return false
+	}
+	if err := decodeJSON(b, &vb); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func decodeJSON(p json.RawMessage, v *interface{}) error {
+	if len(p) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// timeEqual returns true if a and b are the same date/time, they are compared as strings if either can not be parsed.
+func timeEqual(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ta, errA := ftime.Parse(a)
+	tb, errB := ftime.Parse(b)
+	return errA == nil && errB == nil && ta.Equal(tb)
+}
+
+func (v *Verifier) verify(data, sig []byte) bool {
+	switch key := v.key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, sig)
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
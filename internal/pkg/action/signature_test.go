@@ -0,0 +1,208 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package action
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+func encodePublicKey(t *testing.T, key crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func signECDSA(t *testing.T, key *ecdsa.PrivateKey, data string) *model.Signed {
+	t.Helper()
+	digest := sha256.Sum256([]byte(data))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+	return &model.Signed{
+		Data:      base64.StdEncoding.EncodeToString([]byte(data)),
+		Signature: base64.StdEncoding.EncodeToString(sig),
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	publicKey := encodePublicKey(t, &key.PublicKey)
+
+	const payload = `{"action_id":"action-1","type":"UPGRADE","agents":["agent-1","agent-2"],"data":{"version":"8.16.0"},` +
+		`"expiration":"2025-01-01T00:00:00Z","start_time":"2024-12-31T00:00:00Z"}`
+	// signedAction returns the action document of payload signed with signer, modified by fn.
+	signedAction := func(signer *ecdsa.PrivateKey, fn func(*model.Action)) model.Action {
+		action := model.Action{
+			ActionID:   "action-1",
+			Type:       "UPGRADE",
+			Agents:     []string{"agent-1", "agent-2"},
+			Data:       []byte(`{ "version": "8.16.0" }`),
+			Expiration: "2025-01-01T00:00:00.000Z",
+			StartTime:  "2024-12-31T00:00:00Z",
+			Signed:     signECDSA(t, signer, payload),
+		}
+		if fn != nil {
+			fn(&action)
+		}
+		return action
+	}
+
+	tests := []struct {
+		name   string
+		cfg    config.ActionSigning
+		action model.Action
+		err    error
+	}{{
+		name:   "valid signature",
+		cfg:    config.ActionSigning{PublicKey: publicKey},
+		action: signedAction(key, nil),
+	}, {
+		name:   "signed by another key",
+		cfg:    config.ActionSigning{PublicKey: publicKey},
+		action: signedAction(otherKey, nil),
+		err:    ErrSignatureInvalid,
+	}, {
+		name: "tampered signed data",
+		cfg:  config.ActionSigning{PublicKey: publicKey},
+		action: signedAction(key, func(a *model.Action) {
+			a.Signed.Data = base64.StdEncoding.EncodeToString([]byte(`{"action_id":"action-1","type":"UPGRADE","data":{"version":"1.0.0"}}`))
+		}),
+		err: ErrSignatureInvalid,
+	}, {
+		name:   "signature for another action",
+		cfg:    config.ActionSigning{PublicKey: publicKey},
+		action: signedAction(key, func(a *model.Action) { a.ActionID = "action-2" }),
+		err:    ErrSignatureInvalid,
+	}, {
+		name:   "tampered type",
+		cfg:    config.ActionSigning{PublicKey: publicKey},
+		action: signedAction(key, func(a *model.Action) { a.Type = "UNENROLL" }),
+		err:    ErrSignatureInvalid,
+	}, {
+		name:   "tampered data",
+		cfg:    config.ActionSigning{PublicKey: publicKey},
+		action: signedAction(key, func(a *model.Action) { a.Data = []byte(`{"version":"1.0.0"}`) }),
+		err:    ErrSignatureInvalid,
+	}, {
+		name:   "data removed",
+		cfg:    config.ActionSigning{PublicKey: publicKey},
+		action: signedAction(key, func(a *model.Action) { a.Data = nil }),
+		err:    ErrSignatureInvalid,
+	}, {
+		name:   "agent added",
+		cfg:    config.ActionSigning{PublicKey: publicKey},
+		action: signedAction(key, func(a *model.Action) { a.Agents = append(a.Agents, "agent-3") }),
+		err:    ErrSignatureInvalid,
+	}, {
+		name:   "agent replaced",
+		cfg:    config.ActionSigning{PublicKey: publicKey},
+		action: signedAction(key, func(a *model.Action) { a.Agents = []string{"agent-1", "agent-3"} }),
+		err:    ErrSignatureInvalid,
+	}, {
+		name:   "tampered expiration",
+		cfg:    config.ActionSigning{PublicKey: publicKey},
+		action: signedAction(key, func(a *model.Action) { a.Expiration = "2030-01-01T00:00:00Z" }),
+		err:    ErrSignatureInvalid,
+	}, {
+		name:   "expiration removed",
+		cfg:    config.ActionSigning{PublicKey: publicKey},
+		action: signedAction(key, func(a *model.Action) { a.Expiration = "" }),
+		err:    ErrSignatureInvalid,
+	}, {
+		name:   "tampered start time",
+		cfg:    config.ActionSigning{PublicKey: publicKey},
+		action: signedAction(key, func(a *model.Action) { a.StartTime = "2024-12-30T00:00:00Z" }),
+		err:    ErrSignatureInvalid,
+	}, {
+		name:   "signature not base64",
+		cfg:    config.ActionSigning{PublicKey: publicKey},
+		action: signedAction(key, func(a *model.Action) { a.Signed = &model.Signed{Data: "e30=", Signature: "not base64!"} }),
+		err:    ErrSignatureInvalid,
+	}, {
+		name:   "missing signature",
+		cfg:    config.ActionSigning{PublicKey: publicKey},
+		action: model.Action{ActionID: "action-1", Type: "UPGRADE"},
+	}, {
+		name:   "missing signature required",
+		cfg:    config.ActionSigning{PublicKey: publicKey, RequireSignedActions: true},
+		action: model.Action{ActionID: "action-1", Type: "UNENROLL"},
+		err:    ErrSignatureMissing,
+	}, {
+		name:   "unprivileged action is not verified",
+		cfg:    config.ActionSigning{PublicKey: publicKey, RequireSignedActions: true},
+		action: model.Action{ActionID: "action-1", Type: "REQUEST_DIAGNOSTICS"},
+	}, {
+		name:   "no key configured",
+		cfg:    config.ActionSigning{},
+		action: signedAction(otherKey, nil),
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := NewVerifier(tc.cfg)
+			require.NoError(t, err)
+			err = v.Verify(tc.action)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestVerifierEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	data := []byte(`{"action_id":"action-1","type":"UNENROLL"}`)
+	signed := &model.Signed{
+		Data:      base64.StdEncoding.EncodeToString(data),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data)),
+	}
+
+	// Load the key from a file
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, []byte(encodePublicKey(t, pub)), 0o600))
+
+	v, err := NewVerifier(config.ActionSigning{PublicKeyPath: path, RequireSignedActions: true})
+	require.NoError(t, err)
+	require.NoError(t, v.Verify(model.Action{ActionID: "action-1", Type: "UNENROLL", Signed: signed}))
+
+	signed.Signature = base64.StdEncoding.EncodeToString(make([]byte, ed25519.SignatureSize))
+	require.ErrorIs(t, v.Verify(model.Action{ActionID: "action-1", Type: "UNENROLL", Signed: signed}), ErrSignatureInvalid)
+}
+
+func TestNewVerifierInvalidKey(t *testing.T) {
+	_, err := NewVerifier(config.ActionSigning{PublicKey: "not a key"})
+	require.Error(t, err)
+
+	_, err = NewVerifier(config.ActionSigning{PublicKeyPath: filepath.Join(t.TempDir(), "missing.pem")})
+	require.Error(t, err)
+}
+
+func TestNilVerifier(t *testing.T) {
+	var v *Verifier
+	require.NoError(t, v.Verify(model.Action{Type: "UPGRADE"}))
+}
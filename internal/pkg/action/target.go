@@ -0,0 +1,70 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package action
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+// ErrTargetInvalid is returned for a target filter that can't be evaluated.
+var ErrTargetInvalid = errors.New("invalid action target")
+
+// ValidateTarget returns an error if the target filter sets no predicate or its version constraint does not parse.
+func ValidateTarget(target *model.ActionTarget) error {
+	if target == nil || (target.PolicyID == "" && len(target.Tags) == 0 && target.Version == "") {
+		return fmt.Errorf("%w: one of policy_id, tags or version must be specified", ErrTargetInvalid)
+	}
+	if slices.Contains(target.Tags, "") {
+		return fmt.Errorf("%w: empty tag", ErrTargetInvalid)
+	}
+	if target.Version != "" {
+		if _, err := version.NewConstraint(target.Version); err != nil {
+			return fmt.Errorf("%w: version %q: %w", ErrTargetInvalid, target.Version, err)
+		}
+	}
+	return nil
+}
+
+// MatchTarget returns true if the agent satisfies all the predicates set on the target filter:
+//   - policy_id: the agent is assigned to the policy.
+//   - tags: the agent has all the tags.
+//   - version: the agent version satisfies the semver constraint, its pre-release is ignored.
+//
+// An agent that does not report a valid version does not match a version constraint.
+func MatchTarget(target *model.ActionTarget, agent *model.Agent) (bool, error) {
+	if err := ValidateTarget(target); err != nil {
+		return false, err
+	}
+	if target.PolicyID != "" && target.PolicyID != agent.PolicyID {
+		return false, nil
+	}
+	for _, tag := range target.Tags {
+		if !slices.Contains(agent.Tags, tag) {
+			return false, nil
+		}
+	}
+	if target.Version == "" {
+		return true, nil
+	}
+	if agent.Agent == nil || strings.TrimSpace(agent.Agent.Version) == "" {
+		return false, nil
+	}
+	ver, err := version.NewSemver(strings.TrimSpace(agent.Agent.Version))
+	if err != nil {
+		return false, nil //nolint:nilerr // an invalid agent version does not match
+	}
+	constraint, err := version.NewConstraint(target.Version)
+	if err != nil {
+		return false, err
+	}
+	return constraint.Check(ver.Core()), nil
+}
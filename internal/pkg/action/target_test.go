@@ -0,0 +1,74 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package action
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+func TestValidateTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		target *model.ActionTarget
+		valid  bool
+	}{
+		{name: "nil", target: nil},
+		{name: "empty", target: &model.ActionTarget{}},
+		{name: "empty tag", target: &model.ActionTarget{Tags: []string{""}}},
+		{name: "invalid version", target: &model.ActionTarget{Version: "latest"}},
+		{name: "policy", target: &model.ActionTarget{PolicyID: "policy-1"}, valid: true},
+		{name: "tags", target: &model.ActionTarget{Tags: []string{"a", "b"}}, valid: true},
+		{name: "version range", target: &model.ActionTarget{Version: ">= 8.15.0, < 9.0.0"}, valid: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateTarget(tc.target)
+			if tc.valid {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrTargetInvalid)
+		})
+	}
+}
+
+func TestMatchTarget(t *testing.T) {
+	agent := &model.Agent{
+		PolicyID: "policy-1",
+		Tags:     []string{"production", "linux"},
+		Agent:    &model.AgentMetadata{ID: "agent-1", Version: "8.16.1-SNAPSHOT"},
+	}
+	tests := []struct {
+		name   string
+		target model.ActionTarget
+		agent  *model.Agent
+		match  bool
+	}{
+		{name: "policy", target: model.ActionTarget{PolicyID: "policy-1"}, agent: agent, match: true},
+		{name: "other policy", target: model.ActionTarget{PolicyID: "policy-2"}, agent: agent},
+		{name: "all tags", target: model.ActionTarget{Tags: []string{"linux", "production"}}, agent: agent, match: true},
+		{name: "missing tag", target: model.ActionTarget{Tags: []string{"production", "windows"}}, agent: agent},
+		{name: "version in range", target: model.ActionTarget{Version: ">= 8.16.0, < 9.0.0"}, agent: agent, match: true},
+		{name: "version out of range", target: model.ActionTarget{Version: ">= 8.17.0"}, agent: agent},
+		{name: "all predicates", target: model.ActionTarget{PolicyID: "policy-1", Tags: []string{"linux"}, Version: "~> 8.16"}, agent: agent, match: true},
+		{name: "one predicate fails", target: model.ActionTarget{PolicyID: "policy-1", Tags: []string{"linux"}, Version: "< 8.0.0"}, agent: agent},
+		{name: "no agent version", target: model.ActionTarget{Version: ">= 8.0.0"}, agent: &model.Agent{PolicyID: "policy-1"}},
+		{name: "invalid agent version", target: model.ActionTarget{Version: ">= 8.0.0"}, agent: &model.Agent{Agent: &model.AgentMetadata{Version: "unknown"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			match, err := MatchTarget(&tc.target, tc.agent)
+			require.NoError(t, err)
+			require.Equal(t, tc.match, match)
+		})
+	}
+
+	_, err := MatchTarget(&model.ActionTarget{}, agent)
+	require.ErrorIs(t, err, ErrTargetInvalid)
+}
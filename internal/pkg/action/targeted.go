@@ -0,0 +1,113 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package action
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+const (
+	// targetedRefreshInterval is the max age of the targeted actions served to the checkins.
+	targetedRefreshInterval = time.Minute
+	// targetedDeliveredCacheSize is the number of deliveries of targeted actions remembered by an instance.
+	targetedDeliveredCacheSize = 100000
+)
+
+// TargetedActions serves the unexpired actions that target a filter of agents to the checkins, so a checkin does not
+// search them. The actions are read from elasticsearch when they are first needed, when the dispatcher reads a new
+// targeted action from the actions monitor, and at least every targetedRefreshInterval.
+// The deliveries found in the action results index or recorded by the instance are remembered, the results of an agent
+// are searched until the action is delivered to it.
+type TargetedActions struct {
+	bulker bulk.Bulk
+
+	mu       sync.Mutex
+	actions  []model.Action
+	loadedAt time.Time // zero when the actions must be read again
+
+	delivered *lru.Cache[deliveryKey, struct{}]
+}
+
+// NewTargetedActions returns a TargetedActions reading the actions with bulker.
+func NewTargetedActions(bulker bulk.Bulk) (*TargetedActions, error) {
+	delivered, err := lru.New[deliveryKey, struct{}](targetedDeliveredCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	return &TargetedActions{
+		bulker:    bulker,
+		delivered: delivered,
+	}, nil
+}
+
+// Invalidate makes the next call to Actions read the targeted actions from elasticsearch.
+func (t *TargetedActions) Invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.loadedAt = time.Time{}
+}
+
+// Actions returns the unexpired targeted actions, the oldest first. The returned slice must not be modified.
+func (t *TargetedActions) Actions(ctx context.Context) ([]model.Action, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if t.loadedAt.IsZero() || now.Sub(t.loadedAt) >= targetedRefreshInterval {
+		actions, err := dl.FindTargetedActions(ctx, t.bulker)
+		if err != nil {
+			return nil, err
+		}
+		t.actions = actions
+		t.loadedAt = now
+	}
+	unexpired := t.actions[:0:0]
+	for _, a := range t.actions {
+		if expiration, err := ftime.Parse(a.Expiration); err == nil && !expiration.After(now) {
+			continue
+		}
+		unexpired = append(unexpired, a)
+	}
+	return unexpired, nil
+}
+
+// Delivered returns the IDs of the actions, among actionIDs, that were delivered to the agent or that it acked.
+func (t *TargetedActions) Delivered(ctx context.Context, agentID string, actionIDs []string) (map[string]bool, error) {
+	delivered := make(map[string]bool, len(actionIDs))
+	var search []string
+	for _, id := range actionIDs {
+		if t.delivered.Contains(deliveryKey{actionID: id, agentID: agentID}) {
+			delivered[id] = true
+			continue
+		}
+		search = append(search, id)
+	}
+	found, err := dl.FindAgentActionResultIDs(ctx, t.bulker, agentID, search)
+	if err != nil {
+		return nil, err
+	}
+	for id := range found {
+		t.delivered.Add(deliveryKey{actionID: id, agentID: agentID}, struct{}{})
+		delivered[id] = true
+	}
+	return delivered, nil
+}
+
+// RecordDelivery records the delivery of the action of acr to its agent in the action results index.
+func (t *TargetedActions) RecordDelivery(ctx context.Context, acr model.ActionResult) error {
+	if err := dl.CreateActionDelivery(ctx, t.bulker, acr); err != nil {
+		return err
+	}
+	t.delivered.Add(deliveryKey{actionID: acr.ActionID, agentID: acr.AgentID}, struct{}{})
+	return nil
+}
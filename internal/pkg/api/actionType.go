@@ -0,0 +1,51 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+// The action types that fleet ui writes to the actions index but that are never delivered to the agents.
+const (
+	FORCEUNENROLL ActionType = "FORCE_UNENROLL"
+	UPDATETAGS    ActionType = "UPDATE_TAGS"
+)
+
+// ActionTypes are the action types fleet-server knows about.
+// The switches on the result of ParseActionType handle each of them, TestActionTypeSwitches fails when one is missing.
+var ActionTypes = []ActionType{
+	CANCEL,
+	FORCEUNENROLL,
+	INPUTACTION,
+	POLICYCHANGE,
+	POLICYREASSIGN,
+	REQUESTDIAGNOSTICS,
+	SETTINGS,
+	UNENROLL,
+	UPDATETAGS,
+	UPGRADE,
+}
+
+// ParseActionType returns the action type s, ok is false if it is not one of ActionTypes.
+func ParseActionType(s string) (t ActionType, ok bool) {
+	for _, t := range ActionTypes {
+		if string(t) == s {
+			return t, true
+		}
+	}
+	return ActionType(s), false
+}
+
+// actionDelivery returns whether the actions of type s read from the actions index are delivered to the agents, and
+// why they are not. known is false for the types fleet-server does not know about.
+func actionDelivery(s string) (deliver, known bool, reason string) {
+	switch t, _ := ParseActionType(s); t {
+	case CANCEL, INPUTACTION, POLICYREASSIGN, REQUESTDIAGNOSTICS, SETTINGS, UNENROLL, UPGRADE:
+		return true, true, ""
+	case POLICYCHANGE:
+		return false, true, "policy changes are generated on check in"
+	case FORCEUNENROLL, UPDATETAGS:
+		return false, true, "action type not delivered to agents"
+	default:
+		return false, false, "unknown action type"
+	}
+}
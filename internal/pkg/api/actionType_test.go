@@ -0,0 +1,129 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseActionType(t *testing.T) {
+	for _, at := range ActionTypes {
+		parsed, ok := ParseActionType(string(at))
+		assert.True(t, ok, at)
+		assert.Equal(t, at, parsed)
+	}
+	parsed, ok := ParseActionType("CUSTOM_ACTION")
+	assert.False(t, ok)
+	assert.Equal(t, ActionType("CUSTOM_ACTION"), parsed)
+	_, ok = ParseActionType("")
+	assert.False(t, ok)
+}
+
+// TestActionTypeSwitches checks that ActionTypes lists each ActionType constant of the package, and that each switch on
+// the result of ParseActionType has a case for each of them and a default case for the unknown types.
+func TestActionTypeSwitches(t *testing.T) {
+	fset := token.NewFileSet()
+	entries, err := os.ReadDir(".")
+	require.NoError(t, err)
+
+	var consts []string
+	var switches []*ast.SwitchStmt
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".go") || strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, entry.Name(), nil, 0)
+		require.NoError(t, err)
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.ValueSpec:
+				if typ, ok := n.Type.(*ast.Ident); ok && typ.Name == "ActionType" {
+					for _, name := range n.Names {
+						consts = append(consts, name.Name)
+					}
+				}
+			case *ast.SwitchStmt:
+				if init, ok := n.Init.(*ast.AssignStmt); ok && len(init.Rhs) == 1 {
+					if call, ok := init.Rhs[0].(*ast.CallExpr); ok {
+						if fn, ok := call.Fun.(*ast.Ident); ok && fn.Name == "ParseActionType" {
+							switches = append(switches, n)
+						}
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	listed := make([]string, len(ActionTypes))
+	for i, at := range ActionTypes {
+		for _, name := range consts {
+			if name == strings.ReplaceAll(string(at), "_", "") {
+				listed[i] = name
+			}
+		}
+	}
+	slices.Sort(consts)
+	slices.Sort(listed)
+	require.Equal(t, consts, listed, "ActionTypes must list each ActionType constant")
+
+	// the ack and the checkin switches
+	require.GreaterOrEqual(t, len(switches), 2)
+	for _, sw := range switches {
+		var cases []string
+		hasDefault := false
+		for _, stmt := range sw.Body.List {
+			clause := stmt.(*ast.CaseClause)
+			if clause.List == nil {
+				hasDefault = true
+			}
+			for _, expr := range clause.List {
+				if id, ok := expr.(*ast.Ident); ok {
+					cases = append(cases, id.Name)
+				}
+			}
+		}
+		pos := fset.Position(sw.Pos())
+		assert.True(t, hasDefault, "switch at %s must have a default case for the unknown action types", pos)
+		for _, name := range consts {
+			assert.Contains(t, cases, name, "switch at %s must handle %s", pos, name)
+		}
+	}
+}
+
+func TestActionDelivery(t *testing.T) {
+	tests := []struct {
+		actionType string
+		deliver    bool
+		known      bool
+	}{
+		{string(UPGRADE), true, true},
+		{string(REQUESTDIAGNOSTICS), true, true},
+		{string(POLICYCHANGE), false, true},
+		{string(UPDATETAGS), false, true},
+		{string(FORCEUNENROLL), false, true},
+		{"CUSTOM_ACTION", false, false},
+		{"", false, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.actionType, func(t *testing.T) {
+			deliver, known, reason := actionDelivery(tc.actionType)
+			assert.Equal(t, tc.deliver, deliver)
+			assert.Equal(t, tc.known, known)
+			assert.Equal(t, tc.deliver, reason == "")
+		})
+	}
+}
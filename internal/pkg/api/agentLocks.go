@@ -0,0 +1,70 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"sync"
+)
+
+// agentLocks serializes the requests of each agent, the requests of different agents run in parallel.
+// A lock is only held in the map while a request of its agent holds or waits for it, so the map is bounded
+// by the number of agents with requests in flight instead of growing with the agents seen.
+type agentLocks struct {
+	mx    sync.Mutex
+	locks map[string]*agentLock
+}
+
+type agentLock struct {
+	sem  chan struct{}
+	refs int // the requests holding or waiting for the lock
+}
+
+func newAgentLocks() *agentLocks {
+	return &agentLocks{locks: make(map[string]*agentLock)}
+}
+
+// lock waits for the lock of the agent and returns the function releasing it.
+// It returns the error of ctx if ctx is done before the lock is acquired.
+func (l *agentLocks) lock(ctx context.Context, agentID string) (func(), error) {
+	l.mx.Lock()
+	al, ok := l.locks[agentID]
+	if !ok {
+		al = &agentLock{sem: make(chan struct{}, 1)}
+		l.locks[agentID] = al
+	}
+	al.refs++
+	l.mx.Unlock()
+
+	select {
+	case al.sem <- struct{}{}:
+	case <-ctx.Done():
+		l.release(agentID, al)
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-al.sem
+			l.release(agentID, al)
+		})
+	}, nil
+}
+
+func (l *agentLocks) release(agentID string, al *agentLock) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	al.refs--
+	if al.refs == 0 {
+		delete(l.locks, agentID)
+	}
+}
+
+// len returns the number of agents with a request holding or waiting for their lock.
+func (l *agentLocks) len() int {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return len(l.locks)
+}
@@ -0,0 +1,59 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentLocks(t *testing.T) {
+	locks := newAgentLocks()
+	ctx := context.Background()
+
+	unlock1, err := locks.lock(ctx, "agent-1")
+	require.NoError(t, err)
+
+	// another agent is not blocked
+	unlock2, err := locks.lock(ctx, "agent-2")
+	require.NoError(t, err)
+	require.Equal(t, 2, locks.len())
+	unlock2()
+
+	// the same agent waits for the lock
+	acquired := make(chan func())
+	go func() {
+		unlock, err := locks.lock(ctx, "agent-1")
+		if err == nil {
+			acquired <- unlock
+		}
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("the lock of agent-1 is held")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// a waiter gives up when its context is done
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = locks.lock(cctx, "agent-1")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	unlock1()
+	unlock1() // releasing twice is a noop
+	select {
+	case unlock := <-acquired:
+		unlock()
+	case <-time.After(time.Second):
+		t.Fatal("the lock of agent-1 was not handed over")
+	}
+	require.Zero(t, locks.len(), "the released locks are removed")
+}
@@ -0,0 +1,91 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+var (
+	ErrAgentVersionNotSupported = errors.New("agent version is not supported")
+	ErrInvalidVersion           = errors.New("invalid version")
+)
+
+// ParseVersion parses the version of an Elastic Agent or of the Fleet Server.
+// Surrounding spaces and a leading v are ignored, the major and minor are required and a missing patch is 0.
+// Pre-release and build suffixes such as -SNAPSHOT, -beta1 or +build1 are kept, a pre-release sorts before its release.
+func ParseVersion(s string) (*version.Version, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("%w: empty version", ErrInvalidVersion)
+	}
+	ver, err := version.NewSemver(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidVersion, s, err)
+	}
+	core, _, _ := strings.Cut(strings.TrimPrefix(s, "v"), "-")
+	core, _, _ = strings.Cut(core, "+")
+	if n := strings.Count(core, ".") + 1; n < 2 || n > 3 {
+		return nil, fmt.Errorf("%w %q: expected major.minor.patch", ErrInvalidVersion, s)
+	}
+	return ver, nil
+}
+
+// checkAgentVersion returns ErrAgentVersionNotSupported when the agent version has a higher major or minor than the
+// server version, agents with the same major and minor and a higher patch are supported.
+// The pre-release of both versions is ignored, an 8.1.0-SNAPSHOT agent is supported by an 8.1.0 server.
+// Snapshot builds of the server allow agents of the next minor, see agentMinorAllowance.
+// An empty agent version is not checked, the agent may not report it.
+func checkAgentVersion(agentVer string, serverVer *version.Version) error {
+	if serverVer == nil || agentVer == "" {
+		return nil
+	}
+	ver, err := ParseVersion(agentVer)
+	if err != nil {
+		return &BadRequestErr{msg: "invalid agent version", nextErr: err}
+	}
+	agent, server := ver.Segments(), serverVer.Segments()
+	if agent[0] > server[0] || (agent[0] == server[0] && agent[1] > server[1]+agentMinorAllowance) {
+		return fmt.Errorf("%w: agent version %s is newer than fleet-server version %s", ErrAgentVersionNotSupported, ver.Original(), serverVer.Original())
+	}
+	return nil
+}
+
+// checkUserAgentVersion checks the version of the Elastic Agent User-Agent header with checkAgentVersion.
+// Invalid User-Agent headers are left to validateUserAgent.
+func checkUserAgentVersion(userAgent string, serverVer *version.Version) error {
+	s := strings.TrimSpace(userAgent)
+	if !strings.HasPrefix(strings.ToLower(s), userAgentPrefix) {
+		return nil
+	}
+	err := checkAgentVersion(strings.TrimSpace(s[len(userAgentPrefix):]), serverVer)
+	if errors.Is(err, ErrAgentVersionNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// localMetadataVersion returns the elastic.agent.version of the local metadata, or an empty string when it is not set.
+func localMetadataVersion(localMeta []byte) (string, error) {
+	if len(localMeta) == 0 {
+		return "", nil
+	}
+	var meta struct {
+		Elastic struct {
+			Agent struct {
+				Version string `json:"version"`
+			} `json:"agent"`
+		} `json:"elastic"`
+	}
+	if err := json.Unmarshal(localMeta, &meta); err != nil {
+		return "", &BadRequestErr{msg: "unable to parse local metadata", nextErr: err}
+	}
+	return meta.Elastic.Agent.Version, nil
+}
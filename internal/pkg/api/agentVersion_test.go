@@ -0,0 +1,204 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration && !snapshot
+
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/go-version"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseVersion(t *testing.T, s string) *version.Version {
+	t.Helper()
+	ver, err := ParseVersion(s)
+	require.NoError(t, err)
+	return ver
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		version    string
+		segments   []int
+		prerelease string
+		metadata   string
+		err        bool
+	}{
+		{version: "8.5.0", segments: []int{8, 5, 0}},
+		{version: "8.5.3", segments: []int{8, 5, 3}},
+		{version: "8.5", segments: []int{8, 5, 0}},
+		{version: "v8.5.0", segments: []int{8, 5, 0}},
+		{version: "  8.5.0 ", segments: []int{8, 5, 0}},
+		{version: "10.20.30", segments: []int{10, 20, 30}},
+		{version: "8.5.0-SNAPSHOT", segments: []int{8, 5, 0}, prerelease: "SNAPSHOT"},
+		{version: "8.5.0-snapshot", segments: []int{8, 5, 0}, prerelease: "snapshot"},
+		{version: "8.0.0-alpha1", segments: []int{8, 0, 0}, prerelease: "alpha1"},
+		{version: "8.0.0-beta.2", segments: []int{8, 0, 0}, prerelease: "beta.2"},
+		{version: "8.0.0-rc1-SNAPSHOT", segments: []int{8, 0, 0}, prerelease: "rc1-SNAPSHOT"},
+		{version: "8.5.0+build.1", segments: []int{8, 5, 0}, metadata: "build.1"},
+		{version: "8.5.0-SNAPSHOT+a1b2c3", segments: []int{8, 5, 0}, prerelease: "SNAPSHOT", metadata: "a1b2c3"},
+		{version: "", err: true},
+		{version: "   ", err: true},
+		{version: "8", err: true},
+		{version: "v8", err: true},
+		{version: "8.", err: true},
+		{version: "8.5.0.1", err: true},
+		{version: "8.x", err: true},
+		{version: "eight", err: true},
+		{version: "8.5.0-", err: true},
+		{version: "-8.5.0", err: true},
+		{version: "8.5.0 SNAPSHOT", err: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.version, func(t *testing.T) {
+			ver, err := ParseVersion(tc.version)
+			if tc.err {
+				require.ErrorIs(t, err, ErrInvalidVersion)
+				require.Nil(t, ver)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.segments, ver.Segments())
+			require.Equal(t, tc.prerelease, ver.Prerelease())
+			require.Equal(t, tc.metadata, ver.Metadata())
+		})
+	}
+}
+
+func TestParseVersionOrder(t *testing.T) {
+	// pairs of versions in ascending precedence
+	tests := [][2]string{
+		{"7.17.9", "8.0.0-alpha1"},
+		{"8.0.0-alpha1", "8.0.0-alpha2"},
+		{"8.0.0-alpha2", "8.0.0-beta1"},
+		{"8.0.0-beta1", "8.0.0-rc1"},
+		{"8.0.0-rc1", "8.0.0"},
+		{"8.0.0-SNAPSHOT", "8.0.0"},
+		{"7.17.9", "8.0.0-SNAPSHOT"},
+		{"8.0.0", "8.0.1-SNAPSHOT"},
+		{"8.0.1", "8.1.0-SNAPSHOT"},
+		{"8.1.0-SNAPSHOT", "8.1.0"},
+		{"8.9.0", "8.10.0"},
+	}
+	for _, tc := range tests {
+		lower, higher := mustParseVersion(t, tc[0]), mustParseVersion(t, tc[1])
+		require.Truef(t, lower.LessThan(higher), "%s < %s", tc[0], tc[1])
+	}
+	require.True(t, mustParseVersion(t, "8.5.0+build.1").Equal(mustParseVersion(t, "8.5.0+build.2")))
+}
+
+func TestCheckAgentVersion(t *testing.T) {
+	tests := []struct {
+		agent  string
+		server string
+		err    error
+	}{
+		{agent: "", server: "8.5.0"},
+		{agent: "8.5.0", server: "8.5.0"},
+		{agent: "8.5.9", server: "8.5.0"},
+		{agent: "8.4.0", server: "8.5.0"},
+		{agent: "7.17.0", server: "8.5.0"},
+		{agent: "8.5.0-SNAPSHOT", server: "8.5.0"},
+		{agent: "8.5.0", server: "8.5.0-SNAPSHOT"},
+		{agent: "8.5.1-SNAPSHOT", server: "8.5.0-SNAPSHOT"},
+		{agent: "8.5.0-beta1", server: "8.5.0-alpha1"},
+		{agent: "v8.5.0", server: "8.5.0"},
+		{agent: "8.6.0", server: "8.5.0", err: ErrAgentVersionNotSupported},
+		{agent: "8.6.0-SNAPSHOT", server: "8.5.0", err: ErrAgentVersionNotSupported},
+		{agent: "8.6.0-SNAPSHOT", server: "8.5.9-SNAPSHOT", err: ErrAgentVersionNotSupported},
+		{agent: "8.10.0", server: "8.9.0", err: ErrAgentVersionNotSupported},
+		{agent: "9.0.0", server: "8.5.0", err: ErrAgentVersionNotSupported},
+		{agent: "9.0.0-alpha1", server: "8.17.0", err: ErrAgentVersionNotSupported},
+		{agent: "8.0.0", server: "7.17.0", err: ErrAgentVersionNotSupported},
+	}
+	for _, tc := range tests {
+		t.Run(tc.agent+" "+tc.server, func(t *testing.T) {
+			err := checkAgentVersion(tc.agent, mustParseVersion(t, tc.server))
+			if tc.err == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.err)
+			require.Contains(t, err.Error(), tc.agent)
+			require.Contains(t, err.Error(), tc.server)
+
+			resp := NewHTTPErrResp(err)
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			require.Equal(t, "AgentVersionNotSupported", resp.Error)
+			require.Equal(t, err.Error(), resp.Message)
+		})
+	}
+
+	t.Run("no server version", func(t *testing.T) {
+		require.NoError(t, checkAgentVersion("99.0.0", nil))
+	})
+	t.Run("invalid agent version", func(t *testing.T) {
+		err := checkAgentVersion("not a version", mustParseVersion(t, "8.5.0"))
+		var brErr *BadRequestErr
+		require.ErrorAs(t, err, &brErr)
+		require.ErrorIs(t, err, ErrInvalidVersion)
+	})
+}
+
+func TestCheckUserAgentVersion(t *testing.T) {
+	server := mustParseVersion(t, "8.5.0")
+	tests := []struct {
+		userAgent string
+		err       error
+	}{
+		{userAgent: ""},
+		{userAgent: "curl/8.0.0"},
+		{userAgent: "elastic agent"},
+		{userAgent: "elastic agent vbad"},
+		{userAgent: "Elastic Agent v8.5.0"},
+		{userAgent: "Elastic Agent v8.5.3-SNAPSHOT"},
+		{userAgent: "eLaStIc AGeNt v8.4.0"},
+		{userAgent: "Elastic Agent v8.6.0", err: ErrAgentVersionNotSupported},
+		{userAgent: "Elastic Agent v8.6.0-SNAPSHOT", err: ErrAgentVersionNotSupported},
+		{userAgent: "eLaStIc AGeNt v9.0.0", err: ErrAgentVersionNotSupported},
+	}
+	for _, tc := range tests {
+		t.Run(tc.userAgent, func(t *testing.T) {
+			err := checkUserAgentVersion(tc.userAgent, server)
+			if tc.err == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.err)
+			require.Contains(t, err.Error(), "8.5.0")
+		})
+	}
+}
+
+func TestLocalMetadataVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		meta    string
+		version string
+		err     bool
+	}{
+		{name: "empty"},
+		{name: "no agent", meta: `{"host":{"name":"host"}}`},
+		{name: "version", meta: `{"elastic":{"agent":{"id":"agent-1","version":"8.5.0","snapshot":false}}}`, version: "8.5.0"},
+		{name: "snapshot version", meta: `{"elastic":{"agent":{"version":"8.5.0-SNAPSHOT","snapshot":true}}}`, version: "8.5.0-SNAPSHOT"},
+		{name: "invalid json", meta: `{"elastic":`, err: true},
+		{name: "version not a string", meta: `{"elastic":{"agent":{"version":8}}}`, err: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ver, err := localMetadataVersion([]byte(tc.meta))
+			if tc.err {
+				var brErr *BadRequestErr
+				require.ErrorAs(t, err, &brErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.version, ver)
+		})
+	}
+}
@@ -9,6 +9,9 @@ import (
 
 	"go.elastic.co/apm/v2"
 
+	"github.com/elastic/fleet-server/v7/internal/pkg/audittrail"
+	"github.com/elastic/fleet-server/v7/internal/pkg/clientip"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/rollback"
 
 	"github.com/rs/zerolog/hlog"
@@ -70,12 +73,57 @@ func WithAudit(audit *AuditT) APIOpt {
 	}
 }
 
+func WithActions(act *ActionsT) APIOpt {
+	return func(a *apiServer) {
+		a.act = act
+	}
+}
+
+func WithReassign(rt *ReassignT) APIOpt {
+	return func(a *apiServer) {
+		a.rt = rt
+	}
+}
+
+func WithPolicy(pol *PolicyT) APIOpt {
+	return func(a *apiServer) {
+		a.pol = pol
+	}
+}
+
+func WithTags(tt *TagsT) APIOpt {
+	return func(a *apiServer) {
+		a.tt = tt
+	}
+}
+
+func WithOperations(ot *OperationsT) APIOpt {
+	return func(a *apiServer) {
+		a.ot = ot
+	}
+}
+
 func WithTracer(tracer *apm.Tracer) APIOpt {
 	return func(a *apiServer) {
 		a.tracer = tracer
 	}
 }
 
+// WithAuditTrail records the requests of the administrative endpoints with trail.
+func WithAuditTrail(trail *audittrail.Writer) APIOpt {
+	return func(a *apiServer) {
+		a.trail = trail
+	}
+}
+
+// WithDisabledOperations fails the requests of the operations with ErrFeatureDisabled, the operations are named as the
+// route of the request logs.
+func WithDisabledOperations(operations ...string) APIOpt {
+	return func(a *apiServer) {
+		a.disabled = operations
+	}
+}
+
 // FIXME: Cleanup needed for: metrics endpoint (actually a separate listener?), endpoint auth
 // FIXME: Should we use strict handler
 type apiServer struct {
@@ -88,9 +136,20 @@ type apiServer struct {
 	ft    *FileDeliveryT
 	pt    *PGPRetrieverT
 	audit *AuditT
-
+	act   *ActionsT
+	rt    *ReassignT
+	tt    *TagsT
+	pol   *PolicyT
+	ot    *OperationsT
+
+	// health is the health of the HTTP layer reported by the healthz endpoint
+	health *httpHealth
 	// tracer is used by the wrapping server to instrument the API server
 	tracer *apm.Tracer
+	// trail records the requests of the administrative endpoints
+	trail *audittrail.Writer
+	// disabled are the operations disabled by incompatible mappings
+	disabled []string
 }
 
 // ensure api implements the ServerInterface
@@ -123,19 +182,26 @@ func (a *apiServer) AgentEnroll(w http.ResponseWriter, r *http.Request, params A
 	}
 }
 
+func (a *apiServer) BulkEnroll(w http.ResponseWriter, r *http.Request, params BulkEnrollParams) {
+	zlog := hlog.FromRequest(r).With().Str("mod", kBulkEnrollMod).Logger()
+	w.Header().Set("Content-Type", "application/json")
+	if err := a.et.handleBulkEnroll(zlog, w, r); err != nil {
+		cntBulkEnroll.IncError(err)
+		ErrorResp(w, r, err)
+	}
+}
+
 func (a *apiServer) AgentAcks(w http.ResponseWriter, r *http.Request, id string, params AgentAcksParams) {
-	zlog := hlog.FromRequest(r).With().Str(LogAgentID, id).Logger()
 	w.Header().Set("Content-Type", "application/json")
-	if err := a.ack.handleAcks(zlog, w, r, id); err != nil {
+	if err := a.ack.handleAcks(w, r, id); err != nil {
 		cntAcks.IncError(err)
 		ErrorResp(w, r, err)
 	}
 }
 
 func (a *apiServer) AgentCheckin(w http.ResponseWriter, r *http.Request, id string, params AgentCheckinParams) {
-	zlog := hlog.FromRequest(r).With().Str(LogAgentID, id).Logger()
 	w.Header().Set("Content-Type", "application/json")
-	err := a.ct.handleCheckin(zlog, w, r, id, params.UserAgent)
+	err := a.ct.handleCheckin(w, r, id, params.UserAgent)
 	if err != nil {
 		cntCheckin.IncError(err)
 		ErrorResp(w, r, err)
@@ -146,7 +212,7 @@ func (a *apiServer) Artifact(w http.ResponseWriter, r *http.Request, id string,
 	zlog := hlog.FromRequest(r).With().
 		Str(LogAgentID, id).
 		Str("sha2", sha2).
-		Str("remoteAddr", r.RemoteAddr).
+		Str("remoteAddr", clientip.FromRequest(r)).
 		Logger()
 
 	err := a.at.handleArtifacts(zlog, w, r, id, sha2)
@@ -157,6 +223,33 @@ func (a *apiServer) Artifact(w http.ResponseWriter, r *http.Request, id string,
 	}
 }
 
+func (a *apiServer) GetPolicy(w http.ResponseWriter, r *http.Request, id string, revision int64, params GetPolicyParams) {
+	zlog := hlog.FromRequest(r).With().
+		Str("mod", kPolicyMod).
+		Str(LogPolicyID, id).
+		Int64(logger.RevisionIdx, revision).
+		Logger()
+
+	err := a.pol.handleGet(zlog, w, r, id, revision)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		cntPolicyFetch.IncError(err)
+		ErrorResp(w, r, err)
+	}
+}
+
+func (a *apiServer) GetPolicyRollout(w http.ResponseWriter, r *http.Request, id string, params GetPolicyRolloutParams) {
+	zlog := hlog.FromRequest(r).With().
+		Str("mod", kPolicyMod).
+		Str(LogPolicyID, id).
+		Logger()
+	w.Header().Set("Content-Type", "application/json")
+	if err := a.pol.handleRollout(zlog, w, r, id, params); err != nil {
+		cntPolicyRollout.IncError(err)
+		ErrorResp(w, r, err)
+	}
+}
+
 func (a *apiServer) UploadBegin(w http.ResponseWriter, r *http.Request, params UploadBeginParams) {
 	zlog := hlog.FromRequest(r).With().Logger()
 	w.Header().Set("Content-Type", "application/json")
@@ -219,6 +312,60 @@ func (a *apiServer) AuditUnenroll(w http.ResponseWriter, r *http.Request, id str
 	}
 }
 
+func (a *apiServer) CreateActions(w http.ResponseWriter, r *http.Request, params CreateActionsParams) {
+	zlog := hlog.FromRequest(r).With().Str("mod", kActionsMod).Logger()
+	w.Header().Set("Content-Type", "application/json")
+	if err := a.act.handleCreate(zlog, w, r); err != nil {
+		cntCreateActions.IncError(err)
+		ErrorResp(w, r, err)
+	}
+}
+
+func (a *apiServer) GetActionResults(w http.ResponseWriter, r *http.Request, id string, params GetActionResultsParams) {
+	zlog := hlog.FromRequest(r).With().Str("mod", kActionsMod).Str(logger.ActionID, id).Logger()
+	w.Header().Set("Content-Type", "application/json")
+	if err := a.act.handleResults(zlog, w, r, id, params); err != nil {
+		cntActionResults.IncError(err)
+		ErrorResp(w, r, err)
+	}
+}
+
+func (a *apiServer) ReassignAgents(w http.ResponseWriter, r *http.Request, params ReassignAgentsParams) {
+	zlog := hlog.FromRequest(r).With().Str("mod", kReassignMod).Logger()
+	w.Header().Set("Content-Type", "application/json")
+	if err := a.rt.handleReassign(zlog, w, r); err != nil {
+		cntReassignAgents.IncError(err)
+		ErrorResp(w, r, err)
+	}
+}
+
+func (a *apiServer) GetOperation(w http.ResponseWriter, r *http.Request, id string, params GetOperationParams) {
+	zlog := hlog.FromRequest(r).With().Str("mod", kOperationsMod).Str("operation.id", id).Logger()
+	w.Header().Set("Content-Type", "application/json")
+	if err := a.ot.handleGet(zlog, w, r, id); err != nil {
+		cntOperations.IncError(err)
+		ErrorResp(w, r, err)
+	}
+}
+
+func (a *apiServer) AddAgentTags(w http.ResponseWriter, r *http.Request, id string, params AddAgentTagsParams) {
+	zlog := hlog.FromRequest(r).With().Str("mod", kTagsMod).Str(LogAgentID, id).Logger()
+	w.Header().Set("Content-Type", "application/json")
+	if err := a.tt.handleAdd(zlog, w, r, id); err != nil {
+		cntAgentTags.IncError(err)
+		ErrorResp(w, r, err)
+	}
+}
+
+func (a *apiServer) RemoveAgentTag(w http.ResponseWriter, r *http.Request, id string, tag string, params RemoveAgentTagParams) {
+	zlog := hlog.FromRequest(r).With().Str("mod", kTagsMod).Str(LogAgentID, id).Logger()
+	w.Header().Set("Content-Type", "application/json")
+	if err := a.tt.handleRemove(zlog, w, r, id, tag); err != nil {
+		cntAgentTags.IncError(err)
+		ErrorResp(w, r, err)
+	}
+}
+
 func (a *apiServer) Status(w http.ResponseWriter, r *http.Request, params StatusParams) {
 	zlog := hlog.FromRequest(r).With().
 		Str("mod", kStatusMod).
@@ -230,3 +377,7 @@ func (a *apiServer) Status(w http.ResponseWriter, r *http.Request, params Status
 		ErrorResp(w, r, err)
 	}
 }
+
+func (a *apiServer) Healthz(w http.ResponseWriter, r *http.Request) {
+	a.health.handleHealthz(w, r)
+}
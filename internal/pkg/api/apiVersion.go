@@ -20,9 +20,12 @@ var (
 const (
 	ElasticAPIVersionHeader = "Elastic-Api-Version"
 	DefaultVersion          = "2023-06-01"
+	// CheckinV2Version is the version from which the checkin responses hold the data of each action in the field of
+	// its type, the responses of the other endpoints are the same as in DefaultVersion.
+	CheckinV2Version = "2025-06-01"
 )
 
-var SupportedVersions = []string{DefaultVersion}
+var SupportedVersions = []string{DefaultVersion, CheckinV2Version}
 
 var isValidVersionRegex = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`)
 
@@ -0,0 +1,128 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/audittrail"
+	"github.com/elastic/fleet-server/v7/internal/pkg/clientip"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+const (
+	auditOutcomeSuccess = "success"
+	auditOutcomeFailure = "failure"
+)
+
+// auditedRoutes are the routes of the requests managing the agents, their requests are recorded in the audit trail.
+var auditedRoutes = map[string]bool{
+	"createActions":  true,
+	"reassignAgents": true,
+	"agentTags":      true,
+	"audit-unenroll": true,
+}
+
+type auditEntryKey struct{}
+
+// auditEntry is the audit record of a request, completed by the handler through the context of the request.
+type auditEntry struct {
+	mx  sync.Mutex
+	rec model.AuditRecord
+}
+
+// auditTrail returns a middleware recording the requests of the audited routes with trail once they are handled.
+func auditTrail(trail *audittrail.Writer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			route := pathToOperation(r.URL.Path)
+			if !auditedRoutes[route] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			entry := &auditEntry{rec: model.AuditRecord{
+				Timestamp: ftime.Now(),
+				Route:     route,
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				ClientIP:  clientip.FromRequest(r),
+			}}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), auditEntryKey{}, entry)))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			entry.mx.Lock()
+			rec := entry.rec
+			entry.mx.Unlock()
+			rec.StatusCode = int64(status)
+			rec.Outcome = auditOutcomeSuccess
+			if status >= http.StatusBadRequest {
+				rec.Outcome = auditOutcomeFailure
+			}
+			trail.Record(r.Context(), rec)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// withAuditEntry calls fn with the audit record of the request of ctx, fn is not called if the request is not audited.
+func withAuditEntry(ctx context.Context, fn func(rec *model.AuditRecord)) {
+	entry, ok := ctx.Value(auditEntryKey{}).(*auditEntry)
+	if !ok {
+		return
+	}
+	entry.mx.Lock()
+	defer entry.mx.Unlock()
+	fn(&entry.rec)
+}
+
+// auditCaller records the identity of the authenticated caller, serviceToken is the name of the service token it used.
+func auditCaller(ctx context.Context, apiKeyID, serviceToken, userName string) {
+	withAuditEntry(ctx, func(rec *model.AuditRecord) {
+		rec.APIKeyID = apiKeyID
+		rec.ServiceToken = serviceToken
+		rec.UserName = userName
+	})
+}
+
+// auditTargets records the agents and policies the request targets.
+func auditTargets(ctx context.Context, agentIDs, policyIDs []string) {
+	withAuditEntry(ctx, func(rec *model.AuditRecord) {
+		rec.AgentIDs = append(rec.AgentIDs, agentIDs...)
+		rec.PolicyIDs = append(rec.PolicyIDs, policyIDs...)
+	})
+}
+
+// auditNamespaces records the namespaces of the agents the request targets.
+func auditNamespaces(ctx context.Context, namespaces []string) {
+	withAuditEntry(ctx, func(rec *model.AuditRecord) {
+		rec.Namespaces = append(rec.Namespaces, namespaces...)
+	})
+}
+
+// auditDetail adds the key detail of the request to its audit record, value must be encodable as JSON.
+func auditDetail(ctx context.Context, key string, value interface{}) {
+	withAuditEntry(ctx, func(rec *model.AuditRecord) {
+		if rec.Details == nil {
+			rec.Details = make(map[string]interface{})
+		}
+		rec.Details[key] = value
+	})
+}
+
+// auditError records the error the request failed with.
+func auditError(ctx context.Context, err error) {
+	withAuditEntry(ctx, func(rec *model.AuditRecord) {
+		rec.Error = err.Error()
+	})
+}
@@ -0,0 +1,186 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/audittrail"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/clientip"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+// auditRecords captures the audit records written to the audit data stream.
+type auditRecords struct {
+	mx      sync.Mutex
+	records []model.AuditRecord
+}
+
+func (a *auditRecords) capture(t *testing.T, bulker *ftesting.MockBulk, err error) {
+	bulker.On("MCreate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		a.mx.Lock()
+		defer a.mx.Unlock()
+		for _, op := range args.Get(1).([]bulk.MultiOp) {
+			require.Equal(t, dl.FleetAudit, op.Index)
+			var rec model.AuditRecord
+			require.NoError(t, json.Unmarshal(op.Body, &rec))
+			a.records = append(a.records, rec)
+		}
+	}).Return([]bulk.BulkIndexerResponseItem(nil), err)
+}
+
+func (a *auditRecords) wait(t *testing.T, n int) []model.AuditRecord {
+	require.Eventually(t, func() bool {
+		a.mx.Lock()
+		defer a.mx.Unlock()
+		return len(a.records) >= n
+	}, time.Second, time.Millisecond)
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	return a.records
+}
+
+func auditTestRouter(t *testing.T, bulker *ftesting.MockBulk) http.Handler {
+	ctx, cancel := context.WithCancel(testlog.SetLogger(t).WithContext(context.Background()))
+	t.Cleanup(cancel)
+	trail := audittrail.New(bulker)
+	go trail.Run(ctx) //nolint:errcheck // returns when the test is done
+
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Limits.MaxActionTargets = 10
+	si := &apiServer{act: NewActionsT(cfg, bulker, nil)}
+	return newRouter(cfg, si, nil, trail)
+}
+
+func Test_auditTrail_createActions(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{UserName: "elastic", Enabled: true}, nil)
+	mockAdminPrivileges(t, bulker, true)
+	bulker.On("Create", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+	var audit auditRecords
+	audit.capture(t, bulker, nil)
+	hr := auditTestRouter(t, bulker)
+
+	// a successful dispatch
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/actions", strings.NewReader(`{"type":"UPGRADE","agents":["agent-1","agent-2"]}`))
+	r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+	hr.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp CreateActionsAPIResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+	// a dispatch with an invalid type
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/api/fleet/agents/actions", strings.NewReader(`{"type":"BAD","policy_id":"policy-1"}`))
+	r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+	hr.ServeHTTP(w, r)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	records := audit.wait(t, 2)
+	require.Len(t, records, 2)
+
+	rec := records[0]
+	require.Equal(t, "createActions", rec.Route)
+	require.Equal(t, http.MethodPost, rec.Method)
+	require.Equal(t, "/api/fleet/agents/actions", rec.Path)
+	require.Equal(t, "192.0.2.1", rec.ClientIP)
+	require.Equal(t, "id", rec.APIKeyID)
+	require.Equal(t, "elastic", rec.UserName)
+	require.Empty(t, rec.ServiceToken)
+	require.Equal(t, []string{"agent-1", "agent-2"}, rec.AgentIDs)
+	require.Empty(t, rec.PolicyIDs)
+	require.Equal(t, auditOutcomeSuccess, rec.Outcome)
+	require.Equal(t, int64(http.StatusOK), rec.StatusCode)
+	require.Empty(t, rec.Error)
+	require.Equal(t, "UPGRADE", rec.Details["type"])
+	require.ElementsMatch(t, resp.ActionIds, rec.Details["action_ids"])
+	_, err := time.Parse(time.RFC3339Nano, rec.Timestamp)
+	require.NoError(t, err)
+
+	rec = records[1]
+	require.Equal(t, "id", rec.APIKeyID)
+	require.Equal(t, auditOutcomeFailure, rec.Outcome)
+	require.Equal(t, int64(http.StatusBadRequest), rec.StatusCode)
+	require.Contains(t, rec.Error, "invalid type")
+	require.Empty(t, rec.AgentIDs)
+}
+
+func Test_auditTrail_trustedProxy(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{UserName: "elastic", Enabled: true}, nil)
+	mockAdminPrivileges(t, bulker, true)
+	bulker.On("Create", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+	var audit auditRecords
+	audit.capture(t, bulker, nil)
+	proxies, err := clientip.New([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	hr := clientip.Middleware(proxies)(auditTestRouter(t, bulker))
+
+	// the forwarded address is recorded for a trusted proxy, and ignored for any other peer
+	for _, remoteAddr := range []string{"10.0.0.1:1234", "198.51.100.1:1234"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/actions", strings.NewReader(`{"type":"UPGRADE","agents":["agent-1"]}`))
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+		r.Header.Set(clientip.HeaderForwardedFor, "203.0.113.1, 192.0.2.1")
+		hr.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	records := audit.wait(t, 2)
+	require.Equal(t, "192.0.2.1", records[0].ClientIP)
+	require.Equal(t, "198.51.100.1", records[1].ClientIP)
+}
+
+func Test_auditTrail_failureDoesNotFailRequest(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{UserName: "elastic", Enabled: true}, nil)
+	mockAdminPrivileges(t, bulker, true)
+	bulker.On("Create", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+	var audit auditRecords
+	audit.capture(t, bulker, errors.New("index not found"))
+	hr := auditTestRouter(t, bulker)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/actions", strings.NewReader(`{"type":"UPGRADE","agents":["agent-1"]}`))
+	r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+	hr.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	audit.wait(t, 1)
+}
+
+func Test_auditTrail_notAudited(t *testing.T) {
+	ctx := context.Background()
+	// the helpers are no-op outside of an audited request
+	auditCaller(ctx, "id", "", "elastic")
+	auditTargets(ctx, []string{"agent-1"}, nil)
+	auditDetail(ctx, "type", "UPGRADE")
+	auditError(ctx, errors.New("error"))
+
+	called := false
+	withAuditEntry(ctx, func(*model.AuditRecord) { called = true })
+	require.False(t, called)
+}
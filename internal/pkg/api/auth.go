@@ -6,13 +6,17 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 
 	"github.com/rs/zerolog/hlog"
@@ -24,6 +28,8 @@ var (
 	ErrAgentCorrupted   = errors.New("agent record corrupted")
 	ErrAgentInactive    = errors.New("agent inactive")
 	ErrAgentIdentity    = errors.New("agent header contains wrong identifier")
+	ErrAdminAuth        = errors.New("credentials are not allowed to manage agents")
+	ErrServiceTokenAuth = errors.New("a service token is required")
 )
 
 // authAPIKey authenticates the provided API key, it checks that the key exists and is enabled.
@@ -99,6 +105,7 @@ func authAgent(r *http.Request, id *string, bulker bulk.Bulk, c cache.Cache) (*m
 		return nil, err
 	}
 
+	auditCaller(ctx, key.ID, "", "")
 	w := hlog.FromRequest(r).With().
 		Str(LogAccessAPIKeyID, key.ID)
 
@@ -119,9 +126,9 @@ func authAgent(r *http.Request, id *string, bulker bulk.Bulk, c cache.Cache) (*m
 	var agent *model.Agent
 	// If we have the agentID retrieve the agent document with a get (more performant) instead of triggering a search
 	if id != nil {
-		agent, err = getAgentAndVerifyAPIKeyID(ctx, bulker, *id, key.ID)
+		agent, err = getAgentAndVerifyAPIKeyID(ctx, bulker, c, *id, key.ID)
 	} else {
-		agent, err = findAgentByAPIKeyID(ctx, bulker, key.ID)
+		agent, err = findAgentByAPIKeyID(ctx, bulker, c, key.ID)
 	}
 	if err != nil {
 		return nil, err
@@ -180,3 +187,122 @@ func authAgent(r *http.Request, id *string, bulker bulk.Bulk, c cache.Cache) (*m
 
 	return agent, nil
 }
+
+// authAdmin authenticates a caller that manages agents instead of being one.
+// The request must carry either an Elasticsearch service account token (Authorization: Bearer)
+// or an API key that is owned by a user, is not managed by fleet for agent use and has the es.AdminIndexPrivileges.
+// Results are not cached, the endpoints using this method are not expected to be called frequently.
+func authAdmin(r *http.Request, bulker bulk.Bulk) (*apikey.SecurityInfo, error) {
+	span, ctx := apm.StartSpan(r.Context(), "authAdmin", "auth")
+	defer span.End()
+	start := time.Now()
+
+	if strings.HasPrefix(r.Header.Get(apikey.AuthKey), "Bearer ") {
+		return authenticateServiceToken(ctx, r, bulker)
+	}
+
+	key, err := apikey.ExtractAPIKey(r)
+	if err != nil {
+		return nil, err
+	}
+	info, err := bulker.APIKeyAuth(ctx, *key)
+	if err != nil {
+		hlog.FromRequest(r).Info().
+			Err(err).
+			Str(LogAPIKeyID, key.ID).
+			Int64(ECSEventDuration, time.Since(start).Nanoseconds()).
+			Msg("ApiKey fail authentication")
+		return nil, err
+	}
+	if !info.Enabled {
+		return nil, ErrAPIKeyNotEnabled
+	}
+	// Static policy tokens authenticate without a user, they are only valid for enrollment.
+	if info.UserName == "" || isFleetManagedKey(info.Metadata) {
+		hlog.FromRequest(r).Warn().
+			Err(ErrAdminAuth).
+			Str(LogAPIKeyID, key.ID).
+			Msg("ApiKey is managed by fleet")
+		return nil, ErrAdminAuth
+	}
+	missing, err := es.CheckAdminPrivileges(ctx, bulker.Client(), "ApiKey "+key.Token())
+	if err != nil {
+		hlog.FromRequest(r).Info().
+			Err(err).
+			Str(LogAPIKeyID, key.ID).
+			Int64(ECSEventDuration, time.Since(start).Nanoseconds()).
+			Msg("ApiKey fail privileges check")
+		return nil, err
+	}
+	if len(missing) > 0 {
+		hlog.FromRequest(r).Warn().
+			Err(ErrAdminAuth).
+			Str(LogAPIKeyID, key.ID).
+			Strs("privileges", missing).
+			Msg("ApiKey is missing the privileges to manage agents")
+		return nil, ErrAdminAuth
+	}
+
+	hlog.FromRequest(r).Debug().
+		Str(LogAPIKeyID, key.ID).
+		Str("userName", info.UserName).
+		Int64(ECSEventDuration, time.Since(start).Nanoseconds()).
+		Msg("Admin ApiKey authenticated")
+	auditCaller(ctx, key.ID, "", info.UserName)
+	return info, nil
+}
+
+// authServiceToken authenticates a caller that manages agents with an Elasticsearch service account token
+// (Authorization: Bearer) only, API keys are rejected whatever their privileges.
+func authServiceToken(r *http.Request, bulker bulk.Bulk) (*apikey.SecurityInfo, error) {
+	span, ctx := apm.StartSpan(r.Context(), "authServiceToken", "auth")
+	defer span.End()
+
+	if header := r.Header.Get(apikey.AuthKey); header != "" && !strings.HasPrefix(header, "Bearer ") {
+		hlog.FromRequest(r).Warn().
+			Err(ErrServiceTokenAuth).
+			Msg("Endpoint requires a service token")
+		return nil, ErrServiceTokenAuth
+	}
+	return authenticateServiceToken(ctx, r, bulker)
+}
+
+// authenticateServiceToken authenticates the service account token of the request.
+func authenticateServiceToken(ctx context.Context, r *http.Request, bulker bulk.Bulk) (*apikey.SecurityInfo, error) {
+	start := time.Now()
+	token, err := apikey.ExtractServiceToken(r)
+	if err != nil {
+		return nil, err
+	}
+	info, err := apikey.AuthenticateServiceToken(ctx, bulker.Client(), token)
+	if err != nil {
+		hlog.FromRequest(r).Info().
+			Err(err).
+			Int64(ECSEventDuration, time.Since(start).Nanoseconds()).
+			Msg("Service token fail authentication")
+		return nil, err
+	}
+	if !info.IsServiceAccount() {
+		return nil, ErrAdminAuth
+	}
+	hlog.FromRequest(r).Debug().
+		Str("userName", info.UserName).
+		Int64(ECSEventDuration, time.Since(start).Nanoseconds()).
+		Msg("Service token authenticated")
+	auditCaller(ctx, "", apikey.ServiceTokenName(token), info.UserName)
+	return info, nil
+}
+
+// isFleetManagedKey returns true if the API key metadata marks the key as one that is used by agents,
+// for example access, output, or enrollment keys.
+func isFleetManagedKey(raw json.RawMessage) bool {
+	if len(raw) == 0 {
+		return false
+	}
+	var meta apikey.Metadata
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		// Unknown metadata formats are not fleet keys
+		return false
+	}
+	return meta.ManagedBy == apikey.ManagedByFleetServer || (meta.Managed && meta.ManagedBy == "fleet")
+}
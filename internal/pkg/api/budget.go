@@ -0,0 +1,63 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+// ErrBudgetExceeded is returned when an operation of a request exceeds its share of the operation budget of the endpoint.
+var ErrBudgetExceeded = errors.New("operation budget exceeded")
+
+// budget derives the deadlines of the operations of a request from the operation budget of its endpoint.
+//
+// The deadlines compose: a deadline derived from a context never extends the deadline of that context,
+// so the operations derived from the context of the total budget all end with it.
+type budget config.OperationBudget
+
+// total bounds all the operations of the request by the total budget.
+func (b budget) total(ctx context.Context) (context.Context, context.CancelFunc) {
+	return b.derive(ctx, "request", 1)
+}
+
+// auth bounds the authentication of the request.
+func (b budget) auth(ctx context.Context) (context.Context, context.CancelFunc) {
+	return b.derive(ctx, "auth", b.Auth)
+}
+
+// read bounds a read operation of the request.
+func (b budget) read(ctx context.Context) (context.Context, context.CancelFunc) {
+	return b.derive(ctx, "read", b.Read)
+}
+
+// write bounds a write operation of the request.
+func (b budget) write(ctx context.Context) (context.Context, context.CancelFunc) {
+	return b.derive(ctx, "write", b.Write)
+}
+
+func (b budget) derive(ctx context.Context, op string, fraction float64) (context.Context, context.CancelFunc) {
+	if b.Total <= 0 || fraction <= 0 {
+		return context.WithCancel(ctx)
+	}
+	timeout := time.Duration(fraction * float64(b.Total))
+	return context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%s %w after %s", op, ErrBudgetExceeded, timeout))
+}
+
+// budgetErr returns err wrapped with ErrBudgetExceeded if it was returned once a budget of ctx was exceeded.
+// Errors that are unrelated to the budget, or caused by the cancellation of the request, are returned as is.
+func budgetErr(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	if cause := context.Cause(ctx); errors.Is(cause, ErrBudgetExceeded) && !errors.Is(err, ErrBudgetExceeded) {
+		return fmt.Errorf("%w: %w", cause, err)
+	}
+	return err
+}
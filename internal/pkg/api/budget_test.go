@@ -0,0 +1,76 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+func TestBudgetDeadlines(t *testing.T) {
+	b := budget(config.OperationBudget{Total: 10 * time.Second, Auth: 0.2, Read: 0.3, Write: 0.5})
+	now := time.Now()
+	deadline := func(derive func(context.Context) (context.Context, context.CancelFunc), parent context.Context) time.Duration {
+		ctx, cancel := derive(parent)
+		defer cancel()
+		d, ok := ctx.Deadline()
+		require.True(t, ok)
+		return d.Sub(now)
+	}
+
+	require.InDelta(t, 10*time.Second, deadline(b.total, context.Background()), float64(time.Second))
+	require.InDelta(t, 2*time.Second, deadline(b.auth, context.Background()), float64(time.Second))
+	require.InDelta(t, 3*time.Second, deadline(b.read, context.Background()), float64(time.Second))
+	require.InDelta(t, 5*time.Second, deadline(b.write, context.Background()), float64(time.Second))
+
+	// a derived deadline does not extend the deadline of its parent
+	parent, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	parentDeadline, _ := parent.Deadline()
+	require.Equal(t, parentDeadline.Sub(now), deadline(b.write, parent))
+
+	for _, disabled := range []budget{{}, {Total: time.Second}} {
+		ctx, cancel := disabled.read(context.Background())
+		_, ok := ctx.Deadline()
+		cancel()
+		require.False(t, ok, "no deadline without total or fraction")
+	}
+}
+
+func TestBudgetErr(t *testing.T) {
+	errES := errors.New("elasticsearch error")
+	b := budget(config.OperationBudget{Total: time.Millisecond, Read: 1})
+
+	ctx, cancel := b.read(context.Background())
+	defer cancel()
+	require.NoError(t, budgetErr(ctx, nil))
+	<-ctx.Done()
+	err := budgetErr(ctx, context.DeadlineExceeded)
+	require.ErrorIs(t, err, ErrBudgetExceeded)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, 503, NewHTTPErrResp(err).StatusCode)
+
+	// the budget of the parent is reported through the derived context
+	total, cancelTotal := b.total(context.Background())
+	defer cancelTotal()
+	<-total.Done()
+	derived, cancelDerived := budget(config.OperationBudget{Total: time.Hour, Read: 1}).read(total)
+	defer cancelDerived()
+	require.ErrorIs(t, budgetErr(derived, errES), ErrBudgetExceeded)
+
+	// errors before the deadline and cancelled requests are not reported as exceeding the budget
+	ctx, cancel = b.read(context.Background())
+	require.Equal(t, errES, budgetErr(ctx, errES))
+	cancel()
+	require.Equal(t, context.Canceled, budgetErr(ctx, context.Canceled))
+}
@@ -0,0 +1,101 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+)
+
+// checkinDelay recommends a delay before their next checkin to the agents, raised with the load of the instance.
+// The delay is computed by a background job every interval, the checkin handlers read it without locking.
+type checkinDelay struct {
+	cfg config.CheckinDelay
+	// capacity is the number of agents the instance is sized for, the load is zero if it is not set.
+	capacity  atomic.Int64
+	connected func() int64
+
+	delay atomic.Int64  // the recommended time.Duration
+	load  atomic.Uint64 // the bits of the float64 ratio of the connected agents to the capacity
+}
+
+func newCheckinDelay(cfg *config.Server, connected func() int64) *checkinDelay {
+	cd := &checkinDelay{
+		cfg:       cfg.CheckinDelay,
+		connected: connected,
+	}
+	cd.reload(&cfg.Limits)
+	cd.delay.Store(int64(cd.cfg.Min))
+	return cd
+}
+
+// reload sets the capacity from the limits, the max agents or else the max connections. The delay is computed from it
+// on the next update.
+func (cd *checkinDelay) reload(cfg *config.ServerLimits) {
+	capacity := cfg.MaxAgents
+	if capacity <= 0 {
+		capacity = cfg.MaxConnections
+	}
+	cd.capacity.Store(int64(capacity))
+}
+
+// Schedule returns the schedule computing the delay.
+func (cd *checkinDelay) Schedule() scheduler.Schedule {
+	return scheduler.Schedule{
+		Name:       "checkin delay",
+		Interval:   cd.cfg.Interval,
+		RunOnStart: true,
+		WorkFn: func(context.Context) error {
+			cd.update()
+			return nil
+		},
+	}
+}
+
+// update computes the delay from the current number of connected agents.
+func (cd *checkinDelay) update() {
+	var load float64
+	if capacity := cd.capacity.Load(); capacity > 0 {
+		load = float64(cd.connected()) / float64(capacity)
+	}
+	cd.load.Store(math.Float64bits(load))
+	cd.delay.Store(int64(cd.compute(load)))
+}
+
+// compute returns the delay for the load: the floor up to the threshold, then rising linearly to the ceiling as the
+// load reaches the capacity.
+func (cd *checkinDelay) compute(load float64) time.Duration {
+	switch {
+	case load <= cd.cfg.Threshold:
+		return cd.cfg.Min
+	case load >= 1:
+		return cd.cfg.Max
+	}
+	ratio := (load - cd.cfg.Threshold) / (1 - cd.cfg.Threshold)
+	return cd.cfg.Min + time.Duration(float64(cd.cfg.Max-cd.cfg.Min)*ratio)
+}
+
+// next returns the delay recommended to the agents of a policy, policyDelay is the delay set by the policy.
+// The longer of the two is used, within the floor and the ceiling, and rounded to the second.
+func (cd *checkinDelay) next(policyDelay time.Duration) time.Duration {
+	d := max(time.Duration(cd.delay.Load()), policyDelay)
+	return min(max(d, cd.cfg.Min), cd.cfg.Max).Round(time.Second)
+}
+
+// report reports the recommended delay in seconds and the load it was computed from.
+func (cd *checkinDelay) report(_ monitoring.Mode, v monitoring.Visitor) {
+	v.OnRegistryStart()
+	defer v.OnRegistryFinished()
+
+	monitoring.ReportInt(v, "delay", int64(time.Duration(cd.delay.Load()).Seconds()))
+	monitoring.ReportFloat(v, "load", math.Float64frombits(cd.load.Load()))
+}
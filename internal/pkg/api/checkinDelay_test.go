@@ -0,0 +1,128 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func checkinDelayTestCfg() *config.Server {
+	cfg := &config.Server{}
+	cfg.CheckinDelay.InitDefaults()
+	cfg.CheckinDelay.Min = 10 * time.Second
+	cfg.Limits.MaxAgents = 100
+	return cfg
+}
+
+func Test_checkinDelay_load(t *testing.T) {
+	var connected int64
+	cd := newCheckinDelay(checkinDelayTestCfg(), func() int64 { return connected })
+	require.Equal(t, 10*time.Second, cd.next(0), "the floor is recommended before the first update")
+
+	// the delay is the floor up to the threshold, then rises to the ceiling at the capacity
+	tests := []struct {
+		connected int64
+		delay     time.Duration
+	}{
+		{0, 10 * time.Second},
+		{80, 10 * time.Second},
+		{84, 68 * time.Second},
+		{90, 155 * time.Second},
+		{96, 242 * time.Second},
+		{100, 5 * time.Minute},
+		{250, 5 * time.Minute},
+	}
+	var last time.Duration
+	for _, tc := range tests {
+		connected = tc.connected
+		cd.update()
+		require.Equal(t, tc.delay, cd.next(0), "%d connected agents", tc.connected)
+		require.GreaterOrEqual(t, cd.next(0), last)
+		last = cd.next(0)
+	}
+
+	// the recommendation is reported in the stats
+	reg := monitoring.NewRegistry()
+	monitoring.NewFunc(reg, "next_checkin_delay", cd.report)
+	snapshot := monitoring.CollectStructSnapshot(reg, monitoring.Full, false)
+	require.Equal(t, map[string]interface{}{"delay": int64(300), "load": 2.5}, snapshot["next_checkin_delay"])
+}
+
+func Test_checkinDelay_bounds(t *testing.T) {
+	cfg := checkinDelayTestCfg()
+	var connected int64
+	cd := newCheckinDelay(cfg, func() int64 { return connected })
+
+	// the delay of a policy is used when it is longer, within the floor and the ceiling
+	require.Equal(t, 10*time.Second, cd.next(time.Second))
+	require.Equal(t, time.Minute, cd.next(time.Minute))
+	require.Equal(t, 5*time.Minute, cd.next(time.Hour))
+	connected = 90
+	cd.update()
+	require.Equal(t, 155*time.Second, cd.next(time.Minute))
+	require.Equal(t, 4*time.Minute, cd.next(4*time.Minute))
+
+	// without capacity the load is unknown, the floor is recommended
+	cfg.Limits.MaxAgents = 0
+	cd = newCheckinDelay(cfg, func() int64 { return 1000 })
+	cd.update()
+	require.Equal(t, 10*time.Second, cd.next(0))
+
+	// the capacity falls back to the max connections
+	cfg.Limits.MaxConnections = 1000
+	cd = newCheckinDelay(cfg, func() int64 { return 1000 })
+	cd.update()
+	require.Equal(t, 5*time.Minute, cd.next(0))
+
+	// a reloaded capacity is used by the next update
+	cfg.Limits.MaxAgents = 10000
+	cd.reload(&cfg.Limits)
+	cd.update()
+	require.Equal(t, 10*time.Second, cd.next(0))
+}
+
+func Test_CheckinT_nextCheckinDelay(t *testing.T) {
+	cfg := checkinDelayTestCfg()
+	ct, err := NewCheckinT(nil, cfg, nil, nil, nil, nil, nil, ftesting.NewMockBulk())
+	require.NoError(t, err)
+	ct.pm = &limitsMonitor{limits: map[string]policy.Limits{"slow": {NextCheckinDelay: 2 * time.Minute}}}
+	_, ok := ct.CheckinDelaySchedule()
+	require.True(t, ok)
+
+	write := func(policyID string) CheckinResponse {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/api/fleet/agents/agent-1/checkin", nil)
+		err := ct.writeResponse(w, r.WithContext(testlog.SetLogger(t).WithContext(context.Background())), &model.Agent{PolicyID: policyID}, checkinResponse{})
+		require.NoError(t, err)
+		var resp CheckinResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+	require.Equal(t, "10s", *write("fast").NextCheckinDelay)
+	require.Equal(t, "2m0s", *write("slow").NextCheckinDelay)
+
+	// the delay is not recommended when disabled
+	cfg.CheckinDelay.Enabled = false
+	ct, err = NewCheckinT(nil, cfg, nil, nil, nil, nil, nil, ftesting.NewMockBulk())
+	require.NoError(t, err)
+	_, ok = ct.CheckinDelaySchedule()
+	require.False(t, ok)
+	require.Nil(t, write("slow").NextCheckinDelay)
+}
@@ -0,0 +1,157 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// checkinEncoder renders the checkin responses in the shape negotiated by the agent with the Elastic-Api-Version header.
+// The handlers build a single checkinResponse, with the data of each action decoded into the struct of its type, and
+// the encoder is the only place where the shape of the response depends on the version:
+//   - v1, the default, is a CheckinResponse with the data of each action in its data field. The agents that do not
+//     negotiate a version rely on it, it is never changed.
+//   - v2, from CheckinV2Version, is a CheckinResponseV2 with the data of each action in the typed field of its type.
+type checkinEncoder struct {
+	v2 bool
+}
+
+// newCheckinEncoder returns the encoder of the version of the request, the version was validated by the middleware.
+func newCheckinEncoder(r *http.Request) checkinEncoder {
+	version := r.Header.Get(ElasticAPIVersionHeader)
+	// the versions are dates, they are ordered as strings
+	return checkinEncoder{v2: version >= CheckinV2Version}
+}
+
+// checkinResponse is the response of a checkin as the handler builds it.
+// The actions are omitted from the rendering when they are nil, as when the long poll is canceled.
+type checkinResponse struct {
+	ackToken         string
+	actions          []checkinAction
+	nextCheckinDelay string
+}
+
+// checkinAction is an action of a checkin response. Action holds the fields shared by the versions, its Data is not
+// set: the data of the action is payload, one of *ActionCancel, ActionInputAction, *ActionPolicyReassign,
+// *ActionRequestDiagnostics, *ActionSettings, *ActionUpgrade or policyChangePayload, or nil for the actions without data.
+type checkinAction struct {
+	Action
+	payload any
+}
+
+// policyChangePayload is the encoded ActionPolicyChange of a POLICY_CHANGE action. The body of a policy revision is
+// encoded once and spliced with the outputs of each agent, it is not decoded into an ActionPolicyChange.
+type policyChangePayload json.RawMessage
+
+// encode writes the JSON rendering of the response to w, followed by a newline.
+func (e checkinEncoder) encode(w io.Writer, resp *checkinResponse) error {
+	if e.v2 {
+		return json.NewEncoder(w).Encode(toCheckinResponseV2(resp))
+	}
+	v1, err := toCheckinResponseV1(resp)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(v1)
+}
+
+func toCheckinResponseV1(resp *checkinResponse) (CheckinResponse, error) {
+	v1 := CheckinResponse{
+		AckToken: &resp.ackToken,
+		Action:   "checkin",
+	}
+	if resp.nextCheckinDelay != "" {
+		v1.NextCheckinDelay = &resp.nextCheckinDelay
+	}
+	if resp.actions == nil {
+		return v1, nil
+	}
+	actions := make([]Action, 0, len(resp.actions))
+	for _, a := range resp.actions {
+		action := a.Action
+		switch p := a.payload.(type) {
+		case nil:
+		case policyChangePayload:
+			action.Data = Action_Data{union: json.RawMessage(p)}
+		default:
+			data, err := json.Marshal(p)
+			if err != nil {
+				return v1, fmt.Errorf("failed to encode the data of action %s: %w", a.Id, err)
+			}
+			action.Data = Action_Data{union: data}
+		}
+		actions = append(actions, action)
+	}
+	v1.Actions = &actions
+	return v1, nil
+}
+
+// actionV2 is the rendering of an action in a CheckinResponseV2, its PolicyChange shadows the typed field of
+// ActionV2 with the same JSON name to render the encoded policyChangePayload.
+type actionV2 struct {
+	ActionV2
+	PolicyChange json.RawMessage `json:"policy_change,omitempty"`
+}
+
+// checkinResponseV2 is the rendering of a CheckinResponseV2 with the actions rendered by actionV2.
+type checkinResponseV2 struct {
+	CheckinResponseV2
+	Actions []actionV2 `json:"actions,omitempty"`
+}
+
+func toCheckinResponseV2(resp *checkinResponse) checkinResponseV2 {
+	v2 := checkinResponseV2{
+		CheckinResponseV2: CheckinResponseV2{
+			AckToken: &resp.ackToken,
+			Action:   "checkin",
+		},
+	}
+	if resp.nextCheckinDelay != "" {
+		v2.NextCheckinDelay = &resp.nextCheckinDelay
+	}
+	for _, a := range resp.actions {
+		v2.Actions = append(v2.Actions, toActionV2(a))
+	}
+	return v2
+}
+
+func toActionV2(action checkinAction) actionV2 {
+	a := actionV2{
+		ActionV2: ActionV2{
+			AgentId:     action.AgentId,
+			CreatedAt:   action.CreatedAt,
+			Expiration:  action.Expiration,
+			Id:          action.Id,
+			Signed:      action.Signed,
+			StartTime:   action.StartTime,
+			Timeout:     action.Timeout,
+			Traceparent: action.Traceparent,
+			Type:        string(action.Type),
+		},
+	}
+	if action.InputType != "" {
+		a.InputType = &action.InputType
+	}
+	switch p := action.payload.(type) {
+	case *ActionCancel:
+		a.Cancel = p
+	case ActionInputAction:
+		a.InputAction = &p
+	case policyChangePayload:
+		a.PolicyChange = json.RawMessage(p)
+	case *ActionPolicyReassign:
+		a.PolicyReassign = p
+	case *ActionRequestDiagnostics:
+		a.RequestDiagnostics = p
+	case *ActionSettings:
+		a.Settings = p
+	case *ActionUpgrade:
+		a.Upgrade = p
+	}
+	return a
+}
@@ -0,0 +1,130 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+// checkinEncoderResponse returns a response with an action of each type, built as the checkin handler builds them.
+func checkinEncoderResponse(t *testing.T) checkinResponse {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	actions, _ := convertActions(ctx, "agent-1", []model.Action{
+		{ActionID: "cancel-1", Type: string(CANCEL), Timestamp: "2025-01-01T00:00:00Z", Data: json.RawMessage(`{"target_id":"upgrade-1"}`)},
+		{ActionID: "input-1", Type: string(INPUTACTION), Timestamp: "2025-01-01T00:00:00Z", InputType: "osquery", Timeout: 300, Data: json.RawMessage(`{"query":"select 1"}`)},
+		{ActionID: "reassign-1", Type: string(POLICYREASSIGN), Timestamp: "2025-01-01T00:00:00Z", Data: json.RawMessage(`{"policy_id":"policy-2"}`)},
+		{ActionID: "diagnostics-1", Type: string(REQUESTDIAGNOSTICS), Timestamp: "2025-01-01T00:00:00Z", Data: json.RawMessage(`{"additional_metrics":["CPU"]}`)},
+		{ActionID: "settings-1", Type: string(SETTINGS), Timestamp: "2025-01-01T00:00:00Z", Data: json.RawMessage(`{"log_level":"debug"}`)},
+		{ActionID: "unenroll-1", Type: string(UNENROLL), Timestamp: "2025-01-01T00:00:00Z"},
+		{ActionID: "upgrade-1", Type: string(UPGRADE), Timestamp: "2025-01-01T00:00:00Z", StartTime: "2025-01-02T00:00:00Z", Expiration: "2025-01-03T00:00:00Z",
+			Traceparent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", Data: json.RawMessage(`{"version":"9.1.0","source_uri":"https://artifacts.example.com"}`),
+			Signed: &model.Signed{Data: "eyJ2ZXJzaW9uIjoiOS4xLjAifQ==", Signature: "c2lnbmF0dXJl"}},
+		// the actions of an unknown type are skipped, they do not fail the response
+		{ActionID: "unknown-1", Type: "UNKNOWN", Timestamp: "2025-01-01T00:00:00Z", Data: json.RawMessage(`{}`)},
+	})
+	require.Len(t, actions, 7)
+	data, err := policyChangeData([]byte(`{"id":"policy-1","revision":2,"inputs":[{"type":"logfile"}]}`), map[string]map[string]interface{}{"default": {"type": "elasticsearch"}}, []string{})
+	require.NoError(t, err)
+	policyChange := checkinAction{
+		Action:  Action{AgentId: "agent-1", CreatedAt: "2025-01-01T00:00:00Z", Id: "policy:policy-1:2:1", Type: POLICYCHANGE},
+		payload: policyChangePayload(data),
+	}
+	return checkinResponse{ackToken: "1", actions: append([]checkinAction{policyChange}, actions...), nextCheckinDelay: "30s"}
+}
+
+func requireGolden(t *testing.T, name string, body []byte) {
+	golden, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	require.JSONEq(t, string(golden), string(body))
+}
+
+func TestCheckinEncoder(t *testing.T) {
+	resp := checkinEncoderResponse(t)
+
+	// both renderings of the same actions
+	var v1 bytes.Buffer
+	require.NoError(t, checkinEncoder{}.encode(&v1, &resp))
+	requireGolden(t, "checkin-response-v1.golden.json", v1.Bytes())
+	var v2 bytes.Buffer
+	require.NoError(t, checkinEncoder{v2: true}.encode(&v2, &resp))
+	requireGolden(t, "checkin-response-v2.golden.json", v2.Bytes())
+
+	// the data of the v2 actions decodes into the typed fields
+	var typed CheckinResponseV2
+	require.NoError(t, json.Unmarshal(v2.Bytes(), &typed))
+	require.Len(t, *typed.Actions, 8)
+	require.Equal(t, "policy-1", *(*typed.Actions)[0].PolicyChange.Policy.Id)
+	require.Equal(t, "upgrade-1", (*typed.Actions)[1].Cancel.TargetId)
+	require.Equal(t, "debug", string(*(*typed.Actions)[5].Settings.LogLevel))
+	require.Equal(t, "9.1.0", (*typed.Actions)[7].Upgrade.Version)
+	require.Nil(t, (*typed.Actions)[6].Upgrade, "the actions without data have no typed field")
+
+	// the data of the v1 actions decodes into the same typed structs
+	var untyped CheckinResponse
+	require.NoError(t, json.Unmarshal(v1.Bytes(), &untyped))
+	require.Len(t, *untyped.Actions, 8)
+	cancel, err := (*untyped.Actions)[1].Data.AsActionCancel()
+	require.NoError(t, err)
+	require.Equal(t, *(*typed.Actions)[1].Cancel, cancel)
+	upgrade, err := (*untyped.Actions)[7].Data.AsActionUpgrade()
+	require.NoError(t, err)
+	require.Equal(t, *(*typed.Actions)[7].Upgrade, upgrade)
+}
+
+func TestCheckinEncoder_noActions(t *testing.T) {
+	// the actions are omitted when the long poll is canceled, an empty list is rendered in v1 otherwise
+	var v1 bytes.Buffer
+	require.NoError(t, checkinEncoder{}.encode(&v1, &checkinResponse{ackToken: "1"}))
+	require.JSONEq(t, `{"ack_token":"1","action":"checkin"}`, v1.String())
+	v1.Reset()
+	require.NoError(t, checkinEncoder{}.encode(&v1, &checkinResponse{ackToken: "1", actions: []checkinAction{}}))
+	require.JSONEq(t, `{"ack_token":"1","action":"checkin","actions":[]}`, v1.String())
+
+	var v2 bytes.Buffer
+	require.NoError(t, checkinEncoder{v2: true}.encode(&v2, &checkinResponse{ackToken: "1", actions: []checkinAction{}}))
+	require.JSONEq(t, `{"ack_token":"1","action":"checkin"}`, v2.String())
+}
+
+func TestCheckinEncoder_negotiation(t *testing.T) {
+	cfg := checkinDelayTestCfg()
+	cfg.CheckinDelay.Enabled = false
+	ct, err := NewCheckinT(nil, cfg, nil, nil, nil, nil, nil, ftesting.NewMockBulk())
+	require.NoError(t, err)
+
+	tests := []struct {
+		version string
+		golden  string
+	}{
+		{"", "checkin-response-v1.golden.json"},
+		{DefaultVersion, "checkin-response-v1.golden.json"},
+		{CheckinV2Version, "checkin-response-v2.golden.json"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.version, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/api/fleet/agents/agent-1/checkin", nil)
+			if tc.version != "" {
+				r.Header.Set(ElasticAPIVersionHeader, tc.version)
+			}
+			r = r.WithContext(testlog.SetLogger(t).WithContext(context.Background()))
+			require.NoError(t, ct.writeResponse(w, r, &model.Agent{}, checkinEncoderResponse(t)))
+			requireGolden(t, tc.golden, w.Body.Bytes())
+		})
+	}
+}
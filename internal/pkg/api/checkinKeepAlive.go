@@ -0,0 +1,54 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// checkinKeepAlive is written to the response of a long poll while it waits.
+// It is JSON whitespace, so the agents decoding the checkin response ignore it.
+var checkinKeepAlive = []byte("\n")
+
+// keepAliveWriter writes keep-alive bytes to the response of a checkin long poll.
+// The status and headers of the response are sent with the first keep-alive bytes, after which the response
+// is written uncompressed and an error can no longer be written to the agent.
+type keepAliveWriter struct {
+	http.ResponseWriter
+	rc       *http.ResponseController
+	interval time.Duration
+	sent     bool
+}
+
+func newKeepAliveWriter(w http.ResponseWriter, interval time.Duration) *keepAliveWriter {
+	return &keepAliveWriter{
+		ResponseWriter: w,
+		rc:             http.NewResponseController(w),
+		interval:       interval,
+	}
+}
+
+// keepAlive writes and flushes the keep-alive bytes, the response is chunked as its length is unknown.
+func (kw *keepAliveWriter) keepAlive() error {
+	n, err := kw.Write(checkinKeepAlive)
+	kw.sent = true
+	cntCheckin.bodyOut.Add(uint64(n)) //nolint:gosec // disable G115
+	if err != nil {
+		return err
+	}
+	return kw.rc.Flush()
+}
+
+// Unwrap returns the wrapped writer, so http.ResponseController can set its deadlines.
+func (kw *keepAliveWriter) Unwrap() http.ResponseWriter {
+	return kw.ResponseWriter
+}
+
+// keepAliveSent returns true if keep-alive bytes were written to w.
+func keepAliveSent(w http.ResponseWriter) bool {
+	kw, ok := w.(*keepAliveWriter)
+	return ok && kw.sent
+}
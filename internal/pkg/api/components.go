@@ -0,0 +1,132 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+const (
+	// maxComponentsSize is the maximum size of the JSON components stored on an agent document.
+	maxComponentsSize = 64 * 1024
+	// maxComponentMessageLen is the maximum length of the message of a component or unit.
+	maxComponentMessageLen = 1024
+
+	// truncatedComponentID is the ID of the component that replaces the components over maxComponentsSize.
+	truncatedComponentID   = "fleet-server-truncated"
+	truncatedMessageSuffix = "... (truncated)"
+	// truncatedComponentReserve is room kept for the truncated component in maxComponentsSize.
+	truncatedComponentReserve = 256
+)
+
+// normalizeComponents returns the components to store on the agent document.
+// The components are sorted by ID and their units by ID and type, the messages are truncated to maxComponentMessageLen.
+// The components that do not fit in maxComponentsSize are replaced by a single component with truncatedComponentID,
+// its status is the worst status of the components it replaces.
+func normalizeComponents(components []model.ComponentsItems) []model.ComponentsItems {
+	out := make([]model.ComponentsItems, len(components))
+	for i, c := range components {
+		c.Message = truncateMessage(c.Message)
+		if c.Units != nil {
+			units := make([]model.UnitsItems, len(c.Units))
+			for j, u := range c.Units {
+				u.Message = truncateMessage(u.Message)
+				units[j] = u
+			}
+			slices.SortStableFunc(units, func(a, b model.UnitsItems) int {
+				return cmp.Or(strings.Compare(a.ID, b.ID), strings.Compare(a.Type, b.Type))
+			})
+			c.Units = units
+		}
+		out[i] = c
+	}
+	slices.SortStableFunc(out, func(a, b model.ComponentsItems) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	size := len("[]")
+	for i, c := range out {
+		b, err := json.Marshal(c)
+		if err != nil {
+			continue
+		}
+		size += len(b) + len(",")
+		if size > maxComponentsSize-truncatedComponentReserve {
+			dropped := out[i:]
+			return append(out[:i:i], model.ComponentsItems{
+				ID:      truncatedComponentID,
+				Status:  componentsDigest(dropped),
+				Message: fmt.Sprintf("%d of %d components truncated", len(dropped), len(out)),
+			})
+		}
+	}
+	return out
+}
+
+func truncateMessage(msg string) string {
+	return truncateString(msg, maxComponentMessageLen)
+}
+
+// truncateString truncates msg to at most size bytes on a rune boundary, a truncated string ends with truncatedMessageSuffix.
+func truncateString(msg string, size int) string {
+	if len(msg) <= size {
+		return msg
+	}
+	n := size - len(truncatedMessageSuffix)
+	for n > 0 && !utf8.RuneStart(msg[n]) {
+		n--
+	}
+	return msg[:n] + truncatedMessageSuffix
+}
+
+// componentsDigest returns the worst status of the components and their units: FAILED, DEGRADED or HEALTHY.
+// It is empty when there are no components.
+func componentsDigest(components []model.ComponentsItems) string {
+	if len(components) == 0 {
+		return ""
+	}
+	rank := func(status string) int {
+		switch status {
+		case FailedStatus:
+			return 2
+		case DegradedStatus:
+			return 1
+		}
+		return 0
+	}
+	worst := 0
+	for _, c := range components {
+		worst = max(worst, rank(c.Status))
+		for _, u := range c.Units {
+			worst = max(worst, rank(u.Status))
+		}
+	}
+	switch worst {
+	case 2:
+		return FailedStatus
+	case 1:
+		return DegradedStatus
+	}
+	return HealthyStatus
+}
+
+// checkinStatus returns the status stored on the agent document, the status reported by the agent is
+// escalated to error or degraded when the digest of its components is worse so existing status queries see it.
+func checkinStatus(status CheckinRequestStatus, digest string) string {
+	switch {
+	case digest == FailedStatus && (status == CheckinRequestStatusOnline || status == CheckinRequestStatusDegraded):
+		return string(CheckinRequestStatusError)
+	case digest == DegradedStatus && status == CheckinRequestStatusOnline:
+		return string(CheckinRequestStatusDegraded)
+	}
+	return string(status)
+}
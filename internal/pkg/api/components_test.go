@@ -0,0 +1,130 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComponentsDigest(t *testing.T) {
+	tests := []struct {
+		name       string
+		components []model.ComponentsItems
+		digest     string
+	}{{
+		name:   "no components",
+		digest: "",
+	}, {
+		name: "healthy",
+		components: []model.ComponentsItems{{
+			ID: "system/metrics", Status: HealthyStatus,
+			Units: []model.UnitsItems{{ID: "system/metrics-input", Status: HealthyStatus}},
+		}},
+		digest: HealthyStatus,
+	}, {
+		name: "degraded unit",
+		components: []model.ComponentsItems{{
+			ID: "filestream", Status: HealthyStatus,
+			Units: []model.UnitsItems{{ID: "filestream-input", Status: DegradedStatus}},
+		}, {
+			ID: "system/metrics", Status: HealthyStatus,
+		}},
+		digest: DegradedStatus,
+	}, {
+		name: "failed component wins over degraded",
+		components: []model.ComponentsItems{{
+			ID: "filestream", Status: DegradedStatus,
+		}, {
+			ID: "system/metrics", Status: FailedStatus,
+		}},
+		digest: FailedStatus,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.digest, componentsDigest(tc.components))
+		})
+	}
+}
+
+func TestCheckinStatus(t *testing.T) {
+	tests := []struct {
+		status CheckinRequestStatus
+		digest string
+		want   string
+	}{
+		{CheckinRequestStatusOnline, "", "online"},
+		{CheckinRequestStatusOnline, HealthyStatus, "online"},
+		{CheckinRequestStatusOnline, DegradedStatus, "degraded"},
+		{CheckinRequestStatusOnline, FailedStatus, "error"},
+		{CheckinRequestStatusDegraded, FailedStatus, "error"},
+		{CheckinRequestStatusStarting, FailedStatus, "starting"},
+		{CheckinRequestStatusError, DegradedStatus, "error"},
+	}
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("%s/%s", tc.status, tc.digest), func(t *testing.T) {
+			assert.Equal(t, tc.want, checkinStatus(tc.status, tc.digest))
+		})
+	}
+}
+
+func TestNormalizeComponents(t *testing.T) {
+	t.Run("sorted and messages truncated", func(t *testing.T) {
+		components := normalizeComponents([]model.ComponentsItems{{
+			ID: "system/metrics", Status: HealthyStatus,
+		}, {
+			ID: "filestream", Status: DegradedStatus, Message: strings.Repeat("x", 2*maxComponentMessageLen),
+			Units: []model.UnitsItems{{ID: "b", Type: "input"}, {ID: "a", Type: "output"}, {ID: "a", Type: "input"}},
+		}})
+
+		require.Len(t, components, 2)
+		assert.Equal(t, "filestream", components[0].ID)
+		assert.Equal(t, "system/metrics", components[1].ID)
+		assert.Len(t, components[0].Message, maxComponentMessageLen)
+		assert.True(t, strings.HasSuffix(components[0].Message, truncatedMessageSuffix))
+		assert.Equal(t, []model.UnitsItems{{ID: "a", Type: "input"}, {ID: "a", Type: "output"}, {ID: "b", Type: "input"}}, components[0].Units)
+	})
+
+	t.Run("oversized payload truncated deterministically", func(t *testing.T) {
+		var in []model.ComponentsItems
+		for i := 0; i < 200; i++ {
+			status := HealthyStatus
+			if i == 199 {
+				status = FailedStatus
+			}
+			in = append(in, model.ComponentsItems{
+				ID:      fmt.Sprintf("component-%03d", i),
+				Status:  status,
+				Message: strings.Repeat("m", maxComponentMessageLen),
+			})
+		}
+		reversed := make([]model.ComponentsItems, len(in))
+		for i := range in {
+			reversed[len(in)-1-i] = in[i]
+		}
+
+		components := normalizeComponents(in)
+		assert.Equal(t, components, normalizeComponents(reversed))
+
+		last := components[len(components)-1]
+		assert.Equal(t, truncatedComponentID, last.ID)
+		assert.Equal(t, FailedStatus, last.Status)
+		assert.Equal(t, fmt.Sprintf("%d of 200 components truncated", 200-len(components)+1), last.Message)
+		assert.Equal(t, FailedStatus, componentsDigest(components))
+
+		b, err := json.Marshal(components)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(b), maxComponentsSize)
+	})
+}
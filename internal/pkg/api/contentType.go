@@ -0,0 +1,65 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/rs/zerolog"
+)
+
+var ErrUnsupportedMediaType = errors.New("unsupported content type")
+
+const jsonContentType = "application/json"
+
+// jsonOperations are the operations with a JSON request body.
+// The chunks of the uploads are sent as binary data, the other operations have no request body.
+var jsonOperations = map[string]bool{
+	"enroll":         true,
+	"acks":           true,
+	"checkin":        true,
+	"agentTags":      true,
+	"audit-unenroll": true,
+	"createActions":  true,
+	"reassignAgents": true,
+	"uploadBegin":    true,
+	"uploadComplete": true,
+}
+
+// contentType checks the content type of the requests with a JSON body.
+type contentType struct {
+	// strict rejects the requests that are not sent as application/json,
+	// otherwise they are only logged as older agents may not set the header.
+	strict bool
+}
+
+func newContentType(strict bool) *contentType {
+	return &contentType{strict: strict}
+}
+
+func (c *contentType) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !jsonOperations[pathToOperation(r.URL.Path)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		headerValue := r.Header.Get("Content-Type")
+		if mediaType, _, err := mime.ParseMediaType(headerValue); err == nil && mediaType == jsonContentType {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if c.strict {
+			ErrorResp(w, r, fmt.Errorf("received %q, expected %s: %w", headerValue, jsonContentType, ErrUnsupportedMediaType))
+			return
+		}
+		zerolog.Ctx(r.Context()).Debug().Str("content_type", headerValue).Msg("Request body is not sent as application/json")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,165 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+var (
+	// ErrTrailingData is returned when a request body has data after its JSON object.
+	ErrTrailingData = errors.New("unexpected data after the JSON object")
+	// ErrJSONTooDeep is returned when the objects and arrays of a request body are nested deeper than maxJSONDepth.
+	ErrJSONTooDeep = errors.New("JSON nested too deep")
+	// ErrBodyTooLarge is returned when a request body is larger than maxDecodeSize.
+	ErrBodyTooLarge = errors.New("request body too large")
+)
+
+const (
+	// maxPooledBuffer is the capacity above which a buffer is not put back in the pool, so a few large bodies do not retain memory.
+	maxPooledBuffer = 4 << 20
+	// maxJSONDepth is the maximum nesting of the objects and arrays of a request body.
+	maxJSONDepth = 64
+	// maxDecodeSize bounds the size of the decoded bodies of the endpoints without a max body size.
+	maxDecodeSize = 64 << 20
+)
+
+// bufPool is a bytes.Buffer pool shared by the handlers for the request and response bodies.
+var bufPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf, _ := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	bufPool.Put(buf)
+}
+
+// streamDecoder is implemented by the requests that decode their JSON object from the tokens of dec,
+// so the decoder does not buffer the whole object of large requests.
+type streamDecoder interface {
+	decodeStream(dec *json.Decoder) error
+}
+
+// decodeBody decodes the JSON object of body into v while it is read, the body is not held in memory.
+// The callers are expected to limit the size of body, bodies larger than maxDecodeSize or nested deeper than maxJSONDepth
+// are rejected as they are read. Any data other than whitespace after the object is rejected with ErrTrailingData.
+// The raw body is logged when trace logging is enabled.
+func decodeBody(ctx context.Context, body io.Reader, v any) error {
+	body = &jsonLimitReader{r: body, maxSize: maxDecodeSize, maxDepth: maxJSONDepth}
+	if zlog := zerolog.Ctx(ctx); zlog.Trace().Enabled() {
+		buf := getBuffer()
+		defer putBuffer(buf)
+		body = io.TeeReader(body, buf)
+		defer func() {
+			zlog.Trace().Bytes("body", buf.Bytes()).Msg("request body")
+		}()
+	}
+
+	dec := json.NewDecoder(body)
+	var err error
+	if sd, ok := v.(streamDecoder); ok {
+		err = sd.decodeStream(dec)
+	} else {
+		err = dec.Decode(v)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = dec.Token()
+	switch {
+	case errors.Is(err, io.EOF):
+		return nil
+	case err == nil:
+		return ErrTrailingData
+	default:
+		return fmt.Errorf("%w: %w", ErrTrailingData, err)
+	}
+}
+
+// expectDelim reads the next token of dec and returns an error if it is not delim.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return fmt.Errorf("expected %v at offset %d, got %v", delim, dec.InputOffset(), t)
+	}
+	return nil
+}
+
+// jsonLimitReader fails the reads once more than maxSize bytes are read or the JSON read so far nests its objects
+// and arrays deeper than maxDepth. It only follows the strings and the delimiters, the syntax is checked by the decoder.
+// The error is returned again by the reads that follow, the decoder may read again after a failed read.
+type jsonLimitReader struct {
+	r        io.Reader
+	maxSize  int64
+	maxDepth int
+
+	size     int64
+	depth    int
+	inString bool
+	escaped  bool
+	err      error
+}
+
+func (l *jsonLimitReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	// one more byte than allowed is read to tell a body of exactly maxSize bytes from a larger one
+	if left := l.maxSize - l.size + 1; int64(len(p)) > left {
+		p = p[:left]
+	}
+	n, err := l.r.Read(p)
+	if l.size+int64(n) > l.maxSize {
+		n = int(l.maxSize - l.size)
+		l.err = fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, l.maxSize)
+		err = l.err
+	}
+	l.size += int64(n)
+	for i, c := range p[:n] {
+		switch {
+		case l.escaped:
+			l.escaped = false
+		case l.inString:
+			switch c {
+			case '\\':
+				l.escaped = true
+			case '"':
+				l.inString = false
+			}
+		case c == '"':
+			l.inString = true
+		case c == '{' || c == '[':
+			l.depth++
+			if l.depth > l.maxDepth {
+				l.err = fmt.Errorf("%w: more than %d levels", ErrJSONTooDeep, l.maxDepth)
+				return i, l.err
+			}
+		case c == '}' || c == ']':
+			l.depth--
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,210 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		err  error
+	}{{
+		name: "object",
+		body: `{"tags":["a","b"]}`,
+	}, {
+		name: "trailing whitespace",
+		body: "{\"tags\":[\"a\",\"b\"]}\n\t ",
+	}, {
+		name: "trailing object",
+		body: `{"tags":["a","b"]}{"tags":["c"]}`,
+		err:  ErrTrailingData,
+	}, {
+		name: "trailing garbage",
+		body: `{"tags":["a","b"]}garbage`,
+		err:  ErrTrailingData,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var req AgentTagsRequest
+			err := decodeBody(context.Background(), strings.NewReader(tc.body), &req)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, []string{"a", "b"}, req.Tags)
+		})
+	}
+
+	t.Run("invalid object", func(t *testing.T) {
+		var req AgentTagsRequest
+		err := decodeBody(context.Background(), strings.NewReader(`{"tags":`), &req)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("body logged at trace level", func(t *testing.T) {
+		var out bytes.Buffer
+		ctx := zerolog.New(&out).Level(zerolog.TraceLevel).WithContext(context.Background())
+		var req AgentTagsRequest
+		require.NoError(t, decodeBody(ctx, strings.NewReader(`{"tags":["a"]}`), &req))
+		require.Contains(t, out.String(), `"body":"{\"tags\":[\"a\"]}"`)
+
+		out.Reset()
+		ctx = zerolog.New(&out).Level(zerolog.DebugLevel).WithContext(context.Background())
+		require.NoError(t, decodeBody(ctx, strings.NewReader(`{"tags":["a"]}`), &req))
+		require.Empty(t, out.String())
+	})
+}
+
+func TestDecodeBodyLimits(t *testing.T) {
+	nested := func(depth int) string {
+		return `{"tags":["a","b"],"other":` + strings.Repeat("[", depth-1) + strings.Repeat("]", depth-1) + `}`
+	}
+
+	var req AgentTagsRequest
+	require.NoError(t, decodeBody(context.Background(), strings.NewReader(nested(maxJSONDepth)), &req))
+	require.Equal(t, []string{"a", "b"}, req.Tags)
+
+	err := decodeBody(context.Background(), strings.NewReader(nested(maxJSONDepth+1)), &req)
+	require.ErrorIs(t, err, ErrJSONTooDeep)
+
+	t.Run("delimiters in strings", func(t *testing.T) {
+		tag := strings.Repeat(`[{\"`, maxJSONDepth+1)
+		var req AgentTagsRequest
+		require.NoError(t, decodeBody(context.Background(), strings.NewReader(`{"tags":["`+tag+`"]}`), &req))
+		require.Equal(t, []string{strings.Repeat(`[{"`, maxJSONDepth+1)}, req.Tags)
+	})
+
+	t.Run("too deep for the decoder", func(t *testing.T) {
+		var req AckRequest
+		err := decodeBody(context.Background(), strings.NewReader(`{"events":[{"data":`+strings.Repeat("[", 100000)), &req)
+		require.ErrorIs(t, err, ErrJSONTooDeep)
+	})
+
+	t.Run("size", func(t *testing.T) {
+		body := `{"tags":["a"]}`
+		p, err := io.ReadAll(&jsonLimitReader{r: strings.NewReader(body), maxSize: int64(len(body)), maxDepth: maxJSONDepth})
+		require.NoError(t, err)
+		require.Equal(t, body, string(p))
+
+		p, err = io.ReadAll(&jsonLimitReader{r: strings.NewReader(body), maxSize: int64(len(body) - 1), maxDepth: maxJSONDepth})
+		require.ErrorIs(t, err, ErrBodyTooLarge)
+		require.Len(t, p, len(body)-1)
+	})
+}
+
+func TestAckRequestDecodeStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		events int
+		err    string
+	}{{
+		name:   "events",
+		body:   `{"events":[{"action_id":"1","agent_id":"agent"},{"action_id":"2","agent_id":"agent"}]}`,
+		events: 2,
+	}, {
+		name: "no events",
+		body: `{"events":[]}`,
+	}, {
+		name: "null events",
+		body: `{"events":null}`,
+	}, {
+		name:   "unknown fields",
+		body:   `{"other":{"a":[1,2]},"events":[{"action_id":"1","agent_id":"agent"}],"last":1}`,
+		events: 1,
+	}, {
+		name: "events not an array",
+		body: `{"events":{}}`,
+		err:  "expected events array",
+	}, {
+		name: "not an object",
+		body: `[]`,
+		err:  "expected {",
+	}, {
+		name:   "max events",
+		body:   `{"events":[` + strings.Repeat(`{},`, maxAckEvents-1) + `{}]}`,
+		events: maxAckEvents,
+	}, {
+		name: "too many events",
+		body: `{"events":[` + strings.Repeat(`{},`, maxAckEvents) + `{}]}`,
+		err:  ErrTooManyEvents.Error(),
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var req AckRequest
+			err := decodeBody(context.Background(), strings.NewReader(tc.body), &req)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, req.Events, tc.events)
+
+			// the events are the same as the ones decoded at once
+			var expected AckRequest
+			require.NoError(t, json.Unmarshal([]byte(tc.body), &expected))
+			require.Equal(t, expected.Events, req.Events)
+		})
+	}
+}
+
+// ackBody returns an ack request body of about size bytes.
+func ackBody(b *testing.B, size int) []byte {
+	b.Helper()
+	var buf bytes.Buffer
+	buf.WriteString(`{"events":[`)
+	for i := 0; buf.Len() < size; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `{"action_id":"action-%d","agent_id":"agent-id","type":"ACTION_RESULT","subtype":"ACKNOWLEDGED","message":"Action %d acknowledged","timestamp":"2024-01-01T00:00:00Z","payload":{"retry":false}}`, i, i)
+	}
+	buf.WriteString(`]}`)
+	return buf.Bytes()
+}
+
+func BenchmarkDecodeAckBody(b *testing.B) {
+	body := ackBody(b, 5<<20)
+
+	b.Run("read all", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p, err := io.ReadAll(bytes.NewReader(body))
+			require.NoError(b, err)
+			var req AckRequest
+			require.NoError(b, json.Unmarshal(p, &req))
+		}
+	})
+	b.Run("decoder", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var req AckRequest
+			require.NoError(b, json.NewDecoder(bytes.NewReader(body)).Decode(&req))
+		}
+	})
+	b.Run("stream", func(b *testing.B) {
+		ctx := zerolog.Nop().WithContext(context.Background())
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var req AckRequest
+			require.NoError(b, decodeBody(ctx, bytes.NewReader(body), &req))
+		}
+	})
+}
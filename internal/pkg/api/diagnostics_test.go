@@ -0,0 +1,214 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/file"
+	"github.com/elastic/fleet-server/v7/internal/pkg/file/uploader"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+// TestDiagnosticsActionFlow walks a REQUEST_DIAGNOSTICS action from its creation to the result recorded on ack,
+// the agent "foo" of the uploader mock receives the action, uploads the bundle and acks the action.
+func TestDiagnosticsActionFlow(t *testing.T) {
+	zlog := testlog.SetLogger(t)
+	ctx := zlog.WithContext(context.Background())
+	cfg := &config.Server{GC: config.GC{DiagnosticsTimeout: 30 * time.Minute}}
+
+	// the operator requests diagnostics
+	var action model.Action
+	actionBulk := ftesting.NewMockBulk()
+	actionBulk.On("Create", mock.Anything, dl.FleetActions, "", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &action))
+	}).Return("", nil).Once()
+	act := NewActionsT(cfg, actionBulk, nil)
+	ids, err := act.createActions(ctx, zlog, &CreateActionsRequest{Type: string(REQUESTDIAGNOSTICS)}, "elastic", []string{"foo"})
+	require.NoError(t, err)
+	require.Equal(t, []string{action.ActionID}, ids)
+	expiration, err := time.Parse(time.RFC3339, action.Expiration)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(30*time.Minute), expiration, time.Minute)
+
+	// the agent receives the action with a pre-allocated upload
+	resp, _ := convertActions(ctx, "foo", []model.Action{action})
+	require.Len(t, resp, 1)
+	data, ok := resp[0].payload.(*ActionRequestDiagnostics)
+	require.True(t, ok)
+	require.NotNil(t, data.UploadId)
+	require.Equal(t, uploadBeginPath, *data.UploadPath)
+
+	// the agent uploads the bundle to the pre-allocated upload
+	hr, _, fakebulk, mtx := prepareUploaderMock(t)
+	mtx.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+		return sendBodyString("{}"), nil //nolint:bodyclose // nopcloser is used, linter does not see it
+	}
+	bundle := []byte("diagnostics bundle")
+	rec := httptest.NewRecorder()
+	hr.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, *data.UploadPath, strings.NewReader(`{
+		"file": {"size": `+strconv.Itoa(len(bundle))+`, "name": "diagnostics.zip", "mime_type": "application/zip"},
+		"agent_id": "foo",
+		"action_id": "`+action.ActionID+`",
+		"src": "agent"
+	}`)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var begin UploadBeginAPIResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &begin))
+	require.Equal(t, *data.UploadId, begin.UploadId)
+
+	info := file.Info{
+		ID:        begin.UploadId,
+		DocID:     action.ActionID + ".foo",
+		ChunkSize: begin.ChunkSize,
+		Total:     int64(len(bundle)),
+		Count:     1,
+		Start:     time.Now(),
+		Status:    file.StatusAwaiting,
+		Source:    "agent",
+		AgentID:   "foo",
+		ActionID:  action.ActionID,
+	}
+	h := sha256.Sum256(bundle)
+	hash := hex.EncodeToString(h[:])
+	mockUploadInfoResult(fakebulk, info)
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, *data.UploadPath+"/"+begin.UploadId+"/0", strings.NewReader(string(bundle)))
+	req.Header.Set("X-Chunk-SHA2", hash)
+	hr.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	transitHash := mockUploadedFile(fakebulk, info, []file.ChunkInfo{{BID: info.DocID, Last: true, Size: len(bundle), SHA2: hash}})
+	rec = httptest.NewRecorder()
+	hr.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, *data.UploadPath+"/"+begin.UploadId, strings.NewReader(`{"transithash": {"sha256": "`+transitHash+`"}}`)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// the agent acks the action, the result links the uploaded file
+	source, err := json.Marshal(action)
+	require.NoError(t, err)
+	var result model.ActionResult
+	ackBulk := ftesting.NewMockBulk()
+	ackBulk.On("Search", mock.Anything, dl.FleetActions, mock.MatchedBy(matchAction(t, action.ActionID)), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{
+		Hits: []es.HitT{{Source: source}},
+	}}, nil).Once()
+	info.Status = file.StatusDone
+	mockUploadInfoResult(ackBulk, info)
+	ackBulk.On("Create", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &result))
+	}).Return("", nil).Once()
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	ack := NewAckT(cfg, ackBulk, c)
+
+	agent := &model.Agent{ESDocument: model.ESDocument{Id: "foo"}, Agent: &model.AgentMetadata{ID: "foo"}}
+	res, err := ack.handleAckEvents(ctx, agent, []AckRequest_Events_Item{{json.RawMessage(`{
+		"action_id": "` + action.ActionID + `",
+		"agent_id": "foo",
+		"message": "diagnostics uploaded",
+		"timestamp": "` + time.Now().UTC().Format(time.RFC3339) + `",
+		"data": {"upload_id": "` + begin.UploadId + `"}
+	}`)}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.Items[0].Status)
+	require.Equal(t, action.ActionID, result.ActionID)
+	require.Equal(t, "foo", result.AgentID)
+	require.Empty(t, result.Error)
+	require.JSONEq(t, `{"upload_id":"`+begin.UploadId+`","file_id":"`+info.DocID+`"}`, string(result.Data))
+	ackBulk.AssertExpectations(t)
+}
+
+func Test_Ack_linkDiagnosticsUpload(t *testing.T) {
+	info := file.Info{
+		ID:        uploader.UploadID("action-1", "agent-1"),
+		DocID:     "action-1.agent-1",
+		Source:    "agent",
+		AgentID:   "agent-1",
+		ActionID:  "action-1",
+		ChunkSize: file.MaxChunkSize,
+		Total:     100,
+		Status:    file.StatusDone,
+	}
+	tests := []struct {
+		name   string
+		data   string
+		status file.Status
+		agent  string
+		expect string
+	}{{
+		name:   "upload id sent by the agent",
+		data:   `{"upload_id":"` + info.ID + `"}`,
+		status: file.StatusDone,
+		agent:  "agent-1",
+		expect: `{"upload_id":"` + info.ID + `","file_id":"action-1.agent-1"}`,
+	}, {
+		name:   "pre-allocated upload id",
+		status: file.StatusDone,
+		agent:  "agent-1",
+		expect: `{"upload_id":"` + info.ID + `","file_id":"action-1.agent-1"}`,
+	}, {
+		name:   "upload not complete",
+		data:   `{"upload_id":"` + info.ID + `"}`,
+		status: file.StatusProgress,
+		agent:  "agent-1",
+		expect: `{"upload_id":"` + info.ID + `"}`,
+	}, {
+		name:   "upload of another agent",
+		data:   `{"upload_id":"` + info.ID + `"}`,
+		status: file.StatusDone,
+		agent:  "agent-2",
+		expect: `{"upload_id":"` + info.ID + `"}`,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bulker := ftesting.NewMockBulk()
+			upload := info
+			upload.Status = tc.status
+			upload.AgentID = tc.agent
+			mockUploadInfoResult(bulker, upload)
+			ack := NewAckT(&config.Server{}, bulker, nil)
+
+			acr := model.ActionResult{ActionID: "action-1", AgentID: "agent-1"}
+			if tc.data != "" {
+				acr.Data = json.RawMessage(tc.data)
+			}
+			ack.linkDiagnosticsUpload(testlog.SetLogger(t).WithContext(context.Background()), "agent-1", &acr)
+			if tc.expect == "" {
+				require.Empty(t, acr.Data)
+			} else {
+				require.JSONEq(t, tc.expect, string(acr.Data))
+			}
+		})
+	}
+
+	t.Run("upload not found", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+		ack := NewAckT(&config.Server{}, bulker, nil)
+
+		acr := model.ActionResult{ActionID: "action-1", AgentID: "agent-1"}
+		ack.linkDiagnosticsUpload(testlog.SetLogger(t).WithContext(context.Background()), "agent-1", &acr)
+		require.Empty(t, acr.Data)
+	})
+}
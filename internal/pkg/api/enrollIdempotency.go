@@ -0,0 +1,186 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/rollback"
+)
+
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+var (
+	ErrIdempotencyKeyConflict   = errors.New("idempotency key was used by another enroll request")
+	ErrIdempotencyKeyInProgress = errors.New("enroll request with the same idempotency key in progress")
+)
+
+// idempotentEnroll identifies an enroll request sent with an idempotency key.
+type idempotentEnroll struct {
+	// key is the hash of the idempotency key, scoped to the enrollment API key so clients can not replay the enrollments of others.
+	key string
+	// requestHash is the hash of the request, a key reused for a different request is a conflict.
+	requestHash string
+}
+
+func newIdempotentEnroll(enrollmentAPIKeyID, key string, req *EnrollRequest) (idempotentEnroll, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return idempotentEnroll{}, err
+	}
+	keyHash := sha256.Sum256([]byte(enrollmentAPIKeyID + "\x00" + key))
+	requestHash := sha256.Sum256(body)
+	return idempotentEnroll{
+		key:         hex.EncodeToString(keyHash[:]),
+		requestHash: hex.EncodeToString(requestHash[:]),
+	}, nil
+}
+
+// enroll enrolls the agent of req, or replays its enrollment if req is a retry of a request with the same idempotency key.
+//
+// The response of an enrollment with an idempotency key is cached for the retries received by this instance, and the key
+// is stored in the agent document for the retries received by other instances. The access API key of the agent can not
+// be read back from Elasticsearch, so these retries re-issue the enrollment of the same agent with a new access API key.
+func (et *EnrollerT) enroll(
+	ctx context.Context,
+	rb *rollback.Rollback,
+	zlog zerolog.Logger,
+	req *EnrollRequest,
+	enrollmentAPIKeyID string,
+	idempotencyKey string,
+	policyID string,
+	namespaces []string,
+	ver string,
+) (*EnrollResponse, error) {
+	if idempotencyKey == "" || et.idempotencyTTL <= 0 {
+		return et._enroll(ctx, rb, zlog, req, policyID, namespaces, ver)
+	}
+
+	ie, err := newIdempotentEnroll(enrollmentAPIKeyID, idempotencyKey, req)
+	if err != nil {
+		return nil, err
+	}
+	// concurrent retries would both miss the response of the enrollment in progress
+	if _, inProgress := et.enrolling.LoadOrStore(ie.key, struct{}{}); inProgress {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	defer et.enrolling.Delete(ie.key)
+
+	if replay, ok := et.cache.GetEnrollReplay(ie.key); ok {
+		if replay.RequestHash != ie.requestHash {
+			return nil, ErrIdempotencyKeyConflict
+		}
+		var resp EnrollResponse
+		if err := json.Unmarshal(replay.Response, &resp); err != nil {
+			return nil, err
+		}
+		zlog.Info().Str(LogAgentID, resp.Item.Id).Msg("Replaying the enrollment of a request with the same idempotency key")
+		return &resp, nil
+	}
+
+	now := time.Now()
+	var resp *EnrollResponse
+	agent, err := dl.FindAgentByIdempotencyKey(ctx, et.bulker, ie.key, now)
+	switch {
+	case err == nil:
+		if agent.EnrollIdempotency == nil || agent.EnrollIdempotency.RequestHash != ie.requestHash {
+			return nil, ErrIdempotencyKeyConflict
+		}
+		zlog.Info().Str(LogAgentID, agent.Id).Msg("Re-issuing the enrollment of a request with the same idempotency key")
+		resp, err = et.reissueEnroll(ctx, rb, zlog, agent)
+	case errors.Is(err, dl.ErrNotFound):
+		resp, err = et._enroll(ctx, rb, zlog, req, policyID, namespaces, ver)
+		if err == nil {
+			// the agent is deleted by the rollback if the key can not be stored, a retry enrolls it again
+			// the retries are looked up with a search, possibly on another instance: the key is written with a refresh
+			var written bulk.DocVersion
+			written, err = updateFleetAgent(ctx, et.bulker, resp.Item.Id, bulk.UpdateFields{
+				dl.FieldEnrollIdempotency: model.EnrollIdempotency{
+					Key:         ie.key,
+					RequestHash: ie.requestHash,
+					ExpiresAt:   ftime.Format(now.Add(et.idempotencyTTL)),
+				},
+			}, bulk.WithRefresh())
+			if err == nil {
+				setAgentVersion(et.cache, resp.Item.Id, resp.Item.AccessApiKeyId, written)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	et.cache.SetEnrollReplay(ie.key, cache.EnrollReplay{RequestHash: ie.requestHash, Response: body})
+	return resp, nil
+}
+
+// reissueEnroll replaces the access API key of an agent enrolled by a previous request with the same idempotency key.
+func (et *EnrollerT) reissueEnroll(ctx context.Context, rb *rollback.Rollback, zlog zerolog.Logger, agent model.Agent) (*EnrollResponse, error) {
+	if agent.AccessAPIKeyID != "" {
+		if err := invalidateAPIKey(ctx, zlog, et.bulker, agent.AccessAPIKeyID); err != nil {
+			return nil, err
+		}
+	}
+
+	accessAPIKey, err := generateAccessAPIKey(ctx, et.bulker, agent.Id)
+	if err != nil {
+		return nil, err
+	}
+	rb.Register("invalidate API key", func(ctx context.Context) error {
+		return invalidateAPIKey(ctx, zlog, et.bulker, accessAPIKey.ID)
+	})
+
+	agent.AccessAPIKeyID = accessAPIKey.ID
+	written, err := updateFleetAgent(ctx, et.bulker, agent.Id, bulk.UpdateFields{
+		dl.FieldAccessAPIKeyID: accessAPIKey.ID,
+		dl.FieldUpdatedAt:      ftime.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	setAgentVersion(et.cache, agent.Id, accessAPIKey.ID, written)
+
+	et.cache.SetAPIKey(*accessAPIKey, true)
+	return newEnrollResponse(agent.Id, agent, accessAPIKey), nil
+}
+
+// newEnrollResponse returns the response of the enrollment of agent with its access API key.
+func newEnrollResponse(agentID string, agent model.Agent, accessAPIKey *apikey.APIKey) *EnrollResponse {
+	return &EnrollResponse{
+		Action: "created",
+		Item: EnrollResponseItem{
+			AccessApiKey:   accessAPIKey.Token(),
+			AccessApiKeyId: agent.AccessAPIKeyID,
+			// the list of actions is required by the spec, it is always empty
+			Actions:              []map[string]interface{}{},
+			Active:               agent.Active,
+			EnrolledAt:           agent.EnrolledAt,
+			Id:                   agentID,
+			LocalMetadata:        agent.LocalMetadata,
+			PolicyId:             agent.PolicyID,
+			Status:               "online",
+			Tags:                 agent.Tags,
+			Type:                 agent.Type,
+			UserProvidedMetadata: agent.UserProvidedMetadata,
+		},
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/rollback"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testcache "github.com/elastic/fleet-server/v7/internal/pkg/testing/cache"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+const idempotencyTestTTL = 10 * time.Minute
+
+func idempotencyTestRequest(tags ...string) *EnrollRequest {
+	return &EnrollRequest{
+		Type: PERMANENT,
+		Metadata: EnrollMetadata{
+			UserProvided: []byte("{}"),
+			Local:        []byte("{}"),
+			Tags:         tags,
+		},
+	}
+}
+
+// replayCache is a cache.Cache that stores the enroll replays.
+type replayCache struct {
+	cache.Cache
+	replays map[string]cache.EnrollReplay
+}
+
+func idempotencyTestCache() *replayCache {
+	return &replayCache{replays: map[string]cache.EnrollReplay{}}
+}
+
+func (c *replayCache) SetAPIKey(cache.APIKey, bool) {}
+
+func (c *replayCache) InvalidateNotFound(cache.DocKind, string) {}
+
+func (c *replayCache) SetAgentVersion(cache.AgentVersion) {}
+
+func (c *replayCache) GetAgentVersion(string) (cache.AgentVersion, bool) {
+	return cache.AgentVersion{}, false
+}
+
+func (c *replayCache) SetEnrollReplay(key string, replay cache.EnrollReplay) {
+	c.replays[key] = replay
+}
+
+func (c *replayCache) GetEnrollReplay(key string) (cache.EnrollReplay, bool) {
+	replay, ok := c.replays[key]
+	return replay, ok
+}
+
+func idempotencyTestEnroller(bulker *ftesting.MockBulk, c cache.Cache) *EnrollerT {
+	et, _ := NewEnrollerT(nil, &config.Server{}, bulker, c, WithEnrollIdempotencyTTL(idempotencyTestTTL))
+	return et
+}
+
+func idempotentEnrollCall(t *testing.T, et *EnrollerT, req *EnrollRequest, key string) (*EnrollResponse, error) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	return et.enroll(ctx, &rollback.Rollback{}, testlog.SetLogger(t), req, "enrollment-key", key, "policy", nil, "8.9.0")
+}
+
+func mockNewEnrollment(bulker *ftesting.MockBulk, keyID string) {
+	bulker.On("APIKeyCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&apikey.APIKey{ID: keyID, Key: "secret"}, nil).Once()
+	bulker.On("Create", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything, mock.Anything).Return("", nil).Once()
+}
+
+func noAgentHits(bulker *ftesting.MockBulk) {
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+}
+
+func TestEnrollIdempotencyReplay(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	noAgentHits(bulker)
+	mockNewEnrollment(bulker, "key-1")
+	var stored model.EnrollIdempotency
+	bulker.On("Update", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var doc map[string]map[string]model.EnrollIdempotency
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &doc))
+		stored = doc["doc"][dl.FieldEnrollIdempotency]
+	}).Return(nil).Once()
+	et := idempotencyTestEnroller(bulker, idempotencyTestCache())
+
+	start := time.Now().Truncate(time.Millisecond)
+	resp, err := idempotentEnrollCall(t, et, idempotencyTestRequest(), "retry-key")
+	require.NoError(t, err)
+	require.Equal(t, "key-1", resp.Item.AccessApiKeyId)
+
+	// the key and the request are stored hashed with the expiration of the key
+	require.NotEmpty(t, stored.Key)
+	require.NotContains(t, stored.Key, "retry-key")
+	require.NotEmpty(t, stored.RequestHash)
+	expiresAt, err := time.Parse(time.RFC3339Nano, stored.ExpiresAt)
+	require.NoError(t, err)
+	require.WithinRange(t, expiresAt, start.Add(idempotencyTestTTL), time.Now().Add(idempotencyTestTTL))
+
+	// the retry replays the response without enrolling again
+	replayed, err := idempotentEnrollCall(t, et, idempotencyTestRequest(), "retry-key")
+	require.NoError(t, err)
+	want, err := json.Marshal(resp)
+	require.NoError(t, err)
+	got, err := json.Marshal(replayed)
+	require.NoError(t, err)
+	require.JSONEq(t, string(want), string(got))
+	bulker.AssertNumberOfCalls(t, "APIKeyCreate", 1)
+	bulker.AssertNumberOfCalls(t, "Create", 1)
+	bulker.AssertNumberOfCalls(t, "Search", 1)
+}
+
+func TestEnrollIdempotencyConflict(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	noAgentHits(bulker)
+	mockNewEnrollment(bulker, "key-1")
+	bulker.On("Update", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+	et := idempotencyTestEnroller(bulker, idempotencyTestCache())
+
+	_, err := idempotentEnrollCall(t, et, idempotencyTestRequest(), "retry-key")
+	require.NoError(t, err)
+
+	// the same key with a different body is rejected
+	_, err = idempotentEnrollCall(t, et, idempotencyTestRequest("other"), "retry-key")
+	require.ErrorIs(t, err, ErrIdempotencyKeyConflict)
+	require.Equal(t, 409, NewHTTPErrResp(err).StatusCode)
+
+	// another key enrolls another agent
+	mockNewEnrollment(bulker, "key-2")
+	bulker.On("Update", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+	resp, err := idempotentEnrollCall(t, et, idempotencyTestRequest("other"), "other-key")
+	require.NoError(t, err)
+	require.Equal(t, "key-2", resp.Item.AccessApiKeyId)
+}
+
+func TestEnrollIdempotencyOtherInstance(t *testing.T) {
+	req := idempotencyTestRequest()
+	ie, err := newIdempotentEnroll("enrollment-key", "retry-key", req)
+	require.NoError(t, err)
+	agentHit := func(requestHash string) *es.ResultT {
+		body, err := json.Marshal(model.Agent{
+			Active:         true,
+			PolicyID:       "policy",
+			AccessAPIKeyID: "old-key",
+			EnrolledAt:     "2025-01-01T00:00:00Z",
+			EnrollIdempotency: &model.EnrollIdempotency{
+				Key:         ie.key,
+				RequestHash: requestHash,
+				ExpiresAt:   time.Now().Add(time.Minute).UTC().Format(time.RFC3339Nano),
+			},
+		})
+		require.NoError(t, err)
+		return &es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "agent-1", Source: body}}}}
+	}
+
+	t.Run("re-issue", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(agentHit(ie.requestHash), nil)
+		bulker.On("APIKeyRead", mock.Anything, "old-key").Return(&apikey.APIKeyMetadata{ID: "old-key"}, nil)
+		bulker.On("APIKeyInvalidate", mock.Anything, []string{"old-key"}).Return(nil)
+		bulker.On("APIKeyCreate", mock.Anything, "agent-1", mock.Anything, mock.Anything, mock.Anything).Return(&apikey.APIKey{ID: "new-key", Key: "secret"}, nil)
+		bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything, mock.Anything).Return(nil)
+		c := idempotencyTestCache()
+		et := idempotencyTestEnroller(bulker, c)
+
+		resp, err := idempotentEnrollCall(t, et, req, "retry-key")
+		require.NoError(t, err)
+		require.Equal(t, "agent-1", resp.Item.Id)
+		require.Equal(t, "new-key", resp.Item.AccessApiKeyId)
+		require.Equal(t, "2025-01-01T00:00:00Z", resp.Item.EnrolledAt)
+		bulker.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		require.Contains(t, c.replays, ie.key)
+
+		// the search only matches the key if it did not expire
+		body := string(bulker.Calls[0].Arguments.Get(2).([]byte))
+		require.Contains(t, body, dl.FieldEnrollIdempotencyKey)
+		require.Contains(t, body, dl.FieldEnrollIdempotencyExpiresAt)
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(agentHit("other-request"), nil)
+		et := idempotencyTestEnroller(bulker, idempotencyTestCache())
+
+		_, err := idempotentEnrollCall(t, et, req, "retry-key")
+		require.ErrorIs(t, err, ErrIdempotencyKeyConflict)
+		bulker.AssertNotCalled(t, "APIKeyCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestEnrollIdempotencyExpired(t *testing.T) {
+	// the key of the first enrollment expired: it is neither cached nor found in Elasticsearch
+	bulker := ftesting.NewMockBulk()
+	noAgentHits(bulker)
+	mockNewEnrollment(bulker, "key-1")
+	mockNewEnrollment(bulker, "key-2")
+	bulker.On("Update", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	c := testcache.NewMockCache()
+	c.On("SetAPIKey", mock.Anything, true).Return()
+	c.On("InvalidateNotFound", mock.Anything, mock.Anything).Return()
+	c.On("SetAgentVersion", mock.Anything).Return()
+	c.On("SetEnrollReplay", mock.Anything, mock.Anything).Return()
+	c.On("GetEnrollReplay", mock.Anything).Return(cache.EnrollReplay{}, false)
+	et := idempotencyTestEnroller(bulker, c)
+
+	first, err := idempotentEnrollCall(t, et, idempotencyTestRequest(), "retry-key")
+	require.NoError(t, err)
+	second, err := idempotentEnrollCall(t, et, idempotencyTestRequest(), "retry-key")
+	require.NoError(t, err)
+	require.NotEqual(t, first.Item.Id, second.Item.Id)
+	require.Equal(t, "key-2", second.Item.AccessApiKeyId)
+}
+
+func TestEnrollIdempotencyInProgress(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	et := idempotencyTestEnroller(bulker, idempotencyTestCache())
+	ie, err := newIdempotentEnroll("enrollment-key", "retry-key", idempotencyTestRequest())
+	require.NoError(t, err)
+	et.enrolling.Store(ie.key, struct{}{})
+
+	_, err = idempotentEnrollCall(t, et, idempotencyTestRequest(), "retry-key")
+	require.ErrorIs(t, err, ErrIdempotencyKeyInProgress)
+	bulker.AssertNotCalled(t, "Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestEnrollIdempotencyScoped(t *testing.T) {
+	req := idempotencyTestRequest()
+	a, err := newIdempotentEnroll("enrollment-key", "retry-key", req)
+	require.NoError(t, err)
+	b, err := newIdempotentEnroll("other-enrollment-key", "retry-key", req)
+	require.NoError(t, err)
+	require.NotEqual(t, a.key, b.key, "the same key sent with different enrollment keys must not match")
+	require.Equal(t, a.requestHash, b.requestHash)
+}
+
+func TestEnrollWithoutIdempotencyKey(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	mockNewEnrollment(bulker, "key-1")
+	mockNewEnrollment(bulker, "key-2")
+	c := testcache.NewMockCache()
+	c.On("SetAPIKey", mock.Anything, true).Return()
+	c.On("InvalidateNotFound", mock.Anything, mock.Anything).Return()
+	c.On("SetAgentVersion", mock.Anything).Return()
+	et := idempotencyTestEnroller(bulker, c)
+
+	first, err := idempotentEnrollCall(t, et, idempotencyTestRequest(), "")
+	require.NoError(t, err)
+	second, err := idempotentEnrollCall(t, et, idempotencyTestRequest(), "")
+	require.NoError(t, err)
+	require.NotEqual(t, first.Item.Id, second.Item.Id)
+	bulker.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	c.AssertNotCalled(t, "GetEnrollReplay", mock.Anything)
+}
@@ -24,6 +24,8 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/file/uploader"
 	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/operation"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
@@ -100,6 +102,24 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 				zerolog.InfoLevel,
 			},
 		},
+		{
+			ErrBudgetExceeded,
+			HTTPErrResp{
+				http.StatusServiceUnavailable,
+				"OperationBudgetExceeded",
+				"",
+				zerolog.WarnLevel,
+			},
+		},
+		{
+			ErrServerDraining,
+			HTTPErrResp{
+				http.StatusServiceUnavailable,
+				"ServerDraining",
+				"fleet server is draining, retry with another instance",
+				zerolog.InfoLevel,
+			},
+		},
 		{
 			ErrAgentNotReplaceable,
 			HTTPErrResp{
@@ -109,6 +129,24 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 				zerolog.WarnLevel,
 			},
 		},
+		{
+			ErrIdempotencyKeyConflict,
+			HTTPErrResp{
+				http.StatusConflict,
+				"IdempotencyKeyConflict",
+				"idempotency key was used by another enroll request",
+				zerolog.InfoLevel,
+			},
+		},
+		{
+			ErrIdempotencyKeyInProgress,
+			HTTPErrResp{
+				http.StatusConflict,
+				"IdempotencyKeyInProgress",
+				"enroll request with the same idempotency key in progress",
+				zerolog.InfoLevel,
+			},
+		},
 		{
 			ErrInvalidUserAgent,
 			HTTPErrResp{
@@ -127,6 +165,15 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 				zerolog.InfoLevel,
 			},
 		},
+		{
+			ErrAgentVersionNotSupported,
+			HTTPErrResp{
+				http.StatusBadRequest,
+				"AgentVersionNotSupported",
+				"",
+				zerolog.InfoLevel,
+			},
+		},
 		{
 			dl.ErrNotFound,
 			HTTPErrResp{
@@ -145,6 +192,33 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 				zerolog.DebugLevel,
 			},
 		},
+		{
+			ErrorBadSha2,
+			HTTPErrResp{
+				http.StatusBadRequest,
+				"BadSha2",
+				"malformed sha256",
+				zerolog.InfoLevel,
+			},
+		},
+		{
+			ErrorMismatchSha2,
+			HTTPErrResp{
+				http.StatusInternalServerError,
+				"ArtifactMismatch",
+				"artifact does not match its sha256",
+				zerolog.ErrorLevel,
+			},
+		},
+		{
+			ErrorCompression,
+			HTTPErrResp{
+				http.StatusInternalServerError,
+				"ArtifactCompression",
+				"",
+				zerolog.ErrorLevel,
+			},
+		},
 		{
 			limit.ErrRateLimit,
 			HTTPErrResp{
@@ -217,6 +291,15 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 				zerolog.InfoLevel,
 			},
 		},
+		{
+			model.ErrInvalidTransition,
+			HTTPErrResp{
+				http.StatusConflict,
+				"InvalidAgentState",
+				"agent state does not allow the request",
+				zerolog.WarnLevel,
+			},
+		},
 		{
 			ErrAgentInactive,
 			HTTPErrResp{
@@ -336,6 +419,24 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 				zerolog.InfoLevel,
 			},
 		},
+		{
+			uploader.ErrTooManyUploads,
+			HTTPErrResp{
+				http.StatusTooManyRequests,
+				"ErrTooManyUploads",
+				"the agent has too many active uploads",
+				zerolog.InfoLevel,
+			},
+		},
+		{
+			uploader.ErrAgentSizeLimit,
+			HTTPErrResp{
+				http.StatusBadRequest,
+				"ErrAgentSizeLimit",
+				"the active uploads of the agent exceed the maximum allowed total size",
+				zerolog.InfoLevel,
+			},
+		},
 		{
 			uploader.ErrMissingChunks,
 			HTTPErrResp{
@@ -482,6 +583,82 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 				zerolog.InfoLevel,
 			},
 		},
+		// create actions
+		{
+			ErrAdminAuth,
+			HTTPErrResp{
+				http.StatusForbidden,
+				"Forbidden",
+				"credentials are not allowed to manage agents",
+				zerolog.WarnLevel,
+			},
+		},
+		{
+			ErrServiceTokenAuth,
+			HTTPErrResp{
+				http.StatusForbidden,
+				"Forbidden",
+				"a service token is required",
+				zerolog.WarnLevel,
+			},
+		},
+		{
+			ErrActionTargets,
+			HTTPErrResp{
+				http.StatusBadRequest,
+				"ErrActionTargets",
+				"",
+				zerolog.InfoLevel,
+			},
+		},
+		{
+			ErrActionNotFound,
+			HTTPErrResp{
+				http.StatusNotFound,
+				"ActionNotFound",
+				"action could not be found",
+				zerolog.WarnLevel,
+			},
+		},
+		// policy fetch
+		{
+			ErrPolicyForbidden,
+			HTTPErrResp{
+				http.StatusForbidden,
+				"PolicyForbidden",
+				"agent is not assigned to the policy",
+				zerolog.WarnLevel,
+			},
+		},
+		// policy quotas
+		{
+			ErrPolicyQuota,
+			HTTPErrResp{
+				http.StatusTooManyRequests,
+				"PolicyQuotaExceeded",
+				"",
+				zerolog.InfoLevel,
+			},
+		},
+		// agent tags
+		{
+			ErrAgentTags,
+			HTTPErrResp{
+				http.StatusBadRequest,
+				"ErrAgentTags",
+				"",
+				zerolog.InfoLevel,
+			},
+		},
+		{
+			dl.ErrAgentTagsLimit,
+			HTTPErrResp{
+				http.StatusBadRequest,
+				"ErrAgentTagsLimit",
+				"",
+				zerolog.InfoLevel,
+			},
+		},
 		// audit unenroll
 		{
 			ErrAuditUnenrollReason,
@@ -492,6 +669,145 @@ func NewHTTPErrResp(err error) HTTPErrResp {
 				zerolog.InfoLevel,
 			},
 		},
+		// reassign agents
+		{
+			ErrReassignTargets,
+			HTTPErrResp{
+				http.StatusBadRequest,
+				"ErrReassignTargets",
+				"",
+				zerolog.InfoLevel,
+			},
+		},
+		// bulk enroll
+		{
+			ErrBulkEnroll,
+			HTTPErrResp{
+				http.StatusBadRequest,
+				"ErrBulkEnroll",
+				"",
+				zerolog.InfoLevel,
+			},
+		},
+		{
+			ErrAgentExists,
+			HTTPErrResp{
+				http.StatusConflict,
+				"AgentExists",
+				"an agent with the same id is enrolled",
+				zerolog.InfoLevel,
+			},
+		},
+		// operations
+		{
+			operation.ErrNotFound,
+			HTTPErrResp{
+				http.StatusNotFound,
+				"OperationNotFound",
+				"operation could not be found",
+				zerolog.InfoLevel,
+			},
+		},
+		{
+			operation.ErrConflict,
+			HTTPErrResp{
+				http.StatusConflict,
+				"OperationConflict",
+				"operation is running or has other parameters",
+				zerolog.InfoLevel,
+			},
+		},
+		{
+			ErrFeatureDisabled,
+			HTTPErrResp{
+				http.StatusServiceUnavailable,
+				"FeatureDisabled",
+				"",
+				zerolog.WarnLevel,
+			},
+		},
+		{
+			ErrRouteNotFound,
+			HTTPErrResp{
+				http.StatusNotFound,
+				"RouteNotFound",
+				"no route matches the request path",
+				zerolog.DebugLevel,
+			},
+		},
+		{
+			ErrMethodNotAllowed,
+			HTTPErrResp{
+				http.StatusMethodNotAllowed,
+				"MethodNotAllowed",
+				"request method is not allowed on the path",
+				zerolog.DebugLevel,
+			},
+		},
+		{
+			ErrUnsupportedMediaType,
+			HTTPErrResp{
+				http.StatusUnsupportedMediaType,
+				"UnsupportedMediaType",
+				"",
+				zerolog.InfoLevel,
+			},
+		},
+		// elasticsearch, other elasticsearch errors are reported as unavailable below
+		{
+			es.ErrElasticNotFound,
+			HTTPErrResp{
+				http.StatusNotFound,
+				"NotFound",
+				"not found",
+				zerolog.WarnLevel,
+			},
+		},
+		{
+			es.ErrIndexNotFound,
+			HTTPErrResp{
+				http.StatusNotFound,
+				"IndexNotFound",
+				"index not found",
+				zerolog.WarnLevel,
+			},
+		},
+		{
+			es.ErrElasticVersionConflict,
+			HTTPErrResp{
+				http.StatusConflict,
+				"Conflict",
+				"document was updated concurrently",
+				zerolog.InfoLevel,
+			},
+		},
+		{
+			es.ErrTooManyRequests,
+			HTTPErrResp{
+				http.StatusTooManyRequests,
+				"ElasticsearchTooManyRequests",
+				"elasticsearch is overloaded",
+				zerolog.WarnLevel,
+			},
+		},
+		{
+			es.ErrTimeout,
+			HTTPErrResp{
+				http.StatusServiceUnavailable,
+				"ElasticsearchTimeout",
+				"elasticsearch timed out",
+				zerolog.WarnLevel,
+			},
+		},
+		{
+			es.ErrUnavailable,
+			HTTPErrResp{
+				http.StatusServiceUnavailable,
+				"ElasticsearchUnavailable",
+				"elasticsearch is unavailable",
+				zerolog.WarnLevel,
+			},
+		},
 	}
 
 	for _, e := range errTable {
@@ -581,6 +897,7 @@ func ErrorResp(w http.ResponseWriter, r *http.Request, err error) {
 		e = e.Int64(ECSEventDuration, time.Since(ts).Nanoseconds())
 	}
 	e.Msg("HTTP request error")
+	auditError(r.Context(), err)
 
 	if resp.StatusCode >= 500 {
 		if trans := apm.TransactionFromContext(r.Context()); trans != nil {
@@ -11,6 +11,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 	"github.com/stretchr/testify/require"
@@ -112,6 +113,30 @@ func Test_ErrResp_Status(t *testing.T) {
 			Status: 500,
 		},
 		status: 503,
+	}, {
+		name:   "es not found",
+		err:    fmt.Errorf("wrapped error: %w", &es.ErrElastic{Status: 404, Type: "document_missing_exception"}),
+		status: 404,
+	}, {
+		name:   "es version conflict",
+		err:    &es.ErrElastic{Status: 409, Type: "version_conflict_engine_exception"},
+		status: 409,
+	}, {
+		name:   "es rejected execution",
+		err:    &es.ErrElastic{Status: 429, Type: "es_rejected_execution_exception"},
+		status: 429,
+	}, {
+		name:   "es timeout",
+		err:    &es.ErrElastic{Status: 504, Type: "timeout_exception"},
+		status: 503,
+	}, {
+		name:   "es security exception",
+		err:    &es.ErrElastic{Status: 403, Type: "security_exception"},
+		status: 503,
+	}, {
+		name:   "dl not found",
+		err:    dl.ErrNotFound,
+		status: 404,
 	}, {
 		name: "decode req error",
 		err: &BadRequestErr{
@@ -0,0 +1,62 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+const weakPrefix = "W/"
+
+// strongETag returns the strong entity tag of the opaque value, the value must not contain a double quote.
+func strongETag(v string) string {
+	return `"` + v + `"`
+}
+
+// weakETag returns the weak entity tag of the opaque value, the value must not contain a double quote.
+func weakETag(v string) string {
+	return weakPrefix + strongETag(v)
+}
+
+// opaqueTag returns the quoted opaque value of the entity tag, or false if the tag is malformed.
+func opaqueTag(tag string) (string, bool) {
+	tag = strings.TrimPrefix(tag, weakPrefix)
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' || strings.Contains(tag[1:len(tag)-1], `"`) {
+		return "", false
+	}
+	return tag, true
+}
+
+// etagMatch reports whether the If-None-Match header matches the entity tag using the weak comparison of
+// RFC 9110 section 13.1.2. A malformed header matches no tag, the response is sent in full.
+func etagMatch(header, etag string) bool {
+	header = strings.TrimSpace(header)
+	if header == "" || etag == "" {
+		return false
+	}
+	if header == "*" {
+		return true
+	}
+	want, ok := opaqueTag(etag)
+	if !ok {
+		return false
+	}
+	match := false
+	for _, tag := range strings.Split(header, ",") {
+		v, ok := opaqueTag(strings.TrimSpace(tag))
+		if !ok {
+			return false
+		}
+		match = match || v == want
+	}
+	return match
+}
+
+// writeNotModified answers a request with the entity tag of the response it already holds.
+func writeNotModified(w http.ResponseWriter, etag string) {
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,41 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestETagMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		etag   string
+		match  bool
+	}{
+		{name: "empty header", header: "", etag: `"abc"`, match: false},
+		{name: "no tag", header: `"abc"`, etag: "", match: false},
+		{name: "strong", header: `"abc"`, etag: `"abc"`, match: true},
+		{name: "weak header", header: `W/"abc"`, etag: `"abc"`, match: true},
+		{name: "weak tag", header: `"abc"`, etag: `W/"abc"`, match: true},
+		{name: "list", header: `"xyz", W/"abc"`, etag: `"abc"`, match: true},
+		{name: "any", header: " * ", etag: `"abc"`, match: true},
+		{name: "different", header: `"xyz"`, etag: `"abc"`, match: false},
+		{name: "unquoted", header: "abc", etag: `"abc"`, match: false},
+		{name: "unterminated", header: `"abc`, etag: `"abc"`, match: false},
+		{name: "inner quote", header: `"a"bc"`, etag: `"a"bc"`, match: false},
+		{name: "malformed member", header: `"abc", xyz`, etag: `"abc"`, match: false},
+		{name: "empty member", header: `"abc",`, etag: `"abc"`, match: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.match, etagMatch(tc.header, tc.etag))
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+// The fuzz targets decode and validate the bodies of the agent-facing requests, then run the parsing the handlers do
+// on the decoded requests. Only their seeds run with go test, go test -fuzz=<target> explores further.
+
+// addAdversarialSeeds adds the bodies shared by the fuzz targets.
+func addAdversarialSeeds(f *testing.F) {
+	f.Add([]byte(``))
+	f.Add([]byte(`null`))
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"a":` + strings.Repeat("[", maxJSONDepth+1) + strings.Repeat("]", maxJSONDepth+1) + `}`))
+	f.Add([]byte(`{"message":"` + strings.Repeat("\\u0000", 1024) + `"}`))
+	f.Add([]byte(`{"message":"\"{[[[[\\"}`))
+	f.Add([]byte(`{"events":[` + strings.Repeat(`{},`, 64) + `{}]}`))
+}
+
+func FuzzDecodeAckRequest(f *testing.F) {
+	addAdversarialSeeds(f)
+	f.Add([]byte(`{"events":[{"action_id":"a-1","agent_id":"agent-1","message":"done","subtype":"ACKNOWLEDGED","timestamp":"2025-01-02T03:04:05Z","type":"ACTION_RESULT","error":"failed","error_code":"E1"}]}`))
+	f.Add([]byte(`{"events":[{"action_id":"a-1","agent_id":"agent-1","action_input_type":"osquery","action_data":{"q":1},"action_response":{"r":[1,2]},"started_at":"2025-01-02T03:04:05Z","completed_at":"x"}]}`))
+	f.Add([]byte(`{"events":[{"action_id":"a-1","payload":{"retry":true,"retry_attempt":-1},"data":{"upload_id":7}}]}`))
+	f.Add([]byte(`{"events":{"action_id":"a-1"}}`))
+	f.Add([]byte(`{"events":[1,"two",null,[]]}`))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		var req AckRequest
+		if err := decodeRequest(context.Background(), bytes.NewReader(body), &req, "ack"); err != nil {
+			return
+		}
+		require.LessOrEqual(t, len(req.Events), maxAckEvents)
+		for _, ev := range req.Events {
+			for _, aType := range []string{string(UPGRADE), string(UNENROLL), string(REQUESTDIAGNOSTICS), string(INPUTACTION), string(SETTINGS)} {
+				acr := eventToActionResult("agent-1", aType, nil, ev)
+				require.LessOrEqual(t, len(acr.Error), maxActionErrorLen)
+				require.LessOrEqual(t, len(acr.ErrorCode), maxActionErrorCodeLen)
+			}
+			_, _ = ev.AsUpgradeEvent()
+		}
+	})
+}
+
+func FuzzDecodeCheckinRequest(f *testing.F) {
+	addAdversarialSeeds(f)
+	f.Add([]byte(`{"ack_token":"token","status":"online","message":"Running","poll_timeout":"5m","local_metadata":{"elastic":{"agent":{"id":"agent-1","version":"9.1.0"}}},"components":[{"id":"c-1","status":"FAILED","units":[{"id":"u-1","type":"input","status":"DEGRADED","message":"m"}]}],"upgrade_details":{"action_id":"a-1","state":"UPG_DOWNLOADING","target_version":"9.1.0","metadata":{"download_percent":0.5}}}`))
+	f.Add([]byte(`{"status":"degraded","message":"","poll_timeout":"-9223372036854775808ns","components":{},"local_metadata":[]}`))
+	f.Add([]byte(`{"status":"online","message":"","components":[{"units":[{}]},null],"local_metadata":{"elastic":{"agent":{"version":1}}}}`))
+	f.Add([]byte(`{"status":"error","message":"","upgrade_details":{"state":"UPG_FAILED","metadata":{"error_msg":"` + strings.Repeat("e", 4096) + `","retry_until":"x"}}}`))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		ctx := context.Background()
+		var req CheckinRequest
+		if err := decodeRequest(ctx, bytes.NewReader(body), &req, "checkin"); err != nil {
+			return
+		}
+		require.LessOrEqual(t, len(req.Message), 16*1024)
+		agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}, LocalMetadata: []byte(`{}`)}
+		if req.LocalMetadata != nil {
+			_, _ = localMetadataVersion(*req.LocalMetadata)
+			_, _ = parseMeta(ctx, agent, &req)
+		}
+		if req.Components != nil {
+			out, _, _, err := parseComponents(ctx, agent, &req)
+			if err == nil {
+				require.LessOrEqual(t, len(out), maxComponentsSize)
+			}
+		}
+		if d := req.UpgradeDetails; d != nil && d.Metadata != nil {
+			_, _ = d.Metadata.AsUpgradeMetadataFailed()
+			_, _ = d.Metadata.AsUpgradeMetadataDownloading()
+			_, _ = d.Metadata.AsUpgradeMetadataScheduled()
+		}
+	})
+}
+
+func FuzzDecodeEnrollRequest(f *testing.F) {
+	addAdversarialSeeds(f)
+	f.Add([]byte(`{"type":"PERMANENT","id":"agent-1","enrollment_id":"e-1","replace_token":"replace","shared_id":"","metadata":{"local":{"elastic":{"agent":{"id":"x","version":"9.1.0"}}},"tags":["linux"],"user_provided":{}}}`))
+	f.Add([]byte(`{"type":"EPHEMERAL","metadata":{"local":null,"tags":null,"user_provided":null}}`))
+	f.Add([]byte(`{"type":"TEMPORARY","metadata":{"local":{"elastic":{"agent":"x"}},"tags":["",""],"user_provided":[]}}`))
+	f.Add([]byte(`{"type":"PERMANENT","metadata":{"local":"text","tags":[1]}}`))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		ctx := context.Background()
+		req, err := validateRequest(ctx, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		require.LessOrEqual(t, len(req.Metadata.Tags), maxAgentTags)
+		require.LessOrEqual(t, len(fromPtr(req.Id)), 512)
+		_, _ = updateLocalMetaAgentID(req.Metadata.Local, "agent-1")
+		_, _ = localMetadataVersion(req.Metadata.Local)
+	})
+}
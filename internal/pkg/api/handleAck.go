@@ -11,6 +11,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -22,22 +23,45 @@ import (
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/checkin"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/file"
+	"github.com/elastic/fleet-server/v7/internal/pkg/file/uploader"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	"github.com/elastic/fleet-server/v7/internal/pkg/seen"
 	"github.com/elastic/fleet-server/v7/internal/pkg/smap"
+	"github.com/elastic/fleet-server/v7/internal/pkg/unenroll"
 )
 
 const (
-	TypeUnenroll = "UNENROLL"
-	TypeUpgrade  = "UPGRADE"
+	// maxAckEvents is the maximum number of events of an ack request.
+	maxAckEvents = 10000
+	// maxActionErrorLen is the maximum length of the error of an ack event stored on the action result and agent documents.
+	maxActionErrorLen = 4096
+	// maxActionErrorCodeLen is the maximum length of the error code of an ack event.
+	maxActionErrorCodeLen = 256
 )
 
 var (
 	ErrUpdatingInactiveAgent = errors.New("updating inactive agent")
+	ErrTooManyEvents         = errors.New("too many ack events")
+)
+
+const (
+	// ackPolicySuperseded is the message of the ack of a policy change action of another policy than the policy of
+	// the agent, the agent was reassigned after the action.
+	ackPolicySuperseded = "policy_superseded"
+	// ackPolicyRevisionStale is the message of the ack of an older revision of the policy of the agent than the
+	// revision already recorded.
+	ackPolicyRevisionStale = "policy_revision_stale"
+	// ackActionTypeUnsupported is the message of the ack of an action of a type fleet-server does not know about, its
+	// result is recorded without any other handling.
+	ackActionTypeUnsupported = "action_type_unsupported"
 )
 
 type HTTPError struct {
@@ -70,48 +94,104 @@ func (a *AckResponse) SetResult(pos int, status int) {
 
 func (a *AckResponse) SetError(pos int, err error) {
 	var esErr *es.ErrElastic
-	if errors.As(err, &esErr) {
+	switch {
+	case errors.As(err, &esErr):
 		a.setMessage(pos, esErr.Status, esErr.Reason)
-	} else {
+	case errors.Is(err, ErrBudgetExceeded):
+		a.SetResult(pos, http.StatusServiceUnavailable)
+	default:
 		a.SetResult(pos, http.StatusInternalServerError)
 	}
 }
 
 type AckT struct {
-	cfg   *config.Server
-	bulk  bulk.Bulk
-	cache cache.Cache
+	cfg      *config.Server
+	reloaded reloadedCfg
+	bulk     bulk.Bulk
+	cache    cache.Cache
+	seen     *seen.Tracker
+	bc       *checkin.Bulk
+	budget   budget
+	locks    *agentLocks
+}
+
+// Reload applies the reloaded server configuration to the requests handled from now on.
+func (ack *AckT) Reload(cfg *config.Server) {
+	ack.reloaded.p.Store(cfg)
+}
+
+// serverCfg returns the server configuration of the requests, as last reloaded.
+func (ack *AckT) serverCfg() *config.Server {
+	return ack.reloaded.get(ack.cfg)
+}
+
+// AckOpt is an optional setting for AckT.
+type AckOpt func(*AckT)
+
+// WithAckSeenAgents counts the agents that ack actions with t.
+func WithAckSeenAgents(t *seen.Tracker) AckOpt {
+	return func(ack *AckT) {
+		ack.seen = t
+	}
+}
+
+// WithAckCheckin records the state of the agents that ack an unenroll action in the checkin coalescer bc.
+func WithAckCheckin(bc *checkin.Bulk) AckOpt {
+	return func(ack *AckT) {
+		ack.bc = bc
+	}
 }
 
-func NewAckT(cfg *config.Server, bulker bulk.Bulk, cache cache.Cache) *AckT {
-	return &AckT{
-		cfg:   cfg,
-		bulk:  bulker,
-		cache: cache,
+func NewAckT(cfg *config.Server, bulker bulk.Bulk, cache cache.Cache, opts ...AckOpt) *AckT {
+	ack := &AckT{
+		cfg:    cfg,
+		bulk:   bulker,
+		cache:  cache,
+		budget: budget(cfg.Budgets.Ack),
+		locks:  newAgentLocks(),
 	}
+	for _, opt := range opts {
+		opt(ack)
+	}
+	return ack
 }
 
-func (ack *AckT) handleAcks(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, id string) error {
-	agent, err := authAgent(r, &id, ack.bulk, ack.cache)
+func (ack *AckT) handleAcks(w http.ResponseWriter, r *http.Request, id string) error {
+	actx, cancel := ack.budget.auth(r.Context())
+	agent, err := authAgent(r.WithContext(actx), &id, ack.bulk, ack.cache)
+	err = budgetErr(actx, err)
+	cancel()
 	if err != nil {
 		return err
 	}
-	zlog = zlog.With().Str(LogAccessAPIKeyID, agent.AccessAPIKeyID).Logger()
-	ctx := zlog.WithContext(r.Context())
-	r = r.WithContext(ctx)
+	ack.seen.Add(agent.Id)
+	zlog := zerolog.Ctx(r.Context()).With().
+		Str(LogAgentID, agent.Id).
+		Str(LogAccessAPIKeyID, agent.AccessAPIKeyID).
+		Logger()
+	r = r.WithContext(zlog.WithContext(r.Context()))
+
+	// the acks of an agent are processed one at a time, so the retry of an ack in flight can't interleave with it
+	unlock, err := ack.locks.lock(r.Context(), agent.Id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	return ack.processRequest(zlog, w, r, agent)
+	return ack.processRequest(w, r, agent)
 }
 
-func (ack *AckT) processRequest(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, agent *model.Agent) error {
-	req, err := ack.validateRequest(zlog, w, r)
+func (ack *AckT) processRequest(w http.ResponseWriter, r *http.Request, agent *model.Agent) error {
+	req, err := ack.validateRequest(w, r)
 	if err != nil {
 		return err
 	}
 
-	zlog = zlog.With().Int("nEvents", len(req.Events)).Logger()
+	zlog := zerolog.Ctx(r.Context()).With().Int("nEvents", len(req.Events)).Logger()
 
-	resp, err := ack.handleAckEvents(r.Context(), zlog, agent, req.Events)
+	ctx, cancel := ack.budget.total(zlog.WithContext(r.Context()))
+	resp, err := ack.handleAckEvents(ctx, agent, req.Events)
+	cancel()
 	span, _ := apm.StartSpan(r.Context(), "response", "write")
 	defer span.End()
 	if err != nil {
@@ -140,72 +220,199 @@ func (ack *AckT) processRequest(zlog zerolog.Logger, w http.ResponseWriter, r *h
 	return nil
 }
 
-func (ack *AckT) validateRequest(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request) (*AckRequest, error) {
+func (ack *AckT) validateRequest(w http.ResponseWriter, r *http.Request) (*AckRequest, error) {
 	span, _ := apm.StartSpan(r.Context(), "validateRequest", "validate")
 	defer span.End()
 
 	body := r.Body
 
 	// Limit the size of the body to prevent malicious agent from exhausting RAM in server
-	if ack.cfg.Limits.AckLimit.MaxBody > 0 {
-		body = http.MaxBytesReader(w, body, ack.cfg.Limits.AckLimit.MaxBody)
+	if ack.serverCfg().Limits.AckLimit.MaxBody > 0 {
+		body = http.MaxBytesReader(w, body, ack.serverCfg().Limits.AckLimit.MaxBody)
 	}
 	readCounter := datacounter.NewReaderCounter(body)
 
 	var req AckRequest
-	dec := json.NewDecoder(readCounter)
-	if err := dec.Decode(&req); err != nil {
-		return nil, &BadRequestErr{msg: "unable to decode ack request", nextErr: err}
+	if err := decodeRequest(r.Context(), readCounter, &req, "ack"); err != nil {
+		return nil, err
 	}
 
 	cntAcks.bodyIn.Add(readCounter.Count())
-	zlog.Trace().Msg("Ack request")
+	zerolog.Ctx(r.Context()).Trace().Msg("Ack request")
 	return &req, nil
 }
 
+// decodeStream decodes the events one at a time, large ack batches are not buffered by the decoder.
+// The decoding stops once the request has more than maxAckEvents events.
+func (req *AckRequest) decodeStream(dec *json.Decoder) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if key, _ := t.(string); key != "events" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+
+		req.Events = nil
+		t, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			continue
+		}
+		if t != json.Delim('[') {
+			return fmt.Errorf("expected events array at offset %d, got %v", dec.InputOffset(), t)
+		}
+		req.Events = []AckRequest_Events_Item{}
+		for dec.More() {
+			if len(req.Events) == maxAckEvents {
+				return fmt.Errorf("%w: more than %d events", ErrTooManyEvents, maxAckEvents)
+			}
+			var ev AckRequest_Events_Item
+			if err := dec.Decode(&ev); err != nil {
+				return err
+			}
+			req.Events = append(req.Events, ev)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// resultNamespaces returns the namespaces of the result of an action of the agent, the ones of the agent or the
+// namespaces of the action for an agent enrolled without any.
+func resultNamespaces(agent *model.Agent, namespaces []string) []string {
+	if len(agent.Namespaces) > 0 {
+		return agent.Namespaces
+	}
+	return namespaces
+}
+
+// eventToActionResult converts the ack event to an action result document.
+// The error and error code of the event are truncated, the error code is only kept when the event has an error.
+// The documents are read by the actions UI of Kibana: started_at is only set when the agent reports the start of the
+// action, completed_at is the ack time unless the agent reports it, and @timestamp is the time the ack is received
+// when the event has none.
 func eventToActionResult(agentID, aType string, namespaces []string, ev AckRequest_Events_Item) (acr model.ActionResult) {
 	switch aType {
 	case string(REQUESTDIAGNOSTICS):
 		event, _ := ev.AsDiagnosticsEvent()
-		p, _ := json.Marshal(event.Data)
-		return model.ActionResult{
+		var p json.RawMessage
+		if event.Data != nil {
+			p, _ = json.Marshal(event.Data)
+		}
+		acr = model.ActionResult{
 			ActionID:   event.ActionId,
 			AgentID:    agentID,
 			Namespaces: namespaces,
 			Data:       p,
 			Error:      fromPtr(event.Error),
-			Timestamp:  event.Timestamp.Format(time.RFC3339Nano),
+			ErrorCode:  fromPtr(event.ErrorCode),
+			Timestamp:  formatResultTime(event.Timestamp),
 		}
 	case string(INPUTACTION):
 		event, _ := ev.AsInputEvent()
-		return model.ActionResult{
+		acr = model.ActionResult{
 			ActionID:        event.ActionId,
 			AgentID:         agentID,
 			Namespaces:      namespaces,
 			ActionInputType: event.ActionInputType,
-			StartedAt:       event.StartedAt.Format(time.RFC3339Nano),
-			CompletedAt:     event.CompletedAt.Format(time.RFC3339Nano),
+			StartedAt:       formatResultTime(event.StartedAt),
+			CompletedAt:     formatResultTime(event.CompletedAt),
 			ActionData:      event.ActionData,
 			ActionResponse:  event.ActionResponse,
 			Error:           fromPtr(event.Error),
-			Timestamp:       event.Timestamp.Format(time.RFC3339Nano),
+			ErrorCode:       fromPtr(event.ErrorCode),
+			Timestamp:       formatResultTime(event.Timestamp),
 		}
 	default: // UPGRADE action acks are also handled by handelUpgrade (deprecated func)
 		event, _ := ev.AsGenericEvent()
-		return model.ActionResult{
+		acr = model.ActionResult{
 			ActionID:   event.ActionId,
 			Namespaces: namespaces,
 			AgentID:    agentID,
 			Error:      fromPtr(event.Error),
-			Timestamp:  event.Timestamp.Format(time.RFC3339Nano),
+			ErrorCode:  fromPtr(event.ErrorCode),
+			Timestamp:  formatResultTime(event.Timestamp),
 		}
 	}
+	if acr.Timestamp == "" {
+		acr.Timestamp = formatResultTime(time.Now().UTC())
+	}
+	if acr.CompletedAt == "" {
+		acr.CompletedAt = acr.Timestamp
+	}
+	if acr.Error == "" {
+		acr.ErrorCode = ""
+	} else {
+		acr.Error = truncateString(acr.Error, maxActionErrorLen)
+		acr.ErrorCode = truncateString(acr.ErrorCode, maxActionErrorCodeLen)
+	}
+	return acr
+}
+
+// actionResponseName matches the input types and namespaces that are part of the name of a responses index.
+var actionResponseName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// actionResponseIndex returns the index that the result of an input action of inputType is copied to for the namespace.
+func actionResponseIndex(inputType, namespace string) string {
+	return ".logs-" + inputType + ".action.responses-" + namespace
+}
+
+// routeActionResponse copies the result of the input action to the responses index of its input type for each namespace
+// of the action, or the default namespace, when the index is allowed by the configuration.
+// The result is already stored in the fleet action results, so the copy is skipped with a warning when the response
+// is not an object or the index is not allowed, and a failed copy does not fail the ack.
+func (ack *AckT) routeActionResponse(ctx context.Context, action model.Action, acr model.ActionResult) {
+	zlog := zerolog.Ctx(ctx).With().Str("input_type", action.InputType).Logger()
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(acr.ActionResponse, &response); err != nil || response == nil {
+		zlog.Warn().Err(err).Msg("action response is not an object, skipping its routing")
+		return
+	}
+	if !actionResponseName.MatchString(action.InputType) {
+		zlog.Warn().Msg("input type is not valid in an index name, skipping the routing of the action response")
+		return
+	}
+	namespaces := action.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{"default"}
+	}
+	for _, ns := range namespaces {
+		index := actionResponseIndex(action.InputType, ns)
+		if !actionResponseName.MatchString(ns) || !ack.serverCfg().Actions.ResponseIndexAllowed(index) {
+			zlog.Warn().Str("index", index).Msg("action response index is not allowed, skipping its routing")
+			continue
+		}
+		if err := dl.CreateActionResponse(ctx, ack.bulk, index, acr); err != nil {
+			zlog.Warn().Err(err).Str("index", index).Msg("failed to route the action response")
+		}
+	}
+}
+
+// formatResultTime formats t as a date of the action results index, a zero time is not set.
+func formatResultTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return ftime.Format(t)
 }
 
 // handleAckEvents can return:
 // 1. AckResponse and nil error, when the whole request is successful
 // 2. AckResponse and non-nil error, when the request items had errors
-func (ack *AckT) handleAckEvents(ctx context.Context, zlog zerolog.Logger, agent *model.Agent, events []AckRequest_Events_Item) (AckResponse, error) {
+func (ack *AckT) handleAckEvents(ctx context.Context, agent *model.Agent, events []AckRequest_Events_Item) (AckResponse, error) {
 	span, ctx := apm.StartSpan(ctx, "handleAckEvents", "process")
 	defer span.End()
 	var policyAcks []string
@@ -227,9 +434,12 @@ func (ack *AckT) handleAckEvents(ctx context.Context, zlog zerolog.Logger, agent
 
 	setError := func(pos int, err error) {
 		var esErr *es.ErrElastic
-		if errors.As(err, &esErr) {
+		switch {
+		case errors.As(err, &esErr):
 			setResult(pos, esErr.Status)
-		} else {
+		case errors.Is(err, ErrBudgetExceeded):
+			setResult(pos, http.StatusServiceUnavailable)
+		default:
 			setResult(pos, http.StatusInternalServerError)
 		}
 		res.SetError(pos, err)
@@ -242,16 +452,16 @@ func (ack *AckT) handleAckEvents(ctx context.Context, zlog zerolog.Logger, agent
 		span, ctx := apm.StartSpan(ctx, "ackEvent", "process")
 		span.Context.SetLabel("agent_id", agent.Agent.ID)
 		span.Context.SetLabel("action_id", event.ActionId)
-		log := zlog.With().
+		log := zerolog.Ctx(ctx).With().
 			Str(logger.ActionID, event.ActionId).
-			Str(logger.AgentID, event.AgentId).
 			Time("timestamp", event.Timestamp).
 			Int("n", n).Logger()
+		ctx = log.WithContext(ctx)
 		log.Info().Msg("ack event")
 
 		// Check agent id mismatch
 		if event.AgentId != "" && event.AgentId != agent.Id {
-			log.Error().Msg("agent id mismatch")
+			log.Error().Str("event.agent_id", event.AgentId).Msg("agent id mismatch")
 			setResult(n, http.StatusBadRequest)
 			span.End()
 			continue
@@ -276,8 +486,20 @@ func (ack *AckT) handleAckEvents(ctx context.Context, zlog zerolog.Logger, agent
 		vSpan, vCtx := apm.StartSpan(ctx, "ackAction", "validate")
 		action, ok := ack.cache.GetAction(event.ActionId)
 		if !ok {
+			// A missing action acked again is not looked up until its not found entry expires.
+			if ack.cache.NotFound(cache.KindAction, event.ActionId) {
+				log.Debug().Msg("no matching action, cached")
+				setResult(n, http.StatusNotFound)
+				vSpan.End()
+				span.End()
+				continue
+			}
+
 			// Find action by ID
-			actions, err := dl.FindAction(vCtx, ack.bulk, event.ActionId)
+			rctx, cancel := ack.budget.read(vCtx)
+			actions, err := dl.FindAction(rctx, ack.bulk, event.ActionId)
+			err = budgetErr(rctx, err)
+			cancel()
 			if err != nil {
 				log.Error().Err(err).Msg("find action")
 				setError(n, err)
@@ -289,6 +511,7 @@ func (ack *AckT) handleAckEvents(ctx context.Context, zlog zerolog.Logger, agent
 			// Set 404 if action is not found. The agent can retry it later.
 			if len(actions) == 0 {
 				log.Error().Msg("no matching action")
+				ack.cache.SetNotFound(cache.KindAction, event.ActionId)
 				setResult(n, http.StatusNotFound)
 				vSpan.End()
 				span.End()
@@ -299,31 +522,59 @@ func (ack *AckT) handleAckEvents(ctx context.Context, zlog zerolog.Logger, agent
 		}
 		vSpan.End()
 
-		if err := ack.handleActionResult(ctx, zlog, agent, action, ev); err != nil {
+		wctx, cancel := ack.budget.write(ctx)
+		err := budgetErr(wctx, ack.handleActionResult(wctx, agent, action, ev))
+		cancel()
+		if err != nil {
 			setError(n, err)
 		} else {
 			setResult(n, http.StatusOK)
 		}
 
-		if event.Error == nil && action.Type == TypeUnenroll {
-			unenrollIdxs = append(unenrollIdxs, n)
+		switch t, _ := ParseActionType(action.Type); t {
+		case UNENROLL:
+			if event.Error == nil {
+				unenrollIdxs = append(unenrollIdxs, n)
+			}
+		case CANCEL, FORCEUNENROLL, INPUTACTION, POLICYCHANGE, POLICYREASSIGN, REQUESTDIAGNOSTICS, SETTINGS, UPDATETAGS, UPGRADE:
+			// the result is recorded by handleActionResult, which also updates the upgrade details of the UPGRADE acks
+		default:
+			log.Warn().Str(logger.ActionType, action.Type).Msg("unknown action type")
+			if err == nil {
+				res.setMessage(n, http.StatusOK, ackActionTypeUnsupported)
+			}
 		}
 		span.End()
 	}
 
 	// Process policy acks
 	if len(policyAcks) > 0 {
-		if err := ack.handlePolicyChange(ctx, zlog, agent, policyAcks...); err != nil {
+		pctx := zerolog.Ctx(ctx).With().Strs(logger.ActionID, policyAcks).Logger().WithContext(ctx)
+		pctx, cancel := ack.budget.write(pctx)
+		outcomes, err := ack.handlePolicyChange(pctx, agent, policyAcks...)
+		err = budgetErr(pctx, err)
+		cancel()
+		if err != nil {
 			for _, idx := range policyIdxs {
 				setError(idx, err)
 			}
+		} else {
+			// the acks that are not recorded are successful, their message tells why they are ignored
+			for i, idx := range policyIdxs {
+				if outcomes[i] != "" {
+					res.setMessage(idx, http.StatusOK, outcomes[i])
+				}
+			}
 		}
 	}
 
 	// Process unenroll acks
 	if len(unenrollIdxs) > 0 {
-		if err := ack.handleUnenroll(ctx, zlog, agent); err != nil {
-			zlog.Error().Err(err).Msg("handle unenroll event")
+		uctx, cancel := ack.budget.write(ctx)
+		err := budgetErr(uctx, ack.handleUnenroll(uctx, agent))
+		cancel()
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("handle unenroll event")
 			// Set errors for each unenroll event
 			for _, idx := range unenrollIdxs {
 				setError(idx, err)
@@ -338,13 +589,13 @@ func (ack *AckT) handleAckEvents(ctx context.Context, zlog zerolog.Logger, agent
 	return res, nil
 }
 
-func (ack *AckT) handleActionResult(ctx context.Context, zlog zerolog.Logger, agent *model.Agent, action model.Action, ev AckRequest_Events_Item) error {
+func (ack *AckT) handleActionResult(ctx context.Context, agent *model.Agent, action model.Action, ev AckRequest_Events_Item) error {
 	// Build span links for actions
 	var links []apm.SpanLink
 	if ack.bulk.HasTracer() && action.Traceparent != "" {
 		traceCtx, err := apmhttp.ParseTraceparentHeader(action.Traceparent)
 		if err != nil {
-			zlog.Trace().Err(err).Msgf("Error parsing traceparent: %s %s", action.Traceparent, err)
+			zerolog.Ctx(ctx).Trace().Err(err).Msgf("Error parsing traceparent: %s %s", action.Traceparent, err)
 		} else {
 			links = []apm.SpanLink{
 				{
@@ -361,18 +612,32 @@ func (ack *AckT) handleActionResult(ctx context.Context, zlog zerolog.Logger, ag
 	defer span.End()
 
 	// Convert ack event to action result document
-	acr := eventToActionResult(agent.Id, action.Type, action.Namespaces, ev)
+	acr := eventToActionResult(agent.Id, action.Type, resultNamespaces(agent, action.Namespaces), ev)
+	if action.Type == string(REQUESTDIAGNOSTICS) && acr.Error == "" {
+		ack.linkDiagnosticsUpload(ctx, agent.Id, &acr)
+	}
 
 	// Save action result document
 	if err := dl.CreateActionResult(ctx, ack.bulk, acr); err != nil {
-		zlog.Error().Err(err).Str(logger.AgentID, agent.Agent.ID).Str(logger.ActionID, action.Id).Msg("create action result")
+		zerolog.Ctx(ctx).Error().Err(err).Msg("create action result")
 		return err
 	}
+	if action.InputType != "" && len(acr.ActionResponse) > 0 {
+		ack.routeActionResponse(ctx, action, acr)
+	}
 
-	if action.Type == TypeUpgrade {
+	if acr.Error != "" {
+		cntActionFailures.Inc(action.Type)
+		// The action result is already stored, the ack succeeds when the agent document is not updated.
+		if err := ack.updateLastActionError(ctx, agent, action, acr); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("update last action error")
+		}
+	}
+
+	if action.Type == string(UPGRADE) {
 		event, _ := ev.AsUpgradeEvent()
-		if err := ack.handleUpgrade(ctx, zlog, agent, event); err != nil {
-			zlog.Error().Err(err).Str(logger.AgentID, agent.Agent.ID).Str(logger.ActionID, action.Id).Msg("handle upgrade event")
+		if err := ack.handleUpgrade(ctx, agent, action, event); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("handle upgrade event")
 			return err
 		}
 	}
@@ -380,35 +645,115 @@ func (ack *AckT) handleActionResult(ctx context.Context, zlog zerolog.Logger, ag
 	return nil
 }
 
-func (ack *AckT) handlePolicyChange(ctx context.Context, zlog zerolog.Logger, agent *model.Agent, actionIds ...string) error {
-	span, ctx := apm.StartSpan(ctx, "ackPolicyChanges", "process")
+// lastActionErrorScript sets the last action error of the agent to params.error, unless it holds the error of a later
+// event. The acks of an agent may be processed out of order, by concurrent requests or by other instances.
+const lastActionErrorScript = `def last = ctx._source.` + dl.FieldLastActionError + `;` +
+	`if (last == null || last['@timestamp'] == null || ` +
+	`!ZonedDateTime.parse(last['@timestamp']).isAfter(ZonedDateTime.parse(params.error['@timestamp']))) {` +
+	`ctx._source.` + dl.FieldLastActionError + ` = params.error;} else {ctx.op = 'noop';}`
+
+// updateLastActionError records the error of the action result as the last action error of the agent, if it is the
+// newest error reported by the agent.
+func (ack *AckT) updateLastActionError(ctx context.Context, agent *model.Agent, action model.Action, acr model.ActionResult) error {
+	span, ctx := apm.StartSpan(ctx, "updateLastActionError", "update")
 	defer span.End()
-	// If more than one, pick the winner;
-	// 0) Correct policy id
-	// 1) Highest revision number
 
-	found := false
-	currRev := agent.PolicyRevisionIdx
-	vSpan, _ := apm.StartSpan(ctx, "checkPolicyActions", "validate")
-	for _, a := range actionIds {
-		rev, ok := policy.RevisionFromString(a)
+	// The whole object is replaced, a partial update merges objects and would keep the code of a previous error.
+	body, err := json.Marshal(map[string]any{
+		"script": map[string]any{
+			"lang":   "painless",
+			"source": lastActionErrorScript,
+			"params": map[string]any{
+				"error": model.LastActionError{
+					ActionID:   acr.ActionID,
+					ActionType: action.Type,
+					Code:       acr.ErrorCode,
+					Message:    acr.Error,
+					Timestamp:  acr.Timestamp,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("updateLastActionError marshal: %w", err)
+	}
+	if err := ack.bulk.Update(ctx, dl.FleetAgents, agent.Id, body, bulk.WithRetryOnConflict(3)); err != nil {
+		return fmt.Errorf("updateLastActionError update: %w", err)
+	}
+	return nil
+}
 
-		zlog.Debug().
-			Str("agent.policyId", agent.PolicyID).
-			Int64("agent.revisionIdx", currRev).
-			Str("rev.policyId", rev.PolicyID).
-			Int64(logger.RevisionIdx, rev.RevisionIdx).
-			Msg("ack policy revision")
+// linkDiagnosticsUpload adds the ID of the uploaded diagnostics bundle to the result of a completed REQUEST_DIAGNOSTICS action.
+// The upload ID sent by the agent is used, or the ID pre-allocated when the action was delivered.
+// The result is recorded without the file ID when the upload is not found or is not complete.
+func (ack *AckT) linkDiagnosticsUpload(ctx context.Context, agentID string, acr *model.ActionResult) {
+	span, ctx := apm.StartSpan(ctx, "linkDiagnosticsUpload", "search")
+	defer span.End()
 
-		if ok && rev.PolicyID == agent.PolicyID && rev.RevisionIdx > currRev {
-			found = true
-			currRev = rev.RevisionIdx
+	var data struct {
+		UploadID string `json:"upload_id"`
+	}
+	if len(acr.Data) > 0 {
+		if err := json.Unmarshal(acr.Data, &data); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("unable to parse diagnostics result data")
+			return
 		}
 	}
+	if data.UploadID == "" {
+		data.UploadID = uploader.UploadID(acr.ActionID, agentID)
+	}
+	zlog := zerolog.Ctx(ctx).With().Str("upload_id", data.UploadID).Logger()
+
+	info, err := file.GetInfo(ctx, ack.bulk, uploader.UploadHeaderIndexPattern, data.UploadID)
+	if err != nil {
+		zlog.Warn().Err(err).Msg("diagnostics upload not found")
+		return
+	}
+	if info.AgentID != agentID || info.ActionID != acr.ActionID {
+		zlog.Warn().Str("upload.agent_id", info.AgentID).Str("upload.action_id", info.ActionID).Msg("diagnostics upload belongs to another action")
+		return
+	}
+	if info.Status != file.StatusDone {
+		zlog.Warn().Str("status", string(info.Status)).Msg("diagnostics upload is not complete")
+		return
+	}
 
+	p, err := json.Marshal(map[string]string{
+		"upload_id": data.UploadID,
+		"file_id":   info.DocID,
+	})
+	if err != nil {
+		zlog.Warn().Err(err).Msg("unable to encode diagnostics result data")
+		return
+	}
+	acr.Data = p
+}
+
+// handlePolicyChange records the highest acked revision of the policy of the agent, and returns the outcome of each
+// ack: ackPolicySuperseded or ackPolicyRevisionStale for the acks that are ignored, an empty string for the others.
+// The agent document is not updated when all the acks are ignored.
+func (ack *AckT) handlePolicyChange(ctx context.Context, agent *model.Agent, actionIds ...string) ([]string, error) {
+	span, ctx := apm.StartSpan(ctx, "ackPolicyChanges", "process")
+	defer span.End()
+	zlog := zerolog.Ctx(ctx)
+	vSpan, _ := apm.StartSpan(ctx, "checkPolicyActions", "validate")
+	currRev, found := ackedPolicyRevision(zlog, agent, actionIds)
 	vSpan.End()
 	if !found {
-		return nil
+		return policyAckOutcomes(zlog, agent, actionIds), nil
+	}
+
+	// The agent read on authentication may be older than the revision written by a previous ack of the agent,
+	// the revision is compared again with the current document.
+	current, err := dl.GetAgent(ctx, ack.bulk, agent.Id)
+	if err != nil {
+		return nil, fmt.Errorf("handlePolicyChange read agent: %w", err)
+	}
+	agent = &current
+	outcomes := policyAckOutcomes(zlog, agent, actionIds)
+	if currRev, found = ackedPolicyRevision(zlog, agent, actionIds); !found {
+		zlog.Debug().Int64("agent.revisionIdx", agent.PolicyRevisionIdx).Msg("acked policy revision already recorded")
+		return outcomes, nil
 	}
 
 	for outputName, output := range agent.Outputs {
@@ -417,30 +762,77 @@ func (ack *AckT) handlePolicyChange(ctx context.Context, zlog zerolog.Logger, ag
 		}
 
 		err := ack.updateAPIKey(ctx,
-			zlog,
 			agent.Id,
 			output.APIKeyID, output.PermissionsHash, output.ToRetireAPIKeyIds, outputName)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	err := ack.updateAgentDoc(ctx, zlog,
-		agent.Id,
-		currRev,
-		agent.PolicyID)
+	err = ack.updateAgentDoc(ctx,
+		agent,
+		currRev)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return outcomes, nil
+}
+
+// policyAckOutcomes returns the outcome of the ack of each action of actionIds against the recorded policy of the
+// agent: ackPolicySuperseded for another policy, ackPolicyRevisionStale for an older revision, an empty string otherwise.
+func policyAckOutcomes(zlog *zerolog.Logger, agent *model.Agent, actionIds []string) []string {
+	outcomes := make([]string, len(actionIds))
+	for i, a := range actionIds {
+		rev, ok := policy.RevisionFromString(a)
+		switch {
+		case !ok:
+		case rev.PolicyID != agent.PolicyID:
+			outcomes[i] = ackPolicySuperseded
+			zlog.Debug().
+				Str("agent.policyId", agent.PolicyID).
+				Str("rev.policyId", rev.PolicyID).
+				Msg("ack of a policy change of a superseded policy, ignored")
+		case rev.RevisionIdx < agent.PolicyRevisionIdx:
+			outcomes[i] = ackPolicyRevisionStale
+			zlog.Debug().
+				Str(LogPolicyID, agent.PolicyID).
+				Int64("agent.revisionIdx", agent.PolicyRevisionIdx).
+				Int64(logger.RevisionIdx, rev.RevisionIdx).
+				Msg("ack of an older policy revision than the recorded revision, ignored")
+		}
+	}
+	return outcomes
+}
+
+// ackedPolicyRevision returns the highest revision of the policy of the agent in the acked actions,
+// and true if it is higher than the revision of the agent.
+func ackedPolicyRevision(zlog *zerolog.Logger, agent *model.Agent, actionIds []string) (int64, bool) {
+	found := false
+	currRev := agent.PolicyRevisionIdx
+	for _, a := range actionIds {
+		rev, ok := policy.RevisionFromString(a)
+
+		zlog.Debug().
+			Str("agent.policyId", agent.PolicyID).
+			Int64("agent.revisionIdx", currRev).
+			Str("rev.policyId", rev.PolicyID).
+			Int64(logger.RevisionIdx, rev.RevisionIdx).
+			Msg("ack policy revision")
+
+		if ok && rev.PolicyID == agent.PolicyID && rev.RevisionIdx > currRev {
+			found = true
+			currRev = rev.RevisionIdx
+		}
+	}
+	return currRev, found
 }
 
 func (ack *AckT) updateAPIKey(ctx context.Context,
-	zlog zerolog.Logger,
 	agentID string,
 	apiKeyID, permissionHash string,
 	toRetireAPIKeyIDs []model.ToRetireAPIKeyIdsItems, outputName string) error {
+	zlog := zerolog.Ctx(ctx)
 	bulk := ack.bulk
 	// use output bulker if exists
 	if outputName != "" {
@@ -453,7 +845,7 @@ func (ack *AckT) updateAPIKey(ctx context.Context,
 	if apiKeyID != "" {
 		res, err := bulk.APIKeyRead(ctx, apiKeyID, true)
 		if err != nil {
-			if isAgentActive(ctx, zlog, ack.bulk, agentID) {
+			if isAgentActive(ctx, ack.bulk, agentID) {
 				zlog.Warn().
 					Err(err).
 					Str(LogAPIKeyID, apiKeyID).
@@ -492,42 +884,44 @@ func (ack *AckT) updateAPIKey(ctx context.Context,
 				}
 			}
 		}
-		ack.invalidateAPIKeys(ctx, zlog, toRetireAPIKeyIDs, apiKeyID)
+		ack.invalidateAPIKeys(ctx, toRetireAPIKeyIDs, apiKeyID)
 	}
 
 	return nil
 }
 
+// updateAgentDoc records the acked revision of the policy of the agent. The index is not refreshed, the version of the
+// document is recorded in the cache: the next checkin reads the agent by id and sees the revision.
 func (ack *AckT) updateAgentDoc(ctx context.Context,
-	zlog zerolog.Logger,
-	agentID string,
+	agent *model.Agent,
 	currRev int64,
-	policyID string,
 ) error {
 	span, ctx := apm.StartSpan(ctx, "updateAgentDoc", "update")
 	defer span.End()
 	body := makeUpdatePolicyBody(
-		policyID,
+		agent.PolicyID,
 		currRev,
 	)
 
+	var written bulk.DocVersion
 	err := ack.bulk.Update(
 		ctx,
 		dl.FleetAgents,
-		agentID,
+		agent.Id,
 		body,
-		bulk.WithRefresh(),
 		bulk.WithRetryOnConflict(3),
+		bulk.WithDocVersion(&written),
 	)
 
-	zlog.Err(err).
-		Str(LogPolicyID, policyID).
+	zerolog.Ctx(ctx).Err(err).
+		Str(LogPolicyID, agent.PolicyID).
 		Int64("policyRevision", currRev).
 		Msg("ack policy")
 
 	if err != nil {
 		return fmt.Errorf("handlePolicyChange update: %w", err)
 	}
+	setAgentVersion(ack.cache, agent.Id, agent.AccessAPIKeyID, written)
 	return nil
 }
 
@@ -559,92 +953,108 @@ func cleanRoles(roles json.RawMessage) (json.RawMessage, int, error) {
 	return r, len(keys), nil
 }
 
-func (ack *AckT) invalidateAPIKeys(ctx context.Context, zlog zerolog.Logger, toRetireAPIKeyIDs []model.ToRetireAPIKeyIdsItems, skip string) {
-	invalidateAPIKeys(ctx, zlog, ack.bulk, toRetireAPIKeyIDs, skip)
+func (ack *AckT) invalidateAPIKeys(ctx context.Context, toRetireAPIKeyIDs []model.ToRetireAPIKeyIdsItems, skip string) {
+	invalidateAPIKeys(ctx, ack.bulk, toRetireAPIKeyIDs, skip)
 }
 
-func (ack *AckT) handleUnenroll(ctx context.Context, zlog zerolog.Logger, agent *model.Agent) error {
+// handleUnenroll unenrolls the agent that acked the UNENROLL action.
+// The ack fails when the agent is not unenrolled, the API keys that could not be invalidated are retried by the GC.
+func (ack *AckT) handleUnenroll(ctx context.Context, agent *model.Agent) error {
 	span, ctx := apm.StartSpan(ctx, "ackUnenroll", "process")
 	defer span.End()
+	zlog := zerolog.Ctx(ctx)
 
-	apiKeys := agent.APIKeyIDs()
-	zlog.Info().Any("fleet.policy.apiKeyIDsToRetire", apiKeys).Msg("handleUnenroll invalidate API keys")
-	ack.invalidateAPIKeys(ctx, zlog, apiKeys, "")
-
-	now := time.Now().UTC().Format(time.RFC3339)
-	doc := bulk.UpdateFields{
-		dl.FieldActive:       false,
-		dl.FieldUnenrolledAt: now,
-		dl.FieldUpdatedAt:    now,
-	}
-
-	body, err := doc.Marshal()
-	if err != nil {
-		return fmt.Errorf("handleUnenroll marshal: %w", err)
+	if err := dl.CheckAgentTransition(ctx, agent.Id, agent.State(), model.AgentStateUnenrolled); err != nil {
+		return err
 	}
 
-	if err = ack.bulk.Update(ctx, dl.FleetAgents, agent.Id, body, bulk.WithRefresh(), bulk.WithRetryOnConflict(3)); err != nil {
-		return fmt.Errorf("handleUnenroll update: %w", err)
+	if err := unenroll.Unenroll(ctx, ack.bulk, agent, ""); err != nil {
+		return fmt.Errorf("handleUnenroll: %w", err)
 	}
+	ack.bc.SetState(agent.Id, model.AgentStateUnenrolled)
 
 	zlog.Info().Msg("ack unenroll")
 	return nil
 }
 
-func (ack *AckT) handleUpgrade(ctx context.Context, zlog zerolog.Logger, agent *model.Agent, event UpgradeEvent) error {
+// handleUpgrade records the result of the upgrade acked by the agent.
+// The update is conditioned on the state the agent was read with, a completed upgrade is not overridden by a stale ack.
+// An upgrade the agent does not retry itself is re-issued by fleet-server when the upgrade retries are enabled.
+func (ack *AckT) handleUpgrade(ctx context.Context, agent *model.Agent, action model.Action, event UpgradeEvent) error {
 	span, ctx := apm.StartSpan(ctx, "ackUpgrade", "process")
 	defer span.End()
-	now := time.Now().UTC().Format(time.RFC3339)
-	doc := bulk.UpdateFields{}
-	if event.Error != nil {
-		// if the payload indicates a retry, mark change the upgrade status to retrying.
-		if event.Payload == nil {
-			zlog.Info().Msg("marking agent upgrade as failed, agent logs contain failure message")
-			doc = bulk.UpdateFields{
-				dl.FieldUpgradeStartedAt: nil,
-				dl.FieldUpgradeStatus:    "failed",
+	zlog := zerolog.Ctx(ctx)
+	now := ftime.Now()
+
+	var plan upgradeRetryPlan
+	if event.Error != nil && (event.Payload == nil || !event.Payload.Retry) {
+		var err error
+		if plan, err = ack.planUpgradeRetry(ctx, agent, action); err != nil {
+			return fmt.Errorf("handleUpgrade: %w", err)
+		}
+	}
+	failedStatus := dl.UpgradeStatusFailed
+	if plan.exhausted {
+		failedStatus = dl.UpgradeStatusUpgradeFailed
+	}
+
+	updated, err := dl.UpdateAgent(ctx, ack.bulk, agent, func(agent *model.Agent) bulk.UpdateFields {
+		if agent.UpgradeStatus == dl.UpgradeStatusCompleted && agent.UpgradeStartedAt == "" {
+			return nil
+		}
+		doc := bulk.UpdateFields{}
+		if event.Error != nil {
+			// if the payload indicates a retry, mark change the upgrade status to retrying.
+			if event.Payload != nil && event.Payload.Retry {
+				zlog.Info().Int("retry_attempt", event.Payload.RetryAttempt).Msg("marking agent upgrade as retrying")
+				doc[dl.FieldUpgradeStatus] = dl.UpgradeStatusRetrying // Keep FieldUpgradeStatedAt abd FieldUpgradeded at to original values
+			} else if plan.retry != nil {
+				zlog.Info().Int64("upgrade_attempt", plan.retry.UpgradeAttempt).Str("start_time", plan.retry.StartTime).Msg("marking agent upgrade as retrying, upgrade re-issued")
+				doc = bulk.UpdateFields{
+					dl.FieldUpgradeStartedAt: nil,
+					dl.FieldUpgradeStatus:    dl.UpgradeStatusRetrying,
+				}
+			} else {
+				zlog.Info().Str("upgrade_status", failedStatus).Msg("marking agent upgrade as failed, agent logs contain failure message")
+				doc = bulk.UpdateFields{
+					dl.FieldUpgradeStartedAt: nil,
+					dl.FieldUpgradeStatus:    failedStatus,
+				}
 			}
-		} else if event.Payload.Retry {
-			zlog.Info().Int("retry_attempt", event.Payload.RetryAttempt).Msg("marking agent upgrade as retrying")
-			doc[dl.FieldUpgradeStatus] = "retrying" // Keep FieldUpgradeStatedAt abd FieldUpgradeded at to original values
 		} else {
-			zlog.Info().Int("retry_attempt", event.Payload.RetryAttempt).Msg("marking agent upgrade as failed, agent logs contain failure message")
 			doc = bulk.UpdateFields{
 				dl.FieldUpgradeStartedAt: nil,
-				dl.FieldUpgradeStatus:    "failed",
+				dl.FieldUpgradeStatus:    dl.UpgradeStatusCompleted,
+				dl.FieldUpgradedAt:       now,
 			}
 		}
-	} else {
-		doc = bulk.UpdateFields{
-			dl.FieldUpgradeStartedAt: nil,
-			dl.FieldUpgradeStatus:    nil,
-			dl.FieldUpgradedAt:       now,
-		}
-	}
-
-	body, err := doc.Marshal()
+		return doc
+	})
 	if err != nil {
-		return fmt.Errorf("handleUpgrade marshal: %w", err)
-	}
-
-	if err = ack.bulk.Update(ctx, dl.FleetAgents, agent.Id, body, bulk.WithRefresh(), bulk.WithRetryOnConflict(3)); err != nil {
 		return fmt.Errorf("handleUpgrade update: %w", err)
 	}
+	if !updated {
+		zlog.Info().Str("upgradedAt", agent.UpgradedAt).Msg("agent upgrade already completed, ack ignored")
+		return nil
+	}
+	if plan.retry != nil {
+		if _, err := dl.CreateAction(ctx, ack.bulk, *plan.retry); err != nil {
+			return fmt.Errorf("handleUpgrade create retry: %w", err)
+		}
+	}
 
 	zlog.Info().
 		Str("lastReportedVersion", agent.Agent.Version).
 		Str("upgradedAt", now).
-		Str(logger.AgentID, agent.Agent.ID).
-		Str(logger.ActionID, event.ActionId).
 		Msg("ack upgrade")
 
 	return nil
 }
 
-func isAgentActive(ctx context.Context, zlog zerolog.Logger, bulk bulk.Bulk, agentID string) bool {
+func isAgentActive(ctx context.Context, bulk bulk.Bulk, agentID string) bool {
 	agent, err := dl.FindAgent(ctx, bulk, dl.QueryAgentByID, dl.FieldID, agentID)
 	if err != nil {
-		zlog.Error().
+		zerolog.Ctx(ctx).Error().
 			Err(err).
 			Msg("failed to find agent by ID")
 		return true
@@ -661,10 +1071,16 @@ func isAgentActive(ctx context.Context, zlog zerolog.Logger, bulk bulk.Bulk, age
 // policy.  This script should be coupled with a "retry_on_conflict" parameter
 // to allow for *other* changes to the agent record while we running the script.
 // (For example, say the background bulk check-in timestamp update task fires)
+// The revision is only raised, an ack processed by another instance after a
+// later one can not write a lower revision.
 //
 // WARNING: This assumes the input data is sanitized.
 
-const kUpdatePolicyPrefix = `{"script":{"lang":"painless","source":"if (ctx._source.policy_id == params.id) {ctx._source.remove('default_api_key_history');ctx._source.` +
+const kUpdatePolicyPrefix = `{"script":{"lang":"painless","source":"if (ctx._source.policy_id == params.id && (ctx._source.` +
+	dl.FieldPolicyRevisionIdx +
+	` == null || ctx._source.` +
+	dl.FieldPolicyRevisionIdx +
+	` < params.rev)) {ctx._source.remove('default_api_key_history');ctx._source.` +
 	dl.FieldPolicyRevisionIdx +
 	` = params.rev;ctx._source.` +
 	dl.FieldUpdatedAt +
@@ -672,7 +1088,7 @@ const kUpdatePolicyPrefix = `{"script":{"lang":"painless","source":"if (ctx._sou
 
 func makeUpdatePolicyBody(policyID string, newRev int64) []byte {
 	var buf bytes.Buffer
-	buf.Grow(410)
+	buf.Grow(512)
 
 	//  Not pretty, but fast.
 	buf.WriteString(kUpdatePolicyPrefix)
@@ -680,54 +1096,13 @@ func makeUpdatePolicyBody(policyID string, newRev int64) []byte {
 	buf.WriteString(`","rev":`)
 	buf.WriteString(strconv.FormatInt(newRev, 10))
 	buf.WriteString(`,"ts":"`)
-	buf.WriteString(time.Now().UTC().Format(time.RFC3339))
+	buf.WriteString(ftime.Now())
 	buf.WriteString(`"}}}`)
 
 	return buf.Bytes()
 }
 
-func invalidateAPIKeys(ctx context.Context, zlog zerolog.Logger, bulk bulk.Bulk, toRetireAPIKeyIDs []model.ToRetireAPIKeyIdsItems, skip string) {
-	ids := make([]string, 0, len(toRetireAPIKeyIDs))
-	remoteIds := make(map[string][]string)
-	for _, k := range toRetireAPIKeyIDs {
-		if k.ID == skip || k.ID == "" {
-			continue
-		}
-		if k.Output != "" {
-			if remoteIds[k.Output] == nil {
-				remoteIds[k.Output] = make([]string, 0)
-			}
-			remoteIds[k.Output] = append(remoteIds[k.Output], k.ID)
-		} else {
-			ids = append(ids, k.ID)
-		}
-	}
-	if len(ids) > 0 {
-		zlog.Info().Strs("fleet.policy.apiKeyIDsToRetire", ids).Msg("Invalidate old API keys")
-		if err := bulk.APIKeyInvalidate(ctx, ids...); err != nil {
-			zlog.Info().Err(err).Strs("ids", ids).Msg("Failed to invalidate API keys")
-		}
-	}
-	// using remote es bulker to invalidate api key
-	for outputName, outputIds := range remoteIds {
-		outputBulk := bulk.GetBulker(outputName)
-
-		if outputBulk == nil {
-			// read output config from .fleet-policies, not filtering by policy id as agent could be reassigned
-			policy, err := dl.QueryOutputFromPolicy(ctx, bulk, outputName)
-			if err != nil || policy == nil {
-				zlog.Warn().Str(logger.PolicyOutputName, outputName).Any("ids", outputIds).Msg("Output policy not found, API keys will be orphaned")
-			} else {
-				outputBulk, _, err = bulk.CreateAndGetBulker(ctx, zlog, outputName, policy.Data.Outputs)
-				if err != nil {
-					zlog.Warn().Str(logger.PolicyOutputName, outputName).Any("ids", outputIds).Msg("Failed to recreate output bulker, API keys will be orphaned")
-				}
-			}
-		}
-		if outputBulk != nil {
-			if err := outputBulk.APIKeyInvalidate(ctx, outputIds...); err != nil {
-				zlog.Info().Err(err).Strs("ids", outputIds).Str(logger.PolicyOutputName, outputName).Msg("Failed to invalidate API keys")
-			}
-		}
-	}
+// invalidateAPIKeys invalidates the retired API keys, the failures are logged and the keys are not retried.
+func invalidateAPIKeys(ctx context.Context, bulk bulk.Bulk, toRetireAPIKeyIDs []model.ToRetireAPIKeyIdsItems, skip string) {
+	_ = unenroll.InvalidateAPIKeys(ctx, bulk, toRetireAPIKeyIDs, skip)
 }
@@ -8,26 +8,46 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/rs/zerolog"
 
+	"github.com/elastic/fleet-server/v7/internal/pkg/action"
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/checkin"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	mockmonitor "github.com/elastic/fleet-server/v7/internal/pkg/monitor/mock"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	"github.com/elastic/fleet-server/v7/internal/pkg/seen"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
 	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func BenchmarkMakeUpdatePolicyBody(b *testing.B) {
@@ -66,7 +86,7 @@ func TestEventToActionResult(t *testing.T) {
 	    }`)})
 		assert.Equal(t, agentID, r.AgentID)
 		assert.Equal(t, "test-action-id", r.ActionID)
-		assert.Equal(t, "2022-02-23T18:26:08.506128Z", r.Timestamp)
+		assert.Equal(t, "2022-02-23T18:26:08.506Z", r.Timestamp)
 		assert.Empty(t, r.Error)
 	})
 	t.Run("with error", func(t *testing.T) {
@@ -78,7 +98,7 @@ func TestEventToActionResult(t *testing.T) {
 	    }`)})
 		assert.Equal(t, agentID, r.AgentID)
 		assert.Equal(t, "test-action-id", r.ActionID)
-		assert.Equal(t, "2022-02-23T18:26:08.506128Z", r.Timestamp)
+		assert.Equal(t, "2022-02-23T18:26:08.506Z", r.Timestamp)
 		assert.Equal(t, "error message", r.Error)
 	})
 	t.Run("request diagnostics", func(t *testing.T) {
@@ -91,7 +111,7 @@ func TestEventToActionResult(t *testing.T) {
 	    }`)})
 		assert.Equal(t, agentID, r.AgentID)
 		assert.Equal(t, "test-action-id", r.ActionID)
-		assert.Equal(t, "2022-02-23T18:26:08.506128Z", r.Timestamp)
+		assert.Equal(t, "2022-02-23T18:26:08.506Z", r.Timestamp)
 		assert.Equal(t, json.RawMessage(`{"upload_id":"upload"}`), r.Data)
 		assert.Equal(t, "error message", r.Error)
 	})
@@ -109,14 +129,384 @@ func TestEventToActionResult(t *testing.T) {
 	    }`)})
 		assert.Equal(t, agentID, r.AgentID)
 		assert.Equal(t, "test-action-id", r.ActionID)
-		assert.Equal(t, "2022-02-23T18:26:08.506128Z", r.Timestamp)
+		assert.Equal(t, "2022-02-23T18:26:08.506Z", r.Timestamp)
 		assert.Equal(t, "test-input", r.ActionInputType)
 		assert.Equal(t, json.RawMessage(`{"key1":"value1"}`), r.ActionData)
 		assert.Equal(t, json.RawMessage(`{"key2":"value2"}`), r.ActionResponse)
-		assert.Equal(t, "2022-02-24T18:26:08.506128Z", r.CompletedAt)
-		assert.Equal(t, "2022-02-22T18:26:08.506128Z", r.StartedAt)
+		assert.Equal(t, "2022-02-24T18:26:08.506Z", r.CompletedAt)
+		assert.Equal(t, "2022-02-22T18:26:08.506Z", r.StartedAt)
 		assert.Equal(t, "error message", r.Error)
 	})
+	t.Run("with error code", func(t *testing.T) {
+		r := eventToActionResult(agentID, "UNENROLL", []string{}, AckRequest_Events_Item{json.RawMessage(`{
+		"action_id": "test-action-id",
+		"message": "action message",
+		"timestamp": "2022-02-23T18:26:08.506128Z",
+		"error": "error message",
+		"error_code": "E_TIMEOUT"
+	    }`)})
+		assert.Equal(t, "error message", r.Error)
+		assert.Equal(t, "E_TIMEOUT", r.ErrorCode)
+	})
+	t.Run("error code without error", func(t *testing.T) {
+		r := eventToActionResult(agentID, "UNENROLL", []string{}, AckRequest_Events_Item{json.RawMessage(`{
+		"action_id": "test-action-id",
+		"message": "action message",
+		"timestamp": "2022-02-23T18:26:08.506128Z",
+		"error_code": "E_TIMEOUT"
+	    }`)})
+		assert.Empty(t, r.Error)
+		assert.Empty(t, r.ErrorCode)
+	})
+	t.Run("truncated error", func(t *testing.T) {
+		msg := strings.Repeat("é", maxActionErrorLen)
+		code := strings.Repeat("c", maxActionErrorCodeLen+1)
+		p, err := json.Marshal(GenericEvent{ActionId: "test-action-id", Error: &msg, ErrorCode: &code})
+		require.NoError(t, err)
+		r := eventToActionResult(agentID, "UNENROLL", []string{}, AckRequest_Events_Item{p})
+		assert.LessOrEqual(t, len(r.Error), maxActionErrorLen)
+		assert.True(t, utf8.ValidString(r.Error))
+		assert.True(t, strings.HasSuffix(r.Error, truncatedMessageSuffix))
+		assert.Len(t, r.ErrorCode, maxActionErrorCodeLen)
+	})
+}
+
+// jsonSchema is the subset of JSON schema of the schemas captured in testdata.
+type jsonSchema struct {
+	Type                 string                 `json:"type"`
+	Format               string                 `json:"format"`
+	MinLength            int                    `json:"minLength"`
+	Enum                 []string               `json:"enum"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Items                *jsonSchema            `json:"items"`
+}
+
+// validate returns the violations of the schema by the decoded JSON value v.
+func (s *jsonSchema) validate(path string, v interface{}) []string {
+	var errs []string
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return []string{path + ": not an object"}
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, path+"."+name+": missing")
+			}
+		}
+		for name, value := range obj {
+			prop, ok := s.Properties[name]
+			switch {
+			case ok:
+				errs = append(errs, prop.validate(path+"."+name, value)...)
+			case s.AdditionalProperties != nil && !*s.AdditionalProperties:
+				errs = append(errs, path+"."+name+": unknown property")
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return []string{path + ": not an array"}
+		}
+		for i, item := range arr {
+			errs = append(errs, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return []string{path + ": not a string"}
+		}
+		if len(str) < s.MinLength {
+			errs = append(errs, path+": too short")
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, str) {
+			errs = append(errs, path+": not one of "+strings.Join(s.Enum, ", "))
+		}
+		if _, err := time.Parse(time.RFC3339Nano, str); s.Format == "date-time" && err != nil {
+			errs = append(errs, path+": not a date-time: "+err.Error())
+		}
+	}
+	return errs
+}
+
+// TestActionResultKibanaCompatibility validates the action result documents written on acks against the fields that
+// the actions UI of Kibana reads.
+func TestActionResultKibanaCompatibility(t *testing.T) {
+	p, err := os.ReadFile(filepath.Join("testdata", "kibana-action-result.schema.json"))
+	require.NoError(t, err)
+	var schema jsonSchema
+	require.NoError(t, json.Unmarshal(p, &schema))
+
+	agent := &model.Agent{
+		ESDocument: model.ESDocument{Id: "agent-1"},
+		Agent:      &model.AgentMetadata{ID: "agent-1", Version: "9.1.0"},
+	}
+	tests := []struct {
+		name        string
+		actionType  string
+		event       string
+		startedAt   string
+		completedAt string
+	}{{
+		name:        "generic",
+		actionType:  "SETTINGS",
+		event:       `{"action_id":"action-1","timestamp":"2025-04-01T10:00:01.5Z"}`,
+		completedAt: "2025-04-01T10:00:01.500Z",
+	}, {
+		name:        "generic with error",
+		actionType:  "UNENROLL",
+		event:       `{"action_id":"action-1","timestamp":"2025-04-01T10:00:01Z","error":"failed","error_code":"E_FAIL"}`,
+		completedAt: "2025-04-01T10:00:01.000Z",
+	}, {
+		name:       "generic without timestamp",
+		actionType: "SETTINGS",
+		event:      `{"action_id":"action-1"}`,
+	}, {
+		name:        "diagnostics",
+		actionType:  "REQUEST_DIAGNOSTICS",
+		event:       `{"action_id":"action-1","timestamp":"2025-04-01T10:00:01Z","error":"upload failed"}`,
+		completedAt: "2025-04-01T10:00:01.000Z",
+	}, {
+		name:        "input action",
+		actionType:  "INPUT_ACTION",
+		event:       `{"action_id":"action-1","timestamp":"2025-04-01T10:00:02Z","action_input_type":"osquery","action_data":{"query":"select 1"},"action_response":{"count":1},"started_at":"2025-04-01T10:00:00Z","completed_at":"2025-04-01T10:00:01Z"}`,
+		startedAt:   "2025-04-01T10:00:00.000Z",
+		completedAt: "2025-04-01T10:00:01.000Z",
+	}, {
+		name:        "input action without start",
+		actionType:  "INPUT_ACTION",
+		event:       `{"action_id":"action-1","timestamp":"2025-04-01T10:00:02Z","action_input_type":"osquery","action_data":{},"action_response":{}}`,
+		completedAt: "2025-04-01T10:00:02.000Z",
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testlog.SetLogger(t).WithContext(context.Background())
+			bulker := ftesting.NewMockBulk()
+			var body []byte
+			bulker.On("Create", mock.Anything, dl.FleetActionsResults, "action-1:agent-1", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				body = args.Get(3).([]byte)
+			}).Return("", nil).Once()
+			bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+			ack := NewAckT(&config.Server{}, bulker, nil)
+			action := model.Action{ActionID: "action-1", Type: tc.actionType, Namespaces: []string{"default"}}
+			require.NoError(t, ack.handleActionResult(ctx, agent, action, AckRequest_Events_Item{json.RawMessage(tc.event)}))
+			bulker.AssertExpectations(t)
+
+			var doc interface{}
+			require.NoError(t, json.Unmarshal(body, &doc))
+			require.Empty(t, schema.validate("$", doc), string(body))
+
+			var result model.ActionResult
+			require.NoError(t, json.Unmarshal(body, &result))
+			require.Equal(t, tc.startedAt, result.StartedAt)
+			if tc.completedAt == "" {
+				require.Equal(t, result.Timestamp, result.CompletedAt, "completed_at is the time of the ack")
+				return
+			}
+			require.Equal(t, tc.completedAt, result.CompletedAt)
+		})
+	}
+}
+
+func TestAckActionError(t *testing.T) {
+	const actionID = "ab12dcd8-bde0-4045-92dc-c4b27668d73a"
+	agent := &model.Agent{
+		ESDocument: model.ESDocument{Id: "ab12dcd8-bde0-4045-92dc-c4b27668d735"},
+		Agent:      &model.AgentMetadata{Version: "8.0.0"},
+	}
+	newBulker := func(t *testing.T) *ftesting.MockBulk {
+		m := ftesting.NewMockBulk()
+		m.On("Search", mock.Anything, mock.Anything, mock.MatchedBy(matchAction(t, actionID)), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{
+			Hits: []es.HitT{{
+				Source: []byte(`{"action_id":"` + actionID + `","type":"INPUT_ACTION"}`),
+			}},
+		}}, nil)
+		return m
+	}
+
+	t.Run("error", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := newBulker(t)
+		var result model.ActionResult
+		bulker.On("Create", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &result))
+		}).Return("", nil).Once()
+		var doc struct {
+			Script struct {
+				Source string `json:"source"`
+				Params struct {
+					Error model.LastActionError `json:"error"`
+				} `json:"params"`
+			} `json:"script"`
+		}
+		bulker.On("Update", mock.Anything, dl.FleetAgents, agent.Id, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &doc))
+		}).Return(nil).Once()
+		c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+		require.NoError(t, err)
+		failures := cntActionFailures.total.Get()
+
+		ack := NewAckT(&config.Server{}, bulker, c)
+		res, err := ack.handleAckEvents(ctx, agent, []AckRequest_Events_Item{{json.RawMessage(`{
+			"action_id": "` + actionID + `",
+			"timestamp": "2022-02-23T18:26:08.506128Z",
+			"action_input_type": "osquery",
+			"error": "query failed",
+			"error_code": "E_QUERY"
+		}`)}})
+		require.NoError(t, err)
+		assert.False(t, res.Errors)
+		bulker.AssertExpectations(t)
+
+		assert.Equal(t, "query failed", result.Error)
+		assert.Equal(t, "E_QUERY", result.ErrorCode)
+		assert.Equal(t, model.LastActionError{
+			ActionID:   actionID,
+			ActionType: "INPUT_ACTION",
+			Code:       "E_QUERY",
+			Message:    "query failed",
+			Timestamp:  "2022-02-23T18:26:08.506Z",
+		}, doc.Script.Params.Error)
+		// the error of a later event is kept
+		assert.Equal(t, lastActionErrorScript, doc.Script.Source)
+		assert.Equal(t, failures+1, cntActionFailures.total.Get())
+	})
+
+	t.Run("agent update error", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := newBulker(t)
+		bulker.On("Create", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything, mock.Anything).Return("", nil).Once()
+		bulker.On("Update", mock.Anything, dl.FleetAgents, agent.Id, mock.Anything, mock.Anything).Return(errors.New("update failed")).Once()
+		c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+		require.NoError(t, err)
+
+		ack := NewAckT(&config.Server{}, bulker, c)
+		res, err := ack.handleAckEvents(ctx, agent, []AckRequest_Events_Item{{json.RawMessage(`{
+			"action_id": "` + actionID + `",
+			"error": "query failed"
+		}`)}})
+		require.NoError(t, err)
+		assert.False(t, res.Errors)
+		bulker.AssertExpectations(t)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := newBulker(t)
+		var result model.ActionResult
+		bulker.On("Create", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &result))
+		}).Return("", nil).Once()
+		c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+		require.NoError(t, err)
+		failures := cntActionFailures.total.Get()
+
+		ack := NewAckT(&config.Server{}, bulker, c)
+		res, err := ack.handleAckEvents(ctx, agent, []AckRequest_Events_Item{{json.RawMessage(`{
+			"action_id": "` + actionID + `",
+			"error_code": "E_QUERY"
+		}`)}})
+		require.NoError(t, err)
+		assert.False(t, res.Errors)
+		bulker.AssertExpectations(t)
+		bulker.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+		assert.Empty(t, result.Error)
+		assert.Empty(t, result.ErrorCode)
+		assert.Equal(t, failures, cntActionFailures.total.Get())
+	})
+}
+
+func TestAckActionResponseRouting(t *testing.T) {
+	const actionID = "ab12dcd8-bde0-4045-92dc-c4b27668d73a"
+	agent := &model.Agent{
+		ESDocument: model.ESDocument{Id: "ab12dcd8-bde0-4045-92dc-c4b27668d735"},
+		Agent:      &model.AgentMetadata{Version: "8.0.0"},
+	}
+	resultID := actionID + ":" + agent.Id
+	// ackResponse acks the action with the response and returns the results copied to the responses indices.
+	ackResponse := func(t *testing.T, action, response string, createErr error) map[string]model.ActionResult {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, mock.Anything, mock.MatchedBy(matchAction(t, actionID)), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{
+			Hits: []es.HitT{{Source: []byte(action)}},
+		}}, nil)
+		bulker.On("Create", mock.Anything, dl.FleetActionsResults, resultID, mock.Anything, mock.Anything).Return("", nil).Once()
+		routed := map[string]model.ActionResult{}
+		bulker.On("Create", mock.Anything, mock.MatchedBy(func(index string) bool {
+			return strings.HasPrefix(index, ".logs-")
+		}), resultID, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			var acr model.ActionResult
+			require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &acr))
+			routed[args.String(1)] = acr
+		}).Return("", createErr)
+		c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+		require.NoError(t, err)
+		cfg := &config.Server{}
+		cfg.Actions.InitDefaults()
+
+		ack := NewAckT(cfg, bulker, c)
+		res, err := ack.handleAckEvents(ctx, agent, []AckRequest_Events_Item{{json.RawMessage(`{
+			"action_id": "` + actionID + `",
+			"action_input_type": "osquery",
+			"action_response": ` + response + `
+		}`)}})
+		require.NoError(t, err)
+		assert.False(t, res.Errors)
+		bulker.AssertCalled(t, "Create", mock.Anything, dl.FleetActionsResults, resultID, mock.Anything, mock.Anything)
+		return routed
+	}
+
+	t.Run("known input type", func(t *testing.T) {
+		routed := ackResponse(t, `{"action_id":"`+actionID+`","type":"INPUT_ACTION","input_type":"osquery_manager","namespaces":["default","team_a"]}`, `{"osquery":{"count":2}}`, nil)
+		require.Len(t, routed, 2)
+		for _, index := range []string{".logs-osquery_manager.action.responses-default", ".logs-osquery_manager.action.responses-team_a"} {
+			require.Contains(t, routed, index)
+			assert.Equal(t, actionID, routed[index].ActionID)
+			assert.Equal(t, agent.Id, routed[index].AgentID)
+			assert.JSONEq(t, `{"osquery":{"count":2}}`, string(routed[index].ActionResponse))
+		}
+	})
+
+	t.Run("default namespace", func(t *testing.T) {
+		routed := ackResponse(t, `{"action_id":"`+actionID+`","type":"INPUT_ACTION","input_type":"osquery_manager"}`, `{"osquery":{"count":2}}`, nil)
+		assert.Len(t, routed, 1)
+		assert.Contains(t, routed, ".logs-osquery_manager.action.responses-default")
+	})
+
+	t.Run("failed copy", func(t *testing.T) {
+		// the ack succeeds, the result is stored in the fleet action results
+		routed := ackResponse(t, `{"action_id":"`+actionID+`","type":"INPUT_ACTION","input_type":"osquery_manager"}`, `{"osquery":{"count":2}}`, errors.New("create failed"))
+		assert.Len(t, routed, 1)
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		for name, action := range map[string]string{
+			"input type": `{"action_id":"` + actionID + `","type":"INPUT_ACTION","input_type":"endpoint"}`,
+			"pattern":    `{"action_id":"` + actionID + `","type":"INPUT_ACTION","input_type":"osquery_manager.action.responses-x,logs"}`,
+			"namespace":  `{"action_id":"` + actionID + `","type":"INPUT_ACTION","input_type":"osquery_manager","namespaces":["a,b"]}`,
+		} {
+			t.Run(name, func(t *testing.T) {
+				assert.Empty(t, ackResponse(t, action, `{"osquery":{"count":2}}`, nil))
+			})
+		}
+	})
+
+	t.Run("malformed response", func(t *testing.T) {
+		for _, response := range []string{`"done"`, `[1,2]`, `null`} {
+			t.Run(response, func(t *testing.T) {
+				assert.Empty(t, ackResponse(t, `{"action_id":"`+actionID+`","type":"INPUT_ACTION","input_type":"osquery_manager"}`, response, nil))
+			})
+		}
+	})
+
+	t.Run("plain action", func(t *testing.T) {
+		assert.Empty(t, ackResponse(t, `{"action_id":"`+actionID+`","type":"INPUT_ACTION"}`, `{"osquery":{"count":2}}`, nil))
+	})
 }
 
 type searchRequestFilter struct {
@@ -379,7 +769,7 @@ func TestHandleAckEvents(t *testing.T) {
 			res: newAckResponse(true, []AckResponseItem{
 				{
 					Status:  http.StatusOK,
-					Message: ptr(http.StatusText(http.StatusOK)),
+					Message: ptr(ackPolicySuperseded),
 				},
 				{
 					Status:  http.StatusOK,
@@ -399,7 +789,7 @@ func TestHandleAckEvents(t *testing.T) {
 				},
 				{
 					Status:  http.StatusOK,
-					Message: ptr(http.StatusText(http.StatusOK)),
+					Message: ptr(ackPolicySuperseded),
 				},
 			}),
 			bulker: func(t *testing.T) *ftesting.MockBulk {
@@ -453,7 +843,7 @@ func TestHandleAckEvents(t *testing.T) {
 					}},
 				}}, nil).Once()
 				m.On("Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", nil).Once()
-				m.On("Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+				m.On("Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Twice()
 				return m
 			},
 		},
@@ -480,7 +870,7 @@ func TestHandleAckEvents(t *testing.T) {
 					}},
 				}}, nil).Once()
 				m.On("Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", nil).Once()
-				m.On("Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+				m.On("Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Twice()
 				return m
 			},
 		},
@@ -497,7 +887,7 @@ func TestHandleAckEvents(t *testing.T) {
 			bulker := tc.bulker(t)
 			ack := NewAckT(cfg, bulker, cache)
 
-			res, err := ack.handleAckEvents(ctx, logger, agent, tc.events)
+			res, err := ack.handleAckEvents(logger.WithContext(ctx), agent, tc.events)
 			assert.Equal(t, tc.res, res)
 
 			if err != nil {
@@ -519,7 +909,41 @@ func TestHandleAckEvents(t *testing.T) {
 	}
 }
 
+func TestAckMissingActionStampede(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	agent := &model.Agent{
+		ESDocument: model.ESDocument{Id: "agent-1"},
+		Agent:      &model.AgentMetadata{Version: "8.0.0"},
+	}
+	events := []AckRequest_Events_Item{{json.RawMessage(`{"action_id":"missing-action","agent_id":"agent-1"}`)}}
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	ack := NewAckT(&config.Server{}, bulker, c)
+
+	res, err := ack.handleAckEvents(ctx, agent, events)
+	require.Equal(t, &HTTPError{Status: http.StatusNotFound}, err)
+	require.Equal(t, http.StatusNotFound, res.Items[0].Status)
+	require.Eventually(t, func() bool { return c.NotFound(cache.KindAction, "missing-action") }, time.Second, time.Millisecond)
+
+	// the repeated acks of the missing action are served from the cache
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := ack.handleAckEvents(ctx, agent, events)
+			assert.Equal(t, &HTTPError{Status: http.StatusNotFound}, err)
+			assert.Equal(t, http.StatusNotFound, res.Items[0].Status)
+		}()
+	}
+	wg.Wait()
+	bulker.AssertNumberOfCalls(t, "Search", 1)
+}
+
 func TestInvalidateAPIKeys(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
 	toRetire1 := []model.ToRetireAPIKeyIdsItems{{
 		ID: "toRetire1",
 	}}
@@ -556,7 +980,7 @@ func TestInvalidateAPIKeys(t *testing.T) {
 		bulker := ftesting.NewMockBulk()
 		if len(want) > 0 {
 			bulker.On("APIKeyInvalidate",
-				context.Background(), mock.MatchedBy(func(ids []string) bool {
+				ctx, mock.MatchedBy(func(ids []string) bool {
 					// if A contains B and B contains A => A = B
 					return assert.Subset(t, ids, want) &&
 						assert.Subset(t, want, ids)
@@ -564,15 +988,15 @@ func TestInvalidateAPIKeys(t *testing.T) {
 				Return(nil)
 		}
 
-		logger := testlog.SetLogger(t)
 		ack := &AckT{bulk: bulker}
-		ack.invalidateAPIKeys(context.Background(), logger, out.ToRetireAPIKeyIds, skip)
+		ack.invalidateAPIKeys(ctx, out.ToRetireAPIKeyIds, skip)
 
 		bulker.AssertExpectations(t)
 	}
 }
 
 func TestInvalidateAPIKeysRemoteOutput(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
 	toRetire := []model.ToRetireAPIKeyIdsItems{{
 		ID:     "toRetire1",
 		Output: "remote1",
@@ -591,15 +1015,14 @@ func TestInvalidateAPIKeysRemoteOutput(t *testing.T) {
 	bulker.On("GetBulker", "remote2").Return(remoteBulker2)
 
 	remoteBulker.On("APIKeyInvalidate",
-		context.Background(), []string{"toRetire1", "toRetire11"}).
+		ctx, []string{"toRetire1", "toRetire11"}).
 		Return(nil)
 	remoteBulker2.On("APIKeyInvalidate",
-		context.Background(), []string{"toRetire2"}).
+		ctx, []string{"toRetire2"}).
 		Return(nil)
 
-	logger := testlog.SetLogger(t)
 	ack := &AckT{bulk: bulker}
-	ack.invalidateAPIKeys(context.Background(), logger, toRetire, "")
+	ack.invalidateAPIKeys(ctx, toRetire, "")
 
 	bulker.AssertExpectations(t)
 	remoteBulker.AssertExpectations(t)
@@ -607,6 +1030,7 @@ func TestInvalidateAPIKeysRemoteOutput(t *testing.T) {
 }
 
 func TestInvalidateAPIKeysRemoteOutputReadFromPolicies(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
 	toRetire := []model.ToRetireAPIKeyIdsItems{{
 		ID:     "toRetire1",
 		Output: "remote1",
@@ -614,7 +1038,7 @@ func TestInvalidateAPIKeysRemoteOutputReadFromPolicies(t *testing.T) {
 
 	remoteBulker := ftesting.NewMockBulk()
 	remoteBulker.On("APIKeyInvalidate",
-		context.Background(), []string{"toRetire1"}).
+		ctx, []string{"toRetire1"}).
 		Return(nil)
 
 	bulkerFn := func(t *testing.T) *ftesting.MockBulk {
@@ -632,15 +1056,15 @@ func TestInvalidateAPIKeysRemoteOutputReadFromPolicies(t *testing.T) {
 
 	bulker := bulkerFn(t)
 
-	logger := testlog.SetLogger(t)
 	ack := &AckT{bulk: bulker}
-	ack.invalidateAPIKeys(context.Background(), logger, toRetire, "")
+	ack.invalidateAPIKeys(ctx, toRetire, "")
 
 	bulker.AssertExpectations(t)
 	remoteBulker.AssertExpectations(t)
 }
 
 func TestInvalidateAPIKeysRemoteOutputReadFromPoliciesNotFound(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
 	toRetire := []model.ToRetireAPIKeyIdsItems{{
 		ID:     "toRetire1",
 		Output: "remote1",
@@ -660,9 +1084,8 @@ func TestInvalidateAPIKeysRemoteOutputReadFromPoliciesNotFound(t *testing.T) {
 
 	bulker := bulkerFn(t)
 
-	logger := testlog.SetLogger(t)
 	ack := &AckT{bulk: bulker}
-	ack.invalidateAPIKeys(context.Background(), logger, toRetire, "")
+	ack.invalidateAPIKeys(ctx, toRetire, "")
 
 	bulker.AssertExpectations(t)
 	remoteBulker.AssertExpectations(t)
@@ -753,13 +1176,338 @@ func TestAckHandleUpgrade(t *testing.T) {
 			bulker := tc.bulker(t)
 			ack := NewAckT(cfg, bulker, cache)
 
-			err := ack.handleUpgrade(ctx, logger, agent, tc.event)
+			err := ack.handleUpgrade(logger.WithContext(ctx), agent, model.Action{}, tc.event)
 			assert.NoError(t, err)
 			bulker.AssertExpectations(t)
 		})
 	}
 }
 
+func TestUpgradeLifecycle(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  UpgradeEvent
+		status string
+	}{{
+		name:   "ack success",
+		event:  UpgradeEvent{},
+		status: dl.UpgradeStatusCompleted,
+	}, {
+		name:   "ack failure",
+		event:  UpgradeEvent{Error: ptr("upgrade error")},
+		status: dl.UpgradeStatusFailed,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testlog.SetLogger(t).WithContext(context.Background())
+			// the fields of the agent document written by the handlers
+			doc := map[string]interface{}{}
+			bulker := ftesting.NewMockBulk()
+			bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{
+				HitsT: es.HitsT{Hits: []es.HitT{{
+					ID:     "doc-1",
+					SeqNo:  2,
+					Source: []byte(`{"action_id":"upgrade-1","type":"UPGRADE","agents":["agent-1"],"data":{"version":"8.17.0"}}`),
+				}}},
+			}, nil)
+			bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				var body struct {
+					Doc map[string]interface{} `json:"doc"`
+				}
+				require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &body))
+				for k, v := range body.Doc {
+					if v == nil {
+						delete(doc, k)
+					} else {
+						doc[k] = v
+					}
+				}
+			}).Return(nil)
+			gcp := mockmonitor.NewMockMonitor()
+			gcp.On("GetCheckpoint").Return(sqn.SeqNo{2})
+			pm := policy.NewMonitor(bulker, mockmonitor.NewMockMonitor(), config.ServerLimits{PolicyLimit: config.Limit{Interval: time.Millisecond, Burst: 1}})
+			cfg := &config.Server{}
+			cfg.InitDefaults()
+			ct, err := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, checkin.NewBulk(nil), pm, gcp, action.NewDispatcher(mockmonitor.NewMockMonitor(), 0, 1), bulker)
+			require.NoError(t, err)
+			agent := &model.Agent{
+				ESDocument:  model.ESDocument{Id: "agent-1"},
+				Agent:       &model.AgentMetadata{ID: "agent-1", Version: "8.16.0"},
+				PolicyID:    "policy-1",
+				ActionSeqNo: []int64{1},
+			}
+
+			// deliver
+			req := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", strings.NewReader(`{"status":"online"}`)).WithContext(ctx)
+			wr := httptest.NewRecorder()
+			require.NoError(t, ct.ProcessRequest(wr, req, time.Now(), agent, ""))
+			require.Equal(t, http.StatusOK, wr.Code)
+			assert.Equal(t, dl.UpgradeStatusStarted, doc[dl.FieldUpgradeStatus])
+			assert.Equal(t, "8.17.0", doc[dl.FieldUpgradeTargetVersion])
+			assert.NotEmpty(t, doc[dl.FieldUpgradeStartedAt])
+
+			// ack
+			c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+			require.NoError(t, err)
+			ack := NewAckT(cfg, bulker, c)
+			require.NoError(t, ack.handleUpgrade(ctx, agent, model.Action{}, tc.event))
+			assert.Equal(t, tc.status, doc[dl.FieldUpgradeStatus])
+			assert.Equal(t, "8.17.0", doc[dl.FieldUpgradeTargetVersion])
+			assert.NotContains(t, doc, dl.FieldUpgradeStartedAt)
+			if tc.status == dl.UpgradeStatusCompleted {
+				assert.NotEmpty(t, doc[dl.FieldUpgradedAt])
+			} else {
+				assert.NotContains(t, doc, dl.FieldUpgradedAt)
+			}
+		})
+	}
+}
+
+// A stale upgrade failure does not override the completion of the upgrade written by another writer.
+func TestAckUpgradeConflict(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything, mock.Anything).Return(&es.ErrElastic{
+		Status: http.StatusConflict,
+		Type:   "version_conflict_engine_exception",
+	}).Once()
+	bulker.On("ReadRaw", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything).Return(&bulk.MgetResponseItem{
+		SeqNo:       2,
+		PrimaryTerm: 1,
+		Source:      []byte(`{"active":true,"upgrade_status":"completed","upgraded_at":"2024-01-01T12:00:00Z"}`),
+	}, nil).Once()
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	ack := NewAckT(&config.Server{}, bulker, c)
+	agent := &model.Agent{
+		ESDocument:       model.ESDocument{Id: "agent-1", SeqNo: 1, PrimaryTerm: 1},
+		Active:           true,
+		Agent:            &model.AgentMetadata{ID: "agent-1", Version: "8.16.0"},
+		UpgradeStatus:    dl.UpgradeStatusStarted,
+		UpgradeStartedAt: "2024-01-01T11:00:00Z",
+	}
+
+	require.NoError(t, ack.handleUpgrade(ctx, agent, model.Action{}, UpgradeEvent{Error: ptr("upgrade error")}))
+	assert.Equal(t, dl.UpgradeStatusCompleted, agent.UpgradeStatus)
+	bulker.AssertExpectations(t)
+	bulker.AssertNumberOfCalls(t, "Update", 1)
+}
+
+func TestAckUpgradeRetry(t *testing.T) {
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Actions.UpgradeRetry = config.UpgradeRetry{MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: time.Hour}
+	newAck := func(t *testing.T, bulker *ftesting.MockBulk) *AckT {
+		c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+		require.NoError(t, err)
+		return NewAckT(cfg, bulker, c)
+	}
+	// recordAgent applies the updates of the agent document to doc
+	recordAgent := func(t *testing.T, bulker *ftesting.MockBulk, doc map[string]interface{}) {
+		bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			var body struct {
+				Doc map[string]interface{} `json:"doc"`
+			}
+			require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &body))
+			for k, v := range body.Doc {
+				doc[k] = v
+			}
+		}).Return(nil)
+	}
+	noNewerAction := func(bulker *ftesting.MockBulk) {
+		bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+	}
+	agent := &model.Agent{
+		ESDocument: model.ESDocument{Id: "agent-1"},
+		Agent:      &model.AgentMetadata{ID: "agent-1", Version: "8.16.0"},
+	}
+	failed := UpgradeEvent{Error: ptr("download failed")}
+	original := model.Action{
+		ESDocument: model.ESDocument{SeqNo: 2},
+		ActionID:   "upgrade-1",
+		Type:       string(UPGRADE),
+		Agents:     []string{"agent-1"},
+		Data:       json.RawMessage(`{"version":"8.17.0","source_uri":"https://artifacts.example.com"}`),
+		Timestamp:  "2024-01-01T12:00:00Z",
+		Expiration: "2024-01-01T14:00:00Z",
+	}
+
+	t.Run("two failures then success", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		doc := map[string]interface{}{}
+		bulker := ftesting.NewMockBulk()
+		recordAgent(t, bulker, doc)
+		noNewerAction(bulker)
+		var created []model.Action
+		bulker.On("Create", mock.Anything, dl.FleetActions, "", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			var a model.Action
+			require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &a))
+			a.SeqNo = int64(3 + len(created))
+			created = append(created, a)
+		}).Return("", nil)
+		ack := newAck(t, bulker)
+
+		// first attempt fails, the upgrade is re-issued after the backoff
+		before := time.Now()
+		require.NoError(t, ack.handleUpgrade(ctx, agent, original, failed))
+		require.Len(t, created, 1)
+		assert.Equal(t, dl.UpgradeStatusRetrying, doc[dl.FieldUpgradeStatus])
+		retry := created[0]
+		assert.NotEqual(t, original.ActionID, retry.ActionID)
+		assert.Equal(t, "upgrade-1", retry.RetryOf)
+		assert.Equal(t, int64(2), retry.UpgradeAttempt)
+		assert.Equal(t, []string{"agent-1"}, retry.Agents)
+		assert.JSONEq(t, string(original.Data), string(retry.Data))
+		start, err := time.Parse(time.RFC3339, retry.StartTime)
+		require.NoError(t, err)
+		assert.WithinRange(t, start, before.Add(time.Minute).Truncate(time.Second), time.Now().Add(time.Minute))
+		expiration, err := time.Parse(time.RFC3339, retry.Expiration)
+		require.NoError(t, err)
+		assert.Equal(t, 2*time.Hour, expiration.Sub(start), "the retry is valid for as long as the original action")
+
+		// second attempt fails, the backoff doubles
+		require.NoError(t, ack.handleUpgrade(ctx, agent, retry, failed))
+		require.Len(t, created, 2)
+		assert.Equal(t, dl.UpgradeStatusRetrying, doc[dl.FieldUpgradeStatus])
+		assert.Equal(t, "upgrade-1", created[1].RetryOf)
+		assert.Equal(t, int64(3), created[1].UpgradeAttempt)
+		next, err := time.Parse(time.RFC3339, created[1].StartTime)
+		require.NoError(t, err)
+		assert.WithinRange(t, next, before.Add(2*time.Minute).Truncate(time.Second), time.Now().Add(2*time.Minute))
+
+		// third attempt succeeds
+		require.NoError(t, ack.handleUpgrade(ctx, agent, created[1], UpgradeEvent{}))
+		assert.Len(t, created, 2)
+		assert.Equal(t, dl.UpgradeStatusCompleted, doc[dl.FieldUpgradeStatus])
+		assert.NotEmpty(t, doc[dl.FieldUpgradedAt])
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		doc := map[string]interface{}{}
+		bulker := ftesting.NewMockBulk()
+		recordAgent(t, bulker, doc)
+		last := original
+		last.ActionID = "retry-2"
+		last.RetryOf = "upgrade-1"
+		last.UpgradeAttempt = 3
+
+		require.NoError(t, newAck(t, bulker).handleUpgrade(ctx, agent, last, failed))
+		assert.Equal(t, dl.UpgradeStatusUpgradeFailed, doc[dl.FieldUpgradeStatus])
+		bulker.AssertNotCalled(t, "Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		bulker.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("superseded by a newer upgrade", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		doc := map[string]interface{}{}
+		bulker := ftesting.NewMockBulk()
+		recordAgent(t, bulker, doc)
+		bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{
+			HitsT: es.HitsT{Hits: []es.HitT{{
+				ID:     "doc-2",
+				SeqNo:  5,
+				Source: []byte(`{"action_id":"upgrade-2","type":"UPGRADE","data":{"version":"8.18.0"}}`),
+			}}},
+		}, nil).Once()
+
+		require.NoError(t, newAck(t, bulker).handleUpgrade(ctx, agent, original, failed))
+		assert.Equal(t, dl.UpgradeStatusFailed, doc[dl.FieldUpgradeStatus])
+		bulker.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		query := string(bulker.Calls[0].Arguments.Get(2).([]byte))
+		assert.Contains(t, query, `"agents":"agent-1"`)
+		assert.Contains(t, query, `"gt":2`)
+		// the other documents of the failed action are not newer actions
+		assert.Contains(t, query, `"must_not":[{"term":{"action_id":"upgrade-1"}}]`)
+	})
+
+	t.Run("agent retrying itself", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		doc := map[string]interface{}{}
+		bulker := ftesting.NewMockBulk()
+		recordAgent(t, bulker, doc)
+		event := failed
+		event.Payload = &struct {
+			Retry        bool `json:"retry"`
+			RetryAttempt int  `json:"retry_attempt"`
+		}{Retry: true, RetryAttempt: 1}
+
+		require.NoError(t, newAck(t, bulker).handleUpgrade(ctx, agent, original, event))
+		assert.Equal(t, dl.UpgradeStatusRetrying, doc[dl.FieldUpgradeStatus])
+		bulker.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestAckUnenrollState(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	bulker := ftesting.NewMockBulk()
+	var docs []map[string]interface{}
+	bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var body struct {
+			Doc map[string]interface{} `json:"doc"`
+		}
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &body))
+		docs = append(docs, body.Doc)
+	}).Return(nil).Twice()
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	ack := NewAckT(cfg, bulker, c, WithAckCheckin(checkin.NewBulk(bulker)))
+
+	agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}, Active: true, LifecycleState: string(model.AgentStateOnline)}
+	require.NoError(t, ack.handleUnenroll(ctx, agent))
+	require.Len(t, docs, 2)
+	assert.Equal(t, string(model.AgentStateUnenrolled), docs[0][dl.FieldLifecycleState])
+	assert.Equal(t, false, docs[0][dl.FieldActive])
+	assert.Equal(t, true, docs[0][dl.FieldAPIKeysInvalidationPending])
+	// the agent has no API keys, the invalidation completes right away
+	assert.Contains(t, docs[1], dl.FieldAPIKeysInvalidationPending)
+	assert.Nil(t, docs[1][dl.FieldAPIKeysInvalidationPending])
+	assert.NotEmpty(t, docs[1][dl.FieldAPIKeysInvalidatedAt])
+	bulker.AssertExpectations(t)
+}
+
+func TestAckUnenrollFailures(t *testing.T) {
+	agent := func() *model.Agent {
+		return &model.Agent{
+			ESDocument:     model.ESDocument{Id: "agent-1"},
+			Active:         true,
+			LifecycleState: string(model.AgentStateOnline),
+			AccessAPIKeyID: "access-key",
+		}
+	}
+	newAck := func(t *testing.T, bulker *ftesting.MockBulk) *AckT {
+		c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+		require.NoError(t, err)
+		cfg := &config.Server{}
+		cfg.InitDefaults()
+		return NewAckT(cfg, bulker, c, WithAckCheckin(checkin.NewBulk(bulker)))
+	}
+
+	t.Run("agent update fails", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything, mock.Anything).Return(errors.New("update failed")).Once()
+
+		// the agent is unchanged and its keys are live, the ack fails so the agent retries it
+		require.Error(t, newAck(t, bulker).handleUnenroll(ctx, agent()))
+		bulker.AssertExpectations(t)
+		bulker.AssertNotCalled(t, "APIKeyInvalidate", mock.Anything, mock.Anything)
+	})
+
+	t.Run("invalidation fails", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything, mock.Anything).Return(nil).Once()
+		bulker.On("APIKeyInvalidate", mock.Anything, []string{"access-key"}).Return(errors.New("invalidate failed")).Once()
+
+		// the agent is unenrolled with its keys pending, the GC retries the invalidation
+		require.NoError(t, newAck(t, bulker).handleUnenroll(ctx, agent()))
+		bulker.AssertExpectations(t)
+	})
+}
+
 func TestValidateAckRequest(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -786,7 +1534,7 @@ func TestValidateAckRequest(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			wr := httptest.NewRecorder()
 			ack := NewAckT(tc.cfg, nil, nil)
-			ackRes, err := ack.validateRequest(logger, wr, tc.req)
+			ackRes, err := ack.validateRequest(wr, tc.req.WithContext(logger.WithContext(context.Background())))
 			if tc.expErr == nil {
 				assert.NoError(t, err)
 			} else {
@@ -800,3 +1548,271 @@ func TestValidateAckRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestAckRequestLogContext(t *testing.T) {
+	var buf bytes.Buffer
+	zlog := zerolog.New(&buf).Level(zerolog.DebugLevel)
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{Enabled: true}, nil)
+	bulker.On("ReadRaw", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything).Return(&bulk.MgetResponseItem{
+		Found:  true,
+		Source: []byte(`{"access_api_key_id":"id","active":true,"agent":{"id":"agent-1"},"policy_id":"policy-1","policy_revision_idx":2}`),
+	}, nil)
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	agents := seen.NewTracker()
+	ack := NewAckT(cfg, bulker, c, WithAckSeenAgents(agents))
+
+	h := logger.Middleware(routeLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, ack.handleAcks(w, r, "agent-1"))
+	})))
+	body := `{"events":[{"action_id":"policy:policy-1:1","agent_id":"agent-1","type":"ACTION_RESULT","subtype":"ACKNOWLEDGED","message":"policy acked","timestamp":"2024-01-01T00:00:00Z"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/acks", strings.NewReader(body))
+	req = req.WithContext(zlog.WithContext(req.Context()))
+	req.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+	req.Header.Set(logger.HeaderRequestID, "request-1")
+	wr := httptest.NewRecorder()
+	h.ServeHTTP(wr, req)
+	require.Equal(t, http.StatusOK, wr.Code)
+
+	// the line is logged by handlePolicyChange
+	var line map[string]any
+	for _, l := range bytes.Split(buf.Bytes(), []byte("\n")) {
+		if bytes.Contains(l, []byte(`"ack policy revision"`)) {
+			require.NoError(t, json.Unmarshal(l, &line))
+		}
+	}
+	require.NotNil(t, line, "no log line from handlePolicyChange in:\n%s", buf.String())
+	assert.Equal(t, "request-1", line[logger.ECSHTTPRequestID])
+	assert.Equal(t, "acks", line[logger.Route])
+	assert.Equal(t, "agent-1", line[logger.AgentID])
+	assert.Equal(t, "id", line[logger.AccessAPIKeyID])
+	assert.Equal(t, []any{"policy:policy-1:1"}, line[logger.ActionID])
+
+	// the authenticated agent is counted as seen
+	assert.Equal(t, uint64(1), agents.Count(time.Minute))
+}
+
+func TestAckStalledActionLookup(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	bulker := ftesting.NewMockBulk()
+	bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{Enabled: true}, nil)
+	bulker.On("ReadRaw", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything).Return(&bulk.MgetResponseItem{
+		Found:  true,
+		Source: []byte(`{"access_api_key_id":"id","active":true,"agent":{"id":"agent-1"},"policy_id":"policy-1"}`),
+	}, nil)
+	// elasticsearch does not answer until the request is given up
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	}).Return(&es.ResultT{}, context.DeadlineExceeded)
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Budgets.Ack.Total = 200 * time.Millisecond
+	ack := NewAckT(cfg, bulker, c)
+
+	body := `{"events":[{"action_id":"action-1","agent_id":"agent-1","type":"ACTION_RESULT","subtype":"ACKNOWLEDGED","timestamp":"2024-01-01T00:00:00Z"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/acks", strings.NewReader(body)).WithContext(ctx)
+	req.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+	wr := httptest.NewRecorder()
+	start := time.Now()
+	require.NoError(t, ack.handleAcks(wr, req, "agent-1"))
+	require.Less(t, time.Since(start), time.Second, "the lookup fails once the read budget is exceeded")
+
+	require.Equal(t, http.StatusServiceUnavailable, wr.Code)
+	var resp AckResponse
+	require.NoError(t, json.Unmarshal(wr.Body.Bytes(), &resp))
+	require.True(t, resp.Errors)
+	require.Len(t, resp.Items, 1)
+	require.Equal(t, http.StatusServiceUnavailable, resp.Items[0].Status)
+}
+
+// readAgentBulk reads the current document of the agent, the mock returns static values.
+type readAgentBulk struct {
+	*ftesting.MockBulk
+	doc func() []byte
+}
+
+func (b *readAgentBulk) ReadRaw(_ context.Context, _, _ string, _ ...bulk.Opt) (*bulk.MgetResponseItem, error) {
+	return &bulk.MgetResponseItem{Found: true, Source: b.doc()}, nil
+}
+
+func TestAckConcurrentPolicyRevisions(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	// the agent document, the updates are applied without the revision check of the script
+	var mx sync.Mutex
+	rev := int64(1)
+	agentDoc := func() []byte {
+		mx.Lock()
+		defer mx.Unlock()
+		return []byte(fmt.Sprintf(`{"access_api_key_id":"id","active":true,"agent":{"id":"agent-1"},"policy_id":"policy-1","policy_revision_idx":%d}`, rev))
+	}
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{Enabled: true}, nil)
+	bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var body struct {
+			Script struct {
+				Params struct {
+					Rev int64 `json:"rev"`
+				} `json:"params"`
+			} `json:"script"`
+		}
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &body))
+		// a slow write leaves the time for another ack to read the agent
+		time.Sleep(time.Millisecond)
+		mx.Lock()
+		rev = body.Script.Params.Rev
+		mx.Unlock()
+	}).Return(nil)
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	ack := NewAckT(cfg, &readAgentBulk{MockBulk: bulker, doc: agentDoc}, c)
+
+	const maxRev = 20
+	var wg sync.WaitGroup
+	for i := maxRev; i > 1; i-- {
+		wg.Add(1)
+		go func(rev int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"events":[{"action_id":"policy:policy-1:%d","agent_id":"agent-1","type":"ACTION_RESULT","subtype":"ACKNOWLEDGED","timestamp":"2024-01-01T00:00:00Z"}]}`, rev)
+			req := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/acks", strings.NewReader(body)).WithContext(ctx)
+			req.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+			wr := httptest.NewRecorder()
+			assert.NoError(t, ack.handleAcks(wr, req, "agent-1"))
+			assert.Equal(t, http.StatusOK, wr.Code)
+		}(i)
+	}
+	wg.Wait()
+
+	require.Equal(t, int64(maxRev), rev, "the highest acked revision is kept")
+	require.Zero(t, ack.locks.len())
+}
+
+func TestAckPolicyReadAfterWrite(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	tr := newAgentsTransport()
+	bulker := runAgentsBulker(t, tr)
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	_, err = createFleetAgent(ctx, bulker, "agent-1", model.Agent{Active: true, AccessAPIKeyID: "key-1", PolicyID: "policy-1", PolicyRevisionIdx: 1, Agent: &model.AgentMetadata{ID: "agent-1"}})
+	require.NoError(t, err)
+
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	ack := NewAckT(cfg, bulker, c)
+	agent, err := getAgentAndVerifyAPIKeyID(ctx, bulker, c, "agent-1", "key-1")
+	require.NoError(t, err)
+	outcomes, err := ack.handlePolicyChange(ctx, agent, "policy:policy-1:3:1")
+	require.NoError(t, err)
+	require.Equal(t, []string{""}, outcomes)
+	require.Zero(t, tr.refreshes, "the acked revision is written without a refresh")
+
+	// the next checkin reads the acked revision, and so does the policy fetch authenticated by the API key
+	agent, err = getAgentAndVerifyAPIKeyID(ctx, bulker, c, "agent-1", "key-1")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), agent.PolicyRevisionIdx)
+	v, ok := c.GetAgentVersion("agent-1")
+	require.True(t, ok)
+	require.Equal(t, v.SeqNo, agent.SeqNo)
+	agent, err = findAgentByAPIKeyID(ctx, bulker, c, "key-1")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), agent.PolicyRevisionIdx)
+}
+
+func TestAckPolicyChangeOutcomes(t *testing.T) {
+	tests := []struct {
+		name     string
+		acks     []string
+		messages []string
+		updated  bool
+	}{{
+		name:     "newer revision",
+		acks:     []string{"policy:policy-1:3:1"},
+		messages: []string{http.StatusText(http.StatusOK)},
+		updated:  true,
+	}, {
+		name:     "superseded policy",
+		acks:     []string{"policy:policy-0:5:1"},
+		messages: []string{ackPolicySuperseded},
+	}, {
+		name:     "stale revision",
+		acks:     []string{"policy:policy-1:1:1"},
+		messages: []string{ackPolicyRevisionStale},
+	}, {
+		name:     "mixed",
+		acks:     []string{"policy:policy-0:5:1", "policy:policy-1:1:1", "policy:policy-1:3:1"},
+		messages: []string{ackPolicySuperseded, ackPolicyRevisionStale, http.StatusText(http.StatusOK)},
+		updated:  true,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testlog.SetLogger(t).WithContext(context.Background())
+			// the agent was reassigned from policy-0 to policy-1, and acked its revision 2
+			agentDoc := func() []byte {
+				return []byte(`{"access_api_key_id":"id","active":true,"agent":{"id":"agent-1"},"policy_id":"policy-1","policy_revision_idx":2}`)
+			}
+			agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}, Active: true, PolicyID: "policy-1", PolicyRevisionIdx: 2, Agent: &model.AgentMetadata{ID: "agent-1"}}
+			bulker := ftesting.NewMockBulk()
+			if tc.updated {
+				bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything, mock.Anything).Return(nil).Once()
+			}
+			c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+			require.NoError(t, err)
+			cfg := &config.Server{}
+			cfg.InitDefaults()
+			ack := NewAckT(cfg, &readAgentBulk{MockBulk: bulker, doc: agentDoc}, c)
+
+			events := make([]AckRequest_Events_Item, len(tc.acks))
+			for i, actionID := range tc.acks {
+				events[i] = AckRequest_Events_Item{json.RawMessage(fmt.Sprintf(`{"action_id":%q,"agent_id":"agent-1"}`, actionID))}
+			}
+			res, err := ack.handleAckEvents(ctx, agent, events)
+			require.NoError(t, err)
+			require.False(t, res.Errors)
+			for i, item := range res.Items {
+				require.Equal(t, http.StatusOK, item.Status)
+				require.Equal(t, tc.messages[i], *item.Message)
+			}
+			bulker.AssertExpectations(t)
+			if !tc.updated {
+				bulker.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestAckUnknownActionType(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}, Agent: &model.AgentMetadata{ID: "agent-1"}}
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Create", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything, mock.Anything).Return("", nil).Twice()
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	for id, actionType := range map[string]string{"custom": "CUSTOM_ACTION", "settings": string(SETTINGS)} {
+		bulker.On("Search", mock.Anything, mock.Anything, mock.MatchedBy(matchAction(t, id)), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{
+			Hits: []es.HitT{{Source: []byte(`{"action_id":"` + id + `","type":"` + actionType + `"}`)}},
+		}}, nil)
+	}
+	ack := NewAckT(&config.Server{}, bulker, c)
+
+	// the result of the unknown action is recorded, the ack tells it is not otherwise handled
+	res, err := ack.handleAckEvents(ctx, agent, []AckRequest_Events_Item{
+		{json.RawMessage(`{"action_id":"custom","agent_id":"agent-1"}`)},
+		{json.RawMessage(`{"action_id":"settings","agent_id":"agent-1"}`)},
+	})
+	require.NoError(t, err)
+	require.False(t, res.Errors)
+	require.Equal(t, http.StatusOK, res.Items[0].Status)
+	require.Equal(t, ackActionTypeUnsupported, *res.Items[0].Message)
+	require.Equal(t, http.StatusOK, res.Items[1].Status)
+	require.Equal(t, http.StatusText(http.StatusOK), *res.Items[1].Message)
+	bulker.AssertExpectations(t)
+}
@@ -0,0 +1,199 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/gc"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+const (
+	actionStatusAcked   = "acked"
+	actionStatusFailed  = "failed"
+	actionStatusPending = "pending"
+	actionStatusExpired = "expired"
+
+	defaultActionResultsPageSize = 100
+	maxActionResultsPageSize     = 1000
+)
+
+var ErrActionNotFound = errors.New("action not found")
+
+func (act *ActionsT) handleResults(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, id string, params GetActionResultsParams) error {
+	info, err := authServiceToken(r, act.bulk)
+	if err != nil {
+		return err
+	}
+	zlog = zlog.With().Str("userName", info.UserName).Logger()
+
+	withAgents := params.Agents != nil && *params.Agents
+	from, size, err := actionResultsPage(params)
+	if err != nil {
+		return err
+	}
+
+	resp, err := act.actionResults(r.Context(), id, withAgents, from, size)
+	if err != nil {
+		return err
+	}
+	zlog.Trace().
+		Int("total", resp.Total).
+		Int("pending", resp.Pending).
+		Msg("Action results")
+
+	span, _ := apm.StartSpan(r.Context(), "response", "write")
+	defer span.End()
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("actionResults marshal: %w", err)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(data)
+	cntActionResults.bodyOut.Add(uint64(len(data)))
+	return err
+}
+
+// actionResultsPage returns the offset and size of the page of agents requested by params.
+func actionResultsPage(params GetActionResultsParams) (int, int, error) {
+	from, size := 0, defaultActionResultsPageSize
+	if params.From != nil {
+		from = *params.From
+	}
+	if params.Size != nil {
+		size = *params.Size
+	}
+	if from < 0 {
+		return 0, 0, &BadRequestErr{msg: "action results from must not be negative"}
+	}
+	if size < 1 || size > maxActionResultsPageSize {
+		return 0, 0, &BadRequestErr{msg: fmt.Sprintf("action results size must be between 1 and %d", maxActionResultsPageSize)}
+	}
+	return from, size, nil
+}
+
+// actionResults aggregates the latest result of each agent the action targets.
+// The agents are ordered by ID over the target list of the action, not over the results, so a page holds the same
+// agents while results are written and an agent only moves from pending to another status.
+func (act *ActionsT) actionResults(ctx context.Context, id string, withAgents bool, from, size int) (*ActionResultsResponse, error) {
+	span, ctx := apm.StartSpan(ctx, "actionResults", "search")
+	defer span.End()
+
+	actions, err := dl.FindActionTargets(ctx, act.bulk, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrActionNotFound, id)
+	}
+	targets, expired := actionTargets(actions, time.Now())
+
+	results, err := dl.FindLatestActionResults(ctx, act.bulk, id)
+	if err != nil {
+		return nil, err
+	}
+	byAgent := make(map[string]model.ActionResult, len(results))
+	for _, res := range results {
+		byAgent[res.AgentID] = res
+	}
+
+	resp := &ActionResultsResponse{
+		ActionId: id,
+		Total:    len(targets),
+	}
+	var agents []ActionAgentResult
+	for i, agentID := range targets {
+		res, ok := byAgent[agentID]
+		status := actionResultStatus(res, ok, expired)
+		switch status {
+		case actionStatusAcked:
+			resp.Acked++
+		case actionStatusFailed:
+			resp.Failed++
+		case actionStatusExpired:
+			resp.Expired++
+		default:
+			resp.Pending++
+		}
+		if !withAgents || i < from || i >= from+size {
+			continue
+		}
+		agents = append(agents, actionAgentResult(agentID, status, res))
+	}
+	if withAgents {
+		if agents == nil {
+			agents = []ActionAgentResult{}
+		}
+		resp.Agents = &agents
+		if next := from + size; next < len(targets) {
+			resp.NextFrom = &next
+		}
+	}
+	return resp, nil
+}
+
+// actionTargets returns the sorted IDs of the agents targeted by the documents of an action,
+// and whether the action expired at now.
+func actionTargets(actions []model.Action, now time.Time) ([]string, bool) {
+	var targets []string
+	expired := false
+	for _, action := range actions {
+		targets = append(targets, action.Agents...)
+		if action.Expiration == "" {
+			continue
+		}
+		if exp, err := ftime.Parse(action.Expiration); err == nil && !exp.After(now) {
+			expired = true
+		}
+	}
+	slices.Sort(targets)
+	return slices.Compact(targets), expired
+}
+
+// actionResultStatus returns the status of an action for an agent from its latest result, if the agent has one.
+func actionResultStatus(res model.ActionResult, ok, expired bool) string {
+	switch {
+	case !ok && expired:
+		return actionStatusExpired
+	case !ok:
+		return actionStatusPending
+	case res.Status == gc.ActionResultStatusExpired:
+		return actionStatusExpired
+	case res.Error != "":
+		return actionStatusFailed
+	default:
+		return actionStatusAcked
+	}
+}
+
+func actionAgentResult(agentID, status string, res model.ActionResult) ActionAgentResult {
+	result := ActionAgentResult{
+		AgentId: agentID,
+		Status:  status,
+	}
+	if res.Error != "" {
+		result.Error = &res.Error
+	}
+	completedAt := res.CompletedAt
+	if completedAt == "" {
+		completedAt = res.Timestamp
+	}
+	if t, err := ftime.Parse(completedAt); err == nil {
+		result.CompletedAt = &t
+	}
+	return result
+}
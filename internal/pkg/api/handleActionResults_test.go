@@ -0,0 +1,159 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func actionHits(t *testing.T, actions ...model.Action) *es.ResultT {
+	hits := make([]es.HitT, len(actions))
+	for i, action := range actions {
+		body, err := json.Marshal(action)
+		require.NoError(t, err)
+		hits[i] = es.HitT{ID: action.ActionID, Source: body}
+	}
+	return &es.ResultT{HitsT: es.HitsT{Hits: hits}}
+}
+
+// latestResults returns the aggregation of the latest results of the agents.
+func latestResults(t *testing.T, results ...model.ActionResult) *es.ResultT {
+	buckets := make([]es.Bucket, len(results))
+	for i, res := range results {
+		body, err := json.Marshal(res)
+		require.NoError(t, err)
+		buckets[i] = es.Bucket{
+			Key:          res.AgentID,
+			DocCount:     1,
+			Aggregations: map[string]es.HitsT{dl.FieldTimestamp: {Hits: []es.HitT{{Source: body}}}},
+		}
+	}
+	return &es.ResultT{Aggregations: map[string]es.Aggregation{dl.FieldActionResultAgentID: {Buckets: buckets}}}
+}
+
+func actionResultsRouter(t *testing.T, bulker *ftesting.MockBulk) http.Handler {
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	mockServiceToken(t, bulker)
+	return newRouter(cfg, &apiServer{act: NewActionsT(cfg, bulker, nil)}, nil, nil)
+}
+
+func getActionResults(t *testing.T, hr http.Handler, target string) (int, ActionResultsResponse) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r = r.WithContext(testlog.SetLogger(t).WithContext(context.Background()))
+	r.Header.Set("Authorization", testServiceToken)
+	hr.ServeHTTP(w, r)
+	var resp ActionResultsResponse
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	}
+	return w.Code, resp
+}
+
+func Test_ActionResults_partial(t *testing.T) {
+	completedAt := time.Date(2025, 3, 14, 10, 0, 0, 0, time.UTC)
+	bulker := ftesting.NewMockBulk()
+	// the targets are split in two documents
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(actionHits(t,
+		model.Action{ActionID: "action-1", Agents: []string{"agent-4", "agent-1", "agent-3"}, Expiration: time.Now().Add(time.Hour).Format(time.RFC3339)},
+		model.Action{ActionID: "action-1", Agents: []string{"agent-2", "agent-5"}, Expiration: time.Now().Add(time.Hour).Format(time.RFC3339)},
+	), nil)
+	bulker.On("Search", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything).Return(latestResults(t,
+		model.ActionResult{ActionID: "action-1", AgentID: "agent-1", CompletedAt: completedAt.Format(time.RFC3339)},
+		model.ActionResult{ActionID: "action-1", AgentID: "agent-3", Error: "download failed", Timestamp: completedAt.Format(time.RFC3339)},
+	), nil)
+	hr := actionResultsRouter(t, bulker)
+
+	code, resp := getActionResults(t, hr, "/api/fleet/agents/actions/action-1/results")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, ActionResultsResponse{ActionId: "action-1", Total: 5, Acked: 1, Failed: 1, Pending: 3}, resp)
+
+	// the agents are paged in the order of their IDs
+	code, resp = getActionResults(t, hr, "/api/fleet/agents/actions/action-1/results?agents=true&size=3")
+	require.Equal(t, http.StatusOK, code)
+	require.NotNil(t, resp.Agents)
+	agents := *resp.Agents
+	require.Len(t, agents, 3)
+	require.Equal(t, ActionAgentResult{AgentId: "agent-1", Status: actionStatusAcked, CompletedAt: &completedAt}, agents[0])
+	require.Equal(t, ActionAgentResult{AgentId: "agent-2", Status: actionStatusPending}, agents[1])
+	require.Equal(t, "agent-3", agents[2].AgentId)
+	require.Equal(t, actionStatusFailed, agents[2].Status)
+	require.Equal(t, "download failed", *agents[2].Error)
+	require.NotNil(t, resp.NextFrom)
+	require.Equal(t, 3, *resp.NextFrom)
+
+	code, resp = getActionResults(t, hr, "/api/fleet/agents/actions/action-1/results?agents=true&from=3&size=3")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, *resp.Agents, 2)
+	require.Equal(t, "agent-4", (*resp.Agents)[0].AgentId)
+	require.Equal(t, "agent-5", (*resp.Agents)[1].AgentId)
+	require.Nil(t, resp.NextFrom)
+
+	code, _ = getActionResults(t, hr, "/api/fleet/agents/actions/action-1/results?agents=true&size=1001")
+	require.Equal(t, http.StatusBadRequest, code)
+}
+
+func Test_ActionResults_expired(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(actionHits(t,
+		model.Action{ActionID: "action-1", Agents: []string{"agent-1", "agent-2", "agent-3"}, Expiration: time.Now().Add(-time.Hour).Format(time.RFC3339)},
+	), nil)
+	// the GC records the actions that expired before they were delivered
+	bulker.On("Search", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything).Return(latestResults(t,
+		model.ActionResult{ActionID: "action-1", AgentID: "agent-1", Status: "expired"},
+	), nil)
+	hr := actionResultsRouter(t, bulker)
+
+	code, resp := getActionResults(t, hr, "/api/fleet/agents/actions/action-1/results?agents=true")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, 3, resp.Total)
+	require.Equal(t, 3, resp.Expired)
+	require.Zero(t, resp.Pending)
+	require.Len(t, *resp.Agents, 3)
+	for _, agent := range *resp.Agents {
+		require.Equal(t, actionStatusExpired, agent.Status)
+	}
+}
+
+func Test_ActionResults_notFound(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(actionHits(t), nil)
+	hr := actionResultsRouter(t, bulker)
+
+	code, _ := getActionResults(t, hr, "/api/fleet/agents/actions/action-1/results")
+	require.Equal(t, http.StatusNotFound, code)
+	bulker.AssertNotCalled(t, "Search", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything)
+}
+
+func Test_ActionResults_apiKey(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	hr := actionResultsRouter(t, bulker)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/fleet/agents/actions/action-1/results", nil)
+	r = r.WithContext(testlog.SetLogger(t).WithContext(context.Background()))
+	r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+	hr.ServeHTTP(w, r)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	bulker.AssertNotCalled(t, "Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
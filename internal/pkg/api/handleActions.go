@@ -0,0 +1,332 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/miolini/datacounter"
+	"github.com/rs/zerolog"
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/action"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+const (
+	kActionsMod = "actions"
+
+	// actionAgentsChunkSize is the max number of agents a single action document targets.
+	actionAgentsChunkSize = 1000
+	// defaultActionExpiration is used when a create request does not specify an expiration.
+	// Actions without an expiration are never delivered to agents.
+	defaultActionExpiration = 30 * 24 * time.Hour
+)
+
+var ErrActionTargets = errors.New("invalid action targets")
+
+type ActionsT struct {
+	cfg      *config.Server
+	reloaded reloadedCfg
+	bulk     bulk.Bulk
+	cache    cache.Cache
+}
+
+// Reload applies the reloaded server configuration to the requests handled from now on.
+func (act *ActionsT) Reload(cfg *config.Server) {
+	act.reloaded.p.Store(cfg)
+}
+
+// serverCfg returns the server configuration of the requests, as last reloaded.
+func (act *ActionsT) serverCfg() *config.Server {
+	return act.reloaded.get(act.cfg)
+}
+
+func NewActionsT(cfg *config.Server, bulker bulk.Bulk, cache cache.Cache) *ActionsT {
+	return &ActionsT{
+		cfg:   cfg,
+		bulk:  bulker,
+		cache: cache,
+	}
+}
+
+func (act *ActionsT) handleCreate(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request) error {
+	info, err := authAdmin(r, act.bulk)
+	if err != nil {
+		return err
+	}
+	zlog = zlog.With().Str("userName", info.UserName).Logger()
+	r = r.WithContext(zlog.WithContext(r.Context()))
+
+	req, err := act.validateCreateRequest(zlog, w, r)
+	if err != nil {
+		return err
+	}
+
+	auditDetail(r.Context(), "type", req.Type)
+	if req.PolicyId != nil && *req.PolicyId != "" {
+		auditTargets(r.Context(), nil, []string{*req.PolicyId})
+	}
+	if req.Tag != nil && *req.Tag != "" {
+		auditDetail(r.Context(), "tag", *req.Tag)
+	}
+
+	var (
+		agents []string
+		ids    []string
+	)
+	if req.Target != nil {
+		// the agents matching the filter are resolved as they check in
+		target := actionTarget(req.Target)
+		auditDetail(r.Context(), "target", target)
+		ids, err = act.createTargetedAction(r.Context(), zlog, req, info.UserName, target)
+	} else {
+		agents, err = act.expandTargets(r.Context(), req)
+		if err != nil {
+			return err
+		}
+		auditTargets(r.Context(), agents, nil)
+		ids, err = act.createActions(r.Context(), zlog, req, info.UserName, agents)
+	}
+	// the actions created before a failure are recorded as well
+	auditDetail(r.Context(), "action_ids", ids)
+	if err != nil {
+		return err
+	}
+	// the unenrollment starts once the action exists, an agent is never left unenrolling without an action to ack
+	if req.Type == string(UNENROLL) {
+		if err := act.markUnenrolling(r.Context(), zlog, agents); err != nil {
+			return err
+		}
+	}
+
+	span, _ := apm.StartSpan(r.Context(), "response", "write")
+	defer span.End()
+	data, err := json.Marshal(&CreateActionsAPIResponse{ActionIds: ids})
+	if err != nil {
+		return fmt.Errorf("createActions marshal: %w", err)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(data)
+	cntCreateActions.bodyOut.Add(uint64(len(data)))
+	return err
+}
+
+func (act *ActionsT) validateCreateRequest(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request) (*CreateActionsRequest, error) {
+	span, _ := apm.StartSpan(r.Context(), "validateRequest", "validate")
+	defer span.End()
+
+	body := r.Body
+	if act.serverCfg().Limits.CreateActionsLimit.MaxBody > 0 {
+		body = http.MaxBytesReader(w, body, act.serverCfg().Limits.CreateActionsLimit.MaxBody)
+	}
+	readCounter := datacounter.NewReaderCounter(body)
+
+	var req CreateActionsRequest
+	if err := decodeRequest(r.Context(), readCounter, &req, "create actions"); err != nil {
+		return nil, err
+	}
+	cntCreateActions.bodyIn.Add(readCounter.Count())
+
+	if req.Type == "" {
+		return nil, &BadRequestErr{msg: "create actions request missing type"}
+	}
+	if deliver, _, _ := actionDelivery(req.Type); !deliver {
+		return nil, &BadRequestErr{msg: fmt.Sprintf("create actions request invalid type %q", req.Type)}
+	}
+	if req.Expiration != nil && !req.Expiration.After(time.Now()) {
+		return nil, &BadRequestErr{msg: "create actions request expiration is in the past"}
+	}
+
+	targets := 0
+	hasAgents := req.Agents != nil && len(*req.Agents) > 0
+	for _, has := range []bool{hasAgents, req.PolicyId != nil && *req.PolicyId != "", req.Tag != nil && *req.Tag != "", req.Target != nil} {
+		if has {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return nil, fmt.Errorf("%w: exactly one of agents, policy_id, tag or target must be specified", ErrActionTargets)
+	}
+	if hasAgents && len(*req.Agents) > act.serverCfg().Limits.MaxActionTargets {
+		return nil, fmt.Errorf("%w: %d agents exceeds the max of %d", ErrActionTargets, len(*req.Agents), act.serverCfg().Limits.MaxActionTargets)
+	}
+	if req.Target != nil {
+		if err := action.ValidateTarget(actionTarget(req.Target)); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrActionTargets, err)
+		}
+		// the unenrollment of the agents starts when the action is created
+		if req.Type == string(UNENROLL) {
+			return nil, fmt.Errorf("%w: %s actions can't target a filter", ErrActionTargets, req.Type)
+		}
+	}
+
+	zlog.Trace().Str(logger.ActionType, req.Type).Msg("Create actions request")
+	return &req, nil
+}
+
+// expandTargets returns the agent IDs the request targets.
+// Policy and tag targets are capped at the configured max, a policy or tag with more agents is rejected.
+func (act *ActionsT) expandTargets(ctx context.Context, req *CreateActionsRequest) ([]string, error) {
+	if req.Agents != nil && len(*req.Agents) > 0 {
+		return *req.Agents, nil
+	}
+
+	span, ctx := apm.StartSpan(ctx, "expandTargets", "search")
+	defer span.End()
+
+	maxTargets := act.serverCfg().Limits.MaxActionTargets
+	var (
+		agents []string
+		target string
+		err    error
+	)
+	if req.Tag != nil && *req.Tag != "" {
+		target = "tag " + *req.Tag
+		agents, err = dl.FindActiveAgentIDsByTag(ctx, act.bulk, *req.Tag, maxTargets+1)
+	} else {
+		target = "policy " + *req.PolicyId
+		agents, err = dl.FindActiveAgentIDsByPolicyID(ctx, act.bulk, *req.PolicyId, maxTargets+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(agents) == 0 {
+		return nil, fmt.Errorf("%w: no active agents found for %s", ErrActionTargets, target)
+	}
+	if len(agents) > maxTargets {
+		return nil, fmt.Errorf("%w: %s has more than %d agents", ErrActionTargets, target, maxTargets)
+	}
+	return agents, nil
+}
+
+// actionTarget converts the target filter of a create request to the one stored on the action document.
+func actionTarget(t *ActionTarget) *model.ActionTarget {
+	target := &model.ActionTarget{}
+	if t.PolicyId != nil {
+		target.PolicyID = *t.PolicyId
+	}
+	if t.Tags != nil {
+		target.Tags = *t.Tags
+	}
+	if t.Version != nil {
+		target.Version = *t.Version
+	}
+	return target
+}
+
+// createTargetedAction writes a single action document that targets the agents matching the filter.
+func (act *ActionsT) createTargetedAction(ctx context.Context, zlog zerolog.Logger, req *CreateActionsRequest, userID string, target *model.ActionTarget) ([]string, error) {
+	span, ctx := apm.StartSpan(ctx, "createTargetedAction", "create")
+	defer span.End()
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("createActions uuid: %w", err)
+	}
+	a := act.newAction(req, userID, time.Now().UTC())
+	a.ActionID = u.String()
+	a.Target = target
+	if _, err := dl.CreateAction(ctx, act.bulk, a); err != nil {
+		return nil, fmt.Errorf("createActions create: %w", err)
+	}
+	zlog.Info().
+		Str(logger.ActionID, a.ActionID).
+		Str(logger.ActionType, a.Type).
+		Any("target", target).
+		Msg("Created targeted action")
+	return []string{a.ActionID}, nil
+}
+
+// newAction returns the action document of the request, without its ID and targets.
+func (act *ActionsT) newAction(req *CreateActionsRequest, userID string, now time.Time) model.Action {
+	expiration := now.Add(defaultActionExpiration)
+	if req.Expiration != nil {
+		expiration = req.Expiration.UTC()
+	}
+	// Diagnostics that are not uploaded within the timeout expire, the GC records them as expired.
+	if timeout := act.serverCfg().GC.DiagnosticsTimeout; req.Type == string(REQUESTDIAGNOSTICS) && timeout > 0 {
+		if limit := now.Add(timeout); expiration.After(limit) {
+			expiration = limit
+		}
+	}
+	var data json.RawMessage
+	if req.Data != nil {
+		data = *req.Data
+	}
+	var inputType string
+	if req.InputType != nil {
+		inputType = *req.InputType
+	}
+	return model.Action{
+		Data:       data,
+		Expiration: ftime.Format(expiration),
+		InputType:  inputType,
+		Timestamp:  ftime.Format(now),
+		Type:       req.Type,
+		UserID:     userID,
+	}
+}
+
+// createActions writes action documents for the agents, splitting large target lists.
+// The documents share the action ID of the request, so the agents of all the chunks are targeted by a single action.
+// The action ID is returned once a document is written, even when a later chunk fails.
+func (act *ActionsT) createActions(ctx context.Context, zlog zerolog.Logger, req *CreateActionsRequest, userID string, agents []string) ([]string, error) {
+	span, ctx := apm.StartSpan(ctx, "createActions", "create")
+	defer span.End()
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("createActions uuid: %w", err)
+	}
+	now := time.Now().UTC()
+	var ids []string
+	for start := 0; start < len(agents); start += actionAgentsChunkSize {
+		end := min(start+actionAgentsChunkSize, len(agents))
+
+		a := act.newAction(req, userID, now)
+		a.ActionID = u.String()
+		a.Agents = agents[start:end]
+		if _, err := dl.CreateAction(ctx, act.bulk, a); err != nil {
+			return ids, fmt.Errorf("createActions create: %w", err)
+		}
+		ids = []string{a.ActionID}
+		zlog.Info().
+			Str(logger.ActionID, a.ActionID).
+			Str(logger.ActionType, a.Type).
+			Int("agents", len(a.Agents)).
+			Int("offset", start).
+			Msg("Created action")
+	}
+	return ids, nil
+}
+
+// markUnenrolling starts the unenrollment of the agents targeted by an UNENROLL action.
+// The agents that could not be updated, for instance because they do not exist, are logged.
+func (act *ActionsT) markUnenrolling(ctx context.Context, zlog zerolog.Logger, agents []string) error {
+	span, ctx := apm.StartSpan(ctx, "markUnenrolling", "update")
+	defer span.End()
+
+	failed, err := dl.MarkAgentsUnenrolling(ctx, act.bulk, agents, time.Now())
+	if err != nil {
+		return fmt.Errorf("createActions mark unenrolling: %w", err)
+	}
+	if len(failed) > 0 {
+		zlog.Warn().Strs("agents", failed).Msg("Failed to start the unenrollment of agents")
+	}
+	return nil
+}
@@ -0,0 +1,455 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func actionsTestCfg(maxTargets int) *config.Server {
+	return &config.Server{
+		Limits: config.ServerLimits{
+			MaxActionTargets: maxTargets,
+		},
+	}
+}
+
+func agentHits(n int) *es.ResultT {
+	hits := make([]es.HitT, n)
+	for i := range hits {
+		hits[i] = es.HitT{ID: fmt.Sprintf("agent-%d", i)}
+	}
+	return &es.ResultT{HitsT: es.HitsT{Hits: hits}}
+}
+
+func Test_Actions_validateCreateRequest(t *testing.T) {
+	policyID := "policy-id"
+	tag := "production"
+	tests := []struct {
+		name  string
+		body  string
+		cfg   *config.Server
+		valid *CreateActionsRequest
+		err   error
+	}{{
+		name:  "ok with agents",
+		body:  `{"type":"UPGRADE","agents":["agent-1","agent-2"]}`,
+		cfg:   actionsTestCfg(10),
+		valid: &CreateActionsRequest{Type: "UPGRADE", Agents: &[]string{"agent-1", "agent-2"}},
+	}, {
+		name:  "ok with policy",
+		body:  `{"type":"SETTINGS","policy_id":"policy-id"}`,
+		cfg:   actionsTestCfg(10),
+		valid: &CreateActionsRequest{Type: "SETTINGS", PolicyId: &policyID},
+	}, {
+		name:  "ok with tag",
+		body:  `{"type":"SETTINGS","tag":"production"}`,
+		cfg:   actionsTestCfg(10),
+		valid: &CreateActionsRequest{Type: "SETTINGS", Tag: &tag},
+	}, {
+		name:  "ok with target",
+		body:  `{"type":"SETTINGS","target":{"tags":["production"],"version":">= 8.15.0"}}`,
+		cfg:   actionsTestCfg(10),
+		valid: &CreateActionsRequest{Type: "SETTINGS", Target: &ActionTarget{Tags: &[]string{"production"}, Version: ptr(">= 8.15.0")}},
+	}, {
+		name: "not json object",
+		body: `{"invalidJson":}`,
+		cfg:  actionsTestCfg(10),
+		err:  &BadRequestErr{msg: "unable to decode create actions request"},
+	}, {
+		name: "missing type",
+		body: `{"agents":["agent-1"]}`,
+		cfg:  actionsTestCfg(10),
+		err:  &BadRequestErr{msg: "create actions request missing type"},
+	}, {
+		name: "invalid type",
+		body: `{"type":"BAD","agents":["agent-1"]}`,
+		cfg:  actionsTestCfg(10),
+		err:  &BadRequestErr{msg: `create actions request invalid type "BAD"`},
+	}, {
+		name: "expiration in the past",
+		body: `{"type":"UPGRADE","agents":["agent-1"],"expiration":"2020-01-01T00:00:00Z"}`,
+		cfg:  actionsTestCfg(10),
+		err:  &BadRequestErr{msg: "create actions request expiration is in the past"},
+	}, {
+		name: "no targets",
+		body: `{"type":"UPGRADE"}`,
+		cfg:  actionsTestCfg(10),
+		err:  ErrActionTargets,
+	}, {
+		name: "agents and policy",
+		body: `{"type":"UPGRADE","agents":["agent-1"],"policy_id":"policy-id"}`,
+		cfg:  actionsTestCfg(10),
+		err:  ErrActionTargets,
+	}, {
+		name: "policy and tag",
+		body: `{"type":"UPGRADE","policy_id":"policy-id","tag":"production"}`,
+		cfg:  actionsTestCfg(10),
+		err:  ErrActionTargets,
+	}, {
+		name: "tag and target",
+		body: `{"type":"UPGRADE","tag":"production","target":{"policy_id":"policy-id"}}`,
+		cfg:  actionsTestCfg(10),
+		err:  ErrActionTargets,
+	}, {
+		name: "empty target",
+		body: `{"type":"UPGRADE","target":{}}`,
+		cfg:  actionsTestCfg(10),
+		err:  ErrActionTargets,
+	}, {
+		name: "invalid target version",
+		body: `{"type":"UPGRADE","target":{"version":"not a version"}}`,
+		cfg:  actionsTestCfg(10),
+		err:  ErrActionTargets,
+	}, {
+		name: "unenroll target",
+		body: `{"type":"UNENROLL","target":{"policy_id":"policy-id"}}`,
+		cfg:  actionsTestCfg(10),
+		err:  ErrActionTargets,
+	}, {
+		name: "too many agents",
+		body: `{"type":"UPGRADE","agents":["agent-1","agent-2","agent-3"]}`,
+		cfg:  actionsTestCfg(2),
+		err:  ErrActionTargets,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			act := ActionsT{cfg: tc.cfg}
+			w := httptest.NewRecorder()
+			r := &http.Request{Body: io.NopCloser(strings.NewReader(tc.body))}
+
+			req, err := act.validateCreateRequest(testlog.SetLogger(t), w, r)
+			if tc.err != nil {
+				var brErr *BadRequestErr
+				if errors.As(tc.err, &brErr) {
+					require.EqualError(t, err, tc.err.Error())
+				} else {
+					require.ErrorIs(t, err, tc.err)
+				}
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.valid, req)
+		})
+	}
+}
+
+func Test_Actions_expandTargets(t *testing.T) {
+	policyID := "policy-id"
+
+	t.Run("agent list is returned as is", func(t *testing.T) {
+		act := ActionsT{cfg: actionsTestCfg(10)}
+		agents, err := act.expandTargets(context.Background(), &CreateActionsRequest{Agents: &[]string{"agent-1"}})
+		require.NoError(t, err)
+		require.Equal(t, []string{"agent-1"}, agents)
+	})
+	t.Run("policy is expanded", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(agentHits(3), nil)
+		act := ActionsT{cfg: actionsTestCfg(10), bulk: bulker}
+		agents, err := act.expandTargets(context.Background(), &CreateActionsRequest{PolicyId: &policyID})
+		require.NoError(t, err)
+		require.Equal(t, []string{"agent-0", "agent-1", "agent-2"}, agents)
+		bulker.AssertExpectations(t)
+	})
+	t.Run("tag is expanded", func(t *testing.T) {
+		tag := "production"
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetAgents, mock.MatchedBy(func(body []byte) bool {
+			return strings.Contains(string(body), `{"term":{"tags":"production"}}`)
+		}), mock.Anything).Return(agentHits(2), nil)
+		act := ActionsT{cfg: actionsTestCfg(10), bulk: bulker}
+		agents, err := act.expandTargets(context.Background(), &CreateActionsRequest{Tag: &tag})
+		require.NoError(t, err)
+		require.Equal(t, []string{"agent-0", "agent-1"}, agents)
+		bulker.AssertExpectations(t)
+	})
+	t.Run("policy without agents", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(agentHits(0), nil)
+		act := ActionsT{cfg: actionsTestCfg(10), bulk: bulker}
+		_, err := act.expandTargets(context.Background(), &CreateActionsRequest{PolicyId: &policyID})
+		require.ErrorIs(t, err, ErrActionTargets)
+	})
+	t.Run("policy with too many agents", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(agentHits(3), nil)
+		act := ActionsT{cfg: actionsTestCfg(2), bulk: bulker}
+		_, err := act.expandTargets(context.Background(), &CreateActionsRequest{PolicyId: &policyID})
+		require.ErrorIs(t, err, ErrActionTargets)
+	})
+	t.Run("search error", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return((*es.ResultT)(nil), errors.New("search failed"))
+		act := ActionsT{cfg: actionsTestCfg(10), bulk: bulker}
+		_, err := act.expandTargets(context.Background(), &CreateActionsRequest{PolicyId: &policyID})
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrActionTargets)
+	})
+}
+
+func Test_Actions_createActions(t *testing.T) {
+	agents := make([]string, actionAgentsChunkSize+1)
+	for i := range agents {
+		agents[i] = fmt.Sprintf("agent-%d", i)
+	}
+
+	var docs []model.Action
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Create", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var action model.Action
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &action))
+		docs = append(docs, action)
+	}).Return("", nil)
+
+	act := ActionsT{cfg: actionsTestCfg(len(agents)), bulk: bulker}
+	ids, err := act.createActions(context.Background(), testlog.SetLogger(t), &CreateActionsRequest{Type: "UPGRADE"}, "elastic", agents)
+	require.NoError(t, err)
+	require.Len(t, ids, 1, "the chunks share the action ID")
+
+	require.Len(t, docs, 2)
+	require.Len(t, docs[0].Agents, actionAgentsChunkSize)
+	require.Len(t, docs[1].Agents, 1)
+	for _, doc := range docs {
+		require.Equal(t, ids[0], doc.ActionID)
+		require.Equal(t, "UPGRADE", doc.Type)
+		require.Equal(t, "elastic", doc.UserID)
+		require.NotEmpty(t, doc.Timestamp)
+		require.NotEmpty(t, doc.Expiration)
+	}
+	bulker.AssertExpectations(t)
+}
+
+func Test_Actions_createTargetedAction(t *testing.T) {
+	var docs []model.Action
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Create", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var action model.Action
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &action))
+		docs = append(docs, action)
+	}).Return("", nil)
+
+	act := ActionsT{cfg: actionsTestCfg(1), bulk: bulker}
+	target := &model.ActionTarget{PolicyID: "policy-id", Tags: []string{"production"}}
+	ids, err := act.createTargetedAction(context.Background(), testlog.SetLogger(t), &CreateActionsRequest{Type: "SETTINGS"}, "elastic", target)
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+
+	require.Len(t, docs, 1)
+	require.Equal(t, ids[0], docs[0].ActionID)
+	require.Equal(t, target, docs[0].Target)
+	require.Empty(t, docs[0].Agents)
+	require.Equal(t, "SETTINGS", docs[0].Type)
+	require.NotEmpty(t, docs[0].Expiration)
+	bulker.AssertExpectations(t)
+}
+
+func Test_Actions_markUnenrolling(t *testing.T) {
+	var ops []bulk.MultiOp
+	bulker := ftesting.NewMockBulk()
+	bulker.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		ops = args.Get(1).([]bulk.MultiOp)
+	}).Return([]bulk.BulkIndexerResponseItem{{Status: http.StatusOK}, {Status: http.StatusNotFound}}, nil).Once()
+
+	act := ActionsT{cfg: actionsTestCfg(10), bulk: bulker}
+	require.NoError(t, act.markUnenrolling(context.Background(), testlog.SetLogger(t), []string{"agent-1", "agent-2"}))
+	require.Len(t, ops, 2)
+	for i, op := range ops {
+		require.Equal(t, fmt.Sprintf("agent-%d", i+1), op.ID)
+		require.Equal(t, dl.FleetAgents, op.Index)
+		require.Contains(t, string(op.Body), dl.FieldUnenrollmentStartedAt)
+	}
+	bulker.AssertExpectations(t)
+
+	bulker = ftesting.NewMockBulk()
+	bulker.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Return([]bulk.BulkIndexerResponseItem(nil), errors.New("bulk failed")).Once()
+	act = ActionsT{cfg: actionsTestCfg(10), bulk: bulker}
+	require.Error(t, act.markUnenrolling(context.Background(), testlog.SetLogger(t), []string{"agent-1"}))
+}
+
+func Test_Actions_createActions_diagnosticsExpiration(t *testing.T) {
+	inHour := time.Now().Add(time.Hour)
+	inMinute := time.Now().Add(time.Minute).Truncate(time.Second)
+	tests := []struct {
+		name       string
+		timeout    time.Duration
+		expiration *time.Time
+		expect     time.Duration
+	}{{
+		name:    "default timeout",
+		timeout: 30 * time.Minute,
+		expect:  30 * time.Minute,
+	}, {
+		name:       "expiration after the timeout",
+		timeout:    30 * time.Minute,
+		expiration: &inHour,
+		expect:     30 * time.Minute,
+	}, {
+		name:       "expiration before the timeout",
+		timeout:    30 * time.Minute,
+		expiration: &inMinute,
+		expect:     time.Minute,
+	}, {
+		name:   "timeout disabled",
+		expect: defaultActionExpiration,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var doc model.Action
+			bulker := ftesting.NewMockBulk()
+			bulker.On("Create", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &doc))
+			}).Return("", nil)
+
+			// the timeout is read from the reloaded configuration
+			act := ActionsT{cfg: actionsTestCfg(1), bulk: bulker}
+			reloaded := *act.cfg
+			reloaded.GC.DiagnosticsTimeout = tc.timeout
+			act.Reload(&reloaded)
+			req := &CreateActionsRequest{Type: "REQUEST_DIAGNOSTICS", Expiration: tc.expiration}
+			_, err := act.createActions(context.Background(), testlog.SetLogger(t), req, "elastic", []string{"agent-1"})
+			require.NoError(t, err)
+
+			expiration, err := time.Parse(time.RFC3339, doc.Expiration)
+			require.NoError(t, err)
+			require.WithinDuration(t, time.Now().Add(tc.expect), expiration, 5*time.Second)
+		})
+	}
+}
+
+// mockAdminPrivileges makes the has_privileges check of the admin API keys authenticated by bulker report that
+// the key has, or does not have, the privileges to manage agents.
+func mockAdminPrivileges(t *testing.T, bulker *ftesting.MockBulk, hasAll bool) {
+	t.Helper()
+	body := `{"has_all_requested":true}`
+	if !hasAll {
+		body = `{"has_all_requested":false,"cluster":{},"index":{".fleet-*":{"read":true,"write":false}}}`
+	}
+	client, mocktrans := mockESClient(t)
+	mocktrans.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+		require.Equal(t, "/_security/user/_has_privileges", req.URL.Path)
+		require.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "ApiKey "))
+		return sendBodyString(body), nil
+	}
+	bulker.On("Client").Return(client).Maybe()
+}
+
+// testServiceToken is the authorization header of the service token mockServiceToken authenticates.
+var testServiceToken = "Bearer " + base64.StdEncoding.EncodeToString([]byte("elastic/fleet-server/token-1:secret"))
+
+// mockServiceToken makes the service tokens authenticated by bulker belong to the fleet-server service account and
+// returns the authorization header of such a token.
+func mockServiceToken(t *testing.T, bulker *ftesting.MockBulk) string {
+	t.Helper()
+	client, mocktrans := mockESClient(t)
+	mocktrans.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+		require.Equal(t, "/_security/_authenticate", req.URL.Path)
+		require.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "Bearer "))
+		return sendBodyString(`{"username":"elastic/fleet-server","enabled":true,"authentication_realm":{"name":"_service_account","type":"_service_account"}}`), nil
+	}
+	bulker.On("Client").Return(client).Maybe()
+	return testServiceToken
+}
+
+func Test_authServiceToken(t *testing.T) {
+	t.Run("service token", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/reassign", nil)
+		r.Header.Set("Authorization", mockServiceToken(t, bulker))
+
+		info, err := authServiceToken(r, bulker)
+		require.NoError(t, err)
+		require.True(t, info.IsServiceAccount())
+	})
+
+	t.Run("user api key", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{UserName: "elastic", Enabled: true}, nil)
+		mockAdminPrivileges(t, bulker, true)
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/reassign", nil)
+		r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+
+		_, err := authServiceToken(r, bulker)
+		require.ErrorIs(t, err, ErrServiceTokenAuth)
+		require.Equal(t, http.StatusForbidden, NewHTTPErrResp(err).StatusCode)
+		require.Empty(t, bulker.Calls)
+	})
+}
+
+func Test_Actions_authAdmin(t *testing.T) {
+	tests := []struct {
+		name   string
+		info   *apikey.SecurityInfo
+		noPriv bool
+		err    error
+	}{{
+		name: "user api key",
+		info: &apikey.SecurityInfo{UserName: "elastic", Enabled: true},
+	}, {
+		name:   "key without privileges",
+		info:   &apikey.SecurityInfo{UserName: "elastic", Enabled: true},
+		noPriv: true,
+		err:    ErrAdminAuth,
+	}, {
+		name: "disabled key",
+		info: &apikey.SecurityInfo{UserName: "elastic", Enabled: false},
+		err:  ErrAPIKeyNotEnabled,
+	}, {
+		name: "key without user",
+		info: &apikey.SecurityInfo{Enabled: true},
+		err:  ErrAdminAuth,
+	}, {
+		name: "agent key",
+		info: &apikey.SecurityInfo{UserName: "elastic", Enabled: true, Metadata: json.RawMessage(`{"managed_by":"fleet-server","type":"agent"}`)},
+		err:  ErrAdminAuth,
+	}, {
+		name: "enrollment key",
+		info: &apikey.SecurityInfo{UserName: "elastic", Enabled: true, Metadata: json.RawMessage(`{"managed_by":"fleet","managed":true,"type":"enroll"}`)},
+		err:  ErrAdminAuth,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bulker := ftesting.NewMockBulk()
+			bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(tc.info, nil)
+			mockAdminPrivileges(t, bulker, !tc.noPriv)
+			r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/actions", nil)
+			r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+
+			info, err := authAdmin(r, bulker)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.info, info)
+			}
+		})
+	}
+}
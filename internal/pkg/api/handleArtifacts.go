@@ -6,6 +6,7 @@ package api
 
 import (
 	"bytes"
+	"compress/zlib"
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
@@ -15,9 +16,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"go.elastic.co/apm/v2"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
@@ -33,6 +36,10 @@ import (
 const (
 	defaultMaxParallel = 8           // TODO: configurable
 	defaultThrottleTTL = time.Minute // TODO: configurable
+
+	compressionZlib = "zlib"
+	compressionNone = "none"
+	encryptionNone  = "none"
 )
 
 var (
@@ -40,12 +47,16 @@ var (
 	ErrorBadSha2      = errors.New("malformed sha256")
 	ErrorRecord       = errors.New("artifact record mismatch")
 	ErrorMismatchSha2 = errors.New("mismatched sha256")
+	ErrorCompression  = errors.New("unsupported artifact compression")
 )
 
 type ArtifactT struct {
 	bulker     bulk.Bulk
 	cache      cache.Cache
 	esThrottle *throttle.Throttle
+	// fetchGroup deduplicates concurrent fetches of the same artifact, so agents requesting an artifact that is not cached
+	// wait for a single fetch from elasticsearch instead of being throttled.
+	fetchGroup *singleflight.Group
 }
 
 func NewArtifactT(cfg *config.Server, bulker bulk.Bulk, cache cache.Cache) *ArtifactT {
@@ -53,6 +64,7 @@ func NewArtifactT(cfg *config.Server, bulker bulk.Bulk, cache cache.Cache) *Arti
 		bulker:     bulker,
 		cache:      cache,
 		esThrottle: throttle.NewThrottle(defaultMaxParallel),
+		fetchGroup: &singleflight.Group{},
 	}
 }
 
@@ -73,13 +85,19 @@ func (at ArtifactT) handleArtifacts(zlog zerolog.Logger, w http.ResponseWriter,
 		return err
 	}
 
-	rdr, err := at.processRequest(r.Context(), zlog, agent, id, sha2)
+	artifact, err := at.processRequest(r.Context(), zlog, agent, id, sha2)
 	if err != nil {
 		return err
 	}
+	if etagMatch(r.Header.Get("If-None-Match"), artifact.ETag) {
+		writeNotModified(w, artifact.ETag)
+		zlog.Trace().Msg("artifact not modified")
+		return nil
+	}
+
 	span, ctx := apm.StartSpan(r.Context(), "response", "write")
 	defer span.End()
-	n, err := io.Copy(w, rdr)
+	n, err := writeArtifact(w, artifact)
 	if err != nil {
 		return err
 	}
@@ -93,6 +111,20 @@ func (at ArtifactT) handleArtifacts(zlog zerolog.Logger, w http.ResponseWriter,
 	return nil
 }
 
+// writeArtifact writes the artifact body as it is stored, compressed, with headers describing its encoding.
+func writeArtifact(w http.ResponseWriter, artifact *cache.Artifact) (int64, error) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if artifact.ETag != "" {
+		w.Header().Set("ETag", artifact.ETag)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(artifact.Body)))
+	if artifact.CompressionAlgorithm == compressionZlib {
+		// The deflate content-coding is the zlib format, see RFC 9110 section 8.4.1.2.
+		w.Header().Set("Content-Encoding", "deflate")
+	}
+	return io.Copy(w, bytes.NewReader(artifact.Body))
+}
+
 func (at ArtifactT) validateRequest(ctx context.Context, sha2 string) error {
 	span, _ := apm.StartSpan(ctx, "validateRequest", "validate")
 	defer span.End()
@@ -101,7 +133,7 @@ func (at ArtifactT) validateRequest(ctx context.Context, sha2 string) error {
 	return validateSha2String(sha2)
 }
 
-func (at ArtifactT) processRequest(ctx context.Context, zlog zerolog.Logger, agent *model.Agent, id, sha2 string) (io.Reader, error) {
+func (at ArtifactT) processRequest(ctx context.Context, zlog zerolog.Logger, agent *model.Agent, id, sha2 string) (*cache.Artifact, error) {
 	// Determine whether the agent should have access to this artifact
 	if err := at.authorizeArtifact(ctx, agent, id, sha2); err != nil {
 		zlog.Warn().Err(err).Msg("Unauthorized GET on artifact")
@@ -133,9 +165,7 @@ func (at ArtifactT) processRequest(ctx context.Context, zlog zerolog.Logger, age
 		Str("created", artifact.Created).
 		Msg("Artifact GET")
 
-	// Write the payload
-	rdr := bytes.NewReader(artifact.Body)
-	return rdr, nil
+	return artifact, nil
 }
 
 // TODO: Pull the policy record for this agent and validate that the
@@ -154,17 +184,42 @@ func (at ArtifactT) authorizeArtifact(ctx context.Context, _ *model.Agent, _, _
 }
 
 // Return artifact from cache by sha2 or fetch directly from Elastic.
+// Concurrent requests for an artifact that is not cached share a single fetch.
 // Update cache on successful retrieval from Elastic.
-func (at ArtifactT) getArtifact(ctx context.Context, zlog zerolog.Logger, ident, sha2 string) (*model.Artifact, error) {
+func (at ArtifactT) getArtifact(ctx context.Context, zlog zerolog.Logger, ident, sha2 string) (*cache.Artifact, error) {
 	span, ctx := apm.StartSpan(ctx, "getArtifact", "process")
 	defer span.End()
 
 	// Check the cache; return immediately if found.
 	if artifact, ok := at.cache.GetArtifact(ident, sha2); ok {
+		cntArtifacts.cacheHit.Inc()
 		return &artifact, nil
 	}
+	cntArtifacts.cacheMiss.Inc()
+
+	// The fetch is shared with the other requests for the artifact, it must not be canceled when this request is.
+	fetchCtx := context.WithoutCancel(ctx)
+	v, err, shared := at.fetchGroup.Do(ident+":"+sha2, func() (interface{}, error) {
+		// The artifact may have been cached by a fetch that completed after the cache check.
+		if artifact, ok := at.cache.GetArtifact(ident, sha2); ok {
+			return &artifact, nil
+		}
+		return at.loadArtifact(fetchCtx, zlog, ident, sha2)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared {
+		zlog.Trace().Msg("Artifact fetch shared")
+	}
+	// Callers sharing the fetch must not share the artifact struct.
+	artifact := *v.(*cache.Artifact)
+	return &artifact, nil
+}
 
-	// Fetch the artifact from elastic
+// loadArtifact fetches the artifact from Elastic, decodes and validates it, and adds it to the cache.
+// The entity tag of the artifact is the validated sha256 of the body it is served with.
+func (at ArtifactT) loadArtifact(ctx context.Context, zlog zerolog.Logger, ident, sha2 string) (*cache.Artifact, error) {
 	art, err := at.fetchArtifact(ctx, zlog, ident, sha2)
 	if err != nil {
 		zlog.Info().Err(err).Msg("Fail retrieve artifact")
@@ -187,22 +242,31 @@ func (at ArtifactT) getArtifact(ctx context.Context, zlog zerolog.Logger, ident,
 		return nil, err
 	}
 
-	// Validate the sha256 hash; this is just good hygiene.
+	// Validate the sha256 hashes; this is just good hygiene.
 	vSpan, _ := apm.StartSpan(ctx, "validateArtifact", "validate")
 	if err = validateSha2Data(dstPayload, art.EncodedSha256); err != nil {
 		vSpan.End()
 		zlog.Error().Err(err).Msg("Fail sha2 hash validation")
 		return nil, err
 	}
+	if err = validateDecodedArtifact(art, dstPayload, sha2); err != nil {
+		vSpan.End()
+		zlog.Error().Err(err).Str("compression", art.CompressionAlgorithm).Msg("Fail decoded sha2 hash validation")
+		return nil, err
+	}
 	vSpan.End()
 
 	// Reassign decoded payload before adding to cache, avoid base64 decode on cache hit.
 	art.Body = dstPayload
+	artifact := cache.Artifact{
+		Artifact: *art,
+		ETag:     strongETag(art.EncodedSha256),
+	}
 
 	// Update the cache.
-	at.cache.SetArtifact(*art)
+	at.cache.SetArtifact(artifact)
 
-	return art, nil
+	return &artifact, nil
 }
 
 // Attempt to fetch the artifact from Elastic
@@ -246,6 +310,42 @@ func validateSha2String(sha2 string) error {
 	return nil
 }
 
+// validateDecodedArtifact checks that the sha256 of the decompressed body of the artifact is the requested sha2.
+// Encrypted artifacts can not be decompressed and are not validated.
+func validateDecodedArtifact(art *model.Artifact, body []byte, sha2 string) error {
+	if art.EncryptionAlgorithm != "" && art.EncryptionAlgorithm != encryptionNone {
+		return nil
+	}
+
+	var rdr io.Reader
+	switch art.CompressionAlgorithm {
+	case compressionZlib:
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("zlib decompress: %w", err)
+		}
+		defer zr.Close()
+		rdr = zr
+	case compressionNone, "":
+		rdr = bytes.NewReader(body)
+	default:
+		return fmt.Errorf("%w: %s", ErrorCompression, art.CompressionAlgorithm)
+	}
+
+	// Read at most one byte over the decoded size, a larger body does not match the decoded sha256.
+	h := sha256.New()
+	if art.DecodedSize > 0 {
+		rdr = io.LimitReader(rdr, art.DecodedSize+1)
+	}
+	if _, err := io.Copy(h, rdr); err != nil {
+		return fmt.Errorf("artifact decompress: %w", err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != sha2 {
+		return ErrorMismatchSha2
+	}
+	return nil
+}
+
 func validateSha2Data(data []byte, sha2 string) error {
 	src, err := hex.DecodeString(sha2)
 	if err != nil {
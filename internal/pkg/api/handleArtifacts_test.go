@@ -0,0 +1,290 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// testArtifact returns a zlib compressed artifact document as it is stored in elasticsearch.
+func testArtifact(t *testing.T, ident string, decoded []byte) model.Artifact {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write(decoded)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	body, err := json.Marshal(base64.StdEncoding.EncodeToString(buf.Bytes()))
+	require.NoError(t, err)
+	return model.Artifact{
+		Identifier:           ident,
+		Body:                 body,
+		CompressionAlgorithm: compressionZlib,
+		EncryptionAlgorithm:  encryptionNone,
+		DecodedSha256:        sha256Hex(decoded),
+		DecodedSize:          int64(len(decoded)),
+		EncodedSha256:        sha256Hex(buf.Bytes()),
+		EncodedSize:          int64(buf.Len()),
+	}
+}
+
+// decodeArtifactBody returns the compressed body of the artifact as it is served.
+func decodeArtifactBody(t *testing.T, art model.Artifact) []byte {
+	t.Helper()
+	var s string
+	require.NoError(t, json.Unmarshal(art.Body, &s))
+	body, err := base64.StdEncoding.DecodeString(s)
+	require.NoError(t, err)
+	return body
+}
+
+func artifactSearchResult(t *testing.T, artifacts ...model.Artifact) *es.ResultT {
+	t.Helper()
+	res := &es.ResultT{}
+	for _, a := range artifacts {
+		source, err := json.Marshal(a)
+		require.NoError(t, err)
+		res.Hits = append(res.Hits, es.HitT{ID: a.Identifier, Source: source})
+	}
+	return res
+}
+
+func newTestArtifactT(t *testing.T, bulker *ftesting.MockBulk) *ArtifactT {
+	t.Helper()
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000, ArtifactTTL: time.Minute})
+	require.NoError(t, err)
+	return NewArtifactT(&config.Server{}, bulker, c)
+}
+
+func Test_Artifacts_getArtifact(t *testing.T) {
+	decoded := []byte(`{"entries":[{"type":"simple","entries":[]}]}`)
+	art := testArtifact(t, "endpoint-exceptionlist-linux-v1", decoded)
+
+	t.Run("cache miss then hit", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetArtifacts, mock.Anything, mock.Anything).Return(artifactSearchResult(t, art), nil).Once()
+		at := newTestArtifactT(t, bulker)
+		zlog := testlog.SetLogger(t)
+
+		got, err := at.getArtifact(context.Background(), zlog, art.Identifier, art.DecodedSha256)
+		require.NoError(t, err)
+		require.Equal(t, art.EncodedSha256, sha256Hex(got.Body))
+
+		require.Eventually(t, func() bool {
+			_, ok := at.cache.GetArtifact(art.Identifier, art.DecodedSha256)
+			return ok
+		}, time.Second, 10*time.Millisecond)
+		got, err = at.getArtifact(context.Background(), zlog, art.Identifier, art.DecodedSha256)
+		require.NoError(t, err)
+		require.Equal(t, art.EncodedSha256, sha256Hex(got.Body))
+		bulker.AssertNumberOfCalls(t, "Search", 1)
+	})
+
+	t.Run("decoded hash mismatch", func(t *testing.T) {
+		bad := testArtifact(t, art.Identifier, []byte(`{"entries":[]}`))
+		bad.DecodedSha256 = art.DecodedSha256
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetArtifacts, mock.Anything, mock.Anything).Return(artifactSearchResult(t, bad), nil)
+		at := newTestArtifactT(t, bulker)
+
+		_, err := at.getArtifact(context.Background(), testlog.SetLogger(t), art.Identifier, art.DecodedSha256)
+		require.ErrorIs(t, err, ErrorMismatchSha2)
+		require.Equal(t, http.StatusInternalServerError, NewHTTPErrResp(err).StatusCode)
+		_, ok := at.cache.GetArtifact(art.Identifier, art.DecodedSha256)
+		require.False(t, ok)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetArtifacts, mock.Anything, mock.Anything).Return(artifactSearchResult(t), nil)
+		at := newTestArtifactT(t, bulker)
+
+		_, err := at.getArtifact(context.Background(), testlog.SetLogger(t), art.Identifier, art.DecodedSha256)
+		require.ErrorIs(t, err, dl.ErrNotFound)
+		require.Equal(t, http.StatusNotFound, NewHTTPErrResp(err).StatusCode)
+	})
+
+	t.Run("elasticsearch unavailable", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetArtifacts, mock.Anything, mock.Anything).Return(&es.ResultT{}, es.ErrUnavailable)
+		at := newTestArtifactT(t, bulker)
+
+		_, err := at.getArtifact(context.Background(), testlog.SetLogger(t), art.Identifier, art.DecodedSha256)
+		require.ErrorIs(t, err, es.ErrUnavailable)
+		require.Equal(t, http.StatusServiceUnavailable, NewHTTPErrResp(err).StatusCode)
+	})
+
+	t.Run("concurrent requests share one fetch", func(t *testing.T) {
+		release := make(chan struct{})
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetArtifacts, mock.Anything, mock.Anything).Run(func(mock.Arguments) {
+			<-release
+		}).Return(artifactSearchResult(t, art), nil)
+		at := newTestArtifactT(t, bulker)
+		zlog := testlog.SetLogger(t)
+
+		const n = 50
+		var wg sync.WaitGroup
+		errs := make(chan error, n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got, err := at.getArtifact(context.Background(), zlog, art.Identifier, art.DecodedSha256)
+				if err == nil && sha256Hex(got.Body) != art.EncodedSha256 {
+					err = ErrorMismatchSha2
+				}
+				errs <- err
+			}()
+		}
+		// More than defaultMaxParallel requests are waiting, none of them may be throttled.
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+		bulker.AssertNumberOfCalls(t, "Search", 1)
+	})
+}
+
+func Test_Artifacts_validateDecodedArtifact(t *testing.T) {
+	decoded := []byte("artifact")
+	art := testArtifact(t, "ident", decoded)
+	body := decodeArtifactBody(t, art)
+
+	require.NoError(t, validateDecodedArtifact(&art, body, art.DecodedSha256))
+	require.ErrorIs(t, validateDecodedArtifact(&art, body, sha256Hex([]byte("other"))), ErrorMismatchSha2)
+
+	t.Run("decoded size exceeded", func(t *testing.T) {
+		small := art
+		small.DecodedSize = 4
+		require.ErrorIs(t, validateDecodedArtifact(&small, body, art.DecodedSha256), ErrorMismatchSha2)
+	})
+	t.Run("uncompressed", func(t *testing.T) {
+		plain := model.Artifact{CompressionAlgorithm: compressionNone}
+		require.NoError(t, validateDecodedArtifact(&plain, decoded, art.DecodedSha256))
+	})
+	t.Run("encrypted not validated", func(t *testing.T) {
+		enc := model.Artifact{CompressionAlgorithm: compressionZlib, EncryptionAlgorithm: "aes"}
+		require.NoError(t, validateDecodedArtifact(&enc, []byte("ciphertext"), art.DecodedSha256))
+	})
+	t.Run("unsupported compression", func(t *testing.T) {
+		lz := model.Artifact{CompressionAlgorithm: "lz4"}
+		require.ErrorIs(t, validateDecodedArtifact(&lz, decoded, art.DecodedSha256), ErrorCompression)
+	})
+}
+
+func Test_Artifacts_handleArtifacts_headers(t *testing.T) {
+	decoded := []byte("artifact")
+	art := testArtifact(t, "ident", decoded)
+	body := decodeArtifactBody(t, art)
+	art.Body = body
+
+	at := newTestArtifactT(t, ftesting.NewMockBulk())
+	at.cache.SetArtifact(cache.Artifact{Artifact: art, ETag: strongETag(art.EncodedSha256)})
+	require.Eventually(t, func() bool {
+		_, ok := at.cache.GetArtifact(art.Identifier, art.DecodedSha256)
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	got, err := at.processRequest(context.Background(), testlog.SetLogger(t), &model.Agent{}, art.Identifier, art.DecodedSha256)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	_, err = writeArtifact(w, got)
+	require.NoError(t, err)
+	require.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+	require.Equal(t, "deflate", w.Header().Get("Content-Encoding"))
+	require.Equal(t, `"`+art.EncodedSha256+`"`, w.Header().Get("ETag"))
+	require.Equal(t, body, w.Body.Bytes())
+
+	zr, err := zlib.NewReader(w.Body)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	_, err = out.ReadFrom(zr)
+	require.NoError(t, err)
+	require.Equal(t, decoded, out.Bytes())
+}
+
+func Test_Artifacts_handleArtifacts_ifNoneMatch(t *testing.T) {
+	art := testArtifact(t, "ident", []byte("artifact"))
+	body := decodeArtifactBody(t, art)
+	etag := `"` + art.EncodedSha256 + `"`
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{Enabled: true}, nil)
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{
+		HitsT: es.HitsT{Hits: []es.HitT{{ID: "agent-1", Source: []byte(`{"access_api_key_id":"id","active":true,"agent":{"id":"agent-1"}}`)}}},
+	}, nil)
+	bulker.On("Search", mock.Anything, dl.FleetArtifacts, mock.Anything, mock.Anything).Return(artifactSearchResult(t, art), nil)
+	at := newTestArtifactT(t, bulker)
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{name: "no header", status: http.StatusOK},
+		{name: "matching", header: etag, status: http.StatusNotModified},
+		{name: "weak matching", header: "W/" + etag, status: http.StatusNotModified},
+		{name: "matching in list", header: `"other", ` + etag, status: http.StatusNotModified},
+		{name: "any", header: "*", status: http.StatusNotModified},
+		{name: "non-matching", header: `"other"`, status: http.StatusOK},
+		{name: "malformed unquoted", header: art.EncodedSha256, status: http.StatusOK},
+		{name: "malformed in list", header: etag + `, "other`, status: http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/fleet/artifacts/ident/"+art.DecodedSha256, nil)
+			req.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+			if tc.header != "" {
+				req.Header.Set("If-None-Match", tc.header)
+			}
+			w := httptest.NewRecorder()
+			err := at.handleArtifacts(testlog.SetLogger(t), w, req, art.Identifier, art.DecodedSha256)
+			require.NoError(t, err)
+
+			require.Equal(t, tc.status, w.Code)
+			require.Equal(t, etag, w.Header().Get("ETag"))
+			if tc.status == http.StatusNotModified {
+				require.Empty(t, w.Body.Bytes())
+			} else {
+				require.Equal(t, body, w.Body.Bytes())
+			}
+		})
+	}
+}
@@ -6,15 +6,14 @@ package api
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
-	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 
 	"github.com/miolini/datacounter"
@@ -25,9 +24,20 @@ import (
 var ErrAuditUnenrollReason = fmt.Errorf("agent document contains audit_unenroll_reason: orphaned")
 
 type AuditT struct {
-	cfg   *config.Server
-	bulk  bulk.Bulk
-	cache cache.Cache
+	cfg      *config.Server
+	reloaded reloadedCfg
+	bulk     bulk.Bulk
+	cache    cache.Cache
+}
+
+// Reload applies the reloaded server configuration to the requests handled from now on.
+func (audit *AuditT) Reload(cfg *config.Server) {
+	audit.reloaded.p.Store(cfg)
+}
+
+// serverCfg returns the server configuration of the requests, as last reloaded.
+func (audit *AuditT) serverCfg() *config.Server {
+	return audit.reloaded.get(audit.cfg)
 }
 
 func NewAuditT(cfg *config.Server, bulker bulk.Bulk, cache cache.Cache) *AuditT {
@@ -60,6 +70,10 @@ func (audit *AuditT) unenroll(zlog zerolog.Logger, w http.ResponseWriter, r *htt
 		return err
 	}
 
+	auditTargets(r.Context(), []string{agent.Id}, []string{agent.PolicyID})
+	auditNamespaces(r.Context(), agent.Namespaces)
+	auditDetail(r.Context(), "reason", req.Reason)
+
 	if err := audit.markUnenroll(r.Context(), zlog, req, agent); err != nil {
 		return err
 	}
@@ -75,15 +89,14 @@ func (audit *AuditT) validateUnenrollRequest(zlog zerolog.Logger, w http.Respons
 	defer span.End()
 
 	body := r.Body
-	if audit.cfg.Limits.AuditUnenrollLimit.MaxBody > 0 {
-		body = http.MaxBytesReader(w, body, audit.cfg.Limits.AuditUnenrollLimit.MaxBody)
+	if audit.serverCfg().Limits.AuditUnenrollLimit.MaxBody > 0 {
+		body = http.MaxBytesReader(w, body, audit.serverCfg().Limits.AuditUnenrollLimit.MaxBody)
 	}
 	readCounter := datacounter.NewReaderCounter(body)
 
 	var req AuditUnenrollRequest
-	dec := json.NewDecoder(readCounter)
-	if err := dec.Decode(&req); err != nil {
-		return nil, &BadRequestErr{msg: "unable to decode audit/unenroll request", nextErr: err}
+	if err := decodeRequest(r.Context(), readCounter, &req, "audit/unenroll"); err != nil {
+		return nil, err
 	}
 
 	switch req.Reason {
@@ -101,7 +114,7 @@ func (audit *AuditT) markUnenroll(ctx context.Context, zlog zerolog.Logger, req
 	span, ctx := apm.StartSpan(ctx, "auditUnenroll", "process")
 	defer span.End()
 
-	now := time.Now().UTC().Format(time.RFC3339)
+	now := ftime.Now()
 	doc := bulk.UpdateFields{
 		dl.FieldUpdatedAt:             now,
 		dl.FieldAuditUnenrolledTime:   req.Timestamp,
@@ -0,0 +1,350 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/miolini/datacounter"
+	"github.com/rs/zerolog"
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+)
+
+const (
+	kBulkEnrollMod = "bulkEnroll"
+
+	// bulkEnrollBatchSize is the max number of agents created by a single bulk request, their results are written once
+	// the batch is done.
+	bulkEnrollBatchSize = 100
+)
+
+var (
+	ErrBulkEnroll  = errors.New("invalid bulk enroll request")
+	ErrAgentExists = errors.New("agent already exists")
+)
+
+// handleBulkEnroll enrolls the agents of the request in batches, and streams the result of each agent as a line of
+// JSON. The request is rejected as a whole if it is invalid or exceeds the quotas of a policy, the failures of the
+// agents once the response is started are reported in their results.
+func (et *EnrollerT) handleBulkEnroll(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request) error {
+	// a draining server is about to shut down, the provisioning system retries with another instance
+	if et.drain.Draining() {
+		setDrainRetryAfter(w, et.cfg.Draining.RetryAfter)
+		return ErrServerDraining
+	}
+	info, err := authServiceToken(r, et.bulker)
+	if err != nil {
+		return err
+	}
+	zlog = zlog.With().Str("userName", info.UserName).Logger()
+	r = r.WithContext(zlog.WithContext(r.Context()))
+
+	req, err := et.validateBulkEnrollRequest(zlog, w, r)
+	if err != nil {
+		return err
+	}
+
+	policyIDs := make([]string, 0)
+	for _, agent := range req.Agents {
+		if !slices.Contains(policyIDs, agent.PolicyId) {
+			policyIDs = append(policyIDs, agent.PolicyId)
+		}
+	}
+	auditTargets(r.Context(), nil, policyIDs)
+	auditDetail(r.Context(), "agents", len(req.Agents))
+
+	policies, reservations, err := et.bulkEnrollPolicies(r.Context(), zlog, req, policyIDs)
+	if err != nil {
+		return err
+	}
+	// the agents enrolled in each policy are counted by its quotas once the request completes
+	added := make(map[string]int, len(policies))
+	defer func() {
+		for policyID, res := range reservations {
+			res.done(added[policyID])
+		}
+	}()
+
+	start := time.Now()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	var enrolled, failed int
+	for offset := 0; offset < len(req.Agents); offset += bulkEnrollBatchSize {
+		if err := r.Context().Err(); err != nil {
+			zlog.Warn().Err(err).Int("enrolled", enrolled).Int("failed", failed).Msg("bulk enroll request canceled")
+			return nil
+		}
+		batch := req.Agents[offset:min(offset+bulkEnrollBatchSize, len(req.Agents))]
+		for i, res := range et.bulkEnrollBatch(r.Context(), zlog, batch, offset, policies) {
+			if res.Status == http.StatusCreated {
+				enrolled++
+				added[batch[i].PolicyId]++
+			} else {
+				failed++
+			}
+			data, err := json.Marshal(res)
+			if err != nil {
+				return fmt.Errorf("bulkEnroll marshal: %w", err)
+			}
+			n, err := w.Write(append(data, '\n'))
+			cntBulkEnroll.bodyOut.Add(uint64(n)) //nolint:gosec // disable G115
+			if err != nil {
+				// the response is started, the error can only be logged
+				zlog.Warn().Err(err).Int("enrolled", enrolled).Int("failed", failed).Msg("unable to write bulk enroll results")
+				return nil
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			zlog.Debug().Err(err).Msg("unable to flush bulk enroll results")
+		}
+	}
+
+	zlog.Info().
+		Int("enrolled", enrolled).
+		Int("failed", failed).
+		Int64(ECSEventDuration, time.Since(start).Nanoseconds()).
+		Msg("Elastic Agents bulk enrolled")
+	return nil
+}
+
+func (et *EnrollerT) validateBulkEnrollRequest(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request) (*BulkEnrollRequest, error) {
+	span, _ := apm.StartSpan(r.Context(), "validateRequest", "validate")
+	defer span.End()
+
+	body := r.Body
+	if et.serverCfg().Limits.BulkEnrollLimit.MaxBody > 0 {
+		body = http.MaxBytesReader(w, body, et.serverCfg().Limits.BulkEnrollLimit.MaxBody)
+	}
+	readCounter := datacounter.NewReaderCounter(body)
+
+	var req BulkEnrollRequest
+	if err := decodeRequest(r.Context(), readCounter, &req, "bulk enroll"); err != nil {
+		return nil, err
+	}
+	cntBulkEnroll.bodyIn.Add(readCounter.Count())
+
+	if len(req.Agents) == 0 {
+		return nil, fmt.Errorf("%w: no agents", ErrBulkEnroll)
+	}
+	if maxAgents := et.serverCfg().Limits.MaxBulkEnrollAgents; maxAgents > 0 && len(req.Agents) > maxAgents {
+		return nil, fmt.Errorf("%w: %d agents exceed the max of %d", ErrBulkEnroll, len(req.Agents), maxAgents)
+	}
+	ids := make(map[string]struct{}, len(req.Agents))
+	for i := range req.Agents {
+		agent := &req.Agents[i]
+		if agent.PolicyId == "" {
+			return nil, fmt.Errorf("%w: agent %d missing policy_id", ErrBulkEnroll, i)
+		}
+		if agent.Id == nil || *agent.Id == "" {
+			u, err := uuid.NewV4()
+			if err != nil {
+				return nil, err
+			}
+			id := u.String()
+			agent.Id = &id
+		}
+		if _, ok := ids[*agent.Id]; ok {
+			return nil, fmt.Errorf("%w: duplicate agent id %s", ErrBulkEnroll, *agent.Id)
+		}
+		ids[*agent.Id] = struct{}{}
+	}
+
+	zlog.Trace().Int("count", len(req.Agents)).Msg("Bulk enroll request")
+	return &req, nil
+}
+
+// bulkEnrollPolicies reserves the enrollments of the agents of the request in the quotas of their policies, and returns
+// the policies that exist with their reservations. The agents of the policies that do not exist are not enrolled.
+// The request is rejected if a policy exceeds its quotas, the reservations of the other policies are canceled.
+func (et *EnrollerT) bulkEnrollPolicies(ctx context.Context, zlog zerolog.Logger, req *BulkEnrollRequest, policyIDs []string) (map[string]bool, map[string]*enrollReservation, error) {
+	span, ctx := apm.StartSpan(ctx, "checkQuotas", "validate")
+	defer span.End()
+
+	policies := make(map[string]bool, len(policyIDs))
+	reservations := make(map[string]*enrollReservation, len(policyIDs))
+	cancel := func() {
+		for _, res := range reservations {
+			res.cancel()
+		}
+	}
+	for _, policyID := range policyIDs {
+		_, err := et.fetchPolicy(ctx, policyID)
+		if errors.Is(err, ErrPolicyNotFound) {
+			zlog.Info().Str(LogPolicyID, policyID).Msg("bulk enroll policy not found")
+			continue
+		}
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+
+		if et.quotas != nil {
+			n := 0
+			for _, agent := range req.Agents {
+				if agent.PolicyId == policyID {
+					n++
+				}
+			}
+			limits := et.policyLimits(ctx, zlog, policyID)
+			res, err := et.quotas.reserveEnrollN(ctx, policyID, limits, n, n)
+			if err != nil {
+				cancel()
+				return nil, nil, err
+			}
+			reservations[policyID] = res
+		}
+		policies[policyID] = true
+	}
+	return policies, reservations, nil
+}
+
+// bulkEnrollBatch creates the access api keys and the documents of the agents, and returns their results.
+// The keys are created by a single call to the bulker and the documents by a single bulk request.
+// The agents are created and never replaced, the keys of the agents that are not created are invalidated.
+// The agents are not given namespaces, they are not enrolled with an enrollment key that defines them.
+func (et *EnrollerT) bulkEnrollBatch(ctx context.Context, zlog zerolog.Logger, agents []BulkEnrollAgent, offset int, policies map[string]bool) []BulkEnrollResult {
+	span, ctx := apm.StartSpan(ctx, "enrollBatch", "process")
+	defer span.End()
+
+	errs := make([]error, len(agents))
+	keys := make([]*apikey.APIKey, len(agents))
+	reqs := make([]bulk.APIKeyCreateRequest, 0, len(agents))
+	reqIdx := make([]int, 0, len(agents))
+	for i, agent := range agents {
+		if !policies[agent.PolicyId] {
+			errs[i] = ErrPolicyNotFound
+			continue
+		}
+		reqs = append(reqs, accessAPIKeyRequest(*agent.Id))
+		reqIdx = append(reqIdx, i)
+	}
+	if len(reqs) > 0 {
+		created, err := et.bulker.APIKeyMCreate(ctx, reqs)
+		for j, i := range reqIdx {
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			keys[i], errs[i] = created[j].Key, created[j].Err
+		}
+	}
+
+	now := ftime.Format(time.Now())
+	docs := make([]model.Agent, len(agents))
+	ops := make([]bulk.MultiOp, 0, len(agents))
+	opIdx := make([]int, 0, len(agents))
+	for i, agent := range agents {
+		if errs[i] != nil {
+			continue
+		}
+		var meta EnrollMetadata
+		if agent.Metadata != nil {
+			meta = *agent.Metadata
+		}
+		localMeta, err := updateLocalMetaAgentID(meta.Local, *agent.Id)
+		if err != nil {
+			errs[i] = &BadRequestErr{msg: "unable to parse local metadata", nextErr: err}
+			continue
+		}
+		docs[i] = model.Agent{
+			Active:         true,
+			PolicyID:       agent.PolicyId,
+			Type:           EnrollPermanent,
+			EnrolledAt:     now,
+			LocalMetadata:  localMeta,
+			AccessAPIKeyID: keys[i].ID,
+			ActionSeqNo:    []int64{sqn.UndefinedSeqNo},
+			Agent: &model.AgentMetadata{
+				ID: *agent.Id,
+			},
+			Tags:           removeDuplicateStr(meta.Tags),
+			LifecycleState: string(model.AgentStateEnrolled),
+		}
+		body, err := json.Marshal(docs[i])
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		ops = append(ops, bulk.MultiOp{ID: *agent.Id, Index: dl.FleetAgents, Body: body})
+		opIdx = append(opIdx, i)
+	}
+
+	if len(ops) > 0 {
+		// An error is also returned when some of the creations failed, they are then found in the items.
+		items, err := et.bulker.MCreate(ctx, ops)
+		for j, i := range opIdx {
+			if j >= len(items) {
+				errs[i] = err
+				if errs[i] == nil {
+					errs[i] = errors.New("missing bulk create result")
+				}
+				continue
+			}
+			if err := es.TranslateError(items[j].Status, items[j].Error); err != nil {
+				if errors.Is(err, es.ErrElasticVersionConflict) {
+					err = ErrAgentExists
+				}
+				errs[i] = err
+				continue
+			}
+			id, key := *agents[i].Id, keys[i]
+			et.bc.SetState(id, model.AgentStateEnrolled)
+			// The lookups of the agent before its enrollment may be cached as not found.
+			et.cache.InvalidateNotFound(cache.KindAgent, id)
+			et.cache.InvalidateNotFound(cache.KindAgentAPIKey, key.ID)
+			setAgentVersion(et.cache, id, key.ID, bulk.DocVersion{SeqNo: items[j].SeqNo, PrimaryTerm: items[j].PrimaryTerm})
+			et.cache.SetAPIKey(*key, true)
+		}
+	}
+
+	results := make([]BulkEnrollResult, len(agents))
+	var invalidate []string
+	for i, agent := range agents {
+		results[i] = BulkEnrollResult{
+			Id:       *agent.Id,
+			Index:    offset + i,
+			PolicyId: agent.PolicyId,
+			Status:   http.StatusCreated,
+		}
+		if errs[i] == nil {
+			token := keys[i].Token()
+			results[i].AccessApiKey = &token
+			results[i].AccessApiKeyId = &keys[i].ID
+			continue
+		}
+		if keys[i] != nil {
+			invalidate = append(invalidate, keys[i].ID)
+		}
+		resp := NewHTTPErrResp(errs[i])
+		results[i].Status = resp.StatusCode
+		results[i].Error = &resp.Error
+		results[i].Message = &resp.Message
+		zlog.Debug().Err(errs[i]).Str(LogAgentID, *agent.Id).Str(LogPolicyID, agent.PolicyId).Msg("bulk enroll of agent failed")
+	}
+	if len(invalidate) > 0 {
+		if err := invalidateAccessAPIKeys(ctx, zlog, et.bulker, invalidate...); err != nil {
+			zlog.Error().Err(err).Strs("apiKeyIDs", invalidate).Msg("unable to invalidate the api keys of the agents not enrolled")
+		}
+	}
+	return results
+}
@@ -0,0 +1,204 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+// latestPolicies returns the search result of the latest revisions of the policies.
+func latestPolicies(t *testing.T, policyIDs ...string) *es.ResultT {
+	buckets := make([]es.Bucket, len(policyIDs))
+	for i, policyID := range policyIDs {
+		body, err := json.Marshal(model.Policy{PolicyID: policyID, RevisionIdx: 1})
+		require.NoError(t, err)
+		buckets[i] = es.Bucket{
+			Key:          policyID,
+			Aggregations: map[string]es.HitsT{dl.FieldRevisionIdx: {Hits: []es.HitT{{Source: body}}}},
+		}
+	}
+	return &es.ResultT{Aggregations: map[string]es.Aggregation{dl.FieldPolicyID: {Buckets: buckets}}}
+}
+
+func bulkEnroll(t *testing.T, bulker *ftesting.MockBulk, cfg *config.Server, body string) (int, []BulkEnrollResult) {
+	authorization := mockServiceToken(t, bulker)
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	et, err := NewEnrollerT(nil, cfg, bulker, c)
+	require.NoError(t, err)
+	hr := newRouter(cfg, &apiServer{et: et}, nil, nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/bulk_enroll", strings.NewReader(body))
+	r = r.WithContext(testlog.SetLogger(t).WithContext(context.Background()))
+	r.Header.Set("Authorization", authorization)
+	hr.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+
+	require.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	var results []BulkEnrollResult
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var res BulkEnrollResult
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &res))
+		results = append(results, res)
+	}
+	return w.Code, results
+}
+
+func bulkEnrollTestCfg() *config.Server {
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Limits.PolicyQuotas = config.PolicyQuotas{}
+	return cfg
+}
+
+func Test_BulkEnroll_mixed(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetPolicies, mock.Anything, mock.Anything).Return(latestPolicies(t, "policy-1"), nil)
+	var keyReqs []bulk.APIKeyCreateRequest
+	bulker.On("APIKeyMCreate", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		keyReqs = args.Get(1).([]bulk.APIKeyCreateRequest)
+	}).Return([]bulk.APIKeyCreateResult{
+		{Key: &apikey.APIKey{ID: "key-1", Key: "secret-1"}},
+		{Key: &apikey.APIKey{ID: "key-2", Key: "secret-2"}},
+	}, nil)
+	var ops []bulk.MultiOp
+	bulker.On("MCreate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		ops = args.Get(1).([]bulk.MultiOp)
+	}).Return([]bulk.BulkIndexerResponseItem{
+		{Status: http.StatusCreated, SeqNo: 1, PrimaryTerm: 1},
+		{Status: http.StatusConflict, Error: json.RawMessage(`{"type":"version_conflict_engine_exception","reason":"document already exists"}`)},
+	}, nil)
+	// the key of the agent that already exists is invalidated
+	bulker.On("APIKeyRead", mock.Anything, "key-2").Return(&apikey.APIKeyMetadata{ID: "key-2"}, nil)
+	bulker.On("APIKeyInvalidate", mock.Anything, []string{"key-2"}).Return(nil)
+
+	code, results := bulkEnroll(t, bulker, bulkEnrollTestCfg(), `{"agents":[
+		{"id":"agent-1","policy_id":"policy-1","metadata":{"local":{"elastic":{"agent":{"id":"other"}}},"tags":["a","a"]}},
+		{"id":"agent-2","policy_id":"policy-1"},
+		{"id":"agent-3","policy_id":"missing"}
+	]}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, results, 3)
+
+	token := apikey.APIKey{ID: "key-1", Key: "secret-1"}.Token()
+	require.Equal(t, BulkEnrollResult{Index: 0, Id: "agent-1", PolicyId: "policy-1", Status: http.StatusCreated, AccessApiKeyId: &[]string{"key-1"}[0], AccessApiKey: &token}, results[0])
+	require.Equal(t, 1, results[1].Index)
+	require.Equal(t, http.StatusConflict, results[1].Status)
+	require.Equal(t, "AgentExists", *results[1].Error)
+	require.Nil(t, results[1].AccessApiKey)
+	require.Equal(t, 2, results[2].Index)
+	require.Equal(t, http.StatusBadRequest, results[2].Status)
+	require.Equal(t, "ErrPolicyNotFound", *results[2].Error)
+
+	// the keys and the agents of the existing policy are created by a single call each
+	bulker.AssertNumberOfCalls(t, "APIKeyMCreate", 1)
+	require.Len(t, keyReqs, 2)
+	require.Equal(t, "agent-1", keyReqs[0].Name)
+	require.Equal(t, "agent-2", keyReqs[1].Name)
+	require.Len(t, ops, 2)
+	var agent model.Agent
+	require.NoError(t, json.Unmarshal(ops[0].Body, &agent))
+	require.Equal(t, dl.FleetAgents, ops[0].Index)
+	require.Equal(t, "agent-1", ops[0].ID)
+	require.Equal(t, "policy-1", agent.PolicyID)
+	require.Equal(t, "key-1", agent.AccessAPIKeyID)
+	require.Equal(t, string(model.AgentStateEnrolled), agent.LifecycleState)
+	require.Equal(t, []string{"a"}, agent.Tags)
+	require.Empty(t, agent.Namespaces)
+	require.JSONEq(t, `{"elastic":{"agent":{"id":"agent-1"}}}`, string(agent.LocalMetadata))
+	bulker.AssertExpectations(t)
+}
+
+func Test_BulkEnroll_keyFailure(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetPolicies, mock.Anything, mock.Anything).Return(latestPolicies(t, "policy-1"), nil)
+	bulker.On("APIKeyMCreate", mock.Anything, mock.Anything).Return([]bulk.APIKeyCreateResult{
+		{Key: &apikey.APIKey{ID: "key-1", Key: "secret-1"}},
+		{Err: errors.New("unable to create api key")},
+	}, nil)
+	var ops []bulk.MultiOp
+	bulker.On("MCreate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		ops = args.Get(1).([]bulk.MultiOp)
+	}).Return([]bulk.BulkIndexerResponseItem{{Status: http.StatusCreated, SeqNo: 1, PrimaryTerm: 1}}, nil)
+
+	code, results := bulkEnroll(t, bulker, bulkEnrollTestCfg(), `{"agents":[{"id":"agent-1","policy_id":"policy-1"},{"id":"agent-2","policy_id":"policy-1"}]}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, results, 2)
+	require.Equal(t, http.StatusCreated, results[0].Status)
+	require.Equal(t, http.StatusInternalServerError, results[1].Status)
+	// the agent without a key is not created
+	require.Len(t, ops, 1)
+	require.Equal(t, "agent-1", ops[0].ID)
+}
+
+func Test_BulkEnroll_apiKey(t *testing.T) {
+	cfg := bulkEnrollTestCfg()
+	bulker := ftesting.NewMockBulk()
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	et, err := NewEnrollerT(nil, cfg, bulker, c)
+	require.NoError(t, err)
+	hr := newRouter(cfg, &apiServer{et: et}, nil, nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/bulk_enroll", strings.NewReader(`{"agents":[{"policy_id":"policy-1"}]}`))
+	r = r.WithContext(testlog.SetLogger(t).WithContext(context.Background()))
+	r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+	hr.ServeHTTP(w, r)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Empty(t, bulker.Calls)
+}
+
+func Test_BulkEnroll_cap(t *testing.T) {
+	cfg := bulkEnrollTestCfg()
+	cfg.Limits.MaxBulkEnrollAgents = 2
+	bulker := ftesting.NewMockBulk()
+
+	code, _ := bulkEnroll(t, bulker, cfg, `{"agents":[{"policy_id":"policy-1"},{"policy_id":"policy-1"},{"policy_id":"policy-1"}]}`)
+	require.Equal(t, http.StatusBadRequest, code)
+	// the request is rejected before any agent is enrolled, only the caller is authenticated
+	bulker.AssertNumberOfCalls(t, "Client", 1)
+	require.Len(t, bulker.Calls, 1)
+}
+
+func Test_BulkEnroll_policyQuota(t *testing.T) {
+	cfg := bulkEnrollTestCfg()
+	cfg.Limits.PolicyQuotas.MaxAgents = 3
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetPolicies, mock.Anything, mock.Anything).Return(latestPolicies(t, "policy-1"), nil)
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(agentHits(2), nil)
+
+	// the batch is checked as a whole, 2 agents are enrolled in the policy and the batch would add 2 more
+	code, _ := bulkEnroll(t, bulker, cfg, `{"agents":[{"policy_id":"policy-1"},{"policy_id":"policy-1"}]}`)
+	require.Equal(t, http.StatusTooManyRequests, code)
+	bulker.AssertNotCalled(t, "APIKeyMCreate", mock.Anything, mock.Anything)
+	bulker.AssertNotCalled(t, "MCreate", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -9,12 +9,16 @@ import (
 	"compress/flate"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
+	"net/netip"
 	"reflect"
 	"slices"
 	"sync"
@@ -24,14 +28,23 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
 	"github.com/elastic/fleet-server/v7/internal/pkg/checkin"
+	"github.com/elastic/fleet-server/v7/internal/pkg/clientip"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/drain"
+	"github.com/elastic/fleet-server/v7/internal/pkg/file/uploader"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/monitor"
 	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+	"github.com/elastic/fleet-server/v7/internal/pkg/seen"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	"github.com/elastic/fleet-server/v7/internal/pkg/unenroll"
 
+	"github.com/elastic/elastic-agent-client/v7/pkg/client"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/hashicorp/go-version"
 	"github.com/miolini/datacounter"
 	"github.com/rs/zerolog"
@@ -51,36 +64,195 @@ const (
 	kEncodingGzip  = "gzip"
 	FailedStatus   = "FAILED"
 	DegradedStatus = "DEGRADED"
-)
+	HealthyStatus  = "HEALTHY"
 
-// validActionTypes is a map of action.type and if they are valid
-// unlisted or invalid types are removed with filterActions().
-// action types should have a corresponding case in convertActionData.
-var validActionTypes = map[string]bool{
-	string(CANCEL):             true,
-	string(INPUTACTION):        true,
-	string(POLICYREASSIGN):     true,
-	string(REQUESTDIAGNOSTICS): true,
-	string(SETTINGS):           true,
-	string(UNENROLL):           true,
-	string(UPGRADE):            true,
-}
+	// remoteOutputRetryInterval is the delay before an agent is sent its policy again to retry the API key of a degraded remote output.
+	remoteOutputRetryInterval = time.Minute
+)
 
 type CheckinT struct {
-	verCon version.Constraints
-	cfg    *config.Server
-	cache  cache.Cache
-	bc     *checkin.Bulk
-	pm     policy.Monitor
-	gcp    monitor.GlobalCheckpointProvider
-	ad     *action.Dispatcher
-	tr     *action.TokenResolver
+	verCon   version.Constraints
+	cfg      *config.Server
+	reloaded reloadedCfg
+	cache    cache.Cache
+	bc       *checkin.Bulk
+	pm       policy.Monitor
+	gcp      monitor.GlobalCheckpointProvider
+	ad       *action.Dispatcher
+	tr       *action.TokenResolver
+	av       *action.Verifier
+	dt       *action.DeliveryTracker
+	targeted *action.TargetedActions
+
+	// serverVer is the version of the server, the checkins of newer agents are rejected when it is set.
+	serverVer *version.Version
+	// drain is the draining state of the server, the responses hint a backoff while draining.
+	drain *drain.State
+	// delay recommends a delay before their next checkin to the agents, it is nil if the recommendation is disabled.
+	delay *checkinDelay
 
 	// gwPool is a gzip.Writer pool intended to lower the amount of writers created when responding to checkin requests.
 	// gzip.Writer allocations are expensive (~1.2MB each) and can exhaust an instance's memory if a lot of concurrent responses are sent (this occurs when a mass-action such as an upgrade is detected).
 	// effectiveness of the pool is controlled by rate limiter configured through the limit.action_limit attribute.
 	gwPool sync.Pool
 	bulker bulk.Bulk
+	budget budget
+
+	inflight  monitoring.Int     // checkin requests being handled
+	connected monitoring.Int     // agents waiting in the long poll
+	seen      *seen.Tracker      // distinct agents that checked in
+	pending   pendingActionStats // actions pending for the agents that checked in
+}
+
+// Reload applies the reloaded server configuration to the requests handled from now on.
+func (ct *CheckinT) Reload(cfg *config.Server) {
+	ct.reloaded.p.Store(cfg)
+	if ct.delay != nil {
+		ct.delay.reload(&cfg.Limits)
+	}
+}
+
+// serverCfg returns the server configuration of the requests, as last reloaded.
+func (ct *CheckinT) serverCfg() *config.Server {
+	return ct.reloaded.get(ct.cfg)
+}
+
+// CheckinOpt is an optional setting for CheckinT.
+type CheckinOpt func(*CheckinT)
+
+// WithDeliveryTracker records the actions delivered on checkin with the tracker.
+func WithDeliveryTracker(dt *action.DeliveryTracker) CheckinOpt {
+	return func(ct *CheckinT) {
+		ct.dt = dt
+	}
+}
+
+// WithTargetedActions serves the actions that target a filter of agents from t, it is shared with the action dispatcher.
+func WithTargetedActions(t *action.TargetedActions) CheckinOpt {
+	return func(ct *CheckinT) {
+		ct.targeted = t
+	}
+}
+
+// WithCheckinStats registers the number of checkins in flight and of connected agents in reg.
+func WithCheckinStats(reg *monitoring.Registry) CheckinOpt {
+	return func(ct *CheckinT) {
+		reg.Add("inflight", &ct.inflight, monitoring.Full)
+		reg.Add("connected", &ct.connected, monitoring.Full)
+		monitoring.NewFunc(reg, "action_ttl", ct.reportActionTTL)
+		monitoring.NewFunc(reg, "pending_actions", ct.pending.report)
+		if ct.delay != nil {
+			monitoring.NewFunc(reg, "next_checkin_delay", ct.delay.report)
+		}
+	}
+}
+
+// reportActionTTL reports the time to live of the actions written without an expiration, in seconds.
+func (ct *CheckinT) reportActionTTL(_ monitoring.Mode, v monitoring.Visitor) {
+	v.OnRegistryStart()
+	defer v.OnRegistryFinished()
+
+	actions := ct.serverCfg().Actions
+	monitoring.ReportInt(v, "default", int64(actions.DefaultTTL.Seconds()))
+	monitoring.ReportNamespace(v, "types", func() {
+		for actionType, ttl := range actions.TTL {
+			monitoring.ReportInt(v, actionType, int64(ttl.Seconds()))
+		}
+	})
+}
+
+// pendingActionStatsTTL is how long the pending actions of an agent are reported after its last checkin.
+const pendingActionStatsTTL = time.Hour
+
+// pendingActionStats holds the actions pending for each agent with pending actions as of its last checkin.
+// The agents that did not check in for pendingActionStatsTTL are no longer reported.
+type pendingActionStats struct {
+	mut    sync.Mutex
+	agents map[string]agentPendingActions
+}
+
+type agentPendingActions struct {
+	count  int64
+	oldest time.Time
+	seen   time.Time
+}
+
+// Observe records the actions pending for the agent at now, an agent without pending actions is removed.
+func (st *pendingActionStats) Observe(agentID string, count int64, oldest, now time.Time) {
+	st.mut.Lock()
+	defer st.mut.Unlock()
+	if count == 0 {
+		delete(st.agents, agentID)
+		return
+	}
+	if st.agents == nil {
+		st.agents = make(map[string]agentPendingActions)
+	}
+	st.agents[agentID] = agentPendingActions{count: count, oldest: oldest, seen: now}
+}
+
+// report reports the number of agents with pending actions, and the max and 95th percentile of their number of
+// pending actions and of the age of their oldest pending action in seconds.
+func (st *pendingActionStats) report(_ monitoring.Mode, v monitoring.Visitor) {
+	now := time.Now()
+	st.mut.Lock()
+	counts := make([]int64, 0, len(st.agents))
+	ages := make([]int64, 0, len(st.agents))
+	for agentID, a := range st.agents {
+		if now.Sub(a.seen) > pendingActionStatsTTL {
+			delete(st.agents, agentID)
+			continue
+		}
+		counts = append(counts, a.count)
+		ages = append(ages, int64(now.Sub(a.oldest).Seconds()))
+	}
+	st.mut.Unlock()
+
+	v.OnRegistryStart()
+	defer v.OnRegistryFinished()
+	monitoring.ReportInt(v, "agents", int64(len(counts)))
+	for name, values := range map[string][]int64{"count": counts, "oldest_age": ages} {
+		slices.Sort(values)
+		monitoring.ReportNamespace(v, name, func() {
+			monitoring.ReportInt(v, "max", percentile(values, 100))
+			monitoring.ReportInt(v, "p95", percentile(values, 95))
+		})
+	}
+}
+
+// percentile returns the nearest-rank p-th percentile of the sorted values, 0 if there are none.
+func percentile(sorted []int64, p int) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (len(sorted)*p + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// Connected returns the number of agents waiting in the long poll.
+func (ct *CheckinT) Connected() int64 {
+	return ct.connected.Get()
+}
+
+// WithCheckinServerVersion rejects the checkins of agents newer than the server version ver.
+func WithCheckinServerVersion(ver *version.Version) CheckinOpt {
+	return func(ct *CheckinT) {
+		ct.serverVer = ver
+	}
+}
+
+// WithSeenAgents counts the agents that check in with t.
+func WithSeenAgents(t *seen.Tracker) CheckinOpt {
+	return func(ct *CheckinT) {
+		ct.seen = t
+	}
+}
+
+// WithCheckinDrain hints a backoff in the Retry-After header of the responses while d is draining.
+func WithCheckinDrain(d *drain.State) CheckinOpt {
+	return func(ct *CheckinT) {
+		ct.drain = d
+	}
 }
 
 func NewCheckinT(
@@ -92,11 +264,16 @@ func NewCheckinT(
 	gcp monitor.GlobalCheckpointProvider,
 	ad *action.Dispatcher,
 	bulker bulk.Bulk,
+	opts ...CheckinOpt,
 ) (*CheckinT, error) {
 	tr, err := action.NewTokenResolver(bulker)
 	if err != nil {
 		return nil, err
 	}
+	av, err := action.NewVerifier(cfg.ActionSigning)
+	if err != nil {
+		return nil, err
+	}
 	ct := &CheckinT{
 		verCon: verCon,
 		cfg:    cfg,
@@ -106,6 +283,7 @@ func NewCheckinT(
 		gcp:    gcp,
 		ad:     ad,
 		tr:     tr,
+		av:     av,
 		gwPool: sync.Pool{
 			New: func() any {
 				zipper, err := gzip.NewWriterLevel(io.Discard, cfg.CompressionLevel)
@@ -116,48 +294,78 @@ func NewCheckinT(
 			},
 		},
 		bulker: bulker,
+		budget: budget(cfg.Budgets.Checkin),
+	}
+	if cfg.CheckinDelay.Enabled {
+		ct.delay = newCheckinDelay(cfg, ct.Connected)
+	}
+	for _, opt := range opts {
+		opt(ct)
+	}
+	if ct.targeted == nil {
+		if ct.targeted, err = action.NewTargetedActions(bulker); err != nil {
+			return nil, err
+		}
 	}
 
 	return ct, nil
 }
 
-func (ct *CheckinT) handleCheckin(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, id, userAgent string) error {
+func (ct *CheckinT) handleCheckin(w http.ResponseWriter, r *http.Request, id, userAgent string) error {
 	start := time.Now()
+	ct.inflight.Inc()
+	defer ct.inflight.Dec()
 
-	agent, err := authAgent(r, &id, ct.bulker, ct.cache)
+	actx, cancel := ct.budget.auth(r.Context())
+	agent, err := authAgent(r.WithContext(actx), &id, ct.bulker, ct.cache)
+	err = budgetErr(actx, err)
+	cancel()
 	if err != nil {
-		// invalidate remote API keys of force unenrolled agents
+		// invalidate the API keys of force unenrolled agents
 		if errors.Is(err, ErrAgentInactive) && agent != nil {
-			ctx := zlog.WithContext(r.Context())
-			invalidateAPIKeysOfInactiveAgent(ctx, zlog, ct.bulker, agent)
+			zlog := zerolog.Ctx(r.Context()).With().Str(LogAgentID, agent.Id).Logger()
+			invalidateAPIKeysOfInactiveAgent(zlog.WithContext(r.Context()), ct.bulker, agent)
 		}
 		return err
 	}
 
-	zlog = zlog.With().Str(LogAccessAPIKeyID, agent.AccessAPIKeyID).Logger()
-	ctx := zlog.WithContext(r.Context())
-	r = r.WithContext(ctx)
+	ct.seen.Add(agent.Id)
+	zlog := zerolog.Ctx(r.Context()).With().
+		Str(LogAgentID, agent.Id).
+		Str(LogAccessAPIKeyID, agent.AccessAPIKeyID).
+		Logger()
+	r = r.WithContext(zlog.WithContext(r.Context()))
 
-	ver, err := validateUserAgent(r.Context(), zlog, userAgent, ct.verCon)
+	if err := checkUserAgentVersion(userAgent, ct.serverVer); err != nil {
+		return err
+	}
+	ver, err := validateUserAgent(r.Context(), userAgent, ct.verCon)
 	if err != nil {
 		return err
 	}
 
 	// Safely check if the agent version is different, return empty string otherwise
 	newVer := agent.CheckDifferentVersion(ver)
-	return ct.ProcessRequest(zlog, w, r, start, agent, newVer)
+	if ct.serverCfg().Timeouts.CheckinKeepAlive <= 0 {
+		return ct.ProcessRequest(w, r, start, agent, newVer)
+	}
+
+	kw := newKeepAliveWriter(w, ct.serverCfg().Timeouts.CheckinKeepAlive)
+	err = ct.ProcessRequest(kw, r, start, agent, newVer)
+	if err != nil && kw.sent {
+		// The status of the response was sent with the keep-alive bytes, the connection is aborted so the agent retries the checkin.
+		cntCheckin.IncError(err)
+		zlog.Warn().Err(err).Msg("checkin failed after keep-alive bytes were written, aborting response")
+		panic(http.ErrAbortHandler)
+	}
+	return err
 }
 
-func invalidateAPIKeysOfInactiveAgent(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, agent *model.Agent) {
-	remoteAPIKeys := make([]model.ToRetireAPIKeyIdsItems, 0)
-	apiKeys := agent.APIKeyIDs()
-	for _, key := range apiKeys {
-		if key.Output != "" {
-			remoteAPIKeys = append(remoteAPIKeys, key)
-		}
+// invalidateAPIKeysOfInactiveAgent invalidates the API keys of a force unenrolled agent once, a failed invalidation is retried by the GC.
+func invalidateAPIKeysOfInactiveAgent(ctx context.Context, bulker bulk.Bulk, agent *model.Agent) {
+	if err := unenroll.InvalidateInactive(ctx, bulker, agent); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("handleCheckin API keys of inactive agent remain to be invalidated")
 	}
-	zlog.Info().Any("fleet.policy.apiKeyIDsToRetire", remoteAPIKeys).Msg("handleCheckin invalidate remote API keys")
-	invalidateAPIKeys(ctx, zlog, bulker, remoteAPIKeys, "")
 }
 
 // validatedCheckin is a struct to wrap all the things that validateRequest returns.
@@ -168,51 +376,46 @@ type validatedCheckin struct {
 	rawComp         []byte
 	seqno           sqn.SeqNo
 	unhealthyReason *[]string
+	status          string
 }
 
-func (ct *CheckinT) validateRequest(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, start time.Time, agent *model.Agent) (validatedCheckin, error) {
+func (ct *CheckinT) validateRequest(w http.ResponseWriter, r *http.Request, start time.Time, agent *model.Agent) (validatedCheckin, error) {
 	span, ctx := apm.StartSpan(r.Context(), "validateRequest", "validate")
 	defer span.End()
+	zlog := zerolog.Ctx(ctx)
 
 	body := r.Body
 	// Limit the size of the body to prevent malicious agent from exhausting RAM in server
-	if ct.cfg.Limits.CheckinLimit.MaxBody > 0 {
-		body = http.MaxBytesReader(w, body, ct.cfg.Limits.CheckinLimit.MaxBody)
+	if ct.serverCfg().Limits.CheckinLimit.MaxBody > 0 {
+		body = http.MaxBytesReader(w, body, ct.serverCfg().Limits.CheckinLimit.MaxBody)
 	}
 	readCounter := datacounter.NewReaderCounter(body)
 
 	var val validatedCheckin
 	var req CheckinRequest
-	decoder := json.NewDecoder(readCounter)
-	if err := decoder.Decode(&req); err != nil {
-		return val, &BadRequestErr{msg: "unable to decode checkin request", nextErr: err}
+	if err := decodeRequest(ctx, readCounter, &req, "checkin"); err != nil {
+		return val, err
 	}
 	cntCheckin.bodyIn.Add(readCounter.Count())
 
-	if req.Status == CheckinRequestStatus("") {
-		return val, &BadRequestErr{msg: "checkin status missing"}
+	if !req.Status.isKnown() {
+		// the status is stored as is, a newer agent may report a state this version does not know
+		zlog.Warn().Str("status", string(req.Status)).Msg("checkin request status is not a known value")
 	}
 	if len(req.Message) == 0 {
 		zlog.Warn().Msg("checkin request method is empty.")
 	}
 
-	var pDur time.Duration
-	var err error
-	if req.PollTimeout != nil {
-		pDur, err = time.ParseDuration(*req.PollTimeout)
-		if err != nil {
-			return val, &BadRequestErr{msg: "poll_timeout cannot be parsed as duration", nextErr: err}
-		}
-	}
+	pDur := req.pollTimeout()
 
-	pollDuration := ct.cfg.Timeouts.CheckinLongPoll
+	pollDuration := ct.longPoll(agent.PolicyID)
 	// set the pollDuration if pDur parsed from poll_timeout was a non-zero value
 	// sets timeout is set to max(1m, min(pDur-2m, max poll time))
 	// sets the response write timeout to max(2m, timeout+1m)
 	if pDur != time.Duration(0) {
 		pollDuration = pDur - (2 * time.Minute)
-		if pollDuration > ct.cfg.Timeouts.CheckinMaxPoll {
-			pollDuration = ct.cfg.Timeouts.CheckinMaxPoll
+		if pollDuration > ct.serverCfg().Timeouts.CheckinMaxPoll {
+			pollDuration = ct.serverCfg().Timeouts.CheckinMaxPoll
 		}
 		if pollDuration < time.Minute {
 			pollDuration = time.Minute
@@ -228,20 +431,33 @@ func (ct *CheckinT) validateRequest(zlog zerolog.Logger, w http.ResponseWriter,
 	}
 	zlog.Trace().Dur("pollDuration", pollDuration).Msg("Request poll duration set.")
 
+	if ct.serverVer != nil && req.LocalMetadata != nil {
+		agentVer, err := localMetadataVersion(*req.LocalMetadata)
+		if err != nil {
+			return val, err
+		}
+		if err := checkAgentVersion(agentVer, ct.serverVer); err != nil {
+			return val, err
+		}
+	}
+
 	// Compare local_metadata content and update if different
-	rawMeta, err := parseMeta(zlog, agent, &req)
+	rawMeta, err := parseMeta(ctx, agent, &req)
 	if err != nil {
 		return val, &BadRequestErr{msg: "unable to parse meta", nextErr: err}
 	}
 
 	// Compare agent_components content and update if different
-	rawComponents, unhealthyReason, err := parseComponents(zlog, agent, &req)
+	rawComponents, unhealthyReason, digest, err := parseComponents(ctx, agent, &req)
 	if err != nil {
 		return val, err
 	}
 
 	// Resolve AckToken from request, fallback on the agent record
-	seqno, err := ct.resolveSeqNo(ctx, zlog, req, agent)
+	rctx, cancel := ct.budget.read(ctx)
+	seqno, err := ct.resolveSeqNo(rctx, req, agent)
+	err = budgetErr(rctx, err)
+	cancel()
 	if err != nil {
 		return val, err
 	}
@@ -253,13 +469,54 @@ func (ct *CheckinT) validateRequest(zlog zerolog.Logger, w http.ResponseWriter,
 		rawComp:         rawComponents,
 		seqno:           seqno,
 		unhealthyReason: unhealthyReason,
+		status:          checkinStatus(req.Status, digest),
 	}, nil
 }
 
-func (ct *CheckinT) ProcessRequest(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, start time.Time, agent *model.Agent, ver string) error {
-	zlog = zlog.With().
-		Str(logger.AgentID, agent.Id).Logger()
-	validated, err := ct.validateRequest(zlog, w, r, start, agent)
+// CheckinDelaySchedule returns the schedule computing the delay recommended to the agents before their next checkin,
+// ok is false if the recommendation is disabled.
+func (ct *CheckinT) CheckinDelaySchedule() (s scheduler.Schedule, ok bool) {
+	if ct.delay == nil {
+		return scheduler.Schedule{}, false
+	}
+	return ct.delay.Schedule(), true
+}
+
+// nextCheckinDelay returns the delay recommended to the agents of the policy before their next checkin.
+func (ct *CheckinT) nextCheckinDelay(policyID string) time.Duration {
+	var policyDelay time.Duration
+	if ct.pm != nil {
+		if limits, ok := ct.pm.Limits(policyID); ok {
+			policyDelay = limits.NextCheckinDelay
+		}
+	}
+	return ct.delay.next(policyDelay)
+}
+
+// longPoll returns the long poll duration of the agents of the policy, it is set by the policy or the server timeouts.
+// The duration set by the policy is capped to the max poll duration of the server.
+func (ct *CheckinT) longPoll(policyID string) time.Duration {
+	if ct.pm == nil {
+		return ct.serverCfg().Timeouts.CheckinLongPoll
+	}
+	limits, ok := ct.pm.Limits(policyID)
+	if !ok || limits.CheckinLongPoll == 0 {
+		return ct.serverCfg().Timeouts.CheckinLongPoll
+	}
+	if maxPoll := ct.serverCfg().Timeouts.CheckinMaxPoll; maxPoll > 0 && limits.CheckinLongPoll > maxPoll {
+		return maxPoll
+	}
+	return limits.CheckinLongPoll
+}
+
+// ProcessRequest handles the checkin of the authenticated agent.
+// It logs with the logger of the request context, which is expected to carry the agent id.
+func (ct *CheckinT) ProcessRequest(w http.ResponseWriter, r *http.Request, start time.Time, agent *model.Agent, ver string) error {
+	zlog := zerolog.Ctx(r.Context())
+	// The operations before the long poll share the total budget, the operations once it ends are bounded separately.
+	setupCtx, cancelSetup := ct.budget.total(r.Context())
+	defer cancelSetup()
+	validated, err := ct.validateRequest(w, r.WithContext(setupCtx), start, agent)
 	if err != nil {
 		return err
 	}
@@ -270,32 +527,52 @@ func (ct *CheckinT) ProcessRequest(zlog zerolog.Logger, w http.ResponseWriter, r
 	seqno := validated.seqno
 	unhealthyReason := validated.unhealthyReason
 
+	state := agent.State()
+	if err := dl.CheckAgentTransition(r.Context(), agent.Id, state, state.CheckinState()); err != nil {
+		return err
+	}
+
 	// Handle upgrade details for agents using the new 8.11 upgrade details field of the checkin.
 	// Older agents will communicate any issues with upgrades via the Ack endpoint.
-	if err := ct.processUpgradeDetails(r.Context(), agent, req.UpgradeDetails); err != nil {
+	wctx, cancel := ct.budget.write(setupCtx)
+	err = budgetErr(wctx, ct.processUpgradeDetails(wctx, agent, req.UpgradeDetails))
+	cancel()
+	if err != nil {
 		return fmt.Errorf("failed to update upgrade_details: %w", err)
 	}
 
 	// Subscribe to actions dispatcher
-	aSub := ct.ad.Subscribe(zlog, agent.Id, seqno)
-	defer ct.ad.Unsubscribe(zlog, aSub)
+	aSub, err := ct.ad.Subscribe(r.Context(), *zlog, agent.Id, seqno)
+	if err != nil {
+		return fmt.Errorf("subscribe action dispatcher: %w", err)
+	}
+	defer ct.ad.Unsubscribe(*zlog, aSub)
 	actCh := aSub.Ch()
 
 	// use revision_idx=0 if the agent has a single output where no API key is defined
 	// This will force the policy monitor to emit a new policy to regerate API keys
 	revID := agent.PolicyRevisionIdx
-	for _, output := range agent.Outputs {
-		if output.APIKey == "" {
+	outputsHealth := ct.bulker.RemoteOutputHealth()
+	for name, output := range agent.Outputs {
+		if output.APIKey == "" && retryOutputAPIKey(outputsHealth[name], time.Now()) {
 			revID = 0
 			break
 		}
 	}
 
 	// Subscribe to policy manager for changes on PolicyId > policyRev
-	sub, err := ct.pm.Subscribe(agent.Id, agent.PolicyID, revID)
+	sub, err := ct.pm.Subscribe(r.Context(), agent.Id, agent.PolicyID, revID)
 	if err != nil {
 		return fmt.Errorf("subscribe policy monitor: %w", err)
 	}
+	trace := logger.TraceDecision(zlog, agent.Id, "policy_subscription", "subscribed").
+		Str(logger.PolicyID, agent.PolicyID).
+		Int64("agent_revision_idx", agent.PolicyRevisionIdx).
+		Int64("subscription_revision_idx", revID)
+	if revID != agent.PolicyRevisionIdx {
+		trace = trace.Str(logger.DecisionReason, "output without API key, policy is sent again")
+	}
+	trace.Msg("subscribed to policy changes")
 	defer func() {
 		err := ct.pm.Unsubscribe(sub)
 		if err != nil {
@@ -304,11 +581,11 @@ func (ct *CheckinT) ProcessRequest(zlog zerolog.Logger, w http.ResponseWriter, r
 	}()
 
 	// Update check-in timestamp on timeout
-	tick := time.NewTicker(ct.cfg.Timeouts.CheckinTimestamp)
+	tick := time.NewTicker(ct.serverCfg().Timeouts.CheckinTimestamp)
 	defer tick.Stop()
 
 	setupDuration := time.Since(start)
-	pollDuration, jitter := calcPollDuration(zlog, pollDuration, setupDuration, ct.cfg.Timeouts.CheckinJitter)
+	pollDuration, jitter := calcPollDuration(r.Context(), pollDuration, setupDuration, ct.serverCfg().Timeouts.CheckinJitter)
 
 	zlog.Debug().
 		Str("status", string(req.Status)).
@@ -325,82 +602,167 @@ func (ct *CheckinT) ProcessRequest(zlog zerolog.Logger, w http.ResponseWriter, r
 	// Initial update on checkin, and any user fields that might have changed
 	// Run a script to remove audit_unenrolled_* and unenrolled_at attributes if one is set on checkin.
 	// 8.16.x releases would incorrectly set unenrolled_at
-	err = ct.bc.CheckIn(agent.Id, string(req.Status), req.Message, rawMeta, rawComponents, seqno, ver, unhealthyReason, agent.AuditUnenrolledReason != "" || agent.UnenrolledAt != "")
+	err = ct.bc.CheckIn(agent.Id, state, validated.status, req.Message, rawMeta, rawComponents, seqno, ver, unhealthyReason, agent.AuditUnenrolledReason != "" || agent.UnenrolledAt != "")
 	if err != nil {
-		zlog.Error().Err(err).Str(logger.AgentID, agent.Id).Msg("checkin failed")
+		zlog.Error().Err(err).Msg("checkin failed")
 	}
+	ct.recordConnection(r, agent)
 
 	// Initial fetch for pending actions
-	var (
-		actions  []Action
-		ackToken string
-	)
-
 	// Check agent pending actions first
-	pendingActions, err := ct.fetchAgentPendingActions(r.Context(), seqno, agent.Id)
+	rctx, cancel := ct.budget.read(setupCtx)
+	actions, ackToken, heldUntil, outOfWindow, err := ct.pendingActions(rctx, seqno, agent)
+	err = budgetErr(rctx, err)
+	cancel()
 	if err != nil {
 		return err
 	}
-	pendingActions = filterActions(zlog, agent.Id, pendingActions)
-	actions, ackToken = convertActions(zlog, agent.Id, pendingActions)
+
+	// the long poll is expected to take up to pollDuration and is not counted as slow
+	slow := logger.SlowTimerFromContext(r.Context())
+	slow.Phase("setup")
 
 	span, ctx := apm.StartSpan(r.Context(), "longPoll", "process")
+	span.Context.SetLabel("agent_id", agent.Id)
+	span.Context.SetLabel("action_count", len(actions))
 
 	if len(actions) == 0 {
+		// the agent is counted as connected until the response is written
+		ct.connected.Inc()
+		defer ct.connected.Dec()
+
+		// Wake up the long poll when a held back action is scheduled to start.
+		var scheduled <-chan time.Time
+		if !heldUntil.IsZero() {
+			scheduled = time.After(time.Until(heldUntil))
+		}
+
+		// Keep the connection active while the long poll waits.
+		var keepAlive <-chan time.Time
+		kw, ok := w.(*keepAliveWriter)
+		if ok {
+			keepAliveTick := time.NewTicker(kw.interval)
+			defer keepAliveTick.Stop()
+			keepAlive = keepAliveTick.C
+		}
 	LOOP:
 		for {
 			select {
 			case <-ctx.Done():
 				defer span.End()
+				slow.Wait("long_poll")
 				// If the request context is canceled, the API server is shutting down.
 				// We want to immediately stop the long-poll and return a 200 with the ackToken and no actions.
 				if errors.Is(ctx.Err(), context.Canceled) {
-					resp := CheckinResponse{
-						AckToken: &ackToken,
-						Action:   "checkin",
-					}
-					return ct.writeResponse(zlog, w, r, agent, resp)
+					return ct.writeResponse(w, r, agent, checkinResponse{ackToken: ackToken})
 				}
 				return ctx.Err()
 			case acdocs := <-actCh:
-				var acs []Action
-				acdocs = filterActions(zlog, agent.Id, acdocs)
-				acs, ackToken = convertActions(zlog, agent.Id, acdocs)
+				var acs []checkinAction
+				var window int
+				var until time.Time
+				acdocs = filterActions(ctx, agent.Id, acdocs)
+				acdocs = ct.verifyActions(ctx, agent, acdocs)
+				acdocs, window, until = scheduleActions(ctx, agent.Id, time.Now(), ct.serverCfg().Actions, acdocs)
+				if !heldUntil.IsZero() {
+					// Dispatched actions are newer than the held back action, the ack token must not move past it.
+					window = 0
+				}
+				acs, _ = convertActions(ctx, agent.Id, acdocs)
+				if token := actionsAckToken(acdocs[:window]); token != "" {
+					ackToken = token
+				}
+				outOfWindow = append(outOfWindow, acdocs[window:]...)
 				actions = append(actions, acs...)
-				break LOOP
+				if len(actions) > 0 {
+					break LOOP
+				}
+				if !until.IsZero() && (heldUntil.IsZero() || until.Before(heldUntil)) {
+					heldUntil = until
+					scheduled = time.After(time.Until(heldUntil))
+				}
+			case <-scheduled:
+				zlog.Trace().Time("startTime", heldUntil).Msg("scheduled action start time reached")
+				rctx, cancel := ct.budget.read(ctx)
+				actions, ackToken, heldUntil, outOfWindow, err = ct.pendingActions(rctx, seqno, agent)
+				err = budgetErr(rctx, err)
+				cancel()
+				if err != nil {
+					span.End()
+					return err
+				}
+				if len(actions) > 0 {
+					break LOOP
+				}
+				if !heldUntil.IsZero() {
+					scheduled = time.After(time.Until(heldUntil))
+				}
 			case policy := <-sub.Output():
-				actionResp, err := processPolicy(ctx, zlog, ct.bulker, agent.Id, policy)
+				wctx, cancel := ct.budget.write(ctx)
+				actionResp, err := processPolicy(wctx, ct.bulker, agent.Id, policy, ct.policyDelivery(ver))
+				err = budgetErr(wctx, err)
+				cancel()
+				if errors.Is(err, ErrPolicyTooLarge) {
+					// the agent keeps running its current policy, the checkin goes on without the policy change
+					cntPolicySizes.IncRefused()
+					zlog.Warn().Err(err).
+						Str(logger.PolicyID, policy.Policy.PolicyID).
+						Int64(logger.RevisionIdx, policy.Policy.RevisionIdx).
+						Msg("policy change not delivered, the agent can't fetch policies larger than the max policy size")
+					continue
+				}
 				if err != nil {
 					span.End()
 					return fmt.Errorf("processPolicy: %w", err)
 				}
+				logger.TraceDecision(zlog, agent.Id, "action", "included").
+					Str(logger.ActionType, string(POLICYCHANGE)).
+					Str(logger.PolicyID, policy.Policy.PolicyID).
+					Int64(logger.RevisionIdx, policy.Policy.RevisionIdx).
+					Msg("Policy change included in check in response")
 				actions = append(actions, *actionResp)
 				break LOOP
 			case <-longPoll.C:
 				zlog.Trace().Msg("fire long poll")
 				break LOOP
+			case <-aSub.Preempted():
+				// A newer checkin of the agent is waiting, this connection was likely abandoned by the agent.
+				zlog.Debug().Msg("checkin preempted by a newer checkin of the agent")
+				break LOOP
+			case <-keepAlive:
+				if err := kw.keepAlive(); err != nil {
+					span.End()
+					return fmt.Errorf("checkin keep-alive: %w", err)
+				}
 			case <-tick.C:
-				err := ct.bc.CheckIn(agent.Id, string(req.Status), req.Message, nil, rawComponents, nil, ver, unhealthyReason, false)
+				err := ct.bc.CheckIn(agent.Id, state, validated.status, req.Message, nil, rawComponents, nil, ver, unhealthyReason, false)
 				if err != nil {
-					zlog.Error().Err(err).Str(logger.AgentID, agent.Id).Msg("checkin failed")
+					zlog.Error().Err(err).Msg("checkin failed")
 				}
 			}
 		}
 	}
 	span.End()
+	slow.Wait("long_poll")
 
-	resp := CheckinResponse{
-		AckToken: &ackToken,
-		Action:   "checkin",
-		Actions:  &actions,
-	}
+	ct.trackDelivery(r.Context(), agent.Id, actions)
+	ct.markUpgradeStarted(r.Context(), agent, actions)
 
-	return ct.writeResponse(zlog, w, r, agent, resp)
+	if err := ct.writeResponse(w, r, agent, checkinResponse{ackToken: ackToken, actions: actions}); err != nil {
+		return err
+	}
+	// the actions outside of the seqno window are delivered again on the next checkin if the response is not written
+	ct.recordOutOfWindowDelivery(r.Context(), agent, outOfWindow)
+	return nil
 }
 
 func (ct *CheckinT) verifyActionExists(vCtx context.Context, vSpan *apm.Span, agent *model.Agent, details *UpgradeDetails) (*model.Action, error) {
 	action, ok := ct.cache.GetAction(details.ActionId)
 	if !ok {
+		if ct.cache.NotFound(cache.KindAction, details.ActionId) {
+			vSpan.End()
+			return nil, nil
+		}
 		actions, err := dl.FindAction(vCtx, ct.bulker, details.ActionId)
 		if err != nil {
 			vSpan.End()
@@ -409,6 +771,7 @@ func (ct *CheckinT) verifyActionExists(vCtx context.Context, vSpan *apm.Span, ag
 		if len(actions) == 0 {
 			vSpan.End()
 			zerolog.Ctx(vCtx).Warn().Msgf("upgrade_details no action for id %q found (agent id %q)", details.ActionId, agent.Agent.ID)
+			ct.cache.SetNotFound(cache.KindAction, details.ActionId)
 			return nil, nil
 		}
 		action = actions[0]
@@ -530,24 +893,72 @@ func (ct *CheckinT) markUpgradeComplete(ctx context.Context, agent *model.Agent)
 	span.Context.SetLabel("agent_id", agent.Agent.ID)
 	defer span.End()
 	// if the checkin had no details, but agent has details treat like a successful upgrade
-	doc := bulk.UpdateFields{
-		dl.FieldUpgradeDetails:   nil,
-		dl.FieldUpgradeStartedAt: nil,
-		dl.FieldUpgradeStatus:    nil,
-		dl.FieldUpgradedAt:       time.Now().UTC().Format(time.RFC3339),
+	// the upgrade is not completed again when another writer already removed the details
+	_, err := dl.UpdateAgent(ctx, ct.bulker, agent, func(agent *model.Agent) bulk.UpdateFields {
+		if agent.UpgradeDetails == nil {
+			return nil
+		}
+		return bulk.UpdateFields{
+			dl.FieldUpgradeDetails:   nil,
+			dl.FieldUpgradeStartedAt: nil,
+			dl.FieldUpgradeStatus:    dl.UpgradeStatusCompleted,
+			dl.FieldUpgradedAt:       ftime.Now(),
+		}
+	})
+	return err
+}
+
+// markUpgradeStarted records the start of the upgrade of the agent when an UPGRADE action is delivered.
+// The start of an upgrade to the same version is kept when the action is delivered again.
+// A failure is logged and does not fail the checkin, the upgrade is still tracked through the ack and the upgrade details.
+func (ct *CheckinT) markUpgradeStarted(ctx context.Context, agent *model.Agent, actions []checkinAction) {
+	zlog := zerolog.Ctx(ctx)
+	var version, actionID string
+	for _, a := range actions {
+		if data, ok := a.payload.(*ActionUpgrade); ok {
+			version, actionID = data.Version, a.Id
+		}
 	}
-	body, err := doc.Marshal()
+	if version == "" {
+		return
+	}
+
+	updated, err := dl.UpdateAgent(ctx, ct.bulker, agent, func(agent *model.Agent) bulk.UpdateFields {
+		if agent.UpgradeStatus == dl.UpgradeStatusStarted && agent.UpgradeTargetVersion == version {
+			return nil
+		}
+		return bulk.UpdateFields{
+			dl.FieldUpgradeStartedAt:     ftime.Now(),
+			dl.FieldUpgradeStatus:        dl.UpgradeStatusStarted,
+			dl.FieldUpgradeTargetVersion: version,
+		}
+	})
 	if err != nil {
-		return err
+		zlog.Error().Err(err).Str(logger.ActionID, actionID).Msg("unable to mark agent upgrade as started")
+		return
 	}
-	return ct.bulker.Update(ctx, dl.FleetAgents, agent.Id, body, bulk.WithRefresh(), bulk.WithRetryOnConflict(3))
+	if !updated {
+		return
+	}
+	zlog.Info().Str(logger.ActionID, actionID).Str("targetVersion", version).Msg("agent upgrade started")
 }
 
-func (ct *CheckinT) writeResponse(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, agent *model.Agent, resp CheckinResponse) error {
+// writeResponse writes the checkin response. A checkin is never answered with a 304, the agent acks the actions of
+// the response, the policy change included, and moves its ack token.
+func (ct *CheckinT) writeResponse(w http.ResponseWriter, r *http.Request, agent *model.Agent, resp checkinResponse) error {
 	ctx := r.Context()
+	zlog := zerolog.Ctx(ctx)
+
+	// a draining server hints the agents to check in with another instance, unless the headers were already sent
+	if ct.drain.Draining() && !keepAliveSent(w) {
+		setDrainRetryAfter(w, ct.cfg.Draining.RetryAfter)
+	}
+	if ct.delay != nil {
+		resp.nextCheckinDelay = ct.nextCheckinDelay(agent.PolicyID).String()
+	}
 	var links []apm.SpanLink
 	if ct.bulker.HasTracer() {
-		for _, a := range fromPtr(resp.Actions) {
+		for _, a := range resp.actions {
 			if fromPtr(a.Traceparent) != "" {
 				traceContext, err := apmhttp.ParseTraceparentHeader(fromPtr(a.Traceparent))
 				if err != nil {
@@ -565,19 +976,19 @@ func (ct *CheckinT) writeResponse(zlog zerolog.Logger, w http.ResponseWriter, r
 		}
 	}
 
-	if len(fromPtr(resp.Actions)) > 0 {
+	if len(resp.actions) > 0 {
 		var span *apm.Span
 		span, ctx = apm.StartSpanOptions(ctx, "action delivery", "fleet-server", apm.SpanOptions{
 			Links: links,
 		})
-		span.Context.SetLabel("action_count", len(fromPtr(resp.Actions)))
+		span.Context.SetLabel("action_count", len(resp.actions))
 		span.Context.SetLabel("agent_id", agent.Id)
 		defer span.End()
 	}
 
-	for _, action := range fromPtr(resp.Actions) {
+	for _, action := range resp.actions {
 		zlog.Info().
-			Str("ackToken", fromPtr(resp.AckToken)).
+			Str("ackToken", resp.ackToken).
 			Str("createdAt", action.CreatedAt).
 			Str(logger.ActionID, action.Id).
 			Str(logger.ActionType, string(action.Type)).
@@ -588,15 +999,21 @@ func (ct *CheckinT) writeResponse(zlog zerolog.Logger, w http.ResponseWriter, r
 	rSpan, _ := apm.StartSpan(ctx, "response", "write")
 	defer rSpan.End()
 
-	payload, err := json.Marshal(&resp)
-	if err != nil {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := newCheckinEncoder(r).encode(buf, &resp); err != nil {
 		return fmt.Errorf("writeResponse marshal: %w", err)
 	}
+	// drop the newline added by the encoder
+	payload := buf.Bytes()[:buf.Len()-1]
+	var err error
 
 	compressionLevel := ct.cfg.CompressionLevel
 	compressThreshold := ct.cfg.CompressionThresh
 
-	if len(payload) > compressThreshold && compressionLevel != flate.NoCompression && acceptsEncoding(r, kEncodingGzip) {
+	// the headers were sent with the keep-alive bytes, the response can not be compressed
+	compress := len(payload) > compressThreshold && compressionLevel != flate.NoCompression && acceptsEncoding(r, kEncodingGzip)
+	if compress && !keepAliveSent(w) {
 		wrCounter := datacounter.NewWriterCounter(w)
 
 		zipper, _ := ct.gwPool.Get().(*gzip.Writer)
@@ -644,14 +1061,27 @@ func acceptsEncoding(r *http.Request, encoding string) bool {
 }
 
 // Resolve AckToken from request, fallback on the agent record
-func (ct *CheckinT) resolveSeqNo(ctx context.Context, zlog zerolog.Logger, req CheckinRequest, agent *model.Agent) (sqn.SeqNo, error) {
+func (ct *CheckinT) resolveSeqNo(ctx context.Context, req CheckinRequest, agent *model.Agent) (sqn.SeqNo, error) {
 	span, ctx := apm.StartSpan(ctx, "resolveSeqNo", "validate")
 	defer span.End()
+	zlog := zerolog.Ctx(ctx)
 	var err error
 	// Resolve AckToken from request, fallback on the agent record
 	ackToken := req.AckToken
 	var seqno sqn.SeqNo = agent.ActionSeqNo
 
+	// Tokens issued by this version of fleet-server carry the seqno, older tokens are action document ids.
+	if ackToken != nil {
+		sn, err := sqn.ParseToken(*ackToken)
+		if err == nil {
+			return sn, nil
+		}
+		if !errors.Is(err, sqn.ErrNotToken) {
+			zlog.Debug().Err(err).Str("token", *ackToken).Msg("invalid seqno token")
+			return seqno, nil
+		}
+	}
+
 	if ct.tr != nil && ackToken != nil {
 		var sn int64
 		sn, err = ct.tr.Resolve(ctx, *ackToken)
@@ -679,15 +1109,354 @@ func (ct *CheckinT) fetchAgentPendingActions(ctx context.Context, seqno sqn.SeqN
 	return actions, err
 }
 
+// trackDelivery records the delivery of actions read from the actions index.
+// POLICY_CHANGE actions are generated on checkin and are not tracked.
+func (ct *CheckinT) trackDelivery(ctx context.Context, agentID string, actions []checkinAction) {
+	if ct.dt == nil || len(actions) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(actions))
+	for _, a := range actions {
+		if a.Type != POLICYCHANGE {
+			ids = append(ids, a.Id)
+		}
+	}
+	ct.dt.Delivered(*zerolog.Ctx(ctx), agentID, ids...)
+}
+
+// pendingActions fetches the actions pending for the agent and prepares them for delivery.
+// The actions that target a filter the agent matches are delivered after the actions addressed to the agent. They are
+// outside of the seqno window of the agent, like the actions delivered after a held back action, and are returned to
+// record their delivery once the response is written.
+// If an action is held back the earliest time a held back action is scheduled to start is returned.
+func (ct *CheckinT) pendingActions(ctx context.Context, seqno sqn.SeqNo, agent *model.Agent) ([]checkinAction, string, time.Time, []model.Action, error) {
+	pending, err := ct.fetchAgentPendingActions(ctx, seqno, agent.Id)
+	if err != nil {
+		return nil, "", time.Time{}, nil, err
+	}
+	targeted, err := ct.targetedActions(ctx, agent)
+	if err != nil {
+		return nil, "", time.Time{}, nil, err
+	}
+	now := time.Now()
+	ttl := ct.serverCfg().Actions
+	pending = filterActions(ctx, agent.Id, pending)
+	pending = ct.verifyActions(ctx, agent, pending)
+	targeted = filterActions(ctx, agent.Id, targeted)
+	targeted = ct.verifyActions(ctx, agent, targeted)
+	ct.recordPendingActions(ctx, agent, now, ttl, append(slices.Clip(pending), targeted...))
+	delivered, err := ct.deliveredAfterScheduled(ctx, agent, pending)
+	if err != nil {
+		return nil, "", time.Time{}, nil, err
+	}
+
+	pending, window, heldUntil := scheduleActions(ctx, agent.Id, now, ttl, pending)
+	// the ack token moves past the actions already delivered before the first held back action
+	ackToken := actionsAckToken(pending[:window])
+	outOfWindow := excludeDelivered(ctx, agent.Id, pending[window:], delivered)
+	pending = append(excludeDelivered(ctx, agent.Id, pending[:window], delivered), outOfWindow...)
+
+	targeted, _, targetedUntil := scheduleActions(ctx, agent.Id, now, ttl, targeted)
+	if !targetedUntil.IsZero() && (heldUntil.IsZero() || targetedUntil.Before(heldUntil)) {
+		heldUntil = targetedUntil
+	}
+
+	actions, _ := convertActions(ctx, agent.Id, append(pending, targeted...))
+	return actions, ackToken, heldUntil, append(outOfWindow, targeted...), nil
+}
+
+// deliveredAfterScheduled returns the IDs of the actions after the first action with a start_time that were already
+// delivered to the agent. They were delivered outside of the seqno window of the agent while the scheduled action was
+// held back, and are deduplicated by their delivery record or their result.
+func (ct *CheckinT) deliveredAfterScheduled(ctx context.Context, agent *model.Agent, actions []model.Action) (map[string]bool, error) {
+	i := slices.IndexFunc(actions, func(a model.Action) bool { return a.StartTime != "" })
+	if i < 0 || i == len(actions)-1 {
+		return nil, nil
+	}
+	ids := make([]string, 0, len(actions)-i-1)
+	for _, a := range actions[i+1:] {
+		ids = append(ids, a.ActionID)
+	}
+	delivered, err := ct.targeted.Delivered(ctx, agent.Id, ids)
+	if err != nil {
+		return nil, fmt.Errorf("pendingActions results: %w", err)
+	}
+	return delivered, nil
+}
+
+// excludeDelivered returns the actions that are not in delivered.
+func excludeDelivered(ctx context.Context, agentID string, actions []model.Action, delivered map[string]bool) []model.Action {
+	resp := make([]model.Action, 0, len(actions))
+	for _, a := range actions {
+		if delivered[a.ActionID] {
+			logger.TraceDecision(zerolog.Ctx(ctx), agentID, "action", "excluded").Str(logger.DecisionReason, "already delivered").
+				Str(logger.ActionID, a.ActionID).Msg("Removing action delivered after a held back action from check in response")
+			continue
+		}
+		resp = append(resp, a)
+	}
+	return resp
+}
+
+// recordConnection records the connection the agent checked in with on its document, with its checkin and only when
+// it changed. The address of the agent is resolved through the trusted proxies, it is only recorded when it is an IP
+// address and the configuration allows it.
+func (ct *CheckinT) recordConnection(r *http.Request, agent *model.Agent) {
+	cfg := ct.cfg.ConnectionMetadata
+	if !cfg.Enabled {
+		return
+	}
+	var conn model.LastConnection
+	if cfg.RemoteAddress {
+		if addr, err := netip.ParseAddr(clientip.FromRequest(r)); err == nil {
+			conn.RemoteAddress = addr.String()
+		}
+	}
+	if r.TLS != nil {
+		conn.TlsVersion = logger.TLSVersionToString(r.TLS.Version)
+		conn.TlsCipher = tls.CipherSuiteName(r.TLS.CipherSuite)
+		conn.Protocol = r.TLS.NegotiatedProtocol
+	}
+
+	last := model.LastConnection{}
+	if agent.LastConnection != nil {
+		last = *agent.LastConnection
+	}
+	if conn == last {
+		return
+	}
+	ct.bc.SetConnection(agent.Id, conn)
+	agent.LastConnection = &conn
+}
+
+// recordPendingActions records the number of unexpired actions pending for the agent and the age of the oldest one,
+// the held back actions are pending and the delivered actions stay pending until the agent acks their delivery with
+// the ack token of a later checkin.
+// They are written on the agent document with its checkin only when they changed, the age is truncated to the minute.
+func (ct *CheckinT) recordPendingActions(ctx context.Context, agent *model.Agent, now time.Time, ttl config.Actions, actions []model.Action) {
+	var (
+		count  int64
+		oldest time.Time
+	)
+	for _, a := range actions {
+		if expiration, err := actionExpiration(a, ttl); err == nil && !expiration.IsZero() && !expiration.After(now) {
+			continue
+		}
+		count++
+		ts, err := ftime.Parse(a.Timestamp)
+		if err != nil {
+			zerolog.Ctx(ctx).Debug().Err(err).Str(logger.ActionID, a.ActionID).Msg("Unable to parse action timestamp")
+			continue
+		}
+		if oldest.IsZero() || ts.Before(oldest) {
+			oldest = ts
+		}
+	}
+	pending := checkin.PendingActions{Count: count}
+	if !oldest.IsZero() && now.After(oldest) {
+		pending.OldestAge = int64(now.Sub(oldest).Truncate(time.Minute).Seconds())
+	}
+	ct.pending.Observe(agent.Id, pending.Count, oldest, now)
+
+	if pending.Count == agent.PendingActionsCount && pending.OldestAge == agent.OldestPendingActionAge {
+		return
+	}
+	ct.bc.SetPendingActions(agent.Id, pending)
+	agent.PendingActionsCount = pending.Count
+	agent.OldestPendingActionAge = pending.OldestAge
+}
+
+// targetedActions returns the unexpired actions that target a filter the agent matches and that were not yet delivered
+// to it. The filters are evaluated against the agent document read on checkin, the actions the agent acked or that
+// were delivered to it have a result for the agent in the action results index.
+func (ct *CheckinT) targetedActions(ctx context.Context, agent *model.Agent) ([]model.Action, error) {
+	span, ctx := apm.StartSpan(ctx, "targetedActions", "search")
+	defer span.End()
+
+	actions, err := ct.targeted.Actions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("targetedActions: %w", err)
+	}
+	zlog := zerolog.Ctx(ctx)
+	matched := make([]model.Action, 0, len(actions))
+	ids := make([]string, 0, len(actions))
+	for _, a := range actions {
+		ok, err := action.MatchTarget(a.Target, agent)
+		if err != nil {
+			zlog.Warn().Err(err).Str(logger.ActionID, a.ActionID).Msg("Unable to evaluate action target")
+			continue
+		}
+		if !ok {
+			continue
+		}
+		matched = append(matched, a)
+		ids = append(ids, a.ActionID)
+	}
+	if len(matched) == 0 {
+		return nil, nil
+	}
+
+	delivered, err := ct.targeted.Delivered(ctx, agent.Id, ids)
+	if err != nil {
+		return nil, fmt.Errorf("targetedActions results: %w", err)
+	}
+	resp := matched[:0]
+	for _, a := range matched {
+		if delivered[a.ActionID] {
+			logger.TraceDecision(zlog, agent.Id, "action", "excluded").Str(logger.DecisionReason, "already delivered").
+				Str(logger.ActionID, a.ActionID).Msg("Removing targeted action from check in response")
+			continue
+		}
+		resp = append(resp, a)
+	}
+	return resp, nil
+}
+
+// recordOutOfWindowDelivery records the delivery of the actions outside of the seqno window of the agent, the targeted
+// actions and the actions delivered after a held back action, so they are not delivered again.
+// A delivery that fails to be recorded is only logged, the action is then delivered again on the next checkin.
+func (ct *CheckinT) recordOutOfWindowDelivery(ctx context.Context, agent *model.Agent, actions []model.Action) {
+	now := ftime.Now()
+	for _, a := range actions {
+		if err := ct.targeted.RecordDelivery(ctx, model.ActionResult{
+			ActionID:        a.ActionID,
+			ActionInputType: a.InputType,
+			AgentID:         agent.Id,
+			Namespaces:      resultNamespaces(agent, a.Namespaces),
+			Status:          action.DeliveryStatusDelivered,
+			Timestamp:       now,
+		}); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str(logger.AgentID, agent.Id).Str(logger.ActionID, a.ActionID).Msg("Failed to record action delivery")
+		}
+	}
+}
+
+// scheduleActions removes the expired actions from the passed list and holds back actions with a start_time after now.
+// The actions without an expiration expire once they are older than the time to live of their type in ttl.
+// A held back action does not hold back the actions after it. Actions are ordered by sequence number, so the number of
+// returned actions before the first held back action is returned as well: the ack token must not move past the held back
+// action or the agent would never receive it. The earliest start time of the held back actions is returned, or a zero time.
+// Timestamps that fail to parse do not prevent delivery.
+func scheduleActions(ctx context.Context, agentID string, now time.Time, ttl config.Actions, actions []model.Action) ([]model.Action, int, time.Time) {
+	zlog := zerolog.Ctx(ctx)
+	resp := make([]model.Action, 0, len(actions))
+	window := -1
+	var heldUntil time.Time
+	for _, action := range actions {
+		expiration, err := actionExpiration(action, ttl)
+		if err != nil {
+			zlog.Warn().Err(err).Str(logger.ActionID, action.ActionID).Msg("Unable to parse action expiration")
+		} else if !expiration.IsZero() && !expiration.After(now) {
+			reason := "expired"
+			if action.Expiration == "" {
+				reason = "ttl exceeded"
+			}
+			logger.TraceDecision(zlog, agentID, "action", "excluded").Str(logger.DecisionReason, reason).
+				Str(logger.ActionID, action.ActionID).Msg("Removing expired action from check in response")
+			continue
+		}
+		if action.StartTime != "" {
+			startTime, err := ftime.Parse(action.StartTime)
+			if err != nil {
+				zlog.Warn().Err(err).Str(logger.ActionID, action.ActionID).Msg("Unable to parse action start_time")
+			} else if startTime.After(now) {
+				logger.TraceDecision(zlog, agentID, "action", "held").Str(logger.DecisionReason, "start time not reached").
+					Str(logger.ActionID, action.ActionID).Time("startTime", startTime).Msg("Holding back scheduled action")
+				if window < 0 {
+					window = len(resp)
+				}
+				if heldUntil.IsZero() || startTime.Before(heldUntil) {
+					heldUntil = startTime
+				}
+				continue
+			}
+		}
+		resp = append(resp, action)
+	}
+	if window < 0 {
+		window = len(resp)
+	}
+	return resp, window, heldUntil
+}
+
+// actionExpiration returns the expiration of the action, or the zero time if it does not expire.
+// An explicit expiration always applies, the actions without one expire once they are older than the TTL of their type.
+func actionExpiration(action model.Action, ttl config.Actions) (time.Time, error) {
+	if action.Expiration != "" {
+		return ftime.Parse(action.Expiration)
+	}
+	d := ttl.ActionTTL(action.Type)
+	if d <= 0 || action.Timestamp == "" {
+		return time.Time{}, nil
+	}
+	created, err := ftime.Parse(action.Timestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("action creation time: %w", err)
+	}
+	return created.Add(d), nil
+}
+
+// verifyActions removes the actions that fail signature verification from the passed list.
+// A result is recorded for each removed action so the failure is visible to operators.
+func (ct *CheckinT) verifyActions(ctx context.Context, agent *model.Agent, actions []model.Action) []model.Action {
+	zlog := zerolog.Ctx(ctx)
+	resp := make([]model.Action, 0, len(actions))
+	for _, a := range actions {
+		err := ct.av.Verify(a)
+		if err == nil {
+			resp = append(resp, a)
+			continue
+		}
+		zlog.Warn().Err(err).Str(logger.AgentID, agent.Id).Str(logger.ActionID, a.ActionID).Str(logger.ActionType, a.Type).
+			Str(logger.Decision, "action").Str(logger.DecisionResult, "excluded").Str(logger.DecisionReason, "signature verification failed").
+			Msg("Removing action that failed signature verification from check in response")
+		now := ftime.Now()
+		if err := dl.CreateActionResult(ctx, ct.bulker, model.ActionResult{
+			ActionID:        a.ActionID,
+			ActionInputType: a.InputType,
+			AgentID:         agent.Id,
+			CompletedAt:     now,
+			Error:           err.Error(),
+			Namespaces:      resultNamespaces(agent, a.Namespaces),
+			Status:          action.ActionResultStatusSignatureInvalid,
+			Timestamp:       now,
+		}); err != nil {
+			zlog.Error().Err(err).Str(logger.ActionID, a.ActionID).Msg("Failed to record action signature verification result")
+		}
+	}
+	return resp
+}
+
 // filterActions removes the POLICY_CHANGE, UPDATE_TAGS, FORCE_UNENROLL action from the passed list as well as any unknown action types.
 // The source of this list are documents from the fleet actions index.
 // The POLICY_CHANGE action that the agent receives are generated by the fleet-server when it detects a different policy in processRequest()
 // The UPDATE_TAGS, FORCE_UNENROLL actions are UI only actions, should not be delivered to agents
-func filterActions(zlog zerolog.Logger, agentID string, actions []model.Action) []model.Action {
+// The actions of unknown types are reported as unsupported with a warning.
+// The UPGRADE actions re-issued by fleet-server after a failed upgrade are removed when a later UPGRADE action supersedes them.
+func filterActions(ctx context.Context, agentID string, actions []model.Action) []model.Action {
+	lastUpgrade := -1
+	for i, action := range actions {
+		if action.Type == string(UPGRADE) {
+			lastUpgrade = i
+		}
+	}
 	resp := make([]model.Action, 0, len(actions))
-	for _, action := range actions {
-		if valid := validActionTypes[action.Type]; !valid {
-			zlog.Info().Str(logger.AgentID, agentID).Str(logger.ActionID, action.ActionID).Str(logger.ActionType, action.Type).Msg("Removing action found in index from check in response")
+	for i, action := range actions {
+		if deliver, known, reason := actionDelivery(action.Type); !known {
+			zerolog.Ctx(ctx).Warn().Str(logger.AgentID, agentID).Str(logger.ActionID, action.ActionID).Str(logger.ActionType, action.Type).
+				Str(logger.Decision, "action").Str(logger.DecisionResult, "unsupported").Str(logger.DecisionReason, reason).
+				Msg("Removing action of an unknown action type from check in response")
+			continue
+		} else if !deliver {
+			zerolog.Ctx(ctx).Info().Str(logger.AgentID, agentID).Str(logger.ActionID, action.ActionID).Str(logger.ActionType, action.Type).
+				Str(logger.Decision, "action").Str(logger.DecisionResult, "excluded").Str(logger.DecisionReason, reason).
+				Msg("Removing action found in index from check in response")
+			continue
+		}
+		if action.Type == string(UPGRADE) && action.RetryOf != "" && i < lastUpgrade {
+			zerolog.Ctx(ctx).Info().Str(logger.AgentID, agentID).Str(logger.ActionID, action.ActionID).Str(logger.ActionType, action.Type).
+				Str(logger.Decision, "action").Str(logger.DecisionResult, "excluded").Str(logger.DecisionReason, "upgrade retry superseded by a newer upgrade").
+				Msg("Removing upgrade retry from check in response")
 			continue
 		}
 		resp = append(resp, action)
@@ -695,90 +1464,83 @@ func filterActions(zlog zerolog.Logger, agentID string, actions []model.Action)
 	return resp
 }
 
-// convertActionData converts the passed raw message data to Action_Data using aType as a discriminator.
-//
-// raw is first parsed into the action-specific data struct then passed into Action_Data in order to remove any undefined keys.
-//
-// TODO: There is a lot of repitition in this method we should try to clean up.
-//
-//nolint:nakedret // FIXME try to refactor this in the future
-func convertActionData(aType ActionType, raw json.RawMessage) (ad Action_Data, err error) {
+// errUnsupportedActionType is returned for the actions of a type the checkin responses do not deliver.
+var errUnsupportedActionType = errors.New("unsupported action type")
+
+// decodeActionData decodes raw into the data struct of the actions of type aType, the payload of a checkinAction.
+// The undefined keys of raw are dropped.
+func decodeActionData(aType ActionType, raw json.RawMessage) (any, error) {
+	var d any
 	switch aType {
 	case CANCEL:
-		d := ActionCancel{}
-		err = json.Unmarshal(raw, &d)
-		if err != nil {
-			return
-		}
-		err = ad.FromActionCancel(d)
-		return
+		d = &ActionCancel{}
 	case INPUTACTION:
-		d := ActionInputAction{}
-		err = json.Unmarshal(raw, &d)
-		if err != nil {
-			return
+		var input ActionInputAction
+		if err := json.Unmarshal(raw, &input); err != nil {
+			return nil, err
 		}
-		err = ad.FromActionInputAction(d)
-		return
+		return input, nil
 	case POLICYREASSIGN:
-		d := ActionPolicyReassign{}
-		err = json.Unmarshal(raw, &d)
-		if err != nil {
-			return
-		}
-		err = ad.FromActionPolicyReassign(d)
-		return
+		d = &ActionPolicyReassign{}
 	case SETTINGS:
-		d := ActionSettings{}
-		err = json.Unmarshal(raw, &d)
-		if err != nil {
-			return
-		}
-		err = ad.FromActionSettings(d)
-		return
+		d = &ActionSettings{}
 	case UPGRADE:
-		d := ActionUpgrade{}
-		err = json.Unmarshal(raw, &d)
-		if err != nil {
-			return
-		}
-		err = ad.FromActionUpgrade(d)
-		return
+		d = &ActionUpgrade{}
 	case REQUESTDIAGNOSTICS:
-		d := ActionRequestDiagnostics{}
 		// NOTE: action data was added to diagnostics actions in #3333
 		// fleet ui creates actions without a data attribute and fleet-server needs to be backwards compatible with these actions.
 		if raw == nil {
-			return
-		}
-		err = json.Unmarshal(raw, &d)
-		if err != nil {
-			return
+			return nil, nil
 		}
-		err = ad.FromActionRequestDiagnostics(d)
-		return
+		d = &ActionRequestDiagnostics{}
 	case UNENROLL: // Action types with no data
-		return ad, nil
+		return nil, nil
 	default:
-		return ad, fmt.Errorf("data conversion unsupported action type: %s", aType)
+		return nil, fmt.Errorf("%w: %s", errUnsupportedActionType, aType)
+	}
+	if err := json.Unmarshal(raw, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// withDiagnosticsUpload adds the upload the agent sends the diagnostics bundle to to the REQUEST_DIAGNOSTICS action data.
+func withDiagnosticsUpload(d *ActionRequestDiagnostics, actionID, agentID string) *ActionRequestDiagnostics {
+	if d == nil {
+		d = &ActionRequestDiagnostics{}
 	}
+	uploadID := uploader.UploadID(actionID, agentID)
+	uploadPath := uploadBeginPath
+	d.UploadId = &uploadID
+	d.UploadPath = &uploadPath
+	return d
 }
 
-func convertActions(zlog zerolog.Logger, agentID string, actions []model.Action) ([]Action, string) {
-	var ackToken string
+func convertActions(ctx context.Context, agentID string, actions []model.Action) ([]checkinAction, string) {
 	sz := len(actions)
 
-	respList := make([]Action, 0, sz)
+	respList := make([]checkinAction, 0, sz)
 	for _, action := range actions {
-		ad, err := convertActionData(ActionType(action.Type), action.Data)
+		payload, err := decodeActionData(ActionType(action.Type), action.Data)
 		if err != nil {
-			zlog.Error().Err(err).Str(logger.ActionID, action.ActionID).Str(logger.ActionType, action.Type).Msg("Failed to convert action.Data")
+			reason := "invalid action data"
+			if errors.Is(err, errUnsupportedActionType) {
+				reason = "unsupported action type"
+			}
+			zerolog.Ctx(ctx).Error().Err(err).Str(logger.AgentID, agentID).Str(logger.ActionID, action.ActionID).Str(logger.ActionType, action.Type).
+				Str(logger.Decision, "action").Str(logger.DecisionResult, "excluded").Str(logger.DecisionReason, reason).
+				Msg("Failed to convert action.Data")
 			continue
 		}
+		if ActionType(action.Type) == REQUESTDIAGNOSTICS {
+			d, _ := payload.(*ActionRequestDiagnostics)
+			payload = withDiagnosticsUpload(d, action.ActionID, agentID)
+		}
+		logger.TraceDecision(zerolog.Ctx(ctx), agentID, "action", "included").
+			Str(logger.ActionID, action.ActionID).Str(logger.ActionType, action.Type).Msg("Action included in check in response")
 		r := Action{
 			AgentId:   agentID,
 			CreatedAt: action.Timestamp,
-			Data:      ad,
 			Id:        action.ActionID,
 			Type:      ActionType(action.Type),
 			InputType: action.InputType,
@@ -801,31 +1563,72 @@ func convertActions(zlog zerolog.Logger, agentID string, actions []model.Action)
 				Signature: action.Signed.Signature,
 			}
 		}
-		respList = append(respList, r)
+		respList = append(respList, checkinAction{Action: r, payload: payload})
 	}
 
-	if sz > 0 {
-		ackToken = actions[sz-1].Id
+	return respList, actionsAckToken(actions)
+}
+
+// actionsAckToken returns the ack token of the delivered actions, or an empty string if none has a seqno.
+// The ack token encodes the highest delivered seqno, actions that were not read from the index do not have one.
+// The targeted actions are read outside of the seqno window of the agent and are deduplicated by their delivery record.
+func actionsAckToken(actions []model.Action) string {
+	var seqno sqn.SeqNo
+	for _, action := range actions {
+		if action.Id != "" && action.Target == nil {
+			seqno = seqno.Max(sqn.SeqNo{action.SeqNo})
+		}
+	}
+	if seqno.IsSet() {
+		return seqno.Token()
+	}
+	return ""
+}
+
+// retryOutputAPIKey returns true if the missing API key of an output can be created again, the remote outputs that
+// recently failed to create it are retried after remoteOutputRetryInterval instead of on each checkin.
+func retryOutputAPIKey(health bulk.OutputHealth, now time.Time) bool {
+	if health.State != client.UnitStateDegraded.String() {
+		return true
 	}
+	return now.Sub(health.UpdatedAt) >= remoteOutputRetryInterval
+}
+
+// policyDelivery is how the policy revisions are delivered to an agent.
+type policyDelivery struct {
+	size config.PolicySize
+	// fetch is set when the agent fetches the revisions larger than the max policy size from the policy endpoint.
+	fetch bool
+}
 
-	return respList, ackToken
+// policyDelivery returns how the policy revisions are delivered to the agent of version ver.
+func (ct *CheckinT) policyDelivery(ver string) policyDelivery {
+	return policyDelivery{
+		size:  ct.serverCfg().Limits.PolicySize,
+		fetch: canFetchPolicy(ver),
+	}
 }
 
 // A new policy exists for this agent.  Perform the following:
 //   - Generate and update default ApiKey if roles have changed.
 //   - Rewrite the policy for delivery to the agent injecting the key material.
-func processPolicy(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, agentID string, pp *policy.ParsedPolicy) (*Action, error) {
+//
+// The size of the revision is recorded, a revision larger than the max policy size is not delivered inline: the action
+// only holds the fields of the agent and tells it to fetch the policy from the policy endpoint.
+// ErrPolicyTooLarge is returned if the agent can't fetch it.
+func processPolicy(ctx context.Context, bulker bulk.Bulk, agentID string, pp *policy.ParsedPolicy, delivery policyDelivery) (*checkinAction, error) {
 	var links []apm.SpanLink = nil // set to a nil array to preserve default behaviour if no policy links are found
 	if err := pp.Links.Trace.Validate(); err == nil {
 		links = []apm.SpanLink{pp.Links}
 	}
 	span, ctx := apm.StartSpanOptions(ctx, "processPolicy", "process", apm.SpanOptions{Links: links})
 	defer span.End()
-	zlog = zlog.With().
+	zlog := zerolog.Ctx(ctx).With().
 		Str("fleet.ctx", "processPolicy").
 		Int64(logger.RevisionIdx, pp.Policy.RevisionIdx).
 		Str(LogPolicyID, pp.Policy.PolicyID).
 		Logger()
+	ctx = zlog.WithContext(ctx)
 
 	// Repull and decode the agent object. Do not trust the cache.
 	bSpan, bCtx := apm.StartSpan(ctx, "findAgent", "search")
@@ -858,51 +1661,149 @@ func processPolicy(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, a
 				policyOutput.Name, err)
 		}
 	}
-	// Add replace inputs with agent prepared version.
-	data.Inputs = pp.Inputs
 
-	// JSON transformations to turn a model.PolicyData into an Action.data
-	p, err := json.Marshal(data)
+	// The policy is encoded once per revision, only the outputs holding the agent's API keys are encoded for each agent.
+	body, err := pp.Body(marshalPolicyBody)
 	if err != nil {
 		return nil, err
 	}
-	d := PolicyData{}
-	err = json.Unmarshal(p, &d)
-	if err != nil {
-		return nil, err
+	size := len(body)
+	if cntPolicySizes.Observe(pp.Policy.PolicyID, pp.Policy.RevisionIdx, size) && delivery.size.Warn > 0 && size > delivery.size.Warn {
+		zlog.Warn().Int("size", size).Int("warn_byte_size", delivery.size.Warn).Msg("policy revision is larger than the policy size warning threshold")
+	}
+	inline := delivery.size.Max <= 0 || size <= delivery.size.Max
+	if !inline && !delivery.fetch {
+		return nil, fmt.Errorf("%w: revision of %d bytes, max %d", ErrPolicyTooLarge, size, delivery.size.Max)
 	}
+
 	// remove duplicates from secretkeys
 	slices.Sort(pp.SecretKeys)
 	keys := slices.Compact(pp.SecretKeys)
-	d.SecretPaths = &keys
-	ad := Action_Data{}
-	err = ad.FromActionPolicyChange(ActionPolicyChange{d})
+	var p []byte
+	if inline {
+		p, err = policyChangeData(body, data.Outputs, keys)
+	} else {
+		zlog.Debug().Int("size", size).Msg("policy revision delivered by reference")
+		p, err = policyFetchData(pp, size, data.Outputs, keys)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	r := policy.RevisionFromPolicy(pp.Policy)
-	resp := Action{
-		AgentId:   agent.Id,
-		CreatedAt: pp.Policy.Timestamp,
-		Data:      ad,
-		Id:        r.String(),
-		Type:      POLICYCHANGE,
+	resp := checkinAction{
+		Action: Action{
+			AgentId:   agent.Id,
+			CreatedAt: pp.Policy.Timestamp,
+			Id:        r.String(),
+			Type:      POLICYCHANGE,
+		},
+		payload: policyChangePayload(p),
 	}
 
 	return &resp, nil
 }
 
-func getAgentAndVerifyAPIKeyID(ctx context.Context, bulker bulk.Bulk, agentID string, apiKeyID string) (*model.Agent, error) {
+// revisionETag returns the entity tag of data encoding the revision of the policy.
+func revisionETag(pp *policy.ParsedPolicy, data []byte) string {
+	sum := sha256.Sum256(data)
+	return weakETag(fmt.Sprintf("%d.%d-%s", pp.Policy.RevisionIdx, pp.Policy.CoordinatorIdx, hex.EncodeToString(sum[:8])))
+}
+
+// marshalPolicyBody encodes the PolicyData delivered to the agents without the outputs and the secret paths,
+// the inputs are replaced by the agent prepared version.
+func marshalPolicyBody(pp *policy.ParsedPolicy) ([]byte, error) {
+	data := *pp.Policy.Data
+	data.Inputs = pp.Inputs
+	data.Outputs = nil
+
+	// JSON transformations to turn a model.PolicyData into a PolicyData
+	p, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	d := PolicyData{}
+	if err := json.Unmarshal(p, &d); err != nil {
+		return nil, err
+	}
+	d.Outputs = nil
+	d.SecretPaths = nil
+	return json.Marshal(d)
+}
+
+// policyChangeData returns the ActionPolicyChange encoding of the policy body encoded by marshalPolicyBody,
+// with the outputs and secret paths of the agent.
+func policyChangeData(body []byte, outputs map[string]map[string]interface{}, secretPaths []string) ([]byte, error) {
+	o, err := json.Marshal(outputs)
+	if err != nil {
+		return nil, err
+	}
+	s, err := json.Marshal(secretPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	const (
+		prefix  = `{"policy":{"outputs":`
+		secrets = `,"secret_paths":`
+	)
+	p := make([]byte, 0, len(prefix)+len(o)+len(secrets)+len(s)+len(body)+2)
+	p = append(p, prefix...)
+	p = append(p, o...)
+	p = append(p, secrets...)
+	p = append(p, s...)
+	if len(body) > len("{}") {
+		// splice the fields of the body after the agent fields
+		p = append(p, ',')
+		p = append(p, body[1:]...)
+	} else {
+		p = append(p, '}')
+	}
+	return append(p, '}'), nil
+}
+
+// policyFetchData returns the ActionPolicyChange encoding of a revision the agent fetches from the policy endpoint.
+// The policy only holds its id and revision with the outputs and secret paths of the agent.
+func policyFetchData(pp *policy.ParsedPolicy, size int, outputs map[string]map[string]interface{}, secretPaths []string) ([]byte, error) {
+	ref, err := json.Marshal(struct {
+		ID       string `json:"id"`
+		Revision int64  `json:"revision"`
+	}{pp.Policy.PolicyID, pp.Policy.RevisionIdx})
+	if err != nil {
+		return nil, err
+	}
+	p, err := policyChangeData(ref, outputs, secretPaths)
+	if err != nil {
+		return nil, err
+	}
+	fetch, err := json.Marshal(PolicyFetch{Path: policyPath(pp.Policy.PolicyID, pp.Policy.RevisionIdx), Size: size})
+	if err != nil {
+		return nil, err
+	}
+	// splice the fetch field after the policy
+	p = append(p[:len(p)-1], `,"fetch":`...)
+	p = append(p, fetch...)
+	return append(p, '}'), nil
+}
+
+// getAgentAndVerifyAPIKeyID returns the agent with the API key. An agent that is not found, a deleted agent with a valid
+// API key, is not looked up again until its not found entry in c expires.
+func getAgentAndVerifyAPIKeyID(ctx context.Context, bulker bulk.Bulk, c cache.Cache, agentID string, apiKeyID string) (*model.Agent, error) {
 	span, ctx := apm.StartSpan(ctx, "getAgentAndVerifyAPIKeyID", "read")
 	defer span.End()
+	if c.NotFound(cache.KindAgent, agentID) {
+		return nil, fmt.Errorf("invalid API Key ID %w", ErrAgentIdentity)
+	}
 	agent, err := dl.GetAgent(ctx, bulker, agentID)
 	if err != nil {
 		if errors.Is(err, dl.ErrNotFound) {
+			c.SetNotFound(cache.KindAgent, agentID)
 			err = ErrAgentNotFound
 		} else {
 			err = fmt.Errorf("GetAgent: %w", err)
 		}
+	} else if v, ok := c.GetAgentVersion(agentID); ok {
+		err = checkWrittenAgent(agent, v)
 	}
 
 	if agent.AccessAPIKeyID != apiKeyID {
@@ -912,12 +1813,44 @@ func getAgentAndVerifyAPIKeyID(ctx context.Context, bulker bulk.Bulk, agentID st
 	return &agent, err
 }
 
-func findAgentByAPIKeyID(ctx context.Context, bulker bulk.Bulk, id string) (*model.Agent, error) {
+// errStaleAgentRead is returned when an agent read by id is older than the version written by the instance.
+var errStaleAgentRead = errors.New("agent read is older than its last write")
+
+// checkWrittenAgent checks that the agent read by id is not older than the version v written by the instance.
+// The reads by id are realtime, they see the writes without a refresh of the index.
+func checkWrittenAgent(agent model.Agent, v cache.AgentVersion) error {
+	if v.Before(agent.SeqNo, agent.PrimaryTerm) {
+		return fmt.Errorf("agent %s read at seq_no %d, written at %d: %w", v.AgentID, agent.SeqNo, v.SeqNo, errStaleAgentRead)
+	}
+	return nil
+}
+
+// findAgentByAPIKeyID returns the agent with the access API key id. An agent that is not found is not looked up again
+// until its not found entry in c expires. An agent written by the instance during the last minute is read by id.
+func findAgentByAPIKeyID(ctx context.Context, bulker bulk.Bulk, c cache.Cache, id string) (*model.Agent, error) {
 	span, ctx := apm.StartSpan(ctx, "findAgentByID", "search")
 	defer span.End()
+	if c.NotFound(cache.KindAgentAPIKey, id) {
+		return nil, ErrAgentNotFound
+	}
+	if v, ok := c.GetAgentVersionByAPIKey(id); ok {
+		// the agent was written by this instance without a refresh, the search may not see it yet: it is read by id
+		agent, err := dl.GetAgent(ctx, bulker, v.AgentID)
+		if err == nil {
+			err = checkWrittenAgent(agent, v)
+		}
+		if err != nil && !errors.Is(err, dl.ErrNotFound) {
+			return nil, fmt.Errorf("findAgentByApiKeyId: %w", err)
+		}
+		if err == nil && agent.AccessAPIKeyID == id {
+			return &agent, nil
+		}
+		// the agent was deleted or got another key since, the search tells
+	}
 	agent, err := dl.FindAgent(ctx, bulker, dl.QueryAgentByAssessAPIKeyID, dl.FieldAccessAPIKeyID, id)
 	if err != nil {
 		if errors.Is(err, dl.ErrNotFound) {
+			c.SetNotFound(cache.KindAgentAPIKey, id)
 			err = ErrAgentNotFound
 		} else {
 			err = fmt.Errorf("findAgentByApiKeyId: %w", err)
@@ -928,7 +1861,8 @@ func findAgentByAPIKeyID(ctx context.Context, bulker bulk.Bulk, id string) (*mod
 
 // parseMeta compares the agent and the request local_metadata content
 // and returns fields to update the agent record or nil
-func parseMeta(zlog zerolog.Logger, agent *model.Agent, req *CheckinRequest) ([]byte, error) {
+func parseMeta(ctx context.Context, agent *model.Agent, req *CheckinRequest) ([]byte, error) {
+	zlog := zerolog.Ctx(ctx)
 	if req.LocalMetadata == nil {
 		return nil, nil
 	}
@@ -977,7 +1911,10 @@ func parseMeta(zlog zerolog.Logger, agent *model.Agent, req *CheckinRequest) ([]
 	return outMeta, nil
 }
 
-func parseComponents(zlog zerolog.Logger, agent *model.Agent, req *CheckinRequest) ([]byte, *[]string, error) {
+// parseComponents returns the normalized components of the request to store on the agent document when they changed,
+// the unhealthy reason and the digest of the components of the agent.
+func parseComponents(ctx context.Context, agent *model.Agent, req *CheckinRequest) ([]byte, *[]string, string, error) {
+	zlog := zerolog.Ctx(ctx)
 	var unhealthyReason []string
 
 	// fallback to other if components don't exist
@@ -988,48 +1925,51 @@ func parseComponents(zlog zerolog.Logger, agent *model.Agent, req *CheckinReques
 	}
 
 	if req.Components == nil {
-		return nil, &unhealthyReason, nil
+		return nil, &unhealthyReason, componentsDigest(agent.Components), nil
 	}
 
 	agentComponentsJSON, err := json.Marshal(agent.Components)
 	if err != nil {
-		return nil, &unhealthyReason, fmt.Errorf("agent.Components marshal: %w", err)
+		return nil, &unhealthyReason, "", fmt.Errorf("agent.Components marshal: %w", err)
 	}
 
 	// Quick comparison first; compare the JSON payloads.
 	// If the data is not consistently normalized, this short-circuit will not work.
 	if bytes.Equal(*req.Components, agentComponentsJSON) {
 		zlog.Trace().Msg("quick comparing agent components data is equal")
-		return nil, &unhealthyReason, nil
+		return nil, &unhealthyReason, componentsDigest(agent.Components), nil
 	}
 
 	// Deserialize the request components data
 	var reqComponents []model.ComponentsItems
 	if len(*req.Components) > 0 {
 		if err := json.Unmarshal(*req.Components, &reqComponents); err != nil {
-			return nil, &unhealthyReason, fmt.Errorf("parseComponents request: %w", err)
+			return nil, &unhealthyReason, "", fmt.Errorf("parseComponents request: %w", err)
 		}
 	}
 
 	// If empty, don't step on existing data
 	if reqComponents == nil {
-		return nil, &unhealthyReason, nil
+		return nil, &unhealthyReason, componentsDigest(agent.Components), nil
 	}
 
 	var outComponents []byte
 
-	// Compare the deserialized meta structures and return the bytes to update if different
-	if !reflect.DeepEqual(reqComponents, agent.Components) {
+	// Compare the normalized structures and return the bytes to update if different
+	components := normalizeComponents(reqComponents)
+	if !reflect.DeepEqual(components, agent.Components) {
+		outComponents, err = json.Marshal(components)
+		if err != nil {
+			return nil, &unhealthyReason, "", fmt.Errorf("parseComponents marshal: %w", err)
+		}
 
-		reqComponentsJSON, _ := json.Marshal(*req.Components)
 		zlog.Trace().
 			Str("oldComponents", string(agentComponentsJSON)).
-			Str("req.Components", string(reqComponentsJSON)).
+			Str("req.Components", string(outComponents)).
 			Msg("local components data is not equal")
 
 		zlog.Info().Msg("applying new components data")
 
-		outComponents = *req.Components
 		compUnhealthyReason := calcUnhealthyReason(reqComponents)
 		if len(compUnhealthyReason) > 0 {
 			unhealthyReason = compUnhealthyReason
@@ -1038,7 +1978,7 @@ func parseComponents(zlog zerolog.Logger, agent *model.Agent, req *CheckinReques
 
 	zlog.Debug().Any("unhealthy_reason", unhealthyReason).Msg("unhealthy reason")
 
-	return outComponents, &unhealthyReason, nil
+	return outComponents, &unhealthyReason, componentsDigest(reqComponents), nil
 }
 
 func calcUnhealthyReason(reqComponents []model.ComponentsItems) []string {
@@ -1074,7 +2014,8 @@ func calcUnhealthyReason(reqComponents []model.ComponentsItems) []string {
 	return unhealthyReason
 }
 
-func calcPollDuration(zlog zerolog.Logger, pollDuration, setupDuration, jitterDuration time.Duration) (time.Duration, time.Duration) {
+func calcPollDuration(ctx context.Context, pollDuration, setupDuration, jitterDuration time.Duration) (time.Duration, time.Duration) {
+	zlog := zerolog.Ctx(ctx)
 	// Under heavy load, elastic may take along time to authorize the api key, many seconds to minutes.
 	// Short circuit the long poll to take the setup delay into account.  This is particularly necessary
 	// in cloud where the proxy will time us out after 10m20s causing unnecessary errors.
@@ -7,9 +7,18 @@
 package api
 
 import (
+	"bytes"
 	"compress/flate"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -17,12 +26,17 @@ import (
 	"testing"
 	"time"
 
+	"github.com/elastic/fleet-server/v7/internal/pkg/action"
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
 	"github.com/elastic/fleet-server/v7/internal/pkg/checkin"
+	"github.com/elastic/fleet-server/v7/internal/pkg/clientip"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/file/uploader"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	mockmonitor "github.com/elastic/fleet-server/v7/internal/pkg/monitor/mock"
 	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
@@ -31,139 +45,141 @@ import (
 	testcache "github.com/elastic/fleet-server/v7/internal/pkg/testing/cache"
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 
+	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/hashicorp/go-version"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	apmmodel "go.elastic.co/apm/v2/model"
 )
 
-func TestConvertActionData(t *testing.T) {
+func TestDecodeActionData(t *testing.T) {
 	tests := []struct {
 		name   string
 		aType  ActionType
 		raw    json.RawMessage
-		expect Action_Data
-		hasErr bool
+		expect any
+		hasErr error
 	}{{
 		name:   "nil input fails",
 		aType:  CANCEL,
 		raw:    nil,
-		expect: Action_Data{},
-		hasErr: true,
+		hasErr: &json.SyntaxError{},
 	}, {
 		name:   "empty input succeeds",
 		aType:  CANCEL,
 		raw:    json.RawMessage(`{}`),
-		expect: Action_Data{json.RawMessage(`{"target_id":""}`)},
-		hasErr: false,
+		expect: &ActionCancel{},
 	}, {
 		name:   "cancel action",
 		aType:  CANCEL,
 		raw:    json.RawMessage(`{"target_id":"target"}`),
-		expect: Action_Data{json.RawMessage(`{"target_id":"target"}`)},
-		hasErr: false,
+		expect: &ActionCancel{TargetId: "target"},
 	}, {
 		name:   "input action",
 		aType:  INPUTACTION,
 		raw:    json.RawMessage(`{"key":"value"}`),
-		expect: Action_Data{json.RawMessage(`{"key":"value"}`)},
-		hasErr: false,
+		expect: ActionInputAction{"key": "value"},
 	}, {
 		name:   "policy reassign action",
 		aType:  POLICYREASSIGN,
 		raw:    json.RawMessage(`{"policy_id":"policy"}`),
-		expect: Action_Data{json.RawMessage(`{"policy_id":"policy"}`)},
-		hasErr: false,
+		expect: &ActionPolicyReassign{PolicyId: "policy"},
 	}, {
 		name:   "settings action",
 		aType:  SETTINGS,
 		raw:    json.RawMessage(`{"log_level":"error"}`),
-		expect: Action_Data{json.RawMessage(`{"log_level":"error"}`)},
-		hasErr: false,
+		expect: &ActionSettings{LogLevel: ptr(ActionSettingsLogLevel("error"))},
 	}, {
 		name:   "settings action trace level",
 		aType:  SETTINGS,
 		raw:    json.RawMessage(`{"log_level":"trace"}`),
-		expect: Action_Data{json.RawMessage(`{"log_level":"trace"}`)},
-		hasErr: false,
+		expect: &ActionSettings{LogLevel: ptr(ActionSettingsLogLevel("trace"))},
 	}, {
 		name:   "upgrade action",
 		aType:  UPGRADE,
-		raw:    json.RawMessage(`{"source_uri":"https://localhost:8080","version":"1.2.3"}`),
-		expect: Action_Data{json.RawMessage(`{"source_uri":"https://localhost:8080","version":"1.2.3"}`)},
-		hasErr: false,
+		raw:    json.RawMessage(`{"source_uri":"https://localhost:8080","version":"1.2.3","unknown":true}`),
+		expect: &ActionUpgrade{SourceUri: ptr("https://localhost:8080"), Version: "1.2.3"},
 	}, {
 		name:   "request diagnostics action",
 		aType:  REQUESTDIAGNOSTICS,
-		expect: Action_Data{},
-		hasErr: false,
+		expect: nil,
 	}, {
 		name:   "request diagnostics action empty data",
 		aType:  REQUESTDIAGNOSTICS,
 		raw:    json.RawMessage(`{}`),
-		expect: Action_Data{json.RawMessage(`{}`)},
-		hasErr: false,
+		expect: &ActionRequestDiagnostics{},
 	}, {
 		name:   "request diagnostics with additional cpu metric",
 		aType:  REQUESTDIAGNOSTICS,
 		raw:    json.RawMessage(`{"additional_metrics": ["CPU"]}`),
-		expect: Action_Data{json.RawMessage(`{"additional_metrics":["CPU"]}`)},
-		hasErr: false,
+		expect: &ActionRequestDiagnostics{AdditionalMetrics: &[]ActionRequestDiagnosticsAdditionalMetrics{"CPU"}},
 	}, {
 		name:   "unenroll action",
 		aType:  UNENROLL,
-		expect: Action_Data{},
-		hasErr: false,
+		expect: nil,
 	}, {
 		name:   "unknown action type",
 		aType:  ActionType("UNKNOWN"),
-		expect: Action_Data{},
-		hasErr: true,
+		hasErr: errUnsupportedActionType,
 	}}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			ad, err := convertActionData(tc.aType, tc.raw)
-			if tc.hasErr {
+			d, err := decodeActionData(tc.aType, tc.raw)
+			if tc.hasErr != nil {
 				require.Error(t, err)
-			} else {
-				require.NoError(t, err)
+				if errors.Is(tc.hasErr, errUnsupportedActionType) {
+					require.ErrorIs(t, err, errUnsupportedActionType)
+				}
+				return
 			}
-			require.Equal(t, tc.expect, ad)
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, d)
 		})
 	}
 }
 
 func TestConvertActions(t *testing.T) {
+	diagnosticsData := func(actionID string) *ActionRequestDiagnostics {
+		return &ActionRequestDiagnostics{UploadId: ptr(uploader.UploadID(actionID, "agent-id")), UploadPath: ptr("/api/fleet/uploads")}
+	}
+
 	tests := []struct {
 		name    string
 		actions []model.Action
-		resp    []Action
+		resp    []checkinAction
 		token   string
 	}{{
 		name:    "empty actions",
 		actions: nil,
-		resp:    []Action{},
+		resp:    []checkinAction{},
 		token:   "",
 	}, {
 		name:    "single action",
 		actions: []model.Action{{ActionID: "1234", Type: "REQUEST_DIAGNOSTICS", Data: json.RawMessage(`{}`)}},
-		resp: []Action{{
-			AgentId: "agent-id",
-			Id:      "1234",
-			Type:    REQUESTDIAGNOSTICS,
-			Data:    Action_Data{json.RawMessage(`{}`)},
+		resp: []checkinAction{{
+			Action: Action{
+				AgentId: "agent-id",
+				Id:      "1234",
+				Type:    REQUESTDIAGNOSTICS,
+			},
+			payload: diagnosticsData("1234"),
 		}},
 		token: "",
 	}, {
 		name:    "single action signed",
 		actions: []model.Action{{ActionID: "1234", Signed: &model.Signed{Data: "eyJAdGltZXN0YW==", Signature: "U6NOg4ssxpFV="}, Type: "REQUEST_DIAGNOSTICS", Data: json.RawMessage(`{}`)}},
-		resp: []Action{{
-			AgentId: "agent-id",
-			Id:      "1234",
-			Type:    REQUESTDIAGNOSTICS,
-			Signed:  &ActionSignature{Data: "eyJAdGltZXN0YW==", Signature: "U6NOg4ssxpFV="},
-			Data:    Action_Data{json.RawMessage(`{}`)},
+		resp: []checkinAction{{
+			Action: Action{
+				AgentId: "agent-id",
+				Id:      "1234",
+				Type:    REQUESTDIAGNOSTICS,
+				Signed:  &ActionSignature{Data: "eyJAdGltZXN0YW==", Signature: "U6NOg4ssxpFV="},
+			},
+			payload: diagnosticsData("1234"),
 		}},
 		token: "",
 	}, {name: "multiple actions",
@@ -180,24 +196,62 @@ func TestConvertActions(t *testing.T) {
 				Signed:   &model.Signed{Data: "eyJAdGltZXN0YX==", Signature: "U6NOg4ssxpFQ="},
 			},
 		},
-		resp: []Action{{
-			AgentId: "agent-id",
-			Id:      "1234",
-			Type:    REQUESTDIAGNOSTICS,
-			Data:    Action_Data{json.RawMessage(`{}`)},
+		resp: []checkinAction{{
+			Action: Action{
+				AgentId: "agent-id",
+				Id:      "1234",
+				Type:    REQUESTDIAGNOSTICS,
+			},
+			payload: diagnosticsData("1234"),
 		}, {
-			AgentId: "agent-id",
-			Id:      "5678",
-			Signed:  &ActionSignature{Data: "eyJAdGltZXN0YX==", Signature: "U6NOg4ssxpFQ="},
-			Type:    REQUESTDIAGNOSTICS,
-			Data:    Action_Data{json.RawMessage(`{}`)},
+			Action: Action{
+				AgentId: "agent-id",
+				Id:      "5678",
+				Signed:  &ActionSignature{Data: "eyJAdGltZXN0YX==", Signature: "U6NOg4ssxpFQ="},
+				Type:    REQUESTDIAGNOSTICS,
+			},
+			payload: diagnosticsData("5678"),
 		}},
 		token: "",
+	}, {
+		name: "token encodes highest seqno",
+		actions: []model.Action{
+			{ESDocument: model.ESDocument{Id: "doc-1", SeqNo: 4}, ActionID: "1234", Type: "REQUEST_DIAGNOSTICS", Data: json.RawMessage(`{}`)},
+			{ESDocument: model.ESDocument{Id: "doc-2", SeqNo: 9}, ActionID: "5678", Type: "REQUEST_DIAGNOSTICS", Data: json.RawMessage(`{}`)},
+		},
+		resp: []checkinAction{{
+			Action: Action{
+				AgentId: "agent-id",
+				Id:      "1234",
+				Type:    REQUESTDIAGNOSTICS,
+			},
+			payload: diagnosticsData("1234"),
+		}, {
+			Action: Action{
+				AgentId: "agent-id",
+				Id:      "5678",
+				Type:    REQUESTDIAGNOSTICS,
+			},
+			payload: diagnosticsData("5678"),
+		}},
+		token: "sqn:9",
+	}, {
+		name:    "token encodes seqno zero",
+		actions: []model.Action{{ESDocument: model.ESDocument{Id: "doc-1", SeqNo: 0}, ActionID: "1234", Type: "REQUEST_DIAGNOSTICS", Data: json.RawMessage(`{}`)}},
+		resp: []checkinAction{{
+			Action: Action{
+				AgentId: "agent-id",
+				Id:      "1234",
+				Type:    REQUESTDIAGNOSTICS,
+			},
+			payload: diagnosticsData("1234"),
+		}},
+		token: "sqn:0",
 	}}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			logger := testlog.SetLogger(t)
-			resp, token := convertActions(logger, "agent-id", tc.actions)
+			resp, token := convertActions(logger.WithContext(context.Background()), "agent-id", tc.actions)
 			assert.Equal(t, tc.resp, resp)
 			assert.Equal(t, tc.token, token)
 		})
@@ -256,22 +310,610 @@ func TestFilterActions(t *testing.T) {
 			Type:     "FORCE_UNENROLL",
 		}},
 		resp: []model.Action{},
+	}, {
+		name: "filter unknown action type",
+		actions: []model.Action{{
+			ActionID: "1234",
+			Type:     "CUSTOM_ACTION",
+		}},
+		resp: []model.Action{},
 	}, {
 		name: "No type is filterd",
 		actions: []model.Action{{
 			ActionID: "1234",
 		}},
 		resp: []model.Action{},
+	}, {
+		name: "filter upgrade retry superseded by a newer UPGRADE action",
+		actions: []model.Action{{
+			ActionID: "retry",
+			Type:     "UPGRADE",
+			RetryOf:  "1234",
+		}, {
+			ActionID: "5678",
+			Type:     "UNENROLL",
+		}, {
+			ActionID: "newer",
+			Type:     "UPGRADE",
+		}},
+		resp: []model.Action{{
+			ActionID: "5678",
+			Type:     "UNENROLL",
+		}, {
+			ActionID: "newer",
+			Type:     "UPGRADE",
+		}},
+	}, {
+		name: "upgrade retry is the last UPGRADE action",
+		actions: []model.Action{{
+			ActionID: "1234",
+			Type:     "UPGRADE",
+		}, {
+			ActionID: "retry",
+			Type:     "UPGRADE",
+			RetryOf:  "1234",
+		}},
+		resp: []model.Action{{
+			ActionID: "1234",
+			Type:     "UPGRADE",
+		}, {
+			ActionID: "retry",
+			Type:     "UPGRADE",
+			RetryOf:  "1234",
+		}},
 	}}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			logger := testlog.SetLogger(t)
-			resp := filterActions(logger, "agent-id", tc.actions)
+			resp := filterActions(logger.WithContext(context.Background()), "agent-id", tc.actions)
 			assert.Equal(t, tc.resp, resp)
 		})
 	}
 }
 
+func TestScheduleActions(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) string {
+		return now.Add(d).Format(time.RFC3339)
+	}
+	ttl := config.Actions{
+		DefaultTTL: time.Hour,
+		TTL:        map[string]time.Duration{"UPGRADE": 24 * time.Hour, "SETTINGS": 0},
+	}
+	tests := []struct {
+		name      string
+		ttl       config.Actions
+		actions   []model.Action
+		resp      []model.Action
+		window    int // checked when an action is held back, it is the length of resp otherwise
+		heldUntil time.Time
+	}{{
+		name:    "empty list",
+		actions: []model.Action{},
+		resp:    []model.Action{},
+	}, {
+		name: "no expiration or start time",
+		actions: []model.Action{{
+			ActionID: "1234",
+		}},
+		resp: []model.Action{{
+			ActionID: "1234",
+		}},
+	}, {
+		name: "expired action is removed",
+		actions: []model.Action{{
+			ActionID:   "1234",
+			Expiration: at(-time.Second),
+		}, {
+			ActionID:   "5678",
+			Expiration: at(time.Second),
+		}},
+		resp: []model.Action{{
+			ActionID:   "5678",
+			Expiration: at(time.Second),
+		}},
+	}, {
+		name: "action expiring now is removed",
+		actions: []model.Action{{
+			ActionID:   "1234",
+			Expiration: at(0),
+		}},
+		resp: []model.Action{},
+	}, {
+		name: "action starting now is delivered",
+		actions: []model.Action{{
+			ActionID:  "1234",
+			StartTime: at(0),
+		}},
+		resp: []model.Action{{
+			ActionID:  "1234",
+			StartTime: at(0),
+		}},
+	}, {
+		name: "scheduled action is held back without the later actions",
+		actions: []model.Action{{
+			ActionID:  "1234",
+			StartTime: at(-time.Second),
+		}, {
+			ActionID:  "5678",
+			StartTime: at(time.Second),
+		}, {
+			ActionID: "9012",
+			Type:     "UNENROLL",
+		}},
+		resp: []model.Action{{
+			ActionID:  "1234",
+			StartTime: at(-time.Second),
+		}, {
+			ActionID: "9012",
+			Type:     "UNENROLL",
+		}},
+		window:    1,
+		heldUntil: now.Add(time.Second),
+	}, {
+		name: "earliest start time of the held back actions",
+		actions: []model.Action{{
+			ActionID:  "1234",
+			StartTime: at(time.Hour),
+		}, {
+			ActionID:  "5678",
+			StartTime: at(time.Minute),
+		}},
+		resp:      []model.Action{},
+		window:    0,
+		heldUntil: now.Add(time.Minute),
+	}, {
+		name: "expired scheduled action is removed",
+		actions: []model.Action{{
+			ActionID:   "1234",
+			StartTime:  at(time.Hour),
+			Expiration: at(0),
+		}, {
+			ActionID: "5678",
+		}},
+		resp: []model.Action{{
+			ActionID: "5678",
+		}},
+	}, {
+		name: "action older than the default ttl is removed",
+		ttl:  ttl,
+		actions: []model.Action{{
+			ActionID:  "1234",
+			Type:      "UNENROLL",
+			Timestamp: at(-2 * time.Hour),
+		}, {
+			ActionID:  "5678",
+			Type:      "UNENROLL",
+			Timestamp: at(-time.Minute),
+		}},
+		resp: []model.Action{{
+			ActionID:  "5678",
+			Type:      "UNENROLL",
+			Timestamp: at(-time.Minute),
+		}},
+	}, {
+		name: "explicit expiration wins over the ttl",
+		ttl:  ttl,
+		actions: []model.Action{{
+			ActionID:   "1234",
+			Type:       "UNENROLL",
+			Timestamp:  at(-2 * time.Hour),
+			Expiration: at(time.Hour),
+		}, {
+			ActionID:   "5678",
+			Type:       "UPGRADE",
+			Timestamp:  at(-time.Minute),
+			Expiration: at(-time.Second),
+		}},
+		resp: []model.Action{{
+			ActionID:   "1234",
+			Type:       "UNENROLL",
+			Timestamp:  at(-2 * time.Hour),
+			Expiration: at(time.Hour),
+		}},
+	}, {
+		name: "type ttl overrides the default ttl",
+		ttl:  ttl,
+		actions: []model.Action{{
+			ActionID:  "1234",
+			Type:      "UPGRADE",
+			Timestamp: at(-2 * time.Hour),
+		}, {
+			ActionID:  "5678",
+			Type:      "UPGRADE",
+			Timestamp: at(-25 * time.Hour),
+		}, {
+			ActionID:  "9012",
+			Type:      "SETTINGS",
+			Timestamp: at(-25 * time.Hour),
+		}},
+		resp: []model.Action{{
+			ActionID:  "1234",
+			Type:      "UPGRADE",
+			Timestamp: at(-2 * time.Hour),
+		}, {
+			ActionID:  "9012",
+			Type:      "SETTINGS",
+			Timestamp: at(-25 * time.Hour),
+		}},
+	}, {
+		name: "old action without ttl is delivered",
+		actions: []model.Action{{
+			ActionID:  "1234",
+			Timestamp: at(-24 * 365 * time.Hour),
+		}},
+		resp: []model.Action{{
+			ActionID:  "1234",
+			Timestamp: at(-24 * 365 * time.Hour),
+		}},
+	}, {
+		name: "invalid timestamps are delivered",
+		actions: []model.Action{{
+			ActionID:   "1234",
+			StartTime:  "tomorrow",
+			Expiration: "never",
+		}},
+		resp: []model.Action{{
+			ActionID:   "1234",
+			StartTime:  "tomorrow",
+			Expiration: "never",
+		}},
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := testlog.SetLogger(t)
+			resp, window, heldUntil := scheduleActions(logger.WithContext(context.Background()), "agent-id", now, tc.ttl, tc.actions)
+			assert.Equal(t, tc.resp, resp)
+			assert.Equal(t, tc.heldUntil, heldUntil)
+			if tc.heldUntil.IsZero() {
+				assert.Equal(t, len(resp), window)
+			} else {
+				assert.Equal(t, tc.window, window)
+			}
+		})
+	}
+}
+
+func TestVerifyActions(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	cfg := &config.Server{
+		ActionSigning: config.ActionSigning{
+			PublicKey:            string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			RequireSignedActions: true,
+		},
+	}
+	bulker := ftesting.NewMockBulk()
+	var results []model.ActionResult
+	bulker.On("Create", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var acr model.ActionResult
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &acr))
+		results = append(results, acr)
+	}).Return("", nil)
+
+	ct, err := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, nil, nil, nil, nil, bulker)
+	require.NoError(t, err)
+
+	actions := []model.Action{{
+		ActionID: "diagnostics",
+		Type:     "REQUEST_DIAGNOSTICS",
+	}, {
+		ActionID: "unsigned",
+		Type:     "UPGRADE",
+	}, {
+		ActionID: "invalid",
+		Type:     "UNENROLL",
+		Signed:   &model.Signed{Data: "e30=", Signature: "e30="},
+	}}
+	resp := ct.verifyActions(testlog.SetLogger(t).WithContext(context.Background()), &model.Agent{ESDocument: model.ESDocument{Id: "agent-id"}}, actions)
+	assert.Equal(t, actions[:1], resp)
+
+	require.Len(t, results, 2)
+	for i, id := range []string{"unsigned", "invalid"} {
+		assert.Equal(t, id, results[i].ActionID)
+		assert.Equal(t, "agent-id", results[i].AgentID)
+		assert.Equal(t, action.ActionResultStatusSignatureInvalid, results[i].Status)
+		assert.NotEmpty(t, results[i].Error)
+	}
+	bulker.AssertExpectations(t)
+}
+
+// resultsBulk serves the targeted actions and keeps the action results index in memory.
+type resultsBulk struct {
+	bulk.Bulk
+	actions []model.Action
+	results map[string]model.ActionResult
+}
+
+func (b *resultsBulk) Search(_ context.Context, index string, _ []byte, _ ...bulk.Opt) (*es.ResultT, error) {
+	var hits []es.HitT
+	if index == dl.FleetActions {
+		for _, a := range b.actions {
+			src, err := json.Marshal(a)
+			if err != nil {
+				return nil, err
+			}
+			hits = append(hits, es.HitT{ID: a.Id, Source: src})
+		}
+	} else {
+		for id, acr := range b.results {
+			src, err := json.Marshal(acr)
+			if err != nil {
+				return nil, err
+			}
+			hits = append(hits, es.HitT{ID: id, Source: src})
+		}
+	}
+	return &es.ResultT{HitsT: es.HitsT{Hits: hits}}, nil
+}
+
+func (b *resultsBulk) Create(_ context.Context, _, id string, body []byte, _ ...bulk.Opt) (string, error) {
+	if _, ok := b.results[id]; ok {
+		return "", es.ErrElasticVersionConflict
+	}
+	var acr model.ActionResult
+	if err := json.Unmarshal(body, &acr); err != nil {
+		return "", err
+	}
+	b.results[id] = acr
+	return id, nil
+}
+
+func (b *resultsBulk) HasTracer() bool {
+	return false
+}
+
+func TestTargetedActions(t *testing.T) {
+	targeted := model.Action{
+		ESDocument: model.ESDocument{Id: "doc-1", SeqNo: 5},
+		ActionID:   "targeted",
+		Type:       "SETTINGS",
+		Data:       json.RawMessage(`{"log_level":"debug"}`),
+		Target:     &model.ActionTarget{PolicyID: "policy-1", Tags: []string{"production"}, Version: ">= 8.15.0"},
+	}
+	agent := func(id string, tags ...string) *model.Agent {
+		return &model.Agent{
+			ESDocument: model.ESDocument{Id: id},
+			PolicyID:   "policy-1",
+			Tags:       tags,
+			Agent:      &model.AgentMetadata{ID: id, Version: "8.16.0"},
+		}
+	}
+	newCheckinT := func() (*CheckinT, *resultsBulk) {
+		bulker := &resultsBulk{actions: []model.Action{targeted}, results: make(map[string]model.ActionResult)}
+		ta, err := action.NewTargetedActions(bulker)
+		require.NoError(t, err)
+		return &CheckinT{cfg: &config.Server{}, bulker: bulker, targeted: ta}, bulker
+	}
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	deliver := func(t *testing.T, ct *CheckinT, agent *model.Agent) []model.Action {
+		t.Helper()
+		actions, err := ct.targetedActions(ctx, agent)
+		require.NoError(t, err)
+		ct.recordOutOfWindowDelivery(ctx, agent, actions)
+		return actions
+	}
+
+	t.Run("matching agent", func(t *testing.T) {
+		ct, bulker := newCheckinT()
+		actions := deliver(t, ct, agent("agent-1", "production"))
+		require.Len(t, actions, 1)
+		require.Equal(t, "targeted", actions[0].ActionID)
+		acr, ok := bulker.results[dl.ActionDeliveryID("targeted", "agent-1")]
+		require.True(t, ok, "the delivery is recorded in the action results index")
+		require.Equal(t, action.DeliveryStatusDelivered, acr.Status)
+
+		require.Empty(t, deliver(t, ct, agent("agent-1", "production")), "a delivered action is not delivered again")
+	})
+
+	t.Run("agent that does not match", func(t *testing.T) {
+		ct, bulker := newCheckinT()
+		require.Empty(t, deliver(t, ct, agent("agent-1", "staging")))
+		require.Empty(t, bulker.results)
+	})
+
+	t.Run("agent that matches after the action is created", func(t *testing.T) {
+		ct, _ := newCheckinT()
+		require.Empty(t, deliver(t, ct, agent("agent-1")))
+		actions := deliver(t, ct, agent("agent-1", "production"))
+		require.Len(t, actions, 1)
+		require.Equal(t, "targeted", actions[0].ActionID)
+	})
+
+	t.Run("acked action", func(t *testing.T) {
+		ct, bulker := newCheckinT()
+		bulker.results["targeted:agent-1"] = model.ActionResult{ActionID: "targeted", AgentID: "agent-1"}
+		require.Empty(t, deliver(t, ct, agent("agent-1", "production")))
+	})
+
+	t.Run("ack token", func(t *testing.T) {
+		actions, ackToken := convertActions(ctx, "agent-1", []model.Action{targeted})
+		require.Len(t, actions, 1)
+		require.Empty(t, ackToken, "the targeted actions are outside of the seqno window of the agent")
+	})
+}
+
+func TestRecordPendingActions(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	now := time.Now().UTC().Truncate(time.Second)
+	var docs []map[string]json.RawMessage
+	bulker := ftesting.NewMockBulk()
+	bulker.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		for _, op := range args.Get(1).([]bulk.MultiOp) {
+			var body struct {
+				Doc map[string]json.RawMessage `json:"doc"`
+			}
+			require.NoError(t, json.Unmarshal(op.Body, &body))
+			docs = append(docs, body.Doc)
+		}
+	}).Return([]bulk.BulkIndexerResponseItem{}, nil)
+	bc := checkin.NewBulk(bulker)
+	ct := &CheckinT{cfg: &config.Server{}, bc: bc}
+	agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}}
+
+	// checkinWith runs a checkin of the agent with the pending actions and returns the fields of the agent document update.
+	checkinWith := func(t *testing.T, actions ...model.Action) map[string]json.RawMessage {
+		t.Helper()
+		docs = nil
+		require.NoError(t, bc.CheckIn(agent.Id, model.AgentStateOnline, "online", "", nil, nil, nil, "", nil, false))
+		ct.recordPendingActions(ctx, agent, now, config.Actions{}, actions)
+		require.NoError(t, bc.Schedule().WorkFn(ctx))
+		require.Len(t, docs, 1)
+		return docs[0]
+	}
+	older := model.Action{ActionID: "older", Timestamp: now.Add(-150 * time.Second).Format(time.RFC3339), Expiration: now.Add(time.Hour).Format(time.RFC3339)}
+	newer := model.Action{ActionID: "newer", Timestamp: now.Add(-10 * time.Second).Format(time.RFC3339)}
+	expired := model.Action{ActionID: "expired", Timestamp: now.Add(-time.Hour).Format(time.RFC3339), Expiration: now.Add(-time.Minute).Format(time.RFC3339)}
+
+	doc := checkinWith(t)
+	require.NotContains(t, doc, dl.FieldPendingActionsCount, "unchanged values are not written")
+
+	doc = checkinWith(t, older, newer, expired)
+	require.JSONEq(t, "2", string(doc[dl.FieldPendingActionsCount]))
+	require.JSONEq(t, "120", string(doc[dl.FieldOldestPendingActionAge]), "the age is truncated to the minute")
+
+	doc = checkinWith(t, older, newer)
+	require.NotContains(t, doc, dl.FieldPendingActionsCount, "unchanged values are not written")
+
+	// the older action is acked
+	doc = checkinWith(t, newer)
+	require.JSONEq(t, "1", string(doc[dl.FieldPendingActionsCount]))
+	require.JSONEq(t, "0", string(doc[dl.FieldOldestPendingActionAge]))
+
+	// all the actions are acked
+	doc = checkinWith(t)
+	require.JSONEq(t, "0", string(doc[dl.FieldPendingActionsCount]))
+	require.JSONEq(t, "0", string(doc[dl.FieldOldestPendingActionAge]))
+}
+
+func TestRecordConnection(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	var docs []map[string]json.RawMessage
+	bulker := ftesting.NewMockBulk()
+	bulker.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		for _, op := range args.Get(1).([]bulk.MultiOp) {
+			var body struct {
+				Doc map[string]json.RawMessage `json:"doc"`
+			}
+			require.NoError(t, json.Unmarshal(op.Body, &body))
+			docs = append(docs, body.Doc)
+		}
+	}).Return([]bulk.BulkIndexerResponseItem{}, nil)
+	bc := checkin.NewBulk(bulker)
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	ct := &CheckinT{cfg: cfg, bc: bc}
+	agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}}
+	proxies, err := clientip.New([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	// checkinFrom runs a checkin of the agent with r and returns the last_connection field of the agent document update.
+	checkinFrom := func(t *testing.T, r *http.Request) (json.RawMessage, bool) {
+		t.Helper()
+		docs = nil
+		require.NoError(t, bc.CheckIn(agent.Id, model.AgentStateOnline, "online", "", nil, nil, nil, "", nil, false))
+		clientip.Middleware(proxies)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			ct.recordConnection(r, agent)
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		require.NoError(t, bc.Schedule().WorkFn(ctx))
+		require.Len(t, docs, 1)
+		conn, ok := docs[0][dl.FieldLastConnection]
+		return conn, ok
+	}
+	request := func(remoteAddr string, state *tls.ConnectionState) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", nil)
+		r.RemoteAddr = remoteAddr
+		r.TLS = state
+		return r
+	}
+	tls13 := &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, NegotiatedProtocol: "h2"}
+
+	conn, ok := checkinFrom(t, request("192.0.2.10:41000", tls13))
+	require.True(t, ok)
+	require.JSONEq(t, `{"remote_address":"192.0.2.10","tls_version":"1.3","tls_cipher":"TLS_AES_128_GCM_SHA256","protocol":"h2"}`, string(conn))
+
+	_, ok = checkinFrom(t, request("192.0.2.10:42000", tls13))
+	require.False(t, ok, "unchanged values are not written")
+
+	t.Run("proxy headers", func(t *testing.T) {
+		r := request("10.1.2.3:443", tls13)
+		r.Header.Set(clientip.HeaderForwardedFor, "198.51.100.1, 192.0.2.20, 10.0.0.5")
+		conn, ok := checkinFrom(t, r)
+		require.True(t, ok)
+		require.JSONEq(t, `{"remote_address":"192.0.2.20","tls_version":"1.3","tls_cipher":"TLS_AES_128_GCM_SHA256","protocol":"h2"}`, string(conn),
+			"the address is the first one that is not a trusted proxy")
+
+		r = request("192.0.2.20:41000", tls13)
+		r.Header.Set(clientip.HeaderForwardedFor, "198.51.100.1")
+		_, ok = checkinFrom(t, r)
+		require.False(t, ok, "the header of an untrusted peer is ignored")
+	})
+
+	t.Run("tls changed", func(t *testing.T) {
+		conn, ok := checkinFrom(t, request("192.0.2.20:41000", &tls.ConnectionState{Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, NegotiatedProtocol: "http/1.1"}))
+		require.True(t, ok)
+		require.JSONEq(t, `{"remote_address":"192.0.2.20","tls_version":"1.2","tls_cipher":"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256","protocol":"http/1.1"}`, string(conn))
+	})
+
+	t.Run("remote address disabled", func(t *testing.T) {
+		cfg.ConnectionMetadata.RemoteAddress = false
+		defer func() { cfg.ConnectionMetadata.RemoteAddress = true }()
+
+		conn, ok := checkinFrom(t, request("192.0.2.20:41000", tls13))
+		require.True(t, ok)
+		require.JSONEq(t, `{"remote_address":null,"tls_version":"1.3","tls_cipher":"TLS_AES_128_GCM_SHA256","protocol":"h2"}`, string(conn),
+			"the address recorded before is cleared")
+
+		_, ok = checkinFrom(t, request("192.0.2.30:41000", tls13))
+		require.False(t, ok, "the address is not recorded")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg.ConnectionMetadata.Enabled = false
+		defer func() { cfg.ConnectionMetadata.Enabled = true }()
+
+		_, ok := checkinFrom(t, request("192.0.2.40:41000", nil))
+		require.False(t, ok)
+	})
+
+	t.Run("no tls", func(t *testing.T) {
+		conn, ok := checkinFrom(t, request("192.0.2.40:41000", nil))
+		require.True(t, ok)
+		require.JSONEq(t, `{"remote_address":"192.0.2.40","tls_version":null,"tls_cipher":null,"protocol":null}`, string(conn))
+	})
+}
+
+func TestPendingActionStats(t *testing.T) {
+	var st pendingActionStats
+	now := time.Now()
+	for i := 1; i <= 20; i++ {
+		st.Observe(fmt.Sprintf("agent-%d", i), int64(i), now.Add(-time.Duration(i)*time.Minute), now)
+	}
+	st.Observe("agent-20", 0, time.Time{}, now)
+	st.Observe("stale", 100, now.Add(-2*time.Hour), now.Add(-pendingActionStatsTTL-time.Minute))
+
+	reg := monitoring.NewRegistry()
+	monitoring.NewFunc(reg, "pending_actions", st.report)
+	snapshot := monitoring.CollectStructSnapshot(reg, monitoring.Full, false)
+	require.Equal(t, map[string]interface{}{
+		"agents":     int64(19),
+		"count":      map[string]interface{}{"max": int64(19), "p95": int64(19)},
+		"oldest_age": map[string]interface{}{"max": int64(19 * 60), "p95": int64(19 * 60)},
+	}, snapshot["pending_actions"])
+
+	require.Equal(t, int64(0), percentile(nil, 95))
+	require.Equal(t, int64(95), percentile(func() []int64 {
+		values := make([]int64, 100)
+		for i := range values {
+			values[i] = int64(i + 1)
+		}
+		return values
+	}(), 95))
+}
+
 func TestResolveSeqNo(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -287,6 +929,24 @@ func TestResolveSeqNo(t *testing.T) {
 			ActionSeqNo: []int64{sqn.UndefinedSeqNo},
 		},
 		resp: []int64{sqn.UndefinedSeqNo},
+	}, {
+		name: "seqno ackToken",
+		req: CheckinRequest{
+			AckToken: ptr("sqn:42"),
+		},
+		agent: &model.Agent{
+			ActionSeqNo: []int64{7},
+		},
+		resp: []int64{42},
+	}, {
+		name: "invalid seqno ackToken",
+		req: CheckinRequest{
+			AckToken: ptr("sqn:abc"),
+		},
+		agent: &model.Agent{
+			ActionSeqNo: []int64{7},
+		},
+		resp: []int64{7},
 	}}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -304,7 +964,7 @@ func TestResolveSeqNo(t *testing.T) {
 			ct, err := NewCheckinT(verCon, cfg, c, bc, pm, nil, nil, nil)
 			assert.NoError(t, err)
 
-			resp, _ := ct.resolveSeqNo(ctx, logger, tc.req, tc.agent)
+			resp, _ := ct.resolveSeqNo(logger.WithContext(ctx), tc.req, tc.agent)
 			assert.Equal(t, tc.resp, resp)
 		})
 	}
@@ -356,7 +1016,7 @@ func TestProcessUpgradeDetails(t *testing.T) {
 					t.Logf("bulk match unmarshal error: %v", err)
 					return false
 				}
-				return doc.Doc[dl.FieldUpgradeDetails] == nil && doc.Doc[dl.FieldUpgradeStartedAt] == nil && doc.Doc[dl.FieldUpgradeStatus] == nil && doc.Doc[dl.FieldUpgradedAt] != ""
+				return doc.Doc[dl.FieldUpgradeDetails] == nil && doc.Doc[dl.FieldUpgradeStartedAt] == nil && doc.Doc[dl.FieldUpgradeStatus] == dl.UpgradeStatusCompleted && doc.Doc[dl.FieldUpgradedAt] != ""
 			}), mock.Anything, mock.Anything).Return(nil)
 			return mBulk
 		},
@@ -399,6 +1059,7 @@ func TestProcessUpgradeDetails(t *testing.T) {
 		cache: func() *testcache.MockCache {
 			mCache := testcache.NewMockCache()
 			mCache.On("GetAction", "test-action").Return(model.Action{}, false)
+			mCache.On("NotFound", cache.KindAction, "test-action").Return(false)
 			mCache.On("SetAction", mock.Anything)
 			return mCache
 		},
@@ -415,6 +1076,7 @@ func TestProcessUpgradeDetails(t *testing.T) {
 		cache: func() *testcache.MockCache {
 			mCache := testcache.NewMockCache()
 			mCache.On("GetAction", "test-action").Return(model.Action{}, false)
+			mCache.On("NotFound", cache.KindAction, "test-action").Return(false)
 			return mCache
 		},
 		err: es.ErrNotFound,
@@ -710,9 +1372,7 @@ func Test_CheckinT_writeResponse(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			wr := httptest.NewRecorder()
-			err := ct.writeResponse(testlog.SetLogger(t), wr, test.req, &model.Agent{}, CheckinResponse{
-				Action: "checkin",
-			})
+			err := ct.writeResponse(wr, test.req.WithContext(testlog.SetLogger(t).WithContext(context.Background())), &model.Agent{}, checkinResponse{})
 			resp := wr.Result()
 			defer resp.Body.Close()
 			require.NoError(t, err)
@@ -730,20 +1390,17 @@ func Benchmark_CheckinT_writeResponse(b *testing.B) {
 	ct, err := NewCheckinT(verCon, cfg, nil, nil, nil, nil, nil, ftesting.NewMockBulk())
 	require.NoError(b, err)
 
-	logger := zerolog.Nop()
 	req := &http.Request{
 		Header: http.Header{
 			"Accept-Encoding": []string{"gzip"},
 		},
 	}
 	agent := &model.Agent{}
-	resp := CheckinResponse{
-		Action: "checkin",
-	}
+	resp := checkinResponse{}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		err := ct.writeResponse(logger, httptest.NewRecorder(), req, agent, resp)
+		err := ct.writeResponse(httptest.NewRecorder(), req, agent, resp)
 		require.NoError(b, err)
 	}
 }
@@ -757,27 +1414,253 @@ func BenchmarkParallel_CheckinT_writeResponse(b *testing.B) {
 	ct, err := NewCheckinT(verCon, cfg, nil, nil, nil, nil, nil, ftesting.NewMockBulk())
 	require.NoError(b, err)
 
-	logger := zerolog.Nop()
 	req := &http.Request{
 		Header: http.Header{
 			"Accept-Encoding": []string{"gzip"},
 		},
 	}
 	agent := &model.Agent{}
-	resp := CheckinResponse{
-		Action: "checkin",
-	}
+	resp := checkinResponse{}
 
 	b.ResetTimer()
 	b.SetParallelism(100)
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			err := ct.writeResponse(logger, httptest.NewRecorder(), req, agent, resp)
+			err := ct.writeResponse(httptest.NewRecorder(), req, agent, resp)
 			require.NoError(b, err)
 		}
 	})
 }
 
+func Test_processPolicy(t *testing.T) {
+	data := &model.PolicyData{
+		ID:       "policy-id",
+		Revision: 2,
+		Agent:    json.RawMessage(`{"monitoring":{"enabled":true}}`),
+		Inputs:   []map[string]interface{}{{"id": "input-1", "type": "logfile"}},
+		Outputs: map[string]map[string]interface{}{
+			"default": {"type": "logstash", "hosts": []interface{}{"localhost:5044"}},
+		},
+		SecretReferences: []model.SecretReferencesItems{},
+	}
+	pp, err := policy.NewParsedPolicy(context.Background(), ftesting.NewMockBulk(), model.Policy{PolicyID: "policy-id", RevisionIdx: 2, Data: data})
+	require.NoError(t, err)
+	agent, err := json.Marshal(model.Agent{ESDocument: model.ESDocument{Id: "agent-id"}, PolicyID: "policy-id"})
+	require.NoError(t, err)
+	bulker := &agentBulk{res: &es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "agent-id", Source: agent}}}}}
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	for i := 0; i < 2; i++ {
+		// the second response reuses the encoded policy
+		resp, err := processPolicy(ctx, bulker, "agent-id", pp, policyDelivery{})
+		require.NoError(t, err)
+		require.Equal(t, "agent-id", resp.AgentId)
+		require.Equal(t, POLICYCHANGE, resp.Type)
+
+		d := policyChange(t, resp).Policy
+		require.Equal(t, "policy-id", fromPtr(d.Id))
+		require.Equal(t, 2, fromPtr(d.Revision))
+		require.Equal(t, map[string]interface{}{"monitoring": map[string]interface{}{"enabled": true}}, fromPtr(d.Agent))
+		require.Equal(t, []map[string]interface{}{{"id": "input-1", "type": "logfile"}}, fromPtr(d.Inputs))
+		require.Equal(t, map[string]interface{}{
+			"default": map[string]interface{}{"type": "logstash", "hosts": []interface{}{"localhost:5044"}},
+		}, fromPtr(d.Outputs))
+		require.NotNil(t, d.SecretPaths)
+		require.Empty(t, *d.SecretPaths)
+	}
+}
+
+// policyChange decodes the data of a POLICY_CHANGE action built by processPolicy.
+func policyChange(t *testing.T, a *checkinAction) ActionPolicyChange {
+	t.Helper()
+	p, ok := a.payload.(policyChangePayload)
+	require.True(t, ok)
+	require.True(t, json.Valid(p))
+	var change ActionPolicyChange
+	require.NoError(t, json.Unmarshal(p, &change))
+	return change
+}
+
+func Test_CheckinT_writeResponse_ifNoneMatch(t *testing.T) {
+	data := &model.PolicyData{
+		ID:       "policy-id",
+		Revision: 2,
+		Outputs: map[string]map[string]interface{}{
+			"default": {"type": "logstash", "hosts": []interface{}{"localhost:5044"}},
+		},
+		SecretReferences: []model.SecretReferencesItems{},
+	}
+	pp, err := policy.NewParsedPolicy(context.Background(), ftesting.NewMockBulk(), model.Policy{PolicyID: "policy-id", RevisionIdx: 2, CoordinatorIdx: 1, Data: data})
+	require.NoError(t, err)
+	agent, err := json.Marshal(model.Agent{ESDocument: model.ESDocument{Id: "agent-id"}, PolicyID: "policy-id"})
+	require.NoError(t, err)
+	bulker := &agentBulk{res: &es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "agent-id", Source: agent}}}}}
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	action, err := processPolicy(ctx, bulker, "agent-id", pp, policyDelivery{})
+	require.NoError(t, err)
+
+	body, err := marshalPolicyBody(pp)
+	require.NoError(t, err)
+	etag := revisionETag(pp, body)
+
+	ct, err := NewCheckinT(mustBuildConstraints("8.0.0"), &config.Server{}, nil, nil, nil, nil, nil, ftesting.NewMockBulk())
+	require.NoError(t, err)
+
+	// the agent acks the policy change and moves its ack token, a checkin is never answered with a 304
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{name: "no header"},
+		{name: "policy tag", header: etag},
+		{name: "any tag", header: "*"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-id/checkin", nil).WithContext(ctx)
+			if tc.header != "" {
+				req.Header.Set("If-None-Match", tc.header)
+			}
+			wr := httptest.NewRecorder()
+			err := ct.writeResponse(wr, req, &model.Agent{}, checkinResponse{
+				ackToken: "ack-token",
+				actions:  []checkinAction{*action},
+			})
+			require.NoError(t, err)
+
+			require.Equal(t, http.StatusOK, wr.Code)
+			require.Empty(t, wr.Header().Get("ETag"))
+			var resp CheckinResponse
+			require.NoError(t, json.Unmarshal(wr.Body.Bytes(), &resp))
+			require.Equal(t, "ack-token", fromPtr(resp.AckToken))
+			require.Len(t, fromPtr(resp.Actions), 1)
+			require.Equal(t, POLICYCHANGE, fromPtr(resp.Actions)[0].Type)
+		})
+	}
+}
+
+func Test_policyChangeData(t *testing.T) {
+	outputs := map[string]map[string]interface{}{"default": {"type": "kafka"}}
+	p, err := policyChangeData([]byte(`{}`), outputs, []string{"outputs.default.password"})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"policy":{"outputs":{"default":{"type":"kafka"}},"secret_paths":["outputs.default.password"]}}`, string(p))
+
+	p, err = policyChangeData([]byte(`{"id":"policy-id","revision":1}`), outputs, nil)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"policy":{"outputs":{"default":{"type":"kafka"}},"secret_paths":null,"id":"policy-id","revision":1}}`, string(p))
+}
+
+// agentBulk is a bulker that only returns the agent document to the searches, mocks allocate too much to benchmark allocations.
+type agentBulk struct {
+	bulk.Bulk
+	res *es.ResultT
+}
+
+func (b *agentBulk) Search(_ context.Context, _ string, _ []byte, _ ...bulk.Opt) (*es.ResultT, error) {
+	return b.res, nil
+}
+
+func (b *agentBulk) SearchStream(_ context.Context, _ string, _ []byte, fn func(hit *es.HitT) error, _ ...bulk.Opt) error {
+	for i := range b.res.Hits {
+		if err := fn(&b.res.Hits[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *agentBulk) HasTracer() bool {
+	return false
+}
+
+// benchmarkPolicy returns a parsed policy of about size bytes, with a logstash output that does not need an API key.
+func benchmarkPolicy(b *testing.B, size int) *policy.ParsedPolicy {
+	b.Helper()
+	data := &model.PolicyData{
+		ID:       "policy-id",
+		Revision: 2,
+		Agent:    json.RawMessage(`{"monitoring":{"enabled":true,"logs":true,"metrics":true}}`),
+		Outputs: map[string]map[string]interface{}{
+			"default": {"type": "logstash", "hosts": []interface{}{"localhost:5044"}},
+		},
+	}
+	for n := 0; n < size; {
+		input := map[string]interface{}{
+			"id":   fmt.Sprintf("logfile-%d", len(data.Inputs)),
+			"type": "logfile",
+			"meta": map[string]interface{}{"package": map[string]interface{}{"name": "system", "version": "1.0.0"}},
+			"streams": []interface{}{
+				map[string]interface{}{
+					"id":            fmt.Sprintf("logfile-system.auth-%d", len(data.Inputs)),
+					"data_stream":   map[string]interface{}{"dataset": "system.auth", "type": "logs"},
+					"paths":         []interface{}{"/var/log/auth.log*", "/var/log/secure*"},
+					"exclude_files": []interface{}{".gz$"},
+					"multiline":     map[string]interface{}{"pattern": "^\\s", "match": "after"},
+					"processors":    []interface{}{map[string]interface{}{"add_locale": nil}},
+				},
+			},
+		}
+		p, err := json.Marshal(input)
+		require.NoError(b, err)
+		n += len(p)
+		data.Inputs = append(data.Inputs, input)
+	}
+	bulker := ftesting.NewMockBulk()
+	pp, err := policy.NewParsedPolicy(context.Background(), bulker, model.Policy{PolicyID: "policy-id", RevisionIdx: 2, Data: data})
+	require.NoError(b, err)
+	return pp
+}
+
+func benchmarkAgentBulk(b *testing.B) *agentBulk {
+	b.Helper()
+	agent, err := json.Marshal(model.Agent{ESDocument: model.ESDocument{Id: "agent-id"}, PolicyID: "policy-id"})
+	require.NoError(b, err)
+	return &agentBulk{res: &es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "agent-id", Source: agent}}}}}
+}
+
+func Benchmark_processPolicy(b *testing.B) {
+	pp := benchmarkPolicy(b, 200*1024)
+	bulker := benchmarkAgentBulk(b)
+	ctx := zerolog.Nop().WithContext(context.Background())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := processPolicy(ctx, bulker, "agent-id", pp, policyDelivery{})
+		require.NoError(b, err)
+	}
+}
+
+func Benchmark_CheckinT_writePolicyResponse(b *testing.B) {
+	verCon := mustBuildConstraints("8.0.0")
+	cfg := &config.Server{
+		CompressionLevel:  flate.BestSpeed,
+		CompressionThresh: 1,
+	}
+	pp := benchmarkPolicy(b, 200*1024)
+	bulker := benchmarkAgentBulk(b)
+	ct, err := NewCheckinT(verCon, cfg, nil, nil, nil, nil, nil, bulker)
+	require.NoError(b, err)
+
+	req := (&http.Request{
+		Header: http.Header{
+			"Accept-Encoding": []string{"gzip"},
+		},
+	}).WithContext(zerolog.Nop().WithContext(context.Background()))
+	agent := &model.Agent{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		action, err := processPolicy(req.Context(), bulker, "agent-id", pp, policyDelivery{})
+		require.NoError(b, err)
+		err = ct.writeResponse(httptest.NewRecorder(), req, agent, checkinResponse{
+			actions: []checkinAction{*action},
+		})
+		require.NoError(b, err)
+	}
+}
+
 func mustBuildConstraints(verStr string) version.Constraints {
 	con, err := BuildVersionConstraint(verStr)
 	if err != nil {
@@ -786,6 +1669,34 @@ func mustBuildConstraints(verStr string) version.Constraints {
 	return con
 }
 
+func TestRetryOutputAPIKey(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		health bulk.OutputHealth
+		retry  bool
+	}{{
+		name:  "not a remote output",
+		retry: true,
+	}, {
+		name:   "healthy remote output",
+		health: bulk.OutputHealth{State: "HEALTHY", UpdatedAt: now},
+		retry:  true,
+	}, {
+		name:   "recently degraded remote output",
+		health: bulk.OutputHealth{State: "DEGRADED", UpdatedAt: now.Add(-remoteOutputRetryInterval / 2)},
+	}, {
+		name:   "degraded remote output",
+		health: bulk.OutputHealth{State: "DEGRADED", UpdatedAt: now.Add(-remoteOutputRetryInterval)},
+		retry:  true,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.retry, retryOutputAPIKey(tc.health, now))
+		})
+	}
+}
+
 func TestCalcUnhealthyReason(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -879,6 +1790,7 @@ func TestParseComponents(t *testing.T) {
 		req             *CheckinRequest
 		outComponents   []byte
 		unhealthyReason *[]string
+		digest          string
 		err             error
 	}{{
 		name:            "unchanged components healthy",
@@ -886,6 +1798,7 @@ func TestParseComponents(t *testing.T) {
 		req:             &CheckinRequest{},
 		outComponents:   nil,
 		unhealthyReason: &unhealthyReasonNil,
+		digest:          "",
 		err:             nil,
 	},
 		{
@@ -915,6 +1828,7 @@ func TestParseComponents(t *testing.T) {
 			},
 			outComponents:   nil,
 			unhealthyReason: &[]string{"input"},
+			digest:          DegradedStatus,
 			err:             nil,
 		},
 		{
@@ -935,19 +1849,63 @@ func TestParseComponents(t *testing.T) {
 			},
 			outComponents:   degradedInputReqComponents,
 			unhealthyReason: &[]string{"input"},
+			digest:          DegradedStatus,
 			err:             nil,
 		}}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			logger := testlog.SetLogger(t)
-			outComponents, unhealthyReason, err := parseComponents(logger, tc.agent, tc.req)
+			outComponents, unhealthyReason, digest, err := parseComponents(logger.WithContext(context.Background()), tc.agent, tc.req)
 			assert.Equal(t, tc.outComponents, outComponents)
 			assert.Equal(t, tc.unhealthyReason, unhealthyReason)
+			assert.Equal(t, tc.digest, digest)
 			assert.Equal(t, tc.err, err)
 		})
 	}
 }
 
+func TestValidateCheckinRequestAgentVersion(t *testing.T) {
+	serverVer, err := ParseVersion("8.5.0")
+	require.NoError(t, err)
+	ct, err := NewCheckinT(mustBuildConstraints("8.5.0"), &config.Server{}, nil, nil, nil, nil, nil, nil, WithCheckinServerVersion(serverVer))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		meta string
+		err  error
+	}{{
+		name: "no local metadata",
+	}, {
+		name: "same version",
+		meta: `{"elastic":{"agent":{"version":"8.5.0"}}}`,
+	}, {
+		name: "newer patch snapshot",
+		meta: `{"elastic":{"agent":{"version":"8.5.1-SNAPSHOT","snapshot":true}}}`,
+	}, {
+		name: "newer major",
+		meta: `{"elastic":{"agent":{"version":"9.0.0"}}}`,
+		err:  ErrAgentVersionNotSupported,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			body := `{"status":"online","message":"test message"}`
+			if tc.meta != "" {
+				body = `{"status":"online","message":"test message","local_metadata":` + tc.meta + `}`
+			}
+			r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", strings.NewReader(body))
+			_, err := ct.validateRequest(httptest.NewRecorder(), r.WithContext(testlog.SetLogger(t).WithContext(r.Context())), time.Time{}, &model.Agent{LocalMetadata: json.RawMessage(`{}`)})
+			if tc.err == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.err)
+			require.Contains(t, err.Error(), "9.0.0")
+			require.Contains(t, err.Error(), "8.5.0")
+		})
+	}
+}
+
 func TestValidateCheckinRequest(t *testing.T) {
 	verCon := mustBuildConstraints("8.0.0")
 
@@ -991,7 +1949,7 @@ func TestValidateCheckinRequest(t *testing.T) {
 		{
 			name: "Poll Timeout Parsing Error",
 			req: &http.Request{
-				Body: io.NopCloser(strings.NewReader(`{"validJson": "test", "status": "test", "poll_timeout": "not a timeout", "message": "test message"}`)),
+				Body: io.NopCloser(strings.NewReader(`{"validJson": "test", "status": "online", "poll_timeout": "not a timeout", "message": "test message"}`)),
 			},
 			expErr: &BadRequestErr{msg: "poll_timeout cannot be parsed as duration"},
 			cfg: &config.Server{
@@ -1028,7 +1986,7 @@ func TestValidateCheckinRequest(t *testing.T) {
 			assert.NoError(t, err)
 			wr := httptest.NewRecorder()
 			logger := testlog.SetLogger(t)
-			valid, err := checkin.validateRequest(logger, wr, tc.req, time.Time{}, &model.Agent{LocalMetadata: json.RawMessage(`{}`)})
+			valid, err := checkin.validateRequest(wr, tc.req.WithContext(logger.WithContext(context.Background())), time.Time{}, &model.Agent{LocalMetadata: json.RawMessage(`{}`)})
 			if tc.expErr == nil {
 				assert.NoError(t, err)
 				assert.Equal(t, tc.expValid.rawMeta, valid.rawMeta)
@@ -1042,3 +2000,513 @@ func TestValidateCheckinRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessRequestSpans(t *testing.T) {
+	logger := testlog.SetLogger(t)
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{
+		HitsT: es.HitsT{Hits: []es.HitT{{
+			ID:     "doc-1",
+			SeqNo:  2,
+			Source: []byte(`{"action_id":"action-1","type":"UNENROLL","agents":["agent-1"]}`),
+		}}},
+	}, nil)
+	gcp := mockmonitor.NewMockMonitor()
+	gcp.On("GetCheckpoint").Return(sqn.SeqNo{2})
+	am := mockmonitor.NewMockMonitor()
+	pm := policy.NewMonitor(bulker, mockmonitor.NewMockMonitor(), config.ServerLimits{PolicyLimit: config.Limit{Interval: time.Millisecond, Burst: 1}})
+
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	ct, err := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, checkin.NewBulk(nil), pm, gcp, action.NewDispatcher(am, 0, 1), bulker)
+	require.NoError(t, err)
+
+	tx := tracer.StartTransaction("POST /api/fleet/agents/{id}/checkin", "request")
+	ctx := apm.ContextWithTransaction(logger.WithContext(context.Background()), tx)
+	req := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", strings.NewReader(`{"status":"online","message":"healthy"}`)).WithContext(ctx)
+	agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}, Agent: &model.AgentMetadata{ID: "agent-1"}, PolicyID: "policy-1", ActionSeqNo: []int64{1}}
+
+	wr := httptest.NewRecorder()
+	require.NoError(t, ct.ProcessRequest(wr, req, time.Now(), agent, ""))
+	require.Equal(t, http.StatusOK, wr.Code)
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	spans := make(map[string]apmmodel.Span, len(payloads.Spans))
+	for _, span := range payloads.Spans {
+		spans[span.Name] = span
+	}
+	require.Contains(t, spans, "validateRequest")
+	require.Contains(t, spans, "longPoll")
+	require.Contains(t, spans, "action delivery")
+	require.Contains(t, spans, "response")
+
+	txID := payloads.Transactions[0].ID
+	require.Equal(t, txID, spans["validateRequest"].ParentID)
+	require.Equal(t, txID, spans["longPoll"].ParentID)
+	require.Equal(t, txID, spans["action delivery"].ParentID)
+	require.Equal(t, spans["action delivery"].ID, spans["response"].ParentID, "response is written within the action delivery span")
+
+	labels := func(span apmmodel.Span) map[string]interface{} {
+		m := make(map[string]interface{})
+		for _, tag := range span.Context.Tags {
+			m[tag.Key] = tag.Value
+		}
+		return m
+	}
+	require.Equal(t, map[string]interface{}{"agent_id": "agent-1", "action_count": 1.0}, labels(spans["longPoll"]))
+	require.Equal(t, map[string]interface{}{"agent_id": "agent-1", "action_count": 1.0}, labels(spans["action delivery"]))
+}
+
+// failingWriter fails to write the response body.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestProcessRequestRecordsTargetedDelivery(t *testing.T) {
+	logger := testlog.SetLogger(t)
+	targeted := `{"action_id":"targeted","type":"SETTINGS","data":{"log_level":"debug"},"target":{"policy_id":"policy-1"}}`
+
+	for _, tc := range []struct {
+		name     string
+		writer   func(*httptest.ResponseRecorder) http.ResponseWriter
+		recorded bool
+	}{{
+		name:     "response written",
+		writer:   func(wr *httptest.ResponseRecorder) http.ResponseWriter { return wr },
+		recorded: true,
+	}, {
+		name:   "response not written",
+		writer: func(wr *httptest.ResponseRecorder) http.ResponseWriter { return failingWriter{wr} },
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			bulker := ftesting.NewMockBulk()
+			bulker.On("Search", mock.Anything, dl.FleetActions, mock.MatchedBy(func(body []byte) bool {
+				return strings.Contains(string(body), dl.FieldTarget)
+			}), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "doc-1", SeqNo: 3, Source: []byte(targeted)}}}}, nil)
+			bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+			bulker.On("Search", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+			var recorded []string
+			bulker.On("Create", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				recorded = append(recorded, args.String(2))
+			}).Return("", nil)
+			gcp := mockmonitor.NewMockMonitor()
+			gcp.On("GetCheckpoint").Return(sqn.SeqNo{2})
+			pm := policy.NewMonitor(bulker, mockmonitor.NewMockMonitor(), config.ServerLimits{PolicyLimit: config.Limit{Interval: time.Millisecond, Burst: 1}})
+
+			cfg := &config.Server{}
+			cfg.InitDefaults()
+			ct, err := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, checkin.NewBulk(nil), pm, gcp, action.NewDispatcher(mockmonitor.NewMockMonitor(), 0, 1), bulker)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", strings.NewReader(`{"status":"online","message":"healthy"}`)).WithContext(logger.WithContext(context.Background()))
+			agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}, Agent: &model.AgentMetadata{ID: "agent-1"}, PolicyID: "policy-1", ActionSeqNo: []int64{1}}
+
+			err = ct.ProcessRequest(tc.writer(httptest.NewRecorder()), req, time.Now(), agent, "")
+			if !tc.recorded {
+				require.Error(t, err)
+				require.Empty(t, recorded, "the delivery is not recorded when the response is not written")
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, []string{dl.ActionDeliveryID("targeted", "agent-1")}, recorded)
+		})
+	}
+}
+
+func TestPendingActionsHeldAction(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	startTime := time.Now().Add(time.Second)
+	held := fmt.Sprintf(`{"action_id":"held","type":"SETTINGS","agents":["agent-1"],"start_time":%q,"data":{"log_level":"debug"}}`, startTime.Format(time.RFC3339Nano))
+	immediate := `{"action_id":"immediate","type":"UNENROLL","agents":["agent-1"]}`
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.MatchedBy(func(body []byte) bool {
+		return strings.Contains(string(body), dl.FieldTarget)
+	}), mock.Anything).Return(&es.ResultT{}, nil)
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		{ID: "doc-1", SeqNo: 3, Source: []byte(held)},
+		{ID: "doc-2", SeqNo: 4, Source: []byte(immediate)},
+	}}}, nil)
+	bulker.On("Search", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil).Once()
+	bulker.On("Create", mock.Anything, dl.FleetActionsResults, dl.ActionDeliveryID("immediate", "agent-1"), mock.Anything, mock.Anything).Return("", nil).Once()
+	gcp := mockmonitor.NewMockMonitor()
+	gcp.On("GetCheckpoint").Return(sqn.SeqNo{4})
+	pm := policy.NewMonitor(bulker, mockmonitor.NewMockMonitor(), config.ServerLimits{PolicyLimit: config.Limit{Interval: time.Millisecond, Burst: 1}})
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	ct, err := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, checkin.NewBulk(nil), pm, gcp, action.NewDispatcher(mockmonitor.NewMockMonitor(), 0, 1), bulker)
+	require.NoError(t, err)
+	agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}, Agent: &model.AgentMetadata{ID: "agent-1"}, PolicyID: "policy-1"}
+
+	// the action after the held back action is delivered, the ack token does not move past the held back action
+	actions, ackToken, heldUntil, outOfWindow, err := ct.pendingActions(ctx, sqn.SeqNo{2}, agent)
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	require.Equal(t, "immediate", actions[0].Id)
+	require.Empty(t, ackToken)
+	require.True(t, startTime.Equal(heldUntil))
+	require.Len(t, outOfWindow, 1)
+	ct.recordOutOfWindowDelivery(ctx, agent, outOfWindow)
+
+	// the delivered action is not delivered again while the action is held back
+	actions, ackToken, _, outOfWindow, err = ct.pendingActions(ctx, sqn.SeqNo{2}, agent)
+	require.NoError(t, err)
+	require.Empty(t, actions)
+	require.Empty(t, ackToken)
+	require.Empty(t, outOfWindow)
+
+	// once due the held back action is delivered alone, and the ack token moves past both actions
+	time.Sleep(time.Until(startTime))
+	actions, ackToken, heldUntil, outOfWindow, err = ct.pendingActions(ctx, sqn.SeqNo{2}, agent)
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	require.Equal(t, "held", actions[0].Id)
+	require.Equal(t, sqn.SeqNo{4}.Token(), ackToken)
+	require.True(t, heldUntil.IsZero())
+	require.Empty(t, outOfWindow)
+	bulker.AssertExpectations(t)
+}
+
+func TestPendingActionsReloadedTTL(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	upgrade := fmt.Sprintf(`{"action_id":"upgrade","type":"UPGRADE","agents":["agent-1"],"@timestamp":%q,"data":{"version":"8.15.0"}}`, time.Now().Add(-2*time.Hour).Format(time.RFC3339))
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.MatchedBy(func(body []byte) bool {
+		return strings.Contains(string(body), dl.FieldTarget)
+	}), mock.Anything).Return(&es.ResultT{}, nil)
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		{ID: "doc-1", SeqNo: 3, Source: []byte(upgrade)},
+	}}}, nil)
+	gcp := mockmonitor.NewMockMonitor()
+	gcp.On("GetCheckpoint").Return(sqn.SeqNo{3})
+	pm := policy.NewMonitor(bulker, mockmonitor.NewMockMonitor(), config.ServerLimits{PolicyLimit: config.Limit{Interval: time.Millisecond, Burst: 1}})
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Actions.TTL = map[string]time.Duration{"UPGRADE": 24 * time.Hour}
+	reg := monitoring.NewRegistry()
+	ct, err := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, checkin.NewBulk(nil), pm, gcp, action.NewDispatcher(mockmonitor.NewMockMonitor(), 0, 1), bulker,
+		WithCheckinStats(reg))
+	require.NoError(t, err)
+	agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}, Agent: &model.AgentMetadata{ID: "agent-1"}, PolicyID: "policy-1"}
+	stats := func() map[string]int64 {
+		return monitoring.CollectFlatSnapshot(reg, monitoring.Full, false).Ints
+	}
+
+	actions, _, _, _, err := ct.pendingActions(ctx, sqn.SeqNo{2}, agent)
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	require.Equal(t, int64(86400), stats()["action_ttl.types.UPGRADE"])
+	require.Equal(t, int64(1), agent.PendingActionsCount)
+
+	// the reloaded TTL expires the action for the next checkins
+	reloaded := *cfg
+	reloaded.Actions.TTL = map[string]time.Duration{"UPGRADE": time.Hour}
+	ct.Reload(&reloaded)
+	actions, _, _, _, err = ct.pendingActions(ctx, sqn.SeqNo{2}, agent)
+	require.NoError(t, err)
+	require.Empty(t, actions)
+	require.Equal(t, int64(3600), stats()["action_ttl.types.UPGRADE"])
+	require.Equal(t, int64(0), agent.PendingActionsCount)
+}
+
+func TestTargetedActionsCached(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{
+		ID:     "doc-1",
+		Source: []byte(`{"action_id":"targeted","type":"SETTINGS","target":{"policy_id":"policy-1"}}`),
+	}}}}, nil).Once()
+	bulker.On("Search", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil).Once()
+	bulker.On("Create", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything, mock.Anything).Return("", nil).Once()
+	ta, err := action.NewTargetedActions(bulker)
+	require.NoError(t, err)
+	ct := &CheckinT{cfg: &config.Server{}, bulker: bulker, targeted: ta}
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}, PolicyID: "policy-1"}
+
+	actions, err := ct.targetedActions(ctx, agent)
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	ct.recordOutOfWindowDelivery(ctx, agent, actions)
+
+	// the actions and the delivery are served from memory
+	for range 3 {
+		actions, err := ct.targetedActions(ctx, agent)
+		require.NoError(t, err)
+		require.Empty(t, actions)
+	}
+	bulker.AssertExpectations(t)
+}
+
+func TestProcessRequestAgentState(t *testing.T) {
+	tests := []struct {
+		name  string
+		agent model.Agent
+		err   error
+	}{{
+		name:  "enrolled",
+		agent: model.Agent{Active: true, LifecycleState: string(model.AgentStateEnrolled)},
+	}, {
+		name:  "offline",
+		agent: model.Agent{Active: true, LifecycleState: string(model.AgentStateOffline)},
+	}, {
+		name:  "unenrolling",
+		agent: model.Agent{Active: true, UnenrollmentStartedAt: "2024-01-01T00:00:00Z"},
+	}, {
+		name:  "unenrolled",
+		agent: model.Agent{LifecycleState: string(model.AgentStateUnenrolled)},
+		err:   model.ErrInvalidTransition,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testlog.SetLogger(t).WithContext(context.Background())
+			bulker := ftesting.NewMockBulk()
+			bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+			gcp := mockmonitor.NewMockMonitor()
+			gcp.On("GetCheckpoint").Return(sqn.SeqNo{2})
+			pm := policy.NewMonitor(bulker, mockmonitor.NewMockMonitor(), config.ServerLimits{PolicyLimit: config.Limit{Interval: time.Millisecond, Burst: 1}})
+			cfg := &config.Server{}
+			cfg.InitDefaults()
+			cfg.Timeouts.CheckinLongPoll = time.Millisecond
+			cfg.Timeouts.CheckinJitter = 0
+			ct, err := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, checkin.NewBulk(nil), pm, gcp, action.NewDispatcher(mockmonitor.NewMockMonitor(), 0, 1), bulker)
+			require.NoError(t, err)
+
+			agent := tc.agent
+			agent.Id = "agent-1"
+			agent.Agent = &model.AgentMetadata{ID: "agent-1"}
+			agent.PolicyID = "policy-1"
+			agent.ActionSeqNo = []int64{1}
+			req := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", strings.NewReader(`{"status":"online"}`)).WithContext(ctx)
+			wr := httptest.NewRecorder()
+			err = ct.ProcessRequest(wr, req, time.Now(), &agent, "")
+			if tc.err == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, http.StatusConflict, NewHTTPErrResp(err).StatusCode)
+		})
+	}
+}
+
+func TestProcessRequestTraceAgent(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	t.Cleanup(func() { logger.SetTraceAgents(nil) })
+	logger.SetTraceAgents([]string{"agent-traced"})
+
+	type decision struct{ name, result, reason string }
+	tests := []struct {
+		agentID   string
+		decisions []decision
+	}{{
+		agentID: "agent-traced",
+		decisions: []decision{
+			{"policy_subscription", "subscribed", "output without API key, policy is sent again"},
+			{"action", "excluded", "action type not delivered to agents"},
+			{"action", "excluded", "expired"},
+			{"action", "included", ""},
+		},
+	}, {
+		agentID: "agent-other",
+		decisions: []decision{
+			// info level events are logged for all agents
+			{"action", "excluded", "action type not delivered to agents"},
+		},
+	}}
+	for _, tc := range tests {
+		t.Run(tc.agentID, func(t *testing.T) {
+			bulker := ftesting.NewMockBulk()
+			bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{
+				HitsT: es.HitsT{Hits: []es.HitT{{
+					ID:     "doc-1",
+					SeqNo:  2,
+					Source: []byte(`{"action_id":"action-1","type":"UPDATE_TAGS","agents":["` + tc.agentID + `"]}`),
+				}, {
+					ID:     "doc-2",
+					SeqNo:  3,
+					Source: []byte(`{"action_id":"action-2","type":"UNENROLL","expiration":"2000-01-01T00:00:00Z","agents":["` + tc.agentID + `"]}`),
+				}, {
+					ID:     "doc-3",
+					SeqNo:  4,
+					Source: []byte(`{"action_id":"action-3","type":"UNENROLL","agents":["` + tc.agentID + `"]}`),
+				}}},
+			}, nil)
+			gcp := mockmonitor.NewMockMonitor()
+			gcp.On("GetCheckpoint").Return(sqn.SeqNo{4})
+			pm := policy.NewMonitor(bulker, mockmonitor.NewMockMonitor(), config.ServerLimits{PolicyLimit: config.Limit{Interval: time.Millisecond, Burst: 1}})
+
+			cfg := &config.Server{}
+			cfg.InitDefaults()
+			ct, err := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, checkin.NewBulk(nil), pm, gcp, action.NewDispatcher(mockmonitor.NewMockMonitor(), 0, 1), bulker)
+			require.NoError(t, err)
+
+			var b bytes.Buffer
+			ctx := zerolog.New(&b).WithContext(context.Background())
+			req := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/"+tc.agentID+"/checkin", strings.NewReader(`{"status":"online","message":"healthy"}`)).WithContext(ctx)
+			agent := &model.Agent{
+				ESDocument:        model.ESDocument{Id: tc.agentID},
+				Agent:             &model.AgentMetadata{ID: tc.agentID},
+				PolicyID:          "policy-1",
+				PolicyRevisionIdx: 3,
+				Outputs:           map[string]*model.PolicyOutput{"default": {}},
+				ActionSeqNo:       []int64{1},
+			}
+
+			wr := httptest.NewRecorder()
+			require.NoError(t, ct.ProcessRequest(wr, req, time.Now(), agent, ""))
+			require.Equal(t, http.StatusOK, wr.Code)
+
+			var found []decision
+			dec := json.NewDecoder(&b)
+			for dec.More() {
+				var m map[string]interface{}
+				require.NoError(t, dec.Decode(&m))
+				if _, ok := m[logger.Decision]; !ok {
+					continue
+				}
+				assert.Equal(t, tc.agentID, m[logger.AgentID])
+				reason, _ := m[logger.DecisionReason].(string)
+				found = append(found, decision{m[logger.Decision].(string), m[logger.DecisionResult].(string), reason})
+			}
+			assert.Equal(t, tc.decisions, found)
+		})
+	}
+}
+
+// timedRecorder is a recorder that tracks the time of the writes and flushes of the response.
+type timedRecorder struct {
+	*httptest.ResponseRecorder
+	writes  []time.Time
+	flushes []time.Time
+}
+
+func (tr *timedRecorder) Write(p []byte) (int, error) {
+	tr.writes = append(tr.writes, time.Now())
+	return tr.ResponseRecorder.Write(p)
+}
+
+func (tr *timedRecorder) Flush() {
+	tr.flushes = append(tr.flushes, time.Now())
+	tr.ResponseRecorder.Flush()
+}
+
+func TestProcessRequestKeepAlive(t *testing.T) {
+	const (
+		longPoll  = 250 * time.Millisecond
+		keepAlive = 50 * time.Millisecond
+	)
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+	gcp := mockmonitor.NewMockMonitor()
+	gcp.On("GetCheckpoint").Return(sqn.SeqNo{2})
+	pm := policy.NewMonitor(bulker, mockmonitor.NewMockMonitor(), config.ServerLimits{PolicyLimit: config.Limit{Interval: time.Millisecond, Burst: 1}})
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Timeouts.CheckinLongPoll = longPoll
+	cfg.Timeouts.CheckinJitter = 0
+	cfg.CompressionThresh = 0
+	ct, err := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, checkin.NewBulk(nil), pm, gcp, action.NewDispatcher(mockmonitor.NewMockMonitor(), 0, 1), bulker)
+	require.NoError(t, err)
+
+	agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1"}, Agent: &model.AgentMetadata{ID: "agent-1"}, PolicyID: "policy-1", ActionSeqNo: []int64{1}}
+	req := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", strings.NewReader(`{"status":"online"}`)).WithContext(ctx)
+	req.Header.Set("Accept-Encoding", kEncodingGzip)
+	wr := &timedRecorder{ResponseRecorder: httptest.NewRecorder()}
+	start := time.Now()
+	require.NoError(t, ct.ProcessRequest(newKeepAliveWriter(wr, keepAlive), req, start, agent, ""))
+	require.Equal(t, http.StatusOK, wr.Code)
+
+	// each keep-alive write is flushed, the last write is the response
+	require.GreaterOrEqual(t, len(wr.writes), 3)
+	require.Len(t, wr.flushes, len(wr.writes)-1)
+	prev := start
+	for i, flush := range wr.flushes {
+		require.False(t, flush.Before(wr.writes[i]))
+		require.GreaterOrEqual(t, flush.Sub(prev), keepAlive-10*time.Millisecond, "keep-alive %d is written at the interval", i)
+		prev = flush
+	}
+	require.GreaterOrEqual(t, wr.writes[len(wr.writes)-1].Sub(start), longPoll-10*time.Millisecond)
+
+	// the response is not compressed, and is valid JSON after the keep-alive bytes
+	require.Empty(t, wr.Header().Get("Content-Encoding"))
+	body := wr.Body.Bytes()
+	require.Equal(t, strings.Repeat("\n", len(wr.flushes)), string(body[:len(wr.flushes)]))
+	var resp CheckinResponse
+	require.NoError(t, json.Unmarshal(body, &resp))
+	require.Equal(t, "checkin", resp.Action)
+	require.Empty(t, fromPtr(resp.Actions))
+}
+
+func TestCheckinStalledActionLookup(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	bulker := ftesting.NewMockBulk()
+	bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{Enabled: true}, nil)
+	bulker.On("ReadRaw", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything).Return(&bulk.MgetResponseItem{
+		Found:  true,
+		Source: []byte(`{"access_api_key_id":"id","active":true,"agent":{"id":"agent-1"},"policy_id":"policy-1","action_seq_no":[1]}`),
+	}, nil)
+	// elasticsearch does not answer until the request is given up
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	}).Return(&es.ResultT{}, context.DeadlineExceeded)
+	gcp := mockmonitor.NewMockMonitor()
+	gcp.On("GetCheckpoint").Return(sqn.SeqNo{2})
+	pm := policy.NewMonitor(bulker, mockmonitor.NewMockMonitor(), config.ServerLimits{PolicyLimit: config.Limit{Interval: time.Millisecond, Burst: 1}})
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Budgets.Checkin.Total = 200 * time.Millisecond
+	ct, err := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, c, checkin.NewBulk(nil), pm, gcp, action.NewDispatcher(mockmonitor.NewMockMonitor(), 0, 1), bulker)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", strings.NewReader(`{"status":"online","message":"healthy"}`)).WithContext(ctx)
+	req.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+	wr := httptest.NewRecorder()
+	start := time.Now()
+	(&apiServer{ct: ct}).AgentCheckin(wr, req, "agent-1", AgentCheckinParams{UserAgent: "elastic agent 8.0.0"})
+	require.Less(t, time.Since(start), time.Second, "the lookup fails once the read budget is exceeded")
+
+	require.Equal(t, http.StatusServiceUnavailable, wr.Code)
+	var resp HTTPErrResp
+	require.NoError(t, json.Unmarshal(wr.Body.Bytes(), &resp))
+	require.Equal(t, "OperationBudgetExceeded", resp.Error)
+}
+
+func TestGetAgentNotFoundCached(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	bulker := ftesting.NewMockBulk()
+	bulker.On("ReadRaw", mock.Anything, dl.FleetAgents, "deleted-agent", mock.Anything).Return((*bulk.MgetResponseItem)(nil), es.ErrElasticNotFound)
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+
+	_, err = getAgentAndVerifyAPIKeyID(ctx, bulker, c, "deleted-agent", "key-id")
+	require.ErrorIs(t, err, ErrAgentIdentity)
+	require.Eventually(t, func() bool { return c.NotFound(cache.KindAgent, "deleted-agent") }, time.Second, time.Millisecond)
+
+	// the deleted agent is not read again, and gets the same error
+	for range 10 {
+		_, err = getAgentAndVerifyAPIKeyID(ctx, bulker, c, "deleted-agent", "key-id")
+		require.ErrorIs(t, err, ErrAgentIdentity)
+	}
+	bulker.AssertNumberOfCalls(t, "ReadRaw", 1)
+
+	// the agent is read once it is enrolled again
+	c.InvalidateNotFound(cache.KindAgent, "deleted-agent")
+	_, err = getAgentAndVerifyAPIKeyID(ctx, bulker, c, "deleted-agent", "key-id")
+	require.ErrorIs(t, err, ErrAgentIdentity)
+	bulker.AssertNumberOfCalls(t, "ReadRaw", 2)
+}
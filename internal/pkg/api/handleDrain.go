@@ -0,0 +1,60 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	mrand "math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/api"
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/drain"
+)
+
+// ErrServerDraining is returned for the enrollments received while the server is draining.
+var ErrServerDraining = errors.New("fleet server is draining")
+
+// drainResponse is the response of the drain endpoint.
+type drainResponse struct {
+	Draining bool      `json:"draining"`
+	Since    time.Time `json:"since"`
+}
+
+// AttachDrainEndpoint serves the drain endpoint on the monitoring listener at /drain, a POST starts draining d.
+// The endpoint requires the bearer token of auth when it is enabled.
+func AttachDrainEndpoint(router metricsRouter, d *drain.State, auth *MonitoringAuth) {
+	router.AddRoute("/drain", auth.Handler(drainHandler(d)))
+}
+
+// drainHandler starts draining d on a POST and writes the draining state, it is a noop once draining.
+func drainHandler(d *drain.State) api.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		d.Start()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(drainResponse{Draining: true, Since: d.Since()}); err != nil {
+			zerolog.Ctx(r.Context()).Error().Err(err).Msg("fail writing drain response")
+		}
+	}
+}
+
+// setDrainRetryAfter sets the Retry-After header of the response to the backoff of a draining server, jittered between
+// half and all of d so the agents don't move at once.
+func setDrainRetryAfter(w http.ResponseWriter, d time.Duration) {
+	d /= 2
+	if d > 0 {
+		d += mrand.N(d + 1) //nolint:gosec // the jitter is not security sensitive
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int((d+time.Second-1)/time.Second))))
+}
@@ -0,0 +1,150 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-client/v7/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/drain"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func Test_drainHandler(t *testing.T) {
+	d := drain.New()
+	h := drainHandler(d)
+	serve := func(method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(method, "/drain", nil))
+		return w
+	}
+
+	w := serve(http.MethodGet)
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	require.False(t, d.Draining())
+
+	w = serve(http.MethodPost)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.True(t, d.Draining())
+	var resp drainResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.True(t, resp.Draining)
+	require.Equal(t, d.Since(), resp.Since)
+
+	// draining again keeps the start time
+	w = serve(http.MethodPost)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, d.Since(), resp.Since)
+}
+
+func TestDraining(t *testing.T) {
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Draining.RetryAfter = 10 * time.Minute
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{Enabled: true}, nil)
+	bulker.On("ReadRaw", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything).Return(&bulk.MgetResponseItem{
+		Found:  true,
+		Source: []byte(`{"access_api_key_id":"id","active":true,"agent":{"id":"agent-1"},"policy_id":"policy-1","policy_revision_idx":2}`),
+	}, nil)
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+
+	d := drain.New()
+	et, err := NewEnrollerT(mustBuildConstraints("9.1.0"), cfg, bulker, c, WithEnrollDrain(d))
+	require.NoError(t, err)
+	si := &apiServer{
+		et:  et,
+		ack: NewAckT(cfg, bulker, c),
+		st:  NewStatusT(cfg, bulker, c, WithSelfMonitor(&mockPolicyMonitor{client.UnitStateHealthy}), WithDrain(d)),
+	}
+	hr := newRouter(cfg, si, nil, nil)
+	serve := func(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(testlog.SetLogger(t).WithContext(context.Background()))
+		req.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+		req.Header.Set("User-Agent", "Elastic Agent v9.1.0")
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		hr.ServeHTTP(w, req)
+		return w
+	}
+	status := func(t *testing.T) (int, StatusResponseStatus) {
+		t.Helper()
+		w := serve(t, http.MethodGet, "/api/status", "")
+		var resp StatusAPIResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp.Status
+	}
+
+	code, st := status(t)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, StatusResponseStatus(client.UnitStateHealthy.String()), st)
+
+	require.True(t, d.Start())
+
+	// the load balancers see a degraded instance
+	code, st = status(t)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, StatusResponseStatus(client.UnitStateDegraded.String()), st)
+
+	// the enrollments are rejected with a backoff between half and all of retry_after
+	w := serve(t, http.MethodPost, "/api/fleet/agents/enroll", `{"type":"PERMANENT","metadata":{"local":{},"tags":[]}}`)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Contains(t, w.Body.String(), "ServerDraining")
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, retryAfter, 300)
+	assert.LessOrEqual(t, retryAfter, 600)
+
+	// the acks are served until the shutdown
+	body := `{"events":[{"action_id":"policy:policy-1:1","agent_id":"agent-1","type":"ACTION_RESULT","subtype":"ACKNOWLEDGED","message":"policy acked","timestamp":"2024-01-01T00:00:00Z"}]}`
+	w = serve(t, http.MethodPost, "/api/fleet/agents/agent-1/acks", body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func Test_CheckinT_writeResponse_draining(t *testing.T) {
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	d := drain.New()
+	ct, err := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, nil, nil, nil, nil, nil, ftesting.NewMockBulk(), WithCheckinDrain(d))
+	require.NoError(t, err)
+	write := func(t *testing.T) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", nil)
+		require.NoError(t, ct.writeResponse(w, req.WithContext(testlog.SetLogger(t).WithContext(context.Background())), &model.Agent{}, checkinResponse{}))
+		return w
+	}
+
+	require.Empty(t, write(t).Header().Get("Retry-After"))
+	d.Start()
+	w := write(t)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotEmpty(t, w.Header().Get("Retry-After"))
+}
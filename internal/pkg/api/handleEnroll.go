@@ -18,6 +18,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"go.elastic.co/apm/v2"
@@ -26,12 +27,18 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/checkin"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/drain"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
 	"github.com/elastic/fleet-server/v7/internal/pkg/rollback"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	"github.com/elastic/fleet-server/v7/internal/pkg/unenroll"
 
 	"github.com/gofrs/uuid"
 	"github.com/hashicorp/go-version"
@@ -68,22 +75,105 @@ var (
 )
 
 type EnrollerT struct {
-	verCon version.Constraints
-	cfg    *config.Server
-	bulker bulk.Bulk
-	cache  cache.Cache
+	verCon   version.Constraints
+	cfg      *config.Server
+	reloaded reloadedCfg
+	bulker   bulk.Bulk
+	cache    cache.Cache
+	bc       *checkin.Bulk
+
+	// serverVer is the version of the server, the enrollments of newer agents are rejected when it is set.
+	serverVer *version.Version
+	// policyReader coalesces the reads of the policies of the static enrollment tokens, they are read from Elasticsearch on each enrollment if it is not set.
+	policyReader *policy.Reader
+	// pm provides the limits of the loaded policies, the other policies are read with policyReader.
+	pm     policy.Monitor
+	quotas *policyQuotas
+
+	// drain is the draining state of the server, the enrollments are rejected while draining.
+	drain *drain.State
+
+	// idempotencyTTL is how long the enrollments with an idempotency key are replayed, the key is ignored if it is zero.
+	idempotencyTTL time.Duration
+	// enrolling holds the idempotency keys of the enrollments in progress.
+	enrolling sync.Map
 }
 
-func NewEnrollerT(verCon version.Constraints, cfg *config.Server, bulker bulk.Bulk, c cache.Cache) (*EnrollerT, error) {
-	return &EnrollerT{
+// Reload applies the reloaded server configuration to the requests handled from now on.
+func (et *EnrollerT) Reload(cfg *config.Server) {
+	et.reloaded.p.Store(cfg)
+}
+
+// serverCfg returns the server configuration of the requests, as last reloaded.
+func (et *EnrollerT) serverCfg() *config.Server {
+	return et.reloaded.get(et.cfg)
+}
+
+// EnrollerOpt is an optional setting for EnrollerT.
+type EnrollerOpt func(*EnrollerT)
+
+// WithEnrollCheckin records the state of the enrolled agents in the checkin coalescer bc.
+func WithEnrollCheckin(bc *checkin.Bulk) EnrollerOpt {
+	return func(et *EnrollerT) {
+		et.bc = bc
+	}
+}
+
+// WithEnrollServerVersion rejects the enrollment of agents newer than the server version ver.
+func WithEnrollServerVersion(ver *version.Version) EnrollerOpt {
+	return func(et *EnrollerT) {
+		et.serverVer = ver
+	}
+}
+
+// WithEnrollPolicyReader sets the reader of the policies of the static enrollment tokens.
+func WithEnrollPolicyReader(r *policy.Reader) EnrollerOpt {
+	return func(et *EnrollerT) {
+		et.policyReader = r
+	}
+}
+
+// WithEnrollPolicyMonitor gets the limits of the policies loaded by pm from it.
+func WithEnrollPolicyMonitor(pm policy.Monitor) EnrollerOpt {
+	return func(et *EnrollerT) {
+		et.pm = pm
+	}
+}
+
+// WithEnrollIdempotencyTTL replays the enrollments of the requests with an Idempotency-Key header for their retries within ttl.
+func WithEnrollIdempotencyTTL(ttl time.Duration) EnrollerOpt {
+	return func(et *EnrollerT) {
+		et.idempotencyTTL = ttl
+	}
+}
+
+// WithEnrollDrain rejects the enrollments while d is draining.
+func WithEnrollDrain(d *drain.State) EnrollerOpt {
+	return func(et *EnrollerT) {
+		et.drain = d
+	}
+}
+
+func NewEnrollerT(verCon version.Constraints, cfg *config.Server, bulker bulk.Bulk, c cache.Cache, opts ...EnrollerOpt) (*EnrollerT, error) {
+	et := &EnrollerT{
 		verCon: verCon,
 		cfg:    cfg,
 		bulker: bulker,
 		cache:  c,
-	}, nil
+		quotas: newPolicyQuotas(bulker),
+	}
+	for _, opt := range opts {
+		opt(et)
+	}
+	return et, nil
 }
 
 func (et *EnrollerT) handleEnroll(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, rb *rollback.Rollback, userAgent string) error {
+	// a draining server is about to shut down, the agent enrolls with another instance
+	if et.drain.Draining() {
+		setDrainRetryAfter(w, et.cfg.Draining.RetryAfter)
+		return ErrServerDraining
+	}
 	key, err := authAPIKey(r, et.bulker, et.cache)
 	if err != nil {
 		return err
@@ -92,7 +182,10 @@ func (et *EnrollerT) handleEnroll(zlog zerolog.Logger, w http.ResponseWriter, r
 	ctx := zlog.WithContext(r.Context())
 	r = r.WithContext(ctx)
 
-	ver, err := validateUserAgent(r.Context(), zlog, userAgent, et.verCon)
+	if err := checkUserAgentVersion(userAgent, et.serverVer); err != nil {
+		return err
+	}
+	ver, err := validateUserAgent(r.Context(), userAgent, et.verCon)
 	if err != nil {
 		return err
 	}
@@ -126,8 +219,8 @@ func (et *EnrollerT) processRequest(zlog zerolog.Logger, w http.ResponseWriter,
 	body := r.Body
 
 	// Limit the size of the body to prevent malicious agent from exhausting RAM in server
-	if et.cfg.Limits.EnrollLimit.MaxBody > 0 {
-		body = http.MaxBytesReader(w, body, et.cfg.Limits.EnrollLimit.MaxBody)
+	if et.serverCfg().Limits.EnrollLimit.MaxBody > 0 {
+		body = http.MaxBytesReader(w, body, et.serverCfg().Limits.EnrollLimit.MaxBody)
 	}
 
 	readCounter := datacounter.NewReaderCounter(body)
@@ -140,7 +233,22 @@ func (et *EnrollerT) processRequest(zlog zerolog.Logger, w http.ResponseWriter,
 
 	cntEnroll.bodyIn.Add(readCounter.Count())
 
-	return et._enroll(r.Context(), rb, zlog, req, enrollAPI.PolicyID, enrollAPI.Namespaces, ver)
+	if et.serverVer != nil {
+		agentVer, err := localMetadataVersion(req.Metadata.Local)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkAgentVersion(agentVer, et.serverVer); err != nil {
+			return nil, err
+		}
+	}
+
+	namespaces := enrollAPI.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = et.policyNamespaces(r.Context(), zlog, enrollAPI.PolicyID)
+	}
+
+	return et.enroll(r.Context(), rb, zlog, req, enrollmentAPIKey.ID, r.Header.Get(HeaderIdempotencyKey), enrollAPI.PolicyID, namespaces, ver)
 }
 
 // retrieveStaticTokenEnrollmentToken fetches the enrollment key record from the config static tokens.
@@ -176,6 +284,17 @@ func (et *EnrollerT) retrieveStaticTokenEnrollmentToken(ctx context.Context, zlo
 }
 
 func (et *EnrollerT) fetchPolicy(ctx context.Context, policyID string) (model.Policy, error) {
+	if et.policyReader != nil {
+		pp, err := et.policyReader.Get(ctx, policyID, 0)
+		if errors.Is(err, dl.ErrNotFound) {
+			return model.Policy{}, ErrPolicyNotFound
+		}
+		if err != nil {
+			return model.Policy{}, err
+		}
+		return pp.Policy, nil
+	}
+
 	policies, err := dl.QueryLatestPolicies(ctx, et.bulker)
 	if err != nil {
 		return model.Policy{}, err
@@ -193,6 +312,43 @@ func (et *EnrollerT) fetchPolicy(ctx context.Context, policyID string) (model.Po
 	return policy, nil
 }
 
+// policyLimits returns the limits of the policy, with the server-wide defaults for the limits it does not set.
+// The limits are read from the policy monitor, or from Elasticsearch if the monitor has not loaded the policy.
+func (et *EnrollerT) policyLimits(ctx context.Context, zlog zerolog.Logger, policyID string) policy.Limits {
+	defaults := policy.DefaultLimits(et.serverCfg())
+	if et.pm != nil {
+		if limits, ok := et.pm.Limits(policyID); ok {
+			return limits.WithDefaults(defaults)
+		}
+	}
+	if et.policyReader != nil {
+		pp, err := et.policyReader.Get(ctx, policyID, 0)
+		if err == nil {
+			return pp.Limits.WithDefaults(defaults)
+		}
+		zlog.Debug().Err(err).Str(LogPolicyID, policyID).Msg("unable to read policy limits, using the server defaults")
+	}
+	return defaults
+}
+
+// policyNamespaces returns the namespaces of the policy, the agents enrolled with a key without namespaces are placed in them.
+// The policy is read from the policy monitor, or from Elasticsearch if the monitor has not loaded it.
+func (et *EnrollerT) policyNamespaces(ctx context.Context, zlog zerolog.Logger, policyID string) []string {
+	if et.pm != nil {
+		if pp, ok := et.pm.Policy(policyID); ok {
+			return pp.Policy.Namespaces
+		}
+	}
+	if et.policyReader != nil {
+		pp, err := et.policyReader.Get(ctx, policyID, 0)
+		if err == nil {
+			return pp.Policy.Namespaces
+		}
+		zlog.Debug().Err(err).Str(LogPolicyID, policyID).Msg("unable to read policy namespaces, the agent is enrolled without namespaces")
+	}
+	return nil
+}
+
 func (et *EnrollerT) _enroll(
 	ctx context.Context,
 	rb *rollback.Rollback,
@@ -219,7 +375,7 @@ func (et *EnrollerT) _enroll(
 			zlog.Debug().Err(err).
 				Str("EnrollmentId", enrollmentID).
 				Msg("Agent with EnrollmentId not found")
-			if !errors.Is(err, dl.ErrNotFound) && !strings.Contains(err.Error(), "no such index") {
+			if !errors.Is(err, dl.ErrNotFound) && !errors.Is(err, es.ErrIndexNotFound) {
 				vSpan.End()
 				return nil, err
 			}
@@ -334,6 +490,17 @@ func (et *EnrollerT) _enroll(
 		agentID = u.String()
 	}
 
+	// the agents added by the enrollment are counted by the quotas of the policy once it completes
+	added := 0
+	if et.quotas != nil {
+		limits := et.policyLimits(ctx, zlog, policyID)
+		res, err := et.quotas.reserveEnroll(ctx, policyID, limits, agent.Id == "")
+		if err != nil {
+			return nil, err
+		}
+		defer func() { res.done(added) }()
+	}
+
 	// Update the local metadata agent id
 	localMeta, err := updateLocalMetaAgentID(req.Metadata.Local, agentID)
 	if err != nil {
@@ -351,7 +518,12 @@ func (et *EnrollerT) _enroll(
 		return invalidateAPIKey(ctx, zlog, et.bulker, accessAPIKey.ID)
 	})
 
+	if err := dl.CheckAgentTransition(zlog.WithContext(ctx), agentID, agent.State(), model.AgentStateEnrolled); err != nil {
+		return nil, err
+	}
+
 	// Existing agent, only update a subset of the fields
+	var written bulk.DocVersion
 	if agent.Id != "" {
 		agent.Active = true
 		agent.Namespaces = namespaces
@@ -380,9 +552,11 @@ func (et *EnrollerT) _enroll(
 			dl.FieldAuditUnenrolledReason: nil,
 			dl.FieldUnenrolledAt:          nil,
 			dl.FieldUnenrolledReason:      nil,
-			dl.FieldUpdatedAt:             now.UTC().Format(time.RFC3339),
+			dl.FieldUnenrollmentStartedAt: nil,
+			dl.FieldLifecycleState:        model.AgentStateEnrolled,
+			dl.FieldUpdatedAt:             ftime.Format(now),
 		}
-		err = updateFleetAgent(ctx, et.bulker, agentID, doc)
+		written, err = updateFleetAgent(ctx, et.bulker, agentID, doc)
 		if err != nil {
 			return nil, err
 		}
@@ -402,7 +576,7 @@ func (et *EnrollerT) _enroll(
 			PolicyID:       policyID,
 			Namespaces:     namespaces,
 			Type:           string(req.Type),
-			EnrolledAt:     now.UTC().Format(time.RFC3339),
+			EnrolledAt:     ftime.Format(now),
 			LocalMetadata:  localMeta,
 			AccessAPIKeyID: accessAPIKey.ID,
 			ActionSeqNo:    []int64{sqn.UndefinedSeqNo},
@@ -410,54 +584,58 @@ func (et *EnrollerT) _enroll(
 				ID:      agentID,
 				Version: ver,
 			},
-			Tags:         removeDuplicateStr(req.Metadata.Tags),
-			EnrollmentID: enrollmentID,
-			ReplaceToken: replaceHash,
+			Tags:           removeDuplicateStr(req.Metadata.Tags),
+			EnrollmentID:   enrollmentID,
+			ReplaceToken:   replaceHash,
+			LifecycleState: string(model.AgentStateEnrolled),
 		}
 
-		err = createFleetAgent(ctx, et.bulker, agentID, agent)
+		written, err = createFleetAgent(ctx, et.bulker, agentID, agent)
 		if err != nil {
 			return nil, err
 		}
+		added = 1
 		// Register delete fleet agent for enrollment error rollback
 		rb.Register("delete agent", func(ctx context.Context) error {
 			return deleteAgent(ctx, zlog, et.bulker, agentID)
 		})
 	}
 
-	resp := EnrollResponse{
-		Action: "created",
-		Item: EnrollResponseItem{
-			AccessApiKey:         accessAPIKey.Token(),
-			AccessApiKeyId:       agent.AccessAPIKeyID,
-			Active:               agent.Active,
-			EnrolledAt:           agent.EnrolledAt,
-			Id:                   agentID,
-			LocalMetadata:        agent.LocalMetadata,
-			PolicyId:             agent.PolicyID,
-			Status:               "online",
-			Tags:                 agent.Tags,
-			Type:                 agent.Type,
-			UserProvidedMetadata: agent.UserProvidedMetadata,
-		},
-	}
+	et.bc.SetState(agentID, model.AgentStateEnrolled)
+	// The lookups of the agent before its enrollment may be cached as not found.
+	et.cache.InvalidateNotFound(cache.KindAgent, agentID)
+	et.cache.InvalidateNotFound(cache.KindAgentAPIKey, accessAPIKey.ID)
+	setAgentVersion(et.cache, agentID, accessAPIKey.ID, written)
+
+	resp := newEnrollResponse(agentID, agent, accessAPIKey)
 
 	// We are Kool & and the Gang; cache the access key to avoid the roundtrip on impending checkin
 	et.cache.SetAPIKey(*accessAPIKey, true)
 
-	return &resp, nil
+	return resp, nil
 }
 
 func (et *EnrollerT) _checkAgent(ctx context.Context, zlog zerolog.Logger, agentID string) (model.Agent, error) {
 	vSpan, vCtx := apm.StartSpan(ctx, "checkAgentID", "validate")
 	defer vSpan.End()
 
-	agent, err := dl.FindAgent(vCtx, et.bulker, dl.QueryAgentByID, dl.FieldID, agentID)
+	var (
+		agent model.Agent
+		err   error
+	)
+	if v, ok := et.cache.GetAgentVersion(agentID); ok {
+		// the agent was written by this instance without a refresh, the search may not see it yet: it is read by id
+		if agent, err = dl.GetAgent(vCtx, et.bulker, agentID); err == nil {
+			err = checkWrittenAgent(agent, v)
+		}
+	} else {
+		agent, err = dl.FindAgent(vCtx, et.bulker, dl.QueryAgentByID, dl.FieldID, agentID)
+	}
 	if err != nil {
 		zlog.Debug().Err(err).
 			Str("ID", agentID).
 			Msg("Agent with ID not found")
-		if !errors.Is(err, dl.ErrNotFound) && !strings.Contains(err.Error(), "no such index") {
+		if !errors.Is(err, dl.ErrNotFound) && !errors.Is(err, es.ErrIndexNotFound) {
 			return model.Agent{}, err
 		}
 		return model.Agent{}, nil
@@ -467,6 +645,12 @@ func (et *EnrollerT) _checkAgent(ctx context.Context, zlog zerolog.Logger, agent
 		zlog.Debug().
 			Str("ID", agentID).
 			Msg("Inactive agent with ID found")
+		// the pending API keys are invalidated first, they are no longer known once the record is deleted
+		if agent.APIKeysInvalidationPending {
+			if err := unenroll.InvalidateAPIKeys(ctx, et.bulker, agent.APIKeyIDs(), ""); err != nil {
+				return model.Agent{}, fmt.Errorf("invalidate pending API keys of agent %s: %w", agent.Id, err)
+			}
+		}
 		err = deleteAgent(ctx, zlog, et.bulker, agent.Id)
 		if err != nil {
 			zlog.Error().Err(err).
@@ -504,10 +688,17 @@ func deleteAgent(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, age
 }
 
 func invalidateAPIKey(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, apikeyID string) error {
+	return invalidateAccessAPIKeys(ctx, zlog, bulker, apikeyID)
+}
+
+// invalidateAccessAPIKeys invalidates the api keys created by the instance with a single request, once they are visible.
+func invalidateAccessAPIKeys(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, apikeyIDs ...string) error {
 	// hack-a-rama:  We purposely do not force a "refresh:true" on the Apikey creation
 	// because doing so causes the api call to slow down at scale. It is already very slow.
 	// So we have to wait for the key to become visible until we can invalidate it.
-	zlog = zlog.With().Str(LogAPIKeyID, apikeyID).Logger()
+	// The keys are created together, so the last one is waited for.
+	apikeyID := apikeyIDs[len(apikeyIDs)-1]
+	zlog = zlog.With().Str(LogAPIKeyID, apikeyID).Int("count", len(apikeyIDs)).Logger()
 
 	start := time.Now()
 
@@ -539,7 +730,7 @@ LOOP:
 		}
 	}
 
-	if err := bulker.APIKeyInvalidate(ctx, apikeyID); err != nil {
+	if err := bulker.APIKeyInvalidate(ctx, apikeyIDs...); err != nil {
 		zlog.Error().Err(err).Msg("fail invalidate apiKey")
 		return err
 	}
@@ -632,41 +823,60 @@ func updateLocalMetaAgentID(data []byte, agentID string) ([]byte, error) {
 	return data, nil
 }
 
-func updateFleetAgent(ctx context.Context, bulker bulk.Bulk, id string, doc bulk.UpdateFields) error {
+// updateFleetAgent updates the agent document and returns its new version. The index is not refreshed, the version is
+// recorded in the cache for the readers of the instance.
+func updateFleetAgent(ctx context.Context, bulker bulk.Bulk, id string, doc bulk.UpdateFields, opts ...bulk.Opt) (bulk.DocVersion, error) {
 	span, ctx := apm.StartSpan(ctx, "updateAgent", "update")
 	defer span.End()
 
 	body, err := doc.Marshal()
 	if err != nil {
-		return err
+		return bulk.DocVersion{}, err
 	}
-	return bulker.Update(ctx, dl.FleetAgents, id, body, bulk.WithRefresh(), bulk.WithRetryOnConflict(3))
+	var v bulk.DocVersion
+	err = bulker.Update(ctx, dl.FleetAgents, id, body, append(opts, bulk.WithRetryOnConflict(3), bulk.WithDocVersion(&v))...)
+	return v, err
 }
 
-func createFleetAgent(ctx context.Context, bulker bulk.Bulk, id string, agent model.Agent) error {
+// createFleetAgent writes the agent document and returns its version. The index is not refreshed, the version is
+// recorded in the cache for the readers of the instance.
+func createFleetAgent(ctx context.Context, bulker bulk.Bulk, id string, agent model.Agent) (bulk.DocVersion, error) {
 	span, ctx := apm.StartSpan(ctx, "createAgent", "create")
 	defer span.End()
 
 	data, err := json.Marshal(agent)
 	if err != nil {
-		return err
+		return bulk.DocVersion{}, err
 	}
 
-	_, err = bulker.Create(ctx, dl.FleetAgents, id, data, bulk.WithRefresh())
-	if err != nil {
-		return err
-	}
-	return nil
+	var v bulk.DocVersion
+	_, err = bulker.Create(ctx, dl.FleetAgents, id, data, bulk.WithDocVersion(&v))
+	return v, err
 }
 
-func generateAccessAPIKey(ctx context.Context, bulk bulk.Bulk, agentID string) (*apikey.APIKey, error) {
-	return bulk.APIKeyCreate(
-		ctx,
-		agentID,
-		"",
-		[]byte(kFleetAccessRolesJSON),
-		apikey.NewMetadata(agentID, "", apikey.TypeAccess),
-	)
+// setAgentVersion records the version v of the agent document written by the instance, so its next reads by the
+// agent, as the first checkin or policy fetch after the enrollment, see it without a refresh of the index.
+func setAgentVersion(c cache.Cache, agentID, accessAPIKeyID string, v bulk.DocVersion) {
+	c.SetAgentVersion(cache.AgentVersion{
+		AgentID:        agentID,
+		AccessAPIKeyID: accessAPIKeyID,
+		SeqNo:          v.SeqNo,
+		PrimaryTerm:    v.PrimaryTerm,
+	})
+}
+
+func generateAccessAPIKey(ctx context.Context, bulker bulk.Bulk, agentID string) (*apikey.APIKey, error) {
+	req := accessAPIKeyRequest(agentID)
+	return bulker.APIKeyCreate(ctx, req.Name, req.TTL, req.Roles, req.Meta)
+}
+
+// accessAPIKeyRequest returns the request that creates the access API key of an agent.
+func accessAPIKeyRequest(agentID string) bulk.APIKeyCreateRequest {
+	return bulk.APIKeyCreateRequest{
+		Name:  agentID,
+		Roles: []byte(kFleetAccessRolesJSON),
+		Meta:  apikey.NewMetadata(agentID, "", apikey.TypeAccess),
+	}
 }
 
 func (et *EnrollerT) fetchEnrollmentKeyRecord(ctx context.Context, id string) (*model.EnrollmentAPIKey, error) {
@@ -697,18 +907,9 @@ func validateRequest(ctx context.Context, data io.Reader) (*EnrollRequest, error
 	defer span.End()
 
 	var req EnrollRequest
-	decoder := json.NewDecoder(data)
-	if err := decoder.Decode(&req); err != nil {
-		return nil, &BadRequestErr{msg: "unable to decode enroll request", nextErr: err}
-	}
-
-	// Validate
-	switch req.Type {
-	case EnrollEphemeral, EnrollPermanent, EnrollTemporary:
-	default:
-		return nil, ErrUnknownEnrollType
+	if err := decodeRequest(ctx, data, &req, "enroll"); err != nil {
+		return nil, err
 	}
-
 	return &req, nil
 }
 
@@ -7,17 +7,26 @@
 package api
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
@@ -28,6 +37,7 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/rollback"
 	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 )
 
 func TestRemoveDuplicateStr(t *testing.T) {
@@ -569,6 +579,42 @@ func TestEnrollerT_retrieveStaticTokenEnrollmentToken(t *testing.T) {
 	}
 }
 
+func TestEnrollAgentVersion(t *testing.T) {
+	policy, err := json.Marshal(model.Policy{PolicyID: "dummy-policy"})
+	require.NoError(t, err)
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&es.ResultT{
+		Aggregations: map[string]es.Aggregation{
+			"policy_id": {
+				Buckets: []es.Bucket{{
+					Aggregations: map[string]es.HitsT{
+						"revision_idx": {Hits: []es.HitT{{Source: policy}}},
+					},
+				}},
+			},
+		},
+	}, nil)
+	serverVer, err := ParseVersion("8.5.0")
+	require.NoError(t, err)
+	et, err := NewEnrollerT(mustBuildConstraints("8.5.0"), &config.Server{
+		StaticPolicyTokens: config.StaticPolicyTokens{
+			Enabled:      true,
+			PolicyTokens: []config.PolicyToken{{TokenKey: "abcdefg", PolicyID: "dummy-policy"}},
+		},
+	}, bulker, nil, WithEnrollServerVersion(serverVer))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/enroll", strings.NewReader(`{
+		"type": "PERMANENT",
+		"metadata": {"local": {"elastic": {"agent": {"version": "9.0.0"}}}, "user_provided": {}}
+	}`))
+	_, err = et.processRequest(zerolog.Logger{}, w, r, &rollback.Rollback{}, &apikey.APIKey{Key: "abcdefg"}, "8.5.0")
+	require.ErrorIs(t, err, ErrAgentVersionNotSupported)
+	require.EqualError(t, err, "agent version is not supported: agent version 9.0.0 is newer than fleet-server version 8.5.0")
+	bulker.AssertNotCalled(t, "APIKeyCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
 func TestValidateEnrollRequest(t *testing.T) {
 	t.Run("invalid json", func(t *testing.T) {
 		req, err := validateRequest(context.Background(), strings.NewReader("not a json"))
@@ -582,3 +628,203 @@ func TestValidateEnrollRequest(t *testing.T) {
 		assert.Equal(t, json.RawMessage(`{"elastic": {"agent": {"fips": true, "snapshot": false}}}`), req.Metadata.Local)
 	})
 }
+
+// agentsTransport is a mock transport that keeps the documents of the .fleet-agents index in memory.
+// The writes are seen at once by the reads by id, the searches only see them once a write asks for a refresh.
+type agentsTransport struct {
+	mu        sync.Mutex
+	seqNo     int64
+	docs      map[string]agentsTransportDoc
+	refreshed map[string]agentsTransportDoc
+	refreshes int // the bulk requests with a refresh
+}
+
+type agentsTransportDoc struct {
+	seqNo  int64
+	source map[string]any
+}
+
+func newAgentsTransport() *agentsTransport {
+	return &agentsTransport{docs: make(map[string]agentsTransportDoc), refreshed: make(map[string]agentsTransportDoc)}
+}
+
+func (m *agentsTransport) Perform(req *http.Request) (*http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var body bytes.Buffer
+	var err error
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/_bulk"):
+		err = m.bulk(req.Body, &body)
+		if req.URL.Query().Has("refresh") {
+			m.refreshes++
+			m.refreshed = maps.Clone(m.docs)
+		}
+	case strings.HasSuffix(req.URL.Path, "/_mget"):
+		err = m.mget(req.Body, &body)
+	case strings.HasSuffix(req.URL.Path, "/_msearch"):
+		err = m.msearch(req.Body, &body)
+	default:
+		err = fmt.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Request:    req,
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(&body),
+	}, nil
+}
+
+func (m *agentsTransport) bulk(r io.Reader, out *bytes.Buffer) error {
+	var items []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var meta map[string]struct {
+			ID string `json:"_id"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil {
+			return err
+		}
+		if !scanner.Scan() {
+			return errors.New("missing bulk body")
+		}
+		for action, md := range meta {
+			var source map[string]any
+			switch action {
+			case "create":
+				if err := json.Unmarshal(scanner.Bytes(), &source); err != nil {
+					return err
+				}
+			case "update":
+				var upd struct {
+					Doc    map[string]any `json:"doc"`
+					Script *struct {
+						Params map[string]any `json:"params"`
+					} `json:"script"`
+				}
+				if err := json.Unmarshal(scanner.Bytes(), &upd); err != nil {
+					return err
+				}
+				source = maps.Clone(m.docs[md.ID].source)
+				maps.Copy(source, upd.Doc)
+				if upd.Script != nil && source[dl.FieldPolicyID] == upd.Script.Params["id"] {
+					// the policy ack script
+					source[dl.FieldPolicyRevisionIdx] = upd.Script.Params["rev"]
+				}
+			default:
+				return fmt.Errorf("unexpected action %s", action)
+			}
+			m.seqNo++
+			m.docs[md.ID] = agentsTransportDoc{seqNo: m.seqNo, source: source}
+			items = append(items, fmt.Sprintf(`{%q:{"_id":%q,"status":200,"_seq_no":%d,"_primary_term":1}}`, action, md.ID, m.seqNo))
+		}
+	}
+	fmt.Fprintf(out, `{"took":1,"errors":false,"items":[%s]}`, strings.Join(items, ","))
+	return scanner.Err()
+}
+
+func (m *agentsTransport) mget(r io.Reader, out *bytes.Buffer) error {
+	var req struct {
+		Docs []struct {
+			ID string `json:"_id"`
+		} `json:"docs"`
+	}
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		return err
+	}
+	docs := make([]string, 0, len(req.Docs))
+	for _, d := range req.Docs {
+		doc, ok := m.docs[d.ID]
+		if !ok {
+			docs = append(docs, fmt.Sprintf(`{"_id":%q,"found":false}`, d.ID))
+			continue
+		}
+		source, err := json.Marshal(doc.source)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, fmt.Sprintf(`{"_id":%q,"_seq_no":%d,"_primary_term":1,"found":true,"_source":%s}`, d.ID, doc.seqNo, source))
+	}
+	fmt.Fprintf(out, `{"docs":[%s]}`, strings.Join(docs, ","))
+	return nil
+}
+
+// msearch answers the term queries on the access_api_key_id of the agents with the refreshed documents.
+func (m *agentsTransport) msearch(r io.Reader, out *bytes.Buffer) error {
+	var responses []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if !scanner.Scan() {
+			return errors.New("missing msearch body")
+		}
+		var hits []string
+		for id, doc := range m.refreshed {
+			if !bytes.Contains(scanner.Bytes(), []byte(fmt.Sprintf("%q", doc.source[dl.FieldAccessAPIKeyID]))) {
+				continue
+			}
+			source, err := json.Marshal(doc.source)
+			if err != nil {
+				return err
+			}
+			hits = append(hits, fmt.Sprintf(`{"_id":%q,"_seq_no":%d,"_source":%s}`, id, doc.seqNo, source))
+		}
+		responses = append(responses, fmt.Sprintf(`{"status":200,"hits":{"total":{"value":%d,"relation":"eq"},"hits":[%s]}}`, len(hits), strings.Join(hits, ",")))
+	}
+	fmt.Fprintf(out, `{"took":1,"responses":[%s]}`, strings.Join(responses, ","))
+	return scanner.Err()
+}
+
+func runAgentsBulker(t *testing.T, tr *agentsTransport) bulk.Bulk {
+	t.Helper()
+	bulker := bulk.NewBulker(tr, nil, bulk.WithFlushThresholdCount(1), bulk.WithFlushInterval(time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+	return bulker
+}
+
+func TestEnrollReadAfterWrite(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	tr := newAgentsTransport()
+	bulker := runAgentsBulker(t, tr)
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+
+	// the agent is written without a refresh
+	written, err := createFleetAgent(ctx, bulker, "agent-1", model.Agent{Active: true, AccessAPIKeyID: "key-1", Agent: &model.AgentMetadata{ID: "agent-1"}})
+	require.NoError(t, err)
+	require.Zero(t, tr.refreshes)
+
+	// the search does not see it yet
+	_, err = findAgentByAPIKeyID(ctx, bulker, c, "key-1")
+	require.ErrorIs(t, err, ErrAgentNotFound)
+	c.InvalidateNotFound(cache.KindAgentAPIKey, "key-1")
+
+	// once its version is recorded, the agent is read by id
+	setAgentVersion(c, "agent-1", "key-1", written)
+	agent, err := findAgentByAPIKeyID(ctx, bulker, c, "key-1")
+	require.NoError(t, err)
+	require.Equal(t, "agent-1", agent.Id)
+	require.Equal(t, written.SeqNo, agent.SeqNo)
+
+	agent, err = getAgentAndVerifyAPIKeyID(ctx, bulker, c, "agent-1", "key-1")
+	require.NoError(t, err)
+	require.True(t, agent.Active)
+
+	// a read older than the recorded version is rejected
+	setAgentVersion(c, "agent-1", "key-1", bulk.DocVersion{SeqNo: written.SeqNo + 1, PrimaryTerm: written.PrimaryTerm})
+	_, err = getAgentAndVerifyAPIKeyID(ctx, bulker, c, "agent-1", "key-1")
+	require.ErrorIs(t, err, errStaleAgentRead)
+}
@@ -0,0 +1,49 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// httpHealth is the health of the HTTP layer of a server, reported by the healthz endpoint.
+// Unlike the status endpoint it never calls elasticsearch, it is meant for the liveness probes.
+type httpHealth struct {
+	unhealthy atomic.Bool
+}
+
+// stop reports the server as unable to serve requests, once it is shutting down or its listener failed.
+func (h *httpHealth) stop() {
+	h.unhealthy.Store(true)
+}
+
+// handleHealthz writes a 200 while the server is able to serve requests and a 503 after stop.
+// A nil httpHealth is always healthy.
+func (h *httpHealth) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if h != nil && h.unhealthy.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("shutting down"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+// skipHealthz applies mw to the requests of the other endpoints, the healthz requests bypass it.
+func skipHealthz(mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if pathToOperation(r.URL.Path) == "healthz" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-client/v7/pkg/client"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	fbuild "github.com/elastic/fleet-server/v7/internal/pkg/build"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestHealthz(t *testing.T) {
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Limits.MaxConnections = 1
+	cfg.Limits.StatusLimit = config.Limit{Interval: time.Hour, Burst: 1}
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	bulker := ftesting.NewMockBulk()
+	bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{UserName: "elastic", Enabled: true}, nil)
+	st := NewStatusT(cfg, bulker, c, WithSelfMonitor(&mockPolicyMonitor{client.UnitStateHealthy}))
+	hr := newRouter(cfg, &apiServer{st: st, health: &httpHealth{}}, nil, nil)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r = r.WithContext(testlog.SetLogger(t).WithContext(context.Background()))
+		r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+		hr.ServeHTTP(w, r)
+		return w
+	}
+
+	// the healthz requests are neither authenticated nor rate limited
+	for i := 0; i < 5; i++ {
+		w := get("/api/fleet/healthz")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "ok", w.Body.String())
+	}
+	require.Empty(t, bulker.Calls, "healthz must not call elasticsearch")
+
+	// the status requests authenticate the api key with elasticsearch, and are rate limited
+	require.Equal(t, http.StatusOK, get("/api/status").Code)
+	bulker.AssertNumberOfCalls(t, "APIKeyAuth", 1)
+	require.Equal(t, http.StatusTooManyRequests, get("/api/status").Code)
+	require.Equal(t, http.StatusOK, get("/api/fleet/healthz").Code)
+	bulker.AssertNumberOfCalls(t, "APIKeyAuth", 1)
+}
+
+func TestHealthzShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(testlog.SetLogger(t).WithContext(context.Background()))
+	defer cancel()
+
+	port, err := ftesting.FreePort()
+	require.NoError(t, err)
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Host = "localhost"
+	cfg.Port = port
+	addr := cfg.BindEndpoints()[0]
+	srv := NewServer(addr, cfg, WithStatus(NewStatusT(cfg, ftesting.NewMockBulk(), nil)))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Run(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(fmt.Sprintf("http://%s/api/fleet/healthz", addr)) //nolint:noctx // test request
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode == http.StatusOK && string(body) == "ok"
+	}, 5*time.Second, 50*time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil && !errors.Is(err, context.Canceled) {
+			require.NoError(t, err)
+		}
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for server to shut down")
+	}
+
+	w := httptest.NewRecorder()
+	srv.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/fleet/healthz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, "shutting down", w.Body.String())
+}
+
+func TestHealthzUnavailable(t *testing.T) {
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	srv := NewUnavailableServer("localhost:0", cfg, client.UnitStateStarting, fbuild.Info{})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/fleet/healthz", nil)
+	srv.handler.ServeHTTP(w, r.WithContext(testlog.SetLogger(t).WithContext(context.Background())))
+	require.Equal(t, http.StatusOK, w.Code)
+}
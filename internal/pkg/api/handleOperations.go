@@ -0,0 +1,92 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog"
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/operation"
+)
+
+const kOperationsMod = "operations"
+
+// OperationsT reports the progress of the batch operations updating the agents.
+type OperationsT struct {
+	bulker bulk.Bulk
+	ops    *operation.Tracker
+}
+
+func NewOperationsT(bulker bulk.Bulk, ops *operation.Tracker) *OperationsT {
+	return &OperationsT{
+		bulker: bulker,
+		ops:    ops,
+	}
+}
+
+func (ot *OperationsT) handleGet(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, id string) error {
+	info, err := authServiceToken(r, ot.bulker)
+	if err != nil {
+		return err
+	}
+	zlog = zlog.With().Str("userName", info.UserName).Logger()
+
+	op, err := ot.ops.Get(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	resp := operationResponse(op)
+	zlog.Trace().
+		Str("status", resp.Status).
+		Int("total", resp.Total).
+		Msg("Operation progress")
+
+	span, _ := apm.StartSpan(r.Context(), "response", "write")
+	defer span.End()
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("operation marshal: %w", err)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(data)
+	cntOperations.bodyOut.Add(uint64(len(data)))
+	return err
+}
+
+func operationResponse(op *operation.Operation) *OperationResponse {
+	resp := &OperationResponse{
+		Id:        op.ID,
+		Type:      op.Type,
+		Status:    string(op.Status),
+		Total:     op.Total,
+		Succeeded: op.Succeeded,
+		Failed:    op.Failed,
+	}
+	if len(op.Filter) > 0 {
+		resp.Filter = &op.Filter
+	}
+	if op.Cursor != "" {
+		resp.Cursor = &op.Cursor
+	}
+	if op.Resumed > 0 {
+		resp.Resumed = &op.Resumed
+	}
+	if op.Error != "" {
+		resp.Error = &op.Error
+	}
+	if t, err := ftime.Parse(op.StartedAt); err == nil {
+		resp.StartedAt = t
+	}
+	if t, err := ftime.Parse(op.UpdatedAt); err == nil {
+		resp.UpdatedAt = t
+	}
+	return resp
+}
@@ -0,0 +1,78 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/operation"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func Test_Operations_get(t *testing.T) {
+	store := operation.NewMemoryStore()
+	require.NoError(t, store.Store(context.Background(), &operation.Operation{
+		ID:        "op-1",
+		Type:      "reassign",
+		Filter:    map[string]string{"policy_id": "target"},
+		Cursor:    "1000",
+		Total:     1000,
+		Succeeded: 998,
+		Failed:    2,
+		Status:    operation.StatusRunning,
+		Resumed:   1,
+		StartedAt: "2025-04-01T10:00:00Z",
+		UpdatedAt: "2025-04-01T10:01:00Z",
+	}))
+
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	bulker := ftesting.NewMockBulk()
+	authorization := mockServiceToken(t, bulker)
+	hr := newRouter(cfg, &apiServer{ot: NewOperationsT(bulker, operation.NewTracker(store))}, nil, nil)
+
+	get := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/fleet/operations/"+id, nil)
+		r = r.WithContext(testlog.SetLogger(t).WithContext(context.Background()))
+		r.Header.Set("Authorization", authorization)
+		hr.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("op-1")
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp OperationResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	cursor, resumed := "1000", 1
+	require.Equal(t, OperationResponse{
+		Id:        "op-1",
+		Type:      "reassign",
+		Filter:    &map[string]string{"policy_id": "target"},
+		Cursor:    &cursor,
+		Total:     1000,
+		Succeeded: 998,
+		Failed:    2,
+		Status:    "running",
+		Resumed:   &resumed,
+		StartedAt: time.Date(2025, 4, 1, 10, 0, 0, 0, time.UTC),
+		UpdatedAt: time.Date(2025, 4, 1, 10, 1, 0, 0, time.UTC),
+	}, resp)
+
+	w = get("missing")
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Contains(t, w.Body.String(), "OperationNotFound")
+}
@@ -0,0 +1,144 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/hashicorp/go-version"
+	"github.com/rs/zerolog"
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+)
+
+const kPolicyMod = "policy"
+
+var (
+	// ErrPolicyForbidden is returned when an agent fetches a policy it is not assigned to.
+	ErrPolicyForbidden = errors.New("agent is not assigned to the policy")
+	// ErrPolicyTooLarge is returned when a policy revision larger than the max policy size is not delivered
+	// to an agent that can't fetch it from the policy endpoint.
+	ErrPolicyTooLarge = errors.New("policy is larger than the max policy size")
+)
+
+// policyFetchVersion is the first version of the agents that fetch the policy revisions larger than the max policy
+// size from the policy endpoint, the older agents only get the revisions delivered inline.
+var policyFetchVersion = version.Must(version.NewVersion("9.1.0"))
+
+// canFetchPolicy returns true if the agent of version ver fetches the policy revisions from the policy endpoint.
+// The pre-release of the version is ignored, an agent that does not report a valid version can't fetch them.
+func canFetchPolicy(ver string) bool {
+	v, err := ParseVersion(ver)
+	if err != nil {
+		return false
+	}
+	return !v.Core().LessThan(policyFetchVersion)
+}
+
+// policyPath returns the path of the policy endpoint serving the revision of the policy.
+func policyPath(policyID string, revisionIdx int64) string {
+	return "/api/fleet/policies/" + url.PathEscape(policyID) + "/" + strconv.FormatInt(revisionIdx, 10)
+}
+
+// PolicyT serves the policy revisions that are too large to be delivered inline in the checkin responses,
+// and the rollout of the policies to the agents.
+type PolicyT struct {
+	bulker  bulk.Bulk
+	cache   cache.Cache
+	pm      policy.Monitor
+	rollout *policyRollout
+}
+
+func NewPolicyT(_ *config.Server, bulker bulk.Bulk, cache cache.Cache, pm policy.Monitor) *PolicyT {
+	return &PolicyT{
+		bulker:  bulker,
+		cache:   cache,
+		pm:      pm,
+		rollout: newPolicyRollout(bulker),
+	}
+}
+
+// RolloutSchedule returns the schedule refreshing the number of agents on each revision of the policies.
+func (pt *PolicyT) RolloutSchedule() scheduler.Schedule {
+	return pt.rollout.Schedule()
+}
+
+// RegisterRolloutStats reports the number of agents on each revision of the policies in reg.
+func (pt *PolicyT) RegisterRolloutStats(reg *monitoring.Registry) {
+	pt.rollout.Register(reg)
+}
+
+// handleGet writes the revision of the policy encoded as in the POLICY_CHANGE actions, without the outputs and secret
+// paths that the action holds. The agent must be assigned to the policy.
+func (pt *PolicyT) handleGet(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, id string, revision int64) error {
+	agent, err := authAgent(r, nil, pt.bulker, pt.cache)
+	if err != nil {
+		return err
+	}
+	zlog = zlog.With().Str(LogAgentID, agent.Id).Logger()
+	r = r.WithContext(zlog.WithContext(r.Context()))
+
+	if agent.PolicyID != id {
+		zlog.Warn().Str("agent_policy_id", agent.PolicyID).Msg("agent fetched a policy it is not assigned to")
+		return ErrPolicyForbidden
+	}
+	if revision <= 0 {
+		return &BadRequestErr{msg: fmt.Sprintf("invalid policy revision %d", revision)}
+	}
+
+	pp, err := pt.policyRevision(r.Context(), id, revision)
+	if err != nil {
+		return err
+	}
+	body, err := pp.Body(marshalPolicyBody)
+	if err != nil {
+		return err
+	}
+	etag := revisionETag(pp, body)
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		writeNotModified(w, etag)
+		zlog.Trace().Msg("policy not modified")
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	n, err := w.Write(body)
+	cntPolicyFetch.bodyOut.Add(uint64(n)) //nolint:gosec // disable G115
+	if err != nil {
+		return err
+	}
+	zlog.Debug().Int("size", n).Msg("policy revision sent")
+	return nil
+}
+
+// policyRevision returns the revision of the policy, the latest revision is the one of the policy monitor that
+// the checkins delivered, the older ones are read from Elasticsearch.
+func (pt *PolicyT) policyRevision(ctx context.Context, policyID string, revisionIdx int64) (*policy.ParsedPolicy, error) {
+	span, ctx := apm.StartSpan(ctx, "policyRevision", "process")
+	defer span.End()
+
+	if pp, ok := pt.pm.Policy(policyID); ok && pp.Policy.RevisionIdx == revisionIdx {
+		return pp, nil
+	}
+	p, err := dl.FindPolicyRevision(ctx, pt.bulker, policyID, revisionIdx)
+	if err != nil {
+		return nil, err
+	}
+	return policy.NewParsedPolicy(ctx, pt.bulker, p)
+}
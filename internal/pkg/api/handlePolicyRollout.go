@@ -0,0 +1,234 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/rs/zerolog"
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+)
+
+const (
+	// policyRolloutTTL is the max age of the counts of the agents on each revision of the policies.
+	policyRolloutTTL = time.Minute
+
+	defaultRolloutStuckThreshold = 15 * time.Minute
+	maxRolloutStuckAgents        = 100
+)
+
+// policyRollout counts the active agents on each revision of each policy.
+// The counts are aggregated over the agents index every policyRolloutTTL, and on a rollout request when they are older,
+// so the rollout requests and the stats reads don't query Elasticsearch each time.
+type policyRollout struct {
+	bulker bulk.Bulk
+
+	mut       sync.Mutex
+	updatedAt time.Time
+	policies  map[string]map[int64]int64
+}
+
+func newPolicyRollout(bulker bulk.Bulk) *policyRollout {
+	return &policyRollout{bulker: bulker}
+}
+
+// Schedule returns the schedule refreshing the counts.
+func (pr *policyRollout) Schedule() scheduler.Schedule {
+	return scheduler.Schedule{
+		Name:     "policy rollout",
+		Interval: policyRolloutTTL,
+		WorkFn:   pr.refresh,
+	}
+}
+
+func (pr *policyRollout) refresh(ctx context.Context) error {
+	pr.mut.Lock()
+	defer pr.mut.Unlock()
+	return pr.refreshLocked(ctx)
+}
+
+func (pr *policyRollout) refreshLocked(ctx context.Context) error {
+	policies, err := dl.AggregatePolicyRevisions(ctx, pr.bulker)
+	if err != nil {
+		return fmt.Errorf("failed to count the agents on each policy revision: %w", err)
+	}
+	pr.policies = policies
+	pr.updatedAt = time.Now().UTC()
+	return nil
+}
+
+// revisions returns the number of agents on each revision of the policy and the time they were counted.
+// The counts are refreshed first if they are older than policyRolloutTTL, the concurrent requests wait for the refresh.
+func (pr *policyRollout) revisions(ctx context.Context, policyID string) (map[int64]int64, time.Time, error) {
+	pr.mut.Lock()
+	defer pr.mut.Unlock()
+	if time.Since(pr.updatedAt) >= policyRolloutTTL {
+		if err := pr.refreshLocked(ctx); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	return pr.policies[policyID], pr.updatedAt, nil
+}
+
+// Register reports the number of agents on each revision of each policy in reg, as they were last counted.
+func (pr *policyRollout) Register(reg *monitoring.Registry) {
+	monitoring.NewFunc(reg, "policies", pr.report)
+}
+
+func (pr *policyRollout) report(_ monitoring.Mode, v monitoring.Visitor) {
+	pr.mut.Lock()
+	defer pr.mut.Unlock()
+	v.OnRegistryStart()
+	defer v.OnRegistryFinished()
+
+	for policyID, revisions := range pr.policies {
+		monitoring.ReportNamespace(v, policyID, func() {
+			for revisionIdx, agents := range revisions {
+				monitoring.ReportInt(v, strconv.FormatInt(revisionIdx, 10), agents)
+			}
+		})
+	}
+}
+
+// handleRollout writes the number of agents on each revision of the policy, and the agents that did not move to the
+// latest revision within the stuck threshold.
+func (pt *PolicyT) handleRollout(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, id string, params GetPolicyRolloutParams) error {
+	info, err := authServiceToken(r, pt.bulker)
+	if err != nil {
+		return err
+	}
+	zlog = zlog.With().Str("userName", info.UserName).Logger()
+
+	threshold := defaultRolloutStuckThreshold
+	if params.StuckThreshold != nil {
+		threshold, err = time.ParseDuration(*params.StuckThreshold)
+		if err != nil || threshold < 0 {
+			return &BadRequestErr{msg: fmt.Sprintf("invalid stuck threshold %q", *params.StuckThreshold)}
+		}
+	}
+
+	resp, err := pt.policyRollout(r.Context(), id, threshold, time.Now())
+	if err != nil {
+		return err
+	}
+	zlog.Trace().
+		Int64(logger.RevisionIdx, resp.RevisionIdx).
+		Int("total", resp.Total).
+		Int("stuck", resp.Stuck).
+		Msg("Policy rollout")
+
+	span, _ := apm.StartSpan(r.Context(), "response", "write")
+	defer span.End()
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("policyRollout marshal: %w", err)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(data)
+	cntPolicyRollout.bodyOut.Add(uint64(len(data)))
+	return err
+}
+
+// policyRollout returns the rollout of the latest revision of the policy at now.
+// The stuck agents are only read from Elasticsearch when the counts have agents on older revisions past the threshold.
+func (pt *PolicyT) policyRollout(ctx context.Context, policyID string, threshold time.Duration, now time.Time) (*PolicyRolloutResponse, error) {
+	span, ctx := apm.StartSpan(ctx, "policyRollout", "search")
+	defer span.End()
+
+	latest, err := pt.latestPolicy(ctx, policyID)
+	if err != nil {
+		return nil, err
+	}
+	revisions, updatedAt, err := pt.rollout.revisions(ctx, policyID)
+	if err != nil {
+		return nil, err
+	}
+	resp := rolloutResponse(latest, revisions, updatedAt)
+	if resp.Stuck = stuckAgents(latest, revisions, threshold, now); resp.Stuck == 0 {
+		return resp, nil
+	}
+	agents, err := dl.FindAgentsBelowRevision(ctx, pt.bulker, policyID, latest.RevisionIdx, maxRolloutStuckAgents)
+	if err != nil {
+		return nil, err
+	}
+	for _, agent := range agents {
+		resp.StuckAgents = append(resp.StuckAgents, rolloutAgent(agent))
+	}
+	return resp, nil
+}
+
+// latestPolicy returns the latest revision of the policy, from the policy monitor if it has loaded the policy.
+func (pt *PolicyT) latestPolicy(ctx context.Context, policyID string) (model.Policy, error) {
+	if pp, ok := pt.pm.Policy(policyID); ok {
+		return pp.Policy, nil
+	}
+	return dl.FindPolicyRevision(ctx, pt.bulker, policyID, 0)
+}
+
+// rolloutResponse returns the rollout of the latest revision of the policy from the number of agents on each revision,
+// without the stuck agents.
+func rolloutResponse(latest model.Policy, revisions map[int64]int64, updatedAt time.Time) *PolicyRolloutResponse {
+	resp := &PolicyRolloutResponse{
+		PolicyId:    latest.PolicyID,
+		RevisionIdx: latest.RevisionIdx,
+		Revisions:   make([]PolicyRevisionAgents, 0, len(revisions)),
+		StuckAgents: []PolicyRolloutAgent{},
+		UpdatedAt:   updatedAt,
+	}
+	if t, err := ftime.Parse(latest.Timestamp); err == nil {
+		resp.RevisionCreatedAt = &t
+	}
+	for revisionIdx, agents := range revisions {
+		resp.Revisions = append(resp.Revisions, PolicyRevisionAgents{RevisionIdx: revisionIdx, Agents: int(agents)})
+		resp.Total += int(agents)
+	}
+	slices.SortFunc(resp.Revisions, func(a, b PolicyRevisionAgents) int {
+		return cmp.Compare(b.RevisionIdx, a.RevisionIdx)
+	})
+	return resp
+}
+
+// stuckAgents returns the number of agents on a revision older than the latest one, once the latest revision was
+// created more than threshold before now. No agent is stuck if the creation time of the revision is unknown.
+func stuckAgents(latest model.Policy, revisions map[int64]int64, threshold time.Duration, now time.Time) int {
+	createdAt, err := ftime.Parse(latest.Timestamp)
+	if err != nil || now.Sub(createdAt) <= threshold {
+		return 0
+	}
+	stuck := 0
+	for revisionIdx, agents := range revisions {
+		if revisionIdx < latest.RevisionIdx {
+			stuck += int(agents)
+		}
+	}
+	return stuck
+}
+
+func rolloutAgent(agent model.Agent) PolicyRolloutAgent {
+	ra := PolicyRolloutAgent{
+		AgentId:     agent.Id,
+		RevisionIdx: agent.PolicyRevisionIdx,
+	}
+	if t, err := ftime.Parse(agent.LastCheckin); err == nil {
+		ra.LastCheckin = &t
+	}
+	return ra
+}
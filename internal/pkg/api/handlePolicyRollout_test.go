@@ -0,0 +1,175 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func Test_stuckAgents(t *testing.T) {
+	createdAt := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	latest := model.Policy{PolicyID: "policy-1", RevisionIdx: 8, Timestamp: createdAt.Format(time.RFC3339Nano)}
+	revisions := map[int64]int64{8: 6, 7: 3, 5: 1}
+
+	tests := []struct {
+		name   string
+		latest model.Policy
+		now    time.Time
+		stuck  int
+	}{{
+		name:   "within the threshold",
+		latest: latest,
+		now:    createdAt.Add(15 * time.Minute),
+		stuck:  0,
+	}, {
+		name:   "past the threshold",
+		latest: latest,
+		now:    createdAt.Add(16 * time.Minute),
+		stuck:  4,
+	}, {
+		name:   "unknown creation time",
+		latest: model.Policy{PolicyID: "policy-1", RevisionIdx: 8},
+		now:    createdAt.Add(time.Hour),
+		stuck:  0,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.stuck, stuckAgents(tc.latest, revisions, 15*time.Minute, tc.now))
+		})
+	}
+}
+
+func Test_rolloutResponse(t *testing.T) {
+	createdAt := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	updatedAt := createdAt.Add(time.Hour)
+	latest := model.Policy{PolicyID: "policy-1", RevisionIdx: 8, Timestamp: createdAt.Format(time.RFC3339Nano)}
+
+	resp := rolloutResponse(latest, map[int64]int64{5: 1, 8: 6, 7: 3}, updatedAt)
+	require.Equal(t, &PolicyRolloutResponse{
+		PolicyId:          "policy-1",
+		RevisionCreatedAt: &createdAt,
+		RevisionIdx:       8,
+		Revisions:         []PolicyRevisionAgents{{RevisionIdx: 8, Agents: 6}, {RevisionIdx: 7, Agents: 3}, {RevisionIdx: 5, Agents: 1}},
+		StuckAgents:       []PolicyRolloutAgent{},
+		Total:             10,
+		UpdatedAt:         updatedAt,
+	}, resp)
+
+	// a policy without agents
+	resp = rolloutResponse(latest, nil, updatedAt)
+	require.Empty(t, resp.Revisions)
+	require.Zero(t, resp.Total)
+}
+
+func Test_PolicyT_handleRollout(t *testing.T) {
+	latest := testSizedPolicy(t, "policy-1", 8)
+	latest.Policy.Timestamp = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano)
+
+	var agg es.Aggregation
+	require.NoError(t, json.Unmarshal([]byte(`{"buckets":[
+		{"key":"policy-1","doc_count":10,"policy_revision_idx":{"buckets":[{"key":8,"doc_count":7},{"key":7,"doc_count":3}]}}
+	]}`), &agg))
+
+	newPolicyT := func(t *testing.T) (*PolicyT, *ftesting.MockBulk) {
+		t.Helper()
+		bulker := ftesting.NewMockBulk()
+		mockServiceToken(t, bulker)
+		bulker.On("Search", mock.Anything, dl.FleetAgents, dl.QueryPolicyRevisions, mock.Anything).Return(&es.ResultT{
+			Aggregations: map[string]es.Aggregation{dl.FieldPolicyID: agg},
+		}, nil)
+		return NewPolicyT(&config.Server{}, bulker, nil, &latestMonitor{pp: latest}), bulker
+	}
+	rollout := func(t *testing.T, pt *PolicyT, id string, params GetPolicyRolloutParams) (*httptest.ResponseRecorder, error) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/fleet/policies/"+id+"/rollout", nil)
+		req.Header.Set("Authorization", testServiceToken)
+		w := httptest.NewRecorder()
+		return w, pt.handleRollout(testlog.SetLogger(t), w, req, id, params)
+	}
+
+	t.Run("stuck agents", func(t *testing.T) {
+		pt, bulker := newPolicyT(t)
+		bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{
+			HitsT: es.HitsT{Hits: []es.HitT{
+				{ID: "agent-1", Source: []byte(`{"active":true,"policy_id":"policy-1","policy_revision_idx":7,"last_checkin":"2025-04-01T12:00:00Z"}`)},
+				{ID: "agent-2", Source: []byte(`{"active":true,"policy_id":"policy-1","policy_revision_idx":7}`)},
+			}},
+		}, nil)
+		w, err := rollout(t, pt, "policy-1", GetPolicyRolloutParams{})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp PolicyRolloutResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Equal(t, int64(8), resp.RevisionIdx)
+		require.Equal(t, 10, resp.Total)
+		require.Equal(t, 3, resp.Stuck)
+		require.Equal(t, []PolicyRevisionAgents{{RevisionIdx: 8, Agents: 7}, {RevisionIdx: 7, Agents: 3}}, resp.Revisions)
+		require.Len(t, resp.StuckAgents, 2)
+		require.Equal(t, "agent-1", resp.StuckAgents[0].AgentId)
+		require.NotNil(t, resp.StuckAgents[0].LastCheckin)
+		require.Nil(t, resp.StuckAgents[1].LastCheckin)
+	})
+
+	t.Run("within the stuck threshold", func(t *testing.T) {
+		pt, bulker := newPolicyT(t)
+		threshold := "2h"
+		w, err := rollout(t, pt, "policy-1", GetPolicyRolloutParams{StuckThreshold: &threshold})
+		require.NoError(t, err)
+
+		var resp PolicyRolloutResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Zero(t, resp.Stuck)
+		require.Empty(t, resp.StuckAgents)
+		// only the aggregation is searched
+		bulker.AssertNumberOfCalls(t, "Search", 1)
+	})
+
+	t.Run("invalid stuck threshold", func(t *testing.T) {
+		pt, _ := newPolicyT(t)
+		threshold := "soon"
+		_, err := rollout(t, pt, "policy-1", GetPolicyRolloutParams{StuckThreshold: &threshold})
+		require.Equal(t, http.StatusBadRequest, NewHTTPErrResp(err).StatusCode)
+	})
+
+	t.Run("missing policy", func(t *testing.T) {
+		pt, bulker := newPolicyT(t)
+		bulker.On("Search", mock.Anything, dl.FleetPolicies, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+		_, err := rollout(t, pt, "policy-2", GetPolicyRolloutParams{})
+		require.ErrorIs(t, err, dl.ErrNotFound)
+		require.Equal(t, http.StatusNotFound, NewHTTPErrResp(err).StatusCode)
+	})
+
+	t.Run("user api key", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{UserName: "elastic", Enabled: true}, nil)
+		mockAdminPrivileges(t, bulker, true)
+		pt := NewPolicyT(&config.Server{}, bulker, nil, &latestMonitor{pp: latest})
+		req := httptest.NewRequest(http.MethodGet, "/api/fleet/policies/policy-1/rollout", nil)
+		req.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+		err := pt.handleRollout(testlog.SetLogger(t), httptest.NewRecorder(), req, "policy-1", GetPolicyRolloutParams{})
+		require.ErrorIs(t, err, ErrServiceTokenAuth)
+		require.Equal(t, http.StatusForbidden, NewHTTPErrResp(err).StatusCode)
+		bulker.AssertNotCalled(t, "APIKeyAuth", mock.Anything, mock.Anything)
+		bulker.AssertNotCalled(t, "Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
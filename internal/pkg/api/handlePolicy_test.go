@@ -0,0 +1,217 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+// latestMonitor is a policy.Monitor that has loaded the latest revision of a policy.
+type latestMonitor struct {
+	policy.Monitor
+	pp *policy.ParsedPolicy
+}
+
+func (m *latestMonitor) Policy(policyID string) (*policy.ParsedPolicy, bool) {
+	if m.pp == nil || m.pp.Policy.PolicyID != policyID {
+		return nil, false
+	}
+	return m.pp, true
+}
+
+func testSizedPolicy(t *testing.T, policyID string, revisionIdx int64) *policy.ParsedPolicy {
+	t.Helper()
+	data := &model.PolicyData{
+		ID:       policyID,
+		Revision: revisionIdx,
+		Inputs:   []map[string]interface{}{{"id": "input-1", "type": "logfile"}},
+		Outputs: map[string]map[string]interface{}{
+			"default": {"type": "logstash", "hosts": []interface{}{"localhost:5044"}},
+		},
+		SecretReferences: []model.SecretReferencesItems{},
+	}
+	pp, err := policy.NewParsedPolicy(context.Background(), ftesting.NewMockBulk(), model.Policy{PolicyID: policyID, RevisionIdx: revisionIdx, CoordinatorIdx: 1, Data: data})
+	require.NoError(t, err)
+	return pp
+}
+
+func Test_processPolicy_size(t *testing.T) {
+	pp := testSizedPolicy(t, "sized-policy", 2)
+	body, err := marshalPolicyBody(pp)
+	require.NoError(t, err)
+	size := len(body)
+	agent, err := json.Marshal(model.Agent{ESDocument: model.ESDocument{Id: "agent-id"}, PolicyID: "sized-policy"})
+	require.NoError(t, err)
+	bulker := &agentBulk{res: &es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "agent-id", Source: agent}}}}}
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	tests := []struct {
+		name     string
+		delivery policyDelivery
+		inline   bool
+		err      error
+	}{{
+		name:     "below the warning size",
+		delivery: policyDelivery{size: config.PolicySize{Warn: size, Max: size}},
+		inline:   true,
+	}, {
+		name:     "above the warning size",
+		delivery: policyDelivery{size: config.PolicySize{Warn: size - 1, Max: size}},
+		inline:   true,
+	}, {
+		name:     "no max size",
+		delivery: policyDelivery{size: config.PolicySize{Warn: size - 1}},
+		inline:   true,
+	}, {
+		name:     "above the max size",
+		delivery: policyDelivery{size: config.PolicySize{Max: size - 1}, fetch: true},
+	}, {
+		name:     "above the max size for an agent that can't fetch",
+		delivery: policyDelivery{size: config.PolicySize{Max: size - 1}},
+		err:      ErrPolicyTooLarge,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := processPolicy(ctx, bulker, "agent-id", pp, tc.delivery)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			change := policyChange(t, resp)
+			require.Equal(t, "sized-policy", fromPtr(change.Policy.Id))
+			require.Equal(t, 2, fromPtr(change.Policy.Revision))
+			require.NotEmpty(t, fromPtr(change.Policy.Outputs), "the outputs of the agent are always delivered inline")
+			if tc.inline {
+				require.Nil(t, change.Fetch)
+				require.Len(t, fromPtr(change.Policy.Inputs), 1)
+				return
+			}
+			require.Equal(t, &PolicyFetch{Path: "/api/fleet/policies/sized-policy/2", Size: size}, change.Fetch)
+			require.Nil(t, change.Policy.Inputs)
+		})
+	}
+
+	cntPolicySizes.mut.Lock()
+	defer cntPolicySizes.mut.Unlock()
+	require.Equal(t, policySize{revision: 2, size: size}, cntPolicySizes.policies["sized-policy"])
+}
+
+func Test_canFetchPolicy(t *testing.T) {
+	for ver, fetch := range map[string]bool{
+		"9.1.0":          true,
+		"9.1.0-SNAPSHOT": true,
+		"9.2.1":          true,
+		"10.0.0":         true,
+		"9.0.4":          false,
+		"8.18.2":         false,
+		"":               false,
+	} {
+		require.Equal(t, fetch, canFetchPolicy(ver), ver)
+	}
+}
+
+func Test_PolicyT_handleGet(t *testing.T) {
+	latest := testSizedPolicy(t, "policy-1", 2)
+	latestBody, err := marshalPolicyBody(latest)
+	require.NoError(t, err)
+	older := testSizedPolicy(t, "policy-1", 1)
+	olderSource, err := json.Marshal(older.Policy)
+	require.NoError(t, err)
+
+	newPolicyT := func(t *testing.T, agentPolicyID string) (*PolicyT, *ftesting.MockBulk) {
+		t.Helper()
+		bulker := ftesting.NewMockBulk()
+		bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{Enabled: true}, nil)
+		bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{
+			HitsT: es.HitsT{Hits: []es.HitT{{ID: "agent-1", Source: []byte(`{"access_api_key_id":"id","active":true,"policy_id":"` + agentPolicyID + `","agent":{"id":"agent-1"}}`)}}},
+		}, nil)
+		c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000, APIKeyTTL: time.Minute})
+		require.NoError(t, err)
+		return NewPolicyT(&config.Server{}, bulker, c, &latestMonitor{pp: latest}), bulker
+	}
+	get := func(t *testing.T, pt *PolicyT, id string, revision int64, ifNoneMatch string) (*httptest.ResponseRecorder, error) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, policyPath(id, revision), nil)
+		req.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		return w, pt.handleGet(testlog.SetLogger(t), w, req, id, revision)
+	}
+
+	t.Run("latest revision", func(t *testing.T) {
+		pt, bulker := newPolicyT(t, "policy-1")
+		w, err := get(t, pt, "policy-1", 2, "")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, latestBody, w.Body.Bytes())
+		etag := w.Header().Get("ETag")
+		require.Equal(t, revisionETag(latest, latestBody), etag)
+		bulker.AssertNotCalled(t, "Search", mock.Anything, dl.FleetPolicies, mock.Anything, mock.Anything)
+
+		w, err = get(t, pt, "policy-1", 2, etag)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotModified, w.Code)
+		require.Empty(t, w.Body.Bytes())
+	})
+
+	t.Run("older revision", func(t *testing.T) {
+		pt, bulker := newPolicyT(t, "policy-1")
+		bulker.On("Search", mock.Anything, dl.FleetPolicies, mock.Anything, mock.Anything).Return(&es.ResultT{
+			HitsT: es.HitsT{Hits: []es.HitT{{ID: "policy-1-1", Source: olderSource}}},
+		}, nil)
+		w, err := get(t, pt, "policy-1", 1, "")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, w.Code)
+		var data PolicyData
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+		require.Equal(t, 1, fromPtr(data.Revision))
+		require.Nil(t, data.Outputs)
+	})
+
+	t.Run("missing revision", func(t *testing.T) {
+		pt, bulker := newPolicyT(t, "policy-1")
+		bulker.On("Search", mock.Anything, dl.FleetPolicies, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+		_, err := get(t, pt, "policy-1", 3, "")
+		require.ErrorIs(t, err, dl.ErrNotFound)
+		require.Equal(t, http.StatusNotFound, NewHTTPErrResp(err).StatusCode)
+	})
+
+	t.Run("invalid revision", func(t *testing.T) {
+		pt, _ := newPolicyT(t, "policy-1")
+		_, err := get(t, pt, "policy-1", 0, "")
+		require.Equal(t, http.StatusBadRequest, NewHTTPErrResp(err).StatusCode)
+	})
+
+	t.Run("agent assigned to another policy", func(t *testing.T) {
+		pt, bulker := newPolicyT(t, "policy-2")
+		_, err := get(t, pt, "policy-1", 2, "")
+		require.ErrorIs(t, err, ErrPolicyForbidden)
+		require.Equal(t, http.StatusForbidden, NewHTTPErrResp(err).StatusCode)
+		bulker.AssertNotCalled(t, "Search", mock.Anything, dl.FleetPolicies, mock.Anything, mock.Anything)
+	})
+}
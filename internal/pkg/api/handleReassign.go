@@ -0,0 +1,309 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/miolini/datacounter"
+	"github.com/rs/zerolog"
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/operation"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+)
+
+const (
+	kReassignMod = "reassign"
+
+	// reassignPageSize is the max number of agents updated by a single bulk request.
+	reassignPageSize = 1000
+
+	reassignOperation = "reassign"
+)
+
+var ErrReassignTargets = errors.New("invalid reassign targets")
+
+type ReassignT struct {
+	cfg      *config.Server
+	reloaded reloadedCfg
+	bulk     bulk.Bulk
+	pm       policy.Monitor
+	ops      *operation.Tracker
+}
+
+// Reload applies the reloaded server configuration to the requests handled from now on.
+func (rt *ReassignT) Reload(cfg *config.Server) {
+	rt.reloaded.p.Store(cfg)
+}
+
+// serverCfg returns the server configuration of the requests, as last reloaded.
+func (rt *ReassignT) serverCfg() *config.Server {
+	return rt.reloaded.get(rt.cfg)
+}
+
+func NewReassignT(cfg *config.Server, bulker bulk.Bulk, pm policy.Monitor, ops *operation.Tracker) *ReassignT {
+	rt := &ReassignT{
+		cfg:  cfg,
+		bulk: bulker,
+		pm:   pm,
+		ops:  ops,
+	}
+	if ops != nil {
+		ops.Resumable(reassignOperation, rt.resume)
+	}
+	return rt
+}
+
+func (rt *ReassignT) handleReassign(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request) error {
+	info, err := authServiceToken(r, rt.bulk)
+	if err != nil {
+		return err
+	}
+	zlog = zlog.With().Str("userName", info.UserName).Logger()
+	r = r.WithContext(zlog.WithContext(r.Context()))
+
+	req, err := rt.validateRequest(zlog, w, r)
+	if err != nil {
+		return err
+	}
+
+	auditTargets(r.Context(), nil, []string{req.PolicyId})
+	if req.SourcePolicyId != nil && *req.SourcePolicyId != "" {
+		auditDetail(r.Context(), "source_policy_id", *req.SourcePolicyId)
+	}
+	if req.Tag != nil && *req.Tag != "" {
+		auditDetail(r.Context(), "tag", *req.Tag)
+	}
+
+	// The policy is loaded before the agents are updated, so their next checkin does not wait for the monitor to find it.
+	if err := rt.loadPolicy(r.Context(), req.PolicyId); err != nil {
+		return err
+	}
+
+	resp, err := rt.reassign(r.Context(), zlog, req)
+	if err != nil {
+		return err
+	}
+
+	span, _ := apm.StartSpan(r.Context(), "response", "write")
+	defer span.End()
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("reassignAgents marshal: %w", err)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(data)
+	cntReassignAgents.bodyOut.Add(uint64(len(data)))
+	return err
+}
+
+func (rt *ReassignT) validateRequest(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request) (*ReassignAgentsRequest, error) {
+	span, _ := apm.StartSpan(r.Context(), "validateRequest", "validate")
+	defer span.End()
+
+	body := r.Body
+	if rt.serverCfg().Limits.ReassignAgentsLimit.MaxBody > 0 {
+		body = http.MaxBytesReader(w, body, rt.serverCfg().Limits.ReassignAgentsLimit.MaxBody)
+	}
+	readCounter := datacounter.NewReaderCounter(body)
+
+	var req ReassignAgentsRequest
+	if err := decodeRequest(r.Context(), readCounter, &req, "reassign agents"); err != nil {
+		return nil, err
+	}
+	cntReassignAgents.bodyIn.Add(readCounter.Count())
+
+	if req.PolicyId == "" {
+		return nil, &BadRequestErr{msg: "reassign agents request missing policy_id"}
+	}
+	hasAgents := req.Agents != nil && len(*req.Agents) > 0
+	hasSource := req.SourcePolicyId != nil && *req.SourcePolicyId != ""
+	if hasAgents == hasSource {
+		return nil, fmt.Errorf("%w: exactly one of agents or source_policy_id must be specified", ErrReassignTargets)
+	}
+	if req.Tag != nil && *req.Tag != "" && !hasSource {
+		return nil, fmt.Errorf("%w: tag can only be specified with source_policy_id", ErrReassignTargets)
+	}
+	if hasSource && *req.SourcePolicyId == req.PolicyId {
+		return nil, fmt.Errorf("%w: source_policy_id is the same as policy_id", ErrReassignTargets)
+	}
+
+	zlog.Trace().Str(logger.PolicyID, req.PolicyId).Msg("Reassign agents request")
+	return &req, nil
+}
+
+// loadPolicy adds the policy to the policy monitor, ErrPolicyNotFound is returned if the policy does not exist.
+func (rt *ReassignT) loadPolicy(ctx context.Context, policyID string) error {
+	span, ctx := apm.StartSpan(ctx, "loadPolicy", "search")
+	defer span.End()
+
+	err := rt.pm.Load(ctx, policyID)
+	if errors.Is(err, dl.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrPolicyNotFound, policyID)
+	}
+	return err
+}
+
+// reassign assigns the agents of the request to its policy, reassignPageSize agents at a time, as an operation.
+// The agents that fail to update are counted, and are not retried. A request with the ID of an interrupted operation
+// resumes it from its last page, the ID of a completed operation returns its outcome without updating the agents again.
+func (rt *ReassignT) reassign(ctx context.Context, zlog zerolog.Logger, req *ReassignAgentsRequest) (*ReassignAgentsAPIResponse, error) {
+	span, ctx := apm.StartSpan(ctx, "reassign", "update")
+	defer span.End()
+
+	op := operation.Operation{Type: reassignOperation, Filter: reassignFilter(req)}
+	if req.OperationId != nil && *req.OperationId != "" {
+		op.ID = *req.OperationId
+		stored, err := rt.ops.Get(ctx, op.ID)
+		switch {
+		case errors.Is(err, operation.ErrNotFound):
+		case err != nil:
+			return nil, err
+		case stored.Type != op.Type || !maps.Equal(stored.Filter, op.Filter):
+			return nil, fmt.Errorf("%w: operation %s has other parameters", operation.ErrConflict, op.ID)
+		case stored.Status == operation.StatusCompleted:
+			zlog.Debug().Str("operation.id", op.ID).Msg("Reassign operation already completed")
+			return reassignResponse(req, stored), nil
+		}
+	} else {
+		u, err := uuid.NewV4()
+		if err != nil {
+			return nil, fmt.Errorf("reassign uuid: %w", err)
+		}
+		op.ID = u.String()
+	}
+	zlog = zlog.With().Str("operation.id", op.ID).Logger()
+
+	cur, err := rt.ops.Run(ctx, op, rt.reassignStep(zlog, req))
+	if err != nil {
+		return nil, err
+	}
+	return reassignResponse(req, cur), nil
+}
+
+// resume returns the step of a reassign operation taken over from an offline instance. The agents listed by a request
+// are not stored with the operation, only the operations reassigning the agents of a source policy are resumed.
+func (rt *ReassignT) resume(ctx context.Context, op *operation.Operation) (operation.StepFunc, error) {
+	sourcePolicyID := op.Filter["source_policy_id"]
+	if sourcePolicyID == "" {
+		return nil, fmt.Errorf("%w: the agents of reassign operation %s are only known to its request", operation.ErrNotResumable, op.ID)
+	}
+	req := &ReassignAgentsRequest{PolicyId: op.Filter["policy_id"], SourcePolicyId: &sourcePolicyID}
+	if tag := op.Filter["tag"]; tag != "" {
+		req.Tag = &tag
+	}
+	if err := rt.loadPolicy(ctx, req.PolicyId); err != nil {
+		return nil, err
+	}
+	zlog := zerolog.Ctx(ctx).With().Str("operation.id", op.ID).Logger()
+	return rt.reassignStep(zlog, req), nil
+}
+
+// reassignStep returns the step assigning the agents of the request to its policy, reassignPageSize agents at a time.
+func (rt *ReassignT) reassignStep(zlog zerolog.Logger, req *ReassignAgentsRequest) operation.StepFunc {
+	update := func(ctx context.Context, cur *operation.Operation, agents []string) (operation.Page, error) {
+		auditTargets(ctx, agents, nil)
+		failed, err := dl.ReassignAgents(ctx, rt.bulk, agents, req.PolicyId)
+		if err != nil {
+			return operation.Page{}, err
+		}
+		page := operation.Page{Succeeded: len(agents) - len(failed), Failed: len(failed)}
+		zlog.Info().
+			Int("total", cur.Total+len(agents)).
+			Int("reassigned", cur.Succeeded+page.Succeeded).
+			Int("failed", cur.Failed+page.Failed).
+			Msg("Reassigned agents")
+		return page, nil
+	}
+
+	var step operation.StepFunc
+	if req.Agents != nil && len(*req.Agents) > 0 {
+		// the cursor is the index of the first agent of the next page
+		agents := *req.Agents
+		step = func(ctx context.Context, cur *operation.Operation) (operation.Page, error) {
+			start, _ := strconv.Atoi(cur.Cursor)
+			end := min(start+reassignPageSize, len(agents))
+			page, err := update(ctx, cur, agents[start:end])
+			page.Cursor, page.Done = strconv.Itoa(end), end == len(agents)
+			return page, err
+		}
+	} else {
+		// The reassigned agents are no longer enrolled in the source policy, each search returns the next page.
+		// The agents that failed to update are still returned, so they are skipped; the ones that failed before an
+		// interruption are updated again when the operation is resumed.
+		seen := make(map[string]bool)
+		step = func(ctx context.Context, cur *operation.Operation) (operation.Page, error) {
+			agents, err := rt.findSourceAgents(ctx, req, reassignPageSize+cur.Failed)
+			if err != nil {
+				return operation.Page{}, err
+			}
+			agents = slices.DeleteFunc(agents, func(agentID string) bool { return seen[agentID] })
+			if len(agents) == 0 {
+				return operation.Page{Cursor: cur.Cursor, Done: true}, nil
+			}
+			page, err := update(ctx, cur, agents)
+			if err != nil {
+				return page, err
+			}
+			for _, agentID := range agents {
+				seen[agentID] = true
+			}
+			page.Cursor = agents[len(agents)-1]
+			return page, nil
+		}
+	}
+	return step
+}
+
+// reassignFilter returns the parameters of the request selecting the agents, the list of agents is kept as its
+// hash and its length.
+func reassignFilter(req *ReassignAgentsRequest) map[string]string {
+	filter := map[string]string{"policy_id": req.PolicyId}
+	if req.Agents != nil && len(*req.Agents) > 0 {
+		sum := sha256.Sum256([]byte(strings.Join(*req.Agents, "\n")))
+		filter["agents_sha256"] = hex.EncodeToString(sum[:])
+		filter["agents"] = strconv.Itoa(len(*req.Agents))
+	}
+	if req.SourcePolicyId != nil && *req.SourcePolicyId != "" {
+		filter["source_policy_id"] = *req.SourcePolicyId
+	}
+	if req.Tag != nil && *req.Tag != "" {
+		filter["tag"] = *req.Tag
+	}
+	return filter
+}
+
+func reassignResponse(req *ReassignAgentsRequest, op *operation.Operation) *ReassignAgentsAPIResponse {
+	return &ReassignAgentsAPIResponse{
+		OperationId: op.ID,
+		PolicyId:    req.PolicyId,
+		Total:       op.Total,
+		Reassigned:  op.Succeeded,
+		Failed:      op.Failed,
+	}
+}
+
+func (rt *ReassignT) findSourceAgents(ctx context.Context, req *ReassignAgentsRequest, size int) ([]string, error) {
+	if req.Tag != nil && *req.Tag != "" {
+		return dl.FindActiveAgentIDsByPolicyIDAndTag(ctx, rt.bulk, *req.SourcePolicyId, *req.Tag, size)
+	}
+	return dl.FindActiveAgentIDsByPolicyID(ctx, rt.bulk, *req.SourcePolicyId, size)
+}
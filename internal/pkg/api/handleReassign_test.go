@@ -0,0 +1,259 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/operation"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+// loadMonitor is a policy.Monitor that loads the policies that exist.
+type loadMonitor struct {
+	policy.Monitor
+	policies map[string]bool
+	loaded   []string
+}
+
+func (m *loadMonitor) Load(_ context.Context, policyID string) error {
+	if !m.policies[policyID] {
+		return dl.ErrNotFound
+	}
+	m.loaded = append(m.loaded, policyID)
+	return nil
+}
+
+// activeAgents returns the search hits of the active agents read before they are reassigned.
+func activeAgents(ids ...string) *es.ResultT {
+	hits := make([]es.HitT, len(ids))
+	for i, id := range ids {
+		hits[i] = es.HitT{ID: id, SeqNo: int64(i + 1), PrimaryTerm: 1, Source: []byte(`{"active":true}`)}
+	}
+	return &es.ResultT{HitsT: es.HitsT{Hits: hits}}
+}
+
+// isAgentsByIDsQuery matches the search of the agents read before they are reassigned.
+var isAgentsByIDsQuery = mock.MatchedBy(func(body []byte) bool {
+	return strings.Contains(string(body), `"seq_no_primary_term":true`)
+})
+
+// mockReassign records the agents updated by the MUpdate calls of bulker, the update of the second agent fails.
+func mockReassign(t *testing.T, bulker *ftesting.MockBulk, agents ...string) *[][]string {
+	var updates [][]string
+	bulker.On("Search", mock.Anything, dl.FleetAgents, isAgentsByIDsQuery, mock.Anything).Return(activeAgents(agents...), nil)
+	bulker.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		ops := args.Get(1).([]bulk.MultiOp)
+		agents := make([]string, len(ops))
+		for i, op := range ops {
+			require.Equal(t, dl.FleetAgents, op.Index)
+			var doc map[string]map[string]interface{}
+			require.NoError(t, json.Unmarshal(op.Body, &doc))
+			require.Equal(t, "target", doc["doc"][dl.FieldPolicyID])
+			require.EqualValues(t, 0, doc["doc"][dl.FieldPolicyRevisionIdx])
+			require.EqualValues(t, 0, doc["doc"][dl.FieldPolicyCoordinatorIdx])
+			// the update is conditioned on the agent that was read
+			require.Equal(t, int64(i+1), op.IfSeqNo)
+			require.Equal(t, int64(1), op.IfPrimaryTerm)
+			agents[i] = op.ID
+		}
+		updates = append(updates, agents)
+	}).Return([]bulk.BulkIndexerResponseItem{{Status: http.StatusOK}, {Status: http.StatusInternalServerError}, {Status: http.StatusOK}}, nil)
+	return &updates
+}
+
+func reassignAgents(t *testing.T, bulker *ftesting.MockBulk, pm policy.Monitor, ops *operation.Tracker, body string) (int, ReassignAgentsAPIResponse) {
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	authorization := mockServiceToken(t, bulker)
+	hr := newRouter(cfg, &apiServer{rt: NewReassignT(cfg, bulker, pm, ops)}, nil, nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/reassign", strings.NewReader(body))
+	r = r.WithContext(testlog.SetLogger(t).WithContext(context.Background()))
+	r.Header.Set("Authorization", authorization)
+	hr.ServeHTTP(w, r)
+	var resp ReassignAgentsAPIResponse
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	}
+	return w.Code, resp
+}
+
+func Test_Reassign_agents(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	updates := mockReassign(t, bulker, "agent-1", "agent-2", "agent-3")
+	pm := &loadMonitor{policies: map[string]bool{"target": true}}
+
+	code, resp := reassignAgents(t, bulker, pm, operation.NewTracker(operation.NewMemoryStore()), `{"policy_id":"target","agents":["agent-1","agent-2","agent-3"]}`)
+	require.Equal(t, http.StatusOK, code)
+	require.NotEmpty(t, resp.OperationId)
+	resp.OperationId = ""
+	require.Equal(t, ReassignAgentsAPIResponse{PolicyId: "target", Total: 3, Reassigned: 2, Failed: 1}, resp)
+	require.Equal(t, [][]string{{"agent-1", "agent-2", "agent-3"}}, *updates)
+	require.Equal(t, []string{"target"}, pm.loaded)
+	bulker.AssertNumberOfCalls(t, "Search", 1)
+}
+
+func Test_Reassign_sourcePolicy(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	updates := mockReassign(t, bulker, "agent-0", "agent-1", "agent-2")
+	// the first search returns the agents of the source policy, the second one only the agent that failed to update
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(agentHits(3), nil).Once()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "agent-1"}}}}, nil).Once()
+	pm := &loadMonitor{policies: map[string]bool{"target": true}}
+
+	code, resp := reassignAgents(t, bulker, pm, operation.NewTracker(operation.NewMemoryStore()), `{"policy_id":"target","source_policy_id":"source","tag":"production"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.NotEmpty(t, resp.OperationId)
+	resp.OperationId = ""
+	require.Equal(t, ReassignAgentsAPIResponse{PolicyId: "target", Total: 3, Reassigned: 2, Failed: 1}, resp)
+	require.Equal(t, [][]string{{"agent-0", "agent-1", "agent-2"}}, *updates)
+
+	// the search is filtered by the source policy and the tag
+	body := string(bulker.Calls[len(bulker.Calls)-1].Arguments.Get(2).([]byte))
+	require.Contains(t, body, `"source"`)
+	require.Contains(t, body, `"production"`)
+	bulker.AssertExpectations(t)
+}
+
+func Test_Reassign_operation(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, isAgentsByIDsQuery, mock.Anything).Return(activeAgents("agent-2", "agent-3"), nil).Once()
+	bulker.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		ops := args.Get(1).([]bulk.MultiOp)
+		require.Len(t, ops, 2)
+		require.Equal(t, "agent-2", ops[0].ID)
+		require.Equal(t, "agent-3", ops[1].ID)
+	}).Return([]bulk.BulkIndexerResponseItem{{Status: http.StatusOK}, {Status: http.StatusOK}}, nil).Once()
+	pm := &loadMonitor{policies: map[string]bool{"target": true}}
+
+	// the operation was interrupted after its first agent
+	store := operation.NewMemoryStore()
+	agents := []string{"agent-1", "agent-2", "agent-3"}
+	require.NoError(t, store.Store(context.Background(), &operation.Operation{
+		ID:        "op-1",
+		Type:      reassignOperation,
+		Filter:    reassignFilter(&ReassignAgentsRequest{PolicyId: "target", Agents: &agents}),
+		Cursor:    "1",
+		Total:     1,
+		Succeeded: 1,
+		Status:    operation.StatusRunning,
+	}))
+	ops := operation.NewTracker(store)
+	body := `{"policy_id":"target","agents":["agent-1","agent-2","agent-3"],"operation_id":"op-1"}`
+
+	code, resp := reassignAgents(t, bulker, pm, ops, body)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, ReassignAgentsAPIResponse{OperationId: "op-1", PolicyId: "target", Total: 3, Reassigned: 3}, resp)
+
+	// the completed operation is not run again
+	code, resp = reassignAgents(t, bulker, pm, ops, body)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, ReassignAgentsAPIResponse{OperationId: "op-1", PolicyId: "target", Total: 3, Reassigned: 3}, resp)
+
+	// the operation ID can't be reused for other agents
+	code, _ = reassignAgents(t, bulker, pm, ops, `{"policy_id":"target","agents":["agent-4"],"operation_id":"op-1"}`)
+	require.Equal(t, http.StatusConflict, code)
+	bulker.AssertExpectations(t)
+}
+
+func Test_Reassign_takeOver(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	updates := mockReassign(t, bulker, "agent-0", "agent-1", "agent-2")
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(agentHits(3), nil).Once()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "agent-1"}}}}, nil).Once()
+	pm := &loadMonitor{policies: map[string]bool{"target": true}}
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	// server-2 went offline while reassigning the agents of a source policy, and the listed agents of another request
+	store := operation.NewMemoryStore()
+	tag := "production"
+	require.NoError(t, store.Store(ctx, &operation.Operation{
+		ID:     "op-source",
+		Type:   reassignOperation,
+		Filter: reassignFilter(&ReassignAgentsRequest{PolicyId: "target", SourcePolicyId: ptr("source"), Tag: &tag}),
+		Status: operation.StatusRunning,
+		Server: "server-2",
+	}))
+	agents := []string{"agent-4"}
+	require.NoError(t, store.Store(ctx, &operation.Operation{
+		ID:     "op-agents",
+		Type:   reassignOperation,
+		Filter: reassignFilter(&ReassignAgentsRequest{PolicyId: "target", Agents: &agents}),
+		Status: operation.StatusRunning,
+		Server: "server-2",
+	}))
+	ops := operation.NewTracker(store, operation.WithServer("server-1"))
+	NewReassignT(&config.Server{}, bulker, pm, ops)
+
+	require.NoError(t, ops.TakeOver(ctx, "server-2", []string{"op-source", "op-agents"}))
+	require.Equal(t, [][]string{{"agent-0", "agent-1", "agent-2"}}, *updates)
+	require.Equal(t, []string{"target"}, pm.loaded)
+	op, err := ops.Get(ctx, "op-source")
+	require.NoError(t, err)
+	require.Equal(t, operation.StatusCompleted, op.Status)
+	require.Equal(t, "server-1", op.Server)
+	require.Equal(t, 2, op.Succeeded)
+	require.Equal(t, 1, op.Failed)
+
+	// the agents of the request are not stored, the operation is resumed when the request is retried
+	op, err = ops.Get(ctx, "op-agents")
+	require.NoError(t, err)
+	require.Equal(t, operation.StatusRunning, op.Status)
+	require.Equal(t, "server-2", op.Server)
+	bulker.AssertExpectations(t)
+}
+
+func Test_Reassign_invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{{
+		name: "missing policy",
+		body: `{"agents":["agent-1"]}`,
+	}, {
+		name: "missing targets",
+		body: `{"policy_id":"target"}`,
+	}, {
+		name: "agents and source policy",
+		body: `{"policy_id":"target","agents":["agent-1"],"source_policy_id":"source"}`,
+	}, {
+		name: "tag without source policy",
+		body: `{"policy_id":"target","agents":["agent-1"],"tag":"production"}`,
+	}, {
+		name: "same source and target policy",
+		body: `{"policy_id":"target","source_policy_id":"target"}`,
+	}, {
+		name: "target policy does not exist",
+		body: `{"policy_id":"missing","agents":["agent-1"]}`,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bulker := ftesting.NewMockBulk()
+			pm := &loadMonitor{policies: map[string]bool{"target": true}}
+
+			code, _ := reassignAgents(t, bulker, pm, operation.NewTracker(operation.NewMemoryStore()), tc.body)
+			require.Equal(t, http.StatusBadRequest, code)
+			bulker.AssertNotCalled(t, "MUpdate", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
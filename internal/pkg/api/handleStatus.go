@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"sync"
 	"time"
 
 	"go.elastic.co/apm/v2"
@@ -20,8 +21,11 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/drain"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	"github.com/elastic/fleet-server/v7/internal/pkg/usage"
 
 	"github.com/rs/zerolog"
 )
@@ -33,12 +37,56 @@ const (
 type AuthFunc func(*http.Request) (*apikey.APIKey, error)
 
 type StatusT struct {
-	cfg    *config.Server
-	bulk   bulk.Bulk
-	cache  cache.Cache
-	sm     policy.SelfMonitor
-	bi     build.Info
-	authfn AuthFunc
+	cfg       *config.Server
+	bulk      bulk.Bulk
+	cache     cache.Cache
+	sm        policy.SelfMonitor
+	drain     *drain.State
+	usage     *usage.Collector
+	proxy     *es.ProxyHealth
+	bi        build.Info
+	authfn    AuthFunc
+	responses *statusCache
+}
+
+// statusCache holds the last responses of the status endpoint to the authenticated and unauthenticated requests for ttl.
+// It is the status_es_health cache, disabled with a zero ttl.
+type statusCache struct {
+	mx      sync.Mutex
+	ttl     time.Duration
+	entries map[bool]statusCacheEntry
+}
+
+type statusCacheEntry struct {
+	resp    StatusAPIResponse
+	state   client.UnitState
+	expires time.Time
+}
+
+func (c *statusCache) reconfigure(settings config.CacheSettings) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.ttl = settings.TTL
+	clear(c.entries)
+}
+
+func (c *statusCache) get(authed bool) (StatusAPIResponse, client.UnitState, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	entry, ok := c.entries[authed]
+	if !ok || time.Now().After(entry.expires) {
+		return StatusAPIResponse{}, 0, false
+	}
+	return entry.resp, entry.state, true
+}
+
+func (c *statusCache) set(authed bool, resp StatusAPIResponse, state client.UnitState) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	c.entries[authed] = statusCacheEntry{resp: resp, state: state, expires: time.Now().Add(c.ttl)}
 }
 
 type OptFunc func(*StatusT)
@@ -49,6 +97,28 @@ func WithSelfMonitor(sm policy.SelfMonitor) OptFunc {
 	}
 }
 
+// WithDrain reports a healthy server as degraded while d is draining, so the load balancers send the agents to other instances.
+func WithDrain(d *drain.State) OptFunc {
+	return func(st *StatusT) {
+		st.drain = d
+	}
+}
+
+// WithResourceUsage reports a healthy server as degraded while the resources used by the process sampled by c exceed
+// one of the warning thresholds.
+func WithResourceUsage(c *usage.Collector) OptFunc {
+	return func(st *StatusT) {
+		st.usage = c
+	}
+}
+
+// WithProxyHealth reports the health of the proxy in front of elasticsearch recorded in h to the authenticated requests.
+func WithProxyHealth(h *es.ProxyHealth) OptFunc {
+	return func(st *StatusT) {
+		st.proxy = h
+	}
+}
+
 func WithBuildInfo(bi build.Info) OptFunc {
 	return func(st *StatusT) {
 		st.bi = bi
@@ -57,9 +127,10 @@ func WithBuildInfo(bi build.Info) OptFunc {
 
 func NewStatusT(cfg *config.Server, bulker bulk.Bulk, cache cache.Cache, opts ...OptFunc) *StatusT {
 	st := &StatusT{
-		cfg:   cfg,
-		bulk:  bulker,
-		cache: cache,
+		cfg:       cfg,
+		bulk:      bulker,
+		cache:     cache,
+		responses: &statusCache{entries: make(map[bool]statusCacheEntry)},
 	}
 	st.authfn = st.authenticate
 
@@ -69,6 +140,11 @@ func NewStatusT(cfg *config.Server, bulker bulk.Bulk, cache cache.Cache, opts ..
 	return st
 }
 
+// ReconfigureCache sets the ttl of the status responses, it drops the cached responses.
+func (st StatusT) ReconfigureCache(settings config.CacheSettings) {
+	st.responses.reconfigure(settings)
+}
+
 func (st StatusT) authenticate(r *http.Request) (*apikey.APIKey, error) {
 	// This authenticates that the provided API key exists and is enabled.
 	// WARNING: This does not validate that the api key is valid for the Fleet Domain.
@@ -85,22 +161,41 @@ func (st StatusT) handleStatus(zlog zerolog.Logger, r *http.Request, w http.Resp
 	}
 
 	span, ctx := apm.StartSpan(r.Context(), "getState", "process")
-	state := st.sm.State()
-	resp := StatusAPIResponse{
-		Name:   build.ServiceName,
-		Status: StatusResponseStatus(state.String()), // TODO try to make the oapi codegen less verbose here
-	}
-
-	if authed {
-		sSpan, _ := apm.StartSpan(ctx, "getVersion", "process")
-		bt := st.bi.BuildTime.Format(time.RFC3339)
-		resp.Version = &StatusResponseVersion{
-			Number:    &st.bi.Version,
-			BuildHash: &st.bi.Commit,
-			BuildTime: &bt,
+	resp, state, cached := st.responses.get(authed)
+	if !cached {
+		state = st.sm.State()
+		resp = StatusAPIResponse{
+			Name:   build.ServiceName,
+			Status: StatusResponseStatus(state.String()), // TODO try to make the oapi codegen less verbose here
+		}
+
+		if authed {
+			sSpan, _ := apm.StartSpan(ctx, "getVersion", "process")
+			bt := st.bi.BuildTime.Format(time.RFC3339)
+			resp.Version = &StatusResponseVersion{
+				Number:    &st.bi.Version,
+				BuildHash: &st.bi.Commit,
+				BuildTime: &bt,
+			}
+			sSpan.End()
+			resp.Outputs = st.outputsHealth()
+			resp.Proxy = st.proxyHealth()
+		}
+		st.responses.set(authed, resp, state)
+	}
+	// the draining state is not cached, the load balancers see it at once
+	if state == client.UnitStateHealthy && st.drain.Draining() {
+		state = client.UnitStateDegraded
+		resp.Status = StatusResponseStatus(state.String())
+	}
+	if state == client.UnitStateHealthy {
+		if warnings := st.usage.Warnings(); len(warnings) > 0 {
+			zlog.Debug().Strs("warnings", warnings).Msg("resource usage over the warning thresholds, report a degraded status")
+			state = client.UnitStateDegraded
+			resp.Status = StatusResponseStatus(state.String())
 		}
-		sSpan.End()
 	}
+	span.Context.SetLabel("cached", cached)
 	span.End()
 
 	span, _ = apm.StartSpan(r.Context(), "response", "write")
@@ -144,3 +239,41 @@ func (st StatusT) handleStatus(zlog zerolog.Logger, r *http.Request, w http.Resp
 
 	return nil
 }
+
+// outputsHealth returns the last known health of the remote outputs, or nil if there are none.
+func (st StatusT) outputsHealth() *map[string]StatusResponseOutput {
+	health := st.bulk.RemoteOutputHealth()
+	if len(health) == 0 {
+		return nil
+	}
+	outputs := make(map[string]StatusResponseOutput, len(health))
+	for name, h := range health {
+		output := StatusResponseOutput{State: h.State}
+		if h.Message != "" {
+			output.Message = &h.Message
+		}
+		if !h.UpdatedAt.IsZero() {
+			output.UpdatedAt = &h.UpdatedAt
+		}
+		outputs[name] = output
+	}
+	return &outputs
+}
+
+// proxyHealth returns the health of the proxy in front of elasticsearch, or nil if no request went through a proxy.
+func (st StatusT) proxyHealth() *StatusResponseProxy {
+	state, ok := st.proxy.State()
+	if !ok {
+		return nil
+	}
+	proxy := StatusResponseProxy{
+		Url:       state.Proxy,
+		State:     client.UnitStateHealthy.String(),
+		UpdatedAt: &state.UpdatedAt,
+	}
+	if !state.Healthy {
+		proxy.State = client.UnitStateDegraded.String()
+		proxy.Message = &state.Message
+	}
+	return &proxy
+}
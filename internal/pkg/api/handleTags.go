@@ -0,0 +1,173 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/miolini/datacounter"
+	"github.com/rs/zerolog"
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+)
+
+const (
+	kTagsMod = "tags"
+
+	// maxAgentTags is the max number of tags of an agent.
+	maxAgentTags = 100
+	// maxAgentTagLength is the max length of a tag in characters.
+	maxAgentTagLength = 256
+)
+
+var ErrAgentTags = errors.New("invalid agent tags")
+
+type TagsT struct {
+	cfg      *config.Server
+	reloaded reloadedCfg
+	bulk     bulk.Bulk
+}
+
+// Reload applies the reloaded server configuration to the requests handled from now on.
+func (tt *TagsT) Reload(cfg *config.Server) {
+	tt.reloaded.p.Store(cfg)
+}
+
+// serverCfg returns the server configuration of the requests, as last reloaded.
+func (tt *TagsT) serverCfg() *config.Server {
+	return tt.reloaded.get(tt.cfg)
+}
+
+func NewTagsT(cfg *config.Server, bulker bulk.Bulk) *TagsT {
+	return &TagsT{
+		cfg:  cfg,
+		bulk: bulker,
+	}
+}
+
+func (tt *TagsT) handleAdd(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, id string) error {
+	info, err := authAdmin(r, tt.bulk)
+	if err != nil {
+		return err
+	}
+	zlog = zlog.With().Str("userName", info.UserName).Logger()
+	r = r.WithContext(zlog.WithContext(r.Context()))
+
+	req, err := tt.validateAddRequest(zlog, w, r)
+	if err != nil {
+		return err
+	}
+
+	auditTargets(r.Context(), []string{id}, nil)
+	auditDetail(r.Context(), "added_tags", req.Tags)
+
+	tags, err := tt.updateTags(r.Context(), zlog, id, req.Tags, nil)
+	if err != nil {
+		return err
+	}
+	return tt.writeTags(r.Context(), w, tags)
+}
+
+func (tt *TagsT) handleRemove(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request, id, tag string) error {
+	info, err := authAdmin(r, tt.bulk)
+	if err != nil {
+		return err
+	}
+	zlog = zlog.With().Str("userName", info.UserName).Logger()
+	r = r.WithContext(zlog.WithContext(r.Context()))
+
+	if err := validateTag(tag); err != nil {
+		return err
+	}
+
+	auditTargets(r.Context(), []string{id}, nil)
+	auditDetail(r.Context(), "removed_tags", []string{tag})
+
+	tags, err := tt.updateTags(r.Context(), zlog, id, nil, []string{tag})
+	if err != nil {
+		return err
+	}
+	return tt.writeTags(r.Context(), w, tags)
+}
+
+func (tt *TagsT) validateAddRequest(zlog zerolog.Logger, w http.ResponseWriter, r *http.Request) (*AgentTagsRequest, error) {
+	span, _ := apm.StartSpan(r.Context(), "validateRequest", "validate")
+	defer span.End()
+
+	body := r.Body
+	if tt.serverCfg().Limits.AgentTagsLimit.MaxBody > 0 {
+		body = http.MaxBytesReader(w, body, tt.serverCfg().Limits.AgentTagsLimit.MaxBody)
+	}
+	readCounter := datacounter.NewReaderCounter(body)
+
+	var req AgentTagsRequest
+	if err := decodeRequest(r.Context(), readCounter, &req, "agent tags"); err != nil {
+		return nil, err
+	}
+	cntAgentTags.bodyIn.Add(readCounter.Count())
+
+	if len(req.Tags) == 0 {
+		return nil, fmt.Errorf("%w: no tags specified", ErrAgentTags)
+	}
+	if len(req.Tags) > maxAgentTags {
+		return nil, fmt.Errorf("%w: %d tags exceeds the max of %d", ErrAgentTags, len(req.Tags), maxAgentTags)
+	}
+	for _, tag := range req.Tags {
+		if err := validateTag(tag); err != nil {
+			return nil, err
+		}
+	}
+
+	zlog.Trace().Strs("tags", req.Tags).Msg("Agent tags request")
+	return &req, nil
+}
+
+func validateTag(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("%w: empty tag", ErrAgentTags)
+	}
+	if n := utf8.RuneCountInString(tag); n > maxAgentTagLength {
+		return fmt.Errorf("%w: tag of %d characters exceeds the max of %d", ErrAgentTags, n, maxAgentTagLength)
+	}
+	return nil
+}
+
+func (tt *TagsT) updateTags(ctx context.Context, zlog zerolog.Logger, id string, add, remove []string) ([]string, error) {
+	span, ctx := apm.StartSpan(ctx, "updateTags", "update")
+	defer span.End()
+
+	tags, err := dl.UpdateAgentTags(ctx, tt.bulk, id, add, remove, maxAgentTags)
+	if err != nil {
+		return nil, err
+	}
+	zlog.Info().
+		Strs("added", add).
+		Strs("removed", remove).
+		Int("tags", len(tags)).
+		Msg("Updated agent tags")
+	return tags, nil
+}
+
+func (tt *TagsT) writeTags(ctx context.Context, w http.ResponseWriter, tags []string) error {
+	span, _ := apm.StartSpan(ctx, "response", "write")
+	defer span.End()
+
+	data, err := json.Marshal(&AgentTagsResponse{Tags: tags})
+	if err != nil {
+		return fmt.Errorf("agentTags marshal: %w", err)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(data)
+	cntAgentTags.bodyOut.Add(uint64(len(data)))
+	return err
+}
@@ -0,0 +1,219 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func Test_Tags_validateAddRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		valid *AgentTagsRequest
+		err   error
+	}{{
+		name:  "ok",
+		body:  `{"tags":["production","eu-west"]}`,
+		valid: &AgentTagsRequest{Tags: []string{"production", "eu-west"}},
+	}, {
+		name: "not json object",
+		body: `{"invalidJson":}`,
+		err:  &BadRequestErr{msg: "unable to decode agent tags request"},
+	}, {
+		name: "no tags",
+		body: `{"tags":[]}`,
+		err:  ErrAgentTags,
+	}, {
+		name: "empty tag",
+		body: `{"tags":["production",""]}`,
+		err:  ErrAgentTags,
+	}, {
+		name: "tag too long",
+		body: `{"tags":["` + strings.Repeat("a", maxAgentTagLength+1) + `"]}`,
+		err:  ErrAgentTags,
+	}, {
+		name: "too many tags",
+		body: `{"tags":["a"` + strings.Repeat(`,"a"`, maxAgentTags) + `]}`,
+		err:  ErrAgentTags,
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := TagsT{cfg: &config.Server{}}
+			w := httptest.NewRecorder()
+			r := &http.Request{Body: io.NopCloser(strings.NewReader(tc.body))}
+
+			req, err := tt.validateAddRequest(testlog.SetLogger(t), w, r)
+			if tc.err != nil {
+				if _, ok := tc.err.(*BadRequestErr); ok {
+					require.EqualError(t, err, tc.err.Error())
+				} else {
+					require.ErrorIs(t, err, tc.err)
+				}
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.valid, req)
+		})
+	}
+}
+
+// tagsTestBulk returns a bulker that authenticates a user API key, records the params of the tags script
+// and reads back an agent with the tags.
+func tagsTestBulk(t *testing.T, updateErr error, tags []string) (*ftesting.MockBulk, *map[string]interface{}) {
+	t.Helper()
+	var params map[string]interface{}
+	bulker := ftesting.NewMockBulk()
+	bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{UserName: "elastic", Enabled: true}, nil)
+	mockAdminPrivileges(t, bulker, true)
+	bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var body struct {
+			Script struct {
+				Params map[string]interface{} `json:"params"`
+			} `json:"script"`
+		}
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &body))
+		params = body.Script.Params
+	}).Return(updateErr)
+	source, err := json.Marshal(map[string]interface{}{"active": true, "tags": tags})
+	require.NoError(t, err)
+	bulker.On("ReadRaw", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything).Return(&bulk.MgetResponseItem{
+		Found:  true,
+		Source: source,
+	}, nil).Maybe()
+	return bulker, &params
+}
+
+func Test_Tags_handleAdd(t *testing.T) {
+	t.Run("tags added", func(t *testing.T) {
+		bulker, params := tagsTestBulk(t, nil, []string{"existing", "production"})
+		tt := NewTagsT(&config.Server{}, bulker)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/tags", strings.NewReader(`{"tags":["production"]}`))
+		r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+
+		require.NoError(t, tt.handleAdd(testlog.SetLogger(t), w, r, "agent-1"))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"tags":["existing","production"]}`, w.Body.String())
+		require.Equal(t, []interface{}{"production"}, (*params)["add"])
+		require.Equal(t, []interface{}{}, (*params)["remove"])
+		require.EqualValues(t, maxAgentTags, (*params)["max"])
+		bulker.AssertExpectations(t)
+	})
+
+	t.Run("limit exceeded", func(t *testing.T) {
+		tags := make([]string, maxAgentTags)
+		for i := range tags {
+			tags[i] = "tag"
+		}
+		bulker, _ := tagsTestBulk(t, nil, tags)
+		tt := NewTagsT(&config.Server{}, bulker)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/tags", strings.NewReader(`{"tags":["production"]}`))
+		r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+
+		err := tt.handleAdd(testlog.SetLogger(t), w, r, "agent-1")
+		require.ErrorIs(t, err, dl.ErrAgentTagsLimit)
+		resp := NewHTTPErrResp(err)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		require.Contains(t, resp.Message, fmt.Sprintf("max of %d tags", maxAgentTags))
+	})
+
+	t.Run("limit exceeded by the added tags", func(t *testing.T) {
+		// the agent has room for one of the tags, the script adds none of them
+		tags := make([]string, maxAgentTags-1)
+		for i := range tags {
+			tags[i] = fmt.Sprintf("tag-%d", i)
+		}
+		bulker, _ := tagsTestBulk(t, nil, tags)
+		tt := NewTagsT(&config.Server{}, bulker)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/tags", strings.NewReader(`{"tags":["production","linux","tag-0"]}`))
+		r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+
+		err := tt.handleAdd(testlog.SetLogger(t), w, r, "agent-1")
+		require.ErrorIs(t, err, dl.ErrAgentTagsLimit)
+		resp := NewHTTPErrResp(err)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		require.Equal(t, fmt.Sprintf("agent tags limit exceeded: adding 2 tags to the %d tags of the agent exceeds the max of %d tags", maxAgentTags-1, maxAgentTags), resp.Message)
+	})
+
+	t.Run("agent not found", func(t *testing.T) {
+		bulker, _ := tagsTestBulk(t, es.ErrElasticNotFound, nil)
+		tt := NewTagsT(&config.Server{}, bulker)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/tags", strings.NewReader(`{"tags":["production"]}`))
+		r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+
+		err := tt.handleAdd(testlog.SetLogger(t), w, r, "agent-1")
+		require.ErrorIs(t, err, dl.ErrNotFound)
+		require.Equal(t, http.StatusNotFound, NewHTTPErrResp(err).StatusCode)
+	})
+
+	t.Run("agent key rejected", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{
+			UserName: "elastic",
+			Enabled:  true,
+			Metadata: json.RawMessage(`{"managed_by":"fleet-server","type":"agent"}`),
+		}, nil)
+		tt := NewTagsT(&config.Server{}, bulker)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/tags", strings.NewReader(`{"tags":["production"]}`))
+		r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+
+		require.ErrorIs(t, tt.handleAdd(testlog.SetLogger(t), w, r, "agent-1"), ErrAdminAuth)
+		bulker.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func Test_Tags_handleRemove(t *testing.T) {
+	t.Run("tag removed", func(t *testing.T) {
+		bulker, params := tagsTestBulk(t, nil, nil)
+		tt := NewTagsT(&config.Server{}, bulker)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodDelete, "/api/fleet/agents/agent-1/tags/production", nil)
+		r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+
+		require.NoError(t, tt.handleRemove(testlog.SetLogger(t), w, r, "agent-1", "production"))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"tags":[]}`, w.Body.String())
+		require.Equal(t, []interface{}{}, (*params)["add"])
+		require.Equal(t, []interface{}{"production"}, (*params)["remove"])
+		bulker.AssertExpectations(t)
+	})
+
+	t.Run("tag too long", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{UserName: "elastic", Enabled: true}, nil)
+		mockAdminPrivileges(t, bulker, true)
+		tt := NewTagsT(&config.Server{}, bulker)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodDelete, "/api/fleet/agents/agent-1/tags/x", nil)
+		r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+
+		require.ErrorIs(t, tt.handleRemove(testlog.SetLogger(t), w, r, "agent-1", strings.Repeat("x", maxAgentTagLength+1)), ErrAgentTags)
+	})
+}
@@ -7,6 +7,7 @@ package api
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -14,7 +15,6 @@ import (
 	"io"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/rs/zerolog"
 	"go.elastic.co/apm/v2"
@@ -33,7 +33,14 @@ import (
 const (
 	// TODO: move to a config
 	maxFileSize    = 104857600 // 100 MiB
-	maxUploadTimer = 24 * time.Hour
+	maxUploadTimer = uploader.DefaultTimeLimit
+
+	// uploadBeginPath is the path of the endpoint that starts an upload, it is sent with the actions requesting an upload.
+	uploadBeginPath = "/api/fleet/uploads"
+
+	// limits of the active uploads of an agent
+	maxAgentUploads     = 4
+	maxAgentUploadsSize = 2 * maxFileSize
 )
 
 var (
@@ -58,7 +65,7 @@ func NewUploadT(cfg *config.Server, bulker bulk.Bulk, chunkClient *elasticsearch
 		chunkClient: chunkClient,
 		bulker:      bulker,
 		cache:       cache,
-		uploader:    uploader.New(chunkClient, bulker, cache, maxFileSize, maxUploadTimer),
+		uploader:    uploader.New(chunkClient, bulker, cache, maxFileSize, maxUploadTimer, maxAgentUploads, maxAgentUploadsSize),
 		authAgent:   authAgent,
 		authAPIKey:  authAPIKey,
 	}
@@ -128,9 +135,19 @@ func (ut *UploadT) handleUploadChunk(zlog zerolog.Logger, w http.ResponseWriter,
 	if err != nil {
 		return err
 	}
+	// chunks are only accepted from the agent that began the upload
+	if _, err := ut.authAgent(r, &upinfo.AgentID, ut.bulker, ut.cache); err != nil {
+		return fmt.Errorf("error authenticating for chunk upload: %w", err)
+	}
 
 	// prevent over-sized chunks
-	data := http.MaxBytesReader(w, r.Body, file.MaxChunkSize)
+	var data io.Reader
+	if strings.EqualFold(r.Header.Get("Content-Transfer-Encoding"), "base64") {
+		// agents that cannot send binary bodies base64 encode the chunk, the chunk hash is of the decoded chunk
+		data = base64.NewDecoder(base64.StdEncoding, http.MaxBytesReader(w, r.Body, int64(base64.StdEncoding.EncodedLen(file.MaxChunkSize))))
+	} else {
+		data = http.MaxBytesReader(w, r.Body, file.MaxChunkSize)
+	}
 
 	// compute hash as we stream it
 	hash := sha256.New()
@@ -9,6 +9,7 @@ package api
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"io"
@@ -17,6 +18,7 @@ import (
 	"net/http/httptest"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -992,6 +994,148 @@ func TestUploadCompleteBadRequests(t *testing.T) {
 	assert.Equal(t, rec.Body.String(), "{\"statusCode\":400,\"error\":\"BadRequest\",\"message\":\"Bad request: unable to decode upload complete request\"}")
 }
 
+func TestUploadChunkedFile(t *testing.T) {
+	hr, _, fakebulk, mtx := prepareUploaderMock(t)
+
+	chunks := [][]byte{
+		bytes.Repeat([]byte("a"), file.MaxChunkSize),
+		bytes.Repeat([]byte("b"), file.MaxChunkSize),
+		[]byte("final data"),
+	}
+	var size int
+	for _, c := range chunks {
+		size += len(c)
+	}
+
+	// begin
+	rec := httptest.NewRecorder()
+	hr.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, RouteUploadBegin, strings.NewReader(`{
+		"file": {"size": `+strconv.Itoa(size)+`, "name": "diagnostics.zip", "mime_type": "application/zip"},
+		"agent_id": "foo",
+		"action_id": "bar",
+		"src": "agent"
+	}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var begin UploadBeginAPIResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &begin))
+	require.Equal(t, int64(file.MaxChunkSize), begin.ChunkSize)
+
+	info := file.Info{
+		DocID:     "bar.foo",
+		ID:        begin.UploadId,
+		ChunkSize: begin.ChunkSize,
+		Total:     int64(size),
+		Count:     len(chunks),
+		Start:     time.Now(),
+		Status:    file.StatusAwaiting,
+		Source:    "agent",
+		AgentID:   "foo",
+		ActionID:  "bar",
+	}
+	// the upload info may be read from elasticsearch by each chunk request until it is cached
+	for i := 0; i < len(chunks)+1; i++ {
+		mockUploadInfoResult(fakebulk, info)
+	}
+
+	var mu sync.Mutex
+	indexed := make(map[string][]byte)
+	var deleted int
+	mtx.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.Contains(req.URL.Path, "_delete_by_query") {
+			deleted++
+		} else if strings.Contains(req.URL.Path, "_create") {
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+			indexed[req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]] = body
+		}
+		return sendBodyString("{}"), nil //nolint:bodyclose // nopcloser is used, linter does not see it
+	}
+
+	hashes := make([]string, len(chunks))
+	for i, c := range chunks {
+		h := sha256.Sum256(c)
+		hashes[i] = hex.EncodeToString(h[:])
+	}
+	uploadChunk := func(num int, body io.Reader, hash string, base64Encoded bool) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/api/fleet/uploads/"+begin.UploadId+"/"+strconv.Itoa(num), body)
+		req.Header.Set("X-Chunk-SHA2", hash)
+		if base64Encoded {
+			req.Header.Set("Content-Transfer-Encoding", "base64")
+		}
+		hr.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// chunks are uploaded out of order, the first chunk base64 encoded
+	rec = uploadChunk(2, bytes.NewReader(chunks[2]), hashes[2], false)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = uploadChunk(0, strings.NewReader(base64.StdEncoding.EncodeToString(chunks[0])), hashes[0], true)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// a chunk not matching its hash is rejected and removed
+	rec = uploadChunk(1, bytes.NewReader(chunks[0]), hashes[1], false)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "hash does not match")
+	require.Equal(t, 1, deleted)
+
+	rec = uploadChunk(1, bytes.NewReader(chunks[1]), hashes[1], false)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// chunks are indexed decoded
+	require.Len(t, indexed, len(chunks))
+	for i, c := range chunks {
+		require.True(t, bytes.Contains(indexed["bar.foo."+strconv.Itoa(i)], c), "chunk %d data", i)
+	}
+
+	// complete
+	chunkInfos := make([]file.ChunkInfo, len(chunks))
+	for i, c := range chunks {
+		chunkInfos[i] = file.ChunkInfo{
+			Pos:  i,
+			BID:  info.DocID,
+			Last: i == len(chunks)-1,
+			Size: len(c),
+			SHA2: hashes[i],
+		}
+	}
+	transitHash := mockUploadedFile(fakebulk, info, chunkInfos)
+	rec = httptest.NewRecorder()
+	hr.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/fleet/uploads/"+begin.UploadId, strings.NewReader(`{"transithash": {"sha256": "`+transitHash+`"}}`)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+}
+
+func TestUploadBeginAgentLimits(t *testing.T) {
+	tests := []struct {
+		Name         string
+		Active       uint64
+		ActiveSize   float64
+		ExpectStatus int
+	}{
+		{"Below the limits succeeds", maxAgentUploads - 1, 0, http.StatusOK},
+		{"Too many active uploads rejects", maxAgentUploads, 0, http.StatusTooManyRequests},
+		{"Total size of active uploads exceeded rejects", 1, maxAgentUploadsSize - 100, http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			hr, rt, _, _ := prepareUploaderMock(t)
+			res := &es.ResultT{Aggregations: map[string]es.Aggregation{"uploads_size": {Value: tc.ActiveSize}}}
+			res.Total.Value = tc.Active
+			fakebulk := itesting.NewMockBulk()
+			fakebulk.On("Search", mock.Anything, ".fleet-fileds-fromhost-meta-*", mock.Anything, mock.Anything).Return(res, nil)
+			fakebulk.On("Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+			rt.ut.uploader = uploader.New(nil, fakebulk, rt.ut.cache, maxFileSize, maxUploadTimer, maxAgentUploads, maxAgentUploadsSize)
+
+			rec := httptest.NewRecorder()
+			hr.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, RouteUploadBegin, strings.NewReader(mockStartBodyWithAgent("foo"))))
+			assert.Equal(t, tc.ExpectStatus, rec.Code, rec.Body.String())
+		})
+	}
+}
+
 /*
 	Helpers and mocks
 */
@@ -1000,9 +1144,16 @@ func TestUploadCompleteBadRequests(t *testing.T) {
 func prepareUploaderMock(t *testing.T) (http.Handler, apiServer, *itesting.MockBulk, *MockTransport) {
 	// chunk index operations skip the bulker in order to send binary docs directly
 	// so a mock *elasticsearch.Client needs to be be prepared
-	es, tx := mockESClient(t)
+	esClient, tx := mockESClient(t)
 
 	fakebulk := itesting.NewMockBulk()
+	// the agent has no active uploads
+	fakebulk.On("Search",
+		mock.Anything,
+		".fleet-fileds-fromhost-meta-*",
+		mock.MatchedBy(func(body []byte) bool { return bytes.Contains(body, []byte("uploads_size")) }),
+		mock.Anything,
+	).Return(&es.ResultT{}, nil)
 	fakebulk.On("Create",
 		mock.Anything,
 		mock.Anything,
@@ -1023,7 +1174,7 @@ func prepareUploaderMock(t *testing.T) (http.Handler, apiServer, *itesting.MockB
 		mock.Anything,
 		mock.Anything,
 		mock.Anything,
-	).Return(es, nil)
+	).Return(esClient, nil)
 
 	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
 	require.NoError(t, err)
@@ -1032,9 +1183,9 @@ func prepareUploaderMock(t *testing.T) (http.Handler, apiServer, *itesting.MockB
 	si := apiServer{
 		ut: &UploadT{
 			bulker:      fakebulk,
-			chunkClient: es,
+			chunkClient: esClient,
 			cache:       c,
-			uploader:    uploader.New(es, fakebulk, c, maxFileSize, maxUploadTimer),
+			uploader:    uploader.New(esClient, fakebulk, c, maxFileSize, maxUploadTimer, maxAgentUploads, maxAgentUploadsSize),
 			authAgent: func(r *http.Request, id *string, bulker bulk.Bulk, c cache.Cache) (*model.Agent, error) {
 				return &model.Agent{
 					ESDocument: model.ESDocument{
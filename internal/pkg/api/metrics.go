@@ -8,6 +8,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -18,6 +19,7 @@ import (
 
 	"github.com/elastic/elastic-agent-libs/api"
 	cfglib "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
 	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/elastic/elastic-agent-system-metrics/report"
 
@@ -36,17 +38,30 @@ var (
 	cntHTTPClose  *statsCounter
 	cntHTTPActive *statsGauge
 
-	cntCheckin       routeStats
-	cntEnroll        routeStats
-	cntAcks          routeStats
-	cntStatus        routeStats
-	cntUploadStart   routeStats
-	cntUploadChunk   routeStats
-	cntUploadEnd     routeStats
-	cntFileDeliv     routeStats
-	cntGetPGP        routeStats
-	cntAuditUnenroll routeStats
-	cntArtifacts     artifactStats
+	cntCheckin        routeStats
+	cntEnroll         routeStats
+	cntAcks           routeStats
+	cntStatus         routeStats
+	cntUploadStart    routeStats
+	cntUploadChunk    routeStats
+	cntUploadEnd      routeStats
+	cntFileDeliv      routeStats
+	cntGetPGP         routeStats
+	cntAuditUnenroll  routeStats
+	cntCreateActions  routeStats
+	cntActionResults  routeStats
+	cntReassignAgents routeStats
+	cntAgentTags      routeStats
+	cntPolicyFetch    routeStats
+	cntPolicyRollout  routeStats
+	cntBulkEnroll     routeStats
+	cntOperations     routeStats
+	cntArtifacts      artifactStats
+
+	cntPolicyQuotas   policyQuotaStats
+	cntPolicySizes    policySizeStats
+	cntActionFailures actionFailureStats
+	cntConcurrency    concurrencyStats
 
 	infoReg sync.Once
 )
@@ -78,6 +93,19 @@ func init() {
 	cntFileDeliv.Register(routesRegistry.newRegistry("deliverFile"))
 	cntGetPGP.Register(routesRegistry.newRegistry("getPGPKey"))
 	cntAuditUnenroll.Register(routesRegistry.newRegistry("auditUnenroll"))
+	cntCreateActions.Register(routesRegistry.newRegistry("createActions"))
+	cntActionResults.Register(routesRegistry.newRegistry("actionResults"))
+	cntReassignAgents.Register(routesRegistry.newRegistry("reassignAgents"))
+	cntAgentTags.Register(routesRegistry.newRegistry("agentTags"))
+	cntPolicyFetch.Register(routesRegistry.newRegistry("policyFetch"))
+	cntPolicyRollout.Register(routesRegistry.newRegistry("policyRollout"))
+	cntBulkEnroll.Register(routesRegistry.newRegistry("bulkEnroll"))
+	cntOperations.Register(routesRegistry.newRegistry("operations"))
+
+	cntPolicyQuotas.Register(registry.newRegistry("policy_quotas"))
+	cntPolicySizes.Register(registry.newRegistry("policy_sizes"))
+	cntActionFailures.Register(registry.newRegistry("action_failures"))
+	cntConcurrency.Register(registry.newRegistry("concurrency"))
 }
 
 // metricsRegistry wraps libbeat and prometheus registries
@@ -214,14 +242,18 @@ func (rt *routeStats) IncStart() func() {
 // artifactStats is the collection of metrics we collect for the artifact route.
 type artifactStats struct {
 	routeStats
-	notFound *statsCounter
-	throttle *statsCounter
+	notFound  *statsCounter
+	throttle  *statsCounter
+	cacheHit  *statsCounter
+	cacheMiss *statsCounter
 }
 
 func (rt *artifactStats) Register(registry *metricsRegistry) {
 	rt.routeStats.Register(registry)
 	rt.notFound = newCounter(registry, "not_found")
 	rt.throttle = newCounter(registry, "throttle")
+	rt.cacheHit = newCounter(registry, "cache_hit")
+	rt.cacheMiss = newCounter(registry, "cache_miss")
 }
 
 func (rt *artifactStats) IncError(err error) {
@@ -235,10 +267,155 @@ func (rt *artifactStats) IncError(err error) {
 	}
 }
 
+// policyQuotaStats counts the requests rejected by the quotas of the policies.
+// The prometheus counter is labeled with the policy and the quota, the libbeat counter is the total of the rejections.
+type policyQuotaStats struct {
+	rejected *monitoring.Uint
+	byPolicy *prometheus.CounterVec
+}
+
+func (st *policyQuotaStats) Register(registry *metricsRegistry) {
+	st.rejected = monitoring.NewUint(registry.registry, "rejected")
+	st.byPolicy = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: registry.fullName,
+		Name:      "rejected",
+		Help:      "Requests rejected by the quotas of the policies",
+	}, []string{"policy_id", "quota"})
+	registry.promReg.MustRegister(st.byPolicy)
+}
+
+// IncRejected counts a request rejected by the quota of the policy.
+func (st *policyQuotaStats) IncRejected(policyID, quota string) {
+	st.rejected.Inc()
+	st.byPolicy.WithLabelValues(policyID, quota).Inc()
+}
+
+// policySizeStats records the size of the latest revision of each policy as it is delivered to the agents, in bytes.
+// The prometheus gauge is labeled with the policy, the libbeat registry reports the revision and size of each policy
+// and counts the deliveries refused to the agents that can't fetch the revisions larger than the max policy size.
+type policySizeStats struct {
+	mut      sync.Mutex
+	policies map[string]policySize
+	refused  *monitoring.Uint
+	byPolicy *prometheus.GaugeVec
+}
+
+type policySize struct {
+	revision int64
+	size     int
+}
+
+func (st *policySizeStats) Register(registry *metricsRegistry) {
+	st.policies = make(map[string]policySize)
+	st.refused = monitoring.NewUint(registry.registry, "refused")
+	monitoring.NewFunc(registry.registry, "policies", st.report)
+	st.byPolicy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: registry.fullName,
+		Name:      "bytes",
+		Help:      "Size of the latest revision of the policies delivered to the agents",
+	}, []string{"policy_id"})
+	registry.promReg.MustRegister(st.byPolicy)
+}
+
+// Observe records the size of the revision of the policy.
+// True is returned when the revision is newer than the one recorded for the policy.
+func (st *policySizeStats) Observe(policyID string, revision int64, size int) bool {
+	st.mut.Lock()
+	defer st.mut.Unlock()
+	if p, ok := st.policies[policyID]; ok && p.revision >= revision {
+		return false
+	}
+	st.policies[policyID] = policySize{revision: revision, size: size}
+	st.byPolicy.WithLabelValues(policyID).Set(float64(size))
+	return true
+}
+
+// IncRefused counts a policy revision that was not delivered as it is larger than the max policy size.
+func (st *policySizeStats) IncRefused() {
+	st.refused.Inc()
+}
+
+func (st *policySizeStats) report(_ monitoring.Mode, v monitoring.Visitor) {
+	st.mut.Lock()
+	defer st.mut.Unlock()
+	v.OnRegistryStart()
+	defer v.OnRegistryFinished()
+
+	for policyID, p := range st.policies {
+		monitoring.ReportNamespace(v, policyID, func() {
+			monitoring.ReportInt(v, "revision", p.revision)
+			monitoring.ReportInt(v, "bytes", int64(p.size))
+		})
+	}
+}
+
+// actionFailureStats counts the action acks that report an error.
+// The prometheus counter is labeled with the action type, the libbeat counter is the total of the failures.
+type actionFailureStats struct {
+	total  *monitoring.Uint
+	byType *prometheus.CounterVec
+}
+
+func (st *actionFailureStats) Register(registry *metricsRegistry) {
+	st.total = monitoring.NewUint(registry.registry, "total")
+	st.byType = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: registry.fullName,
+		Name:      "total",
+		Help:      "Action acks that report an error",
+	}, []string{"action_type"})
+	registry.promReg.MustRegister(st.byType)
+}
+
+// Inc counts an action ack of the action type that reports an error.
+func (st *actionFailureStats) Inc(actionType string) {
+	st.total.Inc()
+	st.byType.WithLabelValues(actionType).Inc()
+}
+
+// concurrencyStats reports the requests of each endpoint class of the concurrency ceiling,
+// the requests served and waiting for the quota of the class are gauges.
+type concurrencyStats struct {
+	classes map[limit.Class]*concurrencyClassStats
+}
+
+type concurrencyClassStats struct {
+	inFlight *statsGauge
+	queued   *statsGauge
+	rejected *statsCounter
+}
+
+func (st *concurrencyStats) Register(registry *metricsRegistry) {
+	st.classes = make(map[limit.Class]*concurrencyClassStats, len(limit.Classes))
+	for _, class := range limit.Classes {
+		classRegistry := registry.newRegistry(string(class))
+		st.classes[class] = &concurrencyClassStats{
+			inFlight: newGauge(classRegistry, "in_flight"),
+			queued:   newGauge(classRegistry, "queued"),
+			rejected: newCounter(classRegistry, "rejected"),
+		}
+	}
+}
+
+func (st *concurrencyClassStats) IncInFlight() func() {
+	st.inFlight.Inc()
+	return st.inFlight.Dec
+}
+
+func (st *concurrencyClassStats) IncQueued() func() {
+	st.queued.Inc()
+	return st.queued.Dec
+}
+
+func (st *concurrencyClassStats) IncRejected() {
+	st.rejected.Inc()
+}
+
 // InitMetrics initializes metrics exposure mechanisms.
 // If tracer is not nil, prometheus metrics are shipped through the tracer.
-// If cfg.http.enabled is true a /stats endpoint is created to expose libbeat metrics and a /metrics endpoint is created to expose prometheus metrics on the specified interface.
-func InitMetrics(ctx context.Context, cfg *config.Config, bi build.Info, tracer *apm.Tracer) (*api.Server, error) {
+// If cfg.http.enabled is true a /stats endpoint is created to expose libbeat metrics and the stats registered in stats,
+// and a /metrics endpoint is created to expose prometheus metrics on the specified interface.
+// When auth is enabled the endpoints require its bearer token, see AttachStatusEndpoint for the status probe.
+func InitMetrics(ctx context.Context, cfg *config.Config, bi build.Info, tracer *apm.Tracer, stats *monitoring.Registry, auth *MonitoringAuth) (*api.Server, error) {
 	if tracer != nil {
 		tracer.RegisterMetricsGatherer(apmprometheus.Wrap(registry.promReg))
 	}
@@ -261,22 +438,54 @@ func InitMetrics(ctx context.Context, cfg *config.Config, bi build.Info, tracer
 	if err != nil {
 		return nil, err
 	}
-	s, err := api.NewWithDefaultRoutes(zapStub, cfgStub, monitoring.GetNamespace)
+	s, err := api.New(zapStub, newMetricsMux(stats, auth), cfgStub)
 	if err != nil {
 		return nil, fmt.Errorf("could not start the HTTP server for the API: %w", err)
 	}
 
-	attachPrometheusEndpoint(s, registry.promReg, bi)
+	attachPrometheusEndpoint(s, registry.promReg, bi, auth)
 
 	s.Start()
 	return s, err
 }
 
+// newMetricsMux returns the default routes of the libbeat monitoring API, with /stats served by statsHandler, and the OpenAPI document.
+// The routes require the bearer token of auth when it is enabled.
+func newMetricsMux(stats *monitoring.Registry, auth *MonitoringAuth) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", auth.Handler(api.MakeRootAPIHandler(api.MakeAPIHandler(monitoring.GetNamespace("info")))))
+	mux.HandleFunc("/state", auth.Handler(api.MakeAPIHandler(monitoring.GetNamespace("state"))))
+	mux.HandleFunc("/stats", auth.Handler(statsHandler(monitoring.GetNamespace("stats").GetRegistry(), stats)))
+	mux.HandleFunc("/dataset", auth.Handler(api.MakeAPIHandler(monitoring.GetNamespace("dataset"))))
+	mux.HandleFunc(openAPIPath, auth.Handler(openAPIHandler))
+	return mux
+}
+
+// statsHandler serves the libbeat metrics of global merged with the stats of the server instance.
+// The output has the format of the libbeat /stats endpoint so existing tooling can read it.
+func statsHandler(global, stats *monitoring.Registry) api.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := mapstr.M(monitoring.CollectStructSnapshot(global, monitoring.Full, false))
+		if stats != nil {
+			for k, v := range monitoring.CollectStructSnapshot(stats, monitoring.Full, false) {
+				data[k] = v
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if _, ok := r.URL.Query()["pretty"]; ok {
+			fmt.Fprint(w, data.StringToPrint())
+		} else {
+			fmt.Fprint(w, data.String())
+		}
+	}
+}
+
 type metricsRouter interface {
 	AddRoute(string, api.HandlerFunc)
 }
 
-func attachPrometheusEndpoint(router metricsRouter, reg *prometheus.Registry, bi build.Info) {
+func attachPrometheusEndpoint(router metricsRouter, reg *prometheus.Registry, bi build.Info, auth *MonitoringAuth) {
 	// do not attempt to re-register the metric on metrics restart.
 	// NOTE we may want to move this block earlier in InitMetrics so the tracer can ship it?
 	infoReg.Do(func() {
@@ -293,5 +502,21 @@ func attachPrometheusEndpoint(router metricsRouter, reg *prometheus.Registry, bi
 	})
 
 	h := promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
-	router.AddRoute("/metrics", promhttp.InstrumentMetricHandler(reg, h).ServeHTTP)
+	router.AddRoute("/metrics", auth.Handler(promhttp.InstrumentMetricHandler(reg, h).ServeHTTP))
+}
+
+// AttachStatusEndpoint serves the status endpoint of st on the monitoring listener at /api/status.
+// The short status response is the health probe of the load balancers and is not authenticated,
+// the detailed response requires the bearer token of auth when it is enabled.
+func AttachStatusEndpoint(router metricsRouter, st *StatusT, auth *MonitoringAuth) {
+	mst := *st
+	mst.authfn = auth.authenticate
+	router.AddRoute("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		zlog := zerolog.Ctx(r.Context()).With().Str("mod", kStatusMod).Logger()
+		w.Header().Set("Content-Type", "application/json")
+		if err := mst.handleStatus(zlog, r, w); err != nil {
+			cntStatus.IncError(err)
+			ErrorResp(w, r, err)
+		}
+	})
 }
@@ -33,7 +33,7 @@ func TestMetricsEndpoints(t *testing.T) {
 	defer cancel()
 	ctx = testlog.SetLogger(t).WithContext(ctx)
 
-	srv, err := InitMetrics(ctx, cfg, bi, nil)
+	srv, err := InitMetrics(ctx, cfg, bi, nil, nil, nil)
 	require.NoError(t, err, "unable to start metrics server")
 	defer srv.Stop() //nolint:errcheck // test server
 
@@ -0,0 +1,72 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+func TestStatsHandler(t *testing.T) {
+	global := monitoring.NewRegistry()
+	monitoring.NewString(global.NewRegistry("beat"), "name").Set("fleet-server")
+
+	stats := monitoring.NewRegistry()
+	ct := &CheckinT{cfg: &config.Server{Actions: config.Actions{
+		DefaultTTL: 24 * time.Hour,
+		TTL:        map[string]time.Duration{"UPGRADE": time.Hour},
+	}}}
+	WithCheckinStats(stats.NewRegistry("checkin"))(ct)
+	ct.inflight.Add(3)
+	ct.connected.Inc()
+	ct.pending.Observe("agent-1", 2, time.Now().Add(-time.Minute), time.Now())
+
+	for _, target := range []string{"/stats", "/stats?pretty"} {
+		t.Run(target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			statsHandler(global, stats)(w, httptest.NewRequest(http.MethodGet, target, nil))
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+
+			var data map[string]map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+			require.Equal(t, map[string]interface{}{"name": "fleet-server"}, data["beat"])
+			require.Equal(t, map[string]interface{}{
+				"inflight":  3.0,
+				"connected": 1.0,
+				"action_ttl": map[string]interface{}{
+					"default": 86400.0,
+					"types":   map[string]interface{}{"UPGRADE": 3600.0},
+				},
+				"pending_actions": map[string]interface{}{
+					"agents":     1.0,
+					"count":      map[string]interface{}{"max": 2.0, "p95": 2.0},
+					"oldest_age": map[string]interface{}{"max": 60.0, "p95": 60.0},
+				},
+			}, data["checkin"])
+		})
+	}
+}
+
+func TestStatsHandlerNoStats(t *testing.T) {
+	global := monitoring.NewRegistry()
+	monitoring.NewInt(global, "count").Set(1)
+
+	w := httptest.NewRecorder()
+	statsHandler(global, nil)(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"count":1}`, w.Body.String())
+}
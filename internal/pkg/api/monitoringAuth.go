@@ -0,0 +1,126 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/elastic/elastic-agent-libs/api"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+// monitoringTokenLength is the number of random bytes of a generated monitoring token.
+const monitoringTokenLength = 32
+
+// ErrMonitoringUnauthorized is returned for a request to the monitoring endpoint without a valid bearer token.
+var ErrMonitoringUnauthorized = errors.New("missing or invalid monitoring bearer token")
+
+// MonitoringAuth authenticates the requests to the monitoring endpoint with a bearer token.
+// It is shared by the monitoring servers of the successive server runs so a reload replaces the token in place.
+type MonitoringAuth struct {
+	mx      sync.RWMutex
+	enabled bool
+	token   []byte
+}
+
+func NewMonitoringAuth() *MonitoringAuth {
+	return &MonitoringAuth{}
+}
+
+// Configure sets the token of cfg. When none is set a new token is generated and written to cfg.TokenFile with 0600
+// permissions, each call rotates the generated token. The running token is kept if the token file can't be written.
+func (a *MonitoringAuth) Configure(cfg config.HTTPAuth) error {
+	token := cfg.Token
+	if cfg.Enabled && token == "" {
+		b := make([]byte, monitoringTokenLength)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("failed to generate the monitoring token: %w", err)
+		}
+		token = hex.EncodeToString(b)
+		if err := writeTokenFile(cfg.TokenFile, token); err != nil {
+			return err
+		}
+	}
+
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	a.enabled = cfg.Enabled
+	a.token = []byte(token)
+	return nil
+}
+
+// writeTokenFile replaces the content of path with token. The token is written to a temporary file that is renamed so
+// a reader never sees a partial token.
+func writeTokenFile(path, token string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write the monitoring token file: %w", err)
+	}
+	defer os.Remove(f.Name()) //nolint:errcheck // the temporary file is already renamed on success
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write the monitoring token file: %w", err)
+	}
+	if _, err := f.WriteString(token + "\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write the monitoring token file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write the monitoring token file: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to write the monitoring token file: %w", err)
+	}
+	return nil
+}
+
+// Authorized returns true if the authentication is disabled or the request has the bearer token.
+// The token is compared in constant time.
+func (a *MonitoringAuth) Authorized(r *http.Request) bool {
+	if a == nil {
+		return true
+	}
+	a.mx.RLock()
+	defer a.mx.RUnlock()
+	if !a.enabled {
+		return true
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || len(a.token) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), a.token) == 1
+}
+
+// authenticate is the AuthFunc of the status endpoint served on the monitoring listener.
+func (a *MonitoringAuth) authenticate(r *http.Request) (*apikey.APIKey, error) {
+	if !a.Authorized(r) {
+		return nil, ErrMonitoringUnauthorized
+	}
+	return nil, nil
+}
+
+// Handler returns h with the requests that are not authorized rejected with a 401.
+func (a *MonitoringAuth) Handler(h api.HandlerFunc) api.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.Authorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, ErrMonitoringUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
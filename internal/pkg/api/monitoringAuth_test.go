@@ -0,0 +1,146 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-client/v7/pkg/client"
+	"github.com/elastic/elastic-agent-libs/api"
+	"github.com/stretchr/testify/require"
+
+	fbuild "github.com/elastic/fleet-server/v7/internal/pkg/build"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+)
+
+// muxRouter adds the routes of the monitoring listener to a mux.
+type muxRouter struct {
+	*http.ServeMux
+}
+
+func (m muxRouter) AddRoute(path string, h api.HandlerFunc) {
+	m.HandleFunc(path, h)
+}
+
+func newMonitoringMux(t *testing.T, auth *MonitoringAuth) *http.ServeMux {
+	t.Helper()
+	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	st := NewStatusT(&config.Server{}, ftesting.NewMockBulk(), c,
+		WithSelfMonitor(&mockPolicyMonitor{client.UnitStateHealthy}),
+		WithBuildInfo(fbuild.Info{Version: "9.1.0", BuildTime: time.Now()}))
+
+	mux := newMetricsMux(nil, auth)
+	AttachStatusEndpoint(muxRouter{mux}, st, auth)
+	return mux
+}
+
+func TestMonitoringAuth(t *testing.T) {
+	auth := NewMonitoringAuth()
+	require.NoError(t, auth.Configure(config.HTTPAuth{Enabled: true, Token: "secret-token"}))
+	mux := newMonitoringMux(t, auth)
+
+	request := func(path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	status := func(w *httptest.ResponseRecorder) StatusAPIResponse {
+		var resp StatusAPIResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	t.Run("authorized", func(t *testing.T) {
+		for _, authorization := range []string{"Bearer secret-token", "bearer secret-token"} {
+			w := request("/stats", authorization)
+			require.Equal(t, http.StatusOK, w.Code)
+		}
+		w := request("/api/status", "Bearer secret-token")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NotNil(t, status(w).Version, "detailed status is returned")
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		for _, authorization := range []string{"", "Bearer wrong-token", "Bearer secret-token-suffix", "ApiKey secret-token", "secret-token"} {
+			for _, path := range []string{"/stats", "/state", "/"} {
+				w := request(path, authorization)
+				require.Equal(t, http.StatusUnauthorized, w.Code, "%s %q", path, authorization)
+				require.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+			}
+		}
+	})
+
+	t.Run("probe path is exempt", func(t *testing.T) {
+		w := request("/api/status", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		resp := status(w)
+		require.Equal(t, "HEALTHY", string(resp.Status))
+		require.Nil(t, resp.Version, "only the short status is returned")
+
+		w = request("/api/status", "Bearer wrong-token")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Nil(t, status(w).Version)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		require.NoError(t, auth.Configure(config.HTTPAuth{}))
+		t.Cleanup(func() { require.NoError(t, auth.Configure(config.HTTPAuth{Enabled: true, Token: "secret-token"})) })
+		require.Equal(t, http.StatusOK, request("/stats", "").Code)
+		require.NotNil(t, status(request("/api/status", "")).Version)
+	})
+}
+
+func TestMonitoringAuthGeneratedToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "monitoring.token")
+	cfg := config.HTTPAuth{Enabled: true, TokenFile: path}
+	auth := NewMonitoringAuth()
+
+	readToken := func() string {
+		t.Helper()
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+		p, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return strings.TrimSpace(string(p))
+	}
+	authorized := func(token string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return auth.Authorized(req)
+	}
+
+	require.NoError(t, auth.Configure(cfg))
+	token := readToken()
+	require.Len(t, token, 2*monitoringTokenLength)
+	require.True(t, authorized(token))
+
+	// each configuration rotates the generated token
+	require.NoError(t, auth.Configure(cfg))
+	rotated := readToken()
+	require.NotEqual(t, token, rotated)
+	require.True(t, authorized(rotated))
+	require.False(t, authorized(token))
+
+	// the running token is kept if the token file can't be written
+	require.Error(t, auth.Configure(config.HTTPAuth{Enabled: true, TokenFile: filepath.Join(path, "missing", "monitoring.token")}))
+	require.True(t, authorized(rotated))
+}
@@ -19,8 +19,10 @@ import (
 )
 
 const (
-	AgentApiKeyScopes = "agentApiKey.Scopes"
-	ApiKeyScopes      = "apiKey.Scopes"
+	AdminApiKeyScopes  = "adminApiKey.Scopes"
+	AgentApiKeyScopes  = "agentApiKey.Scopes"
+	ApiKeyScopes       = "apiKey.Scopes"
+	ServiceTokenScopes = "serviceToken.Scopes"
 )
 
 // Defines values for ActionType.
@@ -159,6 +161,7 @@ type AckResponse struct {
 // AckResponseItem The results of processing an acknowledgement event.
 type AckResponseItem struct {
 	// Message HTTP status text.
+	// The ignored acks of policy change actions are successful with the reason as message, policy_superseded for an action of another policy than the agent's, or policy_revision_stale for an older revision than the agent's. The acks of the actions of an unknown type are recorded with the message action_type_unsupported.
 	Message *string `json:"message,omitempty"`
 
 	// Status An HTTP status code that indicates if the event was processed successfully or not.
@@ -211,6 +214,21 @@ type Action_Data struct {
 // ActionType The action type. If fleet-server encounters an action that does not have a type listed below it will be filtered out and an error will be logged.
 type ActionType string
 
+// ActionAgentResult The status of an action for an agent.
+type ActionAgentResult struct {
+	// AgentId The agent ID.
+	AgentId string `json:"agent_id"`
+
+	// CompletedAt The time of the latest result of the agent.
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	// Error The error the agent reported.
+	Error *string `json:"error,omitempty"`
+
+	// Status The status of the action for the agent, one of acked, failed, pending or expired.
+	Status string `json:"status"`
+}
+
 // ActionCancel The CANCEL action data.
 type ActionCancel struct {
 	TargetId string `json:"target_id"`
@@ -221,6 +239,10 @@ type ActionInputAction = map[string]interface{}
 
 // ActionPolicyChange The POLICY_CHANGE action data.
 type ActionPolicyChange struct {
+	// Fetch Set when the policy revision is larger than the max size of the policies delivered in the checkin responses.
+	// The policy of the action then only holds the id, revision, outputs and secret_paths of the policy, the agent fetches the other fields from the policy endpoint.
+	Fetch *PolicyFetch `json:"fetch,omitempty"`
+
 	// Policy The full policy that an agent should run after combining with local configuration/env vars.
 	Policy PolicyData `json:"policy"`
 }
@@ -234,11 +256,45 @@ type ActionPolicyReassign struct {
 type ActionRequestDiagnostics struct {
 	// AdditionalMetrics list optional additional metrics.
 	AdditionalMetrics *[]ActionRequestDiagnosticsAdditionalMetrics `json:"additional_metrics,omitempty"`
+
+	// UploadId The ID of the upload of the diagnostics bundle, set by fleet-server when the action is delivered.
+	UploadId *string `json:"upload_id,omitempty"`
+
+	// UploadPath The path of the endpoint the diagnostics bundle is uploaded to, set by fleet-server when the action is delivered.
+	UploadPath *string `json:"upload_path,omitempty"`
 }
 
 // ActionRequestDiagnosticsAdditionalMetrics defines model for ActionRequestDiagnostics.AdditionalMetrics.
 type ActionRequestDiagnosticsAdditionalMetrics string
 
+// ActionResultsResponse The results of an action aggregated over the agents it targets.
+// The agents that have not responded are pending until the action expires.
+type ActionResultsResponse struct {
+	// Acked The number of agents that completed the action.
+	Acked int `json:"acked"`
+
+	// ActionId The action ID.
+	ActionId string `json:"action_id"`
+
+	// Agents The status of each agent the action targets, ordered by agent ID. Only included when requested.
+	Agents *[]ActionAgentResult `json:"agents,omitempty"`
+
+	// Expired The number of agents that did not complete the action before it expired.
+	Expired int `json:"expired"`
+
+	// Failed The number of agents that reported an error.
+	Failed int `json:"failed"`
+
+	// NextFrom The offset of the next page of agents, not set on the last page.
+	NextFrom *int `json:"next_from,omitempty"`
+
+	// Pending The number of agents that have not responded to the action yet.
+	Pending int `json:"pending"`
+
+	// Total The number of agents the action targets.
+	Total int `json:"total"`
+}
+
 // ActionSettings The SETTINGS action data.
 type ActionSettings struct {
 	LogLevel *ActionSettingsLogLevel `json:"log_level,omitempty"`
@@ -259,6 +315,19 @@ type ActionSignature struct {
 // ActionUnenroll The UNENROLL action data.
 type ActionUnenroll = interface{}
 
+// ActionTarget A filter of the agents an action targets, evaluated when each agent checks in until the action expires.
+// An agent matches when it satisfies all the set predicates, the action is delivered to each matching agent once.
+type ActionTarget struct {
+	// PolicyId The ID of the policy the agent is assigned to.
+	PolicyId *string `json:"policy_id,omitempty"`
+
+	// Tags The tags the agent must all have.
+	Tags *[]string `json:"tags,omitempty"`
+
+	// Version The semver constraint the agent version must satisfy, the pre-release of the agent version is ignored.
+	Version *string `json:"version,omitempty"`
+}
+
 // ActionUpgrade the UPGRADE action data.
 type ActionUpgrade struct {
 	// SourceUri The source of the upgrade artifact.
@@ -268,6 +337,74 @@ type ActionUpgrade struct {
 	Version string `json:"version"`
 }
 
+// ActionV2 An action for an elastic-agent, in the checkin responses of the 2025-06-01 API version.
+// The data of the action is in the field named after its type in lower case, UNENROLL actions have no data.
+type ActionV2 struct {
+	// AgentId The agent ID.
+	AgentId string `json:"agent_id"`
+
+	// Cancel The CANCEL action data.
+	Cancel *ActionCancel `json:"cancel,omitempty"`
+
+	// CreatedAt Time when the action was created.
+	CreatedAt string `json:"created_at"`
+
+	// Expiration The latest start time for the action. Actions will be dropped by the agent if execution has not started by this time. Used for scheduled actions.
+	Expiration *string `json:"expiration,omitempty"`
+
+	// Id The action ID.
+	Id string `json:"id"`
+
+	// InputAction The INPUT_ACTION action data.
+	InputAction *ActionInputAction `json:"input_action,omitempty"`
+
+	// InputType The input type of the action for actions with type `INPUT_ACTION`.
+	InputType *string `json:"input_type,omitempty"`
+
+	// PolicyChange The POLICY_CHANGE action data.
+	PolicyChange *ActionPolicyChange `json:"policy_change,omitempty"`
+
+	// PolicyReassign The POLICY_REASSIGN action data.
+	PolicyReassign *ActionPolicyReassign `json:"policy_reassign,omitempty"`
+
+	// RequestDiagnostics The REQUEST_DIAGNOSTICS action data.
+	RequestDiagnostics *ActionRequestDiagnostics `json:"request_diagnostics,omitempty"`
+
+	// Settings The SETTINGS action data.
+	Settings *ActionSettings `json:"settings,omitempty"`
+
+	// Signed Optional action signing data.
+	Signed *ActionSignature `json:"signed,omitempty"`
+
+	// StartTime The earliest execution time for the action. Agent will not execute the action before this time. Used for scheduled actions.
+	StartTime *string `json:"start_time,omitempty"`
+
+	// Timeout The timeout value (in seconds) for actions with type `INPUT_ACTION`.
+	Timeout *int64 `json:"timeout,omitempty"`
+
+	// Traceparent APM traceparent for the action.
+	Traceparent *string `json:"traceparent,omitempty"`
+
+	// Type The action type, one of the types of action.
+	Type string `json:"type"`
+
+	// Upgrade the UPGRADE action data.
+	Upgrade *ActionUpgrade `json:"upgrade,omitempty"`
+}
+
+// AgentTagsRequest Request to add tags to an agent.
+type AgentTagsRequest struct {
+	// Tags The tags to add to the agent, tags the agent already has are ignored.
+	// The number of tags of an agent and the length of a tag are capped by the server.
+	Tags []string `json:"tags"`
+}
+
+// AgentTagsResponse The tags of an agent after they were updated.
+type AgentTagsResponse struct {
+	// Tags The tags of the agent.
+	Tags []string `json:"tags"`
+}
+
 // AuditUnenrollRequest Request to add unenroll audit information to an agent document.
 type AuditUnenrollRequest struct {
 	// Reason The unenroll reason
@@ -280,15 +417,81 @@ type AuditUnenrollRequest struct {
 // AuditUnenrollRequestReason The unenroll reason
 type AuditUnenrollRequestReason string
 
+// BulkEnrollAgent An agent of a bulk enroll request.
+type BulkEnrollAgent struct {
+	// Id The ID of the agent, generated when not set.
+	// The enrollment of the agent fails if an agent with the same ID exists, the agents are not replaced.
+	Id *string `json:"id,omitempty"`
+
+	// Metadata Metadata associated with the agent that is enrolling to fleet.
+	Metadata *EnrollMetadata `json:"metadata,omitempty"`
+
+	// PolicyId The ID of the policy the agent is enrolled in.
+	PolicyId string `json:"policy_id"`
+}
+
+// BulkEnrollRequest A request to enroll a batch of agents, for the provisioning systems registering many agents at once.
+type BulkEnrollRequest struct {
+	// Agents The agents to enroll.
+	// The number of agents is capped by the server.limits.max_bulk_enroll_agents setting.
+	Agents []BulkEnrollAgent `json:"agents"`
+}
+
+// BulkEnrollResult The result of the enrollment of an agent of a bulk enroll request.
+// The response is a stream of results, a JSON object per line, in batches of the order of the request.
+type BulkEnrollResult struct {
+	// AccessApiKey The ApiKey token that fleet-server has generated for the agent.
+	AccessApiKey *string `json:"access_api_key,omitempty"`
+
+	// AccessApiKeyId The id of the ApiKey that fleet-server has generated for the agent.
+	AccessApiKeyId *string `json:"access_api_key_id,omitempty"`
+
+	// Error The error type, when the agent is not enrolled.
+	Error *string `json:"error,omitempty"`
+
+	// Id The ID of the agent.
+	Id string `json:"id"`
+
+	// Index The position of the agent in the request.
+	Index int `json:"index"`
+
+	// Message The error message, when the agent is not enrolled.
+	Message *string `json:"message,omitempty"`
+
+	// PolicyId The ID of the policy of the agent.
+	PolicyId string `json:"policy_id"`
+
+	// Status The HTTP status of the enrollment of the agent, 201 when the agent is enrolled.
+	Status int `json:"status"`
+}
+
+// CheckinComponent A component the agent is running with its health and the health of its units.
+type CheckinComponent struct {
+	// Id The component ID.
+	Id string `json:"id"`
+
+	// Message The component status message.
+	Message *string `json:"message,omitempty"`
+
+	// Status The component status, for example HEALTHY, DEGRADED or FAILED.
+	Status string `json:"status"`
+
+	// Type The component type, for example filebeat or metricbeat.
+	Type  *string        `json:"type,omitempty"`
+	Units *[]CheckinUnit `json:"units,omitempty"`
+}
+
 // CheckinRequest defines model for checkinRequest.
 type CheckinRequest struct {
 	// AckToken The ack_token form a previous response if the agent has checked in before.
 	// Translated to a sequence number in fleet-server in order to retrieve any new actions for the agent from the last checkin.
-	AckToken *string `json:"ack_token,omitempty"`
+	AckToken *string `json:"ack_token,omitempty" validate:"max=512"`
 
-	// Components An embedded JSON object that holds component information that the agent is running.
+	// Components An embedded JSON array of the components the agent is running, each item is a checkinComponent.
 	// Defined in fleet-server as a `json.RawMessage`, defined as an object in the elastic-agent.
 	// fleet-server will update the components in an agent record if they differ from this object.
+	// The components are stored sorted by ID with their messages and total size capped, the components over the cap are replaced by a component with the fleet-server-truncated ID.
+	// The worst status of the components is reflected in the checkin status of the agent.
 	Components *json.RawMessage `json:"components,omitempty"`
 
 	// LocalMetadata An embedded JSON object that holds meta-data values.
@@ -298,7 +501,7 @@ type CheckinRequest struct {
 	LocalMetadata *json.RawMessage `json:"local_metadata,omitempty"`
 
 	// Message State message, may be overridden or use the error message of a failing component.
-	Message string `json:"message"`
+	Message string `json:"message" validate:"max=16384"`
 
 	// PollTimeout An optional timeout value that informs fleet-server of when a client will time out on it's checkin request.
 	// If not specified fleet-server will use the timeout values specified in the config (defaults to 5m polling and a 10m write timeout).
@@ -307,7 +510,8 @@ type CheckinRequest struct {
 	PollTimeout *string `json:"poll_timeout,omitempty"`
 
 	// Status The agent state, inferred from agent control protocol states.
-	Status CheckinRequestStatus `json:"status"`
+	// The statuses outside of the enum are accepted and logged, the agents may report states fleet-server does not know yet.
+	Status CheckinRequestStatus `json:"status" validate:"required"`
 
 	// UpgradeDetails Additional details describing the status of an UPGRADE action delivered by the client (agent) on checkin.
 	UpgradeDetails *UpgradeDetails `json:"upgrade_details,omitempty"`
@@ -326,6 +530,79 @@ type CheckinResponse struct {
 
 	// Actions A list of actions that the agent must execute.
 	Actions *[]Action `json:"actions,omitempty"`
+
+	// NextCheckinDelay The delay the agent should wait before its next checkin, as a duration such as 30s.
+	// The server raises it with its load to spread the checkins of the agents out.
+	NextCheckinDelay *string `json:"next_checkin_delay,omitempty"`
+}
+
+// CheckinResponseV2 The checkin response sent to the agents that negotiate the 2025-06-01 API version.
+// It holds the same fields as checkinResponse, the data of each action is in the field of its type.
+type CheckinResponseV2 struct {
+	// AckToken The acknowlegment token used to indicate action delivery.
+	AckToken *string `json:"ack_token,omitempty"`
+
+	// Action The action result. Set to "checkin".
+	Action string `json:"action"`
+
+	// Actions A list of actions that the agent must execute.
+	Actions *[]ActionV2 `json:"actions,omitempty"`
+
+	// NextCheckinDelay The delay the agent should wait before its next checkin, as a duration such as 30s.
+	// The server raises it with its load to spread the checkins of the agents out.
+	NextCheckinDelay *string `json:"next_checkin_delay,omitempty"`
+}
+
+// CheckinUnit A unit of a component, an input or an output, with its health.
+type CheckinUnit struct {
+	// Id The unit ID.
+	Id string `json:"id"`
+
+	// Message The unit status message.
+	Message *string `json:"message,omitempty"`
+
+	// Status The unit status, for example HEALTHY, DEGRADED or FAILED.
+	Status string `json:"status"`
+
+	// Type The unit type, input or output.
+	Type *string `json:"type,omitempty"`
+}
+
+// CreateActionsRequest Request to create an action targeting a list of agents, all agents enrolled in a policy, all agents with a tag, or the agents matching a filter.
+type CreateActionsRequest struct {
+	// Agents The IDs of the agents the action targets. Mutually exclusive with policy_id, tag and target.
+	Agents *[]string `json:"agents,omitempty"`
+
+	// Data The action payload.
+	// Defined in fleet-server as a `json.RawMessage`; the contents depend on the action type.
+	Data *json.RawMessage `json:"data,omitempty"`
+
+	// Expiration The action expiration date/time. Agents will not receive the action after this time.
+	Expiration *time.Time `json:"expiration,omitempty"`
+
+	// InputType The input type the action should be routed to, used with INPUT_ACTION actions.
+	InputType *string `json:"input_type,omitempty"`
+
+	// PolicyId The ID of a policy; the action targets all active agents enrolled in it. Mutually exclusive with agents, tag and target.
+	// The number of targeted agents is capped by the server.limits.max_action_targets setting.
+	PolicyId *string `json:"policy_id,omitempty"`
+
+	// Tag A tag; the action targets all active agents with the tag. Mutually exclusive with agents, policy_id and target.
+	// The number of targeted agents is capped by the server.limits.max_action_targets setting.
+	Tag *string `json:"tag,omitempty"`
+
+	// Target A filter of the agents the action targets. Mutually exclusive with agents, policy_id and tag.
+	// A single action document is created, it is not capped by the server.limits.max_action_targets setting.
+	Target *ActionTarget `json:"target,omitempty"`
+
+	// Type The action type.
+	Type string `json:"type"`
+}
+
+// CreateActionsAPIResponse Response to a create actions request.
+type CreateActionsAPIResponse struct {
+	// ActionIds The ID of the created action, it is shared by the action documents the targeted agents are split into.
+	ActionIds []string `json:"action_ids"`
 }
 
 // DiagnosticsEvent defines model for diagnosticsEvent.
@@ -345,6 +622,9 @@ type DiagnosticsEvent struct {
 	// For some actions (such as UPGRADE actions) it may result in the action being marked as failed.
 	Error *string `json:"error,omitempty"`
 
+	// ErrorCode An optional code that categorizes the error. Only used when the error attribute is set.
+	ErrorCode *string `json:"error_code,omitempty"`
+
 	// Message An acknowlegement message. The elastic-agent inserts the action ID and action type into this message.
 	Message string `json:"message"`
 
@@ -381,7 +661,7 @@ type EnrollMetadata struct {
 
 	// Tags User provided tags for the agent.
 	// fleet-server will pass the tags to the agent record on enrollment.
-	Tags []string `json:"tags"`
+	Tags []string `json:"tags" validate:"max=100"`
 
 	// UserProvided An embedded JSON object that holds user-provided meta-data values.
 	// Defined in fleet-server as a `json.RawMessage`.
@@ -397,14 +677,14 @@ type EnrollRequest struct {
 	// EnrollmentId The enrollment ID of the agent.
 	// To replace an agent on enroll fail.
 	// The existing agent with a matching enrollment_id will be deleted if it never checked in. The new agent will be enrolled with the enrollment_id.
-	EnrollmentId *string `json:"enrollment_id,omitempty"`
+	EnrollmentId *string `json:"enrollment_id,omitempty" validate:"max=512"`
 
 	// Id The ID of the agent.
 	// This is the ID that will be used to reference this agent, if no ID is passed one will be generated.
 	// If another agent is enrolled with the same ID the other agent will no longer be able to communicate,
 	// this new agent is considered a replacement of the other agent. The other agent will be able to continue
 	// sending data to ES.
-	Id *string `json:"id,omitempty"`
+	Id *string `json:"id,omitempty" validate:"max=512"`
 
 	// Metadata Metadata associated with the agent that is enrolling to fleet.
 	Metadata EnrollMetadata `json:"metadata"`
@@ -412,14 +692,14 @@ type EnrollRequest struct {
 	// ReplaceToken The replacement token of the agent.
 	// Provided when an agent could replace an existing agent. This token must match the original enrollment of
 	// that agent otherwise it will not be able to enroll.
-	ReplaceToken *string `json:"replace_token,omitempty"`
+	ReplaceToken *string `json:"replace_token,omitempty" validate:"max=1024"`
 
 	// SharedId The shared ID of the agent.
 	// To support pre-existing installs.
 	//
 	// Never implemented.
 	// Deprecated:
-	SharedId *string `json:"shared_id,omitempty"`
+	SharedId *string `json:"shared_id,omitempty" validate:"max=512"`
 
 	// Type The enrollment type of the agent.
 	// The agent only supports the PERMANENT value.
@@ -531,18 +811,21 @@ type EventType string
 // GenericEvent A generic ack event for an action. Includes an optional error attribute.
 type GenericEvent struct {
 	// ActionId The action ID.
-	ActionId string `json:"action_id"`
+	ActionId string `json:"action_id" validate:"max=512"`
 
 	// AgentId The ID of the agent that executed the action.
-	AgentId string `json:"agent_id"`
+	AgentId string `json:"agent_id" validate:"max=512"`
 
 	// Error An error message.
 	// If this is non-empty an error has occured when executing the action.
 	// For some actions (such as UPGRADE actions) it may result in the action being marked as failed.
 	Error *string `json:"error,omitempty"`
 
+	// ErrorCode An optional code that categorizes the error. Only used when the error attribute is set.
+	ErrorCode *string `json:"error_code,omitempty"`
+
 	// Message An acknowlegement message. The elastic-agent inserts the action ID and action type into this message.
-	Message string `json:"message"`
+	Message string `json:"message" validate:"max=16384"`
 
 	// Subtype The subtype of the ack event.
 	// The elastic-agent will only generate ACKNOWLEDGED events.
@@ -591,6 +874,9 @@ type InputEvent struct {
 	// For some actions (such as UPGRADE actions) it may result in the action being marked as failed.
 	Error *string `json:"error,omitempty"`
 
+	// ErrorCode An optional code that categorizes the error. Only used when the error attribute is set.
+	ErrorCode *string `json:"error_code,omitempty"`
+
 	// Message An acknowlegement message. The elastic-agent inserts the action ID and action type into this message.
 	Message string `json:"message"`
 
@@ -619,6 +905,45 @@ type InputEvent struct {
 	Type EventType `json:"type"`
 }
 
+// OperationResponse The progress of a batch operation updating agents.
+type OperationResponse struct {
+	// Cursor The position of the next page of agents.
+	Cursor *string `json:"cursor,omitempty"`
+
+	// Error The error that interrupted the operation.
+	Error *string `json:"error,omitempty"`
+
+	// Failed The number of agents that could not be updated.
+	Failed int `json:"failed"`
+
+	// Filter The parameters selecting the agents of the operation.
+	Filter *map[string]string `json:"filter,omitempty"`
+
+	// Id The operation ID.
+	Id string `json:"id"`
+
+	// Resumed The number of times the operation was resumed after an interruption.
+	Resumed *int `json:"resumed,omitempty"`
+
+	// StartedAt The time the operation started.
+	StartedAt time.Time `json:"started_at"`
+
+	// Status The status of the operation, running or completed. An interrupted operation stays running until it is resumed.
+	Status string `json:"status"`
+
+	// Succeeded The number of agents that were updated.
+	Succeeded int `json:"succeeded"`
+
+	// Total The number of agents processed.
+	Total int `json:"total"`
+
+	// Type The type of the operation, one of offline_agents, stale_unenrollments or reassign.
+	Type string `json:"type"`
+
+	// UpdatedAt The time of the last progress of the operation.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 // PolicyData The full policy that an agent should run after combining with local configuration/env vars.
 type PolicyData struct {
 	// Agent Agent configuration details associated with the policy. May include configuration toggling monitoring, uninstallation protection, etc.
@@ -649,11 +974,114 @@ type PolicyData struct {
 	Signed *ActionSignature `json:"signed,omitempty" yaml:"signed"`
 }
 
+// PolicyFetch Set when the policy revision is larger than the max size of the policies delivered in the checkin responses.
+// The policy of the action then only holds the id, revision, outputs and secret_paths of the policy, the agent fetches the other fields from the policy endpoint.
+type PolicyFetch struct {
+	// Path The path of the policy endpoint serving the revision.
+	Path string `json:"path"`
+
+	// Size The size of the policy returned by the policy endpoint, in bytes.
+	Size int `json:"size"`
+}
+
+// PolicyRevisionAgents The number of agents on a revision of a policy.
+type PolicyRevisionAgents struct {
+	// Agents The number of agents on the revision.
+	Agents int `json:"agents"`
+
+	// RevisionIdx The revision of the policy.
+	RevisionIdx int64 `json:"revision_idx"`
+}
+
+// PolicyRolloutAgent An agent that is not on the latest revision of its policy.
+type PolicyRolloutAgent struct {
+	// AgentId The agent ID.
+	AgentId string `json:"agent_id"`
+
+	// LastCheckin The time of the last checkin of the agent.
+	LastCheckin *time.Time `json:"last_checkin,omitempty"`
+
+	// RevisionIdx The revision of the policy the agent runs.
+	RevisionIdx int64 `json:"revision_idx"`
+}
+
+// PolicyRolloutResponse The distribution of the active agents of a policy over its revisions during a rollout.
+// The counts are aggregated periodically and may be up to a minute old.
+type PolicyRolloutResponse struct {
+	// PolicyId The policy ID.
+	PolicyId string `json:"policy_id"`
+
+	// RevisionCreatedAt The time the latest revision of the policy was created.
+	RevisionCreatedAt *time.Time `json:"revision_created_at,omitempty"`
+
+	// RevisionIdx The latest revision of the policy.
+	RevisionIdx int64 `json:"revision_idx"`
+
+	// Revisions The number of agents on each revision of the policy, the latest revision first.
+	Revisions []PolicyRevisionAgents `json:"revisions"`
+
+	// Stuck The number of agents that are on an older revision than the latest one for longer than the stuck threshold.
+	Stuck int `json:"stuck"`
+
+	// StuckAgents The stuck agents on the oldest revisions, up to 100 agents.
+	StuckAgents []PolicyRolloutAgent `json:"stuck_agents"`
+
+	// Total The number of active agents enrolled in the policy.
+	Total int `json:"total"`
+
+	// UpdatedAt The time the agents were counted.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ReassignAgentsRequest Request to assign a list of agents, or the agents enrolled in a policy, to another policy.
+type ReassignAgentsRequest struct {
+	// Agents The IDs of the agents to reassign. Mutually exclusive with source_policy_id.
+	Agents *[]string `json:"agents,omitempty"`
+
+	// OperationId The ID of the operation tracking the progress of the request, generated when not set.
+	// A request retried with the ID of an interrupted operation resumes it from its last page of agents,
+	// and a request retried with the ID of a completed operation returns its outcome without updating the agents again.
+	OperationId *string `json:"operation_id,omitempty"`
+
+	// PolicyId The ID of the policy the agents are assigned to. The policy must exist.
+	PolicyId string `json:"policy_id"`
+
+	// SourcePolicyId The ID of a policy; all active agents enrolled in it are reassigned. Mutually exclusive with agents.
+	SourcePolicyId *string `json:"source_policy_id,omitempty"`
+
+	// Tag Only reassign the agents of source_policy_id with the tag.
+	Tag *string `json:"tag,omitempty"`
+}
+
+// ReassignAgentsAPIResponse The outcome of a reassign agents request.
+type ReassignAgentsAPIResponse struct {
+	// Failed The number of agents that could not be updated.
+	Failed int `json:"failed"`
+
+	// OperationId The ID of the operation tracking the progress of the request.
+	OperationId string `json:"operation_id"`
+
+	// PolicyId The ID of the policy the agents are assigned to.
+	PolicyId string `json:"policy_id"`
+
+	// Reassigned The number of agents that were assigned to the policy.
+	Reassigned int `json:"reassigned"`
+
+	// Total The number of agents the request selected.
+	Total int `json:"total"`
+}
+
 // StatusAPIResponse Status response information.
 type StatusAPIResponse struct {
 	// Name Service name.
 	Name string `json:"name"`
 
+	// Outputs Health of the remote Elasticsearch outputs by name, included in the response to an authorized status request.
+	Outputs *map[string]StatusResponseOutput `json:"outputs,omitempty"`
+
+	// Proxy Health of the proxy in front of Elasticsearch included in the response to an authorized status request.
+	Proxy *StatusResponseProxy `json:"proxy,omitempty"`
+
 	// Status A Unit state that fleet-server may report.
 	// Unit state is defined in the elastic-agent-client specification.
 	Status StatusResponseStatus `json:"status"`
@@ -662,6 +1090,33 @@ type StatusAPIResponse struct {
 	Version *StatusResponseVersion `json:"version,omitempty"`
 }
 
+// StatusResponseOutput Health of a remote Elasticsearch output included in the response to an authorized status request.
+type StatusResponseOutput struct {
+	// Message The reason of a degraded state.
+	Message *string `json:"message,omitempty"`
+
+	// State The last known health state of the output, healthy or degraded.
+	State string `json:"state"`
+
+	// UpdatedAt The date-time the state was last reported.
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// StatusResponseProxy Health of the proxy in front of Elasticsearch included in the response to an authorized status request.
+type StatusResponseProxy struct {
+	// Message The failure of the proxy of a degraded state.
+	Message *string `json:"message,omitempty"`
+
+	// State The health state of the proxy reported by the last request sent through it, healthy or degraded.
+	State string `json:"state"`
+
+	// UpdatedAt The date-time the state was last reported.
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+
+	// Url The URL of the proxy, without its password.
+	Url string `json:"url"`
+}
+
 // StatusResponseStatus A Unit state that fleet-server may report.
 // Unit state is defined in the elastic-agent-client specification.
 type StatusResponseStatus string
@@ -691,6 +1146,9 @@ type UpgradeEvent struct {
 	// For some actions (such as UPGRADE actions) it may result in the action being marked as failed.
 	Error *string `json:"error,omitempty"`
 
+	// ErrorCode An optional code that categorizes the error. Only used when the error attribute is set.
+	ErrorCode *string `json:"error_code,omitempty"`
+
 	// Message An acknowlegement message. The elastic-agent inserts the action ID and action type into this message.
 	Message string `json:"message"`
 
@@ -730,7 +1188,7 @@ type UpgradeEvent struct {
 // UpgradeDetails Additional details describing the status of an UPGRADE action delivered by the client (agent) on checkin.
 type UpgradeDetails struct {
 	// ActionId The upgrade action ID the details are associated with.
-	ActionId string `json:"action_id"`
+	ActionId string `json:"action_id" validate:"max=512"`
 
 	// Metadata Upgrade status metadata. Determined by state.
 	Metadata *UpgradeDetails_Metadata `json:"metadata,omitempty"`
@@ -739,7 +1197,7 @@ type UpgradeDetails struct {
 	State UpgradeDetailsState `json:"state"`
 
 	// TargetVersion The version the agent should upgrade to.
-	TargetVersion string `json:"target_version"`
+	TargetVersion string `json:"target_version" validate:"max=256"`
 }
 
 // UpgradeDetails_Metadata Upgrade status metadata. Determined by state.
@@ -843,12 +1301,18 @@ type UploadCompleteRequest struct {
 // ApiVersion defines model for apiVersion.
 type ApiVersion = string
 
+// IfNoneMatch defines model for ifNoneMatch.
+type IfNoneMatch = string
+
 // RequestId defines model for requestId.
 type RequestId = string
 
 // UserAgent defines model for userAgent.
 type UserAgent = string
 
+// ActionNotFound Error processing request.
+type ActionNotFound = Error
+
 // AgentNotFound Error processing request.
 type AgentNotFound = Error
 
@@ -870,6 +1334,12 @@ type InternalServerError = Error
 // KeyNotEnabled Error processing request.
 type KeyNotEnabled = Error
 
+// OperationNotFound Error processing request.
+type OperationNotFound = Error
+
+// PolicyNotFound Error processing request.
+type PolicyNotFound = Error
+
 // Throttle Error processing request.
 type Throttle = Error
 
@@ -885,6 +1355,42 @@ type GetPGPKeyParams struct {
 	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
 }
 
+// CreateActionsParams defines parameters for CreateActions.
+type CreateActionsParams struct {
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+}
+
+// GetActionResultsParams defines parameters for GetActionResults.
+type GetActionResultsParams struct {
+	// Agents Include the status of each agent in the response.
+	Agents *bool `form:"agents,omitempty" json:"agents,omitempty"`
+
+	// From The offset of the first agent to include.
+	From *int `form:"from,omitempty" json:"from,omitempty"`
+
+	// Size The max number of agents to include.
+	Size *int `form:"size,omitempty" json:"size,omitempty"`
+
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+}
+
+// BulkEnrollParams defines parameters for BulkEnroll.
+type BulkEnrollParams struct {
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+}
+
 // AgentEnrollParams defines parameters for AgentEnroll.
 type AgentEnrollParams struct {
 	// UserAgent The user-agent header that is sent.
@@ -897,6 +1403,20 @@ type AgentEnrollParams struct {
 
 	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
 	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+
+	// IdempotencyKey A key identifying the enrollment, for clients that retry the enroll request after a timeout.
+	// A retry with the same key and body replays the enrollment of the same agent instead of enrolling another one, until the key expires.
+	// A request reusing the key with a different body, or while a request with the same key is in progress, is rejected with a 409.
+	IdempotencyKey *string `json:"Idempotency-Key,omitempty"`
+}
+
+// ReassignAgentsParams defines parameters for ReassignAgents.
+type ReassignAgentsParams struct {
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
 }
 
 // AgentAcksParams defines parameters for AgentAcks.
@@ -936,8 +1456,8 @@ type AgentCheckinParams struct {
 	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
 }
 
-// ArtifactParams defines parameters for Artifact.
-type ArtifactParams struct {
+// AddAgentTagsParams defines parameters for AddAgentTags.
+type AddAgentTagsParams struct {
 	// XRequestId The request tracking ID for APM.
 	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
 
@@ -945,17 +1465,73 @@ type ArtifactParams struct {
 	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
 }
 
-// GetFileParams defines parameters for GetFile.
-type GetFileParams struct {
-	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
-	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
-
+// RemoveAgentTagParams defines parameters for RemoveAgentTag.
+type RemoveAgentTagParams struct {
 	// XRequestId The request tracking ID for APM.
 	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
 }
 
-// UploadBeginParams defines parameters for UploadBegin.
-type UploadBeginParams struct {
+// ArtifactParams defines parameters for Artifact.
+type ArtifactParams struct {
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+
+	// IfNoneMatch The entity tags of the response the client already has, as sent in the ETag header of a previous response.
+	// A response matching one of them is replaced by a 304 without body. Malformed entity tags are ignored.
+	IfNoneMatch *IfNoneMatch `json:"If-None-Match,omitempty"`
+}
+
+// GetFileParams defines parameters for GetFile.
+type GetFileParams struct {
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+}
+
+// GetOperationParams defines parameters for GetOperation.
+type GetOperationParams struct {
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+}
+
+// GetPolicyRolloutParams defines parameters for GetPolicyRollout.
+type GetPolicyRolloutParams struct {
+	// StuckThreshold The time after which the agents that are not on the latest revision of the policy are stuck, as a duration such as 15m.
+	StuckThreshold *string `form:"stuck_threshold,omitempty" json:"stuck_threshold,omitempty"`
+
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+}
+
+// GetPolicyParams defines parameters for GetPolicy.
+type GetPolicyParams struct {
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+
+	// IfNoneMatch The entity tags of the response the client already has, as sent in the ETag header of a previous response.
+	// A response matching one of them is replaced by a 304 without body. Malformed entity tags are ignored.
+	IfNoneMatch *IfNoneMatch `json:"If-None-Match,omitempty"`
+}
+
+// UploadBeginParams defines parameters for UploadBegin.
+type UploadBeginParams struct {
 	// XRequestId The request tracking ID for APM.
 	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
 
@@ -993,9 +1569,18 @@ type StatusParams struct {
 	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
 }
 
+// CreateActionsJSONRequestBody defines body for CreateActions for application/json ContentType.
+type CreateActionsJSONRequestBody = CreateActionsRequest
+
+// BulkEnrollJSONRequestBody defines body for BulkEnroll for application/json ContentType.
+type BulkEnrollJSONRequestBody = BulkEnrollRequest
+
 // AgentEnrollJSONRequestBody defines body for AgentEnroll for application/json ContentType.
 type AgentEnrollJSONRequestBody = EnrollRequest
 
+// ReassignAgentsJSONRequestBody defines body for ReassignAgents for application/json ContentType.
+type ReassignAgentsJSONRequestBody = ReassignAgentsRequest
+
 // AgentAcksJSONRequestBody defines body for AgentAcks for application/json ContentType.
 type AgentAcksJSONRequestBody = AckRequest
 
@@ -1005,6 +1590,9 @@ type AuditUnenrollJSONRequestBody = AuditUnenrollRequest
 // AgentCheckinJSONRequestBody defines body for AgentCheckin for application/json ContentType.
 type AgentCheckinJSONRequestBody = CheckinRequest
 
+// AddAgentTagsJSONRequestBody defines body for AddAgentTags for application/json ContentType.
+type AddAgentTagsJSONRequestBody = AgentTagsRequest
+
 // UploadBeginJSONRequestBody defines body for UploadBegin for application/json ContentType.
 type UploadBeginJSONRequestBody = UploadBeginRequest
 
@@ -1663,9 +2251,21 @@ type ServerInterface interface {
 	// retrieve a PGP key from the fleet-server's local storage.
 	// (GET /api/agents/upgrades/{major}.{minor}.{patch}/pgp-public-key)
 	GetPGPKey(w http.ResponseWriter, r *http.Request, major int, minor int, patch int, params GetPGPKeyParams)
+	// Create an action targeting agents.
+	// (POST /api/fleet/agents/actions)
+	CreateActions(w http.ResponseWriter, r *http.Request, params CreateActionsParams)
+	// Get the results of an action.
+	// (GET /api/fleet/agents/actions/{id}/results)
+	GetActionResults(w http.ResponseWriter, r *http.Request, id string, params GetActionResultsParams)
+	// Enroll a batch of agents.
+	// (POST /api/fleet/agents/bulk_enroll)
+	BulkEnroll(w http.ResponseWriter, r *http.Request, params BulkEnrollParams)
 
 	// (POST /api/fleet/agents/enroll)
 	AgentEnroll(w http.ResponseWriter, r *http.Request, params AgentEnrollParams)
+	// Assign agents to another policy.
+	// (POST /api/fleet/agents/reassign)
+	ReassignAgents(w http.ResponseWriter, r *http.Request, params ReassignAgentsParams)
 
 	// (POST /api/fleet/agents/{id}/acks)
 	AgentAcks(w http.ResponseWriter, r *http.Request, id string, params AgentAcksParams)
@@ -1676,11 +2276,30 @@ type ServerInterface interface {
 	// (POST /api/fleet/agents/{id}/checkin)
 	AgentCheckin(w http.ResponseWriter, r *http.Request, id string, params AgentCheckinParams)
 
+	// Add tags to an agent.
+	// (POST /api/fleet/agents/{id}/tags)
+	AddAgentTags(w http.ResponseWriter, r *http.Request, id string, params AddAgentTagsParams)
+	// Remove a tag from an agent.
+	// (DELETE /api/fleet/agents/{id}/tags/{tag})
+	RemoveAgentTag(w http.ResponseWriter, r *http.Request, id string, tag string, params RemoveAgentTagParams)
+
 	// (GET /api/fleet/artifacts/{id}/{sha2})
 	Artifact(w http.ResponseWriter, r *http.Request, id string, sha2 string, params ArtifactParams)
 	// retrieve stored file for integration
 	// (GET /api/fleet/file/{id})
 	GetFile(w http.ResponseWriter, r *http.Request, id string, params GetFileParams)
+	// Check that fleet-server is able to serve requests.
+	// (GET /api/fleet/healthz)
+	Healthz(w http.ResponseWriter, r *http.Request)
+	// Get the progress of a batch operation.
+	// (GET /api/fleet/operations/{id})
+	GetOperation(w http.ResponseWriter, r *http.Request, id string, params GetOperationParams)
+	// Get the rollout of a policy.
+	// (GET /api/fleet/policies/{id}/rollout)
+	GetPolicyRollout(w http.ResponseWriter, r *http.Request, id string, params GetPolicyRolloutParams)
+	// Get a policy revision.
+	// (GET /api/fleet/policies/{id}/{revision})
+	GetPolicy(w http.ResponseWriter, r *http.Request, id string, revision int64, params GetPolicyParams)
 	// Initiate a file upload process
 	// (POST /api/fleet/uploads)
 	UploadBegin(w http.ResponseWriter, r *http.Request, params UploadBeginParams)
@@ -1705,11 +2324,35 @@ func (_ Unimplemented) GetPGPKey(w http.ResponseWriter, r *http.Request, major i
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
+// Create an action targeting agents.
+// (POST /api/fleet/agents/actions)
+func (_ Unimplemented) CreateActions(w http.ResponseWriter, r *http.Request, params CreateActionsParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Get the results of an action.
+// (GET /api/fleet/agents/actions/{id}/results)
+func (_ Unimplemented) GetActionResults(w http.ResponseWriter, r *http.Request, id string, params GetActionResultsParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Enroll a batch of agents.
+// (POST /api/fleet/agents/bulk_enroll)
+func (_ Unimplemented) BulkEnroll(w http.ResponseWriter, r *http.Request, params BulkEnrollParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
 // (POST /api/fleet/agents/enroll)
 func (_ Unimplemented) AgentEnroll(w http.ResponseWriter, r *http.Request, params AgentEnrollParams) {
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
+// Assign agents to another policy.
+// (POST /api/fleet/agents/reassign)
+func (_ Unimplemented) ReassignAgents(w http.ResponseWriter, r *http.Request, params ReassignAgentsParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
 // (POST /api/fleet/agents/{id}/acks)
 func (_ Unimplemented) AgentAcks(w http.ResponseWriter, r *http.Request, id string, params AgentAcksParams) {
 	w.WriteHeader(http.StatusNotImplemented)
@@ -1726,6 +2369,18 @@ func (_ Unimplemented) AgentCheckin(w http.ResponseWriter, r *http.Request, id s
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
+// Add tags to an agent.
+// (POST /api/fleet/agents/{id}/tags)
+func (_ Unimplemented) AddAgentTags(w http.ResponseWriter, r *http.Request, id string, params AddAgentTagsParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Remove a tag from an agent.
+// (DELETE /api/fleet/agents/{id}/tags/{tag})
+func (_ Unimplemented) RemoveAgentTag(w http.ResponseWriter, r *http.Request, id string, tag string, params RemoveAgentTagParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
 // (GET /api/fleet/artifacts/{id}/{sha2})
 func (_ Unimplemented) Artifact(w http.ResponseWriter, r *http.Request, id string, sha2 string, params ArtifactParams) {
 	w.WriteHeader(http.StatusNotImplemented)
@@ -1737,6 +2392,30 @@ func (_ Unimplemented) GetFile(w http.ResponseWriter, r *http.Request, id string
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
+// Check that fleet-server is able to serve requests.
+// (GET /api/fleet/healthz)
+func (_ Unimplemented) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Get the progress of a batch operation.
+// (GET /api/fleet/operations/{id})
+func (_ Unimplemented) GetOperation(w http.ResponseWriter, r *http.Request, id string, params GetOperationParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Get the rollout of a policy.
+// (GET /api/fleet/policies/{id}/rollout)
+func (_ Unimplemented) GetPolicyRollout(w http.ResponseWriter, r *http.Request, id string, params GetPolicyRolloutParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Get a policy revision.
+// (GET /api/fleet/policies/{id}/{revision})
+func (_ Unimplemented) GetPolicy(w http.ResponseWriter, r *http.Request, id string, revision int64, params GetPolicyParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
 // Initiate a file upload process
 // (POST /api/fleet/uploads)
 func (_ Unimplemented) UploadBegin(w http.ResponseWriter, r *http.Request, params UploadBeginParams) {
@@ -1812,18 +2491,641 @@ func (siw *ServerInterfaceWrapper) GetPGPKey(w http.ResponseWriter, r *http.Requ
 		var ElasticApiVersion ApiVersion
 		n := len(valueList)
 		if n != 1 {
-			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "elastic-api-version", Count: n})
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "elastic-api-version", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, valueList[0], &ElasticApiVersion)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "elastic-api-version", Err: err})
+			return
+		}
+
+		params.ElasticApiVersion = &ElasticApiVersion
+
+	}
+
+	// ------------- Optional header parameter "X-Request-Id" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("X-Request-Id")]; found {
+		var XRequestId RequestId
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "X-Request-Id", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, valueList[0], &XRequestId)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "X-Request-Id", Err: err})
+			return
+		}
+
+		params.XRequestId = &XRequestId
+
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetPGPKey(w, r, major, minor, patch, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// CreateActions operation middleware
+func (siw *ServerInterfaceWrapper) CreateActions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	ctx = context.WithValue(ctx, AdminApiKeyScopes, []string{})
+
+	ctx = context.WithValue(ctx, ServiceTokenScopes, []string{})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params CreateActionsParams
+
+	headers := r.Header
+
+	// ------------- Optional header parameter "X-Request-Id" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("X-Request-Id")]; found {
+		var XRequestId RequestId
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "X-Request-Id", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, valueList[0], &XRequestId)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "X-Request-Id", Err: err})
+			return
+		}
+
+		params.XRequestId = &XRequestId
+
+	}
+
+	// ------------- Optional header parameter "elastic-api-version" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("elastic-api-version")]; found {
+		var ElasticApiVersion ApiVersion
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "elastic-api-version", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, valueList[0], &ElasticApiVersion)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "elastic-api-version", Err: err})
+			return
+		}
+
+		params.ElasticApiVersion = &ElasticApiVersion
+
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.CreateActions(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// GetActionResults operation middleware
+func (siw *ServerInterfaceWrapper) GetActionResults(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, chi.URLParam(r, "id"), &id)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
+		return
+	}
+
+	ctx = context.WithValue(ctx, ServiceTokenScopes, []string{})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetActionResultsParams
+
+	// ------------- Optional query parameter "agents" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "agents", r.URL.Query(), &params.Agents)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "agents", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "from" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "from", r.URL.Query(), &params.From)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "from", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "size" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "size", r.URL.Query(), &params.Size)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "size", Err: err})
+		return
+	}
+
+	headers := r.Header
+
+	// ------------- Optional header parameter "X-Request-Id" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("X-Request-Id")]; found {
+		var XRequestId RequestId
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "X-Request-Id", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, valueList[0], &XRequestId)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "X-Request-Id", Err: err})
+			return
+		}
+
+		params.XRequestId = &XRequestId
+
+	}
+
+	// ------------- Optional header parameter "elastic-api-version" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("elastic-api-version")]; found {
+		var ElasticApiVersion ApiVersion
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "elastic-api-version", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, valueList[0], &ElasticApiVersion)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "elastic-api-version", Err: err})
+			return
+		}
+
+		params.ElasticApiVersion = &ElasticApiVersion
+
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetActionResults(w, r, id, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// BulkEnroll operation middleware
+func (siw *ServerInterfaceWrapper) BulkEnroll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	ctx = context.WithValue(ctx, ServiceTokenScopes, []string{})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params BulkEnrollParams
+
+	headers := r.Header
+
+	// ------------- Optional header parameter "X-Request-Id" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("X-Request-Id")]; found {
+		var XRequestId RequestId
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "X-Request-Id", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, valueList[0], &XRequestId)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "X-Request-Id", Err: err})
+			return
+		}
+
+		params.XRequestId = &XRequestId
+
+	}
+
+	// ------------- Optional header parameter "elastic-api-version" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("elastic-api-version")]; found {
+		var ElasticApiVersion ApiVersion
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "elastic-api-version", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, valueList[0], &ElasticApiVersion)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "elastic-api-version", Err: err})
+			return
+		}
+
+		params.ElasticApiVersion = &ElasticApiVersion
+
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.BulkEnroll(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// AgentEnroll operation middleware
+func (siw *ServerInterfaceWrapper) AgentEnroll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	ctx = context.WithValue(ctx, ApiKeyScopes, []string{})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params AgentEnrollParams
+
+	headers := r.Header
+
+	// ------------- Required header parameter "User-Agent" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("User-Agent")]; found {
+		var UserAgent UserAgent
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "User-Agent", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "User-Agent", runtime.ParamLocationHeader, valueList[0], &UserAgent)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "User-Agent", Err: err})
+			return
+		}
+
+		params.UserAgent = UserAgent
+
+	} else {
+		err := fmt.Errorf("Header parameter User-Agent is required, but not found")
+		siw.ErrorHandlerFunc(w, r, &RequiredHeaderError{ParamName: "User-Agent", Err: err})
+		return
+	}
+
+	// ------------- Optional header parameter "X-Request-Id" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("X-Request-Id")]; found {
+		var XRequestId RequestId
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "X-Request-Id", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, valueList[0], &XRequestId)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "X-Request-Id", Err: err})
+			return
+		}
+
+		params.XRequestId = &XRequestId
+
+	}
+
+	// ------------- Optional header parameter "elastic-api-version" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("elastic-api-version")]; found {
+		var ElasticApiVersion ApiVersion
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "elastic-api-version", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, valueList[0], &ElasticApiVersion)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "elastic-api-version", Err: err})
+			return
+		}
+
+		params.ElasticApiVersion = &ElasticApiVersion
+
+	}
+
+	// ------------- Optional header parameter "Idempotency-Key" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("Idempotency-Key")]; found {
+		var IdempotencyKey string
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "Idempotency-Key", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "Idempotency-Key", runtime.ParamLocationHeader, valueList[0], &IdempotencyKey)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "Idempotency-Key", Err: err})
+			return
+		}
+
+		params.IdempotencyKey = &IdempotencyKey
+
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.AgentEnroll(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// ReassignAgents operation middleware
+func (siw *ServerInterfaceWrapper) ReassignAgents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	ctx = context.WithValue(ctx, ServiceTokenScopes, []string{})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params ReassignAgentsParams
+
+	headers := r.Header
+
+	// ------------- Optional header parameter "X-Request-Id" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("X-Request-Id")]; found {
+		var XRequestId RequestId
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "X-Request-Id", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, valueList[0], &XRequestId)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "X-Request-Id", Err: err})
+			return
+		}
+
+		params.XRequestId = &XRequestId
+
+	}
+
+	// ------------- Optional header parameter "elastic-api-version" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("elastic-api-version")]; found {
+		var ElasticApiVersion ApiVersion
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "elastic-api-version", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, valueList[0], &ElasticApiVersion)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "elastic-api-version", Err: err})
+			return
+		}
+
+		params.ElasticApiVersion = &ElasticApiVersion
+
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ReassignAgents(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// AgentAcks operation middleware
+func (siw *ServerInterfaceWrapper) AgentAcks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, chi.URLParam(r, "id"), &id)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
+		return
+	}
+
+	ctx = context.WithValue(ctx, AgentApiKeyScopes, []string{})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params AgentAcksParams
+
+	headers := r.Header
+
+	// ------------- Optional header parameter "X-Request-Id" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("X-Request-Id")]; found {
+		var XRequestId RequestId
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "X-Request-Id", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, valueList[0], &XRequestId)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "X-Request-Id", Err: err})
+			return
+		}
+
+		params.XRequestId = &XRequestId
+
+	}
+
+	// ------------- Optional header parameter "elastic-api-version" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("elastic-api-version")]; found {
+		var ElasticApiVersion ApiVersion
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "elastic-api-version", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, valueList[0], &ElasticApiVersion)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "elastic-api-version", Err: err})
+			return
+		}
+
+		params.ElasticApiVersion = &ElasticApiVersion
+
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.AgentAcks(w, r, id, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// AuditUnenroll operation middleware
+func (siw *ServerInterfaceWrapper) AuditUnenroll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, chi.URLParam(r, "id"), &id)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
+		return
+	}
+
+	ctx = context.WithValue(ctx, AgentApiKeyScopes, []string{})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params AuditUnenrollParams
+
+	headers := r.Header
+
+	// ------------- Optional header parameter "X-Request-Id" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("X-Request-Id")]; found {
+		var XRequestId RequestId
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "X-Request-Id", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, valueList[0], &XRequestId)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "X-Request-Id", Err: err})
+			return
+		}
+
+		params.XRequestId = &XRequestId
+
+	}
+
+	// ------------- Optional header parameter "elastic-api-version" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("elastic-api-version")]; found {
+		var ElasticApiVersion ApiVersion
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "elastic-api-version", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, valueList[0], &ElasticApiVersion)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "elastic-api-version", Err: err})
+			return
+		}
+
+		params.ElasticApiVersion = &ElasticApiVersion
+
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.AuditUnenroll(w, r, id, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// AgentCheckin operation middleware
+func (siw *ServerInterfaceWrapper) AgentCheckin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, chi.URLParam(r, "id"), &id)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
+		return
+	}
+
+	ctx = context.WithValue(ctx, AgentApiKeyScopes, []string{})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params AgentCheckinParams
+
+	headers := r.Header
+
+	// ------------- Optional header parameter "Accept-Encoding" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("Accept-Encoding")]; found {
+		var AcceptEncoding string
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "Accept-Encoding", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "Accept-Encoding", runtime.ParamLocationHeader, valueList[0], &AcceptEncoding)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "Accept-Encoding", Err: err})
+			return
+		}
+
+		params.AcceptEncoding = &AcceptEncoding
+
+	}
+
+	// ------------- Required header parameter "User-Agent" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("User-Agent")]; found {
+		var UserAgent UserAgent
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "User-Agent", Count: n})
 			return
 		}
 
-		err = runtime.BindStyledParameterWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, valueList[0], &ElasticApiVersion)
+		err = runtime.BindStyledParameterWithLocation("simple", false, "User-Agent", runtime.ParamLocationHeader, valueList[0], &UserAgent)
 		if err != nil {
-			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "elastic-api-version", Err: err})
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "User-Agent", Err: err})
 			return
 		}
 
-		params.ElasticApiVersion = &ElasticApiVersion
+		params.UserAgent = UserAgent
 
+	} else {
+		err := fmt.Errorf("Header parameter User-Agent is required, but not found")
+		siw.ErrorHandlerFunc(w, r, &RequiredHeaderError{ParamName: "User-Agent", Err: err})
+		return
 	}
 
 	// ------------- Optional header parameter "X-Request-Id" -------------
@@ -1845,8 +3147,27 @@ func (siw *ServerInterfaceWrapper) GetPGPKey(w http.ResponseWriter, r *http.Requ
 
 	}
 
+	// ------------- Optional header parameter "elastic-api-version" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("elastic-api-version")]; found {
+		var ElasticApiVersion ApiVersion
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "elastic-api-version", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, valueList[0], &ElasticApiVersion)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "elastic-api-version", Err: err})
+			return
+		}
+
+		params.ElasticApiVersion = &ElasticApiVersion
+
+	}
+
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.GetPGPKey(w, r, major, minor, patch, params)
+		siw.Handler.AgentCheckin(w, r, id, params)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -1856,41 +3177,29 @@ func (siw *ServerInterfaceWrapper) GetPGPKey(w http.ResponseWriter, r *http.Requ
 	handler.ServeHTTP(w, r.WithContext(ctx))
 }
 
-// AgentEnroll operation middleware
-func (siw *ServerInterfaceWrapper) AgentEnroll(w http.ResponseWriter, r *http.Request) {
+// AddAgentTags operation middleware
+func (siw *ServerInterfaceWrapper) AddAgentTags(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	var err error
 
-	ctx = context.WithValue(ctx, ApiKeyScopes, []string{})
-
-	// Parameter object where we will unmarshal all parameters from the context
-	var params AgentEnrollParams
+	// ------------- Path parameter "id" -------------
+	var id string
 
-	headers := r.Header
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, chi.URLParam(r, "id"), &id)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
+		return
+	}
 
-	// ------------- Required header parameter "User-Agent" -------------
-	if valueList, found := headers[http.CanonicalHeaderKey("User-Agent")]; found {
-		var UserAgent UserAgent
-		n := len(valueList)
-		if n != 1 {
-			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "User-Agent", Count: n})
-			return
-		}
+	ctx = context.WithValue(ctx, AdminApiKeyScopes, []string{})
 
-		err = runtime.BindStyledParameterWithLocation("simple", false, "User-Agent", runtime.ParamLocationHeader, valueList[0], &UserAgent)
-		if err != nil {
-			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "User-Agent", Err: err})
-			return
-		}
+	ctx = context.WithValue(ctx, ServiceTokenScopes, []string{})
 
-		params.UserAgent = UserAgent
+	// Parameter object where we will unmarshal all parameters from the context
+	var params AddAgentTagsParams
 
-	} else {
-		err := fmt.Errorf("Header parameter User-Agent is required, but not found")
-		siw.ErrorHandlerFunc(w, r, &RequiredHeaderError{ParamName: "User-Agent", Err: err})
-		return
-	}
+	headers := r.Header
 
 	// ------------- Optional header parameter "X-Request-Id" -------------
 	if valueList, found := headers[http.CanonicalHeaderKey("X-Request-Id")]; found {
@@ -1931,7 +3240,7 @@ func (siw *ServerInterfaceWrapper) AgentEnroll(w http.ResponseWriter, r *http.Re
 	}
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.AgentEnroll(w, r, params)
+		siw.Handler.AddAgentTags(w, r, id, params)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -1941,8 +3250,8 @@ func (siw *ServerInterfaceWrapper) AgentEnroll(w http.ResponseWriter, r *http.Re
 	handler.ServeHTTP(w, r.WithContext(ctx))
 }
 
-// AgentAcks operation middleware
-func (siw *ServerInterfaceWrapper) AgentAcks(w http.ResponseWriter, r *http.Request) {
+// RemoveAgentTag operation middleware
+func (siw *ServerInterfaceWrapper) RemoveAgentTag(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	var err error
@@ -1956,10 +3265,21 @@ func (siw *ServerInterfaceWrapper) AgentAcks(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	ctx = context.WithValue(ctx, AgentApiKeyScopes, []string{})
+	// ------------- Path parameter "tag" -------------
+	var tag string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "tag", runtime.ParamLocationPath, chi.URLParam(r, "tag"), &tag)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "tag", Err: err})
+		return
+	}
+
+	ctx = context.WithValue(ctx, AdminApiKeyScopes, []string{})
+
+	ctx = context.WithValue(ctx, ServiceTokenScopes, []string{})
 
 	// Parameter object where we will unmarshal all parameters from the context
-	var params AgentAcksParams
+	var params RemoveAgentTagParams
 
 	headers := r.Header
 
@@ -2002,7 +3322,7 @@ func (siw *ServerInterfaceWrapper) AgentAcks(w http.ResponseWriter, r *http.Requ
 	}
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.AgentAcks(w, r, id, params)
+		siw.Handler.RemoveAgentTag(w, r, id, tag, params)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -2012,8 +3332,8 @@ func (siw *ServerInterfaceWrapper) AgentAcks(w http.ResponseWriter, r *http.Requ
 	handler.ServeHTTP(w, r.WithContext(ctx))
 }
 
-// AuditUnenroll operation middleware
-func (siw *ServerInterfaceWrapper) AuditUnenroll(w http.ResponseWriter, r *http.Request) {
+// Artifact operation middleware
+func (siw *ServerInterfaceWrapper) Artifact(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	var err error
@@ -2027,10 +3347,19 @@ func (siw *ServerInterfaceWrapper) AuditUnenroll(w http.ResponseWriter, r *http.
 		return
 	}
 
+	// ------------- Path parameter "sha2" -------------
+	var sha2 string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "sha2", runtime.ParamLocationPath, chi.URLParam(r, "sha2"), &sha2)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "sha2", Err: err})
+		return
+	}
+
 	ctx = context.WithValue(ctx, AgentApiKeyScopes, []string{})
 
 	// Parameter object where we will unmarshal all parameters from the context
-	var params AuditUnenrollParams
+	var params ArtifactParams
 
 	headers := r.Header
 
@@ -2072,8 +3401,27 @@ func (siw *ServerInterfaceWrapper) AuditUnenroll(w http.ResponseWriter, r *http.
 
 	}
 
+	// ------------- Optional header parameter "If-None-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-None-Match")]; found {
+		var IfNoneMatch IfNoneMatch
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "If-None-Match", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "If-None-Match", runtime.ParamLocationHeader, valueList[0], &IfNoneMatch)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "If-None-Match", Err: err})
+			return
+		}
+
+		params.IfNoneMatch = &IfNoneMatch
+
+	}
+
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.AuditUnenroll(w, r, id, params)
+		siw.Handler.Artifact(w, r, id, sha2, params)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -2083,8 +3431,8 @@ func (siw *ServerInterfaceWrapper) AuditUnenroll(w http.ResponseWriter, r *http.
 	handler.ServeHTTP(w, r.WithContext(ctx))
 }
 
-// AgentCheckin operation middleware
-func (siw *ServerInterfaceWrapper) AgentCheckin(w http.ResponseWriter, r *http.Request) {
+// GetFile operation middleware
+func (siw *ServerInterfaceWrapper) GetFile(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	var err error
@@ -2101,52 +3449,95 @@ func (siw *ServerInterfaceWrapper) AgentCheckin(w http.ResponseWriter, r *http.R
 	ctx = context.WithValue(ctx, AgentApiKeyScopes, []string{})
 
 	// Parameter object where we will unmarshal all parameters from the context
-	var params AgentCheckinParams
+	var params GetFileParams
 
 	headers := r.Header
 
-	// ------------- Optional header parameter "Accept-Encoding" -------------
-	if valueList, found := headers[http.CanonicalHeaderKey("Accept-Encoding")]; found {
-		var AcceptEncoding string
+	// ------------- Optional header parameter "elastic-api-version" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("elastic-api-version")]; found {
+		var ElasticApiVersion ApiVersion
 		n := len(valueList)
 		if n != 1 {
-			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "Accept-Encoding", Count: n})
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "elastic-api-version", Count: n})
 			return
 		}
 
-		err = runtime.BindStyledParameterWithLocation("simple", false, "Accept-Encoding", runtime.ParamLocationHeader, valueList[0], &AcceptEncoding)
+		err = runtime.BindStyledParameterWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, valueList[0], &ElasticApiVersion)
 		if err != nil {
-			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "Accept-Encoding", Err: err})
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "elastic-api-version", Err: err})
 			return
 		}
 
-		params.AcceptEncoding = &AcceptEncoding
+		params.ElasticApiVersion = &ElasticApiVersion
 
 	}
 
-	// ------------- Required header parameter "User-Agent" -------------
-	if valueList, found := headers[http.CanonicalHeaderKey("User-Agent")]; found {
-		var UserAgent UserAgent
+	// ------------- Optional header parameter "X-Request-Id" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("X-Request-Id")]; found {
+		var XRequestId RequestId
 		n := len(valueList)
 		if n != 1 {
-			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "User-Agent", Count: n})
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "X-Request-Id", Count: n})
 			return
 		}
 
-		err = runtime.BindStyledParameterWithLocation("simple", false, "User-Agent", runtime.ParamLocationHeader, valueList[0], &UserAgent)
+		err = runtime.BindStyledParameterWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, valueList[0], &XRequestId)
 		if err != nil {
-			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "User-Agent", Err: err})
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "X-Request-Id", Err: err})
 			return
 		}
 
-		params.UserAgent = UserAgent
+		params.XRequestId = &XRequestId
 
-	} else {
-		err := fmt.Errorf("Header parameter User-Agent is required, but not found")
-		siw.ErrorHandlerFunc(w, r, &RequiredHeaderError{ParamName: "User-Agent", Err: err})
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetFile(w, r, id, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// Healthz operation middleware
+func (siw *ServerInterfaceWrapper) Healthz(w http.ResponseWriter, r *http.Request) {
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.Healthz(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetOperation operation middleware
+func (siw *ServerInterfaceWrapper) GetOperation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id string
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "id", runtime.ParamLocationPath, chi.URLParam(r, "id"), &id)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
 		return
 	}
 
+	ctx = context.WithValue(ctx, ServiceTokenScopes, []string{})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetOperationParams
+
+	headers := r.Header
+
 	// ------------- Optional header parameter "X-Request-Id" -------------
 	if valueList, found := headers[http.CanonicalHeaderKey("X-Request-Id")]; found {
 		var XRequestId RequestId
@@ -2186,7 +3577,7 @@ func (siw *ServerInterfaceWrapper) AgentCheckin(w http.ResponseWriter, r *http.R
 	}
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.AgentCheckin(w, r, id, params)
+		siw.Handler.GetOperation(w, r, id, params)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -2196,8 +3587,8 @@ func (siw *ServerInterfaceWrapper) AgentCheckin(w http.ResponseWriter, r *http.R
 	handler.ServeHTTP(w, r.WithContext(ctx))
 }
 
-// Artifact operation middleware
-func (siw *ServerInterfaceWrapper) Artifact(w http.ResponseWriter, r *http.Request) {
+// GetPolicyRollout operation middleware
+func (siw *ServerInterfaceWrapper) GetPolicyRollout(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	var err error
@@ -2211,20 +3602,19 @@ func (siw *ServerInterfaceWrapper) Artifact(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// ------------- Path parameter "sha2" -------------
-	var sha2 string
+	ctx = context.WithValue(ctx, ServiceTokenScopes, []string{})
 
-	err = runtime.BindStyledParameterWithLocation("simple", false, "sha2", runtime.ParamLocationPath, chi.URLParam(r, "sha2"), &sha2)
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetPolicyRolloutParams
+
+	// ------------- Optional query parameter "stuck_threshold" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "stuck_threshold", r.URL.Query(), &params.StuckThreshold)
 	if err != nil {
-		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "sha2", Err: err})
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "stuck_threshold", Err: err})
 		return
 	}
 
-	ctx = context.WithValue(ctx, AgentApiKeyScopes, []string{})
-
-	// Parameter object where we will unmarshal all parameters from the context
-	var params ArtifactParams
-
 	headers := r.Header
 
 	// ------------- Optional header parameter "X-Request-Id" -------------
@@ -2266,7 +3656,7 @@ func (siw *ServerInterfaceWrapper) Artifact(w http.ResponseWriter, r *http.Reque
 	}
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.Artifact(w, r, id, sha2, params)
+		siw.Handler.GetPolicyRollout(w, r, id, params)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -2276,8 +3666,8 @@ func (siw *ServerInterfaceWrapper) Artifact(w http.ResponseWriter, r *http.Reque
 	handler.ServeHTTP(w, r.WithContext(ctx))
 }
 
-// GetFile operation middleware
-func (siw *ServerInterfaceWrapper) GetFile(w http.ResponseWriter, r *http.Request) {
+// GetPolicy operation middleware
+func (siw *ServerInterfaceWrapper) GetPolicy(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	var err error
@@ -2291,13 +3681,41 @@ func (siw *ServerInterfaceWrapper) GetFile(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// ------------- Path parameter "revision" -------------
+	var revision int64
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "revision", runtime.ParamLocationPath, chi.URLParam(r, "revision"), &revision)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "revision", Err: err})
+		return
+	}
+
 	ctx = context.WithValue(ctx, AgentApiKeyScopes, []string{})
 
 	// Parameter object where we will unmarshal all parameters from the context
-	var params GetFileParams
+	var params GetPolicyParams
 
 	headers := r.Header
 
+	// ------------- Optional header parameter "X-Request-Id" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("X-Request-Id")]; found {
+		var XRequestId RequestId
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "X-Request-Id", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, valueList[0], &XRequestId)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "X-Request-Id", Err: err})
+			return
+		}
+
+		params.XRequestId = &XRequestId
+
+	}
+
 	// ------------- Optional header parameter "elastic-api-version" -------------
 	if valueList, found := headers[http.CanonicalHeaderKey("elastic-api-version")]; found {
 		var ElasticApiVersion ApiVersion
@@ -2317,27 +3735,27 @@ func (siw *ServerInterfaceWrapper) GetFile(w http.ResponseWriter, r *http.Reques
 
 	}
 
-	// ------------- Optional header parameter "X-Request-Id" -------------
-	if valueList, found := headers[http.CanonicalHeaderKey("X-Request-Id")]; found {
-		var XRequestId RequestId
+	// ------------- Optional header parameter "If-None-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-None-Match")]; found {
+		var IfNoneMatch IfNoneMatch
 		n := len(valueList)
 		if n != 1 {
-			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "X-Request-Id", Count: n})
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "If-None-Match", Count: n})
 			return
 		}
 
-		err = runtime.BindStyledParameterWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, valueList[0], &XRequestId)
+		err = runtime.BindStyledParameterWithLocation("simple", false, "If-None-Match", runtime.ParamLocationHeader, valueList[0], &IfNoneMatch)
 		if err != nil {
-			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "X-Request-Id", Err: err})
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "If-None-Match", Err: err})
 			return
 		}
 
-		params.XRequestId = &XRequestId
+		params.IfNoneMatch = &IfNoneMatch
 
 	}
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.GetFile(w, r, id, params)
+		siw.Handler.GetPolicy(w, r, id, revision, params)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -2761,9 +4179,21 @@ func HandlerWithOptions(si ServerInterface, options ChiServerOptions) http.Handl
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/api/agents/upgrades/{major}.{minor}.{patch}/pgp-public-key", wrapper.GetPGPKey)
 	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/api/fleet/agents/actions", wrapper.CreateActions)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/fleet/agents/actions/{id}/results", wrapper.GetActionResults)
+	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/api/fleet/agents/bulk_enroll", wrapper.BulkEnroll)
+	})
 	r.Group(func(r chi.Router) {
 		r.Post(options.BaseURL+"/api/fleet/agents/enroll", wrapper.AgentEnroll)
 	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/api/fleet/agents/reassign", wrapper.ReassignAgents)
+	})
 	r.Group(func(r chi.Router) {
 		r.Post(options.BaseURL+"/api/fleet/agents/{id}/acks", wrapper.AgentAcks)
 	})
@@ -2773,12 +4203,30 @@ func HandlerWithOptions(si ServerInterface, options ChiServerOptions) http.Handl
 	r.Group(func(r chi.Router) {
 		r.Post(options.BaseURL+"/api/fleet/agents/{id}/checkin", wrapper.AgentCheckin)
 	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/api/fleet/agents/{id}/tags", wrapper.AddAgentTags)
+	})
+	r.Group(func(r chi.Router) {
+		r.Delete(options.BaseURL+"/api/fleet/agents/{id}/tags/{tag}", wrapper.RemoveAgentTag)
+	})
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/api/fleet/artifacts/{id}/{sha2}", wrapper.Artifact)
 	})
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/api/fleet/file/{id}", wrapper.GetFile)
 	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/fleet/healthz", wrapper.Healthz)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/fleet/operations/{id}", wrapper.GetOperation)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/fleet/policies/{id}/rollout", wrapper.GetPolicyRollout)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/fleet/policies/{id}/{revision}", wrapper.GetPolicy)
+	})
 	r.Group(func(r chi.Router) {
 		r.Post(options.BaseURL+"/api/fleet/uploads", wrapper.UploadBegin)
 	})
@@ -0,0 +1,32 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPIPath is the path of the OpenAPI document on the monitoring listener.
+const openAPIPath = "/api/fleet/openapi.json"
+
+// openAPIDoc is the OpenAPI document of the fleet-server API generated from model/openapi.yml, see internal/pkg/openapigen.
+//
+//go:embed openapi.json
+var openAPIDoc []byte
+
+// openAPIHandler serves the OpenAPI document.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(openAPIDoc)
+	}
+}
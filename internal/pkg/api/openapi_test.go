@@ -0,0 +1,518 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/action"
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/checkin"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	mockmonitor "github.com/elastic/fleet-server/v7/internal/pkg/monitor/mock"
+	"github.com/elastic/fleet-server/v7/internal/pkg/operation"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	"github.com/elastic/fleet-server/v7/internal/pkg/rollback"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestOpenAPIEndpoint(t *testing.T) {
+	auth := NewMonitoringAuth()
+	require.NoError(t, auth.Configure(config.HTTPAuth{Enabled: true, Token: "secret-token"}))
+	mux := newMonitoringMux(t, auth)
+
+	request := func(method, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, openAPIPath, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodGet, "Bearer secret-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var doc struct {
+		OpenAPI string         `json:"openapi"`
+		Paths   map[string]any `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	require.Equal(t, "3.1.0", doc.OpenAPI)
+	require.Contains(t, doc.Paths, "/api/fleet/agents/{id}/checkin")
+
+	require.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, request(http.MethodPost, "Bearer secret-token").Code)
+}
+
+// openAPIType returns the value a JSON body with the named schema of the spec is decoded into.
+var openAPIType = map[string]func() any{
+	"ackRequest":            func() any { return &AckRequest{} },
+	"ackResponse":           func() any { return &AckResponse{} },
+	"agentTagsRequest":      func() any { return &AgentTagsRequest{} },
+	"auditUnenrollRequest":  func() any { return &AuditUnenrollRequest{} },
+	"bulkEnrollRequest":     func() any { return &BulkEnrollRequest{} },
+	"checkinRequest":        func() any { return &CheckinRequest{} },
+	"checkinResponse":       func() any { return &CheckinResponse{} },
+	"createActionsRequest":  func() any { return &CreateActionsRequest{} },
+	"enrollRequest":         func() any { return &EnrollRequest{} },
+	"enrollResponse":        func() any { return &EnrollResponse{} },
+	"error":                 func() any { return &HTTPErrResp{} },
+	"reassignAgentsRequest": func() any { return &ReassignAgentsRequest{} },
+	"statusResponse":        func() any { return &StatusAPIResponse{} },
+}
+
+type openAPIMedia struct {
+	Schema struct {
+		Ref   string `json:"$ref"`
+		AllOf []struct {
+			Ref string `json:"$ref"`
+		} `json:"allOf"`
+	} `json:"schema"`
+	Examples map[string]struct {
+		Value json.RawMessage `json:"value"`
+	} `json:"examples"`
+}
+
+// schemaName returns the name of the schema of m, or "" if it is not a reference to a component.
+func (m openAPIMedia) schemaName() string {
+	ref := m.Schema.Ref
+	if ref == "" && len(m.Schema.AllOf) == 1 {
+		ref = m.Schema.AllOf[0].Ref
+	}
+	return strings.TrimPrefix(ref, "#/components/schemas/")
+}
+
+// TestOpenAPIExamples checks the examples of the served document against the request and response types:
+// the request examples are accepted by the decoding and validation of the handlers,
+// and the response examples have no field the response types don't have.
+func TestOpenAPIExamples(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]struct {
+			RequestBody struct {
+				Content map[string]openAPIMedia `json:"content"`
+			} `json:"requestBody"`
+			Responses map[string]struct {
+				Content map[string]openAPIMedia `json:"content"`
+			} `json:"responses"`
+		} `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(openAPIDoc, &doc))
+
+	var requests, responses int
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			for _, media := range op.RequestBody.Content {
+				for name, ex := range media.Examples {
+					t.Run(method+" "+path+" "+name, func(t *testing.T) {
+						newValue, ok := openAPIType[media.schemaName()]
+						require.True(t, ok, "no type for the schema %q", media.schemaName())
+						require.NoError(t, decodeRequest(context.Background(), bytes.NewReader(ex.Value), newValue(), media.schemaName()))
+					})
+					requests++
+				}
+			}
+			for code, resp := range op.Responses {
+				for _, media := range resp.Content {
+					for name, ex := range media.Examples {
+						t.Run(method+" "+path+" "+code+" "+name, func(t *testing.T) {
+							newValue, ok := openAPIType[media.schemaName()]
+							require.True(t, ok, "no type for the schema %q", media.schemaName())
+							dec := json.NewDecoder(bytes.NewReader(ex.Value))
+							dec.DisallowUnknownFields()
+							require.NoError(t, dec.Decode(newValue()))
+						})
+						responses++
+					}
+				}
+			}
+		}
+	}
+	require.NotZero(t, requests)
+	require.NotZero(t, responses)
+}
+
+// openAPIOperation runs the request body of an operation of the spec through its handler and returns the response.
+// The handlers are called after the authentication of the agents, the admin requests are authenticated by the mocks.
+type openAPIOperation func(t *testing.T, body []byte) *httptest.ResponseRecorder
+
+// openAPIOperations are the handlers of the operations with request examples, by operation ID.
+var openAPIOperations = map[string]openAPIOperation{
+	"agentEnroll": func(t *testing.T, body []byte) *httptest.ResponseRecorder {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetPolicies, mock.Anything, mock.Anything).Return(latestPolicies(t, "policy-1"), nil)
+		bulker.On("Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+		bulker.On("APIKeyCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&apikey.APIKey{ID: "key-1", Key: "secret-1"}, nil)
+		bulker.On("Create", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+		serverVer, err := ParseVersion("8.6.0")
+		require.NoError(t, err)
+		cfg := &config.Server{
+			StaticPolicyTokens: config.StaticPolicyTokens{
+				Enabled:      true,
+				PolicyTokens: []config.PolicyToken{{TokenKey: "enrollment-key", PolicyID: "policy-1"}},
+			},
+		}
+		c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+		require.NoError(t, err)
+		et, err := NewEnrollerT(mustBuildConstraints("8.6.0"), cfg, bulker, c, WithEnrollServerVersion(serverVer))
+		require.NoError(t, err)
+
+		zlog := testlog.SetLogger(t)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/enroll", bytes.NewReader(body))
+		r = r.WithContext(zlog.WithContext(r.Context()))
+		resp, err := et.processRequest(zlog, w, r, &rollback.Rollback{}, &apikey.APIKey{Key: "enrollment-key"}, "8.6.0")
+		require.NoError(t, err)
+		require.NoError(t, writeResponse(r.Context(), zlog, w, resp, time.Now()))
+		return w
+	},
+	"agentCheckin": func(t *testing.T, body []byte) *httptest.ResponseRecorder {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{
+			HitsT: es.HitsT{Hits: []es.HitT{{
+				ID:     "doc-1",
+				SeqNo:  2,
+				Source: []byte(`{"action_id":"upgrade-action-id","type":"UPGRADE","agents":["test-agent"],"data":{"version":"8.17.0"}}`),
+			}}},
+		}, nil)
+		bulker.On("Update", mock.Anything, dl.FleetAgents, "test-agent", mock.Anything, mock.Anything).Return(nil).Maybe()
+		gcp := mockmonitor.NewMockMonitor()
+		gcp.On("GetCheckpoint").Return(sqn.SeqNo{2})
+		pm := policy.NewMonitor(bulker, mockmonitor.NewMockMonitor(), config.ServerLimits{PolicyLimit: config.Limit{Interval: time.Millisecond, Burst: 1}})
+		cfg := &config.Server{}
+		cfg.InitDefaults()
+		c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+		require.NoError(t, err)
+		ct, err := NewCheckinT(mustBuildConstraints("8.0.0"), cfg, c, checkin.NewBulk(nil), pm, gcp, action.NewDispatcher(mockmonitor.NewMockMonitor(), 0, 1), bulker)
+		require.NoError(t, err)
+		agent := &model.Agent{
+			ESDocument:  model.ESDocument{Id: "test-agent"},
+			Agent:       &model.AgentMetadata{ID: "test-agent", Version: "8.16.0"},
+			PolicyID:    "policy-1",
+			ActionSeqNo: []int64{1},
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/test-agent/checkin", bytes.NewReader(body))
+		r = r.WithContext(testlog.SetLogger(t).WithContext(r.Context()))
+		require.NoError(t, ct.ProcessRequest(w, r, time.Now(), agent, ""))
+		return w
+	},
+	"agentAcks": func(t *testing.T, body []byte) *httptest.ResponseRecorder {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything).Return(&es.ResultT{
+			HitsT: es.HitsT{Hits: []es.HitT{{
+				ID:     "doc-1",
+				Source: []byte(`{"action_id":"test-action","type":"SETTINGS","agents":["test-agent"],"data":{"log_level":"debug"}}`),
+			}}},
+		}, nil)
+		bulker.On("Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", nil).Maybe()
+		bulker.On("Update", mock.Anything, dl.FleetAgents, "test-agent", mock.Anything, mock.Anything).Return(nil).Maybe()
+		c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+		require.NoError(t, err)
+		cfg := &config.Server{}
+		cfg.InitDefaults()
+		ack := NewAckT(cfg, bulker, c)
+		agent := &model.Agent{
+			ESDocument: model.ESDocument{Id: "test-agent"},
+			Agent:      &model.AgentMetadata{ID: "test-agent", Version: "8.16.0"},
+			PolicyID:   "policy-1",
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/test-agent/acks", bytes.NewReader(body))
+		r = r.WithContext(testlog.SetLogger(t).WithContext(r.Context()))
+		require.NoError(t, ack.processRequest(w, r, agent))
+		return w
+	},
+	"auditUnenroll": func(t *testing.T, body []byte) *httptest.ResponseRecorder {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Update", mock.Anything, dl.FleetAgents, "test-agent", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		audit := NewAuditT(&config.Server{}, bulker, nil)
+		agent := &model.Agent{ESDocument: model.ESDocument{Id: "test-agent"}, PolicyID: "policy-1"}
+
+		zlog := testlog.SetLogger(t)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/test-agent/audit/unenroll", bytes.NewReader(body))
+		r = r.WithContext(zlog.WithContext(r.Context()))
+		require.NoError(t, audit.unenroll(zlog, w, r, agent))
+		return w
+	},
+	"createActions": func(t *testing.T, body []byte) *httptest.ResponseRecorder {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("APIKeyAuth", mock.Anything, mock.Anything).Return(&apikey.SecurityInfo{UserName: "elastic", Enabled: true}, nil)
+		mockAdminPrivileges(t, bulker, true)
+		bulker.On("Create", mock.Anything, dl.FleetActions, mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+		act := NewActionsT(actionsTestCfg(10), bulker, nil)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/actions", bytes.NewReader(body))
+		r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+		require.NoError(t, act.handleCreate(testlog.SetLogger(t), w, r))
+		return w
+	},
+	"reassignAgents": func(t *testing.T, body []byte) *httptest.ResponseRecorder {
+		bulker := ftesting.NewMockBulk()
+		authorization := mockServiceToken(t, bulker)
+		bulker.On("Search", mock.Anything, dl.FleetAgents, isAgentsByIDsQuery, mock.Anything).Return(activeAgents("agent-0"), nil)
+		bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(agentHits(1), nil).Once()
+		bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+		bulker.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Return([]bulk.BulkIndexerResponseItem{{Status: http.StatusOK}}, nil)
+		pm := &loadMonitor{policies: map[string]bool{"policy-2": true}}
+		rt := NewReassignT(&config.Server{}, bulker, pm, operation.NewTracker(operation.NewMemoryStore()))
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/reassign", bytes.NewReader(body))
+		r = r.WithContext(testlog.SetLogger(t).WithContext(r.Context()))
+		r.Header.Set("Authorization", authorization)
+		require.NoError(t, rt.handleReassign(testlog.SetLogger(t), w, r))
+		return w
+	},
+	"bulkEnroll": func(t *testing.T, body []byte) *httptest.ResponseRecorder {
+		bulker := ftesting.NewMockBulk()
+		authorization := mockServiceToken(t, bulker)
+		bulker.On("Search", mock.Anything, dl.FleetPolicies, mock.Anything, mock.Anything).Return(latestPolicies(t, "policy-1"), nil)
+		bulker.On("APIKeyMCreate", mock.Anything, mock.Anything).Return([]bulk.APIKeyCreateResult{
+			{Key: &apikey.APIKey{ID: "key-1", Key: "secret-1"}},
+			{Key: &apikey.APIKey{ID: "key-2", Key: "secret-2"}},
+		}, nil)
+		bulker.On("MCreate", mock.Anything, mock.Anything, mock.Anything).Return([]bulk.BulkIndexerResponseItem{
+			{Status: http.StatusCreated, SeqNo: 1, PrimaryTerm: 1},
+			{Status: http.StatusCreated, SeqNo: 2, PrimaryTerm: 1},
+		}, nil)
+		c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+		require.NoError(t, err)
+		et, err := NewEnrollerT(nil, bulkEnrollTestCfg(), bulker, c)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/bulk_enroll", bytes.NewReader(body))
+		r = r.WithContext(testlog.SetLogger(t).WithContext(r.Context()))
+		r.Header.Set("Authorization", authorization)
+		require.NoError(t, et.handleBulkEnroll(testlog.SetLogger(t), w, r))
+		return w
+	},
+	"addAgentTags": func(t *testing.T, body []byte) *httptest.ResponseRecorder {
+		bulker, _ := tagsTestBulk(t, nil, []string{"production", "eu-west"})
+		tt := NewTagsT(&config.Server{}, bulker)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/tags", bytes.NewReader(body))
+		r.Header.Set("Authorization", "ApiKey aWQ6a2V5")
+		require.NoError(t, tt.handleAdd(testlog.SetLogger(t), w, r, "agent-1"))
+		return w
+	},
+}
+
+// TestOpenAPIHandlers runs the request examples of the served document through the handlers of their operations
+// and validates the responses against the schemas of the document.
+func TestOpenAPIHandlers(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+			RequestBody struct {
+				Content map[string]openAPIMedia `json:"content"`
+			} `json:"requestBody"`
+			Responses map[string]struct {
+				Content map[string]struct {
+					Schema map[string]any `json:"schema"`
+				} `json:"content"`
+			} `json:"responses"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(openAPIDoc, &doc))
+	schemas := openAPISchemas(doc.Components.Schemas)
+
+	var requests int
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			for _, media := range op.RequestBody.Content {
+				for name, ex := range media.Examples {
+					t.Run(method+" "+path+" "+name, func(t *testing.T) {
+						handler, ok := openAPIOperations[op.OperationID]
+						require.True(t, ok, "no handler for the operation %q", op.OperationID)
+						w := handler(t, ex.Value)
+						require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+						resp, ok := op.Responses["200"]
+						require.True(t, ok, "no response for the status 200")
+						if len(resp.Content) == 0 {
+							require.Empty(t, w.Body.String())
+							return
+						}
+						// the content type is set by the apiServer methods wrapping the handlers
+						require.Len(t, resp.Content, 1)
+						var content struct {
+							Schema map[string]any `json:"schema"`
+						}
+						for _, content = range resp.Content {
+						}
+						// the ndjson responses are made of a document per line
+						scanner := bufio.NewScanner(w.Body)
+						for scanner.Scan() {
+							var v any
+							require.NoError(t, json.Unmarshal(scanner.Bytes(), &v))
+							require.NoError(t, schemas.validate(content.Schema, v, "response"))
+						}
+						require.NoError(t, scanner.Err())
+					})
+					requests++
+				}
+			}
+		}
+	}
+	require.NotZero(t, requests)
+}
+
+// openAPISchemas are the schemas of the components of the served document, by name.
+type openAPISchemas map[string]any
+
+// validate returns an error if the decoded JSON value v does not match schema.
+// It checks the keywords used by the document: $ref, allOf, oneOf, anyOf, enum, type, properties, required,
+// additionalProperties and items.
+func (s openAPISchemas) validate(schema map[string]any, v any, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		ref, ok := s[name].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %q", path, name)
+		}
+		return s.validate(ref, v, path)
+	}
+	for _, sub := range subSchemas(schema["allOf"]) {
+		if err := s.validate(sub, v, path); err != nil {
+			return err
+		}
+	}
+	// the data schemas of the oneOf of the actions have no required property, the data of an action may match several
+	// of them, the type of the action selects its schema: oneOf is checked as anyOf
+	for _, keyword := range []string{"oneOf", "anyOf"} {
+		if subs := subSchemas(schema[keyword]); len(subs) > 0 && !slices.ContainsFunc(subs, func(sub map[string]any) bool {
+			return s.validate(sub, v, path) == nil
+		}) {
+			return fmt.Errorf("%s: matches no schema of %s", path, keyword)
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		return fmt.Errorf("%s: %v is not one of %v", path, v, enum)
+	}
+	if schema["format"] == "application/json" {
+		// the raw JSON of a json.RawMessage field is any JSON value
+		return nil
+	}
+	if typ, ok := schema["type"].(string); ok && !isJSONType(v, typ) {
+		return fmt.Errorf("%s: %v is not of type %s", path, v, typ)
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok && !isOmitEmpty(properties[name.(string)]) {
+				return fmt.Errorf("%s: %s is required", path, name)
+			}
+		}
+		for name, value := range v {
+			sub, ok := properties[name].(map[string]any)
+			if !ok {
+				switch additional := schema["additionalProperties"].(type) {
+				case map[string]any:
+					sub = additional
+				case bool:
+					if !additional {
+						return fmt.Errorf("%s: unknown property %s", path, name)
+					}
+					continue
+				default:
+					// the properties of a schema without properties are free-form
+					if properties != nil {
+						return fmt.Errorf("%s: unknown property %s", path, name)
+					}
+					continue
+				}
+			}
+			if err := s.validate(sub, value, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []any:
+		items, ok := schema["items"].(map[string]any)
+		if !ok {
+			return nil
+		}
+		for i, item := range v {
+			if err := s.validate(items, item, fmt.Sprintf("%s.%d", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isOmitEmpty returns true if the property schema has an omitempty JSON tag. Such properties are required by the spec
+// so the generated fields are not pointers, they are omitted when they are empty.
+func isOmitEmpty(schema any) bool {
+	property, _ := schema.(map[string]any)
+	tags, _ := property["x-oapi-codegen-extra-tags"].(map[string]any)
+	tag, _ := tags["json"].(string)
+	return strings.HasSuffix(tag, ",omitempty")
+}
+
+func subSchemas(v any) []map[string]any {
+	list, _ := v.([]any)
+	subs := make([]map[string]any, 0, len(list))
+	for _, sub := range list {
+		subs = append(subs, sub.(map[string]any))
+	}
+	return subs
+}
+
+// isJSONType returns true if the decoded JSON value v is of the JSON schema type typ.
+func isJSONType(v any, typ string) bool {
+	switch v := v.(type) {
+	case nil:
+		return typ == "null"
+	case bool:
+		return typ == "boolean"
+	case float64:
+		return typ == "number" || typ == "integer" && v == math.Trunc(v)
+	case string:
+		return typ == "string"
+	case []any:
+		return typ == "array"
+	case map[string]any:
+		return typ == "object"
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+)
+
+const (
+	quotaMaxAgents          = "max_agents"
+	quotaMaxEnrollPerMinute = "max_enroll_per_minute"
+
+	// quotaIdleTimeout is the time after which the quota of a policy without enrollments is forgotten. The enrollment
+	// rate of the policy is refilled by then, a new quota allows the same enrollments.
+	quotaIdleTimeout = time.Minute
+	// enrollSettleTime is the time the enrolled agents are counted by their quota after their enrollment, until the
+	// search of the agents of the policy sees them after a refresh of the agents index.
+	enrollSettleTime = 2 * time.Second
+)
+
+// ErrPolicyQuota is returned when a request exceeds a quota of a policy, the error names the policy and the quota.
+var ErrPolicyQuota = errors.New("policy quota exceeded")
+
+// policyQuotas enforces the enrollment quotas of the policies.
+// An enrollment reserves its part of the quotas before it enrolls the agents: the reservations of the concurrent
+// enrollments in a policy are counted with its enrolled agents, so they cannot exceed its max_agents together.
+type policyQuotas struct {
+	bulker bulk.Bulk
+
+	mx        sync.Mutex
+	quotas    map[string]*policyQuota
+	lastSweep time.Time
+}
+
+// policyQuota is the state of the quotas of a policy.
+type policyQuota struct {
+	perMinute int
+	limiter   *rate.Limiter
+
+	// pending is the number of agents added by the enrollments in progress.
+	pending int
+	// settling are the agents added by the recent enrollments, that the search may not see yet.
+	settling []settlingAgents
+	lastUsed time.Time
+}
+
+type settlingAgents struct {
+	n     int
+	until time.Time
+}
+
+// reserved returns the number of agents that are reserved in the policy and not seen by the search at now.
+func (pq *policyQuota) reserved(now time.Time) int {
+	i := 0
+	for i < len(pq.settling) && !pq.settling[i].until.After(now) {
+		i++
+	}
+	pq.settling = pq.settling[i:]
+	n := pq.pending
+	for _, s := range pq.settling {
+		n += s.n
+	}
+	return n
+}
+
+// idle returns true if the policy has no reserved agent and no enrollment since quotaIdleTimeout at now.
+func (pq *policyQuota) idle(now time.Time) bool {
+	return pq.reserved(now) == 0 && now.Sub(pq.lastUsed) >= quotaIdleTimeout
+}
+
+// enrollReservation is the part of the quotas of a policy reserved by an enrollment, it is released by done.
+// The methods of a nil reservation do nothing.
+type enrollReservation struct {
+	q     *policyQuotas
+	quota *policyQuota
+	rate  *rate.Reservation
+	// reservedAt is the time the enrollments were taken from the rate, a reservation is only refunded at that time.
+	reservedAt time.Time
+	agents     int
+}
+
+// done releases the reservation when the enrollment completes, added is the number of agents it added to the policy.
+func (r *enrollReservation) done(added int) {
+	if r == nil {
+		return
+	}
+	r.q.mx.Lock()
+	defer r.q.mx.Unlock()
+	now := time.Now()
+	r.quota.pending -= r.agents
+	r.agents = 0
+	if added > 0 {
+		r.quota.settling = append(r.quota.settling, settlingAgents{n: added, until: now.Add(enrollSettleTime)})
+	}
+	r.quota.lastUsed = now
+}
+
+// cancel releases the reservation and refunds its enrollments to the rate of the policy, when the enrollment is rejected.
+func (r *enrollReservation) cancel() {
+	if r == nil {
+		return
+	}
+	if r.rate != nil {
+		r.rate.CancelAt(r.reservedAt)
+	}
+	r.done(0)
+}
+
+func newPolicyQuotas(bulker bulk.Bulk) *policyQuotas {
+	return &policyQuotas{
+		bulker: bulker,
+		quotas: make(map[string]*policyQuota),
+	}
+}
+
+// reserveEnroll reserves the enrollment of an agent in the policy, or returns an error if it exceeds one of limits.
+// The number of agents is only checked if the enrollment adds an agent, newAgent is false when an enrolled agent is replaced.
+func (q *policyQuotas) reserveEnroll(ctx context.Context, policyID string, limits policy.Limits, newAgent bool) (*enrollReservation, error) {
+	newAgents := 0
+	if newAgent {
+		newAgents = 1
+	}
+	return q.reserveEnrollN(ctx, policyID, limits, 1, newAgents)
+}
+
+// reserveEnrollN reserves the enrollment of n agents in the policy, newAgents of which are added to it, or returns an
+// error if it exceeds one of limits. The agents are enrolled all together or not at all, a batch larger than the
+// enrollment rate is rejected. A rejected enrollment does not consume the enrollment rate of the policy.
+func (q *policyQuotas) reserveEnrollN(ctx context.Context, policyID string, limits policy.Limits, n, newAgents int) (*enrollReservation, error) {
+	if limits.MaxEnrollPerMinute <= 0 && (newAgents <= 0 || limits.MaxAgents <= 0) {
+		return nil, nil
+	}
+	res, err := q.reserve(policyID, limits, n, newAgents)
+	if err != nil {
+		return nil, err
+	}
+	if res.agents == 0 {
+		return res, nil
+	}
+
+	var agents []string
+	if newAgents <= limits.MaxAgents {
+		agents, err = dl.FindActiveAgentIDsByPolicyID(ctx, q.bulker, policyID, limits.MaxAgents)
+		if err != nil {
+			res.cancel()
+			return nil, err
+		}
+	}
+	q.mx.Lock()
+	// the reservation of the enrollment is counted with the others
+	reserved := res.quota.reserved(time.Now())
+	q.mx.Unlock()
+	if len(agents)+reserved > limits.MaxAgents {
+		res.cancel()
+		cntPolicyQuotas.IncRejected(policyID, quotaMaxAgents)
+		return nil, fmt.Errorf("%w: policy %s %s of %d reached", ErrPolicyQuota, policyID, quotaMaxAgents, limits.MaxAgents)
+	}
+	return res, nil
+}
+
+// reserve consumes n enrollments of the rate of the policy and reserves newAgents if the policy has a max_agents quota.
+func (q *policyQuotas) reserve(policyID string, limits policy.Limits, n, newAgents int) (*enrollReservation, error) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	now := time.Now()
+	q.sweep(now)
+	pq, ok := q.quotas[policyID]
+	if !ok {
+		pq = &policyQuota{}
+		q.quotas[policyID] = pq
+	}
+	pq.lastUsed = now
+
+	res := &enrollReservation{q: q, quota: pq, reservedAt: now}
+	if limits.MaxEnrollPerMinute > 0 {
+		// a new revision of the policy may change its rate
+		if pq.limiter == nil || pq.perMinute != limits.MaxEnrollPerMinute {
+			pq.perMinute = limits.MaxEnrollPerMinute
+			pq.limiter = rate.NewLimiter(rate.Limit(float64(pq.perMinute)/60), pq.perMinute)
+		}
+		r := pq.limiter.ReserveN(now, n)
+		if !r.OK() || r.DelayFrom(now) > 0 {
+			r.CancelAt(now)
+			cntPolicyQuotas.IncRejected(policyID, quotaMaxEnrollPerMinute)
+			return nil, fmt.Errorf("%w: policy %s %s of %d reached", ErrPolicyQuota, policyID, quotaMaxEnrollPerMinute, limits.MaxEnrollPerMinute)
+		}
+		res.rate = r
+	}
+	if newAgents > 0 && limits.MaxAgents > 0 {
+		res.agents = newAgents
+		pq.pending += newAgents
+	}
+	return res, nil
+}
+
+// sweep forgets the idle quotas, at most once per quotaIdleTimeout. q.mx must be held.
+func (q *policyQuotas) sweep(now time.Time) {
+	if now.Sub(q.lastSweep) < quotaIdleTimeout {
+		return
+	}
+	q.lastSweep = now
+	for policyID, pq := range q.quotas {
+		if pq.idle(now) {
+			delete(q.quotas, policyID)
+		}
+	}
+}
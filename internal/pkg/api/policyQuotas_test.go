@@ -0,0 +1,248 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	"github.com/elastic/fleet-server/v7/internal/pkg/rollback"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+)
+
+// limitsMonitor is a policy.Monitor that has loaded the policies of limits.
+type limitsMonitor struct {
+	policy.Monitor
+	limits map[string]policy.Limits
+}
+
+func (m *limitsMonitor) Limits(policyID string) (policy.Limits, bool) {
+	l, ok := m.limits[policyID]
+	return l, ok
+}
+
+func quotasTestCfg(maxAgents, maxEnrollPerMinute int) *config.Server {
+	cfg := &config.Server{}
+	cfg.Limits.PolicyQuotas = config.PolicyQuotas{MaxAgents: maxAgents, MaxEnrollPerMinute: maxEnrollPerMinute}
+	return cfg
+}
+
+func Test_policyQuotas_maxAgents(t *testing.T) {
+	pm := &limitsMonitor{limits: map[string]policy.Limits{
+		"capped":   {MaxAgents: 2},
+		"uncapped": {},
+	}}
+	tests := []struct {
+		name     string
+		policyID string
+		agents   int
+		newAgent bool
+		err      bool
+	}{{
+		name:     "policy cap overrides the global cap",
+		policyID: "capped",
+		agents:   2,
+		newAgent: true,
+		err:      true,
+	}, {
+		name:     "policy cap not reached",
+		policyID: "capped",
+		agents:   1,
+		newAgent: true,
+	}, {
+		name:     "policy without cap falls back to the global cap",
+		policyID: "uncapped",
+		agents:   2,
+		newAgent: true,
+	}, {
+		name:     "global cap reached",
+		policyID: "uncapped",
+		agents:   5,
+		newAgent: true,
+		err:      true,
+	}, {
+		name:     "policy not loaded falls back to the global cap",
+		policyID: "unknown",
+		agents:   5,
+		newAgent: true,
+		err:      true,
+	}, {
+		name:     "replaced agent",
+		policyID: "capped",
+		agents:   2,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bulker := ftesting.NewMockBulk()
+			if tc.newAgent {
+				bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(agentHits(tc.agents), nil).Once()
+			}
+			et, err := NewEnrollerT(nil, quotasTestCfg(5, 0), bulker, nil, WithEnrollPolicyMonitor(pm))
+			require.NoError(t, err)
+
+			limits := et.policyLimits(context.Background(), zerolog.Nop(), tc.policyID)
+			_, err = et.quotas.reserveEnroll(context.Background(), tc.policyID, limits, tc.newAgent)
+			if tc.err {
+				require.ErrorIs(t, err, ErrPolicyQuota)
+				resp := NewHTTPErrResp(err)
+				require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+				require.Contains(t, resp.Message, "policy "+tc.policyID+" max_agents")
+			} else {
+				require.NoError(t, err)
+			}
+			bulker.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_policyQuotas_maxEnrollPerMinute(t *testing.T) {
+	pm := &limitsMonitor{limits: map[string]policy.Limits{
+		"capped": {MaxEnrollPerMinute: 1},
+	}}
+	et, err := NewEnrollerT(nil, quotasTestCfg(0, 2), ftesting.NewMockBulk(), nil, WithEnrollPolicyMonitor(pm))
+	require.NoError(t, err)
+	ctx := context.Background()
+	enroll := func(policyID string) error {
+		res, err := et.quotas.reserveEnroll(ctx, policyID, et.policyLimits(ctx, zerolog.Nop(), policyID), true)
+		res.done(1)
+		return err
+	}
+
+	// the policy rate overrides the global rate
+	require.NoError(t, enroll("capped"))
+	err = enroll("capped")
+	require.ErrorIs(t, err, ErrPolicyQuota)
+	require.Contains(t, err.Error(), "policy capped max_enroll_per_minute of 1")
+
+	// the other policies have their own rate, the global one
+	require.NoError(t, enroll("other"))
+	require.NoError(t, enroll("other"))
+	require.ErrorIs(t, enroll("other"), ErrPolicyQuota)
+}
+
+func Test_policyQuotas_concurrentEnroll(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	// the agents of the concurrent enrollments are not enrolled yet
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(agentHits(0), nil)
+	q := newPolicyQuotas(bulker)
+	ctx := context.Background()
+	limits := policy.Limits{MaxAgents: 1}
+
+	res, err := q.reserveEnroll(ctx, "1234", limits, true)
+	require.NoError(t, err)
+	_, err = q.reserveEnroll(ctx, "1234", limits, true)
+	require.ErrorIs(t, err, ErrPolicyQuota, "the enrollment in progress is counted")
+
+	// the enrolled agent is counted until the search sees it
+	res.done(1)
+	_, err = q.reserveEnroll(ctx, "1234", limits, true)
+	require.ErrorIs(t, err, ErrPolicyQuota, "the enrolled agent is counted")
+
+	// the agent of a failed enrollment is not counted
+	res, err = q.reserveEnroll(ctx, "5678", limits, true)
+	require.NoError(t, err)
+	res.done(0)
+	res, err = q.reserveEnroll(ctx, "5678", limits, true)
+	require.NoError(t, err)
+	res.done(0)
+}
+
+func Test_policyQuotas_refundRejected(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(agentHits(1), nil).Once()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(agentHits(0), nil).Once()
+	q := newPolicyQuotas(bulker)
+	ctx := context.Background()
+	limits := policy.Limits{MaxAgents: 1, MaxEnrollPerMinute: 1}
+
+	_, err := q.reserveEnroll(ctx, "1234", limits, true)
+	require.ErrorContains(t, err, "max_agents")
+	// the enrollment rejected by max_agents did not consume the enrollment rate
+	res, err := q.reserveEnroll(ctx, "1234", limits, true)
+	require.NoError(t, err)
+	res.done(1)
+	_, err = q.reserveEnroll(ctx, "1234", policy.Limits{MaxEnrollPerMinute: 1}, false)
+	require.ErrorContains(t, err, "max_enroll_per_minute")
+	bulker.AssertExpectations(t)
+}
+
+func Test_policyQuotas_evictIdle(t *testing.T) {
+	q := newPolicyQuotas(ftesting.NewMockBulk())
+	ctx := context.Background()
+	limits := policy.Limits{MaxEnrollPerMinute: 10}
+
+	_, err := q.reserveEnroll(ctx, "idle", limits, false)
+	require.NoError(t, err)
+	_, err = q.reserveEnroll(ctx, "busy", limits, false)
+	require.NoError(t, err)
+	require.Len(t, q.quotas, 2)
+
+	// the quotas not used for quotaIdleTimeout are forgotten by the next sweep
+	q.quotas["idle"].lastUsed = time.Now().Add(-quotaIdleTimeout)
+	q.lastSweep = time.Now().Add(-quotaIdleTimeout)
+	_, err = q.reserveEnroll(ctx, "busy", limits, false)
+	require.NoError(t, err)
+	require.Len(t, q.quotas, 1)
+	require.Contains(t, q.quotas, "busy")
+}
+
+func Test_policyQuotas_enroll(t *testing.T) {
+	pm := &limitsMonitor{limits: map[string]policy.Limits{
+		"1234": {MaxAgents: 1},
+	}}
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(agentHits(1), nil)
+	et, err := NewEnrollerT(nil, quotasTestCfg(0, 0), bulker, nil, WithEnrollPolicyMonitor(pm))
+	require.NoError(t, err)
+
+	req := &EnrollRequest{
+		Type: "PERMANENT",
+		Metadata: EnrollMetadata{
+			UserProvided: []byte("{}"),
+			Local:        []byte("{}"),
+		},
+	}
+	_, err = et._enroll(context.Background(), &rollback.Rollback{}, zerolog.Nop(), req, "1234", nil, "8.9.0")
+	require.ErrorIs(t, err, ErrPolicyQuota)
+	// no API key is created for the rejected enrollment
+	bulker.AssertNotCalled(t, "APIKeyCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_CheckinT_longPoll(t *testing.T) {
+	cfg := &config.Server{}
+	cfg.Timeouts.CheckinLongPoll = 5 * time.Minute
+	cfg.Timeouts.CheckinMaxPoll = 10 * time.Minute
+	pm := &limitsMonitor{limits: map[string]policy.Limits{
+		"short":    {CheckinLongPoll: time.Minute},
+		"long":     {CheckinLongPoll: time.Hour},
+		"no-limit": {},
+	}}
+	ct := &CheckinT{cfg: cfg, pm: pm}
+
+	require.Equal(t, time.Minute, ct.longPoll("short"))
+	require.Equal(t, 10*time.Minute, ct.longPoll("long"), "capped to the max poll")
+	require.Equal(t, 5*time.Minute, ct.longPoll("no-limit"))
+	require.Equal(t, 5*time.Minute, ct.longPoll("unknown"))
+
+	ct.pm = nil
+	require.Equal(t, 5*time.Minute, ct.longPoll("short"))
+
+	// the reloaded timeouts apply to the next checkins
+	reloaded := *cfg
+	reloaded.Timeouts.CheckinLongPoll = 30 * time.Second
+	ct.Reload(&reloaded)
+	require.Equal(t, 30*time.Second, ct.longPoll("short"))
+}
@@ -0,0 +1,81 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+// Reloader is implemented by the handlers and servers that apply a reloaded server configuration in place,
+// see config.Server.ApplyInPlace for the settings they apply.
+type Reloader interface {
+	Reload(cfg *config.Server)
+}
+
+// reloadedCfg holds the reloaded server configuration of a handler.
+type reloadedCfg struct {
+	p atomic.Pointer[config.Server]
+}
+
+// get returns the reloaded configuration, or cfg, the configuration the handler was created with, if none was reloaded.
+func (r *reloadedCfg) get(cfg *config.Server) *config.Server {
+	if reloaded := r.p.Load(); reloaded != nil {
+		return reloaded
+	}
+	return cfg
+}
+
+// routeLimits holds the endpoint limits and the max connections of a router, they are replaced when the configuration
+// is reloaded. The requests in progress release the limiter they acquired.
+type routeLimits struct {
+	p        atomic.Pointer[limiter]
+	throttle atomic.Pointer[connThrottle]
+}
+
+// connThrottle limits the requests served at once to max, they are not limited if max is not positive.
+type connThrottle struct {
+	max int
+	mw  func(http.Handler) http.Handler
+}
+
+func newRouteLimits(cfg *config.ServerLimits) *routeLimits {
+	l := &routeLimits{}
+	l.reload(cfg)
+	return l
+}
+
+func (l *routeLimits) reload(cfg *config.ServerLimits) {
+	l.p.Store(Limiter(cfg))
+	// the throttle is kept if the max is unchanged, so the requests in progress are still counted
+	if cur := l.throttle.Load(); cur == nil || cur.max != cfg.MaxConnections {
+		t := &connThrottle{max: cfg.MaxConnections}
+		if t.max > 0 {
+			t.mw = middleware.Throttle(t.max)
+		}
+		l.throttle.Store(t)
+	}
+}
+
+func (l *routeLimits) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.p.Load().middleware(next).ServeHTTP(w, r)
+	})
+}
+
+// throttleMiddleware limits the requests served at once to the max connections.
+func (l *routeLimits) throttleMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t := l.throttle.Load(); t.mw != nil {
+			t.mw(next).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
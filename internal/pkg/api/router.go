@@ -5,8 +5,10 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
@@ -15,22 +17,57 @@ import (
 	"go.elastic.co/apm/module/apmchiv5/v2"
 	"go.elastic.co/apm/v2"
 
+	"github.com/elastic/fleet-server/v7/internal/pkg/audittrail"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 )
 
-func newRouter(cfg *config.ServerLimits, si ServerInterface, tracer *apm.Tracer) http.Handler {
+var (
+	ErrRouteNotFound    = errors.New("route not found")
+	ErrMethodNotAllowed = errors.New("method not allowed")
+	ErrFeatureDisabled  = errors.New("feature disabled by incompatible mappings")
+)
+
+// routeMethods are the methods tried to list the allowed methods of a path.
+var routeMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// newRouter routes the requests to si, the requests of the administrative endpoints are recorded with trail if it is not nil.
+func newRouter(cfg *config.Server, si ServerInterface, tracer *apm.Tracer, trail *audittrail.Writer) http.Handler {
+	return newLimitedRouter(cfg, si, tracer, trail, newRouteLimits(&cfg.Limits), nil)
+}
+
+// newLimitedRouter is newRouter with the endpoint limits of limits, the requests of the disabled operations fail with
+// ErrFeatureDisabled.
+func newLimitedRouter(cfg *config.Server, si ServerInterface, tracer *apm.Tracer, trail *audittrail.Writer, limits *routeLimits, disabled []string) http.Handler {
 	r := chi.NewRouter()
+	routeErrors(r)
 	if tracer != nil {
 		r.Use(apmchiv5.Middleware(apmchiv5.WithTracer(tracer)))
 	}
-	r.Use(logger.Middleware) // Attach middlewares to router directly so the occur before any request parsing/validation
+	r.Use(skipHealthz(logger.Middleware)) // Attach middlewares to router directly so the occur before any request parsing/validation
+	r.Use(routeLogger)
 	r.Use(middleware.Recoverer)
-	if cfg.MaxConnections > 0 {
-		r.Use(middleware.Throttle(cfg.MaxConnections))
+	if trail != nil {
+		r.Use(auditTrail(trail))
 	}
-	r.Use(Limiter(cfg).middleware)
+	r.Use(skipHealthz(limits.throttleMiddleware))
+	if ceiling := limit.NewCeiling(&cfg.Limits.Concurrency); ceiling != nil {
+		r.Use(concurrencyCeiling(ceiling))
+	}
+	if len(disabled) > 0 {
+		r.Use(disableOperations(disabled))
+	}
+	r.Use(limits.middleware)
+	r.Use(newContentType(cfg.StrictContentType).middleware)
 	return HandlerWithOptions(si, ChiServerOptions{
 		BaseRouter:       r,
 		ErrorHandlerFunc: ErrorResp,
@@ -39,6 +76,57 @@ func newRouter(cfg *config.ServerLimits, si ServerInterface, tracer *apm.Tracer)
 	})
 }
 
+// newUnavailableRouter only routes the status and healthz requests to si, the other requests fail with err.
+func newUnavailableRouter(si ServerInterface, err error) http.Handler {
+	r := chi.NewRouter()
+	routeErrors(r)
+	r.Use(skipHealthz(logger.Middleware))
+	r.Use(routeLogger)
+	r.Use(middleware.Recoverer)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if op := pathToOperation(r.URL.Path); op != "status" && op != "healthz" {
+				ErrorResp(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	return HandlerWithOptions(si, ChiServerOptions{
+		BaseRouter:       r,
+		ErrorHandlerFunc: ErrorResp,
+	})
+}
+
+// disableOperations fails the requests of the operations, named as pathToOperation names them, with ErrFeatureDisabled.
+func disableOperations(operations []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(operations, pathToOperation(r.URL.Path)) {
+				ErrorResp(w, r, ErrFeatureDisabled)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// routeErrors responds to the requests of unknown paths or methods with the JSON body of the other errors.
+// The methods allowed on a known path are listed in the Allow header.
+func routeErrors(r *chi.Mux) {
+	r.NotFound(func(w http.ResponseWriter, req *http.Request) {
+		ErrorResp(w, req, ErrRouteNotFound)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, req *http.Request) {
+		for _, method := range routeMethods {
+			if r.Match(chi.NewRouteContext(), method, req.URL.Path) {
+				w.Header().Add("Allow", method)
+			}
+		}
+		ErrorResp(w, req, ErrMethodNotAllowed)
+	})
+}
+
 // limiter wraps routes with metrics and rate limits.
 //
 // auth is handled elsewhere.
@@ -54,6 +142,13 @@ type limiter struct {
 	deliverFile    *limit.Limiter
 	getPGPKey      *limit.Limiter
 	auditUnenroll  *limit.Limiter
+	createActions  *limit.Limiter
+	actionResults  *limit.Limiter
+	reassignAgents *limit.Limiter
+	agentTags      *limit.Limiter
+	policyFetch    *limit.Limiter
+	policyRollout  *limit.Limiter
+	bulkEnroll     *limit.Limiter
 }
 
 func Limiter(cfg *config.ServerLimits) *limiter {
@@ -69,6 +164,13 @@ func Limiter(cfg *config.ServerLimits) *limiter {
 		deliverFile:    limit.NewLimiter(&cfg.DeliverFileLimit),
 		getPGPKey:      limit.NewLimiter(&cfg.GetPGPKey),
 		auditUnenroll:  limit.NewLimiter(&cfg.AuditUnenrollLimit),
+		createActions:  limit.NewLimiter(&cfg.CreateActionsLimit),
+		actionResults:  limit.NewLimiter(&cfg.ActionResultsLimit),
+		reassignAgents: limit.NewLimiter(&cfg.ReassignAgentsLimit),
+		agentTags:      limit.NewLimiter(&cfg.AgentTagsLimit),
+		policyFetch:    limit.NewLimiter(&cfg.PolicyFetchLimit),
+		policyRollout:  limit.NewLimiter(&cfg.PolicyRolloutLimit),
+		bulkEnroll:     limit.NewLimiter(&cfg.BulkEnrollLimit),
 	}
 }
 
@@ -83,9 +185,21 @@ func pathToOperation(path string) string {
 	if path == "/api/status" {
 		return "status"
 	}
+	if path == "/api/fleet/healthz" {
+		return "healthz"
+	}
 	if path == "/api/fleet/uploads" {
 		return "uploadBegin"
 	}
+	if path == "/api/fleet/agents/actions" {
+		return "createActions"
+	}
+	if path == "/api/fleet/agents/reassign" {
+		return "reassignAgents"
+	}
+	if path == "/api/fleet/agents/bulk_enroll" {
+		return "bulkEnroll"
+	}
 	if pgpReg.MatchString(path) {
 		return "getPGPKey"
 	}
@@ -99,24 +213,81 @@ func pathToOperation(path string) string {
 				return "uploadComplete"
 			} else if pp[2] == "file" {
 				return "deliverFile"
+			} else if pp[2] == "operations" {
+				return "operations"
 			}
 		} else if len(pp) == 5 {
 			if pp[2] == "agents" {
 				if pp[4] == "acks" || pp[4] == "checkin" {
 					return pp[4]
+				} else if pp[4] == "tags" {
+					return "agentTags"
 				}
 			} else if pp[2] == "uploads" {
 				return "uploadChunk"
 			} else if pp[2] == "artifacts" {
 				return "artifact"
+			} else if pp[2] == "policies" {
+				if pp[4] == "rollout" {
+					return "policyRollout"
+				}
+				return "policyFetch"
+			}
+		} else if len(pp) == 6 && pp[2] == "agents" {
+			if pp[3] == "actions" && pp[5] == "results" {
+				return "actionResults"
+			} else if pp[4] == "audit" {
+				return "audit-" + pp[5]
+			} else if pp[4] == "tags" {
+				return "agentTags"
 			}
-		} else if len(pp) == 6 && pp[2] == "agents" && pp[4] == "audit" {
-			return "audit-" + pp[5]
 		}
 	}
 	return ""
 }
 
+// routeLogger adds the route of the request to the logger of the request context.
+// Handlers enrich the logger further, the agent id is added once the agent is authenticated.
+func routeLogger(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if route := pathToOperation(r.URL.Path); route != "" {
+			zlog := zerolog.Ctx(r.Context()).With().Str(logger.Route, route).Logger()
+			r = r.WithContext(zlog.WithContext(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// concurrencyCeiling applies the quota of the endpoint class of each request.
+// The status and healthz requests are cheap and bypass the ceiling, as do the requests of unknown routes.
+func concurrencyCeiling(ceiling *limit.Ceiling) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		classes := make(map[limit.Class]http.Handler, len(limit.Classes))
+		for _, class := range limit.Classes {
+			ll := zerolog.DebugLevel
+			if class == limit.ClassCheckin {
+				ll = zerolog.WarnLevel
+			}
+			classes[class] = ceiling.Wrap(class, cntConcurrency.classes[class], ll)(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch pathToOperation(r.URL.Path) {
+			case "", "status", "healthz":
+				next.ServeHTTP(w, r)
+			case "checkin":
+				classes[limit.ClassCheckin].ServeHTTP(w, r)
+			case "enroll":
+				classes[limit.ClassEnroll].ServeHTTP(w, r)
+			case "acks":
+				classes[limit.ClassAck].ServeHTTP(w, r)
+			default:
+				classes[limit.ClassOther].ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
 func (l *limiter) middleware(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		switch pathToOperation(r.URL.Path) {
@@ -142,8 +313,22 @@ func (l *limiter) middleware(next http.Handler) http.Handler {
 			l.status.Wrap("status", &cntStatus, zerolog.DebugLevel)(next).ServeHTTP(w, r)
 		case "audit-unenroll":
 			l.auditUnenroll.Wrap("audit-unenroll", &cntAuditUnenroll, zerolog.DebugLevel)(next).ServeHTTP(w, r)
+		case "createActions":
+			l.createActions.Wrap("createActions", &cntCreateActions, zerolog.DebugLevel)(next).ServeHTTP(w, r)
+		case "actionResults":
+			l.actionResults.Wrap("actionResults", &cntActionResults, zerolog.DebugLevel)(next).ServeHTTP(w, r)
+		case "reassignAgents":
+			l.reassignAgents.Wrap("reassignAgents", &cntReassignAgents, zerolog.DebugLevel)(next).ServeHTTP(w, r)
+		case "agentTags":
+			l.agentTags.Wrap("agentTags", &cntAgentTags, zerolog.DebugLevel)(next).ServeHTTP(w, r)
+		case "policyFetch":
+			l.policyFetch.Wrap("policyFetch", &cntPolicyFetch, zerolog.DebugLevel)(next).ServeHTTP(w, r)
+		case "policyRollout":
+			l.policyRollout.Wrap("policyRollout", &cntPolicyRollout, zerolog.DebugLevel)(next).ServeHTTP(w, r)
+		case "bulkEnroll":
+			l.bulkEnroll.Wrap("bulkEnroll", &cntBulkEnroll, zerolog.DebugLevel)(next).ServeHTTP(w, r)
 		default:
-			// no tracking or limits
+			// no tracking or limits, healthz included
 			next.ServeHTTP(w, r)
 		}
 	}
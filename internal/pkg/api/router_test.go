@@ -5,15 +5,21 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/elastic/elastic-agent-client/v7/pkg/client"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
+	"github.com/elastic/fleet-server/v7/internal/pkg/monitor/mock"
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.elastic.co/apm/v2/apmtest"
 )
 
 func TestPathToOperation(t *testing.T) {
@@ -24,6 +30,8 @@ func TestPathToOperation(t *testing.T) {
 		{"/api/status/", "status"},
 		{"/api/status", "status"},
 		{"/api/status/toolong", ""},
+		{"/api/fleet/healthz", "healthz"},
+		{"/api/fleet/healthz/", "healthz"},
 		{"/api/fleet/uploads", "uploadBegin"},
 		{"/api/fleet/upload", ""},
 		{"/api/fleet/agents/some-id", "enroll"},
@@ -33,8 +41,17 @@ func TestPathToOperation(t *testing.T) {
 		{"/api/fleet/uploads/some-id/0", "uploadChunk"},
 		{"/api/fleet/file", ""},
 		{"/api/fleet/file/abc", "deliverFile"},
+		{"/api/fleet/operations/abc", "operations"},
 		{"/api/fleet/artifacts/some-id/hash", "artifact"},
 		{"/api/fleet/agents/some-id/audit/unenroll", "audit-unenroll"},
+		{"/api/fleet/agents/actions", "createActions"},
+		{"/api/fleet/agents/actions/1234/results", "actionResults"},
+		{"/api/fleet/policies/some-id/2", "policyFetch"},
+		{"/api/fleet/policies/some-id/rollout", "policyRollout"},
+		{"/api/fleet/agents/reassign", "reassignAgents"},
+		{"/api/fleet/agents/bulk_enroll", "bulkEnroll"},
+		{"/api/fleet/agents/some-id/tags", "agentTags"},
+		{"/api/fleet/agents/some-id/tags/some-tag", "agentTags"},
 		{"/api/fleet/agents/some-id/other/unenroll", ""},
 		{"/api/fleet/unimplemented/some-id", ""},
 		{"/api/flet/agents/some-id/acks", ""},
@@ -103,3 +120,300 @@ func TestLimiter(t *testing.T) {
 		})
 	}
 }
+
+func TestConcurrencyCeiling(t *testing.T) {
+	var cfg config.Concurrency
+	cfg.InitDefaults()
+	cfg.Max = 10
+	cfg.QueueSize = 0
+	ceiling := limit.NewCeiling(&cfg)
+	require.NotNil(t, ceiling)
+
+	// the enrollments block until the end of the test, they use the quota of their class
+	unblock := make(chan struct{})
+	r := chi.NewRouter()
+	r.Use(concurrencyCeiling(ceiling))
+	r.HandleFunc("/*", func(w http.ResponseWriter, req *http.Request) {
+		if pathToOperation(req.URL.Path) == "enroll" {
+			<-unblock
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(method, path string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+
+	enrollStats := cntConcurrency.classes[limit.ClassEnroll]
+	inFlight := enrollStats.inFlight.metric.Get()
+	rejected := enrollStats.rejected.metric.Get()
+	done := make(chan int)
+	go func() {
+		done <- serve(http.MethodPost, "/api/fleet/agents/agent-1")
+	}()
+	require.Eventually(t, func() bool {
+		return enrollStats.inFlight.metric.Get() == inFlight+1
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, http.StatusTooManyRequests, serve(http.MethodPost, "/api/fleet/agents/agent-2"))
+	assert.Equal(t, rejected+1, enrollStats.rejected.metric.Get())
+	// the enrollment storm does not starve the other classes, and the status requests bypass the ceiling
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/fleet/agents/agent-1/acks"))
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/fleet/agents/agent-1/checkin"))
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/status"))
+
+	close(unblock)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, inFlight, enrollStats.inFlight.metric.Get())
+}
+
+func TestRouterTracing(t *testing.T) {
+	sm := mock.NewMockMonitor()
+	sm.On("State").Return(client.UnitStateHealthy)
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	si := &apiServer{st: NewStatusT(cfg, nil, nil, WithSelfMonitor(sm))}
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	hr := newRouter(cfg, si, tracer.Tracer, nil)
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		hr.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	tracer.Flush(nil)
+
+	// one transaction per request
+	txs := tracer.Payloads().Transactions
+	assert.Len(t, txs, 2)
+	for _, tx := range txs {
+		assert.Equal(t, "GET /api/status", tx.Name)
+		assert.Equal(t, "request", tx.Type)
+	}
+}
+
+func TestRouterErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		errStr string
+		allow  []string
+	}{{
+		name:   "unknown path",
+		method: http.MethodGet,
+		path:   "/api/fleet/unknown",
+		status: http.StatusNotFound,
+		errStr: "RouteNotFound",
+	}, {
+		name:   "unknown method",
+		method: http.MethodDelete,
+		path:   "/api/status",
+		status: http.StatusMethodNotAllowed,
+		errStr: "MethodNotAllowed",
+		allow:  []string{http.MethodGet},
+	}, {
+		name:   "unknown method with path parameter",
+		method: http.MethodGet,
+		path:   "/api/fleet/agents/some-id/tags",
+		status: http.StatusMethodNotAllowed,
+		errStr: "MethodNotAllowed",
+		allow:  []string{http.MethodPost},
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Server{}
+			cfg.InitDefaults()
+			hr := newRouter(cfg, Unimplemented{}, nil, nil)
+
+			w := httptest.NewRecorder()
+			hr.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+			require.Equal(t, tc.status, w.Code)
+			require.Equal(t, tc.allow, w.Header().Values("Allow"))
+			require.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+
+			var resp HTTPErrResp
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			require.Equal(t, tc.status, resp.StatusCode)
+			require.Equal(t, tc.errStr, resp.Error)
+		})
+	}
+}
+
+func TestRouterContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		strict      bool
+		method      string
+		path        string
+		contentType string
+		status      int
+	}{{
+		name:        "json",
+		strict:      true,
+		method:      http.MethodPost,
+		path:        "/api/fleet/agents/some-id/checkin",
+		contentType: "application/json",
+		status:      http.StatusNotImplemented,
+	}, {
+		name:        "json with parameters",
+		strict:      true,
+		method:      http.MethodPost,
+		path:        "/api/fleet/agents/some-id/checkin",
+		contentType: "Application/JSON; charset=utf-8",
+		status:      http.StatusNotImplemented,
+	}, {
+		name:        "strict other content type",
+		strict:      true,
+		method:      http.MethodPost,
+		path:        "/api/fleet/agents/some-id/checkin",
+		contentType: "text/plain",
+		status:      http.StatusUnsupportedMediaType,
+	}, {
+		name:   "strict missing content type",
+		strict: true,
+		method: http.MethodPost,
+		path:   "/api/fleet/agents/enroll",
+		status: http.StatusUnsupportedMediaType,
+	}, {
+		name:        "strict malformed content type",
+		strict:      true,
+		method:      http.MethodPost,
+		path:        "/api/fleet/agents/some-id/acks",
+		contentType: "application/json; charset",
+		status:      http.StatusUnsupportedMediaType,
+	}, {
+		name:        "strict upload chunk",
+		strict:      true,
+		method:      http.MethodPut,
+		path:        "/api/fleet/uploads/some-id/0",
+		contentType: "application/octet-stream",
+		status:      http.StatusNotImplemented,
+	}, {
+		name:        "legacy other content type",
+		method:      http.MethodPost,
+		path:        "/api/fleet/agents/some-id/checkin",
+		contentType: "text/plain",
+		status:      http.StatusNotImplemented,
+	}, {
+		name:   "legacy missing content type",
+		method: http.MethodPost,
+		path:   "/api/fleet/agents/enroll",
+		status: http.StatusNotImplemented,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Server{}
+			cfg.InitDefaults()
+			cfg.StrictContentType = tc.strict
+			hr := newRouter(cfg, Unimplemented{}, nil, nil)
+
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			r.Header.Set("User-Agent", "elastic agent 8.15.0")
+			r.Header.Set("X-Chunk-SHA2", "abc")
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			w := httptest.NewRecorder()
+			hr.ServeHTTP(w, r)
+			require.Equal(t, tc.status, w.Code)
+			if tc.status == http.StatusUnsupportedMediaType {
+				var resp HTTPErrResp
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+				require.Equal(t, "UnsupportedMediaType", resp.Error)
+			}
+		})
+	}
+}
+
+func TestServerReloadLimits(t *testing.T) {
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Limits.CheckinLimit = config.Limit{Interval: time.Hour, Burst: 1}
+	srv := NewServer("localhost:0", cfg, WithCheckin(&CheckinT{cfg: cfg}))
+	hr := srv.handler
+
+	checkin := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/some-id/checkin", nil)
+		r.Header.Set("User-Agent", "elastic agent 8.15.0")
+		w := httptest.NewRecorder()
+		hr.ServeHTTP(w, r)
+		return w.Code
+	}
+	require.NotEqual(t, http.StatusTooManyRequests, checkin())
+	require.Equal(t, http.StatusTooManyRequests, checkin())
+
+	// the reloaded limits apply to the next requests of the running server
+	reloaded := *cfg
+	reloaded.Limits.CheckinLimit = config.Limit{}
+	srv.Reload(&reloaded)
+	require.NotEqual(t, http.StatusTooManyRequests, checkin())
+	require.NotEqual(t, http.StatusTooManyRequests, checkin())
+}
+
+func TestRouteLimitsReloadMaxConnections(t *testing.T) {
+	limits := newRouteLimits(&config.ServerLimits{MaxConnections: 1})
+	block := make(chan struct{})
+	hr := limits.throttleMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			<-block
+		}
+	}))
+	serve := func(path string) int {
+		w := httptest.NewRecorder()
+		hr.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	done := make(chan int)
+	go func() {
+		done <- serve("/block")
+	}()
+	require.Eventually(t, func() bool { return serve("/") == http.StatusTooManyRequests }, time.Second, time.Millisecond)
+
+	// a reload that keeps the max still counts the request in progress
+	limits.reload(&config.ServerLimits{MaxConnections: 1, CheckinLimit: config.Limit{Burst: 1}})
+	require.Equal(t, http.StatusTooManyRequests, serve("/"))
+
+	// a raised max applies to the next requests, without a restart of the router
+	limits.reload(&config.ServerLimits{MaxConnections: 2})
+	require.Equal(t, http.StatusOK, serve("/"))
+	limits.reload(&config.ServerLimits{})
+	require.Equal(t, http.StatusOK, serve("/"))
+
+	close(block)
+	require.Equal(t, http.StatusOK, <-done)
+}
+
+func TestServerDisabledOperations(t *testing.T) {
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	srv := NewServer("localhost:0", cfg, WithDisabledOperations("createActions", "agentTags"))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"disabled", http.MethodPost, "/api/fleet/agents/actions", http.StatusServiceUnavailable},
+		{"disabled with path parameter", http.MethodPost, "/api/fleet/agents/some-id/tags", http.StatusServiceUnavailable},
+		{"enabled", http.MethodGet, "/api/fleet/unknown", http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			srv.handler.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+			require.Equal(t, tc.status, w.Code)
+			if tc.status == http.StatusServiceUnavailable {
+				var resp HTTPErrResp
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+				require.Equal(t, "FeatureDisabled", resp.Error)
+			}
+		})
+	}
+}
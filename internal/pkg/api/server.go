@@ -13,17 +13,25 @@ import (
 	"net"
 	"net/http"
 
+	"github.com/elastic/elastic-agent-client/v7/pkg/client"
 	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/build"
+	"github.com/elastic/fleet-server/v7/internal/pkg/clientip"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 
 	"github.com/rs/zerolog"
 )
 
 type server struct {
-	cfg     *config.Server
-	addr    string
-	handler http.Handler
+	cfg      *config.Server
+	addr     string
+	handler  http.Handler
+	health   *httpHealth
+	limits   *routeLimits
+	reloaded reloadedCfg
 }
 
 // NewServer creates a new HTTP api for the passed addr.
@@ -31,17 +39,58 @@ type server struct {
 // The server has an http request limit and endpoint specific rate-limits.
 // The underlying API structs (such as *CheckinT) may be shared between servers.
 func NewServer(addr string, cfg *config.Server, opts ...APIOpt) *server {
-	a := &apiServer{}
+	a := &apiServer{health: &httpHealth{}}
 	for _, opt := range opts {
 		opt(a)
 	}
+	limits := newRouteLimits(&cfg.Limits)
 	return &server{
 		addr:    addr,
 		cfg:     cfg,
-		handler: newRouter(&cfg.Limits, a, a.tracer),
+		handler: newLimitedRouter(cfg, a, a.tracer, a.trail, limits, a.disabled),
+		health:  a.health,
+		limits:  limits,
 	}
 }
 
+// Reload applies the endpoint limits and the max connections of cfg to the requests served from now on, and its drain
+// timeout to the shutdown. The listener is kept.
+func (s *server) Reload(cfg *config.Server) {
+	s.reloaded.p.Store(cfg)
+	if s.limits != nil {
+		s.limits.reload(&cfg.Limits)
+	}
+}
+
+// NewUnavailableServer creates an HTTP api for the passed addr that is served while fleet-server waits for elasticsearch.
+//
+// The status endpoint reports state without authenticating the request, the other endpoints fail with ElasticsearchUnavailable.
+func NewUnavailableServer(addr string, cfg *config.Server, state client.UnitState, bi build.Info) *server {
+	st := NewStatusT(cfg, nil, nil, WithSelfMonitor(fixedState(state)), WithBuildInfo(bi))
+	st.authfn = func(*http.Request) (*apikey.APIKey, error) {
+		return nil, es.ErrUnavailable
+	}
+	health := &httpHealth{}
+	return &server{
+		addr:    addr,
+		cfg:     cfg,
+		handler: newUnavailableRouter(&apiServer{st: st, health: health}, es.ErrUnavailable),
+		health:  health,
+	}
+}
+
+// fixedState is a policy.SelfMonitor that always reports the same state.
+type fixedState client.UnitState
+
+func (s fixedState) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (s fixedState) State() client.UnitState {
+	return client.UnitState(s)
+}
+
 func (s *server) Run(ctx context.Context) error {
 	rdto := s.cfg.Timeouts.Read
 	wrto := s.cfg.Timeouts.Write
@@ -49,9 +98,14 @@ func (s *server) Run(ctx context.Context) error {
 	rdhr := s.cfg.Timeouts.ReadHeader
 	mhbz := s.cfg.Limits.MaxHeaderByteSize
 
+	proxies, err := clientip.New(s.cfg.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
 	srv := http.Server{
 		Addr:              s.addr,
-		Handler:           s.handler,
+		Handler:           clientip.Middleware(proxies)(s.handler),
 		ReadTimeout:       rdto,
 		ReadHeaderTimeout: rdhr,
 		WriteTimeout:      wrto,
@@ -104,12 +158,15 @@ func (s *server) Run(ctx context.Context) error {
 	select {
 	// Listen and return any errors that occur from the server listener
 	case err := <-errCh:
+		s.health.stop()
 		if !errors.Is(err, context.Canceled) {
 			return fmt.Errorf("error while serving API listener: %w", err)
 		}
 	// Do a clean shutdown if the context is cancelled
 	case <-ctx.Done():
-		sCtx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeouts.Drain) // Background context to allow connections to drain when server context is cancelled.
+		// the probes of the draining connections fail
+		s.health.stop()
+		sCtx, cancel := context.WithTimeout(context.Background(), s.reloaded.get(s.cfg).Timeouts.Drain) // Background context to allow connections to drain when server context is cancelled.
 		defer cancel()
 		if err := srv.Shutdown(sCtx); err != nil {
 			cErr := srv.Close() // force it closed
@@ -0,0 +1,117 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+// upgradeRetryPlan is what is done after an agent failed the upgrade of an UPGRADE action.
+type upgradeRetryPlan struct {
+	// retry is the action re-issued to the agent, nil when the upgrade is not retried.
+	retry *model.Action
+	// exhausted is set when the last attempt failed, the upgrade status of the agent is then upgrade_failed.
+	exhausted bool
+}
+
+// planUpgradeRetry prepares the retry of the failed upgrade of action when the retries are enabled.
+// The upgrade is not retried when it is superseded by a newer UPGRADE action addressed to the agent, or when the action
+// is signed since the signature is bound to the action ID.
+func (ack *AckT) planUpgradeRetry(ctx context.Context, agent *model.Agent, action model.Action) (upgradeRetryPlan, error) {
+	cfg := ack.serverCfg().Actions.UpgradeRetry
+	if !cfg.Enabled() || action.Type != string(UPGRADE) {
+		return upgradeRetryPlan{}, nil
+	}
+	span, ctx := apm.StartSpan(ctx, "planUpgradeRetry", "process")
+	defer span.End()
+	if len(action.Data) == 0 {
+		// the cached actions only hold their ID and type
+		actions, err := dl.FindAction(ctx, ack.bulk, action.ActionID)
+		if err != nil {
+			return upgradeRetryPlan{}, fmt.Errorf("planUpgradeRetry find action: %w", err)
+		}
+		if len(actions) == 0 {
+			return upgradeRetryPlan{}, nil
+		}
+		action = actions[0]
+	}
+	attempt := int(max(action.UpgradeAttempt, 1))
+	zlog := zerolog.Ctx(ctx).With().Str(logger.ActionID, action.ActionID).Int("attempt", attempt).Logger()
+
+	if attempt >= cfg.MaxAttempts {
+		zlog.Info().Int("max_attempts", cfg.MaxAttempts).Msg("upgrade attempts exhausted")
+		return upgradeRetryPlan{exhausted: true}, nil
+	}
+	if action.Signed != nil {
+		zlog.Info().Msg("signed upgrade action is not retried")
+		return upgradeRetryPlan{}, nil
+	}
+	newer, err := dl.FindNewerAgentAction(ctx, ack.bulk, agent.Id, string(UPGRADE), action.ActionID, action.SeqNo)
+	switch {
+	case err == nil:
+		zlog.Info().Str("newer_action_id", newer.ActionID).Msg("failed upgrade superseded by a newer upgrade action, not retried")
+		return upgradeRetryPlan{}, nil
+	case !errors.Is(err, dl.ErrNotFound):
+		return upgradeRetryPlan{}, fmt.Errorf("planUpgradeRetry find newer action: %w", err)
+	}
+
+	retry, err := newUpgradeRetry(agent.Id, action, attempt+1, time.Now().UTC().Add(cfg.Delay(attempt)))
+	if err != nil {
+		return upgradeRetryPlan{}, err
+	}
+	return upgradeRetryPlan{retry: retry}, nil
+}
+
+// newUpgradeRetry returns the action that re-issues the upgrade of action to the agent at start.
+// The retry keeps the time the original action was valid for after its start, or the default action expiration.
+func newUpgradeRetry(agentID string, action model.Action, attempt int, start time.Time) (*model.Action, error) {
+	u, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("newUpgradeRetry uuid: %w", err)
+	}
+	lifetime := defaultActionExpiration
+	if action.Expiration != "" {
+		from := action.StartTime
+		if from == "" {
+			from = action.Timestamp
+		}
+		expiration, errExp := ftime.Parse(action.Expiration)
+		begin, errBegin := ftime.Parse(from)
+		if errExp == nil && errBegin == nil && expiration.After(begin) {
+			lifetime = expiration.Sub(begin)
+		}
+	}
+	retryOf := action.RetryOf
+	if retryOf == "" {
+		retryOf = action.ActionID
+	}
+	return &model.Action{
+		ActionID:                 u.String(),
+		Agents:                   []string{agentID},
+		Data:                     action.Data,
+		Expiration:               ftime.Format(start.Add(lifetime)),
+		MinimumExecutionDuration: action.MinimumExecutionDuration,
+		Namespaces:               action.Namespaces,
+		RetryOf:                  retryOf,
+		StartTime:                ftime.Format(start),
+		Timeout:                  action.Timeout,
+		Timestamp:                ftime.Now(),
+		Type:                     action.Type,
+		UpgradeAttempt:           int64(attempt),
+		UserID:                   action.UserID,
+	}, nil
+}
@@ -47,10 +47,10 @@ func maximizePatch(ver *version.Version) string {
 
 // validateUserAgent validates that the User-Agent of the connecting Elastic Agent is valid and that the version is
 // supported for this Fleet Server.
-func validateUserAgent(ctx context.Context, zlog zerolog.Logger, userAgent string, verConst version.Constraints) (string, error) {
+func validateUserAgent(ctx context.Context, userAgent string, verConst version.Constraints) (string, error) {
 	span, _ := apm.StartSpan(ctx, "userAgent", "validate")
 	defer span.End()
-	zlog = zlog.With().Str("userAgent", userAgent).Logger()
+	zlog := zerolog.Ctx(ctx).With().Str("userAgent", userAgent).Logger()
 
 	if userAgent == "" {
 		zlog.Info().
@@ -12,7 +12,6 @@ import (
 	"testing"
 
 	"github.com/hashicorp/go-version"
-	"github.com/rs/zerolog"
 )
 
 func TestValidateUserAgent(t *testing.T) {
@@ -114,7 +113,7 @@ func TestValidateUserAgent(t *testing.T) {
 	}
 	for _, tr := range tests {
 		t.Run(tr.userAgent, func(t *testing.T) {
-			_, res := validateUserAgent(context.Background(), zerolog.Nop(), tr.userAgent, tr.verCon)
+			_, res := validateUserAgent(context.Background(), tr.userAgent, tr.verCon)
 			if !errors.Is(tr.err, res) {
 				t.Fatalf("err mismatch: %v != %v", tr.err, res)
 			}
@@ -0,0 +1,104 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/schema"
+)
+
+// requestValidator is implemented by the requests that check their fields once decoded.
+// The request types are generated from the OpenAPI spec, the limits set by the validate tags of their fields are
+// checked by the schema package, the checks that the tags can't express live here.
+type requestValidator interface {
+	Validate() error
+}
+
+// decodeRequest decodes the body into v with decodeBody and checks it with checkRequest.
+// A body that can't be decoded is returned as a BadRequestErr for the named request.
+func decodeRequest(ctx context.Context, body io.Reader, v any, name string) error {
+	if err := decodeBody(ctx, body, v); err != nil {
+		return &BadRequestErr{msg: "unable to decode " + name + " request", nextErr: err}
+	}
+	return checkRequest(v, name)
+}
+
+// checkRequest checks the validate tags of the fields of the decoded request v, and validates it if v is a
+// requestValidator. The fields that fail their tags are returned as a BadRequestErr for the named request, the errors
+// of Validate are returned as is.
+func checkRequest(v any, name string) error {
+	if err := schema.Validate(v); err != nil {
+		return &BadRequestErr{msg: name + " " + err.Error(), nextErr: err}
+	}
+	if rv, ok := v.(requestValidator); ok {
+		return rv.Validate()
+	}
+	return nil
+}
+
+// Validate checks that the poll_timeout is a duration.
+// The status is not checked against the known values, the agents may report states that fleet-server does not know
+// yet, see isKnown.
+func (req *CheckinRequest) Validate() error {
+	if req.PollTimeout != nil {
+		if _, err := time.ParseDuration(*req.PollTimeout); err != nil {
+			return &BadRequestErr{msg: "poll_timeout cannot be parsed as duration", nextErr: err}
+		}
+	}
+	return nil
+}
+
+// isKnown returns true if the status is one of the values of the spec.
+func (s CheckinRequestStatus) isKnown() bool {
+	switch s {
+	case CheckinRequestStatusDegraded, CheckinRequestStatusError, CheckinRequestStatusOnline, CheckinRequestStatusStarting:
+		return true
+	}
+	return false
+}
+
+// pollTimeout returns the poll_timeout of a validated request, or 0 if it is not set.
+func (req *CheckinRequest) pollTimeout() time.Duration {
+	if req.PollTimeout == nil {
+		return 0
+	}
+	d, _ := time.ParseDuration(*req.PollTimeout)
+	return d
+}
+
+// Validate checks the enrollment type and that the tags are within the limits of the agent tags.
+func (req *EnrollRequest) Validate() error {
+	switch req.Type {
+	case EnrollEphemeral, EnrollPermanent, EnrollTemporary:
+	default:
+		return ErrUnknownEnrollType
+	}
+	for _, tag := range req.Metadata.Tags {
+		if n := utf8.RuneCountInString(tag); n > maxAgentTagLength {
+			return &BadRequestErr{msg: fmt.Sprintf("enroll request tag of %d characters exceeds the max of %d", n, maxAgentTagLength)}
+		}
+	}
+	return nil
+}
+
+// Validate checks the validate tags of the events, they are unions the schema package does not decode.
+// The number of events is bounded by the decoding of the request.
+func (req *AckRequest) Validate() error {
+	for i, ev := range req.Events {
+		event, err := ev.AsGenericEvent()
+		if err != nil {
+			return &BadRequestErr{msg: fmt.Sprintf("ack event %d is not valid", i), nextErr: err}
+		}
+		if err := schema.Validate(&event); err != nil {
+			return &BadRequestErr{msg: fmt.Sprintf("ack event %d %s", i, err), nextErr: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		err  string
+	}{{
+		name: "valid",
+		body: `{"status":"online","message":"ok","poll_timeout":"5m"}`,
+	}, {
+		name: "invalid JSON",
+		body: `{"status":`,
+		err:  "Bad request: unable to decode checkin request",
+	}, {
+		name: "missing status",
+		body: `{"message":"ok"}`,
+		err:  "Bad request: checkin status missing",
+	}, {
+		name: "unknown status",
+		body: `{"status":"sleeping","message":"ok","poll_timeout":"5m"}`,
+	}, {
+		name: "invalid poll_timeout",
+		body: `{"status":"degraded","message":"ok","poll_timeout":"soon"}`,
+		err:  "Bad request: poll_timeout cannot be parsed as duration",
+	}, {
+		name: "long message",
+		body: `{"status":"online","message":"` + strings.Repeat("m", 16*1024+1) + `"}`,
+		err:  "Bad request: checkin message is longer than 16384 bytes",
+	}, {
+		name: "long ack_token",
+		body: `{"status":"online","message":"ok","ack_token":"` + strings.Repeat("t", 512+1) + `"}`,
+		err:  "Bad request: checkin ack_token is longer than 512 bytes",
+	}, {
+		name: "long upgrade target_version",
+		body: `{"status":"online","message":"ok","upgrade_details":{"action_id":"a-1","state":"UPG_REQUESTED","target_version":"` + strings.Repeat("9", 256+1) + `"}}`,
+		err:  "Bad request: checkin upgrade_details.target_version is longer than 256 bytes",
+	}, {
+		name: "nested too deep",
+		body: `{"status":"online","message":"ok","local_metadata":` + strings.Repeat(`{"a":`, maxJSONDepth) + `{}` + strings.Repeat(`}`, maxJSONDepth) + `}`,
+		err:  "Bad request: unable to decode checkin request",
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var req CheckinRequest
+			err := decodeRequest(context.Background(), strings.NewReader(tc.body), &req, "checkin")
+			if tc.err != "" {
+				var bErr *BadRequestErr
+				require.ErrorAs(t, err, &bErr)
+				require.Equal(t, tc.err, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, 5*time.Minute, req.pollTimeout())
+		})
+	}
+
+	t.Run("request without validation", func(t *testing.T) {
+		var req AgentTagsRequest
+		require.NoError(t, decodeRequest(context.Background(), strings.NewReader(`{}`), &req, "agent tags"))
+	})
+}
+
+func TestEnrollRequestValidate(t *testing.T) {
+	long := strings.Repeat("a", 512+1)
+	tests := []struct {
+		name string
+		req  EnrollRequest
+		err  error
+	}{
+		{name: "permanent", req: EnrollRequest{Type: EnrollPermanent}},
+		{name: "id", req: EnrollRequest{Type: EnrollEphemeral, Id: ptr(strings.Repeat("a", 512))}},
+		{name: "unknown type", req: EnrollRequest{Type: "SOMETIMES"}, err: ErrUnknownEnrollType},
+		{name: "missing type", req: EnrollRequest{}, err: ErrUnknownEnrollType},
+		{name: "long id", req: EnrollRequest{Type: EnrollPermanent, Id: &long}, err: &BadRequestErr{}},
+		{name: "long enrollment_id", req: EnrollRequest{Type: EnrollPermanent, EnrollmentId: &long}, err: &BadRequestErr{}},
+		{name: "long shared_id", req: EnrollRequest{Type: EnrollPermanent, SharedId: &long}, err: &BadRequestErr{}},
+		{name: "long replace_token", req: EnrollRequest{Type: EnrollPermanent, ReplaceToken: ptr(strings.Repeat("r", 1024+1))}, err: &BadRequestErr{}},
+		{name: "max tags", req: EnrollRequest{Type: EnrollPermanent, Metadata: EnrollMetadata{Tags: make([]string, maxAgentTags)}}},
+		{name: "too many tags", req: EnrollRequest{Type: EnrollPermanent, Metadata: EnrollMetadata{Tags: make([]string, maxAgentTags+1)}}, err: &BadRequestErr{}},
+		{name: "long tag", req: EnrollRequest{Type: EnrollPermanent, Metadata: EnrollMetadata{Tags: []string{strings.Repeat("é", maxAgentTagLength)}}}},
+		{name: "too long tag", req: EnrollRequest{Type: EnrollPermanent, Metadata: EnrollMetadata{Tags: []string{strings.Repeat("é", maxAgentTagLength+1)}}}, err: &BadRequestErr{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkRequest(&tc.req, "enroll")
+			switch expErr := tc.err.(type) {
+			case nil:
+				require.NoError(t, err)
+			case *BadRequestErr:
+				require.ErrorAs(t, err, &expErr)
+			default:
+				require.ErrorIs(t, err, expErr)
+			}
+		})
+	}
+}
+
+func TestAckRequestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		err  string
+	}{{
+		name: "valid",
+		body: `{"events":[{"action_id":"a-1","agent_id":"agent-1","message":"done"}]}`,
+	}, {
+		name: "long action_id",
+		body: `{"events":[{"action_id":"a-1"},{"action_id":"` + strings.Repeat("a", 512+1) + `"}]}`,
+		err:  "Bad request: ack event 1 action_id is longer than 512 bytes",
+	}, {
+		name: "long agent_id",
+		body: `{"events":[{"action_id":"a-1","agent_id":"` + strings.Repeat("a", 512+1) + `"}]}`,
+		err:  "Bad request: ack event 0 agent_id is longer than 512 bytes",
+	}, {
+		name: "long message",
+		body: `{"events":[{"action_id":"a-1","message":"` + strings.Repeat("m", 16*1024+1) + `"}]}`,
+		err:  "Bad request: ack event 0 message is longer than 16384 bytes",
+	}, {
+		name: "event not an object",
+		body: `{"events":["a-1"]}`,
+		err:  "Bad request: ack event 0 is not valid",
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var req AckRequest
+			err := decodeRequest(context.Background(), strings.NewReader(tc.body), &req, "ack")
+			if tc.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			var bErr *BadRequestErr
+			require.ErrorAs(t, err, &bErr)
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+// TestRequestJSONRoundTrip checks that the requests decoded from the agent bodies are encoded back to the same JSON,
+// the optional fields that are not set are omitted.
+func TestRequestJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		req  func() any
+		body string
+	}{{
+		name: "checkin",
+		req:  func() any { return &CheckinRequest{} },
+		body: `{"ack_token":"token","status":"online","message":"Running","poll_timeout":"5m","local_metadata":{"elastic":{"agent":{"id":"agent-1"}}},"components":[{"id":"c-1"}],"upgrade_details":{"action_id":"a-1","state":"UPG_DOWNLOADING","target_version":"9.1.0","metadata":{}}}`,
+	}, {
+		name: "checkin required fields",
+		req:  func() any { return &CheckinRequest{} },
+		body: `{"status":"starting","message":""}`,
+	}, {
+		name: "ack",
+		req:  func() any { return &AckRequest{} },
+		body: `{"events":[{"action_id":"a-1","agent_id":"agent-1","message":"done","subtype":"ACKNOWLEDGED","timestamp":"2025-01-02T03:04:05Z","type":"ACTION_RESULT","error":"failed"}]}`,
+	}, {
+		name: "ack without events",
+		req:  func() any { return &AckRequest{} },
+		body: `{"events":null}`,
+	}, {
+		name: "enroll",
+		req:  func() any { return &EnrollRequest{} },
+		body: `{"type":"PERMANENT","id":"agent-1","enrollment_id":"e-1","replace_token":"replace","shared_id":"","metadata":{"local":{"elastic":{"agent":{"version":"9.1.0"}}},"tags":["linux"],"user_provided":{}}}`,
+	}, {
+		name: "enroll required fields",
+		req:  func() any { return &EnrollRequest{} },
+		body: `{"type":"EPHEMERAL","metadata":{"local":{},"tags":null,"user_provided":null}}`,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := tc.req()
+			require.NoError(t, decodeRequest(context.Background(), strings.NewReader(tc.body), req, tc.name))
+			p, err := json.Marshal(req)
+			require.NoError(t, err)
+			require.JSONEq(t, tc.body, string(p))
+		})
+	}
+}
+
+// TestResponseJSONRoundTrip checks that the responses decoded by the agents are encoded back to the same JSON,
+// the optional fields that are not set are omitted.
+func TestResponseJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		resp func() any
+		body string
+	}{{
+		name: "checkin",
+		resp: func() any { return &CheckinResponse{} },
+		body: `{"ack_token":"sqn:1","action":"checkin","next_checkin_delay":"30s","actions":[{"agent_id":"agent-1","created_at":"2025-01-01T00:00:00Z","data":{"version":"9.1.0"},"expiration":"2025-01-03T00:00:00Z","id":"upgrade-1","input_type":"","signed":{"data":"ZGF0YQ==","signature":"c2ln"},"start_time":"2025-01-02T00:00:00Z","timeout":300,"traceparent":"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01","type":"UPGRADE"}]}`,
+	}, {
+		name: "checkin required fields",
+		resp: func() any { return &CheckinResponse{} },
+		body: `{"action":"checkin"}`,
+	}, {
+		name: "checkin v2",
+		resp: func() any { return &CheckinResponseV2{} },
+		body: `{"ack_token":"sqn:1","action":"checkin","actions":[{"agent_id":"agent-1","created_at":"2025-01-01T00:00:00Z","id":"cancel-1","type":"CANCEL","cancel":{"target_id":"upgrade-1"}},{"agent_id":"agent-1","created_at":"2025-01-01T00:00:00Z","id":"settings-1","type":"SETTINGS","settings":{"log_level":"debug"}}]}`,
+	}, {
+		name: "ack",
+		resp: func() any { return &AckResponse{} },
+		body: `{"action":"acks","errors":true,"items":[{"status":200},{"message":"Not Found","status":404}]}`,
+	}, {
+		name: "ack required fields",
+		resp: func() any { return &AckResponse{} },
+		body: `{"action":"acks"}`,
+	}, {
+		name: "enroll",
+		resp: func() any { return &EnrollResponse{} },
+		body: `{"action":"created","item":{"access_api_key":"key","access_api_key_id":"key-id","actions":[],"active":true,"enrolled_at":"2025-01-01T00:00:00Z","id":"agent-1","local_metadata":{"elastic":{"agent":{"id":"agent-1"}}},"policy_id":"policy-1","status":"online","tags":["linux"],"type":"PERMANENT","user_provided_metadata":{}}}`,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := tc.resp()
+			require.NoError(t, json.Unmarshal([]byte(tc.body), resp))
+			p, err := json.Marshal(resp)
+			require.NoError(t, err)
+			require.JSONEq(t, tc.body, string(p))
+		})
+	}
+}
@@ -12,6 +12,9 @@ import (
 	"github.com/hashicorp/go-version"
 )
 
+// agentMinorAllowance is the number of minor versions an Elastic Agent may be ahead of the Fleet Server.
+const agentMinorAllowance = 0
+
 // BuildVersionConstraint turns the version into a constraint to ensure that the connecting Elastic Agent's are
 // a supported version.
 func BuildVersionConstraint(verStr string) (version.Constraints, error) {
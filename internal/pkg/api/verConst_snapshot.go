@@ -14,6 +14,10 @@ import (
 	"github.com/hashicorp/go-version"
 )
 
+// agentMinorAllowance is the number of minor versions an Elastic Agent may be ahead of the Fleet Server.
+// Snapshot builds allow the next minor in order to allow automated testing to proceed.
+const agentMinorAllowance = 1
+
 // BuildVersionConstraint turns the version into a constraint to ensure that the connecting Elastic Agent's are
 // a supported version.
 // For snapshot builds we allow the minor version to be newer in order to allow automated testing to proceed.
@@ -39,3 +39,12 @@ func TestVersionConstraint(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckAgentVersionSnapshot(t *testing.T) {
+	serverVer, err := ParseVersion("8.0.0-SNAPSHOT")
+	require.NoError(t, err)
+
+	require.NoError(t, checkAgentVersion("8.1.0-SNAPSHOT", serverVer))
+	require.ErrorIs(t, checkAgentVersion("8.2.0-SNAPSHOT", serverVer), ErrAgentVersionNotSupported)
+	require.ErrorIs(t, checkAgentVersion("9.0.0", serverVer), ErrAgentVersionNotSupported)
+}
@@ -20,7 +20,10 @@ import (
 )
 
 const (
-	authPrefix = "ApiKey "
+	authPrefix   = "ApiKey "
+	bearerPrefix = "Bearer "
+
+	serviceAccountRealm = "_service_account"
 )
 
 var (
@@ -147,3 +150,31 @@ func ExtractAPIKey(r *http.Request) (*APIKey, error) {
 	apiKeyStr = strings.TrimSpace(apiKeyStr)
 	return NewAPIKeyFromToken(apiKeyStr)
 }
+
+// ExtractServiceToken gathers the Elasticsearch service token associated with the request.
+func ExtractServiceToken(r *http.Request) (string, error) {
+	s, ok := r.Header[AuthKey]
+	if !ok {
+		return "", ErrNoAuthHeader
+	}
+	if len(s) != 1 || !strings.HasPrefix(s[0], bearerPrefix) {
+		return "", ErrMalformedHeader
+	}
+
+	token := strings.TrimSpace(s[0][len(bearerPrefix):])
+	if token == "" {
+		return "", ErrMalformedToken
+	}
+	return token, nil
+}
+
+// ServiceTokenName returns the "namespace/service/name" part of a service token, used for logging and auditing.
+// An empty string is returned if the token can not be decoded.
+func ServiceTokenName(token string) string {
+	d, err := base64.StdEncoding.DecodeString(token)
+	if err != nil || !utf8.Valid(d) {
+		return ""
+	}
+	name, _, _ := strings.Cut(string(d), ":")
+	return name
+}
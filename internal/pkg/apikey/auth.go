@@ -37,17 +37,30 @@ type SecurityInfo struct {
 // Authenticate will return the SecurityInfo associated with the APIKey (retrieved from Elasticsearch).
 // Note: Prefer the bulk wrapper on this API
 func (k APIKey) Authenticate(ctx context.Context, client *elasticsearch.Client) (*SecurityInfo, error) {
-
 	token := fmt.Sprintf("%s%s", authPrefix, k.Token())
+	return authenticate(ctx, client, token, "apikey", k.ID)
+}
+
+// AuthenticateServiceToken will return the SecurityInfo associated with the Elasticsearch service token.
+func AuthenticateServiceToken(ctx context.Context, client *elasticsearch.Client, token string) (*SecurityInfo, error) {
+	header := fmt.Sprintf("%s%s", bearerPrefix, token)
+	return authenticate(ctx, client, header, "service token", ServiceTokenName(token))
+}
+
+// IsServiceAccount returns true if the SecurityInfo was authenticated by the service account realm.
+func (info *SecurityInfo) IsServiceAccount() bool {
+	return info.AuthRealm["type"] == serviceAccountRealm
+}
 
+func authenticate(ctx context.Context, client *elasticsearch.Client, header, kind, id string) (*SecurityInfo, error) {
 	req := esapi.SecurityAuthenticateRequest{
-		Header: map[string][]string{AuthKey: []string{token}},
+		Header: map[string][]string{AuthKey: []string{header}},
 	}
 
 	res, err := req.Do(ctx, client)
 
 	if err != nil {
-		return nil, fmt.Errorf("apikey auth request %s: %w", k.ID, err)
+		return nil, fmt.Errorf("%s auth request %s: %w", kind, id, err)
 	}
 
 	if res.Body != nil {
@@ -63,7 +76,7 @@ func (k APIKey) Authenticate(ctx context.Context, client *elasticsearch.Client)
 			returnError = ErrElasticsearchAuthLimit
 		}
 		if returnError != nil {
-			return nil, fmt.Errorf("%w: %w", returnError, fmt.Errorf("apikey auth response %s: %s", k.ID, res.String()))
+			return nil, fmt.Errorf("%w: %w", returnError, fmt.Errorf("%s auth response %s: %s", kind, id, res.String()))
 		}
 		// body is not parsed to not give the caller too much information
 		return nil, es.TranslateError(res.StatusCode, nil)
@@ -72,7 +85,7 @@ func (k APIKey) Authenticate(ctx context.Context, client *elasticsearch.Client)
 	var info SecurityInfo
 	decoder := json.NewDecoder(res.Body)
 	if err := decoder.Decode(&info); err != nil {
-		return nil, fmt.Errorf("apikey auth parse %s: %w", k.ID, err)
+		return nil, fmt.Errorf("%s auth parse %s: %w", kind, id, err)
 	}
 
 	return &info, nil
@@ -11,10 +11,11 @@ type Type int
 const (
 	TypeAccess Type = iota
 	TypeOutput
+	TypeEnroll
 )
 
 func (t Type) String() string {
-	return []string{"access", "output"}[t]
+	return []string{"access", "output", "enroll"}[t]
 }
 
 // Metadata is additional information associated with an APIKey.
@@ -0,0 +1,73 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package apikey
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// QueryHit is an API key found by Query.
+type QueryHit struct {
+	APIKeyMetadata
+	Name     string
+	Creation time.Time
+	// Sort holds the sort values of the key, the next page of a sorted query is requested after them.
+	Sort []interface{}
+}
+
+// Query finds the API keys matching the query in body, the body of a query API keys request.
+func Query(ctx context.Context, client *elasticsearch.Client, body []byte) ([]QueryHit, error) {
+	opts := []func(*esapi.SecurityQueryAPIKeysRequest){
+		client.Security.QueryAPIKeys.WithContext(ctx),
+		client.Security.QueryAPIKeys.WithBody(bytes.NewReader(body)),
+	}
+
+	res, err := client.Security.QueryAPIKeys(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("request to elasticsearch failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("fail QueryAPIKeys: %s", res.String())
+	}
+
+	type APIKeyResponse struct {
+		ID       string        `json:"id"`
+		Name     string        `json:"name"`
+		Creation int64         `json:"creation"`
+		Metadata Metadata      `json:"metadata"`
+		Sort     []interface{} `json:"_sort"`
+	}
+	type QueryAPIKeysResponse struct {
+		APIKeys []APIKeyResponse `json:"api_keys"`
+	}
+
+	var resp QueryAPIKeysResponse
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("could not decode elasticsearch QueryAPIKeysResponse: %w", err)
+	}
+
+	hits := make([]QueryHit, len(resp.APIKeys))
+	for i, key := range resp.APIKeys {
+		hits[i] = QueryHit{
+			APIKeyMetadata: APIKeyMetadata{
+				ID:       key.ID,
+				Metadata: key.Metadata,
+			},
+			Name:     key.Name,
+			Creation: time.UnixMilli(key.Creation).UTC(),
+			Sort:     key.Sort,
+		}
+	}
+	return hits, nil
+}
@@ -0,0 +1,158 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package audittrail writes the audit records of the requests to the administrative endpoints.
+package audittrail
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+const (
+	defaultQueueSize = 1024
+	// batchSize is the max number of records written by a single bulk request.
+	batchSize = 100
+)
+
+type Opt func(w *Writer)
+
+// WithStats registers the written and failed record counts in reg.
+func WithStats(reg *monitoring.Registry) Opt {
+	return func(w *Writer) {
+		reg.Add("written", &w.written, monitoring.Full)
+		reg.Add("failed", &w.failed, monitoring.Full)
+	}
+}
+
+// WithIndex sets the data stream the records are written to, dl.FleetAudit by default.
+func WithIndex(index string) Opt {
+	return func(w *Writer) {
+		w.index = index
+	}
+}
+
+// WithQueueSize sets the number of records waiting to be written above which new records are dropped.
+func WithQueueSize(size int) Opt {
+	return func(w *Writer) {
+		w.queueSize = size
+	}
+}
+
+// Writer writes the audit records in the background, so the requests do not wait for Elasticsearch.
+// The records that can not be written are counted as failed, they never fail the audited request.
+type Writer struct {
+	bulker    bulk.Bulk
+	index     string
+	queueSize int
+	records   chan model.AuditRecord
+
+	written monitoring.Int
+	failed  monitoring.Int
+}
+
+// New returns a Writer using bulker, Run must be called for the records to be written.
+func New(bulker bulk.Bulk, opts ...Opt) *Writer {
+	w := &Writer{
+		bulker:    bulker,
+		index:     dl.FleetAudit,
+		queueSize: defaultQueueSize,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.records = make(chan model.AuditRecord, w.queueSize)
+	return w
+}
+
+// Record queues rec to be written, it does not block.
+// rec is dropped and counted as failed if the queue is full.
+func (w *Writer) Record(ctx context.Context, rec model.AuditRecord) {
+	select {
+	case w.records <- rec:
+	default:
+		w.failed.Inc()
+		zerolog.Ctx(ctx).Warn().Str("route", rec.Route).Msg("audit trail queue is full, audit record dropped")
+	}
+}
+
+// Run writes the queued records until ctx is done.
+func (w *Writer) Run(ctx context.Context) error {
+	batch := make([]model.AuditRecord, 0, batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case rec := <-w.records:
+			batch = append(batch[:0], rec)
+		}
+		// write the records queued while the previous batch was written along with this one
+	drain:
+		for len(batch) < batchSize {
+			select {
+			case rec := <-w.records:
+				batch = append(batch, rec)
+			default:
+				break drain
+			}
+		}
+		w.write(ctx, batch)
+	}
+}
+
+func (w *Writer) write(ctx context.Context, batch []model.AuditRecord) {
+	zlog := zerolog.Ctx(ctx)
+	ops := make([]bulk.MultiOp, 0, len(batch))
+	for _, rec := range batch {
+		body, err := json.Marshal(rec)
+		if err != nil {
+			w.failed.Inc()
+			zlog.Warn().Err(err).Str("route", rec.Route).Msg("unable to marshal audit record")
+			continue
+		}
+		id, err := uuid.NewV4()
+		if err != nil {
+			w.failed.Inc()
+			zlog.Warn().Err(err).Str("route", rec.Route).Msg("unable to create audit record id")
+			continue
+		}
+		ops = append(ops, bulk.MultiOp{ID: id.String(), Index: w.index, Body: body})
+	}
+	if len(ops) == 0 {
+		return
+	}
+
+	items, err := w.bulker.MCreate(ctx, ops, bulk.WithDroppable())
+	if err != nil && len(items) == 0 {
+		w.failed.Add(int64(len(ops)))
+		zlog.Warn().Err(err).Int("count", len(ops)).Msg("unable to write audit records")
+		return
+	}
+	// the records dropped from the low priority lane of the bulker, or spooled, have no response item
+	dropped := bulk.IsDropped(err)
+	failed := 0
+	for _, item := range items {
+		if item.Status == 0 && dropped {
+			continue
+		}
+		if item.Status < 200 || item.Status >= 300 {
+			failed++
+		}
+	}
+	// items are only missing when the request failed
+	failed += len(ops) - len(items)
+	w.written.Add(int64(len(ops) - failed))
+	if failed > 0 {
+		w.failed.Add(int64(failed))
+		zlog.Warn().Err(err).Int("count", failed).Msg("unable to write audit records")
+	}
+}
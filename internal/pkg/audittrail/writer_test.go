@@ -0,0 +1,98 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package audittrail
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+)
+
+func TestWriterWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		items   []bulk.BulkIndexerResponseItem
+		err     error
+		written int64
+		failed  int64
+	}{{
+		name:    "written",
+		items:   []bulk.BulkIndexerResponseItem{{Status: 201}, {Status: 201}, {Status: 201}},
+		written: 3,
+	}, {
+		name:    "item failures",
+		items:   []bulk.BulkIndexerResponseItem{{Status: 201}, {Status: 404}, {Status: 201}},
+		written: 2,
+		failed:  1,
+	}, {
+		name:    "spooled",
+		items:   []bulk.BulkIndexerResponseItem{{Status: 201}, {}, {Status: 201}},
+		err:     bulk.ErrSpooled,
+		written: 3,
+	}, {
+		name:   "request failure",
+		err:    errors.New("unavailable"),
+		failed: 3,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var ops []bulk.MultiOp
+			bulker := ftesting.NewMockBulk()
+			bulker.On("MCreate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				ops = args.Get(1).([]bulk.MultiOp)
+			}).Return(tc.items, tc.err)
+
+			w := New(bulker, WithStats(monitoring.NewRegistry()))
+			w.write(context.Background(), []model.AuditRecord{{Route: "createActions"}, {Route: "agentTags"}, {Route: "audit-unenroll"}})
+
+			require.Len(t, ops, 3)
+			for _, op := range ops {
+				require.Equal(t, dl.FleetAudit, op.Index)
+				require.NotEmpty(t, op.ID)
+			}
+			require.Equal(t, tc.written, w.written.Get())
+			require.Equal(t, tc.failed, w.failed.Get())
+		})
+	}
+}
+
+func TestWriterRun(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	written := make(chan []bulk.MultiOp)
+	bulker.On("MCreate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		written <- args.Get(1).([]bulk.MultiOp)
+	}).Return([]bulk.BulkIndexerResponseItem(nil), nil)
+
+	w := New(bulker, WithQueueSize(batchSize+1), WithIndex("audit-test"))
+	// the queued records are written by batches of at most batchSize
+	for range batchSize + 1 {
+		w.Record(context.Background(), model.AuditRecord{Route: "createActions"})
+	}
+	// dropped when the queue is full
+	w.Record(context.Background(), model.AuditRecord{Route: "createActions"})
+	require.Equal(t, int64(1), w.failed.Get())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() {
+		errCh <- w.Run(ctx)
+	}()
+	ops := <-written
+	require.Len(t, ops, batchSize)
+	require.Equal(t, "audit-test", ops[0].Index)
+	require.Len(t, <-written, 1)
+
+	cancel()
+	require.ErrorIs(t, <-errCh, context.Canceled)
+}
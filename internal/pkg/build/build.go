@@ -5,14 +5,52 @@
 // Package build contains build inforamtion that can be exposed during runtime.
 package build
 
-import "time"
+import (
+	"runtime/debug"
+	"time"
+
+	fversion "github.com/elastic/fleet-server/v7/version"
+)
 
 const ServiceName = "fleet-server"
 
+// The build information embedded by the linker, for example:
+//
+//	-ldflags "-X github.com/elastic/fleet-server/v7/internal/pkg/build.version=8.18.0 -X github.com/elastic/fleet-server/v7/internal/pkg/build.commit=31668e0"
+var (
+	version   string
+	commit    string
+	buildTime string
+)
+
 // Info contains build information.
 type Info struct {
-	Version, Commit string
-	BuildTime       time.Time
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit,omitempty"`
+	BuildTime time.Time `json:"build_time,omitzero"`
+}
+
+// Current returns the build information embedded in the binary.
+// The VCS revision recorded by the go toolchain is used as commit when it is not set by the linker.
+func Current() Info {
+	bi := Info{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: Time(buildTime),
+	}
+	if bi.Version == "" {
+		bi.Version = fversion.DefaultVersion
+	}
+	if bi.Commit == "" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range info.Settings {
+				if s.Key == "vcs.revision" {
+					bi.Commit = s.Value
+				}
+			}
+		}
+	}
+	return bi
 }
 
 // Time parses the given string using RFC3339, or returns an empty time.Time.
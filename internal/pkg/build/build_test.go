@@ -0,0 +1,58 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package build
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	fversion "github.com/elastic/fleet-server/v7/version"
+)
+
+// setLinkerFlags sets the variables as -ldflags "-X ..." does for the duration of the test.
+func setLinkerFlags(t *testing.T, v, c, bt string) {
+	t.Helper()
+	prevVersion, prevCommit, prevBuildTime := version, commit, buildTime
+	version, commit, buildTime = v, c, bt
+	t.Cleanup(func() {
+		version, commit, buildTime = prevVersion, prevCommit, prevBuildTime
+	})
+}
+
+func TestCurrent(t *testing.T) {
+	t.Run("linker flags", func(t *testing.T) {
+		setLinkerFlags(t, "8.18.0", "31668e0", "2025-03-01T12:00:00Z")
+		require.Equal(t, Info{
+			Version:   "8.18.0",
+			Commit:    "31668e0",
+			BuildTime: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+		}, Current())
+	})
+
+	t.Run("no linker flags", func(t *testing.T) {
+		setLinkerFlags(t, "", "", "")
+		bi := Current()
+		require.Equal(t, fversion.DefaultVersion, bi.Version)
+		require.True(t, bi.BuildTime.IsZero())
+	})
+
+	t.Run("malformed build time", func(t *testing.T) {
+		setLinkerFlags(t, "8.18.0", "31668e0", "yesterday")
+		require.True(t, Current().BuildTime.IsZero())
+	})
+}
+
+func TestInfoJSON(t *testing.T) {
+	p, err := json.Marshal(Info{Version: "8.18.0", Commit: "31668e0", BuildTime: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"version":"8.18.0","commit":"31668e0","build_time":"2025-03-01T12:00:00Z"}`, string(p))
+
+	p, err = json.Marshal(Info{Version: "8.18.0"})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"version":"8.18.0"}`, string(p))
+}
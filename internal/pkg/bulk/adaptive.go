@@ -0,0 +1,179 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// ErrDocumentTooLarge is returned for a bulk request rejected with a 413 on its own, it can not be split further.
+var ErrDocumentTooLarge = errors.New("document too large")
+
+const (
+	// adaptiveRecoverFlushes is the number of successful flushes between two raises of the thresholds.
+	adaptiveRecoverFlushes  = 10
+	adaptiveMinThresholdCnt = 16
+	adaptiveMinThresholdSz  = 64 * 1024
+)
+
+// adaptiveT holds the flush thresholds the run loop uses. They are lowered while elasticsearch, or a proxy in front
+// of it, rejects the flushes with 429 or 413, and raised back by a quarter every adaptiveRecoverFlushes successful
+// flushes up to the configured thresholds.
+type adaptiveT struct {
+	maxCnt int
+	maxSz  int
+
+	mu        sync.Mutex
+	successes int
+
+	thresholdCnt monitoring.Int // read by the run loop without the lock
+	thresholdSz  monitoring.Int
+	throttled    monitoring.Uint // flushes rejected with 429
+	splits       monitoring.Uint // flushes split in half after a 413
+	tooLarge     monitoring.Uint // single requests rejected with 413
+}
+
+func newAdaptive(cnt, sz int) *adaptiveT {
+	a := &adaptiveT{maxCnt: cnt, maxSz: sz}
+	a.thresholdCnt.Set(int64(cnt))
+	a.thresholdSz.Set(int64(sz))
+	return a
+}
+
+func (a *adaptiveT) registerStats(reg *monitoring.Registry) {
+	reg.Add("adaptive_threshold_items", &a.thresholdCnt, monitoring.Full)
+	reg.Add("adaptive_threshold_bytes", &a.thresholdSz, monitoring.Full)
+	reg.Add("throttled_flushes", &a.throttled, monitoring.Full)
+	reg.Add("split_flushes", &a.splits, monitoring.Full)
+	reg.Add("too_large_items", &a.tooLarge, monitoring.Full)
+}
+
+// thresholds returns the item count and the size in bytes of the pending requests that trigger a flush.
+func (a *adaptiveT) thresholds() (int, int) {
+	return int(a.thresholdCnt.Get()), int(a.thresholdSz.Get())
+}
+
+// throttle halves the thresholds after a flush is rejected with 429.
+// The elasticsearch client already retried the flush, so the rejection is sustained.
+func (a *adaptiveT) throttle() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.throttled.Inc()
+	a.successes = 0
+	a.thresholdCnt.Set(int64(max(int(a.thresholdCnt.Get())/2, min(adaptiveMinThresholdCnt, a.maxCnt))))
+	a.thresholdSz.Set(int64(max(int(a.thresholdSz.Get())/2, min(adaptiveMinThresholdSz, a.maxSz))))
+}
+
+// shrink lowers the size threshold to half the size of a flush rejected with 413.
+func (a *adaptiveT) shrink(bodySz int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.splits.Inc()
+	a.successes = 0
+	if sz := max(bodySz/2, min(adaptiveMinThresholdSz, a.maxSz)); int64(sz) < a.thresholdSz.Get() {
+		a.thresholdSz.Set(int64(sz))
+	}
+}
+
+// succeed records a flush accepted without a 429, the thresholds are raised once enough flushes succeeded in a row.
+func (a *adaptiveT) succeed() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cnt, sz := int(a.thresholdCnt.Get()), int(a.thresholdSz.Get())
+	if cnt == a.maxCnt && sz == a.maxSz {
+		return
+	}
+	a.successes++
+	if a.successes < adaptiveRecoverFlushes {
+		return
+	}
+	a.successes = 0
+	a.thresholdCnt.Set(int64(min(cnt+max(cnt/4, 1), a.maxCnt)))
+	a.thresholdSz.Set(int64(min(sz+max(sz/4, 1), a.maxSz)))
+}
+
+// configure replaces the configured thresholds. The thresholds lowered below them are kept until the flushes succeed
+// again, the others are set to them.
+func (a *adaptiveT) configure(cnt, sz int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cur := int(a.thresholdCnt.Get()); cur == a.maxCnt || cur > cnt {
+		a.thresholdCnt.Set(int64(cnt))
+	}
+	if cur := int(a.thresholdSz.Get()); cur == a.maxSz || cur > sz {
+		a.thresholdSz.Set(int64(sz))
+	}
+	a.maxCnt, a.maxSz = cnt, sz
+}
+
+// splitBulk flushes the two halves of a queue rejected with 413, recursively down to single requests.
+// Each half is failed on its own, so the caller must only fail the queue when an error is returned.
+func (b *Bulker) splitBulk(ctx context.Context, queue queueT, bodySz int) error {
+	if queue.cnt <= 1 {
+		b.adaptive.tooLarge.Inc()
+		index, id := bulkTarget(queue.head.buf.Bytes())
+		return fmt.Errorf("%w: %d bytes request for index %s, id %s", ErrDocumentTooLarge, bodySz, index, id)
+	}
+	b.adaptive.shrink(bodySz)
+
+	first, second := splitQueue(queue)
+	zerolog.Ctx(ctx).Debug().
+		Str("mod", kModBulk).
+		Int("cnt", queue.cnt).
+		Int("bodySz", bodySz).
+		Msg("Bulk request too large, split in half")
+
+	for _, q := range []queueT{first, second} {
+		if err := b.flushBulk(ctx, q); err != nil {
+			failQueue(q, err)
+		}
+	}
+	return nil
+}
+
+// splitQueue splits the linked requests of queue in two queues of the same type.
+func splitQueue(queue queueT) (queueT, queueT) {
+	first := queueT{ty: queue.ty, cnt: queue.cnt / 2, head: queue.head}
+	second := queueT{ty: queue.ty, cnt: queue.cnt - first.cnt}
+
+	n := queue.head
+	for i := 1; i < first.cnt; i++ {
+		first.pending += n.buf.Len()
+		n = n.next
+	}
+	first.pending += n.buf.Len()
+	second.head = n.next
+	second.pending = queue.pending - first.pending
+	n.next = nil
+
+	return first, second
+}
+
+// bulkTarget returns the index and the id from the metadata line of a bulk request.
+func bulkTarget(buf []byte) (string, string) {
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		buf = buf[:i]
+	}
+	var meta map[string]struct {
+		Index string `json:"_index"`
+		ID    string `json:"_id"`
+	}
+	if err := json.Unmarshal(buf, &meta); err != nil {
+		return "", ""
+	}
+	for _, m := range meta {
+		return m.Index, m.ID
+	}
+	return "", ""
+}
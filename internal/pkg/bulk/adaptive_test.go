@@ -0,0 +1,265 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+)
+
+// proxyBulkTransport is a proxy in front of mockBulkTransport, it rejects the requests larger than limit with a 413
+// and the first throttled requests with a 429.
+type proxyBulkTransport struct {
+	mockBulkTransport
+	limit     int
+	throttled atomic.Int32
+	accepted  atomic.Int32
+	rejected  atomic.Int32
+}
+
+func (m *proxyBulkTransport) Perform(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	if m.throttled.Add(-1) >= 0 {
+		return &http.Response{
+			Request:    req,
+			StatusCode: http.StatusTooManyRequests,
+			Body:       io.NopCloser(strings.NewReader(`{"error":{"type":"es_rejected_execution_exception"},"status":429}`)),
+		}, nil
+	}
+	if len(body) > m.limit {
+		m.rejected.Add(1)
+		return &http.Response{
+			Request:    req,
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Body:       io.NopCloser(strings.NewReader(`<html><body><h1>413 Request Entity Too Large</h1></body></html>`)),
+		}, nil
+	}
+	m.accepted.Add(1)
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return m.mockBulkTransport.Perform(req)
+}
+
+func TestBulkerProxySizeLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const proxyLimit = 1024 * 1024
+	transport := &proxyBulkTransport{limit: proxyLimit}
+	reg := monitoring.NewRegistry()
+	bulker := NewBulker(transport, nil, WithStats(reg), WithFlushThresholdSize(8*proxyLimit), WithFlushInterval(100*time.Millisecond))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+	stat := func(name string) int64 {
+		return monitoring.CollectFlatSnapshot(reg, monitoring.Full, false).Ints[name]
+	}
+
+	doc := []byte(fmt.Sprintf(`{"data":"%s"}`, strings.Repeat("x", 4096)))
+	ops := make([]MultiOp, 500)
+	for i := range ops {
+		ops[i] = MultiOp{Index: "testidx", Body: doc}
+	}
+	send := func(batches int) {
+		var sendWg sync.WaitGroup
+		for range 4 {
+			sendWg.Add(1)
+			go func() {
+				defer sendWg.Done()
+				for range batches {
+					items, err := bulker.MCreate(ctx, ops)
+					require.NoError(t, err)
+					require.Len(t, items, len(ops))
+				}
+			}()
+		}
+		sendWg.Wait()
+	}
+
+	// every request succeeds, the flushes over the limit of the proxy are split
+	send(5)
+	require.Positive(t, transport.rejected.Load())
+	require.Positive(t, stat("split_flushes"))
+	require.Less(t, stat("adaptive_threshold_bytes"), int64(8*proxyLimit))
+
+	// the flush size converges below the limit, it is only exceeded when the thresholds recover
+	rejected, accepted := transport.rejected.Load(), transport.accepted.Load()
+	send(20)
+	rejected, accepted = transport.rejected.Load()-rejected, transport.accepted.Load()-accepted
+	require.Positive(t, accepted)
+	require.Less(t, rejected*adaptiveRecoverFlushes, accepted, "%d flushes rejected for %d accepted", rejected, accepted)
+	require.LessOrEqual(t, stat("adaptive_threshold_bytes"), int64(proxyLimit+proxyLimit/4))
+	require.Zero(t, stat("too_large_items"))
+
+	t.Run("document too large", func(t *testing.T) {
+		large := []byte(fmt.Sprintf(`{"data":"%s"}`, strings.Repeat("x", proxyLimit)))
+		_, err := bulker.Create(ctx, "testidx", "large-doc", large)
+		require.ErrorIs(t, err, ErrDocumentTooLarge)
+		require.ErrorContains(t, err, "index testidx, id large-doc")
+		require.Equal(t, int64(1), stat("too_large_items"))
+	})
+
+	cancel()
+	wg.Wait()
+}
+
+func TestBulkerThrottled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := &proxyBulkTransport{limit: 1024 * 1024}
+	transport.throttled.Store(1)
+	reg := monitoring.NewRegistry()
+	bulker := NewBulker(transport, nil, WithStats(reg), WithFlushThresholdCount(1))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+	stat := func(name string) int64 {
+		return monitoring.CollectFlatSnapshot(reg, monitoring.Full, false).Ints[name]
+	}
+
+	_, err := bulker.Create(ctx, "testidx", "", []byte(`{"hey":"now"}`))
+	require.ErrorIs(t, err, es.ErrTooManyRequests)
+	require.Equal(t, int64(1), stat("throttled_flushes"))
+	require.Equal(t, int64(defaultFlushThresholdSz/2), stat("adaptive_threshold_bytes"))
+
+	_, err = bulker.Create(ctx, "testidx", "", []byte(`{"hey":"now"}`))
+	require.NoError(t, err)
+
+	cancel()
+	wg.Wait()
+}
+
+func TestAdaptiveThresholds(t *testing.T) {
+	a := newAdaptive(1000, 1024*1024)
+
+	a.throttle()
+	cnt, sz := a.thresholds()
+	require.Equal(t, 500, cnt)
+	require.Equal(t, 512*1024, sz)
+
+	// the thresholds are not lowered below the minimums
+	for range 10 {
+		a.throttle()
+	}
+	cnt, sz = a.thresholds()
+	require.Equal(t, adaptiveMinThresholdCnt, cnt)
+	require.Equal(t, adaptiveMinThresholdSz, sz)
+
+	// they are raised by a quarter after enough successful flushes
+	for range adaptiveRecoverFlushes - 1 {
+		a.succeed()
+	}
+	cnt, _ = a.thresholds()
+	require.Equal(t, adaptiveMinThresholdCnt, cnt)
+	a.succeed()
+	cnt, sz = a.thresholds()
+	require.Equal(t, adaptiveMinThresholdCnt+adaptiveMinThresholdCnt/4, cnt)
+	require.Equal(t, adaptiveMinThresholdSz+adaptiveMinThresholdSz/4, sz)
+
+	// up to the configured thresholds
+	for range 100 * adaptiveRecoverFlushes {
+		a.succeed()
+	}
+	cnt, sz = a.thresholds()
+	require.Equal(t, 1000, cnt)
+	require.Equal(t, 1024*1024, sz)
+
+	// a 413 lowers the size threshold only
+	a.shrink(600 * 1024)
+	cnt, sz = a.thresholds()
+	require.Equal(t, 1000, cnt)
+	require.Equal(t, 300*1024, sz)
+	a.shrink(1024 * 1024)
+	_, sz = a.thresholds()
+	require.Equal(t, 300*1024, sz)
+}
+
+func TestAdaptiveConfigure(t *testing.T) {
+	a := newAdaptive(1000, 1024*1024)
+
+	// thresholds that were not lowered follow the configuration
+	a.configure(2000, 512*1024)
+	cnt, sz := a.thresholds()
+	require.Equal(t, 2000, cnt)
+	require.Equal(t, 512*1024, sz)
+
+	// lowered thresholds are kept below the new configuration, and recover up to it
+	a.throttle()
+	a.configure(4000, 1024*1024)
+	cnt, sz = a.thresholds()
+	require.Equal(t, 1000, cnt)
+	require.Equal(t, 256*1024, sz)
+	for range 100 * adaptiveRecoverFlushes {
+		a.succeed()
+	}
+	cnt, sz = a.thresholds()
+	require.Equal(t, 4000, cnt)
+	require.Equal(t, 1024*1024, sz)
+}
+
+func TestBulkerReload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := &proxyBulkTransport{limit: 1024 * 1024}
+	bulker := NewBulker(transport, nil, WithFlushThresholdCount(1000), WithFlushInterval(time.Hour))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+
+	// the running bulker flushes each request once the count threshold is reloaded
+	var cfg config.Server
+	cfg.Bulk.FlushInterval = time.Hour
+	cfg.Bulk.FlushThresholdCount = 1
+	cfg.Bulk.FlushThresholdSize = defaultFlushThresholdSz
+	cfg.Bulk.SearchMaxResponseSize = defaultSearchMaxRespSz
+	bulker.Reload(&cfg)
+
+	createCtx, createCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer createCancel()
+	_, err := bulker.Create(createCtx, "testidx", "", []byte(`{"hey":"now"}`))
+	require.NoError(t, err)
+	require.Equal(t, int32(1), transport.accepted.Load())
+
+	cancel()
+	wg.Wait()
+}
+
+func TestBulkTarget(t *testing.T) {
+	index, id := bulkTarget([]byte(`{"create":{"_id":"agent-1","_index":".fleet-agents"}}` + "\n" + `{"a":1}` + "\n"))
+	require.Equal(t, ".fleet-agents", index)
+	require.Equal(t, "agent-1", id)
+
+	index, id = bulkTarget([]byte(`not json`))
+	require.Empty(t, index)
+	require.Empty(t, id)
+}
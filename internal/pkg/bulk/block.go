@@ -29,6 +29,7 @@ type flagsT int8
 
 const (
 	flagRefresh flagsT = 1 << iota
+	flagDroppable
 )
 
 func (ft flagsT) Has(f flagsT) bool {
@@ -152,6 +152,50 @@ func Test_CreateAndGetBulkerChanged(t *testing.T) {
 	assert.Equal(t, true, cancelFnCalled)
 }
 
+func Test_CreateAndGetBulkerInvalid(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+	bulker := NewBulker(nil, nil)
+	outputBulker := NewBulker(nil, nil)
+	bulker.bulkerMap["remote1"] = outputBulker
+	bulker.remoteOutputConfigMap["remote1"] = map[string]interface{}{
+		"type":          "remote_elasticsearch",
+		"hosts":         []interface{}{"https://remote-es:443"},
+		"service_token": "token1",
+	}
+	outputMap := map[string]map[string]interface{}{
+		"remote1": {
+			"type":  "remote_elasticsearch",
+			"hosts": []interface{}{"https://remote-es:443"},
+		},
+	}
+	cancelFnCalled := false
+	outputBulker.cancelFn = func() { cancelFnCalled = true }
+	newBulker, _, err := bulker.CreateAndGetBulker(ctx, zerolog.Nop(), "remote1", outputMap)
+	assert.Nil(t, newBulker)
+	assert.Error(t, err)
+	assert.Equal(t, true, cancelFnCalled)
+	assert.Nil(t, bulker.GetBulker("remote1"), "expected the stopped bulker to be forgotten")
+
+	// the same invalid config is tried again
+	_, _, err = bulker.CreateAndGetBulker(ctx, zerolog.Nop(), "remote1", outputMap)
+	assert.Error(t, err)
+}
+
+func Test_RemoteOutputHealth(t *testing.T) {
+	bulker := NewBulker(nil, nil)
+	assert.Empty(t, bulker.RemoteOutputHealth())
+
+	bulker.SetRemoteOutputHealth("remote1", OutputHealth{State: "DEGRADED", Message: "unreachable"})
+	health := bulker.RemoteOutputHealth()
+	assert.Equal(t, "DEGRADED", health["remote1"].State)
+	assert.Equal(t, "unreachable", health["remote1"].Message)
+	assert.False(t, health["remote1"].UpdatedAt.IsZero())
+
+	health["remote1"] = OutputHealth{State: "HEALTHY"}
+	assert.Equal(t, "DEGRADED", bulker.RemoteOutputHealth()["remote1"].State, "expected a copy of the health")
+}
+
 func Benchmark_CreateAndGetBulker(b *testing.B) {
 	b.Skip("Crashes on remote runner")
 	ctx, cancel := context.WithCancel(context.Background())
@@ -9,14 +9,29 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	apmmodel "go.elastic.co/apm/v2/model"
 )
 
 // TODO:
@@ -106,6 +121,438 @@ func (m *mockBulkTransport) Perform(req *http.Request) (*http.Response, error) {
 	return resp, nil
 }
 
+// flakyBulkTransport rejects the first failures requests with a 429 response,
+// the following requests are answered by mockBulkTransport.
+type flakyBulkTransport struct {
+	mockBulkTransport
+	failures int32
+	calls    atomic.Int32
+}
+
+func (m *flakyBulkTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if m.calls.Add(1) <= m.failures {
+		_, _ = io.Copy(io.Discard, req.Body)
+		return &http.Response{
+			Request:    req,
+			StatusCode: http.StatusTooManyRequests,
+			Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+			Body:       io.NopCloser(strings.NewReader(`{"error":{"type":"es_rejected_execution_exception"},"status":429}`)),
+		}, nil
+	}
+	resp, err := m.Perform(req)
+	if err == nil {
+		resp.Header = http.Header{"X-Elastic-Product": []string{"Elasticsearch"}}
+	}
+	return resp, err
+}
+
+// A failed flush is retried by the elasticsearch client, the bulker does not send it again.
+func TestBulkerClientRetries(t *testing.T) {
+	tests := map[string]struct {
+		maxRetries int
+		failures   int32
+		wantErr    bool
+		calls      int32
+	}{
+		"retried by the client": {
+			maxRetries: 3,
+			failures:   2,
+			calls:      3,
+		},
+		"failed after max_retries": {
+			maxRetries: 1,
+			failures:   5,
+			wantErr:    true,
+			calls:      2,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			transport := &flakyBulkTransport{failures: tc.failures}
+			cfg := &config.Config{Output: config.Output{Elasticsearch: config.Elasticsearch{
+				Hosts:        []string{"localhost:9200"},
+				ServiceToken: "test-token",
+				MaxRetries:   tc.maxRetries,
+				Backoff:      config.ESBackoff{Init: time.Millisecond, Max: 5 * time.Millisecond},
+			}}}
+			client, err := es.NewClient(ctx, cfg, false, func(escfg *elasticsearch.Config) {
+				escfg.Transport = transport
+			})
+			require.NoError(t, err)
+
+			bulker := NewBulker(client, nil, WithFlushThresholdCount(1))
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = bulker.Run(ctx)
+			}()
+
+			_, err = bulker.Create(ctx, "testidx", "", []byte(`{"hey":"now"}`))
+			if tc.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.calls, transport.calls.Load())
+
+			cancel()
+			wg.Wait()
+		})
+	}
+}
+
+func TestBulkerStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reg := monitoring.NewRegistry()
+	bulker := NewBulker(&mockBulkTransport{}, nil, WithStats(reg), WithFlushThresholdCount(2), WithFlushInterval(time.Hour))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+
+	stat := func(name string) int64 {
+		return monitoring.CollectFlatSnapshot(reg, monitoring.Full, false).Ints[name]
+	}
+
+	// the first request is held until the flush threshold is reached by the second one
+	errCh := make(chan error, 2)
+	create := func() {
+		_, err := bulker.Create(ctx, "testidx", "", []byte(`{"hello":"world"}`))
+		errCh <- err
+	}
+	go create()
+	require.Eventually(t, func() bool { return stat("pending_items") == 1 }, time.Second, 10*time.Millisecond)
+	require.Positive(t, stat("pending_bytes"))
+	require.Zero(t, stat("queue_depth"))
+
+	go create()
+	require.NoError(t, <-errCh)
+	require.NoError(t, <-errCh)
+	require.Eventually(t, func() bool {
+		return stat("pending_items") == 0 && stat("pending_bytes") == 0 && stat("flush_inflight") == 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	wg.Wait()
+}
+
+// slowBulkTransport delays the responses of the mock transport.
+type slowBulkTransport struct {
+	mockBulkTransport
+	delay time.Duration
+}
+
+func (m *slowBulkTransport) Perform(req *http.Request) (*http.Response, error) {
+	time.Sleep(m.delay)
+	return m.mockBulkTransport.Perform(req)
+}
+
+func TestBulkerSlowFlush(t *testing.T) {
+	prev := logger.SlowThresholds()
+	logger.SetSlowThresholds(config.LoggingSlow{BulkFlush: 20 * time.Millisecond})
+	t.Cleanup(func() { logger.SetSlowThresholds(prev) })
+
+	var out syncBuffer
+	ctx, cancel := context.WithCancel(zerolog.New(&out).WithContext(context.Background()))
+	defer cancel()
+
+	bulker := NewBulker(&slowBulkTransport{delay: 50 * time.Millisecond}, nil, WithFlushThresholdCount(1))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+
+	_, err := bulker.Create(ctx, "testidx", "", []byte(`{"hey":"now"}`))
+	require.NoError(t, err)
+
+	var line map[string]any
+	require.Eventually(t, func() bool {
+		for _, l := range bytes.Split(out.Bytes(), []byte("\n")) {
+			if bytes.Contains(l, []byte(`"slow operation"`)) {
+				return json.Unmarshal(l, &line) == nil
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, "warn", line[zerolog.LevelFieldName])
+	require.Equal(t, "bulk flush", line["slow.operation"])
+	require.Equal(t, "bulk", line["queue"])
+	require.Equal(t, float64(1), line["cnt"])
+	phases, ok := line["slow.phases"].(map[string]any)
+	require.True(t, ok)
+	require.GreaterOrEqual(t, phases["flush"], float64(50))
+
+	cancel()
+	wg.Wait()
+}
+
+// recordBulkTransport records the document ids of the requests answered by the mock transport.
+type recordBulkTransport struct {
+	mockBulkTransport
+	mu  sync.Mutex
+	ids []string
+}
+
+var bulkIDPattern = regexp.MustCompile(`"_id":"([^"]+)"`)
+
+func (m *recordBulkTransport) Perform(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	for _, match := range bulkIDPattern.FindAllSubmatch(body, -1) {
+		m.ids = append(m.ids, string(match[1]))
+	}
+	m.mu.Unlock()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return m.mockBulkTransport.Perform(req)
+}
+
+func (m *recordBulkTransport) sent() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.ids)
+}
+
+func TestBulkerLowPriorityLane(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	body := []byte(`{"hello":"world"}`)
+	var buf Buf
+	require.NoError(t, NewBulker(nil, nil).writeBulkMeta(&buf, ActionCreate.String(), "testidx", "low-0", "", 0, 0))
+	require.NoError(t, NewBulker(nil, nil).writeBulkBody(&buf, ActionCreate, body))
+	size := buf.Len()
+
+	reg := monitoring.NewRegistry()
+	transport := &recordBulkTransport{}
+	bulker := NewBulker(transport, nil, WithStats(reg), WithLowPriorityMaxSize(3*size), WithFlushInterval(10*time.Millisecond))
+	stat := func(name string) int64 {
+		return monitoring.CollectFlatSnapshot(reg, monitoring.Full, false).Ints[name]
+	}
+
+	// the bulker is not running yet, as if elasticsearch was too slow to read the requests
+	errs := make([]chan error, 5)
+	for i := range errs {
+		errs[i] = make(chan error, 1)
+		go func() {
+			_, err := bulker.Create(ctx, "testidx", fmt.Sprintf("low-%d", i), body, WithDroppable())
+			errs[i] <- err
+		}()
+		queued := min(i+1, 3)
+		require.Eventually(t, func() bool {
+			return stat("low_priority_pending_bytes") == int64(queued*size)
+		}, time.Second, time.Millisecond)
+	}
+	criticalErr := make(chan error, 1)
+	go func() {
+		_, err := bulker.Create(ctx, "testidx", "critical", body)
+		criticalErr <- err
+	}()
+
+	require.ErrorIs(t, <-errs[0], ErrDropped)
+	require.ErrorIs(t, <-errs[1], ErrDropped)
+	require.Equal(t, int64(2), stat("low_priority_dropped"))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+
+	require.NoError(t, <-criticalErr)
+	for _, errCh := range errs[2:] {
+		require.NoError(t, <-errCh)
+	}
+	require.ElementsMatch(t, []string{"critical", "low-2", "low-3", "low-4"}, transport.sent())
+	require.Zero(t, stat("low_priority_pending_bytes"))
+
+	cancel()
+	wg.Wait()
+}
+
+func TestBulkerLowPriorityLaneMulti(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := &recordBulkTransport{}
+	bulker := NewBulker(transport, nil, WithLowPriorityMaxSize(1), WithFlushInterval(10*time.Millisecond))
+
+	// only the newest request fits in the lane
+	ops := []MultiOp{
+		{ID: "low-0", Index: "testidx", Body: []byte(`{"hello":"world"}`)},
+		{ID: "low-1", Index: "testidx", Body: []byte(`{"hello":"world"}`)},
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+
+	items, err := bulker.MCreate(ctx, ops, WithDroppable())
+	require.ErrorIs(t, err, ErrDropped)
+	require.Len(t, items, 2)
+	require.Zero(t, items[0].Status, "expected the oldest request to be dropped")
+	require.Equal(t, 201, items[1].Status)
+	require.Equal(t, []string{"low-1"}, transport.sent())
+
+	cancel()
+	wg.Wait()
+}
+
+// syncBuffer is a bytes.Buffer that can be written by the flush goroutines while it is read.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+func TestWriteBulkMeta(t *testing.T) {
+	tests := map[string]struct {
+		retry         string
+		ifSeqNo       int64
+		ifPrimaryTerm int64
+		want          string
+	}{
+		"unconditional": {
+			want: `{"update":{"_id":"agent-1","_index":"testidx"}}`,
+		},
+		"retry on conflict": {
+			retry: "3",
+			want:  `{"update":{"_id":"agent-1","retry_on_conflict":3,"_index":"testidx"}}`,
+		},
+		"conditioned on seq_no": {
+			retry:         "3",
+			ifSeqNo:       42,
+			ifPrimaryTerm: 1,
+			want:          `{"update":{"_id":"agent-1","if_seq_no":42,"if_primary_term":1,"_index":"testidx"}}`,
+		},
+		"first seq_no": {
+			ifPrimaryTerm: 2,
+			want:          `{"update":{"_id":"agent-1","if_seq_no":0,"if_primary_term":2,"_index":"testidx"}}`,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			bulker := NewBulker(nil, nil)
+			body := []byte(`{"doc":{}}`)
+			var buf Buf
+			require.NoError(t, bulker.writeBulkMeta(&buf, ActionUpdate.String(), "testidx", "agent-1", tc.retry, tc.ifSeqNo, tc.ifPrimaryTerm))
+			require.Equal(t, tc.want+"\n", string(buf.Bytes()))
+			require.NoError(t, bulker.writeBulkBody(&buf, ActionUpdate, body))
+			require.Equal(t, buf.Len(), bulker.calcBulkSz(ActionUpdate.String(), "testidx", "agent-1", tc.retry, tc.ifSeqNo, tc.ifPrimaryTerm, body))
+		})
+	}
+}
+
+// conflictBulkTransport records the metadata lines of the requests and answers every action with a version conflict.
+type conflictBulkTransport struct {
+	mu    sync.Mutex
+	metas []string
+}
+
+func (m *conflictBulkTransport) Perform(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	items := make([]string, 0, len(lines)/2)
+	m.mu.Lock()
+	for i := 0; i < len(lines); i += 2 {
+		m.metas = append(m.metas, lines[i])
+		items = append(items, `{"update":{"_id":"agent-1","status":409,"error":{"type":"version_conflict_engine_exception","reason":"version conflict"}}}`)
+	}
+	m.mu.Unlock()
+	return &http.Response{
+		Request:    req,
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Body:       io.NopCloser(strings.NewReader(`{"took":1,"errors":true,"items":[` + strings.Join(items, ",") + `]}`)),
+	}, nil
+}
+
+func TestBulkerUpdateIfSeqNoConflict(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := &conflictBulkTransport{}
+	bulker := NewBulker(transport, nil, WithFlushThresholdCount(1))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+
+	err := bulker.Update(ctx, "testidx", "agent-1", []byte(`{"doc":{"active":false}}`), WithRetryOnConflict(3), WithIfSeqNo(7, 1))
+	require.ErrorIs(t, err, es.ErrElasticVersionConflict)
+
+	items, err := bulker.MUpdate(ctx, []MultiOp{{ID: "agent-1", Index: "testidx", Body: []byte(`{"doc":{}}`), IfSeqNo: 8, IfPrimaryTerm: 1}})
+	require.ErrorIs(t, err, es.ErrElasticVersionConflict)
+	require.Len(t, items, 1)
+	require.Equal(t, http.StatusConflict, items[0].Status)
+
+	transport.mu.Lock()
+	require.Equal(t, []string{
+		`{"update":{"_id":"agent-1","if_seq_no":7,"if_primary_term":1,"_index":"testidx"}}`,
+		`{"update":{"_id":"agent-1","if_seq_no":8,"if_primary_term":1,"_index":"testidx"}}`,
+	}, transport.metas)
+	transport.mu.Unlock()
+
+	cancel()
+	wg.Wait()
+}
+
+func TestBulkerDocVersion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bulker := NewBulker(&mockBulkTransport{}, nil, WithFlushThresholdCount(1))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+
+	var v DocVersion
+	_, err := bulker.Create(ctx, "testidx", "agent-1", []byte(`{"active":true}`), WithDocVersion(&v))
+	require.NoError(t, err)
+	require.Equal(t, DocVersion{SeqNo: 0, PrimaryTerm: 1}, v)
+
+	v = DocVersion{}
+	require.NoError(t, bulker.Update(ctx, "testidx", "agent-1", []byte(`{"doc":{}}`), WithDocVersion(&v)))
+	require.Equal(t, int64(1), v.PrimaryTerm)
+
+	cancel()
+	wg.Wait()
+}
+
 // API should exit quickly if cancelled.
 // Note: In the real world, the transaction may already be in flight,
 // cancelling a call does not mean the transaction did not occur.
@@ -396,3 +843,102 @@ func BenchmarkMockBulk(b *testing.B) {
 		b.Run(strconv.Itoa(n), bindFunc(n))
 	}
 }
+
+func TestBulkerTracing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	bulker := NewBulker(&mockBulkTransport{}, tracer.Tracer, WithFlushThresholdCount(1))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+
+	tx := tracer.StartTransaction("checkin", "request")
+	span, reqCtx := apm.StartSpan(apm.ContextWithTransaction(ctx, tx), "update agent", "db")
+	_, err := bulker.Create(reqCtx, "testidx", "", []byte(`{"hello":"world"}`))
+	require.NoError(t, err)
+	span.End()
+	tx.End()
+
+	cancel()
+	wg.Wait()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	var flushTx apmmodel.Transaction
+	for _, ptx := range payloads.Transactions {
+		if ptx.Name == "Flush queue bulk" {
+			flushTx = ptx
+		}
+	}
+	require.Equal(t, "bulker", flushTx.Type, "flush transaction missing")
+
+	spans := make(map[string]apmmodel.Span, len(payloads.Spans))
+	for _, span := range payloads.Spans {
+		spans[span.Name] = span
+	}
+	require.Contains(t, spans, "Flush: bulk")
+	require.Contains(t, spans, "Bulker: create")
+	require.Equal(t, flushTx.ID, spans["Flush: bulk"].TransactionID)
+
+	// the flush is linked to the span of the request waiting for it, in the trace of the caller
+	waiting := spans["Bulker: create"]
+	require.Equal(t, apmmodel.SpanID(span.TraceContext().Span), waiting.ParentID)
+	require.Equal(t, []apmmodel.SpanLink{{
+		TraceID: waiting.TraceID,
+		SpanID:  waiting.ID,
+	}}, spans["Flush: bulk"].Links)
+}
+
+// apiKeyTransport answers the API key creations, the keys named "fail" are rejected.
+type apiKeyTransport struct {
+	calls atomic.Int32
+}
+
+func (m *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	m.calls.Add(1)
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	status, resp := http.StatusOK, fmt.Sprintf(`{"id":"%s-id","name":"%s","api_key":"secret"}`, body.Name, body.Name)
+	if body.Name == "fail" {
+		status, resp = http.StatusBadRequest, `{"error":{"type":"illegal_argument_exception"}}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}, "Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(resp)),
+	}, nil
+}
+
+func TestBulkerAPIKeyMCreate(t *testing.T) {
+	ctx := context.Background()
+	transport := &apiKeyTransport{}
+	cfg := &config.Config{Output: config.Output{Elasticsearch: config.Elasticsearch{
+		Hosts:        []string{"localhost:9200"},
+		ServiceToken: "test-token",
+	}}}
+	client, err := es.NewClient(ctx, cfg, false, func(escfg *elasticsearch.Config) {
+		escfg.Transport = transport
+	})
+	require.NoError(t, err)
+	bulker := NewBulker(client, nil, WithAPIKeyMaxParallel(1))
+
+	results, err := bulker.APIKeyMCreate(ctx, []APIKeyCreateRequest{{Name: "agent-1"}, {Name: "fail"}, {Name: "agent-2"}})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.Equal(t, &apikey.APIKey{ID: "agent-1-id", Key: "secret"}, results[0].Key)
+	require.Error(t, results[1].Err)
+	require.Nil(t, results[1].Key)
+	require.Equal(t, &apikey.APIKey{ID: "agent-2-id", Key: "secret"}, results[2].Key)
+	require.Equal(t, int32(3), transport.calls.Load())
+}
@@ -0,0 +1,171 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	mrand "math/rand/v2"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/file"
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+const kRedacted = "[redacted]"
+
+// failureCapture records a sample of the bulk items rejected by Elasticsearch with the error of each item, so the
+// documents that fail the mappings can be reproduced. The values of the redacted fields are never recorded.
+// The items are written as JSON lines to the rotated file of the configuration, or logged when it has no file.
+type failureCapture struct {
+	cfg    config.LoggingFailedBulk
+	redact map[string]struct{}
+	sample func() float64
+
+	mx  sync.Mutex
+	out io.WriteCloser // opened on the first capture
+}
+
+// capturedItem is a line of the capture file.
+type capturedItem struct {
+	Timestamp string          `json:"@timestamp"`
+	Status    int             `json:"status"`
+	Error     json.RawMessage `json:"error,omitempty"`
+	Meta      json.RawMessage `json:"meta"`
+	Body      json.RawMessage `json:"body,omitempty"`
+}
+
+func newFailureCapture(cfg config.LoggingFailedBulk) *failureCapture {
+	redact := make(map[string]struct{}, len(cfg.RedactFields))
+	for _, name := range cfg.RedactFields {
+		redact[strings.ToLower(name)] = struct{}{}
+	}
+	return &failureCapture{
+		cfg:    cfg,
+		redact: redact,
+		sample: mrand.Float64,
+	}
+}
+
+// capture records the bulk op, the metadata line and body written by the bulker, if the item is sampled.
+// The items that are not found or conflict are expected by the callers and are not recorded.
+func (c *failureCapture) capture(ctx context.Context, op []byte, item *BulkIndexerResponseItem) {
+	if len(item.Error) == 0 || item.Status == http.StatusNotFound || item.Status == http.StatusConflict {
+		return
+	}
+	if c.cfg.SampleRate <= 0 || c.sample() >= c.cfg.SampleRate {
+		return
+	}
+
+	meta, body, _ := bytes.Cut(op, []byte("\n"))
+	rec := capturedItem{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Status:    item.Status,
+		Error:     item.Error,
+		Meta:      validJSON(meta),
+		Body:      c.redactBody(bytes.TrimSpace(body)),
+	}
+
+	zlog := zerolog.Ctx(ctx)
+	if c.cfg.File == "" {
+		e := zlog.Warn().Str("mod", kModBulk).
+			Int("status", rec.Status).
+			RawJSON("bulk.error", rec.Error).
+			RawJSON("bulk.meta", rec.Meta)
+		if rec.Body != nil {
+			e = e.RawJSON("bulk.body", rec.Body)
+		}
+		e.Msg("Captured failed bulk item")
+		return
+	}
+
+	p, err := json.Marshal(rec)
+	if err != nil {
+		zlog.Warn().Err(err).Str("mod", kModBulk).Msg("Unable to encode the failed bulk item")
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.out == nil {
+		c.out, err = file.NewFileRotator(c.cfg.File,
+			file.MaxSizeBytes(c.cfg.MaxSizeMB*1024*1024),
+			file.MaxBackups(c.cfg.MaxBackups),
+			file.Permissions(os.FileMode(0o600)),
+			file.RotateOnStartup(false),
+		)
+		if err != nil {
+			c.out = nil
+			zlog.Warn().Err(err).Str("mod", kModBulk).Str("path", c.cfg.File).Msg("Unable to open the failed bulk capture file")
+			return
+		}
+	}
+	if _, err := c.out.Write(append(p, '\n')); err != nil {
+		zlog.Warn().Err(err).Str("mod", kModBulk).Str("path", c.cfg.File).Msg("Unable to write the failed bulk item")
+	}
+}
+
+// close closes the capture file.
+func (c *failureCapture) close() error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.out == nil {
+		return nil
+	}
+	err := c.out.Close()
+	c.out = nil
+	return err
+}
+
+// redactBody returns the body with the values of the redacted fields replaced. A body that is not JSON is redacted.
+func (c *failureCapture) redactBody(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		p, _ := json.Marshal(kRedacted)
+		return p
+	}
+	p, err := json.Marshal(c.redactValue(v))
+	if err != nil {
+		p, _ = json.Marshal(kRedacted)
+	}
+	return p
+}
+
+func (c *failureCapture) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			if _, ok := c.redact[strings.ToLower(k)]; ok {
+				v[k] = kRedacted
+				continue
+			}
+			v[k] = c.redactValue(val)
+		}
+	case []interface{}:
+		for i, val := range v {
+			v[i] = c.redactValue(val)
+		}
+	}
+	return v
+}
+
+// validJSON returns p if it is valid JSON, so it can be logged as raw JSON.
+func validJSON(p []byte) json.RawMessage {
+	if !json.Valid(p) {
+		b, _ := json.Marshal(string(p))
+		return b
+	}
+	return p
+}
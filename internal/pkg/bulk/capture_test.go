@@ -0,0 +1,141 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+)
+
+const mappingError = `{"type":"document_parsing_exception","reason":"failed to parse field [count] of type [long]"}`
+
+// mappingBulkTransport rejects the documents of the items with a mapping error.
+type mappingBulkTransport struct{}
+
+func (m *mappingBulkTransport) Perform(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	items := make([]string, 0, len(lines)/2)
+	for i := 0; i < len(lines); i += 2 {
+		items = append(items, `{"create":{"_id":"doc-1","status":400,"error":`+mappingError+`}}`)
+	}
+	return &http.Response{
+		Request:    req,
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Body:       io.NopCloser(strings.NewReader(`{"took":1,"errors":true,"items":[` + strings.Join(items, ",") + `]}`)),
+	}, nil
+}
+
+func TestBulkerFailureCapture(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "failed-bulk")
+	cfg := config.LoggingFailedBulk{}
+	cfg.InitDefaults()
+	cfg.SampleRate = 1
+	cfg.File = path
+	bulker := NewBulker(&mappingBulkTransport{}, nil, WithFlushThresholdCount(1), WithFailureCapture(cfg))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+
+	_, err := bulker.Create(ctx, "testidx", "doc-1", []byte(`{"count":"many","access_api_key":"id:key","outputs":{"es":{"API_KEY":"secret-key","hosts":["localhost"]}},"tokens":[{"token":"enroll-token"}]}`))
+	var esErr *es.ErrElastic
+	require.ErrorAs(t, err, &esErr)
+	cancel()
+	wg.Wait()
+
+	files, err := filepath.Glob(path + "-*.ndjson")
+	require.NoError(t, err)
+	require.Len(t, files, 1, "the items are written to the rotated file")
+	fi, err := os.Stat(files[0])
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	p, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(p)), "\n")
+	require.Len(t, lines, 1)
+
+	var rec capturedItem
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+	require.Equal(t, http.StatusBadRequest, rec.Status)
+	require.JSONEq(t, mappingError, string(rec.Error))
+	require.JSONEq(t, `{"create":{"_id":"doc-1","_index":"testidx"}}`, string(rec.Meta))
+	require.JSONEq(t, `{
+		"count": "many",
+		"access_api_key": "[redacted]",
+		"outputs": {"es": {"API_KEY": "[redacted]", "hosts": ["localhost"]}},
+		"tokens": [{"token": "[redacted]"}]
+	}`, string(rec.Body))
+	require.NotContains(t, string(p), "secret-key")
+	require.NotContains(t, string(p), "enroll-token")
+}
+
+func TestFailureCaptureSample(t *testing.T) {
+	cfg := config.LoggingFailedBulk{}
+	cfg.InitDefaults()
+	cfg.SampleRate = 0.5
+	c := newFailureCapture(cfg)
+	var buf bytes.Buffer
+	ctx := zerolog.New(&buf).WithContext(context.Background())
+
+	op := []byte("{\"update\":{\"_id\":\"agent-1\",\"_index\":\".fleet-agents\"}}\n{\"doc\":{\"password\":\"changeme\",\"active\":1}}\n")
+	failed := &BulkIndexerResponseItem{DocumentID: "agent-1", Status: http.StatusBadRequest, Error: json.RawMessage(mappingError)}
+
+	c.sample = func() float64 { return 0.7 }
+	c.capture(ctx, op, failed)
+	require.Empty(t, buf.String(), "the item is not sampled")
+
+	c.sample = func() float64 { return 0.2 }
+	c.capture(ctx, op, &BulkIndexerResponseItem{Status: http.StatusConflict, Error: json.RawMessage(`{"type":"version_conflict_engine_exception"}`)})
+	c.capture(ctx, op, &BulkIndexerResponseItem{Status: http.StatusNotFound, Error: json.RawMessage(`{"type":"document_missing_exception"}`)})
+	require.Empty(t, buf.String(), "conflicts and missing documents are not captured")
+
+	c.capture(ctx, op, failed)
+	var entry struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"bulk.error"`
+		Meta   json.RawMessage `json:"bulk.meta"`
+		Body   json.RawMessage `json:"bulk.body"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	require.Equal(t, http.StatusBadRequest, entry.Status)
+	require.JSONEq(t, mappingError, string(entry.Error))
+	require.JSONEq(t, `{"update":{"_id":"agent-1","_index":".fleet-agents"}}`, string(entry.Meta))
+	require.JSONEq(t, `{"doc":{"password":"[redacted]","active":1}}`, string(entry.Body))
+}
+
+func TestFailureCaptureDisabledByDefault(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.InitDefaults()
+	require.False(t, cfg.Logging.CaptureFailedBulk)
+	require.Nil(t, parseBulkOpts(BulkOptsFromCfg(cfg)...).failedBulk)
+	require.Nil(t, NewBulker(&mockBulkTransport{}, nil, BulkOptsFromCfg(cfg)...).capture)
+
+	cfg.Logging.CaptureFailedBulk = true
+	require.NotNil(t, NewBulker(&mockBulkTransport{}, nil, BulkOptsFromCfg(cfg)...).capture)
+}
@@ -12,6 +12,7 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
@@ -25,6 +26,7 @@ import (
 	"go.elastic.co/apm/v2"
 	"golang.org/x/sync/semaphore"
 
+	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
 )
@@ -32,15 +34,28 @@ import (
 type APIKey = apikey.APIKey
 type SecurityInfo = apikey.SecurityInfo
 type APIKeyMetadata = apikey.APIKeyMetadata
+type APIKeyQueryHit = apikey.QueryHit
 
 var (
 	ErrNoQuotes = errors.New("quoted literal not supported")
 )
 
+// OutputHealth is the last known health of a remote output, reported by the checks of its cluster and by the
+// API key operations sent to it.
+type OutputHealth struct {
+	State     string
+	Message   string
+	UpdatedAt time.Time
+}
+
+// MultiOp is an operation on a single document of a multi operation.
+// The operation is conditioned on IfSeqNo and IfPrimaryTerm when IfPrimaryTerm is set, as with WithIfSeqNo.
 type MultiOp struct {
-	ID    string
-	Index string
-	Body  []byte
+	ID            string
+	Index         string
+	Body          []byte
+	IfSeqNo       int64
+	IfPrimaryTerm int64
 }
 
 type Bulk interface {
@@ -53,6 +68,8 @@ type Bulk interface {
 	Delete(ctx context.Context, index, id string, opts ...Opt) error
 	Index(ctx context.Context, index, id string, body []byte, opts ...Opt) (string, error)
 	Search(ctx context.Context, index string, body []byte, opts ...Opt) (*es.ResultT, error)
+	// SearchStream runs outside of the bulk engine, the hits are passed to fn as they are decoded
+	SearchStream(ctx context.Context, index string, body []byte, fn func(hit *es.HitT) error, opts ...Opt) error
 	HasTracer() bool
 	StartTransaction(name, transactionType string) *apm.Transaction
 	StartTransactionOptions(name, transactionType string, opts apm.TransactionOptions) *apm.Transaction
@@ -65,7 +82,9 @@ type Bulk interface {
 
 	// APIKey operations
 	APIKeyCreate(ctx context.Context, name, ttl string, roles []byte, meta interface{}) (*APIKey, error)
+	APIKeyMCreate(ctx context.Context, reqs []APIKeyCreateRequest) ([]APIKeyCreateResult, error)
 	APIKeyRead(ctx context.Context, id string, withOwner bool) (*APIKeyMetadata, error)
+	APIKeyQuery(ctx context.Context, body []byte) ([]APIKeyQueryHit, error)
 	APIKeyAuth(ctx context.Context, key APIKey) (*SecurityInfo, error)
 	APIKeyInvalidate(ctx context.Context, ids ...string) error
 	APIKeyUpdate(ctx context.Context, id, outputPolicyHash string, roles []byte) error
@@ -77,7 +96,11 @@ type Bulk interface {
 	GetBulker(outputName string) Bulk
 	GetBulkerMap() map[string]Bulk
 	CancelFn() context.CancelFunc
+	// Reload applies the flush settings of the reloaded server configuration.
+	Reload(cfg *config.Server)
 	RemoteOutputConfigChanged(zlog zerolog.Logger, name string, newCfg map[string]interface{}) bool
+	SetRemoteOutputHealth(outputName string, health OutputHealth)
+	RemoteOutputHealth() map[string]OutputHealth
 
 	ReadSecrets(ctx context.Context, secretIds []string) (map[string]string, error)
 }
@@ -93,8 +116,61 @@ type Bulker struct {
 	tracer                *apm.Tracer
 	remoteOutputConfigMap map[string]map[string]interface{}
 	bulkerMap             map[string]Bulk
+	remoteOutputHealth    map[string]OutputHealth
 	cancelFn              context.CancelFunc
 	remoteOutputMutex     sync.RWMutex
+	stats                 bulkStats
+	lane                  *laneT          // low priority lane of the droppable requests, nil if disabled
+	spool                 *spoolT         // disk spool of the lane during the outages, nil if disabled
+	capture               *failureCapture // capture of the failed items, nil if disabled
+	adaptive              *adaptiveT      // flush thresholds lowered on 429 and 413 responses
+	flushInterval         atomic.Int64    // time.Duration of the flush timer, replaced by Reload
+	searchMaxRespSz       atomic.Int64    // size in bytes of the streamed search responses, replaced by Reload
+}
+
+// bulkStats are the queue and availability stats of a bulker.
+type bulkStats struct {
+	pendingItems  monitoring.Int // requests waiting for the next flush
+	pendingBytes  monitoring.Int
+	flushInflight monitoring.Int // flushes waiting for elasticsearch
+
+	// the breaker is open while the flushes fail as elasticsearch is unavailable, trips counts the times it opened
+	breakerOpen  atomic.Bool
+	breakerTrips monitoring.Uint
+}
+
+// observeAvailability opens the breaker when a flush fails as elasticsearch is unavailable, the next successful flush
+// closes it. The other errors, such as the throttling of elasticsearch, do not change it.
+func (s *bulkStats) observeAvailability(err error) {
+	switch {
+	case err == nil:
+		s.breakerOpen.Store(false)
+	case isOutage(err):
+		if s.breakerOpen.CompareAndSwap(false, true) {
+			s.breakerTrips.Inc()
+		}
+	}
+}
+
+// registerStats adds the stats to reg, queue_depth is the number of requests not yet read from the channel.
+func (b *Bulker) registerStats(reg *monitoring.Registry) {
+	monitoring.NewFunc(reg, "queue_depth", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnInt(int64(len(b.ch)))
+	})
+	reg.Add("pending_items", &b.stats.pendingItems, monitoring.Full)
+	reg.Add("pending_bytes", &b.stats.pendingBytes, monitoring.Full)
+	reg.Add("flush_inflight", &b.stats.flushInflight, monitoring.Full)
+	monitoring.NewFunc(reg, "breaker_open", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnBool(b.stats.breakerOpen.Load())
+	})
+	reg.Add("breaker_trips", &b.stats.breakerTrips, monitoring.Full)
+	b.adaptive.registerStats(reg)
+	if b.lane != nil {
+		b.lane.registerStats(reg)
+	}
+	if b.spool != nil {
+		b.spool.registerStats(reg)
+	}
 }
 
 const (
@@ -105,6 +181,7 @@ const (
 	defaultBlockQueueSz        = 32 // Small capacity to allow multiOp to spin fast
 	defaultAPIKeyMaxParallel   = 32
 	defaultApikeyMaxReqSize    = 100 * 1024 * 1024
+	defaultSearchMaxRespSz     = 100 * 1024 * 1024
 	defaultFlushContextTimeout = time.Minute * 1
 )
 
@@ -116,7 +193,7 @@ func NewBulker(es esapi.Transport, tracer *apm.Tracer, opts ...BulkOpt) *Bulker
 		return &bulkT{ch: make(chan respT, 1)}
 	}
 
-	return &Bulker{
+	b := &Bulker{
 		opts:                  bopts,
 		es:                    es,
 		ch:                    make(chan *bulkT, bopts.blockQueueSz),
@@ -125,8 +202,34 @@ func NewBulker(es esapi.Transport, tracer *apm.Tracer, opts ...BulkOpt) *Bulker
 		tracer:                tracer,
 		remoteOutputConfigMap: make(map[string]map[string]interface{}),
 		// remote ES bulkers
-		bulkerMap: make(map[string]Bulk),
+		bulkerMap:          make(map[string]Bulk),
+		remoteOutputHealth: make(map[string]OutputHealth),
+		adaptive:           newAdaptive(bopts.flushThresholdCnt, bopts.flushThresholdSz),
+	}
+	b.flushInterval.Store(int64(bopts.flushInterval))
+	b.searchMaxRespSz.Store(int64(bopts.searchMaxRespSz))
+	if bopts.lowPriorityMaxSz > 0 {
+		b.lane = newLane(bopts.lowPriorityMaxSz)
+		if bopts.spool != nil {
+			b.spool = newSpool(*bopts.spool)
+			b.lane.spool = b.spool
+		}
+	}
+	if bopts.stats != nil {
+		b.registerStats(bopts.stats)
+	}
+	if bopts.failedBulk != nil {
+		b.capture = newFailureCapture(*bopts.failedBulk)
 	}
+	return b
+}
+
+// Reload applies the flush interval, the flush thresholds and the search response size of cfg to the running bulker.
+// The requests already queued are flushed on the new thresholds, the other bulk settings are only applied by a new bulker.
+func (b *Bulker) Reload(cfg *config.Server) {
+	b.flushInterval.Store(int64(cfg.Bulk.FlushInterval))
+	b.searchMaxRespSz.Store(int64(cfg.Bulk.SearchMaxResponseSize))
+	b.adaptive.configure(cfg.Bulk.FlushThresholdCount, cfg.Bulk.FlushThresholdSize)
 }
 
 func (b *Bulker) GetBulker(outputName string) Bulk {
@@ -150,23 +253,18 @@ func (b *Bulker) CancelFn() context.CancelFunc {
 	return b.cancelFn
 }
 
-func (b *Bulker) updateBulkerMap(outputName string, newBulker *Bulker) {
-	// concurrency control of updating map
-	b.remoteOutputMutex.Lock()
-	defer b.remoteOutputMutex.Unlock()
-
-	b.bulkerMap[outputName] = newBulker
-}
-
 // for remote ES output, create a new bulker in bulkerMap if does not exist
 // if bulker exists for output, check if config changed
 // if not changed, return the existing bulker
 // if changed, stop the existing bulker and create a new one
+// if the new bulker can not be created, the output is forgotten so the next call tries to create it again
 func (b *Bulker) CreateAndGetBulker(ctx context.Context, zlog zerolog.Logger, outputName string, outputMap map[string]map[string]interface{}) (Bulk, bool, error) {
+	// concurrent checkins of agents with the same remote output must share its bulker
+	b.remoteOutputMutex.Lock()
+	defer b.remoteOutputMutex.Unlock()
+
 	hasConfigChanged := b.hasChangedAndUpdateRemoteOutputConfig(zlog, outputName, outputMap[outputName])
-	b.remoteOutputMutex.RLock()
 	bulker := b.bulkerMap[outputName]
-	b.remoteOutputMutex.RUnlock()
 	if bulker != nil && !hasConfigChanged {
 		return bulker, false, nil
 	}
@@ -180,13 +278,15 @@ func (b *Bulker) CreateAndGetBulker(ctx context.Context, zlog zerolog.Logger, ou
 	es, err := b.createRemoteEsClient(bulkCtx, outputName, outputMap)
 	if err != nil {
 		defer bulkCancel()
+		delete(b.bulkerMap, outputName)
+		delete(b.remoteOutputConfigMap, outputName)
 		return nil, hasConfigChanged, err
 	}
 	// starting a new bulker to create/update API keys for remote ES output
 	newBulker := NewBulker(es, b.tracer)
 	newBulker.cancelFn = bulkCancel
 
-	b.updateBulkerMap(outputName, newBulker)
+	b.bulkerMap[outputName] = newBulker
 
 	errCh := make(chan error)
 	go func() {
@@ -259,6 +359,12 @@ func (b *Bulker) Client() *elasticsearch.Client {
 }
 
 func (b *Bulker) RemoteOutputConfigChanged(zlog zerolog.Logger, name string, newCfg map[string]interface{}) bool {
+	b.remoteOutputMutex.RLock()
+	defer b.remoteOutputMutex.RUnlock()
+	return b.remoteOutputConfigChanged(name, newCfg)
+}
+
+func (b *Bulker) remoteOutputConfigChanged(name string, newCfg map[string]interface{}) bool {
 	curCfg := b.remoteOutputConfigMap[name]
 
 	hasChanged := false
@@ -270,9 +376,9 @@ func (b *Bulker) RemoteOutputConfigChanged(zlog zerolog.Logger, name string, new
 	return hasChanged
 }
 
-// check if remote output cfg changed
+// check if remote output cfg changed, remoteOutputMutex must be locked
 func (b *Bulker) hasChangedAndUpdateRemoteOutputConfig(zlog zerolog.Logger, name string, newCfg map[string]interface{}) bool {
-	hasChanged := b.RemoteOutputConfigChanged(zlog, name, newCfg)
+	hasChanged := b.remoteOutputConfigChanged(name, newCfg)
 	if hasChanged {
 		zlog.Debug().Str("name", name).Msg("remote output configuration has changed")
 	}
@@ -285,6 +391,27 @@ func (b *Bulker) hasChangedAndUpdateRemoteOutputConfig(zlog zerolog.Logger, name
 	return hasChanged
 }
 
+// SetRemoteOutputHealth records the last known health of a remote output.
+func (b *Bulker) SetRemoteOutputHealth(outputName string, health OutputHealth) {
+	if health.UpdatedAt.IsZero() {
+		health.UpdatedAt = time.Now().UTC()
+	}
+	b.remoteOutputMutex.Lock()
+	defer b.remoteOutputMutex.Unlock()
+	b.remoteOutputHealth[outputName] = health
+}
+
+// RemoteOutputHealth returns a copy of the last known health of the remote outputs.
+func (b *Bulker) RemoteOutputHealth() map[string]OutputHealth {
+	b.remoteOutputMutex.RLock()
+	defer b.remoteOutputMutex.RUnlock()
+	health := make(map[string]OutputHealth, len(b.remoteOutputHealth))
+	for k, v := range b.remoteOutputHealth {
+		health[k] = v
+	}
+	return health
+}
+
 // read secrets one by one as there is no bulk API yet to read them in one request
 func (b *Bulker) ReadSecrets(ctx context.Context, secretIds []string) (map[string]string, error) {
 	result := make(map[string]string)
@@ -341,9 +468,33 @@ func (b *Bulker) Run(ctx context.Context) error {
 	var err error
 
 	zerolog.Ctx(ctx).Info().Interface("opts", &b.opts).Msg("Run bulker with options")
+	if b.capture != nil {
+		zerolog.Ctx(ctx).Warn().Float64("sample_rate", b.opts.failedBulk.SampleRate).Str("path", b.opts.failedBulk.File).
+			Msg("Capture of the failed bulk items is enabled, it is a diagnostic mode that should not be left enabled")
+		defer b.capture.close()
+	}
+	if b.spool != nil {
+		if err := b.spool.open(ctx); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Str("path", b.opts.spool.Path).
+				Msg("Unable to open the bulk spool, the non-critical writes are dropped while elasticsearch is unavailable")
+		} else {
+			spoolCtx, cancel := context.WithCancel(ctx)
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b.runSpool(spoolCtx)
+			}()
+			defer func() {
+				cancel()
+				wg.Wait()
+				b.spool.close()
+			}()
+		}
+	}
 
 	// Create timer in stopped state
-	timer := time.NewTimer(b.opts.flushInterval)
+	timer := time.NewTimer(time.Duration(b.flushInterval.Load()))
 	stopTimer(timer)
 	defer timer.Stop()
 
@@ -380,47 +531,74 @@ func (b *Bulker) Run(ctx context.Context) error {
 		// Reset threshold counters
 		itemCnt = 0
 		byteCnt = 0
+		b.stats.pendingItems.Set(0)
+		b.stats.pendingBytes.Set(0)
 
 		return nil
 	}
 
-	for err == nil {
+	queueBlk := func(blk *bulkT) error {
+		queueIdx := blkToQueueType(blk)
+		q := &queues[queueIdx]
 
-		select {
+		// Prepend block to head of target queue
+		blk.next = q.head
+		q.head = blk
 
-		case blk := <-b.ch:
+		// Update pending count on target queue
+		q.cnt += 1
+		q.pending += blk.buf.Len()
 
-			queueIdx := blkToQueueType(blk)
-			q := &queues[queueIdx]
+		// Update threshold counters
+		itemCnt += 1
+		byteCnt += blk.buf.Len()
+		b.stats.pendingItems.Set(int64(itemCnt))
+		b.stats.pendingBytes.Set(int64(byteCnt))
 
-			// Prepend block to head of target queue
-			blk.next = q.head
-			q.head = blk
+		// Start timer on first queued item
+		if itemCnt == 1 {
+			timer.Reset(time.Duration(b.flushInterval.Load()))
+		}
 
-			// Update pending count on target queue
-			q.cnt += 1
-			q.pending += blk.buf.Len()
+		// Threshold test, short circuit timer on pending count
+		thresholdCnt, thresholdSz := b.adaptive.thresholds()
+		if itemCnt >= thresholdCnt || byteCnt >= thresholdSz {
+			zerolog.Ctx(ctx).Trace().
+				Str("mod", kModBulk).
+				Int("itemCnt", itemCnt).
+				Int("byteCnt", byteCnt).
+				Msg("Flush on threshold")
 
-			// Update threshold counters
-			itemCnt += 1
-			byteCnt += blk.buf.Len()
+			err := doFlush()
+			stopTimer(timer)
+			return err
+		}
+		return nil
+	}
 
-			// Start timer on first queued item
-			if itemCnt == 1 {
-				timer.Reset(b.opts.flushInterval)
-			}
+	var laneReady <-chan struct{}
+	if b.lane != nil {
+		laneReady = b.lane.ready
+	}
 
-			// Threshold test, short circuit timer on pending count
-			if itemCnt >= b.opts.flushThresholdCnt || byteCnt >= b.opts.flushThresholdSz {
-				zerolog.Ctx(ctx).Trace().
-					Str("mod", kModBulk).
-					Int("itemCnt", itemCnt).
-					Int("byteCnt", byteCnt).
-					Msg("Flush on threshold")
+	for err == nil {
 
-				err = doFlush()
+		select {
 
-				stopTimer(timer)
+		case blk := <-b.ch:
+			err = queueBlk(blk)
+
+		case <-laneReady:
+			// the requests that can not be dropped are queued first
+			for len(b.ch) > 0 && err == nil {
+				err = queueBlk(<-b.ch)
+			}
+			for err == nil {
+				blk := b.lane.pop()
+				if blk == nil {
+					break
+				}
+				err = queueBlk(blk)
 			}
 
 		case <-timer.C:
@@ -450,8 +628,12 @@ func (b *Bulker) Run(ctx context.Context) error {
 	return err
 }
 
+// flushQueue sends the queued requests to elasticsearch.
+// Failed requests are retried by the elasticsearch client as configured in the output, within the flush deadline.
+// The bulker does not send a failed flush again, the error is returned to every caller in the queue.
 func (b *Bulker) flushQueue(ctx context.Context, w *semaphore.Weighted, queue queueT) error {
 	start := time.Now()
+	slow := logger.StartSlowTimer("bulk flush", logger.SlowThresholds().BulkFlush)
 	zerolog.Ctx(ctx).Trace().
 		Str("mod", kModBulk).
 		Int("cnt", queue.cnt).
@@ -466,6 +648,7 @@ func (b *Bulker) flushQueue(ctx context.Context, w *semaphore.Weighted, queue qu
 		zerolog.Ctx(ctx).Error().Err(err).Msg("flushQueue Wait error")
 		return err
 	}
+	slow.Phase("acquire")
 
 	zerolog.Ctx(ctx).Trace().
 		Str("mod", kModBulk).
@@ -478,10 +661,7 @@ func (b *Bulker) flushQueue(ctx context.Context, w *semaphore.Weighted, queue qu
 	go func() {
 		start := time.Now()
 
-		// deadline prevents bulker being blocked on flush
-		flushCtx, cancel := context.WithTimeout(ctx, defaultFlushContextTimeout)
-		defer cancel()
-
+		// the flush spans and the elasticsearch requests are recorded in the transaction of the flush
 		if b.tracer != nil {
 			trans := b.tracer.StartTransaction(fmt.Sprintf("Flush queue %s", queue.Type()), "bulker")
 			trans.Context.SetLabel("queue.size", queue.cnt)
@@ -490,8 +670,15 @@ func (b *Bulker) flushQueue(ctx context.Context, w *semaphore.Weighted, queue qu
 			defer trans.End()
 		}
 
+		// deadline prevents bulker being blocked on flush
+		flushCtx, cancel := context.WithTimeout(ctx, defaultFlushContextTimeout)
+		defer cancel()
+
 		defer w.Release(1)
 
+		b.stats.flushInflight.Inc()
+		defer b.stats.flushInflight.Dec()
+
 		var err error
 		switch queue.ty {
 		case kQueueRead, kQueueRefreshRead:
@@ -504,10 +691,22 @@ func (b *Bulker) flushQueue(ctx context.Context, w *semaphore.Weighted, queue qu
 			err = b.flushBulk(flushCtx, queue)
 		}
 
+		slow.Phase("flush")
+		b.stats.observeAvailability(err)
+		if b.spool != nil {
+			b.spool.observe(ctx, err)
+		}
 		if err != nil {
 			failQueue(queue, err)
 			apm.CaptureError(ctx, err).Send()
 		}
+		slow.End(ctx, func(e *zerolog.Event) {
+			e.Err(err).
+				Str("mod", kModBulk).
+				Int("cnt", queue.cnt).
+				Int("szPending", queue.pending).
+				Str("queue", queue.Type())
+		})
 
 		zerolog.Ctx(ctx).Trace().
 			Err(err).
@@ -551,6 +750,9 @@ func (b *Bulker) newBlk(action actionT, opts optionsT) *bulkT {
 	if opts.Refresh {
 		blk.flags.Set(flagRefresh)
 	}
+	if opts.Droppable {
+		blk.flags.Set(flagDroppable)
+	}
 	blk.spanLink = opts.spanLink
 
 	return blk
@@ -596,18 +798,22 @@ func (b *Bulker) validateBody(body []byte) error {
 func (b *Bulker) dispatch(ctx context.Context, blk *bulkT) respT {
 	start := time.Now()
 
-	// Dispatch to bulk Run loop
-	select {
-	case b.ch <- blk:
-	case <-ctx.Done():
-		zerolog.Ctx(ctx).Error().
-			Err(ctx.Err()).
-			Str("mod", kModBulk).
-			Str("action", blk.action.String()).
-			Bool("refresh", blk.flags.Has(flagRefresh)).
-			Dur("rtt", time.Since(start)).
-			Msg("Dispatch abort queue")
-		return respT{err: ctx.Err()}
+	// Dispatch to bulk Run loop, droppable requests wait in the low priority lane
+	if b.lane != nil && blk.flags.Has(flagDroppable) {
+		b.lane.push(ctx, blk)
+	} else {
+		select {
+		case b.ch <- blk:
+		case <-ctx.Done():
+			zerolog.Ctx(ctx).Error().
+				Err(ctx.Err()).
+				Str("mod", kModBulk).
+				Str("action", blk.action.String()).
+				Bool("refresh", blk.flags.Has(flagRefresh)).
+				Dur("rtt", time.Since(start)).
+				Msg("Dispatch abort queue")
+			return respT{err: ctx.Err()}
+		}
 	}
 
 	// Wait for response
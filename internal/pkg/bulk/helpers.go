@@ -7,6 +7,7 @@ package bulk
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
@@ -50,7 +51,8 @@ func parseError(res *esapi.Response, log *zerolog.Logger) error {
 			log.Debug().Err(err).Bytes("body", b.Bytes()).Msg("Error content")
 		}
 
-		return err
+		// the status is kept so the error can still be classified
+		return fmt.Errorf("%w: %w", es.TranslateError(res.StatusCode, nil), err)
 	}
 
 	return es.TranslateError(res.StatusCode, e.Err)
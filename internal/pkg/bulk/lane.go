@@ -0,0 +1,177 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/time/rate"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// ErrDropped is returned for a droppable request dropped from the low priority lane of the bulker.
+var ErrDropped = errors.New("bulk request dropped, low priority lane is full")
+
+// IsDropped returns true if err reports a droppable request dropped from the low priority lane, whether it is lost,
+// ErrDropped, or written to the spool, ErrSpooled. The callers of the droppable writes accept these requests.
+func IsDropped(err error) bool {
+	return errors.Is(err, ErrDropped) || errors.Is(err, ErrSpooled)
+}
+
+const dropWarnInterval = 10 * time.Second
+
+// laneT is the low priority lane of the bulker, it holds the droppable requests until the run loop has no other
+// request to read. Once the pending bytes of the lane exceed its limit, the oldest requests are dropped so the
+// non-critical writes can not stall the other requests while elasticsearch is slow.
+type laneT struct {
+	maxBytes int
+	ready    chan struct{} // signaled when requests are pushed
+	spool    *spoolT       // spool of the dropped requests during an outage, nil if disabled
+
+	mu      sync.Mutex
+	head    *bulkT // oldest request
+	tail    *bulkT
+	pending int
+
+	dropWarn     rate.Sometimes
+	dropped      monitoring.Uint
+	pendingBytes monitoring.Int
+}
+
+func newLane(maxBytes int) *laneT {
+	return &laneT{
+		maxBytes: maxBytes,
+		ready:    make(chan struct{}, 1),
+		dropWarn: rate.Sometimes{Interval: dropWarnInterval},
+	}
+}
+
+func (l *laneT) registerStats(reg *monitoring.Registry) {
+	reg.Add("low_priority_dropped", &l.dropped, monitoring.Full)
+	reg.Add("low_priority_pending_bytes", &l.pendingBytes, monitoring.Full)
+}
+
+// push appends blks to the lane, dropping the oldest requests beyond its limit.
+// The callers of the dropped requests receive ErrDropped, or ErrSpooled if they are written to the spool.
+//
+// During an outage, the first requests dropped to the spool are followed by the rest of the lane, and the requests
+// are appended to the spool rather than to the lane until it is replayed. The requests for a document are then sent
+// in the order they were pushed, a spooled request can not overwrite a newer request once it is replayed.
+func (l *laneT) push(ctx context.Context, blks ...*bulkT) {
+	var spooled, dropped []*bulkT
+	l.mu.Lock()
+	if l.spool != nil && l.spool.pendingRecords() > 0 {
+		spooled, dropped = l.spill(ctx, blks)
+		l.mu.Unlock()
+		l.reply(ctx, spooled, dropped)
+		return
+	}
+	for _, blk := range blks {
+		blk.next = nil
+		if l.tail == nil {
+			l.head = blk
+		} else {
+			l.tail.next = blk
+		}
+		l.tail = blk
+		l.pending += blk.buf.Len()
+	}
+	// the newest request is kept even if it exceeds the limit on its own
+	for l.pending > l.maxBytes && l.head != l.tail {
+		blk := l.head
+		l.head = blk.next
+		l.pending -= blk.buf.Len()
+		dropped = append(dropped, blk)
+	}
+	if len(dropped) > 0 && l.spool != nil && l.spool.outage.Load() {
+		spooled, dropped = l.spill(ctx, dropped)
+		if len(spooled) > 0 {
+			// the requests left in the lane are newer than the spooled ones
+			rest, restDropped := l.spill(ctx, l.takeAll())
+			spooled = append(spooled, rest...)
+			dropped = append(dropped, restDropped...)
+		}
+	}
+	l.pendingBytes.Set(int64(l.pending))
+	l.mu.Unlock()
+
+	select {
+	case l.ready <- struct{}{}:
+	default:
+	}
+	l.reply(ctx, spooled, dropped)
+}
+
+// spill writes blks to the spool in order, it returns the requests written and the requests that do not fit.
+// It must be called with the lock held, so the requests pushed meanwhile are not sent before the spooled ones.
+func (l *laneT) spill(ctx context.Context, blks []*bulkT) ([]*bulkT, []*bulkT) {
+	var spooled, dropped []*bulkT
+	for _, blk := range blks {
+		blk.next = nil
+		if l.spool.append(ctx, blk) {
+			spooled = append(spooled, blk)
+		} else {
+			dropped = append(dropped, blk)
+		}
+	}
+	return spooled, dropped
+}
+
+// takeAll removes all the requests of the lane, oldest first. It must be called with the lock held.
+func (l *laneT) takeAll() []*bulkT {
+	var blks []*bulkT
+	for blk := l.head; blk != nil; blk = blk.next {
+		blks = append(blks, blk)
+	}
+	l.head = nil
+	l.tail = nil
+	l.pending = 0
+	return blks
+}
+
+// reply sends ErrSpooled to the callers of the spooled requests and ErrDropped to the callers of the dropped ones.
+func (l *laneT) reply(ctx context.Context, spooled, dropped []*bulkT) {
+	for _, blk := range spooled {
+		blk.ch <- respT{err: ErrSpooled, idx: blk.idx}
+	}
+	if len(dropped) == 0 {
+		return
+	}
+	l.dropped.Add(uint64(len(dropped)))
+	l.dropWarn.Do(func() {
+		zerolog.Ctx(ctx).Warn().
+			Str("mod", kModBulk).
+			Int("maxBytes", l.maxBytes).
+			Uint64("dropped", l.dropped.Get()).
+			Msg("Low priority lane is full, dropping the oldest requests")
+	})
+	for _, blk := range dropped {
+		blk.next = nil
+		blk.ch <- respT{err: ErrDropped, idx: blk.idx}
+	}
+}
+
+// pop removes the oldest request of the lane, it returns nil if the lane is empty.
+func (l *laneT) pop() *bulkT {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	blk := l.head
+	if blk == nil {
+		return nil
+	}
+	l.head = blk.next
+	if l.head == nil {
+		l.tail = nil
+	}
+	blk.next = nil
+	l.pending -= blk.buf.Len()
+	l.pendingBytes.Set(int64(l.pending))
+	return blk
+}
@@ -62,6 +62,44 @@ func (b *Bulker) APIKeyCreate(ctx context.Context, name, ttl string, roles []byt
 	return apikey.Create(ctx, b.Client(), name, ttl, "false", roles, meta)
 }
 
+// APIKeyCreateRequest describes an API key created by APIKeyMCreate.
+type APIKeyCreateRequest struct {
+	Name  string
+	TTL   string
+	Roles []byte
+	Meta  interface{}
+}
+
+// APIKeyCreateResult is the key created for an APIKeyCreateRequest, or the error that prevented its creation.
+type APIKeyCreateResult struct {
+	Key *APIKey
+	Err error
+}
+
+// APIKeyMCreate creates the API keys of reqs and returns their results in the same order.
+// Elasticsearch has no API to create several keys in one request, the keys are created one after the other while
+// holding a single API key slot of the bulker, so a batch does not take the slots of the concurrent enrollments.
+// An error is returned if the slot is not acquired, the failures of the keys are in their results.
+func (b *Bulker) APIKeyMCreate(ctx context.Context, reqs []APIKeyCreateRequest) ([]APIKeyCreateResult, error) {
+	span, ctx := apm.StartSpan(ctx, "createAPIKeys", "auth")
+	span.Context.SetLabel("count", len(reqs))
+	defer span.End()
+	if err := b.apikeyLimit.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	defer b.apikeyLimit.Release(1)
+
+	results := make([]APIKeyCreateResult, len(reqs))
+	for i, req := range reqs {
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Key, results[i].Err = apikey.Create(ctx, b.Client(), req.Name, req.TTL, "false", req.Roles, req.Meta)
+	}
+	return results, nil
+}
+
 func (b *Bulker) APIKeyRead(ctx context.Context, id string, withOwner bool) (*APIKeyMetadata, error) {
 	span, ctx := apm.StartSpan(ctx, "readAPIKey", "auth")
 	defer span.End()
@@ -73,6 +111,17 @@ func (b *Bulker) APIKeyRead(ctx context.Context, id string, withOwner bool) (*AP
 	return apikey.Read(ctx, b.Client(), id, withOwner)
 }
 
+func (b *Bulker) APIKeyQuery(ctx context.Context, body []byte) ([]APIKeyQueryHit, error) {
+	span, ctx := apm.StartSpan(ctx, "queryAPIKeys", "auth")
+	defer span.End()
+	if err := b.apikeyLimit.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	defer b.apikeyLimit.Release(1)
+
+	return apikey.Query(ctx, b.Client(), body)
+}
+
 func (b *Bulker) APIKeyInvalidate(ctx context.Context, ids ...string) error {
 	span, ctx := apm.StartSpan(ctx, "invalidateAPIKey", "auth")
 	defer span.End()
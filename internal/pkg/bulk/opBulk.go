@@ -9,6 +9,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8/esapi"
@@ -56,7 +58,7 @@ func (b *Bulker) waitBulkAction(ctx context.Context, action actionT, index, id s
 	const kSlop = 64
 	blk.buf.Grow(len(body) + kSlop)
 
-	if err := b.writeBulkMeta(&blk.buf, action.String(), index, id, opt.RetryOnConflict); err != nil {
+	if err := b.writeBulkMeta(&blk.buf, action.String(), index, id, opt.RetryOnConflict, opt.IfSeqNo, opt.IfPrimaryTerm); err != nil {
 		return nil, err
 	}
 
@@ -80,6 +82,9 @@ func (b *Bulker) waitBulkAction(ctx context.Context, action actionT, index, id s
 	if err := es.TranslateError(r.Status, r.Error); err != nil {
 		return nil, err
 	}
+	if opt.DocVersion != nil {
+		*opt.DocVersion = DocVersion{SeqNo: r.SeqNo, PrimaryTerm: r.PrimaryTerm}
+	}
 	return r, nil
 }
 
@@ -96,7 +101,9 @@ func (b *Bulker) writeMget(buf *Buf, index, id string) error {
 	return nil
 }
 
-func (b *Bulker) writeBulkMeta(buf *Buf, action, index, id, retry string) error {
+// writeBulkMeta writes the metadata line of the bulk action.
+// The action is conditioned on ifSeqNo and ifPrimaryTerm when ifPrimaryTerm is set, Elasticsearch rejects retry_on_conflict with them.
+func (b *Bulker) writeBulkMeta(buf *Buf, action, index, id, retry string, ifSeqNo, ifPrimaryTerm int64) error {
 	if err := b.validateMeta(index, id); err != nil {
 		return err
 	}
@@ -109,7 +116,13 @@ func (b *Bulker) writeBulkMeta(buf *Buf, action, index, id, retry string) error
 		_, _ = buf.WriteString(id)
 		_, _ = buf.WriteString(`",`)
 	}
-	if retry != "" {
+	if ifPrimaryTerm != 0 {
+		_, _ = buf.WriteString(`"if_seq_no":`)
+		_, _ = buf.WriteString(strconv.FormatInt(ifSeqNo, 10))
+		_, _ = buf.WriteString(`,"if_primary_term":`)
+		_, _ = buf.WriteString(strconv.FormatInt(ifPrimaryTerm, 10))
+		_, _ = buf.WriteString(`,`)
+	} else if retry != "" {
 		_, _ = buf.WriteString(`"retry_on_conflict":`)
 		_, _ = buf.WriteString(retry)
 		_, _ = buf.WriteString(`,`)
@@ -142,11 +155,14 @@ func (b *Bulker) writeBulkBody(buf *Buf, action actionT, body []byte) error {
 	return nil
 }
 
-func (b *Bulker) calcBulkSz(action, idx, id, retry string, body []byte) int {
+func (b *Bulker) calcBulkSz(action, idx, id, retry string, ifSeqNo, ifPrimaryTerm int64, body []byte) int {
 	const kFraming = 19
 	metaSz := kFraming + len(action) + len(idx)
 
-	if retry != "" {
+	if ifPrimaryTerm != 0 {
+		const kSeqNoFraming = 32
+		metaSz += kSeqNoFraming + len(strconv.FormatInt(ifSeqNo, 10)) + len(strconv.FormatInt(ifPrimaryTerm, 10))
+	} else if retry != "" {
 		metaSz += 21 + len(retry)
 	}
 
@@ -217,7 +233,15 @@ func (b *Bulker) flushBulk(ctx context.Context, queue queueT) error {
 		defer res.Body.Close()
 	}
 
+	// A proxy in front of elasticsearch may reject the request on its size
+	if res.StatusCode == http.StatusRequestEntityTooLarge {
+		return b.splitBulk(ctx, queue, buf.Len())
+	}
+
 	if res.IsError() {
+		if res.StatusCode == http.StatusTooManyRequests {
+			b.adaptive.throttle()
+		}
 		zerolog.Ctx(ctx).Error().Str("mod", kModBulk).Str("error.message", res.String()).Msg("Fail BulkRequest result")
 		return parseError(res, zerolog.Ctx(ctx))
 	}
@@ -272,14 +296,22 @@ func (b *Bulker) flushBulk(ctx context.Context, queue queueT) error {
 	// Do NOT return a non-nil value or failQueue
 	// up the stack will fail.
 
+	throttled := false
 	n := queue.head
 	for i := range blk.Items {
 		next := n.next // 'n' is invalid immediately on channel send
 
 		item := blk.Items[i].Choose()
+		if item != nil && item.Status == http.StatusTooManyRequests {
+			throttled = true
+		}
+		itemErr := item.deriveError()
+		if itemErr != nil && item != nil && b.capture != nil {
+			b.capture.capture(ctx, n.buf.Bytes(), item)
+		}
 		select {
 		case n.ch <- respT{
-			err:  item.deriveError(),
+			err:  itemErr,
 			idx:  n.idx,
 			data: item,
 		}:
@@ -290,6 +322,11 @@ func (b *Bulker) flushBulk(ctx context.Context, queue queueT) error {
 		n = next
 	}
 
+	if throttled {
+		b.adaptive.throttle()
+	} else {
+		b.adaptive.succeed()
+	}
 	return nil
 }
 
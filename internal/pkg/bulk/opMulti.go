@@ -13,22 +13,22 @@ import (
 // TODO: Are multi requests used by anything? a quick grep shows no hits outside the bulk package.
 
 func (b *Bulker) MCreate(ctx context.Context, ops []MultiOp, opts ...Opt) ([]BulkIndexerResponseItem, error) {
-	return b.multiWaitBulkOp(ctx, ActionCreate, ops)
+	return b.multiWaitBulkOp(ctx, ActionCreate, ops, opts...)
 }
 
 func (b *Bulker) MIndex(ctx context.Context, ops []MultiOp, opts ...Opt) ([]BulkIndexerResponseItem, error) {
-	return b.multiWaitBulkOp(ctx, ActionIndex, ops)
+	return b.multiWaitBulkOp(ctx, ActionIndex, ops, opts...)
 }
 
 func (b *Bulker) MUpdate(ctx context.Context, ops []MultiOp, opts ...Opt) ([]BulkIndexerResponseItem, error) {
-	return b.multiWaitBulkOp(ctx, ActionUpdate, ops)
+	return b.multiWaitBulkOp(ctx, ActionUpdate, ops, opts...)
 }
 
 func (b *Bulker) MDelete(ctx context.Context, ops []MultiOp, opts ...Opt) ([]BulkIndexerResponseItem, error) {
-	return b.multiWaitBulkOp(ctx, ActionDelete, ops)
+	return b.multiWaitBulkOp(ctx, ActionDelete, ops, opts...)
 }
 
-func (b *Bulker) multiWaitBulkOp(ctx context.Context, action actionT, ops []MultiOp, opts ...Opt) ([]BulkIndexerResponseItem, error) { //nolint:unparam // better to keep consistency with other methods
+func (b *Bulker) multiWaitBulkOp(ctx context.Context, action actionT, ops []MultiOp, opts ...Opt) ([]BulkIndexerResponseItem, error) {
 	if len(ops) == 0 {
 		return nil, nil
 	}
@@ -48,7 +48,7 @@ func (b *Bulker) multiWaitBulkOp(ctx context.Context, action actionT, ops []Mult
 	// O(n) Determine how much space we need
 	var byteCnt int
 	for _, op := range ops {
-		byteCnt += b.calcBulkSz(actionStr, op.Index, op.ID, opt.RetryOnConflict, op.Body)
+		byteCnt += b.calcBulkSz(actionStr, op.Index, op.ID, opt.RetryOnConflict, op.IfSeqNo, op.IfPrimaryTerm, op.Body)
 	}
 
 	// Create one bulk buffer to serialize each piece.
@@ -67,7 +67,7 @@ func (b *Bulker) multiWaitBulkOp(ctx context.Context, action actionT, ops []Mult
 
 		op := &ops[i]
 
-		if err := b.writeBulkMeta(&bulkBuf, actionStr, op.Index, op.ID, opt.RetryOnConflict); err != nil {
+		if err := b.writeBulkMeta(&bulkBuf, actionStr, op.Index, op.ID, opt.RetryOnConflict, op.IfSeqNo, op.IfPrimaryTerm); err != nil {
 			return nil, err
 		}
 
@@ -85,6 +85,9 @@ func (b *Bulker) multiWaitBulkOp(ctx context.Context, action actionT, ops []Mult
 		if opt.Refresh {
 			bulk.flags.Set(flagRefresh)
 		}
+		if opt.Droppable {
+			bulk.flags.Set(flagDroppable)
+		}
 	}
 
 	// Dispatch requests
@@ -115,6 +118,16 @@ func (b *Bulker) multiWaitBulkOp(ctx context.Context, action actionT, ops []Mult
 
 func (b *Bulker) multiDispatch(ctx context.Context, blks []bulkT) error {
 
+	// Droppable requests wait in the low priority lane
+	if b.lane != nil && blks[0].flags.Has(flagDroppable) {
+		ptrs := make([]*bulkT, len(blks))
+		for i := range blks {
+			ptrs[i] = &blks[i]
+		}
+		b.lane.push(ctx, ptrs...)
+		return nil
+	}
+
 	// Dispatch to bulk Run loop; Iterate by reference.
 	for i := range blks {
 		select {
@@ -0,0 +1,67 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+)
+
+// SearchStream runs the search outside of the bulk engine and calls fn for each hit as it is decoded from the response,
+// so the memory used does not grow with the number of hits.
+// The response is read up to the max search response size of the bulker, the search fails with es.ErrResponseTooLarge
+// beyond it.
+func (b *Bulker) SearchStream(ctx context.Context, index string, body []byte, fn func(hit *es.HitT) error, opts ...Opt) error {
+	span, ctx := apm.StartSpan(ctx, "Bulker: searchStream", "bulker")
+	defer span.End()
+	opt := b.parseOpts(opts...)
+
+	if err := b.validateIndex(index); err != nil {
+		return err
+	}
+	if err := b.validateBody(body); err != nil {
+		return err
+	}
+
+	var (
+		res *esapi.Response
+		err error
+	)
+	// Use the fleet search endpoint if need to wait for checkpoints
+	if len(opt.WaitForCheckpoints) > 0 {
+		req := esapi.FleetSearchRequest{
+			Index:              index,
+			Body:               bytes.NewReader(body),
+			WaitForCheckpoints: []string{sqn.SeqNo(opt.WaitForCheckpoints).String()},
+		}
+		res, err = req.Do(ctx, b.es)
+	} else {
+		req := esapi.SearchRequest{
+			Index: append([]string{index}, opt.Indices...),
+			Body:  bytes.NewReader(body),
+		}
+		if opt.IgnoreUnavailable {
+			ignore := true
+			req.IgnoreUnavailable = &ignore
+		}
+		res, err = req.Do(ctx, b.es)
+	}
+	if err != nil {
+		return err
+	}
+	defer es.DrainAndClose(res.Body)
+
+	if err := es.DecodeHits(es.LimitReader(res.Body, b.searchMaxRespSz.Load()), res.StatusCode, fn); err != nil {
+		return fmt.Errorf("search stream %s: %w", index, err)
+	}
+	return nil
+}
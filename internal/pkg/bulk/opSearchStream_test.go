@@ -0,0 +1,112 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+)
+
+// searchTransport answers the searches with body and records the requests.
+type searchTransport struct {
+	status int
+	body   string
+	reqs   []*http.Request
+	bodies []*trackedBody
+}
+
+// trackedBody records if the response body was read to the end and closed.
+type trackedBody struct {
+	io.Reader
+	eof    bool
+	closed bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (m *searchTransport) Perform(req *http.Request) (*http.Response, error) {
+	m.reqs = append(m.reqs, req)
+	body := &trackedBody{Reader: strings.NewReader(m.body)}
+	m.bodies = append(m.bodies, body)
+	return &http.Response{
+		Request:    req,
+		StatusCode: m.status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       body,
+	}, nil
+}
+
+func TestBulkerSearchStream(t *testing.T) {
+	const response = `{"took":1,"hits":{"total":{"value":2,"relation":"eq"},"hits":[{"_id":"agent-1","_source":{}},{"_id":"agent-2","_source":{}}]},"aggregations":{}}`
+	ctx := context.Background()
+	collect := func(ids *[]string) func(hit *es.HitT) error {
+		return func(hit *es.HitT) error {
+			*ids = append(*ids, hit.ID)
+			return nil
+		}
+	}
+
+	t.Run("search", func(t *testing.T) {
+		transport := &searchTransport{status: http.StatusOK, body: response}
+		var ids []string
+		require.NoError(t, NewBulker(transport, nil).SearchStream(ctx, ".fleet-agents", []byte(`{}`), collect(&ids), WithIgnoreUnavailble()))
+		require.Equal(t, []string{"agent-1", "agent-2"}, ids)
+		require.Equal(t, "/.fleet-agents/_search", transport.reqs[0].URL.Path)
+		require.Equal(t, "true", transport.reqs[0].URL.Query().Get("ignore_unavailable"))
+		require.True(t, transport.bodies[0].eof, "the body is drained")
+		require.True(t, transport.bodies[0].closed)
+	})
+
+	t.Run("wait for checkpoints", func(t *testing.T) {
+		transport := &searchTransport{status: http.StatusOK, body: response}
+		var ids []string
+		require.NoError(t, NewBulker(transport, nil).SearchStream(ctx, ".fleet-actions", []byte(`{}`), collect(&ids), WithWaitForCheckpoints([]int64{3, 4})))
+		require.Len(t, ids, 2)
+		require.Equal(t, "/.fleet-actions/_fleet/_fleet_search", transport.reqs[0].URL.Path)
+		require.Equal(t, "3,4", transport.reqs[0].URL.Query().Get("wait_for_checkpoints"))
+	})
+
+	t.Run("response too large", func(t *testing.T) {
+		transport := &searchTransport{status: http.StatusOK, body: response}
+		var ids []string
+		err := NewBulker(transport, nil, WithSearchMaxResponseSize(64)).SearchStream(ctx, ".fleet-agents", []byte(`{}`), collect(&ids))
+		var tooLarge *es.ErrResponseTooLarge
+		require.ErrorAs(t, err, &tooLarge)
+		require.Equal(t, int64(64), tooLarge.Limit)
+		require.Empty(t, ids)
+		require.True(t, transport.bodies[0].closed)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		transport := &searchTransport{status: http.StatusNotFound, body: `{"error":{"type":"index_not_found_exception","reason":"no such index"},"status":404}`}
+		err := NewBulker(transport, nil).SearchStream(ctx, ".fleet-agents", []byte(`{}`), collect(new([]string)))
+		require.ErrorIs(t, err, es.ErrIndexNotFound)
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		transport := &searchTransport{status: http.StatusOK, body: response}
+		err := NewBulker(transport, nil).SearchStream(ctx, ".fleet-agents", []byte(`{`), collect(new([]string)))
+		require.ErrorIs(t, err, es.ErrInvalidBody)
+		require.Empty(t, transport.reqs)
+	})
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/rs/zerolog"
 	"go.elastic.co/apm/v2"
 
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
 	"github.com/elastic/fleet-server/v7/internal/pkg/build"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 )
@@ -22,9 +24,13 @@ import (
 type optionsT struct {
 	Refresh            bool
 	RetryOnConflict    string
+	IfSeqNo            int64
+	IfPrimaryTerm      int64
 	Indices            []string
 	WaitForCheckpoints []int64
 	IgnoreUnavailable  bool
+	Droppable          bool
+	DocVersion         *DocVersion
 	spanLink           *apm.SpanLink
 }
 
@@ -42,12 +48,44 @@ func WithIgnoreUnavailble() Opt {
 	}
 }
 
+// WithDroppable marks a non-critical write, it is queued in the low priority lane of the bulker and may be
+// dropped with ErrDropped when the lane is full.
+func WithDroppable() Opt {
+	return func(opt *optionsT) {
+		opt.Droppable = true
+	}
+}
+
 func WithRetryOnConflict(n int) Opt {
 	return func(opt *optionsT) {
 		opt.RetryOnConflict = strconv.Itoa(n)
 	}
 }
 
+// WithIfSeqNo conditions the write of a single document on the seq_no and primary_term the document was read with.
+// The write fails with es.ErrElasticVersionConflict when the document was changed since, retry on conflict is then ignored.
+// The write is unconditional when primaryTerm is 0, as for a document that was read without its seq_no and primary_term.
+func WithIfSeqNo(seqNo, primaryTerm int64) Opt {
+	return func(opt *optionsT) {
+		opt.IfSeqNo = seqNo
+		opt.IfPrimaryTerm = primaryTerm
+	}
+}
+
+// DocVersion is the seq_no and primary_term of a written document.
+type DocVersion struct {
+	SeqNo       int64
+	PrimaryTerm int64
+}
+
+// WithDocVersion stores the seq_no and primary_term of the document written by Create, Index or Update in v.
+// A writer can tell its readers which version of the document to expect instead of refreshing the index.
+func WithDocVersion(v *DocVersion) Opt {
+	return func(opt *optionsT) {
+		opt.DocVersion = v
+	}
+}
+
 // WithIndex sets the index when searching
 func WithIndex(idx string) Opt {
 	return func(opt *optionsT) {
@@ -63,6 +101,7 @@ func WithWaitForCheckpoints(checkpoints []int64) Opt {
 	}
 }
 
+// withAPMLinkedContext links the flush of the request to the span, or the transaction, of ctx.
 func withAPMLinkedContext(ctx context.Context) Opt {
 	return func(opt *optionsT) {
 		trace := apm.TransactionFromContext(ctx)
@@ -70,6 +109,9 @@ func withAPMLinkedContext(ctx context.Context) Opt {
 			return
 		}
 		tCtx := trace.TraceContext()
+		if span := apm.SpanFromContext(ctx); span != nil {
+			tCtx = span.TraceContext()
+		}
 		opt.spanLink = &apm.SpanLink{
 			Trace: tCtx.Trace,
 			Span:  tCtx.Span,
@@ -88,8 +130,13 @@ type bulkOptT struct {
 	blockQueueSz      int
 	apikeyMaxParallel int
 	apikeyMaxReqSize  int
+	lowPriorityMaxSz  int
+	searchMaxRespSz   int
 	policyTokens      []config.PolicyToken
 	bi                build.Info
+	stats             *monitoring.Registry
+	failedBulk        *config.LoggingFailedBulk // capture of the failed items, nil if disabled
+	spool             *config.BulkSpool         // disk spool of the low priority lane, nil if disabled
 }
 
 type BulkOpt func(*bulkOptT)
@@ -129,6 +176,22 @@ func WithBlockQueueSize(sz int) BulkOpt {
 	}
 }
 
+// WithLowPriorityMaxSize sets the size in bytes of the droppable requests pending in the low priority lane,
+// the oldest are dropped beyond it. Droppable requests are not treated differently when it is 0.
+func WithLowPriorityMaxSize(sz int) BulkOpt {
+	return func(opt *bulkOptT) {
+		opt.lowPriorityMaxSz = sz
+	}
+}
+
+// WithSearchMaxResponseSize sets the size in bytes of the streamed search responses, the searches fail with
+// es.ErrResponseTooLarge beyond it. The responses are not limited when it is 0.
+func WithSearchMaxResponseSize(sz int) BulkOpt {
+	return func(opt *bulkOptT) {
+		opt.searchMaxRespSz = sz
+	}
+}
+
 // WithAPIKeyMaxParallel sets the number of api key operations outstanding
 func WithAPIKeyMaxParallel(max int) BulkOpt {
 	return func(opt *bulkOptT) {
@@ -158,6 +221,28 @@ func WithBi(bi build.Info) BulkOpt {
 	}
 }
 
+// WithStats registers the queue stats of the bulker in reg.
+func WithStats(reg *monitoring.Registry) BulkOpt {
+	return func(opt *bulkOptT) {
+		opt.stats = reg
+	}
+}
+
+// WithFailureCapture enables the capture of the bulk items rejected by Elasticsearch with cfg.
+func WithFailureCapture(cfg config.LoggingFailedBulk) BulkOpt {
+	return func(opt *bulkOptT) {
+		opt.failedBulk = &cfg
+	}
+}
+
+// WithSpool enables the disk spool of the droppable requests that overflow the low priority lane while elasticsearch is
+// unavailable, it requires WithLowPriorityMaxSize.
+func WithSpool(cfg config.BulkSpool) BulkOpt {
+	return func(opt *bulkOptT) {
+		opt.spool = &cfg
+	}
+}
+
 func parseBulkOpts(opts ...BulkOpt) bulkOptT {
 	bopt := bulkOptT{
 		flushInterval:     defaultFlushInterval,
@@ -167,6 +252,7 @@ func parseBulkOpts(opts ...BulkOpt) bulkOptT {
 		apikeyMaxParallel: defaultAPIKeyMaxParallel,
 		blockQueueSz:      defaultBlockQueueSz,
 		apikeyMaxReqSize:  defaultApikeyMaxReqSize,
+		searchMaxRespSz:   defaultSearchMaxRespSz,
 		policyTokens:      []config.PolicyToken{}, // default is empty
 	}
 
@@ -185,6 +271,10 @@ func (o *bulkOptT) MarshalZerologObject(e *zerolog.Event) {
 	e.Int("blockQueueSz", o.blockQueueSz)
 	e.Int("apikeyMaxParallel", o.apikeyMaxParallel)
 	e.Int("apikeyMaxReqSize", o.apikeyMaxReqSize)
+	e.Int("lowPriorityMaxSz", o.lowPriorityMaxSz)
+	e.Int("searchMaxRespSz", o.searchMaxRespSz)
+	e.Bool("captureFailedBulk", o.failedBulk != nil)
+	e.Bool("spool", o.spool != nil)
 }
 
 // BulkOptsFromCfg transforms config to a slize of BulkOpt
@@ -201,13 +291,22 @@ func BulkOptsFromCfg(cfg *config.Config) []BulkOpt {
 	if cfg.Inputs[0].Server.StaticPolicyTokens.Enabled {
 		policyTokens = cfg.Inputs[0].Server.StaticPolicyTokens.PolicyTokens
 	}
-	return []BulkOpt{
+	opts := []BulkOpt{
 		WithFlushInterval(bulkCfg.FlushInterval),
 		WithFlushThresholdCount(bulkCfg.FlushThresholdCount),
 		WithFlushThresholdSize(bulkCfg.FlushThresholdSize),
 		WithMaxPending(bulkCfg.FlushMaxPending),
+		WithLowPriorityMaxSize(bulkCfg.LowPriorityMaxSize),
+		WithSearchMaxResponseSize(bulkCfg.SearchMaxResponseSize),
 		WithAPIKeyMaxParallel(maxKeyParallel),
 		WithAPIKeyMaxRequestSize(cfg.Output.Elasticsearch.MaxContentLength),
 		WithPolicyTokens(policyTokens),
 	}
+	if bulkCfg.Spool.Enabled {
+		opts = append(opts, WithSpool(bulkCfg.Spool))
+	}
+	if cfg.Logging.CaptureFailedBulk {
+		opts = append(opts, WithFailureCapture(cfg.Logging.FailedBulk))
+	}
+	return opts
 }
@@ -7,6 +7,7 @@ package bulk
 import (
 	"encoding/json"
 	"errors"
+	"net/http"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 )
@@ -48,9 +49,9 @@ type BulkIndexerResponseItem struct {
 	DocumentID string `json:"_id"`
 	//	Version    int64  `json:"_version"`
 	//	Result     string `json:"result"`
-	Status int `json:"status"`
-	//	SeqNo      int64  `json:"_seq_no"`
-	//	PrimTerm   int64  `json:"_primary_term"`
+	Status      int   `json:"status"`
+	SeqNo       int64 `json:"_seq_no"`
+	PrimaryTerm int64 `json:"_primary_term"`
 
 	//	Shards struct {
 	//		Total      int `json:"total"`
@@ -80,11 +81,11 @@ type MgetResponse struct {
 type MgetResponseItem struct {
 	//	Index      string          `json:"_index"`
 	//	Type       string          `json:"_type"`
-	DocumentID string `json:"_id"`
-	Version    int64  `json:"_version"`
-	SeqNo      int64  `json:"_seq_no"`
-	//	PrimTerm   int64           `json:"_primary_term"`
-	Found bool `json:"found"`
+	DocumentID  string `json:"_id"`
+	Version     int64  `json:"_version"`
+	SeqNo       int64  `json:"_seq_no"`
+	PrimaryTerm int64  `json:"_primary_term"`
+	Found       bool   `json:"found"`
 	//	Routing    string          `json:"_routing"`
 	Source json.RawMessage `json:"_source"`
 	//	Fields     json.RawMessage `json:"_fields"`
@@ -92,7 +93,10 @@ type MgetResponseItem struct {
 
 func (i *MgetResponseItem) deriveError() error {
 	if !i.Found {
-		return es.ErrElasticNotFound
+		return &es.ErrElastic{
+			Status: http.StatusNotFound,
+			Reason: "document [" + i.DocumentID + "] not found",
+		}
 	}
 	return nil
 }
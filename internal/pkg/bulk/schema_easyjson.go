@@ -22,7 +22,117 @@ var (
 	_ easyjson.Marshaler
 )
 
-func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk(in *jlexer.Lexer, out *bulkStubItem) {
+func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk(in *jlexer.Lexer, out *bulkIndexerResponse) {
+	isTopLevel := in.IsStart()
+	if in.IsNull() {
+		if isTopLevel {
+			in.Consumed()
+		}
+		in.Skip()
+		return
+	}
+	in.Delim('{')
+	for !in.IsDelim('}') {
+		key := in.UnsafeFieldName(false)
+		in.WantColon()
+		if in.IsNull() {
+			in.Skip()
+			in.WantComma()
+			continue
+		}
+		switch key {
+		case "took":
+			out.Took = int(in.Int())
+		case "errors":
+			out.HasErrors = bool(in.Bool())
+		case "items":
+			if in.IsNull() {
+				in.Skip()
+				out.Items = nil
+			} else {
+				in.Delim('[')
+				if out.Items == nil {
+					if !in.IsDelim(']') {
+						out.Items = make([]bulkStubItem, 0, 2)
+					} else {
+						out.Items = []bulkStubItem{}
+					}
+				} else {
+					out.Items = (out.Items)[:0]
+				}
+				for !in.IsDelim(']') {
+					var v1 bulkStubItem
+					easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk1(in, &v1)
+					out.Items = append(out.Items, v1)
+					in.WantComma()
+				}
+				in.Delim(']')
+			}
+		default:
+			in.SkipRecursive()
+		}
+		in.WantComma()
+	}
+	in.Delim('}')
+	if isTopLevel {
+		in.Consumed()
+	}
+}
+func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk(out *jwriter.Writer, in bulkIndexerResponse) {
+	out.RawByte('{')
+	first := true
+	_ = first
+	{
+		const prefix string = ",\"took\":"
+		out.RawString(prefix[1:])
+		out.Int(int(in.Took))
+	}
+	{
+		const prefix string = ",\"errors\":"
+		out.RawString(prefix)
+		out.Bool(bool(in.HasErrors))
+	}
+	if len(in.Items) != 0 {
+		const prefix string = ",\"items\":"
+		out.RawString(prefix)
+		{
+			out.RawByte('[')
+			for v2, v3 := range in.Items {
+				if v2 > 0 {
+					out.RawByte(',')
+				}
+				easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk1(out, v3)
+			}
+			out.RawByte(']')
+		}
+	}
+	out.RawByte('}')
+}
+
+// MarshalJSON supports json.Marshaler interface
+func (v bulkIndexerResponse) MarshalJSON() ([]byte, error) {
+	w := jwriter.Writer{}
+	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk(&w, v)
+	return w.Buffer.BuildBytes(), w.Error
+}
+
+// MarshalEasyJSON supports easyjson.Marshaler interface
+func (v bulkIndexerResponse) MarshalEasyJSON(w *jwriter.Writer) {
+	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk(w, v)
+}
+
+// UnmarshalJSON supports json.Unmarshaler interface
+func (v *bulkIndexerResponse) UnmarshalJSON(data []byte) error {
+	r := jlexer.Lexer{Data: data}
+	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk(&r, v)
+	return r.Error()
+}
+
+// UnmarshalEasyJSON supports easyjson.Unmarshaler interface
+func (v *bulkIndexerResponse) UnmarshalEasyJSON(l *jlexer.Lexer) {
+	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk(l, v)
+}
+func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk1(in *jlexer.Lexer, out *bulkStubItem) {
 	isTopLevel := in.IsStart()
 	if in.IsNull() {
 		if isTopLevel {
@@ -49,7 +159,7 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk(in *jlex
 				if out.Index == nil {
 					out.Index = new(BulkIndexerResponseItem)
 				}
-				(*out.Index).UnmarshalEasyJSON(in)
+				easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk2(in, out.Index)
 			}
 		case "delete":
 			if in.IsNull() {
@@ -59,7 +169,7 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk(in *jlex
 				if out.Delete == nil {
 					out.Delete = new(BulkIndexerResponseItem)
 				}
-				(*out.Delete).UnmarshalEasyJSON(in)
+				easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk2(in, out.Delete)
 			}
 		case "create":
 			if in.IsNull() {
@@ -69,7 +179,7 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk(in *jlex
 				if out.Create == nil {
 					out.Create = new(BulkIndexerResponseItem)
 				}
-				(*out.Create).UnmarshalEasyJSON(in)
+				easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk2(in, out.Create)
 			}
 		case "update":
 			if in.IsNull() {
@@ -79,7 +189,7 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk(in *jlex
 				if out.Update == nil {
 					out.Update = new(BulkIndexerResponseItem)
 				}
-				(*out.Update).UnmarshalEasyJSON(in)
+				easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk2(in, out.Update)
 			}
 		default:
 			in.SkipRecursive()
@@ -91,7 +201,7 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk(in *jlex
 		in.Consumed()
 	}
 }
-func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk(out *jwriter.Writer, in bulkStubItem) {
+func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk1(out *jwriter.Writer, in bulkStubItem) {
 	out.RawByte('{')
 	first := true
 	_ = first
@@ -101,7 +211,7 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk(out *jwr
 		if in.Index == nil {
 			out.RawString("null")
 		} else {
-			(*in.Index).MarshalEasyJSON(out)
+			easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk2(out, *in.Index)
 		}
 	}
 	{
@@ -110,7 +220,7 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk(out *jwr
 		if in.Delete == nil {
 			out.RawString("null")
 		} else {
-			(*in.Delete).MarshalEasyJSON(out)
+			easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk2(out, *in.Delete)
 		}
 	}
 	{
@@ -119,7 +229,7 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk(out *jwr
 		if in.Create == nil {
 			out.RawString("null")
 		} else {
-			(*in.Create).MarshalEasyJSON(out)
+			easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk2(out, *in.Create)
 		}
 	}
 	{
@@ -128,36 +238,84 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk(out *jwr
 		if in.Update == nil {
 			out.RawString("null")
 		} else {
-			(*in.Update).MarshalEasyJSON(out)
+			easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk2(out, *in.Update)
 		}
 	}
 	out.RawByte('}')
 }
-
-// MarshalJSON supports json.Marshaler interface
-func (v bulkStubItem) MarshalJSON() ([]byte, error) {
-	w := jwriter.Writer{}
-	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk(&w, v)
-	return w.Buffer.BuildBytes(), w.Error
-}
-
-// MarshalEasyJSON supports easyjson.Marshaler interface
-func (v bulkStubItem) MarshalEasyJSON(w *jwriter.Writer) {
-	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk(w, v)
-}
-
-// UnmarshalJSON supports json.Unmarshaler interface
-func (v *bulkStubItem) UnmarshalJSON(data []byte) error {
-	r := jlexer.Lexer{Data: data}
-	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk(&r, v)
-	return r.Error()
+func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk2(in *jlexer.Lexer, out *BulkIndexerResponseItem) {
+	isTopLevel := in.IsStart()
+	if in.IsNull() {
+		if isTopLevel {
+			in.Consumed()
+		}
+		in.Skip()
+		return
+	}
+	in.Delim('{')
+	for !in.IsDelim('}') {
+		key := in.UnsafeFieldName(false)
+		in.WantColon()
+		if in.IsNull() {
+			in.Skip()
+			in.WantComma()
+			continue
+		}
+		switch key {
+		case "_id":
+			out.DocumentID = string(in.String())
+		case "status":
+			out.Status = int(in.Int())
+		case "_seq_no":
+			out.SeqNo = int64(in.Int64())
+		case "_primary_term":
+			out.PrimaryTerm = int64(in.Int64())
+		case "error":
+			if data := in.Raw(); in.Ok() {
+				in.AddError((out.Error).UnmarshalJSON(data))
+			}
+		default:
+			in.SkipRecursive()
+		}
+		in.WantComma()
+	}
+	in.Delim('}')
+	if isTopLevel {
+		in.Consumed()
+	}
 }
-
-// UnmarshalEasyJSON supports easyjson.Unmarshaler interface
-func (v *bulkStubItem) UnmarshalEasyJSON(l *jlexer.Lexer) {
-	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk(l, v)
+func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk2(out *jwriter.Writer, in BulkIndexerResponseItem) {
+	out.RawByte('{')
+	first := true
+	_ = first
+	{
+		const prefix string = ",\"_id\":"
+		out.RawString(prefix[1:])
+		out.String(string(in.DocumentID))
+	}
+	{
+		const prefix string = ",\"status\":"
+		out.RawString(prefix)
+		out.Int(int(in.Status))
+	}
+	{
+		const prefix string = ",\"_seq_no\":"
+		out.RawString(prefix)
+		out.Int64(int64(in.SeqNo))
+	}
+	{
+		const prefix string = ",\"_primary_term\":"
+		out.RawString(prefix)
+		out.Int64(int64(in.PrimaryTerm))
+	}
+	if len(in.Error) != 0 {
+		const prefix string = ",\"error\":"
+		out.RawString(prefix)
+		out.Raw((in.Error).MarshalJSON())
+	}
+	out.RawByte('}')
 }
-func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk1(in *jlexer.Lexer, out *bulkIndexerResponse) {
+func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk3(in *jlexer.Lexer, out *MsearchResponse) {
 	isTopLevel := in.IsStart()
 	if in.IsNull() {
 		if isTopLevel {
@@ -176,33 +334,31 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk1(in *jle
 			continue
 		}
 		switch key {
-		case "took":
-			out.Took = int(in.Int())
-		case "errors":
-			out.HasErrors = bool(in.Bool())
-		case "items":
+		case "responses":
 			if in.IsNull() {
 				in.Skip()
-				out.Items = nil
+				out.Responses = nil
 			} else {
 				in.Delim('[')
-				if out.Items == nil {
+				if out.Responses == nil {
 					if !in.IsDelim(']') {
-						out.Items = make([]bulkStubItem, 0, 2)
+						out.Responses = make([]MsearchResponseItem, 0, 0)
 					} else {
-						out.Items = []bulkStubItem{}
+						out.Responses = []MsearchResponseItem{}
 					}
 				} else {
-					out.Items = (out.Items)[:0]
+					out.Responses = (out.Responses)[:0]
 				}
 				for !in.IsDelim(']') {
-					var v1 bulkStubItem
-					(v1).UnmarshalEasyJSON(in)
-					out.Items = append(out.Items, v1)
+					var v4 MsearchResponseItem
+					easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk4(in, &v4)
+					out.Responses = append(out.Responses, v4)
 					in.WantComma()
 				}
 				in.Delim(']')
 			}
+		case "took":
+			out.Took = int(in.Int())
 		default:
 			in.SkipRecursive()
 		}
@@ -213,61 +369,58 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk1(in *jle
 		in.Consumed()
 	}
 }
-func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk1(out *jwriter.Writer, in bulkIndexerResponse) {
+func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk3(out *jwriter.Writer, in MsearchResponse) {
 	out.RawByte('{')
 	first := true
 	_ = first
 	{
-		const prefix string = ",\"took\":"
+		const prefix string = ",\"responses\":"
 		out.RawString(prefix[1:])
-		out.Int(int(in.Took))
-	}
-	{
-		const prefix string = ",\"errors\":"
-		out.RawString(prefix)
-		out.Bool(bool(in.HasErrors))
-	}
-	if len(in.Items) != 0 {
-		const prefix string = ",\"items\":"
-		out.RawString(prefix)
-		{
+		if in.Responses == nil && (out.Flags&jwriter.NilSliceAsEmpty) == 0 {
+			out.RawString("null")
+		} else {
 			out.RawByte('[')
-			for v2, v3 := range in.Items {
-				if v2 > 0 {
+			for v5, v6 := range in.Responses {
+				if v5 > 0 {
 					out.RawByte(',')
 				}
-				(v3).MarshalEasyJSON(out)
+				easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk4(out, v6)
 			}
 			out.RawByte(']')
 		}
 	}
+	{
+		const prefix string = ",\"took\":"
+		out.RawString(prefix)
+		out.Int(int(in.Took))
+	}
 	out.RawByte('}')
 }
 
 // MarshalJSON supports json.Marshaler interface
-func (v bulkIndexerResponse) MarshalJSON() ([]byte, error) {
+func (v MsearchResponse) MarshalJSON() ([]byte, error) {
 	w := jwriter.Writer{}
-	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk1(&w, v)
+	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk3(&w, v)
 	return w.Buffer.BuildBytes(), w.Error
 }
 
 // MarshalEasyJSON supports easyjson.Marshaler interface
-func (v bulkIndexerResponse) MarshalEasyJSON(w *jwriter.Writer) {
-	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk1(w, v)
+func (v MsearchResponse) MarshalEasyJSON(w *jwriter.Writer) {
+	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk3(w, v)
 }
 
 // UnmarshalJSON supports json.Unmarshaler interface
-func (v *bulkIndexerResponse) UnmarshalJSON(data []byte) error {
+func (v *MsearchResponse) UnmarshalJSON(data []byte) error {
 	r := jlexer.Lexer{Data: data}
-	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk1(&r, v)
+	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk3(&r, v)
 	return r.Error()
 }
 
 // UnmarshalEasyJSON supports easyjson.Unmarshaler interface
-func (v *bulkIndexerResponse) UnmarshalEasyJSON(l *jlexer.Lexer) {
-	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk1(l, v)
+func (v *MsearchResponse) UnmarshalEasyJSON(l *jlexer.Lexer) {
+	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk3(l, v)
 }
-func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk2(in *jlexer.Lexer, out *MsearchResponseItem) {
+func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk4(in *jlexer.Lexer, out *MsearchResponseItem) {
 	isTopLevel := in.IsStart()
 	if in.IsNull() {
 		if isTopLevel {
@@ -309,9 +462,9 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk2(in *jle
 				for !in.IsDelim('}') {
 					key := string(in.String())
 					in.WantColon()
-					var v4 es.Aggregation
-					easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgEs1(in, &v4)
-					(out.Aggregations)[key] = v4
+					var v7 es.Aggregation
+					easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgEs1(in, &v7)
+					(out.Aggregations)[key] = v7
 					in.WantComma()
 				}
 				in.Delim('}')
@@ -330,7 +483,7 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk2(in *jle
 		in.Consumed()
 	}
 }
-func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk2(out *jwriter.Writer, in MsearchResponseItem) {
+func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk4(out *jwriter.Writer, in MsearchResponseItem) {
 	out.RawByte('{')
 	first := true
 	_ = first
@@ -364,16 +517,16 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk2(out *jw
 		out.RawString(prefix)
 		{
 			out.RawByte('{')
-			v5First := true
-			for v5Name, v5Value := range in.Aggregations {
-				if v5First {
-					v5First = false
+			v8First := true
+			for v8Name, v8Value := range in.Aggregations {
+				if v8First {
+					v8First = false
 				} else {
 					out.RawByte(',')
 				}
-				out.String(string(v5Name))
+				out.String(string(v8Name))
 				out.RawByte(':')
-				easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgEs1(out, v5Value)
+				easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgEs1(out, v8Value)
 			}
 			out.RawByte('}')
 		}
@@ -385,30 +538,6 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk2(out *jw
 	}
 	out.RawByte('}')
 }
-
-// MarshalJSON supports json.Marshaler interface
-func (v MsearchResponseItem) MarshalJSON() ([]byte, error) {
-	w := jwriter.Writer{}
-	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk2(&w, v)
-	return w.Buffer.BuildBytes(), w.Error
-}
-
-// MarshalEasyJSON supports easyjson.Marshaler interface
-func (v MsearchResponseItem) MarshalEasyJSON(w *jwriter.Writer) {
-	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk2(w, v)
-}
-
-// UnmarshalJSON supports json.Unmarshaler interface
-func (v *MsearchResponseItem) UnmarshalJSON(data []byte) error {
-	r := jlexer.Lexer{Data: data}
-	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk2(&r, v)
-	return r.Error()
-}
-
-// UnmarshalEasyJSON supports easyjson.Unmarshaler interface
-func (v *MsearchResponseItem) UnmarshalEasyJSON(l *jlexer.Lexer) {
-	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk2(l, v)
-}
 func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgEs1(in *jlexer.Lexer, out *es.Aggregation) {
 	isTopLevel := in.IsStart()
 	if in.IsNull() {
@@ -450,11 +579,11 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgEs1(in *jlexe
 					out.Buckets = (out.Buckets)[:0]
 				}
 				for !in.IsDelim(']') {
-					var v6 es.Bucket
+					var v9 es.Bucket
 					if data := in.Raw(); in.Ok() {
-						in.AddError((v6).UnmarshalJSON(data))
+						in.AddError((v9).UnmarshalJSON(data))
 					}
-					out.Buckets = append(out.Buckets, v6)
+					out.Buckets = append(out.Buckets, v9)
 					in.WantComma()
 				}
 				in.Delim(']')
@@ -493,11 +622,11 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgEs1(out *jwri
 		out.RawString(prefix)
 		{
 			out.RawByte('[')
-			for v7, v8 := range in.Buckets {
-				if v7 > 0 {
+			for v10, v11 := range in.Buckets {
+				if v10 > 0 {
 					out.RawByte(',')
 				}
-				easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgEs2(out, v8)
+				easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgEs2(out, v11)
 			}
 			out.RawByte(']')
 		}
@@ -588,9 +717,9 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgEs(in *jlexer
 					out.Hits = (out.Hits)[:0]
 				}
 				for !in.IsDelim(']') {
-					var v9 es.HitT
-					easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgEs3(in, &v9)
-					out.Hits = append(out.Hits, v9)
+					var v12 es.HitT
+					easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgEs3(in, &v12)
+					out.Hits = append(out.Hits, v12)
 					in.WantComma()
 				}
 				in.Delim(']')
@@ -628,11 +757,11 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgEs(out *jwrit
 			out.RawString("null")
 		} else {
 			out.RawByte('[')
-			for v10, v11 := range in.Hits {
-				if v10 > 0 {
+			for v13, v14 := range in.Hits {
+				if v13 > 0 {
 					out.RawByte(',')
 				}
-				easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgEs3(out, v11)
+				easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgEs3(out, v14)
 			}
 			out.RawByte(']')
 		}
@@ -731,6 +860,8 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgEs3(in *jlexe
 			out.ID = string(in.String())
 		case "_seq_no":
 			out.SeqNo = int64(in.Int64())
+		case "_primary_term":
+			out.PrimaryTerm = int64(in.Int64())
 		case "version":
 			out.Version = int64(in.Int64())
 		case "_index":
@@ -758,15 +889,15 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgEs3(in *jlexe
 				for !in.IsDelim('}') {
 					key := string(in.String())
 					in.WantColon()
-					var v12 interface{}
-					if m, ok := v12.(easyjson.Unmarshaler); ok {
+					var v15 interface{}
+					if m, ok := v15.(easyjson.Unmarshaler); ok {
 						m.UnmarshalEasyJSON(in)
-					} else if m, ok := v12.(json.Unmarshaler); ok {
+					} else if m, ok := v15.(json.Unmarshaler); ok {
 						_ = m.UnmarshalJSON(in.Raw())
 					} else {
-						v12 = in.Interface()
+						v15 = in.Interface()
 					}
-					(out.Fields)[key] = v12
+					(out.Fields)[key] = v15
 					in.WantComma()
 				}
 				in.Delim('}')
@@ -795,6 +926,11 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgEs3(out *jwri
 		out.RawString(prefix)
 		out.Int64(int64(in.SeqNo))
 	}
+	{
+		const prefix string = ",\"_primary_term\":"
+		out.RawString(prefix)
+		out.Int64(int64(in.PrimaryTerm))
+	}
 	{
 		const prefix string = ",\"version\":"
 		out.RawString(prefix)
@@ -826,21 +962,21 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgEs3(out *jwri
 			out.RawString(`null`)
 		} else {
 			out.RawByte('{')
-			v13First := true
-			for v13Name, v13Value := range in.Fields {
-				if v13First {
-					v13First = false
+			v16First := true
+			for v16Name, v16Value := range in.Fields {
+				if v16First {
+					v16First = false
 				} else {
 					out.RawByte(',')
 				}
-				out.String(string(v13Name))
+				out.String(string(v16Name))
 				out.RawByte(':')
-				if m, ok := v13Value.(easyjson.Marshaler); ok {
+				if m, ok := v16Value.(easyjson.Marshaler); ok {
 					m.MarshalEasyJSON(out)
-				} else if m, ok := v13Value.(json.Marshaler); ok {
+				} else if m, ok := v16Value.(json.Marshaler); ok {
 					out.Raw(m.MarshalJSON())
 				} else {
-					out.Raw(json.Marshal(v13Value))
+					out.Raw(json.Marshal(v16Value))
 				}
 			}
 			out.RawByte('}')
@@ -921,186 +1057,6 @@ func easyjsonCef4e921Encode(out *jwriter.Writer, in struct {
 	}
 	out.RawByte('}')
 }
-func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk3(in *jlexer.Lexer, out *MsearchResponse) {
-	isTopLevel := in.IsStart()
-	if in.IsNull() {
-		if isTopLevel {
-			in.Consumed()
-		}
-		in.Skip()
-		return
-	}
-	in.Delim('{')
-	for !in.IsDelim('}') {
-		key := in.UnsafeFieldName(false)
-		in.WantColon()
-		if in.IsNull() {
-			in.Skip()
-			in.WantComma()
-			continue
-		}
-		switch key {
-		case "responses":
-			if in.IsNull() {
-				in.Skip()
-				out.Responses = nil
-			} else {
-				in.Delim('[')
-				if out.Responses == nil {
-					if !in.IsDelim(']') {
-						out.Responses = make([]MsearchResponseItem, 0, 0)
-					} else {
-						out.Responses = []MsearchResponseItem{}
-					}
-				} else {
-					out.Responses = (out.Responses)[:0]
-				}
-				for !in.IsDelim(']') {
-					var v14 MsearchResponseItem
-					(v14).UnmarshalEasyJSON(in)
-					out.Responses = append(out.Responses, v14)
-					in.WantComma()
-				}
-				in.Delim(']')
-			}
-		case "took":
-			out.Took = int(in.Int())
-		default:
-			in.SkipRecursive()
-		}
-		in.WantComma()
-	}
-	in.Delim('}')
-	if isTopLevel {
-		in.Consumed()
-	}
-}
-func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk3(out *jwriter.Writer, in MsearchResponse) {
-	out.RawByte('{')
-	first := true
-	_ = first
-	{
-		const prefix string = ",\"responses\":"
-		out.RawString(prefix[1:])
-		if in.Responses == nil && (out.Flags&jwriter.NilSliceAsEmpty) == 0 {
-			out.RawString("null")
-		} else {
-			out.RawByte('[')
-			for v15, v16 := range in.Responses {
-				if v15 > 0 {
-					out.RawByte(',')
-				}
-				(v16).MarshalEasyJSON(out)
-			}
-			out.RawByte(']')
-		}
-	}
-	{
-		const prefix string = ",\"took\":"
-		out.RawString(prefix)
-		out.Int(int(in.Took))
-	}
-	out.RawByte('}')
-}
-
-// MarshalJSON supports json.Marshaler interface
-func (v MsearchResponse) MarshalJSON() ([]byte, error) {
-	w := jwriter.Writer{}
-	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk3(&w, v)
-	return w.Buffer.BuildBytes(), w.Error
-}
-
-// MarshalEasyJSON supports easyjson.Marshaler interface
-func (v MsearchResponse) MarshalEasyJSON(w *jwriter.Writer) {
-	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk3(w, v)
-}
-
-// UnmarshalJSON supports json.Unmarshaler interface
-func (v *MsearchResponse) UnmarshalJSON(data []byte) error {
-	r := jlexer.Lexer{Data: data}
-	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk3(&r, v)
-	return r.Error()
-}
-
-// UnmarshalEasyJSON supports easyjson.Unmarshaler interface
-func (v *MsearchResponse) UnmarshalEasyJSON(l *jlexer.Lexer) {
-	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk3(l, v)
-}
-func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk4(in *jlexer.Lexer, out *MgetResponseItem) {
-	isTopLevel := in.IsStart()
-	if in.IsNull() {
-		if isTopLevel {
-			in.Consumed()
-		}
-		in.Skip()
-		return
-	}
-	in.Delim('{')
-	for !in.IsDelim('}') {
-		key := in.UnsafeFieldName(false)
-		in.WantColon()
-		if in.IsNull() {
-			in.Skip()
-			in.WantComma()
-			continue
-		}
-		switch key {
-		case "found":
-			out.Found = bool(in.Bool())
-		case "_source":
-			if data := in.Raw(); in.Ok() {
-				in.AddError((out.Source).UnmarshalJSON(data))
-			}
-		default:
-			in.SkipRecursive()
-		}
-		in.WantComma()
-	}
-	in.Delim('}')
-	if isTopLevel {
-		in.Consumed()
-	}
-}
-func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk4(out *jwriter.Writer, in MgetResponseItem) {
-	out.RawByte('{')
-	first := true
-	_ = first
-	{
-		const prefix string = ",\"found\":"
-		out.RawString(prefix[1:])
-		out.Bool(bool(in.Found))
-	}
-	{
-		const prefix string = ",\"_source\":"
-		out.RawString(prefix)
-		out.Raw((in.Source).MarshalJSON())
-	}
-	out.RawByte('}')
-}
-
-// MarshalJSON supports json.Marshaler interface
-func (v MgetResponseItem) MarshalJSON() ([]byte, error) {
-	w := jwriter.Writer{}
-	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk4(&w, v)
-	return w.Buffer.BuildBytes(), w.Error
-}
-
-// MarshalEasyJSON supports easyjson.Marshaler interface
-func (v MgetResponseItem) MarshalEasyJSON(w *jwriter.Writer) {
-	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk4(w, v)
-}
-
-// UnmarshalJSON supports json.Unmarshaler interface
-func (v *MgetResponseItem) UnmarshalJSON(data []byte) error {
-	r := jlexer.Lexer{Data: data}
-	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk4(&r, v)
-	return r.Error()
-}
-
-// UnmarshalEasyJSON supports easyjson.Unmarshaler interface
-func (v *MgetResponseItem) UnmarshalEasyJSON(l *jlexer.Lexer) {
-	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk4(l, v)
-}
 func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk5(in *jlexer.Lexer, out *MgetResponse) {
 	isTopLevel := in.IsStart()
 	if in.IsNull() {
@@ -1128,7 +1084,7 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk5(in *jle
 				in.Delim('[')
 				if out.Items == nil {
 					if !in.IsDelim(']') {
-						out.Items = make([]MgetResponseItem, 0, 2)
+						out.Items = make([]MgetResponseItem, 0, 0)
 					} else {
 						out.Items = []MgetResponseItem{}
 					}
@@ -1137,7 +1093,7 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk5(in *jle
 				}
 				for !in.IsDelim(']') {
 					var v17 MgetResponseItem
-					(v17).UnmarshalEasyJSON(in)
+					easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk6(in, &v17)
 					out.Items = append(out.Items, v17)
 					in.WantComma()
 				}
@@ -1168,7 +1124,7 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk5(out *jw
 				if v18 > 0 {
 					out.RawByte(',')
 				}
-				(v19).MarshalEasyJSON(out)
+				easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk6(out, v19)
 			}
 			out.RawByte(']')
 		}
@@ -1199,7 +1155,7 @@ func (v *MgetResponse) UnmarshalJSON(data []byte) error {
 func (v *MgetResponse) UnmarshalEasyJSON(l *jlexer.Lexer) {
 	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk5(l, v)
 }
-func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk6(in *jlexer.Lexer, out *BulkIndexerResponseItem) {
+func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk6(in *jlexer.Lexer, out *MgetResponseItem) {
 	isTopLevel := in.IsStart()
 	if in.IsNull() {
 		if isTopLevel {
@@ -1220,11 +1176,17 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk6(in *jle
 		switch key {
 		case "_id":
 			out.DocumentID = string(in.String())
-		case "status":
-			out.Status = int(in.Int())
-		case "error":
+		case "_version":
+			out.Version = int64(in.Int64())
+		case "_seq_no":
+			out.SeqNo = int64(in.Int64())
+		case "_primary_term":
+			out.PrimaryTerm = int64(in.Int64())
+		case "found":
+			out.Found = bool(in.Bool())
+		case "_source":
 			if data := in.Raw(); in.Ok() {
-				in.AddError((out.Error).UnmarshalJSON(data))
+				in.AddError((out.Source).UnmarshalJSON(data))
 			}
 		default:
 			in.SkipRecursive()
@@ -1236,7 +1198,7 @@ func easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk6(in *jle
 		in.Consumed()
 	}
 }
-func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk6(out *jwriter.Writer, in BulkIndexerResponseItem) {
+func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk6(out *jwriter.Writer, in MgetResponseItem) {
 	out.RawByte('{')
 	first := true
 	_ = first
@@ -1246,38 +1208,29 @@ func easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk6(out *jw
 		out.String(string(in.DocumentID))
 	}
 	{
-		const prefix string = ",\"status\":"
+		const prefix string = ",\"_version\":"
 		out.RawString(prefix)
-		out.Int(int(in.Status))
+		out.Int64(int64(in.Version))
 	}
-	if len(in.Error) != 0 {
-		const prefix string = ",\"error\":"
+	{
+		const prefix string = ",\"_seq_no\":"
 		out.RawString(prefix)
-		out.Raw((in.Error).MarshalJSON())
+		out.Int64(int64(in.SeqNo))
+	}
+	{
+		const prefix string = ",\"_primary_term\":"
+		out.RawString(prefix)
+		out.Int64(int64(in.PrimaryTerm))
+	}
+	{
+		const prefix string = ",\"found\":"
+		out.RawString(prefix)
+		out.Bool(bool(in.Found))
+	}
+	{
+		const prefix string = ",\"_source\":"
+		out.RawString(prefix)
+		out.Raw((in.Source).MarshalJSON())
 	}
 	out.RawByte('}')
 }
-
-// MarshalJSON supports json.Marshaler interface
-func (v BulkIndexerResponseItem) MarshalJSON() ([]byte, error) {
-	w := jwriter.Writer{}
-	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk6(&w, v)
-	return w.Buffer.BuildBytes(), w.Error
-}
-
-// MarshalEasyJSON supports easyjson.Marshaler interface
-func (v BulkIndexerResponseItem) MarshalEasyJSON(w *jwriter.Writer) {
-	easyjsonCef4e921EncodeGithubComElasticFleetServerV7InternalPkgBulk6(w, v)
-}
-
-// UnmarshalJSON supports json.Unmarshaler interface
-func (v *BulkIndexerResponseItem) UnmarshalJSON(data []byte) error {
-	r := jlexer.Lexer{Data: data}
-	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk6(&r, v)
-	return r.Error()
-}
-
-// UnmarshalEasyJSON supports easyjson.Unmarshaler interface
-func (v *BulkIndexerResponseItem) UnmarshalEasyJSON(l *jlexer.Lexer) {
-	easyjsonCef4e921DecodeGithubComElasticFleetServerV7InternalPkgBulk6(l, v)
-}
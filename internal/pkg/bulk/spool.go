@@ -0,0 +1,405 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package bulk
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+)
+
+// ErrSpooled is returned for a droppable request written to the disk spool of the bulker, it is sent to elasticsearch
+// once it is available again.
+var ErrSpooled = errors.New("bulk request spooled, elasticsearch is unavailable")
+
+const (
+	// spoolHeaderSz is the size of the header of a record: the length of the request, the CRC32 of the action, the
+	// flags and the request, the action and the flags.
+	spoolHeaderSz = 10
+	// spoolReplayBatchSz is the maximum number of records replayed in a batch.
+	spoolReplayBatchSz = 1000
+	// spoolReplayInterval is the interval of the replay attempts, the replay also starts as soon as a flush succeeds
+	// after an outage.
+	spoolReplayInterval = 30 * time.Second
+)
+
+// spoolT is the disk spool of the bulker. It holds the droppable requests that overflow the low priority lane while
+// elasticsearch is unavailable, from the first flush failing with an availability error to the next successful flush.
+// Once it holds records, the droppable requests are appended to it until it is replayed, see laneT.push.
+//
+// The requests are appended to the file as records of spoolHeaderSz bytes followed by the bulk request, the metadata
+// line with its index and id and the body, as written by the bulker. On open, the records are read from the start of
+// the file and the file is truncated at the first incomplete or corrupted record.
+// The replay is at least once: the offset of the replayed records is kept in memory and the file is truncated once
+// all of them are replayed, the records replayed before a restart are replayed again.
+type spoolT struct {
+	path     string
+	maxBytes int64
+
+	outage    atomic.Bool
+	recovered chan struct{} // signaled when a flush succeeds after an outage
+
+	mu      sync.Mutex
+	f       *os.File // nil until opened
+	size    int64
+	offset  int64 // offset of the first record not replayed
+	records int   // records not replayed
+
+	bytes        monitoring.Int
+	pending      monitoring.Int
+	spilled      monitoring.Uint
+	dropped      monitoring.Uint // requests dropped as the spool is full
+	replayed     monitoring.Uint
+	replayFailed monitoring.Uint // replayed requests rejected by elasticsearch
+}
+
+// spoolRecord is a request read from the spool.
+type spoolRecord struct {
+	action actionT
+	flags  flagsT
+	buf    []byte
+}
+
+func newSpool(cfg config.BulkSpool) *spoolT {
+	return &spoolT{
+		path:      cfg.Path,
+		maxBytes:  int64(cfg.MaxSize),
+		recovered: make(chan struct{}, 1),
+	}
+}
+
+func (s *spoolT) registerStats(reg *monitoring.Registry) {
+	reg.Add("spool_bytes", &s.bytes, monitoring.Full)
+	reg.Add("spool_pending", &s.pending, monitoring.Full)
+	reg.Add("spool_spilled", &s.spilled, monitoring.Full)
+	reg.Add("spool_dropped", &s.dropped, monitoring.Full)
+	reg.Add("spool_replayed", &s.replayed, monitoring.Full)
+	reg.Add("spool_replay_failed", &s.replayFailed, monitoring.Full)
+	monitoring.NewFunc(reg, "spool_outage", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnBool(s.outage.Load())
+	})
+}
+
+// open opens the spool file and recovers its records.
+func (s *spoolT) open(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	size, records, err := recoverSpool(f, info.Size())
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if info.Size() > size {
+		zerolog.Ctx(ctx).Warn().
+			Str("mod", kModBulk).
+			Str("path", s.path).
+			Int64("discarded", info.Size()-size).
+			Msg("Bulk spool is corrupted, discarding the bytes after the last valid record")
+		if err := f.Truncate(size); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.f = f
+	s.size = size
+	s.offset = 0
+	s.records = records
+	s.updateStats()
+	if records > 0 {
+		zerolog.Ctx(ctx).Info().Str("mod", kModBulk).Str("path", s.path).Int("records", records).Msg("Bulk spool recovered")
+	}
+	return nil
+}
+
+// recoverSpool returns the size of the valid records at the start of the end bytes of f and their count.
+func recoverSpool(f *os.File, end int64) (int64, int, error) {
+	var size int64
+	var records int
+	for {
+		_, next, err := readSpoolRecord(f, size, end)
+		if errors.Is(err, io.EOF) || errors.Is(err, errSpoolCorrupted) {
+			return size, records, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		size = next
+		records++
+	}
+}
+
+var errSpoolCorrupted = errors.New("corrupted spool record")
+
+// readSpoolRecord reads the record at off, the records end at end. It returns the offset of the next record.
+func readSpoolRecord(r io.ReaderAt, off, end int64) (spoolRecord, int64, error) {
+	if off >= end {
+		return spoolRecord{}, off, io.EOF
+	}
+	var header [spoolHeaderSz]byte
+	if off+spoolHeaderSz > end {
+		return spoolRecord{}, off, errSpoolCorrupted
+	}
+	if _, err := r.ReadAt(header[:], off); err != nil {
+		return spoolRecord{}, off, err
+	}
+	n := int64(binary.BigEndian.Uint32(header[0:4]))
+	if off+spoolHeaderSz+n > end {
+		return spoolRecord{}, off, errSpoolCorrupted
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, off+spoolHeaderSz); err != nil {
+		return spoolRecord{}, off, err
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(header[8:])
+	crc.Write(buf)
+	if crc.Sum32() != binary.BigEndian.Uint32(header[4:8]) || int(header[8]) >= len(actionStrings) {
+		return spoolRecord{}, off, errSpoolCorrupted
+	}
+	rec := spoolRecord{action: actionT(header[8]), flags: flagsT(header[9]), buf: buf}
+	return rec, off + spoolHeaderSz + n, nil
+}
+
+// close closes the spool file, the records that are not replayed are kept.
+func (s *spoolT) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// append writes blk to the spool, it returns false if the spool is not open or full.
+func (s *spoolT) append(ctx context.Context, blk *bulkT) bool {
+	buf := blk.buf.Bytes()
+	rec := make([]byte, spoolHeaderSz+len(buf))
+	binary.BigEndian.PutUint32(rec[0:4], uint32(len(buf))) //nolint:gosec // disable G115, the requests are smaller than the spool
+	rec[8] = byte(blk.action)
+	rec[9] = byte(blk.flags &^ flagDroppable)
+	copy(rec[spoolHeaderSz:], buf)
+	binary.BigEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(rec[8:]))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return false
+	}
+	// the replayed records are not counted, they are removed once all the records are replayed
+	if s.size-s.offset+int64(len(rec)) > s.maxBytes {
+		s.dropped.Inc()
+		return false
+	}
+	if err := s.write(rec); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("mod", kModBulk).Str("path", s.path).Msg("Unable to write the bulk spool")
+		// a partial record would be discarded on recovery along with the records after it
+		_ = s.f.Truncate(s.size)
+		return false
+	}
+	s.size += int64(len(rec))
+	s.records++
+	s.spilled.Inc()
+	s.updateStats()
+	return true
+}
+
+// write writes rec at the end of the spool and syncs it to disk, so the spooled requests survive a crash.
+// It must be called with the lock held.
+func (s *spoolT) write(rec []byte) error {
+	if _, err := s.f.WriteAt(rec, s.size); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// next reads the batch of records to replay, it returns the offset after the batch. A batch never holds two requests
+// for the same document, so the requests for a document are sent in the order they were spooled.
+func (s *spoolT) next() ([]spoolRecord, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil, 0, nil
+	}
+	var batch []spoolRecord
+	targets := make(map[string]struct{})
+	off := s.offset
+	for off < s.size && len(batch) < spoolReplayBatchSz {
+		rec, next, err := readSpoolRecord(s.f, off, s.size)
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to read the bulk spool at %d: %w", off, err)
+		}
+		if index, id := bulkTarget(rec.buf); id != "" {
+			target := index + "/" + id
+			if _, ok := targets[target]; ok {
+				break
+			}
+			targets[target] = struct{}{}
+		}
+		batch = append(batch, rec)
+		off = next
+	}
+	return batch, off, nil
+}
+
+// advance marks the records up to off as replayed, the file is truncated once all the records are replayed.
+func (s *spoolT) advance(off int64, cnt int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset = off
+	s.records -= cnt
+	if s.offset >= s.size && s.f != nil {
+		if err := s.f.Truncate(0); err == nil {
+			s.size = 0
+			s.offset = 0
+		}
+	}
+	s.updateStats()
+}
+
+// updateStats must be called with the lock held.
+func (s *spoolT) updateStats() {
+	s.bytes.Set(s.size - s.offset)
+	s.pending.Set(int64(s.records))
+}
+
+func (s *spoolT) pendingRecords() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records
+}
+
+// observe tracks the availability of elasticsearch from the result of a flush. A flush failing with an availability
+// error starts an outage, the next successful flush ends it and signals the replay.
+func (s *spoolT) observe(ctx context.Context, err error) {
+	switch {
+	case err == nil:
+		if s.outage.Swap(false) {
+			zerolog.Ctx(ctx).Info().Str("mod", kModBulk).Msg("Elasticsearch is available, the bulk spool is replayed")
+			select {
+			case s.recovered <- struct{}{}:
+			default:
+			}
+		}
+	case isOutage(err):
+		if !s.outage.Swap(true) {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("mod", kModBulk).Msg("Elasticsearch is unavailable, the overflow of the low priority lane is spooled")
+		}
+	}
+}
+
+// isOutage returns true if err shows that elasticsearch can not be reached or can not serve the requests.
+// Elasticsearch rejecting requests with a 429 is throttling, not an outage.
+func isOutage(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, es.ErrUnavailable) || errors.Is(err, es.ErrTimeout) || errors.Is(err, es.ErrProxy) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr)
+}
+
+// runSpool replays the spool when an outage ends, and on spoolReplayInterval for the records left by a previous
+// replay or recovered on open.
+func (b *Bulker) runSpool(ctx context.Context) {
+	ticker := time.NewTicker(spoolReplayInterval)
+	defer ticker.Stop()
+	for {
+		if !b.spool.outage.Load() && b.spool.pendingRecords() > 0 {
+			if err := b.replaySpool(ctx); err != nil && ctx.Err() == nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Str("mod", kModBulk).Int("pending", b.spool.pendingRecords()).Msg("Bulk spool replay interrupted")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.spool.recovered:
+		case <-ticker.C:
+		}
+	}
+}
+
+// replaySpool sends the spooled requests to the run loop, batch by batch, until the spool is empty.
+// The replay stops without consuming the batch if it fails with an availability error, the requests rejected by
+// elasticsearch, such as the version conflicts, are consumed.
+func (b *Bulker) replaySpool(ctx context.Context) error {
+	for {
+		batch, next, err := b.spool.next()
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+
+		ch := make(chan respT, len(batch))
+		blks := make([]bulkT, len(batch))
+		for i, rec := range batch {
+			blk := &blks[i]
+			blk.ch = ch
+			blk.idx = int32(i) //nolint:gosec // disable G115, the batches are small
+			blk.action = rec.action
+			blk.flags = rec.flags
+			blk.buf.Set(rec.buf)
+		}
+		if err := b.multiDispatch(ctx, blks); err != nil {
+			return err
+		}
+
+		var outageErr error
+		failed := 0
+		for range batch {
+			select {
+			case r := <-ch:
+				if r.err == nil {
+					continue
+				}
+				if isOutage(r.err) {
+					outageErr = r.err
+				}
+				failed++
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if outageErr != nil {
+			return outageErr
+		}
+
+		b.spool.advance(next, len(batch))
+		b.spool.replayed.Add(uint64(len(batch)))
+		b.spool.replayFailed.Add(uint64(failed)) //nolint:gosec // disable G115
+		zerolog.Ctx(ctx).Debug().
+			Str("mod", kModBulk).
+			Int("cnt", len(batch)).
+			Int("failed", failed).
+			Int("pending", b.spool.pendingRecords()).
+			Msg("Bulk spool batch replayed")
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+var errConnRefused = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+// spoolBlk returns a request as written by the bulker.
+func spoolBlk(t *testing.T, action actionT, id, body string) *bulkT {
+	var buf Buf
+	b := NewBulker(nil, nil)
+	require.NoError(t, b.writeBulkMeta(&buf, action.String(), "testidx", id, "", 0, 0))
+	require.NoError(t, b.writeBulkBody(&buf, action, []byte(body)))
+	blk := &bulkT{action: action, ch: make(chan respT, 1)}
+	blk.flags.Set(flagDroppable)
+	blk.buf.Set(buf.Bytes())
+	return blk
+}
+
+func spoolTestCfg(t *testing.T, maxSize int) config.BulkSpool {
+	return config.BulkSpool{Enabled: true, Path: filepath.Join(t.TempDir(), "spool", "bulk.spool"), MaxSize: maxSize}
+}
+
+func TestSpoolSpill(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	blks := []*bulkT{
+		spoolBlk(t, ActionCreate, "low-0", `{"hello":"world"}`),
+		spoolBlk(t, ActionCreate, "low-1", `{"hello":"world"}`),
+		spoolBlk(t, ActionCreate, "low-2", `{"hello":"world"}`),
+		spoolBlk(t, ActionCreate, "low-3", `{"hello":"world"}`),
+		spoolBlk(t, ActionCreate, "low-4", `{"hello":"world"}`),
+	}
+	size := blks[0].buf.Len()
+
+	spool := newSpool(spoolTestCfg(t, 2*(spoolHeaderSz+size)))
+	require.NoError(t, spool.open(ctx))
+	defer spool.close()
+	lane := newLane(size)
+	lane.spool = spool
+
+	// the requests dropped out of an outage are not spooled
+	lane.push(ctx, blks[0], blks[1])
+	require.ErrorIs(t, (<-blks[0].ch).err, ErrDropped)
+
+	// a flush failing to reach elasticsearch starts an outage, a 429 does not
+	spool.observe(ctx, errors.New("elastic fail 429"))
+	require.False(t, spool.outage.Load())
+	spool.observe(ctx, errConnRefused)
+	require.True(t, spool.outage.Load())
+
+	// the spool holds two requests, the next ones are dropped along with the rest of the lane
+	lane.push(ctx, blks[2], blks[3], blks[4])
+	require.ErrorIs(t, (<-blks[1].ch).err, ErrSpooled)
+	require.ErrorIs(t, (<-blks[2].ch).err, ErrSpooled)
+	require.ErrorIs(t, (<-blks[3].ch).err, ErrDropped)
+	require.ErrorIs(t, (<-blks[4].ch).err, ErrDropped)
+	require.Nil(t, lane.pop())
+
+	require.Equal(t, uint64(2), spool.spilled.Get())
+	require.Equal(t, uint64(2), spool.dropped.Get())
+	require.Equal(t, uint64(3), lane.dropped.Get())
+	require.Equal(t, int64(2), spool.pending.Get())
+	require.Equal(t, int64(2*(spoolHeaderSz+size)), spool.bytes.Get())
+
+	// the request and its metadata are spooled as written by the bulker
+	batch, _, err := spool.next()
+	require.NoError(t, err)
+	require.Len(t, batch, 2)
+	require.Equal(t, ActionCreate, batch[0].action)
+	require.False(t, batch[0].flags.Has(flagDroppable))
+	require.Equal(t, blks[1].buf.Bytes(), batch[0].buf)
+	require.Equal(t, blks[2].buf.Bytes(), batch[1].buf)
+
+	// a successful flush ends the outage and signals the replay
+	spool.observe(ctx, nil)
+	require.False(t, spool.outage.Load())
+	require.Len(t, spool.recovered, 1)
+}
+
+func TestSpoolPushOrder(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	seq := func(n string) *bulkT {
+		return spoolBlk(t, ActionUpdate, "agent-1", `{"doc":{"seq":`+n+`}}`)
+	}
+	blks := []*bulkT{seq("1"), seq("2"), seq("3"), seq("4")}
+	size := blks[0].buf.Len()
+
+	spool := newSpool(spoolTestCfg(t, 1024*1024))
+	require.NoError(t, spool.open(ctx))
+	defer spool.close()
+	lane := newLane(size)
+	lane.spool = spool
+
+	// the first request dropped during an outage is followed by the rest of the lane
+	spool.observe(ctx, errConnRefused)
+	lane.push(ctx, blks[0], blks[1])
+	require.ErrorIs(t, (<-blks[0].ch).err, ErrSpooled)
+	require.ErrorIs(t, (<-blks[1].ch).err, ErrSpooled)
+	require.Nil(t, lane.pop())
+
+	// the requests pushed once the outage ends are spooled until the spool is replayed
+	spool.observe(ctx, nil)
+	lane.push(ctx, blks[2])
+	require.ErrorIs(t, (<-blks[2].ch).err, ErrSpooled)
+	require.Nil(t, lane.pop())
+	require.Zero(t, lane.dropped.Get())
+
+	var replayed [][]byte
+	for spool.pendingRecords() > 0 {
+		batch, next, err := spool.next()
+		require.NoError(t, err)
+		for _, rec := range batch {
+			replayed = append(replayed, rec.buf)
+		}
+		spool.advance(next, len(batch))
+	}
+	require.Equal(t, [][]byte{blks[0].buf.Bytes(), blks[1].buf.Bytes(), blks[2].buf.Bytes()}, replayed)
+
+	// the replayed spool is empty, the requests go through the lane again
+	lane.push(ctx, blks[3])
+	require.Equal(t, blks[3], lane.pop())
+}
+
+func TestSpoolCapacity(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	blk := func(id string) *bulkT {
+		return spoolBlk(t, ActionCreate, id, `{"hello":"world"}`)
+	}
+	size := int64(spoolHeaderSz + blk("low-0").buf.Len())
+
+	spool := newSpool(spoolTestCfg(t, int(2*size)))
+	require.NoError(t, spool.open(ctx))
+	defer spool.close()
+	require.True(t, spool.append(ctx, blk("low-0")))
+	require.True(t, spool.append(ctx, blk("low-1")))
+	require.False(t, spool.append(ctx, blk("low-2")))
+
+	// the replayed records free their space in the spool
+	spool.advance(size, 1)
+	require.True(t, spool.append(ctx, blk("low-2")))
+	require.False(t, spool.append(ctx, blk("low-3")))
+	require.Equal(t, int64(2*size), spool.bytes.Get())
+	require.Equal(t, uint64(2), spool.dropped.Get())
+}
+
+func TestSpoolRecovery(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	cfg := spoolTestCfg(t, 1024*1024)
+
+	spool := newSpool(cfg)
+	require.NoError(t, spool.open(ctx))
+	for _, id := range []string{"agent-1", "agent-2", "agent-3"} {
+		require.True(t, spool.append(ctx, spoolBlk(t, ActionUpdate, id, `{"doc":{"last_checkin_status":"online"}}`)))
+	}
+	require.NoError(t, spool.close())
+	info, err := os.Stat(cfg.Path)
+	require.NoError(t, err)
+	valid := info.Size()
+
+	// the process stopped while a record was written
+	f, err := os.OpenFile(cfg.Path, os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 1, 0, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	spool = newSpool(cfg)
+	require.NoError(t, spool.open(ctx))
+	require.Equal(t, int64(3), spool.pending.Get())
+	info, err = os.Stat(cfg.Path)
+	require.NoError(t, err)
+	require.Equal(t, valid, info.Size(), "expected the incomplete record to be truncated")
+	require.True(t, spool.append(ctx, spoolBlk(t, ActionUpdate, "agent-4", `{"doc":{}}`)))
+	require.NoError(t, spool.close())
+
+	// a corrupted record discards the records after it
+	p, err := os.ReadFile(cfg.Path)
+	require.NoError(t, err)
+	second := bytes.Index(p, []byte("agent-2"))
+	p[second] = 'A'
+	require.NoError(t, os.WriteFile(cfg.Path, p, 0o600))
+
+	spool = newSpool(cfg)
+	require.NoError(t, spool.open(ctx))
+	defer spool.close()
+	batch, _, err := spool.next()
+	require.NoError(t, err)
+	require.Len(t, batch, 1)
+	index, id := bulkTarget(batch[0].buf)
+	require.Equal(t, "testidx", index)
+	require.Equal(t, "agent-1", id)
+}
+
+// outageBulkTransport fails the requests with a connection error while down, the requests are recorded by flush.
+type outageBulkTransport struct {
+	mockBulkTransport
+	down atomic.Bool
+
+	mu      sync.Mutex
+	flushes []string
+}
+
+func (m *outageBulkTransport) Perform(req *http.Request) (*http.Response, error) {
+	if m.down.Load() {
+		return nil, errConnRefused
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.flushes = append(m.flushes, string(body))
+	m.mu.Unlock()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return m.mockBulkTransport.Perform(req)
+}
+
+func (m *outageBulkTransport) sent() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.flushes)
+}
+
+func TestBulkerSpoolReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(testlog.SetLogger(t).WithContext(context.Background()))
+	defer cancel()
+	cfg := spoolTestCfg(t, 1024*1024)
+
+	// the spool left by a previous process, with two updates of agent-1
+	spool := newSpool(cfg)
+	require.NoError(t, spool.open(ctx))
+	require.True(t, spool.append(ctx, spoolBlk(t, ActionUpdate, "agent-1", `{"doc":{"seq":1}}`)))
+	require.True(t, spool.append(ctx, spoolBlk(t, ActionCreate, "result-1", `{"seq":2}`)))
+	require.True(t, spool.append(ctx, spoolBlk(t, ActionUpdate, "agent-1", `{"doc":{"seq":3}}`)))
+	require.NoError(t, spool.close())
+
+	reg := monitoring.NewRegistry()
+	transport := &outageBulkTransport{}
+	transport.down.Store(true)
+	bulker := NewBulker(transport, nil, WithStats(reg), WithLowPriorityMaxSize(1024), WithSpool(cfg), WithFlushInterval(10*time.Millisecond))
+	stats := func() monitoring.FlatSnapshot {
+		return monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+
+	// the replay of the recovered records fails while elasticsearch is down, they are kept
+	require.Eventually(t, func() bool {
+		return stats().Bools["spool_outage"]
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int64(3), stats().Ints["spool_pending"])
+	require.Empty(t, transport.sent())
+
+	// the first successful flush ends the outage and replays the records
+	transport.down.Store(false)
+	_, err := bulker.Create(ctx, "testidx", "critical", []byte(`{"hello":"world"}`))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return stats().Ints["spool_pending"] == 0
+	}, time.Second, time.Millisecond)
+
+	// the updates of agent-1 are sent in two flushes, in the order they were spooled
+	flushes := transport.sent()
+	require.Len(t, flushes, 3)
+	require.Contains(t, flushes[1], `{"doc":{"seq":1}}`)
+	require.Contains(t, flushes[1], `{"seq":2}`)
+	require.NotContains(t, flushes[1], `{"doc":{"seq":3}}`)
+	require.Contains(t, flushes[2], `{"update":{"_id":"agent-1","_index":"testidx"}}`+"\n"+`{"doc":{"seq":3}}`)
+
+	snapshot := stats()
+	require.Equal(t, int64(3), snapshot.Ints["spool_replayed"])
+	require.Zero(t, snapshot.Ints["spool_replay_failed"])
+	require.Zero(t, snapshot.Ints["spool_bytes"])
+	info, err := os.Stat(cfg.Path)
+	require.NoError(t, err)
+	require.Zero(t, info.Size(), "expected the replayed spool to be truncated")
+
+	cancel()
+	wg.Wait()
+}
+
+func TestBulkerBreakerStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reg := monitoring.NewRegistry()
+	transport := &outageBulkTransport{}
+	transport.down.Store(true)
+	bulker := NewBulker(transport, nil, WithStats(reg), WithFlushThresholdCount(1))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+	stats := func() monitoring.FlatSnapshot {
+		return monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	}
+
+	// the breaker opens on the first failed flush and stays open
+	for i := 0; i < 2; i++ {
+		_, err := bulker.Create(ctx, "testidx", "", []byte(`{"hello":"world"}`))
+		require.Error(t, err)
+		require.True(t, stats().Bools["breaker_open"])
+		require.Equal(t, int64(1), stats().Ints["breaker_trips"])
+	}
+
+	// a successful flush closes it
+	transport.down.Store(false)
+	_, err := bulker.Create(ctx, "testidx", "", []byte(`{"hello":"world"}`))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return !stats().Bools["breaker_open"] }, time.Second, time.Millisecond)
+	require.Equal(t, int64(1), stats().Ints["breaker_trips"])
+
+	cancel()
+	wg.Wait()
+}
@@ -9,11 +9,14 @@ package cache
 import (
 	"fmt"
 	"math/rand"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
 
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/file"
@@ -22,6 +25,7 @@ import (
 
 type Cache interface {
 	Reconfigure(config.Cache) error
+	Register(name string, r Reconfigurable) error
 
 	SetAction(model.Action)
 	GetAction(id string) (model.Action, bool)
@@ -32,16 +36,60 @@ type Cache interface {
 	SetEnrollmentAPIKey(id string, key model.EnrollmentAPIKey, cost int64)
 	GetEnrollmentAPIKey(id string) (model.EnrollmentAPIKey, bool)
 
-	SetArtifact(artifact model.Artifact)
-	GetArtifact(ident, sha2 string) (model.Artifact, bool)
+	SetArtifact(artifact Artifact)
+	GetArtifact(ident, sha2 string) (Artifact, bool)
 
 	SetUpload(id string, info file.Info)
 	GetUpload(id string) (file.Info, bool)
 
 	SetPGPKey(id string, p []byte)
 	GetPGPKey(id string) ([]byte, bool)
+
+	SetEnrollReplay(key string, replay EnrollReplay)
+	GetEnrollReplay(key string) (EnrollReplay, bool)
+
+	SetNotFound(kind DocKind, id string)
+	NotFound(kind DocKind, id string) bool
+	InvalidateNotFound(kind DocKind, id string)
+
+	SetAgentVersion(v AgentVersion)
+	GetAgentVersion(agentID string) (AgentVersion, bool)
+	GetAgentVersionByAPIKey(apiKeyID string) (AgentVersion, bool)
+}
+
+// DocKind is the kind of the documents whose lookups are cached when they are not found.
+type DocKind string
+
+const (
+	// KindAction is an action looked up by action id.
+	KindAction DocKind = "action"
+	// KindAgent is an agent looked up by agent id.
+	KindAgent DocKind = "agent"
+	// KindAgentAPIKey is an agent looked up by the id of its access API key.
+	KindAgentAPIKey DocKind = "agent_api_key"
+)
+
+// EnrollReplay is the response of an enroll request, replayed for the retries with the same idempotency key.
+type EnrollReplay struct {
+	RequestHash string
+	Response    []byte
+}
+
+// Artifact is an artifact with its body decoded as it is served, and the entity tag of the body.
+type Artifact struct {
+	model.Artifact
+	ETag string
 }
 
+// Reconfigurable is a cache held by another subsystem that takes the settings of its name from the cache configuration.
+type Reconfigurable interface {
+	ReconfigureCache(config.CacheSettings)
+}
+
+// instanceNames are the named caches that get their own instance when their size is overridden,
+// they share the default cache otherwise.
+var instanceNames = []string{config.CacheAuth, config.CacheEnrollKey, config.CacheNotFound}
+
 type APIKey = apikey.APIKey
 type SecurityInfo = apikey.SecurityInfo
 
@@ -53,11 +101,30 @@ func WithLog(log *zerolog.Logger) Option {
 	}
 }
 
+// WithStats registers the hit and miss counts of the cache, and the effective settings of the named caches in reg.
+// The lookups served by the not found entries are counted as negative hits.
+func WithStats(reg *monitoring.Registry) Option {
+	return func(c *CacheT) {
+		reg.Add("hits", &c.hits, monitoring.Full)
+		reg.Add("misses", &c.misses, monitoring.Full)
+		reg.Add("negative_hits", &c.negativeHits, monitoring.Full)
+		monitoring.NewFunc(reg, "settings", c.reportSettings)
+	}
+}
+
 type CacheT struct {
 	cache Cacher
 	log   *zerolog.Logger
 	cfg   config.Cache
 	mut   sync.RWMutex
+
+	instances  map[string]Cacher         // named caches with their own instance
+	registered map[string]Reconfigurable // named caches of the other subsystems
+	versions   *agentVersions
+
+	hits         monitoring.Int
+	misses       monitoring.Int
+	negativeHits monitoring.Int
 }
 
 type actionCache struct {
@@ -73,38 +140,129 @@ func New(cfg config.Cache, opts ...Option) (*CacheT, error) {
 	}
 
 	log := zerolog.Nop()
-	c := CacheT{
-		cache: cache,
-		cfg:   cfg,
-		log:   &log,
+	c := &CacheT{
+		cache:      cache,
+		log:        &log,
+		instances:  make(map[string]Cacher),
+		registered: make(map[string]Reconfigurable),
+		versions:   newAgentVersions(),
 	}
+	if err := c.setInstances(cfg); err != nil {
+		return nil, err
+	}
+	c.cfg = cfg
 
 	for _, opt := range opts {
-		opt(&c)
+		opt(c)
 	}
 
-	return &c, nil
+	return c, nil
 }
 
-// Reconfigure will drop cache
+// get returns the value of key from cache and counts the lookup.
+func (c *CacheT) get(cache Cacher, key string) (interface{}, bool) {
+	v, ok := cache.Get(key)
+	if ok {
+		c.hits.Inc()
+	} else {
+		c.misses.Inc()
+	}
+	return v, ok
+}
+
+// instance returns the instance of the named cache, or the default cache if it has none.
+func (c *CacheT) instance(name string) Cacher {
+	if cache, ok := c.instances[name]; ok {
+		return cache
+	}
+	return c.cache
+}
+
+// setInstances creates the instances of the named caches with a size, and drops the instances whose size changed.
+// It is called before the cache settings are set to cfg.
+func (c *CacheT) setInstances(cfg config.Cache) error {
+	for _, name := range instanceNames {
+		size := cfg.Settings(name).Size
+		cur, ok := c.instances[name]
+		if ok && size == c.cfg.Settings(name).Size && cfg.NumCounters == c.cfg.NumCounters {
+			continue
+		}
+		if ok {
+			cur.Close()
+			delete(c.instances, name)
+		}
+		if size == 0 {
+			continue
+		}
+		cache, err := newCache(config.Cache{NumCounters: cfg.NumCounters, MaxCost: size})
+		if err != nil {
+			return fmt.Errorf("cache %s: %w", name, err)
+		}
+		c.instances[name] = cache
+	}
+	return nil
+}
+
+// Reconfigure applies the cache settings. The default cache and the instances of the named caches are dropped
+// when their size changes, the other changes apply to the entries set from now on.
 func (c *CacheT) Reconfigure(cfg config.Cache) error {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
-	cache, err := newCache(cfg)
-	if err != nil {
+	if cfg.NumCounters != c.cfg.NumCounters || cfg.MaxCost != c.cfg.MaxCost {
+		cache, err := newCache(cfg)
+		if err != nil {
+			return err
+		}
+
+		// Close down previous cache
+		c.cache.Close()
+		c.cache = cache
+	}
+	if err := c.setInstances(cfg); err != nil {
 		return err
 	}
+	c.cfg = cfg
 
-	// Close down previous cache
-	c.cache.Close()
+	for name, r := range c.registered {
+		r.ReconfigureCache(cfg.Settings(name))
+	}
+	return nil
+}
 
-	// And assign new one
-	c.cfg = cfg
-	c.cache = cache
+// Register sets r as the named cache, r replaces the cache previously registered with the name.
+// r is configured with the settings of the name now, and on each reconfiguration.
+func (c *CacheT) Register(name string, r Reconfigurable) error {
+	if !slices.Contains(config.CacheNames, name) {
+		return fmt.Errorf("unknown cache %q", name)
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.registered[name] = r
+	r.ReconfigureCache(c.cfg.Settings(name))
 	return nil
 }
 
+// reportSettings reports the effective ttl, in milliseconds, and size of the named caches.
+func (c *CacheT) reportSettings(_ monitoring.Mode, v monitoring.Visitor) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	v.OnRegistryStart()
+	defer v.OnRegistryFinished()
+
+	for _, name := range config.CacheNames {
+		s := c.cfg.Settings(name)
+		monitoring.ReportNamespace(v, name, func() {
+			monitoring.ReportInt(v, "ttl_ms", s.TTL.Milliseconds())
+			monitoring.ReportInt(v, "size", s.Size)
+			_, own := c.instances[name]
+			monitoring.ReportBool(v, "own_instance", own)
+		})
+	}
+}
+
 // SetAction sets an action in the cache.
 //
 // This will only cache the action ID and action Type. So `GetAction` will only
@@ -137,7 +295,7 @@ func (c *CacheT) GetAction(id string) (model.Action, bool) {
 	defer c.mut.RUnlock()
 
 	scopedKey := "action:" + id
-	if v, ok := c.cache.Get(scopedKey); ok {
+	if v, ok := c.get(c.cache, scopedKey); ok {
 		c.log.Trace().Str("id", id).Msg("Action cache HIT")
 		action, ok := v.(actionCache)
 		if !ok {
@@ -172,7 +330,7 @@ func (c *CacheT) SetAPIKey(key APIKey, enabled bool) {
 	// across time, which is helpful if a bunch of agents came on at the same time,
 	// say during a network restoration. With some jitter, we avoid having to
 	// revalidate the API Keys all at the same time, which we know causes load on Elastic.
-	ttl := c.cfg.APIKeyTTL
+	ttl := c.cfg.Settings(config.CacheAuth).TTL
 	if c.cfg.APIKeyJitter != 0 {
 		jitter := time.Duration(rand.Int63n(int64(c.cfg.APIKeyJitter))) //nolint:gosec // used to generate a jitter offset value
 		if jitter < ttl {
@@ -181,7 +339,7 @@ func (c *CacheT) SetAPIKey(key APIKey, enabled bool) {
 	}
 
 	cost := len(scopedKey) + len(val)
-	ok := c.cache.SetWithTTL(scopedKey, val, int64(cost), ttl)
+	ok := c.instance(config.CacheAuth).SetWithTTL(scopedKey, val, int64(cost), ttl)
 	c.log.Trace().
 		Bool("ok", ok).
 		Bool("enabled", enabled).
@@ -197,7 +355,7 @@ func (c *CacheT) ValidAPIKey(key APIKey) bool {
 	defer c.mut.RUnlock()
 
 	scopedKey := "api:" + key.ID
-	v, ok := c.cache.Get(scopedKey)
+	v, ok := c.get(c.instance(config.CacheAuth), scopedKey)
 	if ok {
 		switch v {
 		case "":
@@ -220,7 +378,7 @@ func (c *CacheT) GetEnrollmentAPIKey(id string) (model.EnrollmentAPIKey, bool) {
 	defer c.mut.RUnlock()
 
 	scopedKey := "record:" + id
-	if v, ok := c.cache.Get(scopedKey); ok {
+	if v, ok := c.get(c.instance(config.CacheEnrollKey), scopedKey); ok {
 		c.log.Trace().Str("id", id).Msg("Enrollment cache HIT")
 		key, ok := v.(model.EnrollmentAPIKey)
 
@@ -241,8 +399,8 @@ func (c *CacheT) SetEnrollmentAPIKey(id string, key model.EnrollmentAPIKey, cost
 	defer c.mut.RUnlock()
 
 	scopedKey := "record:" + id
-	ttl := c.cfg.EnrollKeyTTL
-	ok := c.cache.SetWithTTL(scopedKey, key, cost, ttl)
+	ttl := c.cfg.Settings(config.CacheEnrollKey).TTL
+	ok := c.instance(config.CacheEnrollKey).SetWithTTL(scopedKey, key, cost, ttl)
 	c.log.Trace().
 		Bool("ok", ok).
 		Str("id", id).
@@ -255,29 +413,29 @@ func makeArtifactKey(ident, sha2 string) string {
 	return fmt.Sprintf("artifact:%s:%s", ident, sha2)
 }
 
-func (c *CacheT) GetArtifact(ident, sha2 string) (model.Artifact, bool) {
+func (c *CacheT) GetArtifact(ident, sha2 string) (Artifact, bool) {
 	c.mut.RLock()
 	defer c.mut.RUnlock()
 
 	scopedKey := makeArtifactKey(ident, sha2)
-	if v, ok := c.cache.Get(scopedKey); ok {
+	if v, ok := c.get(c.cache, scopedKey); ok {
 		c.log.Trace().Str("key", scopedKey).Msg("Artifact cache HIT")
-		key, ok := v.(model.Artifact)
+		key, ok := v.(Artifact)
 
 		if !ok {
 			c.log.Error().Str("sha2", sha2).Msg("Artifact cache cast fail")
-			return model.Artifact{}, false
+			return Artifact{}, false
 		}
 		return key, ok
 	}
 
 	c.log.Trace().Str("key", scopedKey).Msg("Artifact cache MISS")
-	return model.Artifact{}, false
+	return Artifact{}, false
 }
 
 // SetArtifact will set the cached artifact
 // TODO: strip body and spool to on disk cache if larger than a size threshold
-func (c *CacheT) SetArtifact(artifact model.Artifact) {
+func (c *CacheT) SetArtifact(artifact Artifact) {
 	c.mut.RLock()
 	defer c.mut.RUnlock()
 
@@ -315,7 +473,7 @@ func (c *CacheT) GetUpload(id string) (file.Info, bool) { //nolint:dupl // a lit
 	defer c.mut.RUnlock()
 
 	scopedKey := "upload:" + id
-	if v, ok := c.cache.Get(scopedKey); ok {
+	if v, ok := c.get(c.cache, scopedKey); ok {
 		c.log.Trace().Str("id", id).Msg("upload info cache HIT")
 		key, ok := v.(file.Info)
 		if !ok {
@@ -349,7 +507,7 @@ func (c *CacheT) GetPGPKey(id string) ([]byte, bool) {
 	defer c.mut.RUnlock()
 
 	scopedKey := "pgp:" + id
-	if v, ok := c.cache.Get(scopedKey); ok {
+	if v, ok := c.get(c.cache, scopedKey); ok {
 		c.log.Trace().Str("id", id).Msg("PGP key cache HIT")
 		key, ok := v.([]byte)
 		if !ok {
@@ -360,3 +518,102 @@ func (c *CacheT) GetPGPKey(id string) ([]byte, bool) {
 	}
 	return nil, false
 }
+
+// SetEnrollReplay caches the response of an enroll request by the hash of its idempotency key.
+func (c *CacheT) SetEnrollReplay(key string, replay EnrollReplay) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	scopedKey := "enroll:" + key
+	ttl := c.cfg.EnrollIdempotencyTTL
+	cost := int64(len(scopedKey) + len(replay.RequestHash) + len(replay.Response))
+	ok := c.cache.SetWithTTL(scopedKey, replay, cost, ttl)
+	c.log.Trace().
+		Bool("ok", ok).
+		Int64("cost", cost).
+		Dur("ttl", ttl).
+		Msg("Enroll replay cache SET")
+}
+
+// GetEnrollReplay returns the response of the enroll request with the hash of the idempotency key.
+func (c *CacheT) GetEnrollReplay(key string) (EnrollReplay, bool) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	scopedKey := "enroll:" + key
+	if v, ok := c.get(c.cache, scopedKey); ok {
+		c.log.Trace().Msg("Enroll replay cache HIT")
+		replay, ok := v.(EnrollReplay)
+		if !ok {
+			c.log.Error().Msg("Enroll replay cache cast fail")
+			return EnrollReplay{}, false
+		}
+		return replay, ok
+	}
+
+	c.log.Trace().Msg("Enroll replay cache MISS")
+	return EnrollReplay{}, false
+}
+
+func makeNotFoundKey(kind DocKind, id string) string {
+	return fmt.Sprintf("notfound:%s:%s", kind, id)
+}
+
+// SetNotFound caches that the lookup of the document of kind with id found nothing, for the ttl of the not_found cache.
+func (c *CacheT) SetNotFound(kind DocKind, id string) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	scopedKey := makeNotFoundKey(kind, id)
+	ttl := c.cfg.Settings(config.CacheNotFound).TTL
+	ok := c.instance(config.CacheNotFound).SetWithTTL(scopedKey, struct{}{}, int64(len(scopedKey)), ttl)
+	c.log.Trace().
+		Bool("ok", ok).
+		Str("key", scopedKey).
+		Dur("ttl", ttl).
+		Msg("Not found cache SET")
+}
+
+// NotFound returns true if the lookup of the document of kind with id is cached as not found.
+// It is called once the document is not found in its own cache, a false result is not counted as a miss.
+func (c *CacheT) NotFound(kind DocKind, id string) bool {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	scopedKey := makeNotFoundKey(kind, id)
+	if _, ok := c.instance(config.CacheNotFound).Get(scopedKey); ok {
+		c.negativeHits.Inc()
+		c.log.Trace().Str("key", scopedKey).Msg("Not found cache HIT")
+		return true
+	}
+	return false
+}
+
+// InvalidateNotFound removes the not found entry of the document of kind with id, it is called when the document is created.
+func (c *CacheT) InvalidateNotFound(kind DocKind, id string) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	c.instance(config.CacheNotFound).Del(makeNotFoundKey(kind, id))
+}
+
+// SetAgentVersion records the version of the agent document written by the instance, for the readers of the instance
+// that would not see the write with a search until the index is refreshed.
+func (c *CacheT) SetAgentVersion(v AgentVersion) {
+	c.versions.set(v)
+	c.log.Trace().
+		Str("agent_id", v.AgentID).
+		Int64("seq_no", v.SeqNo).
+		Msg("Agent version SET")
+}
+
+// GetAgentVersion returns the version of the agent document with agentID written by the instance during the last minute.
+func (c *CacheT) GetAgentVersion(agentID string) (AgentVersion, bool) {
+	return c.versions.get(agentID)
+}
+
+// GetAgentVersionByAPIKey returns the version of the agent document with the access API key apiKeyID written by the
+// instance during the last minute.
+func (c *CacheT) GetAgentVersionByAPIKey(apiKeyID string) (AgentVersion, bool) {
+	return c.versions.getByAPIKey(apiKeyID)
+}
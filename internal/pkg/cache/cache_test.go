@@ -0,0 +1,151 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+type registeredCache struct {
+	settings []config.CacheSettings
+}
+
+func (r *registeredCache) ReconfigureCache(settings config.CacheSettings) {
+	r.settings = append(r.settings, settings)
+}
+
+func TestReconfigureOverrides(t *testing.T) {
+	cfg := config.Cache{NumCounters: 100, MaxCost: 100000, APIKeyTTL: time.Hour, EnrollKeyTTL: time.Hour}
+	reg := monitoring.NewRegistry()
+	c, err := New(cfg, WithStats(reg))
+	require.NoError(t, err)
+	r := &registeredCache{}
+	require.NoError(t, c.Register(config.CacheStatusESHealth, r))
+	require.Error(t, c.Register("unknown", r))
+
+	key := APIKey{ID: "id", Key: "key"}
+	c.SetAPIKey(key, true)
+	c.SetEnrollmentAPIKey("enroll", model.EnrollmentAPIKey{APIKeyID: "enroll"}, 10)
+	require.Eventually(t, func() bool {
+		_, ok := c.GetEnrollmentAPIKey("enroll")
+		return ok && c.ValidAPIKey(key)
+	}, time.Second, time.Millisecond)
+
+	// the ttl of the enrollment keys is reloaded, the other caches keep their settings and entries
+	next := cfg
+	next.Overrides = map[string]config.CacheOverride{
+		config.CacheEnrollKey:      {TTL: 10 * time.Millisecond},
+		config.CacheStatusESHealth: {TTL: time.Second},
+	}
+	require.NoError(t, c.Reconfigure(next))
+	require.True(t, c.ValidAPIKey(key))
+	_, ok := c.GetEnrollmentAPIKey("enroll")
+	require.True(t, ok)
+
+	c.SetEnrollmentAPIKey("enroll", model.EnrollmentAPIKey{APIKeyID: "enroll"}, 10)
+	require.Eventually(t, func() bool {
+		_, ok := c.GetEnrollmentAPIKey("enroll")
+		return !ok
+	}, time.Second, time.Millisecond)
+	require.True(t, c.ValidAPIKey(key))
+	require.Equal(t, []config.CacheSettings{{}, {TTL: time.Second}}, r.settings)
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	require.EqualValues(t, 10, snapshot.Ints["settings.enroll_key.ttl_ms"])
+	require.EqualValues(t, time.Hour.Milliseconds(), snapshot.Ints["settings.auth.ttl_ms"])
+	require.EqualValues(t, 1000, snapshot.Ints["settings.status_es_health.ttl_ms"])
+	require.False(t, snapshot.Bools["settings.enroll_key.own_instance"])
+
+	// a size override gives the cache its own instance, its entries are dropped
+	next.Overrides[config.CacheAuth] = config.CacheOverride{Size: 10000}
+	require.NoError(t, c.Reconfigure(next))
+	require.False(t, c.ValidAPIKey(key))
+	c.SetAPIKey(key, true)
+	require.Eventually(t, func() bool { return c.ValidAPIKey(key) }, time.Second, time.Millisecond)
+	snapshot = monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	require.True(t, snapshot.Bools["settings.auth.own_instance"])
+	require.EqualValues(t, 10000, snapshot.Ints["settings.auth.size"])
+}
+
+func TestNotFound(t *testing.T) {
+	reg := monitoring.NewRegistry()
+	c, err := New(config.Cache{NumCounters: 100, MaxCost: 100000}, WithStats(reg))
+	require.NoError(t, err)
+
+	require.False(t, c.NotFound(KindAction, "action-1"))
+	c.SetNotFound(KindAction, "action-1")
+	require.Eventually(t, func() bool { return c.NotFound(KindAction, "action-1") }, time.Second, time.Millisecond)
+	require.False(t, c.NotFound(KindAgent, "action-1"), "the entries are scoped by kind")
+
+	// the negative hits are counted apart from the hits and misses
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	require.EqualValues(t, 1, snapshot.Ints["negative_hits"])
+	require.Zero(t, snapshot.Ints["hits"])
+	require.Zero(t, snapshot.Ints["misses"])
+
+	c.InvalidateNotFound(KindAction, "action-1")
+	require.False(t, c.NotFound(KindAction, "action-1"))
+
+	// the entries expire after the ttl of the not_found cache
+	require.NoError(t, c.Reconfigure(config.Cache{NumCounters: 100, MaxCost: 100000, Overrides: map[string]config.CacheOverride{
+		config.CacheNotFound: {TTL: 10 * time.Millisecond},
+	}}))
+	c.SetNotFound(KindAgent, "agent-1")
+	require.Eventually(t, func() bool { return !c.NotFound(KindAgent, "agent-1") }, time.Second, time.Millisecond)
+}
+
+func TestAgentVersion(t *testing.T) {
+	c, err := New(config.Cache{NumCounters: 100, MaxCost: 100000})
+	require.NoError(t, err)
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	c.versions.now = func() time.Time { return now }
+
+	_, ok := c.GetAgentVersion("agent-1")
+	require.False(t, ok)
+
+	// the version is seen at once by the next read
+	v := AgentVersion{AgentID: "agent-1", AccessAPIKeyID: "key-1", SeqNo: 7, PrimaryTerm: 1}
+	c.SetAgentVersion(v)
+	got, ok := c.GetAgentVersion("agent-1")
+	require.True(t, ok)
+	require.Equal(t, v, got)
+	got, ok = c.GetAgentVersionByAPIKey("key-1")
+	require.True(t, ok)
+	require.Equal(t, v, got)
+	require.True(t, got.Before(6, 1))
+	require.False(t, got.Before(7, 1))
+	require.False(t, got.Before(3, 2), "a document of another primary term is not compared")
+
+	// a new access API key replaces the previous one
+	now = now.Add(30 * time.Second)
+	v = AgentVersion{AgentID: "agent-1", AccessAPIKeyID: "key-2", SeqNo: 9, PrimaryTerm: 1}
+	c.SetAgentVersion(v)
+	_, ok = c.GetAgentVersionByAPIKey("key-1")
+	require.False(t, ok)
+
+	// the versions expire after a minute from their last set
+	now = now.Add(45 * time.Second)
+	c.SetAgentVersion(AgentVersion{AgentID: "agent-2", SeqNo: 1, PrimaryTerm: 1})
+	got, ok = c.GetAgentVersionByAPIKey("key-2")
+	require.True(t, ok)
+	require.Equal(t, int64(9), got.SeqNo)
+	now = now.Add(15 * time.Second)
+	_, ok = c.GetAgentVersion("agent-1")
+	require.False(t, ok)
+	c.SetAgentVersion(AgentVersion{AgentID: "agent-3", SeqNo: 1, PrimaryTerm: 1})
+	require.NotContains(t, c.versions.byID, "agent-1")
+	require.NotContains(t, c.versions.byAPIKey, "key-2")
+	require.Contains(t, c.versions.byID, "agent-2")
+}
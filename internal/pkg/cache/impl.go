@@ -12,5 +12,6 @@ type Cacher interface {
 	Get(key interface{}) (interface{}, bool)
 	Set(key, value interface{}, cost int64) bool
 	SetWithTTL(key, value interface{}, cost int64, ttl time.Duration) bool
+	Del(key interface{})
 	Close()
 }
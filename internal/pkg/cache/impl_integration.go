@@ -30,5 +30,8 @@ func (c *NoCache) SetWithTTL(_, _ interface{}, _ int64, _ time.Duration) bool {
 	return true
 }
 
+func (c *NoCache) Del(_ interface{}) {
+}
+
 func (c *NoCache) Close() {
 }
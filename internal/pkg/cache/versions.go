@@ -0,0 +1,107 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// agentVersionTTL is how long the version of an agent document written by the instance is kept. It is well above the
+// refresh interval of the .fleet-agents index, the searches see the write once the index is refreshed.
+const agentVersionTTL = time.Minute
+
+// AgentVersion is the version of an agent document written by the instance without refreshing the index.
+// The readers of the instance read the document by id, a realtime read that sees the write, instead of searching it.
+type AgentVersion struct {
+	AgentID        string
+	AccessAPIKeyID string
+	SeqNo          int64
+	PrimaryTerm    int64
+}
+
+// Before returns true if the document read with seqNo and primaryTerm is older than the version v.
+func (v AgentVersion) Before(seqNo, primaryTerm int64) bool {
+	return primaryTerm == v.PrimaryTerm && seqNo < v.SeqNo
+}
+
+// agentVersions holds the versions of the agent documents written by the instance, by agent id and by access API key id.
+// It is not held by ristretto: the sets of ristretto are asynchronous and may be rejected, a version that is set must
+// be seen by the next read.
+type agentVersions struct {
+	mx       sync.Mutex
+	now      func() time.Time
+	byID     map[string]agentVersionEntry
+	byAPIKey map[string]string
+	// expiries are the expiry times of the versions in the order they were set, they all have the same ttl.
+	expiries []agentVersionExpiry
+}
+
+type agentVersionEntry struct {
+	AgentVersion
+	expires time.Time
+}
+
+type agentVersionExpiry struct {
+	agentID string
+	expires time.Time
+}
+
+func newAgentVersions() *agentVersions {
+	return &agentVersions{
+		now:      time.Now,
+		byID:     make(map[string]agentVersionEntry),
+		byAPIKey: make(map[string]string),
+	}
+}
+
+func (a *agentVersions) set(v AgentVersion) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	now := a.now()
+	a.expire(now)
+	if prev, ok := a.byID[v.AgentID]; ok && prev.AccessAPIKeyID != v.AccessAPIKeyID {
+		delete(a.byAPIKey, prev.AccessAPIKeyID)
+	}
+	expires := now.Add(agentVersionTTL)
+	a.byID[v.AgentID] = agentVersionEntry{AgentVersion: v, expires: expires}
+	if v.AccessAPIKeyID != "" {
+		a.byAPIKey[v.AccessAPIKeyID] = v.AgentID
+	}
+	a.expiries = append(a.expiries, agentVersionExpiry{agentID: v.AgentID, expires: expires})
+}
+
+func (a *agentVersions) get(agentID string) (AgentVersion, bool) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	entry, ok := a.byID[agentID]
+	if !ok || !a.now().Before(entry.expires) {
+		return AgentVersion{}, false
+	}
+	return entry.AgentVersion, true
+}
+
+func (a *agentVersions) getByAPIKey(apiKeyID string) (AgentVersion, bool) {
+	a.mx.Lock()
+	agentID, ok := a.byAPIKey[apiKeyID]
+	a.mx.Unlock()
+	if !ok {
+		return AgentVersion{}, false
+	}
+	return a.get(agentID)
+}
+
+// expire removes the versions that expired at now, an entry set again since is kept until its new expiry.
+func (a *agentVersions) expire(now time.Time) {
+	i := 0
+	for ; i < len(a.expiries) && !now.Before(a.expiries[i].expires); i++ {
+		e := a.expiries[i]
+		if entry, ok := a.byID[e.agentID]; ok && entry.expires.Equal(e.expires) {
+			delete(a.byID, e.agentID)
+			delete(a.byAPIKey, entry.AccessAPIKeyID)
+		}
+	}
+	a.expiries = a.expiries[i:]
+}
@@ -15,7 +15,12 @@ import (
 	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/esclock"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
 
 	"github.com/rs/zerolog"
@@ -24,13 +29,20 @@ import (
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/scriptlanguage"
 )
 
-const defaultFlushInterval = 10 * time.Second
+const (
+	defaultFlushInterval = 10 * time.Second
+
+	// stateTTL is how long the states set with SetState are kept, it is longer than a checkin long poll
+	// so the checkins of the requests that started before the state changed are checked against it.
+	stateTTL = 10 * time.Minute
+)
 
 //go:embed deleteAuditFieldsOnCheckin.painless
 var deleteAuditAttributesScript string
 
 type optionsT struct {
 	flushInterval time.Duration
+	damping       config.StatusDamping
 }
 
 type Opt func(*optionsT)
@@ -41,12 +53,65 @@ func WithFlushInterval(d time.Duration) Opt {
 	}
 }
 
+// WithStatusDamping damps the status changes of the agents that flap between statuses, see CheckIn.
+func WithStatusDamping(cfg config.StatusDamping) Opt {
+	return func(opt *optionsT) {
+		opt.damping = cfg
+	}
+}
+
+type stateT struct {
+	state model.AgentState
+	set   time.Time
+}
+
 type extraT struct {
-	meta        []byte
-	seqNo       sqn.SeqNo
-	ver         string
-	components  []byte
-	deleteAudit bool
+	meta           []byte
+	seqNo          sqn.SeqNo
+	ver            string
+	components     []byte
+	deleteAudit    bool
+	pendingActions *PendingActions
+	statusFlapping *bool
+	connection     *model.LastConnection
+}
+
+// statusT are the status fields of a checkin.
+type statusT struct {
+	status          string
+	message         string
+	unhealthyReason *[]string
+}
+
+// dampingT is the status damping state of an agent.
+type dampingT struct {
+	// written are the last status fields written, at writtenAt if the status changed.
+	written   statusT
+	writtenAt time.Time
+	// last is the status of the last checkin, changes are its changes within the flapping interval.
+	last    string
+	changes []time.Time
+	// held is the last checkin with a damped status change, its status fields are written when the window closes.
+	// It holds no other changes, they were written with the checkin.
+	held *pendingT
+	// flapping is the status_flapping marker as last written.
+	flapping bool
+}
+
+// trim drops the status changes older than interval.
+func (d *dampingT) trim(now time.Time, interval time.Duration) {
+	i := 0
+	for i < len(d.changes) && now.Sub(d.changes[i]) > interval {
+		i++
+	}
+	d.changes = d.changes[i:]
+}
+
+// PendingActions are the actions pending for an agent as of its checkin.
+type PendingActions struct {
+	Count int64
+	// OldestAge is the age of the oldest pending action in seconds.
+	OldestAge int64
 }
 
 // Minimize the size of this structure.
@@ -54,18 +119,57 @@ type extraT struct {
 // in the map at any point.
 type pendingT struct {
 	ts              string
+	state           model.AgentState
 	status          string
 	message         string
 	extra           *extraT
 	unhealthyReason *[]string
 }
 
+func (p *pendingT) statusFields() statusT {
+	return statusT{status: p.status, message: p.message, unhealthyReason: p.unhealthyReason}
+}
+
+func (p *pendingT) setStatusFields(s statusT) {
+	p.status, p.message, p.unhealthyReason = s.status, s.message, s.unhealthyReason
+}
+
+// keepChanges keeps the fields of the pending checkin prev that are only set when they change, the pending actions,
+// the connection and the status_flapping marker, unless p sets them.
+func (p *pendingT) keepChanges(prev pendingT) {
+	if prev.extra == nil {
+		return
+	}
+	pa, conn, flapping := prev.extra.pendingActions, prev.extra.connection, prev.extra.statusFlapping
+	if pa == nil && conn == nil && flapping == nil {
+		return
+	}
+	extra := &extraT{}
+	if p.extra != nil {
+		e := *p.extra
+		extra = &e
+	}
+	if extra.pendingActions == nil {
+		extra.pendingActions = pa
+	}
+	if extra.connection == nil {
+		extra.connection = conn
+	}
+	if extra.statusFlapping == nil {
+		extra.statusFlapping = flapping
+	}
+	p.extra = extra
+}
+
 // Bulk will batch pending checkins and update elasticsearch at a set interval.
 type Bulk struct {
 	opts    optionsT
 	bulker  bulk.Bulk
 	mut     sync.Mutex
 	pending map[string]pendingT
+	states  map[string]stateT
+	damping map[string]*dampingT
+	now     func() time.Time
 
 	ts   string
 	unix int64
@@ -78,6 +182,9 @@ func NewBulk(bulker bulk.Bulk, opts ...Opt) *Bulk {
 		opts:    parsedOpts,
 		bulker:  bulker,
 		pending: make(map[string]pendingT),
+		states:  make(map[string]stateT),
+		damping: make(map[string]*dampingT),
+		now:     time.Now,
 	}
 }
 
@@ -99,10 +206,11 @@ func parseOpts(opts ...Opt) optionsT {
 func (bc *Bulk) timestamp() string {
 
 	// WARNING: Expects mutex locked.
-	now := time.Now()
+	// The offline detection compares the checkin timestamps with the time of the other instances.
+	now := esclock.SkewedNow()
 	if now.Unix() != bc.unix {
 		bc.unix = now.Unix()
-		bc.ts = now.UTC().Format(time.RFC3339)
+		bc.ts = ftime.Format(now)
 	}
 
 	return bc.ts
@@ -110,9 +218,13 @@ func (bc *Bulk) timestamp() string {
 
 // CheckIn will add the agent (identified by id) to the pending set.
 // The pending agents are sent to elasticsearch as a bulk update at each flush interval.
+// state is the lifecycle state of the agent when the checkin started, it is replaced by the state set with SetState if any.
 // NOTE: If Checkin is called after Run has returned it will just add the entry to the pending map and not do any operations, this may occur when the fleet-server is shutting down.
+// With status damping, the first status change of an agent is written with the next flush. The status changes of the
+// agent within the damping window that follows are held in memory, its checkins are written with the status fields last
+// written, and the last change is written when the window closes. The other fields of the checkins are not damped.
 // WARNING: Bulk will take ownership of fields, so do not use after passing in.
-func (bc *Bulk) CheckIn(id string, status string, message string, meta []byte, components []byte, seqno sqn.SeqNo, newVer string, unhealthyReason *[]string, deleteAudit bool) error {
+func (bc *Bulk) CheckIn(id string, state model.AgentState, status string, message string, meta []byte, components []byte, seqno sqn.SeqNo, newVer string, unhealthyReason *[]string, deleteAudit bool) error {
 	// Separate out the extra data to minimize
 	// the memory footprint of the 90% case of just
 	// updating the timestamp.
@@ -129,43 +241,236 @@ func (bc *Bulk) CheckIn(id string, status string, message string, meta []byte, c
 
 	bc.mut.Lock()
 
-	bc.pending[id] = pendingT{
+	if s, ok := bc.states[id]; ok {
+		state = s.state
+	}
+	p := pendingT{
 		ts:              bc.timestamp(),
+		state:           state,
 		status:          status,
 		message:         message,
 		extra:           extra,
 		unhealthyReason: unhealthyReason,
 	}
+	if prev, ok := bc.pending[id]; ok {
+		p.keepChanges(prev)
+	}
+	bc.damp(id, &p)
+	bc.pending[id] = p
 
 	bc.mut.Unlock()
 	return nil
 }
 
-// Run starts the flush timer and exit only when the context is cancelled.
-func (bc *Bulk) Run(ctx context.Context) error {
-	tick := time.NewTicker(bc.opts.flushInterval)
-	defer tick.Stop()
+// damp records the status of the checkin p of the agent. The checkin is always written, but while the damping window
+// of the agent is open a status change is held and p is written with the status fields last written.
+// The status_flapping marker is set on p when it changed.
+// WARNING: Expects mutex locked.
+func (bc *Bulk) damp(id string, p *pendingT) {
+	window := bc.opts.damping.Window
+	if window <= 0 {
+		return
+	}
+	now := bc.now()
+	cur := p.statusFields()
+	d, ok := bc.damping[id]
+	if !ok {
+		d = &dampingT{written: cur, last: p.status}
+		bc.damping[id] = d
+	}
+	if p.status != d.last {
+		d.changes = append(d.changes, now)
+		d.last = p.status
+	}
+	switch {
+	case p.status == d.written.status:
+		// back to the status last written, or no change
+		d.written, d.held = cur, nil
+	case d.held == nil && now.Sub(d.writtenAt) >= window:
+		// first change since the last window closed
+		d.written, d.writtenAt = cur, now
+	default:
+		d.held = &pendingT{ts: p.ts, state: p.state}
+		d.held.setStatusFields(cur)
+		p.setStatusFields(d.written)
+	}
+	bc.markFlapping(d, p, now)
+}
+
+// markFlapping sets the status_flapping marker on the checkin p when it changed.
+// WARNING: Expects mutex locked.
+func (bc *Bulk) markFlapping(d *dampingT, p *pendingT, now time.Time) {
+	threshold := bc.opts.damping.FlappingThreshold
+	if threshold <= 0 {
+		return
+	}
+	d.trim(now, bc.opts.damping.FlappingInterval)
+	flapping := len(d.changes) > threshold
+	if flapping == d.flapping {
+		return
+	}
+	d.flapping = flapping
+	extra := &extraT{}
+	if p.extra != nil {
+		e := *p.extra
+		extra = &e
+	}
+	extra.statusFlapping = &flapping
+	p.extra = extra
+}
 
-	for {
-		select {
-		case <-tick.C:
-			if err := bc.flush(ctx); err != nil {
-				zerolog.Ctx(ctx).Error().Err(err).Msg("Eat bulk checkin error; Keep on truckin'")
+// releaseHeld writes the held status changes of the agents whose damping window closed, or of all the agents if all
+// is set, with their pending checkin. The damping state of the agents that stopped changing status is dropped.
+// WARNING: Expects mutex locked.
+func (bc *Bulk) releaseHeld(all bool) {
+	window := bc.opts.damping.Window
+	if window <= 0 {
+		return
+	}
+	now := bc.now()
+	for id, d := range bc.damping {
+		if d.held != nil && (all || now.Sub(d.writtenAt) >= window) {
+			// the pending checkin is at least as recent as the held one
+			p, ok := bc.pending[id]
+			if !ok {
+				p = *d.held
 			}
+			p.setStatusFields(d.held.statusFields())
+			d.written, d.writtenAt, d.held = d.held.statusFields(), now, nil
+			bc.markFlapping(d, &p, now)
+			bc.pending[id] = p
+			continue
+		}
+		d.trim(now, bc.opts.damping.FlappingInterval)
+		if d.held == nil && !d.flapping && len(d.changes) == 0 && now.Sub(d.writtenAt) >= window {
+			delete(bc.damping, id)
+		}
+	}
+}
+
+// SetPendingActions adds the actions pending for the agent to its pending checkin, they are written with it.
+// The caller only sets them when they changed, nothing is done if the checkin of the agent was already flushed.
+// SetPendingActions can be called on a nil Bulk.
+func (bc *Bulk) SetPendingActions(id string, pending PendingActions) {
+	if bc == nil {
+		return
+	}
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
 
-		case <-ctx.Done():
-			return ctx.Err()
+	p, ok := bc.pending[id]
+	if !ok {
+		return
+	}
+	extra := &extraT{}
+	if p.extra != nil {
+		e := *p.extra
+		extra = &e
+	}
+	extra.pendingActions = &pending
+	p.extra = extra
+	bc.pending[id] = p
+}
+
+// SetConnection adds the connection the agent checked in with to its pending checkin, it is written with it.
+// The caller only sets it when it changed, nothing is done if the checkin of the agent was already flushed.
+// SetConnection can be called on a nil Bulk.
+func (bc *Bulk) SetConnection(id string, conn model.LastConnection) {
+	if bc == nil {
+		return
+	}
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
+
+	p, ok := bc.pending[id]
+	if !ok {
+		return
+	}
+	extra := &extraT{}
+	if p.extra != nil {
+		e := *p.extra
+		extra = &e
+	}
+	extra.connection = &conn
+	p.extra = extra
+	bc.pending[id] = p
+}
+
+// connectionFields returns the last_connection object of conn. It replaces the object of the agent document, the
+// fields that are not set are written as null so that a value recorded before is cleared.
+func connectionFields(conn *model.LastConnection) map[string]interface{} {
+	value := func(s string) interface{} {
+		if s == "" {
+			return nil
 		}
+		return s
+	}
+	return map[string]interface{}{
+		"remote_address": value(conn.RemoteAddress),
+		"tls_version":    value(conn.TlsVersion),
+		"tls_cipher":     value(conn.TlsCipher),
+		"protocol":       value(conn.Protocol),
+	}
+}
+
+// SetState records the lifecycle state of the agent written by another writer than the checkins.
+// The pending and following checkins of the agent are validated against it before they are flushed,
+// a checkin that would revive an agent that was unenrolled meanwhile is dropped.
+// SetState can be called on a nil Bulk.
+func (bc *Bulk) SetState(id string, state model.AgentState) {
+	if bc == nil {
+		return
+	}
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
+
+	bc.states[id] = stateT{state: state, set: time.Now()}
+	if p, ok := bc.pending[id]; ok {
+		p.state = state
+		bc.pending[id] = p
+	}
+	if d, ok := bc.damping[id]; ok && d.held != nil {
+		d.held.state = state
+	}
+}
+
+// Schedule returns the schedule that flushes the pending checkins at the flush interval, and a last time on shutdown
+// with the status changes held by the damping.
+// A failed flush does not stop fleet server, the checkins it dropped are sent again by the agents.
+// The first flush is not delayed by the first run delay of the scheduler, the flushes of the instances are spread by 10%.
+func (bc *Bulk) Schedule() scheduler.Schedule {
+	return scheduler.Schedule{
+		Name:       "bulk checkin",
+		Interval:   bc.opts.flushInterval,
+		Jitter:     0.1,
+		RunOnStart: true,
+		WorkFn:     bc.flush,
+		StopFn:     bc.flushAll,
 	}
 }
 
 // flush sends the minium data needed to update records in elasticsearch.
 func (bc *Bulk) flush(ctx context.Context) error {
+	return bc.flushPending(ctx, false)
+}
+
+// flushAll flushes the pending checkins along with all the held status changes.
+func (bc *Bulk) flushAll(ctx context.Context) error {
+	return bc.flushPending(ctx, true)
+}
+
+func (bc *Bulk) flushPending(ctx context.Context, all bool) error {
 	start := time.Now()
 
 	bc.mut.Lock()
+	bc.releaseHeld(all)
 	pending := bc.pending
 	bc.pending = make(map[string]pendingT, len(pending))
+	for id, s := range bc.states {
+		if start.Sub(s.set) > stateTTL {
+			delete(bc.states, id)
+		}
+	}
 	bc.mut.Unlock()
 
 	if len(pending) == 0 {
@@ -176,11 +481,15 @@ func (bc *Bulk) flush(ctx context.Context) error {
 
 	simpleCache := make(map[pendingT][]byte)
 
-	nowTimestamp := start.UTC().Format(time.RFC3339)
+	nowTimestamp := ftime.Format(start)
 
 	var err error
 	var needRefresh bool
 	for id, pendingData := range pending {
+		state := pendingData.state.CheckinState()
+		if err := dl.CheckAgentTransition(ctx, id, pendingData.state, state); err != nil {
+			continue
+		}
 
 		// In the simple case, there are no fields and no seqNo.
 		// When that is true, we can reuse an already generated
@@ -196,6 +505,7 @@ func (bc *Bulk) flush(ctx context.Context) error {
 					dl.FieldLastCheckinStatus:  pendingData.status,
 					dl.FieldLastCheckinMessage: pendingData.message,
 					dl.FieldUnhealthyReason:    pendingData.unhealthyReason,
+					dl.FieldLifecycleState:     state,
 				}
 				if body, err = fields.Marshal(); err != nil {
 					return err
@@ -204,7 +514,7 @@ func (bc *Bulk) flush(ctx context.Context) error {
 			}
 		} else if pendingData.extra.deleteAudit {
 			// Use a script instead of a partial doc to update if attributes need to be removed
-			params, err := encodeParams(nowTimestamp, pendingData)
+			params, err := encodeParams(nowTimestamp, state, pendingData)
 			if err != nil {
 				return err
 			}
@@ -230,6 +540,7 @@ func (bc *Bulk) flush(ctx context.Context) error {
 				dl.FieldLastCheckinStatus:  pendingData.status,  // Set the pending status
 				dl.FieldLastCheckinMessage: pendingData.message, // Set the status message
 				dl.FieldUnhealthyReason:    pendingData.unhealthyReason,
+				dl.FieldLifecycleState:     state,
 			}
 
 			// If the agent version is not empty it needs to be updated
@@ -253,6 +564,19 @@ func (bc *Bulk) flush(ctx context.Context) error {
 				fields[dl.FieldComponents] = json.RawMessage(pendingData.extra.components)
 			}
 
+			if pa := pendingData.extra.pendingActions; pa != nil {
+				fields[dl.FieldPendingActionsCount] = pa.Count
+				fields[dl.FieldOldestPendingActionAge] = pa.OldestAge
+			}
+
+			if flapping := pendingData.extra.statusFlapping; flapping != nil {
+				fields[dl.FieldStatusFlapping] = *flapping
+			}
+
+			if conn := pendingData.extra.connection; conn != nil {
+				fields[dl.FieldLastConnection] = connectionFields(conn)
+			}
+
 			// If seqNo changed, set the field appropriately
 			if pendingData.extra.seqNo.IsSet() {
 				fields[dl.FieldActionSeqNo] = pendingData.extra.seqNo
@@ -272,6 +596,9 @@ func (bc *Bulk) flush(ctx context.Context) error {
 			Index: dl.FleetAgents,
 		})
 	}
+	if len(updates) == 0 {
+		return nil
+	}
 
 	var opts []bulk.Opt
 	if needRefresh {
@@ -290,10 +617,11 @@ func (bc *Bulk) flush(ctx context.Context) error {
 	return err
 }
 
-func encodeParams(now string, data pendingT) (map[string]json.RawMessage, error) {
+func encodeParams(now string, state model.AgentState, data pendingT) (map[string]json.RawMessage, error) {
 	var (
 		tsNow      json.RawMessage
 		ts         json.RawMessage
+		lcState    json.RawMessage
 		status     json.RawMessage
 		message    json.RawMessage
 		reason     json.RawMessage
@@ -302,12 +630,17 @@ func encodeParams(now string, data pendingT) (map[string]json.RawMessage, error)
 		components json.RawMessage
 		isSet      json.RawMessage
 		seqNo      json.RawMessage
+		pending    json.RawMessage
+		flapping   json.RawMessage
+		connection json.RawMessage
 		err        error
 	)
 	tsNow, err = json.Marshal(now)
 	Err := errors.Join(err)
 	ts, err = json.Marshal(data.ts)
 	Err = errors.Join(Err, err)
+	lcState, err = json.Marshal(state)
+	Err = errors.Join(Err, err)
 	status, err = json.Marshal(data.status)
 	Err = errors.Join(Err, err)
 	message, err = json.Marshal(data.message)
@@ -328,12 +661,28 @@ func encodeParams(now string, data pendingT) (map[string]json.RawMessage, error)
 		components, err = json.Marshal(data.extra.components)
 		Err = errors.Join(Err, err)
 	}
+	if pa := data.extra.pendingActions; pa != nil {
+		pending, err = json.Marshal(map[string]int64{
+			dl.FieldPendingActionsCount:    pa.Count,
+			dl.FieldOldestPendingActionAge: pa.OldestAge,
+		})
+		Err = errors.Join(Err, err)
+	}
+	if data.extra.statusFlapping != nil {
+		flapping, err = json.Marshal(*data.extra.statusFlapping)
+		Err = errors.Join(Err, err)
+	}
+	if data.extra.connection != nil {
+		connection, err = json.Marshal(connectionFields(data.extra.connection))
+		Err = errors.Join(Err, err)
+	}
 	if Err != nil {
 		return nil, Err
 	}
 	return map[string]json.RawMessage{
 		"Now":             tsNow,
 		"TS":              ts,
+		"State":           lcState,
 		"Status":          status,
 		"Message":         message,
 		"UnhealthyReason": reason,
@@ -342,5 +691,8 @@ func encodeParams(now string, data pendingT) (map[string]json.RawMessage, error)
 		"Components":      components,
 		"SeqNoSet":        isSet,
 		"SeqNo":           seqNo,
+		"PendingActions":  pending,
+		"StatusFlapping":  flapping,
+		"Connection":      connection,
 	}, nil
 }
@@ -8,11 +8,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
 	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
@@ -20,6 +24,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/rs/xid"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // Test simple,
@@ -48,6 +53,7 @@ func matchOp(tb testing.TB, c bulkcase, ts time.Time) func(ops []bulk.MultiOp) b
 			UpdatedAt   string          `json:"updated_at"`
 			Meta        json.RawMessage `json:"local_metadata"`
 			SeqNo       sqn.SeqNo       `json:"action_seq_no"`
+			State       string          `json:"lifecycle_state"`
 		}
 
 		m := make(map[string]updateT)
@@ -75,6 +81,10 @@ func matchOp(tb testing.TB, c bulkcase, ts time.Time) func(ops []bulk.MultiOp) b
 			tb.Error("status mismatch")
 		}
 
+		if sub.State != string(model.AgentStateOnline) {
+			tb.Errorf("lifecycle state mismatch: %s", sub.State)
+		}
+
 		return true
 	}
 }
@@ -204,7 +214,7 @@ func TestBulkSimple(t *testing.T) {
 			mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(matchOp(t, c, start)), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
 			bc := NewBulk(mockBulk)
 
-			if err := bc.CheckIn(c.id, c.status, c.message, c.meta, c.components, c.seqno, c.ver, c.unhealthyReason, false); err != nil {
+			if err := bc.CheckIn(c.id, model.AgentStateOnline, c.status, c.message, c.meta, c.components, c.seqno, c.ver, c.unhealthyReason, false); err != nil {
 				t.Fatal(err)
 			}
 
@@ -217,6 +227,279 @@ func TestBulkSimple(t *testing.T) {
 	}
 }
 
+func TestBulkStaleCheckin(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	t.Run("unenrolled before flush", func(t *testing.T) {
+		mockBulk := ftesting.NewMockBulk()
+		bc := NewBulk(mockBulk)
+
+		require.NoError(t, bc.CheckIn("agent", model.AgentStateOnline, "online", "", nil, nil, nil, "", nil, false))
+		bc.SetState("agent", model.AgentStateUnenrolled)
+		require.NoError(t, bc.flush(ctx))
+
+		// A checkin that started before the unenrollment is checked against the state set meanwhile.
+		require.NoError(t, bc.CheckIn("agent", model.AgentStateOnline, "online", "", nil, nil, nil, "", nil, false))
+		require.NoError(t, bc.flush(ctx))
+		mockBulk.AssertNotCalled(t, "MUpdate", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("unenrolling stays unenrolling", func(t *testing.T) {
+		mockBulk := ftesting.NewMockBulk()
+		mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(func(ops []bulk.MultiOp) bool {
+			return len(ops) == 1 && bytes.Contains(ops[0].Body, []byte(`"lifecycle_state":"unenrolling"`))
+		}), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+		bc := NewBulk(mockBulk)
+
+		require.NoError(t, bc.CheckIn("agent", model.AgentStateOnline, "online", "", nil, nil, nil, "", nil, false))
+		bc.SetState("agent", model.AgentStateUnenrolling)
+		require.NoError(t, bc.flush(ctx))
+		mockBulk.AssertExpectations(t)
+	})
+
+	t.Run("enrolled again", func(t *testing.T) {
+		mockBulk := ftesting.NewMockBulk()
+		mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(func(ops []bulk.MultiOp) bool {
+			return len(ops) == 1 && bytes.Contains(ops[0].Body, []byte(`"lifecycle_state":"online"`))
+		}), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+		bc := NewBulk(mockBulk)
+
+		bc.SetState("agent", model.AgentStateUnenrolled)
+		bc.SetState("agent", model.AgentStateEnrolled)
+		require.NoError(t, bc.CheckIn("agent", model.AgentStateUnenrolled, "online", "", nil, nil, nil, "", nil, false))
+		require.NoError(t, bc.flush(ctx))
+		mockBulk.AssertExpectations(t)
+	})
+}
+
+func TestBulkPendingActions(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	t.Run("written with the checkin", func(t *testing.T) {
+		mockBulk := ftesting.NewMockBulk()
+		mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(func(ops []bulk.MultiOp) bool {
+			return len(ops) == 1 && bytes.Contains(ops[0].Body, []byte(`"pending_actions_count":2`)) &&
+				bytes.Contains(ops[0].Body, []byte(`"oldest_pending_action_age":120`)) &&
+				bytes.Contains(ops[0].Body, []byte(`"local_metadata":{"os":"linux"}`))
+		}), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+		bc := NewBulk(mockBulk)
+
+		require.NoError(t, bc.CheckIn("agent", model.AgentStateOnline, "online", "", []byte(`{"os":"linux"}`), nil, nil, "", nil, false))
+		bc.SetPendingActions("agent", PendingActions{Count: 2, OldestAge: 120})
+		require.NoError(t, bc.flush(ctx))
+		mockBulk.AssertExpectations(t)
+	})
+
+	t.Run("written with the audit fields removal", func(t *testing.T) {
+		mockBulk := ftesting.NewMockBulk()
+		mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(func(ops []bulk.MultiOp) bool {
+			return len(ops) == 1 && bytes.Contains(ops[0].Body, []byte(`"PendingActions":{"oldest_pending_action_age":0,"pending_actions_count":0}`))
+		}), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+		bc := NewBulk(mockBulk)
+
+		require.NoError(t, bc.CheckIn("agent", model.AgentStateOnline, "online", "", nil, nil, nil, "", nil, true))
+		bc.SetPendingActions("agent", PendingActions{})
+		require.NoError(t, bc.flush(ctx))
+		mockBulk.AssertExpectations(t)
+	})
+
+	t.Run("checkin already flushed", func(t *testing.T) {
+		mockBulk := ftesting.NewMockBulk()
+		bc := NewBulk(mockBulk)
+
+		bc.SetPendingActions("agent", PendingActions{Count: 1})
+		require.NoError(t, bc.flush(ctx))
+		mockBulk.AssertNotCalled(t, "MUpdate", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestBulkConnection(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	t.Run("written with the checkin", func(t *testing.T) {
+		mockBulk := ftesting.NewMockBulk()
+		mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(func(ops []bulk.MultiOp) bool {
+			return len(ops) == 1 && bytes.Contains(ops[0].Body, []byte(`"last_connection":{"protocol":"h2","remote_address":null,"tls_cipher":"TLS_AES_128_GCM_SHA256","tls_version":"1.3"}`))
+		}), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+		bc := NewBulk(mockBulk)
+
+		require.NoError(t, bc.CheckIn("agent", model.AgentStateOnline, "online", "", nil, nil, nil, "", nil, false))
+		bc.SetConnection("agent", model.LastConnection{Protocol: "h2", TlsCipher: "TLS_AES_128_GCM_SHA256", TlsVersion: "1.3"})
+		require.NoError(t, bc.flush(ctx))
+		mockBulk.AssertExpectations(t)
+	})
+
+	t.Run("written with the audit fields removal", func(t *testing.T) {
+		mockBulk := ftesting.NewMockBulk()
+		mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(func(ops []bulk.MultiOp) bool {
+			return len(ops) == 1 && bytes.Contains(ops[0].Body, []byte(`"Connection":{"protocol":null,"remote_address":"10.0.0.1","tls_cipher":null,"tls_version":null}`))
+		}), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+		bc := NewBulk(mockBulk)
+
+		require.NoError(t, bc.CheckIn("agent", model.AgentStateOnline, "online", "", nil, nil, nil, "", nil, true))
+		bc.SetConnection("agent", model.LastConnection{RemoteAddress: "10.0.0.1"})
+		require.NoError(t, bc.flush(ctx))
+		mockBulk.AssertExpectations(t)
+	})
+
+	t.Run("checkin already flushed", func(t *testing.T) {
+		mockBulk := ftesting.NewMockBulk()
+		bc := NewBulk(mockBulk)
+
+		bc.SetConnection("agent", model.LastConnection{RemoteAddress: "10.0.0.1"})
+		require.NoError(t, bc.flush(ctx))
+		mockBulk.AssertNotCalled(t, "MUpdate", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestBulkFlushOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(testlog.SetLogger(t).WithContext(context.Background()))
+	mockBulk := ftesting.NewMockBulk()
+	mockBulk.On("MUpdate", mock.Anything, mock.MatchedBy(func(ops []bulk.MultiOp) bool {
+		return len(ops) == 1 && ops[0].ID == "agent"
+	}), mock.Anything).Return([]bulk.BulkIndexerResponseItem{}, nil).Once()
+	bc := NewBulk(mockBulk, WithFlushInterval(time.Hour))
+
+	sched, err := scheduler.New([]scheduler.Schedule{bc.Schedule()})
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() {
+		done <- sched.Run(ctx)
+	}()
+
+	// the pending checkin is not lost when the schedule stops before the flush interval
+	require.NoError(t, bc.CheckIn("agent", model.AgentStateOnline, "online", "", nil, nil, nil, "", nil, false))
+	cancel()
+	require.NoError(t, <-done)
+	mockBulk.AssertExpectations(t)
+}
+
+func TestBulkStatusDamping(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	var writes []string
+	mockBulk := ftesting.NewMockBulk()
+	mockBulk.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		for _, op := range args.Get(1).([]bulk.MultiOp) {
+			writes = append(writes, string(op.Body))
+		}
+	}).Return([]bulk.BulkIndexerResponseItem{}, nil)
+	bc := NewBulk(mockBulk, WithStatusDamping(config.StatusDamping{
+		Window:            time.Minute,
+		FlappingThreshold: 5,
+		FlappingInterval:  10 * time.Minute,
+	}))
+	now := time.Now()
+	bc.now = func() time.Time { return now }
+	checkin := func(status string) {
+		require.NoError(t, bc.CheckIn("agent", model.AgentStateOnline, status, status+" message", nil, nil, nil, "", nil, false))
+	}
+	status := func(w string) string {
+		var update struct {
+			Doc struct {
+				Status string `json:"last_checkin_status"`
+			} `json:"doc"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(w), &update))
+		return update.Doc.Status
+	}
+
+	checkin("online")
+	require.NoError(t, bc.flush(ctx))
+	require.Len(t, writes, 1)
+
+	// the first change is written with the next flush
+	checkin("degraded")
+	require.NoError(t, bc.flush(ctx))
+	require.Len(t, writes, 2)
+	require.Contains(t, writes[1], `"last_checkin_status":"degraded"`)
+
+	// an agent flapping every 2s for 5 minutes, with a flush every 10s
+	writes = writes[:0]
+	statuses := []string{"online", "degraded"}
+	for i := 0; i < 150; i++ {
+		now = now.Add(2 * time.Second)
+		checkin(statuses[i%2])
+		if i%5 == 4 {
+			require.NoError(t, bc.flush(ctx))
+		}
+	}
+	require.Len(t, writes, 30, "the checkins are written with each flush")
+	changes, flapping := 0, 0
+	written := "degraded"
+	for _, w := range writes {
+		require.Contains(t, w, `"last_checkin":`)
+		if s := status(w); s != written {
+			changes++
+			written = s
+		}
+		if strings.Contains(w, `"status_flapping":true`) {
+			flapping++
+		}
+	}
+	require.LessOrEqual(t, changes, 10, "the status changes are written at most twice per damping window")
+	require.Equal(t, 1, flapping, "the marker is written once")
+
+	// the last status is written when the window closes
+	writes = writes[:0]
+	now = now.Add(2 * time.Second)
+	require.NoError(t, bc.CheckIn("agent", model.AgentStateOnline, "online", "online message", nil, nil, nil, "", nil, false))
+	require.NoError(t, bc.flush(ctx))
+	now = now.Add(time.Minute)
+	require.NoError(t, bc.flush(ctx))
+	require.NotEmpty(t, writes)
+	require.Contains(t, writes[len(writes)-1], `"last_checkin_status":"online"`)
+	require.Contains(t, writes[len(writes)-1], `"last_checkin_message":"online message"`)
+	require.Nil(t, bc.damping["agent"].held)
+
+	// the marker is cleared once the agent stopped flapping
+	writes = writes[:0]
+	now = now.Add(10 * time.Minute)
+	checkin("online")
+	require.NoError(t, bc.flush(ctx))
+	require.Len(t, writes, 1)
+	require.Contains(t, writes[0], `"status_flapping":false`)
+
+	// and the damping state of the agent is dropped
+	now = now.Add(time.Minute)
+	require.NoError(t, bc.flush(ctx))
+	require.Empty(t, bc.damping)
+}
+
+func TestBulkStatusDampingOtherChanges(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	var writes []string
+	mockBulk := ftesting.NewMockBulk()
+	mockBulk.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		for _, op := range args.Get(1).([]bulk.MultiOp) {
+			writes = append(writes, string(op.Body))
+		}
+	}).Return([]bulk.BulkIndexerResponseItem{}, nil)
+	bc := NewBulk(mockBulk, WithStatusDamping(config.StatusDamping{Window: time.Minute}))
+
+	require.NoError(t, bc.CheckIn("agent", model.AgentStateOnline, "degraded", "", nil, nil, nil, "", nil, false))
+	require.NoError(t, bc.CheckIn("agent", model.AgentStateOnline, "online", "", nil, nil, nil, "", nil, false))
+	require.NoError(t, bc.flush(ctx))
+	require.Len(t, writes, 1)
+	require.Contains(t, writes[0], `"last_checkin_status":"online"`)
+
+	// the checkin with a damped status change is written with its other fields and those set while it is pending
+	require.NoError(t, bc.CheckIn("agent", model.AgentStateOnline, "degraded", "", []byte(`{"os":"linux"}`), nil, nil, "", nil, false))
+	bc.SetPendingActions("agent", PendingActions{Count: 2})
+	bc.SetConnection("agent", model.LastConnection{TlsVersion: "1.3"})
+	require.NoError(t, bc.CheckIn("agent", model.AgentStateOnline, "degraded", "", nil, nil, nil, "", nil, false))
+	require.NoError(t, bc.flush(ctx))
+	require.Len(t, writes, 2)
+	require.Contains(t, writes[1], `"last_checkin_status":"online"`)
+	require.Contains(t, writes[1], `"pending_actions_count":2`)
+	require.Contains(t, writes[1], `"tls_version":"1.3"`)
+
+	// the held status change is written on shutdown
+	require.NoError(t, bc.flushAll(ctx))
+	require.Len(t, writes, 3)
+	require.Contains(t, writes[2], `"last_checkin_status":"degraded"`)
+	require.Empty(t, bc.pending)
+}
+
 func validateTimestamp(tb testing.TB, start time.Time, ts string) {
 	if t1, err := time.Parse(time.RFC3339, ts); err != nil {
 		tb.Error("expected rfc3999")
@@ -239,7 +522,7 @@ func benchmarkBulk(n int, b *testing.B) {
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
 		for _, id := range ids {
-			err := bc.CheckIn(id, "", "", nil, nil, nil, "", nil, false)
+			err := bc.CheckIn(id, model.AgentStateOnline, "", "", nil, nil, nil, "", nil, false)
 			if err != nil {
 				b.Fatal(err)
 			}
@@ -265,7 +548,7 @@ func benchmarkFlush(n int, b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		b.StopTimer()
 		for _, id := range ids {
-			err := bc.CheckIn(id, "", "", nil, nil, nil, "", nil, false)
+			err := bc.CheckIn(id, model.AgentStateOnline, "", "", nil, nil, nil, "", nil, false)
 			if err != nil {
 				b.Fatal(err)
 			}
@@ -0,0 +1,150 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package clientip resolves the IP address of the client of a request, including behind the trusted proxies that forward it.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const HeaderForwardedFor = "X-Forwarded-For"
+
+type ctxKey struct{}
+
+// Resolver resolves the IP address of the client of the requests.
+type Resolver struct {
+	proxies []netip.Prefix
+}
+
+// New returns a Resolver that trusts the X-Forwarded-For header of the requests sent by proxies.
+// A proxy is a CIDR or an IP address.
+func New(proxies []string) (*Resolver, error) {
+	rs := &Resolver{}
+	for _, proxy := range proxies {
+		prefix, err := ParseProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		rs.proxies = append(rs.proxies, prefix)
+	}
+	return rs, nil
+}
+
+// ParseProxy parses a CIDR or an IP address, an address is the prefix of this single address.
+func ParseProxy(proxy string) (netip.Prefix, error) {
+	proxy = strings.TrimSpace(proxy)
+	if prefix, err := netip.ParsePrefix(proxy); err == nil {
+		if prefix.Addr().Is4In6() {
+			return netip.Prefix{}, fmt.Errorf("invalid trusted proxy %q: IPv4-mapped IPv6 CIDRs are not supported", proxy)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(proxy)
+	if err != nil || addr.Zone() != "" {
+		return netip.Prefix{}, fmt.Errorf("invalid trusted proxy %q: expected a CIDR or an IP address", proxy)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Resolve returns the IP address of the client of r.
+//
+// It is the address of the peer of r, unless the peer is a trusted proxy. The X-Forwarded-For header is then read from
+// right to left, each proxy appending the address of its own peer: the client is the first address that is not a trusted
+// proxy, the addresses on its left are set by the client and can not be trusted.
+// If a malformed address is found first, the client is the last trusted proxy on its right, so the resolved address is
+// always one of an actual peer. The port of the peer is not returned.
+func (rs *Resolver) Resolve(r *http.Request) string {
+	peer, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return remoteHost(r.RemoteAddr)
+	}
+	client := peer
+	if !rs.trusted(client) {
+		return client.String()
+	}
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseAddr(hops[i])
+		if !ok {
+			break
+		}
+		client = hop
+		if !rs.trusted(client) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func (rs *Resolver) trusted(addr netip.Addr) bool {
+	if rs == nil {
+		return false
+	}
+	for _, prefix := range rs.proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware adds the client IP of the requests resolved by rs to their context.
+func Middleware(rs *Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), ctxKey{}, rs.Resolve(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// FromRequest returns the client IP of r added by Middleware, or the IP of the peer of r if it was not added.
+func FromRequest(r *http.Request) string {
+	if ip, ok := r.Context().Value(ctxKey{}).(string); ok {
+		return ip
+	}
+	return remoteHost(r.RemoteAddr)
+}
+
+// forwardedFor returns the addresses of the X-Forwarded-For headers of h, in order.
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	for _, value := range h.Values(HeaderForwardedFor) {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseAddr parses an IP address with an optional port, IPv6 addresses with a port are enclosed in brackets.
+// The IPv4-mapped IPv6 addresses are returned as IPv4 addresses, and zones are dropped.
+func parseAddr(s string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		host, _, splitErr := net.SplitHostPort(s)
+		if splitErr != nil {
+			return netip.Addr{}, false
+		}
+		if addr, err = netip.ParseAddr(host); err != nil {
+			return netip.Addr{}, false
+		}
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// remoteHost returns the host of a RemoteAddr that is not parsed as an IP address.
+func remoteHost(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
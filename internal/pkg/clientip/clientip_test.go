@@ -0,0 +1,231 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		err     bool
+	}{
+		{name: "none"},
+		{name: "ipv4 cidr", proxies: []string{"10.0.0.0/8"}},
+		{name: "ipv6 cidr", proxies: []string{"fd00::/8"}},
+		{name: "addresses", proxies: []string{"10.0.0.1", "::1", " 192.168.1.1 "}},
+		{name: "unmasked cidr", proxies: []string{"10.1.2.3/8"}},
+		{name: "empty", proxies: []string{""}, err: true},
+		{name: "hostname", proxies: []string{"proxy.example.com"}, err: true},
+		{name: "invalid prefix length", proxies: []string{"10.0.0.0/33"}, err: true},
+		{name: "address with port", proxies: []string{"10.0.0.1:8080"}, err: true},
+		{name: "address with zone", proxies: []string{"fe80::1%eth0"}, err: true},
+		{name: "mapped cidr", proxies: []string{"::ffff:10.0.0.0/104"}, err: true},
+		{name: "one invalid", proxies: []string{"10.0.0.0/8", "invalid"}, err: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(tc.proxies)
+			if tc.err {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	rs, err := New([]string{"10.0.0.0/8", "fd00::/8", "192.168.1.1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		ip         string
+	}{{
+		name:       "no header",
+		remoteAddr: "192.0.2.1:1234",
+		ip:         "192.0.2.1",
+	}, {
+		name:       "untrusted peer",
+		remoteAddr: "192.0.2.1:1234",
+		forwarded:  []string{"198.51.100.1"},
+		ip:         "192.0.2.1",
+	}, {
+		name:       "trusted peer without header",
+		remoteAddr: "10.0.0.1:1234",
+		ip:         "10.0.0.1",
+	}, {
+		name:       "trusted peer with empty header",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{""},
+		ip:         "10.0.0.1",
+	}, {
+		name:       "trusted peer",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"198.51.100.1"},
+		ip:         "198.51.100.1",
+	}, {
+		name:       "trusted peer address",
+		remoteAddr: "192.168.1.1:1234",
+		forwarded:  []string{"198.51.100.1"},
+		ip:         "198.51.100.1",
+	}, {
+		name:       "chained trusted proxies",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"198.51.100.1, 10.0.0.3, 10.0.0.2"},
+		ip:         "198.51.100.1",
+	}, {
+		name:       "spoofed hops on the left",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"203.0.113.1, 198.51.100.1, 10.0.0.2"},
+		ip:         "198.51.100.1",
+	}, {
+		name:       "spoofed trusted hop on the left",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"10.0.0.5, 198.51.100.1"},
+		ip:         "198.51.100.1",
+	}, {
+		name:       "multiple headers",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"203.0.113.1, 198.51.100.1", "10.0.0.3", "10.0.0.2"},
+		ip:         "198.51.100.1",
+	}, {
+		name:       "all hops trusted",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"10.0.0.3, 10.0.0.2"},
+		ip:         "10.0.0.3",
+	}, {
+		name:       "hop with port",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"198.51.100.1:5678"},
+		ip:         "198.51.100.1",
+	}, {
+		name:       "extra whitespace",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"  198.51.100.1 ,10.0.0.2  "},
+		ip:         "198.51.100.1",
+	}, {
+		name:       "ipv6 peer",
+		remoteAddr: "[fd00::1]:1234",
+		forwarded:  []string{"2001:db8::1"},
+		ip:         "2001:db8::1",
+	}, {
+		name:       "ipv6 untrusted peer",
+		remoteAddr: "[2001:db8::2]:1234",
+		forwarded:  []string{"2001:db8::1"},
+		ip:         "2001:db8::2",
+	}, {
+		name:       "ipv6 hop with port",
+		remoteAddr: "[fd00::1]:1234",
+		forwarded:  []string{"[2001:db8::1]:5678"},
+		ip:         "2001:db8::1",
+	}, {
+		name:       "ipv6 hop with zone",
+		remoteAddr: "[fd00::1]:1234",
+		forwarded:  []string{"fe80::1%eth0"},
+		ip:         "fe80::1",
+	}, {
+		name:       "ipv6 trusted hop",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"198.51.100.1, fd00::2"},
+		ip:         "198.51.100.1",
+	}, {
+		name:       "ipv4-mapped peer",
+		remoteAddr: "[::ffff:10.0.0.1]:1234",
+		forwarded:  []string{"::ffff:198.51.100.1"},
+		ip:         "198.51.100.1",
+	}, {
+		name:       "malformed hop",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"not-an-ip"},
+		ip:         "10.0.0.1",
+	}, {
+		name:       "malformed hop after trusted hops",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"198.51.100.1, garbage, 10.0.0.2"},
+		ip:         "10.0.0.2",
+	}, {
+		name:       "malformed hop on the left",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"garbage, 198.51.100.1"},
+		ip:         "198.51.100.1",
+	}, {
+		name:       "empty hop",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"198.51.100.1,,"},
+		ip:         "10.0.0.1",
+	}, {
+		name:       "unknown hop",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"unknown"},
+		ip:         "10.0.0.1",
+	}, {
+		name:       "ipv6 hop without brackets and port",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"2001:db8::1:5678"},
+		ip:         "2001:db8::1:5678",
+	}, {
+		name:       "bracketed ipv6 hop without port",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  []string{"[2001:db8::1]"},
+		ip:         "10.0.0.1",
+	}, {
+		name:       "peer without port",
+		remoteAddr: "10.0.0.1",
+		forwarded:  []string{"198.51.100.1"},
+		ip:         "198.51.100.1",
+	}, {
+		name:       "malformed peer",
+		remoteAddr: "pipe",
+		forwarded:  []string{"198.51.100.1"},
+		ip:         "pipe",
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, value := range tc.forwarded {
+				r.Header.Add(HeaderForwardedFor, value)
+			}
+			require.Equal(t, tc.ip, rs.Resolve(r))
+		})
+	}
+}
+
+func TestResolveNoProxies(t *testing.T) {
+	for _, rs := range []*Resolver{nil, {}} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set(HeaderForwardedFor, "198.51.100.1")
+		require.Equal(t, "10.0.0.1", rs.Resolve(r))
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	rs, err := New([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set(HeaderForwardedFor, "198.51.100.1")
+	require.Equal(t, "10.0.0.1", FromRequest(r), "expected the peer without the middleware")
+
+	var ip string
+	Middleware(rs)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		ip = FromRequest(r)
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	require.Equal(t, "198.51.100.1", ip)
+}
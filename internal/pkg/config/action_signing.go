@@ -0,0 +1,51 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ActionSigning is the configuration used to verify the signatures of privileged actions before they are delivered to agents.
+type ActionSigning struct {
+	// PublicKey is the PEM encoded public key used to verify action signatures.
+	PublicKey string `config:"public_key"`
+	// PublicKeyPath is the location of a file containing the PEM encoded public key, it may be used instead of PublicKey.
+	PublicKeyPath string `config:"public_key_path"`
+	// RequireSignedActions rejects privileged actions that are not signed.
+	RequireSignedActions bool `config:"require_signed_actions"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *ActionSigning) InitDefaults() {}
+
+// Validate ensures that the configuration is valid.
+func (c *ActionSigning) Validate() error {
+	if c.PublicKey != "" && c.PublicKeyPath != "" {
+		return errors.New("only one of public_key or public_key_path may be specified")
+	}
+	if c.RequireSignedActions && c.PublicKey == "" && c.PublicKeyPath == "" {
+		return errors.New("require_signed_actions needs a public_key or public_key_path")
+	}
+	return nil
+}
+
+// Key returns the configured PEM encoded public key, reading it from PublicKeyPath if needed.
+// It returns nil if no key is configured.
+func (c *ActionSigning) Key() ([]byte, error) {
+	if c.PublicKeyPath != "" {
+		p, err := os.ReadFile(c.PublicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read action signing public key: %w", err)
+		}
+		return p, nil
+	}
+	if c.PublicKey != "" {
+		return []byte(c.PublicKey), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,86 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"path"
+	"time"
+)
+
+const (
+	defaultUpgradeRetryBackoff    = 5 * time.Minute
+	defaultUpgradeRetryMaxBackoff = time.Hour
+)
+
+// defaultResponseIndices are the indices that the responses of the osquery input actions are copied to.
+var defaultResponseIndices = []string{".logs-osquery_manager.action.responses-*"}
+
+// Actions is the configuration of the delivery of the actions.
+type Actions struct {
+	// DefaultTTL is the time to live of the actions written without an expiration, counted from their creation.
+	// Older actions are not delivered and an expired result is recorded for their agents. Zero disables it.
+	DefaultTTL time.Duration `config:"default_ttl"`
+	// TTL overrides the default time to live for the action types, a zero TTL disables it for the type.
+	TTL map[string]time.Duration `config:"ttl"`
+	// ResponseIndices are the patterns of the indices that the results of the input actions may be copied to.
+	// The result of an input action with a response is copied to .logs-<input_type>.action.responses-<namespace>
+	// for each namespace of the action when the index matches a pattern, in addition to the fleet action result.
+	ResponseIndices []string `config:"response_indices"`
+	// UpgradeRetry is the automatic retry of the UPGRADE actions the agents fail.
+	UpgradeRetry UpgradeRetry `config:"upgrade_retry"`
+}
+
+// UpgradeRetry is the configuration of the automatic retry of the failed upgrades.
+// An UPGRADE action acked with an error is re-issued to the agent with the same version and source once Backoff passed,
+// the backoff doubles with each attempt up to MaxBackoff. The agent upgrade status is set to upgrade_failed once
+// MaxAttempts attempts failed, including the UPGRADE action of the user. A MaxAttempts below 2 disables the retries.
+type UpgradeRetry struct {
+	MaxAttempts int           `config:"max_attempts"`
+	Backoff     time.Duration `config:"backoff"`
+	MaxBackoff  time.Duration `config:"max_backoff"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *Actions) InitDefaults() {
+	c.ResponseIndices = append([]string(nil), defaultResponseIndices...)
+	c.UpgradeRetry.Backoff = defaultUpgradeRetryBackoff
+	c.UpgradeRetry.MaxBackoff = defaultUpgradeRetryMaxBackoff
+}
+
+// ActionTTL returns the time to live of the actions of type actionType written without an expiration,
+// or zero if they do not expire.
+func (c Actions) ActionTTL(actionType string) time.Duration {
+	if ttl, ok := c.TTL[actionType]; ok {
+		return ttl
+	}
+	return c.DefaultTTL
+}
+
+// ResponseIndexAllowed returns true if the results of the input actions may be copied to index.
+func (c Actions) ResponseIndexAllowed(index string) bool {
+	for _, pattern := range c.ResponseIndices {
+		if ok, _ := path.Match(pattern, index); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Enabled returns true if the failed upgrades are retried.
+func (c UpgradeRetry) Enabled() bool {
+	return c.MaxAttempts > 1
+}
+
+// Delay returns the time to wait before the attempt that follows the failed attempt, the first attempt being 1.
+func (c UpgradeRetry) Delay(attempt int) time.Duration {
+	d := c.Backoff
+	for i := 1; i < attempt && (c.MaxBackoff <= 0 || d < c.MaxBackoff); i++ {
+		d *= 2
+	}
+	if c.MaxBackoff > 0 && d > c.MaxBackoff {
+		return c.MaxBackoff
+	}
+	return d
+}
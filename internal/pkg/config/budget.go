@@ -0,0 +1,45 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import "time"
+
+const (
+	defaultBudgetTotal = 30 * time.Second
+	defaultBudgetAuth  = 0.3
+	defaultBudgetRead  = 0.3
+	defaultBudgetWrite = 0.4
+)
+
+// OperationBudget is the time allowed to the elasticsearch operations of the requests of an endpoint.
+// The auth, read and write operations of a request are each allowed a fraction of the total, they fail
+// once it is exceeded so there is still time to write the error response. A zero total disables the budget.
+type OperationBudget struct {
+	Total time.Duration `config:"total"`
+	Auth  float64       `config:"auth"`
+	Read  float64       `config:"read"`
+	Write float64       `config:"write"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *OperationBudget) InitDefaults() {
+	c.Total = defaultBudgetTotal
+	c.Auth = defaultBudgetAuth
+	c.Read = defaultBudgetRead
+	c.Write = defaultBudgetWrite
+}
+
+// Budgets are the operation budgets of the endpoints.
+type Budgets struct {
+	// Checkin budget applies to the operations before the long poll, and to each operation once it ends.
+	Checkin OperationBudget `config:"checkin"`
+	Ack     OperationBudget `config:"ack"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *Budgets) InitDefaults() {
+	c.Checkin.InitDefaults()
+	c.Ack.InitDefaults()
+}
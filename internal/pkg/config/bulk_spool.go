@@ -0,0 +1,21 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+const defaultBulkSpoolMaxSize = 256 * 1024 * 1024
+
+// BulkSpool is the configuration of the disk spool of the bulker. While elasticsearch is unavailable, the non-critical
+// writes that overflow the low priority lane are appended to the file at Path, up to MaxSize bytes, instead of being
+// dropped. They are written to elasticsearch once it is available again.
+type BulkSpool struct {
+	Enabled bool   `config:"enabled"`
+	Path    string `config:"path"`
+	MaxSize int    `config:"max_size"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *BulkSpool) InitDefaults() {
+	c.MaxSize = defaultBulkSpoolMaxSize
+}
@@ -5,6 +5,9 @@
 package config
 
 import (
+	"fmt"
+	"maps"
+	"slices"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -16,8 +19,44 @@ const (
 	defaultArtifactTTL  = time.Hour * 24
 	defaultAPIKeyTTL    = time.Minute * 15 // APIKey validation is a bottleneck.
 	defaultAPIKeyJitter = time.Minute * 5  // Jitter allows some randomness on APIKeyTTL, zero to disable
+
+	defaultEnrollIdempotencyTTL = time.Minute * 10
+	defaultPolicyDocTTL         = time.Second * 5 // The policy monitor invalidates the policy documents earlier on a new revision.
+	defaultNotFoundTTL          = time.Second * 5 // The creation of a document invalidates its not found lookups earlier.
+)
+
+// Names of the caches that can be overridden.
+const (
+	// CacheAuth caches the API key authentications.
+	CacheAuth = "auth"
+	// CacheEnrollKey caches the enrollment API keys.
+	CacheEnrollKey = "enroll_key"
+	// CachePolicyDoc caches the policy revisions read by the requests that do not get them from the policy monitor.
+	CachePolicyDoc = "policy_doc"
+	// CacheStatusESHealth caches the responses of the status endpoint, with the health of the outputs. It is disabled by default.
+	CacheStatusESHealth = "status_es_health"
+	// CacheNotFound caches the lookups of the actions and agents that were not found.
+	CacheNotFound = "not_found"
 )
 
+// CacheNames are the names of the caches that can be overridden.
+var CacheNames = []string{CacheAuth, CacheEnrollKey, CachePolicyDoc, CacheStatusESHealth, CacheNotFound}
+
+// CacheOverride overrides the settings of a named cache, a zero value keeps the default.
+type CacheOverride struct {
+	TTL time.Duration `config:"ttl"`
+	// Size is the max cost of the cache, its own instance is created instead of sharing the default one.
+	// The entries of the auth, enroll_key and not_found caches cost their size in bytes, the policy_doc cache holds at most Size revisions.
+	// The status_es_health cache holds a single response and has no size.
+	Size int64 `config:"size"`
+}
+
+// CacheSettings are the effective settings of a named cache, a zero Size uses the default cache, or no size limit.
+type CacheSettings struct {
+	TTL  time.Duration
+	Size int64
+}
+
 type Cache struct {
 	NumCounters  int64         `config:"num_counters"`
 	MaxCost      int64         `config:"max_cost"`
@@ -26,10 +65,53 @@ type Cache struct {
 	ArtifactTTL  time.Duration `config:"ttl_artifact"`
 	APIKeyTTL    time.Duration `config:"ttl_api_key"`
 	APIKeyJitter time.Duration `config:"jitter_api_key"`
+
+	// EnrollIdempotencyTTL is how long an enroll response is replayed for the retries with the same idempotency key.
+	EnrollIdempotencyTTL time.Duration `config:"ttl_enroll_idempotency"`
+
+	// Overrides are the settings of the named caches that take precedence over the defaults, keyed by cache name.
+	Overrides map[string]CacheOverride `config:"overrides"`
 }
 
 func (c *Cache) InitDefaults() {}
 
+// Validate ensures the overrides are set for known caches.
+func (c *Cache) Validate() error {
+	for name, o := range c.Overrides {
+		if !slices.Contains(CacheNames, name) {
+			return fmt.Errorf("unknown cache %q in cache overrides, expected one of %v", name, CacheNames)
+		}
+		if o.TTL < 0 || o.Size < 0 {
+			return fmt.Errorf("cache override %q: ttl and size must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// Settings returns the effective settings of the named cache, its override takes precedence over the defaults.
+func (c *Cache) Settings(name string) CacheSettings {
+	var s CacheSettings
+	switch name {
+	case CacheAuth:
+		s.TTL = c.APIKeyTTL
+	case CacheEnrollKey:
+		s.TTL = c.EnrollKeyTTL
+	case CachePolicyDoc:
+		s.TTL = defaultPolicyDocTTL
+	case CacheNotFound:
+		s.TTL = defaultNotFoundTTL
+	}
+	if o, ok := c.Overrides[name]; ok {
+		if o.TTL > 0 {
+			s.TTL = o.TTL
+		}
+		if o.Size > 0 {
+			s.Size = o.Size
+		}
+	}
+	return s
+}
+
 // LoadLimits loads envLimits for any attribute that is not defined in Cache
 func (c *Cache) LoadLimits(limits *envLimits) {
 	l := limits.Cache
@@ -55,6 +137,9 @@ func (c *Cache) LoadLimits(limits *envLimits) {
 	if c.APIKeyJitter == 0 {
 		c.APIKeyJitter = defaultAPIKeyJitter
 	}
+	if c.EnrollIdempotencyTTL == 0 {
+		c.EnrollIdempotencyTTL = defaultEnrollIdempotencyTTL
+	}
 }
 
 // CopyCache returns a copy of the config's Cache settings
@@ -68,6 +153,10 @@ func CopyCache(cfg *Config) Cache {
 		ArtifactTTL:  ccfg.ArtifactTTL,
 		APIKeyTTL:    ccfg.APIKeyTTL,
 		APIKeyJitter: ccfg.APIKeyJitter,
+
+		EnrollIdempotencyTTL: ccfg.EnrollIdempotencyTTL,
+
+		Overrides: maps.Clone(ccfg.Overrides),
 	}
 }
 
@@ -80,4 +169,11 @@ func (c *Cache) MarshalZerologObject(e *zerolog.Event) {
 	e.Dur("artifactTTL", c.ArtifactTTL)
 	e.Dur("apiKeyTTL", c.APIKeyTTL)
 	e.Dur("apiKeyJitter", c.APIKeyJitter)
+	e.Dur("enrollIdempotencyTTL", c.EnrollIdempotencyTTL)
+	for _, name := range CacheNames {
+		if _, ok := c.Overrides[name]; ok {
+			s := c.Settings(name)
+			e.Dict(name, zerolog.Dict().Dur("ttl", s.TTL).Int64("size", s.Size))
+		}
+	}
 }
@@ -0,0 +1,49 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheSettings(t *testing.T) {
+	c := Cache{
+		MaxCost:      1000,
+		APIKeyTTL:    15 * time.Minute,
+		EnrollKeyTTL: time.Minute,
+		Overrides: map[string]CacheOverride{
+			CacheEnrollKey:      {TTL: 5 * time.Minute, Size: 4096},
+			CacheAuth:           {Size: 2048},
+			CacheStatusESHealth: {TTL: time.Second},
+		},
+	}
+
+	// the overrides take precedence over the defaults, a zero value keeps the default
+	require.Equal(t, CacheSettings{TTL: 5 * time.Minute, Size: 4096}, c.Settings(CacheEnrollKey))
+	require.Equal(t, CacheSettings{TTL: 15 * time.Minute, Size: 2048}, c.Settings(CacheAuth))
+	require.Equal(t, CacheSettings{TTL: time.Second}, c.Settings(CacheStatusESHealth))
+	require.Equal(t, CacheSettings{TTL: defaultPolicyDocTTL}, c.Settings(CachePolicyDoc))
+	require.Equal(t, CacheSettings{TTL: defaultNotFoundTTL}, c.Settings(CacheNotFound))
+
+	c.Overrides = nil
+	require.Equal(t, CacheSettings{TTL: time.Minute}, c.Settings(CacheEnrollKey))
+	require.Equal(t, CacheSettings{}, c.Settings(CacheStatusESHealth))
+}
+
+func TestCacheValidate(t *testing.T) {
+	c := Cache{Overrides: map[string]CacheOverride{CachePolicyDoc: {TTL: time.Second, Size: 10}}}
+	require.NoError(t, c.Validate())
+
+	c.Overrides["artifacts"] = CacheOverride{TTL: time.Second}
+	require.ErrorContains(t, c.Validate(), `unknown cache "artifacts"`)
+
+	c.Overrides = map[string]CacheOverride{CacheAuth: {TTL: -time.Second}}
+	require.ErrorContains(t, c.Validate(), "must not be negative")
+}
@@ -0,0 +1,36 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import "time"
+
+const (
+	defaultCheckinDelayInterval  = 5 * time.Second
+	defaultCheckinDelayMax       = 5 * time.Minute
+	defaultCheckinDelayThreshold = 0.8
+)
+
+// CheckinDelay is the configuration of the delay the checkin responses recommend to the agents before their next
+// checkin, raised with the load of the instance to spread the checkins of the agents out.
+type CheckinDelay struct {
+	Enabled bool `config:"enabled"`
+	// Interval is how often the delay is computed from the load.
+	Interval time.Duration `config:"interval"`
+	// Min is the floor of the delay, recommended while the load is under Threshold.
+	Min time.Duration `config:"min"`
+	// Max is the ceiling of the delay, recommended once the instance is at capacity.
+	Max time.Duration `config:"max"`
+	// Threshold is the ratio of the connected agents to the capacity of the instance, limits.max_agents or
+	// limits.max_connections, above which the delay rises from Min to Max.
+	Threshold float64 `config:"threshold"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *CheckinDelay) InitDefaults() {
+	c.Enabled = true
+	c.Interval = defaultCheckinDelayInterval
+	c.Max = defaultCheckinDelayMax
+	c.Threshold = defaultCheckinDelayThreshold
+}
@@ -0,0 +1,28 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import "time"
+
+const (
+	defaultClockSkewInterval  = time.Minute
+	defaultClockSkewThreshold = 5 * time.Second
+)
+
+// ClockSkew is the configuration of the time source of the leases, sweeps and timeouts, that compare the time of the
+// instance with the timestamps written by the other instances. The offset of the local clock to the elasticsearch
+// clock is sampled every Interval, a warning is logged when it exceeds Threshold.
+type ClockSkew struct {
+	Enabled   bool          `config:"enabled"`
+	Interval  time.Duration `config:"interval"`
+	Threshold time.Duration `config:"threshold"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *ClockSkew) InitDefaults() {
+	c.Enabled = true
+	c.Interval = defaultClockSkewInterval
+	c.Threshold = defaultClockSkewThreshold
+}
@@ -7,6 +7,7 @@ package config
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"strings"
 	"sync"
@@ -156,6 +157,10 @@ func redactOutput(cfg *Config) Output {
 		redacted.Elasticsearch.ServiceToken = kRedacted
 	}
 
+	if redacted.Elasticsearch.Password != "" {
+		redacted.Elasticsearch.Password = kRedacted
+	}
+
 	if redacted.Elasticsearch.TLS != nil {
 		newTLS := *redacted.Elasticsearch.TLS
 
@@ -185,14 +190,19 @@ func redactHeaders(headers map[string]string) map[string]string {
 	redactedHeaders := make(map[string]string)
 	for k, v := range headers {
 		redactedHeaders[k] = v
-		lk := strings.ToLower(k)
-		if strings.Contains(lk, "auth") || strings.Contains(lk, "token") || strings.Contains(lk, "key") || strings.Contains(lk, "bearer") {
+		if isSensitiveHeader(k) {
 			redactedHeaders[k] = kRedacted
 		}
 	}
 	return redactedHeaders
 }
 
+// isSensitiveHeader returns true if the header name suggests its value is a credential.
+func isSensitiveHeader(name string) bool {
+	lk := strings.ToLower(name)
+	return strings.Contains(lk, "auth") || strings.Contains(lk, "token") || strings.Contains(lk, "key") || strings.Contains(lk, "bearer")
+}
+
 func redactServer(cfg *Config) Server {
 	redacted := cfg.Inputs[0].Server
 
@@ -221,8 +231,9 @@ func redactServer(cfg *Config) Server {
 		policyTokens := make([]PolicyToken, len(redacted.StaticPolicyTokens.PolicyTokens))
 		for i := range redacted.StaticPolicyTokens.PolicyTokens {
 			policyTokens[i] = PolicyToken{
-				TokenKey: kRedacted,
-				PolicyID: redacted.StaticPolicyTokens.PolicyTokens[i].PolicyID,
+				TokenKey:     kRedacted,
+				TokenKeyPath: redacted.StaticPolicyTokens.PolicyTokens[i].TokenKeyPath,
+				PolicyID:     redacted.StaticPolicyTokens.PolicyTokens[i].PolicyID,
 			}
 		}
 		redacted.StaticPolicyTokens.PolicyTokens = policyTokens
@@ -236,15 +247,36 @@ func (c *Config) Redact() *Config {
 	redacted := &Config{
 		Fleet:   c.Fleet,
 		Output:  c.Output,
-		Inputs:  make([]Input, 1),
 		Logging: c.Logging,
 		HTTP:    c.HTTP,
 	}
-	redacted.Inputs[0].Server = redactServer(c)
+	if redacted.HTTP.Auth.Token != "" {
+		redacted.HTTP.Auth.Token = kRedacted
+	}
+	if len(c.Inputs) > 0 {
+		redacted.Inputs = make([]Input, 1)
+		redacted.Inputs[0].Server = redactServer(c)
+	}
 	redacted.Output = redactOutput(c)
 	return redacted
 }
 
+// String returns the configuration as JSON with all sensitive attributes redacted.
+func (c *Config) String() string {
+	p, err := json.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(p)
+}
+
+// MarshalJSON encodes the configuration with all sensitive attributes redacted.
+func (c *Config) MarshalJSON() ([]byte, error) {
+	// plainConfig does not implement json.Marshaler
+	type plainConfig Config
+	return json.Marshal((*plainConfig)(c.Redact()))
+}
+
 func checkDeprecatedOptions(deprecatedOpts map[string]string, c *ucfg.Config) {
 	for opt, message := range deprecatedOpts {
 		if c.HasField(opt) {
@@ -8,6 +8,7 @@ package config
 
 import (
 	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 
@@ -43,6 +44,7 @@ func TestConfig(t *testing.T) {
 							FetchSize:          defaultFetchSize,
 							PollTimeout:        defaultPollTimeout,
 							PolicyDebounceTime: defaultPolicyDebounceTime,
+							CheckpointInterval: defaultCheckpointInterval,
 						},
 					},
 				},
@@ -72,6 +74,7 @@ func TestConfig(t *testing.T) {
 							FetchSize:          defaultFetchSize,
 							PollTimeout:        defaultPollTimeout,
 							PolicyDebounceTime: defaultPolicyDebounceTime,
+							CheckpointInterval: defaultCheckpointInterval,
 						},
 					},
 				},
@@ -94,6 +97,7 @@ func TestConfig(t *testing.T) {
 							FetchSize:          defaultFetchSize,
 							PollTimeout:        defaultPollTimeout,
 							PolicyDebounceTime: defaultPolicyDebounceTime,
+							CheckpointInterval: defaultCheckpointInterval,
 						},
 					},
 				},
@@ -134,17 +138,30 @@ func TestConfig(t *testing.T) {
 							Limits:            generateServerLimits(0),
 							Bulk:              defaultServerBulk(),
 							GC:                defaultServerGC(),
+							Retention:         defaultRetention(),
 							PGP: PGP{
 								UpstreamURL: defaultPGPUpstreamURL,
 								Dir:         filepath.Join(retrieveExecutableDir(), defaultPGPDirectoryName),
 							},
-							PDKDF2: defaultPBKDF2(),
+							PDKDF2:             defaultPBKDF2(),
+							StandaloneSetup:    defaultStandaloneSetup(),
+							DeliveryTracking:   defaultDeliveryTracking(),
+							Heartbeat:          defaultHeartbeat(),
+							Budgets:            defaultBudgets(),
+							Actions:            defaultActions(),
+							Draining:           defaultDraining(),
+							ResourceUsage:      defaultResourceUsage(),
+							StatusDamping:      defaultStatusDamping(),
+							ConnectionMetadata: defaultConnectionMetadata(),
+							CheckinDelay:       defaultCheckinDelay(),
+							ClockSkew:          defaultClockSkew(),
 						},
 						Cache: generateCache(0),
 						Monitor: Monitor{
 							FetchSize:          defaultFetchSize,
 							PollTimeout:        defaultPollTimeout,
 							PolicyDebounceTime: defaultPolicyDebounceTime,
+							CheckpointInterval: defaultCheckpointInterval,
 						},
 					},
 				},
@@ -220,6 +237,7 @@ func TestConfig(t *testing.T) {
 						FetchSize:          defaultFetchSize,
 						PollTimeout:        defaultPollTimeout,
 						PolicyDebounceTime: defaultPolicyDebounceTime,
+						CheckpointInterval: defaultCheckpointInterval,
 					},
 				},
 			},
@@ -309,7 +327,24 @@ func TestLoadServerLimits(t *testing.T) {
 		assert.NotZero(t, c.Inputs[0].Server.Limits.CheckinLimit.Burst)
 		assert.NotZero(t, c.Inputs[0].Cache.ActionTTL)
 	})
-
+	t.Run("concurrency ceiling is derived from the agent count", func(t *testing.T) {
+		l := testlog.SetLogger(t)
+		load := func(maxAgents, concurrency int) int {
+			c := &Config{Inputs: []Input{{}}}
+			c.Inputs[0].Server.InitDefaults()
+			c.Inputs[0].Server.Limits.MaxAgents = maxAgents
+			c.Inputs[0].Server.Limits.Concurrency.Max = concurrency
+			require.NoError(t, c.LoadServerLimits(&l))
+			return c.Inputs[0].Server.Limits.Concurrency.Max
+		}
+		perCPU := concurrencyPerCPU * runtime.GOMAXPROCS(0)
+		// the checkin quota of the ceiling fits the expected agents
+		assert.Equal(t, 3125+perCPU, load(2500, 0))
+		assert.Equal(t, 50001+perCPU, load(40001, 0))
+		// the ceiling is disabled when the number of agents is not bounded
+		assert.Zero(t, load(-1, 0))
+		assert.Equal(t, 500, load(2500, 500))
+	})
 }
 
 func TestConfigRedact(t *testing.T) {
@@ -452,6 +487,31 @@ func TestConfigRedact(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Redact output password",
+			inputCfg: &Config{
+				Inputs: []Input{{}},
+				Output: Output{
+					Elasticsearch: Elasticsearch{
+						Protocol: "https",
+						Hosts:    []string{"localhost:9200"},
+						Username: "fleet",
+						Password: "secret",
+					},
+				},
+			},
+			redactedCfg: &Config{
+				Inputs: []Input{{}},
+				Output: Output{
+					Elasticsearch: Elasticsearch{
+						Protocol: "https",
+						Hosts:    []string{"localhost:9200"},
+						Username: "fleet",
+						Password: kRedacted,
+					},
+				},
+			},
+		},
 		{
 			name: "Redact proxy authorization output header",
 			inputCfg: &Config{
@@ -537,6 +597,7 @@ func generateCache(maxAgents int) Cache {
 func generateServerLimits(maxAgents int) ServerLimits {
 	log := zerolog.Nop()
 	var d ServerLimits
+	d.InitDefaults()
 	d.MaxAgents = maxAgents
 	d.LoadLimits(loadLimits(&log, maxAgents))
 	return d
@@ -554,12 +615,84 @@ func defaultServerGC() GC {
 	return d
 }
 
+func defaultRetention() Retention {
+	var d Retention
+	d.InitDefaults()
+	return d
+}
+
+func defaultActions() Actions {
+	var d Actions
+	d.InitDefaults()
+	return d
+}
+
+func defaultDraining() Draining {
+	var d Draining
+	d.InitDefaults()
+	return d
+}
+
+func defaultResourceUsage() ResourceUsage {
+	var d ResourceUsage
+	d.InitDefaults()
+	return d
+}
+
+func defaultStatusDamping() StatusDamping {
+	var d StatusDamping
+	d.InitDefaults()
+	return d
+}
+
+func defaultConnectionMetadata() ConnectionMetadata {
+	var d ConnectionMetadata
+	d.InitDefaults()
+	return d
+}
+
+func defaultCheckinDelay() CheckinDelay {
+	var d CheckinDelay
+	d.InitDefaults()
+	return d
+}
+
+func defaultClockSkew() ClockSkew {
+	var d ClockSkew
+	d.InitDefaults()
+	return d
+}
+
 func defaultPBKDF2() PBKDF2 {
 	var d PBKDF2
 	d.InitDefaults()
 	return d
 }
 
+func defaultDeliveryTracking() DeliveryTracking {
+	var d DeliveryTracking
+	d.InitDefaults()
+	return d
+}
+
+func defaultHeartbeat() Heartbeat {
+	var d Heartbeat
+	d.InitDefaults()
+	return d
+}
+
+func defaultBudgets() Budgets {
+	var d Budgets
+	d.InitDefaults()
+	return d
+}
+
+func defaultStandaloneSetup() StandaloneSetup {
+	var d StandaloneSetup
+	d.InitDefaults()
+	return d
+}
+
 func defaultLogging() Logging {
 	var d Logging
 	d.InitDefaults()
@@ -587,9 +720,13 @@ func defaultElastic() Elasticsearch {
 		ServiceToken:     "test-token",
 		Hosts:            []string{"localhost:9200"},
 		MaxRetries:       3,
+		Backoff:          ESBackoff{Init: 500 * time.Millisecond, Max: 10 * time.Second},
 		MaxConnPerHost:   128,
+		MaxIdleConns:     100,
 		MaxContentLength: 104857600,
 		Timeout:          90 * time.Second,
+		Verification:     ESVerification{MinVersion: "8.0.0"},
+		MaxStartupWait:   5 * time.Minute,
 	}
 }
 
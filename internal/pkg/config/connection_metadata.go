@@ -0,0 +1,20 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+// ConnectionMetadata is the configuration of the connection metadata recorded on the agent documents on checkin.
+type ConnectionMetadata struct {
+	// Enabled records the TLS version, cipher suite and ALPN protocol of the connection the agents check in with.
+	Enabled bool `config:"enabled"`
+	// RemoteAddress records the IP address of the agents too, resolved through the trusted proxies.
+	// It can be disabled for the deployments that must not store the addresses of the hosts.
+	RemoteAddress bool `config:"remote_address"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *ConnectionMetadata) InitDefaults() {
+	c.Enabled = true
+	c.RemoteAddress = true
+}
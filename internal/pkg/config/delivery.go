@@ -0,0 +1,29 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import "time"
+
+const (
+	defaultDeliveryMaxRedeliveries = 5
+	defaultDeliveryFlushInterval   = 10 * time.Second
+)
+
+// DeliveryTracking is the configuration for recording when actions are delivered to agents.
+type DeliveryTracking struct {
+	// Enabled records a delivery marker in the action results index each time an action is included in a checkin response.
+	Enabled bool `config:"enabled"`
+	// MaxRedeliveries is the number of times an action may be delivered again to an agent before the delivery is marked as stuck.
+	MaxRedeliveries int `config:"max_redeliveries"`
+	// FlushInterval is how often pending delivery markers are written.
+	FlushInterval time.Duration `config:"flush_interval"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *DeliveryTracking) InitDefaults() {
+	c.Enabled = false
+	c.MaxRedeliveries = defaultDeliveryMaxRedeliveries
+	c.FlushInterval = defaultDeliveryFlushInterval
+}
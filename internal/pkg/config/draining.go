@@ -0,0 +1,28 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import "time"
+
+const (
+	defaultDrainingTimeout    = 10 * time.Minute
+	defaultDrainingRetryAfter = time.Minute
+)
+
+// Draining is the configuration of the draining mode, started with a SIGUSR1 or a POST on the /drain endpoint of the
+// monitoring listener to move the agents to the other instances before a shutdown.
+type Draining struct {
+	// Timeout is the time a draining instance waits to be shut down, it then shuts down by itself. A zero Timeout waits forever.
+	Timeout time.Duration `config:"timeout"`
+	// RetryAfter is the backoff hinted in the Retry-After header of the checkin responses and of the rejected enrollments
+	// while draining, the header is jittered between half and all of it.
+	RetryAfter time.Duration `config:"retry_after"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *Draining) InitDefaults() {
+	c.Timeout = defaultDrainingTimeout
+	c.RetryAfter = defaultDrainingRetryAfter
+}
@@ -0,0 +1,282 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/elastic/go-ucfg"
+)
+
+// EnvOverridePrefix is the prefix of the environment variables that override configuration keys.
+// The remainder of the variable name is the upper-cased key path with dots replaced by underscores,
+// list entries are selected by index, for example:
+//
+//	FLEET_SERVER_OUTPUT_ELASTICSEARCH_HOSTS=es1:9200,es2:9200
+//	FLEET_SERVER_INPUTS_0_SERVER_PORT=8221
+const EnvOverridePrefix = "FLEET_SERVER_"
+
+// ErrEnvNotSet is returned when a configuration file references an environment variable that is not set and has no default.
+var ErrEnvNotSet = errors.New("environment variable is not set")
+
+// expandedOptions are the options used for configuration that has already been expanded.
+// Variable expansion is disabled so expanded values, and escaped dollars, are used literally.
+var expandedOptions = []ucfg.Option{
+	ucfg.PathSep("."),
+	ucfg.FieldReplaceValues("inputs"),
+}
+
+// ReadFile reads the configuration file at path.
+// References to environment variables in string values are expanded using the ${VAR} or ${VAR:default} syntax, $$ is a literal dollar.
+// A value that only consists of a reference is parsed as YAML, so it may hold a number, boolean or list.
+// Keys are then overridden by environment variables with the EnvOverridePrefix.
+func ReadFile(path string) (*ucfg.Config, error) {
+	p, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return readEnvConfig(p, os.LookupEnv, os.Environ())
+}
+
+func readEnvConfig(p []byte, lookup func(string) (string, bool), environ []string) (*ucfg.Config, error) {
+	var data map[string]interface{}
+	if err := yaml.Unmarshal(p, &data); err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	expanded, err := expandEnv("", data, lookup)
+	if err != nil {
+		return nil, err
+	}
+	data = expanded.(map[string]interface{}) //nolint:errcheck // expandEnv retains the value type of maps
+	if err := applyEnvOverrides(data, environ); err != nil {
+		return nil, err
+	}
+	return ucfg.NewFrom(data, expandedOptions...)
+}
+
+// expandEnv replaces environment variable references in all string values of v.
+func expandEnv(key string, v interface{}, lookup func(string) (string, bool)) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			expanded, err := expandEnv(joinKey(key, k), val, lookup)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = expanded
+		}
+		return v, nil
+	case []interface{}:
+		for i, val := range v {
+			expanded, err := expandEnv(joinKey(key, strconv.Itoa(i)), val, lookup)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = expanded
+		}
+		return v, nil
+	case string:
+		s, whole, err := expandString(v, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("unable to expand %s: %w", key, err)
+		}
+		if whole {
+			return parseEnvValue(s), nil
+		}
+		return s, nil
+	default:
+		return v, nil
+	}
+}
+
+// expandString expands the references in s.
+// whole is true if s consists of a single reference.
+func expandString(s string, lookup func(string) (string, bool)) (string, bool, error) {
+	var b strings.Builder
+	refs := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", false, fmt.Errorf("unterminated reference in %q", s)
+			}
+			name, def, hasDef := strings.Cut(s[i+2:i+end], ":")
+			if name == "" {
+				return "", false, fmt.Errorf("empty reference in %q", s)
+			}
+			val, ok := lookup(name)
+			if !ok {
+				if !hasDef {
+					return "", false, fmt.Errorf("%w: %s", ErrEnvNotSet, name)
+				}
+				val = def
+			}
+			b.WriteString(val)
+			refs++
+			i += end
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	whole := refs == 1 && strings.HasPrefix(s, "${") && strings.IndexByte(s, '}') == len(s)-1
+	return b.String(), whole, nil
+}
+
+// parseEnvValue parses a value taken from the environment as YAML.
+// Values that can not be parsed, or parse to a map, are used as strings.
+func parseEnvValue(s string) interface{} {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return s
+	}
+	switch v.(type) {
+	case nil, map[string]interface{}:
+		return s
+	}
+	return v
+}
+
+// applyEnvOverrides sets the keys named by the environment variables with the EnvOverridePrefix in data.
+// Variables that do not name a configuration key are ignored.
+func applyEnvOverrides(data map[string]interface{}, environ []string) error {
+	sort.Strings(environ)
+	for _, kv := range environ {
+		name, val, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, EnvOverridePrefix) {
+			continue
+		}
+		path, typ, ok := resolveEnvKey(reflect.TypeOf(Config{}), strings.Split(strings.TrimPrefix(name, EnvOverridePrefix), "_"))
+		if !ok {
+			continue
+		}
+		var v interface{}
+		if typ.Kind() == reflect.Slice && !strings.HasPrefix(strings.TrimSpace(val), "[") {
+			items := strings.Split(val, ",")
+			list := make([]interface{}, 0, len(items))
+			for _, item := range items {
+				list = append(list, strings.TrimSpace(item))
+			}
+			v = list
+		} else {
+			v = parseEnvValue(val)
+		}
+		if _, err := setKey(data, path, v); err != nil {
+			return fmt.Errorf("unable to apply %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// resolveEnvKey matches the upper-cased parts of an environment variable name against the config tags of t.
+// It returns the key path and the type of the field it refers to.
+func resolveEnvKey(t reflect.Type, parts []string) ([]string, reflect.Type, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if len(parts) == 0 {
+		return nil, t, true
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		if _, err := strconv.Atoi(parts[0]); err != nil {
+			return nil, nil, false
+		}
+		path, typ, ok := resolveEnvKey(t.Elem(), parts[1:])
+		if !ok {
+			return nil, nil, false
+		}
+		return append([]string{parts[0]}, path...), typ, true
+	case reflect.Struct:
+	default:
+		return nil, nil, false
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("config"), ",")
+		if strings.Contains(opts, "inline") {
+			if path, typ, ok := resolveEnvKey(f.Type, parts); ok {
+				return path, typ, true
+			}
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		// a tag may contain underscores so it can span several parts of the name
+		tagParts := strings.Split(strings.ToUpper(name), "_")
+		if len(tagParts) > len(parts) || strings.Join(parts[:len(tagParts)], "_") != strings.Join(tagParts, "_") {
+			continue
+		}
+		if path, typ, ok := resolveEnvKey(f.Type, parts[len(tagParts):]); ok {
+			return append([]string{name}, path...), typ, true
+		}
+	}
+	return nil, nil, false
+}
+
+// setKey sets the value at path in node, creating intermediate objects and list entries, and returns the updated node.
+func setKey(node interface{}, path []string, v interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	if idx, err := strconv.Atoi(path[0]); err == nil {
+		l, ok := node.([]interface{})
+		if !ok && node != nil {
+			return nil, errors.New("configuration value is not a list")
+		}
+		for len(l) <= idx {
+			l = append(l, nil)
+		}
+		next, err := setKey(l[idx], path[1:], v)
+		if err != nil {
+			return nil, err
+		}
+		l[idx] = next
+		return l, nil
+	}
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		if node != nil {
+			return nil, errors.New("configuration value is not an object")
+		}
+		m = make(map[string]interface{})
+	}
+	next, err := setKey(m[path[0]], path[1:], v)
+	if err != nil {
+		return nil, err
+	}
+	m[path[0]] = next
+	return m, nil
+}
+
+func joinKey(key, k string) string {
+	if key == "" {
+		return k
+	}
+	return key + "." + k
+}
@@ -26,6 +26,10 @@ const (
 
 	defaultMaxConnections = 0 // no limit
 
+	defaultMaxActionTargets = 10000
+
+	defaultMaxBulkEnrollAgents = 5000
+
 	defaultActionInterval = 0 // no throttle
 	defaultActionBurst    = 5
 
@@ -86,6 +90,41 @@ const (
 	defaultAuditUnenrollBurst    = 50
 	defaultAuditUnenrollMax      = 100
 	defaultAuditUnenrollMaxBody  = 1024
+
+	defaultCreateActionsInterval = time.Millisecond * 100
+	defaultCreateActionsBurst    = 5
+	defaultCreateActionsMax      = 10
+	defaultCreateActionsMaxBody  = 1024 * 1024
+
+	defaultAgentTagsInterval = time.Millisecond * 10
+	defaultAgentTagsBurst    = 10
+	defaultAgentTagsMax      = 50
+	defaultAgentTagsMaxBody  = 64 * 1024
+
+	defaultActionResultsInterval = time.Millisecond * 10
+	defaultActionResultsBurst    = 10
+	defaultActionResultsMax      = 50
+	defaultActionResultsMaxBody  = 0
+
+	defaultReassignAgentsInterval = time.Millisecond * 100
+	defaultReassignAgentsBurst    = 5
+	defaultReassignAgentsMax      = 10
+	defaultReassignAgentsMaxBody  = 1024 * 1024
+
+	defaultPolicyFetchInterval = time.Millisecond * 10
+	defaultPolicyFetchBurst    = 10
+	defaultPolicyFetchMax      = 50
+	defaultPolicyFetchMaxBody  = 0
+
+	defaultPolicyRolloutInterval = time.Millisecond * 100
+	defaultPolicyRolloutBurst    = 5
+	defaultPolicyRolloutMax      = 10
+	defaultPolicyRolloutMaxBody  = 0
+
+	defaultBulkEnrollInterval = time.Second
+	defaultBulkEnrollBurst    = 2
+	defaultBulkEnrollMax      = 4
+	defaultBulkEnrollMaxBody  = 32 * 1024 * 1024
 )
 
 type valueRange struct {
@@ -141,27 +180,40 @@ type limit struct {
 }
 
 type serverLimitDefaults struct {
-	PolicyThrottle time.Duration `config:"policy_throttle"` // deprecated: replaced by policy_limit
-	MaxConnections int           `config:"max_connections"`
-
-	ActionLimit        limit `config:"action_limit"`
-	PolicyLimit        limit `config:"policy_limit"`
-	CheckinLimit       limit `config:"checkin_limit"`
-	ArtifactLimit      limit `config:"artifact_limit"`
-	EnrollLimit        limit `config:"enroll_limit"`
-	AckLimit           limit `config:"ack_limit"`
-	StatusLimit        limit `config:"status_limit"`
-	UploadStartLimit   limit `config:"upload_start_limit"`
-	UploadEndLimit     limit `config:"upload_end_limit"`
-	UploadChunkLimit   limit `config:"upload_chunk_limit"`
-	DeliverFileLimit   limit `config:"file_delivery_limit"`
-	GetPGPKeyLimit     limit `config:"pgp_retrieval_limit"`
-	AuditUnenrollLimit limit `config:"audit_unenroll_limit"`
+	PolicyThrottle   time.Duration `config:"policy_throttle"` // deprecated: replaced by policy_limit
+	MaxConnections   int           `config:"max_connections"`
+	MaxActionTargets int           `config:"max_action_targets"`
+
+	MaxBulkEnrollAgents int `config:"max_bulk_enroll_agents"`
+
+	ActionLimit         limit `config:"action_limit"`
+	PolicyLimit         limit `config:"policy_limit"`
+	CheckinLimit        limit `config:"checkin_limit"`
+	ArtifactLimit       limit `config:"artifact_limit"`
+	EnrollLimit         limit `config:"enroll_limit"`
+	AckLimit            limit `config:"ack_limit"`
+	StatusLimit         limit `config:"status_limit"`
+	UploadStartLimit    limit `config:"upload_start_limit"`
+	UploadEndLimit      limit `config:"upload_end_limit"`
+	UploadChunkLimit    limit `config:"upload_chunk_limit"`
+	DeliverFileLimit    limit `config:"file_delivery_limit"`
+	GetPGPKeyLimit      limit `config:"pgp_retrieval_limit"`
+	AuditUnenrollLimit  limit `config:"audit_unenroll_limit"`
+	CreateActionsLimit  limit `config:"create_actions_limit"`
+	AgentTagsLimit      limit `config:"agent_tags_limit"`
+	ActionResultsLimit  limit `config:"action_results_limit"`
+	ReassignAgentsLimit limit `config:"reassign_agents_limit"`
+	PolicyFetchLimit    limit `config:"policy_fetch_limit"`
+	PolicyRolloutLimit  limit `config:"policy_rollout_limit"`
+	BulkEnrollLimit     limit `config:"bulk_enroll_limit"`
 }
 
 func defaultserverLimitDefaults() *serverLimitDefaults {
 	return &serverLimitDefaults{
-		MaxConnections: defaultMaxConnections,
+		MaxConnections:   defaultMaxConnections,
+		MaxActionTargets: defaultMaxActionTargets,
+
+		MaxBulkEnrollAgents: defaultMaxBulkEnrollAgents,
 		ActionLimit: limit{
 			Interval: defaultActionInterval,
 			Burst:    defaultActionBurst,
@@ -236,6 +288,48 @@ func defaultserverLimitDefaults() *serverLimitDefaults {
 			Max:      defaultAuditUnenrollMax,
 			MaxBody:  defaultAuditUnenrollMaxBody,
 		},
+		CreateActionsLimit: limit{
+			Interval: defaultCreateActionsInterval,
+			Burst:    defaultCreateActionsBurst,
+			Max:      defaultCreateActionsMax,
+			MaxBody:  defaultCreateActionsMaxBody,
+		},
+		AgentTagsLimit: limit{
+			Interval: defaultAgentTagsInterval,
+			Burst:    defaultAgentTagsBurst,
+			Max:      defaultAgentTagsMax,
+			MaxBody:  defaultAgentTagsMaxBody,
+		},
+		ActionResultsLimit: limit{
+			Interval: defaultActionResultsInterval,
+			Burst:    defaultActionResultsBurst,
+			Max:      defaultActionResultsMax,
+			MaxBody:  defaultActionResultsMaxBody,
+		},
+		ReassignAgentsLimit: limit{
+			Interval: defaultReassignAgentsInterval,
+			Burst:    defaultReassignAgentsBurst,
+			Max:      defaultReassignAgentsMax,
+			MaxBody:  defaultReassignAgentsMaxBody,
+		},
+		PolicyFetchLimit: limit{
+			Interval: defaultPolicyFetchInterval,
+			Burst:    defaultPolicyFetchBurst,
+			Max:      defaultPolicyFetchMax,
+			MaxBody:  defaultPolicyFetchMaxBody,
+		},
+		PolicyRolloutLimit: limit{
+			Interval: defaultPolicyRolloutInterval,
+			Burst:    defaultPolicyRolloutBurst,
+			Max:      defaultPolicyRolloutMax,
+			MaxBody:  defaultPolicyRolloutMaxBody,
+		},
+		BulkEnrollLimit: limit{
+			Interval: defaultBulkEnrollInterval,
+			Burst:    defaultBulkEnrollBurst,
+			Max:      defaultBulkEnrollMax,
+			MaxBody:  defaultBulkEnrollMaxBody,
+		},
 	}
 }
 
@@ -0,0 +1,140 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const envTestConfig = `
+output:
+  elasticsearch:
+    hosts: ["${ES_HOST:localhost:9200}", "${ES_HOST_2}"]
+    service_token: "${ES_TOKEN}"
+    proxy_url: "http://proxy/$${NOT_A_VAR}/$$"
+    max_retries: ${ES_RETRIES:3}
+fleet:
+  agent:
+    id: agent-id
+inputs:
+  - type: fleet-server
+    server:
+      port: ${PORT}
+      timeouts:
+        checkin_long_poll: ${LONG_POLL:5m}
+`
+
+func envLookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func loadEnvTestConfig(t *testing.T, data string, env map[string]string, environ ...string) (*Config, error) {
+	t.Helper()
+	c, err := readEnvConfig([]byte(data), envLookup(env), environ)
+	if err != nil {
+		return nil, err
+	}
+	return FromConfig(c)
+}
+
+func TestReadEnvConfigExpansion(t *testing.T) {
+	cfg, err := loadEnvTestConfig(t, envTestConfig, map[string]string{
+		"ES_HOST_2": "es2:9200",
+		"ES_TOKEN":  "secret-token",
+		"PORT":      "8221",
+	})
+	require.NoError(t, err)
+
+	es := cfg.Output.Elasticsearch
+	require.Equal(t, []string{"localhost:9200", "es2:9200"}, es.Hosts)
+	require.Equal(t, "secret-token", es.ServiceToken)
+	require.Equal(t, "http://proxy/${NOT_A_VAR}/$", es.ProxyURL)
+	require.Equal(t, 3, es.MaxRetries)
+	require.Equal(t, uint16(8221), cfg.Inputs[0].Server.Port)
+	require.Equal(t, 5*time.Minute, cfg.Inputs[0].Server.Timeouts.CheckinLongPoll)
+}
+
+func TestReadEnvConfigListValue(t *testing.T) {
+	cfg, err := loadEnvTestConfig(t, `
+output.elasticsearch.hosts: ${ES_HOSTS}
+output.elasticsearch.service_token: token
+inputs:
+  - type: fleet-server
+`, map[string]string{"ES_HOSTS": `["es1:9200", "es2:9200"]`})
+	require.NoError(t, err)
+	require.Equal(t, []string{"es1:9200", "es2:9200"}, cfg.Output.Elasticsearch.Hosts)
+}
+
+func TestReadEnvConfigMissingVariable(t *testing.T) {
+	_, err := loadEnvTestConfig(t, envTestConfig, map[string]string{"ES_HOST_2": "es2:9200", "PORT": "8221"})
+	require.ErrorIs(t, err, ErrEnvNotSet)
+	require.ErrorContains(t, err, "ES_TOKEN")
+	require.ErrorContains(t, err, "output.elasticsearch.service_token")
+}
+
+func TestReadEnvConfigOverrides(t *testing.T) {
+	env := map[string]string{"ES_HOST_2": "es2:9200", "ES_TOKEN": "secret-token", "PORT": "8221"}
+	cfg, err := loadEnvTestConfig(t, envTestConfig, env,
+		"FLEET_SERVER_OUTPUT_ELASTICSEARCH_HOSTS=es3:9200, es4:9200",
+		"FLEET_SERVER_OUTPUT_ELASTICSEARCH_SERVICE_TOKEN_PATH=/token",
+		"FLEET_SERVER_OUTPUT_ELASTICSEARCH_MAX_RETRIES=7",
+		"FLEET_SERVER_INPUTS_0_SERVER_PORT=9000",
+		"FLEET_SERVER_INPUTS_0_SERVER_TIMEOUTS_CHECKIN_LONG_POLL=1m",
+		"FLEET_SERVER_LOGGING_LEVEL=debug",
+		// not configuration keys
+		"FLEET_SERVER_ENABLE=1",
+		"FLEET_SERVER_ELASTICSEARCH_HOST=http://es:9200",
+		"OTHER_OUTPUT_ELASTICSEARCH_HOSTS=ignored",
+	)
+	require.NoError(t, err)
+
+	es := cfg.Output.Elasticsearch
+	require.Equal(t, []string{"es3:9200", "es4:9200"}, es.Hosts)
+	require.Equal(t, "secret-token", es.ServiceToken)
+	require.Equal(t, "/token", es.ServiceTokenPath)
+	require.Equal(t, 7, es.MaxRetries)
+	require.Equal(t, uint16(9000), cfg.Inputs[0].Server.Port)
+	require.Equal(t, time.Minute, cfg.Inputs[0].Server.Timeouts.CheckinLongPoll)
+	require.Equal(t, "debug", cfg.Logging.Level)
+}
+
+func TestReadEnvConfigOverrideListSyntax(t *testing.T) {
+	cfg, err := loadEnvTestConfig(t, `
+output.elasticsearch.service_token: token
+inputs:
+  - type: fleet-server
+`, nil, `FLEET_SERVER_OUTPUT_ELASTICSEARCH_HOSTS=["es1:9200"]`, "FLEET_SERVER_INPUTS_0_SERVER_HOST=10.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"es1:9200"}, cfg.Output.Elasticsearch.Hosts)
+	require.Equal(t, "10.0.0.1", cfg.Inputs[0].Server.Host)
+}
+
+func TestReadFile(t *testing.T) {
+	t.Setenv("FLEET_SERVER_TEST_TOKEN", "file-token")
+	t.Setenv("FLEET_SERVER_INPUTS_0_SERVER_PORT", "8222")
+	path := filepath.Join(t.TempDir(), "fleet-server.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+output.elasticsearch.service_token: ${FLEET_SERVER_TEST_TOKEN}
+inputs:
+  - type: fleet-server
+`), 0o600))
+
+	c, err := ReadFile(path)
+	require.NoError(t, err)
+	cfg, err := FromConfig(c)
+	require.NoError(t, err)
+	require.Equal(t, "file-token", cfg.Output.Elasticsearch.ServiceToken)
+	require.Equal(t, uint16(8222), cfg.Inputs[0].Server.Port)
+}
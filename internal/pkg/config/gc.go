@@ -9,16 +9,30 @@ import "time"
 const (
 	defaultScheduleInterval            = time.Hour
 	defaultCleanupIntervalAfterExpired = "30d" // cleanup expired actions with expiration time older than 30 days from now
+	defaultUpgradeTimeout              = 2 * time.Hour
+	defaultDiagnosticsTimeout          = 30 * time.Minute
 )
 
 // GC is the configuration for the Fleet Server data garbage collection.
-// Currently manages the expired actions cleanup
+// Currently manages the expired actions cleanup, the agent upgrades that never completed and the agents that stopped checking in.
+// A zero UpgradeTimeout keeps the upgrades in the started status, a zero OfflineTimeout never marks the agents offline.
+// The agents that did not ack their UNENROLL action within UnenrollTimeout are unenrolled, a zero UnenrollTimeout waits for the ack.
+// DiagnosticsTimeout is the expiration of the diagnostics requests, a zero DiagnosticsTimeout uses the default action expiration.
+// APIKeys configures the invalidation of the API keys left without an owner.
 type GC struct {
 	ScheduleInterval            time.Duration `config:"schedule_interval"`
 	CleanupAfterExpiredInterval string        `config:"cleanup_after_expired_interval"`
+	UpgradeTimeout              time.Duration `config:"upgrade_timeout"`
+	OfflineTimeout              time.Duration `config:"offline_timeout"`
+	UnenrollTimeout             time.Duration `config:"unenroll_timeout"`
+	DiagnosticsTimeout          time.Duration `config:"diagnostics_timeout"`
+	APIKeys                     APIKeysGC     `config:"api_keys"`
 }
 
 func (g *GC) InitDefaults() {
 	g.ScheduleInterval = defaultScheduleInterval
 	g.CleanupAfterExpiredInterval = defaultCleanupIntervalAfterExpired
+	g.UpgradeTimeout = defaultUpgradeTimeout
+	g.DiagnosticsTimeout = defaultDiagnosticsTimeout
+	g.APIKeys.InitDefaults()
 }
@@ -0,0 +1,33 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import "time"
+
+const (
+	defaultAPIKeysGCRetention  = 24 * time.Hour
+	defaultAPIKeysGCBatchSize  = 100
+	defaultAPIKeysGCMaxBatches = 10
+)
+
+// APIKeysGC is the configuration of the reconciliation of the API keys created by fleet server.
+// The keys of agents that do not exist, or that are unenrolled for more than Retention, are invalidated by the leader
+// instance on each GC schedule_interval, up to MaxBatches batches of BatchSize keys per run.
+// With DryRun the orphaned keys are only counted and logged.
+type APIKeysGC struct {
+	Enabled    bool          `config:"enabled"`
+	DryRun     bool          `config:"dry_run"`
+	Retention  time.Duration `config:"retention"`
+	BatchSize  int           `config:"batch_size"`
+	MaxBatches int           `config:"max_batches"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *APIKeysGC) InitDefaults() {
+	c.Enabled = true
+	c.Retention = defaultAPIKeysGCRetention
+	c.BatchSize = defaultAPIKeysGCBatchSize
+	c.MaxBatches = defaultAPIKeysGCMaxBatches
+}
@@ -0,0 +1,29 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import "time"
+
+const (
+	defaultHeartbeatInterval     = 30 * time.Second
+	defaultHeartbeatStaleTimeout = 5 * time.Minute
+
+	// minStaleHeartbeats is the minimum number of heartbeat intervals in the stale timeout.
+	minStaleHeartbeats = 3
+)
+
+// Heartbeat is the configuration for reporting the fleet server instance in the .fleet-servers index.
+type Heartbeat struct {
+	// Interval is how often the last_seen time, the status and the connected agents of the instance are updated.
+	Interval time.Duration `config:"interval"`
+	// StaleTimeout is the time without heartbeat after which the document of another instance is flagged as stale and offline.
+	StaleTimeout time.Duration `config:"stale_timeout"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *Heartbeat) InitDefaults() {
+	c.Interval = defaultHeartbeatInterval
+	c.StaleTimeout = defaultHeartbeatStaleTimeout
+}
@@ -4,16 +4,24 @@
 
 package config
 
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
 const kDefaultHTTPHost = "localhost"
 const kDefaultHTTPPort = 5066
 
 // HTTP is the configuration for the API endpoint.
 type HTTP struct {
-	Enabled            bool   `config:"enabled"`
-	Host               string `config:"host"`
-	Port               int    `config:"port"`
-	User               string `config:"named_pipe.user"`
-	SecurityDescriptor string `config:"named_pipe.security_descriptor"`
+	Enabled            bool     `config:"enabled"`
+	Host               string   `config:"host"`
+	Port               int      `config:"port"`
+	User               string   `config:"named_pipe.user"`
+	SecurityDescriptor string   `config:"named_pipe.security_descriptor"`
+	Auth               HTTPAuth `config:"auth"`
 }
 
 func (h *HTTP) InitDefaults() {
@@ -21,3 +29,29 @@ func (h *HTTP) InitDefaults() {
 	h.Host = kDefaultHTTPHost
 	h.Port = kDefaultHTTPPort
 }
+
+// HTTPAuth is the bearer token authentication of the monitoring endpoint.
+// The token is read from TokenPath when it is not set. When enabled without a token, a token is generated at startup
+// and on each reload and written to TokenFile.
+type HTTPAuth struct {
+	Enabled   bool   `config:"enabled"`
+	Token     string `config:"token"`
+	TokenPath string `config:"token_path"`
+	TokenFile string `config:"token_file"`
+}
+
+// Validate reads the token from token_path when token is not set, the file is read each time the configuration is
+// loaded. It ensures a generated token is written to a file.
+func (a *HTTPAuth) Validate() error {
+	if a.Token == "" && a.TokenPath != "" {
+		p, err := os.ReadFile(a.TokenPath)
+		if err != nil {
+			return fmt.Errorf("unable to read http.auth.token_path: %w", err)
+		}
+		a.Token = strings.TrimSpace(string(p))
+	}
+	if a.Enabled && a.Token == "" && a.TokenFile == "" {
+		return errors.New("http.auth.token_file is required when http.auth.token is not set")
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ package config
 import (
 	"compress/flate"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
@@ -45,10 +46,13 @@ type ServerTLS struct {
 }
 
 type ServerBulk struct {
-	FlushInterval       time.Duration `config:"flush_interval"`
-	FlushThresholdCount int           `config:"flush_threshold_cnt"`
-	FlushThresholdSize  int           `config:"flush_threshold_size"`
-	FlushMaxPending     int           `config:"flush_max_pending"`
+	FlushInterval         time.Duration `config:"flush_interval"`
+	FlushThresholdCount   int           `config:"flush_threshold_cnt"`
+	FlushThresholdSize    int           `config:"flush_threshold_size"`
+	FlushMaxPending       int           `config:"flush_max_pending"`
+	LowPriorityMaxSize    int           `config:"low_priority_max_size"`
+	SearchMaxResponseSize int           `config:"search_max_response_size"`
+	Spool                 BulkSpool     `config:"spool"`
 }
 
 func (c *ServerBulk) InitDefaults() {
@@ -56,6 +60,9 @@ func (c *ServerBulk) InitDefaults() {
 	c.FlushThresholdCount = 2048
 	c.FlushThresholdSize = 1024 * 1024
 	c.FlushMaxPending = 8
+	c.LowPriorityMaxSize = 4 * 1024 * 1024
+	c.SearchMaxResponseSize = 100 * 1024 * 1024
+	c.Spool.InitDefaults()
 }
 
 // Server is the configuration for the server
@@ -73,10 +80,25 @@ type (
 		Runtime            Runtime                 `config:"runtime"`
 		Bulk               ServerBulk              `config:"bulk"`
 		GC                 GC                      `config:"gc"`
+		Retention          Retention               `config:"retention"`
 		Instrumentation    Instrumentation         `config:"instrumentation"`
 		StaticPolicyTokens StaticPolicyTokens      `config:"static_policy_tokens"`
 		PGP                PGP                     `config:"pgp"`
 		PDKDF2             PBKDF2                  `config:"pdkdf2"`
+		StandaloneSetup    StandaloneSetup         `config:"standalone_setup"`
+		ActionSigning      ActionSigning           `config:"action_signing"`
+		DeliveryTracking   DeliveryTracking        `config:"delivery_tracking"`
+		Heartbeat          Heartbeat               `config:"heartbeat"`
+		TrustedProxies     []string                `config:"trusted_proxies"`
+		StrictContentType  bool                    `config:"strict_content_type"`
+		Budgets            Budgets                 `config:"budgets"`
+		Actions            Actions                 `config:"actions"`
+		Draining           Draining                `config:"draining"`
+		ResourceUsage      ResourceUsage           `config:"resource_usage"`
+		StatusDamping      StatusDamping           `config:"status_damping"`
+		ConnectionMetadata ConnectionMetadata      `config:"connection_metadata"`
+		CheckinDelay       CheckinDelay            `config:"checkin_delay"`
+		ClockSkew          ClockSkew               `config:"clock_skew"`
 	}
 
 	StaticPolicyTokens struct {
@@ -89,10 +111,26 @@ type (
 	// PolicyToken is a static token for single policy
 	PolicyToken struct {
 		TokenKey string `config:"token_key"`
-		PolicyID string `config:"policy_id"`
+		// TokenKeyPath is the path of a file holding the token key, it is read when token_key is not set.
+		TokenKeyPath string `config:"token_key_path"`
+		PolicyID     string `config:"policy_id"`
 	}
 )
 
+// Validate reads the token key from token_key_path when token_key is not set. The file is read each time the
+// configuration is loaded, a reload picks its new content.
+func (pt *PolicyToken) Validate() error {
+	if pt.TokenKey != "" || pt.TokenKeyPath == "" {
+		return nil
+	}
+	p, err := os.ReadFile(pt.TokenKeyPath)
+	if err != nil {
+		return fmt.Errorf("unable to read token_key_path: %w", err)
+	}
+	pt.TokenKey = strings.TrimSpace(string(p))
+	return nil
+}
+
 // InitDefaults initializes the defaults for the configuration.
 func (c *Server) InitDefaults() {
 	c.Host = kDefaultHost
@@ -106,8 +144,21 @@ func (c *Server) InitDefaults() {
 	c.Runtime.InitDefaults()
 	c.Bulk.InitDefaults()
 	c.GC.InitDefaults()
+	c.Retention.InitDefaults()
 	c.PGP.InitDefaults()
 	c.PDKDF2.InitDefaults()
+	c.StandaloneSetup.InitDefaults()
+	c.ActionSigning.InitDefaults()
+	c.DeliveryTracking.InitDefaults()
+	c.Heartbeat.InitDefaults()
+	c.Budgets.InitDefaults()
+	c.Actions.InitDefaults()
+	c.Draining.InitDefaults()
+	c.ResourceUsage.InitDefaults()
+	c.StatusDamping.InitDefaults()
+	c.ConnectionMetadata.InitDefaults()
+	c.CheckinDelay.InitDefaults()
+	c.ClockSkew.InitDefaults()
 }
 
 // BindEndpoints returns the binding address for the all HTTP server listeners.
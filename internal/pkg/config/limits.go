@@ -5,6 +5,7 @@
 package config
 
 import (
+	"runtime"
 	"time"
 )
 
@@ -15,28 +16,134 @@ type Limit struct {
 	MaxBody  int64         `config:"max_body_byte_size"`
 }
 
+// PolicyQuotas are the quotas of the agents of each policy, a policy overrides them with the limits of its document.
+// A 0 value disables the quota.
+type PolicyQuotas struct {
+	MaxAgents          int `config:"max_agents"`
+	MaxEnrollPerMinute int `config:"max_enroll_per_minute"`
+}
+
+const (
+	defaultPolicySizeWarn = 1024 * 1024
+	defaultPolicySizeMax  = 1024 * 1024 * 4
+)
+
+// PolicySize are the thresholds of the size of the policy revisions encoded for the agents, in bytes.
+// A 0 value disables the threshold.
+type PolicySize struct {
+	// Warn logs a warning for the revisions larger than it.
+	Warn int `config:"warn_byte_size"`
+	// Max is the size above which a revision is not delivered inline in the checkin responses,
+	// the agents are told to fetch it from the policy endpoint instead.
+	Max int `config:"max_byte_size"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *PolicySize) InitDefaults() {
+	c.Warn = defaultPolicySizeWarn
+	c.Max = defaultPolicySizeMax
+}
+
+const (
+	defaultConcurrencyQueueSize    = 100
+	defaultConcurrencyQueueTimeout = time.Second
+	defaultConcurrencyRetryAfter   = 30 * time.Second
+	// concurrencyPerCPU is the number of requests served at once for each CPU, above the expected long polls of the agents.
+	concurrencyPerCPU = 256
+)
+
+// Concurrency is the ceiling of the requests served at once by the API. It is shared by the endpoint classes,
+// each class may use its quota of the ceiling, so a storm of requests of a class does not starve the other classes.
+// The status requests are not counted.
+type Concurrency struct {
+	Enabled bool `config:"enabled"`
+	// Max is the number of requests served at once. A 0 value derives it from the expected number of agents,
+	// so all of them can long poll, and GOMAXPROCS. The ceiling is disabled if the number of agents is not bounded.
+	Max int `config:"max"`
+	// QueueSize is the number of requests of a class, other than checkin, that wait for the quota of the class when it is used.
+	// The checkins are rejected at once.
+	QueueSize int `config:"queue_size"`
+	// QueueTimeout is the time a request waits in the queue before it is rejected.
+	QueueTimeout time.Duration `config:"queue_timeout"`
+	// RetryAfter is the backoff set in the Retry-After header of the rejected requests, the header is jittered between half and all of it.
+	RetryAfter time.Duration     `config:"retry_after"`
+	Quotas     ConcurrencyQuotas `config:"quotas"`
+}
+
+// ConcurrencyQuotas are the fractions of the concurrency ceiling that each endpoint class may use, their total must not exceed 1.
+type ConcurrencyQuotas struct {
+	Checkin float64 `config:"checkin"`
+	Enroll  float64 `config:"enroll"`
+	Ack     float64 `config:"ack"`
+	// Other is the quota of the endpoints that are not checkin, enroll, ack or status.
+	Other float64 `config:"other"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *Concurrency) InitDefaults() {
+	c.Enabled = true
+	c.QueueSize = defaultConcurrencyQueueSize
+	c.QueueTimeout = defaultConcurrencyQueueTimeout
+	c.RetryAfter = defaultConcurrencyRetryAfter
+	c.Quotas = ConcurrencyQuotas{
+		Checkin: 0.8,
+		Enroll:  0.05,
+		Ack:     0.1,
+		Other:   0.05,
+	}
+}
+
+// deriveMax returns the ceiling for the expected number of agents, or 0 if the number of agents is not known.
+// The checkin quota of the ceiling fits a long poll of each agent, with the requests served by each CPU on top.
+func (c *Concurrency) deriveMax(agents valueRange) int {
+	expected := agents.Max
+	if int64(expected) >= getMaxInt() {
+		expected = agents.Min
+	}
+	if expected <= 0 || c.Quotas.Checkin <= 0 {
+		return 0
+	}
+	return int(float64(expected)/c.Quotas.Checkin) + concurrencyPerCPU*runtime.GOMAXPROCS(0)
+}
+
 type ServerLimits struct {
-	MaxAgents         int `config:"max_agents"`
-	MaxHeaderByteSize int `config:"max_header_byte_size"`
-	MaxConnections    int `config:"max_connections"`
-
-	ActionLimit        Limit `config:"action_limit"`
-	PolicyLimit        Limit `config:"policy_limit"`
-	CheckinLimit       Limit `config:"checkin_limit"`
-	ArtifactLimit      Limit `config:"artifact_limit"`
-	EnrollLimit        Limit `config:"enroll_limit"`
-	AckLimit           Limit `config:"ack_limit"`
-	StatusLimit        Limit `config:"status_limit"`
-	UploadStartLimit   Limit `config:"upload_start_limit"`
-	UploadEndLimit     Limit `config:"upload_end_limit"`
-	UploadChunkLimit   Limit `config:"upload_chunk_limit"`
-	DeliverFileLimit   Limit `config:"file_delivery_limit"`
-	GetPGPKey          Limit `config:"pgp_retrieval_limit"`
-	AuditUnenrollLimit Limit `config:"audit_unenroll_limit"`
+	MaxAgents           int `config:"max_agents"`
+	MaxHeaderByteSize   int `config:"max_header_byte_size"`
+	MaxConnections      int `config:"max_connections"`
+	MaxActionTargets    int `config:"max_action_targets"`
+	MaxBulkEnrollAgents int `config:"max_bulk_enroll_agents"`
+
+	PolicyQuotas PolicyQuotas `config:"policy_quotas"`
+	PolicySize   PolicySize   `config:"policy_size"`
+	Concurrency  Concurrency  `config:"concurrency"`
+
+	ActionLimit         Limit `config:"action_limit"`
+	PolicyLimit         Limit `config:"policy_limit"`
+	CheckinLimit        Limit `config:"checkin_limit"`
+	ArtifactLimit       Limit `config:"artifact_limit"`
+	EnrollLimit         Limit `config:"enroll_limit"`
+	AckLimit            Limit `config:"ack_limit"`
+	StatusLimit         Limit `config:"status_limit"`
+	UploadStartLimit    Limit `config:"upload_start_limit"`
+	UploadEndLimit      Limit `config:"upload_end_limit"`
+	UploadChunkLimit    Limit `config:"upload_chunk_limit"`
+	DeliverFileLimit    Limit `config:"file_delivery_limit"`
+	GetPGPKey           Limit `config:"pgp_retrieval_limit"`
+	AuditUnenrollLimit  Limit `config:"audit_unenroll_limit"`
+	CreateActionsLimit  Limit `config:"create_actions_limit"`
+	AgentTagsLimit      Limit `config:"agent_tags_limit"`
+	ActionResultsLimit  Limit `config:"action_results_limit"`
+	ReassignAgentsLimit Limit `config:"reassign_agents_limit"`
+	PolicyFetchLimit    Limit `config:"policy_fetch_limit"`
+	PolicyRolloutLimit  Limit `config:"policy_rollout_limit"`
+	BulkEnrollLimit     Limit `config:"bulk_enroll_limit"`
 }
 
 // InitDefaults initializes the defaults for the configuration.
-func (c *ServerLimits) InitDefaults() {}
+func (c *ServerLimits) InitDefaults() {
+	c.PolicySize.InitDefaults()
+	c.Concurrency.InitDefaults()
+}
 
 func (c *ServerLimits) LoadLimits(limits *envLimits) {
 	l := limits.Server
@@ -47,6 +154,15 @@ func (c *ServerLimits) LoadLimits(limits *envLimits) {
 	if c.MaxConnections == 0 {
 		c.MaxConnections = l.MaxConnections
 	}
+	if c.MaxActionTargets == 0 {
+		c.MaxActionTargets = l.MaxActionTargets
+	}
+	if c.MaxBulkEnrollAgents == 0 {
+		c.MaxBulkEnrollAgents = l.MaxBulkEnrollAgents
+	}
+	if c.Concurrency.Max == 0 {
+		c.Concurrency.Max = c.Concurrency.deriveMax(limits.Agents)
+	}
 
 	c.ActionLimit = mergeEnvLimit(c.ActionLimit, l.ActionLimit)
 	c.PolicyLimit = mergeEnvLimit(c.PolicyLimit, l.PolicyLimit)
@@ -61,6 +177,13 @@ func (c *ServerLimits) LoadLimits(limits *envLimits) {
 	c.DeliverFileLimit = mergeEnvLimit(c.DeliverFileLimit, l.DeliverFileLimit)
 	c.GetPGPKey = mergeEnvLimit(c.GetPGPKey, l.GetPGPKeyLimit)
 	c.AuditUnenrollLimit = mergeEnvLimit(c.AuditUnenrollLimit, l.AuditUnenrollLimit)
+	c.CreateActionsLimit = mergeEnvLimit(c.CreateActionsLimit, l.CreateActionsLimit)
+	c.AgentTagsLimit = mergeEnvLimit(c.AgentTagsLimit, l.AgentTagsLimit)
+	c.ActionResultsLimit = mergeEnvLimit(c.ActionResultsLimit, l.ActionResultsLimit)
+	c.ReassignAgentsLimit = mergeEnvLimit(c.ReassignAgentsLimit, l.ReassignAgentsLimit)
+	c.PolicyFetchLimit = mergeEnvLimit(c.PolicyFetchLimit, l.PolicyFetchLimit)
+	c.PolicyRolloutLimit = mergeEnvLimit(c.PolicyRolloutLimit, l.PolicyRolloutLimit)
+	c.BulkEnrollLimit = mergeEnvLimit(c.BulkEnrollLimit, l.BulkEnrollLimit)
 }
 
 func mergeEnvLimit(L Limit, l limit) Limit {
@@ -5,7 +5,9 @@
 package config
 
 import (
+	"errors"
 	"os"
+	"reflect"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -14,11 +16,15 @@ import (
 const defaultLevel = "info"
 
 // LoggingFiles configuration for the logging file output.
+// MaxSizeMB and MaxBackupFiles take precedence over rotateeverybytes and keepfiles when they are set.
 type LoggingFiles struct {
 	Path            string        `config:"path"`
 	Name            string        `config:"name"`
 	MaxSize         uint          `config:"rotateeverybytes" validate:"min=1"`
+	MaxSizeMB       uint          `config:"max_size_mb"`
 	MaxBackups      uint          `config:"keepfiles" validate:"max=1024"`
+	MaxBackupFiles  *uint         `config:"max_backups"`
+	KeepAge         time.Duration `config:"keep_age"`
 	Permissions     uint32        `config:"permissions"`
 	Interval        time.Duration `config:"interval"`
 	RotateOnStartup bool          `config:"rotateonstartup"`
@@ -42,6 +48,70 @@ func (c *LoggingFiles) InitDefaults() {
 	c.RotateOnStartup = true
 }
 
+// Validate ensures that the configuration is valid.
+// The size and number of backups are resolved into MaxSize and MaxBackups.
+func (c *LoggingFiles) Validate() error {
+	if c.MaxSizeMB > 0 {
+		c.MaxSize = c.MaxSizeMB * 1024 * 1024
+	}
+	if c.MaxBackupFiles != nil {
+		if *c.MaxBackupFiles > 1024 {
+			return errors.New("max_backups can not be greater than 1024")
+		}
+		c.MaxBackups = *c.MaxBackupFiles
+	}
+	if c.KeepAge < 0 {
+		return errors.New("keep_age can not be negative")
+	}
+	return nil
+}
+
+// LoggingSlow configuration for the logging of slow operations.
+// An operation that takes longer than its threshold is logged with a warning, a zero threshold disables the logging.
+type LoggingSlow struct {
+	BulkFlush time.Duration `config:"bulk_flush"`
+	Query     time.Duration `config:"query"`
+	Handler   time.Duration `config:"handler"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *LoggingSlow) InitDefaults() {
+	c.BulkFlush = 5 * time.Second
+	c.Query = 5 * time.Second
+	c.Handler = 10 * time.Second
+}
+
+// LoggingFailedBulk configuration for the capture of the bulk items rejected by Elasticsearch.
+// A sample of the failed items is written with their Elasticsearch error to the files rotated by size named
+// File-<date>.ndjson, or logged when File is not set.
+// The values of the RedactFields at any depth of the items are redacted.
+type LoggingFailedBulk struct {
+	SampleRate   float64  `config:"sample_rate"`
+	RedactFields []string `config:"redact_fields"`
+	File         string   `config:"file"`
+	MaxSizeMB    uint     `config:"max_size_mb"`
+	MaxBackups   uint     `config:"max_backups"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *LoggingFailedBulk) InitDefaults() {
+	c.SampleRate = 0.1
+	c.RedactFields = []string{"api_key", "access_api_key", "default_api_key", "password", "secret", "secrets", "token", "replace_token", "enrollment_token"}
+	c.MaxSizeMB = 10
+	c.MaxBackups = 3
+}
+
+// Validate ensures that the configuration is valid.
+func (c *LoggingFailedBulk) Validate() error {
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return errors.New("sample_rate must be between 0 and 1")
+	}
+	if c.File != "" && c.MaxSizeMB == 0 {
+		return errors.New("max_size_mb must be greater than 0")
+	}
+	return nil
+}
+
 // Logging configuration.
 type Logging struct {
 	Level    string        `config:"level"`
@@ -49,24 +119,28 @@ type Logging struct {
 	ToFiles  bool          `config:"to_files"`
 	Pretty   bool          `config:"pretty"`
 	Files    *LoggingFiles `config:"files"`
+	Slow     LoggingSlow   `config:"slow"`
+	// TraceAgents lists the agents whose policy dispatch decisions are logged regardless of the log level.
+	TraceAgents []string `config:"trace_agents"`
+	// CaptureFailedBulk enables the capture of the bulk items rejected by Elasticsearch, it is a diagnostic mode that is
+	// never enabled by default.
+	CaptureFailedBulk bool              `config:"capture_failed_bulk"`
+	FailedBulk        LoggingFailedBulk `config:"failed_bulk"`
 }
 
 func (c *Logging) EqualExcludeLevel(cfg Logging) bool {
 	if !(c.ToStderr == cfg.ToStderr && c.ToFiles == cfg.ToFiles && c.Pretty == cfg.Pretty) {
 		return false
 	}
-	af := c.Files
-	bf := cfg.Files
-	if (af == nil && bf != nil) || (af != nil && bf == nil) || ((af != nil && bf != nil) && *af != *bf) {
-		return false
-	}
-	return true
+	return reflect.DeepEqual(c.Files, cfg.Files)
 }
 
 // InitDefaults initializes the defaults for the configuration.
 func (c *Logging) InitDefaults() {
 	c.Level = defaultLevel
 	c.ToFiles = true
+	c.Slow.InitDefaults()
+	c.FailedBulk.InitDefaults()
 }
 
 // Validate ensures that the configuration is valid.
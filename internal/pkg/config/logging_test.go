@@ -10,6 +10,9 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/go-ucfg/yaml"
 )
 
 func TestDefaultLevel(t *testing.T) {
@@ -129,3 +132,60 @@ func Test_Logging_EqualExcludeLevel(t *testing.T) {
 		assert.True(t, a.EqualExcludeLevel(b))
 	})
 }
+
+func TestLoggingFilesSizeAndBackups(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        string
+		maxSize    uint
+		maxBackups uint
+		keepAge    time.Duration
+		err        string
+	}{{
+		name:       "defaults",
+		cfg:        "{}",
+		maxSize:    10 * 1024 * 1024,
+		maxBackups: 7,
+	}, {
+		name:       "legacy names",
+		cfg:        "{rotateeverybytes: 2048, keepfiles: 3}",
+		maxSize:    2048,
+		maxBackups: 3,
+	}, {
+		name:       "size in MB, backups and age",
+		cfg:        "{max_size_mb: 5, max_backups: 0, keep_age: 72h}",
+		maxSize:    5 * 1024 * 1024,
+		maxBackups: 0,
+		keepAge:    72 * time.Hour,
+	}, {
+		name:       "new names take precedence",
+		cfg:        "{rotateeverybytes: 2048, max_size_mb: 1, keepfiles: 3, max_backups: 4}",
+		maxSize:    1024 * 1024,
+		maxBackups: 4,
+	}, {
+		name: "too many backups",
+		cfg:  "{max_backups: 2000}",
+		err:  "max_backups can not be greater than 1024",
+	}, {
+		name: "negative age",
+		cfg:  "{keep_age: -1h}",
+		err:  "keep_age can not be negative",
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := yaml.NewConfig([]byte("files: "+tc.cfg), DefaultOptions...)
+			require.NoError(t, err)
+			var cfg Logging
+			err = c.Unpack(&cfg, DefaultOptions...)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, cfg.Files)
+			assert.Equal(t, tc.maxSize, cfg.Files.MaxSize)
+			assert.Equal(t, tc.maxBackups, cfg.Files.MaxBackups)
+			assert.Equal(t, tc.keepAge, cfg.Files.KeepAge)
+		})
+	}
+}
@@ -10,16 +10,19 @@ const (
 	defaultFetchSize          = 1000
 	defaultPollTimeout        = 4 * time.Minute
 	defaultPolicyDebounceTime = time.Second
+	defaultCheckpointInterval = time.Minute
 )
 
 type Monitor struct {
 	FetchSize          int           `config:"fetch_size"`
 	PollTimeout        time.Duration `config:"poll_timeout"`
 	PolicyDebounceTime time.Duration `config:"policy_debounce_time"`
+	CheckpointInterval time.Duration `config:"checkpoint_interval"`
 }
 
 func (m *Monitor) InitDefaults() {
 	m.FetchSize = defaultFetchSize
 	m.PollTimeout = defaultPollTimeout
 	m.PolicyDebounceTime = defaultPolicyDebounceTime
+	m.CheckpointInterval = defaultCheckpointInterval
 }
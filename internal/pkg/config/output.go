@@ -7,7 +7,9 @@ package config
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
@@ -21,16 +23,34 @@ import (
 	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
 	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/hashicorp/go-version"
 	"github.com/rs/zerolog"
 )
 
 // The timeout would be driven by the server for long poll.
 // Giving it some sane long value.
 const httpTransportLongPollTimeout = 10 * time.Minute
-const schemeHTTP = "http"
+const (
+	schemeHTTP  = "http"
+	schemeHTTPS = "https"
+)
+
+// defaultESMinVersion is the lowest elasticsearch version with the APIs fleet-server uses.
+const defaultESMinVersion = "8.0.0"
 
 var hasScheme = regexp.MustCompile(`^([a-z][a-z0-9+\-.]*)://`)
 
+// defaultRetryOnStatus are the response statuses that are retried when retry_on_status is not set.
+// It is not set by InitDefaults as a configured list would be merged into the default one.
+var defaultRetryOnStatus = []int{
+	http.StatusTooManyRequests,
+	http.StatusRequestTimeout,
+	http.StatusTooEarly,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
 // Output is the output configuration to elasticsearch.
 type Output struct {
 	Elasticsearch Elasticsearch          `config:"elasticsearch"`
@@ -45,14 +65,55 @@ type Elasticsearch struct {
 	Headers          map[string]string `config:"headers"`
 	ServiceToken     string            `config:"service_token"`
 	ServiceTokenPath string            `config:"service_token_path"`
+	Username         string            `config:"username"`
+	Password         string            `config:"password"`
+	PasswordPath     string            `config:"password_path"`
 	ProxyURL         string            `config:"proxy_url"`
 	ProxyDisable     bool              `config:"proxy_disable"`
 	ProxyHeaders     map[string]string `config:"proxy_headers"`
+	ProxyBypass      []string          `config:"proxy_bypass"`
 	TLS              *tlscommon.Config `config:"ssl"`
 	MaxRetries       int               `config:"max_retries"`
+	RetryOnStatus    []int             `config:"retry_on_status"`
+	Backoff          ESBackoff         `config:"backoff"`
 	MaxConnPerHost   int               `config:"max_conn_per_host"`
+	MaxIdleConns     int               `config:"max_idle_conns"`
 	Timeout          time.Duration     `config:"timeout"`
 	MaxContentLength int               `config:"max_content_length"`
+	Verification     ESVerification    `config:"verification"`
+	MaxStartupWait   time.Duration     `config:"max_startup_wait"`
+}
+
+// ESBackoff is the delay between retries of a failed request to elasticsearch.
+// The delay starts at Init and doubles with every retry up to Max.
+type ESBackoff struct {
+	Init time.Duration `config:"init"`
+	Max  time.Duration `config:"max"`
+}
+
+// Delay returns the randomized delay before retry attempt, 1 is the first retry.
+func (b ESBackoff) Delay(attempt int) time.Duration {
+	d := b.Init
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	// spread the retries of concurrent requests between half and the full delay
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1)) //nolint:gosec // jitter does not need a secure random source
+}
+
+// ESVerification is the verification of the elasticsearch cluster done when fleet-server starts.
+type ESVerification struct {
+	// Skip disables the verification, for restricted environments where the credentials can not call the info or has_privileges APIs.
+	Skip bool `config:"skip"`
+	// MinVersion is the lowest elasticsearch version fleet-server starts with, it is not checked when empty.
+	MinVersion string `config:"min_version"`
+	// Force starts fleet-server in degraded mode when the mappings of the fleet indices have fields of other types,
+	// the features writing the indices with conflicts are disabled.
+	Force bool `config:"force"`
 }
 
 // InitDefaults initializes the defaults for the configuration.
@@ -61,17 +122,57 @@ func (c *Elasticsearch) InitDefaults() {
 	c.Hosts = []string{"localhost:9200"}
 	c.Timeout = 90 * time.Second
 	c.MaxRetries = 3
+	c.Backoff = ESBackoff{
+		Init: 500 * time.Millisecond,
+		Max:  10 * time.Second,
+	}
 	c.MaxConnPerHost = 128
+	c.MaxIdleConns = 100
 	c.MaxContentLength = 100 * 1024 * 1024
+	c.Verification = ESVerification{MinVersion: defaultESMinVersion}
+	c.MaxStartupWait = 5 * time.Minute
 }
 
 // Validate ensures that the configuration is valid.
 func (c *Elasticsearch) Validate() error {
+	for _, host := range c.Hosts {
+		if _, err := makeURL(c.Protocol, c.Path, host, 9200); err != nil {
+			return fmt.Errorf("invalid host %q: %w", host, err)
+		}
+	}
+	hasPassword := c.Password != "" || c.PasswordPath != ""
+	if (c.ServiceToken != "" || c.ServiceTokenPath != "") && (c.Username != "" || hasPassword) {
+		return errors.New("service_token and username/password can not both be set, use a service token")
+	}
+	if (c.Username == "") != !hasPassword {
+		return errors.New("username and password must be set together")
+	}
+	for _, status := range c.RetryOnStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("retry_on_status contains invalid HTTP status %d", status)
+		}
+	}
+	if c.Backoff.Max < c.Backoff.Init {
+		return errors.New("backoff.max can not be less than backoff.init")
+	}
+	if c.Verification.MinVersion != "" {
+		if _, err := version.NewVersion(c.Verification.MinVersion); err != nil {
+			return fmt.Errorf("invalid verification.min_version %q: %w", c.Verification.MinVersion, err)
+		}
+	}
+	for key := range c.Headers {
+		if strings.TrimSpace(key) == "" {
+			return errors.New("headers can not contain an empty header name")
+		}
+	}
 	if c.ProxyURL != "" && !c.ProxyDisable {
 		if _, err := urlutil.ParseURL(c.ProxyURL); err != nil {
 			return err
 		}
 	}
+	if err := validateProxyBypass(c.ProxyBypass); err != nil {
+		return err
+	}
 	if c.TLS != nil && c.TLS.IsEnabled() {
 		_, err := tlscommon.LoadTLSConfig(c.TLS)
 		if err != nil {
@@ -81,6 +182,35 @@ func (c *Elasticsearch) Validate() error {
 	return nil
 }
 
+// Warnings returns the problems with combinations of settings that are accepted but likely unintended.
+func (c *Elasticsearch) Warnings() []string {
+	var warnings []string
+	if c.TLS != nil && c.TLS.IsEnabled() {
+		if c.TLS.VerificationMode == tlscommon.VerifyNone {
+			warnings = append(warnings, "ssl.verification_mode is none, the certificates of the elasticsearch hosts are not verified")
+			if len(c.TLS.CAs) > 0 {
+				warnings = append(warnings, "ssl.certificate_authorities are ignored because ssl.verification_mode is none")
+			}
+		}
+		https := false
+		for _, host := range c.Hosts {
+			if addr, err := makeURL(c.Protocol, c.Path, host, 9200); err == nil && strings.HasPrefix(addr, schemeHTTPS+"://") {
+				https = true
+			}
+		}
+		if !https {
+			warnings = append(warnings, "ssl settings are ignored because no elasticsearch host uses https")
+		}
+	}
+	if c.ProxyDisable && c.ProxyURL != "" {
+		warnings = append(warnings, "proxy_url is ignored because proxy_disable is set")
+	}
+	if c.ProxyDisable && len(c.ProxyBypass) > 0 {
+		warnings = append(warnings, "proxy_bypass is ignored because proxy_disable is set")
+	}
+	return warnings
+}
+
 // ToESConfig converts the configuration object into the config for the elasticsearch client.
 func (c *Elasticsearch) ToESConfig(longPoll bool) (elasticsearch.Config, error) {
 	// build the addresses
@@ -102,7 +232,7 @@ func (c *Elasticsearch) ToESConfig(longPoll bool) (elasticsearch.Config, error)
 		TLSHandshakeTimeout:   10 * time.Second,
 		DisableKeepAlives:     false,
 		DisableCompression:    false,
-		MaxIdleConns:          100,
+		MaxIdleConns:          c.MaxIdleConns,
 		MaxIdleConnsPerHost:   32,
 		MaxConnsPerHost:       c.MaxConnPerHost,
 		IdleConnTimeout:       60 * time.Second,
@@ -110,16 +240,32 @@ func (c *Elasticsearch) ToESConfig(longPoll bool) (elasticsearch.Config, error)
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 
-	disableRetry := false
+	if c.MaxIdleConns > 0 && c.MaxIdleConns < httpTransport.MaxIdleConnsPerHost {
+		httpTransport.MaxIdleConnsPerHost = c.MaxIdleConns
+	}
+
+	// the client falls back to its own default number of retries when MaxRetries is 0
+	disableRetry := c.MaxRetries == 0
 
 	if longPoll {
 		httpTransport.IdleConnTimeout = httpTransportLongPollTimeout
 		httpTransport.ResponseHeaderTimeout = httpTransportLongPollTimeout
 
-		// no retries for long poll monitoring
+		// No retries for the long poll client: the index monitors retry failed polls on their own,
+		// and file chunks are streamed so their bodies can not be sent again.
 		disableRetry = true
 	}
 
+	retryOnStatus := c.RetryOnStatus
+	if len(retryOnStatus) == 0 {
+		retryOnStatus = defaultRetryOnStatus
+	}
+
+	var retryBackoff func(int) time.Duration
+	if c.Backoff.Init > 0 {
+		retryBackoff = c.Backoff.Delay
+	}
+
 	if c.TLS != nil && c.TLS.IsEnabled() {
 		tls, err := tlscommon.LoadTLSConfig(c.TLS)
 		if err != nil {
@@ -138,6 +284,10 @@ func (c *Elasticsearch) ToESConfig(longPoll bool) (elasticsearch.Config, error)
 		} else {
 			httpTransport.Proxy = http.ProxyFromEnvironment
 		}
+		if len(c.ProxyBypass) > 0 {
+			// the hosts of the bypass list are not proxied, on top of the NO_PROXY environment variable
+			httpTransport.Proxy = bypassProxy(c.ProxyBypass, httpTransport.Proxy)
+		}
 
 		var proxyHeaders http.Header
 		if len(c.ProxyHeaders) > 0 {
@@ -164,16 +314,30 @@ func (c *Elasticsearch) ToESConfig(longPoll bool) (elasticsearch.Config, error)
 		if err != nil {
 			return elasticsearch.Config{}, fmt.Errorf("unable to read service_token_path: %w", err)
 		}
-		serviceToken = string(p)
+		// mounted secrets usually end with a newline that is not part of the token
+		serviceToken = strings.TrimSpace(string(p))
+	}
+
+	password := c.Password
+	if c.Password == "" && c.PasswordPath != "" {
+		p, err := os.ReadFile(c.PasswordPath)
+		if err != nil {
+			return elasticsearch.Config{}, fmt.Errorf("unable to read password_path: %w", err)
+		}
+		password = strings.TrimSpace(string(p))
 	}
 
 	return elasticsearch.Config{
-		Addresses:    addrs,
-		ServiceToken: serviceToken,
-		Header:       h,
-		Transport:    httpTransport,
-		MaxRetries:   c.MaxRetries,
-		DisableRetry: disableRetry,
+		Addresses:     addrs,
+		ServiceToken:  serviceToken,
+		Username:      c.Username,
+		Password:      password,
+		Header:        h,
+		Transport:     httpTransport,
+		MaxRetries:    c.MaxRetries,
+		RetryOnStatus: retryOnStatus,
+		RetryBackoff:  retryBackoff,
+		DisableRetry:  disableRetry,
 	}, nil
 }
 
@@ -25,6 +25,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-ucfg"
+	"github.com/elastic/go-ucfg/yaml"
 
 	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 )
@@ -41,13 +43,46 @@ func TestToESConfig(t *testing.T) {
 				ServiceToken:   "test-token",
 				MaxRetries:     3,
 				MaxConnPerHost: 128,
+				MaxIdleConns:   100,
+				Timeout:        90 * time.Second,
+			},
+			result: elasticsearch.Config{
+				Addresses:     []string{"http://localhost:9200"},
+				ServiceToken:  "test-token",
+				Header:        http.Header{},
+				MaxRetries:    3,
+				RetryOnStatus: defaultRetryOnStatus,
+				Transport: &http.Transport{
+					TLSHandshakeTimeout:   10 * time.Second,
+					MaxIdleConns:          100,
+					MaxIdleConnsPerHost:   32,
+					MaxConnsPerHost:       128,
+					IdleConnTimeout:       60 * time.Second,
+					ResponseHeaderTimeout: 90 * time.Second,
+					ExpectContinueTimeout: 1 * time.Second,
+				},
+			},
+		},
+		"custom headers": {
+			cfg: Elasticsearch{
+				Protocol:       "http",
+				Hosts:          []string{"localhost:9200"},
+				Headers:        map[string]string{"X-Auth-Proxy-User": "fleet", "x-custom": "value"},
+				ServiceToken:   "test-token",
+				MaxRetries:     3,
+				MaxConnPerHost: 128,
+				MaxIdleConns:   100,
 				Timeout:        90 * time.Second,
 			},
 			result: elasticsearch.Config{
 				Addresses:    []string{"http://localhost:9200"},
 				ServiceToken: "test-token",
-				Header:       http.Header{},
-				MaxRetries:   3,
+				Header: http.Header{
+					"X-Auth-Proxy-User": []string{"fleet"},
+					"X-Custom":          []string{"value"},
+				},
+				MaxRetries:    3,
+				RetryOnStatus: defaultRetryOnStatus,
 				Transport: &http.Transport{
 					TLSHandshakeTimeout:   10 * time.Second,
 					MaxIdleConns:          100,
@@ -67,13 +102,15 @@ func TestToESConfig(t *testing.T) {
 				ServiceTokenPath: "/path/is/ignored",
 				MaxRetries:       3,
 				MaxConnPerHost:   128,
+				MaxIdleConns:     100,
 				Timeout:          90 * time.Second,
 			},
 			result: elasticsearch.Config{
-				Addresses:    []string{"http://localhost:9200"},
-				ServiceToken: "test-token",
-				Header:       http.Header{},
-				MaxRetries:   3,
+				Addresses:     []string{"http://localhost:9200"},
+				ServiceToken:  "test-token",
+				Header:        http.Header{},
+				MaxRetries:    3,
+				RetryOnStatus: defaultRetryOnStatus,
 				Transport: &http.Transport{
 					TLSHandshakeTimeout:   10 * time.Second,
 					MaxIdleConns:          100,
@@ -95,13 +132,15 @@ func TestToESConfig(t *testing.T) {
 				},
 				MaxRetries:     6,
 				MaxConnPerHost: 256,
+				MaxIdleConns:   100,
 				Timeout:        120 * time.Second,
 			},
 			result: elasticsearch.Config{
-				Addresses:    []string{"http://localhost:9200", "http://other-host:9200"},
-				ServiceToken: "test-token",
-				Header:       http.Header{"X-Custom-Header": {"Header-Value"}},
-				MaxRetries:   6,
+				Addresses:     []string{"http://localhost:9200", "http://other-host:9200"},
+				ServiceToken:  "test-token",
+				Header:        http.Header{"X-Custom-Header": {"Header-Value"}},
+				MaxRetries:    6,
+				RetryOnStatus: defaultRetryOnStatus,
 				Transport: &http.Transport{
 					TLSHandshakeTimeout:   10 * time.Second,
 					MaxIdleConns:          100,
@@ -123,16 +162,18 @@ func TestToESConfig(t *testing.T) {
 				},
 				MaxRetries:     6,
 				MaxConnPerHost: 256,
+				MaxIdleConns:   100,
 				Timeout:        120 * time.Second,
 				TLS: &tlscommon.Config{
 					VerificationMode: tlscommon.VerifyNone,
 				},
 			},
 			result: elasticsearch.Config{
-				Addresses:    []string{"https://localhost:9200", "https://other-host:9200"},
-				ServiceToken: "test-token",
-				Header:       http.Header{"X-Custom-Header": {"Header-Value"}},
-				MaxRetries:   6,
+				Addresses:     []string{"https://localhost:9200", "https://other-host:9200"},
+				ServiceToken:  "test-token",
+				Header:        http.Header{"X-Custom-Header": {"Header-Value"}},
+				MaxRetries:    6,
+				RetryOnStatus: defaultRetryOnStatus,
 				Transport: &http.Transport{
 					TLSClientConfig: &tls.Config{
 						InsecureSkipVerify: true, //nolint:gosec // test case
@@ -161,16 +202,18 @@ func TestToESConfig(t *testing.T) {
 				},
 				MaxRetries:     6,
 				MaxConnPerHost: 256,
+				MaxIdleConns:   100,
 				Timeout:        120 * time.Second,
 				TLS: &tlscommon.Config{
 					VerificationMode: tlscommon.VerifyNone,
 				},
 			},
 			result: elasticsearch.Config{
-				Addresses:    []string{"http://localhost:9200", "https://other-host:9200"},
-				ServiceToken: "test-token",
-				Header:       http.Header{"X-Custom-Header": {"Header-Value"}},
-				MaxRetries:   6,
+				Addresses:     []string{"http://localhost:9200", "https://other-host:9200"},
+				ServiceToken:  "test-token",
+				Header:        http.Header{"X-Custom-Header": {"Header-Value"}},
+				MaxRetries:    6,
+				RetryOnStatus: defaultRetryOnStatus,
 				Transport: &http.Transport{
 					TLSClientConfig: &tls.Config{
 						InsecureSkipVerify: true, //nolint:gosec // test case
@@ -219,16 +262,18 @@ func TestToESConfig(t *testing.T) {
 			ServiceTokenPath: fileName,
 			MaxRetries:       3,
 			MaxConnPerHost:   128,
+			MaxIdleConns:     100,
 			Timeout:          90 * time.Second,
 		}
 		es, err := cfg.ToESConfig(false)
 		require.NoError(t, err)
 
 		expect := elasticsearch.Config{
-			Addresses:    []string{"http://localhost:9200"},
-			ServiceToken: "test-token",
-			Header:       http.Header{"X-Elastic-Product-Origin": []string{"fleet"}},
-			MaxRetries:   3,
+			Addresses:     []string{"http://localhost:9200"},
+			ServiceToken:  "test-token",
+			Header:        http.Header{"X-Elastic-Product-Origin": []string{"fleet"}},
+			MaxRetries:    3,
+			RetryOnStatus: defaultRetryOnStatus,
 			Transport: &http.Transport{
 				TLSHandshakeTimeout:   10 * time.Second,
 				MaxIdleConns:          100,
@@ -252,15 +297,17 @@ func TestToESConfig(t *testing.T) {
 			ServiceTokenPath: fileName,
 			MaxRetries:       3,
 			MaxConnPerHost:   128,
+			MaxIdleConns:     100,
 			Timeout:          90 * time.Second,
 		}
 		es, err := cfg.ToESConfig(false)
 		require.NoError(t, err)
 
 		expect := elasticsearch.Config{
-			Addresses:  []string{"http://localhost:9200"},
-			Header:     http.Header{"X-Elastic-Product-Origin": []string{"fleet"}},
-			MaxRetries: 3,
+			Addresses:     []string{"http://localhost:9200"},
+			Header:        http.Header{"X-Elastic-Product-Origin": []string{"fleet"}},
+			MaxRetries:    3,
+			RetryOnStatus: defaultRetryOnStatus,
 			Transport: &http.Transport{
 				TLSHandshakeTimeout:   10 * time.Second,
 				MaxIdleConns:          100,
@@ -283,6 +330,7 @@ func TestToESConfig(t *testing.T) {
 			ServiceTokenPath: filepath.Join(t.TempDir(), "some-file"),
 			MaxRetries:       3,
 			MaxConnPerHost:   128,
+			MaxIdleConns:     100,
 			Timeout:          90 * time.Second,
 		}
 		_, err := cfg.ToESConfig(false)
@@ -340,6 +388,28 @@ func TestESProxyConfig(t *testing.T) {
 				"HTTP_PROXY": "http://proxy.com",
 			},
 		},
+		"bypassed host": {
+			cfg: Elasticsearch{
+				ProxyURL:    "http://proxy.com",
+				ProxyBypass: []string{"monitoring.local"},
+			},
+			url: "http://es.monitoring.local:9200",
+		},
+		"host not bypassed": {
+			cfg: Elasticsearch{
+				ProxyURL:    "http://proxy.com",
+				ProxyBypass: []string{"monitoring.local"},
+			},
+			url:  "http://test.com",
+			want: "http://proxy.com",
+		},
+		"bypassed host with proxy from env": {
+			cfg: Elasticsearch{ProxyBypass: []string{"10.0.0.0/8"}},
+			url: "http://10.1.2.3:9200",
+			env: map[string]string{
+				"HTTP_PROXY": "http://proxy.com",
+			},
+		},
 	}
 
 	for name, test := range testcases {
@@ -351,7 +421,7 @@ func TestESProxyConfig(t *testing.T) {
 			require.NoError(t, err)
 
 			transport := res.Transport.(*http.Transport) //nolint:errcheck // test case
-			if test.want == "" {
+			if test.url == "" {
 				require.Nil(t, transport.Proxy)
 				return
 			}
@@ -362,6 +432,10 @@ func TestESProxyConfig(t *testing.T) {
 
 			got, err := transport.Proxy(req)
 			require.NoError(t, err)
+			if test.want == "" {
+				require.Nil(t, got)
+				return
+			}
 
 			if len(test.headers) == 0 {
 				require.Len(t, transport.ProxyConnectHeader, 0)
@@ -400,3 +474,374 @@ func Test_Elasticsearch_DiagRequests(t *testing.T) {
 	require.NotEmpty(t, p)
 	require.Contains(t, string(p), "request 0 successful.")
 }
+
+func TestESHostNormalization(t *testing.T) {
+	cfg := Elasticsearch{
+		Protocol: schemeHTTPS,
+		Path:     "/es",
+		Hosts: []string{
+			"es1",
+			"es2:9201",
+			"http://es3",
+			"https://es4:443/custom",
+			"::1",
+			"[::1]:9202",
+		},
+	}
+	res, err := cfg.ToESConfig(false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"https://es1:9200/es",
+		"https://es2:9201/es",
+		"http://es3:9200/es",
+		"https://es4:443/custom",
+		"https://[::1]:9200/es",
+		"https://[::1]:9202/es",
+	}, res.Addresses)
+}
+
+func TestESTLSConfig(t *testing.T) {
+	caPath := filepath.Join("testdata", "ca.crt")
+	caPEM, err := os.ReadFile(caPath)
+	require.NoError(t, err)
+	expectedPool, errs := tlscommon.LoadCertificateAuthorities([]string{caPath})
+	require.Empty(t, errs)
+
+	tests := map[string]struct {
+		tls              *tlscommon.Config
+		rootCAs          bool
+		insecure         bool
+		verifyConnection bool
+	}{
+		"ca file path": {
+			tls:              &tlscommon.Config{CAs: []string{caPath}},
+			rootCAs:          true,
+			insecure:         true,
+			verifyConnection: true,
+		},
+		"inline ca": {
+			tls:              &tlscommon.Config{CAs: []string{string(caPEM)}},
+			rootCAs:          true,
+			insecure:         true,
+			verifyConnection: true,
+		},
+		"verification none": {
+			tls:      &tlscommon.Config{VerificationMode: tlscommon.VerifyNone},
+			insecure: true,
+		},
+		"verification certificate": {
+			tls:              &tlscommon.Config{VerificationMode: tlscommon.VerifyCertificate, CAs: []string{caPath}},
+			rootCAs:          true,
+			insecure:         true,
+			verifyConnection: true,
+		},
+		"verification full": {
+			tls:              &tlscommon.Config{VerificationMode: tlscommon.VerifyFull},
+			insecure:         true,
+			verifyConnection: true,
+		},
+		"verification strict": {
+			tls: &tlscommon.Config{VerificationMode: tlscommon.VerifyStrict},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Elasticsearch{Protocol: schemeHTTPS, Hosts: []string{"localhost:9200"}, TLS: tc.tls}
+			res, err := cfg.ToESConfig(false)
+			require.NoError(t, err)
+			tlsCfg := res.Transport.(*http.Transport).TLSClientConfig
+			require.NotNil(t, tlsCfg)
+			if tc.rootCAs {
+				require.NotNil(t, tlsCfg.RootCAs)
+				assert.True(t, expectedPool.Equal(tlsCfg.RootCAs))
+			} else {
+				assert.Nil(t, tlsCfg.RootCAs)
+			}
+			assert.Equal(t, tc.insecure, tlsCfg.InsecureSkipVerify)
+			assert.Equal(t, tc.verifyConnection, tlsCfg.VerifyConnection != nil)
+		})
+	}
+
+	t.Run("invalid verification mode", func(t *testing.T) {
+		c, err := ucfg.NewFrom(map[string]interface{}{"ssl.verification_mode": "sometimes"}, DefaultOptions...)
+		require.NoError(t, err)
+		var es Elasticsearch
+		require.Error(t, c.Unpack(&es, DefaultOptions...))
+	})
+}
+
+func TestElasticsearchValidate(t *testing.T) {
+	tests := map[string]struct {
+		cfg Elasticsearch
+		err string
+	}{
+		"valid": {
+			cfg: Elasticsearch{Hosts: []string{"localhost:9200", "https://es:443"}, Headers: map[string]string{"X-Custom": "value"}},
+		},
+		"invalid host": {
+			cfg: Elasticsearch{Hosts: []string{"localhost:9200", "http://es:port"}},
+			err: `invalid host "http://es:port"`,
+		},
+		"empty header name": {
+			cfg: Elasticsearch{Hosts: []string{"localhost:9200"}, Headers: map[string]string{" ": "value"}},
+			err: "empty header name",
+		},
+		"invalid proxy url": {
+			cfg: Elasticsearch{Hosts: []string{"localhost:9200"}, ProxyURL: "://proxy"},
+			err: "missing protocol scheme",
+		},
+		"invalid proxy url with proxy disabled": {
+			cfg: Elasticsearch{Hosts: []string{"localhost:9200"}, ProxyURL: "://proxy", ProxyDisable: true},
+		},
+		"invalid proxy bypass CIDR": {
+			cfg: Elasticsearch{Hosts: []string{"localhost:9200"}, ProxyBypass: []string{"10.0.0.0/33"}},
+			err: `invalid proxy_bypass entry "10.0.0.0/33"`,
+		},
+		"empty proxy bypass entry": {
+			cfg: Elasticsearch{Hosts: []string{"localhost:9200"}, ProxyBypass: []string{"monitoring.local", " "}},
+			err: "proxy_bypass can not contain an empty entry",
+		},
+		"invalid verification min version": {
+			cfg: Elasticsearch{Hosts: []string{"localhost:9200"}, Verification: ESVerification{MinVersion: "eight"}},
+			err: `invalid verification.min_version "eight"`,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if tc.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestElasticsearchWarnings(t *testing.T) {
+	caPath := filepath.Join("testdata", "ca.crt")
+	tests := map[string]struct {
+		cfg      Elasticsearch
+		warnings []string
+	}{
+		"no warnings": {
+			cfg: Elasticsearch{Protocol: schemeHTTPS, Hosts: []string{"localhost:9200"}, TLS: &tlscommon.Config{CAs: []string{caPath}}},
+		},
+		"verification none": {
+			cfg: Elasticsearch{Protocol: schemeHTTPS, Hosts: []string{"localhost:9200"}, TLS: &tlscommon.Config{VerificationMode: tlscommon.VerifyNone, CAs: []string{caPath}}},
+			warnings: []string{
+				"ssl.verification_mode is none, the certificates of the elasticsearch hosts are not verified",
+				"ssl.certificate_authorities are ignored because ssl.verification_mode is none",
+			},
+		},
+		"ssl without https hosts": {
+			cfg:      Elasticsearch{Protocol: schemeHTTP, Hosts: []string{"localhost:9200", "http://es:9200"}, TLS: &tlscommon.Config{CAs: []string{caPath}}},
+			warnings: []string{"ssl settings are ignored because no elasticsearch host uses https"},
+		},
+		"ssl with one https host": {
+			cfg: Elasticsearch{Protocol: schemeHTTP, Hosts: []string{"localhost:9200", "https://es:9200"}, TLS: &tlscommon.Config{CAs: []string{caPath}}},
+		},
+		"proxy disabled": {
+			cfg:      Elasticsearch{Hosts: []string{"localhost:9200"}, ProxyURL: "http://proxy:8080", ProxyDisable: true},
+			warnings: []string{"proxy_url is ignored because proxy_disable is set"},
+		},
+		"proxy bypass with proxy disabled": {
+			cfg:      Elasticsearch{Hosts: []string{"localhost:9200"}, ProxyBypass: []string{"monitoring.local"}, ProxyDisable: true},
+			warnings: []string{"proxy_bypass is ignored because proxy_disable is set"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.warnings, tc.cfg.Warnings())
+		})
+	}
+}
+
+func TestElasticsearchAuthConfig(t *testing.T) {
+	tests := map[string]struct {
+		cfg string
+		err string
+	}{
+		"service token": {
+			cfg: "{service_token: test-token}",
+		},
+		"service token path": {
+			cfg: "{service_token_path: /path/to/token}",
+		},
+		"username and password": {
+			cfg: "{username: fleet, password: secret}",
+		},
+		"service token and username": {
+			cfg: "{service_token: test-token, username: fleet, password: secret}",
+			err: "service_token and username/password can not both be set",
+		},
+		"service token path and password": {
+			cfg: "{service_token_path: /path/to/token, username: fleet, password: secret}",
+			err: "service_token and username/password can not both be set",
+		},
+		"username without password": {
+			cfg: "{username: fleet}",
+			err: "username and password must be set together",
+		},
+		"username and password path": {
+			cfg: "{username: fleet, password_path: /path/to/password}",
+		},
+		"service token and password path": {
+			cfg: "{service_token: test-token, username: fleet, password_path: /path/to/password}",
+			err: "service_token and username/password can not both be set",
+		},
+		"password path without username": {
+			cfg: "{password_path: /path/to/password}",
+			err: "username and password must be set together",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, err := yaml.NewConfig([]byte("output.elasticsearch: "+tc.cfg+"\ninputs: [{type: fleet-server}]"), DefaultOptions...)
+			require.NoError(t, err)
+			_, err = FromConfig(c)
+			if tc.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestToESConfigAuth(t *testing.T) {
+	t.Run("service_token_path is trimmed", func(t *testing.T) {
+		cfg := Elasticsearch{Hosts: []string{"localhost:9200"}, ServiceTokenPath: writeTestFile(t, "test-token\n")}
+		res, err := cfg.ToESConfig(false)
+		require.NoError(t, err)
+		assert.Equal(t, "test-token", res.ServiceToken)
+		assert.Empty(t, res.Username)
+	})
+	t.Run("username and password", func(t *testing.T) {
+		cfg := Elasticsearch{Hosts: []string{"localhost:9200"}, Username: "fleet", Password: "secret"}
+		res, err := cfg.ToESConfig(false)
+		require.NoError(t, err)
+		assert.Empty(t, res.ServiceToken)
+		assert.Equal(t, "fleet", res.Username)
+		assert.Equal(t, "secret", res.Password)
+	})
+	t.Run("password_path is trimmed", func(t *testing.T) {
+		cfg := Elasticsearch{Hosts: []string{"localhost:9200"}, Username: "fleet", PasswordPath: writeTestFile(t, "secret\n")}
+		res, err := cfg.ToESConfig(false)
+		require.NoError(t, err)
+		assert.Equal(t, "fleet", res.Username)
+		assert.Equal(t, "secret", res.Password)
+	})
+	t.Run("password_path does not exist", func(t *testing.T) {
+		cfg := Elasticsearch{Hosts: []string{"localhost:9200"}, Username: "fleet", PasswordPath: filepath.Join(t.TempDir(), "missing")}
+		_, err := cfg.ToESConfig(false)
+		assert.ErrorContains(t, err, "unable to read password_path")
+	})
+}
+
+func TestElasticsearchRetryConfig(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		c, err := yaml.NewConfig([]byte("output.elasticsearch: {service_token: test-token}\ninputs: [{type: fleet-server}]"), DefaultOptions...)
+		require.NoError(t, err)
+		cfg, err := FromConfig(c)
+		require.NoError(t, err)
+		es := cfg.Output.Elasticsearch
+		assert.Equal(t, 3, es.MaxRetries)
+		assert.Empty(t, es.RetryOnStatus)
+		assert.Equal(t, ESBackoff{Init: 500 * time.Millisecond, Max: 10 * time.Second}, es.Backoff)
+		assert.Equal(t, 100, es.MaxIdleConns)
+	})
+	t.Run("custom", func(t *testing.T) {
+		c, err := yaml.NewConfig([]byte(`
+output.elasticsearch:
+  service_token: test-token
+  max_retries: 5
+  retry_on_status: [503]
+  backoff: {init: 1s, max: 30s}
+  max_conn_per_host: 16
+  max_idle_conns: 8
+  timeout: 10s
+inputs: [{type: fleet-server}]`), DefaultOptions...)
+		require.NoError(t, err)
+		cfg, err := FromConfig(c)
+		require.NoError(t, err)
+		es := cfg.Output.Elasticsearch
+		assert.Equal(t, 5, es.MaxRetries)
+		assert.Equal(t, []int{503}, es.RetryOnStatus)
+		assert.Equal(t, ESBackoff{Init: time.Second, Max: 30 * time.Second}, es.Backoff)
+
+		res, err := es.ToESConfig(false)
+		require.NoError(t, err)
+		assert.Equal(t, 5, res.MaxRetries)
+		assert.Equal(t, []int{503}, res.RetryOnStatus)
+		assert.False(t, res.DisableRetry)
+		require.NotNil(t, res.RetryBackoff)
+		transport := res.Transport.(*http.Transport)
+		assert.Equal(t, 16, transport.MaxConnsPerHost)
+		assert.Equal(t, 8, transport.MaxIdleConns)
+		assert.Equal(t, 8, transport.MaxIdleConnsPerHost)
+		assert.Equal(t, 10*time.Second, transport.ResponseHeaderTimeout)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for cfg, msg := range map[string]string{
+			"{retry_on_status: [503, 600]}":       "invalid HTTP status 600",
+			"{backoff: {init: 10s, max: 1s}}":     "backoff.max can not be less than backoff.init",
+			"{retry_on_status: [0], backoff: {}}": "invalid HTTP status 0",
+		} {
+			c, err := yaml.NewConfig([]byte("output.elasticsearch: "+cfg+"\ninputs: [{type: fleet-server}]"), DefaultOptions...)
+			require.NoError(t, err)
+			_, err = FromConfig(c)
+			assert.ErrorContains(t, err, msg, cfg)
+		}
+	})
+}
+
+func TestToESConfigRetries(t *testing.T) {
+	var def Elasticsearch
+	def.InitDefaults()
+
+	t.Run("retries enabled", func(t *testing.T) {
+		res, err := def.ToESConfig(false)
+		require.NoError(t, err)
+		assert.False(t, res.DisableRetry)
+		assert.Equal(t, 3, res.MaxRetries)
+		assert.Equal(t, []int{429, 408, 425, 502, 503, 504}, res.RetryOnStatus)
+	})
+	t.Run("long poll disables retries", func(t *testing.T) {
+		res, err := def.ToESConfig(true)
+		require.NoError(t, err)
+		assert.True(t, res.DisableRetry)
+	})
+	t.Run("zero max_retries disables retries", func(t *testing.T) {
+		cfg := def
+		cfg.MaxRetries = 0
+		res, err := cfg.ToESConfig(false)
+		require.NoError(t, err)
+		assert.True(t, res.DisableRetry)
+	})
+	t.Run("zero backoff retries immediately", func(t *testing.T) {
+		cfg := def
+		cfg.Backoff = ESBackoff{}
+		res, err := cfg.ToESConfig(false)
+		require.NoError(t, err)
+		assert.Nil(t, res.RetryBackoff)
+	})
+}
+
+func TestESBackoffDelay(t *testing.T) {
+	b := ESBackoff{Init: 100 * time.Millisecond, Max: time.Second}
+	for attempt, want := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		4: 800 * time.Millisecond,
+		5: time.Second,
+		9: time.Second,
+	} {
+		for i := 0; i < 10; i++ {
+			d := b.Delay(attempt)
+			assert.GreaterOrEqual(t, d, want/2, "attempt %d", attempt)
+			assert.LessOrEqual(t, d, want, "attempt %d", attempt)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// bypassProxy returns the proxy function of the transport that connects directly to the hosts matching one of the
+// entries of bypass, the other requests use the proxy returned by proxy.
+// The entries follow the NO_PROXY conventions: * matches all the hosts, an IP address or a CIDR matches the IP
+// addresses it contains, a domain name matches the domain and its subdomains and a domain starting with a dot only
+// its subdomains. Any entry may end with a port to only match this port.
+func bypassProxy(bypass []string, proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if proxyBypassed(bypass, req.URL) {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
+
+// proxyBypassed returns true if u matches one of the entries of bypass.
+func proxyBypassed(bypass []string, u *url.URL) bool {
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == schemeHTTPS {
+			port = "443"
+		}
+	}
+	addr, addrErr := netip.ParseAddr(host)
+	for _, entry := range bypass {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" {
+			return true
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			if addrErr == nil && prefix.Contains(addr.Unmap()) {
+				return true
+			}
+			continue
+		}
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		entryHost = strings.Trim(entryHost, "[]")
+		if entryAddr, err := netip.ParseAddr(entryHost); err == nil {
+			if addrErr == nil && entryAddr.Unmap() == addr.Unmap() {
+				return true
+			}
+			continue
+		}
+		if subdomains, ok := strings.CutPrefix(entryHost, "."); ok {
+			if strings.HasSuffix(host, "."+subdomains) {
+				return true
+			}
+			continue
+		}
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateProxyBypass returns an error if an entry of bypass is not a host, an IP address or a CIDR.
+func validateProxyBypass(bypass []string) error {
+	for _, entry := range bypass {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			return fmt.Errorf("proxy_bypass can not contain an empty entry")
+		case strings.Contains(entry, "/"):
+			if _, err := netip.ParsePrefix(entry); err != nil {
+				return fmt.Errorf("invalid proxy_bypass entry %q: %w", entry, err)
+			}
+		case strings.ContainsAny(entry, " \t?#@"):
+			return fmt.Errorf("invalid proxy_bypass entry %q: expected a host, an IP address or a CIDR", entry)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package config
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyBypassed(t *testing.T) {
+	tests := []struct {
+		bypass   []string
+		host     string
+		bypassed bool
+	}{
+		{bypass: nil, host: "http://es:9200"},
+		{bypass: []string{"*"}, host: "http://es:9200", bypassed: true},
+		{bypass: []string{"es"}, host: "http://es:9200", bypassed: true},
+		{bypass: []string{"ES"}, host: "http://es:9200", bypassed: true},
+		{bypass: []string{"es"}, host: "http://other-es:9200"},
+		{bypass: []string{"monitoring.local"}, host: "http://monitoring.local:9200", bypassed: true},
+		{bypass: []string{"monitoring.local"}, host: "https://es.monitoring.local", bypassed: true},
+		{bypass: []string{".monitoring.local"}, host: "https://es.monitoring.local", bypassed: true},
+		{bypass: []string{".monitoring.local"}, host: "https://monitoring.local"},
+		{bypass: []string{"monitoring.local:9200"}, host: "http://monitoring.local:9200", bypassed: true},
+		{bypass: []string{"monitoring.local:9200"}, host: "http://monitoring.local:9300"},
+		{bypass: []string{"monitoring.local:443"}, host: "https://monitoring.local", bypassed: true},
+		{bypass: []string{"10.0.0.1"}, host: "http://10.0.0.1:9200", bypassed: true},
+		{bypass: []string{"10.0.0.1"}, host: "http://10.0.0.2:9200"},
+		{bypass: []string{"10.0.0.0/8"}, host: "http://10.1.2.3:9200", bypassed: true},
+		{bypass: []string{"10.0.0.0/8"}, host: "http://192.168.1.1:9200"},
+		{bypass: []string{"10.0.0.0/8"}, host: "http://ten.local:9200"},
+		{bypass: []string{"::1"}, host: "http://[::1]:9200", bypassed: true},
+		{bypass: []string{"[::1]:9200"}, host: "http://[::1]:9200", bypassed: true},
+		{bypass: []string{"fd00::/8"}, host: "http://[fd00::1]:9200", bypassed: true},
+		{bypass: []string{"other", " es "}, host: "http://es:9200", bypassed: true},
+	}
+	for _, tc := range tests {
+		u, err := url.Parse(tc.host)
+		require.NoError(t, err)
+		require.Equal(t, tc.bypassed, proxyBypassed(tc.bypass, u), "%v bypasses %s", tc.bypass, tc.host)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ReloadDiff describes the differences between a running configuration and a reloaded one.
+type ReloadDiff struct {
+	// Changed lists the settings that are applied by the reload.
+	Changed []string
+	// RestartRequired lists the settings that changed but are only applied on restart.
+	RestartRequired []string
+}
+
+// IsEmpty returns true if the reloaded configuration does not contain any changes.
+func (d ReloadDiff) IsEmpty() bool {
+	return len(d.Changed) == 0 && len(d.RestartRequired) == 0
+}
+
+// ApplyReload returns a copy of the running configuration c with the settings
+// that can safely be changed at runtime taken from next: the logging level,
+// the server limits, timeouts and bulk settings of Server.ApplyInPlace, action TTLs, cache sizes and the monitoring
+// endpoint authentication.
+// All other changes are reported in the diff as requiring a restart and are not applied.
+func (c *Config) ApplyReload(next *Config) (*Config, ReloadDiff, error) {
+	if err := c.Validate(); err != nil {
+		return nil, ReloadDiff{}, err
+	}
+	if err := next.Validate(); err != nil {
+		return nil, ReloadDiff{}, err
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var diff ReloadDiff
+	merged := &Config{
+		Fleet:   c.Fleet,
+		Output:  c.Output,
+		Inputs:  []Input{c.Inputs[0]},
+		Logging: c.Logging,
+		HTTP:    c.HTTP,
+	}
+	cur, nxt := &c.Inputs[0], &next.Inputs[0]
+
+	// Settings applied at runtime
+	if c.Logging.Level != next.Logging.Level {
+		diff.Changed = append(diff.Changed, "logging.level")
+		merged.Logging.Level = next.Logging.Level
+	}
+	inPlace := cur.Server.ApplyInPlace(&nxt.Server)
+	if !reflect.DeepEqual(cur.Server.Limits, inPlace.Limits) {
+		diff.Changed = append(diff.Changed, "inputs.server.limits")
+		merged.Inputs[0].Server.Limits = inPlace.Limits
+	}
+	if cur.Server.Timeouts != inPlace.Timeouts {
+		diff.Changed = append(diff.Changed, "inputs.server.timeouts")
+		merged.Inputs[0].Server.Timeouts = inPlace.Timeouts
+	}
+	if cur.Server.Bulk != inPlace.Bulk {
+		diff.Changed = append(diff.Changed, "inputs.server.bulk")
+		merged.Inputs[0].Server.Bulk = inPlace.Bulk
+	}
+	if !reflect.DeepEqual(cur.Server.Actions, nxt.Server.Actions) {
+		diff.Changed = append(diff.Changed, "inputs.server.actions")
+		merged.Inputs[0].Server.Actions = nxt.Server.Actions
+	}
+	if !reflect.DeepEqual(cur.Cache, nxt.Cache) {
+		diff.Changed = append(diff.Changed, "inputs.cache")
+		merged.Inputs[0].Cache = nxt.Cache
+	}
+	if c.HTTP.Auth != next.HTTP.Auth {
+		diff.Changed = append(diff.Changed, "http.auth")
+		merged.HTTP.Auth = next.HTTP.Auth
+	}
+
+	// Settings that require a restart; the fleet section holds the generated agent metadata and is ignored.
+	nextLogging := next.Logging
+	nextLogging.Level = c.Logging.Level
+	if !reflect.DeepEqual(c.Logging, nextLogging) {
+		diff.RestartRequired = append(diff.RestartRequired, "logging")
+	}
+	if !reflect.DeepEqual(c.Output, next.Output) {
+		diff.RestartRequired = append(diff.RestartRequired, "output")
+	}
+	nextHTTP := next.HTTP
+	nextHTTP.Auth = c.HTTP.Auth
+	if !reflect.DeepEqual(c.HTTP, nextHTTP) {
+		diff.RestartRequired = append(diff.RestartRequired, "http")
+	}
+	if cur.Type != nxt.Type || !reflect.DeepEqual(cur.Policy, nxt.Policy) || !reflect.DeepEqual(cur.Monitor, nxt.Monitor) {
+		diff.RestartRequired = append(diff.RestartRequired, "inputs")
+	}
+	diff.RestartRequired = append(diff.RestartRequired, serverRestartRequired(&cur.Server, &nxt.Server)...)
+	sort.Strings(diff.RestartRequired)
+
+	return merged, diff, nil
+}
+
+// serverRestartRequired returns the names of the server settings that differ and are not applied in place. The limits,
+// timeouts and bulk settings are named by their fields, as some of them are applied in place.
+func serverRestartRequired(cur, next *Server) []string {
+	applied := cur.ApplyInPlace(next)
+	var names []string
+	av, nv := reflect.ValueOf(&applied).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < av.NumField(); i++ {
+		if reflect.DeepEqual(av.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		name := configName(av.Type().Field(i))
+		switch name {
+		case "limits", "timeouts", "bulk":
+			names = append(names, changedFields("inputs.server."+name, av.Field(i), nv.Field(i))...)
+		default:
+			names = append(names, "inputs.server."+name)
+		}
+	}
+	return names
+}
+
+// changedFields returns the names of the fields that differ between the structs cur and next, prefixed with prefix.
+func changedFields(prefix string, cur, next reflect.Value) []string {
+	var names []string
+	for i := 0; i < cur.NumField(); i++ {
+		if !reflect.DeepEqual(cur.Field(i).Interface(), next.Field(i).Interface()) {
+			names = append(names, prefix+"."+configName(cur.Type().Field(i)))
+		}
+	}
+	return names
+}
+
+// configName returns the name of the setting of a configuration field.
+func configName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("config"), ",")
+	return name
+}
+
+// ApplyInPlace returns a copy of c with the settings of next that the running server applies without a restart: the
+// limits other than the max header size and the concurrency ceiling, the timeouts other than those of the listener, and
+// the flush interval, flush thresholds and search response size of the bulker, and the action settings.
+// The other settings, such as the listener timeouts or the pending flushes and the spool of the bulker, need a restart.
+func (c *Server) ApplyInPlace(next *Server) Server {
+	s := *c
+	l, nl := &s.Limits, &next.Limits
+	l.MaxAgents = nl.MaxAgents
+	l.MaxConnections = nl.MaxConnections
+	l.MaxActionTargets = nl.MaxActionTargets
+	l.MaxBulkEnrollAgents = nl.MaxBulkEnrollAgents
+	l.PolicyQuotas = nl.PolicyQuotas
+	l.PolicySize = nl.PolicySize
+	l.ActionLimit = nl.ActionLimit
+	l.PolicyLimit = nl.PolicyLimit
+	l.CheckinLimit = nl.CheckinLimit
+	l.ArtifactLimit = nl.ArtifactLimit
+	l.EnrollLimit = nl.EnrollLimit
+	l.AckLimit = nl.AckLimit
+	l.StatusLimit = nl.StatusLimit
+	l.UploadStartLimit = nl.UploadStartLimit
+	l.UploadEndLimit = nl.UploadEndLimit
+	l.UploadChunkLimit = nl.UploadChunkLimit
+	l.DeliverFileLimit = nl.DeliverFileLimit
+	l.GetPGPKey = nl.GetPGPKey
+	l.AuditUnenrollLimit = nl.AuditUnenrollLimit
+	l.CreateActionsLimit = nl.CreateActionsLimit
+	l.AgentTagsLimit = nl.AgentTagsLimit
+	l.ActionResultsLimit = nl.ActionResultsLimit
+	l.ReassignAgentsLimit = nl.ReassignAgentsLimit
+	l.PolicyFetchLimit = nl.PolicyFetchLimit
+	l.PolicyRolloutLimit = nl.PolicyRolloutLimit
+	l.BulkEnrollLimit = nl.BulkEnrollLimit
+
+	t, nt := &s.Timeouts, &next.Timeouts
+	t.CheckinTimestamp = nt.CheckinTimestamp
+	t.CheckinLongPoll = nt.CheckinLongPoll
+	t.CheckinJitter = nt.CheckinJitter
+	t.CheckinMaxPoll = nt.CheckinMaxPoll
+	t.CheckinKeepAlive = nt.CheckinKeepAlive
+	t.Drain = nt.Drain
+
+	b, nb := &s.Bulk, &next.Bulk
+	b.FlushInterval = nb.FlushInterval
+	b.FlushThresholdCount = nb.FlushThresholdCount
+	b.FlushThresholdSize = nb.FlushThresholdSize
+	b.SearchMaxResponseSize = nb.SearchMaxResponseSize
+
+	s.Actions = next.Actions
+	return s
+}
@@ -0,0 +1,148 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testReloadConfig() *Config {
+	cfg := &Config{}
+	cfg.InitDefaults()
+	cfg.Output.Elasticsearch.InitDefaults()
+	cfg.Fleet.Agent.ID = "agent-id"
+	return cfg
+}
+
+func TestApplyReload(t *testing.T) {
+	t.Run("no changes", func(t *testing.T) {
+		merged, diff, err := testReloadConfig().ApplyReload(testReloadConfig())
+		require.NoError(t, err)
+		require.True(t, diff.IsEmpty())
+		require.Equal(t, testReloadConfig().Inputs, merged.Inputs)
+	})
+
+	t.Run("reloadable settings", func(t *testing.T) {
+		cur := testReloadConfig()
+		next := testReloadConfig()
+		next.Fleet.Agent.ID = "regenerated-id"
+		next.Logging.Level = "debug"
+		next.Inputs[0].Server.Timeouts.CheckinLongPoll = time.Minute
+		next.Inputs[0].Server.Limits.CheckinLimit.Interval = time.Second
+		next.Inputs[0].Server.Bulk.FlushInterval = time.Second
+		next.Inputs[0].Server.Actions.TTL = map[string]time.Duration{"UPGRADE": time.Hour}
+		next.Inputs[0].Cache.NumCounters = 42
+		next.HTTP.Auth = HTTPAuth{Enabled: true, Token: "rotated"}
+
+		merged, diff, err := cur.ApplyReload(next)
+		require.NoError(t, err)
+		require.Equal(t, []string{"logging.level", "inputs.server.limits", "inputs.server.timeouts", "inputs.server.bulk", "inputs.server.actions", "inputs.cache", "http.auth"}, diff.Changed)
+		require.Empty(t, diff.RestartRequired)
+
+		require.Equal(t, "debug", merged.Logging.Level)
+		require.Equal(t, time.Minute, merged.Inputs[0].Server.Timeouts.CheckinLongPoll)
+		require.Equal(t, time.Second, merged.Inputs[0].Server.Limits.CheckinLimit.Interval)
+		require.Equal(t, time.Second, merged.Inputs[0].Server.Bulk.FlushInterval)
+		require.Equal(t, time.Hour, merged.Inputs[0].Server.Actions.ActionTTL("UPGRADE"))
+		require.Equal(t, int64(42), merged.Inputs[0].Cache.NumCounters)
+		require.Equal(t, "rotated", merged.HTTP.Auth.Token)
+		require.Equal(t, "agent-id", merged.Fleet.Agent.ID, "agent metadata is kept")
+
+		// the running configuration is not modified
+		require.Equal(t, testReloadConfig().Inputs, cur.Inputs)
+	})
+
+	t.Run("restart required", func(t *testing.T) {
+		next := testReloadConfig()
+		next.Inputs[0].Server.Host = "10.0.0.1"
+		next.Inputs[0].Server.Port = 9000
+		next.Inputs[0].Server.Timeouts.CheckinLongPoll = time.Minute
+		next.Output.Elasticsearch.Hosts = []string{"remote:9200"}
+		next.Logging.Pretty = true
+		next.HTTP.Port = 5067
+
+		merged, diff, err := testReloadConfig().ApplyReload(next)
+		require.NoError(t, err)
+		require.Equal(t, []string{"inputs.server.timeouts"}, diff.Changed)
+		require.Equal(t, []string{"http", "inputs.server.host", "inputs.server.port", "logging", "output"}, diff.RestartRequired)
+
+		require.Equal(t, kDefaultHost, merged.Inputs[0].Server.Host)
+		require.Equal(t, uint16(kDefaultPort), merged.Inputs[0].Server.Port)
+		require.Equal(t, testReloadConfig().Output, merged.Output)
+		require.False(t, merged.Logging.Pretty)
+		require.Equal(t, time.Minute, merged.Inputs[0].Server.Timeouts.CheckinLongPoll)
+	})
+
+	t.Run("restart required server settings", func(t *testing.T) {
+		next := testReloadConfig()
+		next.Inputs[0].Server.Timeouts.Read = time.Hour
+		next.Inputs[0].Server.Limits.MaxHeaderByteSize = 1024
+		next.Inputs[0].Server.Limits.MaxConnections = 10
+		next.Inputs[0].Server.Bulk.FlushMaxPending = 1
+		next.Inputs[0].Server.Bulk.FlushThresholdCount = 10
+
+		merged, diff, err := testReloadConfig().ApplyReload(next)
+		require.NoError(t, err)
+		require.Equal(t, []string{"inputs.server.limits", "inputs.server.bulk"}, diff.Changed)
+		require.Equal(t, []string{"inputs.server.bulk.flush_max_pending", "inputs.server.limits.max_header_byte_size", "inputs.server.timeouts.read"}, diff.RestartRequired)
+
+		cur := testReloadConfig().Inputs[0].Server
+		require.Equal(t, 10, merged.Inputs[0].Server.Limits.MaxConnections)
+		require.Equal(t, 10, merged.Inputs[0].Server.Bulk.FlushThresholdCount)
+		require.Equal(t, cur.Timeouts, merged.Inputs[0].Server.Timeouts)
+		require.Equal(t, cur.Limits.MaxHeaderByteSize, merged.Inputs[0].Server.Limits.MaxHeaderByteSize)
+		require.Equal(t, cur.Bulk.FlushMaxPending, merged.Inputs[0].Server.Bulk.FlushMaxPending)
+
+		// the merged configuration is applied in place
+		require.Equal(t, merged.Inputs[0].Server, cur.ApplyInPlace(&merged.Inputs[0].Server))
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		next := testReloadConfig()
+		next.Inputs = nil
+		_, _, err := testReloadConfig().ApplyReload(next)
+		require.Error(t, err)
+	})
+}
+
+func TestApplyInPlace(t *testing.T) {
+	cur := testReloadConfig().Inputs[0].Server
+	next := testReloadConfig().Inputs[0].Server
+	next.Timeouts.CheckinLongPoll = time.Minute
+	next.Timeouts.Drain = time.Minute
+	next.Limits.CheckinLimit = Limit{Interval: time.Second, Burst: 10, Max: 100, MaxBody: 1024}
+	next.Limits.MaxActionTargets = 42
+	next.Limits.MaxConnections = 10
+	next.Limits.ActionLimit.Interval = time.Second
+	next.Limits.PolicyLimit.Burst = 5
+	next.Bulk.FlushInterval = time.Second
+	next.Bulk.FlushThresholdCount = 10
+	next.Bulk.FlushThresholdSize = 1024
+	next.Bulk.SearchMaxResponseSize = 1024
+	next.Actions.TTL = map[string]time.Duration{"UPGRADE": time.Hour}
+
+	// the settings applied by the running server leave nothing to restart
+	require.Equal(t, next, cur.ApplyInPlace(&next))
+
+	next.Timeouts.Write = time.Minute
+	next.Limits.MaxHeaderByteSize = 1024
+	next.Bulk.FlushMaxPending = 1
+	next.Bulk.Spool.Enabled = !next.Bulk.Spool.Enabled
+	s := cur.ApplyInPlace(&next)
+	require.NotEqual(t, next, s)
+	require.Equal(t, time.Minute, s.Timeouts.CheckinLongPoll)
+	require.Equal(t, 42, s.Limits.MaxActionTargets)
+	require.Equal(t, 10, s.Limits.MaxConnections)
+	require.Equal(t, time.Second, s.Bulk.FlushInterval)
+	require.Equal(t, cur.Timeouts.Write, s.Timeouts.Write)
+	require.Equal(t, cur.Limits.MaxHeaderByteSize, s.Limits.MaxHeaderByteSize)
+	require.Equal(t, cur.Bulk.FlushMaxPending, s.Bulk.FlushMaxPending)
+	require.Equal(t, cur.Bulk.Spool, s.Bulk.Spool)
+}
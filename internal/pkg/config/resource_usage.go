@@ -0,0 +1,25 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+const defaultMaxFDRatio = 0.9
+
+// ResourceUsage is the configuration of the warning thresholds on the resources used by the fleet server process.
+// A healthy instance that exceeds one of them reports a DEGRADED status. A zero threshold is disabled.
+type ResourceUsage struct {
+	// MaxFDRatio is the fraction of the open file descriptors limit the process may use.
+	MaxFDRatio float64 `config:"max_fd_ratio"`
+	// MaxRSS is the resident memory in bytes.
+	MaxRSS int `config:"max_rss"`
+	// MaxGoroutines is the number of goroutines.
+	MaxGoroutines int `config:"max_goroutines"`
+	// MaxCPUPercent is the percentage of all the CPUs of the host used since the previous sample.
+	MaxCPUPercent float64 `config:"max_cpu_percent"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *ResourceUsage) InitDefaults() {
+	c.MaxFDRatio = defaultMaxFDRatio
+}
@@ -0,0 +1,21 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import "time"
+
+const defaultUnenrolledAgentsRetention = 90 * 24 * time.Hour
+
+// Retention is the configuration of the time the fleet documents are kept.
+type Retention struct {
+	// UnenrolledAgents is the time the documents of the unenrolled agents are kept. The GC then replaces each of them with
+	// a tombstone in the logs-fleet_server.agent_tombstones-default data stream. A zero UnenrolledAgents keeps them forever.
+	UnenrolledAgents time.Duration `config:"unenrolled_agents"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *Retention) InitDefaults() {
+	c.UnenrolledAgents = defaultUnenrolledAgentsRetention
+}
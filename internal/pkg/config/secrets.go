@@ -0,0 +1,97 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"os"
+	"strings"
+
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+)
+
+// Secrets returns the values of the sensitive attributes of the configuration,
+// including the contents of the files referenced by the *_path attributes.
+// Files that can not be read are skipped, the error is reported where the attribute is used.
+func (c *Config) Secrets() []string {
+	s := secretSet{}
+
+	es := &c.Output.Elasticsearch
+	s.add(es.ServiceToken)
+	s.addFile(es.ServiceTokenPath)
+	s.add(es.Password)
+	s.addFile(es.PasswordPath)
+	for k, v := range es.Headers {
+		if isSensitiveHeader(k) {
+			s.add(v)
+		}
+	}
+	for k, v := range es.ProxyHeaders {
+		if isSensitiveHeader(k) {
+			s.add(v)
+		}
+	}
+	if es.TLS != nil {
+		s.addCertificate(es.TLS.Certificate)
+	}
+
+	for i := range c.Inputs {
+		srv := &c.Inputs[i].Server
+		if srv.TLS != nil {
+			s.addCertificate(srv.TLS.Certificate)
+		}
+		s.add(srv.Instrumentation.APIKey)
+		s.addFile(srv.Instrumentation.APIKeyPath)
+		s.add(srv.Instrumentation.SecretToken)
+		s.addFile(srv.Instrumentation.SecretTokenPath)
+		for _, pt := range srv.StaticPolicyTokens.PolicyTokens {
+			s.add(pt.TokenKey)
+			s.addFile(pt.TokenKeyPath)
+		}
+	}
+
+	s.add(c.HTTP.Auth.Token)
+	s.addFile(c.HTTP.Auth.TokenPath)
+	return s.values
+}
+
+type secretSet struct {
+	values []string
+	seen   map[string]struct{}
+}
+
+func (s *secretSet) add(v string) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return
+	}
+	if _, ok := s.seen[v]; ok {
+		return
+	}
+	if s.seen == nil {
+		s.seen = make(map[string]struct{})
+	}
+	s.seen[v] = struct{}{}
+	s.values = append(s.values, v)
+}
+
+func (s *secretSet) addFile(path string) {
+	if path == "" {
+		return
+	}
+	p, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	s.add(string(p))
+}
+
+func (s *secretSet) addCertificate(cert tlscommon.CertificateConfig) {
+	// the key is either a path or the PEM encoded key itself
+	if tlscommon.IsPEMString(cert.Key) {
+		s.add(cert.Key)
+	}
+	s.add(cert.Passphrase)
+	s.addFile(cert.PassphrasePath)
+}
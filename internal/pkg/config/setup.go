@@ -0,0 +1,25 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+const (
+	defaultStandalonePolicyID   = "fleet-server-standalone-policy"
+	defaultStandalonePolicyName = "Default standalone policy"
+)
+
+// StandaloneSetup is the configuration used to bootstrap a default agent policy and
+// enrollment API key when fleet-server runs in stand-alone mode without Kibana.
+type StandaloneSetup struct {
+	Enabled    bool   `config:"enabled"`
+	PolicyID   string `config:"policy_id"`
+	PolicyName string `config:"policy_name"`
+}
+
+// InitDefaults initializes the defaults for the configuration.
+func (c *StandaloneSetup) InitDefaults() {
+	c.Enabled = false
+	c.PolicyID = defaultStandalonePolicyID
+	c.PolicyName = defaultStandalonePolicyName
+}
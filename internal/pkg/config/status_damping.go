@@ -0,0 +1,31 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import "time"
+
+const (
+	defaultStatusDampingFlappingThreshold = 10
+	defaultStatusDampingFlappingInterval  = 10 * time.Minute
+)
+
+// StatusDamping is the configuration of the damping of the status changes of the agents that flap between statuses.
+type StatusDamping struct {
+	// Window is the time after a status change is written during which the following changes of the agent are kept in
+	// memory, the last change is written when the window closes. A zero Window, the default, disables the damping.
+	Window time.Duration `config:"window"`
+	// FlappingThreshold is the number of status changes within FlappingInterval above which the agent is marked with
+	// status_flapping. A zero FlappingThreshold disables the marker.
+	FlappingThreshold int `config:"flapping_threshold"`
+	// FlappingInterval is the interval the status changes are counted over.
+	FlappingInterval time.Duration `config:"flapping_interval"`
+}
+
+// InitDefaults initializes the defaults for the configuration, the damping is disabled.
+func (c *StatusDamping) InitDefaults() {
+	c.Window = 0
+	c.FlappingThreshold = defaultStatusDampingFlappingThreshold
+	c.FlappingInterval = defaultStatusDampingFlappingInterval
+}
@@ -18,6 +18,7 @@ type ServerTimeouts struct {
 	CheckinLongPoll  time.Duration `config:"checkin_long_poll"`
 	CheckinJitter    time.Duration `config:"checkin_jitter"`
 	CheckinMaxPoll   time.Duration `config:"checkin_max_poll"`
+	CheckinKeepAlive time.Duration `config:"checkin_keepalive"`
 	Drain            time.Duration `config:"drain"`
 }
 
@@ -66,6 +67,11 @@ func (c *ServerTimeouts) InitDefaults() {
 	// CheckinMaxPoll values of less then 1m are effectively ignored and a 1m limit is used.
 	c.CheckinMaxPoll = time.Hour
 
+	// KeepAlive is the interval at which a long poll writes a newline to the response while it waits.
+	// It keeps the load balancers and proxies that close idle connections from closing the long poll. Disabled if zero.
+	// The newline is sent with the status of the response, which is no longer compressed.
+	c.CheckinKeepAlive = 0
+
 	// Drain is the max duration that a server will keep connections open when a shutdown signal is received in order to gracefully handle in progress-requests.
 	// It is used as a context timeout value for server.ShutDown(ctx).
 	// A long-poll checkin connection should immediately return with a 200 status and the same ackToken it was sent, the same as if the long-poll completed with no changes detected.
@@ -0,0 +1,494 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package config
+
+import (
+	"fmt"
+	"math"
+	gopath "path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-ucfg"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/clientip"
+)
+
+// FieldError is a problem with the value of a configuration key.
+type FieldError struct {
+	// Path is the full path of the key, it is empty for problems that apply to the whole configuration.
+	Path string
+	Msg  string
+}
+
+func (e FieldError) Error() string {
+	if e.Path == "" {
+		return e.Msg
+	}
+	return e.Path + ": " + e.Msg
+}
+
+// ValidationError is the report of all the problems found in a configuration.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration, %d problem(s) found:", len(e.Errors))
+	for _, fe := range e.Errors {
+		b.WriteString("\n  ")
+		b.WriteString(fe.Error())
+	}
+	return b.String()
+}
+
+const unknownKey = "unknown configuration key"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ValidateConfig unpacks c after validating every key against the configuration
+// schema. Unknown keys are returned as warnings, or reported as errors when
+// strict is set. Values of the wrong type, missing required settings and values
+// out of range are reported together in a *ValidationError.
+func ValidateConfig(c *ucfg.Config, strict bool) (*Config, []FieldError, error) {
+	var raw map[string]interface{}
+	if err := c.Unpack(&raw, DefaultOptions...); err != nil {
+		return nil, nil, err
+	}
+
+	v := &validator{strict: strict}
+	v.walk("", reflect.TypeOf((*Config)(nil)).Elem(), raw)
+
+	// Keys with an invalid value have been removed so the remaining settings can still be checked.
+	pruned, err := ucfg.NewFrom(raw, expandedOptions...)
+	if err != nil {
+		return nil, v.warnings, err
+	}
+	cfg, err := FromConfig(pruned)
+	if err != nil {
+		v.errors = append(v.errors, FieldError{Msg: err.Error()})
+	} else {
+		v.check(cfg)
+	}
+
+	byPath := func(errs []FieldError) func(i, j int) bool {
+		return func(i, j int) bool { return errs[i].Path < errs[j].Path }
+	}
+	sort.SliceStable(v.errors, byPath(v.errors))
+	sort.SliceStable(v.warnings, byPath(v.warnings))
+	if len(v.errors) > 0 {
+		return nil, v.warnings, &ValidationError{Errors: v.errors}
+	}
+	return cfg, v.warnings, nil
+}
+
+type validator struct {
+	strict   bool
+	errors   []FieldError
+	warnings []FieldError
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	v.errors = append(v.errors, FieldError{Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) unknown(path string) {
+	fe := FieldError{Path: path, Msg: unknownKey}
+	if v.strict {
+		v.errors = append(v.errors, fe)
+	} else {
+		v.warnings = append(v.warnings, fe)
+	}
+}
+
+// walk checks the raw value val at path against type t.
+// It returns false if the value can not be unpacked into t.
+func (v *validator) walk(path string, t reflect.Type, val interface{}) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if val == nil {
+		return true
+	}
+	if t == durationType {
+		return v.checkDuration(path, val)
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			v.fail(path, "must be an object, got %s", describe(val))
+			return false
+		}
+		v.walkStruct(path, t, m)
+		return true
+	case reflect.Slice:
+		l, ok := val.([]interface{})
+		if !ok {
+			// a single value is unpacked as a list with one entry
+			return v.walk(path, t.Elem(), val)
+		}
+		valid := true
+		for i, item := range l {
+			if !v.walk(joinKey(path, strconv.Itoa(i)), t.Elem(), item) {
+				valid = false
+			}
+		}
+		return valid
+	case reflect.Map:
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			v.fail(path, "must be an object, got %s", describe(val))
+			return false
+		}
+		for k, item := range m {
+			if !v.walk(joinKey(path, k), t.Elem(), item) {
+				delete(m, k)
+			}
+		}
+		return true
+	case reflect.Interface:
+		return true
+	}
+
+	if hasUnpack(t) {
+		// custom types validate their own values when unpacked
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if s, ok := val.(string); ok {
+			if _, err := strconv.ParseBool(s); err == nil {
+				return true
+			}
+		}
+		if _, ok := val.(bool); !ok {
+			v.fail(path, "must be a boolean, got %s", describe(val))
+			return false
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.checkInt(path, t, val)
+	case reflect.Float32, reflect.Float64:
+		switch val := val.(type) {
+		case int64, uint64, float64:
+		case string:
+			if _, err := strconv.ParseFloat(val, 64); err != nil {
+				v.fail(path, "must be a number, got %s", describe(val))
+				return false
+			}
+		default:
+			v.fail(path, "must be a number, got %s", describe(val))
+			return false
+		}
+	case reflect.String:
+		switch val.(type) {
+		case []interface{}, map[string]interface{}:
+			v.fail(path, "must be a string, got %s", describe(val))
+			return false
+		}
+	}
+	return true
+}
+
+func (v *validator) walkStruct(path string, t reflect.Type, m map[string]interface{}) {
+	known, inlineMap := make(map[string]reflect.Type), false
+	collectFields(t, known, &inlineMap)
+	for k, val := range m {
+		ft, ok := known[k]
+		if !ok {
+			if !inlineMap {
+				v.unknown(joinKey(path, k))
+			}
+			continue
+		}
+		if !v.walk(joinKey(path, k), ft, val) {
+			delete(m, k)
+		}
+	}
+}
+
+// collectFields adds the config keys of the fields of struct t to known, including the fields of inlined structs.
+// inlineMap is set if t accepts arbitrary keys.
+func collectFields(t reflect.Type, known map[string]reflect.Type, inlineMap *bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("config"), ",")
+		if strings.Contains(opts, "ignore") {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			switch ft.Kind() {
+			case reflect.Struct:
+				collectFields(ft, known, inlineMap)
+			case reflect.Map:
+				*inlineMap = true
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		known[name] = f.Type
+	}
+}
+
+// hasUnpack returns true if the type implements one of the ucfg unpacker interfaces.
+func hasUnpack(t reflect.Type) bool {
+	_, ok := reflect.PointerTo(t).MethodByName("Unpack")
+	return ok
+}
+
+func (v *validator) checkDuration(path string, val interface{}) bool {
+	switch val := val.(type) {
+	case int64, uint64, float64:
+		return true
+	case string:
+		if _, err := time.ParseDuration(val); err == nil {
+			return true
+		}
+	}
+	v.fail(path, "must be a duration, got %s", describe(val))
+	return false
+}
+
+func (v *validator) checkInt(path string, t reflect.Type, val interface{}) bool {
+	var n float64
+	switch val := val.(type) {
+	case int64:
+		n = float64(val)
+	case uint64:
+		n = float64(val)
+	case float64:
+		if val != math.Trunc(val) {
+			v.fail(path, "must be an integer, got %s", describe(val))
+			return false
+		}
+		n = val
+	case string:
+		i, err := strconv.ParseFloat(val, 64)
+		if err != nil || i != math.Trunc(i) {
+			v.fail(path, "must be an integer, got %s", describe(val))
+			return false
+		}
+		n = i
+	default:
+		v.fail(path, "must be an integer, got %s", describe(val))
+		return false
+	}
+
+	bits := t.Bits()
+	switch t.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n < 0 {
+			v.fail(path, "must not be negative, got %s", describe(val))
+			return false
+		}
+		if bits < 64 && n > float64(uint64(1)<<bits-1) {
+			v.fail(path, "must be at most %d, got %s", uint64(1)<<bits-1, describe(val))
+			return false
+		}
+	default:
+		if bits < 64 && (n < -float64(int64(1)<<(bits-1)) || n > float64(int64(1)<<(bits-1)-1)) {
+			v.fail(path, "must be between %d and %d, got %s", -int64(1)<<(bits-1), int64(1)<<(bits-1)-1, describe(val))
+			return false
+		}
+	}
+	return true
+}
+
+// check validates the settings of the unpacked configuration.
+func (v *validator) check(cfg *Config) {
+	es := &cfg.Output.Elasticsearch
+	if len(es.Hosts) == 0 {
+		v.fail("output.elasticsearch.hosts", "must not be empty")
+	}
+	for i, host := range es.Hosts {
+		if strings.TrimSpace(host) == "" {
+			v.fail(joinKey("output.elasticsearch.hosts", strconv.Itoa(i)), "must not be empty")
+		}
+	}
+	if es.Timeout <= 0 {
+		v.fail("output.elasticsearch.timeout", "must be positive, got %s", es.Timeout)
+	}
+	if es.MaxStartupWait <= 0 {
+		v.fail("output.elasticsearch.max_startup_wait", "must be positive, got %s", es.MaxStartupWait)
+	}
+	if es.MaxRetries < 0 {
+		v.fail("output.elasticsearch.max_retries", "must not be negative, got %d", es.MaxRetries)
+	}
+	if es.MaxConnPerHost < 0 {
+		v.fail("output.elasticsearch.max_conn_per_host", "must not be negative, got %d", es.MaxConnPerHost)
+	}
+	if es.MaxIdleConns < 0 {
+		v.fail("output.elasticsearch.max_idle_conns", "must not be negative, got %d", es.MaxIdleConns)
+	}
+	v.checkNumbers("output.elasticsearch.backoff", reflect.ValueOf(es.Backoff), nil)
+	v.checkNumbers("logging.slow", reflect.ValueOf(cfg.Logging.Slow), nil)
+
+	for i := range cfg.Inputs {
+		srv := &cfg.Inputs[i].Server
+		path := joinKey("inputs", strconv.Itoa(i)) + ".server"
+		if srv.Port == 0 {
+			v.fail(path+".port", "must be set")
+		}
+		v.checkNumbers(path+".timeouts", reflect.ValueOf(srv.Timeouts), func(name string) bool {
+			// jitter and keepalive are disabled when they are zero
+			return name != "checkin_jitter" && name != "checkin_keepalive"
+		})
+		v.checkNumbers(path+".limits", reflect.ValueOf(srv.Limits), nil)
+		v.checkConcurrencyQuotas(path+".limits.concurrency.quotas", srv.Limits.Concurrency.Quotas)
+		v.checkNumbers(path+".bulk", reflect.ValueOf(srv.Bulk), nil)
+		if sp := srv.Bulk.Spool; sp.Enabled {
+			if sp.Path == "" {
+				v.fail(path+".bulk.spool.path", "must be set")
+			}
+			if sp.MaxSize <= 0 {
+				v.fail(path+".bulk.spool.max_size", "must be positive, got %d", sp.MaxSize)
+			}
+			// only the writes that overflow the low priority lane are spooled
+			if srv.Bulk.LowPriorityMaxSize == 0 {
+				v.fail(path+".bulk.spool.enabled", "requires bulk.low_priority_max_size")
+			}
+		}
+		v.checkNumbers(path+".gc", reflect.ValueOf(srv.GC), nil)
+		if ak := srv.GC.APIKeys; ak.Enabled && ak.BatchSize <= 0 {
+			v.fail(path+".gc.api_keys.batch_size", "must be positive, got %d", ak.BatchSize)
+		}
+		if ak := srv.GC.APIKeys; ak.Enabled && ak.MaxBatches <= 0 {
+			v.fail(path+".gc.api_keys.max_batches", "must be positive, got %d", ak.MaxBatches)
+		}
+		v.checkNumbers(path+".retention", reflect.ValueOf(srv.Retention), nil)
+		v.checkNumbers(path+".heartbeat", reflect.ValueOf(srv.Heartbeat), func(string) bool { return true })
+		if hb := srv.Heartbeat; hb.Interval > 0 && hb.StaleTimeout < minStaleHeartbeats*hb.Interval {
+			// a few missed heartbeats must not be enough to flag a running instance as offline
+			v.fail(path+".heartbeat.stale_timeout", "must be at least %d times heartbeat.interval (%s), got %s", minStaleHeartbeats, hb.Interval, hb.StaleTimeout)
+		}
+		v.checkNumbers(path+".budgets", reflect.ValueOf(srv.Budgets), nil)
+		v.checkBudget(path+".budgets.checkin", srv.Budgets.Checkin)
+		v.checkBudget(path+".budgets.ack", srv.Budgets.Ack)
+		v.checkNumbers(path+".actions", reflect.ValueOf(srv.Actions), nil)
+		v.checkNumbers(path+".draining", reflect.ValueOf(srv.Draining), func(name string) bool { return name == "retry_after" })
+		v.checkNumbers(path+".resource_usage", reflect.ValueOf(srv.ResourceUsage), nil)
+		v.checkNumbers(path+".status_damping", reflect.ValueOf(srv.StatusDamping), func(name string) bool { return name == "flapping_interval" })
+		v.checkNumbers(path+".checkin_delay", reflect.ValueOf(srv.CheckinDelay), func(name string) bool { return name == "interval" })
+		if cd := srv.CheckinDelay; cd.Max < cd.Min {
+			v.fail(path+".checkin_delay.max", "must not be less than checkin_delay.min (%s), got %s", cd.Min, cd.Max)
+		}
+		if ratio := srv.CheckinDelay.Threshold; ratio < 0 || ratio >= 1 {
+			v.fail(path+".checkin_delay.threshold", "must be a number between 0 and 1 (excluded), got %v", ratio)
+		}
+		v.checkNumbers(path+".clock_skew", reflect.ValueOf(srv.ClockSkew), func(name string) bool { return name == "interval" })
+		if ratio := srv.ResourceUsage.MaxFDRatio; ratio < 0 || ratio > 1 {
+			v.fail(path+".resource_usage.max_fd_ratio", "must be a number between 0 and 1, got %v", ratio)
+		}
+		if pct := srv.ResourceUsage.MaxCPUPercent; pct < 0 || pct > 100 {
+			v.fail(path+".resource_usage.max_cpu_percent", "must be a number between 0 and 100, got %v", pct)
+		}
+		for actionType, ttl := range srv.Actions.TTL {
+			if ttl < 0 {
+				v.fail(joinKey(path+".actions.ttl", actionType), "must not be negative, got %s", ttl)
+			}
+		}
+		for j, pattern := range srv.Actions.ResponseIndices {
+			if _, err := gopath.Match(pattern, ""); err != nil {
+				v.fail(joinKey(path+".actions.response_indices", strconv.Itoa(j)), "must be a valid index pattern, got %s", describe(pattern))
+			}
+		}
+		if retry := srv.Actions.UpgradeRetry; retry.Enabled() && retry.Backoff <= 0 {
+			v.fail(path+".actions.upgrade_retry.backoff", "must be positive when max_attempts is above 1, got %s", retry.Backoff)
+		}
+		for j, proxy := range srv.TrustedProxies {
+			if _, err := clientip.ParseProxy(proxy); err != nil {
+				v.fail(joinKey(path+".trusted_proxies", strconv.Itoa(j)), "must be a CIDR or an IP address, got %s", describe(proxy))
+			}
+		}
+		if rate := srv.Instrumentation.TransactionSampleRate; rate != "" {
+			if f, err := strconv.ParseFloat(rate, 64); err != nil || f < 0 || f > 1 {
+				v.fail(path+".instrumentation.transaction_sample_rate", "must be a number between 0 and 1, got %s", describe(rate))
+			}
+		}
+	}
+}
+
+// checkBudget checks that the fractions of the operation budget b are between 0 and 1.
+func (v *validator) checkBudget(path string, b OperationBudget) {
+	for key, f := range map[string]float64{"auth": b.Auth, "read": b.Read, "write": b.Write} {
+		if f < 0 || f > 1 {
+			v.fail(joinKey(path, key), "must be a number between 0 and 1, got %v", f)
+		}
+	}
+}
+
+// checkConcurrencyQuotas checks that the quotas q are between 0 and 1, and that their total does not exceed 1.
+func (v *validator) checkConcurrencyQuotas(path string, q ConcurrencyQuotas) {
+	var total float64
+	for _, quota := range []struct {
+		key string
+		f   float64
+	}{{"checkin", q.Checkin}, {"enroll", q.Enroll}, {"ack", q.Ack}, {"other", q.Other}} {
+		if quota.f <= 0 || quota.f > 1 {
+			v.fail(joinKey(path, quota.key), "must be a number above 0 and at most 1, got %v", quota.f)
+		}
+		total += quota.f
+	}
+	// the rounding error of the sum of the fractions must not fail the quotas that add up to 1
+	if total > 1+1e-9 {
+		v.fail(path, "must not exceed 1 in total, got %.3g", total)
+	}
+}
+
+// checkNumbers checks that the numeric and duration fields of struct val are not negative.
+// Durations must be positive if positive returns true for their key.
+func (v *validator) checkNumbers(path string, val reflect.Value, positive func(string) bool) {
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("config"), ",")
+		fpath := joinKey(path, name)
+		f := val.Field(i)
+		switch {
+		case f.Type() == durationType:
+			d := time.Duration(f.Int())
+			if positive != nil && positive(name) && d <= 0 {
+				v.fail(fpath, "must be positive, got %s", d)
+			} else if d < 0 {
+				v.fail(fpath, "must not be negative, got %s", d)
+			}
+		case f.Kind() == reflect.Struct:
+			v.checkNumbers(fpath, f, positive)
+		case f.CanInt():
+			if f.Int() < 0 {
+				v.fail(fpath, "must not be negative, got %d", f.Int())
+			}
+		}
+	}
+}
+
+// describe formats a raw configuration value for an error message.
+func describe(val interface{}) string {
+	switch val := val.(type) {
+	case string:
+		return strconv.Quote(val)
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package config
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/go-ucfg/yaml"
+)
+
+func validateFile(t *testing.T, path string, strict bool) (*Config, []FieldError, error) {
+	t.Helper()
+	c, err := yaml.NewConfigWithFile(path, DefaultOptions...)
+	require.NoError(t, err)
+	return ValidateConfig(c, strict)
+}
+
+func fieldErrorStrings(errs []FieldError) []string {
+	s := make([]string, 0, len(errs))
+	for _, fe := range errs {
+		s = append(s, fe.Error())
+	}
+	sort.Strings(s)
+	return s
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		strict   bool
+		errors   []string
+		warnings []string
+	}{{
+		name: "bad-types",
+		errors: []string{
+			`inputs.0.server.port: must be at most 65535, got 70000`,
+			`inputs.0.server.profiler.enabled: must be a boolean, got "maybe"`,
+			`inputs.0.server.timeouts.checkin_long_poll: must be a duration, got "soon"`,
+			`output.elasticsearch.headers: must be an object, got a list`,
+			`output.elasticsearch.max_retries: must be an integer, got "many"`,
+			`output.elasticsearch.timeout: must be a duration, got "fast"`,
+		},
+	}, {
+		name: "bad-unknown-keys",
+		warnings: []string{
+			"inputs.0.server.timeout: unknown configuration key",
+			"output.elasticsearch.max_retry: unknown configuration key",
+		},
+	}, {
+		name:   "bad-unknown-keys",
+		strict: true,
+		errors: []string{
+			"inputs.0.server.timeout: unknown configuration key",
+			"output.elasticsearch.max_retry: unknown configuration key",
+		},
+	}, {
+		name: "bad-ranges",
+		errors: []string{
+			"inputs.0.server.actions.default_ttl: must not be negative, got -1h0m0s",
+			`inputs.0.server.actions.response_indices.1: must be a valid index pattern, got ".logs-[osquery.action.responses-*"`,
+			"inputs.0.server.actions.ttl.UPGRADE: must not be negative, got -1s",
+			"inputs.0.server.actions.upgrade_retry.backoff: must be positive when max_attempts is above 1, got 0s",
+			"inputs.0.server.budgets.ack.read: must be a number between 0 and 1, got 1.5",
+			"inputs.0.server.budgets.ack.total: must not be negative, got -1s",
+			"inputs.0.server.bulk.flush_interval: must not be negative, got -250ms",
+			"inputs.0.server.bulk.spool.path: must be set",
+			"inputs.0.server.checkin_delay.max: must not be less than checkin_delay.min (1m0s), got 30s",
+			"inputs.0.server.checkin_delay.threshold: must be a number between 0 and 1 (excluded), got 1.5",
+			"inputs.0.server.gc.api_keys.batch_size: must be positive, got 0",
+			"inputs.0.server.heartbeat.interval: must be positive, got 0s",
+			`inputs.0.server.instrumentation.transaction_sample_rate: must be a number between 0 and 1, got "1.5"`,
+			"inputs.0.server.limits.checkin_limit.burst: must not be negative, got -1",
+			"inputs.0.server.limits.concurrency.quotas.enroll: must be a number above 0 and at most 1, got 0",
+			"inputs.0.server.limits.concurrency.quotas: must not exceed 1 in total, got 1.15",
+			"inputs.0.server.limits.max_agents: must not be negative, got -5",
+			"inputs.0.server.port: must be set",
+			"inputs.0.server.resource_usage.max_fd_ratio: must be a number between 0 and 1, got 90",
+			"inputs.0.server.resource_usage.max_goroutines: must not be negative, got -1",
+			"inputs.0.server.timeouts.write: must be positive, got -1s",
+			`inputs.0.server.trusted_proxies.1: must be a CIDR or an IP address, got "proxy.example.com"`,
+			"logging.slow.query: must not be negative, got -1s",
+			"output.elasticsearch.backoff.init: must not be negative, got -1s",
+			"output.elasticsearch.hosts.0: must not be empty",
+			"output.elasticsearch.max_idle_conns: must not be negative, got -1",
+			"output.elasticsearch.max_startup_wait: must be positive, got 0s",
+			"output.elasticsearch.timeout: must be positive, got 0s",
+		},
+	}, {
+		name: "bad-heartbeat",
+		errors: []string{
+			"inputs.0.server.heartbeat.stale_timeout: must be at least 3 times heartbeat.interval (1m0s), got 2m0s",
+		},
+	}, {
+		name: "bad-mixed",
+		errors: []string{
+			"inputs.0.server.port: must be set",
+			`output.elasticsearch.timeout: must be a duration, got "fast"`,
+		},
+		warnings: []string{
+			"inputs.0.server.hots: unknown configuration key",
+		},
+	}, {
+		name: "input-config",
+	}, {
+		name: "basic",
+	}, {
+		name: "fleet-logging",
+	}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, warnings, err := validateFile(t, filepath.Join("testdata", tc.name+".yml"), tc.strict)
+			require.Equal(t, tc.warnings, nilIfEmpty(fieldErrorStrings(warnings)))
+			if len(tc.errors) == 0 {
+				require.NoError(t, err)
+				require.NotNil(t, cfg)
+				return
+			}
+			require.Nil(t, cfg)
+			var verr *ValidationError
+			require.ErrorAs(t, err, &verr)
+			require.Equal(t, tc.errors, fieldErrorStrings(verr.Errors))
+		})
+	}
+}
+
+func TestValidateConfigReference(t *testing.T) {
+	_, warnings, err := validateFile(t, filepath.Join("..", "..", "..", "fleet-server.reference.yml"), true)
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+}
+
+func TestValidationErrorReport(t *testing.T) {
+	err := &ValidationError{Errors: []FieldError{
+		{Path: "output.elasticsearch.timeout", Msg: `must be a duration, got "fast"`},
+		{Msg: "a fleet-server input must be defined"},
+	}}
+	require.Equal(t, `invalid configuration, 2 problem(s) found:
+  output.elasticsearch.timeout: must be a duration, got "fast"
+  a fleet-server input must be defined`, err.Error())
+}
+
+func nilIfEmpty(s []string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
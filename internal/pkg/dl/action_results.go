@@ -8,21 +8,71 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"time"
+	"slices"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/rs/zerolog"
 )
 
+// ActionResultStatusExpired is the status of the result recorded for an action that expired before it was delivered.
+const ActionResultStatusExpired = "expired"
+
+// maxResultAgentsPerQuery is the number of agents the results are searched for in a query.
+const maxResultAgentsPerQuery = 1000
+
+// CreateActionResult records the result of the action for the agent of acr, the first result is kept.
+// The expired result recorded when the action was not delivered in time is replaced by the result of the agent.
 func CreateActionResult(ctx context.Context, bulker bulk.Bulk, acr model.ActionResult) error {
-	return createActionResult(ctx, bulker, FleetActionsResults, acr)
+	if acr.Timestamp == "" {
+		acr.Timestamp = ftime.Now()
+	}
+	err := createActionResult(ctx, bulker, FleetActionsResults, acr)
+	if !errors.Is(err, errActionResultExists) {
+		return err
+	}
+	if acr.Status == ActionResultStatusExpired {
+		return nil
+	}
+	id := acr.ActionID + ":" + acr.AgentID
+	p, err := bulker.Read(ctx, FleetActionsResults, id)
+	if err != nil {
+		return err
+	}
+	var existing model.ActionResult
+	if err := json.Unmarshal(p, &existing); err != nil {
+		return err
+	}
+	if existing.Status != ActionResultStatusExpired {
+		return nil
+	}
+	zerolog.Ctx(ctx).Debug().Str("id", id).Msg("action result replaces the expired result")
+	body, err := json.Marshal(acr)
+	if err != nil {
+		return err
+	}
+	_, err = bulker.Index(ctx, FleetActionsResults, id, body, bulk.WithRefresh())
+	return err
 }
 
+// CreateActionResponse copies the result of an input action to index, the responses data stream of an integration.
+func CreateActionResponse(ctx context.Context, bulker bulk.Bulk, index string, acr model.ActionResult) error {
+	if err := createActionResult(ctx, bulker, index, acr); !errors.Is(err, errActionResultExists) {
+		return err
+	}
+	return nil
+}
+
+// errActionResultExists is returned by createActionResult when the result of the agent was already recorded.
+var errActionResultExists = errors.New("action result already exists")
+
 func createActionResult(ctx context.Context, bulker bulk.Bulk, index string, acr model.ActionResult) error {
 	if acr.Timestamp == "" {
-		acr.Timestamp = time.Now().UTC().Format(time.RFC3339)
+		acr.Timestamp = ftime.Now()
 	}
 	body, err := json.Marshal(acr)
 	if err != nil {
@@ -30,11 +80,217 @@ func createActionResult(ctx context.Context, bulker bulk.Bulk, index string, acr
 	}
 
 	id := acr.ActionID + ":" + acr.AgentID
-	_, err = bulker.Create(ctx, index, id, body, bulk.WithRefresh())
+	_, err = bulker.Create(ctx, index, id, body, bulk.WithRefresh(), bulk.WithDroppable())
 	// ignoring version conflict in case the same action result is tried to be created multiple times (unique id with actionID and agentID)
 	if errors.Is(err, es.ErrElasticVersionConflict) {
 		zerolog.Ctx(ctx).Debug().Err(err).Str("id", id).Msg("action result already exists, ignoring")
+		return errActionResultExists
+	}
+	// the result is a non-critical write, it is accepted even if it is dropped while elasticsearch is unavailable
+	if bulk.IsDropped(err) {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("id", id).Msg("action result not written")
+		return nil
+	}
+	return err
+}
+
+// ActionDeliveryID returns the id of the result document that records the delivery of the action to the agent.
+// It is distinct from the id of the result written when the agent acks the action.
+func ActionDeliveryID(actionID, agentID string) string {
+	return actionID + ":" + agentID + ":delivery"
+}
+
+// CreateActionDelivery records the delivery of the action to the agent of acr.
+// The delivery is recorded once, a delivery that was already recorded is left unchanged.
+func CreateActionDelivery(ctx context.Context, bulker bulk.Bulk, acr model.ActionResult) error {
+	body, err := json.Marshal(acr)
+	if err != nil {
+		return err
+	}
+	id := ActionDeliveryID(acr.ActionID, acr.AgentID)
+	_, err = bulker.Create(ctx, FleetActionsResults, id, body, bulk.WithRefresh())
+	if errors.Is(err, es.ErrElasticVersionConflict) {
+		zerolog.Ctx(ctx).Debug().Err(err).Str("id", id).Msg("action delivery already exists, ignoring")
 		return nil
 	}
 	return err
 }
+
+var (
+	tmplQueryLatestActionResults      = prepareQueryLatestActionResults(false)
+	tmplQueryLatestActionResultsAfter = prepareQueryLatestActionResults(true)
+	tmplQueryAgentActionResults       = prepareQueryAgentActionResults()
+	tmplQueryActionResultAgents       = prepareQueryActionResultAgents()
+)
+
+func prepareQueryActionResultAgents() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldActionID, tmpl.Bind(FieldActionID), nil)
+	filter.Terms(FieldActionResultAgentID, tmpl.Bind(FieldActionResultAgentID), nil)
+	root.Source().Includes(FieldActionResultAgentID)
+	root.WithSize(tmpl.Bind(FieldSize))
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// FindActionResultAgentIDs returns the agents, among agentIDs, that have a result recorded for the action.
+// Both the ack results and the delivery records are found.
+func FindActionResultAgentIDs(ctx context.Context, bulker bulk.Bulk, actionID string, agentIDs []string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	for chunk := range slices.Chunk(agentIDs, maxResultAgentsPerQuery) {
+		res, err := Search(ctx, bulker, tmplQueryActionResultAgents, FleetActionsResults, map[string]interface{}{
+			FieldActionID:            actionID,
+			FieldActionResultAgentID: chunk,
+			// an agent has at most an ack result and a delivery record per action
+			FieldSize: 2 * len(chunk),
+		})
+		if err != nil {
+			if errors.Is(err, es.ErrIndexNotFound) {
+				zerolog.Ctx(ctx).Debug().Str("index", FleetActionsResults).Msg(es.ErrIndexNotFound.Error())
+				return ids, nil
+			}
+			return nil, err
+		}
+		for _, hit := range res.Hits {
+			var acr model.ActionResult
+			if err := hit.Unmarshal(&acr); err != nil {
+				return nil, err
+			}
+			ids[acr.AgentID] = true
+		}
+	}
+	return ids, nil
+}
+
+func prepareQueryAgentActionResults() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldActionResultAgentID, tmpl.Bind(FieldActionResultAgentID), nil)
+	filter.Terms(FieldActionID, tmpl.Bind(FieldActionID), nil)
+	root.Source().Includes(FieldActionID)
+	root.WithSize(tmpl.Bind(FieldSize))
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// FindAgentActionResultIDs returns the IDs of the actions, among actionIDs, that have a result recorded for the agent.
+// Both the ack results and the delivery records are found.
+func FindAgentActionResultIDs(ctx context.Context, bulker bulk.Bulk, agentID string, actionIDs []string) (map[string]bool, error) {
+	if len(actionIDs) == 0 {
+		return nil, nil
+	}
+	res, err := Search(ctx, bulker, tmplQueryAgentActionResults, FleetActionsResults, map[string]interface{}{
+		FieldActionResultAgentID: agentID,
+		FieldActionID:            actionIDs,
+		// an agent has at most an ack result and a delivery record per action
+		FieldSize: 2 * len(actionIDs),
+	})
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			zerolog.Ctx(ctx).Debug().Str("index", FleetActionsResults).Msg(es.ErrIndexNotFound.Error())
+			return nil, nil
+		}
+		return nil, err
+	}
+	ids := make(map[string]bool, len(res.Hits))
+	for _, hit := range res.Hits {
+		var acr model.ActionResult
+		if err := hit.Unmarshal(&acr); err != nil {
+			return nil, err
+		}
+		ids[acr.ActionID] = true
+	}
+	return ids, nil
+}
+
+// latestActionResultsPageSize is the number of agents whose latest result is read per search.
+const latestActionResultsPageSize = 1000
+
+// prepareQueryLatestActionResults returns the composite aggregation of the latest result of each agent, after the
+// agents of the previous page when after is true.
+func prepareQueryLatestActionResults(after bool) *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	root.Size(0)
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldActionID, tmpl.Bind(FieldActionID), nil)
+	agentID := root.Aggs().Agg(FieldActionResultAgentID)
+	composite := agentID.Composite()
+	composite.Param(FieldSize, latestActionResultsPageSize)
+	composite.Param("sources", []map[string]interface{}{{
+		FieldActionResultAgentID: map[string]interface{}{"terms": map[string]interface{}{"field": FieldActionResultAgentID}},
+	}})
+	if after {
+		composite.Param("after", tmpl.Bind("after"))
+	}
+	latest := agentID.Aggs().Agg(FieldTimestamp).TopHits()
+	latest.Size(1)
+	latest.Sort().SortOrder(FieldTimestamp, dsl.SortDescend)
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// FindLatestActionResults returns the latest result of each agent that responded to the action.
+// The agents are read in pages of a composite aggregation, so all of them are returned whatever their number.
+func FindLatestActionResults(ctx context.Context, bulker bulk.Bulk, actionID string, opt ...Option) ([]model.ActionResult, error) {
+	o := newOption(FleetActionsResults, opt...)
+	var results []model.ActionResult
+	var after json.RawMessage
+	for {
+		page, afterKey, err := findLatestActionResultsPage(ctx, bulker, o, actionID, after)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, page...)
+		if len(page) < latestActionResultsPageSize || len(afterKey) == 0 {
+			return results, nil
+		}
+		after = afterKey
+	}
+}
+
+// findLatestActionResultsPage returns a page of the latest results of the agents that sort after the after key, and
+// the after key of the page.
+func findLatestActionResultsPage(ctx context.Context, bulker bulk.Bulk, o queryOption, actionID string, after json.RawMessage) ([]model.ActionResult, json.RawMessage, error) {
+	tmpl := tmplQueryLatestActionResults
+	params := map[string]interface{}{
+		FieldActionID: actionID,
+	}
+	if after != nil {
+		tmpl = tmplQueryLatestActionResultsAfter
+		params["after"] = after
+	}
+	slow := logger.StartSlowTimer("query", logger.SlowThresholds().Query)
+	query, err := render(tmpl, o, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := bulker.Search(ctx, o.indexName, query)
+	slow.End(ctx, slowQueryFields(o.indexName, err))
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			zerolog.Ctx(ctx).Debug().Str("index", o.indexName).Msg(es.ErrIndexNotFound.Error())
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	agentID, ok := res.Aggregations[FieldActionResultAgentID]
+	if !ok {
+		return nil, nil, nil
+	}
+	results := make([]model.ActionResult, len(agentID.Buckets))
+	for i, bucket := range agentID.Buckets {
+		latest, ok := bucket.Aggregations[FieldTimestamp]
+		if !ok || len(latest.Hits) != 1 {
+			return nil, nil, ErrMissingAggregations
+		}
+		if err := latest.Hits[0].Unmarshal(&results[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return results, agentID.AfterKey, nil
+}
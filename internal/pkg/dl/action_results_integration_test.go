@@ -137,3 +137,41 @@ func TestActionsResultsStored(t *testing.T) {
 		}
 	}
 }
+
+func TestFindLatestActionResults(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	index, bulker := ftesting.SetupCleanIndex(ctx, t, FleetActionsResults)
+	actionID := uuid.Must(uuid.NewV4()).String()
+	now := time.Now().UTC()
+	for i, agentID := range []string{"agent-1", "agent-2", "agent-3"} {
+		err := createActionResult(ctx, bulker, index, model.ActionResult{
+			ActionID:  actionID,
+			AgentID:   agentID,
+			Timestamp: now.Add(time.Duration(i) * time.Second).Format(time.RFC3339),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a result of another action
+	err := createActionResult(ctx, bulker, index, model.ActionResult{ActionID: "other", AgentID: "agent-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := FindLatestActionResults(ctx, bulker, actionID, WithIndexName(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	for _, res := range results {
+		if res.ActionID != actionID {
+			t.Fatalf("unexpected action %s", res.ActionID)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package dl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestPrepareQueryLatestActionResults(t *testing.T) {
+	query, err := tmplQueryLatestActionResultsAfter.Render(map[string]interface{}{
+		FieldActionID: "action-1",
+		"after":       json.RawMessage(`{"agent_id":"agent-1"}`),
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"aggs":{"agent_id":{
+			"aggs":{"@timestamp":{"top_hits":{"size":1,"sort":[{"@timestamp":"desc"}]}}},
+			"composite":{"after":{"agent_id":"agent-1"},"size":1000,"sources":[{"agent_id":{"terms":{"field":"agent_id"}}}]}
+		}},
+		"query":{"bool":{"filter":[{"term":{"action_id":"action-1"}}]}},
+		"size":0
+	}`, string(query))
+}
+
+// latestResultsPage returns a page of the composite aggregation of the latest results of the agents.
+func latestResultsPage(t *testing.T, agentIDs []string, afterKey string) *es.ResultT {
+	buckets := make([]es.Bucket, len(agentIDs))
+	for i, agentID := range agentIDs {
+		body, err := json.Marshal(model.ActionResult{ActionID: "action-1", AgentID: agentID})
+		require.NoError(t, err)
+		buckets[i] = es.Bucket{
+			DocCount:     1,
+			Aggregations: map[string]es.HitsT{FieldTimestamp: {Hits: []es.HitT{{Source: body}}}},
+		}
+	}
+	agg := es.Aggregation{Buckets: buckets}
+	if afterKey != "" {
+		agg.AfterKey = json.RawMessage(afterKey)
+	}
+	return &es.ResultT{Aggregations: map[string]es.Aggregation{FieldActionResultAgentID: agg}}
+}
+
+func TestFindLatestActionResultsPages(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	first := make([]string, latestActionResultsPageSize)
+	for i := range first {
+		first[i] = fmt.Sprintf("agent-%04d", i)
+	}
+	lastKey := fmt.Sprintf(`{"agent_id":"%s"}`, first[len(first)-1])
+
+	bulker := ftesting.NewMockBulk()
+	var queries []string
+	bulker.On("Search", mock.Anything, FleetActionsResults, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		queries = append(queries, string(args.Get(2).([]byte)))
+	}).Return(latestResultsPage(t, first, lastKey), nil).Once()
+	bulker.On("Search", mock.Anything, FleetActionsResults, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		queries = append(queries, string(args.Get(2).([]byte)))
+	}).Return(latestResultsPage(t, []string{"agent-1000"}, `{"agent_id":"agent-1000"}`), nil).Once()
+
+	results, err := FindLatestActionResults(ctx, bulker, "action-1")
+	require.NoError(t, err)
+	require.Len(t, results, latestActionResultsPageSize+1)
+	require.Equal(t, "agent-1000", results[len(results)-1].AgentID)
+	require.Len(t, queries, 2)
+	require.NotContains(t, queries[0], `"after"`)
+	require.Contains(t, queries[1], `"after":`+lastKey)
+	bulker.AssertExpectations(t)
+}
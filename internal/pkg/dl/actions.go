@@ -14,27 +14,39 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
 	"github.com/rs/zerolog"
 )
 
 const (
-	FieldAgents     = "agents"
-	FieldExpiration = "expiration"
-	FieldSize       = "size"
+	FieldSize = "size"
 
 	maxAgentActionsFetchSize = 100
+
+	fieldExpirationFrom = "expiration_from"
+	fieldTimestampFrom  = "timestamp_from"
 )
 
 var (
-	QueryAction          = prepareFindAction()
-	QueryAllAgentActions = prepareFindAllAgentsActions()
-	QueryAgentActions    = prepareFindAgentActions()
+	QueryAction           = prepareFindAction()
+	QueryActionTargets    = prepareFindActionTargets()
+	QueryAllAgentActions  = prepareFindAllAgentsActions()
+	QueryAgentActions     = prepareFindAgentActions()
+	QueryTargetedActions  = prepareFindTargetedActions()
+	QueryNewerAgentAction = prepareFindNewerAgentAction()
 
 	// Query for expired actions GC
 	QueryDeleteExpiredActions = prepareDeleteExpiredAction()
 	QueryFindExpiredActions   = prepareFindExpiredAction()
+
+	// Query for actions that expired within a time range
+	QueryFindExpiredActionsInRange = prepareFindExpiredActionInRange()
+
+	// Queries for actions without expiration created within a time range, of the given types or of the other types
+	QueryFindUnexpiringActionsOfTypes    = prepareFindUnexpiringActionsInRange(false)
+	QueryFindUnexpiringActionsNotOfTypes = prepareFindUnexpiringActionsInRange(true)
 )
 
 func prepareFindAllAgentsActions() *dsl.Tmpl {
@@ -46,6 +58,7 @@ func prepareFindAllAgentsActions() *dsl.Tmpl {
 func prepareFindAction() *dsl.Tmpl {
 	tmpl := dsl.NewTmpl()
 	root := dsl.NewRoot()
+	root.Param(seqNoPrimaryTerm, true)
 	filter := root.Query().Bool().Filter()
 	filter.Term(FieldActionID, tmpl.Bind(FieldActionID), nil)
 	root.Source().Excludes(FieldAgents)
@@ -53,6 +66,16 @@ func prepareFindAction() *dsl.Tmpl {
 	return tmpl
 }
 
+func prepareFindActionTargets() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldActionID, tmpl.Bind(FieldActionID), nil)
+	root.WithSize(tmpl.Bind(FieldSize))
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
 func prepareDeleteExpiredAction() *dsl.Tmpl {
 	tmpl := dsl.NewTmpl()
 	root := dsl.NewRoot()
@@ -74,6 +97,42 @@ func prepareFindExpiredAction() *dsl.Tmpl {
 	return tmpl
 }
 
+func prepareFindExpiredActionInRange() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	root.Param(seqNoPrimaryTerm, true)
+	filter := root.Query().Bool().Filter()
+	filter.Range(FieldSeqNo, dsl.WithRangeGT(tmpl.Bind(FieldSeqNo)))
+	filter.Range(FieldExpiration, dsl.WithRangeGT(tmpl.Bind(fieldExpirationFrom)))
+	filter.Range(FieldExpiration, dsl.WithRangeLTE(tmpl.Bind(FieldExpiration)))
+	root.WithSize(tmpl.Bind(FieldSize))
+	root.Sort().SortOrder(FieldSeqNo, dsl.SortAscend)
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+func prepareFindUnexpiringActionsInRange(excludeTypes bool) *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	root.Param(seqNoPrimaryTerm, true)
+	b := root.Query().Bool()
+	filter := b.Filter()
+	filter.Range(FieldSeqNo, dsl.WithRangeGT(tmpl.Bind(FieldSeqNo)))
+	filter.Range(FieldTimestamp, dsl.WithRangeGT(tmpl.Bind(fieldTimestampFrom)))
+	filter.Range(FieldTimestamp, dsl.WithRangeLTE(tmpl.Bind(FieldTimestamp)))
+	mustNot := b.MustNot()
+	mustNot.Exists(FieldExpiration)
+	if excludeTypes {
+		mustNot.Terms(FieldType, tmpl.Bind(FieldType), nil)
+	} else {
+		filter.Terms(FieldType, tmpl.Bind(FieldType), nil)
+	}
+	root.WithSize(tmpl.Bind(FieldSize))
+	root.Sort().SortOrder(FieldSeqNo, dsl.SortAscend)
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
 func prepareFindAgentActions() *dsl.Tmpl {
 	tmpl, root, filter := createBaseActionsQuery()
 
@@ -87,6 +146,40 @@ func prepareFindAgentActions() *dsl.Tmpl {
 	return tmpl
 }
 
+// prepareFindTargetedActions selects the unexpired actions that target a filter of agents instead of a list of agents.
+// The sequence number window of the agent does not apply, an agent that matches the filter later still receives them.
+func prepareFindTargetedActions() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	root.Param(seqNoPrimaryTerm, true)
+	filter := root.Query().Bool().Filter()
+	filter.Exists(FieldTarget)
+	filter.Range(FieldExpiration, dsl.WithRangeGT(tmpl.Bind(FieldExpiration)))
+	root.Size(maxAgentActionsFetchSize)
+	root.Sort().SortOrder(FieldSeqNo, dsl.SortAscend)
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareFindNewerAgentAction selects the oldest action of a type addressed to the agent after a sequence number.
+func prepareFindNewerAgentAction() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	root.Param(seqNoPrimaryTerm, true)
+	b := root.Query().Bool()
+	filter := b.Filter()
+	filter.Range(FieldSeqNo, dsl.WithRangeGT(tmpl.Bind(FieldSeqNo)))
+	filter.Term(FieldAgents, tmpl.Bind(FieldAgents), nil)
+	filter.Term(FieldType, tmpl.Bind(FieldType), nil)
+	// the later documents of an action addressed to many agents are the same action
+	b.MustNot().Term(FieldActionID, tmpl.Bind(FieldActionID), nil)
+	root.Size(1)
+	root.Source().Excludes(FieldAgents)
+	root.Sort().SortOrder(FieldSeqNo, dsl.SortAscend)
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
 func createBaseActionsQuery() (tmpl *dsl.Tmpl, root, filter *dsl.Node) {
 	tmpl = dsl.NewTmpl()
 
@@ -104,26 +197,67 @@ func createBaseActionsQuery() (tmpl *dsl.Tmpl, root, filter *dsl.Node) {
 
 func FindAction(ctx context.Context, bulker bulk.Bulk, id string, opts ...Option) ([]model.Action, error) {
 	o := newOption(FleetActions, opts...)
-	return findActions(ctx, bulker, QueryAction, o.indexName, map[string]interface{}{
+	return findActions(ctx, bulker, QueryAction, o, map[string]interface{}{
 		FieldActionID: id,
 	}, nil)
 }
 
+// FindActionTargets returns the documents of the action including the agents they target.
+// An action that targets many agents may be stored in several documents.
+func FindActionTargets(ctx context.Context, bulker bulk.Bulk, id string, opts ...Option) ([]model.Action, error) {
+	o := newOption(FleetActions, opts...)
+	return findActions(ctx, bulker, QueryActionTargets, o, map[string]interface{}{
+		FieldActionID: id,
+		FieldSize:     maxAgentActionsFetchSize,
+	}, nil)
+}
+
 func FindAgentActions(ctx context.Context, bulker bulk.Bulk, minSeqNo, maxSeqNo sqn.SeqNo, agentID string) ([]model.Action, error) {
-	const index = FleetActions
 	params := map[string]interface{}{
 		FieldSeqNo:      minSeqNo.Value(),
 		FieldMaxSeqNo:   maxSeqNo.Value(),
-		FieldExpiration: time.Now().UTC().Format(time.RFC3339),
+		FieldExpiration: ftime.Format(timeNow()),
 		FieldAgents:     []string{agentID},
 	}
 
-	res, err := findActionsHits(ctx, bulker, QueryAgentActions, index, params, maxSeqNo)
-	if err != nil || res == nil {
-		return nil, err
+	return findActions(ctx, bulker, QueryAgentActions, newOption(FleetActions), params, maxSeqNo)
+}
+
+// FindTargetedActions returns the unexpired actions that target a filter of agents, the oldest first.
+// The filters are evaluated against the agent document by the caller.
+func FindTargetedActions(ctx context.Context, bulker bulk.Bulk) ([]model.Action, error) {
+	return findActions(ctx, bulker, QueryTargetedActions, newOption(FleetActions), map[string]interface{}{
+		FieldExpiration: ftime.Format(timeNow()),
+	}, nil)
+}
+
+// FindNewerAgentAction returns an action of type actionType addressed to the agent after the document of the action
+// actionID with sequence number seqNo, or ErrNotFound when there is none. The other documents of actionID are not newer
+// actions.
+func FindNewerAgentAction(ctx context.Context, bulker bulk.Bulk, agentID, actionType, actionID string, seqNo int64) (model.Action, error) {
+	actions, err := findActions(ctx, bulker, QueryNewerAgentAction, newOption(FleetActions), map[string]interface{}{
+		FieldSeqNo:    seqNo,
+		FieldAgents:   agentID,
+		FieldType:     actionType,
+		FieldActionID: actionID,
+	}, nil)
+	if err != nil {
+		return model.Action{}, err
 	}
+	if len(actions) == 0 {
+		return model.Action{}, ErrNotFound
+	}
+	return actions[0], nil
+}
 
-	return hitsToActions(res.Hits)
+// CreateAction creates a new action document in the index
+func CreateAction(ctx context.Context, bulker bulk.Bulk, action model.Action, opt ...Option) (string, error) {
+	o := newOption(FleetActions, opt...)
+	data, err := json.Marshal(&action)
+	if err != nil {
+		return "", err
+	}
+	return bulker.Create(ctx, o.indexName, "", data, bulk.WithRefresh())
 }
 
 func DeleteExpiredForIndex(ctx context.Context, index string, bulker bulk.Bulk, cleanupIntervalAfterExpired string) (int64, error) {
@@ -142,7 +276,7 @@ func DeleteExpiredForIndex(ctx context.Context, index string, bulker bulk.Bulk,
 	if err != nil {
 		return 0, err
 	}
-	defer res.Body.Close()
+	defer es.DrainAndClose(res.Body)
 
 	var esres es.DeleteByQueryResponse
 
@@ -167,11 +301,11 @@ func DeleteExpiredForIndex(ctx context.Context, index string, bulker bulk.Bulk,
 
 func FindExpiredActionsHitsForIndex(ctx context.Context, index string, bulker bulk.Bulk, expiredBefore time.Time, size int) ([]es.HitT, error) {
 	params := map[string]interface{}{
-		FieldExpiration: expiredBefore.UTC().Format(time.RFC3339),
+		FieldExpiration: ftime.Format(expiredBefore),
 		FieldSize:       size,
 	}
 
-	res, err := findActionsHits(ctx, bulker, QueryFindExpiredActions, index, params, nil)
+	res, err := findActionsHits(ctx, bulker, QueryFindExpiredActions, newOption(index), params, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -181,15 +315,50 @@ func FindExpiredActionsHitsForIndex(ctx context.Context, index string, bulker bu
 	return nil, nil
 }
 
-func findActionsHits(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, index string, params map[string]interface{}, seqNos []int64) (*es.HitsT, error) {
+// FindExpiredActions returns the actions with an expiration after from and no later than to, up to size actions
+// with a sequence number greater than seqNo, the oldest first.
+// The returned actions include the agents they target.
+func FindExpiredActions(ctx context.Context, bulker bulk.Bulk, from, to time.Time, seqNo int64, size int, opts ...Option) ([]model.Action, error) {
+	o := newOption(FleetActions, opts...)
+	return findActions(ctx, bulker, QueryFindExpiredActionsInRange, o, map[string]interface{}{
+		FieldSeqNo:          seqNo,
+		fieldExpirationFrom: ftime.Format(from),
+		FieldExpiration:     ftime.Format(to),
+		FieldSize:           size,
+	}, nil)
+}
+
+// FindUnexpiringActions returns the actions without an expiration created after from and no later than to, up to size
+// actions with a sequence number greater than seqNo, the oldest first.
+// The actions of the given types are returned, or the actions of all the other types if excludeTypes is set.
+// The returned actions include the agents they target.
+func FindUnexpiringActions(ctx context.Context, bulker bulk.Bulk, from, to time.Time, types []string, excludeTypes bool, seqNo int64, size int, opts ...Option) ([]model.Action, error) {
+	o := newOption(FleetActions, opts...)
+	tmpl := QueryFindUnexpiringActionsOfTypes
+	if excludeTypes {
+		tmpl = QueryFindUnexpiringActionsNotOfTypes
+	}
+	if types == nil {
+		types = []string{}
+	}
+	return findActions(ctx, bulker, tmpl, o, map[string]interface{}{
+		FieldSeqNo:         seqNo,
+		fieldTimestampFrom: ftime.Format(from),
+		FieldTimestamp:     ftime.Format(to),
+		FieldType:          types,
+		FieldSize:          size,
+	}, nil)
+}
+
+func findActionsHits(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, o queryOption, params map[string]interface{}, seqNos []int64) (*es.HitsT, error) {
 	var ops []bulk.Opt
 	if len(seqNos) > 0 {
 		ops = append(ops, bulk.WithWaitForCheckpoints(seqNos))
 	}
-	res, err := Search(ctx, bulker, tmpl, index, params, ops...)
+	res, err := search(ctx, bulker, tmpl, o, params, ops...)
 	if err != nil {
 		if errors.Is(err, es.ErrIndexNotFound) {
-			zerolog.Ctx(ctx).Debug().Str("index", index).Msg(es.ErrIndexNotFound.Error())
+			zerolog.Ctx(ctx).Debug().Str("index", o.indexName).Msg(es.ErrIndexNotFound.Error())
 			err = nil
 		}
 		return nil, err
@@ -197,8 +366,8 @@ func findActionsHits(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, inde
 	return res, nil
 }
 
-func findActions(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, index string, params map[string]interface{}, seqNos []int64) ([]model.Action, error) {
-	res, err := findActionsHits(ctx, bulker, tmpl, index, params, seqNos)
+func findActions(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, o queryOption, params map[string]interface{}, seqNos []int64) ([]model.Action, error) {
+	res, err := findActionsHits(ctx, bulker, tmpl, o, params, seqNos)
 	if err != nil || res == nil {
 		return nil, err
 	}
@@ -37,7 +37,7 @@ func TestSearchActionsQuery(t *testing.T) {
 
 	t.Run("all agents actions", func(t *testing.T) {
 
-		foundActions, err := findActions(ctx, bulker, QueryAllAgentActions, index, map[string]interface{}{
+		foundActions, err := findActions(ctx, bulker, QueryAllAgentActions, newOption(index), map[string]interface{}{
 			FieldSeqNo:      minSeqNo,
 			FieldMaxSeqNo:   maxSeqNo,
 			FieldExpiration: now,
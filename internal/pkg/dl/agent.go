@@ -9,23 +9,84 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+
+	"github.com/rs/zerolog"
 )
 
 const (
-	FieldAccessAPIKeyID = "access_api_key_id"
+	// Values of the upgrade_status field of an agent.
+	UpgradeStatusStarted   = "started"
+	UpgradeStatusRetrying  = "retrying"
+	UpgradeStatusFailed    = "failed"
+	UpgradeStatusCompleted = "completed"
+	// UpgradeStatusUpgradeFailed is the terminal status of an upgrade once its automatic retries are exhausted.
+	UpgradeStatusUpgradeFailed = "upgrade_failed"
 )
 
 var (
-	QueryAgentByAssessAPIKeyID = prepareAgentFindByAccessAPIKeyID()
-	QueryAgentByID             = prepareAgentFindByID()
-	QueryAgentByEnrollmentID   = prepareAgentFindByEnrollmentID()
+	QueryAgentByAssessAPIKeyID   = prepareAgentFindByAccessAPIKeyID()
+	QueryAgentByID               = prepareAgentFindByID()
+	QueryAgentByEnrollmentID     = prepareAgentFindByEnrollmentID()
+	QueryAgentByIdempotencyKey   = prepareAgentFindByIdempotencyKey()
+	QueryAgentsByIDs             = prepareFindAgentsByIDs()
+	QueryActiveAgentsByPolicy    = prepareFindActiveAgentsByPolicyID()
+	QueryStaleUpgrades           = prepareFindStaleUpgrades()
+	QueryOfflineAgents           = prepareFindOfflineAgents()
+	QueryPendingKeyInvalidations = prepareFindPendingKeyInvalidations()
+	QueryStaleUnenrollments      = prepareFindStaleUnenrollments()
+	QueryPurgeableAgents         = prepareFindPurgeableAgents()
+	QueryActiveAgentsByTag       = prepareFindActiveAgentsByTag()
+
+	QueryActiveAgentsByPolicyAndTag = prepareFindActiveAgentsByPolicyIDAndTag()
+	QueryAgentsBelowRevision        = prepareFindAgentsBelowRevision()
+	QueryPolicyRevisions            = prepareAggregatePolicyRevisions()
 )
 
+const (
+	// maxRevisionPolicies is the max number of policies counted by QueryPolicyRevisions.
+	maxRevisionPolicies = 10000
+	// maxPolicyRevisions is the max number of revisions of a policy counted by QueryPolicyRevisions, the agents on
+	// older revisions are not counted.
+	maxPolicyRevisions = 100
+)
+
+// maxAgentUpdateRetries is the number of times a conditional update of an agent that conflicted with another writer is retried.
+const maxAgentUpdateRetries = 3
+
+// ErrAgentTagsLimit is returned when adding tags to an agent would exceed the max number of tags of an agent.
+var ErrAgentTagsLimit = errors.New("agent tags limit exceeded")
+
+// agentTagsScript adds params.add to the tags of an agent and removes params.remove from them.
+// The update is a noop when the tags do not change, or when tags are added and the agent would have more than params.max tags.
+const agentTagsScript = `
+if (ctx._source.tags == null) {
+  ctx._source.tags = new ArrayList();
+}
+List tags = ctx._source.tags;
+boolean removed = tags.removeAll(params.remove);
+boolean added = false;
+for (String tag : params.add) {
+  if (!tags.contains(tag)) {
+    tags.add(tag);
+    added = true;
+  }
+}
+if ((added && tags.size() > params.max) || !(added || removed)) {
+  ctx.op = 'noop';
+} else {
+  ctx._source.updated_at = params.now;
+}`
+
 func prepareAgentFindByID() *dsl.Tmpl {
 	return prepareAgentFindByField(FieldID)
 }
@@ -38,8 +99,179 @@ func prepareAgentFindByEnrollmentID() *dsl.Tmpl {
 	return prepareAgentFindByField(FieldEnrollmentID)
 }
 
+// prepareAgentFindByIdempotencyKey finds the agent enrolled with an idempotency key that expires after the bound time.
+func prepareAgentFindByIdempotencyKey() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	root.Param("version", true)
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldEnrollIdempotencyKey, tmpl.Bind(FieldEnrollIdempotencyKey), nil)
+	filter.Range(FieldEnrollIdempotencyExpiresAt, dsl.WithRangeGT(tmpl.Bind(FieldEnrollIdempotencyExpiresAt)))
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareAgentFindByField finds the agent by field, with the seq_no and primary_term its conditional updates are made with.
 func prepareAgentFindByField(field string) *dsl.Tmpl {
-	return prepareFindByField(field, map[string]interface{}{"version": true})
+	return prepareFindByField(field, map[string]interface{}{"version": true, seqNoPrimaryTerm: true})
+}
+
+// prepareFindAgentsByIDs finds the agents by ID, with the seq_no and primary_term their conditional updates are made with.
+func prepareFindAgentsByIDs() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	root.Param(seqNoPrimaryTerm, true)
+	root.Query().Bool().Filter().Terms(FieldID, tmpl.Bind(FieldID), nil)
+	root.WithSize(tmpl.Bind(FieldSize))
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+func prepareFindActiveAgentsByPolicyID() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldPolicyID, tmpl.Bind(FieldPolicyID), nil)
+	filter.Term(FieldActive, true, nil)
+	// Select only agent ids
+	root.Source().Includes("_id")
+	root.WithSize(tmpl.Bind(FieldSize))
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareFindStaleUpgrades finds the agents with an upgrade that started before upgrade_started_at and is still in the started status.
+func prepareFindStaleUpgrades() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldUpgradeStatus, UpgradeStatusStarted, nil)
+	filter.Range(FieldUpgradeStartedAt, dsl.WithRangeLTE(tmpl.Bind(FieldUpgradeStartedAt)))
+	root.Source().Includes(FieldUpgradeStartedAt)
+	root.WithSize(tmpl.Bind(FieldSize))
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareFindOfflineAgents finds the online agents that did not check in since last_checkin, with an agent ID after
+// agent.id in the order of the agent IDs.
+func prepareFindOfflineAgents() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldLifecycleState, string(model.AgentStateOnline), nil)
+	filter.Term(FieldActive, true, nil)
+	filter.Range(FieldLastCheckin, dsl.WithRangeLTE(tmpl.Bind(FieldLastCheckin)))
+	filter.Range(FieldAgentDocID, dsl.WithRangeGT(tmpl.Bind(FieldAgentDocID)))
+	// Fields the state of the agent is derived from
+	root.Source().Includes(FieldActive, FieldLastCheckin, FieldLifecycleState, FieldUnenrolledAt, FieldUnenrollmentStartedAt)
+	root.WithSize(tmpl.Bind(FieldSize))
+	root.Sort().SortOrder(FieldAgentDocID, dsl.SortAscend)
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareFindPendingKeyInvalidations finds the unenrolled agents with API keys that remain to be invalidated.
+func prepareFindPendingKeyInvalidations() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	root.Param(seqNoPrimaryTerm, true)
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldAPIKeysInvalidationPending, true, nil)
+	root.WithSize(tmpl.Bind(FieldSize))
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareFindStaleUnenrollments finds the active agents with an unenrollment that started before unenrollment_started_at,
+// with an agent ID after agent.id in the order of the agent IDs.
+func prepareFindStaleUnenrollments() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	root.Param(seqNoPrimaryTerm, true)
+	query := root.Query().Bool()
+	filter := query.Filter()
+	filter.Term(FieldActive, true, nil)
+	filter.Range(FieldUnenrollmentStartedAt, dsl.WithRangeLTE(tmpl.Bind(FieldUnenrollmentStartedAt)))
+	filter.Range(FieldAgentDocID, dsl.WithRangeGT(tmpl.Bind(FieldAgentDocID)))
+	query.MustNot().Exists(FieldUnenrolledAt)
+	root.WithSize(tmpl.Bind(FieldSize))
+	root.Sort().SortOrder(FieldAgentDocID, dsl.SortAscend)
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareFindPurgeableAgents finds the agents unenrolled before unenrolled_at, with no API key left to invalidate.
+func prepareFindPurgeableAgents() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	root.Param(seqNoPrimaryTerm, true)
+	query := root.Query().Bool()
+	filter := query.Filter()
+	filter.Term(FieldActive, false, nil)
+	filter.Range(FieldUnenrolledAt, dsl.WithRangeLTE(tmpl.Bind(FieldUnenrolledAt)))
+	query.MustNot().Term(FieldAPIKeysInvalidationPending, true, nil)
+	root.WithSize(tmpl.Bind(FieldSize))
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareFindActiveAgentsByTag finds the active agents with a tag.
+func prepareFindActiveAgentsByTag() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldTags, tmpl.Bind(FieldTags), nil)
+	filter.Term(FieldActive, true, nil)
+	// Select only agent ids
+	root.Source().Includes("_id")
+	root.WithSize(tmpl.Bind(FieldSize))
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareFindActiveAgentsByPolicyIDAndTag finds the active agents enrolled in a policy with a tag.
+func prepareFindActiveAgentsByPolicyIDAndTag() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldPolicyID, tmpl.Bind(FieldPolicyID), nil)
+	filter.Term(FieldTags, tmpl.Bind(FieldTags), nil)
+	filter.Term(FieldActive, true, nil)
+	// Select only agent ids
+	root.Source().Includes("_id")
+	root.WithSize(tmpl.Bind(FieldSize))
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareFindAgentsBelowRevision finds the active agents of a policy on a revision lower or equal to policy_revision_idx,
+// the agents on the oldest revisions first.
+func prepareFindAgentsBelowRevision() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldPolicyID, tmpl.Bind(FieldPolicyID), nil)
+	filter.Term(FieldActive, true, nil)
+	filter.Range(FieldPolicyRevisionIdx, dsl.WithRangeLTE(tmpl.Bind(FieldPolicyRevisionIdx)))
+	root.Source().Includes(FieldPolicyRevisionIdx, FieldLastCheckin)
+	root.Sort().SortOrder(FieldPolicyRevisionIdx, dsl.SortAscend)
+	root.WithSize(tmpl.Bind(FieldSize))
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareAggregatePolicyRevisions counts the active agents on each revision of each policy, the newest revisions first.
+func prepareAggregatePolicyRevisions() []byte {
+	root := dsl.NewRoot()
+	root.Size(0)
+	root.Query().Bool().Filter().Term(FieldActive, true, nil)
+	policyID := root.Aggs().Agg(FieldPolicyID)
+	policyID.Terms("field", FieldPolicyID, nil).Size(maxRevisionPolicies)
+	revisionIdx := policyID.Aggs().Agg(FieldPolicyRevisionIdx).Terms("field", FieldPolicyRevisionIdx, nil)
+	revisionIdx.Size(maxPolicyRevisions)
+	revisionIdx.Param("order", map[string]string{"_key": "desc"})
+	return root.MustMarshalJSON()
 }
 
 func GetAgent(ctx context.Context, bulker bulk.Bulk, agentID string, opt ...Option) (model.Agent, error) {
@@ -58,8 +290,13 @@ func GetAgent(ctx context.Context, bulker bulk.Bulk, agentID string, opt ...Opti
 		return model.Agent{}, err
 	}
 
+	if o.namespace != "" && !slices.Contains(agent.Namespaces, o.namespace) {
+		return model.Agent{}, ErrNotFound
+	}
+
 	agent.Id = agentID
 	agent.SeqNo = data.SeqNo
+	agent.PrimaryTerm = data.PrimaryTerm
 	agent.Version = data.Version
 
 	return agent, err
@@ -67,7 +304,7 @@ func GetAgent(ctx context.Context, bulker bulk.Bulk, agentID string, opt ...Opti
 
 func FindAgent(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, name string, v interface{}, opt ...Option) (model.Agent, error) {
 	o := newOption(FleetAgents, opt...)
-	res, err := SearchWithOneParam(ctx, bulker, tmpl, o.indexName, name, v)
+	res, err := search(ctx, bulker, tmpl, o, map[string]interface{}{name: v})
 	if err != nil {
 		return model.Agent{}, fmt.Errorf("failed searching for agent: %w", err)
 	}
@@ -83,3 +320,486 @@ func FindAgent(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, name strin
 
 	return agent, nil
 }
+
+// FindAgentByIdempotencyKey returns the agent enrolled with the idempotency key if the key did not expire at now.
+func FindAgentByIdempotencyKey(ctx context.Context, bulker bulk.Bulk, key string, now time.Time, opt ...Option) (model.Agent, error) {
+	o := newOption(FleetAgents, opt...)
+	res, err := search(ctx, bulker, QueryAgentByIdempotencyKey, o, map[string]interface{}{
+		FieldEnrollIdempotencyKey:       key,
+		FieldEnrollIdempotencyExpiresAt: ftime.Format(now),
+	})
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return model.Agent{}, ErrNotFound
+		}
+		return model.Agent{}, fmt.Errorf("failed searching for agent: %w", err)
+	}
+	if len(res.Hits) == 0 {
+		return model.Agent{}, ErrNotFound
+	}
+
+	var agent model.Agent
+	if err = res.Hits[0].Unmarshal(&agent); err != nil {
+		return model.Agent{}, fmt.Errorf("could not unmarshal ES document into model.Agent: %w", err)
+	}
+	return agent, nil
+}
+
+// FindActiveAgentIDsByPolicyID returns the IDs of up to size active agents enrolled in the policy.
+func FindActiveAgentIDsByPolicyID(ctx context.Context, bulker bulk.Bulk, policyID string, size int, opt ...Option) ([]string, error) {
+	o := newOption(FleetAgents, opt...)
+	return streamAgentIDs(ctx, bulker, QueryActiveAgentsByPolicy, o, map[string]interface{}{
+		FieldPolicyID: policyID,
+		FieldSize:     size,
+	})
+}
+
+// FindActiveAgentIDsByTag returns the IDs of up to size active agents with the tag.
+func FindActiveAgentIDsByTag(ctx context.Context, bulker bulk.Bulk, tag string, size int, opt ...Option) ([]string, error) {
+	o := newOption(FleetAgents, opt...)
+	res, err := search(ctx, bulker, QueryActiveAgentsByTag, o, map[string]interface{}{
+		FieldTags: tag,
+		FieldSize: size,
+	})
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed searching for agents: %w", err)
+	}
+
+	ids := make([]string, len(res.Hits))
+	for i, hit := range res.Hits {
+		ids[i] = hit.ID
+	}
+	return ids, nil
+}
+
+// FindActiveAgentIDsByPolicyIDAndTag returns the IDs of up to size active agents enrolled in the policy with the tag.
+func FindActiveAgentIDsByPolicyIDAndTag(ctx context.Context, bulker bulk.Bulk, policyID, tag string, size int, opt ...Option) ([]string, error) {
+	o := newOption(FleetAgents, opt...)
+	return streamAgentIDs(ctx, bulker, QueryActiveAgentsByPolicyAndTag, o, map[string]interface{}{
+		FieldPolicyID: policyID,
+		FieldTags:     tag,
+		FieldSize:     size,
+	})
+}
+
+// streamAgentIDs returns the IDs of the agents the query selects, the hits are decoded as they are read so a large
+// size does not hold the whole response in memory.
+func streamAgentIDs(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, o queryOption, params map[string]interface{}) ([]string, error) {
+	ids := []string{}
+	err := searchStream(ctx, bulker, tmpl, o, params, func(hit *es.HitT) error {
+		ids = append(ids, hit.ID)
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed searching for agents: %w", err)
+	}
+	return ids, nil
+}
+
+// UpdateAgent updates the agent with the fields fn returns for it, and returns false when fn skips the update.
+// The update is conditioned on the seq_no and primary_term the agent was read with, so it is never computed from a state another
+// writer changed meanwhile. On a conflict the agent is read again and fn is called with its current state, up to
+// maxAgentUpdateRetries times, agent is then updated in place. The update is unconditional when the agent was read without them.
+func UpdateAgent(ctx context.Context, bulker bulk.Bulk, agent *model.Agent, fn func(agent *model.Agent) bulk.UpdateFields, opt ...Option) (bool, error) {
+	o := newOption(FleetAgents, opt...)
+	for retry := 0; ; retry++ {
+		doc := fn(agent)
+		if doc == nil {
+			return false, nil
+		}
+		body, err := doc.Marshal()
+		if err != nil {
+			return false, fmt.Errorf("could not create request body to update agent: %w", err)
+		}
+		err = bulker.Update(ctx, o.indexName, agent.Id, body, bulk.WithRefresh(), bulk.WithRetryOnConflict(3), bulk.WithIfSeqNo(agent.SeqNo, agent.PrimaryTerm))
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, es.ErrElasticVersionConflict) || retry == maxAgentUpdateRetries {
+			return false, err
+		}
+		zerolog.Ctx(ctx).Debug().Err(err).Str(logger.AgentID, agent.Id).Int("retry", retry+1).Msg("agent updated by another writer, reading it again")
+		current, err := GetAgent(ctx, bulker, agent.Id, opt...)
+		if err != nil {
+			return false, fmt.Errorf("failed reading agent after update conflict: %w", err)
+		}
+		*agent = current
+	}
+}
+
+// ReassignAgents assigns the active agents to the policy in a single bulk request, and returns the IDs of the agents that could not be updated.
+// The policy revision and coordinator indices of the agents are reset so their next checkin delivers the policy.
+// Each update is conditioned on the state the agent was read with, an agent that is not found or is no longer active is not reassigned.
+// The agents updated by another writer meanwhile are read again and retried up to maxAgentUpdateRetries times.
+func ReassignAgents(ctx context.Context, bulker bulk.Bulk, agentIDs []string, policyID string, opt ...Option) ([]string, error) {
+	o := newOption(FleetAgents, opt...)
+	body, err := bulk.UpdateFields{
+		FieldPolicyID:             policyID,
+		FieldPolicyRevisionIdx:    0,
+		FieldPolicyCoordinatorIdx: 0,
+		FieldUpdatedAt:            ftime.Now(),
+	}.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("could not create request body to reassign agents: %w", err)
+	}
+	agents, err := findAgentsByIDs(ctx, bulker, o, agentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var failed []string
+	for retry := 0; ; retry++ {
+		found := make(map[string]model.Agent, len(agents))
+		for _, agent := range agents {
+			found[agent.Id] = agent
+		}
+		ops := make([]bulk.MultiOp, 0, len(agentIDs))
+		for _, agentID := range agentIDs {
+			agent, ok := found[agentID]
+			if !ok || !agent.Active {
+				failed = append(failed, agentID)
+				continue
+			}
+			ops = append(ops, bulk.MultiOp{
+				ID:            agentID,
+				Body:          body,
+				Index:         o.indexName,
+				IfSeqNo:       agent.SeqNo,
+				IfPrimaryTerm: agent.PrimaryTerm,
+			})
+		}
+		if len(ops) == 0 {
+			return failed, nil
+		}
+		// An error is also returned when some of the updates failed, they are then found in the items.
+		items, err := bulker.MUpdate(ctx, ops, bulk.WithRefresh())
+		if len(items) != len(ops) {
+			return nil, fmt.Errorf("failed reassigning agents: %w", err)
+		}
+
+		agentIDs, agents = nil, nil
+		for i, item := range items {
+			err := es.TranslateError(item.Status, item.Error)
+			switch {
+			case err == nil:
+			case errors.Is(err, es.ErrElasticVersionConflict) && retry < maxAgentUpdateRetries:
+				agent, err := GetAgent(ctx, bulker, ops[i].ID, opt...)
+				if err != nil && !errors.Is(err, ErrNotFound) {
+					return nil, fmt.Errorf("failed reading agent after update conflict: %w", err)
+				}
+				agentIDs = append(agentIDs, ops[i].ID)
+				if err == nil {
+					agents = append(agents, agent)
+				}
+			default:
+				failed = append(failed, ops[i].ID)
+			}
+		}
+		if len(agentIDs) == 0 {
+			return failed, nil
+		}
+	}
+}
+
+// FindAgentsByIDs returns the agents found among agentIDs.
+func FindAgentsByIDs(ctx context.Context, bulker bulk.Bulk, agentIDs []string, opt ...Option) ([]model.Agent, error) {
+	return findAgentsByIDs(ctx, bulker, newOption(FleetAgents, opt...), agentIDs)
+}
+
+// findAgentsByIDs returns the agents found with their seq_no and primary_term.
+func findAgentsByIDs(ctx context.Context, bulker bulk.Bulk, o queryOption, agentIDs []string) ([]model.Agent, error) {
+	res, err := search(ctx, bulker, QueryAgentsByIDs, o, map[string]interface{}{
+		FieldID:   agentIDs,
+		FieldSize: len(agentIDs),
+	})
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed searching for agents: %w", err)
+	}
+	return agentsFromHits(res.Hits)
+}
+
+// UpdateAgentTags adds tags to the agent and removes tags from it, and returns the tags of the agent after the update.
+// The tags are updated with a script so concurrent updates of the tags of an agent do not overwrite each other.
+// ErrAgentTagsLimit is returned, and no tag is added or removed, when the agent would have more than maxTags tags.
+func UpdateAgentTags(ctx context.Context, bulker bulk.Bulk, agentID string, add, remove []string, maxTags int, opt ...Option) ([]string, error) {
+	o := newOption(FleetAgents, opt...)
+	if add == nil {
+		add = []string{}
+	}
+	if remove == nil {
+		remove = []string{}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"script": map[string]interface{}{
+			"lang":   "painless",
+			"source": agentTagsScript,
+			"params": map[string]interface{}{
+				"add":    add,
+				"remove": remove,
+				"max":    maxTags,
+				"now":    ftime.Now(),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create request body to update agent tags: %w", err)
+	}
+	if err := bulker.Update(ctx, o.indexName, agentID, body, bulk.WithRefresh(), bulk.WithRetryOnConflict(3)); err != nil {
+		if errors.Is(err, es.ErrElasticNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed updating agent tags: %w", err)
+	}
+
+	agent, err := GetAgent(ctx, bulker, agentID, opt...)
+	if err != nil {
+		return nil, err
+	}
+	tags := agent.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	// The script is a noop when the limit is exceeded, tags that were added are then missing.
+	// A tag that is missing although the agent has room for it was removed by a concurrent update.
+	missing := 0
+	for _, tag := range add {
+		if !slices.Contains(tags, tag) {
+			missing++
+		}
+	}
+	if missing > 0 && len(tags)+missing > maxTags {
+		return tags, fmt.Errorf("%w: adding %d tags to the %d tags of the agent exceeds the max of %d tags", ErrAgentTagsLimit, missing, len(tags), maxTags)
+	}
+	return tags, nil
+}
+
+// FindStaleUpgrades returns the IDs of up to size agents with an upgrade that started before and never completed or failed.
+func FindStaleUpgrades(ctx context.Context, bulker bulk.Bulk, before time.Time, size int, opt ...Option) ([]string, error) {
+	o := newOption(FleetAgents, opt...)
+	res, err := search(ctx, bulker, QueryStaleUpgrades, o, map[string]interface{}{
+		FieldUpgradeStartedAt: ftime.Format(before),
+		FieldSize:             size,
+	})
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed searching for agents: %w", err)
+	}
+
+	ids := make([]string, len(res.Hits))
+	for i, hit := range res.Hits {
+		ids[i] = hit.ID
+	}
+	return ids, nil
+}
+
+// FindOfflineAgents returns up to size online agents that did not check in since before, ordered by agent ID from
+// the first agent ID after after, so the agents are read in pages. Only the fields the state of the agents is
+// derived from are set.
+func FindOfflineAgents(ctx context.Context, bulker bulk.Bulk, before time.Time, after string, size int, opt ...Option) ([]model.Agent, error) {
+	o := newOption(FleetAgents, opt...)
+	res, err := search(ctx, bulker, QueryOfflineAgents, o, map[string]interface{}{
+		FieldLastCheckin: ftime.Format(before),
+		FieldAgentDocID:  after,
+		FieldSize:        size,
+	})
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed searching for agents: %w", err)
+	}
+
+	return agentsFromHits(res.Hits)
+}
+
+// FindPendingKeyInvalidations returns up to size unenrolled agents with API keys that remain to be invalidated.
+func FindPendingKeyInvalidations(ctx context.Context, bulker bulk.Bulk, size int, opt ...Option) ([]model.Agent, error) {
+	o := newOption(FleetAgents, opt...)
+	res, err := search(ctx, bulker, QueryPendingKeyInvalidations, o, map[string]interface{}{
+		FieldSize: size,
+	})
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed searching for agents: %w", err)
+	}
+	return agentsFromHits(res.Hits)
+}
+
+// FindStaleUnenrollments returns up to size active agents with an unenrollment that started before and was never acked,
+// ordered by agent ID from the first agent ID after after, so the agents are read in pages.
+func FindStaleUnenrollments(ctx context.Context, bulker bulk.Bulk, before time.Time, after string, size int, opt ...Option) ([]model.Agent, error) {
+	o := newOption(FleetAgents, opt...)
+	res, err := search(ctx, bulker, QueryStaleUnenrollments, o, map[string]interface{}{
+		FieldUnenrollmentStartedAt: ftime.Format(before),
+		FieldAgentDocID:            after,
+		FieldSize:                  size,
+	})
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed searching for agents: %w", err)
+	}
+	return agentsFromHits(res.Hits)
+}
+
+// FindPurgeableAgents returns up to size agents unenrolled before, the API keys of which are invalidated.
+func FindPurgeableAgents(ctx context.Context, bulker bulk.Bulk, before time.Time, size int, opt ...Option) ([]model.Agent, error) {
+	o := newOption(FleetAgents, opt...)
+	res, err := search(ctx, bulker, QueryPurgeableAgents, o, map[string]interface{}{
+		FieldUnenrolledAt: ftime.Format(before),
+		FieldSize:         size,
+	})
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed searching for agents: %w", err)
+	}
+	return agentsFromHits(res.Hits)
+}
+
+// FindAgentsBelowRevision returns up to size active agents of the policy on a revision lower than revisionIdx,
+// the agents on the oldest revisions first. Only the revision and the last checkin of the agents are read.
+func FindAgentsBelowRevision(ctx context.Context, bulker bulk.Bulk, policyID string, revisionIdx int64, size int, opt ...Option) ([]model.Agent, error) {
+	o := newOption(FleetAgents, opt...)
+	res, err := search(ctx, bulker, QueryAgentsBelowRevision, o, map[string]interface{}{
+		FieldPolicyID:          policyID,
+		FieldPolicyRevisionIdx: revisionIdx - 1,
+		FieldSize:              size,
+	})
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed searching for agents: %w", err)
+	}
+	return agentsFromHits(res.Hits)
+}
+
+// AggregatePolicyRevisions returns the number of active agents on each revision of each policy.
+func AggregatePolicyRevisions(ctx context.Context, bulker bulk.Bulk, opt ...Option) (map[string]map[int64]int64, error) {
+	o := newOption(FleetAgents, opt...)
+	query, err := o.filter(QueryPolicyRevisions)
+	if err != nil {
+		return nil, err
+	}
+	slow := logger.StartSlowTimer("query", logger.SlowThresholds().Query)
+	res, err := bulker.Search(ctx, o.indexName, query, bulk.WithIgnoreUnavailble())
+	slow.End(ctx, slowQueryFields(o.indexName, err))
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return map[string]map[int64]int64{}, nil
+		}
+		return nil, err
+	}
+
+	policies := make(map[string]map[int64]int64)
+	policyID, ok := res.Aggregations[FieldPolicyID]
+	if !ok {
+		// Aggregation will not be here if there index is not available
+		return policies, nil
+	}
+	for _, bucket := range policyID.Buckets {
+		revisionIdx, ok := bucket.SubAggregations[FieldPolicyRevisionIdx]
+		if !ok {
+			return nil, ErrMissingAggregations
+		}
+		revisions := make(map[int64]int64, len(revisionIdx.Buckets))
+		for _, rb := range revisionIdx.Buckets {
+			idx, err := strconv.ParseInt(rb.Key, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid policy revision %q: %w", rb.Key, err)
+			}
+			revisions[idx] = rb.DocCount
+		}
+		policies[bucket.Key] = revisions
+	}
+	return policies, nil
+}
+
+func agentsFromHits(hits []es.HitT) ([]model.Agent, error) {
+	agents := make([]model.Agent, len(hits))
+	for i, hit := range hits {
+		if err := hit.Unmarshal(&agents[i]); err != nil {
+			return nil, fmt.Errorf("could not unmarshal ES document into model.Agent: %w", err)
+		}
+		agents[i].Id = hit.ID
+	}
+	return agents, nil
+}
+
+// agentUnenrollingScript starts the unenrollment of an active agent, the update is a noop when the unenrollment already started.
+const agentUnenrollingScript = `
+if (ctx._source.active != true || ctx._source.unenrollment_started_at != null) {
+  ctx.op = 'noop';
+} else {
+  ctx._source.unenrollment_started_at = params.now;
+  ctx._source.lifecycle_state = params.state;
+  ctx._source.updated_at = params.now;
+}`
+
+// MarkAgentsUnenrolling starts the unenrollment of the agents in a single bulk request, and returns the IDs of the agents that could not be updated.
+// The unenrollment completes when the agent acks the UNENROLL action, or when it times out.
+func MarkAgentsUnenrolling(ctx context.Context, bulker bulk.Bulk, agentIDs []string, now time.Time, opt ...Option) ([]string, error) {
+	o := newOption(FleetAgents, opt...)
+	body, err := json.Marshal(map[string]interface{}{
+		"script": map[string]interface{}{
+			"lang":   "painless",
+			"source": agentUnenrollingScript,
+			"params": map[string]interface{}{
+				"now":   ftime.Format(now),
+				"state": model.AgentStateUnenrolling,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create request body to mark agents unenrolling: %w", err)
+	}
+	ops := make([]bulk.MultiOp, len(agentIDs))
+	for i, agentID := range agentIDs {
+		ops[i] = bulk.MultiOp{
+			ID:    agentID,
+			Body:  body,
+			Index: o.indexName,
+		}
+	}
+	items, err := bulker.MUpdate(ctx, ops, bulk.WithRefresh(), bulk.WithRetryOnConflict(3))
+	if err != nil {
+		return nil, fmt.Errorf("failed marking agents unenrolling: %w", err)
+	}
+	var failed []string
+	for i, item := range items {
+		if item.Status >= 300 && i < len(agentIDs) {
+			failed = append(failed, agentIDs[i])
+		}
+	}
+	return failed, nil
+}
+
+// CheckAgentTransition validates the lifecycle transition of the agent, it is called by every writer of the lifecycle_state field.
+// A rejected transition is logged with the logger of ctx and returned as a *model.TransitionError.
+func CheckAgentTransition(ctx context.Context, agentID string, from, to model.AgentState) error {
+	err := model.ValidateTransition(from, to)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Str(logger.AgentID, agentID).
+			Str("fleet.agent.state.from", string(from)).
+			Str("fleet.agent.state.to", string(to)).
+			Msg("rejected agent state transition")
+	}
+	return err
+}
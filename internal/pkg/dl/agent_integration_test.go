@@ -9,6 +9,8 @@ package dl
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -70,3 +72,177 @@ func TestFindAgent_NewModel(t *testing.T) {
 	assert.Equal(t, agentID, agent.Id)
 	assert.Equal(t, wantOutputs, agent.Outputs)
 }
+
+func TestUpdateAgentTags(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	index, bulker := ftesting.SetupCleanIndex(ctx, t, FleetAgents)
+
+	agentID := uuid.Must(uuid.NewV4()).String()
+	body, err := json.Marshal(model.Agent{
+		Active: true,
+		Tags:   []string{"existing"},
+	})
+	require.NoError(t, err)
+	_, err = bulker.Create(ctx, index, agentID, body, bulk.WithRefresh())
+	require.NoError(t, err)
+
+	t.Run("concurrent adds are not lost", func(t *testing.T) {
+		const n = 5
+		var wg sync.WaitGroup
+		errs := make(chan error, n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := UpdateAgentTags(ctx, bulker, agentID, []string{fmt.Sprintf("tag-%d", i)}, nil, 100, WithIndexName(index))
+				errs <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		agent, err := GetAgent(ctx, bulker, agentID, WithIndexName(index))
+		require.NoError(t, err)
+		assert.Len(t, agent.Tags, n+1)
+		assert.Contains(t, agent.Tags, "existing")
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		tags, err := UpdateAgentTags(ctx, bulker, agentID, nil, []string{"existing", "missing"}, 100, WithIndexName(index))
+		require.NoError(t, err)
+		assert.NotContains(t, tags, "existing")
+		assert.Len(t, tags, 5)
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		tags, err := UpdateAgentTags(ctx, bulker, agentID, []string{"one-too-many"}, nil, 5, WithIndexName(index))
+		require.ErrorIs(t, err, ErrAgentTagsLimit)
+		assert.NotContains(t, tags, "one-too-many")
+		assert.Len(t, tags, 5)
+	})
+
+	t.Run("find by tag", func(t *testing.T) {
+		ids, err := FindActiveAgentIDsByTag(ctx, bulker, "tag-3", 10, WithIndexName(index))
+		require.NoError(t, err)
+		assert.Equal(t, []string{agentID}, ids)
+	})
+
+	t.Run("missing agent", func(t *testing.T) {
+		_, err := UpdateAgentTags(ctx, bulker, "missing", []string{"tag"}, nil, 10, WithIndexName(index))
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestReassignAgents(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	index, bulker := ftesting.SetupCleanIndex(ctx, t, FleetAgents)
+
+	agents := []model.Agent{
+		{Active: true, PolicyID: "source", PolicyRevisionIdx: 3, Tags: []string{"production"}},
+		{Active: true, PolicyID: "source", PolicyRevisionIdx: 3},
+		{Active: true, PolicyID: "other", PolicyRevisionIdx: 1, Tags: []string{"production"}},
+	}
+	ids := make([]string, len(agents))
+	for i, agent := range agents {
+		ids[i] = uuid.Must(uuid.NewV4()).String()
+		body, err := json.Marshal(agent)
+		require.NoError(t, err)
+		_, err = bulker.Create(ctx, index, ids[i], body, bulk.WithRefresh())
+		require.NoError(t, err)
+	}
+
+	found, err := FindActiveAgentIDsByPolicyIDAndTag(ctx, bulker, "source", "production", 10, WithIndexName(index))
+	require.NoError(t, err)
+	require.Equal(t, []string{ids[0]}, found)
+
+	failed, err := ReassignAgents(ctx, bulker, []string{ids[0], "missing"}, "target", WithIndexName(index))
+	require.NoError(t, err)
+	require.Equal(t, []string{"missing"}, failed)
+
+	agent, err := GetAgent(ctx, bulker, ids[0], WithIndexName(index))
+	require.NoError(t, err)
+	assert.Equal(t, "target", agent.PolicyID)
+	assert.Equal(t, int64(0), agent.PolicyRevisionIdx)
+
+	// the update is refreshed, the agent is no longer found in its source policy
+	found, err = FindActiveAgentIDsByPolicyID(ctx, bulker, "source", 10, WithIndexName(index))
+	require.NoError(t, err)
+	require.Equal(t, []string{ids[1]}, found)
+}
+
+func TestFindAgentByIdempotencyKey(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	index, bulker := ftesting.SetupCleanIndex(ctx, t, FleetAgents)
+
+	now := time.Now().UTC()
+	agents := map[string]model.EnrollIdempotency{
+		"expired": {Key: "expired-key", RequestHash: "hash", ExpiresAt: now.Add(-time.Minute).Format(time.RFC3339Nano)},
+		"current": {Key: "current-key", RequestHash: "hash", ExpiresAt: now.Add(time.Minute).Format(time.RFC3339Nano)},
+	}
+	for id, idem := range agents {
+		body, err := json.Marshal(model.Agent{Active: true, EnrollIdempotency: &idem})
+		require.NoError(t, err)
+		_, err = bulker.Create(ctx, index, id, body, bulk.WithRefresh())
+		require.NoError(t, err)
+	}
+
+	agent, err := FindAgentByIdempotencyKey(ctx, bulker, "current-key", now, WithIndexName(index))
+	require.NoError(t, err)
+	assert.Equal(t, "current", agent.Id)
+	assert.Equal(t, "hash", agent.EnrollIdempotency.RequestHash)
+
+	_, err = FindAgentByIdempotencyKey(ctx, bulker, "expired-key", now, WithIndexName(index))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	_, err = FindAgentByIdempotencyKey(ctx, bulker, "current-key", now.Add(2*time.Minute), WithIndexName(index))
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestAgentNamespaces(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	index, bulker := ftesting.SetupCleanIndex(ctx, t, FleetAgents)
+
+	policyID := uuid.Must(uuid.NewV4()).String()
+	for id, ns := range map[string]string{"agent-a": "team-a", "agent-b": "team-b"} {
+		body, err := json.Marshal(model.Agent{Active: true, PolicyID: policyID, Namespaces: []string{ns}, Tags: []string{"production"}})
+		require.NoError(t, err)
+		_, err = bulker.Create(ctx, index, id, body, bulk.WithRefresh())
+		require.NoError(t, err)
+	}
+
+	ids, err := FindActiveAgentIDsByPolicyID(ctx, bulker, policyID, 10, WithIndexName(index))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"agent-a", "agent-b"}, ids)
+
+	ids, err = FindActiveAgentIDsByPolicyID(ctx, bulker, policyID, 10, WithIndexName(index), WithNamespace("team-a"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"agent-a"}, ids)
+
+	ids, err = FindActiveAgentIDsByTag(ctx, bulker, "production", 10, WithIndexName(index), WithNamespace("team-c"))
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	// the agents of another namespace are not read
+	_, err = FindAgent(ctx, bulker, QueryAgentByID, FieldID, "agent-b", WithIndexName(index), WithNamespace("team-a"))
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = GetAgent(ctx, bulker, "agent-b", WithIndexName(index), WithNamespace("team-a"))
+	require.ErrorIs(t, err, ErrNotFound)
+	agent, err := GetAgent(ctx, bulker, "agent-b", WithIndexName(index), WithNamespace("team-b"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team-b"}, agent.Namespaces)
+}
@@ -5,14 +5,257 @@
 package dl
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 )
 
 func TestPrepareAgentFindByEnrollmentID(t *testing.T) {
 
 	tmpl := prepareAgentFindByEnrollmentID()
 	query, _ := tmpl.RenderOne(FieldEnrollmentID, "1")
-	assert.Equal(t, `{"query":{"bool":{"filter":[{"term":{"enrollment_id":"1"}}]}},"version":true}`, string(query[:]))
+	assert.Equal(t, `{"query":{"bool":{"filter":[{"term":{"enrollment_id":"1"}}]}},"seq_no_primary_term":true,"version":true}`, string(query[:]))
+}
+
+func TestPrepareFindActiveAgentsByTag(t *testing.T) {
+	query, err := QueryActiveAgentsByTag.Render(map[string]interface{}{
+		FieldTags: "production",
+		FieldSize: 10,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"_source":{"includes":["_id"]},"query":{"bool":{"filter":[{"term":{"tags":"production"}},{"term":{"active":true}}]}},"size":10}`, string(query))
+}
+
+func TestPrepareFindStaleUnenrollments(t *testing.T) {
+	query, err := QueryStaleUnenrollments.Render(map[string]interface{}{
+		FieldUnenrollmentStartedAt: "2024-01-01T12:00:00Z",
+		FieldAgentDocID:            "agent-1",
+		FieldSize:                  10,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"query":{"bool":{"filter":[{"term":{"active":true}},{"range":{"unenrollment_started_at":{"lte":"2024-01-01T12:00:00Z"}}},{"range":{"agent.id":{"gt":"agent-1"}}}],"must_not":[{"exists":{"field":"unenrolled_at"}}]}},"seq_no_primary_term":true,"size":10,"sort":["agent.id"]}`, string(query))
+}
+
+func TestPrepareFindPendingKeyInvalidations(t *testing.T) {
+	query, err := QueryPendingKeyInvalidations.Render(map[string]interface{}{
+		FieldSize: 10,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"query":{"bool":{"filter":[{"term":{"api_keys_invalidation_pending":true}}]}},"seq_no_primary_term":true,"size":10}`, string(query))
+}
+
+func TestPrepareFindAgentsByIDs(t *testing.T) {
+	query, err := QueryAgentsByIDs.Render(map[string]interface{}{
+		FieldID:   []string{"agent-1", "agent-2"},
+		FieldSize: 2,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"query":{"bool":{"filter":[{"terms":{"_id":["agent-1","agent-2"]}}]}},"seq_no_primary_term":true,"size":2}`, string(query))
+}
+
+func TestPrepareFindAgentsBelowRevision(t *testing.T) {
+	query, err := QueryAgentsBelowRevision.Render(map[string]interface{}{
+		FieldPolicyID:          "policy-1",
+		FieldPolicyRevisionIdx: 7,
+		FieldSize:              100,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"_source":{"includes":["policy_revision_idx","last_checkin"]},"query":{"bool":{"filter":[{"term":{"policy_id":"policy-1"}},{"term":{"active":true}},{"range":{"policy_revision_idx":{"lte":7}}}]}},"size":100,"sort":["policy_revision_idx"]}`, string(query))
+}
+
+func TestAggregatePolicyRevisions(t *testing.T) {
+	assert.Equal(t, `{"aggs":{"policy_id":{"aggs":{"policy_revision_idx":{"terms":{"field":"policy_revision_idx","order":{"_key":"desc"},"size":100}}},"terms":{"field":"policy_id","size":10000}}},"query":{"bool":{"filter":[{"term":{"active":true}}]}},"size":0}`, string(QueryPolicyRevisions))
+
+	// the response of Elasticsearch, the keys of the revisions are numbers
+	var agg es.Aggregation
+	require.NoError(t, json.Unmarshal([]byte(`{"buckets":[
+		{"key":"policy-1","doc_count":10,"policy_revision_idx":{"buckets":[{"key":8,"doc_count":6},{"key":7,"doc_count":3},{"key":5,"doc_count":1}]}},
+		{"key":"policy-2","doc_count":2,"policy_revision_idx":{"buckets":[{"key":1,"doc_count":2}]}}
+	]}`), &agg))
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, FleetAgents, QueryPolicyRevisions, mock.Anything).Return(&es.ResultT{
+		Aggregations: map[string]es.Aggregation{FieldPolicyID: agg},
+	}, nil)
+
+	policies, err := AggregatePolicyRevisions(ctx, bulker)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[int64]int64{
+		"policy-1": {8: 6, 7: 3, 5: 1},
+		"policy-2": {1: 2},
+	}, policies)
+}
+
+var errConflict = &es.ErrElastic{Status: http.StatusConflict, Type: "version_conflict_engine_exception"}
+
+// unenrollActive unenrolls the agent, it is not updated once it is inactive.
+func unenrollActive(agent *model.Agent) bulk.UpdateFields {
+	if !agent.Active {
+		return nil
+	}
+	return bulk.UpdateFields{FieldActive: false}
+}
+
+func TestUpdateAgent(t *testing.T) {
+	t.Run("retried after a conflict", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		// the checkin of the agent was written after the agent was read
+		bulker.On("Update", mock.Anything, FleetAgents, "agent-1", mock.Anything, mock.Anything).Return(errConflict).Once()
+		bulker.On("ReadRaw", mock.Anything, FleetAgents, "agent-1", mock.Anything).Return(&bulk.MgetResponseItem{
+			SeqNo:       2,
+			PrimaryTerm: 1,
+			Source:      []byte(`{"active":true,"last_checkin":"2024-01-01T12:00:00Z"}`),
+		}, nil).Once()
+		bulker.On("Update", mock.Anything, FleetAgents, "agent-1", mock.Anything, mock.Anything).Return(nil).Once()
+		agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1", SeqNo: 1, PrimaryTerm: 1}, Active: true}
+
+		updated, err := UpdateAgent(ctx, bulker, agent, unenrollActive)
+		require.NoError(t, err)
+		assert.True(t, updated)
+		assert.Equal(t, int64(2), agent.SeqNo)
+		assert.Equal(t, "2024-01-01T12:00:00Z", agent.LastCheckin)
+		bulker.AssertExpectations(t)
+	})
+
+	t.Run("state changed by another writer", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Update", mock.Anything, FleetAgents, "agent-1", mock.Anything, mock.Anything).Return(errConflict).Once()
+		bulker.On("ReadRaw", mock.Anything, FleetAgents, "agent-1", mock.Anything).Return(&bulk.MgetResponseItem{
+			SeqNo:       2,
+			PrimaryTerm: 1,
+			Source:      []byte(`{"active":false,"unenrolled_at":"2024-01-01T12:00:00Z"}`),
+		}, nil).Once()
+		agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1", SeqNo: 1, PrimaryTerm: 1}, Active: true}
+
+		// the update computed from the state that was read is not written again
+		updated, err := UpdateAgent(ctx, bulker, agent, unenrollActive)
+		require.NoError(t, err)
+		assert.False(t, updated)
+		assert.Equal(t, "2024-01-01T12:00:00Z", agent.UnenrolledAt)
+		bulker.AssertExpectations(t)
+		bulker.AssertNumberOfCalls(t, "Update", 1)
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Update", mock.Anything, FleetAgents, "agent-1", mock.Anything, mock.Anything).Return(errConflict)
+		bulker.On("ReadRaw", mock.Anything, FleetAgents, "agent-1", mock.Anything).Return(&bulk.MgetResponseItem{
+			SeqNo:       2,
+			PrimaryTerm: 1,
+			Source:      []byte(`{"active":true}`),
+		}, nil)
+		agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent-1", SeqNo: 1, PrimaryTerm: 1}, Active: true}
+
+		updated, err := UpdateAgent(ctx, bulker, agent, unenrollActive)
+		require.ErrorIs(t, err, es.ErrElasticVersionConflict)
+		assert.False(t, updated)
+		bulker.AssertNumberOfCalls(t, "Update", maxAgentUpdateRetries+1)
+		bulker.AssertNumberOfCalls(t, "ReadRaw", maxAgentUpdateRetries)
+	})
+}
+
+func TestReassignAgentsConflicts(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		{ID: "agent-1", SeqNo: 1, PrimaryTerm: 1, Source: []byte(`{"active":true}`)},
+		{ID: "agent-2", SeqNo: 2, PrimaryTerm: 1, Source: []byte(`{"active":true}`)},
+		{ID: "agent-3", SeqNo: 3, PrimaryTerm: 1, Source: []byte(`{"active":false}`)},
+	}}}, nil).Once()
+	// both agents were updated by other writers, agent-2 was unenrolled meanwhile
+	bulker.On("ReadRaw", mock.Anything, FleetAgents, "agent-1", mock.Anything).Return(&bulk.MgetResponseItem{
+		SeqNo: 5, PrimaryTerm: 1, Source: []byte(`{"active":true}`),
+	}, nil).Once()
+	bulker.On("ReadRaw", mock.Anything, FleetAgents, "agent-2", mock.Anything).Return(&bulk.MgetResponseItem{
+		SeqNo: 6, PrimaryTerm: 1, Source: []byte(`{"active":false}`),
+	}, nil).Once()
+	var updates [][]bulk.MultiOp
+	conflict, err := json.Marshal(map[string]string{"type": "version_conflict_engine_exception"})
+	require.NoError(t, err)
+	bulker.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		updates = append(updates, args.Get(1).([]bulk.MultiOp))
+	}).Return([]bulk.BulkIndexerResponseItem{{Status: http.StatusConflict, Error: conflict}, {Status: http.StatusConflict, Error: conflict}}, errConflict).Once()
+	bulker.On("MUpdate", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		updates = append(updates, args.Get(1).([]bulk.MultiOp))
+	}).Return([]bulk.BulkIndexerResponseItem{{Status: http.StatusOK}}, nil).Once()
+
+	failed, err := ReassignAgents(ctx, bulker, []string{"agent-1", "agent-2", "agent-3", "missing"}, "target")
+	require.NoError(t, err)
+	// the inactive agents are not reassigned
+	assert.Equal(t, []string{"agent-3", "missing", "agent-2"}, failed)
+	require.Len(t, updates, 2)
+	assert.Equal(t, []string{"agent-1", "agent-2"}, []string{updates[0][0].ID, updates[0][1].ID})
+	assert.Equal(t, []int64{1, 2}, []int64{updates[0][0].IfSeqNo, updates[0][1].IfSeqNo})
+	require.Len(t, updates[1], 1)
+	assert.Equal(t, "agent-1", updates[1][0].ID)
+	assert.Equal(t, int64(5), updates[1][0].IfSeqNo)
+	assert.Equal(t, int64(1), updates[1][0].IfPrimaryTerm)
+	bulker.AssertExpectations(t)
+}
+
+func TestWithNamespace(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	t.Run("query filtered by namespace", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil).Once()
+
+		_, err := FindActiveAgentIDsByTag(ctx, bulker, "production", 10, WithNamespace("team-a"))
+		require.NoError(t, err)
+		query := bulker.Calls[0].Arguments.Get(2).([]byte)
+		assert.JSONEq(t, `{"_source":{"includes":["_id"]},"query":{"bool":{"filter":[{"term":{"namespaces":"team-a"}},{"bool":{"filter":[{"term":{"tags":"production"}},{"term":{"active":true}}]}}]}},"size":10}`, string(query))
+	})
+
+	t.Run("query without namespace", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil).Once()
+
+		_, err := FindActiveAgentIDsByTag(ctx, bulker, "production", 10)
+		require.NoError(t, err)
+		query := bulker.Calls[0].Arguments.Get(2).([]byte)
+		assert.JSONEq(t, `{"_source":{"includes":["_id"]},"query":{"bool":{"filter":[{"term":{"tags":"production"}},{"term":{"active":true}}]}},"size":10}`, string(query))
+	})
+
+	t.Run("agent read in another namespace", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("ReadRaw", mock.Anything, FleetAgents, "agent-1", mock.Anything).Return(&bulk.MgetResponseItem{
+			Source: []byte(`{"active":true,"namespaces":["team-a"]}`),
+		}, nil)
+
+		agent, err := GetAgent(ctx, bulker, "agent-1", WithNamespace("team-a"))
+		require.NoError(t, err)
+		assert.Equal(t, "agent-1", agent.Id)
+
+		_, err = GetAgent(ctx, bulker, "agent-1", WithNamespace("team-b"))
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("aggregation filtered by namespace", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil).Once()
+
+		_, err := AggregatePolicyRevisions(ctx, bulker, WithNamespace("team-a"))
+		require.NoError(t, err)
+		var query struct {
+			Query json.RawMessage `json:"query"`
+			Aggs  json.RawMessage `json:"aggs"`
+		}
+		require.NoError(t, json.Unmarshal(bulker.Calls[0].Arguments.Get(2).([]byte), &query))
+		assert.JSONEq(t, `{"bool":{"filter":[{"term":{"namespaces":"team-a"}},{"bool":{"filter":[{"term":{"active":true}}]}}]}}`, string(query.Query))
+		assert.NotEmpty(t, query.Aggs)
+	})
 }
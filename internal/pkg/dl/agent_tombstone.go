@@ -0,0 +1,61 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package dl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+// ErrAgentChanged is returned when an agent is not purged because its document changed since it was read,
+// as when the agent enrolled again with the same ID.
+var ErrAgentChanged = errors.New("agent changed since it was read")
+
+// NewAgentTombstone returns the tombstone of the unenrolled agent written when its document is purged at now.
+func NewAgentTombstone(agent *model.Agent, now time.Time) model.AgentTombstone {
+	return model.AgentTombstone{
+		Timestamp:        ftime.Format(now),
+		AgentID:          agent.Id,
+		PolicyID:         agent.PolicyID,
+		EnrolledAt:       agent.EnrolledAt,
+		UnenrolledAt:     agent.UnenrolledAt,
+		UnenrolledReason: agent.UnenrolledReason,
+	}
+}
+
+// PurgeAgent deletes the document of the unenrolled agent and writes its tombstone to the tombstones data stream.
+// The document is only deleted if it did not change since it was read, ErrAgentChanged is returned otherwise and no
+// tombstone is written, so an agent that enrolled again with the same ID is neither purged nor recorded as purged.
+// The tombstone ID is derived from the agent ID and unenrollment time, so a purge that is repeated, by another instance
+// that read the same agent, does not write it twice.
+func PurgeAgent(ctx context.Context, bulker bulk.Bulk, agent *model.Agent, now time.Time) error {
+	body, err := json.Marshal(NewAgentTombstone(agent, now))
+	if err != nil {
+		return err
+	}
+
+	err = bulker.Delete(ctx, FleetAgents, agent.Id, bulk.WithIfSeqNo(agent.SeqNo, agent.PrimaryTerm))
+	switch {
+	case err == nil, errors.Is(err, es.ErrElasticNotFound):
+	case errors.Is(err, es.ErrElasticVersionConflict):
+		return fmt.Errorf("failed to delete agent %s: %w", agent.Id, ErrAgentChanged)
+	default:
+		return fmt.Errorf("failed to delete agent %s: %w", agent.Id, err)
+	}
+
+	id := agent.Id + ":" + agent.UnenrolledAt
+	if _, err := bulker.Create(ctx, FleetAgentTombstones, id, body); err != nil && !errors.Is(err, es.ErrElasticVersionConflict) {
+		return fmt.Errorf("agent %s purged, failed to write its tombstone: %w", agent.Id, err)
+	}
+	return nil
+}
@@ -4,8 +4,13 @@
 
 package dl
 
+import (
+	"encoding/json"
+)
+
 type queryOption struct {
 	indexName string
+	namespace string
 }
 
 // Option for the operation being made
@@ -20,6 +25,14 @@ func WithIndexName(name string) Option {
 	}
 }
 
+// WithNamespace restricts the documents a query matches to the ones of the namespace.
+// The queries match the documents of all the namespaces when it is not set.
+func WithNamespace(namespace string) Option {
+	return func(opt *queryOption) {
+		opt.namespace = namespace
+	}
+}
+
 func newOption(defaultIndex string, opts ...Option) queryOption {
 	o := queryOption{indexName: defaultIndex}
 	for _, opt := range opts {
@@ -27,3 +40,29 @@ func newOption(defaultIndex string, opts ...Option) queryOption {
 	}
 	return o
 }
+
+// filter restricts the rendered query to the documents of the namespace of the option,
+// the query is returned unchanged when no namespace is set.
+func (o queryOption) filter(query []byte) ([]byte, error) {
+	if o.namespace == "" {
+		return query, nil
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(query, &body); err != nil {
+		return nil, err
+	}
+	filter := []interface{}{map[string]interface{}{
+		"term": map[string]interface{}{FieldNamespaces: o.namespace},
+	}}
+	if q, ok := body["query"]; ok {
+		filter = append(filter, q)
+	}
+	q, err := json.Marshal(map[string]interface{}{
+		"bool": map[string]interface{}{"filter": filter},
+	})
+	if err != nil {
+		return nil, err
+	}
+	body["query"] = q
+	return json.Marshal(body)
+}
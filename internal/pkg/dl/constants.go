@@ -11,58 +11,40 @@ const (
 	FleetActions           = ".fleet-actions"
 	FleetActionsResults    = ".fleet-actions-results"
 	FleetAgents            = ".fleet-agents"
+	FleetAgentTombstones   = "logs-fleet_server.agent_tombstones-default"
 	FleetArtifacts         = ".fleet-artifacts"
+	FleetAudit             = ".fleet-audit"
+	FleetCheckpoints       = ".fleet-checkpoints"
 	FleetEnrollmentAPIKeys = ".fleet-enrollment-api-keys"
 	FleetPolicies          = ".fleet-policies"
 	FleetOutputHealth      = "logs-fleet_server.output_health-default"
+	FleetServers           = ".fleet-servers"
 )
 
-// Query fields
+// Query fields, the fields of the documents described in model/schema are generated in fields.gen.go.
 const (
 	FieldSeqNo  = "_seq_no"
 	FieldSource = "_source"
 	FieldID     = "_id"
 
-	FieldMaxSeqNo    = "max_seq_no"
-	FieldActionSeqNo = "action_seq_no"
+	FieldMaxSeqNo = "max_seq_no"
 
-	FieldActionID                      = "action_id"
-	FieldAgent                         = "agent"
+	FieldActionResultAgentID = "agent_id"
+
+	// FieldAgentDocID is the ID of the agent in its document, it is set to the document ID at enrollment.
+	FieldAgentDocID                    = "agent.id"
 	FieldAgentVersion                  = "version"
-	FieldLastCheckin                   = "last_checkin"
-	FieldLastCheckinStatus             = "last_checkin_status"
-	FieldLastCheckinMessage            = "last_checkin_message"
-	FieldLocalMetadata                 = "local_metadata"
-	FieldComponents                    = "components"
-	FieldPolicyID                      = "policy_id"
 	FieldPolicyOutputAPIKey            = "api_key"
 	FieldPolicyOutputAPIKeyID          = "api_key_id"
 	FieldPolicyOutputPermissionsHash   = "permissions_hash"
 	FieldPolicyOutputToRetireAPIKeyIDs = "to_retire_api_key_ids" //nolint:gosec // false positive
-	FieldPolicyRevisionIdx             = "policy_revision_idx"
 	FieldRevisionIdx                   = "revision_idx"
-	FieldUnenrolledReason              = "unenrolled_reason"
-	FiledType                          = "type"
-	FieldUnhealthyReason               = "unhealthy_reason"
-
-	FieldActive           = "active"
-	FieldNamespaces       = "namespaces"
-	FieldTags             = "tags"
-	FieldUpdatedAt        = "updated_at"
-	FieldUnenrolledAt     = "unenrolled_at"
-	FieldUpgradedAt       = "upgraded_at"
-	FieldUpgradeStartedAt = "upgrade_started_at"
-	FieldUpgradeStatus    = "upgrade_status"
-	FieldUpgradeDetails   = "upgrade_details"
-	FieldUpgradeAttempts  = "upgrade_attempts"
 
-	FieldAuditUnenrolledTime   = "audit_unenrolled_time"
-	FieldAuditUnenrolledReason = "audit_unenrolled_reason"
+	FieldEnrollIdempotencyKey       = "enroll_idempotency.key"
+	FieldEnrollIdempotencyExpiresAt = "enroll_idempotency.expires_at"
 
 	FieldDecodedSha256 = "decoded_sha256"
 	FieldIdentifier    = "identifier"
-	FieldSharedID      = "shared_id"
-	FieldEnrollmentID  = "enrollment_id"
 )
 
 // Private constants
@@ -7,10 +7,12 @@ package dl
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 )
 
@@ -21,6 +23,7 @@ const (
 var (
 	QueryEnrollmentAPIKeyByID       = prepareFindActiveEnrollmentAPIKeyByID()
 	QueryEnrollmentAPIKeyByPolicyID = prepareFindActiveEnrollmentAPIKeyByPolicyID()
+	QueryEnrollmentAPIKeysByIDs     = prepareFindActiveEnrollmentAPIKeysByIDs()
 )
 
 func prepareFindActiveEnrollmentAPIKeyByID() *dsl.Tmpl {
@@ -47,6 +50,21 @@ func prepareFindActiveEnrollmentAPIKeyByPolicyID() *dsl.Tmpl {
 	return tmpl
 }
 
+// prepareFindActiveEnrollmentAPIKeysByIDs finds the active enrollment keys of the API keys api_key_id.
+func prepareFindActiveEnrollmentAPIKeysByIDs() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+
+	root := dsl.NewRoot()
+	filter := root.Query().Bool().Filter()
+	filter.Terms(FieldAPIKeyID, tmpl.Bind(FieldAPIKeyID), nil)
+	filter.Term(FieldActive, true, nil)
+	root.Source().Includes(FieldAPIKeyID)
+	root.WithSize(tmpl.Bind(FieldSize))
+
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
 func FindEnrollmentAPIKey(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, field string, id string) (rec model.EnrollmentAPIKey, err error) {
 	return findEnrollmentAPIKey(ctx, bulker, FleetEnrollmentAPIKeys, tmpl, field, id)
 }
@@ -86,6 +104,30 @@ func findEnrollmentAPIKeys(ctx context.Context, bulker bulk.Bulk, index string,
 	return recs, nil
 }
 
+// FindActiveEnrollmentAPIKeyIDs returns the ids of the API keys, among apiKeyIDs, of the active enrollment keys.
+func FindActiveEnrollmentAPIKeyIDs(ctx context.Context, bulker bulk.Bulk, apiKeyIDs []string, opt ...Option) (map[string]bool, error) {
+	o := newOption(FleetEnrollmentAPIKeys, opt...)
+	res, err := search(ctx, bulker, QueryEnrollmentAPIKeysByIDs, o, map[string]interface{}{
+		FieldAPIKeyID: apiKeyIDs,
+		FieldSize:     len(apiKeyIDs),
+	})
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return map[string]bool{}, nil
+		}
+		return nil, fmt.Errorf("failed searching for enrollment keys: %w", err)
+	}
+	found := make(map[string]bool, len(res.Hits))
+	for _, hit := range res.Hits {
+		var rec model.EnrollmentAPIKey
+		if err := hit.Unmarshal(&rec); err != nil {
+			return nil, err
+		}
+		found[rec.APIKeyID] = true
+	}
+	return found, nil
+}
+
 // CreateEnrollmentAPIKey creates a new enrollment API key
 func CreateEnrollmentAPIKey(ctx context.Context, bulker bulk.Bulk, key model.EnrollmentAPIKey, opt ...Option) (string, error) {
 	o := newOption(FleetEnrollmentAPIKeys, opt...)
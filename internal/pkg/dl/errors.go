@@ -4,6 +4,13 @@
 
 package dl
 
-import "errors"
+import "github.com/elastic/fleet-server/v7/internal/pkg/es"
 
-var ErrNotFound = errors.New("not found")
+// ErrNotFound is returned when a document is not found, it matches es.ErrElasticNotFound.
+var ErrNotFound error = notFoundError{}
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string { return "not found" }
+
+func (notFoundError) Unwrap() error { return es.ErrElasticNotFound }
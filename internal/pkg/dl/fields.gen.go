@@ -0,0 +1,77 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Code generated by schemagen. DO NOT EDIT.
+
+package dl
+
+// Document fields of the model/schema schemas
+const (
+	FieldAPIKeysInvalidatedAt             = "api_keys_invalidated_at"
+	FieldAPIKeysInvalidationPending       = "api_keys_invalidation_pending"
+	FieldAccessAPIKeyID                   = "access_api_key_id"
+	FieldActionID                         = "action_id"
+	FieldActionSeqNo                      = "action_seq_no"
+	FieldActive                           = "active"
+	FieldAgent                            = "agent"
+	FieldAgentID                          = "agent_id"
+	FieldAgentPolicyOutputPermissionsHash = "policy_output_permissions_hash"
+	FieldAgents                           = "agents"
+	FieldAuditUnenrolledReason            = "audit_unenrolled_reason"
+	FieldAuditUnenrolledTime              = "audit_unenrolled_time"
+	FieldComponents                       = "components"
+	FieldData                             = "data"
+	FieldDefaultAPIKey                    = "default_api_key"
+	FieldDefaultAPIKeyHistory             = "default_api_key_history"
+	FieldDefaultAPIKeyID                  = "default_api_key_id"
+	FieldEnrollIdempotency                = "enroll_idempotency"
+	FieldEnrolledAt                       = "enrolled_at"
+	FieldEnrollmentID                     = "enrollment_id"
+	FieldExpiration                       = "expiration"
+	FieldInputType                        = "input_type"
+	FieldLastActionError                  = "last_action_error"
+	FieldLastCheckin                      = "last_checkin"
+	FieldLastCheckinMessage               = "last_checkin_message"
+	FieldLastCheckinStatus                = "last_checkin_status"
+	FieldLastConnection                   = "last_connection"
+	FieldLastUpdated                      = "last_updated"
+	FieldLifecycleState                   = "lifecycle_state"
+	FieldLocalMetadata                    = "local_metadata"
+	FieldMinimumExecutionDuration         = "minimum_execution_duration"
+	FieldNamespaces                       = "namespaces"
+	FieldOldestPendingActionAge           = "oldest_pending_action_age"
+	FieldOutputs                          = "outputs"
+	FieldPackages                         = "packages"
+	FieldPendingActionsCount              = "pending_actions_count"
+	FieldPolicyCoordinatorIdx             = "policy_coordinator_idx"
+	FieldPolicyID                         = "policy_id"
+	FieldPolicyRevisionIdx                = "policy_revision_idx"
+	FieldReplaceToken                     = "replace_token"
+	FieldRetryOf                          = "retry_of"
+	FieldRolloutDurationSeconds           = "rollout_duration_seconds"
+	FieldSharedID                         = "shared_id"
+	FieldSigned                           = "signed"
+	FieldStartTime                        = "start_time"
+	FieldStatusFlapping                   = "status_flapping"
+	FieldTags                             = "tags"
+	FieldTarget                           = "target"
+	FieldTimeout                          = "timeout"
+	FieldTimestamp                        = "@timestamp"
+	FieldTraceparent                      = "traceparent"
+	FieldType                             = "type"
+	FieldUnenrolledAt                     = "unenrolled_at"
+	FieldUnenrolledReason                 = "unenrolled_reason"
+	FieldUnenrollmentStartedAt            = "unenrollment_started_at"
+	FieldUnhealthyReason                  = "unhealthy_reason"
+	FieldUpdatedAt                        = "updated_at"
+	FieldUpgradeAttempt                   = "upgrade_attempt"
+	FieldUpgradeAttempts                  = "upgrade_attempts"
+	FieldUpgradeDetails                   = "upgrade_details"
+	FieldUpgradeStartedAt                 = "upgrade_started_at"
+	FieldUpgradeStatus                    = "upgrade_status"
+	FieldUpgradeTargetVersion             = "upgrade_target_version"
+	FieldUpgradedAt                       = "upgraded_at"
+	FieldUserID                           = "user_id"
+	FieldUserProvidedMetadata             = "user_provided_metadata"
+)
@@ -17,6 +17,7 @@ import (
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
 )
 
 type (
@@ -222,7 +223,7 @@ func migrateAgentOutputs() (string, string, []byte, error) {
 	query := dsl.NewRoot()
 	query.Query().Bool().Must().Exists(fieldDefaultAPIKeyID)
 
-	fields := map[string]interface{}{fieldRetiredAt: timeNow().UTC().Format(time.RFC3339)}
+	fields := map[string]interface{}{fieldRetiredAt: ftime.Format(timeNow())}
 	painless := `
 // set up the new fields
 ctx._source['` + fieldOutputs + `']=new HashMap();
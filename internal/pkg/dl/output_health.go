@@ -7,9 +7,9 @@ package dl
 import (
 	"context"
 	"encoding/json"
-	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/gofrs/uuid"
 )
@@ -20,7 +20,7 @@ func CreateOutputHealth(ctx context.Context, bulker bulk.Bulk, doc model.OutputH
 
 func createOutputHealth(ctx context.Context, bulker bulk.Bulk, index string, doc model.OutputHealth) error {
 	if doc.Timestamp == "" {
-		doc.Timestamp = time.Now().UTC().Format(time.RFC3339)
+		doc.Timestamp = ftime.Now()
 	}
 	doc.DataStream = &model.DataStream{
 		Dataset:   "fleet_server.output_health",
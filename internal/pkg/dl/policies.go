@@ -22,6 +22,8 @@ var (
 	tmplQueryLatestPolicies = prepareQueryLatestPolicies()
 	ErrMissingAggregations  = errors.New("missing expected aggregation result")
 	tmplQueryPolicies       = prepareQueryPolicies()
+	tmplQueryLatestPolicy   = prepareQueryPolicyRevision(false)
+	tmplQueryPolicyRevision = prepareQueryPolicyRevision(true)
 )
 
 func prepareQueryLatestPolicies() []byte {
@@ -39,7 +41,13 @@ func prepareQueryLatestPolicies() []byte {
 // QueryLatestPolicies gets the latest revision for a policy
 func QueryLatestPolicies(ctx context.Context, bulker bulk.Bulk, opt ...Option) ([]model.Policy, error) {
 	o := newOption(FleetPolicies, opt...)
-	res, err := bulker.Search(ctx, o.indexName, tmplQueryLatestPolicies, bulk.WithIgnoreUnavailble())
+	query, err := o.filter(tmplQueryLatestPolicies)
+	if err != nil {
+		return nil, err
+	}
+	slow := logger.StartSlowTimer("query", logger.SlowThresholds().Query)
+	res, err := bulker.Search(ctx, o.indexName, query, bulk.WithIgnoreUnavailble())
+	slow.End(ctx, slowQueryFields(o.indexName, err))
 	if err != nil {
 		return nil, err
 	}
@@ -67,6 +75,49 @@ func QueryLatestPolicies(ctx context.Context, bulker bulk.Bulk, opt ...Option) (
 	return policies, nil
 }
 
+func prepareQueryPolicyRevision(revision bool) *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	root.Size(1)
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldPolicyID, tmpl.Bind(FieldPolicyID), nil)
+	if revision {
+		filter.Term(FieldRevisionIdx, tmpl.Bind(FieldRevisionIdx), nil)
+	}
+	root.Sort().SortOrder(FieldRevisionIdx, dsl.SortDescend)
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// FindPolicyRevision gets the revisionIdx revision of a policy, or its latest revision if revisionIdx is not positive.
+// ErrNotFound is returned if the policy or the revision does not exist.
+func FindPolicyRevision(ctx context.Context, bulker bulk.Bulk, policyID string, revisionIdx int64, opt ...Option) (model.Policy, error) {
+	o := newOption(FleetPolicies, opt...)
+	tmpl := tmplQueryLatestPolicy
+	params := map[string]interface{}{
+		FieldPolicyID: policyID,
+	}
+	if revisionIdx > 0 {
+		tmpl = tmplQueryPolicyRevision
+		params[FieldRevisionIdx] = revisionIdx
+	}
+	res, err := search(ctx, bulker, tmpl, o, params)
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			err = ErrNotFound
+		}
+		return model.Policy{}, err
+	}
+	if len(res.Hits) == 0 {
+		return model.Policy{}, ErrNotFound
+	}
+	var policy model.Policy
+	if err := res.Hits[0].Unmarshal(&policy); err != nil {
+		return model.Policy{}, err
+	}
+	return policy, nil
+}
+
 // CreatePolicy creates a new policy in the index
 func CreatePolicy(ctx context.Context, bulker bulk.Bulk, policy model.Policy, opt ...Option) (string, error) {
 	o := newOption(FleetPolicies, opt...)
@@ -92,7 +143,7 @@ func prepareQueryPolicies() *dsl.Tmpl {
 func QueryOutputFromPolicy(ctx context.Context, bulker bulk.Bulk, outputName string, opt ...Option) (*model.Policy, error) {
 	o := newOption(FleetPolicies, opt...)
 	params := map[string]interface{}{}
-	res, err := Search(ctx, bulker, tmplQueryPolicies, o.indexName, params)
+	res, err := search(ctx, bulker, tmplQueryPolicies, o, params)
 	if err != nil {
 		if errors.Is(err, es.ErrIndexNotFound) {
 			zerolog.Ctx(ctx).Debug().Str("index", o.indexName).Msg(es.ErrIndexNotFound.Error())
@@ -106,6 +106,30 @@ func TestCreatePolicy(t *testing.T) {
 	}
 }
 
+func TestFindPolicyRevision(t *testing.T) {
+	ctx, cn := context.WithCancel(context.Background())
+	defer cn()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	index, bulker := ftesting.SetupCleanIndex(ctx, t, FleetPolicies)
+
+	rec, err := storeRandomPolicy(ctx, bulker, index)
+	require.NoError(t, err)
+
+	policy, err := FindPolicyRevision(ctx, bulker, rec.PolicyID, 0, WithIndexName(index))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), policy.RevisionIdx)
+
+	policy, err = FindPolicyRevision(ctx, bulker, rec.PolicyID, 2, WithIndexName(index))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), policy.RevisionIdx)
+
+	_, err = FindPolicyRevision(ctx, bulker, rec.PolicyID, 4, WithIndexName(index))
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = FindPolicyRevision(ctx, bulker, "missing", 0, WithIndexName(index))
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
 func TestQueryOutputFromPolicy(t *testing.T) {
 	ctx, cn := context.WithCancel(context.Background())
 	defer cn()
@@ -7,18 +7,30 @@ package dl
 import (
 	"context"
 
+	"github.com/rs/zerolog"
+
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 )
 
 func Search(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, index string, params map[string]interface{}, opts ...bulk.Opt) (*es.HitsT, error) {
-	query, err := tmpl.Render(params)
+	return search(ctx, bulker, tmpl, queryOption{indexName: index}, params, opts...)
+}
+
+// search runs the query in the index of o, restricted to the namespace of o when it is set.
+func search(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, o queryOption, params map[string]interface{}, opts ...bulk.Opt) (*es.HitsT, error) {
+	slow := logger.StartSlowTimer("query", logger.SlowThresholds().Query)
+	query, err := render(tmpl, o, params)
 	if err != nil {
 		return nil, err
 	}
+	slow.Phase("render")
 
-	res, err := bulker.Search(ctx, index, query, opts...)
+	res, err := bulker.Search(ctx, o.indexName, query, opts...)
+	slow.Phase("search")
+	slow.End(ctx, slowQueryFields(o.indexName, err))
 	if err != nil {
 		return nil, err
 	}
@@ -27,14 +39,55 @@ func Search(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, index string,
 }
 
 func SearchWithOneParam(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, index string, name string, v interface{}) (*es.HitsT, error) {
+	slow := logger.StartSlowTimer("query", logger.SlowThresholds().Query)
 	query, err := tmpl.RenderOne(name, v)
 	if err != nil {
 		return nil, err
 	}
+	slow.Phase("render")
 	res, err := bulker.Search(ctx, index, query)
+	slow.Phase("search")
+	slow.End(ctx, slowQueryFields(index, err))
 	if err != nil {
 		return nil, err
 	}
 
 	return &res.HitsT, nil
 }
+
+// SearchStream runs the query and calls fn for each hit as it is decoded from the response, the hits are not held in
+// memory. It fails with es.ErrResponseTooLarge when the response is larger than the max search response size.
+func SearchStream(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, index string, params map[string]interface{}, fn func(hit *es.HitT) error, opts ...bulk.Opt) error {
+	return searchStream(ctx, bulker, tmpl, queryOption{indexName: index}, params, fn, opts...)
+}
+
+// searchStream streams the hits of the query in the index of o, restricted to the namespace of o when it is set.
+func searchStream(ctx context.Context, bulker bulk.Bulk, tmpl *dsl.Tmpl, o queryOption, params map[string]interface{}, fn func(hit *es.HitT) error, opts ...bulk.Opt) error {
+	slow := logger.StartSlowTimer("query", logger.SlowThresholds().Query)
+	query, err := render(tmpl, o, params)
+	if err != nil {
+		return err
+	}
+	slow.Phase("render")
+
+	err = bulker.SearchStream(ctx, o.indexName, query, fn, opts...)
+	slow.Phase("search")
+	slow.End(ctx, slowQueryFields(o.indexName, err))
+	return err
+}
+
+// render renders the query of tmpl with params, filtered by the namespace of o.
+func render(tmpl *dsl.Tmpl, o queryOption, params map[string]interface{}) ([]byte, error) {
+	query, err := tmpl.Render(params)
+	if err != nil {
+		return nil, err
+	}
+	return o.filter(query)
+}
+
+// slowQueryFields adds the index and the error of a query to its slow operation log line.
+func slowQueryFields(index string, err error) func(e *zerolog.Event) {
+	return func(e *zerolog.Event) {
+		e.Err(err).Str("index", index)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package dl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+const (
+	FieldLastSeen        = "last_seen"
+	FieldServerStatus    = "status"
+	FieldConnectedAgents = "connected_agents"
+	FieldDistinctAgents  = "distinct_agents"
+	FieldStale           = "stale"
+	FieldDraining        = "draining"
+	FieldDrainingSince   = "draining_since"
+	FieldResources       = "resources"
+	FieldOperations      = "operations"
+	FieldOfflineBy       = "offline_by"
+
+	// ServerStatusStopped is the status of a fleet server that was shut down.
+	ServerStatusStopped = "STOPPED"
+	// ServerStatusOffline is the status of a fleet server that stopped sending heartbeats without being shut down.
+	ServerStatusOffline = "OFFLINE"
+)
+
+// serverOfflineScript flags the document of a fleet server as stale and offline, taken over by the fleet server params.by.
+// The agents connected to the server reconnect to other instances, it no longer counts them.
+// The update is a noop when the server sent a heartbeat since params.before, or when it was stopped or flagged meanwhile,
+// so a janitor that read an outdated document does not overwrite a newer heartbeat, and a single janitor takes it over.
+const serverOfflineScript = `
+String lastSeen = ctx._source.last_seen;
+if ((lastSeen != null && lastSeen.compareTo(params.before) > 0) || ctx._source.stale == true || ctx._source.status == params.stopped) {
+  ctx.op = 'noop';
+} else {
+  ctx._source.stale = true;
+  ctx._source.status = params.offline;
+  ctx._source.offline_by = params.by;
+  ctx._source.connected_agents = 0;
+}`
+
+var (
+	// QueryStaleServers finds the fleet servers that are running and did not send a heartbeat since last_seen.
+	QueryStaleServers = prepareFindStaleServers()
+	// QueryLeaderServer finds the running fleet server with the lowest id that sent a heartbeat since last_seen.
+	QueryLeaderServer = prepareFindLeaderServer()
+)
+
+func prepareFindStaleServers() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	b := root.Query().Bool()
+	b.Filter().Range(FieldLastSeen, dsl.WithRangeLTE(tmpl.Bind(FieldLastSeen)))
+	mustNot := b.MustNot()
+	mustNot.Term(FieldStale, true, nil)
+	mustNot.Term(FieldServerStatus, ServerStatusStopped, nil)
+	root.Source().Includes(FieldLastSeen)
+	root.WithSize(tmpl.Bind(FieldSize))
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+func prepareFindLeaderServer() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	b := root.Query().Bool()
+	b.Filter().Range(FieldLastSeen, dsl.WithRangeGT(tmpl.Bind(FieldLastSeen)))
+	mustNot := b.MustNot()
+	mustNot.Term(FieldStale, true, nil)
+	mustNot.Term(FieldServerStatus, ServerStatusStopped, nil)
+	root.Sort().SortOrder(FieldAgentDocID, dsl.SortAscend)
+	root.Source().Includes(FieldAgentDocID)
+	root.Size(1)
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// IndexServer writes the document of a fleet server, replacing the previous document of the server.
+func IndexServer(ctx context.Context, bulker bulk.Bulk, doc model.Server, opts ...Option) error {
+	o := newOption(FleetServers, opts...)
+	if doc.Timestamp == "" {
+		doc.Timestamp = ftime.Now()
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = bulker.Index(ctx, o.indexName, doc.Agent.ID, body)
+	return err
+}
+
+// UpdateServer updates fields of the document of the fleet server id.
+func UpdateServer(ctx context.Context, bulker bulk.Bulk, id string, fields bulk.UpdateFields, opts ...Option) error {
+	o := newOption(FleetServers, opts...)
+	body, err := fields.Marshal()
+	if err != nil {
+		return err
+	}
+	return bulker.Update(ctx, o.indexName, id, body)
+}
+
+// FindStaleServers returns the ids of up to size fleet servers that are neither stopped nor flagged as stale,
+// and that did not send a heartbeat since before.
+func FindStaleServers(ctx context.Context, bulker bulk.Bulk, before time.Time, size int, opts ...Option) ([]string, error) {
+	o := newOption(FleetServers, opts...)
+	res, err := search(ctx, bulker, QueryStaleServers, o, map[string]interface{}{
+		FieldLastSeen: ftime.Format(before),
+		FieldSize:     size,
+	})
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	ids := make([]string, len(res.Hits))
+	for i, hit := range res.Hits {
+		ids[i] = hit.ID
+	}
+	return ids, nil
+}
+
+// MarkServerOffline flags the fleet server id as stale and offline if it did not send a heartbeat since before, and
+// returns its document if the fleet server by flagged it, by then takes over the operations of the document.
+// A nil document is returned when the server sent a heartbeat, was stopped, or was flagged by another instance.
+// The update fails with es.ErrElasticVersionConflict if the document was updated concurrently, by a heartbeat or by another instance.
+func MarkServerOffline(ctx context.Context, bulker bulk.Bulk, id string, before time.Time, by string, opts ...Option) (*model.Server, error) {
+	o := newOption(FleetServers, opts...)
+	body, err := json.Marshal(map[string]interface{}{
+		"script": map[string]interface{}{
+			"lang":   "painless",
+			"source": serverOfflineScript,
+			"params": map[string]interface{}{
+				"before":  ftime.Format(before),
+				"stopped": ServerStatusStopped,
+				"offline": ServerStatusOffline,
+				"by":      by,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create request body to mark fleet server offline: %w", err)
+	}
+	if err := bulker.Update(ctx, o.indexName, id, body); err != nil {
+		return nil, err
+	}
+
+	// the update does not tell if the script flagged the document, the document is read back
+	p, err := bulker.Read(ctx, o.indexName, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the fleet server document: %w", err)
+	}
+	var doc model.Server
+	if err := json.Unmarshal(p, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode the fleet server document: %w", err)
+	}
+	if !doc.Stale || doc.OfflineBy != by {
+		return nil, nil
+	}
+	doc.Id = id
+	return &doc, nil
+}
+
+// FindLeaderServer returns the id of the leader of the fleet servers, the running fleet server with the lowest id among
+// the servers that sent a heartbeat since after. An empty id is returned when no server is running.
+func FindLeaderServer(ctx context.Context, bulker bulk.Bulk, after time.Time, opts ...Option) (string, error) {
+	o := newOption(FleetServers, opts...)
+	res, err := search(ctx, bulker, QueryLeaderServer, o, map[string]interface{}{
+		FieldLastSeen: ftime.Format(after),
+	})
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	if len(res.Hits) == 0 {
+		return "", nil
+	}
+	return res.Hits[0].ID, nil
+}
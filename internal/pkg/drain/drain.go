@@ -0,0 +1,75 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package drain holds the draining state of the fleet server, used to move the agents to the other instances before
+// the instance is shut down, for instance during a blue/green upgrade.
+package drain
+
+import (
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// State is the draining state of the fleet server instance.
+// Once draining starts the instance stops attracting agents: its status is reported as degraded, the checkin responses
+// hint a backoff and the enrollments are rejected. The requests in flight and the acks are served normally until the
+// instance is shut down. Draining can not be stopped, the instance is expected to be shut down.
+// The methods of a nil State report an instance that is not draining.
+type State struct {
+	mx      sync.Mutex
+	since   time.Time
+	started chan struct{}
+}
+
+// New returns the state of an instance that is not draining.
+func New() *State {
+	return &State{started: make(chan struct{})}
+}
+
+// Start starts draining, it returns false if the instance was already draining.
+func (s *State) Start() bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if !s.since.IsZero() {
+		return false
+	}
+	s.since = time.Now().UTC()
+	close(s.started)
+	return true
+}
+
+// Draining returns true once draining started.
+func (s *State) Draining() bool {
+	return !s.Since().IsZero()
+}
+
+// Since returns the time draining started, or the zero time if the instance is not draining.
+func (s *State) Since() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.since
+}
+
+// Started returns a channel that is closed when draining starts.
+func (s *State) Started() <-chan struct{} {
+	return s.started
+}
+
+// Register reports the draining state as the "drain" namespace of reg.
+func (s *State) Register(reg *monitoring.Registry) {
+	monitoring.NewFunc(reg, "drain", func(_ monitoring.Mode, v monitoring.Visitor) {
+		since := s.Since()
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		monitoring.ReportBool(v, "draining", !since.IsZero())
+		if !since.IsZero() {
+			monitoring.ReportString(v, "since", since.Format(time.RFC3339))
+		}
+	})
+}
@@ -0,0 +1,52 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package drain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestState(t *testing.T) {
+	s := New()
+	reg := monitoring.NewRegistry()
+	s.Register(reg)
+
+	assert.False(t, s.Draining())
+	assert.True(t, s.Since().IsZero())
+	assert.Equal(t, map[string]any{"drain": map[string]any{"draining": false}}, monitoring.CollectStructSnapshot(reg, monitoring.Full, false))
+	select {
+	case <-s.Started():
+		t.Fatal("started before draining")
+	default:
+	}
+
+	require.True(t, s.Start())
+	assert.True(t, s.Draining())
+	since := s.Since()
+	assert.WithinDuration(t, time.Now(), since, time.Minute)
+	select {
+	case <-s.Started():
+	default:
+		t.Fatal("not started once draining")
+	}
+	assert.Equal(t, map[string]any{"drain": map[string]any{"draining": true, "since": since.Format(time.RFC3339)}}, monitoring.CollectStructSnapshot(reg, monitoring.Full, false))
+
+	// draining again keeps the start time
+	require.False(t, s.Start())
+	assert.Equal(t, since, s.Since())
+}
+
+func TestNilState(t *testing.T) {
+	var s *State
+	assert.False(t, s.Draining())
+	assert.True(t, s.Since().IsZero())
+}
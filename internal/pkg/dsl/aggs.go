@@ -15,3 +15,11 @@ func (n *Node) Agg(name string) *Node {
 func (n *Node) Max() *Node {
 	return n.findOrCreateChildByName(kKeywordMax)
 }
+
+func (n *Node) Sum() *Node {
+	return n.findOrCreateChildByName(kKeywordSum)
+}
+
+func (n *Node) Composite() *Node {
+	return n.findOrCreateChildByName(kKeywordComposite)
+}
@@ -5,7 +5,7 @@
 package dsl
 
 func (n *Node) Exists(field string) {
-	childNode := n.findOrCreateChildByName(kKeywordExists)
+	childNode := n.appendOrSetChildNode(kKeywordExists)
 	childNode.nodeMap = nodeMapT{kKeywordField: &Node{
 		leaf: field,
 	}}
@@ -8,6 +8,7 @@ const (
 	kKeywordAggs        = "aggs"
 	kKeywordBool        = "bool"
 	kKeywordBoost       = "boost"
+	kKeywordComposite   = "composite"
 	kKeywordExcludes    = "excludes"
 	kKeywordExists      = "exists"
 	kKeywordField       = "field"
@@ -27,6 +28,7 @@ const (
 	kKeywordSize        = "size"
 	kKeywordSort        = "sort"
 	kKeywordSource      = "_source"
+	kKeywordSum         = "sum"
 	kKeywordTerm        = "term"
 	kKeywordTerms       = "terms"
 	kKeywordTopHits     = "top_hits"
@@ -23,33 +23,12 @@ import (
 	"github.com/elastic/go-elasticsearch/v8"
 )
 
-const (
-	initialRetryBackoff = 500 * time.Millisecond
-	maxRetryBackoff     = 10 * time.Second
-	randomizationFactor = 0.5
-	defaultMaxRetries   = 5
-)
-
 type ConfigOption func(config *elasticsearch.Config)
 
 func applyDefaultOptions(escfg *elasticsearch.Config) {
-	exp := backoff.NewExponentialBackOff()
-	exp.InitialInterval = initialRetryBackoff
-	exp.RandomizationFactor = randomizationFactor
-	exp.MaxInterval = maxRetryBackoff
-
+	// The retried statuses, backoff and number of retries come from the output configuration.
 	opts := []ConfigOption{
-		WithRetryOnErrs(syscall.ECONNREFUSED, syscall.ECONNRESET), // server may be restarting
-
-		WithRetryOnStatus(http.StatusTooManyRequests),
-		WithRetryOnStatus(http.StatusRequestTimeout),
-		WithRetryOnStatus(http.StatusTooEarly),
-		WithRetryOnStatus(http.StatusBadGateway),
-		WithRetryOnStatus(http.StatusServiceUnavailable),
-		WithRetryOnStatus(http.StatusGatewayTimeout),
-
-		WithBackoff(exp),
-		WithMaxRetries(defaultMaxRetries),
+		WithRetryOnErrs(syscall.ECONNREFUSED, syscall.ECONNRESET, ErrProxy), // server or proxy may be restarting
 	}
 
 	for _, opt := range opts {
@@ -68,6 +47,11 @@ func NewClient(ctx context.Context, cfg *config.Config, longPoll bool, opts ...C
 	// apply default config
 	applyDefaultOptions(&escfg)
 
+	// the proxy failures are told apart from the failures of elasticsearch
+	if t, ok := escfg.Transport.(*http.Transport); ok && t.Proxy != nil {
+		escfg.Transport = newProxyTransport(t)
+	}
+
 	// Apply configuration options
 	for _, opt := range opts {
 		opt(&escfg)
@@ -109,6 +93,16 @@ func WithUsrPwd(usr, pwd string) ConfigOption {
 	}
 }
 
+// WithProxyHealth reports the health of the proxy in front of elasticsearch to h.
+// It must be applied before the options wrapping the transport.
+func WithProxyHealth(h *ProxyHealth) ConfigOption {
+	return func(config *elasticsearch.Config) {
+		if t, ok := config.Transport.(*proxyTransport); ok {
+			t.health = h
+		}
+	}
+}
+
 func InstrumentRoundTripper() ConfigOption {
 	return func(config *elasticsearch.Config) {
 		config.Transport = apmelasticsearch.WrapRoundTripper(
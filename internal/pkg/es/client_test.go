@@ -8,14 +8,23 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/testing/certs"
+	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/stretchr/testify/require"
 )
 
@@ -162,3 +171,141 @@ func TestClientCerts(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestClientAuthorization(t *testing.T) {
+	auth := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth <- r.Header.Get("Authorization")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		fmt.Fprintln(w, "{}")
+	}))
+	defer server.Close()
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("file-token\n"), 0o600))
+
+	tests := map[string]struct {
+		es     config.Elasticsearch
+		header string
+	}{
+		"service token": {
+			es:     config.Elasticsearch{Hosts: []string{server.URL}, ServiceToken: "test-token"},
+			header: "Bearer test-token",
+		},
+		"service token path": {
+			es:     config.Elasticsearch{Hosts: []string{server.URL}, ServiceTokenPath: tokenPath},
+			header: "Bearer file-token",
+		},
+		"username and password": {
+			es:     config.Elasticsearch{Hosts: []string{server.URL}, Username: "fleet", Password: "secret"},
+			header: "Basic " + base64.StdEncoding.EncodeToString([]byte("fleet:secret")),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			client, err := NewClient(context.Background(), &config.Config{Output: config.Output{Elasticsearch: tc.es}}, false)
+			require.NoError(t, err)
+			res, err := client.Info()
+			require.NoError(t, err)
+			res.Body.Close()
+			require.Equal(t, tc.header, <-auth)
+		})
+	}
+}
+
+// flakyTransport fails the first failures requests with a 503 response, or err when it is set.
+type flakyTransport struct {
+	failures int32
+	err      error
+	calls    atomic.Int32
+}
+
+func (f *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	n := f.calls.Add(1)
+	if n <= f.failures {
+		if f.err != nil {
+			return nil, f.err
+		}
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(strings.NewReader("{}")), Request: req}, nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader("{}")),
+		Request:    req,
+	}, nil
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := map[string]struct {
+		maxRetries int
+		longPoll   bool
+		failures   int32
+		err        error
+		status     int
+		calls      int32
+	}{
+		"retried until success": {
+			maxRetries: 3,
+			failures:   2,
+			status:     http.StatusOK,
+			calls:      3,
+		},
+		"max_retries exhausted": {
+			maxRetries: 2,
+			failures:   5,
+			status:     http.StatusServiceUnavailable,
+			calls:      3,
+		},
+		"retries disabled": {
+			maxRetries: 0,
+			failures:   1,
+			status:     http.StatusServiceUnavailable,
+			calls:      1,
+		},
+		"long poll client is not retried": {
+			maxRetries: 3,
+			longPoll:   true,
+			failures:   1,
+			status:     http.StatusServiceUnavailable,
+			calls:      1,
+		},
+		"connection refused is retried": {
+			maxRetries: 1,
+			failures:   1,
+			err:        syscall.ECONNREFUSED,
+			status:     http.StatusOK,
+			calls:      2,
+		},
+		"proxy failure is retried": {
+			maxRetries: 1,
+			failures:   1,
+			err:        &ProxyError{Proxy: "proxy:3128", Status: http.StatusBadGateway},
+			status:     http.StatusOK,
+			calls:      2,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			transport := &flakyTransport{failures: tc.failures, err: tc.err}
+			cfg := &config.Config{Output: config.Output{Elasticsearch: config.Elasticsearch{
+				Hosts:        []string{"localhost:9200"},
+				ServiceToken: "test-token",
+				MaxRetries:   tc.maxRetries,
+				Backoff:      config.ESBackoff{Init: time.Millisecond, Max: 5 * time.Millisecond},
+			}}}
+			client, err := NewClient(context.Background(), cfg, tc.longPoll, func(escfg *elasticsearch.Config) {
+				escfg.Transport = transport
+			})
+			require.NoError(t, err)
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+			require.NoError(t, err)
+			res, err := client.Perform(req)
+			require.NoError(t, err)
+			res.Body.Close()
+			require.Equal(t, tc.status, res.StatusCode)
+			require.Equal(t, tc.calls, transport.calls.Load())
+		})
+	}
+}
@@ -7,6 +7,7 @@ package es
 import (
 	"encoding/json"
 	"errors"
+	"net/http"
 	"strconv"
 	"strings"
 )
@@ -16,10 +17,24 @@ const (
 	timeoutErrorType         = "timeout_exception"
 	indexNotFoundErrorType   = "index_not_found_exception"
 	versionConflictErrorType = "version_conflict_engine_exception"
+
+	documentMissingErrorType     = "document_missing_exception"
+	resourceNotFoundErrorType    = "resource_not_found_exception"
+	securityErrorType            = "security_exception"
+	rejectedExecutionErrorType   = "es_rejected_execution_exception"
+	circuitBreakingErrorType     = "circuit_breaking_exception"
+	unavailableShardsErrorType   = "unavailable_shards_exception"
+	noShardAvailableErrorType    = "no_shard_available_action_exception"
+	masterNotDiscoveredErrorType = "master_not_discovered_exception"
+	clusterEventTimeoutErrorType = "process_cluster_event_timeout_exception"
+	receiveTimeoutErrorType      = "receive_timeout_transport_exception"
 )
 
 // TODO: Why do we have both ErrElastic and ErrorT?  Very strange.
 
+// ErrElastic is an error returned by Elasticsearch.
+// The type and reason of the error, and of its cause, are preserved as they are returned.
+// It unwraps to the sentinel error of its category, so it can be matched with errors.Is.
 type ErrElastic struct {
 	Status int
 	Type   string
@@ -30,16 +45,42 @@ type ErrElastic struct {
 	}
 }
 
+// Unwrap returns the category of the error, or nil if it is not known.
+// The type of the error takes precedence over the type of its cause and then over the status code.
 func (e *ErrElastic) Unwrap() error {
-	if e.Type == indexNotFoundErrorType {
-		return ErrIndexNotFound
-	} else if e.Type == timeoutErrorType {
-		return ErrTimeout
+	if err, ok := errorCategories[e.Type]; ok {
+		return err
 	}
+	if err, ok := errorCategories[e.Cause.Type]; ok {
+		return err
+	}
+	return statusCategory(e.Status)
+}
 
+func statusCategory(status int) error {
+	switch status {
+	case http.StatusNotFound:
+		return ErrElasticNotFound
+	case http.StatusConflict:
+		return ErrElasticVersionConflict
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrSecurityException
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return ErrTimeout
+	case http.StatusTooManyRequests:
+		return ErrTooManyRequests
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return ErrUnavailable
+	}
 	return nil
 }
 
+// IsRetryable returns true if err is an Elasticsearch or a proxy error that is expected to be transient,
+// the same request may succeed when it is retried later.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrTooManyRequests) || errors.Is(err, ErrUnavailable) || errors.Is(err, ErrProxy)
+}
+
 func (e ErrElastic) Error() string {
 	// Improved error string to account on missing empty e.Type and e.Reason
 	// Otherwise were getting: "elastic fail 404::"
@@ -74,11 +115,37 @@ var (
 	ErrIndexNotFound          = errors.New("index not found")
 	ErrTimeout                = errors.New("timeout")
 	ErrNotFound               = errors.New("not found")
+	ErrSecurityException      = errors.New("elastic security exception")
+	ErrTooManyRequests        = errors.New("elastic too many requests")
+	ErrUnavailable            = errors.New("elastic unavailable")
 
-	knownErrorTypes = [3]string{
+	knownErrorTypes = []string{
 		timeoutErrorType,
 		indexNotFoundErrorType,
 		versionConflictErrorType,
+		securityErrorType,
+		rejectedExecutionErrorType,
+		circuitBreakingErrorType,
+		unavailableShardsErrorType,
+		noShardAvailableErrorType,
+		masterNotDiscoveredErrorType,
+	}
+
+	// errorCategories maps the error types to the category returned by ErrElastic.Unwrap.
+	errorCategories = map[string]error{
+		indexNotFoundErrorType:       ErrIndexNotFound,
+		documentMissingErrorType:     ErrElasticNotFound,
+		resourceNotFoundErrorType:    ErrElasticNotFound,
+		versionConflictErrorType:     ErrElasticVersionConflict,
+		securityErrorType:            ErrSecurityException,
+		timeoutErrorType:             ErrTimeout,
+		clusterEventTimeoutErrorType: ErrTimeout,
+		receiveTimeoutErrorType:      ErrTimeout,
+		rejectedExecutionErrorType:   ErrTooManyRequests,
+		circuitBreakingErrorType:     ErrTooManyRequests,
+		unavailableShardsErrorType:   ErrUnavailable,
+		noShardAvailableErrorType:    ErrUnavailable,
+		masterNotDiscoveredErrorType: ErrUnavailable,
 	}
 
 	// helps with native translation of native java exceptions
@@ -89,13 +156,23 @@ var (
 		"IndexNotFoundException":              indexNotFoundErrorType,
 		ErrTimeout.Error():                    timeoutErrorType,
 		"ElasticsearchTimeoutException":       timeoutErrorType,
-		"ProcessClusterEventTimeoutException": timeoutErrorType,
-		"ReceiveTimeoutTransportException":    timeoutErrorType,
+		"ProcessClusterEventTimeoutException": clusterEventTimeoutErrorType,
+		"ReceiveTimeoutTransportException":    receiveTimeoutErrorType,
 		ErrElasticVersionConflict.Error():     versionConflictErrorType,
 		"VersionConflictEngineException":      versionConflictErrorType,
+		"ElasticsearchSecurityException":      securityErrorType,
+		"EsRejectedExecutionException":        rejectedExecutionErrorType,
+		"CircuitBreakingException":            circuitBreakingErrorType,
+		"UnavailableShardsException":          unavailableShardsErrorType,
+		"NoShardAvailableActionException":     noShardAvailableErrorType,
+		"MasterNotDiscoveredException":        masterNotDiscoveredErrorType,
 	}
 )
 
+// TranslateError returns the error of an Elasticsearch response with the status and the error attribute rawError,
+// or nil if the status is a success.
+// A detailed error object is decoded, otherwise the type is guessed from the error text that is kept as the reason.
+// The returned error is always an *ErrElastic.
 func TranslateError(status int, rawError json.RawMessage) error {
 	if status == 200 || status == 201 {
 		return nil
@@ -115,16 +192,10 @@ func TranslateError(status int, rawError json.RawMessage) error {
 	}
 
 	reason := string(rawError)
-	eType := errType(reason)
-	switch eType {
-	case versionConflictErrorType:
-		return ErrElasticVersionConflict
-	default:
-		return &ErrElastic{
-			Status: status,
-			Type:   eType,
-			Reason: reason,
-		}
+	return &ErrElastic{
+		Status: status,
+		Type:   errType(reason),
+		Reason: reason,
 	}
 }
 
@@ -151,24 +222,16 @@ func translateDetailedError(status int, e *ErrorT) error {
 		}
 	}
 
-	var err error
-	switch e.Type {
-	case versionConflictErrorType:
-		err = ErrElasticVersionConflict
-	default:
-		err = &ErrElastic{
-			Status: status,
-			Type:   e.Type,
-			Reason: e.Reason,
-			Cause: struct {
-				Type   string
-				Reason string
-			}{
-				Type:   e.Cause.Type,
-				Reason: e.Cause.Reason,
-			},
-		}
+	return &ErrElastic{
+		Status: status,
+		Type:   e.Type,
+		Reason: e.Reason,
+		Cause: struct {
+			Type   string
+			Reason string
+		}{
+			Type:   e.Cause.Type,
+			Reason: e.Cause.Reason,
+		},
 	}
-
-	return err
 }
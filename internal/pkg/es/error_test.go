@@ -8,6 +8,10 @@ package es
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -198,8 +202,7 @@ func TestErrorTranslation(t *testing.T) {
 			require.True(t, err != nil, "error is expected but not returned")
 
 			if tc.ExpectedType == versionConflictErrorType {
-				require.Equal(t, ErrElasticVersionConflict, err)
-				return
+				require.ErrorIs(t, err, ErrElasticVersionConflict)
 			}
 			elasticErr, ok := err.(*ErrElastic)
 			require.True(t, ok, "elastic error is required")
@@ -209,6 +212,81 @@ func TestErrorTranslation(t *testing.T) {
 	}
 }
 
+func TestErrorTranslationFixtures(t *testing.T) {
+	testCases := []struct {
+		fixture   string
+		category  error
+		retryable bool
+		errType   string
+		reason    string
+		cause     string
+	}{
+		{"document_missing", ErrElasticNotFound, false, documentMissingErrorType, "[agent-1]: document missing", ""},
+		{"index_not_found", ErrIndexNotFound, false, indexNotFoundErrorType, "no such index [.fleet-actions]", ""},
+		{"version_conflict", ErrElasticVersionConflict, false, versionConflictErrorType, "[action-1:agent-1]: version conflict, document already exists (current version [1])", ""},
+		{"security_exception", ErrSecurityException, false, securityErrorType, "action [indices:data/write/bulk[s]] is unauthorized for service account [elastic/fleet-server] on indices [.fleet-agents-7], this action is granted by the index privileges [create_doc,create,delete,index,write,all]", ""},
+		{"timeout", ErrTimeout, true, timeoutErrorType, "Wait for global checkpoint advance timed out [30s]", ""},
+		{"too_many_requests", ErrTooManyRequests, true, rejectedExecutionErrorType, "rejected execution of coordinating operation [coordinating_and_primary_bytes=0, replica_bytes=0, all_bytes=0, coordinating_operation_bytes=52428800, max_coordinating_and_primary_bytes=53687091]", ""},
+		{"circuit_breaking", ErrTooManyRequests, true, circuitBreakingErrorType, "[parent] Data too large, data for [<http_request>] would be [1019162962/971.9mb], which is larger than the limit of [1014594355/967.5mb], real usage: [1019162624/971.9mb], new bytes reserved: [338/338b]", ""},
+		{"unavailable_shards", ErrUnavailable, true, unavailableShardsErrorType, "[.fleet-agents-7][0] primary shard is not active Timeout: [1m], request: [BulkShardRequest [[.fleet-agents-7][0]] containing [index {[.fleet-agents-7][agent-1]}]]", ""},
+		{"search_phase_no_shard", ErrUnavailable, true, "search_phase_execution_exception", "all shards failed", noShardAvailableErrorType},
+		{"master_not_discovered", ErrUnavailable, true, masterNotDiscoveredErrorType, "", ""},
+		{"parsing_exception", nil, false, "parsing_exception", "unknown query [match_al]", "named_object_not_found_exception"},
+	}
+
+	categories := []error{ErrElasticNotFound, ErrIndexNotFound, ErrElasticVersionConflict, ErrSecurityException, ErrTimeout, ErrTooManyRequests, ErrUnavailable}
+
+	for _, tc := range testCases {
+		t.Run(tc.fixture, func(t *testing.T) {
+			p, err := os.ReadFile(filepath.Join("testdata", "errors", tc.fixture+".json"))
+			require.NoError(t, err)
+			var res struct {
+				Status int             `json:"status"`
+				Error  json.RawMessage `json:"error"`
+			}
+			require.NoError(t, json.Unmarshal(p, &res))
+
+			err = fmt.Errorf("wrapped: %w", TranslateError(res.Status, res.Error))
+			var esErr *ErrElastic
+			require.ErrorAs(t, err, &esErr)
+			require.Equal(t, res.Status, esErr.Status)
+			require.Equal(t, tc.errType, esErr.Type)
+			require.Equal(t, tc.reason, esErr.Reason)
+			require.Equal(t, tc.cause, esErr.Cause.Type)
+
+			for _, c := range categories {
+				require.Equal(t, c == tc.category, errors.Is(err, c), "category %v", c)
+			}
+			require.Equal(t, tc.retryable, IsRetryable(err))
+		})
+	}
+}
+
+func TestErrElasticStatusCategory(t *testing.T) {
+	testCases := []struct {
+		status   int
+		category error
+	}{
+		{400, nil},
+		{401, ErrSecurityException},
+		{403, ErrSecurityException},
+		{404, ErrElasticNotFound},
+		{408, ErrTimeout},
+		{409, ErrElasticVersionConflict},
+		{429, ErrTooManyRequests},
+		{500, nil},
+		{502, ErrUnavailable},
+		{503, ErrUnavailable},
+		{504, ErrTimeout},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.status), func(t *testing.T) {
+			err := TranslateError(tc.status, nil)
+			require.Equal(t, tc.category, errors.Unwrap(err))
+		})
+	}
+}
+
 func errorTinBytes(e ErrorT) []byte {
 	b, _ := json.Marshal(e)
 	return b
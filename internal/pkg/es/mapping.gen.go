@@ -0,0 +1,363 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Code generated by schemagen. DO NOT EDIT.
+
+package es
+
+// MappingAction is the mapping of the .fleet-actions index.
+const MappingAction = `{
+  "properties": {
+    "@timestamp": {
+      "type": "date"
+    },
+    "action_id": {
+      "type": "keyword"
+    },
+    "agents": {
+      "type": "keyword"
+    },
+    "data": {
+      "enabled": false,
+      "type": "object"
+    },
+    "expiration": {
+      "type": "date"
+    },
+    "input_type": {
+      "type": "keyword"
+    },
+    "minimum_execution_duration": {
+      "type": "long"
+    },
+    "namespaces": {
+      "type": "keyword"
+    },
+    "retry_of": {
+      "type": "keyword"
+    },
+    "rollout_duration_seconds": {
+      "type": "long"
+    },
+    "signed": {
+      "properties": {
+        "data": {
+          "type": "keyword"
+        },
+        "signature": {
+          "type": "keyword"
+        }
+      }
+    },
+    "start_time": {
+      "type": "date"
+    },
+    "target": {
+      "properties": {
+        "policy_id": {
+          "type": "keyword"
+        },
+        "tags": {
+          "type": "keyword"
+        },
+        "version": {
+          "type": "keyword"
+        }
+      }
+    },
+    "timeout": {
+      "type": "long"
+    },
+    "traceparent": {
+      "type": "keyword"
+    },
+    "type": {
+      "type": "keyword"
+    },
+    "upgrade_attempt": {
+      "type": "long"
+    },
+    "user_id": {
+      "type": "keyword"
+    }
+  }
+}`
+
+// MappingAgent is the mapping of the .fleet-agents index.
+const MappingAgent = `{
+  "properties": {
+    "access_api_key_id": {
+      "type": "keyword"
+    },
+    "action_seq_no": {
+      "type": "long"
+    },
+    "active": {
+      "type": "boolean"
+    },
+    "agent": {
+      "properties": {
+        "id": {
+          "type": "keyword"
+        },
+        "version": {
+          "type": "keyword"
+        }
+      }
+    },
+    "api_keys_invalidated_at": {
+      "type": "date"
+    },
+    "api_keys_invalidation_pending": {
+      "type": "boolean"
+    },
+    "audit_unenrolled_reason": {
+      "type": "keyword"
+    },
+    "audit_unenrolled_time": {
+      "type": "date"
+    },
+    "components": {
+      "properties": {
+        "id": {
+          "type": "keyword"
+        },
+        "message": {
+          "type": "keyword"
+        },
+        "status": {
+          "type": "keyword"
+        },
+        "type": {
+          "type": "keyword"
+        },
+        "units": {
+          "properties": {
+            "id": {
+              "type": "keyword"
+            },
+            "message": {
+              "type": "keyword"
+            },
+            "status": {
+              "type": "keyword"
+            },
+            "type": {
+              "type": "keyword"
+            }
+          }
+        }
+      }
+    },
+    "default_api_key": {
+      "type": "keyword"
+    },
+    "default_api_key_history": {
+      "properties": {
+        "id": {
+          "type": "keyword"
+        },
+        "output": {
+          "type": "keyword"
+        },
+        "retired_at": {
+          "type": "date"
+        }
+      }
+    },
+    "default_api_key_id": {
+      "type": "keyword"
+    },
+    "enroll_idempotency": {
+      "properties": {
+        "expires_at": {
+          "type": "date"
+        },
+        "key": {
+          "type": "keyword"
+        },
+        "request_hash": {
+          "type": "keyword"
+        }
+      }
+    },
+    "enrolled_at": {
+      "type": "date"
+    },
+    "enrollment_id": {
+      "type": "keyword"
+    },
+    "last_action_error": {
+      "properties": {
+        "@timestamp": {
+          "type": "date"
+        },
+        "action_id": {
+          "type": "keyword"
+        },
+        "action_type": {
+          "type": "keyword"
+        },
+        "code": {
+          "type": "keyword"
+        },
+        "message": {
+          "type": "keyword"
+        }
+      }
+    },
+    "last_checkin": {
+      "type": "date"
+    },
+    "last_checkin_message": {
+      "type": "keyword"
+    },
+    "last_checkin_status": {
+      "type": "keyword"
+    },
+    "last_connection": {
+      "properties": {
+        "protocol": {
+          "type": "keyword"
+        },
+        "remote_address": {
+          "type": "ip"
+        },
+        "tls_cipher": {
+          "type": "keyword"
+        },
+        "tls_version": {
+          "type": "keyword"
+        }
+      }
+    },
+    "last_updated": {
+      "type": "date"
+    },
+    "lifecycle_state": {
+      "type": "keyword"
+    },
+    "local_metadata": {
+      "type": "flattened"
+    },
+    "namespaces": {
+      "type": "keyword"
+    },
+    "oldest_pending_action_age": {
+      "type": "long"
+    },
+    "outputs": {
+      "dynamic": true,
+      "type": "object"
+    },
+    "packages": {
+      "type": "keyword"
+    },
+    "pending_actions_count": {
+      "type": "long"
+    },
+    "policy_coordinator_idx": {
+      "type": "long"
+    },
+    "policy_id": {
+      "type": "keyword"
+    },
+    "policy_output_permissions_hash": {
+      "type": "keyword"
+    },
+    "policy_revision_idx": {
+      "type": "long"
+    },
+    "replace_token": {
+      "type": "keyword"
+    },
+    "shared_id": {
+      "type": "keyword"
+    },
+    "status_flapping": {
+      "type": "boolean"
+    },
+    "tags": {
+      "type": "keyword"
+    },
+    "type": {
+      "type": "keyword"
+    },
+    "unenrolled_at": {
+      "type": "date"
+    },
+    "unenrolled_reason": {
+      "type": "keyword"
+    },
+    "unenrollment_started_at": {
+      "type": "date"
+    },
+    "unhealthy_reason": {
+      "type": "keyword"
+    },
+    "updated_at": {
+      "type": "date"
+    },
+    "upgrade_attempts": {
+      "type": "keyword"
+    },
+    "upgrade_details": {
+      "properties": {
+        "action_id": {
+          "type": "keyword"
+        },
+        "metadata": {
+          "enabled": false,
+          "type": "object"
+        },
+        "state": {
+          "type": "keyword"
+        },
+        "target_version": {
+          "type": "keyword"
+        }
+      }
+    },
+    "upgrade_started_at": {
+      "type": "date"
+    },
+    "upgrade_status": {
+      "type": "keyword"
+    },
+    "upgrade_target_version": {
+      "type": "keyword"
+    },
+    "upgraded_at": {
+      "type": "date"
+    },
+    "user_provided_metadata": {
+      "enabled": false,
+      "type": "object"
+    }
+  }
+}`
+
+// MappingAgentTombstone is the mapping of the logs-fleet_server.agent_tombstones-default index.
+const MappingAgentTombstone = `{
+  "properties": {
+    "@timestamp": {
+      "type": "date"
+    },
+    "agent_id": {
+      "type": "keyword"
+    },
+    "enrolled_at": {
+      "type": "date"
+    },
+    "policy_id": {
+      "type": "keyword"
+    },
+    "unenrolled_at": {
+      "type": "date"
+    },
+    "unenrolled_reason": {
+      "type": "keyword"
+    }
+  }
+}`
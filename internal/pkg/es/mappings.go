@@ -0,0 +1,200 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// MappingConflict is a field of an index whose type in the cluster is not the type fleet-server expects.
+// The type of an existing field can not be updated, the index has to be reindexed.
+type MappingConflict struct {
+	Index    string
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (c MappingConflict) String() string {
+	return fmt.Sprintf("%s field %s is %s, expected %s", c.Index, c.Field, c.Actual, c.Expected)
+}
+
+// IncompatibleMappingsError lists the fields of the fleet indices whose types are not the expected types.
+type IncompatibleMappingsError struct {
+	Conflicts []MappingConflict
+}
+
+func (e *IncompatibleMappingsError) Error() string {
+	conflicts := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		conflicts[i] = c.String()
+	}
+	return "incompatible index mappings: " + strings.Join(conflicts, ", ")
+}
+
+// MappingDiff is the difference between the expected mapping of an index and its mapping in the cluster.
+// The fields of the cluster that are not expected are ignored.
+type MappingDiff struct {
+	// Missing is the mapping of the expected fields that are not in the cluster, as the body of the put mapping API.
+	// It is nil when no field is missing.
+	Missing json.RawMessage
+	// MissingFields are the paths of the missing fields, sorted.
+	MissingFields []string
+	// Conflicts are the fields with another type in the cluster, sorted by path. Their index is not set.
+	Conflicts []MappingConflict
+}
+
+// mappingField is a field of a mapping, or the mapping itself.
+type mappingField struct {
+	Type       string                     `json:"type"`
+	Enabled    *bool                      `json:"enabled"`
+	Properties map[string]json.RawMessage `json:"properties"`
+}
+
+// fieldType returns the type of the field, the objects are mapped without a type.
+func (f mappingField) fieldType() string {
+	if f.Type == "" {
+		return "object"
+	}
+	return f.Type
+}
+
+// DiffMappings returns the difference between the expected mapping and the actual mapping of an index.
+// The objects are compared field by field, the other fields by their type only. The expected objects that are not
+// indexed accept any type, fleet-server only stores them.
+func DiffMappings(expected, actual []byte) (MappingDiff, error) {
+	var exp, act mappingField
+	if err := json.Unmarshal(expected, &exp); err != nil {
+		return MappingDiff{}, fmt.Errorf("failed to parse expected mapping: %w", err)
+	}
+	if len(bytes.TrimSpace(actual)) > 0 {
+		if err := json.Unmarshal(actual, &act); err != nil {
+			return MappingDiff{}, fmt.Errorf("failed to parse actual mapping: %w", err)
+		}
+	}
+
+	var diff MappingDiff
+	missing, err := diffProperties(&diff, "", exp.Properties, act.Properties)
+	if err != nil {
+		return MappingDiff{}, err
+	}
+	if len(missing) > 0 {
+		if diff.Missing, err = json.Marshal(map[string]interface{}{"properties": missing}); err != nil {
+			return MappingDiff{}, err
+		}
+	}
+	slices.Sort(diff.MissingFields)
+	slices.SortFunc(diff.Conflicts, func(a, b MappingConflict) int { return strings.Compare(a.Field, b.Field) })
+	return diff, nil
+}
+
+// diffProperties adds the missing fields and the conflicts of the properties under path to diff, and returns the
+// properties of the missing fields.
+func diffProperties(diff *MappingDiff, path string, expected, actual map[string]json.RawMessage) (map[string]interface{}, error) {
+	missing := make(map[string]interface{})
+	for name, expRaw := range expected {
+		field := name
+		if path != "" {
+			field = path + "." + name
+		}
+		actRaw, ok := actual[name]
+		if !ok {
+			missing[name] = expRaw
+			diff.MissingFields = append(diff.MissingFields, field)
+			continue
+		}
+
+		var exp, act mappingField
+		if err := json.Unmarshal(expRaw, &exp); err != nil {
+			return nil, fmt.Errorf("failed to parse expected mapping of %s: %w", field, err)
+		}
+		if err := json.Unmarshal(actRaw, &act); err != nil {
+			return nil, fmt.Errorf("failed to parse actual mapping of %s: %w", field, err)
+		}
+		if exp.Enabled != nil && !*exp.Enabled {
+			continue
+		}
+		if exp.fieldType() != act.fieldType() {
+			diff.Conflicts = append(diff.Conflicts, MappingConflict{Field: field, Expected: exp.fieldType(), Actual: act.fieldType()})
+			continue
+		}
+		if len(exp.Properties) == 0 {
+			continue
+		}
+		sub, err := diffProperties(diff, field, exp.Properties, act.Properties)
+		if err != nil {
+			return nil, err
+		}
+		if len(sub) > 0 {
+			missing[name] = map[string]interface{}{"properties": sub}
+		}
+	}
+	return missing, nil
+}
+
+// FetchMappings returns the mapping of each index of name, an index, alias or data stream.
+// It returns nil if name does not exist.
+func FetchMappings(ctx context.Context, esCli *elasticsearch.Client, name string) (map[string]json.RawMessage, error) {
+	res, err := esCli.Indices.GetMapping(
+		esCli.Indices.GetMapping.WithContext(ctx),
+		esCli.Indices.GetMapping.WithIndex(name),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	var sres map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&sres); err != nil {
+		return nil, err
+	}
+	if err := TranslateError(res.StatusCode, sres["error"]); err != nil {
+		return nil, err
+	}
+
+	mappings := make(map[string]json.RawMessage, len(sres))
+	for index, raw := range sres {
+		var m struct {
+			Mappings json.RawMessage `json:"mappings"`
+		}
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, fmt.Errorf("failed to parse the mapping of %s: %w", index, err)
+		}
+		mappings[index] = m.Mappings
+	}
+	return mappings, nil
+}
+
+// PutMapping adds the fields of body to the mapping of name, an index, alias or data stream.
+func PutMapping(ctx context.Context, esCli *elasticsearch.Client, name string, body []byte) error {
+	res, err := esCli.Indices.PutMapping(
+		[]string{name},
+		bytes.NewReader(body),
+		esCli.Indices.PutMapping.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var sres struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&sres); err != nil {
+		return err
+	}
+	return TranslateError(res.StatusCode, sres.Error)
+}
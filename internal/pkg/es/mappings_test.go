@@ -0,0 +1,194 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+// readMappings returns the mappings of the get mapping response in testdata/mappings/name.
+func readMappings(t *testing.T, name string) map[string]json.RawMessage {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", "mappings", name))
+	require.NoError(t, err)
+	var res map[string]struct {
+		Mappings json.RawMessage `json:"mappings"`
+	}
+	require.NoError(t, json.Unmarshal(body, &res))
+	mappings := make(map[string]json.RawMessage, len(res))
+	for index, m := range res {
+		mappings[index] = m.Mappings
+	}
+	return mappings
+}
+
+// putMapping merges the properties of the put mapping body into mapping, as elasticsearch does.
+func putMapping(t *testing.T, mapping, body []byte) []byte {
+	t.Helper()
+	var merge func(dst, src map[string]interface{})
+	merge = func(dst, src map[string]interface{}) {
+		for k, v := range src {
+			sub, ok := v.(map[string]interface{})
+			if cur, exists := dst[k].(map[string]interface{}); ok && exists {
+				merge(cur, sub)
+				continue
+			}
+			dst[k] = v
+		}
+	}
+	var dst, src map[string]interface{}
+	require.NoError(t, json.Unmarshal(mapping, &dst))
+	require.NoError(t, json.Unmarshal(body, &src))
+	merge(dst, src)
+	merged, err := json.Marshal(dst)
+	require.NoError(t, err)
+	return merged
+}
+
+func TestDiffMappings(t *testing.T) {
+	tests := []struct {
+		name      string
+		expected  string
+		actual    json.RawMessage
+		missing   []string
+		conflicts []MappingConflict
+	}{{
+		name:     "up to date agents",
+		expected: MappingAgent,
+		actual:   json.RawMessage(MappingAgent),
+	}, {
+		name:     "older agents",
+		expected: MappingAgent,
+		actual:   readMappings(t, "agents_older.json")[".fleet-agents-7"],
+		missing: []string{
+			"agent.version", "api_keys_invalidated_at", "api_keys_invalidation_pending", "audit_unenrolled_reason",
+			"audit_unenrolled_time", "components.units", "default_api_key", "default_api_key_history", "default_api_key_id",
+			"enroll_idempotency", "enrollment_id", "last_action_error", "last_checkin", "last_checkin_message",
+			"last_checkin_status", "last_connection", "last_updated", "lifecycle_state", "namespaces", "oldest_pending_action_age", "packages",
+			"pending_actions_count", "policy_coordinator_idx", "policy_output_permissions_hash", "replace_token", "shared_id", "status_flapping",
+			"tags", "type", "unenrolled_at", "unenrolled_reason", "unenrollment_started_at", "unhealthy_reason",
+			"updated_at", "upgrade_attempts", "upgrade_details", "upgrade_started_at", "upgrade_status", "upgrade_target_version", "upgraded_at",
+		},
+	}, {
+		name:     "incompatible actions",
+		expected: MappingAction,
+		actual:   readMappings(t, "actions_incompatible.json")[".fleet-actions-7"],
+		missing: []string{
+			"input_type", "minimum_execution_duration", "namespaces", "retry_of", "rollout_duration_seconds",
+			"start_time", "target.tags", "target.version", "timeout", "traceparent", "upgrade_attempt", "user_id",
+		},
+		conflicts: []MappingConflict{
+			{Field: "agents", Expected: "keyword", Actual: "text"},
+			{Field: "signed", Expected: "object", Actual: "keyword"},
+			{Field: "target.policy_id", Expected: "keyword", Actual: "long"},
+		},
+	}, {
+		name:     "empty index",
+		expected: MappingAgentTombstone,
+		actual:   json.RawMessage(`{}`),
+		missing:  []string{"@timestamp", "agent_id", "enrolled_at", "policy_id", "unenrolled_at", "unenrolled_reason"},
+	}, {
+		name:     "object mapped as nested",
+		expected: `{"properties":{"agent":{"properties":{"id":{"type":"keyword"}}}}}`,
+		actual:   json.RawMessage(`{"properties":{"agent":{"type":"nested","properties":{"id":{"type":"keyword"}}}}}`),
+		conflicts: []MappingConflict{
+			{Field: "agent", Expected: "object", Actual: "nested"},
+		},
+	}, {
+		name:     "disabled object",
+		expected: `{"properties":{"data":{"type":"object","enabled":false}}}`,
+		actual:   json.RawMessage(`{"properties":{"data":{"type":"object","enabled":false}}}`),
+	}, {
+		name:     "disabled object indexed as flattened",
+		expected: `{"properties":{"data":{"type":"object","enabled":false}}}`,
+		actual:   json.RawMessage(`{"properties":{"data":{"type":"flattened"}}}`),
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			diff, err := DiffMappings([]byte(tc.expected), tc.actual)
+			require.NoError(t, err)
+			require.Equal(t, tc.missing, diff.MissingFields)
+			require.Equal(t, tc.conflicts, diff.Conflicts)
+			if len(tc.missing) == 0 {
+				require.Nil(t, diff.Missing)
+				return
+			}
+
+			// the missing fields added to the mapping leave only the conflicts
+			updated, err := DiffMappings([]byte(tc.expected), putMapping(t, tc.actual, diff.Missing))
+			require.NoError(t, err)
+			require.Empty(t, updated.MissingFields)
+			require.Equal(t, tc.conflicts, updated.Conflicts)
+		})
+	}
+}
+
+func TestDiffMappingsInvalid(t *testing.T) {
+	_, err := DiffMappings([]byte(MappingAgent), []byte(`{"properties":{"agent":"keyword"}}`))
+	require.ErrorContains(t, err, "failed to parse actual mapping of agent")
+}
+
+func TestFetchAndPutMappings(t *testing.T) {
+	body, err := os.ReadFile(filepath.Join("testdata", "mappings", "tombstones_rollover.json"))
+	require.NoError(t, err)
+	var put []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/logs-fleet_server.agent_tombstones-default/_mapping":
+			_, _ = w.Write(body)
+		case r.Method == http.MethodGet:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"type":"index_not_found_exception","reason":"no such index"},"status":404}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/.ds-logs-fleet_server.agent_tombstones-default-2025.01.01-000001/_mapping":
+			put, _ = io.ReadAll(r.Body)
+			_, _ = w.Write([]byte(`{"acknowledged":true}`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"type":"security_exception","reason":"action [indices:admin/mapping/put] is unauthorized"},"status":403}`))
+		}
+	}))
+	defer server.Close()
+	cli, err := NewClient(context.Background(), &config.Config{
+		Output: config.Output{Elasticsearch: config.Elasticsearch{Hosts: []string{server.URL}}},
+	}, false)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	mappings, err := FetchMappings(ctx, cli, "logs-fleet_server.agent_tombstones-default")
+	require.NoError(t, err)
+	require.Len(t, mappings, 2)
+	first := ".ds-logs-fleet_server.agent_tombstones-default-2025.01.01-000001"
+	diff, err := DiffMappings([]byte(MappingAgentTombstone), mappings[first])
+	require.NoError(t, err)
+	require.Equal(t, []string{"enrolled_at", "unenrolled_reason"}, diff.MissingFields)
+	require.NoError(t, PutMapping(ctx, cli, first, diff.Missing))
+	require.JSONEq(t, `{"properties":{"enrolled_at":{"type":"date"},"unenrolled_reason":{"type":"keyword"}}}`, string(put))
+
+	diff, err = DiffMappings([]byte(MappingAgentTombstone), mappings[".ds-logs-fleet_server.agent_tombstones-default-2025.02.01-000002"])
+	require.NoError(t, err)
+	require.Nil(t, diff.Missing)
+
+	mappings, err = FetchMappings(ctx, cli, ".fleet-missing")
+	require.NoError(t, err)
+	require.Nil(t, mappings)
+
+	err = PutMapping(ctx, cli, ".fleet-agents-7", diff.Missing)
+	require.ErrorIs(t, err, ErrSecurityException)
+}
@@ -0,0 +1,124 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// IndexPrivileges are the privileges required on a set of indices.
+type IndexPrivileges struct {
+	Names      []string `json:"names"`
+	Privileges []string `json:"privileges"`
+}
+
+// RequiredClusterPrivileges are the cluster privileges fleet-server needs, it monitors the cluster
+// and creates the API keys of the agents.
+var RequiredClusterPrivileges = []string{"monitor", "manage_own_api_key"}
+
+// RequiredIndexPrivileges are the index privileges fleet-server needs on the fleet system indices.
+var RequiredIndexPrivileges = []IndexPrivileges{{
+	Names:      []string{".fleet-*"},
+	Privileges: []string{"read", "write", "monitor", "create_index", "auto_configure", "maintenance"},
+}}
+
+// AdminIndexPrivileges are the index privileges an API key needs to call the endpoints that manage agents, it reads
+// and writes the agents, actions and policies in the fleet system indices.
+var AdminIndexPrivileges = []IndexPrivileges{{
+	Names:      []string{".fleet-*"},
+	Privileges: []string{"read", "write"},
+}}
+
+// MissingPrivilegesError lists the required privileges the elasticsearch credentials do not have.
+type MissingPrivilegesError struct {
+	Privileges []string
+}
+
+func (e *MissingPrivilegesError) Error() string {
+	return "elasticsearch credentials are missing the privileges " + strings.Join(e.Privileges, ", ")
+}
+
+type hasPrivilegesRequest struct {
+	Cluster []string          `json:"cluster,omitempty"`
+	Index   []IndexPrivileges `json:"index"`
+}
+
+type hasPrivilegesResponse struct {
+	HasAllRequested bool                       `json:"has_all_requested"`
+	Cluster         map[string]bool            `json:"cluster"`
+	Index           map[string]map[string]bool `json:"index"`
+	Error           json.RawMessage            `json:"error,omitempty"`
+}
+
+// CheckPrivileges calls the has_privileges API for the privileges fleet-server requires
+// and returns the missing ones, as "cluster:<privilege>" or "<index>:<privilege>".
+func CheckPrivileges(ctx context.Context, esCli *elasticsearch.Client) ([]string, error) {
+	return hasPrivileges(ctx, esCli, "", RequiredClusterPrivileges, RequiredIndexPrivileges)
+}
+
+// CheckAdminPrivileges calls the has_privileges API as the caller authenticated by the authorization header and
+// returns the AdminIndexPrivileges it is missing, as "<index>:<privilege>".
+func CheckAdminPrivileges(ctx context.Context, esCli *elasticsearch.Client, authorization string) ([]string, error) {
+	return hasPrivileges(ctx, esCli, authorization, nil, AdminIndexPrivileges)
+}
+
+// hasPrivileges returns the privileges among cluster and index that are missing. The request is made with the
+// credentials of the client unless an authorization header is passed.
+func hasPrivileges(ctx context.Context, esCli *elasticsearch.Client, authorization string, cluster []string, index []IndexPrivileges) ([]string, error) {
+	body, err := json.Marshal(hasPrivilegesRequest{
+		Cluster: cluster,
+		Index:   index,
+	})
+	if err != nil {
+		return nil, err
+	}
+	opts := []func(*esapi.SecurityHasPrivilegesRequest){esCli.Security.HasPrivileges.WithContext(ctx)}
+	if authorization != "" {
+		opts = append(opts, esCli.Security.HasPrivileges.WithHeader(map[string]string{"Authorization": authorization}))
+	}
+	res, err := esCli.Security.HasPrivileges(bytes.NewReader(body), opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var sres hasPrivilegesResponse
+	if err := json.NewDecoder(res.Body).Decode(&sres); err != nil {
+		return nil, err
+	}
+	if err := TranslateError(res.StatusCode, sres.Error); err != nil {
+		return nil, err
+	}
+	if sres.HasAllRequested {
+		return nil, nil
+	}
+
+	var missing []string
+	for _, priv := range cluster {
+		if !sres.Cluster[priv] {
+			missing = append(missing, "cluster:"+priv)
+		}
+	}
+	for _, idx := range index {
+		for _, name := range idx.Names {
+			for _, priv := range idx.Privileges {
+				if !sres.Index[name][priv] {
+					missing = append(missing, name+":"+priv)
+				}
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil, errors.New("has_privileges reported missing privileges that were not requested")
+	}
+	return missing, nil
+}
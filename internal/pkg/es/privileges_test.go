@@ -0,0 +1,112 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+func TestCheckPrivileges(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		missing []string
+		err     error
+	}{{
+		name:   "all privileges",
+		status: http.StatusOK,
+		body:   `{"has_all_requested":true}`,
+	}, {
+		name:    "missing privileges",
+		status:  http.StatusOK,
+		body:    `{"has_all_requested":false,"cluster":{"monitor":true,"manage_own_api_key":false},"index":{".fleet-*":{"read":true,"write":false,"monitor":true,"create_index":true,"auto_configure":true,"maintenance":true}}}`,
+		missing: []string{"cluster:manage_own_api_key", ".fleet-*:write"},
+	}, {
+		name:   "unauthorized",
+		status: http.StatusUnauthorized,
+		body:   `{"error":{"type":"security_exception","reason":"unable to authenticate"}}`,
+		err:    ErrSecurityException,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/_security/user/_has_privileges", r.URL.Path)
+				var req hasPrivilegesRequest
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+				require.Equal(t, RequiredClusterPrivileges, req.Cluster)
+				require.Equal(t, RequiredIndexPrivileges, req.Index)
+
+				w.Header().Set("X-Elastic-Product", "Elasticsearch")
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			cli, err := NewClient(context.Background(), &config.Config{
+				Output: config.Output{Elasticsearch: config.Elasticsearch{Hosts: []string{server.URL}}},
+			}, false)
+			require.NoError(t, err)
+
+			missing, err := CheckPrivileges(context.Background(), cli)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.missing, missing)
+		})
+	}
+}
+
+func TestCheckAdminPrivileges(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		missing []string
+	}{{
+		name: "all privileges",
+		body: `{"has_all_requested":true}`,
+	}, {
+		name:    "missing privileges",
+		body:    `{"has_all_requested":false,"cluster":{},"index":{".fleet-*":{"read":true,"write":false}}}`,
+		missing: []string{".fleet-*:write"},
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/_security/user/_has_privileges", r.URL.Path)
+				require.Equal(t, "ApiKey aWQ6a2V5", r.Header.Get("Authorization"))
+				var req hasPrivilegesRequest
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+				require.Empty(t, req.Cluster)
+				require.Equal(t, AdminIndexPrivileges, req.Index)
+
+				w.Header().Set("X-Elastic-Product", "Elasticsearch")
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			cli, err := NewClient(context.Background(), &config.Config{
+				Output: config.Output{Elasticsearch: config.Elasticsearch{Hosts: []string{server.URL}, ServiceToken: "token"}},
+			}, false)
+			require.NoError(t, err)
+
+			missing, err := CheckAdminPrivileges(context.Background(), cli, "ApiKey aWQ6a2V5")
+			require.NoError(t, err)
+			require.Equal(t, tc.missing, missing)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package es
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrProxy is the category of the requests to elasticsearch that failed in the proxy in front of it.
+var ErrProxy = errors.New("elastic proxy failure")
+
+// ProxyError is a request to elasticsearch that failed in the proxy: the proxy could not be reached, it refused the
+// tunnel to elasticsearch, or it answered with a bad gateway or a gateway timeout of its own.
+// It unwraps to ErrProxy and to the error of the connection.
+type ProxyError struct {
+	// Proxy is the host of the proxy.
+	Proxy string
+	// Status is the status of the response of the proxy, zero when the connection to the proxy failed.
+	Status int
+	Err    error
+}
+
+func (e *ProxyError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("proxy %s failed with status %d", e.Proxy, e.Status)
+	}
+	return fmt.Sprintf("proxy %s failed: %v", e.Proxy, e.Err)
+}
+
+func (e *ProxyError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrProxy}
+	}
+	return []error{ErrProxy, e.Err}
+}
+
+// ProxyHealth is the last known health of the proxy in front of elasticsearch, reported by the requests going
+// through it. It is safe for concurrent use.
+type ProxyHealth struct {
+	mx    sync.Mutex
+	state ProxyState
+}
+
+// ProxyState is the health of the proxy, it is zero until a request goes through a proxy.
+type ProxyState struct {
+	// Proxy is the URL of the proxy, without its password.
+	Proxy     string
+	Healthy   bool
+	Message   string
+	UpdatedAt time.Time
+}
+
+// State returns the health of the proxy, ok is false if no request went through a proxy.
+func (h *ProxyHealth) State() (state ProxyState, ok bool) {
+	if h == nil {
+		return ProxyState{}, false
+	}
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	return h.state, !h.state.UpdatedAt.IsZero()
+}
+
+// Report records the outcome of a request sent through proxyURL, err is nil if the proxy forwarded the request.
+func (h *ProxyHealth) Report(proxyURL *url.URL, err error) {
+	if h == nil {
+		return
+	}
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.state = ProxyState{Proxy: proxyURL.Redacted(), Healthy: err == nil, UpdatedAt: time.Now().UTC()}
+	if err != nil {
+		h.state.Message = err.Error()
+	}
+}
+
+// proxyTransport classifies the failures of the proxy of next as ProxyError, and reports them to health.
+// The proxy headers are sent with the requests to the plain http hosts, the proxy forwards them without a tunnel.
+type proxyTransport struct {
+	next   *http.Transport
+	health *ProxyHealth
+}
+
+func newProxyTransport(next *http.Transport) *proxyTransport {
+	// the transport returns the status of a refused tunnel as a plain error
+	next.OnProxyConnectResponse = func(_ context.Context, proxyURL *url.URL, _ *http.Request, resp *http.Response) error {
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+		return &ProxyError{Proxy: proxyURL.Host, Status: resp.StatusCode}
+	}
+	return &proxyTransport{next: next}
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	proxyURL, err := t.next.Proxy(req)
+	if err != nil || proxyURL == nil {
+		return t.next.RoundTrip(req)
+	}
+	if req.URL.Scheme == "http" && len(t.next.ProxyConnectHeader) > 0 {
+		req = req.Clone(req.Context())
+		for k, v := range t.next.ProxyConnectHeader {
+			req.Header[k] = v
+		}
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		var proxyErr *ProxyError
+		var opErr *net.OpError
+		switch {
+		case errors.As(err, &proxyErr):
+		case errors.As(err, &opErr) && opErr.Op == "proxyconnect":
+			err = &ProxyError{Proxy: proxyURL.Host, Err: err}
+		default:
+			// the proxy is not known to be at fault
+			return nil, err
+		}
+		t.health.Report(proxyURL, err)
+		return nil, err
+	}
+	// the gateway errors of elasticsearch itself come with the product header
+	if (res.StatusCode == http.StatusBadGateway || res.StatusCode == http.StatusGatewayTimeout) && res.Header.Get("X-Elastic-Product") == "" {
+		DrainAndClose(res.Body)
+		err := &ProxyError{Proxy: proxyURL.Host, Status: res.StatusCode}
+		t.health.Report(proxyURL, err)
+		return nil, err
+	}
+	t.health.Report(proxyURL, nil)
+	return res, nil
+}
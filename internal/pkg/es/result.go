@@ -27,13 +27,14 @@ type AckResponse struct {
 }
 
 type HitT struct {
-	ID      string                 `json:"_id"`
-	SeqNo   int64                  `json:"_seq_no"`
-	Version int64                  `json:"version"`
-	Index   string                 `json:"_index"`
-	Source  json.RawMessage        `json:"_source"`
-	Score   *float64               `json:"_score"`
-	Fields  map[string]interface{} `json:"fields"`
+	ID          string                 `json:"_id"`
+	SeqNo       int64                  `json:"_seq_no"`
+	PrimaryTerm int64                  `json:"_primary_term"`
+	Version     int64                  `json:"version"`
+	Index       string                 `json:"_index"`
+	Source      json.RawMessage        `json:"_source"`
+	Score       *float64               `json:"_score"`
+	Fields      map[string]interface{} `json:"fields"`
 }
 
 func (hit *HitT) Unmarshal(v interface{}) error {
@@ -42,7 +43,7 @@ func (hit *HitT) Unmarshal(v interface{}) error {
 		return err
 	}
 	if s, ok := v.(model.ESInitializer); ok {
-		s.ESInitialize(hit.ID, hit.SeqNo, hit.Version)
+		s.ESInitialize(hit.ID, hit.SeqNo, hit.PrimaryTerm, hit.Version)
 	}
 	return nil
 }
@@ -62,9 +63,14 @@ type Bucket struct {
 	Key          string           `json:"key"`
 	DocCount     int64            `json:"doc_count"`
 	Aggregations map[string]HitsT `json:"-"`
+	// SubAggregations are the bucket aggregations nested in the bucket, such as a terms aggregation of a terms aggregation.
+	SubAggregations map[string]Aggregation `json:"-"`
 }
 
-type _bucket Bucket
+type _bucket struct {
+	Key      json.RawMessage `json:"key"`
+	DocCount int64           `json:"doc_count"`
+}
 
 func (b *Bucket) UnmarshalJSON(data []byte) error {
 	b2 := _bucket{}
@@ -72,7 +78,7 @@ func (b *Bucket) UnmarshalJSON(data []byte) error {
 	if err != nil {
 		return err
 	}
-	var aggs map[string]interface{}
+	var aggs map[string]json.RawMessage
 	err = json.Unmarshal(data, &aggs)
 	if err != nil {
 		return err
@@ -82,28 +88,42 @@ func (b *Bucket) UnmarshalJSON(data []byte) error {
 	// from `Bucket`.
 	delete(aggs, "key")
 	delete(aggs, "doc_count")
-	b2.Aggregations = make(map[string]HitsT)
+	out := Bucket{
+		DocCount:        b2.DocCount,
+		Aggregations:    make(map[string]HitsT),
+		SubAggregations: make(map[string]Aggregation),
+	}
+	// the keys of the terms aggregations of numeric fields are numbers, they are kept as their JSON text
+	if len(b2.Key) > 0 && b2.Key[0] == '"' {
+		if err := json.Unmarshal(b2.Key, &out.Key); err != nil {
+			return err
+		}
+	} else {
+		out.Key = string(b2.Key)
+	}
 	for name, value := range aggs {
-		vMap, ok := value.(map[string]interface{})
-		if !ok {
+		if len(value) == 0 || value[0] != '{' {
 			continue
 		}
-		hMap, ok := vMap["hits"]
-		if !ok {
-			continue
+		var agg struct {
+			Hits    *HitsT          `json:"hits"`
+			Buckets json.RawMessage `json:"buckets"`
 		}
-		data, err := json.Marshal(hMap)
-		if err != nil {
+		if err := json.Unmarshal(value, &agg); err != nil {
 			return err
 		}
-		var hits HitsT
-		err = json.Unmarshal(data, &hits)
-		if err != nil {
-			return err
+		switch {
+		case agg.Hits != nil:
+			out.Aggregations[name] = *agg.Hits
+		case agg.Buckets != nil:
+			var sub Aggregation
+			if err := json.Unmarshal(value, &sub); err != nil {
+				return err
+			}
+			out.SubAggregations[name] = sub
 		}
-		b2.Aggregations[name] = hits
 	}
-	*b = Bucket(b2)
+	*b = out
 	return nil
 }
 
@@ -112,6 +132,9 @@ type Aggregation struct {
 	DocCountErrorUpperBound int64    `json:"doc_count_error_upper_bound"`
 	SumOtherDocCount        int64    `json:"sum_other_doc_count"`
 	Buckets                 []Bucket `json:"buckets,omitempty"`
+	// AfterKey is the key of the last bucket of a composite aggregation, it is passed as its after param to read
+	// the next page of buckets.
+	AfterKey json.RawMessage `json:"after_key,omitempty"`
 }
 
 type Response struct {
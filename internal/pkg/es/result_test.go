@@ -73,3 +73,39 @@ func TestHitUnmarshal(t *testing.T) {
 	}
 
 }
+
+func TestBucketUnmarshal(t *testing.T) {
+	var agg Aggregation
+	err := json.Unmarshal([]byte(`{
+	"buckets": [{
+	    "key": "policy-1",
+	    "doc_count": 3,
+	    "policy_revision_idx": {"buckets": [{"key": 2, "doc_count": 2}, {"key": 1, "doc_count": 1}]},
+	    "latest": {"hits": {"hits": [{"_id": "agent-1", "_source": {}}]}}
+	}]
+    }`), &agg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agg.Buckets) != 1 {
+		t.Fatalf("expected 1 bucket, got %d", len(agg.Buckets))
+	}
+	b := agg.Buckets[0]
+	if diff := cmp.Diff("policy-1", b.Key); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff(int64(3), b.DocCount); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff("agent-1", b.Aggregations["latest"].Hits[0].ID); diff != "" {
+		t.Error(diff)
+	}
+	// the keys of numeric fields are kept as their JSON text
+	revisions := b.SubAggregations["policy_revision_idx"].Buckets
+	if diff := cmp.Diff([]string{"2", "1"}, []string{revisions[0].Key, revisions[1].Key}); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff(int64(2), revisions[0].DocCount); diff != "" {
+		t.Error(diff)
+	}
+}
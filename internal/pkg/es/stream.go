@@ -0,0 +1,157 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// maxDrainSize is the size of the rest of a response body that is read before it is closed, so the connection can be
+// reused. The connection of a larger rest is closed instead.
+const maxDrainSize = 256 * 1024
+
+// ErrResponseTooLarge is returned when a response of Elasticsearch is larger than the limit it is read with.
+type ErrResponseTooLarge struct {
+	Limit int64
+}
+
+func (e *ErrResponseTooLarge) Error() string {
+	return fmt.Sprintf("elasticsearch response larger than %d bytes", e.Limit)
+}
+
+// LimitReader returns a reader of r that fails with ErrResponseTooLarge once more than limit bytes are read.
+// A limit of 0 or less does not limit r.
+func LimitReader(r io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+	return &limitReader{r: r, n: limit, limit: limit}
+}
+
+type limitReader struct {
+	r     io.Reader
+	n     int64
+	limit int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// a response of exactly limit bytes is not too large
+		var b [1]byte
+		n, err := l.r.Read(b[:])
+		if n > 0 {
+			return 0, &ErrResponseTooLarge{Limit: l.limit}
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// DrainAndClose reads the rest of the body, up to maxDrainSize, and closes it.
+// A response body that is not read to the end is not reused by the HTTP transport.
+func DrainAndClose(body io.ReadCloser) {
+	if body == nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, maxDrainSize))
+	_ = body.Close()
+}
+
+// DecodeHits decodes the hits of the search response read from r one at a time and calls fn for each of them,
+// the hits are not held in memory. It stops at the first error of fn.
+// The error of a response that failed is returned with the status as translated by TranslateError.
+func DecodeHits(r io.Reader, status int, fn func(hit *HitT) error) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "hits":
+			if err := decodeHitsObject(dec, fn); err != nil {
+				return err
+			}
+		case "error":
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return err
+			}
+			if err := TranslateError(status, raw); err != nil {
+				return err
+			}
+		default:
+			if err := skipValue(dec); err != nil {
+				return err
+			}
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return err
+	}
+	return TranslateError(status, nil)
+}
+
+// decodeHitsObject decodes the hits object of a search response, the hits array is decoded one hit at a time.
+func decodeHitsObject(dec *json.Decoder, fn func(hit *HitT) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if key != "hits" {
+			if err := skipValue(dec); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := expectDelim(dec, '['); err != nil {
+			return err
+		}
+		for dec.More() {
+			var hit HitT
+			if err := dec.Decode(&hit); err != nil {
+				return err
+			}
+			if err := fn(&hit); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("unexpected token %v in search response, expected %v", tok, delim)
+	}
+	return nil
+}
+
+// skipValue reads the next value of dec without decoding it.
+func skipValue(dec *json.Decoder) error {
+	var raw json.RawMessage
+	return dec.Decode(&raw)
+}
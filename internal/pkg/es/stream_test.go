@@ -0,0 +1,230 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package es
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const streamResponse = `{
+	"took": 3,
+	"timed_out": false,
+	"_shards": {"total": 1, "successful": 1, "skipped": 0, "failed": 0},
+	"hits": {
+		"total": {"value": 2, "relation": "eq"},
+		"max_score": null,
+		"hits": [
+			{"_id": "agent-1", "_seq_no": 5, "_primary_term": 1, "_source": {"active": true, "tags": ["a", "b"]}},
+			{"_id": "agent-2", "_seq_no": 7, "_primary_term": 1, "_source": {"active": true}}
+		]
+	},
+	"aggregations": {"policies": {"buckets": []}}
+}`
+
+func TestDecodeHits(t *testing.T) {
+	var hits []HitT
+	err := DecodeHits(strings.NewReader(streamResponse), http.StatusOK, func(hit *HitT) error {
+		hits = append(hits, *hit)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, hits, 2)
+	require.Equal(t, "agent-1", hits[0].ID)
+	require.Equal(t, int64(5), hits[0].SeqNo)
+	require.JSONEq(t, `{"active": true, "tags": ["a", "b"]}`, string(hits[0].Source))
+	require.Equal(t, "agent-2", hits[1].ID)
+
+	t.Run("no hits", func(t *testing.T) {
+		err := DecodeHits(strings.NewReader(`{"took":1,"hits":{"total":{"value":0},"hits":[]}}`), http.StatusOK, func(*HitT) error {
+			t.Fatal("unexpected hit")
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("callback error", func(t *testing.T) {
+		errStop := errors.New("stop")
+		calls := 0
+		err := DecodeHits(strings.NewReader(streamResponse), http.StatusOK, func(*HitT) error {
+			calls++
+			return errStop
+		})
+		require.ErrorIs(t, err, errStop)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("error response", func(t *testing.T) {
+		body := `{"error":{"type":"index_not_found_exception","reason":"no such index [.fleet-agents]"},"status":404}`
+		err := DecodeHits(strings.NewReader(body), http.StatusNotFound, func(*HitT) error { return nil })
+		require.ErrorIs(t, err, ErrIndexNotFound)
+	})
+
+	t.Run("error status without error", func(t *testing.T) {
+		err := DecodeHits(strings.NewReader(`{}`), http.StatusServiceUnavailable, func(*HitT) error { return nil })
+		require.ErrorIs(t, err, ErrUnavailable)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		err := DecodeHits(strings.NewReader(`{"hits":{"hits":{}}}`), http.StatusOK, func(*HitT) error { return nil })
+		require.Error(t, err)
+	})
+}
+
+func TestLimitReader(t *testing.T) {
+	data, err := io.ReadAll(LimitReader(strings.NewReader("0123456789"), 10))
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(data))
+
+	_, err = io.ReadAll(LimitReader(strings.NewReader("0123456789"), 9))
+	var tooLarge *ErrResponseTooLarge
+	require.ErrorAs(t, err, &tooLarge)
+	require.Equal(t, int64(9), tooLarge.Limit)
+
+	data, err = io.ReadAll(LimitReader(strings.NewReader("0123456789"), 0))
+	require.NoError(t, err)
+	require.Len(t, data, 10)
+
+	t.Run("search response", func(t *testing.T) {
+		calls := 0
+		err := DecodeHits(LimitReader(strings.NewReader(streamResponse), 200), http.StatusOK, func(*HitT) error {
+			calls++
+			return nil
+		})
+		require.ErrorAs(t, err, &tooLarge)
+		require.Less(t, calls, 2)
+	})
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestDrainAndClose(t *testing.T) {
+	body := &closeRecorder{Reader: strings.NewReader(`{"took":1}` + "\n")}
+	DrainAndClose(body)
+	require.True(t, body.closed)
+	n, _ := body.Read(make([]byte, 1))
+	require.Zero(t, n, "the body is drained")
+
+	DrainAndClose(nil)
+}
+
+// hitsReader generates a search response of n hits of about 1KiB as it is read.
+type hitsReader struct {
+	n, i int
+	buf  bytes.Buffer
+	done bool
+}
+
+func newHitsReader(n int) *hitsReader {
+	r := &hitsReader{n: n}
+	fmt.Fprintf(&r.buf, `{"took":10,"timed_out":false,"hits":{"total":{"value":%d,"relation":"eq"},"hits":[`, n)
+	return r
+}
+
+func (r *hitsReader) Read(p []byte) (int, error) {
+	for r.buf.Len() < len(p) && !r.done {
+		if r.i == r.n {
+			r.buf.WriteString(`]}}`)
+			r.done = true
+			break
+		}
+		if r.i > 0 {
+			r.buf.WriteByte(',')
+		}
+		fmt.Fprintf(&r.buf, `{"_id":"agent-%d","_seq_no":%d,"_primary_term":1,"_source":{"active":true,"policy_id":"policy-1","local_metadata":{"host":{"hostname":"%s"}}}}`,
+			r.i, r.i, strings.Repeat("h", 900))
+		r.i++
+	}
+	if r.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return r.buf.Read(p)
+}
+
+// BenchmarkDecodeHits compares the memory held to read the IDs of a large scan.
+// The live-B/op metric is the heap still in use once the IDs are read: it grows with the number of hits when the
+// response is read and unmarshaled at once, it stays flat when the hits are streamed.
+func BenchmarkDecodeHits(b *testing.B) {
+	liveHeap := func(b *testing.B, before uint64) {
+		b.Helper()
+		var ms runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&ms)
+		if ms.HeapAlloc > before {
+			b.ReportMetric(float64(ms.HeapAlloc-before), "live-B/op")
+		} else {
+			b.ReportMetric(0, "live-B/op")
+		}
+	}
+	heap := func() uint64 {
+		var ms runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&ms)
+		return ms.HeapAlloc
+	}
+
+	for _, n := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("unmarshal/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				before := heap()
+				data, err := io.ReadAll(newHitsReader(n))
+				if err != nil {
+					b.Fatal(err)
+				}
+				var res Response
+				if err := json.Unmarshal(data, &res); err != nil {
+					b.Fatal(err)
+				}
+				ids := make([]string, 0, len(res.Hits.Hits))
+				for _, hit := range res.Hits.Hits {
+					ids = append(ids, hit.ID)
+				}
+				b.StopTimer()
+				liveHeap(b, before)
+				runtime.KeepAlive(data)
+				runtime.KeepAlive(res)
+				b.StartTimer()
+			}
+		})
+		b.Run(fmt.Sprintf("stream/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				before := heap()
+				ids := make([]string, 0, n)
+				err := DecodeHits(newHitsReader(n), http.StatusOK, func(hit *HitT) error {
+					ids = append(ids, hit.ID)
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				liveHeap(b, before)
+				runtime.KeepAlive(ids)
+				b.StartTimer()
+			}
+		})
+	}
+}
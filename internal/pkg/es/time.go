@@ -0,0 +1,51 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+type nodesTimeResponse struct {
+	Nodes map[string]struct {
+		OS struct {
+			Timestamp int64 `json:"timestamp"`
+		} `json:"os"`
+	} `json:"nodes"`
+	Error json.RawMessage `json:"error,omitempty"`
+}
+
+// FetchESTime returns the time of the elasticsearch node handling the request, read from its OS stats.
+func FetchESTime(ctx context.Context, esCli *elasticsearch.Client) (time.Time, error) {
+	res, err := esCli.Nodes.Stats(
+		esCli.Nodes.Stats.WithContext(ctx),
+		esCli.Nodes.Stats.WithNodeID("_local"),
+		esCli.Nodes.Stats.WithMetric("os"),
+		esCli.Nodes.Stats.WithFilterPath("nodes.*.os.timestamp"),
+	)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer res.Body.Close()
+
+	var sres nodesTimeResponse
+	if err := json.NewDecoder(res.Body).Decode(&sres); err != nil {
+		return time.Time{}, err
+	}
+	if err := TranslateError(res.StatusCode, sres.Error); err != nil {
+		return time.Time{}, err
+	}
+	for _, node := range sres.Nodes {
+		if node.OS.Timestamp > 0 {
+			return time.UnixMilli(node.OS.Timestamp).UTC(), nil
+		}
+	}
+	return time.Time{}, errors.New("no node time in the elasticsearch response")
+}
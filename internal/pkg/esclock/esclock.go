@@ -0,0 +1,132 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package esclock aligns the time of the instance on the clock of Elasticsearch.
+// The leases, sweeps and timeouts compare the time of the instance with the timestamps written by the other instances,
+// they use SkewedNow so instances with skewed clocks agree on them.
+package esclock
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+)
+
+const (
+	// minOffset is the offset under which the clocks are considered aligned, the time of elasticsearch is read from
+	// its OS stats that are cached for up to a second.
+	minOffset = time.Second
+	// staleSamples is the number of intervals an offset is used without a new sample, the local time is used after.
+	staleSamples = 3
+)
+
+var (
+	// timeNow is used to get the local time. It should be replaced for testing.
+	timeNow = time.Now
+
+	current atomic.Pointer[Clock]
+)
+
+// Clock samples the offset of the local clock to the clock of elasticsearch.
+type Clock struct {
+	cfg   config.ClockSkew
+	fetch func(context.Context) (time.Time, error)
+
+	offset    atomic.Int64 // the time.Duration to add to the local time
+	sampledAt atomic.Int64 // the local unix nanoseconds of the last sample, zero before the first sample
+}
+
+// New returns a clock sampling the time of elasticsearch with fetch.
+func New(cfg config.ClockSkew, fetch func(context.Context) (time.Time, error)) *Clock {
+	return &Clock{
+		cfg:   cfg,
+		fetch: fetch,
+	}
+}
+
+// SetDefault sets the clock used by SkewedNow, a nil clock uses the local time.
+func SetDefault(c *Clock) {
+	current.Store(c)
+}
+
+// SkewedNow returns the time of elasticsearch, estimated from the local time and the offset sampled by the default
+// clock. It is the local time when there is no default clock or its offset is stale.
+func SkewedNow() time.Time {
+	if c := current.Load(); c != nil {
+		return c.Now()
+	}
+	return timeNow()
+}
+
+// Now returns the local time corrected by the offset, or the local time when the offset was not sampled within the
+// last intervals.
+func (c *Clock) Now() time.Time {
+	now := timeNow()
+	offset, ok := c.Offset(now)
+	if !ok {
+		return now
+	}
+	return now.Add(offset)
+}
+
+// Offset returns the offset of the local clock to the clock of elasticsearch, and false when it is stale.
+func (c *Clock) Offset(now time.Time) (time.Duration, bool) {
+	sampledAt := c.sampledAt.Load()
+	if sampledAt == 0 || now.Sub(time.Unix(0, sampledAt)) > staleSamples*c.cfg.Interval {
+		return 0, false
+	}
+	return time.Duration(c.offset.Load()), true
+}
+
+// Schedule returns the schedule sampling the offset.
+func (c *Clock) Schedule() scheduler.Schedule {
+	return scheduler.Schedule{
+		Name:       "clock skew",
+		Interval:   c.cfg.Interval,
+		RunOnStart: true,
+		WorkFn:     c.sample,
+	}
+}
+
+// sample reads the time of elasticsearch and computes the offset from the local time halfway through the request.
+func (c *Clock) sample(ctx context.Context) error {
+	log := zerolog.Ctx(ctx).With().Str("ctx", "clock skew").Logger()
+	start := timeNow()
+	esNow, err := c.fetch(ctx)
+	if err != nil {
+		if _, ok := c.Offset(timeNow()); !ok {
+			log.Debug().Err(err).Msg("failed to read the elasticsearch time, using the local time")
+		}
+		return err
+	}
+	end := timeNow()
+
+	offset := esNow.Sub(start.Add(end.Sub(start) / 2))
+	if offset.Abs() < minOffset {
+		offset = 0
+	}
+	if offset.Abs() > c.cfg.Threshold {
+		log.Warn().Dur("offset", offset).Dur("threshold", c.cfg.Threshold).Msg("the local clock is skewed from the elasticsearch clock")
+	}
+	c.offset.Store(int64(offset))
+	c.sampledAt.Store(end.UnixNano())
+	return nil
+}
+
+// Register registers the offset in milliseconds, and whether it is used.
+func (c *Clock) Register(reg *monitoring.Registry) {
+	monitoring.NewFunc(reg, "clock", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		offset, ok := c.Offset(timeNow())
+		monitoring.ReportInt(v, "offset_ms", offset.Milliseconds())
+		monitoring.ReportBool(v, "aligned", ok)
+	})
+}
@@ -0,0 +1,89 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package esclock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestClock(t *testing.T) {
+	local := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return local
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+
+	var cfg config.ClockSkew
+	cfg.InitDefaults()
+	// the local clock is 10 minutes ahead of elasticsearch
+	skew := 10 * time.Minute
+	errUnreachable := errors.New("unreachable")
+	var fetchErr error
+	c := New(cfg, func(context.Context) (time.Time, error) {
+		return local.Add(-skew), fetchErr
+	})
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	// the local time is used before the first sample
+	require.Equal(t, local, c.Now())
+	require.NoError(t, c.sample(ctx))
+	require.Equal(t, local.Add(-skew), c.Now())
+
+	// the offset is used until it is stale, then the local time is used while elasticsearch is unreachable
+	fetchErr = errUnreachable
+	local = local.Add(3 * cfg.Interval)
+	require.ErrorIs(t, c.sample(ctx), errUnreachable)
+	require.Equal(t, local.Add(-skew), c.Now())
+	local = local.Add(time.Second)
+	require.Equal(t, local, c.Now())
+
+	// the offsets within the precision of the elasticsearch time are ignored
+	fetchErr = nil
+	skew = 500 * time.Millisecond
+	require.NoError(t, c.sample(ctx))
+	require.Equal(t, local, c.Now())
+
+	reg := monitoring.NewRegistry()
+	c.Register(reg)
+	skew = -2 * time.Second
+	require.NoError(t, c.sample(ctx))
+	snapshot := monitoring.CollectStructSnapshot(reg, monitoring.Full, false)
+	require.Equal(t, map[string]interface{}{"offset_ms": int64(2000), "aligned": true}, snapshot["clock"])
+}
+
+func TestSkewedNow(t *testing.T) {
+	local := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return local
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+		SetDefault(nil)
+	})
+
+	require.Equal(t, local, SkewedNow())
+
+	var cfg config.ClockSkew
+	cfg.InitDefaults()
+	c := New(cfg, func(context.Context) (time.Time, error) {
+		return local.Add(time.Hour), nil
+	})
+	require.NoError(t, c.sample(context.Background()))
+	SetDefault(c)
+	require.Equal(t, local.Add(time.Hour), SkewedNow())
+}
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
@@ -19,16 +20,27 @@ import (
 )
 
 const (
-	FieldBaseID   = "bid"
-	FieldLast     = "last"
-	FieldSHA2     = "sha2"
-	FieldUploadID = "upload_id"
+	FieldBaseID      = "bid"
+	FieldLast        = "last"
+	FieldSHA2        = "sha2"
+	FieldUploadID    = "upload_id"
+	FieldAgentID     = "agent_id"
+	FieldStatus      = "file.Status"
+	FieldSize        = "file.size"
+	FieldUploadStart = "upload_start"
+
+	aggUploadsSize = "uploads_size"
 )
 
 var (
 	QueryChunkInfoWithSize = prepareChunkInfo(true)
 	QueryChunkInfo         = prepareChunkInfo(false)
 	QueryUploadID          = prepareFindMetaByUploadID()
+	QueryAgentUploads      = prepareFindAgentUploads()
+	QueryExpiredUploads    = prepareFindExpiredUploads()
+
+	// activeStatuses are the statuses of the uploads that accept chunks.
+	activeStatuses = []string{string(StatusAwaiting), string(StatusProgress)}
 )
 
 // get fields other than the byte payload (data)
@@ -61,6 +73,33 @@ func prepareFindMetaByUploadID() *dsl.Tmpl {
 	return tmpl
 }
 
+// prepareFindAgentUploads counts the active uploads of an agent started after upload_start and sums their size.
+func prepareFindAgentUploads() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	root.Param("track_total_hits", true)
+	filter := root.Query().Bool().Filter()
+	filter.Term(FieldAgentID, tmpl.Bind(FieldAgentID), nil)
+	filter.Terms(FieldStatus, activeStatuses, nil)
+	filter.Range(FieldUploadStart, dsl.WithRangeGT(tmpl.Bind(FieldUploadStart)))
+	root.Aggs().Agg(aggUploadsSize).Sum().Field(FieldSize)
+	root.Size(0)
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
+// prepareFindExpiredUploads finds the active uploads started before upload_start.
+func prepareFindExpiredUploads() *dsl.Tmpl {
+	tmpl := dsl.NewTmpl()
+	root := dsl.NewRoot()
+	filter := root.Query().Bool().Filter()
+	filter.Terms(FieldStatus, activeStatuses, nil)
+	filter.Range(FieldUploadStart, dsl.WithRangeLTE(tmpl.Bind(FieldUploadStart)))
+	root.WithSize(tmpl.Bind("size"))
+	tmpl.MustResolve(root)
+	return tmpl
+}
+
 func GetMetadata(ctx context.Context, bulker bulk.Bulk, indexPattern string, uploadID string) ([]es.HitT, error) {
 	span, ctx := apm.StartSpan(ctx, "getFileInfo", "search")
 	defer span.End()
@@ -92,8 +131,65 @@ func GetInfo(ctx context.Context, bulker bulk.Bulk, indexPattern string, uploadI
 		return Info{}, fmt.Errorf("unable to locate upload record, got %d records, expected 1", len(results))
 	}
 
+	return infoFromHit(results[0])
+}
+
+// GetAgentUploads returns the number and the total size of the active uploads of the agent started after since.
+func GetAgentUploads(ctx context.Context, bulker bulk.Bulk, indexPattern string, agentID string, since time.Time) (int, int64, error) {
+	span, ctx := apm.StartSpan(ctx, "getAgentUploads", "search")
+	defer span.End()
+	query, err := QueryAgentUploads.Render(map[string]interface{}{
+		FieldAgentID:     agentID,
+		FieldUploadStart: since.UnixMilli(),
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	res, err := bulker.Search(ctx, fmt.Sprintf(indexPattern, "*"), query)
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	return int(res.Total.Value), int64(res.Aggregations[aggUploadsSize].Value), nil
+}
+
+// FindExpiredUploads returns up to size uploads that still accept chunks and started before before.
+func FindExpiredUploads(ctx context.Context, bulker bulk.Bulk, indexPattern string, before time.Time, size int) ([]Info, error) {
+	span, ctx := apm.StartSpan(ctx, "findExpiredUploads", "search")
+	defer span.End()
+	query, err := QueryExpiredUploads.Render(map[string]interface{}{
+		FieldUploadStart: before.UnixMilli(),
+		"size":           size,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := bulker.Search(ctx, fmt.Sprintf(indexPattern, "*"), query)
+	if err != nil {
+		if errors.Is(err, es.ErrIndexNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	infos := make([]Info, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		info, err := infoFromHit(hit)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func infoFromHit(hit es.HitT) (Info, error) {
 	var fi MetaDoc
-	if err := json.Unmarshal(results[0].Source, &fi); err != nil {
+	if err := json.Unmarshal(hit.Source, &fi); err != nil {
 		return Info{}, fmt.Errorf("file meta doc parsing error: %w", err)
 	}
 
@@ -108,7 +204,8 @@ func GetInfo(ctx context.Context, bulker bulk.Bulk, indexPattern string, uploadI
 		Source:    fi.Source,
 		AgentID:   fi.AgentID,
 		ActionID:  fi.ActionID,
-		DocID:     results[0].ID,
+		DocID:     hit.ID,
+		Index:     hit.Index,
 		ChunkSize: fi.File.ChunkSize,
 		Total:     fi.File.Size,
 		Count:     int(cnt),
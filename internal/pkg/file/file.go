@@ -85,6 +85,7 @@ type ChunkInfo struct {
 type Info struct {
 	ID         string // upload operation identifier. Used to identify the upload process
 	DocID      string // document ID of the uploaded file and chunks
+	Index      string // index of the metadata document
 	Source     string // which integration is performing the upload
 	AgentID    string
 	ActionID   string
@@ -20,6 +20,12 @@ import (
 	"go.elastic.co/apm/v2"
 )
 
+// DefaultTimeLimit is the time an upload accepts chunks after it started, abandoned uploads are expired by the GC.
+const DefaultTimeLimit = 24 * time.Hour
+
+// uploadNamespace is the namespace of the upload IDs derived from the action and the agent.
+var uploadNamespace = uuid.NewV5(uuid.NamespaceURL, "https://github.com/elastic/fleet-server/uploads")
+
 var (
 	ErrInvalidUploadID  = errors.New("active upload not found with this ID, it may be expired")
 	ErrFileSizeTooLarge = errors.New("this file exceeds the maximum allowed file size")
@@ -28,6 +34,8 @@ var (
 	ErrUploadExpired    = errors.New("upload has expired")
 	ErrUploadStopped    = errors.New("upload has stopped")
 	ErrInvalidChunkNum  = errors.New("invalid chunk number")
+	ErrTooManyUploads   = errors.New("the agent has too many active uploads")
+	ErrAgentSizeLimit   = errors.New("the active uploads of the agent exceed the maximum allowed total size")
 
 	ErrPayloadRequired  = errors.New("upload start payload required")
 	ErrFileSizeRequired = errors.New("file.size is required")
@@ -40,17 +48,23 @@ type Uploader struct {
 	sizeLimit int64
 	timeLimit time.Duration
 
+	// limits of the active uploads of an agent, a limit of 0 is not enforced
+	agentCountLimit int
+	agentSizeLimit  int64
+
 	chunkClient *elasticsearch.Client
 	bulker      bulk.Bulk
 }
 
-func New(chunkClient *elasticsearch.Client, bulker bulk.Bulk, cache cache.Cache, sizeLimit int64, timeLimit time.Duration) *Uploader {
+func New(chunkClient *elasticsearch.Client, bulker bulk.Bulk, cache cache.Cache, sizeLimit int64, timeLimit time.Duration, agentCountLimit int, agentSizeLimit int64) *Uploader {
 	return &Uploader{
-		chunkClient: chunkClient,
-		bulker:      bulker,
-		sizeLimit:   sizeLimit,
-		timeLimit:   timeLimit,
-		cache:       cache,
+		chunkClient:     chunkClient,
+		bulker:          bulker,
+		sizeLimit:       sizeLimit,
+		timeLimit:       timeLimit,
+		agentCountLimit: agentCountLimit,
+		agentSizeLimit:  agentSizeLimit,
+		cache:           cache,
 	}
 }
 
@@ -77,17 +91,16 @@ func (u *Uploader) Begin(ctx context.Context, namespaces []string, data JSDict)
 		vSpan.End()
 		return file.Info{}, ErrFileSizeTooLarge
 	}
-
-	uid, err := uuid.NewV4()
 	vSpan.End()
-	if err != nil {
-		return file.Info{}, fmt.Errorf("unable to generate upload operation ID: %w", err)
-	}
-	id := uid.String()
 
 	// grab required fields that were checked already in validation step
 	agentID, _ := data.Str("agent_id")
+	if err := u.checkAgentLimits(ctx, agentID, size); err != nil {
+		return file.Info{}, err
+	}
+
 	actionID, _ := data.Str("action_id")
+	id := UploadID(actionID, agentID)
 	source, _ := data.Str("src")
 	docID := fmt.Sprintf("%s.%s", actionID, agentID)
 
@@ -147,6 +160,35 @@ func (u *Uploader) Begin(ctx context.Context, namespaces []string, data JSDict)
 	return info, nil
 }
 
+// UploadID returns the ID of the upload of the agent for the action.
+// The ID is pre-allocated when an action requesting an upload is delivered, the upload started for the action uses the same ID.
+func UploadID(actionID, agentID string) string {
+	return uuid.NewV5(uploadNamespace, actionID+"."+agentID).String()
+}
+
+// checkAgentLimits rejects an upload of size bytes when the agent has too many active uploads,
+// or when the upload would exceed the total size of the active uploads of the agent.
+// Uploads that started before the time limit no longer accept chunks and are not counted.
+func (u *Uploader) checkAgentLimits(ctx context.Context, agentID string, size int64) error {
+	if u.agentCountLimit <= 0 && u.agentSizeLimit <= 0 {
+		return nil
+	}
+	span, ctx := apm.StartSpan(ctx, "checkAgentLimits", "validate")
+	defer span.End()
+
+	count, total, err := file.GetAgentUploads(ctx, u.bulker, UploadHeaderIndexPattern, agentID, time.Now().Add(-u.timeLimit))
+	if err != nil {
+		return fmt.Errorf("unable to retrieve active uploads of the agent: %w", err)
+	}
+	if u.agentCountLimit > 0 && count >= u.agentCountLimit {
+		return fmt.Errorf("%w: %d active uploads", ErrTooManyUploads, count)
+	}
+	if u.agentSizeLimit > 0 && total+size > u.agentSizeLimit {
+		return fmt.Errorf("%w: %d bytes active", ErrAgentSizeLimit, total)
+	}
+	return nil
+}
+
 func (u *Uploader) Chunk(ctx context.Context, uplID string, chunkNum int, chunkHash string) (file.Info, file.ChunkInfo, error) {
 	// find the upload, details, and status associated with the file upload
 	info, err := u.GetUploadInfo(ctx, uplID)
@@ -82,7 +82,7 @@ func TestUploadBeginReturnsCorrectInfo(t *testing.T) {
 
 	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
 	require.NoError(t, err)
-	u := New(nil, fakeBulk, c, int64(size), time.Hour)
+	u := New(nil, fakeBulk, c, int64(size), time.Hour, 0, 0)
 	info, err := u.Begin(context.Background(), []string{}, data)
 	assert.NoError(t, err)
 
@@ -93,9 +93,18 @@ func TestUploadBeginReturnsCorrectInfo(t *testing.T) {
 	assert.Equal(t, file.StatusAwaiting, info.Status)
 	assert.Greaterf(t, info.ChunkSize, int64(0), "server chosen chunk size should be >0")
 	assert.Equal(t, action+"."+agent, info.DocID)
+	assert.Equal(t, UploadID(action, agent), info.ID)
 	assert.WithinDuration(t, time.Now(), info.Start, time.Minute)
 }
 
+func TestUploadID(t *testing.T) {
+	id := UploadID("abc", "XYZ")
+	assert.Equal(t, id, UploadID("abc", "XYZ"), "upload ID of an action and agent is stable")
+	assert.NotEqual(t, id, UploadID("abc", "XYZW"))
+	assert.NotEqual(t, id, UploadID("abcd", "XYZ"))
+	assert.Len(t, id, 36)
+}
+
 // Happy-path case, where everything expected is provided
 // tests the document sent to elasticsearch passes through
 // the correct fields from input
@@ -126,7 +135,7 @@ func TestUploadBeginWritesDocumentFromInputs(t *testing.T) {
 
 	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
 	require.NoError(t, err)
-	u := New(nil, fakeBulk, c, int64(size), time.Hour)
+	u := New(nil, fakeBulk, c, int64(size), time.Hour, 0, 0)
 	_, err = u.Begin(context.Background(), []string{}, data)
 	assert.NoError(t, err)
 
@@ -164,7 +173,7 @@ func TestUploadBeginCalculatesCorrectChunkCount(t *testing.T) {
 
 	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
 	require.NoError(t, err)
-	u := New(nil, fakeBulk, c, file.MaxChunkSize*3000, time.Hour)
+	u := New(nil, fakeBulk, c, file.MaxChunkSize*3000, time.Hour, 0, 0)
 
 	for _, tc := range tests {
 		t.Run(tc.Name, func(t *testing.T) {
@@ -206,7 +215,7 @@ func TestUploadBeginMaxFileSize(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.Name, func(t *testing.T) {
-			u := New(nil, fakeBulk, c, tc.UploadSizeLimit, time.Hour)
+			u := New(nil, fakeBulk, c, tc.UploadSizeLimit, time.Hour, 0, 0)
 			data := makeUploadRequestDict(map[string]interface{}{
 				"file.size": tc.FileSize,
 			})
@@ -220,6 +229,47 @@ func TestUploadBeginMaxFileSize(t *testing.T) {
 	}
 }
 
+func TestUploadBeginAgentLimits(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Active      uint64
+		ActiveSize  float64
+		FileSize    int64
+		ExpectedErr error
+	}{
+		{"no active uploads", 0, 0, 1024, nil},
+		{"below limits", 1, 1024, 1024, nil},
+		{"too many active uploads", 2, 1024, 1024, ErrTooManyUploads},
+		{"total size exceeded", 1, 3500, 1024, ErrAgentSizeLimit},
+		{"total size exactly limit", 1, 3072, 1024, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			fakeBulk := itesting.NewMockBulk()
+			fakeBulk.On("Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+			res := &es.ResultT{Aggregations: map[string]es.Aggregation{"uploads_size": {Value: tc.ActiveSize}}}
+			res.Total.Value = tc.Active
+			fakeBulk.On("Search", mock.Anything, ".fleet-fileds-fromhost-meta-*", mock.MatchedBy(func(body []byte) bool {
+				return strings.Contains(string(body), `"agent_id":"456"`)
+			}), mock.Anything).Return(res, nil)
+
+			c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
+			require.NoError(t, err)
+			u := New(nil, fakeBulk, c, 4096, time.Hour, 2, 4096)
+			_, err = u.Begin(context.Background(), []string{}, makeUploadRequestDict(map[string]interface{}{
+				"file.size": tc.FileSize,
+			}))
+			if tc.ExpectedErr != nil {
+				assert.ErrorIs(t, err, tc.ExpectedErr)
+				fakeBulk.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
 func TestUploadRejectsMissingRequiredFields(t *testing.T) {
 
 	tests := []string{
@@ -243,7 +293,7 @@ func TestUploadRejectsMissingRequiredFields(t *testing.T) {
 	c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
 	require.NoError(t, err)
 
-	u := New(nil, fakeBulk, c, 2048, time.Hour)
+	u := New(nil, fakeBulk, c, 2048, time.Hour, 0, 0)
 
 	var ok bool
 	for _, field := range tests {
@@ -336,7 +386,7 @@ func TestChunkMarksFinal(t *testing.T) {
 			c, err := cache.New(config.Cache{NumCounters: 100, MaxCost: 100000})
 			require.NoError(t, err)
 
-			u := New(nil, fakeBulk, c, 8388608000, time.Hour)
+			u := New(nil, fakeBulk, c, 8388608000, time.Hour, 0, 0)
 
 			data := makeUploadRequestDict(map[string]interface{}{
 				"file.size": tc.FileSize,
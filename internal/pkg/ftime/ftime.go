@@ -0,0 +1,36 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package ftime formats the timestamps fleet-server writes to Elasticsearch and parses the timestamps it reads back.
+//
+// The timestamps are written in UTC with a millisecond precision, the precision of the date fields of the index
+// mappings, so the range queries and date math on them compare the same instants whatever path wrote them.
+package ftime
+
+import "time"
+
+// RFC3339Milli is the layout of the written timestamps, RFC3339 with a millisecond precision.
+// The timestamps are formatted in UTC so the zone is always Z. The layout matches the strict_date_optional_time
+// format of the index mappings.
+const RFC3339Milli = "2006-01-02T15:04:05.000Z07:00"
+
+// Now returns the current time formatted with RFC3339Milli.
+func Now() string {
+	return Format(time.Now())
+}
+
+// Format returns t in UTC formatted with RFC3339Milli.
+func Format(t time.Time) string {
+	return t.UTC().Format(RFC3339Milli)
+}
+
+// Parse parses a timestamp read from Elasticsearch and returns it in UTC.
+// It accepts RFC3339 with any precision, the documents written by older versions have a second or a nanosecond precision.
+func Parse(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
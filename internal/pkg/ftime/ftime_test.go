@@ -0,0 +1,109 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package ftime
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// strictDateOptionalTime matches the strict_date_optional_time format of the date fields of the index mappings.
+var strictDateOptionalTime = regexp.MustCompile(`^\d{4}(-\d{2}(-\d{2}(T\d{2}(:\d{2}(:\d{2}([.,]\d{1,9})?)?)?(Z|[+-]\d{2}(:?\d{2})?)?)?)?)?$`)
+
+func TestFormat(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+	tests := []struct {
+		name   string
+		t      time.Time
+		expect string
+	}{{
+		name:   "utc",
+		t:      time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC),
+		expect: "2025-04-01T12:00:00.000Z",
+	}, {
+		name:   "nanoseconds",
+		t:      time.Date(2025, 4, 1, 12, 0, 0, 123456789, time.UTC),
+		expect: "2025-04-01T12:00:00.123Z",
+	}, {
+		name:   "zone",
+		t:      time.Date(2025, 4, 1, 13, 0, 0, 500000000, cet),
+		expect: "2025-04-01T12:00:00.500Z",
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := Format(tc.t)
+			require.Equal(t, tc.expect, s)
+			require.Regexp(t, strictDateOptionalTime, s)
+
+			parsed, err := Parse(s)
+			require.NoError(t, err)
+			require.True(t, tc.t.Truncate(time.Millisecond).Equal(parsed))
+			require.Equal(t, time.UTC, parsed.Location())
+		})
+	}
+
+	require.Regexp(t, strictDateOptionalTime, Now())
+}
+
+func TestParse(t *testing.T) {
+	expect := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	for _, s := range []string{
+		"2025-04-01T12:00:00Z",
+		"2025-04-01T12:00:00.000Z",
+		"2025-04-01T12:00:00.000000000Z",
+		"2025-04-01T14:00:00+02:00",
+	} {
+		parsed, err := Parse(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expect, parsed, s)
+	}
+
+	parsed, err := Parse("2025-04-01T12:00:00.123456Z")
+	require.NoError(t, err)
+	require.Equal(t, 123456000, parsed.Nanosecond())
+
+	for _, s := range []string{"", "2025-04-01", "2025-04-01 12:00:00Z"} {
+		_, err := Parse(s)
+		require.Error(t, err, s)
+	}
+}
+
+// TestWritePaths fails when a package writing documents to Elasticsearch formats or parses a timestamp without ftime.
+func TestWritePaths(t *testing.T) {
+	direct := regexp.MustCompile(`\.Format\(time\.RFC3339|time\.Parse\(time\.RFC3339`)
+	// the timestamps that are not written to Elasticsearch
+	allowed := map[string]bool{
+		// the build time of the status response
+		filepath.Join("..", "api", "handleStatus.go"): true,
+	}
+
+	for _, pkg := range []string{"action", "api", "checkin", "dl", "gc", "instance", "model", "monitor", "policy", "setup", "testing", "unenroll"} {
+		err := filepath.WalkDir(filepath.Join("..", pkg), func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") || allowed[path] {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			for i, line := range strings.Split(string(data), "\n") {
+				if direct.MatchString(line) {
+					t.Errorf("%s:%d: timestamp formatted without ftime: %s", path, i+1, strings.TrimSpace(line))
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+	}
+}
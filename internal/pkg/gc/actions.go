@@ -6,16 +6,42 @@ package gc
 
 import (
 	"context"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/esclock"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
 
 	"github.com/rs/zerolog"
 )
 
+const (
+	// ActionResultStatusExpired is the status of the result recorded for an action that expired before it was delivered.
+	ActionResultStatusExpired = dl.ActionResultStatusExpired
+
+	maxExpiredActionsFetchSize = 100
+
+	actionTypeRequestDiagnostics = "REQUEST_DIAGNOSTICS"
+)
+
+// timeNow is used to get the current time, aligned on the elasticsearch clock. It should be replaced for testing.
+var timeNow = esclock.SkewedNow
+
+// undeliveredActionTypes are the action types that are never delivered to agents, no result is recorded for them.
+var undeliveredActionTypes = map[string]bool{
+	"POLICY_CHANGE":  true,
+	"UPDATE_TAGS":    true,
+	"FORCE_UNENROLL": true,
+}
+
 type ActionsCleanupConfig struct {
 	cleanupIntervalAfterExpired string
 }
@@ -82,3 +108,131 @@ func cleanupActions(ctx context.Context, index string, bulker bulk.Bulk, opts ..
 	log.Debug().Int64("count", deleted).Msg("deleted expired actions")
 	return nil
 }
+
+// getExpiredActionsFunc returns the work function recording the expired results, each run uses the TTLs returned by ttl.
+func getExpiredActionsFunc(bulker bulk.Bulk, scheduleInterval time.Duration, ttl func() config.Actions) scheduler.WorkFunc {
+	return func(ctx context.Context) error {
+		return recordExpiredActions(ctx, bulker, scheduleInterval, ttl())
+	}
+}
+
+// recordExpiredActions records an expired result for the agents targeted by actions that expired since the previous run.
+// The actions without an expiration expire once they are older than the TTL of their type in ttl.
+// The lookback covers two schedule intervals so a delayed run does not miss actions.
+// The results are only recorded for the agents without a result, the result an agent writes on a late ack replaces it.
+func recordExpiredActions(ctx context.Context, bulker bulk.Bulk, scheduleInterval time.Duration, ttl config.Actions) error {
+	now := timeNow().UTC()
+	from := now.Add(-2 * scheduleInterval)
+
+	log := zerolog.Ctx(ctx).With().Str("ctx", "fleet expired actions").Time("from", from).Logger()
+	r := expiredResults{bulker: bulker, now: now}
+
+	err := eachPage(func(seqNo int64) ([]model.Action, error) {
+		return dl.FindExpiredActions(ctx, bulker, from, now, seqNo, maxExpiredActionsFetchSize)
+	}, func(actions []model.Action) error {
+		return r.record(ctx, actions)
+	})
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to record the results of the expired actions")
+		return err
+	}
+
+	// The actions of the types with a TTL override, then the actions of the other types with the default TTL.
+	overrides := make([]string, 0, len(ttl.TTL))
+	for actionType := range ttl.TTL {
+		overrides = append(overrides, actionType)
+	}
+	slices.Sort(overrides)
+	for _, actionType := range overrides {
+		if d := ttl.TTL[actionType]; d > 0 {
+			err := eachPage(func(seqNo int64) ([]model.Action, error) {
+				return dl.FindUnexpiringActions(ctx, bulker, from.Add(-d), now.Add(-d), []string{actionType}, false, seqNo, maxExpiredActionsFetchSize)
+			}, func(actions []model.Action) error {
+				return r.record(ctx, actions)
+			})
+			if err != nil {
+				log.Debug().Err(err).Str(logger.ActionType, actionType).Msg("failed to record the results of the actions older than their ttl")
+				return err
+			}
+		}
+	}
+	if d := ttl.DefaultTTL; d > 0 {
+		err := eachPage(func(seqNo int64) ([]model.Action, error) {
+			return dl.FindUnexpiringActions(ctx, bulker, from.Add(-d), now.Add(-d), overrides, true, seqNo, maxExpiredActionsFetchSize)
+		}, func(actions []model.Action) error {
+			return r.record(ctx, actions)
+		})
+		if err != nil {
+			log.Debug().Err(err).Msg("failed to record the results of the actions older than the default ttl")
+			return err
+		}
+	}
+
+	log.Debug().Int("actions", r.actions).Int("count", r.count).Msg("recorded expired action results")
+	return nil
+}
+
+// eachPage calls fn with the pages of actions returned by find, until a page is not full.
+// find returns the actions with a sequence number greater than the one it is passed, the oldest first.
+func eachPage(find func(seqNo int64) ([]model.Action, error), fn func([]model.Action) error) error {
+	seqNo := int64(-1)
+	for {
+		actions, err := find(seqNo)
+		if err != nil {
+			return err
+		}
+		if err := fn(actions); err != nil {
+			return err
+		}
+		if len(actions) < maxExpiredActionsFetchSize {
+			return nil
+		}
+		seqNo = actions[len(actions)-1].SeqNo
+	}
+}
+
+// expiredResults records the expired results of the actions.
+type expiredResults struct {
+	bulker bulk.Bulk
+	now    time.Time
+
+	actions int // actions found
+	count   int // results recorded
+}
+
+func (r *expiredResults) record(ctx context.Context, actions []model.Action) error {
+	for _, action := range actions {
+		r.actions++
+		if undeliveredActionTypes[action.Type] || len(action.Agents) == 0 {
+			continue
+		}
+		responded, err := dl.FindActionResultAgentIDs(ctx, r.bulker, action.ActionID, action.Agents)
+		if err != nil {
+			return err
+		}
+		msg := "action expired before it was delivered"
+		if action.Type == actionTypeRequestDiagnostics {
+			msg = "diagnostics did not complete before the action expired"
+		}
+		for _, agentID := range action.Agents {
+			if responded[agentID] {
+				continue
+			}
+			err := dl.CreateActionResult(ctx, r.bulker, model.ActionResult{
+				ActionID:        action.ActionID,
+				ActionInputType: action.InputType,
+				AgentID:         agentID,
+				CompletedAt:     ftime.Format(r.now),
+				Error:           msg,
+				Status:          ActionResultStatusExpired,
+				Timestamp:       ftime.Format(r.now),
+			})
+			if err != nil {
+				zerolog.Ctx(ctx).Debug().Err(err).Str(logger.ActionID, action.ActionID).Str(logger.AgentID, agentID).Msg("failed to record expired action result")
+				return err
+			}
+			r.count++
+		}
+	}
+	return nil
+}
@@ -7,9 +7,23 @@
 package gc
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 )
 
 func TestIsIntervalStringValid(t *testing.T) {
@@ -44,3 +58,200 @@ func TestIsIntervalStringValid(t *testing.T) {
 		})
 	}
 }
+
+func TestRecordExpiredActions(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+
+	hit := func(action model.Action) es.HitT {
+		src, err := json.Marshal(action)
+		require.NoError(t, err)
+		return es.HitT{ID: action.ActionID, Source: src}
+	}
+	res := &es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		hit(model.Action{ActionID: "action-1", Type: "UPGRADE", Agents: []string{"agent-1", "agent-2"}}),
+		hit(model.Action{ActionID: "action-2", Type: "UPDATE_TAGS", Agents: []string{"agent-1"}}),
+		hit(model.Action{ActionID: "action-3", Type: "REQUEST_DIAGNOSTICS", Agents: []string{"agent-1"}}),
+	}}}
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.MatchedBy(func(body []byte) bool {
+		var query struct {
+			Query struct {
+				Bool struct {
+					Filter []struct {
+						Range map[string]map[string]interface{} `json:"range"`
+					} `json:"filter"`
+				} `json:"bool"`
+			} `json:"query"`
+		}
+		if err := json.Unmarshal(body, &query); err != nil || len(query.Query.Bool.Filter) != 3 {
+			return false
+		}
+		return query.Query.Bool.Filter[1].Range[dl.FieldExpiration]["gt"] == "2024-01-01T10:00:00.000Z" &&
+			query.Query.Bool.Filter[2].Range[dl.FieldExpiration]["lte"] == "2024-01-01T12:00:00.000Z"
+	}), mock.Anything).Return(res, nil)
+	// agent-2 acked action-1 before it expired
+	bulker.On("Search", mock.Anything, dl.FleetActionsResults, mock.MatchedBy(func(body []byte) bool {
+		return strings.Contains(string(body), `"action-1"`)
+	}), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		{ID: "action-1:agent-2", Source: []byte(`{"action_id":"action-1","agent_id":"agent-2"}`)},
+	}}}, nil)
+	bulker.On("Search", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+
+	var results []model.ActionResult
+	bulker.On("Create", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var acr model.ActionResult
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &acr))
+		require.Equal(t, acr.ActionID+":"+acr.AgentID, args.String(2))
+		results = append(results, acr)
+	}).Return("", nil)
+
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	err := recordExpiredActions(ctx, bulker, time.Hour, config.Actions{})
+	require.NoError(t, err)
+	bulker.AssertExpectations(t)
+
+	require.Len(t, results, 2)
+	require.Equal(t, "action-1", results[0].ActionID)
+	require.Equal(t, "agent-1", results[0].AgentID)
+	require.Equal(t, ActionResultStatusExpired, results[0].Status)
+	require.Equal(t, "action expired before it was delivered", results[0].Error)
+	require.Equal(t, "2024-01-01T12:00:00.000Z", results[0].Timestamp)
+	require.Equal(t, "action-3", results[1].ActionID)
+	require.Equal(t, ActionResultStatusExpired, results[1].Status)
+	require.Equal(t, "diagnostics did not complete before the action expired", results[1].Error)
+}
+
+func TestRecordExpiredActionsPages(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+
+	// a full page of actions, then the last action
+	page := func(from, n int) *es.ResultT {
+		res := &es.ResultT{}
+		for i := from; i < from+n; i++ {
+			src, err := json.Marshal(model.Action{ActionID: fmt.Sprintf("action-%d", i), Type: "UPGRADE", Agents: []string{"agent-1"}})
+			require.NoError(t, err)
+			res.Hits = append(res.Hits, es.HitT{ID: fmt.Sprintf("action-%d", i), SeqNo: int64(i), Source: src})
+		}
+		return res
+	}
+	afterSeqNo := func(seqNo int) interface{} {
+		return mock.MatchedBy(func(body []byte) bool {
+			return strings.Contains(string(body), fmt.Sprintf(`{"range":{"_seq_no":{"gt":%d}}}`, seqNo))
+		})
+	}
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetActions, afterSeqNo(-1), mock.Anything).Return(page(0, maxExpiredActionsFetchSize), nil).Once()
+	bulker.On("Search", mock.Anything, dl.FleetActions, afterSeqNo(maxExpiredActionsFetchSize-1), mock.Anything).Return(page(maxExpiredActionsFetchSize, 1), nil).Once()
+	bulker.On("Search", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+	var count int
+	bulker.On("Create", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything, mock.Anything).Run(func(mock.Arguments) {
+		count++
+	}).Return("", nil)
+
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	require.NoError(t, recordExpiredActions(ctx, bulker, time.Hour, config.Actions{}))
+	bulker.AssertExpectations(t)
+	require.Equal(t, maxExpiredActionsFetchSize+1, count)
+}
+
+func TestRecordExpiredActionsTTL(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+
+	hit := func(action model.Action) es.HitT {
+		src, err := json.Marshal(action)
+		require.NoError(t, err)
+		return es.HitT{ID: action.ActionID, Source: src}
+	}
+	type query struct {
+		Query struct {
+			Bool struct {
+				Filter []struct {
+					Range map[string]map[string]interface{} `json:"range"`
+					Terms map[string][]string               `json:"terms"`
+				} `json:"filter"`
+				MustNot []struct {
+					Exists map[string]string   `json:"exists"`
+					Terms  map[string][]string `json:"terms"`
+				} `json:"must_not"`
+			} `json:"bool"`
+		} `json:"query"`
+	}
+	// matchQuery matches the query of the actions without expiration created in the range, of types or not of types.
+	matchQuery := func(from, to string, types []string, exclude bool) interface{} {
+		return mock.MatchedBy(func(body []byte) bool {
+			var q query
+			if err := json.Unmarshal(body, &q); err != nil || len(q.Query.Bool.Filter) < 3 || len(q.Query.Bool.MustNot) == 0 {
+				return false
+			}
+			b := q.Query.Bool
+			if b.Filter[1].Range[dl.FieldTimestamp]["gt"] != from || b.Filter[2].Range[dl.FieldTimestamp]["lte"] != to ||
+				b.MustNot[0].Exists["field"] != dl.FieldExpiration {
+				return false
+			}
+			if exclude {
+				return len(b.MustNot) == 2 && cmp.Equal(b.MustNot[1].Terms[dl.FieldType], types)
+			}
+			return len(b.Filter) == 4 && cmp.Equal(b.Filter[3].Terms[dl.FieldType], types)
+		})
+	}
+
+	bulker := ftesting.NewMockBulk()
+	// no action has an explicit expiration
+	bulker.On("Search", mock.Anything, dl.FleetActions, mock.MatchedBy(func(body []byte) bool {
+		var q query
+		return json.Unmarshal(body, &q) == nil && len(q.Query.Bool.MustNot) == 0
+	}), mock.Anything).Return(&es.ResultT{}, nil).Once()
+	// UPGRADE actions expire after a day
+	bulker.On("Search", mock.Anything, dl.FleetActions, matchQuery("2023-12-31T10:00:00.000Z", "2023-12-31T12:00:00.000Z", []string{"UPGRADE"}, false), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		hit(model.Action{ActionID: "upgrade-1", Type: "UPGRADE", Agents: []string{"agent-1"}}),
+	}}}, nil).Once()
+	// the other actions expire after an hour
+	bulker.On("Search", mock.Anything, dl.FleetActions, matchQuery("2024-01-01T09:00:00.000Z", "2024-01-01T11:00:00.000Z", []string{"SETTINGS", "UPGRADE"}, true), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		hit(model.Action{ActionID: "unenroll-1", Type: "UNENROLL", Agents: []string{"agent-2"}}),
+		hit(model.Action{ActionID: "tags-1", Type: "UPDATE_TAGS", Agents: []string{"agent-2"}}),
+	}}}, nil).Once()
+
+	bulker.On("Search", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil)
+
+	var results []model.ActionResult
+	bulker.On("Create", mock.Anything, dl.FleetActionsResults, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var acr model.ActionResult
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &acr))
+		results = append(results, acr)
+	}).Return("", nil)
+
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	err := recordExpiredActions(ctx, bulker, time.Hour, config.Actions{
+		DefaultTTL: time.Hour,
+		// the SETTINGS actions do not expire
+		TTL: map[string]time.Duration{"UPGRADE": 24 * time.Hour, "SETTINGS": 0},
+	})
+	require.NoError(t, err)
+	bulker.AssertExpectations(t)
+
+	require.Len(t, results, 2)
+	require.Equal(t, "upgrade-1", results[0].ActionID)
+	require.Equal(t, "agent-1", results[0].AgentID)
+	require.Equal(t, ActionResultStatusExpired, results[0].Status)
+	require.Equal(t, "unenroll-1", results[1].ActionID)
+	require.Equal(t, "agent-2", results[1].AgentID)
+}
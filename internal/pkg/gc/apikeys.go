@@ -0,0 +1,225 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+)
+
+// apiKeysMinAge is the age of the keys that are checked. A key is created before the document that owns it is written,
+// the younger keys may belong to an enrollment in progress.
+const apiKeysMinAge = time.Hour
+
+// APIKeys invalidates the API keys created by fleet server that are left without an owner, after a crash between
+// the creation of a key and the write of the document that references it.
+// The access and output keys are owned by the agent of their metadata, an agent that is unenrolled for more than the
+// retention no longer owns them. The enrollment keys are owned by an active enrollment key document.
+type APIKeys struct {
+	bulker   bulk.Bulk
+	cfg      config.APIKeysGC
+	interval time.Duration
+	leader   func(context.Context) (bool, error)
+
+	runs        atomic.Int64
+	checked     atomic.Int64
+	orphaned    atomic.Int64
+	invalidated atomic.Int64
+	failed      atomic.Int64
+}
+
+// NewAPIKeys returns the reconciliation of the API keys, it runs every interval when leader reports the instance as the
+// leader of the fleet servers.
+func NewAPIKeys(bulker bulk.Bulk, cfg config.APIKeysGC, interval time.Duration, leader func(context.Context) (bool, error)) *APIKeys {
+	if interval == 0 {
+		interval = defaultScheduleInterval
+	}
+	return &APIKeys{
+		bulker:   bulker,
+		cfg:      cfg,
+		interval: interval,
+		leader:   leader,
+	}
+}
+
+// Schedule returns the schedule of the reconciliation.
+func (ak *APIKeys) Schedule() scheduler.Schedule {
+	return scheduler.Schedule{
+		Name:     "fleet orphaned api keys",
+		Interval: ak.interval,
+		WorkFn:   ak.run,
+	}
+}
+
+// Register registers the counts of the keys checked, orphaned and invalidated since the instance started.
+func (ak *APIKeys) Register(reg *monitoring.Registry) {
+	monitoring.NewFunc(reg, "api_keys", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		monitoring.ReportInt(v, "runs", ak.runs.Load())
+		monitoring.ReportInt(v, "checked", ak.checked.Load())
+		monitoring.ReportInt(v, "orphaned", ak.orphaned.Load())
+		monitoring.ReportInt(v, "invalidated", ak.invalidated.Load())
+		monitoring.ReportInt(v, "failed", ak.failed.Load())
+	})
+}
+
+// run checks up to MaxBatches batches of the keys, oldest first. A batch that fails to be invalidated is counted and
+// left for the next run.
+func (ak *APIKeys) run(ctx context.Context) error {
+	log := zerolog.Ctx(ctx).With().Str("ctx", "fleet orphaned api keys").Bool("dry_run", ak.cfg.DryRun).Logger()
+	ctx = log.WithContext(ctx)
+
+	if ok, err := checkLeader(ctx, ak.leader); !ok {
+		return err
+	}
+	ak.runs.Add(1)
+
+	now := timeNow().UTC()
+	var checked, orphaned, invalidated, failed int
+	var after []interface{}
+	for i := 0; i < ak.cfg.MaxBatches; i++ {
+		body, err := apiKeysQuery(now.Add(-apiKeysMinAge), ak.cfg.BatchSize, after)
+		if err != nil {
+			return err
+		}
+		keys, err := ak.bulker.APIKeyQuery(ctx, body)
+		if err != nil {
+			log.Debug().Err(err).Msg("failed to query the api keys")
+			return err
+		}
+		if len(keys) == 0 {
+			break
+		}
+		ids, err := ak.orphans(ctx, keys, now)
+		if err != nil {
+			log.Debug().Err(err).Msg("failed to find the owners of the api keys")
+			return err
+		}
+		checked += len(keys)
+		orphaned += len(ids)
+		ak.checked.Add(int64(len(keys)))
+		ak.orphaned.Add(int64(len(ids)))
+		if len(ids) > 0 {
+			if ak.cfg.DryRun {
+				log.Info().Strs("api_key_ids", ids).Msg("orphaned api keys found")
+			} else if err := ak.bulker.APIKeyInvalidate(ctx, ids...); err != nil {
+				log.Warn().Err(err).Strs("api_key_ids", ids).Msg("failed to invalidate orphaned api keys")
+				failed += len(ids)
+				ak.failed.Add(int64(len(ids)))
+			} else {
+				log.Info().Strs("api_key_ids", ids).Msg("orphaned api keys invalidated")
+				invalidated += len(ids)
+				ak.invalidated.Add(int64(len(ids)))
+			}
+		}
+		if len(keys) < ak.cfg.BatchSize {
+			break
+		}
+		after = keys[len(keys)-1].Sort
+	}
+	log.Info().Int("checked", checked).Int("orphaned", orphaned).Int("invalidated", invalidated).Int("failed", failed).Msg("orphaned api keys reconciled")
+	return nil
+}
+
+// orphans returns the ids of the keys without an owner.
+func (ak *APIKeys) orphans(ctx context.Context, keys []bulk.APIKeyQueryHit, now time.Time) ([]string, error) {
+	var agentIDs, enrollKeyIDs []string
+	for _, key := range keys {
+		switch {
+		case key.Metadata.Type == apikey.TypeEnroll.String():
+			enrollKeyIDs = append(enrollKeyIDs, key.ID)
+		case key.Metadata.AgentID != "":
+			agentIDs = append(agentIDs, key.Metadata.AgentID)
+		}
+	}
+
+	agents := make(map[string]model.Agent, len(agentIDs))
+	if len(agentIDs) > 0 {
+		found, err := dl.FindAgentsByIDs(ctx, ak.bulker, agentIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, agent := range found {
+			agents[agent.Id] = agent
+		}
+	}
+	enrollKeys := map[string]bool{}
+	if len(enrollKeyIDs) > 0 {
+		var err error
+		if enrollKeys, err = dl.FindActiveEnrollmentAPIKeyIDs(ctx, ak.bulker, enrollKeyIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	before := now.Add(-ak.cfg.Retention)
+	var ids []string
+	for _, key := range keys {
+		switch {
+		case key.Metadata.Type == apikey.TypeEnroll.String():
+			if !enrollKeys[key.ID] {
+				ids = append(ids, key.ID)
+			}
+		case key.Metadata.AgentID != "":
+			agent, ok := agents[key.Metadata.AgentID]
+			if !ok || unenrolledBefore(agent, before) {
+				ids = append(ids, key.ID)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// unenrolledBefore returns true when the agent was unenrolled before the time.
+func unenrolledBefore(agent model.Agent, before time.Time) bool {
+	if agent.Active || agent.UnenrolledAt == "" {
+		return false
+	}
+	unenrolledAt, err := ftime.Parse(agent.UnenrolledAt)
+	return err == nil && unenrolledAt.Before(before)
+}
+
+// apiKeysQuery returns the query API keys request of the valid keys created by fleet server before the time, sorted by
+// creation, after the sort values of the previous page.
+func apiKeysQuery(before time.Time, size int, after []interface{}) ([]byte, error) {
+	req := map[string]interface{}{
+		"size": size,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"metadata.managed_by": apikey.ManagedByFleetServer}},
+					map[string]interface{}{"term": map[string]interface{}{"invalidated": false}},
+					map[string]interface{}{"range": map[string]interface{}{"creation": map[string]interface{}{"lte": before.UnixMilli()}}},
+				},
+			},
+		},
+		"sort": []interface{}{
+			map[string]interface{}{"creation": "asc"},
+			map[string]interface{}{"name": "asc"},
+		},
+	}
+	if len(after) > 0 {
+		req["search_after"] = after
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the query api keys request: %w", err)
+	}
+	return body, nil
+}
@@ -0,0 +1,164 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package gc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func fleetKey(id, agentID string, typ apikey.Type) bulk.APIKeyQueryHit {
+	return bulk.APIKeyQueryHit{
+		APIKeyMetadata: bulk.APIKeyMetadata{ID: id, Metadata: apikey.NewMetadata(agentID, "", typ)},
+		Sort:           []interface{}{id},
+	}
+}
+
+// apiKeysBulker returns the keys, the agents 1 to 3 and the active enrollment key of key-enroll-1.
+// agent-2 was unenrolled 2 days before now, agent-3 12 hours before now.
+func apiKeysBulker(keys ...[]bulk.APIKeyQueryHit) *ftesting.MockBulk {
+	bulker := ftesting.NewMockBulk()
+	for _, page := range keys {
+		bulker.On("APIKeyQuery", mock.Anything, mock.Anything).Return(page, nil).Once()
+	}
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		{ID: "agent-1", Source: []byte(`{"active":true}`)},
+		{ID: "agent-2", Source: []byte(`{"active":false,"unenrolled_at":"2024-03-30T12:00:00Z"}`)},
+		{ID: "agent-3", Source: []byte(`{"active":false,"unenrolled_at":"2024-04-01T00:00:00Z"}`)},
+	}}}, nil)
+	bulker.On("Search", mock.Anything, dl.FleetEnrollmentAPIKeys, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		{ID: "enroll-1", Source: []byte(`{"api_key_id":"key-enroll-1"}`)},
+	}}}, nil)
+	return bulker
+}
+
+func apiKeysTestCfg() config.APIKeysGC {
+	var cfg config.APIKeysGC
+	cfg.InitDefaults()
+	return cfg
+}
+
+func isLeader(context.Context) (bool, error) {
+	return true, nil
+}
+
+func TestAPIKeysOrphans(t *testing.T) {
+	now := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+
+	keys := []bulk.APIKeyQueryHit{
+		fleetKey("key-1", "agent-1", apikey.TypeAccess),
+		fleetKey("key-1-output", "agent-1", apikey.TypeOutput),
+		fleetKey("key-missing", "agent-missing", apikey.TypeAccess),
+		fleetKey("key-missing-output", "agent-missing", apikey.TypeOutput),
+		fleetKey("key-2", "agent-2", apikey.TypeAccess),
+		fleetKey("key-3", "agent-3", apikey.TypeAccess),
+		fleetKey("key-enroll-1", "", apikey.TypeEnroll),
+		fleetKey("key-enroll-2", "", apikey.TypeEnroll),
+	}
+	bulker := apiKeysBulker(keys)
+	bulker.On("APIKeyInvalidate", mock.Anything, mock.Anything).Return(nil)
+
+	ak := NewAPIKeys(bulker, apiKeysTestCfg(), 0, isLeader)
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	require.NoError(t, ak.run(ctx))
+
+	// the keys of the missing agent, of the agent unenrolled beyond the retention and of the revoked enrollment key
+	// are invalidated, agent-3 is unenrolled within the retention
+	bulker.AssertCalled(t, "APIKeyInvalidate", mock.Anything, []string{"key-missing", "key-missing-output", "key-2", "key-enroll-2"})
+	bulker.AssertNumberOfCalls(t, "APIKeyInvalidate", 1)
+	// the keys created within the last hour are not queried
+	bulker.AssertCalled(t, "APIKeyQuery", mock.Anything, mock.MatchedBy(func(body []byte) bool {
+		return bytes.Contains(body, []byte(`{"range":{"creation":{"lte":1711969200000}}}`)) &&
+			bytes.Contains(body, []byte(`{"term":{"metadata.managed_by":"fleet-server"}}`))
+	}))
+
+	reg := monitoring.NewRegistry()
+	ak.Register(reg)
+	snapshot := monitoring.CollectStructSnapshot(reg, monitoring.Full, false)
+	require.Equal(t, map[string]interface{}{"runs": int64(1), "checked": int64(8), "orphaned": int64(4), "invalidated": int64(4), "failed": int64(0)}, snapshot["api_keys"])
+}
+
+func TestAPIKeysBatches(t *testing.T) {
+	now := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+
+	errFailed := errors.New("failed")
+	cfg := apiKeysTestCfg()
+	cfg.BatchSize = 2
+	cfg.MaxBatches = 2
+	bulker := apiKeysBulker(
+		[]bulk.APIKeyQueryHit{fleetKey("key-missing", "agent-missing", apikey.TypeAccess), fleetKey("key-1", "agent-1", apikey.TypeAccess)},
+		[]bulk.APIKeyQueryHit{fleetKey("key-2", "agent-2", apikey.TypeAccess), fleetKey("key-3", "agent-3", apikey.TypeAccess)},
+	)
+	bulker.On("APIKeyInvalidate", mock.Anything, []string{"key-missing"}).Return(errFailed)
+	bulker.On("APIKeyInvalidate", mock.Anything, []string{"key-2"}).Return(nil)
+
+	ak := NewAPIKeys(bulker, cfg, 0, isLeader)
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	require.NoError(t, ak.run(ctx))
+
+	// the run stops after max_batches, the next page is requested after the last key of the previous page
+	bulker.AssertNumberOfCalls(t, "APIKeyQuery", 2)
+	bulker.AssertCalled(t, "APIKeyQuery", mock.Anything, mock.MatchedBy(func(body []byte) bool {
+		return bytes.Contains(body, []byte(`"search_after":["key-1"]`))
+	}))
+	require.Equal(t, int64(1), ak.failed.Load())
+	require.Equal(t, int64(1), ak.invalidated.Load())
+}
+
+func TestAPIKeysDryRun(t *testing.T) {
+	cfg := apiKeysTestCfg()
+	cfg.DryRun = true
+	bulker := apiKeysBulker([]bulk.APIKeyQueryHit{fleetKey("key-missing", "agent-missing", apikey.TypeAccess)})
+
+	ak := NewAPIKeys(bulker, cfg, 0, isLeader)
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	require.NoError(t, ak.run(ctx))
+
+	// the orphaned keys are only counted
+	bulker.AssertNotCalled(t, "APIKeyInvalidate", mock.Anything, mock.Anything)
+	require.Equal(t, int64(1), ak.orphaned.Load())
+	require.Equal(t, int64(0), ak.invalidated.Load())
+}
+
+func TestAPIKeysNotLeader(t *testing.T) {
+	bulker := ftesting.NewMockBulk()
+	ak := NewAPIKeys(bulker, apiKeysTestCfg(), 0, func(context.Context) (bool, error) {
+		return false, nil
+	})
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	require.NoError(t, ak.run(ctx))
+
+	require.Empty(t, bulker.Calls)
+	require.Equal(t, int64(0), ak.runs.Load())
+}
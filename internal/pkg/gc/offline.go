@@ -0,0 +1,90 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/operation"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+)
+
+const (
+	maxOfflineAgentsFetchSize = 1000
+
+	// offlineAgentsOperation is the ID of the operation marking the agents offline. It is shared by the instances, the
+	// sweep is run by the leader of the fleet servers and a new leader resumes it.
+	offlineAgentsOperation = "offline-agents"
+	filterBefore           = "before"
+)
+
+func getOfflineAgentsFunc(bulker bulk.Bulk, ops *operation.Tracker, leader func(context.Context) (bool, error), offlineTimeout time.Duration) scheduler.WorkFunc {
+	return func(ctx context.Context) error {
+		if ok, err := checkLeader(ctx, leader); !ok {
+			return err
+		}
+		return markOfflineAgents(ctx, bulker, ops, offlineTimeout)
+	}
+}
+
+// markOfflineAgents moves the online agents that did not check in for more than offlineTimeout to the offline state.
+// The next checkin of an agent moves it back online.
+// The agents are read in pages of the operation tracked by ops, an interrupted sweep is resumed from its last page.
+// An agent that fails is counted and retried on the next sweep, the other agents are still marked.
+func markOfflineAgents(ctx context.Context, bulker bulk.Bulk, ops *operation.Tracker, offlineTimeout time.Duration) error {
+	before := timeNow().UTC().Add(-offlineTimeout)
+	log := zerolog.Ctx(ctx).With().Str("ctx", "fleet offline agents").Logger()
+	ctx = log.WithContext(ctx)
+
+	body, err := bulk.UpdateFields{
+		dl.FieldLifecycleState: model.AgentStateOffline,
+	}.Marshal()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	_, err = ops.Run(ctx, operation.Operation{
+		ID:     offlineAgentsOperation,
+		Type:   "offline_agents",
+		Filter: map[string]string{filterBefore: ftime.Format(before)},
+	}, func(ctx context.Context, op *operation.Operation) (operation.Page, error) {
+		// a resumed sweep keeps the time it started with
+		before, err := ftime.Parse(op.Filter[filterBefore])
+		if err != nil {
+			return operation.Page{}, err
+		}
+		agents, err := dl.FindOfflineAgents(ctx, bulker, before, op.Cursor, maxOfflineAgentsFetchSize)
+		if err != nil {
+			log.Debug().Err(err).Time("before", before).Msg("failed to find offline agents")
+			return operation.Page{}, err
+		}
+		page := operation.Page{Cursor: op.Cursor, Done: len(agents) < maxOfflineAgentsFetchSize}
+		for _, agent := range agents {
+			page.Cursor = agent.Id
+			if err := dl.CheckAgentTransition(ctx, agent.Id, agent.State(), model.AgentStateOffline); err != nil {
+				continue
+			}
+			if err := bulker.Update(ctx, dl.FleetAgents, agent.Id, body, bulk.WithRetryOnConflict(3)); err != nil {
+				log.Debug().Err(err).Str(logger.AgentID, agent.Id).Msg("failed to mark agent offline")
+				errs = append(errs, err)
+				page.Failed++
+				continue
+			}
+			page.Succeeded++
+			log.Debug().Str(logger.AgentID, agent.Id).Msg("agent marked offline")
+		}
+		return page, nil
+	})
+	return errors.Join(append(errs, err)...)
+}
@@ -0,0 +1,148 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/operation"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestMarkOfflineAgents(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.MatchedBy(func(body []byte) bool {
+		var query struct {
+			Query struct {
+				Bool struct {
+					Filter []map[string]map[string]interface{} `json:"filter"`
+				} `json:"bool"`
+			} `json:"query"`
+		}
+		if err := json.Unmarshal(body, &query); err != nil || len(query.Query.Bool.Filter) != 4 {
+			return false
+		}
+		lastCheckin, _ := query.Query.Bool.Filter[2]["range"][dl.FieldLastCheckin].(map[string]interface{})
+		return query.Query.Bool.Filter[0]["term"][dl.FieldLifecycleState] == string(model.AgentStateOnline) && lastCheckin["lte"] == "2024-01-01T11:50:00.000Z" &&
+			searchAfter(body) == ""
+	}), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		{ID: "agent-1", Source: []byte(`{"active":true,"lifecycle_state":"online","last_checkin":"2024-01-01T11:00:00Z"}`)},
+		// unenrolled by Kibana meanwhile, not moved back to offline
+		{ID: "agent-2", Source: []byte(`{"active":false,"lifecycle_state":"online","unenrolled_at":"2024-01-01T11:55:00Z"}`)},
+	}}}, nil)
+
+	var marked []string
+	bulker.On("Update", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var body struct {
+			Doc map[string]interface{} `json:"doc"`
+		}
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &body))
+		require.Equal(t, map[string]interface{}{dl.FieldLifecycleState: string(model.AgentStateOffline)}, body.Doc)
+		marked = append(marked, args.String(2))
+	}).Return(nil)
+
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	require.NoError(t, markOfflineAgents(ctx, bulker, operation.NewTracker(operation.NewMemoryStore()), 10*time.Minute))
+	require.Equal(t, []string{"agent-1"}, marked)
+}
+
+// searchAfter returns the agent ID the agents of the search body start after.
+func searchAfter(body []byte) string {
+	var query struct {
+		Query struct {
+			Bool struct {
+				Filter []struct {
+					Range map[string]struct {
+						GT string `json:"gt"`
+					} `json:"range"`
+				} `json:"filter"`
+			} `json:"bool"`
+		} `json:"query"`
+	}
+	if err := json.Unmarshal(body, &query); err != nil {
+		return "invalid"
+	}
+	for _, filter := range query.Query.Bool.Filter {
+		if r, ok := filter.Range[dl.FieldAgentDocID]; ok {
+			return r.GT
+		}
+	}
+	return "invalid"
+}
+
+func TestMarkOfflineAgentsResume(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+
+	// a full page of agents, and the agents after it
+	hits := make([]es.HitT, maxOfflineAgentsFetchSize+5)
+	for i := range hits {
+		hits[i] = es.HitT{ID: fmt.Sprintf("agent-%04d", i), Source: []byte(`{"active":true,"lifecycle_state":"online"}`)}
+	}
+	errKilled := errors.New("killed")
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.MatchedBy(func(body []byte) bool {
+		return searchAfter(body) == ""
+	}), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: hits[:maxOfflineAgentsFetchSize]}}, nil).Once()
+	// the sweep is interrupted after the first page
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.MatchedBy(func(body []byte) bool {
+		return searchAfter(body) == "agent-0999"
+	}), mock.Anything).Return((*es.ResultT)(nil), errKilled).Once()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.MatchedBy(func(body []byte) bool {
+		return searchAfter(body) == "agent-0999"
+	}), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: hits[maxOfflineAgentsFetchSize:]}}, nil).Once()
+	marked := map[string]int{}
+	bulker.On("Update", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		marked[args.String(2)]++
+	}).Return(nil)
+
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	store := operation.NewMemoryStore()
+	require.ErrorIs(t, markOfflineAgents(ctx, bulker, operation.NewTracker(store), 10*time.Minute), errKilled)
+	require.Len(t, marked, maxOfflineAgentsFetchSize)
+
+	// the next sweep, of another instance, resumes after the last page with the time the sweep started with
+	now = now.Add(time.Hour)
+	ops := operation.NewTracker(store)
+	require.NoError(t, markOfflineAgents(ctx, bulker, ops, 10*time.Minute))
+	require.Len(t, marked, len(hits))
+	for id, n := range marked {
+		require.Equal(t, 1, n, "agent %s marked more than once", id)
+	}
+	op, err := ops.Get(ctx, offlineAgentsOperation)
+	require.NoError(t, err)
+	require.Equal(t, operation.StatusCompleted, op.Status)
+	require.Equal(t, len(hits), op.Succeeded)
+	require.Equal(t, 1, op.Resumed)
+	require.Equal(t, "2024-01-01T11:50:00.000Z", op.Filter[filterBefore])
+	bulker.AssertExpectations(t)
+}
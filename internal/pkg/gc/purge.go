@@ -0,0 +1,57 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+)
+
+const maxPurgeableAgentsFetchSize = 100
+
+func getPurgeAgentsFunc(bulker bulk.Bulk, retention time.Duration) scheduler.WorkFunc {
+	return func(ctx context.Context) error {
+		return purgeUnenrolledAgents(ctx, bulker, retention)
+	}
+}
+
+// purgeUnenrolledAgents replaces the documents of the agents unenrolled for more than retention with their tombstones.
+// The agents with API keys that remain to be invalidated are kept until the keys are invalidated.
+// An agent that enrolled again since it was read is not purged, the agents that fail to be deleted are retried on the
+// next run.
+func purgeUnenrolledAgents(ctx context.Context, bulker bulk.Bulk, retention time.Duration) error {
+	now := timeNow().UTC()
+	before := now.Add(-retention)
+	log := zerolog.Ctx(ctx).With().Str("ctx", "fleet purge unenrolled agents").Time("before", before).Logger()
+	ctx = log.WithContext(ctx)
+
+	agents, err := dl.FindPurgeableAgents(ctx, bulker, before, maxPurgeableAgentsFetchSize)
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to find unenrolled agents to purge")
+		return err
+	}
+	var errs []error
+	for _, agent := range agents {
+		if err := dl.PurgeAgent(ctx, bulker, &agent, now); err != nil {
+			if errors.Is(err, dl.ErrAgentChanged) {
+				log.Debug().Err(err).Str(logger.AgentID, agent.Id).Msg("agent changed, not purged")
+				continue
+			}
+			log.Warn().Err(err).Str(logger.AgentID, agent.Id).Msg("failed to purge unenrolled agent")
+			errs = append(errs, err)
+			continue
+		}
+		log.Info().Str(logger.AgentID, agent.Id).Str("unenrolled_at", agent.UnenrolledAt).Msg("unenrolled agent purged")
+	}
+	return errors.Join(errs...)
+}
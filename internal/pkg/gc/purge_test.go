@@ -0,0 +1,125 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package gc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/operation"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestPurgeUnenrolledAgents(t *testing.T) {
+	now := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+	retention := 90 * 24 * time.Hour
+	errFailed := errors.New("failed")
+
+	bulker := ftesting.NewMockBulk()
+	// the agents unenrolled at the cutoff are purged
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.MatchedBy(func(body []byte) bool {
+		return bytes.Contains(body, []byte(`{"range":{"unenrolled_at":{"lte":"2024-01-02T12:00:00.000Z"}}}`))
+	}), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		{ID: "agent-1", SeqNo: 5, PrimaryTerm: 1, Source: []byte(`{"active":false,"policy_id":"policy-1","enrolled_at":"2023-06-01T00:00:00Z","unenrolled_at":"2024-01-02T12:00:00Z","unenrolled_reason":"manual","access_api_key_id":"key-1","local_metadata":{"host":{"hostname":"test"}}}`)},
+		{ID: "agent-2", SeqNo: 7, PrimaryTerm: 1, Source: []byte(`{"active":false,"unenrolled_at":"2023-12-01T00:00:00Z"}`)},
+		{ID: "agent-3", SeqNo: 9, PrimaryTerm: 1, Source: []byte(`{"active":false,"unenrolled_at":"2023-11-01T00:00:00Z"}`)},
+		{ID: "agent-4", SeqNo: 11, PrimaryTerm: 1, Source: []byte(`{"active":false,"unenrolled_at":"2023-10-01T00:00:00Z","unenrolled_reason":"timeout"}`)},
+	}}}, nil).Once()
+
+	tombstones := map[string][]byte{}
+	bulker.On("Create", mock.Anything, dl.FleetAgentTombstones, "agent-3:2023-11-01T00:00:00Z", mock.Anything, mock.Anything).Return("", errFailed).Once()
+	// the tombstone of agent-4 was written by another instance that purged the same agent
+	bulker.On("Create", mock.Anything, dl.FleetAgentTombstones, "agent-4:2023-10-01T00:00:00Z", mock.Anything, mock.Anything).Return("", es.ErrElasticVersionConflict).Once()
+	bulker.On("Create", mock.Anything, dl.FleetAgentTombstones, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		tombstones[args.String(2)] = args.Get(3).([]byte)
+	}).Return("", nil)
+
+	// agent-2 enrolled again with the same ID since it was read
+	bulker.On("Delete", mock.Anything, dl.FleetAgents, "agent-2", mock.Anything).Return(es.ErrElasticVersionConflict).Once()
+	bulker.On("Delete", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(nil)
+
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	require.ErrorIs(t, purgeUnenrolledAgents(ctx, bulker, retention), errFailed)
+
+	require.Len(t, tombstones, 1)
+	assert.JSONEq(t, `{
+		"@timestamp": "2024-04-01T12:00:00.000Z",
+		"agent_id": "agent-1",
+		"policy_id": "policy-1",
+		"enrolled_at": "2023-06-01T00:00:00Z",
+		"unenrolled_at": "2024-01-02T12:00:00Z",
+		"unenrolled_reason": "manual"
+	}`, string(tombstones["agent-1:2024-01-02T12:00:00Z"]))
+	// the agent that enrolled again is not recorded as purged
+	assert.NotContains(t, tombstones, "agent-2:2023-12-01T00:00:00Z")
+
+	bulker.AssertExpectations(t)
+	bulker.AssertCalled(t, "Delete", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything)
+	bulker.AssertCalled(t, "Delete", mock.Anything, dl.FleetAgents, "agent-3", mock.Anything)
+	bulker.AssertCalled(t, "Delete", mock.Anything, dl.FleetAgents, "agent-4", mock.Anything)
+}
+
+func TestPurgeReenrolledAgent(t *testing.T) {
+	now := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+	retention := 90 * 24 * time.Hour
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+	bulker := ftesting.NewMockBulk()
+	// the purge reads agent-1 as it enrolls again with the same ID
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		{ID: "agent-1", SeqNo: 5, PrimaryTerm: 1, Source: []byte(`{"active":false,"unenrolled_at":"2023-12-01T00:00:00Z"}`)},
+	}}}, nil).Once()
+	bulker.On("Delete", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything).Return(es.ErrElasticVersionConflict).Once()
+	require.NoError(t, purgeUnenrolledAgents(ctx, bulker, retention))
+	bulker.AssertNotCalled(t, "Create", mock.Anything, dl.FleetAgentTombstones, mock.Anything, mock.Anything, mock.Anything)
+
+	// the enrolled agent is unenrolled again and purged with a tombstone of its last unenrollment
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		{ID: "agent-1", SeqNo: 12, PrimaryTerm: 1, Source: []byte(`{"active":false,"enrolled_at":"2023-12-01T00:01:00Z","unenrolled_at":"2023-12-15T00:00:00Z"}`)},
+	}}}, nil).Once()
+	bulker.On("Delete", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything).Return(nil).Once()
+	bulker.On("Create", mock.Anything, dl.FleetAgentTombstones, "agent-1:2023-12-15T00:00:00Z", mock.Anything, mock.Anything).Return("", nil).Once()
+	require.NoError(t, purgeUnenrolledAgents(ctx, bulker, retention))
+
+	bulker.AssertExpectations(t)
+	bulker.AssertNumberOfCalls(t, "Create", 1)
+}
+
+func TestPurgeUnenrolledAgentsSchedule(t *testing.T) {
+	names := func(retention time.Duration) []string {
+		var names []string
+		for _, s := range Schedules(ftesting.NewMockBulk(), operation.NewTracker(operation.NewMemoryStore()), nil, time.Hour, "", 0, 0, 0, retention, func() config.Actions { return config.Actions{} }) {
+			names = append(names, s.Name)
+		}
+		return names
+	}
+	assert.NotContains(t, names(0), "fleet purge unenrolled agents")
+	assert.Contains(t, names(90*24*time.Hour), "fleet purge unenrolled agents")
+}
@@ -5,19 +5,34 @@
 package gc
 
 import (
+	"context"
 	"time"
 
+	"github.com/rs/zerolog"
+
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/file/uploader"
+	"github.com/elastic/fleet-server/v7/internal/pkg/operation"
 	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
 )
 
 const (
 	defaultScheduleInterval            = time.Hour
 	defaultCleanupIntervalAfterExpired = "30d" // cleanup with expiration older than 30 days from now
+	scheduleJitter                     = 0.2   // spreads the schedules of the instances started together
 )
 
 // Schedules returns the GC schedules
-func Schedules(bulker bulk.Bulk, scheduleInterval time.Duration, cleanupIntervalAfterExpired string) []scheduler.Schedule {
+// The stale upgrades are cleared when upgradeTimeout is set, the agents are marked offline when offlineTimeout is set.
+// The partially completed unenrollments are completed, and the agents are unenrolled after unenrollTimeout when it is set.
+// An expired result is recorded for the actions without an expiration once they are older than their TTL in the
+// configuration returned by actionTTL, it is read on each run so reloaded TTLs apply.
+// The agents unenrolled for more than unenrolledRetention are purged when it is set.
+// The progress of the sweeps updating many agents is tracked by ops, so an interrupted sweep is resumed. The operations
+// of the sweeps are shared by the instances, they are run when leader reports the instance as the leader of the fleet
+// servers.
+func Schedules(bulker bulk.Bulk, ops *operation.Tracker, leader func(context.Context) (bool, error), scheduleInterval time.Duration, cleanupIntervalAfterExpired string, upgradeTimeout, offlineTimeout, unenrollTimeout, unenrolledRetention time.Duration, actionTTL func() config.Actions) []scheduler.Schedule {
 	if scheduleInterval == 0 {
 		scheduleInterval = defaultScheduleInterval
 	}
@@ -25,11 +40,67 @@ func Schedules(bulker bulk.Bulk, scheduleInterval time.Duration, cleanupInterval
 		cleanupIntervalAfterExpired = defaultCleanupIntervalAfterExpired
 	}
 
-	return []scheduler.Schedule{
+	schedules := []scheduler.Schedule{
 		{
 			Name:     "fleet actions cleanup",
 			Interval: scheduleInterval,
 			WorkFn:   getActionsGCFunc(bulker, cleanupIntervalAfterExpired),
 		},
+		{
+			Name:     "fleet expired actions results",
+			Interval: scheduleInterval,
+			WorkFn:   getExpiredActionsFunc(bulker, scheduleInterval, actionTTL),
+		},
+		{
+			Name:     "fleet expired uploads",
+			Interval: scheduleInterval,
+			WorkFn:   getExpiredUploadsFunc(bulker, uploader.DefaultTimeLimit),
+		},
+		{
+			Name:     "fleet unenrollments",
+			Interval: scheduleInterval,
+			WorkFn:   getUnenrollmentsFunc(bulker, ops, leader, unenrollTimeout),
+		},
+	}
+	if upgradeTimeout > 0 {
+		schedules = append(schedules, scheduler.Schedule{
+			Name:     "fleet stale upgrades",
+			Interval: scheduleInterval,
+			WorkFn:   getStaleUpgradesFunc(bulker, upgradeTimeout),
+		})
+	}
+	if offlineTimeout > 0 {
+		// The agents are swept more often than the other schedules so the offline state is not late by an hour,
+		// and on start for the agents that went offline while the fleet servers were down.
+		schedules = append(schedules, scheduler.Schedule{
+			Name:       "fleet offline agents",
+			Interval:   min(scheduleInterval, offlineTimeout),
+			RunOnStart: true,
+			WorkFn:     getOfflineAgentsFunc(bulker, ops, leader, offlineTimeout),
+		})
+	}
+	if unenrolledRetention > 0 {
+		schedules = append(schedules, scheduler.Schedule{
+			Name:     "fleet purge unenrolled agents",
+			Interval: scheduleInterval,
+			WorkFn:   getPurgeAgentsFunc(bulker, unenrolledRetention),
+		})
+	}
+	for i := range schedules {
+		schedules[i].Jitter = scheduleJitter
+	}
+	return schedules
+}
+
+// checkLeader returns true if leader reports the instance as the leader of the fleet servers.
+func checkLeader(ctx context.Context, leader func(context.Context) (bool, error)) (bool, error) {
+	ok, err := leader(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Err(err).Msg("failed to find the leader fleet server")
+		return false, err
+	}
+	if !ok {
+		zerolog.Ctx(ctx).Debug().Msg("not the leader fleet server, skipping")
 	}
+	return ok, nil
 }
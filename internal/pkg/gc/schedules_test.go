@@ -0,0 +1,66 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package gc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/operation"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestSchedulesSweepsRunByLeader(t *testing.T) {
+	notLeader := func(context.Context) (bool, error) {
+		return false, nil
+	}
+	errLeader := errors.New("leader search failed")
+	failed := func(context.Context) (bool, error) {
+		return false, errLeader
+	}
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	ops := operation.NewTracker(operation.NewMemoryStore())
+
+	t.Run("offline agents", func(t *testing.T) {
+		bulker := ftesting.NewMockBulk()
+		require.NoError(t, getOfflineAgentsFunc(bulker, ops, notLeader, time.Minute)(ctx))
+		require.ErrorIs(t, getOfflineAgentsFunc(bulker, ops, failed, time.Minute)(ctx), errLeader)
+		bulker.AssertNotCalled(t, "Search", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("unenrollments", func(t *testing.T) {
+		// the pending API keys are still invalidated, the stale unenrollments are not searched
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Search", mock.Anything, dl.FleetAgents, mock.MatchedBy(func(body []byte) bool {
+			return bytes.Contains(body, []byte(dl.FieldAPIKeysInvalidationPending))
+		}), mock.Anything).Return(&es.ResultT{}, nil).Once()
+		require.NoError(t, getUnenrollmentsFunc(bulker, ops, notLeader, time.Hour)(ctx))
+		require.ErrorIs(t, getUnenrollmentsFunc(bulker, ops, failed, time.Hour)(ctx), errLeader)
+		bulker.AssertExpectations(t)
+	})
+}
+
+func TestSchedulesJitter(t *testing.T) {
+	ops := operation.NewTracker(operation.NewMemoryStore())
+	schedules := Schedules(ftesting.NewMockBulk(), ops, nil, time.Hour, "", time.Hour, 5*time.Minute, time.Hour, time.Hour, func() config.Actions { return config.Actions{} })
+	require.Len(t, schedules, 7)
+	for _, s := range schedules {
+		require.Equal(t, scheduleJitter, s.Jitter, s.Name)
+		// only the offline agents are swept on start, the other schedules wait for the first run delay
+		require.Equal(t, s.Name == "fleet offline agents", s.RunOnStart, s.Name)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/operation"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+	"github.com/elastic/fleet-server/v7/internal/pkg/unenroll"
+)
+
+const (
+	maxUnenrollmentsFetchSize = 100
+
+	// staleUnenrollmentsOperation is the ID of the operation unenrolling the agents after the unenroll timeout. It is
+	// shared by the instances, the operation is run by the leader of the fleet servers and a new leader resumes it.
+	staleUnenrollmentsOperation = "stale-unenrollments"
+)
+
+func getUnenrollmentsFunc(bulker bulk.Bulk, ops *operation.Tracker, leader func(context.Context) (bool, error), unenrollTimeout time.Duration) scheduler.WorkFunc {
+	return func(ctx context.Context) error {
+		// the pending API keys are invalidated by every instance, the stale unenrollments only by the leader
+		timeout := unenrollTimeout
+		if timeout > 0 {
+			ok, err := checkLeader(ctx, leader)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				timeout = 0
+			}
+		}
+		return reconcileUnenrollments(ctx, bulker, ops, timeout)
+	}
+}
+
+// reconcileUnenrollments completes the unenrollments that were left partially completed.
+// The API keys of the unenrolled agents that remain pending are invalidated, and when unenrollTimeout is set
+// the agents that did not ack their UNENROLL action within unenrollTimeout are unenrolled.
+// An agent that fails is retried on the next run, the other agents are still reconciled.
+// The agents past the timeout are read in pages of the operation tracked by ops, an interrupted run is resumed from
+// its last page.
+func reconcileUnenrollments(ctx context.Context, bulker bulk.Bulk, ops *operation.Tracker, unenrollTimeout time.Duration) error {
+	log := zerolog.Ctx(ctx).With().Str("ctx", "fleet unenrollments").Logger()
+	ctx = log.WithContext(ctx)
+
+	var errs []error
+	agents, err := dl.FindPendingKeyInvalidations(ctx, bulker, maxUnenrollmentsFetchSize)
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to find pending API keys invalidations")
+		return err
+	}
+	for _, agent := range agents {
+		if err := unenroll.InvalidatePending(ctx, bulker, &agent); err != nil {
+			log.Warn().Err(err).Str(logger.AgentID, agent.Id).Msg("failed to invalidate pending API keys")
+			errs = append(errs, err)
+			continue
+		}
+		log.Info().Str(logger.AgentID, agent.Id).Msg("pending API keys invalidated")
+	}
+
+	if unenrollTimeout <= 0 {
+		return errors.Join(errs...)
+	}
+	before := timeNow().UTC().Add(-unenrollTimeout)
+	_, err = ops.Run(ctx, operation.Operation{
+		ID:     staleUnenrollmentsOperation,
+		Type:   "stale_unenrollments",
+		Filter: map[string]string{filterBefore: ftime.Format(before)},
+	}, func(ctx context.Context, op *operation.Operation) (operation.Page, error) {
+		// a resumed run keeps the time it started with
+		before, err := ftime.Parse(op.Filter[filterBefore])
+		if err != nil {
+			return operation.Page{}, err
+		}
+		agents, err := dl.FindStaleUnenrollments(ctx, bulker, before, op.Cursor, maxUnenrollmentsFetchSize)
+		if err != nil {
+			log.Debug().Err(err).Time("before", before).Msg("failed to find stale unenrollments")
+			return operation.Page{}, err
+		}
+		page := operation.Page{Cursor: op.Cursor, Done: len(agents) < maxUnenrollmentsFetchSize}
+		for _, agent := range agents {
+			page.Cursor = agent.Id
+			if err := dl.CheckAgentTransition(ctx, agent.Id, agent.State(), model.AgentStateUnenrolled); err != nil {
+				continue
+			}
+			if err := unenroll.Unenroll(ctx, bulker, &agent, unenroll.ReasonTimeout); err != nil {
+				log.Warn().Err(err).Str(logger.AgentID, agent.Id).Msg("failed to unenroll agent")
+				errs = append(errs, err)
+				page.Failed++
+				continue
+			}
+			page.Succeeded++
+			log.Info().Str(logger.AgentID, agent.Id).Msg("agent unenrolled after unenroll timeout")
+		}
+		return page, nil
+	})
+	return errors.Join(append(errs, err)...)
+}
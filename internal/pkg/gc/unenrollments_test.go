@@ -0,0 +1,90 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package gc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/operation"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestReconcileUnenrollments(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+	errFailed := errors.New("failed")
+
+	// the agents with keys that remain pending, cleared once the invalidation is recorded
+	pending := map[string]bool{"agent-1": true, "agent-2": true}
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.MatchedBy(func(body []byte) bool {
+		return bytes.Contains(body, []byte(dl.FieldAPIKeysInvalidationPending))
+	}), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		{ID: "agent-1", Source: []byte(`{"active":false,"access_api_key_id":"key-1","api_keys_invalidation_pending":true}`)},
+		{ID: "agent-2", Source: []byte(`{"active":false,"access_api_key_id":"key-2","api_keys_invalidation_pending":true}`)},
+	}}}, nil).Once()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.MatchedBy(func(body []byte) bool {
+		return bytes.Contains(body, []byte(dl.FieldAPIKeysInvalidationPending))
+	}), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		{ID: "agent-1", Source: []byte(`{"active":false,"access_api_key_id":"key-1","api_keys_invalidation_pending":true}`)},
+	}}}, nil).Once()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.MatchedBy(func(body []byte) bool {
+		return bytes.Contains(body, []byte(dl.FieldUnenrollmentStartedAt))
+	}), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{
+		{ID: "agent-3", Source: []byte(`{"active":true,"access_api_key_id":"key-3","unenrollment_started_at":"2024-01-01T10:00:00Z"}`)},
+	}}}, nil).Once()
+
+	// the invalidation of the keys of agent-1 fails on the first run
+	bulker.On("APIKeyInvalidate", mock.Anything, []string{"key-1"}).Return(errFailed).Once()
+	bulker.On("APIKeyInvalidate", mock.Anything, mock.Anything).Return(nil)
+	docs := map[string][]map[string]interface{}{}
+	bulker.On("Update", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var body struct {
+			Doc map[string]interface{} `json:"doc"`
+		}
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &body))
+		id := args.String(2)
+		docs[id] = append(docs[id], body.Doc)
+		if _, ok := body.Doc[dl.FieldAPIKeysInvalidatedAt]; ok {
+			delete(pending, id)
+		}
+	}).Return(nil)
+
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	ops := operation.NewTracker(operation.NewMemoryStore())
+	require.ErrorIs(t, reconcileUnenrollments(ctx, bulker, ops, 0), errFailed)
+	assert.Equal(t, map[string]bool{"agent-1": true}, pending)
+
+	require.NoError(t, reconcileUnenrollments(ctx, bulker, ops, time.Hour))
+	assert.Empty(t, pending)
+
+	// agent-3 did not ack the UNENROLL action within the timeout
+	require.Len(t, docs["agent-3"], 2)
+	assert.Equal(t, false, docs["agent-3"][0][dl.FieldActive])
+	assert.Equal(t, "timeout", docs["agent-3"][0][dl.FieldUnenrolledReason])
+	assert.Equal(t, true, docs["agent-3"][0][dl.FieldAPIKeysInvalidationPending])
+	assert.NotEmpty(t, docs["agent-3"][1][dl.FieldAPIKeysInvalidatedAt])
+	bulker.AssertExpectations(t)
+	bulker.AssertCalled(t, "APIKeyInvalidate", mock.Anything, []string{"key-3"})
+}
@@ -0,0 +1,53 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package gc
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+)
+
+const maxStaleUpgradesFetchSize = 100
+
+func getStaleUpgradesFunc(bulker bulk.Bulk, upgradeTimeout time.Duration) scheduler.WorkFunc {
+	return func(ctx context.Context) error {
+		return clearStaleUpgrades(ctx, bulker, upgradeTimeout)
+	}
+}
+
+// clearStaleUpgrades clears the started status of the agent upgrades that started more than upgradeTimeout ago.
+// The agent never acked the UPGRADE action nor reported upgrade details, the upgrade can be started again from Kibana.
+func clearStaleUpgrades(ctx context.Context, bulker bulk.Bulk, upgradeTimeout time.Duration) error {
+	before := timeNow().UTC().Add(-upgradeTimeout)
+	log := zerolog.Ctx(ctx).With().Str("ctx", "fleet stale upgrades").Time("before", before).Logger()
+
+	ids, err := dl.FindStaleUpgrades(ctx, bulker, before, maxStaleUpgradesFetchSize)
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to find stale upgrades")
+		return err
+	}
+	for _, id := range ids {
+		body, err := bulk.UpdateFields{
+			dl.FieldUpgradeStartedAt: nil,
+			dl.FieldUpgradeStatus:    nil,
+		}.Marshal()
+		if err != nil {
+			return err
+		}
+		if err := bulker.Update(ctx, dl.FleetAgents, id, body, bulk.WithRetryOnConflict(3)); err != nil {
+			log.Debug().Err(err).Str(logger.AgentID, id).Msg("failed to clear stale upgrade")
+			return err
+		}
+		log.Info().Str(logger.AgentID, id).Msg("stale upgrade cleared")
+	}
+	return nil
+}
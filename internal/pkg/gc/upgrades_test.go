@@ -0,0 +1,63 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func TestClearStaleUpgrades(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetAgents, mock.MatchedBy(func(body []byte) bool {
+		var query struct {
+			Query struct {
+				Bool struct {
+					Filter []map[string]map[string]interface{} `json:"filter"`
+				} `json:"bool"`
+			} `json:"query"`
+		}
+		if err := json.Unmarshal(body, &query); err != nil || len(query.Query.Bool.Filter) != 2 {
+			return false
+		}
+		startedAt, _ := query.Query.Bool.Filter[1]["range"][dl.FieldUpgradeStartedAt].(map[string]interface{})
+		return query.Query.Bool.Filter[0]["term"][dl.FieldUpgradeStatus] == dl.UpgradeStatusStarted && startedAt["lte"] == "2024-01-01T10:00:00.000Z"
+	}), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "agent-1"}, {ID: "agent-2"}}}}, nil)
+
+	var cleared []string
+	bulker.On("Update", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var body struct {
+			Doc map[string]interface{} `json:"doc"`
+		}
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &body))
+		require.Equal(t, map[string]interface{}{dl.FieldUpgradeStartedAt: nil, dl.FieldUpgradeStatus: nil}, body.Doc)
+		cleared = append(cleared, args.String(2))
+	}).Return(nil)
+
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	require.NoError(t, clearStaleUpgrades(ctx, bulker, 2*time.Hour))
+	bulker.AssertExpectations(t)
+	require.Equal(t, []string{"agent-1", "agent-2"}, cleared)
+}
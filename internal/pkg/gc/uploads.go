@@ -0,0 +1,57 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package gc
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/file"
+	"github.com/elastic/fleet-server/v7/internal/pkg/file/uploader"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+)
+
+const maxExpiredUploadsFetchSize = 100
+
+func getExpiredUploadsFunc(bulker bulk.Bulk, uploadTimeout time.Duration) scheduler.WorkFunc {
+	return func(ctx context.Context) error {
+		return expireUploads(ctx, bulker, uploadTimeout)
+	}
+}
+
+// expireUploads marks the uploads that started more than uploadTimeout ago and were never completed as failed,
+// and deletes the chunks that were uploaded. The uploads no longer accept chunks, nor count towards the agent limits.
+func expireUploads(ctx context.Context, bulker bulk.Bulk, uploadTimeout time.Duration) error {
+	before := timeNow().UTC().Add(-uploadTimeout)
+	log := zerolog.Ctx(ctx).With().Str("ctx", "fleet expired uploads").Time("before", before).Logger()
+
+	infos, err := file.FindExpiredUploads(ctx, bulker, uploader.UploadHeaderIndexPattern, before, maxExpiredUploadsFetchSize)
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to find expired uploads")
+		return err
+	}
+	for _, info := range infos {
+		body, err := bulk.UpdateFields{
+			"file": map[string]interface{}{
+				"Status": file.StatusFail,
+			},
+		}.Marshal()
+		if err != nil {
+			return err
+		}
+		if err := bulker.Update(ctx, info.Index, info.DocID, body, bulk.WithRetryOnConflict(3)); err != nil {
+			log.Debug().Err(err).Str("fileID", info.DocID).Str("uploadID", info.ID).Msg("failed to mark upload expired")
+			return err
+		}
+		if err := uploader.DeleteAllChunksForFile(ctx, bulker, info.Source, info.DocID); err != nil {
+			log.Warn().Err(err).Str("fileID", info.DocID).Str("uploadID", info.ID).Msg("upload expired, but encountered an error deleting left-behind chunk data")
+		}
+		log.Info().Str("fileID", info.DocID).Str("uploadID", info.ID).Msg("upload expired")
+	}
+	return nil
+}
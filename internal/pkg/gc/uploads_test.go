@@ -0,0 +1,105 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/file"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestExpireUploads(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+
+	source, err := json.Marshal(map[string]interface{}{
+		"action_id": "action-1",
+		"agent_id":  "agent-1",
+		"src":       "agent",
+		"file": map[string]interface{}{
+			"size":      100,
+			"ChunkSize": file.MaxChunkSize,
+			"Status":    file.StatusProgress,
+		},
+		"upload_id":    "upload-1",
+		"upload_start": now.Add(-25 * time.Hour).UnixMilli(),
+	})
+	require.NoError(t, err)
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, ".fleet-fileds-fromhost-meta-*", mock.MatchedBy(func(body []byte) bool {
+		var query struct {
+			Query struct {
+				Bool struct {
+					Filter []map[string]map[string]interface{} `json:"filter"`
+				} `json:"bool"`
+			} `json:"query"`
+		}
+		if err := json.Unmarshal(body, &query); err != nil || len(query.Query.Bool.Filter) != 2 {
+			return false
+		}
+		start, _ := query.Query.Bool.Filter[1]["range"][file.FieldUploadStart].(map[string]interface{})
+		return start["lte"] == float64(now.Add(-2*time.Hour).UnixMilli())
+	}), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{
+		ID:     "action-1.agent-1",
+		Index:  ".fleet-fileds-fromhost-meta-agent",
+		Source: source,
+	}}}}, nil)
+
+	var status interface{}
+	bulker.On("Update", mock.Anything, ".fleet-fileds-fromhost-meta-agent", "action-1.agent-1", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		var body struct {
+			Doc struct {
+				File map[string]interface{} `json:"file"`
+			} `json:"doc"`
+		}
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &body))
+		status = body.Doc.File["Status"]
+	}).Return(nil)
+
+	var deleted []string
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			deleted = append(deleted, req.URL.Path)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader("{}")),
+				Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+			}, nil
+		}),
+	})
+	require.NoError(t, err)
+	bulker.On("Client").Return(client)
+
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	require.NoError(t, expireUploads(ctx, bulker, 2*time.Hour))
+	bulker.AssertExpectations(t)
+	require.Equal(t, string(file.StatusFail), status)
+	require.Equal(t, []string{"/.fleet-fileds-fromhost-data-agent/_delete_by_query"}, deleted)
+}
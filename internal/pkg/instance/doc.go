@@ -0,0 +1,7 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package instance reports the running fleet server instance in the .fleet-servers index,
+// so Kibana and the other fleet servers know which instances exist and whether they are alive.
+package instance
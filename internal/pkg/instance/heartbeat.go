@@ -0,0 +1,273 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/build"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/drain"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/esclock"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+	"github.com/elastic/fleet-server/v7/internal/pkg/seen"
+	"github.com/elastic/fleet-server/v7/internal/pkg/usage"
+)
+
+// timeNow is used to get the current time, aligned on the elasticsearch clock. It should be replaced for testing.
+var timeNow = esclock.SkewedNow
+
+// Heartbeat writes the document of the fleet server instance when it starts,
+// updates its last_seen time, status and connected agents at every interval, and marks it as stopped on shutdown.
+type Heartbeat struct {
+	bulker   bulk.Bulk
+	interval time.Duration
+	doc      model.Server
+
+	sm        policy.SelfMonitor
+	connected func() int64
+	seen      *seen.Tracker
+	drain     *drain.State
+	usage     *usage.Collector
+	ops       func() []string
+
+	// registered is set once the document of the instance is written.
+	registered bool
+}
+
+// Opt is an optional setting for Heartbeat.
+type Opt func(*Heartbeat)
+
+// WithSelfMonitor reports the state of the self monitor as the status of the instance.
+func WithSelfMonitor(sm policy.SelfMonitor) Opt {
+	return func(h *Heartbeat) {
+		h.sm = sm
+	}
+}
+
+// WithConnectedAgents reports the number of agents returned by fn as the connected agents of the instance.
+func WithConnectedAgents(fn func() int64) Opt {
+	return func(h *Heartbeat) {
+		h.connected = fn
+	}
+}
+
+// WithSeenAgents reports the distinct agents counted by t.
+func WithSeenAgents(t *seen.Tracker) Opt {
+	return func(h *Heartbeat) {
+		h.seen = t
+	}
+}
+
+// WithDrain reports the draining state d of the instance.
+func WithDrain(d *drain.State) Opt {
+	return func(h *Heartbeat) {
+		h.drain = d
+	}
+}
+
+// WithResourceUsage reports the resources used by the process sampled by c.
+func WithResourceUsage(c *usage.Collector) Opt {
+	return func(h *Heartbeat) {
+		h.usage = c
+	}
+}
+
+// WithOperations reports the IDs of the operations returned by fn as the operations running on the instance,
+// they are taken over by another instance if this one goes offline.
+func WithOperations(fn func() []string) Opt {
+	return func(h *Heartbeat) {
+		h.ops = fn
+	}
+}
+
+// NewHeartbeat returns the heartbeat of the fleet server described by cfg.
+// The instance is identified by the id of the agent running the fleet server.
+func NewHeartbeat(bulker bulk.Bulk, cfg *config.Config, bi build.Info, opts ...Opt) *Heartbeat {
+	h := &Heartbeat{
+		bulker:   bulker,
+		interval: cfg.Inputs[0].Server.Heartbeat.Interval,
+		doc: model.Server{
+			Agent: &model.AgentMetadata{
+				ID:      cfg.Fleet.Agent.ID,
+				Version: cfg.Fleet.Agent.Version,
+			},
+			Host: &model.HostMetadata{
+				ID:           cfg.Fleet.Host.ID,
+				Name:         cfg.Fleet.Host.Name,
+				Architecture: runtime.GOARCH,
+			},
+			Server: &model.ServerMetadata{
+				ID:      cfg.Fleet.Agent.ID,
+				Version: bi.Version,
+				Commit:  bi.Commit,
+			},
+			BindAddress: cfg.Inputs[0].Server.BindAddress(),
+			StartedAt:   ftime.Format(timeNow()),
+		},
+	}
+	if !bi.BuildTime.IsZero() {
+		h.doc.Server.BuildTime = ftime.Format(bi.BuildTime)
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Schedule returns the schedule of the heartbeat. It writes the document of the instance when the scheduler starts,
+// updates it at every interval, and marks it as stopped when the scheduler stops.
+// A failed heartbeat is retried at the next interval, it does not stop fleet server.
+func (h *Heartbeat) Schedule() scheduler.Schedule {
+	return scheduler.Schedule{
+		Name:       "fleet server heartbeat",
+		Interval:   h.interval,
+		Jitter:     0.1,
+		RunOnStart: true,
+		WorkFn:     h.beat,
+		StopFn:     h.stop,
+	}
+}
+
+// beat updates the document of the instance, the whole document is written if it was not written yet or was deleted.
+func (h *Heartbeat) beat(ctx context.Context) error {
+	log := h.logger(ctx)
+	ctx = log.WithContext(ctx)
+	if warnings := h.usage.Warnings(); len(warnings) > 0 {
+		log.Warn().Strs("warnings", warnings).Msg("fleet server resource usage over the warning thresholds")
+	}
+	if h.registered {
+		err := dl.UpdateServer(ctx, h.bulker, h.doc.Agent.ID, h.fields(h.status()))
+		if !errors.Is(err, es.ErrElasticNotFound) {
+			if err != nil {
+				return fmt.Errorf("failed to update the fleet server document: %w", err)
+			}
+			return nil
+		}
+		// the document was deleted, write it again
+	}
+	if err := h.register(ctx); err != nil {
+		return err
+	}
+	h.registered = true
+	return nil
+}
+
+// register writes the whole document of the instance.
+func (h *Heartbeat) register(ctx context.Context) error {
+	now := ftime.Format(timeNow())
+	doc := h.doc
+	doc.Timestamp = now
+	doc.LastSeen = now
+	doc.Status = h.status()
+	doc.ConnectedAgents = h.connectedAgents()
+	counts := h.seen.Counts()
+	doc.DistinctAgents1m = int64(counts[0])  //nolint:gosec // disable G115
+	doc.DistinctAgents5m = int64(counts[1])  //nolint:gosec // disable G115
+	doc.DistinctAgents15m = int64(counts[2]) //nolint:gosec // disable G115
+	if since := h.drain.Since(); !since.IsZero() {
+		doc.Draining = true
+		doc.DrainingSince = ftime.Format(since)
+	}
+	doc.Resources = h.resources()
+	doc.Operations = h.operations()
+	if err := dl.IndexServer(ctx, h.bulker, doc); err != nil {
+		return fmt.Errorf("failed to write the fleet server document: %w", err)
+	}
+	zerolog.Ctx(ctx).Debug().Str(dl.FieldServerStatus, doc.Status).Msg("fleet server document written")
+	return nil
+}
+
+// stop marks the document of the instance as stopped, the scheduler gives it a few seconds on shutdown.
+func (h *Heartbeat) stop(ctx context.Context) error {
+	if err := dl.UpdateServer(ctx, h.bulker, h.doc.Agent.ID, h.fields(dl.ServerStatusStopped)); err != nil {
+		return fmt.Errorf("failed to mark the fleet server document as stopped: %w", err)
+	}
+	log := h.logger(ctx)
+	log.Debug().Msg("fleet server document marked as stopped")
+	return nil
+}
+
+func (h *Heartbeat) logger(ctx context.Context) zerolog.Logger {
+	return zerolog.Ctx(ctx).With().Str(logger.AgentID, h.doc.Agent.ID).Logger()
+}
+
+// fields returns the fields of the document that are updated by a heartbeat.
+func (h *Heartbeat) fields(status string) bulk.UpdateFields {
+	now := ftime.Format(timeNow())
+	fields := bulk.UpdateFields{
+		"@timestamp":            now,
+		dl.FieldLastSeen:        now,
+		dl.FieldServerStatus:    status,
+		dl.FieldConnectedAgents: h.connectedAgents(),
+		dl.FieldStale:           false,
+	}
+	for i, c := range h.seen.Counts() {
+		fields[dl.FieldDistinctAgents+"_"+seen.WindowName(seen.Windows[i])] = c
+	}
+	if since := h.drain.Since(); !since.IsZero() {
+		fields[dl.FieldDraining] = true
+		fields[dl.FieldDrainingSince] = ftime.Format(since)
+	}
+	if res := h.resources(); res != nil {
+		fields[dl.FieldResources] = res
+	}
+	if h.ops != nil {
+		fields[dl.FieldOperations] = h.operations()
+	}
+	return fields
+}
+
+func (h *Heartbeat) status() string {
+	if h.sm == nil {
+		return ""
+	}
+	return h.sm.State().String()
+}
+
+// operations returns the IDs of the running operations, or nil if they are not reported.
+func (h *Heartbeat) operations() []string {
+	if h.ops == nil {
+		return nil
+	}
+	return h.ops()
+}
+
+func (h *Heartbeat) connectedAgents() int64 {
+	if h.connected == nil {
+		return 0
+	}
+	return h.connected()
+}
+
+// resources returns the resources used by the process, or nil if they are not sampled.
+func (h *Heartbeat) resources() *model.ServerResources {
+	if h.usage == nil {
+		return nil
+	}
+	u := h.usage.Usage()
+	return &model.ServerResources{
+		CPUPct:         u.CPUPercent,
+		CPUSeconds:     u.CPUSeconds,
+		RSSBytes:       u.RSSBytes,
+		Goroutines:     u.Goroutines,
+		GCPauseSeconds: u.GCPauseSeconds,
+		OpenFDs:        u.OpenFDs,
+		FDLimit:        u.FDLimit,
+	}
+}
@@ -0,0 +1,526 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package instance
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-client/v7/pkg/client"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/build"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/drain"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+	"github.com/elastic/fleet-server/v7/internal/pkg/seen"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+	"github.com/elastic/fleet-server/v7/internal/pkg/usage"
+)
+
+// serversTransport is a mock transport that keeps the documents of the .fleet-servers index in memory.
+// It answers the bulk index and update operations, the reads, and the stale servers query.
+type serversTransport struct {
+	mu   sync.Mutex
+	docs map[string]map[string]any
+	ops  []string // the bulk operations as "action id"
+
+	// beforeUpdate is called with the document before it is updated
+	beforeUpdate func(id string, doc map[string]any)
+}
+
+func newServersTransport() *serversTransport {
+	return &serversTransport{docs: make(map[string]map[string]any)}
+}
+
+func (m *serversTransport) Perform(req *http.Request) (*http.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var body bytes.Buffer
+	var err error
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/_bulk"):
+		err = m.bulk(req.Body, &body)
+	case strings.HasSuffix(req.URL.Path, "/_msearch"):
+		err = m.msearch(req.Body, &body)
+	case strings.HasSuffix(req.URL.Path, "/_mget"):
+		err = m.mget(req.Body, &body)
+	default:
+		err = fmt.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Request:    req,
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(&body),
+	}, nil
+}
+
+func (m *serversTransport) bulk(r io.Reader, out *bytes.Buffer) error {
+	var items []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var meta map[string]struct {
+			ID    string `json:"_id"`
+			Index string `json:"_index"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil {
+			return err
+		}
+		if !scanner.Scan() {
+			return fmt.Errorf("missing bulk body")
+		}
+		for action, md := range meta {
+			if md.Index != dl.FleetServers {
+				return fmt.Errorf("unexpected index %s", md.Index)
+			}
+			m.ops = append(m.ops, action+" "+md.ID)
+			status := http.StatusOK
+			var doc map[string]any
+			switch action {
+			case "index":
+				if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+					return err
+				}
+				m.docs[md.ID] = doc
+			case "update":
+				var upd struct {
+					Doc    map[string]any `json:"doc"`
+					Script *struct {
+						Params map[string]string `json:"params"`
+					} `json:"script"`
+				}
+				if err := json.Unmarshal(scanner.Bytes(), &upd); err != nil {
+					return err
+				}
+				doc, ok := m.docs[md.ID]
+				if !ok {
+					status = http.StatusNotFound
+					break
+				}
+				if m.beforeUpdate != nil {
+					m.beforeUpdate(md.ID, doc)
+				}
+				if upd.Script != nil {
+					// the server offline script
+					lastSeen, _ := doc[dl.FieldLastSeen].(string)
+					if lastSeen > upd.Script.Params["before"] || doc[dl.FieldStale] == true || doc[dl.FieldServerStatus] == upd.Script.Params["stopped"] {
+						break
+					}
+					upd.Doc = map[string]any{
+						dl.FieldStale:           true,
+						dl.FieldServerStatus:    upd.Script.Params["offline"],
+						dl.FieldOfflineBy:       upd.Script.Params["by"],
+						dl.FieldConnectedAgents: 0,
+					}
+				}
+				for k, v := range upd.Doc {
+					doc[k] = v
+				}
+			default:
+				return fmt.Errorf("unexpected action %s", action)
+			}
+			if status == http.StatusNotFound {
+				items = append(items, fmt.Sprintf(`{%q:{"_id":%q,"status":404,"error":{"type":"document_missing_exception","reason":"[%s]: document missing"}}}`, action, md.ID, md.ID))
+			} else {
+				items = append(items, fmt.Sprintf(`{%q:{"_id":%q,"status":%d,"result":"updated"}}`, action, md.ID, status))
+			}
+		}
+	}
+	fmt.Fprintf(out, `{"took":1,"errors":false,"items":[%s]}`, strings.Join(items, ","))
+	return scanner.Err()
+}
+
+// msearch answers the stale servers query with the documents matching it.
+func (m *serversTransport) msearch(r io.Reader, out *bytes.Buffer) error {
+	var responses []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if !scanner.Scan() {
+			return fmt.Errorf("missing msearch body")
+		}
+		var query struct {
+			Query struct {
+				Bool struct {
+					Filter []struct {
+						Range map[string]struct {
+							LTE string `json:"lte"`
+						} `json:"range"`
+					} `json:"filter"`
+				} `json:"bool"`
+			} `json:"query"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &query); err != nil {
+			return err
+		}
+		before := query.Query.Bool.Filter[0].Range[dl.FieldLastSeen].LTE
+		var hits []string
+		for id, doc := range m.docs {
+			lastSeen, _ := doc[dl.FieldLastSeen].(string)
+			if lastSeen > before || doc[dl.FieldStale] == true || doc[dl.FieldServerStatus] == dl.ServerStatusStopped {
+				continue
+			}
+			hits = append(hits, fmt.Sprintf(`{"_id":%q,"_source":{}}`, id))
+		}
+		responses = append(responses, fmt.Sprintf(`{"status":200,"hits":{"total":{"value":%d,"relation":"eq"},"hits":[%s]}}`, len(hits), strings.Join(hits, ",")))
+	}
+	fmt.Fprintf(out, `{"took":1,"responses":[%s]}`, strings.Join(responses, ","))
+	return scanner.Err()
+}
+
+// mget answers the reads with the documents.
+func (m *serversTransport) mget(r io.Reader, out *bytes.Buffer) error {
+	var req struct {
+		Docs []struct {
+			ID string `json:"_id"`
+		} `json:"docs"`
+	}
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		return err
+	}
+	docs := make([]string, len(req.Docs))
+	for i, d := range req.Docs {
+		doc, ok := m.docs[d.ID]
+		if !ok {
+			docs[i] = fmt.Sprintf(`{"_id":%q,"found":false}`, d.ID)
+			continue
+		}
+		source, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		docs[i] = fmt.Sprintf(`{"_id":%q,"found":true,"_source":%s}`, d.ID, source)
+	}
+	fmt.Fprintf(out, `{"docs":[%s]}`, strings.Join(docs, ","))
+	return nil
+}
+
+func (m *serversTransport) doc(id string) map[string]any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	doc, ok := m.docs[id]
+	if !ok {
+		return nil
+	}
+	c := make(map[string]any, len(doc))
+	for k, v := range doc {
+		c[k] = v
+	}
+	return c
+}
+
+func (m *serversTransport) operations() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.ops...)
+}
+
+type fakeSelfMonitor struct {
+	mu    sync.Mutex
+	state client.UnitState
+}
+
+func (m *fakeSelfMonitor) setState(state client.UnitState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = state
+}
+
+func (m *fakeSelfMonitor) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (m *fakeSelfMonitor) State() client.UnitState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+func runBulker(t *testing.T, ctx context.Context, tr *serversTransport) *bulk.Bulker {
+	t.Helper()
+	bulker := bulk.NewBulker(tr, nil, bulk.WithFlushThresholdCount(1), bulk.WithFlushInterval(time.Millisecond))
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = bulker.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+	return bulker
+}
+
+// runSchedule runs schedule until ctx is cancelled.
+func runSchedule(ctx context.Context, schedule scheduler.Schedule) error {
+	sched, err := scheduler.New([]scheduler.Schedule{schedule})
+	if err != nil {
+		return err
+	}
+	return sched.Run(ctx)
+}
+
+func testConfig() *config.Config {
+	cfg := &config.Config{
+		Fleet: config.Fleet{
+			Agent: config.Agent{ID: "server-1", Version: "9.1.0"},
+			Host:  config.Host{ID: "host-1", Name: "fleet-host"},
+		},
+		Inputs: []config.Input{{Type: "fleet-server"}},
+	}
+	cfg.Inputs[0].Server.InitDefaults()
+	cfg.Inputs[0].Server.Heartbeat.Interval = 10 * time.Millisecond
+	return cfg
+}
+
+func TestHeartbeatLifecycle(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	tr := newServersTransport()
+	bulker := runBulker(t, ctx, tr)
+
+	sm := &fakeSelfMonitor{state: client.UnitStateHealthy}
+	agents := seen.NewTracker()
+	agents.Add("agent-1")
+	agents.Add("agent-2")
+	draining := drain.New()
+	resources := usage.New(config.ResourceUsage{}, usage.WithSampler(func() usage.Usage {
+		return usage.Usage{CPUSeconds: 12.5, RSSBytes: 64 << 20, Goroutines: 120, GCPauseSeconds: 0.25, OpenFDs: 42, FDLimit: 1024}
+	}))
+	var mu sync.Mutex
+	running := []string{"op-1"}
+	hb := NewHeartbeat(bulker, testConfig(), build.Info{Version: "9.1.0-SNAPSHOT", Commit: "31668e0", BuildTime: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)},
+		WithSelfMonitor(sm),
+		WithConnectedAgents(func() int64 { return 3 }),
+		WithSeenAgents(agents),
+		WithDrain(draining),
+		WithResourceUsage(resources),
+		WithOperations(func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return slices.Clone(running)
+		}),
+	)
+
+	hbCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- runSchedule(hbCtx, hb.Schedule())
+	}()
+
+	// the whole document is written on start
+	require.Eventually(t, func() bool { return tr.doc("server-1") != nil }, time.Second, time.Millisecond)
+	doc := tr.doc("server-1")
+	require.Equal(t, "0.0.0.0:8220", doc["bind_address"])
+	require.Equal(t, map[string]any{"id": "server-1", "version": "9.1.0-SNAPSHOT", "commit": "31668e0", "build_time": "2025-03-01T12:00:00.000Z"}, doc["server"])
+	require.Equal(t, map[string]any{"id": "server-1", "version": "9.1.0"}, doc["agent"])
+	require.Equal(t, "fleet-host", doc["host"].(map[string]any)["name"])
+	require.Equal(t, "HEALTHY", doc["status"])
+	require.Equal(t, float64(3), doc["connected_agents"])
+	require.Equal(t, float64(2), doc["distinct_agents_5m"])
+	require.NotEmpty(t, doc["started_at"])
+	require.Nil(t, doc["draining"])
+	require.Equal(t, map[string]any{
+		"cpu_seconds":      12.5,
+		"rss_bytes":        float64(64 << 20),
+		"goroutines":       float64(120),
+		"gc_pause_seconds": 0.25,
+		"open_fds":         float64(42),
+		"fd_limit":         float64(1024),
+	}, doc["resources"])
+	require.Equal(t, []any{"op-1"}, doc["operations"])
+	startedAt := doc["started_at"]
+
+	// the heartbeats report the running operations
+	mu.Lock()
+	running = []string{}
+	mu.Unlock()
+	require.Eventually(t, func() bool { return len(tr.doc("server-1")["operations"].([]any)) == 0 }, time.Second, time.Millisecond)
+
+	// the heartbeats update the status
+	sm.setState(client.UnitStateDegraded)
+	require.Eventually(t, func() bool { return tr.doc("server-1")["status"] == "DEGRADED" }, time.Second, time.Millisecond)
+
+	// and report the draining state
+	draining.Start()
+	require.Eventually(t, func() bool { return tr.doc("server-1")["draining"] == true }, time.Second, time.Millisecond)
+	require.Equal(t, ftime.Format(draining.Since()), tr.doc("server-1")["draining_since"])
+
+	// on shutdown the document is marked as stopped
+	cancel()
+	require.NoError(t, <-done)
+	doc = tr.doc("server-1")
+	require.Equal(t, dl.ServerStatusStopped, doc["status"])
+	require.Equal(t, false, doc["stale"])
+	require.Equal(t, startedAt, doc["started_at"])
+	require.Equal(t, float64(2), doc["distinct_agents_1m"])
+	require.Equal(t, float64(2), doc["distinct_agents_15m"])
+	require.Equal(t, float64(42), doc["resources"].(map[string]any)["open_fds"])
+
+	ops := tr.operations()
+	require.Equal(t, "index server-1", ops[0])
+	for _, op := range ops[1:] {
+		require.Equal(t, "update server-1", op)
+	}
+}
+
+func TestHeartbeatDocumentDeleted(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	tr := newServersTransport()
+	bulker := runBulker(t, ctx, tr)
+
+	hb := NewHeartbeat(bulker, testConfig(), build.Info{Version: "9.1.0"})
+	hbCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- runSchedule(hbCtx, hb.Schedule())
+	}()
+
+	require.Eventually(t, func() bool { return tr.doc("server-1") != nil }, time.Second, time.Millisecond)
+	tr.mu.Lock()
+	delete(tr.docs, "server-1")
+	tr.mu.Unlock()
+
+	// the next heartbeat fails with a not found error and writes the whole document again
+	require.Eventually(t, func() bool { return tr.doc("server-1") != nil }, time.Second, time.Millisecond)
+	require.Equal(t, "0.0.0.0:8220", tr.doc("server-1")["bind_address"])
+
+	cancel()
+	require.NoError(t, <-done)
+	require.Contains(t, tr.operations()[1:], "index server-1")
+}
+
+func TestJanitor(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	t.Cleanup(func() { timeNow = time.Now })
+
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	tr := newServersTransport()
+	old := now.Add(-10 * time.Minute).Format(time.RFC3339)
+	tr.docs["alive"] = map[string]any{dl.FieldLastSeen: now.Add(-time.Minute).Format(time.RFC3339), dl.FieldServerStatus: "HEALTHY"}
+	tr.docs["silent"] = map[string]any{dl.FieldLastSeen: old, dl.FieldServerStatus: "HEALTHY"}
+	tr.docs["stopped"] = map[string]any{dl.FieldLastSeen: old, dl.FieldServerStatus: dl.ServerStatusStopped}
+	tr.docs["flagged"] = map[string]any{dl.FieldLastSeen: old, dl.FieldServerStatus: "HEALTHY", dl.FieldStale: true}
+	bulker := runBulker(t, ctx, tr)
+
+	schedule := Janitor(bulker, "alive", 5*time.Minute, nil)
+	require.Equal(t, 5*time.Minute, schedule.Interval)
+	require.NoError(t, schedule.WorkFn(ctx))
+
+	require.Equal(t, []string{"update silent"}, tr.operations())
+	require.Equal(t, true, tr.doc("silent")[dl.FieldStale])
+	require.Equal(t, "alive", tr.doc("silent")[dl.FieldOfflineBy])
+	require.Equal(t, dl.ServerStatusOffline, tr.doc("silent")[dl.FieldServerStatus])
+	require.Nil(t, tr.doc("alive")[dl.FieldStale])
+	require.Nil(t, tr.doc("stopped")[dl.FieldStale])
+}
+
+func TestJanitorDeadInstance(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	tr := newServersTransport()
+	// server-2 died without marking its document as stopped, while running an operation
+	tr.docs["server-2"] = map[string]any{
+		dl.FieldLastSeen:        time.Now().UTC().Add(-time.Hour).Format(time.RFC3339),
+		dl.FieldServerStatus:    "HEALTHY",
+		dl.FieldConnectedAgents: 120,
+		dl.FieldOperations:      []any{"op-1"},
+	}
+	bulker := runBulker(t, ctx, tr)
+
+	hbCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go runSchedule(hbCtx, NewHeartbeat(bulker, testConfig(), build.Info{Version: "9.1.0"}).Schedule()) //nolint:errcheck // stopped by cancel
+	require.Eventually(t, func() bool { return tr.doc("server-1") != nil }, time.Second, time.Millisecond)
+
+	// every instance runs the janitor, concurrent runs flag the document of server-2 once and a single instance
+	// takes over its operations
+	var mu sync.Mutex
+	takeovers := make(map[string][]string)
+	var wg sync.WaitGroup
+	for _, id := range []string{"server-1", "server-3", "server-4"} {
+		janitor := Janitor(bulker, id, time.Minute, func(_ context.Context, server string, operations []string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			require.Equal(t, "server-2", server)
+			takeovers[id] = operations
+			return nil
+		})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, janitor.WorkFn(ctx))
+		}()
+	}
+	wg.Wait()
+
+	doc := tr.doc("server-2")
+	require.Equal(t, true, doc[dl.FieldStale])
+	require.Equal(t, dl.ServerStatusOffline, doc[dl.FieldServerStatus])
+	require.EqualValues(t, 0, doc[dl.FieldConnectedAgents])
+	require.Len(t, takeovers, 1)
+	for id, operations := range takeovers {
+		require.Equal(t, id, doc[dl.FieldOfflineBy])
+		require.Equal(t, []string{"op-1"}, operations)
+	}
+	// the running instance is not flagged
+	require.NotEqual(t, true, tr.doc("server-1")[dl.FieldStale])
+	require.NotEqual(t, dl.ServerStatusOffline, tr.doc("server-1")[dl.FieldServerStatus])
+
+	// server-2 comes back, its heartbeat clears the flag
+	cfg := testConfig()
+	cfg.Fleet.Agent.ID = "server-2"
+	go runSchedule(hbCtx, NewHeartbeat(bulker, cfg, build.Info{Version: "9.1.0"}).Schedule()) //nolint:errcheck // stopped by cancel
+	require.Eventually(t, func() bool {
+		doc := tr.doc("server-2")
+		return doc[dl.FieldStale] == false && doc[dl.FieldServerStatus] != dl.ServerStatusOffline
+	}, time.Second, time.Millisecond)
+}
+
+func TestJanitorHeartbeatWins(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	tr := newServersTransport()
+	now := time.Now().UTC()
+	tr.docs["server-1"] = map[string]any{dl.FieldLastSeen: now.Add(-time.Hour).Format(time.RFC3339), dl.FieldServerStatus: "HEALTHY"}
+	// the instance sends a heartbeat after the janitor found its document
+	tr.beforeUpdate = func(_ string, doc map[string]any) {
+		doc[dl.FieldLastSeen] = now.Format(time.RFC3339)
+	}
+	bulker := runBulker(t, ctx, tr)
+
+	takeover := func(context.Context, string, []string) error {
+		t.Fatal("the operations of a running instance are taken over")
+		return nil
+	}
+	require.NoError(t, Janitor(bulker, "server-2", time.Minute, takeover).WorkFn(ctx))
+	require.Equal(t, []string{"update server-1"}, tr.operations())
+	require.Nil(t, tr.doc("server-1")[dl.FieldStale])
+	require.Equal(t, "HEALTHY", tr.doc("server-1")[dl.FieldServerStatus])
+}
@@ -0,0 +1,73 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package instance
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+)
+
+const maxStaleServersFetchSize = 100
+
+// TakeoverFunc takes over the operations that the offline instance server was running.
+type TakeoverFunc func(ctx context.Context, server string, operations []string) error
+
+// Janitor returns the schedule that marks the documents of the instances that did not send a heartbeat for staleTimeout
+// as stale and offline. Every instance runs it, the documents are updated with a conditional update so instances that
+// flag the same document concurrently, or an instance that sends a heartbeat meanwhile, do not overwrite each other.
+// The instance id that flags a document takes over the operations of the offline instance with takeover.
+// An instance that is flagged by mistake, after a few failed heartbeats, clears the flag with its next heartbeat.
+func Janitor(bulker bulk.Bulk, id string, staleTimeout time.Duration, takeover TakeoverFunc) scheduler.Schedule {
+	return scheduler.Schedule{
+		Name:     "fleet servers stale",
+		Interval: staleTimeout,
+		WorkFn: func(ctx context.Context) error {
+			return flagStaleServers(ctx, bulker, id, staleTimeout, takeover)
+		},
+	}
+}
+
+func flagStaleServers(ctx context.Context, bulker bulk.Bulk, self string, staleTimeout time.Duration, takeover TakeoverFunc) error {
+	before := timeNow().UTC().Add(-staleTimeout)
+	log := zerolog.Ctx(ctx).With().Str("ctx", "fleet servers stale").Time("before", before).Logger()
+
+	ids, err := dl.FindStaleServers(ctx, bulker, before, maxStaleServersFetchSize)
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to find stale fleet servers")
+		return err
+	}
+	for _, id := range ids {
+		doc, err := dl.MarkServerOffline(ctx, bulker, id, before, self)
+		switch {
+		case errors.Is(err, es.ErrElasticVersionConflict), errors.Is(err, es.ErrElasticNotFound):
+			// the document was updated or deleted since it was found, the next run checks it again
+			log.Debug().Err(err).Str(logger.AgentID, id).Msg("fleet server changed while flagging it as stale")
+		case err != nil:
+			log.Debug().Err(err).Str(logger.AgentID, id).Msg("failed to flag stale fleet server")
+			return err
+		case doc == nil:
+			log.Debug().Str(logger.AgentID, id).Msg("fleet server sent a heartbeat or was flagged by another instance")
+		default:
+			log.Info().Str(logger.AgentID, id).Strs("operations", doc.Operations).Msg("fleet server flagged as stale and offline")
+			if takeover == nil || len(doc.Operations) == 0 {
+				continue
+			}
+			// the operations that fail to resume are resumed by the next run of their schedule or by retrying their request
+			if err := takeover(ctx, id, doc.Operations); err != nil {
+				log.Warn().Err(err).Str(logger.AgentID, id).Msg("failed to take over the operations of the offline fleet server")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package instance
+
+import (
+	"context"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+)
+
+// LeaderOpt is an option of Leader.
+type LeaderOpt func(*leaderStats)
+
+// WithLeaderStats registers the leadership stats of the instance in reg: leader is true while the last check found the
+// instance to be the leader, checks and errors count the checks and the failed ones, and changes counts the times the
+// instance became or stopped being the leader.
+func WithLeaderStats(reg *monitoring.Registry) LeaderOpt {
+	return func(s *leaderStats) {
+		reg.Add("leader", &s.leader, monitoring.Full)
+		reg.Add("checks", &s.checks, monitoring.Full)
+		reg.Add("errors", &s.errors, monitoring.Full)
+		reg.Add("changes", &s.changes, monitoring.Full)
+	}
+}
+
+type leaderStats struct {
+	leader  monitoring.Bool
+	checks  monitoring.Uint
+	errors  monitoring.Uint
+	changes monitoring.Uint
+}
+
+// Leader returns a function that reports whether the instance id is the leader of the fleet servers.
+// The leader is the running instance with the lowest id among those that sent a heartbeat within staleTimeout, so the
+// instances agree on it without coordination. A new leader takes over once the documents of the instances before it
+// are stopped or stale, and two instances may briefly both be leaders, the jobs run by the leader must tolerate it.
+func Leader(bulker bulk.Bulk, id string, staleTimeout time.Duration, opts ...LeaderOpt) func(context.Context) (bool, error) {
+	stats := &leaderStats{}
+	for _, opt := range opts {
+		opt(stats)
+	}
+	return func(ctx context.Context) (bool, error) {
+		stats.checks.Inc()
+		leader, err := dl.FindLeaderServer(ctx, bulker, timeNow().UTC().Add(-staleTimeout))
+		if err != nil {
+			stats.errors.Inc()
+			return false, err
+		}
+		isLeader := leader == id
+		if stats.leader.Get() != isLeader {
+			stats.leader.Set(isLeader)
+			stats.changes.Inc()
+		}
+		return isLeader, nil
+	}
+}
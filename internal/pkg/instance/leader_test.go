@@ -0,0 +1,146 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package instance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/esclock"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+)
+
+func TestLeader(t *testing.T) {
+	now := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+
+	bulker := ftesting.NewMockBulk()
+	// the running server with the lowest id that sent a heartbeat within the stale timeout is the leader
+	bulker.On("Search", mock.Anything, dl.FleetServers, mock.MatchedBy(func(body []byte) bool {
+		return bytes.Contains(body, []byte(`{"range":{"last_seen":{"gt":"2024-04-01T11:55:00.000Z"}}}`)) &&
+			bytes.Contains(body, []byte(`"sort":["agent.id"]`))
+	}), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "server-1"}}}}, nil)
+
+	ctx := context.Background()
+	leader, err := Leader(bulker, "server-1", 5*time.Minute)(ctx)
+	require.NoError(t, err)
+	require.True(t, leader)
+	leader, err = Leader(bulker, "server-2", 5*time.Minute)(ctx)
+	require.NoError(t, err)
+	require.False(t, leader)
+
+	// no instance is the leader before the servers index exists
+	bulker = ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetServers, mock.Anything, mock.Anything).Return(&es.ResultT{}, es.ErrIndexNotFound)
+	leader, err = Leader(bulker, "server-1", 5*time.Minute)(ctx)
+	require.NoError(t, err)
+	require.False(t, leader)
+}
+
+// leaderBulker answers the leader query with server-1 when its last heartbeat, at lastSeen, is after the bound of the
+// query, and with server-2 otherwise.
+func leaderBulker(t *testing.T, lastSeen time.Time) *ftesting.MockBulk {
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetServers, mock.MatchedBy(func(body []byte) bool {
+		var query struct {
+			Query struct {
+				Bool struct {
+					Filter []struct {
+						Range map[string]struct {
+							GT string `json:"gt"`
+						} `json:"range"`
+					} `json:"filter"`
+				} `json:"bool"`
+			} `json:"query"`
+		}
+		require.NoError(t, json.Unmarshal(body, &query))
+		after, err := ftime.Parse(query.Query.Bool.Filter[0].Range[dl.FieldLastSeen].GT)
+		require.NoError(t, err)
+		return lastSeen.After(after)
+	}), mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "server-1"}}}}, nil)
+	bulker.On("Search", mock.Anything, dl.FleetServers, mock.Anything, mock.Anything).Return(&es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: "server-2"}}}}, nil)
+	return bulker
+}
+
+func TestLeaderClockSkew(t *testing.T) {
+	// the clock of this instance, server-2, is 10 minutes ahead of elasticsearch, the heartbeats of server-1 are
+	// written with the elasticsearch time
+	skew := 10 * time.Minute
+	var cfg config.ClockSkew
+	cfg.InitDefaults()
+	clock := esclock.New(cfg, func(context.Context) (time.Time, error) {
+		return time.Now().Add(-skew), nil
+	})
+	require.NoError(t, clock.Schedule().WorkFn(context.Background()))
+	timeNow = clock.Now
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+	staleTimeout := 5 * time.Minute
+	ctx := context.Background()
+
+	// server-1 sent a heartbeat a minute ago, it remains the leader
+	esNow := time.Now().Add(-skew)
+	leader, err := Leader(leaderBulker(t, esNow.Add(-time.Minute)), "server-2", staleTimeout)(ctx)
+	require.NoError(t, err)
+	require.False(t, leader, "server-1 must not be taken over before the stale timeout")
+
+	// server-1 stopped sending heartbeats for longer than the stale timeout, server-2 takes over
+	leader, err = Leader(leaderBulker(t, esNow.Add(-staleTimeout-time.Minute)), "server-2", staleTimeout)(ctx)
+	require.NoError(t, err)
+	require.True(t, leader)
+
+	// with the local time, server-1 is taken over while it is alive
+	timeNow = time.Now
+	leader, err = Leader(leaderBulker(t, esNow.Add(-time.Minute)), "server-2", staleTimeout)(ctx)
+	require.NoError(t, err)
+	require.True(t, leader)
+}
+
+func TestLeaderStats(t *testing.T) {
+	hits := func(id string) *es.ResultT {
+		return &es.ResultT{HitsT: es.HitsT{Hits: []es.HitT{{ID: id}}}}
+	}
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetServers, mock.Anything, mock.Anything).Return(hits("server-1"), nil).Twice()
+	bulker.On("Search", mock.Anything, dl.FleetServers, mock.Anything, mock.Anything).Return(hits("server-2"), nil).Once()
+	bulker.On("Search", mock.Anything, dl.FleetServers, mock.Anything, mock.Anything).Return(&es.ResultT{}, es.ErrUnavailable).Once()
+
+	reg := monitoring.NewRegistry()
+	leader := Leader(bulker, "server-1", 5*time.Minute, WithLeaderStats(reg))
+	ctx := context.Background()
+	for _, want := range []bool{true, true, false} {
+		isLeader, err := leader(ctx)
+		require.NoError(t, err)
+		require.Equal(t, want, isLeader)
+		require.Equal(t, want, monitoring.CollectFlatSnapshot(reg, monitoring.Full, false).Bools["leader"])
+	}
+	_, err := leader(ctx)
+	require.Error(t, err)
+
+	// the instance became the leader, then stopped being the leader
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	require.Equal(t, map[string]bool{"leader": false}, snapshot.Bools)
+	require.Equal(t, map[string]int64{"checks": 4, "errors": 1, "changes": 2}, snapshot.Ints)
+}
@@ -0,0 +1,144 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package limit
+
+import (
+	"context"
+	mrand "math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+// Class is a class of endpoints that shares a quota of the concurrency ceiling.
+type Class string
+
+const (
+	ClassCheckin Class = "checkin"
+	ClassEnroll  Class = "enroll"
+	ClassAck     Class = "ack"
+	ClassOther   Class = "other"
+)
+
+// Classes are the endpoint classes of the concurrency ceiling.
+var Classes = []Class{ClassCheckin, ClassEnroll, ClassAck, ClassOther}
+
+// ClassStats is the interface used to report the requests of an endpoint class.
+type ClassStats interface {
+	// IncInFlight counts a request that is served, the returned function is called when it is done.
+	IncInFlight() func()
+	// IncQueued counts a request that waits for the quota of the class, the returned function is called when it stops waiting.
+	IncQueued() func()
+	// IncRejected counts a request that is rejected.
+	IncRejected()
+}
+
+// Ceiling bounds the requests served at once. Each endpoint class has its own quota of the ceiling, the requests
+// of a class wait in a bounded queue for a short time when the quota is used, the checkins are rejected at once.
+type Ceiling struct {
+	classes      map[Class]*classLimit
+	queueTimeout time.Duration
+	retryAfter   time.Duration
+}
+
+type classLimit struct {
+	sem   *semaphore.Weighted
+	queue chan struct{} // a token for each waiting request, nil if the requests of the class don't wait
+}
+
+// NewCeiling returns the concurrency ceiling of cfg, or nil if it is disabled.
+func NewCeiling(cfg *config.Concurrency) *Ceiling {
+	if !cfg.Enabled || cfg.Max <= 0 {
+		return nil
+	}
+	c := &Ceiling{
+		classes:      make(map[Class]*classLimit, len(Classes)),
+		queueTimeout: cfg.QueueTimeout,
+		retryAfter:   cfg.RetryAfter,
+	}
+	quotas := map[Class]float64{
+		ClassCheckin: cfg.Quotas.Checkin,
+		ClassEnroll:  cfg.Quotas.Enroll,
+		ClassAck:     cfg.Quotas.Ack,
+		ClassOther:   cfg.Quotas.Other,
+	}
+	for class, quota := range quotas {
+		// the epsilon keeps the fractions written in the configuration from rounding down
+		l := &classLimit{sem: semaphore.NewWeighted(max(1, int64(float64(cfg.Max)*quota+1e-9)))}
+		if class != ClassCheckin && cfg.QueueSize > 0 && cfg.QueueTimeout > 0 {
+			l.queue = make(chan struct{}, cfg.QueueSize)
+		}
+		c.classes[class] = l
+	}
+	return c
+}
+
+// acquire waits for the quota of the class and returns the function releasing it.
+func (c *Ceiling) acquire(ctx context.Context, class Class, stats ClassStats) (releaseFunc, error) {
+	l := c.classes[class]
+	if l.sem.TryAcquire(1) {
+		return l.release, nil
+	}
+	if l.queue == nil {
+		return nil, ErrConcurrencyLimit
+	}
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return nil, ErrConcurrencyLimit
+	}
+	done := stats.IncQueued()
+	defer func() {
+		<-l.queue
+		done()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, c.queueTimeout)
+	defer cancel()
+	if err := l.sem.Acquire(ctx, 1); err != nil {
+		return nil, ErrConcurrencyLimit
+	}
+	return l.release, nil
+}
+
+func (l *classLimit) release() {
+	l.sem.Release(1)
+}
+
+// retryAfterSeconds returns the jittered backoff of the Retry-After header, so the rejected agents don't retry at once.
+func (c *Ceiling) retryAfterSeconds() int {
+	d := c.retryAfter / 2
+	if d > 0 {
+		d += mrand.N(d + 1) //nolint:gosec // the jitter is not security sensitive
+	}
+	return max(1, int((d+time.Second-1)/time.Second))
+}
+
+// Wrap applies the quota of the class to the requests of the handler.
+func (c *Ceiling) Wrap(class Class, stats ClassStats, ll zerolog.Level) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			release, err := c.acquire(r.Context(), class, stats)
+			if err != nil {
+				stats.IncRejected()
+				hlog.FromRequest(r).WithLevel(ll).Str("class", string(class)).Err(err).Msg("concurrency limit reached")
+				w.Header().Set("Retry-After", strconv.Itoa(c.retryAfterSeconds()))
+				if wErr := writeError(hlog.FromRequest(r), w, err); wErr != nil {
+					hlog.FromRequest(r).Error().Err(wErr).Msg("fail writing error response")
+				}
+				return
+			}
+			defer release()
+			defer stats.IncInFlight()()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
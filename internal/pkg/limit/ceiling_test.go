@@ -0,0 +1,191 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package limit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+type fakeClassStats struct {
+	inFlight    atomic.Int64
+	maxInFlight atomic.Int64
+	queued      atomic.Int64
+	rejected    atomic.Int64
+}
+
+func (s *fakeClassStats) IncInFlight() func() {
+	n := s.inFlight.Add(1)
+	for {
+		m := s.maxInFlight.Load()
+		if n <= m || s.maxInFlight.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	return func() { s.inFlight.Add(-1) }
+}
+
+func (s *fakeClassStats) IncQueued() func() {
+	s.queued.Add(1)
+	return func() { s.queued.Add(-1) }
+}
+
+func (s *fakeClassStats) IncRejected() {
+	s.rejected.Add(1)
+}
+
+func testConcurrency() config.Concurrency {
+	var cfg config.Concurrency
+	cfg.InitDefaults()
+	cfg.Max = 100
+	cfg.QueueSize = 5
+	cfg.QueueTimeout = 50 * time.Millisecond
+	cfg.RetryAfter = 10 * time.Second
+	return cfg
+}
+
+func TestNewCeilingDisabled(t *testing.T) {
+	cfg := testConcurrency()
+	cfg.Enabled = false
+	assert.Nil(t, NewCeiling(&cfg))
+
+	cfg = testConcurrency()
+	cfg.Max = 0
+	assert.Nil(t, NewCeiling(&cfg))
+}
+
+// TestCeilingLoad sends more requests of each class than their quota while the handlers are blocked,
+// and checks that the served requests don't exceed the quotas and that the requests of a class are not starved by the other classes.
+func TestCeilingLoad(t *testing.T) {
+	cfg := testConcurrency()
+	ceiling := NewCeiling(&cfg)
+	require.NotNil(t, ceiling)
+
+	quotas := map[Class]int64{ClassCheckin: 80, ClassEnroll: 5, ClassAck: 10, ClassOther: 5}
+	requests := map[Class]int{ClassCheckin: 200, ClassEnroll: 500, ClassAck: 20, ClassOther: 20}
+
+	unblock := make(chan struct{})
+	stats := make(map[Class]*fakeClassStats)
+	handlers := make(map[Class]http.Handler)
+	for _, class := range Classes {
+		stats[class] = &fakeClassStats{}
+		handlers[class] = ceiling.Wrap(class, stats[class], zerolog.DebugLevel)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-unblock
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+
+	var wg sync.WaitGroup
+	var mx sync.Mutex
+	codes := make(map[Class]map[int]int)
+	for class, n := range requests {
+		codes[class] = make(map[int]int)
+		for range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w := httptest.NewRecorder()
+				handlers[class].ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+				if w.Code == http.StatusTooManyRequests {
+					retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+					assert.NoError(t, err)
+					assert.GreaterOrEqual(t, retryAfter, 5)
+					assert.LessOrEqual(t, retryAfter, 10)
+				}
+				mx.Lock()
+				codes[class][w.Code]++
+				mx.Unlock()
+			}()
+		}
+	}
+
+	// every class gets its quota while the enrollments and the checkins overflow theirs
+	require.Eventually(t, func() bool {
+		for class, quota := range quotas {
+			if stats[class].inFlight.Load() != quota {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, time.Millisecond)
+	// the queued requests time out, the other requests are rejected at once
+	require.Eventually(t, func() bool {
+		for class := range quotas {
+			if stats[class].queued.Load() != 0 {
+				return false
+			}
+		}
+		return stats[ClassCheckin].rejected.Load() == 120 && stats[ClassEnroll].rejected.Load() == 495
+	}, 5*time.Second, time.Millisecond)
+	close(unblock)
+	wg.Wait()
+
+	for class, quota := range quotas {
+		assert.Equal(t, quota, stats[class].maxInFlight.Load(), class)
+		assert.Equal(t, int(quota), codes[class][http.StatusOK], class)
+		assert.Equal(t, requests[class]-int(quota), codes[class][http.StatusTooManyRequests], class)
+		assert.Equal(t, int64(requests[class])-quota, stats[class].rejected.Load(), class)
+	}
+}
+
+func TestCeilingQueue(t *testing.T) {
+	cfg := testConcurrency()
+	cfg.QueueTimeout = 5 * time.Second
+	cfg.Quotas.Enroll = 0.01
+	ceiling := NewCeiling(&cfg)
+	require.NotNil(t, ceiling)
+
+	stats := &fakeClassStats{}
+	started := make(chan struct{}, 10)
+	unblock := make(chan struct{})
+	handler := ceiling.Wrap(ClassEnroll, stats, zerolog.DebugLevel)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-unblock
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+		return w
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.Equal(t, http.StatusOK, serve().Code)
+	}()
+	<-started
+
+	// the requests above the queue size are rejected at once, the queued ones are served in turn
+	for range cfg.QueueSize {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, http.StatusOK, serve().Code)
+		}()
+	}
+	require.Eventually(t, func() bool {
+		return stats.queued.Load() == int64(cfg.QueueSize)
+	}, time.Second, time.Millisecond)
+	w := serve()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "ConcurrencyLimit")
+
+	close(unblock)
+	wg.Wait()
+	assert.Equal(t, int64(1), stats.maxInFlight.Load())
+	assert.Equal(t, int64(1), stats.rejected.Load())
+}
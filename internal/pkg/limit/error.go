@@ -15,6 +15,8 @@ import (
 var (
 	ErrRateLimit = errors.New("rate limit")
 	ErrMaxLimit  = errors.New("max limit")
+	// ErrConcurrencyLimit is returned when the quota of the concurrency ceiling of an endpoint class is used.
+	ErrConcurrencyLimit = errors.New("concurrency limit")
 )
 
 // writeError recreates the behaviour of api/error.go.
@@ -36,6 +38,9 @@ func writeError(log *zerolog.Logger, w http.ResponseWriter, err error) error {
 	case errors.Is(err, ErrMaxLimit):
 		resp.Error = "MaxLimit"
 		resp.Message = "exceeded the max limit"
+	case errors.Is(err, ErrConcurrencyLimit):
+		resp.Error = "ConcurrencyLimit"
+		resp.Message = "exceeded the concurrency limit"
 	default:
 		log.Error().Err(err).Msg("Encountered unknown limiter error")
 	}
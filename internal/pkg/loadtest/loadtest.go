@@ -0,0 +1,270 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package loadtest simulates agents against a fleet-server to measure its capacity.
+//
+// Each simulated agent enrolls with an enrollment token, then checks in in a loop and acks the actions it receives,
+// as an Elastic Agent does. The requests and responses are the types of the API spec, so the simulated agents
+// speak the protocol of the server they are built with.
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/api"
+)
+
+// Operations of the simulated agents reported by the load test.
+const (
+	OpEnroll  = "enroll"
+	OpCheckin = "checkin"
+	OpAck     = "ack"
+)
+
+// minEnrollRetry is the minimum time an agent waits before it retries a failed enrollment.
+const minEnrollRetry = time.Second
+
+// Config is the configuration of a load test.
+type Config struct {
+	// URL is the base URL of the fleet-server, for instance https://localhost:8220.
+	URL string
+	// EnrollmentToken is the enrollment API key the agents enroll with.
+	EnrollmentToken string
+	// Agents is the number of simulated agents.
+	Agents int
+	// AgentVersion is the version the agents report in their User-Agent and local metadata.
+	AgentVersion string
+	// Duration is the time the agents check in, counted from the start of the test.
+	Duration time.Duration
+	// RampUp spreads the enrollments of the agents over its duration.
+	RampUp time.Duration
+	// PollTimeout is the long poll timeout the agents request, the server default is used if it is zero.
+	PollTimeout time.Duration
+	// CheckinInterval is the time an agent waits between the end of a checkin and the next one.
+	CheckinInterval time.Duration
+	// Client sends the requests, http.DefaultClient is used if it is nil.
+	Client *http.Client
+}
+
+// Run simulates the agents of cfg until the duration is over or ctx is done, and returns the statistics of their requests.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("the URL of the fleet-server is not set")
+	}
+	if cfg.EnrollmentToken == "" {
+		return nil, errors.New("the enrollment token is not set")
+	}
+	if cfg.Agents <= 0 {
+		return nil, fmt.Errorf("the number of agents must be positive, got %d", cfg.Agents)
+	}
+	if cfg.Duration <= 0 {
+		return nil, fmt.Errorf("the duration must be positive, got %s", cfg.Duration)
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	report := newReport()
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range cfg.Agents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cfg.RampUp > 0 {
+				delay := cfg.RampUp * time.Duration(i) / time.Duration(cfg.Agents)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+			}
+			a := &agent{cfg: &cfg, report: report, name: fmt.Sprintf("loadtest-%d", i)}
+			a.run(ctx)
+		}()
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+	return report, nil
+}
+
+// agent is a simulated agent.
+type agent struct {
+	cfg    *Config
+	report *Report
+	name   string
+
+	id        string
+	apiKey    string
+	ackToken  *string
+	localMeta json.RawMessage
+}
+
+func (a *agent) run(ctx context.Context) {
+	for a.id == "" {
+		if err := a.enroll(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			a.wait(ctx, max(a.cfg.CheckinInterval, minEnrollRetry))
+		}
+	}
+	for ctx.Err() == nil {
+		actions, err := a.checkin(ctx)
+		if err == nil && len(actions) > 0 {
+			_ = a.ack(ctx, actions) // the failures are counted in the report
+		}
+		a.wait(ctx, a.cfg.CheckinInterval)
+	}
+}
+
+// wait waits for d with a jitter of 10%, so the agents don't send their requests at once.
+func (a *agent) wait(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	d += mrand.N(d/10 + 1) //nolint:gosec // the jitter is not security sensitive
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+func (a *agent) enroll(ctx context.Context) error {
+	local, err := json.Marshal(map[string]any{
+		"elastic": map[string]any{"agent": map[string]any{"version": a.cfg.AgentVersion}},
+		"host":    map[string]any{"hostname": a.name},
+	})
+	if err != nil {
+		return err
+	}
+	req := api.EnrollRequest{
+		Type:     api.PERMANENT,
+		Metadata: api.EnrollMetadata{Local: local, Tags: []string{"loadtest"}},
+	}
+	var resp api.EnrollResponse
+	if err := a.do(ctx, OpEnroll, "/api/fleet/agents/enroll", "ApiKey "+a.cfg.EnrollmentToken, req, &resp); err != nil {
+		return err
+	}
+	a.id = resp.Item.Id
+	a.apiKey = resp.Item.AccessApiKey
+	a.localMeta = local
+	return nil
+}
+
+func (a *agent) checkin(ctx context.Context) ([]api.Action, error) {
+	req := api.CheckinRequest{
+		AckToken:      a.ackToken,
+		LocalMetadata: &a.localMeta,
+		Message:       "Healthy",
+		Status:        api.CheckinRequestStatusOnline,
+	}
+	if a.cfg.PollTimeout > 0 {
+		pollTimeout := a.cfg.PollTimeout.String()
+		req.PollTimeout = &pollTimeout
+	}
+	var resp api.CheckinResponse
+	if err := a.do(ctx, OpCheckin, "/api/fleet/agents/"+a.id+"/checkin", "ApiKey "+a.apiKey, req, &resp); err != nil {
+		return nil, err
+	}
+	if resp.AckToken != nil {
+		a.ackToken = resp.AckToken
+	}
+	if resp.Actions == nil {
+		return nil, nil
+	}
+	return *resp.Actions, nil
+}
+
+func (a *agent) ack(ctx context.Context, actions []api.Action) error {
+	req := api.AckRequest{Events: make([]api.AckRequest_Events_Item, len(actions))}
+	for i, action := range actions {
+		if err := req.Events[i].FromGenericEvent(api.GenericEvent{
+			ActionId:  action.Id,
+			AgentId:   a.id,
+			Message:   fmt.Sprintf("Action %q of type %q acknowledged.", action.Id, action.Type),
+			Subtype:   api.ACKNOWLEDGED,
+			Timestamp: time.Now().UTC(),
+			Type:      api.ACTIONRESULT,
+		}); err != nil {
+			return err
+		}
+	}
+	var resp api.AckResponse
+	if err := a.do(ctx, OpAck, "/api/fleet/agents/"+a.id+"/acks", "ApiKey "+a.apiKey, req, &resp); err != nil {
+		return err
+	}
+	if resp.Errors {
+		err := errors.New("ack items failed")
+		a.report.fail(OpAck, err)
+		return err
+	}
+	return nil
+}
+
+// do sends the request of the operation and decodes its response into resp.
+// The latency of the requests that get a response is recorded, and the failures are counted in the report.
+// The requests interrupted by the end of the test are not counted.
+func (a *agent) do(ctx context.Context, op, path, authorization string, req, resp any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", authorization)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("User-Agent", "Elastic Agent v"+a.cfg.AgentVersion)
+
+	start := time.Now()
+	res, err := a.cfg.Client.Do(r)
+	if err != nil {
+		if ctx.Err() == nil {
+			a.report.fail(op, err)
+		}
+		return err
+	}
+	defer res.Body.Close()
+	p, err := io.ReadAll(res.Body)
+	if err != nil {
+		if ctx.Err() == nil {
+			a.report.fail(op, err)
+		}
+		return err
+	}
+	a.report.observe(op, time.Since(start))
+	if res.StatusCode != http.StatusOK {
+		err := fmt.Errorf("status %d", res.StatusCode)
+		a.report.fail(op, err)
+		if res.StatusCode == http.StatusTooManyRequests {
+			// honour the backoff asked by the server
+			if s, convErr := strconv.Atoi(res.Header.Get("Retry-After")); convErr == nil {
+				a.wait(ctx, time.Duration(s)*time.Second)
+			}
+		}
+		return err
+	}
+	if err := json.Unmarshal(p, resp); err != nil {
+		a.report.fail(op, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/action"
+	"github.com/elastic/fleet-server/v7/internal/pkg/api"
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
+	"github.com/elastic/fleet-server/v7/internal/pkg/checkin"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	esclient "github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	mockmonitor "github.com/elastic/fleet-server/v7/internal/pkg/monitor/mock"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+// fakeES is an in-memory Elasticsearch for the enroll, checkin and ack handlers of a fleet-server. It stores the
+// enrolled agents and their API keys, serves an action addressed to all the agents and records the results of their
+// acks.
+// The calls it does not serve fail as the calls of a MockBulk without expectations.
+type fakeES struct {
+	*ftesting.MockBulk
+	t *testing.T
+
+	mx     sync.Mutex
+	keys   map[string]string // the keys of the API keys by ID
+	agents map[string][]byte // the agent documents by ID
+	acked  map[string][]string
+}
+
+// testAction is the action addressed to all the agents, it is delivered by the first checkin of an agent.
+var testAction = []byte(`{"action_id":"action-1","type":"SETTINGS","agents":[],"data":{"log_level":"debug"}}`)
+
+// seqNoAfter matches the lower bound of the sequence numbers of the actions searched by a checkin.
+var seqNoAfter = regexp.MustCompile(`"_seq_no":\{"gt":(-?\d+)`)
+
+func newFakeES(t *testing.T) *fakeES {
+	return &fakeES{
+		MockBulk: ftesting.NewMockBulk(),
+		t:        t,
+		keys:     map[string]string{enrollmentKey.ID: enrollmentKey.Key},
+		agents:   make(map[string][]byte),
+		acked:    make(map[string][]string),
+	}
+}
+
+func (es *fakeES) APIKeyAuth(_ context.Context, key bulk.APIKey) (*bulk.SecurityInfo, error) {
+	es.mx.Lock()
+	defer es.mx.Unlock()
+	if k, ok := es.keys[key.ID]; !ok || k != key.Key {
+		return nil, apikey.ErrUnauthorized
+	}
+	return &bulk.SecurityInfo{UserName: key.ID, Enabled: true}, nil
+}
+
+func (es *fakeES) APIKeyCreate(_ context.Context, name, _ string, _ []byte, _ interface{}) (*bulk.APIKey, error) {
+	es.mx.Lock()
+	defer es.mx.Unlock()
+	key := &bulk.APIKey{ID: "key-" + name, Key: "secret-" + name}
+	es.keys[key.ID] = key.Key
+	return key, nil
+}
+
+func (es *fakeES) Create(_ context.Context, index, id string, body []byte, _ ...bulk.Opt) (string, error) {
+	es.mx.Lock()
+	defer es.mx.Unlock()
+	switch index {
+	case dl.FleetAgents:
+		es.agents[id] = body
+	case dl.FleetActionsResults:
+		var result model.ActionResult
+		require.NoError(es.t, json.Unmarshal(body, &result))
+		es.acked[result.AgentID] = append(es.acked[result.AgentID], result.ActionID)
+	default:
+		es.t.Errorf("unexpected document created in %s", index)
+	}
+	return id, nil
+}
+
+func (es *fakeES) Update(_ context.Context, index, _ string, _ []byte, _ ...bulk.Opt) error {
+	assert.Equal(es.t, dl.FleetAgents, index)
+	return nil
+}
+
+func (es *fakeES) ReadRaw(_ context.Context, index, id string, _ ...bulk.Opt) (*bulk.MgetResponseItem, error) {
+	es.mx.Lock()
+	defer es.mx.Unlock()
+	source, ok := es.agents[id]
+	if index != dl.FleetAgents || !ok {
+		return nil, esclient.ErrElasticNotFound
+	}
+	return &bulk.MgetResponseItem{Found: true, Source: source}, nil
+}
+
+func (es *fakeES) Search(_ context.Context, index string, body []byte, _ ...bulk.Opt) (*esclient.ResultT, error) {
+	switch index {
+	case dl.FleetPolicies:
+		policy, err := json.Marshal(model.Policy{PolicyID: "policy-1", RevisionIdx: 1})
+		require.NoError(es.t, err)
+		return &esclient.ResultT{Aggregations: map[string]esclient.Aggregation{dl.FieldPolicyID: {Buckets: []esclient.Bucket{{
+			Key:          "policy-1",
+			Aggregations: map[string]esclient.HitsT{dl.FieldRevisionIdx: {Hits: []esclient.HitT{{Source: policy}}}},
+		}}}}}, nil
+	case dl.FleetActions:
+		// the action is pending for the agents that did not ack it, it is found by its ID
+		if m := seqNoAfter.FindSubmatch(body); m != nil && string(m[1]) != "-1" {
+			return &esclient.ResultT{}, nil
+		}
+		return &esclient.ResultT{HitsT: esclient.HitsT{Hits: []esclient.HitT{{ID: "doc-1", SeqNo: 1, Source: testAction}}}}, nil
+	}
+	return &esclient.ResultT{}, nil
+}
+
+// startServer runs the enroll, checkin and ack handlers of a fleet-server in process with the static enrollment token
+// enrollment-key, and returns its URL.
+func startServer(t *testing.T, es *fakeES) string {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	port, err := ftesting.FreePort()
+	require.NoError(t, err)
+	cfg := &config.Server{}
+	cfg.InitDefaults()
+	cfg.Host = "localhost"
+	cfg.Port = port
+	cfg.StaticPolicyTokens = config.StaticPolicyTokens{
+		Enabled:      true,
+		PolicyTokens: []config.PolicyToken{{TokenKey: "enrollment-key", PolicyID: "policy-1"}},
+	}
+	verCon, err := api.BuildVersionConstraint("9.1.0")
+	require.NoError(t, err)
+	c, err := cache.New(config.Cache{NumCounters: 1000, MaxCost: 1 << 20})
+	require.NoError(t, err)
+
+	et, err := api.NewEnrollerT(verCon, cfg, es, c)
+	require.NoError(t, err)
+	gcp := mockmonitor.NewMockMonitor()
+	gcp.On("GetCheckpoint").Return(sqn.SeqNo{1})
+	pm := policy.NewMonitor(es, mockmonitor.NewMockMonitor(), cfg.Limits)
+	ct, err := api.NewCheckinT(verCon, cfg, c, checkin.NewBulk(nil), pm, gcp, action.NewDispatcher(mockmonitor.NewMockMonitor(), 0, 1), es)
+	require.NoError(t, err)
+	ack := api.NewAckT(cfg, es, c)
+
+	addr := cfg.BindEndpoints()[0]
+	srv := api.NewServer(addr, cfg, api.WithEnroller(et), api.WithCheckin(ct), api.WithAck(ack))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-errCh; err != nil && !errors.Is(err, context.Canceled) {
+			t.Error(err)
+		}
+	})
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return "http://" + addr
+}
+
+// enrollmentKey is the static enrollment key of the test server.
+var enrollmentKey = apikey.APIKey{ID: "enrollment-id", Key: "enrollment-key"}
+
+var enrollmentToken = enrollmentKey.Token()
+
+func TestRunSmoke(t *testing.T) {
+	const agents = 50
+	es := newFakeES(t)
+	url := startServer(t, es)
+
+	report, err := Run(context.Background(), Config{
+		URL:             url + "/",
+		EnrollmentToken: enrollmentToken,
+		Agents:          agents,
+		AgentVersion:    "9.1.0",
+		Duration:        2 * time.Second,
+		RampUp:          100 * time.Millisecond,
+		PollTimeout:     100 * time.Millisecond,
+		CheckinInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	enroll := report.Op(OpEnroll)
+	assert.Equal(t, agents, enroll.Count())
+	assert.Zero(t, enroll.ErrorCount(), enroll.Errors)
+	checkin := report.Op(OpCheckin)
+	// the first checkin returns the action, the long poll of the next one outlasts the run
+	assert.GreaterOrEqual(t, checkin.Count(), agents)
+	assert.Zero(t, checkin.ErrorCount(), checkin.Errors)
+	ack := report.Op(OpAck)
+	assert.Equal(t, agents, ack.Count())
+	assert.Zero(t, ack.ErrorCount(), ack.Errors)
+
+	// each agent acked the action delivered by its first checkin
+	es.mx.Lock()
+	defer es.mx.Unlock()
+	require.Len(t, es.agents, agents)
+	require.Len(t, es.acked, agents)
+	for id, actions := range es.acked {
+		assert.Contains(t, es.agents, id)
+		assert.Equal(t, []string{"action-1"}, actions)
+	}
+
+	var out strings.Builder
+	require.NoError(t, report.Write(&out))
+	assert.Contains(t, out.String(), fmt.Sprintf("%-8s %8d %8d", OpEnroll, agents, 0))
+}
+
+func TestRunErrors(t *testing.T) {
+	url := startServer(t, newFakeES(t))
+
+	report, err := Run(context.Background(), Config{
+		URL:             url,
+		EnrollmentToken: apikey.APIKey{ID: "enrollment-id", Key: "wrong-key"}.Token(),
+		Agents:          2,
+		AgentVersion:    "9.1.0",
+		Duration:        200 * time.Millisecond,
+		CheckinInterval: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	enroll := report.Op(OpEnroll)
+	assert.NotZero(t, enroll.ErrorCount())
+	assert.Equal(t, enroll.Count(), enroll.Errors["status 401"], enroll.Errors)
+	assert.Zero(t, report.Op(OpCheckin).Count())
+
+	_, err = Run(context.Background(), Config{URL: url, EnrollmentToken: enrollmentToken})
+	require.Error(t, err)
+}
+
+func TestPercentile(t *testing.T) {
+	var s OpStats
+	assert.Zero(t, s.Percentile(50))
+	for i := 100; i > 0; i-- {
+		s.latencies = append(s.latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 50*time.Millisecond, s.Percentile(50))
+	assert.Equal(t, 99*time.Millisecond, s.Percentile(99))
+	assert.Equal(t, 100*time.Millisecond, s.Percentile(100))
+	assert.Equal(t, time.Millisecond, s.Percentile(0))
+}
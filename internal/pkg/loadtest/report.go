@@ -0,0 +1,130 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package loadtest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Report is the statistics of the requests of the simulated agents for each operation.
+type Report struct {
+	mx  sync.Mutex
+	ops map[string]*OpStats
+	// Elapsed is the duration of the test.
+	Elapsed time.Duration
+}
+
+// OpStats is the statistics of the requests of an operation.
+type OpStats struct {
+	// latencies of the requests that got a response, sorted once the test is over
+	latencies []time.Duration
+	sorted    bool
+	// Errors counts the failed requests by error.
+	Errors map[string]int
+}
+
+func newReport() *Report {
+	return &Report{ops: make(map[string]*OpStats)}
+}
+
+func (r *Report) op(name string) *OpStats {
+	s, ok := r.ops[name]
+	if !ok {
+		s = &OpStats{Errors: make(map[string]int)}
+		r.ops[name] = s
+	}
+	return s
+}
+
+func (r *Report) observe(op string, d time.Duration) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	s := r.op(op)
+	s.latencies = append(s.latencies, d)
+	s.sorted = false
+}
+
+// fail counts the failed request of the operation. The errors of the transport are counted without their URL,
+// so the same error of the different agents is counted once.
+func (r *Report) fail(op string, err error) {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.op(op).Errors[err.Error()]++
+}
+
+// Op returns the statistics of the operation, they are empty if the operation was not run.
+func (r *Report) Op(name string) *OpStats {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.op(name)
+}
+
+// Count returns the number of requests that got a response.
+func (s *OpStats) Count() int {
+	return len(s.latencies)
+}
+
+// ErrorCount returns the number of failed requests.
+func (s *OpStats) ErrorCount() int {
+	n := 0
+	for _, c := range s.Errors {
+		n += c
+	}
+	return n
+}
+
+// Percentile returns the latency under which p percent of the requests got a response, or 0 if no request got one.
+func (s *OpStats) Percentile(p float64) time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	if !s.sorted {
+		slices.Sort(s.latencies)
+		s.sorted = true
+	}
+	i := int(float64(len(s.latencies))*p/100+0.5) - 1
+	return s.latencies[min(max(i, 0), len(s.latencies)-1)]
+}
+
+// Write writes the latency percentiles and the errors of each operation to w.
+func (r *Report) Write(w io.Writer) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if _, err := fmt.Fprintf(w, "elapsed %s\n%-8s %8s %8s %10s %10s %10s %10s\n", r.Elapsed.Round(time.Millisecond), "op", "count", "errors", "p50", "p90", "p99", "max"); err != nil {
+		return err
+	}
+	for _, name := range []string{OpEnroll, OpCheckin, OpAck} {
+		s := r.op(name)
+		if _, err := fmt.Fprintf(w, "%-8s %8d %8d %10s %10s %10s %10s\n", name, s.Count(), s.ErrorCount(),
+			s.Percentile(50).Round(time.Microsecond), s.Percentile(90).Round(time.Microsecond),
+			s.Percentile(99).Round(time.Microsecond), s.Percentile(100).Round(time.Microsecond)); err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{OpEnroll, OpCheckin, OpAck} {
+		errs := r.op(name).Errors
+		keys := make([]string, 0, len(errs))
+		for k := range errs {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			if _, err := fmt.Fprintf(w, "%s error: %s (%d)\n", name, k, errs[k]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -27,6 +27,7 @@ const (
 	ECSURLFull   = "url.full"
 	ECSURLDomain = "url.domain"
 	ECSURLPort   = "url.port"
+	ECSURLPath   = "url.path"
 
 	// Client
 	ECSClientAddress = "client.address"
@@ -71,4 +72,5 @@ const (
 	PolicyOutputName      = "fleet.policy.output.name"
 	RevisionIdx           = "fleet.revision_idx"
 	CoordinatorIdx        = "fleet.coordinator_idx"
+	Route                 = "fleet.route"
 )
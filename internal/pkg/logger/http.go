@@ -22,6 +22,7 @@ import (
 	"go.elastic.co/apm/module/apmzerolog/v2"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/clientip"
 )
 
 const (
@@ -109,6 +110,13 @@ func splitAddr(addr string) (host string, port int) {
 	return //nolint:nakedret // short function
 }
 
+// clientAddr returns the IP address of the client of r, and true if it was forwarded by a trusted proxy.
+func clientAddr(r *http.Request) (string, bool) {
+	clientIP := clientip.FromRequest(r)
+	remoteIP, _ := splitAddr(r.RemoteAddr)
+	return clientIP, clientIP != "" && clientIP != remoteIP
+}
+
 // Expects HTTP version in form of HTTP/x.y
 func stripHTTP(h string) string {
 
@@ -152,8 +160,10 @@ func httpMeta(r *http.Request, e *zerolog.Event) {
 		e.Str(APIKeyID, apiKey.ID)
 	}
 
-	// Client info
-	if r.RemoteAddr != "" {
+	// Client info, the address of the client forwarded by a trusted proxy replaces the address of the proxy
+	if clientIP, forwarded := clientAddr(r); forwarded {
+		e.Str(ECSClientAddress, clientIP)
+	} else if r.RemoteAddr != "" {
 		e.Str(ECSClientAddress, r.RemoteAddr)
 	}
 
@@ -162,8 +172,10 @@ func httpMeta(r *http.Request, e *zerolog.Event) {
 }
 
 func httpDebug(r *http.Request, e *zerolog.Event) {
-	// Client info
-	if r.RemoteAddr != "" {
+	// Client info, the port is only known if the client is the peer
+	if clientIP, forwarded := clientAddr(r); forwarded {
+		e.Str(ECSClientIP, clientIP)
+	} else if r.RemoteAddr != "" {
 		remoteIP, remotePort := splitAddr(r.RemoteAddr)
 		e.Str(ECSClientIP, remoteIP)
 		e.Int(ECSClientPort, remotePort)
@@ -232,6 +244,14 @@ func Middleware(next http.Handler) http.Handler {
 		ctx = context.WithValue(ctx, ctxTSKey{}, start)
 		r = r.WithContext(ctx)
 
+		// handlers mark the phases of the request on the timer of the request context
+		if slow := StartSlowTimer("http request", SlowThresholds().Handler); slow != nil {
+			r = r.WithContext(WithSlowTimer(ctx, slow))
+			defer slow.End(ctx, func(e *zerolog.Event) {
+				e.Str(ECSHTTPRequestMethod, r.Method).Str(ECSURLPath, r.URL.Path)
+			})
+		}
+
 		e := zlog.Info()
 
 		if !e.Enabled() {
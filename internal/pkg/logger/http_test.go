@@ -16,6 +16,8 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/clientip"
 )
 
 func TestMiddleware(t *testing.T) {
@@ -57,3 +59,60 @@ func TestMiddleware(t *testing.T) {
 	require.Truef(t, ok, "expected to find key: %s in %v", ECSServerAddress, obj)
 	require.NotEmpty(t, v)
 }
+
+func TestHTTPMetaClient(t *testing.T) {
+	proxies, err := clientip.New([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		address    string
+		ip         string
+		port       any
+	}{{
+		name:       "direct",
+		remoteAddr: "192.0.2.1:1234",
+		address:    "192.0.2.1:1234",
+		ip:         "192.0.2.1",
+		port:       float64(1234),
+	}, {
+		name:       "trusted proxy",
+		remoteAddr: "10.0.0.1:1234",
+		forwarded:  "192.0.2.1",
+		address:    "192.0.2.1",
+		ip:         "192.0.2.1",
+	}, {
+		name:       "untrusted proxy",
+		remoteAddr: "198.51.100.1:1234",
+		forwarded:  "192.0.2.1",
+		address:    "198.51.100.1:1234",
+		ip:         "198.51.100.1",
+		port:       float64(1234),
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var obj map[string]any
+			h := clientip.Middleware(proxies)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				var b bytes.Buffer
+				log := zerolog.New(&b)
+				e := log.Info()
+				httpMeta(r, e)
+				httpDebug(r, e)
+				e.Send()
+				require.NoError(t, json.Unmarshal(b.Bytes(), &obj))
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.forwarded != "" {
+				r.Header.Set(clientip.HeaderForwardedFor, tc.forwarded)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			require.Equal(t, tc.address, obj[ECSClientAddress])
+			require.Equal(t, tc.ip, obj[ECSClientIP])
+			require.Equal(t, tc.port, obj[ECSClientPort])
+		})
+	}
+}
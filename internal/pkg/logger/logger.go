@@ -36,18 +36,23 @@ type WriterSync interface {
 // Logger will manage the zerolog/log.Logger variable.
 // An instance with TraceLevel is always created and log level is controlled through zerolog.GlobalLevel.
 type Logger struct {
-	cfg  *config.Config
-	sync WriterSync
-	name string
-	log  zerolog.Logger
+	cfg    *config.Config
+	sync   WriterSync
+	redact *redactWriter
+	name   string
+	log    zerolog.Logger
 }
 
 // Reload reloads the logger configuration.
 // If only the log level has changed then only GlobalLogLevel is set.
+// The slow thresholds and the traced agents are always updated from cfg.
+// The secrets scrubbed from the output are always updated from cfg.
 func (l *Logger) Reload(_ context.Context, cfg *config.Config) error {
 	if levelChanged(cfg) {
 		zerolog.SetGlobalLevel(level(cfg))
 	}
+	SetSlowThresholds(cfg.Logging.Slow)
+	SetTraceAgents(cfg.Logging.TraceAgents)
 	if !l.cfg.Logging.EqualExcludeLevel(cfg.Logging) {
 		// sync before set
 		l.Sync()
@@ -56,11 +61,20 @@ func (l *Logger) Reload(_ context.Context, cfg *config.Config) error {
 		if err != nil {
 			return err
 		}
-		l.log = l.log.Output(out)
+		prev := l.sync
+		l.redact = newRedactWriter(out, cfg.Secrets())
+		l.log = l.log.Output(l.redact)
 		l.sync = wr
 
 		log.Logger = l.log
 		zerolog.DefaultContextLogger = &l.log // introduces race conditions in integration test?
+
+		// release the log file that is no longer written to
+		if c, ok := prev.(io.Closer); ok && prev != WriterSync(os.Stderr) {
+			c.Close() //nolint: errcheck // nowhere to report an error
+		}
+	} else if l.redact != nil {
+		l.redact.setSecrets(cfg.Secrets())
 	}
 	l.cfg = cfg
 	return nil
@@ -78,12 +92,17 @@ func Init(cfg *config.Config, svcName string) (*Logger, error) {
 	var err error
 	once.Do(func() {
 		zerolog.SetGlobalLevel(level(cfg))
+		SetSlowThresholds(cfg.Logging.Slow)
+		SetTraceAgents(cfg.Logging.TraceAgents)
 
-		out, wr, err := getOutput(cfg)
+		var out io.Writer
+		var wr WriterSync
+		out, wr, err = getOutput(cfg)
 		if err != nil {
 			return
 		}
-		l := ecszerolog.New(out)
+		redact := newRedactWriter(out, cfg.Secrets())
+		l := ecszerolog.New(redact)
 		if svcName != "" {
 			l = l.With().Str(ECSServiceName, svcName).Str(ECSServiceType, svcName).Logger()
 		}
@@ -91,10 +110,11 @@ func Init(cfg *config.Config, svcName string) (*Logger, error) {
 		log.Logger = l
 		zerolog.DefaultContextLogger = &l
 		gLogger = &Logger{
-			cfg:  cfg,
-			sync: wr,
-			name: svcName,
-			log:  l,
+			cfg:    cfg,
+			sync:   wr,
+			redact: redact,
+			name:   svcName,
+			log:    l,
 		}
 	})
 	return gLogger, err
@@ -135,6 +155,10 @@ func fileRotatorOut(cfg *config.Config) (io.Writer, WriterSync, error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	if files.KeepAge > 0 {
+		ar := newAgeRotator(rotator, filename, files.KeepAge)
+		return ar, ar, nil
+	}
 	return rotator, rotator, nil
 }
 
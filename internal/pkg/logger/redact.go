@@ -0,0 +1,73 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+)
+
+// minSecretLen is the length of the shortest secret that is scrubbed from the log output,
+// shorter values would match unrelated text.
+const minSecretLen = 6
+
+var redacted = []byte("[redacted]")
+
+// redactWriter replaces the secrets of the configuration in the log output before it is written.
+// The configuration is redacted when it is logged, the writer catches secrets that are logged by accident.
+type redactWriter struct {
+	out io.Writer
+
+	mu      sync.RWMutex
+	secrets [][]byte
+}
+
+func newRedactWriter(out io.Writer, secrets []string) *redactWriter {
+	w := &redactWriter{out: out}
+	w.setSecrets(secrets)
+	return w
+}
+
+// setSecrets replaces the secrets that are scrubbed.
+func (w *redactWriter) setSecrets(secrets []string) {
+	values := make([][]byte, 0, len(secrets))
+	for _, s := range secrets {
+		if len(s) < minSecretLen {
+			continue
+		}
+		values = append(values, []byte(s))
+		// the JSON encoded form is written when a secret contains characters that are escaped
+		if p, err := json.Marshal(s); err == nil && string(p[1:len(p)-1]) != s {
+			values = append(values, p[1:len(p)-1])
+		}
+	}
+	// longer secrets first so a secret containing another one is fully replaced
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	w.mu.Lock()
+	w.secrets = values
+	w.mu.Unlock()
+}
+
+// Write writes p to the output with the secrets replaced.
+func (w *redactWriter) Write(p []byte) (int, error) {
+	w.mu.RLock()
+	secrets := w.secrets
+	w.mu.RUnlock()
+
+	out := p
+	for _, s := range secrets {
+		if bytes.Contains(out, s) {
+			out = bytes.ReplaceAll(out, s, redacted)
+		}
+	}
+	if _, err := w.out.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,52 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package logger
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := newRedactWriter(&b, []string{"service-token", "service-token-suffix", "short", `pass"word\`})
+	l := zerolog.New(w)
+
+	l.Info().Str("token", "service-token-suffix").Str("other", "service-token").Msg("connecting with service-token")
+	l.Info().Str("password", `pass"word\`).Msg("short values are kept")
+
+	out := b.String()
+	assert.NotContains(t, out, "service-token")
+	assert.NotContains(t, out, `pass\"word\\`)
+	assert.Contains(t, out, `"token":"[redacted]"`)
+	assert.Contains(t, out, `"other":"[redacted]"`)
+	assert.Contains(t, out, `"password":"[redacted]"`)
+	assert.Contains(t, out, "connecting with [redacted]")
+	assert.Contains(t, out, "short values are kept")
+}
+
+func TestLoggerReloadSecrets(t *testing.T) {
+	logger, err := Init(stderrcfg(), "test")
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	logger.cfg = stderrcfg()
+	logger.sync = &nopSync{}
+	logger.redact = newRedactWriter(&b, nil)
+	l := zerolog.New(logger.redact)
+
+	cfg := stderrcfg()
+	cfg.Output.Elasticsearch.ServiceToken = "reloaded-token"
+	require.NoError(t, logger.Reload(context.Background(), cfg))
+
+	l.Info().Msg("using reloaded-token")
+	assert.NotContains(t, b.String(), "reloaded-token")
+	assert.Contains(t, b.String(), "using [redacted]")
+}
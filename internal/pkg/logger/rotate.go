@@ -0,0 +1,80 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/file"
+)
+
+// keepAgeInterval is the minimum time between two checks for expired log files.
+var keepAgeInterval = time.Minute
+
+// ageRotator removes the log files of a rotator that are older than keepAge.
+// The rotator does not report when it rotates, so the files are checked on write, at most once every keepAgeInterval.
+type ageRotator struct {
+	*file.Rotator
+
+	pattern string
+	keepAge time.Duration
+
+	mu        sync.Mutex
+	lastCheck time.Time
+}
+
+func newAgeRotator(r *file.Rotator, filename string, keepAge time.Duration) *ageRotator {
+	a := &ageRotator{
+		Rotator: r,
+		// matches the {filename}-{datetime}[-{index}].ndjson files written by the rotator
+		pattern: filename + "-*.ndjson",
+		keepAge: keepAge,
+	}
+	a.removeExpired(time.Now())
+	return a
+}
+
+// Write writes p to the log file.
+func (a *ageRotator) Write(p []byte) (int, error) {
+	n, err := a.Rotator.Write(p)
+
+	now := time.Now()
+	a.mu.Lock()
+	if now.Sub(a.lastCheck) >= keepAgeInterval {
+		a.removeExpired(now)
+	}
+	a.mu.Unlock()
+	return n, err
+}
+
+// removeExpired removes the files last modified before keepAge.
+// The most recently modified file is the active one and is never removed.
+func (a *ageRotator) removeExpired(now time.Time) {
+	a.lastCheck = now
+	files, err := filepath.Glob(a.pattern)
+	if err != nil || len(files) < 2 {
+		return
+	}
+	modTimes := make(map[string]time.Time, len(files))
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		modTimes[f] = fi.ModTime()
+	}
+	sort.Slice(files, func(i, j int) bool { return modTimes[files[i]].Before(modTimes[files[j]]) })
+
+	cutoff := now.Add(-a.keepAge)
+	for _, f := range files[:len(files)-1] {
+		if modTimes[f].Before(cutoff) {
+			_ = os.Remove(f)
+		}
+	}
+}
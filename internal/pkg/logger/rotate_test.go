@@ -0,0 +1,94 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/file"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+func filecfg(t *testing.T) *config.Config {
+	cfg := &config.Config{}
+	cfg.InitDefaults()
+	cfg.Logging.Files = &config.LoggingFiles{}
+	cfg.Logging.Files.InitDefaults()
+	cfg.Logging.Files.Path = t.TempDir()
+	cfg.Logging.Files.RotateOnStartup = false
+	return cfg
+}
+
+func TestFileRotatorOutRotates(t *testing.T) {
+	cfg := filecfg(t)
+	cfg.Logging.Files.MaxSize = 1024
+	cfg.Logging.Files.MaxBackups = 2
+
+	out, wr, err := getOutput(cfg)
+	require.NoError(t, err)
+	line := []byte(strings.Repeat("x", 99) + "\n")
+	for i := 0; i < 50; i++ {
+		_, err := out.Write(line)
+		require.NoError(t, err)
+	}
+	require.NoError(t, wr.Sync())
+
+	files, err := filepath.Glob(filepath.Join(cfg.Logging.Files.Path, "fleet-server.log-*.ndjson"))
+	require.NoError(t, err)
+	// the active file and the configured number of backups
+	assert.Len(t, files, 3)
+}
+
+func TestFileRotatorOutKeepAge(t *testing.T) {
+	cfg := filecfg(t)
+	cfg.Logging.Files.KeepAge = time.Hour
+	dir := cfg.Logging.Files.Path
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"fleet-server.log-20240101.ndjson", "fleet-server.log-20240101-1.ndjson"} {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, []byte("{}\n"), 0o600))
+		require.NoError(t, os.Chtimes(p, old, old))
+	}
+	recent := filepath.Join(dir, "fleet-server.log-20240102.ndjson")
+	require.NoError(t, os.WriteFile(recent, []byte("{}\n"), 0o600))
+	unrelated := filepath.Join(dir, "other.log-20240101.ndjson")
+	require.NoError(t, os.WriteFile(unrelated, []byte("{}\n"), 0o600))
+	require.NoError(t, os.Chtimes(unrelated, old, old))
+
+	out, wr, err := getOutput(cfg)
+	require.NoError(t, err)
+	_, err = out.Write([]byte("{}\n"))
+	require.NoError(t, err)
+	require.NoError(t, wr.Sync())
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{recent, unrelated}, files)
+}
+
+func TestAgeRotatorKeepsActiveFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "fleet-server.log")
+	active := filename + "-20240101.ndjson"
+	require.NoError(t, os.WriteFile(active, []byte("{}\n"), 0o600))
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(active, old, old))
+
+	r, err := file.NewFileRotator(filename, file.RotateOnStartup(false))
+	require.NoError(t, err)
+	ar := newAgeRotator(r, filename, time.Minute)
+	defer ar.Close()
+
+	_, err = os.Stat(active)
+	assert.NoError(t, err, "the only log file is the active one")
+}
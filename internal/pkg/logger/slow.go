@@ -0,0 +1,135 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package logger
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+var slowThresholds atomic.Pointer[config.LoggingSlow]
+
+// SetSlowThresholds replaces the thresholds of the slow operation logging.
+// It is called when the logger is initialized or reloaded.
+func SetSlowThresholds(cfg config.LoggingSlow) {
+	slowThresholds.Store(&cfg)
+}
+
+// SlowThresholds returns the thresholds of the slow operation logging.
+// All thresholds are zero, and the logging disabled, until the logger is initialized.
+func SlowThresholds() config.LoggingSlow {
+	if t := slowThresholds.Load(); t != nil {
+		return *t
+	}
+	return config.LoggingSlow{}
+}
+
+type slowPhase struct {
+	name string
+	dur  time.Duration
+	wait bool
+}
+
+// SlowTimer times an operation and logs a single warning with the time spent in each phase when it is slower than its threshold.
+// A nil SlowTimer is returned when the threshold disables the logging, all methods can be called on it.
+type SlowTimer struct {
+	op        string
+	threshold time.Duration
+
+	mu     sync.Mutex
+	start  time.Time
+	last   time.Time
+	phases []slowPhase
+}
+
+// StartSlowTimer starts timing operation op.
+func StartSlowTimer(op string, threshold time.Duration) *SlowTimer {
+	if threshold <= 0 {
+		return nil
+	}
+	now := time.Now()
+	return &SlowTimer{op: op, threshold: threshold, start: now, last: now}
+}
+
+// Phase records the time since the previous phase as the phase name.
+func (t *SlowTimer) Phase(name string) {
+	t.record(name, false)
+}
+
+// Wait records the time since the previous phase as the phase name.
+// The time of a wait phase is expected, such as a long poll, and is not counted against the threshold.
+func (t *SlowTimer) Wait(name string) {
+	t.record(name, true)
+}
+
+func (t *SlowTimer) record(name string, wait bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.phases = append(t.phases, slowPhase{name: name, dur: now.Sub(t.last), wait: wait})
+	t.last = now
+}
+
+// End ends the operation, it is logged with the logger of ctx if it was slow.
+// The time since the last phase is recorded as the phase "end".
+// The fields set by fn are added to the log line.
+func (t *SlowTimer) End(ctx context.Context, fn func(e *zerolog.Event)) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if d := now.Sub(t.last); d > 0 && len(t.phases) > 0 {
+		t.phases = append(t.phases, slowPhase{name: "end", dur: d})
+	}
+	total := now.Sub(t.start)
+	active := total
+	for _, p := range t.phases {
+		if p.wait {
+			active -= p.dur
+		}
+	}
+	if active <= t.threshold {
+		return
+	}
+
+	phases := zerolog.Dict()
+	for _, p := range t.phases {
+		phases = phases.Dur(p.name, p.dur)
+	}
+	e := zerolog.Ctx(ctx).Warn().
+		Str("slow.operation", t.op).
+		Dur("slow.threshold", t.threshold).
+		Dur("slow.active", active).
+		Dict("slow.phases", phases).
+		Int64(ECSEventDuration, total.Nanoseconds())
+	if fn != nil {
+		fn(e)
+	}
+	e.Msg("slow operation")
+}
+
+type slowTimerKey struct{}
+
+// WithSlowTimer returns a copy of ctx that carries t.
+func WithSlowTimer(ctx context.Context, t *SlowTimer) context.Context {
+	return context.WithValue(ctx, slowTimerKey{}, t)
+}
+
+// SlowTimerFromContext returns the timer of ctx, or nil.
+func SlowTimerFromContext(ctx context.Context) *SlowTimer {
+	t, _ := ctx.Value(slowTimerKey{}).(*SlowTimer)
+	return t
+}
@@ -0,0 +1,129 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+func TestSlowTimer(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		slow := StartSlowTimer("op", 0)
+		require.Nil(t, slow)
+		slow.Phase("a")
+		slow.Wait("b")
+		slow.End(context.Background(), nil)
+	})
+
+	t.Run("fast operation", func(t *testing.T) {
+		var b bytes.Buffer
+		ctx := zerolog.New(&b).WithContext(context.Background())
+		slow := StartSlowTimer("op", time.Hour)
+		slow.Phase("a")
+		slow.End(ctx, nil)
+		assert.Empty(t, b.String())
+	})
+
+	t.Run("wait is not slow", func(t *testing.T) {
+		var b bytes.Buffer
+		ctx := zerolog.New(&b).WithContext(context.Background())
+		slow := StartSlowTimer("op", 20*time.Millisecond)
+		time.Sleep(30 * time.Millisecond)
+		slow.Wait("long_poll")
+		slow.End(ctx, nil)
+		assert.Empty(t, b.String())
+	})
+
+	t.Run("slow operation", func(t *testing.T) {
+		var b bytes.Buffer
+		ctx := zerolog.New(&b).With().Str(ECSHTTPRequestID, "request-1").Logger().WithContext(context.Background())
+		slow := StartSlowTimer("op", 10*time.Millisecond)
+		slow.Phase("fast")
+		time.Sleep(20 * time.Millisecond)
+		slow.Phase("slow")
+		slow.End(ctx, func(e *zerolog.Event) {
+			e.Str("index", "test")
+		})
+
+		var line map[string]any
+		require.NoError(t, json.Unmarshal(b.Bytes(), &line))
+		assert.Equal(t, "warn", line[zerolog.LevelFieldName])
+		assert.Equal(t, "slow operation", line["message"])
+		assert.Equal(t, "op", line["slow.operation"])
+		assert.Equal(t, "request-1", line[ECSHTTPRequestID])
+		assert.Equal(t, "test", line["index"])
+		phases, ok := line["slow.phases"].(map[string]any)
+		require.True(t, ok)
+		assert.Contains(t, phases, "fast")
+		assert.GreaterOrEqual(t, phases["slow"], float64(20))
+	})
+}
+
+func TestSlowTimerContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, SlowTimerFromContext(ctx))
+	slow := StartSlowTimer("op", time.Second)
+	assert.Same(t, slow, SlowTimerFromContext(WithSlowTimer(ctx, slow)))
+}
+
+func TestMiddlewareSlowHandler(t *testing.T) {
+	prev := SlowThresholds()
+	SetSlowThresholds(config.LoggingSlow{Handler: 20 * time.Millisecond})
+	t.Cleanup(func() { SetSlowThresholds(prev) })
+
+	tests := map[string]struct {
+		handler func(r *http.Request)
+		slow    bool
+	}{
+		"slow handler": {
+			handler: func(r *http.Request) {
+				time.Sleep(30 * time.Millisecond)
+				SlowTimerFromContext(r.Context()).Phase("setup")
+			},
+			slow: true,
+		},
+		"long poll": {
+			handler: func(r *http.Request) {
+				SlowTimerFromContext(r.Context()).Phase("setup")
+				time.Sleep(30 * time.Millisecond)
+				SlowTimerFromContext(r.Context()).Wait("long_poll")
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var b bytes.Buffer
+			ctx := zerolog.New(&b).Level(zerolog.WarnLevel).WithContext(context.Background())
+			h := Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				tc.handler(r)
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/api/fleet/agents/agent-1/checkin", nil).WithContext(ctx)
+			req.Header.Set(HeaderRequestID, "request-1")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if !tc.slow {
+				assert.Empty(t, b.String())
+				return
+			}
+			var line map[string]any
+			require.NoError(t, json.Unmarshal(b.Bytes(), &line))
+			assert.Equal(t, "http request", line["slow.operation"])
+			assert.Equal(t, "request-1", line[ECSHTTPRequestID])
+			assert.Equal(t, "/api/fleet/agents/agent-1/checkin", line[ECSURLPath])
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package logger
+
+import (
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// Fields of the policy dispatch decision events.
+const (
+	Decision       = "fleet.decision.name"
+	DecisionResult = "fleet.decision.result"
+	DecisionReason = "fleet.decision.reason"
+)
+
+var traceAgents atomic.Pointer[map[string]struct{}]
+
+// SetTraceAgents replaces the agents whose policy dispatch decisions are traced.
+// It is called when the logger is initialized or reloaded.
+func SetTraceAgents(ids []string) {
+	m := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		m[id] = struct{}{}
+	}
+	traceAgents.Store(&m)
+}
+
+// IsTraced returns true if the policy dispatch decisions of agentID are traced regardless of the log level.
+func IsTraced(agentID string) bool {
+	m := traceAgents.Load()
+	if m == nil {
+		return false
+	}
+	_, ok := (*m)[agentID]
+	return ok
+}
+
+// TraceDecision returns a debug event for a policy dispatch decision about agentID, the result is logged when the event is sent.
+// The event is logged with the debug level of zlog, and logged at any level when agentID is listed in logging.trace_agents.
+func TraceDecision(zlog *zerolog.Logger, agentID, decision, result string) *zerolog.Event {
+	var e *zerolog.Event
+	if IsTraced(agentID) {
+		// NoLevel events are not dropped by the global level
+		e = zlog.Log().Str(zerolog.LevelFieldName, zerolog.LevelDebugValue)
+	} else {
+		e = zlog.Debug()
+	}
+	return e.Str(AgentID, agentID).Str(Decision, decision).Str(DecisionResult, result)
+}
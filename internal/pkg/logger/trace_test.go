@@ -0,0 +1,66 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceDecision(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	t.Cleanup(func() { SetTraceAgents(nil) })
+	SetTraceAgents([]string{"agent-traced"})
+
+	var b bytes.Buffer
+	l := zerolog.New(&b)
+
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	TraceDecision(&l, "agent-other", "action", "included").Msg("not traced")
+	assert.Empty(t, b.String())
+
+	TraceDecision(&l, "agent-traced", "action", "excluded").Str(DecisionReason, "expired").Msg("traced")
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal(b.Bytes(), &m))
+	assert.Equal(t, map[string]interface{}{
+		zerolog.LevelFieldName:   "debug",
+		zerolog.MessageFieldName: "traced",
+		AgentID:                  "agent-traced",
+		Decision:                 "action",
+		DecisionResult:           "excluded",
+		DecisionReason:           "expired",
+	}, m)
+
+	b.Reset()
+	m = nil
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	TraceDecision(&l, "agent-other", "action", "included").Msg("debug level")
+	require.NoError(t, json.Unmarshal(b.Bytes(), &m))
+	assert.Equal(t, "debug", m[zerolog.LevelFieldName])
+	assert.Equal(t, "agent-other", m[AgentID])
+}
+
+func TestLoggerReloadTraceAgents(t *testing.T) {
+	logger, err := Init(stderrcfg(), "test")
+	require.NoError(t, err)
+	t.Cleanup(func() { SetTraceAgents(nil) })
+	logger.cfg = stderrcfg()
+	logger.sync = &nopSync{}
+
+	cfg := stderrcfg()
+	cfg.Logging.TraceAgents = []string{"agent-1"}
+	require.NoError(t, logger.Reload(context.Background(), cfg))
+	assert.True(t, IsTraced("agent-1"))
+	assert.False(t, IsTraced("agent-2"))
+
+	require.NoError(t, logger.Reload(context.Background(), stderrcfg()))
+	assert.False(t, IsTraced("agent-1"))
+}
@@ -0,0 +1,66 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Code generated by schemagen. DO NOT EDIT.
+
+package model
+
+import "encoding/json"
+
+// Action An Elastic Agent action
+type Action struct {
+	ESDocument
+
+	// The unique identifier for the Elastic Agent action. There could be multiple documents with the same action_id if the action is split into two separate documents.
+	ActionID string `json:"action_id,omitempty"`
+
+	// The Agent IDs the action is intended for. No support for json.RawMessage with the current generator. Could be useful to lazy parse the agent ids
+	Agents []string `json:"agents,omitempty"`
+
+	// The opaque payload.
+	Data json.RawMessage `json:"data,omitempty"`
+
+	// The action expiration date/time
+	Expiration string `json:"expiration,omitempty"`
+
+	// The input type the actions should be routed to.
+	InputType string `json:"input_type,omitempty"`
+
+	// The minimum time (in seconds) provided for an action execution when scheduled by fleet-server.
+	MinimumExecutionDuration int64 `json:"minimum_execution_duration,omitempty"`
+
+	// Namespaces
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// The action_id of the failed UPGRADE action that fleet-server re-issued this action for.
+	RetryOf string `json:"retry_of,omitempty"`
+
+	// The rollout duration (in seconds) provided for an action execution when scheduled by fleet-server.
+	RolloutDurationSeconds int64   `json:"rollout_duration_seconds,omitempty"`
+	Signed                 *Signed `json:"signed,omitempty"`
+
+	// The action start date/time
+	StartTime string `json:"start_time,omitempty"`
+
+	// The filter of the agents the action targets when it is not addressed to a list of agents. The action is delivered to each matching agent once.
+	Target *ActionTarget `json:"target,omitempty"`
+
+	// The optional action timeout in seconds
+	Timeout int64 `json:"timeout,omitempty"`
+
+	// Date/time the action was created
+	Timestamp string `json:"@timestamp,omitempty"`
+
+	// APM traceparent for the action.
+	Traceparent string `json:"traceparent,omitempty"`
+
+	// The action type. INPUT_ACTION is the value for the actions that suppose to be routed to the endpoints/beats.
+	Type string `json:"type,omitempty"`
+
+	// The attempt of the upgrade made by an UPGRADE action re-issued by fleet-server, the UPGRADE action of the user is the first attempt.
+	UpgradeAttempt int64 `json:"upgrade_attempt,omitempty"`
+
+	// The ID of the user who created the action.
+	UserID string `json:"user_id,omitempty"`
+}
@@ -0,0 +1,225 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Code generated by schemagen. DO NOT EDIT.
+
+package model
+
+import "encoding/json"
+
+// Agent An Elastic Agent that has enrolled into Fleet
+type Agent struct {
+	ESDocument
+
+	// Date/time the API keys of the unenrolled Elastic Agent were invalidated
+	APIKeysInvalidatedAt string `json:"api_keys_invalidated_at,omitempty"`
+
+	// Set while the API keys of the unenrolled Elastic Agent remain to be invalidated
+	APIKeysInvalidationPending bool `json:"api_keys_invalidation_pending,omitempty"`
+
+	// ID of the API key the Elastic Agent must used to contact Fleet Server
+	AccessAPIKeyID string `json:"access_api_key_id,omitempty"`
+
+	// The last acknowledged action sequence number for the Elastic Agent
+	ActionSeqNo []int64 `json:"action_seq_no,omitempty"`
+
+	// Active flag
+	Active bool           `json:"active"`
+	Agent  *AgentMetadata `json:"agent,omitempty"`
+
+	// Agent reason for unenroll/uninstall annotation.
+	AuditUnenrolledReason string `json:"audit_unenrolled_reason,omitempty"`
+
+	// Agent timestamp for audit unenroll/uninstall action
+	AuditUnenrolledTime string `json:"audit_unenrolled_time,omitempty"`
+
+	// Elastic Agent components detailed status information
+	Components []ComponentsItems `json:"components,omitempty"`
+
+	// Deprecated. Use Outputs instead. API key the Elastic Agent uses to authenticate with elasticsearch
+	DefaultAPIKey string `json:"default_api_key,omitempty"`
+
+	// Deprecated. Use Outputs instead. Default API Key History
+	DefaultAPIKeyHistory []ToRetireAPIKeyIdsItems `json:"default_api_key_history,omitempty"`
+
+	// Deprecated. Use Outputs instead. ID of the API key the Elastic Agent uses to authenticate with elasticsearch
+	DefaultAPIKeyID string `json:"default_api_key_id,omitempty"`
+
+	// The idempotency key of the enrollment request, a retry of the request with the same key re-issues the enrollment of this agent until it expires
+	EnrollIdempotency *EnrollIdempotency `json:"enroll_idempotency,omitempty"`
+
+	// Date/time the Elastic Agent enrolled
+	EnrolledAt string `json:"enrolled_at"`
+
+	// Enrollment ID
+	EnrollmentID string `json:"enrollment_id,omitempty"`
+
+	// The last error reported by the Elastic Agent when acknowledging an action
+	LastActionError *LastActionError `json:"last_action_error,omitempty"`
+
+	// Date/time the Elastic Agent checked in last time
+	LastCheckin string `json:"last_checkin,omitempty"`
+
+	// Last checkin message
+	LastCheckinMessage string `json:"last_checkin_message,omitempty"`
+
+	// Last checkin status
+	LastCheckinStatus string `json:"last_checkin_status,omitempty"`
+
+	// The connection the Elastic Agent last checked in with
+	LastConnection *LastConnection `json:"last_connection,omitempty"`
+
+	// Date/time the Elastic Agent was last updated
+	LastUpdated string `json:"last_updated,omitempty"`
+
+	// Lifecycle state of the Elastic Agent: enrolled, online, offline, unenrolling or unenrolled
+	LifecycleState string `json:"lifecycle_state,omitempty"`
+
+	// Local metadata information for the Elastic Agent
+	LocalMetadata json.RawMessage `json:"local_metadata,omitempty"`
+
+	// Namespaces
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// The age in seconds, truncated to the minute, of the oldest action pending for the Elastic Agent as of its last checkin
+	OldestPendingActionAge int64 `json:"oldest_pending_action_age,omitempty"`
+
+	// Outputs is the policy output data, mapping the output name to its data
+	Outputs map[string]*PolicyOutput `json:"outputs,omitempty"`
+
+	// Packages array
+	Packages []string `json:"packages,omitempty"`
+
+	// The number of actions pending for the Elastic Agent, delivered but not yet acknowledged or not yet delivered, as of its last checkin
+	PendingActionsCount int64 `json:"pending_actions_count,omitempty"`
+
+	// The current policy coordinator for the Elastic Agent
+	PolicyCoordinatorIdx int64 `json:"policy_coordinator_idx,omitempty"`
+
+	// The policy ID for the Elastic Agent
+	PolicyID string `json:"policy_id,omitempty"`
+
+	// Deprecated. Use Outputs instead. The policy output permissions hash
+	PolicyOutputPermissionsHash string `json:"policy_output_permissions_hash,omitempty"`
+
+	// The current policy revision_idx for the Elastic Agent
+	PolicyRevisionIdx int64 `json:"policy_revision_idx,omitempty"`
+
+	// hash of token provided during enrollment that allows replacement by another enrollment with same ID
+	ReplaceToken string `json:"replace_token,omitempty"`
+
+	// Shared ID
+	SharedID string `json:"shared_id,omitempty"`
+
+	// True while the Elastic Agent changes its status more often than the flapping threshold, its status changes are then damped
+	StatusFlapping bool `json:"status_flapping,omitempty"`
+
+	// User provided tags for the Elastic Agent
+	Tags []string `json:"tags,omitempty"`
+
+	// Type
+	Type string `json:"type"`
+
+	// Date/time the Elastic Agent unenrolled
+	UnenrolledAt string `json:"unenrolled_at,omitempty"`
+
+	// Reason the Elastic Agent was unenrolled
+	UnenrolledReason string `json:"unenrolled_reason,omitempty"`
+
+	// Date/time the Elastic Agent unenrolled started
+	UnenrollmentStartedAt string `json:"unenrollment_started_at,omitempty"`
+
+	// Unhealthy reason: input/output/other
+	UnhealthyReason []string `json:"unhealthy_reason,omitempty"`
+
+	// Date/time the Elastic Agent was last updated
+	UpdatedAt string `json:"updated_at,omitempty"`
+
+	// List of timestamps of attempts of Elastic Agent automatic upgrades
+	UpgradeAttempts []string `json:"upgrade_attempts,omitempty"`
+
+	// Additional upgrade status details.
+	UpgradeDetails *UpgradeDetails `json:"upgrade_details,omitempty"`
+
+	// Date/time the Elastic Agent started the current upgrade
+	UpgradeStartedAt string `json:"upgrade_started_at,omitempty"`
+
+	// Upgrade status: started, retrying, failed, upgrade_failed once the automatic retries are exhausted, or completed
+	UpgradeStatus string `json:"upgrade_status,omitempty"`
+
+	// Version the Elastic Agent is upgrading to, or was last upgraded to
+	UpgradeTargetVersion string `json:"upgrade_target_version,omitempty"`
+
+	// Date/time the Elastic Agent was last upgraded
+	UpgradedAt string `json:"upgraded_at,omitempty"`
+
+	// User provided metadata information for the Elastic Agent
+	UserProvidedMetadata json.RawMessage `json:"user_provided_metadata,omitempty"`
+}
+
+// ComponentsItems
+type ComponentsItems struct {
+	ID      string       `json:"id,omitempty"`
+	Message string       `json:"message,omitempty"`
+	Status  string       `json:"status,omitempty"`
+	Type    string       `json:"type,omitempty"`
+	Units   []UnitsItems `json:"units,omitempty"`
+}
+
+// EnrollIdempotency The idempotency key of the enrollment request, a retry of the request with the same key re-issues the enrollment of this agent until it expires
+type EnrollIdempotency struct {
+	// Date/time the idempotency key expires
+	ExpiresAt string `json:"expires_at,omitempty"`
+
+	// Hash of the idempotency key, scoped to the enrollment API key
+	Key string `json:"key,omitempty"`
+
+	// Hash of the enrollment request body
+	RequestHash string `json:"request_hash,omitempty"`
+}
+
+// LastActionError The last error reported by the Elastic Agent when acknowledging an action
+type LastActionError struct {
+	// The ID of the action that failed
+	ActionID string `json:"action_id,omitempty"`
+
+	// The type of the action that failed
+	ActionType string `json:"action_type,omitempty"`
+
+	// The error code reported by the Elastic Agent
+	Code string `json:"code,omitempty"`
+
+	// The error message, truncated to a maximum size
+	Message string `json:"message,omitempty"`
+
+	// Date/time the error was reported
+	Timestamp string `json:"@timestamp,omitempty"`
+}
+
+// LastConnection The connection the Elastic Agent last checked in with
+type LastConnection struct {
+	// The application protocol negotiated with ALPN
+	Protocol string `json:"protocol,omitempty"`
+
+	// The IP address of the Elastic Agent, resolved through the trusted proxies. Not set when the recording of the addresses is disabled
+	RemoteAddress string `json:"remote_address,omitempty"`
+
+	// The TLS cipher suite of the connection
+	TlsCipher string `json:"tls_cipher,omitempty"`
+
+	// The TLS version of the connection, not set for the connections without TLS
+	TlsVersion string `json:"tls_version,omitempty"`
+}
+
+// UnitsItems
+type UnitsItems struct {
+	ID      string `json:"id,omitempty"`
+	Message string `json:"message,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Type    string `json:"type,omitempty"`
+}
+
+// UpgradeDetails Additional upgrade status details.
+type UpgradeDetails struct {
+}
@@ -0,0 +1,30 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Code generated by schemagen. DO NOT EDIT.
+
+package model
+
+// AgentTombstone The record of an unenrolled Elastic Agent kept once the agent document is purged
+type AgentTombstone struct {
+	ESDocument
+
+	// The ID of the purged Elastic Agent
+	AgentID string `json:"agent_id"`
+
+	// Date/time the Elastic Agent enrolled
+	EnrolledAt string `json:"enrolled_at,omitempty"`
+
+	// The policy ID of the Elastic Agent when it was unenrolled
+	PolicyID string `json:"policy_id,omitempty"`
+
+	// Date/time the agent document was purged
+	Timestamp string `json:"@timestamp"`
+
+	// Date/time the Elastic Agent unenrolled
+	UnenrolledAt string `json:"unenrolled_at"`
+
+	// Reason the Elastic Agent was unenrolled
+	UnenrolledReason string `json:"unenrolled_reason,omitempty"`
+}
@@ -7,26 +7,28 @@ package model
 import (
 	"maps"
 	"time"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
 )
 
 // Time returns the time for the current leader.
 func (m *PolicyLeader) Time() (time.Time, error) {
-	return time.Parse(time.RFC3339Nano, m.Timestamp)
+	return ftime.Parse(m.Timestamp)
 }
 
 // SetTime sets the timestamp.
 func (m *PolicyLeader) SetTime(t time.Time) {
-	m.Timestamp = t.Format(time.RFC3339Nano)
+	m.Timestamp = ftime.Format(t)
 }
 
 // Time returns the time for the server.
 func (m *Server) Time() (time.Time, error) {
-	return time.Parse(time.RFC3339Nano, m.Timestamp)
+	return ftime.Parse(m.Timestamp)
 }
 
 // SetTime sets the timestamp.
 func (m *Server) SetTime(t time.Time) {
-	m.Timestamp = t.Format(time.RFC3339Nano)
+	m.Timestamp = ftime.Format(t)
 }
 
 // CheckDifferentVersion returns Agent version if it is different from ver, otherwise return empty string
@@ -14,69 +14,23 @@ import (
 type Root interface{}
 
 type ESInitializer interface {
-	ESInitialize(id string, seqno, version int64)
+	ESInitialize(id string, seqno, primaryTerm, version int64)
 }
 
 type ESDocument struct {
-	Id      string `json:"-"`
-	Version int64  `json:"-"`
-	SeqNo   int64  `json:"-"`
+	Id          string `json:"-"`
+	Version     int64  `json:"-"`
+	SeqNo       int64  `json:"-"`
+	PrimaryTerm int64  `json:"-"`
 }
 
-func (d *ESDocument) ESInitialize(id string, seqno, version int64) {
+func (d *ESDocument) ESInitialize(id string, seqno, primaryTerm, version int64) {
 	d.Id = id
 	d.SeqNo = seqno
+	d.PrimaryTerm = primaryTerm
 	d.Version = version
 }
 
-// Action An Elastic Agent action
-type Action struct {
-	ESDocument
-
-	// The unique identifier for the Elastic Agent action. There could be multiple documents with the same action_id if the action is split into two separate documents.
-	ActionID string `json:"action_id,omitempty"`
-
-	// The Agent IDs the action is intended for. No support for json.RawMessage with the current generator. Could be useful to lazy parse the agent ids
-	Agents []string `json:"agents,omitempty"`
-
-	// The opaque payload.
-	Data json.RawMessage `json:"data,omitempty"`
-
-	// The action expiration date/time
-	Expiration string `json:"expiration,omitempty"`
-
-	// The input type the actions should be routed to.
-	InputType string `json:"input_type,omitempty"`
-
-	// The minimum time (in seconds) provided for an action execution when scheduled by fleet-server.
-	MinimumExecutionDuration int64 `json:"minimum_execution_duration,omitempty"`
-
-	// Namespaces
-	Namespaces []string `json:"namespaces,omitempty"`
-
-	// The rollout duration (in seconds) provided for an action execution when scheduled by fleet-server.
-	RolloutDurationSeconds int64   `json:"rollout_duration_seconds,omitempty"`
-	Signed                 *Signed `json:"signed,omitempty"`
-
-	// The action start date/time
-	StartTime string `json:"start_time,omitempty"`
-
-	// The optional action timeout in seconds
-	Timeout int64 `json:"timeout,omitempty"`
-
-	// Date/time the action was created
-	Timestamp string `json:"@timestamp,omitempty"`
-
-	// APM traceparent for the action.
-	Traceparent string `json:"traceparent,omitempty"`
-
-	// The action type. INPUT_ACTION is the value for the actions that suppose to be routed to the endpoints/beats.
-	Type string `json:"type,omitempty"`
-
-	// The ID of the user who created the action.
-	UserID string `json:"user_id,omitempty"`
-}
-
 // ActionResult An Elastic Agent action results
 type ActionResult struct {
 	ESDocument
@@ -105,134 +59,33 @@ type ActionResult struct {
 	// The action error message.
 	Error string `json:"error,omitempty"`
 
+	// The action error code.
+	ErrorCode string `json:"error_code,omitempty"`
+
 	// Namespaces
 	Namespaces []string `json:"namespaces,omitempty"`
 
 	// Date/time the action was started
 	StartedAt string `json:"started_at,omitempty"`
 
+	// The action result status, set to expired when the action expired before it was delivered.
+	Status string `json:"status,omitempty"`
+
 	// Date/time the action was created
 	Timestamp string `json:"@timestamp,omitempty"`
 }
 
-// Agent An Elastic Agent that has enrolled into Fleet
-type Agent struct {
-	ESDocument
-
-	// ID of the API key the Elastic Agent must used to contact Fleet Server
-	AccessAPIKeyID string `json:"access_api_key_id,omitempty"`
-
-	// The last acknowledged action sequence number for the Elastic Agent
-	ActionSeqNo []int64 `json:"action_seq_no,omitempty"`
-
-	// Active flag
-	Active bool           `json:"active"`
-	Agent  *AgentMetadata `json:"agent,omitempty"`
-
-	// Agent reason for unenroll/uninstall annotation.
-	AuditUnenrolledReason string `json:"audit_unenrolled_reason,omitempty"`
-
-	// Agent timestamp for audit unenroll/uninstall action
-	AuditUnenrolledTime string `json:"audit_unenrolled_time,omitempty"`
+// ActionTarget The filter of the agents an action targets, evaluated against the agent document when the agent checks in. An agent matches when it satisfies all the set predicates.
+type ActionTarget struct {
 
-	// Elastic Agent components detailed status information
-	Components []ComponentsItems `json:"components,omitempty"`
-
-	// Deprecated. Use Outputs instead. API key the Elastic Agent uses to authenticate with elasticsearch
-	DefaultAPIKey string `json:"default_api_key,omitempty"`
-
-	// Deprecated. Use Outputs instead. Default API Key History
-	DefaultAPIKeyHistory []ToRetireAPIKeyIdsItems `json:"default_api_key_history,omitempty"`
-
-	// Deprecated. Use Outputs instead. ID of the API key the Elastic Agent uses to authenticate with elasticsearch
-	DefaultAPIKeyID string `json:"default_api_key_id,omitempty"`
-
-	// Date/time the Elastic Agent enrolled
-	EnrolledAt string `json:"enrolled_at"`
-
-	// Enrollment ID
-	EnrollmentID string `json:"enrollment_id,omitempty"`
-
-	// Date/time the Elastic Agent checked in last time
-	LastCheckin string `json:"last_checkin,omitempty"`
-
-	// Last checkin message
-	LastCheckinMessage string `json:"last_checkin_message,omitempty"`
-
-	// Last checkin status
-	LastCheckinStatus string `json:"last_checkin_status,omitempty"`
-
-	// Date/time the Elastic Agent was last updated
-	LastUpdated string `json:"last_updated,omitempty"`
-
-	// Local metadata information for the Elastic Agent
-	LocalMetadata json.RawMessage `json:"local_metadata,omitempty"`
-
-	// Namespaces
-	Namespaces []string `json:"namespaces,omitempty"`
-
-	// Outputs is the policy output data, mapping the output name to its data
-	Outputs map[string]*PolicyOutput `json:"outputs,omitempty"`
-
-	// Packages array
-	Packages []string `json:"packages,omitempty"`
-
-	// The current policy coordinator for the Elastic Agent
-	PolicyCoordinatorIdx int64 `json:"policy_coordinator_idx,omitempty"`
-
-	// The policy ID for the Elastic Agent
+	// The policy the agent is assigned to.
 	PolicyID string `json:"policy_id,omitempty"`
 
-	// Deprecated. Use Outputs instead. The policy output permissions hash
-	PolicyOutputPermissionsHash string `json:"policy_output_permissions_hash,omitempty"`
-
-	// The current policy revision_idx for the Elastic Agent
-	PolicyRevisionIdx int64 `json:"policy_revision_idx,omitempty"`
-
-	// hash of token provided during enrollment that allows replacement by another enrollment with same ID
-	ReplaceToken string `json:"replace_token,omitempty"`
-
-	// Shared ID
-	SharedID string `json:"shared_id,omitempty"`
-
-	// User provided tags for the Elastic Agent
+	// The tags the agent must all have.
 	Tags []string `json:"tags,omitempty"`
 
-	// Type
-	Type string `json:"type"`
-
-	// Date/time the Elastic Agent unenrolled
-	UnenrolledAt string `json:"unenrolled_at,omitempty"`
-
-	// Reason the Elastic Agent was unenrolled
-	UnenrolledReason string `json:"unenrolled_reason,omitempty"`
-
-	// Date/time the Elastic Agent unenrolled started
-	UnenrollmentStartedAt string `json:"unenrollment_started_at,omitempty"`
-
-	// Unhealthy reason: input/output/other
-	UnhealthyReason []string `json:"unhealthy_reason,omitempty"`
-
-	// Date/time the Elastic Agent was last updated
-	UpdatedAt string `json:"updated_at,omitempty"`
-
-	// List of timestamps of attempts of Elastic Agent automatic upgrades
-	UpgradeAttempts []string `json:"upgrade_attempts,omitempty"`
-
-	// Additional upgrade status details.
-	UpgradeDetails *UpgradeDetails `json:"upgrade_details,omitempty"`
-
-	// Date/time the Elastic Agent started the current upgrade
-	UpgradeStartedAt string `json:"upgrade_started_at,omitempty"`
-
-	// Upgrade status
-	UpgradeStatus string `json:"upgrade_status,omitempty"`
-
-	// Date/time the Elastic Agent was last upgraded
-	UpgradedAt string `json:"upgraded_at,omitempty"`
-
-	// User provided metadata information for the Elastic Agent
-	UserProvidedMetadata json.RawMessage `json:"user_provided_metadata,omitempty"`
+	// The semver constraint the agent version must satisfy, for instance ">= 8.15.0, < 9.0.0".
+	Version string `json:"version,omitempty"`
 }
 
 // AgentMetadata An Elastic Agent metadata
@@ -280,6 +133,56 @@ type Artifact struct {
 	PackageName string `json:"package_name,omitempty"`
 }
 
+// AuditRecord A request of a caller managing the agents
+type AuditRecord struct {
+	ESDocument
+
+	// The id of the API key of the caller
+	APIKeyID string `json:"api_key_id,omitempty"`
+
+	// The ids of the agents targeted by the request
+	AgentIDs []string `json:"agent_ids,omitempty"`
+
+	// The IP address of the caller, forwarded by a trusted proxy if the caller is behind one
+	ClientIP string `json:"client_ip,omitempty"`
+
+	// Structured details added by the handler of the request
+	Details map[string]interface{} `json:"details,omitempty"`
+
+	// The error of a failed request
+	Error string `json:"error,omitempty"`
+
+	// The HTTP method of the request
+	Method string `json:"method"`
+
+	// The namespaces of the agents targeted by the request
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// The outcome of the request, success or failure
+	Outcome string `json:"outcome"`
+
+	// The path of the request
+	Path string `json:"path"`
+
+	// The ids of the policies targeted by the request
+	PolicyIDs []string `json:"policy_ids,omitempty"`
+
+	// The route of the request
+	Route string `json:"route"`
+
+	// The name of the service token of the caller
+	ServiceToken string `json:"service_token,omitempty"`
+
+	// The HTTP status code of the response
+	StatusCode int64 `json:"status_code"`
+
+	// Date/time the request was received
+	Timestamp string `json:"@timestamp"`
+
+	// The user name of the caller
+	UserName string `json:"user_name,omitempty"`
+}
+
 // Checkin An Elastic Agent checkin to Fleet
 type Checkin struct {
 	ESDocument
@@ -329,14 +232,6 @@ type CheckinPolicyInputItems struct {
 	TemplateID string `json:"template_id"`
 }
 
-// ComponentsItems
-type ComponentsItems struct {
-	ID      string       `json:"id,omitempty"`
-	Message string       `json:"message,omitempty"`
-	Status  string       `json:"status,omitempty"`
-	Units   []UnitsItems `json:"units,omitempty"`
-}
-
 // DataStream
 type DataStream struct {
 	Dataset   string `json:"dataset,omitempty"`
@@ -411,7 +306,8 @@ type Policy struct {
 	Data           *PolicyData `json:"data"`
 
 	// True when this policy is the default policy to start Fleet Server
-	DefaultFleetServer bool `json:"default_fleet_server"`
+	DefaultFleetServer bool          `json:"default_fleet_server"`
+	Limits             *PolicyLimits `json:"limits,omitempty"`
 
 	// Namespaces
 	Namespaces []string `json:"namespaces,omitempty"`
@@ -467,6 +363,22 @@ type PolicyLeader struct {
 	Timestamp string `json:"@timestamp,omitempty"`
 }
 
+// PolicyLimits The quotas of the agents of a policy, the server-wide defaults apply to the quotas that are not set
+type PolicyLimits struct {
+
+	// The duration of the checkin long poll of the agents of the policy, overrides server.timeouts.checkin_long_poll
+	CheckinLongPoll string `json:"checkin_long_poll,omitempty"`
+
+	// The max number of active agents enrolled in the policy
+	MaxAgents int64 `json:"max_agents,omitempty"`
+
+	// The max number of enrollments in the policy per minute
+	MaxEnrollPerMinute int64 `json:"max_enroll_per_minute,omitempty"`
+
+	// The delay recommended to the agents of the policy before their next checkin when it is longer than the delay computed from the load, within server.checkin_delay.min and max
+	NextCheckinDelay string `json:"next_checkin_delay,omitempty"`
+}
+
 // PolicyOutput holds the needed data to manage the output API keys
 type PolicyOutput struct {
 	ESDocument
@@ -492,12 +404,52 @@ type SecretReferencesItems struct {
 	ID string `json:"id"`
 }
 
-// Server A Fleet Server
+// Server A Fleet Server instance reported in the .fleet-servers index
 type Server struct {
 	ESDocument
-	Agent  *AgentMetadata  `json:"agent"`
-	Host   *HostMetadata   `json:"host"`
-	Server *ServerMetadata `json:"server"`
+	Agent *AgentMetadata `json:"agent"`
+
+	// The address the Fleet Server listens on for agent requests
+	BindAddress string `json:"bind_address,omitempty"`
+
+	// The number of agents connected to the Fleet Server at the last heartbeat
+	ConnectedAgents int64 `json:"connected_agents,omitempty"`
+
+	// The number of distinct agents that checked in or acked during the last 15 minutes
+	DistinctAgents15m int64 `json:"distinct_agents_15m,omitempty"`
+
+	// The number of distinct agents that checked in or acked during the last minute
+	DistinctAgents1m int64 `json:"distinct_agents_1m,omitempty"`
+
+	// The number of distinct agents that checked in or acked during the last 5 minutes
+	DistinctAgents5m int64 `json:"distinct_agents_5m,omitempty"`
+
+	// True if the Fleet Server is draining, it stops attracting agents until it is shut down
+	Draining bool `json:"draining,omitempty"`
+
+	// Date/time the Fleet Server started draining
+	DrainingSince string        `json:"draining_since,omitempty"`
+	Host          *HostMetadata `json:"host"`
+
+	// Date/time of the last heartbeat of the Fleet Server
+	LastSeen string `json:"last_seen,omitempty"`
+
+	// The Fleet Server that flagged the instance as offline and took over its operations
+	OfflineBy string `json:"offline_by,omitempty"`
+
+	// The IDs of the batch operations running on the Fleet Server at the last heartbeat
+	Operations []string         `json:"operations,omitempty"`
+	Resources  *ServerResources `json:"resources,omitempty"`
+	Server     *ServerMetadata  `json:"server"`
+
+	// True if the Fleet Server stopped sending heartbeats without being shut down
+	Stale bool `json:"stale,omitempty"`
+
+	// Date/time the Fleet Server started
+	StartedAt string `json:"started_at,omitempty"`
+
+	// The status of the Fleet Server, STOPPED once it is shut down and OFFLINE once it stopped sending heartbeats
+	Status string `json:"status,omitempty"`
 
 	// Date/time the server was updated
 	Timestamp string `json:"@timestamp,omitempty"`
@@ -506,6 +458,12 @@ type Server struct {
 // ServerMetadata A Fleet Server metadata
 type ServerMetadata struct {
 
+	// The date-time that the Fleet Server binary was created
+	BuildTime string `json:"build_time,omitempty"`
+
+	// The VCS commit the Fleet Server was built from
+	Commit string `json:"commit,omitempty"`
+
 	// The unique identifier for the Fleet Server
 	ID string `json:"id"`
 
@@ -513,6 +471,31 @@ type ServerMetadata struct {
 	Version string `json:"version"`
 }
 
+// ServerResources The resources used by a Fleet Server process, -1 when unknown on the platform
+type ServerResources struct {
+
+	// The percentage of all the CPUs of the host used since the previous sample
+	CPUPct float64 `json:"cpu_pct,omitempty"`
+
+	// The user and system CPU time used since the process started
+	CPUSeconds float64 `json:"cpu_seconds,omitempty"`
+
+	// The soft limit of open file descriptors
+	FDLimit int64 `json:"fd_limit,omitempty"`
+
+	// The total time the garbage collector stopped the process
+	GCPauseSeconds float64 `json:"gc_pause_seconds,omitempty"`
+
+	// The number of live goroutines
+	Goroutines int64 `json:"goroutines,omitempty"`
+
+	// The number of open file descriptors
+	OpenFDs int64 `json:"open_fds,omitempty"`
+
+	// The resident memory of the process
+	RSSBytes int64 `json:"rss_bytes,omitempty"`
+}
+
 // Signed The action signed data and signature.
 type Signed struct {
 
@@ -535,15 +518,3 @@ type ToRetireAPIKeyIdsItems struct {
 	// Date/time the API key was retired
 	RetiredAt string `json:"retired_at,omitempty"`
 }
-
-// UnitsItems
-type UnitsItems struct {
-	ID      string `json:"id,omitempty"`
-	Message string `json:"message,omitempty"`
-	Status  string `json:"status,omitempty"`
-	Type    string `json:"type,omitempty"`
-}
-
-// UpgradeDetails Additional upgrade status details.
-type UpgradeDetails struct {
-}
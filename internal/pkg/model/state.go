@@ -0,0 +1,89 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// AgentState is the lifecycle state of an agent.
+type AgentState string
+
+// Lifecycle states of an agent, the zero value is an agent that is not enrolled yet.
+const (
+	AgentStateEnrolled    AgentState = "enrolled"
+	AgentStateOnline      AgentState = "online"
+	AgentStateOffline     AgentState = "offline"
+	AgentStateUnenrolling AgentState = "unenrolling"
+	AgentStateUnenrolled  AgentState = "unenrolled"
+)
+
+// ErrInvalidTransition is matched by the errors of the rejected lifecycle transitions.
+var ErrInvalidTransition = errors.New("invalid agent state transition")
+
+// TransitionError is the error of a rejected lifecycle transition.
+type TransitionError struct {
+	From AgentState
+	To   AgentState
+}
+
+func (e *TransitionError) Error() string {
+	from := e.From
+	if from == "" {
+		from = "none"
+	}
+	return fmt.Sprintf("%s: %s to %s", ErrInvalidTransition, from, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// transitions are the states an agent may move to from each state.
+// An agent can always be enrolled again, an enrollment replaces the agent document.
+var transitions = map[AgentState][]AgentState{
+	"":                    {AgentStateEnrolled},
+	AgentStateEnrolled:    {AgentStateEnrolled, AgentStateOnline, AgentStateOffline, AgentStateUnenrolling, AgentStateUnenrolled},
+	AgentStateOnline:      {AgentStateEnrolled, AgentStateOnline, AgentStateOffline, AgentStateUnenrolling, AgentStateUnenrolled},
+	AgentStateOffline:     {AgentStateEnrolled, AgentStateOnline, AgentStateOffline, AgentStateUnenrolling, AgentStateUnenrolled},
+	AgentStateUnenrolling: {AgentStateEnrolled, AgentStateUnenrolling, AgentStateUnenrolled},
+	AgentStateUnenrolled:  {AgentStateEnrolled, AgentStateUnenrolled},
+}
+
+// ValidateTransition returns a *TransitionError if an agent is not allowed to move from one state to the other.
+func ValidateTransition(from, to AgentState) error {
+	if !slices.Contains(transitions[from], to) {
+		return &TransitionError{From: from, To: to}
+	}
+	return nil
+}
+
+// CheckinState returns the state of an agent in state s once it checked in.
+// An unenrolling agent stays unenrolling until it acks the unenroll action.
+func (s AgentState) CheckinState() AgentState {
+	if s == AgentStateUnenrolling {
+		return AgentStateUnenrolling
+	}
+	return AgentStateOnline
+}
+
+// State returns the lifecycle state of the agent.
+// The unenrollment fields set by Kibana take precedence, the state of the documents written before
+// the lifecycle_state field was introduced is derived from the last checkin.
+func (m *Agent) State() AgentState {
+	switch {
+	case m.LifecycleState == string(AgentStateUnenrolled) || (m.UnenrolledAt != "" && !m.Active):
+		return AgentStateUnenrolled
+	case m.UnenrollmentStartedAt != "":
+		return AgentStateUnenrolling
+	case m.LifecycleState != "":
+		return AgentState(m.LifecycleState)
+	case m.LastCheckin != "":
+		return AgentStateOnline
+	}
+	return AgentStateEnrolled
+}
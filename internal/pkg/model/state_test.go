@@ -0,0 +1,110 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTransition(t *testing.T) {
+	const (
+		none        = AgentState("")
+		enrolled    = AgentStateEnrolled
+		online      = AgentStateOnline
+		offline     = AgentStateOffline
+		unenrolling = AgentStateUnenrolling
+		unenrolled  = AgentStateUnenrolled
+	)
+	states := []AgentState{enrolled, online, offline, unenrolling, unenrolled}
+
+	// allowed is the transition matrix, a missing pair is rejected.
+	allowed := map[AgentState][]AgentState{
+		none:        {enrolled},
+		enrolled:    {enrolled, online, offline, unenrolling, unenrolled},
+		online:      {enrolled, online, offline, unenrolling, unenrolled},
+		offline:     {enrolled, online, offline, unenrolling, unenrolled},
+		unenrolling: {enrolled, unenrolling, unenrolled},
+		unenrolled:  {enrolled, unenrolled},
+	}
+
+	for _, from := range append([]AgentState{none}, states...) {
+		for _, to := range states {
+			want := false
+			for _, s := range allowed[from] {
+				want = want || s == to
+			}
+			t.Run(string(from)+"->"+string(to), func(t *testing.T) {
+				err := ValidateTransition(from, to)
+				if want {
+					require.NoError(t, err)
+					return
+				}
+				require.ErrorIs(t, err, ErrInvalidTransition)
+				var terr *TransitionError
+				require.True(t, errors.As(err, &terr))
+				assert.Equal(t, from, terr.From)
+				assert.Equal(t, to, terr.To)
+			})
+		}
+	}
+}
+
+func TestTransitionErrorMessage(t *testing.T) {
+	assert.Equal(t, "invalid agent state transition: unenrolled to online", (&TransitionError{From: AgentStateUnenrolled, To: AgentStateOnline}).Error())
+	assert.Equal(t, "invalid agent state transition: none to online", (&TransitionError{To: AgentStateOnline}).Error())
+}
+
+func TestAgentState(t *testing.T) {
+	tests := []struct {
+		name  string
+		agent Agent
+		want  AgentState
+	}{{
+		name:  "enrolled",
+		agent: Agent{Active: true},
+		want:  AgentStateEnrolled,
+	}, {
+		name:  "checked in before the state was recorded",
+		agent: Agent{Active: true, LastCheckin: "2024-01-01T00:00:00Z"},
+		want:  AgentStateOnline,
+	}, {
+		name:  "recorded state",
+		agent: Agent{Active: true, LastCheckin: "2024-01-01T00:00:00Z", LifecycleState: string(AgentStateOffline)},
+		want:  AgentStateOffline,
+	}, {
+		name:  "unenrollment started by kibana",
+		agent: Agent{Active: true, LifecycleState: string(AgentStateOnline), UnenrollmentStartedAt: "2024-01-01T00:00:00Z"},
+		want:  AgentStateUnenrolling,
+	}, {
+		name:  "unenrolled by kibana",
+		agent: Agent{LifecycleState: string(AgentStateOnline), UnenrolledAt: "2024-01-01T00:00:00Z", UnenrollmentStartedAt: "2024-01-01T00:00:00Z"},
+		want:  AgentStateUnenrolled,
+	}, {
+		name:  "unenrolled_at set by an audit unenroll of 8.16",
+		agent: Agent{Active: true, LifecycleState: string(AgentStateOnline), UnenrolledAt: "2024-01-01T00:00:00Z"},
+		want:  AgentStateOnline,
+	}, {
+		name:  "unenrolled",
+		agent: Agent{LifecycleState: string(AgentStateUnenrolled)},
+		want:  AgentStateUnenrolled,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.agent.State())
+		})
+	}
+}
+
+func TestCheckinState(t *testing.T) {
+	assert.Equal(t, AgentStateOnline, AgentStateEnrolled.CheckinState())
+	assert.Equal(t, AgentStateOnline, AgentStateOffline.CheckinState())
+	assert.Equal(t, AgentStateUnenrolling, AgentStateUnenrolling.CheckinState())
+	assert.Equal(t, AgentStateOnline, AgentStateUnenrolled.CheckinState())
+	require.Error(t, ValidateTransition(AgentStateUnenrolled, AgentStateUnenrolled.CheckinState()))
+}
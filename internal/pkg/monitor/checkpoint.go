@@ -0,0 +1,135 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+)
+
+// CheckpointStore persists the checkpoint of a monitor, so a restarted monitor resumes from the documents it did not read
+// instead of skipping the documents written while it was stopped.
+type CheckpointStore interface {
+	// Load returns the stored checkpoint, or nil if no checkpoint was stored.
+	Load(ctx context.Context) (sqn.SeqNo, error)
+	// Store saves the checkpoint.
+	Store(ctx context.Context, checkpoint sqn.SeqNo) error
+}
+
+// memoryCheckpointStore keeps the checkpoint in memory, it survives the restarts of a monitor in the same process.
+type memoryCheckpointStore struct {
+	mx         sync.Mutex
+	checkpoint sqn.SeqNo
+}
+
+// NewMemoryCheckpointStore returns a CheckpointStore that keeps the checkpoint in memory.
+func NewMemoryCheckpointStore() CheckpointStore {
+	return &memoryCheckpointStore{}
+}
+
+func (s *memoryCheckpointStore) Load(_ context.Context) (sqn.SeqNo, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.checkpoint.Clone(), nil
+}
+
+func (s *memoryCheckpointStore) Store(_ context.Context, checkpoint sqn.SeqNo) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.checkpoint = checkpoint.Clone()
+	return nil
+}
+
+// esCheckpointStore keeps the checkpoint in the document id of index.
+type esCheckpointStore struct {
+	esCli *elasticsearch.Client
+	index string
+	id    string
+}
+
+type checkpointDoc struct {
+	Timestamp   string    `json:"@timestamp"`
+	Checkpoints sqn.SeqNo `json:"checkpoints"`
+}
+
+// NewESCheckpointStore returns a CheckpointStore that keeps the checkpoint in the document id of index.
+func NewESCheckpointStore(esCli *elasticsearch.Client, index, id string) CheckpointStore {
+	return &esCheckpointStore{esCli: esCli, index: index, id: id}
+}
+
+func (s *esCheckpointStore) Load(ctx context.Context) (sqn.SeqNo, error) {
+	res, err := s.esCli.Get(s.index, s.id, s.esCli.Get.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		// the index or the document do not exist yet
+		return nil, nil
+	}
+
+	var sres struct {
+		Source checkpointDoc   `json:"_source"`
+		Error  json.RawMessage `json:"error,omitempty"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&sres); err != nil {
+		return nil, err
+	}
+	if err := es.TranslateError(res.StatusCode, sres.Error); err != nil {
+		return nil, err
+	}
+	return sres.Source.Checkpoints, nil
+}
+
+func (s *esCheckpointStore) Store(ctx context.Context, checkpoint sqn.SeqNo) error {
+	body, err := json.Marshal(checkpointDoc{
+		Timestamp:   ftime.Now(),
+		Checkpoints: checkpoint,
+	})
+	if err != nil {
+		return err
+	}
+	res, err := s.esCli.Index(s.index, bytes.NewReader(body),
+		s.esCli.Index.WithContext(ctx),
+		s.esCli.Index.WithDocumentID(s.id),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if !res.IsError() {
+		return nil
+	}
+	var sres struct {
+		Error json.RawMessage `json:"error,omitempty"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&sres); err != nil {
+		return err
+	}
+	return es.TranslateError(res.StatusCode, sres.Error)
+}
+
+// checkpointValid returns false if checkpoint can not be a previous checkpoint of an index with the global checkpoints current,
+// either the number of shards changed or a shard went back, the index was deleted and created again.
+func checkpointValid(checkpoint, current sqn.SeqNo) bool {
+	if len(checkpoint) != len(current) {
+		return false
+	}
+	for i := range checkpoint {
+		if checkpoint[i] > current[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -18,6 +18,7 @@ import (
 // MockSubscription implements monitor.Subscription
 type MockSubscription struct {
 	mock.Mock
+	resync <-chan struct{}
 }
 
 func NewMockSubscription() *MockSubscription {
@@ -32,6 +33,16 @@ func (m *MockSubscription) Output() <-chan []es.HitT {
 	return args.Get(0).(<-chan []es.HitT)
 }
 
+// Resync returns the channel of SetResync, it is not a mocked call so the tests that do not resync need no expectation.
+func (m *MockSubscription) Resync() <-chan struct{} {
+	return m.resync
+}
+
+// SetResync sets the channel returned by Resync.
+func (m *MockSubscription) SetResync(ch <-chan struct{}) {
+	m.resync = ch
+}
+
 // MockMonitor implements monitor.SimpleMonitor and monitor.Monitor
 type MockMonitor struct {
 	mock.Mock
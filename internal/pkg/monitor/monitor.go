@@ -17,6 +17,7 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dsl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
 	"github.com/elastic/fleet-server/v7/internal/pkg/gcheckpt"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sleep"
 	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
@@ -24,6 +25,7 @@ import (
 	"github.com/rs/zerolog"
 	"go.elastic.co/apm/v2"
 
+	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/elastic/go-elasticsearch/v8"
 )
 
@@ -46,6 +48,12 @@ const (
 	// 2. Any other error waiting on global checkpoint, except timeouts.
 	// For the long poll timeout, start a new request as soon as possible.
 	retryDelay = 10 * time.Second
+
+	// Max retry delay, the delay doubles after each consecutive error up to this value.
+	maxRetryDelay = 2 * time.Minute
+
+	// Timeout of the checkpoint store on shutdown, once the context of the monitor is cancelled.
+	shutdownStoreTimeout = 5 * time.Second
 )
 
 const (
@@ -92,6 +100,18 @@ type simpleMonitorT struct {
 
 	checkpoint sqn.SeqNo    // index global checkpoint
 	mx         sync.RWMutex // checkpoint mutex
+	store      CheckpointStore
+	handler    Handler
+
+	storeInterval time.Duration // min interval between the stores of the checkpoint, 0 stores each new checkpoint
+	stored        sqn.SeqNo     // last checkpoint saved to the store
+	storedAt      time.Time
+
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+
+	resyncHandlers []func(ctx context.Context)
+	resyncs        monitoring.Uint // times the global checkpoints went back
 
 	log zerolog.Logger
 
@@ -100,6 +120,10 @@ type simpleMonitorT struct {
 	readyCh chan error
 }
 
+// Handler receives the new documents of an index, in the order of their _seq_no.
+// The checkpoint of the monitor does not advance if it returns an error, the documents are delivered again after a delay.
+type Handler func(ctx context.Context, hits []es.HitT) error
+
 // Option is a functional configuration option.
 type Option func(SimpleMonitor)
 
@@ -116,6 +140,8 @@ func NewSimple(index string, esCli, monCli *elasticsearch.Client, opts ...Option
 		debounceTime:   0,
 		checkpoint:     sqn.DefaultSeqNo,
 		outCh:          make(chan []es.HitT, 1),
+		retryDelay:     retryDelay,
+		maxRetryDelay:  maxRetryDelay,
 	}
 
 	for _, opt := range opts {
@@ -179,6 +205,48 @@ func WithDebounceTime(dur time.Duration) Option {
 	}
 }
 
+// WithCheckpointStore loads the initial checkpoint of the monitor from store, and saves the checkpoint to it after new documents are read
+// and when the monitor stops.
+// Without a store, or if the store has no checkpoint, the monitor starts from the current global checkpoint of the index.
+func WithCheckpointStore(store CheckpointStore) Option {
+	return func(m SimpleMonitor) {
+		m.(*simpleMonitorT).store = store
+	}
+}
+
+// WithCheckpointInterval saves the checkpoint to the checkpoint store at most once per interval while the monitor runs.
+// The checkpoint is always saved when the monitor stops, the documents read since the last save are read again
+// on restart if the process dies meanwhile.
+func WithCheckpointInterval(interval time.Duration) Option {
+	return func(m SimpleMonitor) {
+		m.(*simpleMonitorT).storeInterval = interval
+	}
+}
+
+// WithHandler delivers the new documents to handler instead of the output channel.
+func WithHandler(handler Handler) Option {
+	return func(m SimpleMonitor) {
+		m.(*simpleMonitorT).handler = handler
+	}
+}
+
+// WithResyncHandler calls handler when the global checkpoints of the index went back, once the checkpoint is reset
+// before the first document of the index. It happens when the index is restored from a snapshot or recreated, the
+// documents of the index are then delivered again.
+func WithResyncHandler(handler func(ctx context.Context)) Option {
+	return func(m SimpleMonitor) {
+		sm := m.(*simpleMonitorT)
+		sm.resyncHandlers = append(sm.resyncHandlers, handler)
+	}
+}
+
+// WithStats registers the number of resynchronizations of the monitor in reg.
+func WithStats(reg *monitoring.Registry) Option {
+	return func(m SimpleMonitor) {
+		reg.Add("resyncs", &m.(*simpleMonitorT).resyncs, monitoring.Full)
+	}
+}
+
 // Output returns the output channel for the monitor.
 func (m *simpleMonitorT) Output() <-chan []es.HitT {
 	return m.outCh
@@ -227,11 +295,11 @@ func (m *simpleMonitorT) Run(ctx context.Context) (err error) {
 			ctx = apm.ContextWithTransaction(ctx, trans)
 		}
 		span, sCtx := apm.StartSpan(ctx, "global_checkpoint", "fleet_global_checkpoints")
-		checkpoint, err := gcheckpt.Query(sCtx, m.monCli, m.index)
+		checkpoint, err := m.initialCheckpoint(sCtx)
 		span.End()
 		if err != nil {
 			m.log.Warn().Err(err).Msg("failed to initialize the global checkpoints, will retry")
-			err = sleep.WithContext(ctx, retryDelay)
+			err = sleep.WithContext(ctx, m.retryDelay)
 			if err != nil {
 				if m.tracer != nil {
 					trans.End()
@@ -246,6 +314,12 @@ func (m *simpleMonitorT) Run(ctx context.Context) (err error) {
 
 		m.storeCheckpoint(checkpoint)
 		m.log.Debug().Ints64("checkpoint", checkpoint).Msg("initial checkpoint")
+		// the checkpoint is saved on shutdown, the store is given a moment once the context is cancelled
+		defer func() {
+			sctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownStoreTimeout)
+			defer cancel()
+			m.persistCheckpoint(sctx, true)
+		}()
 
 		if m.tracer != nil {
 			trans.End()
@@ -259,6 +333,14 @@ func (m *simpleMonitorT) Run(ctx context.Context) (err error) {
 		m.readyCh = nil
 	}
 
+	// Delay of the next attempt after an error, reset once elasticsearch answers again
+	errDelay := m.retryDelay
+	backoff := func() error {
+		delay := errDelay
+		errDelay = min(2*errDelay, m.maxRetryDelay)
+		return sleep.WithContext(ctx, delay)
+	}
+
 	for {
 		if m.tracer != nil {
 			trans = m.tracer.StartTransaction(fmt.Sprintf("Monitor index %s", m.index), "monitor")
@@ -273,17 +355,20 @@ func (m *simpleMonitorT) Run(ctx context.Context) (err error) {
 		newCheckpoint, err := gcheckpt.WaitAdvance(gCtx, m.monCli, m.index, checkpoint, m.pollTimeout)
 		span.End()
 		if err != nil {
+			delay := backoff
 			if errors.Is(err, es.ErrIndexNotFound) {
-				// Wait until created
-				m.log.Debug().Msgf("index not found, poll again in %v", retryDelay)
+				// Wait until created, the documents of a new index are read from the start
+				m.log.Debug().Msgf("index not found, poll again in %v", m.retryDelay)
+				m.resetCheckpoint(ctx)
+				errDelay = m.retryDelay
 			} else if errors.Is(err, es.ErrTimeout) {
 				// Timed out, wait again
 				m.log.Debug().Msg("timeout on global checkpoints advance, poll again")
+				errDelay = m.retryDelay
+				m.checkRegression(ctx, checkpoint)
+				m.persistCheckpoint(ctx, false)
 				// Loop back to the checkpoint "wait advance" without delay
-				if m.tracer != nil {
-					trans.End()
-				}
-				continue
+				delay = nil
 			} else if errors.Is(err, context.Canceled) {
 				m.log.Info().Msg("context closed waiting for global checkpoints advance")
 				// Exit run
@@ -291,25 +376,27 @@ func (m *simpleMonitorT) Run(ctx context.Context) (err error) {
 					trans.End()
 				}
 				return err
+			} else if es.IsRetryable(err) {
+				// Elasticsearch is overloaded or recovering, keep trying
+				m.log.Debug().Err(err).Dur("retry_in", errDelay).Msg("elasticsearch unavailable waiting for global checkpoints advance, poll again")
 			} else {
 				// Log the error and keep trying
-				m.log.Info().Err(err).Msg("failed on waiting for global checkpoints advance")
+				m.log.Info().Err(err).Dur("retry_in", errDelay).Msg("failed on waiting for global checkpoints advance")
 			}
 
-			// Delay next attempt
-			err = sleep.WithContext(ctx, retryDelay)
-			if err != nil {
-				if m.tracer != nil {
-					trans.End()
-				}
-				return err
-			}
-			// Loop back to the checkpoint "wait advance" after the retry delay
 			if m.tracer != nil {
 				trans.End()
 			}
+			// Delay next attempt
+			if delay != nil {
+				if err := delay(); err != nil {
+					return err
+				}
+			}
+			// Loop back to the checkpoint "wait advance"
 			continue
 		}
+		errDelay = m.retryDelay
 
 		// This is an example of steps for fetching the documents without "holes" (not-yet-indexed documents in between)
 		// as recommended by Elasticsearch team on August 25th, 2021
@@ -326,19 +413,22 @@ func (m *simpleMonitorT) Run(ctx context.Context) (err error) {
 
 		// Set count to max fetch size (m.fetchSize) initially, so the fetch happens at least once.
 		count := m.fetchSize
+		var fetchErr error
 		for count == m.fetchSize {
 			// Fetch the documents between the last known checkpoint and the new checkpoint value received from "wait advance".
-			hits, err := m.fetch(ctx, checkpoint, newCheckpoint)
-			if err != nil {
-				m.log.Error().Err(err).Msg("failed checking new documents")
-				if m.tracer != nil {
-					trans.End()
-				}
+			var hits []es.HitT
+			hits, fetchErr = m.fetch(ctx, checkpoint, newCheckpoint)
+			if fetchErr != nil {
+				m.log.Error().Err(fetchErr).Dur("retry_in", errDelay).Msg("failed checking new documents")
 				break
 			}
 
 			// Notify call updates m.checkpoint as max(_seq_no) from the fetched hits
-			count = m.notify(ctx, hits)
+			count, fetchErr = m.notify(ctx, hits)
+			if fetchErr != nil {
+				m.log.Error().Err(fetchErr).Dur("retry_in", errDelay).Msg("failed handling new documents")
+				break
+			}
 			m.log.Debug().Int("count", count).Msg("hits found after notify")
 
 			// If the number of fetched documents is the same as the max fetch size, then it's possible there are more documents to fetch.
@@ -358,9 +448,17 @@ func (m *simpleMonitorT) Run(ctx context.Context) (err error) {
 				m.storeCheckpoint(newCheckpoint)
 			}
 		}
+		m.persistCheckpoint(ctx, false)
 		if m.tracer != nil {
 			trans.End()
 		}
+		if fetchErr != nil {
+			// The documents after the checkpoint are fetched again after a delay
+			if err := backoff(); err != nil {
+				return err
+			}
+			continue
+		}
 		if m.debounceTime > 0 {
 			m.log.Debug().Dur("debounce_time", m.debounceTime).Msg("monitor debounce start")
 			// Introduce a debounce time before wait advance (the signal for new docs in the index)
@@ -374,22 +472,118 @@ func (m *simpleMonitorT) Run(ctx context.Context) (err error) {
 	}
 }
 
-func (m *simpleMonitorT) notify(ctx context.Context, hits []es.HitT) int {
+// initialCheckpoint returns the checkpoint of the checkpoint store, or the current global checkpoint of the index.
+// The stored checkpoint is not used if the global checkpoints went back since it was stored, the index is read from
+// the start.
+func (m *simpleMonitorT) initialCheckpoint(ctx context.Context) (sqn.SeqNo, error) {
+	current, err := gcheckpt.Query(ctx, m.monCli, m.index)
+	if err != nil {
+		return nil, err
+	}
+	if m.store == nil {
+		return current, nil
+	}
+	stored, err := m.store.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the stored checkpoint: %w", err)
+	}
+	switch {
+	case stored == nil:
+		return current, nil
+	case !checkpointValid(stored, current):
+		m.resync(ctx, stored, current)
+		return sqn.DefaultSeqNo, nil
+	default:
+		m.stored, m.storedAt = stored, time.Now()
+		return stored, nil
+	}
+}
+
+// checkRegression resets the checkpoint if the global checkpoints went back, the index was restored from a snapshot
+// or recreated with fewer documents or another number of shards. The global checkpoints would otherwise wait until
+// the index has more documents than before, and the documents written meanwhile would be skipped.
+func (m *simpleMonitorT) checkRegression(ctx context.Context, checkpoint sqn.SeqNo) {
+	current, err := gcheckpt.Query(ctx, m.monCli, m.index)
+	if err != nil {
+		m.log.Debug().Err(err).Msg("failed to query the global checkpoints")
+		return
+	}
+	if checkpointValid(checkpoint, current) {
+		return
+	}
+	m.resync(ctx, checkpoint, current)
+}
+
+// resync reads the index again from the start after its global checkpoints current went back from checkpoint,
+// and calls the resync handlers.
+func (m *simpleMonitorT) resync(ctx context.Context, checkpoint, current sqn.SeqNo) {
+	m.resyncs.Inc()
+	m.log.Warn().
+		Ints64("checkpoint", checkpoint).
+		Ints64("global_checkpoint", current).
+		Msg("global checkpoints went back, the index was restored from a snapshot or recreated: reading it again from the start")
+	m.resetCheckpoint(ctx)
+	for _, handler := range m.resyncHandlers {
+		handler(ctx)
+	}
+}
+
+// resetCheckpoint sets the checkpoint before the first document of the index.
+func (m *simpleMonitorT) resetCheckpoint(ctx context.Context) {
+	if m.loadCheckpoint().Compare(sqn.DefaultSeqNo) == 0 {
+		return
+	}
+	m.storeCheckpoint(sqn.DefaultSeqNo)
+	m.persistCheckpoint(ctx, true)
+}
+
+// persistCheckpoint saves the checkpoint to the checkpoint store if it changed since it was last saved, and the checkpoint
+// interval elapsed unless force is set. A failure is logged and retried with the next checkpoint.
+func (m *simpleMonitorT) persistCheckpoint(ctx context.Context, force bool) {
+	if m.store == nil {
+		return
+	}
+	checkpoint := m.loadCheckpoint()
+	if m.stored != nil && checkpoint.Compare(m.stored) == 0 {
+		return
+	}
+	if !force && m.storeInterval > 0 && time.Since(m.storedAt) < m.storeInterval {
+		return
+	}
+	if err := m.store.Store(ctx, checkpoint); err != nil {
+		if !errors.Is(err, context.Canceled) {
+			m.log.Warn().Err(err).Msg("failed to store the checkpoint")
+		}
+		return
+	}
+	m.stored = checkpoint
+	m.storedAt = time.Now()
+}
+
+func (m *simpleMonitorT) notify(ctx context.Context, hits []es.HitT) (int, error) {
 	sz := len(hits)
-	if sz > 0 {
-		select {
-		case m.outCh <- hits:
-			maxVal := hits[sz-1].SeqNo
-			m.storeCheckpoint([]int64{maxVal})
-			return sz
-		case <-ctx.Done():
+	if sz == 0 {
+		return 0, nil
+	}
+	if m.handler != nil {
+		if err := m.handler(ctx, hits); err != nil {
+			return 0, err
 		}
+		m.storeCheckpoint([]int64{hits[sz-1].SeqNo})
+		return sz, nil
+	}
+	select {
+	case m.outCh <- hits:
+		maxVal := hits[sz-1].SeqNo
+		m.storeCheckpoint([]int64{maxVal})
+		return sz, nil
+	case <-ctx.Done():
 	}
-	return 0
+	return 0, nil
 }
 
 func (m *simpleMonitorT) fetch(ctx context.Context, checkpoint, maxCheckpoint sqn.SeqNo) ([]es.HitT, error) {
-	now := time.Now().UTC().Format(time.RFC3339)
+	now := ftime.Now()
 
 	// Run check query that detects that there are new documents available
 	params := map[string]interface{}{
@@ -425,7 +619,7 @@ func (m *simpleMonitorT) search(ctx context.Context, tmpl *dsl.Tmpl, params map[
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
+	defer es.DrainAndClose(res.Body)
 	var esres es.Response
 	err = json.NewDecoder(res.Body).Decode(&esres)
 	if err != nil {
@@ -0,0 +1,502 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/sqn"
+	"github.com/elastic/fleet-server/v7/internal/pkg/testing/esutil"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+const testIndex = ".fleet-test"
+
+// scriptedIndex is a mock of the fleet global checkpoints and search APIs for a single shard index.
+// The documents have the _seq_no of their position, the global checkpoint is the _seq_no of the last document.
+type scriptedIndex struct {
+	mu       sync.Mutex
+	docs     int
+	missing  bool
+	errs     []int // status codes returned by the next global checkpoints requests
+	searches int
+	waits    int
+}
+
+func (s *scriptedIndex) add(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs += n
+	s.missing = false
+}
+
+// recreate replaces the index with a new index of n documents.
+func (s *scriptedIndex) recreate(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs = n
+	s.missing = false
+}
+
+func (s *scriptedIndex) fail(codes ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = append(s.errs, codes...)
+}
+
+func (s *scriptedIndex) counts() (searches, waits int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.searches, s.waits
+}
+
+func (s *scriptedIndex) roundTrip(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/_fleet/global_checkpoints"):
+		return s.globalCheckpoints(req)
+	case strings.HasSuffix(req.URL.Path, "/_fleet/_fleet_search"):
+		return s.search(req)
+	}
+	return nil, fmt.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+}
+
+func (s *scriptedIndex) globalCheckpoints(req *http.Request) (*http.Response, error) {
+	wait := req.URL.Query().Get("wait_for_advance") == "true"
+	if wait {
+		s.waits++
+	}
+	if len(s.errs) > 0 {
+		code := s.errs[0]
+		s.errs = s.errs[1:]
+		return response(code, `{"error":{"type":"unavailable","reason":"scripted error"}}`), nil
+	}
+	if s.missing {
+		return response(http.StatusNotFound, `{"error":{"type":"index_not_found_exception","reason":"no such index"}}`), nil
+	}
+	current := int64(s.docs - 1)
+	if wait {
+		checkpoints, err := sqn.Parse(req.URL.Query().Get("checkpoints"))
+		if err != nil {
+			return nil, err
+		}
+		if current <= checkpoints.Value() {
+			// long poll timeout, unlock the index meanwhile so the test can add documents
+			s.mu.Unlock()
+			time.Sleep(time.Millisecond)
+			s.mu.Lock()
+			return response(http.StatusOK, fmt.Sprintf(`{"global_checkpoints":[%d],"timed_out":true}`, current)), nil
+		}
+	}
+	return response(http.StatusOK, fmt.Sprintf(`{"global_checkpoints":[%d],"timed_out":false}`, current)), nil
+}
+
+func (s *scriptedIndex) search(req *http.Request) (*http.Response, error) {
+	s.searches++
+	var query struct {
+		Size  int `json:"size"`
+		Query struct {
+			Bool struct {
+				Filter []struct {
+					Range map[string]map[string]int64 `json:"range"`
+				} `json:"filter"`
+			} `json:"bool"`
+		} `json:"query"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+		return nil, err
+	}
+	from, to := int64(-1), int64(s.docs-1)
+	for _, f := range query.Query.Bool.Filter {
+		if gt, ok := f.Range["_seq_no"]["gt"]; ok {
+			from = gt
+		}
+		if lte, ok := f.Range["_seq_no"]["lte"]; ok {
+			to = min(to, lte)
+		}
+	}
+	var hits []string
+	for seqNo := from + 1; seqNo <= to && len(hits) < query.Size; seqNo++ {
+		hits = append(hits, fmt.Sprintf(`{"_id":"doc-%d","_seq_no":%d,"_source":{}}`, seqNo, seqNo))
+	}
+	return response(http.StatusOK, fmt.Sprintf(`{"hits":{"hits":[%s]}}`, strings.Join(hits, ","))), nil
+}
+
+func response(code int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: code,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header: http.Header{
+			"X-Elastic-Product": []string{"Elasticsearch"},
+			"Content-Type":      []string{"application/json"},
+		},
+	}
+}
+
+// received collects the _seq_no of the documents delivered to the handler.
+type received struct {
+	mu     sync.Mutex
+	seqNos []int64
+	errs   int // number of batches to fail
+}
+
+func (r *received) handle(_ context.Context, hits []es.HitT) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.errs > 0 {
+		r.errs--
+		return errors.New("handler failed")
+	}
+	for _, hit := range hits {
+		r.seqNos = append(r.seqNos, hit.SeqNo)
+	}
+	return nil
+}
+
+func (r *received) get() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64(nil), r.seqNos...)
+}
+
+func seqNos(from, to int64) []int64 {
+	var s []int64
+	for i := from; i <= to; i++ {
+		s = append(s, i)
+	}
+	return s
+}
+
+func runScripted(t *testing.T, idx *scriptedIndex, opts ...Option) SimpleMonitor {
+	t.Helper()
+	mon, stop := startScripted(t, idx, opts...)
+	t.Cleanup(stop)
+	return mon
+}
+
+// startScripted runs a monitor of idx until stop is called.
+func startScripted(t *testing.T, idx *scriptedIndex, opts ...Option) (SimpleMonitor, func()) {
+	t.Helper()
+	cli, tr := esutil.MockESClient(t)
+	tr.RoundTripFn = idx.roundTrip
+
+	mon, err := NewSimple(testIndex, cli, cli, append([]Option{WithFetchSize(2)}, opts...)...)
+	require.NoError(t, err)
+	m := mon.(*simpleMonitorT)
+	m.retryDelay = time.Millisecond
+	m.maxRetryDelay = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(testlog.SetLogger(t).WithContext(context.Background()))
+	readyCh := make(chan error, 2)
+	WithReadyChan(readyCh)(m)
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+	stop := func() {
+		cancel()
+		require.NoError(t, <-done)
+	}
+	select {
+	case err := <-readyCh:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		stop()
+		require.Fail(t, "monitor did not start")
+	}
+	return mon, stop
+}
+
+func TestSimpleMonitorCheckpointAdvance(t *testing.T) {
+	idx := &scriptedIndex{docs: 2}
+	r := &received{}
+	mon := runScripted(t, idx, WithHandler(r.handle))
+	require.Equal(t, sqn.SeqNo{1}, mon.GetCheckpoint())
+
+	// the existing documents are skipped, the new ones are delivered in batches of the fetch size
+	idx.add(5)
+	require.Eventually(t, func() bool { return len(r.get()) == 5 }, time.Second, time.Millisecond)
+	require.Equal(t, seqNos(2, 6), r.get())
+	require.Eventually(t, func() bool { return mon.GetCheckpoint().Value() == 6 }, time.Second, time.Millisecond)
+
+	idx.add(1)
+	require.Eventually(t, func() bool { return len(r.get()) == 6 }, time.Second, time.Millisecond)
+	require.Equal(t, seqNos(2, 7), r.get())
+}
+
+func TestSimpleMonitorNoChange(t *testing.T) {
+	idx := &scriptedIndex{docs: 3}
+	r := &received{}
+	runScripted(t, idx, WithHandler(r.handle))
+
+	// polls that time out without advance do not search the index
+	require.Eventually(t, func() bool { _, waits := idx.counts(); return waits > 5 }, time.Second, time.Millisecond)
+	searches, _ := idx.counts()
+	require.Zero(t, searches)
+	require.Empty(t, r.get())
+}
+
+func TestSimpleMonitorOutput(t *testing.T) {
+	idx := &scriptedIndex{docs: 1}
+	mon := runScripted(t, idx)
+
+	idx.add(2)
+	select {
+	case hits := <-mon.Output():
+		require.Len(t, hits, 2)
+		require.Equal(t, int64(1), hits[0].SeqNo)
+		require.Equal(t, int64(2), hits[1].SeqNo)
+	case <-time.After(time.Second):
+		require.Fail(t, "no documents received")
+	}
+}
+
+func TestSimpleMonitorRecovery(t *testing.T) {
+	t.Run("elasticsearch errors", func(t *testing.T) {
+		idx := &scriptedIndex{docs: 1}
+		idx.fail(http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+		r := &received{}
+		runScripted(t, idx, WithHandler(r.handle))
+
+		idx.fail(http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusInternalServerError)
+		idx.add(3)
+		require.Eventually(t, func() bool { return len(r.get()) == 3 }, time.Second, time.Millisecond)
+		require.Equal(t, seqNos(1, 3), r.get())
+	})
+
+	t.Run("handler errors", func(t *testing.T) {
+		idx := &scriptedIndex{docs: 1}
+		r := &received{errs: 2}
+		runScripted(t, idx, WithHandler(r.handle))
+
+		// the documents are delivered again until the handler succeeds
+		idx.add(3)
+		require.Eventually(t, func() bool { return len(r.get()) == 3 }, time.Second, time.Millisecond)
+		require.Equal(t, seqNos(1, 3), r.get())
+	})
+
+	t.Run("index not found", func(t *testing.T) {
+		idx := &scriptedIndex{missing: true}
+		r := &received{}
+		mon := runScripted(t, idx, WithHandler(r.handle))
+		require.Equal(t, sqn.SeqNo(sqn.DefaultSeqNo), mon.GetCheckpoint())
+
+		// the documents of the created index are read from the start
+		idx.add(2)
+		require.Eventually(t, func() bool { return len(r.get()) == 2 }, time.Second, time.Millisecond)
+		require.Equal(t, seqNos(0, 1), r.get())
+	})
+
+	t.Run("recreated index", func(t *testing.T) {
+		idx := &scriptedIndex{docs: 10}
+		r := &received{}
+		mon := runScripted(t, idx, WithHandler(r.handle))
+		require.Equal(t, sqn.SeqNo{9}, mon.GetCheckpoint())
+
+		// the new index has fewer documents than the checkpoint
+		idx.recreate(3)
+		require.Eventually(t, func() bool { return len(r.get()) == 3 }, time.Second, time.Millisecond)
+		require.Equal(t, seqNos(0, 2), r.get())
+	})
+}
+
+func TestSimpleMonitorResync(t *testing.T) {
+	idx := &scriptedIndex{docs: 10}
+	r := &received{}
+	var resyncs atomic.Int32
+	reg := monitoring.NewRegistry()
+	mon := runScripted(t, idx, WithHandler(r.handle), WithStats(reg),
+		WithResyncHandler(func(context.Context) { resyncs.Add(1) }))
+	require.Equal(t, sqn.SeqNo{9}, mon.GetCheckpoint())
+
+	// the index is restored from a snapshot taken before the last documents, then written again
+	idx.recreate(4)
+	require.Eventually(t, func() bool { return resyncs.Load() == 1 }, time.Second, time.Millisecond)
+	require.Equal(t, int64(1), monitoring.CollectFlatSnapshot(reg, monitoring.Full, false).Ints["resyncs"])
+	idx.add(2)
+	require.Eventually(t, func() bool { return len(r.get()) == 6 }, time.Second, time.Millisecond)
+	require.Equal(t, seqNos(0, 5), r.get())
+	require.Equal(t, int32(1), resyncs.Load())
+}
+
+func TestSubscriptionResync(t *testing.T) {
+	m := &monitorT{subs: make(map[uint64]*subT)}
+	s1 := m.Subscribe()
+	s2 := m.Subscribe()
+	defer m.Unsubscribe(s2)
+
+	// a pending signal is not repeated and does not block
+	m.notifyResync(context.Background())
+	m.notifyResync(context.Background())
+	for _, s := range []Subscription{s1, s2} {
+		select {
+		case <-s.Resync():
+		default:
+			require.Fail(t, "no resync signaled")
+		}
+		select {
+		case <-s.Resync():
+			require.Fail(t, "resync signaled twice")
+		default:
+		}
+	}
+
+	m.Unsubscribe(s1)
+	m.notifyResync(context.Background())
+	select {
+	case <-s1.Resync():
+		require.Fail(t, "resync signaled after unsubscribe")
+	default:
+	}
+	<-s2.Resync()
+}
+
+func TestSimpleMonitorCheckpointStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("resume from the stored checkpoint", func(t *testing.T) {
+		store := NewMemoryCheckpointStore()
+		require.NoError(t, store.Store(ctx, sqn.SeqNo{2}))
+		idx := &scriptedIndex{docs: 6}
+		r := &received{}
+		runScripted(t, idx, WithHandler(r.handle), WithCheckpointStore(store))
+
+		// the documents written after the stored checkpoint are delivered
+		require.Eventually(t, func() bool { return len(r.get()) == 3 }, time.Second, time.Millisecond)
+		require.Equal(t, seqNos(3, 5), r.get())
+		require.Eventually(t, func() bool {
+			stored, err := store.Load(ctx)
+			return err == nil && stored.Value() == 5
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("no stored checkpoint", func(t *testing.T) {
+		store := NewMemoryCheckpointStore()
+		idx := &scriptedIndex{docs: 4}
+		mon := runScripted(t, idx, WithCheckpointStore(store))
+		require.Equal(t, sqn.SeqNo{3}, mon.GetCheckpoint())
+	})
+
+	t.Run("stored checkpoint of a recreated index", func(t *testing.T) {
+		store := NewMemoryCheckpointStore()
+		require.NoError(t, store.Store(ctx, sqn.SeqNo{20}))
+		idx := &scriptedIndex{docs: 2}
+		r := &received{}
+		runScripted(t, idx, WithHandler(r.handle), WithCheckpointStore(store))
+
+		require.Eventually(t, func() bool { return len(r.get()) == 2 }, time.Second, time.Millisecond)
+		require.Equal(t, seqNos(0, 1), r.get())
+	})
+}
+
+// countingStore counts the checkpoints saved to a memory store.
+type countingStore struct {
+	CheckpointStore
+	mx     sync.Mutex
+	stores int
+}
+
+func (s *countingStore) Store(ctx context.Context, checkpoint sqn.SeqNo) error {
+	s.mx.Lock()
+	s.stores++
+	s.mx.Unlock()
+	return s.CheckpointStore.Store(ctx, checkpoint)
+}
+
+func (s *countingStore) count() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.stores
+}
+
+func TestSimpleMonitorCheckpointInterval(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("restart resumes after the checkpoint saved on shutdown", func(t *testing.T) {
+		store := &countingStore{CheckpointStore: NewMemoryCheckpointStore()}
+		idx := &scriptedIndex{docs: 2}
+		r := &received{}
+		_, stop := startScripted(t, idx, WithHandler(r.handle), WithCheckpointStore(store), WithCheckpointInterval(time.Hour))
+
+		// the checkpoints of the batches are not saved before the interval elapses
+		idx.add(5)
+		require.Eventually(t, func() bool { return len(r.get()) == 5 }, time.Second, time.Millisecond)
+		require.Equal(t, 1, store.count())
+		stop()
+		stored, err := store.Load(ctx)
+		require.NoError(t, err)
+		require.Equal(t, sqn.SeqNo{6}, stored)
+
+		// the documents written while the monitor was stopped are delivered after the restart
+		idx.add(2)
+		runScripted(t, idx, WithHandler(r.handle), WithCheckpointStore(store), WithCheckpointInterval(time.Hour))
+		require.Eventually(t, func() bool { return len(r.get()) == 7 }, time.Second, time.Millisecond)
+		require.Equal(t, seqNos(2, 8), r.get())
+	})
+
+	t.Run("unchanged checkpoint is not saved", func(t *testing.T) {
+		store := &countingStore{CheckpointStore: NewMemoryCheckpointStore()}
+		require.NoError(t, store.CheckpointStore.Store(ctx, sqn.SeqNo{2}))
+		idx := &scriptedIndex{docs: 3}
+		_, stop := startScripted(t, idx, WithCheckpointStore(store))
+		require.Eventually(t, func() bool { _, waits := idx.counts(); return waits > 5 }, time.Second, time.Millisecond)
+		stop()
+		require.Zero(t, store.count())
+	})
+}
+
+func TestESCheckpointStore(t *testing.T) {
+	ctx := context.Background()
+	cli, tr := esutil.MockESClient(t)
+	var doc []byte
+	tr.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+		require.Equal(t, "/.fleet-checkpoints/_doc/actions", req.URL.Path)
+		switch req.Method {
+		case http.MethodGet:
+			if doc == nil {
+				return response(http.StatusNotFound, `{"_index":".fleet-checkpoints","_id":"actions","found":false}`), nil
+			}
+			return response(http.StatusOK, fmt.Sprintf(`{"_index":".fleet-checkpoints","_id":"actions","found":true,"_source":%s}`, doc)), nil
+		case http.MethodPut:
+			var buf bytes.Buffer
+			_, err := buf.ReadFrom(req.Body)
+			require.NoError(t, err)
+			doc = buf.Bytes()
+			return response(http.StatusOK, `{"result":"created"}`), nil
+		}
+		return nil, fmt.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+	}
+	store := NewESCheckpointStore(cli, ".fleet-checkpoints", "actions")
+
+	checkpoint, err := store.Load(ctx)
+	require.NoError(t, err)
+	require.Nil(t, checkpoint)
+
+	require.NoError(t, store.Store(ctx, sqn.SeqNo{4, 7}))
+	checkpoint, err = store.Load(ctx)
+	require.NoError(t, err)
+	require.Equal(t, sqn.SeqNo{4, 7}, checkpoint)
+}
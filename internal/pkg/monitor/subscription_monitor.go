@@ -28,6 +28,9 @@ var gCounter uint64
 type Subscription interface {
 	// Output is the channel the monitor send new documents to
 	Output() <-chan []es.HitT
+	// Resync is signaled when the global checkpoints of the index went back, the documents of the index are sent
+	// again from the start.
+	Resync() <-chan struct{}
 }
 
 // Monitor monitors for new documents in an index and sends them to its subscriptions.
@@ -44,8 +47,9 @@ type Monitor interface {
 
 // subT is a subscription to get notified for new documents.
 type subT struct {
-	idx uint64
-	c   chan []es.HitT
+	idx    uint64
+	c      chan []es.HitT
+	resync chan struct{}
 }
 
 // Output returns the subscription channel.
@@ -53,6 +57,11 @@ func (s *subT) Output() <-chan []es.HitT {
 	return s.c
 }
 
+// Resync returns the channel signaled when the monitor reads the index again from the start.
+func (s *subT) Resync() <-chan struct{} {
+	return s.resync
+}
+
 // monitorT monitors for new documents in an index.
 type monitorT struct {
 	sm         SimpleMonitor
@@ -63,17 +72,17 @@ type monitorT struct {
 
 // New creates new subscription monitor.
 func New(index string, esCli, monCli *elasticsearch.Client, opts ...Option) (Monitor, error) {
-	sm, err := NewSimple(index, esCli, monCli, opts...)
-	if err != nil {
-		return nil, err
-	}
-
 	m := &monitorT{
-		sm:         sm,
 		subs:       make(map[uint64]*subT),
 		subTimeout: defaultSubscriptionTimeout,
 	}
 
+	sm, err := NewSimple(index, esCli, monCli, append(opts, WithResyncHandler(m.notifyResync))...)
+	if err != nil {
+		return nil, err
+	}
+	m.sm = sm
+
 	return m, nil
 }
 
@@ -87,8 +96,9 @@ func (m *monitorT) Subscribe() Subscription {
 	idx := atomic.AddUint64(&gCounter, 1)
 
 	s := &subT{
-		idx: idx,
-		c:   make(chan []es.HitT, 1),
+		idx:    idx,
+		c:      make(chan []es.HitT, 1),
+		resync: make(chan struct{}, 1),
 	}
 
 	m.mut.Lock()
@@ -163,3 +173,16 @@ func (m *monitorT) notify(ctx context.Context, hits []es.HitT) {
 		wg.Wait()
 	}
 }
+
+// notifyResync signals the subscriptions that the index is read again from the start.
+// It does not block, a pending signal is not repeated.
+func (m *monitorT) notifyResync(_ context.Context) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	for _, s := range m.subs {
+		select {
+		case s.resync <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package openapigen generates the JSON OpenAPI document served by fleet-server from the spec in model/openapi.yml.
+//
+// The spec is the source of the request and response types of internal/pkg/api, the JSON document is
+// generated with go generate, see dev-tools/openapigen.
+package openapigen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Paths of the spec and of the generated document, relative to the repository root.
+const (
+	SpecFile = "model/openapi.yml"
+	JSONFile = "internal/pkg/api/openapi.json"
+)
+
+// Generate reads the spec of root and returns the content of the JSON document.
+func Generate(root string) ([]byte, error) {
+	p, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(SpecFile)))
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(p, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", SpecFile, err)
+	}
+	v, err := toJSON(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", SpecFile, err)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Write writes the generated document to root.
+func Write(root string, p []byte) error {
+	return os.WriteFile(filepath.Join(root, filepath.FromSlash(JSONFile)), p, 0o644) //nolint:gosec // generated sources are not secret
+}
+
+// toJSON returns the value of n that is encoded to JSON.
+// The timestamps are kept as written, so the dates of the examples are not turned into times.
+func toJSON(n *yaml.Node) (any, error) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, nil
+		}
+		return toJSON(n.Content[0])
+	case yaml.AliasNode:
+		return toJSON(n.Alias)
+	case yaml.MappingNode:
+		m := make(map[string]any, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i]
+			if k.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: mapping key is not a scalar", k.Line)
+			}
+			if _, ok := m[k.Value]; ok {
+				return nil, fmt.Errorf("line %d: mapping key %q already defined", k.Line, k.Value)
+			}
+			v, err := toJSON(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			m[k.Value] = v
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		s := make([]any, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := toJSON(c)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, v)
+		}
+		return s, nil
+	case yaml.ScalarNode:
+		if n.ShortTag() == "!!timestamp" {
+			return n.Value, nil
+		}
+		var v any
+		if err := n.Decode(&v); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("line %d: unexpected node kind %v", n.Line, n.Kind)
+	}
+}
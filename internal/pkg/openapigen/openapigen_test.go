@@ -0,0 +1,73 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package openapigen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const repoRoot = "../../.."
+
+// TestGeneratedFileUpToDate fails when the checked in document is not the one generated from model/openapi.yml.
+func TestGeneratedFileUpToDate(t *testing.T) {
+	want, err := Generate(repoRoot)
+	require.NoError(t, err)
+	got, err := os.ReadFile(filepath.Join(repoRoot, filepath.FromSlash(JSONFile)))
+	require.NoError(t, err, "%s is missing, run go generate", JSONFile)
+	assert.Equal(t, string(want), string(got), "%s is out of date, run go generate", JSONFile)
+}
+
+func TestGenerate(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "model"), 0o755))
+	write := func(spec string) {
+		require.NoError(t, os.WriteFile(filepath.Join(root, filepath.FromSlash(SpecFile)), []byte(spec), 0o600))
+	}
+
+	write(`openapi: 3.1.0
+info:
+  version: "0000-00-00"
+components:
+  schemas:
+    base: &base
+      type: object
+    agent: *base
+  examples:
+    date:
+      value: 2023-06-01
+    time:
+      value: 2022-12-01T01:02:03Z
+    size:
+      value: 8276748
+    enabled:
+      value: true
+`)
+	p, err := Generate(root)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"openapi": "3.1.0",
+		"info": {"version": "0000-00-00"},
+		"components": {
+			"schemas": {"base": {"type": "object"}, "agent": {"type": "object"}},
+			"examples": {
+				"date": {"value": "2023-06-01"},
+				"time": {"value": "2022-12-01T01:02:03Z"},
+				"size": {"value": 8276748},
+				"enabled": {"value": true}
+			}
+		}
+	}`, string(p))
+
+	write("paths:\n  /a: {}\n  /a: {}\n")
+	_, err = Generate(root)
+	require.ErrorContains(t, err, "already defined")
+}
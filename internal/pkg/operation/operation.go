@@ -0,0 +1,315 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package operation tracks the progress of the batch operations that update many agents.
+// The progress of an operation is persisted after each page, so an operation interrupted by a restart is resumed
+// from its cursor instead of starting over.
+package operation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+)
+
+var (
+	// ErrNotFound is returned when no operation has the requested ID.
+	ErrNotFound = errors.New("operation not found")
+	// ErrConflict is returned when an operation is started while an operation with the same ID is running,
+	// either in this process or with another type.
+	ErrConflict = errors.New("operation conflict")
+	// ErrNotResumable is returned by a ResumeFunc when the operation can only be resumed by the request that started it.
+	ErrNotResumable = errors.New("operation not resumable")
+)
+
+// Status is the status of an operation.
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+)
+
+// Operation is the progress document of a batch operation.
+type Operation struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Filter selects the agents of the operation, a resumed operation keeps the filter it was started with.
+	Filter map[string]string `json:"filter,omitempty"`
+	// Cursor is the position of the next page, its format is up to the operation.
+	Cursor    string `json:"cursor,omitempty"`
+	Total     int    `json:"total"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	Status    Status `json:"status"`
+	// Resumed is the number of times the operation was resumed after an interruption.
+	Resumed int `json:"resumed,omitempty"`
+	// Server is the ID of the fleet-server instance that runs the operation.
+	Server string `json:"server,omitempty"`
+	// Error is the error of the last page that failed.
+	Error     string `json:"error,omitempty"`
+	StartedAt string `json:"started_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// Page is the outcome of a page of an operation.
+type Page struct {
+	// Cursor is the position of the next page.
+	Cursor    string
+	Succeeded int
+	Failed    int
+	// Done is true on the last page.
+	Done bool
+}
+
+// StepFunc processes the page of op at op.Cursor. The updates of a page must be idempotent, the page of an
+// interrupted operation is processed again when the operation is resumed.
+type StepFunc func(ctx context.Context, op *Operation) (Page, error)
+
+// ResumeFunc returns the StepFunc that resumes op, an operation of another instance that went offline.
+// ErrNotResumable is returned when the step of the operation can not be rebuilt from the operation.
+type ResumeFunc func(ctx context.Context, op *Operation) (StepFunc, error)
+
+// Tracker runs the operations page by page, and persists their progress in a Store.
+type Tracker struct {
+	store  Store
+	server string
+
+	mx      sync.Mutex
+	running map[string]Operation
+	resume  map[string]ResumeFunc
+
+	started   monitoring.Uint
+	resumed   monitoring.Uint
+	completed monitoring.Uint
+}
+
+// Option is an optional setting of the Tracker.
+type Option func(*Tracker)
+
+// WithStats registers the counts of the operations and the progress of the running operations in reg.
+func WithStats(reg *monitoring.Registry) Option {
+	return func(t *Tracker) {
+		reg.Add("started", &t.started, monitoring.Full)
+		reg.Add("resumed", &t.resumed, monitoring.Full)
+		reg.Add("completed", &t.completed, monitoring.Full)
+		monitoring.NewFunc(reg, "running", t.report)
+	}
+}
+
+// WithServer records id, the ID of the fleet-server instance, as the instance running the operations.
+func WithServer(id string) Option {
+	return func(t *Tracker) {
+		t.server = id
+	}
+}
+
+// NewTracker returns a Tracker persisting the operations in store.
+func NewTracker(store Store, opts ...Option) *Tracker {
+	t := &Tracker{
+		store:   store,
+		running: make(map[string]Operation),
+		resume:  make(map[string]ResumeFunc),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Run runs op with step until its last page, and returns its progress.
+// An operation with the same ID and type left running by an interrupted run is resumed from its cursor with its
+// filter, any other stored operation with the same ID is replaced. ErrConflict is returned if the operation is
+// already running in this process; the idempotent pages make a concurrent run in another process harmless.
+func (t *Tracker) Run(ctx context.Context, op Operation, step StepFunc) (*Operation, error) {
+	log := zerolog.Ctx(ctx).With().Str("operation.id", op.ID).Str("operation.type", op.Type).Logger()
+
+	stored, err := t.store.Load(ctx, op.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load operation %s: %w", op.ID, err)
+	}
+	cur, resumed := &op, false
+	switch {
+	case stored == nil || stored.Status != StatusRunning:
+		cur.Cursor, cur.Total, cur.Succeeded, cur.Failed, cur.Resumed, cur.Error = "", 0, 0, 0, 0, ""
+		cur.Status = StatusRunning
+		cur.StartedAt = ftime.Now()
+	case stored.Type != op.Type:
+		return nil, fmt.Errorf("%w: operation %s is a running %s operation", ErrConflict, op.ID, stored.Type)
+	default:
+		cur, resumed = stored, true
+		cur.Resumed++
+	}
+	cur.Server = t.server
+	cur.UpdatedAt = ftime.Now()
+	if !t.start(cur) {
+		return nil, fmt.Errorf("%w: operation %s is already running", ErrConflict, op.ID)
+	}
+	defer t.finish(cur.ID)
+	if resumed {
+		t.resumed.Inc()
+		log.Info().Str("cursor", cur.Cursor).Int("total", cur.Total).Msg("resuming interrupted operation")
+	} else {
+		t.started.Inc()
+	}
+	if err := t.store.Store(ctx, cur); err != nil {
+		return cur, fmt.Errorf("failed to store operation %s: %w", cur.ID, err)
+	}
+
+	for {
+		page, err := step(ctx, cur)
+		if err != nil {
+			// the operation stays running, the next run resumes it from the last stored cursor
+			cur.Error = err.Error()
+			cur.UpdatedAt = ftime.Now()
+			t.update(cur)
+			if serr := t.store.Store(context.WithoutCancel(ctx), cur); serr != nil {
+				log.Warn().Err(serr).Msg("failed to store operation")
+			}
+			return cur, err
+		}
+		cur.Cursor = page.Cursor
+		cur.Succeeded += page.Succeeded
+		cur.Failed += page.Failed
+		cur.Total += page.Succeeded + page.Failed
+		cur.Error = ""
+		cur.UpdatedAt = ftime.Now()
+		if page.Done {
+			cur.Status = StatusCompleted
+		}
+		if err := t.store.Store(ctx, cur); err != nil {
+			// the page is processed again when the operation is resumed
+			return cur, fmt.Errorf("failed to store operation %s: %w", cur.ID, err)
+		}
+		t.update(cur)
+		if page.Done {
+			t.completed.Inc()
+			log.Debug().Int("total", cur.Total).Int("succeeded", cur.Succeeded).Int("failed", cur.Failed).Msg("operation completed")
+			return cur, nil
+		}
+	}
+}
+
+// Get returns the progress of the operation, ErrNotFound is returned if it does not exist.
+func (t *Tracker) Get(ctx context.Context, id string) (*Operation, error) {
+	t.mx.Lock()
+	op, ok := t.running[id]
+	t.mx.Unlock()
+	if ok {
+		return &op, nil
+	}
+	stored, err := t.store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, ErrNotFound
+	}
+	return stored, nil
+}
+
+// Resumable registers fn to resume the operations of type typ taken over from an instance that went offline.
+func (t *Tracker) Resumable(typ string, fn ResumeFunc) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.resume[typ] = fn
+}
+
+// Running returns the IDs of the operations running in this process.
+func (t *Tracker) Running() []string {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	ids := make([]string, 0, len(t.running))
+	for id := range t.running {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// TakeOver resumes the operations ids that the instance server was running when it went offline, the operations that
+// completed, were resumed by another instance or are not resumable are skipped. The operations are resumed one after
+// the other; if server is still running them the idempotent pages make the concurrent runs harmless.
+func (t *Tracker) TakeOver(ctx context.Context, server string, ids []string) error {
+	var errs []error
+	for _, id := range ids {
+		log := zerolog.Ctx(ctx).With().Str("operation.id", id).Str("operation.server", server).Logger()
+		stored, err := t.store.Load(ctx, id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to load operation %s: %w", id, err))
+			continue
+		}
+		if stored == nil || stored.Status != StatusRunning || stored.Server != server {
+			continue
+		}
+		t.mx.Lock()
+		resume, ok := t.resume[stored.Type]
+		t.mx.Unlock()
+		if !ok {
+			log.Warn().Str("operation.type", stored.Type).Msg("operation of an offline fleet server is not resumable")
+			continue
+		}
+		step, err := resume(ctx, stored)
+		if errors.Is(err, ErrNotResumable) {
+			log.Warn().Err(err).Msg("operation of an offline fleet server is resumed by retrying its request")
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to resume operation %s: %w", id, err))
+			continue
+		}
+		log.Info().Msg("taking over operation of an offline fleet server")
+		if _, err := t.Run(ctx, *stored, step); err != nil && !errors.Is(err, ErrConflict) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// start adds op to the running operations, false is returned if it is already running.
+func (t *Tracker) start(op *Operation) bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if _, ok := t.running[op.ID]; ok {
+		return false
+	}
+	t.running[op.ID] = *op
+	return true
+}
+
+func (t *Tracker) update(op *Operation) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.running[op.ID] = *op
+}
+
+func (t *Tracker) finish(id string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	delete(t.running, id)
+}
+
+func (t *Tracker) report(_ monitoring.Mode, v monitoring.Visitor) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	v.OnRegistryStart()
+	defer v.OnRegistryFinished()
+
+	for id, op := range t.running {
+		monitoring.ReportNamespace(v, id, func() {
+			monitoring.ReportString(v, "type", op.Type)
+			monitoring.ReportString(v, "cursor", op.Cursor)
+			monitoring.ReportInt(v, "total", int64(op.Total))
+			monitoring.ReportInt(v, "succeeded", int64(op.Succeeded))
+			monitoring.ReportInt(v, "failed", int64(op.Failed))
+		})
+	}
+}
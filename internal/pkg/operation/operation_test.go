@@ -0,0 +1,232 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+// itemsStep processes the items in pages of size, the cursor is the index of the next item.
+// It fails once the items before failAt are processed, as a restart would interrupt it.
+type itemsStep struct {
+	items     int
+	size      int
+	failAt    int
+	processed []int
+}
+
+func (s *itemsStep) step(_ context.Context, op *Operation) (Page, error) {
+	start := 0
+	if op.Cursor != "" {
+		start, _ = strconv.Atoi(op.Cursor)
+	}
+	if s.failAt > 0 && start >= s.failAt {
+		return Page{}, errors.New("interrupted")
+	}
+	end := min(start+s.size, s.items)
+	for i := start; i < end; i++ {
+		s.processed = append(s.processed, i)
+	}
+	return Page{Cursor: strconv.Itoa(end), Succeeded: end - start, Done: end == s.items}, nil
+}
+
+func TestTrackerResume(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	store := NewMemoryStore()
+	reg := monitoring.NewRegistry()
+	tracker := NewTracker(store, WithStats(reg))
+
+	s := &itemsStep{items: 10, size: 3, failAt: 6}
+	op, err := tracker.Run(ctx, Operation{ID: "op-1", Type: "test", Filter: map[string]string{"before": "t1"}}, s.step)
+	require.EqualError(t, err, "interrupted")
+	require.Equal(t, StatusRunning, op.Status)
+	require.Equal(t, "6", op.Cursor)
+	require.Equal(t, 6, op.Succeeded)
+	require.Equal(t, "interrupted", op.Error)
+
+	// another tracker resumes the operation from the stored cursor, with its filter
+	s.failAt = 0
+	op, err = NewTracker(store).Run(ctx, Operation{ID: "op-1", Type: "test", Filter: map[string]string{"before": "t2"}}, s.step)
+	require.NoError(t, err)
+	require.Equal(t, StatusCompleted, op.Status)
+	require.Equal(t, map[string]string{"before": "t1"}, op.Filter)
+	require.Equal(t, 10, op.Total)
+	require.Equal(t, 1, op.Resumed)
+	require.Empty(t, op.Error)
+	require.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, s.processed, "no item is processed twice")
+
+	stored, err := tracker.Get(ctx, "op-1")
+	require.NoError(t, err)
+	require.Equal(t, op, stored)
+
+	// a completed operation starts over
+	s.processed = nil
+	op, err = tracker.Run(ctx, Operation{ID: "op-1", Type: "test"}, s.step)
+	require.NoError(t, err)
+	require.Equal(t, 10, op.Total)
+	require.Zero(t, op.Resumed)
+	require.Len(t, s.processed, 10)
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	require.Equal(t, int64(2), snapshot.Ints["started"])
+	require.Equal(t, int64(1), snapshot.Ints["completed"])
+}
+
+func TestTrackerConflict(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	reg := monitoring.NewRegistry()
+	tracker := NewTracker(NewMemoryStore(), WithStats(reg))
+
+	_, err := tracker.Get(ctx, "op-1")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	release := make(chan struct{})
+	done := make(chan error)
+	inStep := make(chan struct{})
+	go func() {
+		_, err := tracker.Run(ctx, Operation{ID: "op-1", Type: "test"}, func(_ context.Context, op *Operation) (Page, error) {
+			if op.Cursor == "" {
+				return Page{Cursor: "1", Succeeded: 2, Failed: 1}, nil
+			}
+			close(inStep)
+			<-release
+			return Page{Cursor: "2", Done: true}, nil
+		})
+		done <- err
+	}()
+	<-inStep
+
+	// the progress of the running operation is reported
+	op, err := tracker.Get(ctx, "op-1")
+	require.NoError(t, err)
+	require.Equal(t, StatusRunning, op.Status)
+	require.Equal(t, 3, op.Total)
+	snapshot := monitoring.CollectStructSnapshot(reg, monitoring.Full, false)
+	running, ok := snapshot["running"].(map[string]interface{})
+	require.True(t, ok)
+	require.Equal(t, map[string]interface{}{"type": "test", "cursor": "1", "total": int64(3), "succeeded": int64(2), "failed": int64(1)}, running["op-1"])
+
+	_, err = tracker.Run(ctx, Operation{ID: "op-1", Type: "test"}, nil)
+	require.ErrorIs(t, err, ErrConflict)
+
+	close(release)
+	require.NoError(t, <-done)
+	op, err = tracker.Get(ctx, "op-1")
+	require.NoError(t, err)
+	require.Equal(t, StatusCompleted, op.Status)
+}
+
+func TestTrackerConflictType(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	store := NewMemoryStore()
+	require.NoError(t, store.Store(ctx, &Operation{ID: "op-1", Type: "reassign", Status: StatusRunning}))
+
+	_, err := NewTracker(store).Run(ctx, Operation{ID: "op-1", Type: "test"}, nil)
+	require.ErrorIs(t, err, ErrConflict)
+}
+
+func TestTrackerTakeOver(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	store := NewMemoryStore()
+
+	// server-2 went offline while running op-1, op-2 and op-3
+	dead := NewTracker(store, WithServer("server-2"))
+	s := &itemsStep{items: 10, size: 3, failAt: 6}
+	_, err := dead.Run(ctx, Operation{ID: "op-1", Type: "test"}, s.step)
+	require.EqualError(t, err, "interrupted")
+	require.NoError(t, store.Store(ctx, &Operation{ID: "op-2", Type: "listed", Status: StatusRunning, Server: "server-2"}))
+	require.NoError(t, store.Store(ctx, &Operation{ID: "op-3", Type: "unknown", Status: StatusRunning, Server: "server-2"}))
+	// op-4 was resumed by server-3 and op-5 completed since the last heartbeat of server-2
+	require.NoError(t, store.Store(ctx, &Operation{ID: "op-4", Type: "test", Status: StatusRunning, Server: "server-3"}))
+	require.NoError(t, store.Store(ctx, &Operation{ID: "op-5", Type: "test", Status: StatusCompleted, Server: "server-2"}))
+
+	tracker := NewTracker(store, WithServer("server-1"))
+	var resumed []string
+	tracker.Resumable("test", func(_ context.Context, op *Operation) (StepFunc, error) {
+		resumed = append(resumed, op.ID)
+		s.failAt = 0
+		return s.step, nil
+	})
+	tracker.Resumable("listed", func(context.Context, *Operation) (StepFunc, error) {
+		return nil, ErrNotResumable
+	})
+	require.NoError(t, tracker.TakeOver(ctx, "server-2", []string{"op-1", "op-2", "op-3", "op-4", "op-5", "op-6"}))
+	require.Equal(t, []string{"op-1"}, resumed)
+
+	op, err := tracker.Get(ctx, "op-1")
+	require.NoError(t, err)
+	require.Equal(t, StatusCompleted, op.Status)
+	require.Equal(t, "server-1", op.Server)
+	require.Equal(t, 1, op.Resumed)
+	require.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, s.processed, "no item is processed twice")
+	for _, id := range []string{"op-2", "op-3", "op-4"} {
+		op, err := tracker.Get(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, StatusRunning, op.Status, id)
+		require.NotEqual(t, "server-1", op.Server, id)
+	}
+}
+
+func TestTrackerRunning(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	tracker := NewTracker(NewMemoryStore())
+	require.Empty(t, tracker.Running())
+
+	release := make(chan struct{})
+	done := make(chan error)
+	inStep := make(chan struct{})
+	go func() {
+		_, err := tracker.Run(ctx, Operation{ID: "op-1", Type: "test"}, func(context.Context, *Operation) (Page, error) {
+			close(inStep)
+			<-release
+			return Page{Done: true}, nil
+		})
+		done <- err
+	}()
+	<-inStep
+	require.Equal(t, []string{"op-1"}, tracker.Running())
+
+	close(release)
+	require.NoError(t, <-done)
+	require.Empty(t, tracker.Running())
+}
+
+func TestESStore(t *testing.T) {
+	ctx := context.Background()
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Read", mock.Anything, ".fleet-checkpoints", "operation:missing", mock.Anything).Return([]byte(nil), es.ErrElasticNotFound)
+	bulker.On("Read", mock.Anything, ".fleet-checkpoints", "operation:op-1", mock.Anything).Return([]byte(`{"id":"op-1","type":"test","cursor":"3","total":3,"succeeded":3,"failed":0,"status":"running"}`), nil)
+	var indexed Operation
+	bulker.On("Index", mock.Anything, ".fleet-checkpoints", "operation:op-2", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &indexed))
+	}).Return("operation:op-2", nil)
+	store := NewESStore(bulker, ".fleet-checkpoints")
+
+	op, err := store.Load(ctx, "missing")
+	require.NoError(t, err)
+	require.Nil(t, op)
+
+	op, err = store.Load(ctx, "op-1")
+	require.NoError(t, err)
+	require.Equal(t, &Operation{ID: "op-1", Type: "test", Cursor: "3", Total: 3, Succeeded: 3, Status: StatusRunning}, op)
+
+	require.NoError(t, store.Store(ctx, &Operation{ID: "op-2", Type: "test", Status: StatusCompleted}))
+	require.Equal(t, Operation{ID: "op-2", Type: "test", Status: StatusCompleted}, indexed)
+	bulker.AssertExpectations(t)
+}
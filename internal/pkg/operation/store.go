@@ -0,0 +1,104 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+)
+
+// Store persists the progress of the operations.
+type Store interface {
+	// Load returns the operation, or nil if it was never stored.
+	Load(ctx context.Context, id string) (*Operation, error)
+	// Store saves the operation.
+	Store(ctx context.Context, op *Operation) error
+}
+
+// memoryStore keeps the operations in memory, they survive the restarts of a tracker in the same process.
+type memoryStore struct {
+	mx  sync.Mutex
+	ops map[string]Operation
+}
+
+// NewMemoryStore returns a Store that keeps the operations in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{ops: make(map[string]Operation)}
+}
+
+func (s *memoryStore) Load(_ context.Context, id string) (*Operation, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	op, ok := s.ops[id]
+	if !ok {
+		return nil, nil
+	}
+	op.Filter = cloneFilter(op.Filter)
+	return &op, nil
+}
+
+func (s *memoryStore) Store(_ context.Context, op *Operation) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	stored := *op
+	stored.Filter = cloneFilter(op.Filter)
+	s.ops[op.ID] = stored
+	return nil
+}
+
+func cloneFilter(filter map[string]string) map[string]string {
+	if filter == nil {
+		return nil
+	}
+	clone := make(map[string]string, len(filter))
+	for k, v := range filter {
+		clone[k] = v
+	}
+	return clone
+}
+
+// esStore keeps each operation in a document of index, so any fleet-server instance resumes it.
+type esStore struct {
+	bulker bulk.Bulk
+	index  string
+}
+
+// NewESStore returns a Store that keeps the operations in index, with the document ID operation:<id>.
+func NewESStore(bulker bulk.Bulk, index string) Store {
+	return &esStore{bulker: bulker, index: index}
+}
+
+func docID(id string) string {
+	return "operation:" + id
+}
+
+func (s *esStore) Load(ctx context.Context, id string) (*Operation, error) {
+	body, err := s.bulker.Read(ctx, s.index, docID(id))
+	if errors.Is(err, es.ErrElasticNotFound) || errors.Is(err, es.ErrIndexNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var op Operation
+	if err := json.Unmarshal(body, &op); err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+func (s *esStore) Store(ctx context.Context, op *Operation) error {
+	body, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	_, err = s.bulker.Index(ctx, s.index, docID(op.ID), body)
+	return err
+}
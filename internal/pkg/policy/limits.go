@@ -0,0 +1,84 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package policy
+
+import (
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+// Limits are the quotas of the agents of a policy, a zero value is not set by the policy.
+type Limits struct {
+	MaxAgents          int
+	MaxEnrollPerMinute int
+	CheckinLongPoll    time.Duration
+	NextCheckinDelay   time.Duration
+}
+
+// DefaultLimits returns the server-wide limits of the policies.
+func DefaultLimits(cfg *config.Server) Limits {
+	return Limits{
+		MaxAgents:          cfg.Limits.PolicyQuotas.MaxAgents,
+		MaxEnrollPerMinute: cfg.Limits.PolicyQuotas.MaxEnrollPerMinute,
+		CheckinLongPoll:    cfg.Timeouts.CheckinLongPoll,
+	}
+}
+
+// WithDefaults returns l with the values of defaults for the limits l does not set.
+func (l Limits) WithDefaults(defaults Limits) Limits {
+	if l.MaxAgents == 0 {
+		l.MaxAgents = defaults.MaxAgents
+	}
+	if l.MaxEnrollPerMinute == 0 {
+		l.MaxEnrollPerMinute = defaults.MaxEnrollPerMinute
+	}
+	if l.CheckinLongPoll == 0 {
+		l.CheckinLongPoll = defaults.CheckinLongPoll
+	}
+	return l
+}
+
+// parseLimits returns the limits of the policy document.
+// Invalid limits are logged and ignored, so they do not prevent the policy from being delivered.
+func parseLimits(zlog zerolog.Logger, l *model.PolicyLimits) Limits {
+	var limits Limits
+	if l == nil {
+		return limits
+	}
+	if l.MaxAgents > 0 {
+		limits.MaxAgents = int(l.MaxAgents)
+	} else if l.MaxAgents < 0 {
+		zlog.Warn().Int64("max_agents", l.MaxAgents).Msg("ignoring negative policy max_agents limit")
+	}
+	if l.MaxEnrollPerMinute > 0 {
+		limits.MaxEnrollPerMinute = int(l.MaxEnrollPerMinute)
+	} else if l.MaxEnrollPerMinute < 0 {
+		zlog.Warn().Int64("max_enroll_per_minute", l.MaxEnrollPerMinute).Msg("ignoring negative policy max_enroll_per_minute limit")
+	}
+	limits.CheckinLongPoll = parseLimitDuration(zlog, "checkin_long_poll", l.CheckinLongPoll)
+	limits.NextCheckinDelay = parseLimitDuration(zlog, "next_checkin_delay", l.NextCheckinDelay)
+	return limits
+}
+
+// parseLimitDuration returns the positive duration of the limit, or zero if it is not set or invalid.
+func parseLimitDuration(zlog zerolog.Logger, name, value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	switch {
+	case err != nil:
+		zlog.Warn().Err(err).Str(name, value).Msgf("ignoring invalid policy %s limit", name)
+		return 0
+	case d <= 0:
+		zlog.Warn().Str(name, value).Msgf("ignoring non-positive policy %s limit", name)
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,97 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+)
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits *model.PolicyLimits
+		expect Limits
+	}{{
+		name: "no limits",
+	}, {
+		name:   "limits",
+		limits: &model.PolicyLimits{MaxAgents: 10, MaxEnrollPerMinute: 5, CheckinLongPoll: "2m", NextCheckinDelay: "30s"},
+		expect: Limits{MaxAgents: 10, MaxEnrollPerMinute: 5, CheckinLongPoll: 2 * time.Minute, NextCheckinDelay: 30 * time.Second},
+	}, {
+		name:   "invalid limits are ignored",
+		limits: &model.PolicyLimits{MaxAgents: -1, MaxEnrollPerMinute: 5, CheckinLongPoll: "soon"},
+		expect: Limits{MaxEnrollPerMinute: 5},
+	}, {
+		name:   "negative long poll is ignored",
+		limits: &model.PolicyLimits{MaxAgents: 10, MaxEnrollPerMinute: -5, CheckinLongPoll: "-1m", NextCheckinDelay: "0s"},
+		expect: Limits{MaxAgents: 10},
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expect, parseLimits(zerolog.Nop(), tc.limits))
+		})
+	}
+}
+
+func TestLimitsWithDefaults(t *testing.T) {
+	cfg := &config.Server{}
+	cfg.Limits.PolicyQuotas = config.PolicyQuotas{MaxAgents: 100, MaxEnrollPerMinute: 60}
+	cfg.Timeouts.CheckinLongPoll = 5 * time.Minute
+	defaults := DefaultLimits(cfg)
+
+	// the policy limits override the server defaults
+	require.Equal(t, Limits{MaxAgents: 10, MaxEnrollPerMinute: 60, CheckinLongPoll: time.Minute},
+		Limits{MaxAgents: 10, CheckinLongPoll: time.Minute}.WithDefaults(defaults))
+	// the server defaults apply to the policies without limits
+	require.Equal(t, Limits{MaxAgents: 100, MaxEnrollPerMinute: 60, CheckinLongPoll: 5 * time.Minute},
+		Limits{}.WithDefaults(defaults))
+}
+
+func TestMonitorLimits(t *testing.T) {
+	ctx := context.Background()
+	m := NewMonitor(ftesting.NewMockBulk(), nil, config.ServerLimits{}).(*monitorT)
+
+	// unknown policy
+	_, ok := m.Limits("policy-1")
+	require.False(t, ok)
+
+	// subscribed policy that is not loaded yet
+	_, err := m.Subscribe(ctx, "agent-1", "policy-1", 1)
+	require.NoError(t, err)
+	_, ok = m.Limits("policy-1")
+	require.False(t, ok)
+	_, ok = m.Policy("policy-1")
+	require.False(t, ok)
+
+	var data model.PolicyData
+	require.NoError(t, json.Unmarshal([]byte(logstashOutputPolicy), &data))
+	pp, err := NewParsedPolicy(ctx, ftesting.NewMockBulk(), model.Policy{
+		PolicyID:    "policy-1",
+		RevisionIdx: 1,
+		Data:        &data,
+		Limits:      &model.PolicyLimits{MaxAgents: 2},
+	})
+	require.NoError(t, err)
+	m.updatePolicy(ctx, pp)
+
+	limits, ok := m.Limits("policy-1")
+	require.True(t, ok)
+	require.Equal(t, Limits{MaxAgents: 2}, limits)
+	latest, ok := m.Policy("policy-1")
+	require.True(t, ok)
+	require.Equal(t, int64(1), latest.Policy.RevisionIdx)
+}
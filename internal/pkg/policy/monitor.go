@@ -21,6 +21,7 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/monitor"
+	"github.com/elastic/fleet-server/v7/internal/pkg/waiters"
 )
 
 const cloudPolicyID = "policy-elastic-agent-on-cloud"
@@ -43,6 +44,10 @@ If the subscription is unsubscribed (ie. the agent drops offline), this implemen
 will remove the subscription request from its current location in either the waiting
 queue on the policy or the pending queue.
 
+The checkins waiting for a policy change are registered by agent id in a waiters.Registry,
+like the checkins waiting for actions; a newer checkin of an agent preempts its older one,
+and the subscription is removed when the context of the checkin is done.
+
 Ordering is achieved with a simple double linked list implementation that allows object
 migration across queues, and O(1) unlink without knowledge about which queue the subscription
 is in.
@@ -58,10 +63,24 @@ type Monitor interface {
 	Run(ctx context.Context) error
 
 	// Subscribe creates a new subscription for a policy update.
-	Subscribe(agentID string, policyID string, revisionIdx int64) (Subscription, error)
+	// The subscription is removed when ctx is done or by Unsubscribe. A previous subscription of the agent is preempted.
+	Subscribe(ctx context.Context, agentID string, policyID string, revisionIdx int64) (Subscription, error)
 
 	// Unsubscribe removes the current subscription.
 	Unsubscribe(sub Subscription) error
+
+	// Reload applies the policy limit of the reloaded server configuration.
+	Reload(cfg *config.Server)
+
+	// Limits returns the limits of the latest revision of the policy, false is returned if the policy is not loaded.
+	Limits(policyID string) (Limits, bool)
+
+	// Policy returns the latest revision of the policy, false is returned if the policy is not loaded.
+	Policy(policyID string) (*ParsedPolicy, bool)
+
+	// Load adds the latest revision of the policy to the monitor if it is not loaded yet,
+	// so the agents assigned to the policy get it on their next checkin. dl.ErrNotFound is returned if the policy does not exist.
+	Load(ctx context.Context, policyID string) error
 }
 
 type policyFetcher func(ctx context.Context, bulker bulk.Bulk, opt ...dl.Option) ([]model.Policy, error)
@@ -83,36 +102,68 @@ type monitorT struct {
 
 	policies map[string]policyT
 	pendingQ *subT
+	waiters  *waiters.Registry[*ParsedPolicy]
 
 	policyF       policyFetcher
+	revisionF     revisionFetcher
 	policiesIndex string
 	limit         *rate.Limiter
+	reader        *Reader
 
 	startCh chan struct{}
 }
 
-// NewMonitor creates the policy monitor for subscribing agents.
-func NewMonitor(bulker bulk.Bulk, monitor monitor.Monitor, cfg config.ServerLimits) Monitor {
-	burst := cfg.PolicyLimit.Burst
-	interval := rate.Every(cfg.PolicyLimit.Interval)
-	if cfg.PolicyLimit.Burst <= 0 {
-		burst = 1
-	}
-	if cfg.PolicyLimit.Interval <= 0 {
-		interval = rate.Every(time.Nanosecond) // set minimal spin rate
+// MonitorOpt is an optional setting for the policy monitor.
+type MonitorOpt func(*monitorT)
+
+// WithReader sets the reader whose cached policies are invalidated when the monitor finds a new revision.
+func WithReader(r *Reader) MonitorOpt {
+	return func(m *monitorT) {
+		m.reader = r
 	}
-	return &monitorT{
+}
+
+// NewMonitor creates the policy monitor for subscribing agents.
+func NewMonitor(bulker bulk.Bulk, monitor monitor.Monitor, cfg config.ServerLimits, opts ...MonitorOpt) Monitor {
+	interval, burst := policyRate(cfg.PolicyLimit)
+	m := &monitorT{
 		bulker:        bulker,
 		monitor:       monitor,
 		kickCh:        make(chan struct{}, 1),
 		deployCh:      make(chan struct{}, 1),
 		policies:      make(map[string]policyT),
 		pendingQ:      makeHead(),
+		waiters:       waiters.New[*ParsedPolicy](0), // the checkins are bounded by the action dispatcher
 		limit:         rate.NewLimiter(interval, burst),
 		policyF:       dl.QueryLatestPolicies,
+		revisionF:     dl.FindPolicyRevision,
 		policiesIndex: dl.FleetPolicies,
 		startCh:       make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// policyRate returns the rate and the burst of the policy dispatch limiter of l.
+func policyRate(l config.Limit) (rate.Limit, int) {
+	burst := l.Burst
+	interval := rate.Every(l.Interval)
+	if l.Burst <= 0 {
+		burst = 1
+	}
+	if l.Interval <= 0 {
+		interval = rate.Every(time.Nanosecond) // set minimal spin rate
+	}
+	return interval, burst
+}
+
+// Reload applies the policy limit of cfg to the dispatch of the policies, the subscriptions are kept.
+func (m *monitorT) Reload(cfg *config.Server) {
+	interval, burst := policyRate(cfg.Limits.PolicyLimit)
+	m.limit.SetLimit(interval)
+	m.limit.SetBurst(burst)
 }
 
 // endTrans is a convenience function to end the passed transaction if it's not nil
@@ -177,6 +228,22 @@ LOOP:
 			}
 			m.dispatchPending(iCtx)
 			endTrans(trans)
+		case <-s.Resync():
+			m.log.Info().Msg("policy monitor resync: the policies index was restored")
+			if m.bulker.HasTracer() {
+				trans = m.bulker.StartTransaction("resync policies", "policy_monitor")
+				iCtx = apm.ContextWithTransaction(ctx, trans)
+			}
+
+			if m.reader != nil {
+				m.reader.InvalidateAll()
+			}
+			if err := m.loadPolicies(iCtx); err != nil {
+				endTrans(trans)
+				return err
+			}
+			m.dispatchPending(iCtx)
+			endTrans(trans)
 		case <-ctx.Done():
 			break LOOP
 		}
@@ -237,10 +304,22 @@ func (m *monitorT) dispatchPending(ctx context.Context) {
 		return
 	}
 
-	for s != nil {
+	for ; s != nil; s = m.pendingQ.popFront() {
+		if s.preempted() {
+			logger.TraceDecision(&m.log, s.agentID, "pending_revision", "dropped").
+				Str(logger.DecisionReason, "subscription preempted by a newer checkin").
+				Str(logger.PolicyID, s.policyID).
+				Msg("skip preempted subscription")
+			continue
+		}
 		// Use a rate.Limiter to control how fast policies are passed to the checkin handler.
 		// This is done to avoid all responses to agents on the same policy from being written at once.
 		// If too many (checkin) responses are written concurrently memory usage may explode due to allocating gzip writers.
+		throttle := "allowed"
+		if m.limit.Tokens() < 1 {
+			throttle = "delayed"
+		}
+		wait := time.Now()
 		err := m.limit.Wait(ctx)
 		if err != nil {
 			m.log.Warn().Err(err).Msg("Policy limit error")
@@ -255,26 +334,24 @@ func (m *monitorT) dispatchPending(ctx context.Context) {
 			return
 		}
 
-		select {
-		case <-ctx.Done():
+		if ctx.Err() != nil {
 			m.log.Debug().Err(ctx.Err()).Msg("context termination detected in policy dispatch")
 			return
-		case s.ch <- &policy.pp:
-			m.log.Debug().
-				Str(logger.PolicyID, s.policyID).
-				Int64("subscription_revision_idx", s.revIdx).
-				Int64(logger.RevisionIdx, s.revIdx).
-				Msg("dispatch policy change")
-		default:
-			// Should never block on a channel; we created a channel of size one.
-			// A block here indicates a logic error somewheres.
-			m.log.Error().
+		}
+		if !m.waiters.Notify(s.agentID, &policy.pp) {
+			// The checkin ended before it was unsubscribed.
+			logger.TraceDecision(&m.log, s.agentID, "throttle", throttle).
+				Str(logger.DecisionReason, "checkin no longer waiting").
 				Str(logger.PolicyID, s.policyID).
-				Str(logger.AgentID, s.agentID).
-				Msg("logic error: should never block on policy channel")
-			return
+				Msg("policy change not dispatched")
+			continue
 		}
-		s = m.pendingQ.popFront()
+		logger.TraceDecision(&m.log, s.agentID, "throttle", throttle).
+			Dur("throttle.wait", time.Since(wait)).
+			Str(logger.PolicyID, s.policyID).
+			Int64("subscription_revision_idx", s.revIdx).
+			Int64(logger.RevisionIdx, s.revIdx).
+			Msg("dispatch policy change")
 		nQueued += 1
 	}
 
@@ -368,6 +445,10 @@ func (m *monitorT) updatePolicy(ctx context.Context, pp *ParsedPolicy) bool {
 		Int64(logger.RevisionIdx, newPolicy.RevisionIdx).
 		Logger()
 
+	if m.reader != nil {
+		m.reader.Invalidate(newPolicy.PolicyID, newPolicy.RevisionIdx)
+	}
+
 	m.mut.Lock()
 	defer m.mut.Unlock()
 
@@ -396,6 +477,10 @@ func (m *monitorT) updatePolicy(ctx context.Context, pp *ParsedPolicy) bool {
 
 	iter := NewIterator(p.head)
 	for sub := iter.Next(); sub != nil; sub = iter.Next() {
+		if sub.preempted() {
+			iter.Unlink()
+			continue
+		}
 		if sub.isUpdate(&newPolicy) {
 
 			// Unlink the target node from the list
@@ -410,8 +495,8 @@ func (m *monitorT) updatePolicy(ctx context.Context, pp *ParsedPolicy) bool {
 				m.pendingQ.pushBack(sub)
 			}
 
-			zlog.Debug().
-				Str(logger.AgentID, sub.agentID).
+			logger.TraceDecision(&zlog, sub.agentID, "pending_revision", "found").
+				Int64("subscription_revision_idx", sub.revIdx).
 				Msg("scheduled pendingQ on policy revision")
 
 			nQueued += 1
@@ -443,10 +528,15 @@ func (m *monitorT) kickDeploy() {
 }
 
 // Subscribe creates a new subscription for a policy update.
-func (m *monitorT) Subscribe(agentID string, policyID string, revisionIdx int64) (Subscription, error) {
+// The subscription is removed when ctx is done or by Unsubscribe. A previous subscription of the agent is preempted.
+func (m *monitorT) Subscribe(ctx context.Context, agentID string, policyID string, revisionIdx int64) (Subscription, error) {
 	if revisionIdx < 0 {
 		return nil, errors.New("revisionIdx must be greater than or equal to 0")
 	}
+	w, err := m.waiters.Register(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
 	m.log.Debug().
 		Str(logger.AgentID, agentID).
 		Str(logger.PolicyID, policyID).
@@ -458,9 +548,17 @@ func (m *monitorT) Subscribe(agentID string, policyID string, revisionIdx int64)
 		agentID,
 		revisionIdx,
 	)
+	s.w = w
+	s.stop = context.AfterFunc(ctx, func() { m.unlink(s) })
 
 	m.mut.Lock()
 	defer m.mut.Unlock()
+	if err := ctx.Err(); err != nil {
+		// the unlink may have run before the subscription was linked
+		s.stop()
+		m.waiters.Unregister(w)
+		return nil, err
+	}
 	p, ok := m.policies[policyID]
 
 	switch {
@@ -469,6 +567,8 @@ func (m *monitorT) Subscribe(agentID string, policyID string, revisionIdx int64)
 		m.log.Info().
 			Str(logger.PolicyID, policyID).
 			Str(logger.AgentID, s.agentID).
+			Str(logger.Decision, "pending_revision").
+			Str(logger.DecisionResult, "unknown_policy").
 			Msg("force load on unknown policyId")
 		p = policyT{head: makeHead()}
 		p.head.pushBack(s)
@@ -477,17 +577,19 @@ func (m *monitorT) Subscribe(agentID string, policyID string, revisionIdx int64)
 	case s.isUpdate(&p.pp.Policy):
 		empty := m.pendingQ.isEmpty()
 		m.pendingQ.pushBack(s)
-		m.log.Debug().
-			Str(logger.AgentID, s.agentID).
+		logger.TraceDecision(&m.log, s.agentID, "pending_revision", "found").
+			Str(logger.PolicyID, policyID).
+			Int64("subscription_revision_idx", revisionIdx).
 			Int64(logger.RevisionIdx, (&p.pp.Policy).RevisionIdx).
 			Msg("deploy pending on subscribe")
 		if empty {
 			m.kickDeploy()
 		}
 	default:
-		m.log.Debug().
+		logger.TraceDecision(&m.log, s.agentID, "pending_revision", "not_found").
+			Str(logger.DecisionReason, "revision already acked").
 			Str(logger.PolicyID, policyID).
-			Str(logger.AgentID, s.agentID).
+			Int64("subscription_revision_idx", revisionIdx).
 			Int64(logger.RevisionIdx, (&p.pp.Policy).RevisionIdx).
 			Msg("subscription added without new revision")
 		p.head.pushBack(s)
@@ -496,6 +598,54 @@ func (m *monitorT) Subscribe(agentID string, policyID string, revisionIdx int64)
 	return s, nil
 }
 
+// Limits returns the limits of the latest revision of the policy, false is returned if the policy is not loaded.
+func (m *monitorT) Limits(policyID string) (Limits, bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	p, ok := m.policies[policyID]
+	// the policies subscribed to before they are loaded have an empty parsed policy
+	if !ok || p.pp.Policy.PolicyID == "" {
+		return Limits{}, false
+	}
+	return p.pp.Limits, true
+}
+
+func (m *monitorT) Policy(policyID string) (*ParsedPolicy, bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	p, ok := m.policies[policyID]
+	if !ok || p.pp.Policy.PolicyID == "" {
+		return nil, false
+	}
+	// the copy shares the encoded body of the revision
+	pp := p.pp
+	return &pp, true
+}
+
+// Load adds the latest revision of the policy to the monitor if it is not loaded yet.
+func (m *monitorT) Load(ctx context.Context, policyID string) error {
+	if _, ok := m.Limits(policyID); ok {
+		return nil
+	}
+	p, err := m.revisionF(ctx, m.bulker, policyID, 0, dl.WithIndexName(m.policiesIndex))
+	if err != nil {
+		return err
+	}
+	pp, err := NewParsedPolicy(ctx, m.bulker, p)
+	if err != nil {
+		return err
+	}
+	// the monitor may have loaded a newer revision meanwhile
+	if _, ok := m.Limits(policyID); ok {
+		return nil
+	}
+	// the agents that subscribed before the policy was loaded are queued for delivery
+	if m.updatePolicy(ctx, pp) {
+		m.kickDeploy()
+	}
+	return nil
+}
+
 // Unsubscribe removes the current subscription.
 func (m *monitorT) Unsubscribe(sub Subscription) error {
 	s, ok := sub.(*subT)
@@ -503,9 +653,11 @@ func (m *monitorT) Unsubscribe(sub Subscription) error {
 		return errors.New("not a subscription returned from this monitor")
 	}
 
-	m.mut.Lock()
-	s.unlink()
-	m.mut.Unlock()
+	if s.stop != nil {
+		s.stop()
+	}
+	m.waiters.Unregister(s.w)
+	m.unlink(s)
 
 	m.log.Debug().
 		Str(logger.AgentID, s.agentID).
@@ -515,3 +667,10 @@ func (m *monitorT) Unsubscribe(sub Subscription) error {
 
 	return nil
 }
+
+// unlink removes the subscription from the queue it is in.
+func (m *monitorT) unlink(s *subT) {
+	m.mut.Lock()
+	s.unlink()
+	m.mut.Unlock()
+}
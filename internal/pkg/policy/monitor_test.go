@@ -7,6 +7,7 @@
 package policy
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"sync"
@@ -16,6 +17,7 @@ import (
 	"github.com/gofrs/uuid"
 	"github.com/google/go-cmp/cmp"
 	"github.com/rs/xid"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -25,6 +27,7 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	mmock "github.com/elastic/fleet-server/v7/internal/pkg/monitor/mock"
 	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
@@ -65,6 +68,11 @@ func TestNewMonitor(t *testing.T) {
 			assert.Equal(t, tc.burst, m.limit.Burst())
 			assert.Equal(t, tc.rate, float64(m.limit.Limit()))
 
+			// the same limit is applied by a reload of a monitor created with another one
+			m = NewMonitor(nil, nil, config.ServerLimits{PolicyLimit: config.Limit{Burst: 5, Interval: time.Millisecond}}).(*monitorT)
+			m.Reload(&config.Server{Limits: tc.cfg})
+			assert.Equal(t, tc.burst, m.limit.Burst())
+			assert.Equal(t, tc.rate, float64(m.limit.Limit()))
 		})
 	}
 }
@@ -102,7 +110,7 @@ func TestMonitor_NewPolicy(t *testing.T) {
 
 	agentId := uuid.Must(uuid.NewV4()).String()
 	policyID := uuid.Must(uuid.NewV4()).String()
-	s, err := monitor.Subscribe(agentId, policyID, 0)
+	s, err := monitor.Subscribe(ctx, agentId, policyID, 0)
 	defer monitor.Unsubscribe(s)
 	require.NoError(t, err)
 
@@ -181,7 +189,7 @@ func TestMonitor_SamePolicy(t *testing.T) {
 
 	agentId := uuid.Must(uuid.NewV4()).String()
 	policyId := uuid.Must(uuid.NewV4()).String()
-	s, err := monitor.Subscribe(agentId, policyId, 1)
+	s, err := monitor.Subscribe(ctx, agentId, policyId, 1)
 	defer monitor.Unsubscribe(s)
 	require.NoError(t, err)
 
@@ -292,7 +300,7 @@ func runTestMonitor_NewPolicyExists(t *testing.T, delay time.Duration) {
 	err := monitor.(*monitorT).waitStart(ctx)
 	require.NoError(t, err)
 
-	s, err := monitor.Subscribe(agentId, policyId, 1)
+	s, err := monitor.Subscribe(ctx, agentId, policyId, 1)
 	defer monitor.Unsubscribe(s)
 	require.NoError(t, err)
 
@@ -348,13 +356,13 @@ func Test_Monitor_Limit_Delay(t *testing.T) {
 
 	agentId := uuid.Must(uuid.NewV4()).String()
 	policyID := uuid.Must(uuid.NewV4()).String()
-	s, err := monitor.Subscribe(agentId, policyID, 0)
+	s, err := monitor.Subscribe(ctx, agentId, policyID, 0)
 	defer monitor.Unsubscribe(s)
 	require.NoError(t, err)
 
 	agentId = uuid.Must(uuid.NewV4()).String()
 	policyID2 := uuid.Must(uuid.NewV4()).String()
-	s2, err := monitor.Subscribe(agentId, policyID, 0)
+	s2, err := monitor.Subscribe(ctx, agentId, policyID, 0)
 	defer monitor.Unsubscribe(s2)
 	require.NoError(t, err)
 
@@ -442,3 +450,197 @@ LOOP:
 	ms.AssertExpectations(t)
 	mm.AssertExpectations(t)
 }
+
+func TestMonitor_TraceDecisions(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	t.Cleanup(func() { logger.SetTraceAgents(nil) })
+	logger.SetTraceAgents([]string{"agent-1", "agent-2"})
+
+	var b bytes.Buffer
+	pm := NewMonitor(ftesting.NewMockBulk(), nil, config.ServerLimits{}).(*monitorT)
+	pm.log = zerolog.New(&b)
+	pm.policies["policy-1"] = policyT{
+		pp:   ParsedPolicy{Policy: model.Policy{PolicyID: "policy-1", RevisionIdx: 2}},
+		head: makeHead(),
+	}
+
+	for agentID, revIdx := range map[string]int64{"agent-1": 1, "agent-2": 2, "agent-3": 1} {
+		s, err := pm.Subscribe(context.Background(), agentID, "policy-1", revIdx)
+		require.NoError(t, err)
+		defer pm.Unsubscribe(s) //nolint:errcheck // test
+	}
+	pm.dispatchPending(context.Background())
+
+	type decision struct{ agent, name, result string }
+	var found []decision
+	dec := json.NewDecoder(&b)
+	for dec.More() {
+		var m map[string]interface{}
+		require.NoError(t, dec.Decode(&m))
+		if _, ok := m[logger.Decision]; !ok {
+			continue
+		}
+		assert.Equal(t, "debug", m[zerolog.LevelFieldName])
+		found = append(found, decision{m[logger.AgentID].(string), m[logger.Decision].(string), m[logger.DecisionResult].(string)})
+	}
+	assert.ElementsMatch(t, []decision{
+		{"agent-1", "pending_revision", "found"},
+		{"agent-2", "pending_revision", "not_found"},
+		{"agent-1", "throttle", "allowed"},
+	}, found, "decisions about agent-3 are not traced at info level")
+}
+
+func TestMonitor_SubscribeWaiters(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	pm := NewMonitor(ftesting.NewMockBulk(), nil, config.ServerLimits{}).(*monitorT)
+	pm.policies["policy-1"] = policyT{
+		pp:   ParsedPolicy{Policy: model.Policy{PolicyID: "policy-1", RevisionIdx: 1}},
+		head: makeHead(),
+	}
+
+	// a newer checkin of the agent preempts the older one
+	older, err := pm.Subscribe(ctx, "agent-1", "policy-1", 1)
+	require.NoError(t, err)
+	newer, err := pm.Subscribe(ctx, "agent-1", "policy-1", 1)
+	require.NoError(t, err)
+	require.True(t, older.(*subT).preempted())
+	require.Equal(t, 1, pm.waiters.Len())
+
+	// the subscription of a canceled checkin is removed
+	cctx, cancel := context.WithCancel(ctx)
+	canceled, err := pm.Subscribe(cctx, "agent-2", "policy-1", 1)
+	require.NoError(t, err)
+	cancel()
+	require.Eventually(t, func() bool {
+		pm.mut.Lock()
+		defer pm.mut.Unlock()
+		return canceled.(*subT).next == nil && pm.waiters.Len() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	pp := ParsedPolicy{Policy: model.Policy{PolicyID: "policy-1", RevisionIdx: 2}}
+	require.True(t, pm.updatePolicy(ctx, &pp))
+	pm.dispatchPending(ctx)
+	select {
+	case p := <-newer.Output():
+		require.Equal(t, int64(2), p.Policy.RevisionIdx)
+	default:
+		t.Fatal("the newer checkin did not get the policy change")
+	}
+	require.Empty(t, older.Output(), "the preempted checkin is not notified")
+	require.True(t, pm.policies["policy-1"].head.isEmpty())
+	require.True(t, pm.pendingQ.isEmpty())
+
+	require.NoError(t, pm.Unsubscribe(newer))
+	require.NoError(t, pm.Unsubscribe(older))
+	require.Zero(t, pm.waiters.Len())
+
+	_, err = pm.Subscribe(cctx, "agent-3", "policy-1", 1)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMonitor_Load(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	pm := NewMonitor(ftesting.NewMockBulk(), nil, config.ServerLimits{}).(*monitorT)
+	fetches := 0
+	pm.revisionF = func(_ context.Context, _ bulk.Bulk, policyID string, revisionIdx int64, _ ...dl.Option) (model.Policy, error) {
+		fetches++
+		require.Zero(t, revisionIdx, "the latest revision is loaded")
+		if policyID != "policy-1" {
+			return model.Policy{}, dl.ErrNotFound
+		}
+		return model.Policy{PolicyID: policyID, RevisionIdx: 3, Data: policyDataDefault}, nil
+	}
+
+	require.ErrorIs(t, pm.Load(ctx, "missing"), dl.ErrNotFound)
+	_, ok := pm.Limits("missing")
+	require.False(t, ok)
+
+	// an agent reassigned to the policy subscribed before it was loaded
+	s, err := pm.Subscribe(ctx, "agent-1", "policy-1", 0)
+	require.NoError(t, err)
+	defer pm.Unsubscribe(s) //nolint:errcheck // test
+	require.True(t, pm.pendingQ.isEmpty())
+
+	require.NoError(t, pm.Load(ctx, "policy-1"))
+	_, ok = pm.Limits("policy-1")
+	require.True(t, ok)
+	require.False(t, pm.pendingQ.isEmpty(), "the subscription is queued for delivery")
+	require.Equal(t, 2, fetches)
+
+	// a loaded policy is not fetched again
+	require.NoError(t, pm.Load(ctx, "policy-1"))
+	require.Equal(t, 2, fetches)
+}
+
+func TestMonitor_Resync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	chHitT := make(chan []es.HitT, 1)
+	defer close(chHitT)
+	chResync := make(chan struct{}, 1)
+	ms := mmock.NewMockSubscription()
+	ms.On("Output").Return((<-chan []es.HitT)(chHitT))
+	ms.SetResync(chResync)
+	mm := mmock.NewMockMonitor()
+	mm.On("Subscribe").Return(ms).Once()
+	mm.On("Unsubscribe", mock.Anything).Return().Once()
+	bulker := ftesting.NewMockBulk()
+
+	f := &countingFetcher{revisions: []int64{1}}
+	r := newTestReader(time.Minute, f)
+	monitor := NewMonitor(bulker, mm, config.ServerLimits{}, WithReader(r))
+	pm := monitor.(*monitorT)
+	var mx sync.Mutex
+	revisionIdx := int64(1)
+	pm.policyF = func(ctx context.Context, bulker bulk.Bulk, opt ...dl.Option) ([]model.Policy, error) {
+		mx.Lock()
+		defer mx.Unlock()
+		return []model.Policy{{PolicyID: "policy-1", RevisionIdx: revisionIdx, Data: policyDataDefault}}, nil
+	}
+
+	var merr error
+	var mwg sync.WaitGroup
+	mwg.Add(1)
+	go func() {
+		defer mwg.Done()
+		merr = monitor.Run(ctx)
+	}()
+	require.NoError(t, pm.waitStart(ctx))
+
+	s, err := monitor.Subscribe(ctx, "agent-1", "policy-1", 1)
+	require.NoError(t, err)
+	defer monitor.Unsubscribe(s) //nolint:errcheck // test
+	require.Eventually(t, func() bool {
+		_, ok := monitor.Limits("policy-1")
+		return ok
+	}, 2*time.Second, 10*time.Millisecond)
+	_, err = r.Get(ctx, "policy-1", 0)
+	require.NoError(t, err)
+
+	// the restored index holds a revision the monitor did not receive as a hit
+	mx.Lock()
+	revisionIdx = 3
+	mx.Unlock()
+	f.addRevision(3)
+	chResync <- struct{}{}
+
+	select {
+	case pp := <-s.Output():
+		require.Equal(t, int64(3), pp.Policy.RevisionIdx)
+	case <-time.After(2 * time.Second):
+		t.Fatal("never got the policy of the restored index")
+	}
+	pp, err := r.Get(ctx, "policy-1", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), pp.Policy.RevisionIdx, "the cached revisions are invalidated")
+
+	cancel()
+	mwg.Wait()
+	if merr != nil && merr != context.Canceled {
+		t.Fatal(merr)
+	}
+	mm.AssertExpectations(t)
+}
@@ -0,0 +1,181 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/smap"
+)
+
+// ErrIndexNotAllowed is returned for an output permission section granting an index pattern out of allowedIndexPatterns.
+var ErrIndexNotAllowed = errors.New("index pattern not allowed for output api keys")
+
+// ErrPrivilegeNotAllowed is returned for an output permission section granting a privilege the output API keys may
+// not be granted.
+var ErrPrivilegeNotAllowed = errors.New("privilege not allowed for output api keys")
+
+// allowedIndexPatterns are the index patterns the output API keys may be granted, the patterns of the output
+// permission sections must be covered by one of them.
+var allowedIndexPatterns = []string{"logs-*", "metrics-*", "traces-*", ".logs-endpoint*"}
+
+var (
+	// allowedClusterPrivileges are the cluster privileges the output API keys may be granted.
+	allowedClusterPrivileges = map[string]bool{"monitor": true}
+	// allowedIndexPrivileges are the index privileges the output API keys may be granted, they only add documents.
+	allowedIndexPrivileges = map[string]bool{"auto_configure": true, "create_doc": true, "create": true, "index": true}
+	// allowedDescriptorFields are the fields of the role descriptors of the output permission sections, the other
+	// fields, such as run_as or applications, grant more than writing the data of the agents.
+	allowedDescriptorFields = map[string]bool{"cluster": true, "indices": true, "remote_indices": true, "metadata": true, "description": true}
+)
+
+// legacyOutputRoleJSON is the role of the output API keys of the policies without a valid output permission section,
+// as granted before the sections were written by Kibana.
+const legacyOutputRoleJSON = `{
+	"fleet-output": {
+		"cluster": ["monitor"],
+		"indices": [{
+			"names": ["logs-*", "metrics-*", "traces-*", ".logs-endpoint.diagnostic.collection-*", ".logs-endpoint.action.responses-*"],
+			"privileges": ["auto_configure", "create_doc"]
+		}]
+	}
+}`
+
+var legacyOutputRole = mustRole(legacyOutputRoleJSON)
+
+func mustRole(raw string) *RoleT {
+	descriptors, err := smap.Parse([]byte(raw))
+	if err != nil {
+		panic(err)
+	}
+	r := &RoleT{}
+	if r.Sha2, err = descriptors.Hash(); err != nil {
+		panic(err)
+	}
+	if r.Raw, err = json.Marshal(descriptors); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// outputRole returns the role descriptors of the output API key of an agent, the output permission section of the
+// output. The legacy output role is used, with a warning, when the policy has no section for the output or when the
+// section is not valid.
+func outputRole(zlog zerolog.Logger, role *RoleT) *RoleT {
+	if role == nil {
+		zlog.Warn().Err(ErrNoOutputPerms).Msg("policy does not contain an output permission section, using the legacy output role")
+		return legacyOutputRole
+	}
+	if err := validateRoleDescriptors(role.Raw); err != nil {
+		zlog.Warn().Err(err).Msg("invalid output permission section, using the legacy output role")
+		return legacyOutputRole
+	}
+	return role
+}
+
+// validateRoleDescriptors checks that the role descriptors of an output permission section only grant the cluster
+// and index privileges allowed for the output API keys, on the index patterns covered by allowedIndexPatterns.
+func validateRoleDescriptors(raw []byte) error {
+	descriptors, err := smap.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPermissionsFormat, err)
+	}
+	if len(descriptors) == 0 {
+		return fmt.Errorf("%w: no role descriptors", ErrInvalidPermissionsFormat)
+	}
+	for name := range descriptors {
+		descriptor := descriptors.GetMap(name)
+		if descriptor == nil {
+			return fmt.Errorf("%w: role descriptor %s is not an object", ErrInvalidPermissionsFormat, name)
+		}
+		for field := range descriptor {
+			if !allowedDescriptorFields[field] {
+				return fmt.Errorf("%w: %s in role descriptor %s", ErrPrivilegeNotAllowed, field, name)
+			}
+		}
+		if err := validatePrivileges(name, "cluster", descriptor["cluster"], allowedClusterPrivileges); err != nil {
+			return err
+		}
+		for _, field := range []string{"indices", "remote_indices"} {
+			if err := validateIndices(name, descriptor[field]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateIndices(name string, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	indices, ok := v.([]interface{})
+	if !ok {
+		return fmt.Errorf("%w: indices of role descriptor %s is not a list", ErrInvalidPermissionsFormat, name)
+	}
+	for _, index := range indices {
+		index, ok := index.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%w: index of role descriptor %s is not an object", ErrInvalidPermissionsFormat, name)
+		}
+		if err := validatePrivileges(name, "index", index["privileges"], allowedIndexPrivileges); err != nil {
+			return err
+		}
+		patterns, ok := index["names"].([]interface{})
+		if !ok {
+			return fmt.Errorf("%w: index names of role descriptor %s is not a list", ErrInvalidPermissionsFormat, name)
+		}
+		for _, pattern := range patterns {
+			pattern, ok := pattern.(string)
+			if !ok {
+				return fmt.Errorf("%w: index name of role descriptor %s is not a string", ErrInvalidPermissionsFormat, name)
+			}
+			if !indexPatternAllowed(pattern) {
+				return fmt.Errorf("%w: %q in role descriptor %s", ErrIndexNotAllowed, pattern, name)
+			}
+		}
+	}
+	return nil
+}
+
+// validatePrivileges checks that the privileges v of kind, cluster or index, are in allowed.
+func validatePrivileges(name, kind string, v interface{}, allowed map[string]bool) error {
+	if v == nil {
+		return nil
+	}
+	privileges, ok := v.([]interface{})
+	if !ok {
+		return fmt.Errorf("%w: %s privileges of role descriptor %s is not a list", ErrInvalidPermissionsFormat, kind, name)
+	}
+	for _, privilege := range privileges {
+		privilege, ok := privilege.(string)
+		if !ok {
+			return fmt.Errorf("%w: %s privilege of role descriptor %s is not a string", ErrInvalidPermissionsFormat, kind, name)
+		}
+		if !allowed[privilege] {
+			return fmt.Errorf("%w: %s privilege %q in role descriptor %s", ErrPrivilegeNotAllowed, kind, privilege, name)
+		}
+	}
+	return nil
+}
+
+// indexPatternAllowed returns true if the pattern is covered by one of allowedIndexPatterns.
+// A comma separated list of patterns is not allowed, as only its first pattern would be checked.
+func indexPatternAllowed(pattern string) bool {
+	if strings.Contains(pattern, ",") {
+		return false
+	}
+	for _, allowed := range allowedIndexPatterns {
+		if strings.HasPrefix(pattern, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
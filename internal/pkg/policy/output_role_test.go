@@ -0,0 +1,99 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package policy
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+// TestOutputRole compares the role descriptors of the output API keys built from the policies in
+// testdata/output-permissions with their golden files.
+func TestOutputRole(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		legacy bool
+	}{
+		{name: "kibana-default", output: "default"},
+		{name: "endpoint", output: "default"},
+		{name: "non-default-namespace", output: "default"},
+		{name: "multiple-outputs", output: "monitoring"},
+		{name: "no-output-permissions", output: "default", legacy: true},
+		{name: "index-not-allowed", output: "default", legacy: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := os.ReadFile(filepath.Join("testdata", "output-permissions", tc.name+".policy.json"))
+			require.NoError(t, err)
+			var data model.PolicyData
+			require.NoError(t, json.Unmarshal(p, &data))
+			roles, err := parsePerms(data.OutputPermissions)
+			require.NoError(t, err)
+			outputs, err := constructPolicyOutputs(data.Outputs, roles)
+			require.NoError(t, err)
+
+			role := outputRole(testlog.SetLogger(t), outputs[tc.output].Role)
+
+			golden, err := os.ReadFile(filepath.Join("testdata", "output-permissions", tc.name+".golden.json"))
+			require.NoError(t, err)
+			assert.JSONEq(t, string(golden), string(role.Raw))
+			if tc.legacy {
+				assert.Equal(t, legacyOutputRole.Sha2, role.Sha2)
+			} else {
+				assert.Equal(t, outputs[tc.output].Role, role, "the section is used as it is")
+			}
+		})
+	}
+}
+
+func TestValidateRoleDescriptors(t *testing.T) {
+	tests := map[string]struct {
+		raw string
+		err error
+	}{
+		"allowed":           {raw: `{"a":{"cluster":["monitor"],"indices":[{"names":["logs-*","metrics-system.cpu-default","traces-apm-*",".logs-endpoint.action.responses-*"]}]}}`},
+		"cluster only":      {raw: `{"_elastic_agent_checks":{"cluster":["monitor"]}}`},
+		"not allowed":       {raw: `{"a":{"indices":[{"names":["logs-*","synthetics-*"]}]}}`, err: ErrIndexNotAllowed},
+		"all indices":       {raw: `{"a":{"indices":[{"names":["*"]}]}}`, err: ErrIndexNotAllowed},
+		"list of patterns":  {raw: `{"a":{"indices":[{"names":["logs-*,.security"]}]}}`, err: ErrIndexNotAllowed},
+		"remote indices":    {raw: `{"a":{"remote_indices":[{"clusters":["*"],"names":[".fleet-*"]}]}}`, err: ErrIndexNotAllowed},
+		"index privileges":  {raw: `{"a":{"indices":[{"names":["logs-*"],"privileges":["auto_configure","create_doc","create","index"]}]}}`},
+		"read":              {raw: `{"a":{"indices":[{"names":["logs-*"],"privileges":["create_doc","read"]}]}}`, err: ErrPrivilegeNotAllowed},
+		"all index":         {raw: `{"a":{"indices":[{"names":["logs-*"],"privileges":["all"]}]}}`, err: ErrPrivilegeNotAllowed},
+		"remote privileges": {raw: `{"a":{"remote_indices":[{"clusters":["*"],"names":["logs-*"],"privileges":["delete"]}]}}`, err: ErrPrivilegeNotAllowed},
+		"manage cluster":    {raw: `{"a":{"cluster":["monitor","manage_security"]}}`, err: ErrPrivilegeNotAllowed},
+		"all cluster":       {raw: `{"a":{"cluster":["all"]}}`, err: ErrPrivilegeNotAllowed},
+		"run as":            {raw: `{"a":{"cluster":["monitor"],"run_as":["elastic"]}}`, err: ErrPrivilegeNotAllowed},
+		"applications":      {raw: `{"a":{"applications":[{"application":"kibana-.kibana","privileges":["all"],"resources":["*"]}]}}`, err: ErrPrivilegeNotAllowed},
+		"global":            {raw: `{"a":{"global":{"application":{"manage":{"applications":["*"]}}}}}`, err: ErrPrivilegeNotAllowed},
+		"empty":             {raw: `{}`, err: ErrInvalidPermissionsFormat},
+		"not a descriptor":  {raw: `{"a":"logs-*"}`, err: ErrInvalidPermissionsFormat},
+		"names not a list":  {raw: `{"a":{"indices":[{"names":"logs-*"}]}}`, err: ErrInvalidPermissionsFormat},
+		"name not a string": {raw: `{"a":{"indices":[{"names":[1]}]}}`, err: ErrInvalidPermissionsFormat},
+		"privileges string": {raw: `{"a":{"cluster":"monitor"}}`, err: ErrInvalidPermissionsFormat},
+		"not json":          {raw: `{`, err: ErrInvalidPermissionsFormat},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateRoleDescriptors([]byte(tc.raw))
+			if tc.err == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
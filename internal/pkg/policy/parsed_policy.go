@@ -9,10 +9,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 
+	"github.com/rs/zerolog"
 	"go.elastic.co/apm/v2"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/smap"
 )
@@ -52,6 +55,31 @@ type ParsedPolicy struct {
 	Inputs     []map[string]interface{}
 	SecretKeys []string
 	Links      apm.SpanLink
+	// Limits are the quotas set by the policy document, the server-wide defaults apply to the ones it does not set.
+	Limits Limits
+
+	// body is shared by the copies of the parsed policy, it is nil if the policy was not created by NewParsedPolicy.
+	body *bodyCache
+}
+
+// bodyCache holds the encoding of the policy revision that is delivered to every agent.
+type bodyCache struct {
+	once sync.Once
+	body []byte
+	err  error
+}
+
+// Body returns the encoding of the policy returned by marshal.
+// marshal is called once for the revision and its result is reused by the next calls, so it must not depend on the agent.
+// The returned bytes must not be modified.
+func (pp *ParsedPolicy) Body(marshal func(*ParsedPolicy) ([]byte, error)) ([]byte, error) {
+	if pp.body == nil {
+		return marshal(pp)
+	}
+	pp.body.once.Do(func() {
+		pp.body.body, pp.body.err = marshal(pp)
+	})
+	return pp.body.body, pp.body.err
 }
 
 func NewParsedPolicy(ctx context.Context, bulker bulk.Bulk, p model.Policy) (*ParsedPolicy, error) {
@@ -96,6 +124,8 @@ func NewParsedPolicy(ctx context.Context, bulker bulk.Bulk, p model.Policy) (*Pa
 		},
 		Inputs:     policyInputs,
 		SecretKeys: secretKeys,
+		Limits:     parseLimits(zerolog.Ctx(ctx).With().Str(logger.PolicyID, p.PolicyID).Logger(), p.Limits),
+		body:       &bodyCache{},
 	}
 	if trace := apm.TransactionFromContext(ctx); trace != nil {
 		// Pass current transaction link (should be a monitor transaction) to caller (likely a client request).
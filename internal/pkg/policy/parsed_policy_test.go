@@ -89,3 +89,35 @@ func TestNewParsedPolicyRemoteES(t *testing.T) {
 	// Validate that default was found
 	require.Equal(t, "remote", pp.Default.Name)
 }
+
+func TestParsedPolicyBody(t *testing.T) {
+	var d model.PolicyData
+	err := json.Unmarshal([]byte(logstashOutputPolicy), &d)
+	require.NoError(t, err)
+
+	pp, err := NewParsedPolicy(context.TODO(), nil, model.Policy{Data: &d})
+	require.NoError(t, err)
+
+	calls := 0
+	marshal := func(pp *ParsedPolicy) ([]byte, error) {
+		calls++
+		return json.Marshal(pp.Inputs)
+	}
+	body, err := pp.Body(marshal)
+	require.NoError(t, err)
+
+	// the copies of the parsed policy share the body
+	cp := *pp
+	cpBody, err := cp.Body(marshal)
+	require.NoError(t, err)
+	require.Equal(t, body, cpBody)
+	require.Equal(t, 1, calls)
+
+	// a parsed policy that was not created by NewParsedPolicy does not cache the body
+	other := ParsedPolicy{Inputs: pp.Inputs}
+	_, err = other.Body(marshal)
+	require.NoError(t, err)
+	_, err = other.Body(marshal)
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+}
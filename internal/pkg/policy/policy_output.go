@@ -10,7 +10,6 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/rs/zerolog"
 	"go.elastic.co/apm/v2"
@@ -19,6 +18,7 @@ import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
 	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/smap"
@@ -63,7 +63,8 @@ func (p *Output) Prepare(ctx context.Context, zlog zerolog.Logger, bulker bulk.B
 		zlog.Debug().Msg("preparing remote elasticsearch output")
 		newBulker, hasConfigChanged, err := bulker.CreateAndGetBulker(ctx, zlog, p.Name, outputMap)
 		if err != nil {
-			return err
+			return p.prepareDegradedRemote(ctx, zlog, bulker, agent, outputMap, err,
+				fmt.Sprintf("remote ES client could not be created due to error: %v", err))
 		}
 		// the outputBulker is different for remote ES, it is used to create/update Api keys in the remote ES client
 		if err := p.prepareElasticsearch(ctx, zlog, bulker, newBulker, agent, outputMap, hasConfigChanged); err != nil {
@@ -90,12 +91,9 @@ func (p *Output) prepareElasticsearch(
 	agent *model.Agent,
 	outputMap map[string]map[string]interface{},
 	hasConfigChanged bool) error {
-	// The role is required to do api key management
-	if p.Role == nil {
-		zlog.Error().
-			Msg("policy does not contain required output permission section")
-		return ErrNoOutputPerms
-	}
+	// the output permission section of the policy already grants the data stream namespaces of its integrations,
+	// the agent namespaces are Kibana spaces and do not restrict it
+	role := outputRole(zlog, p.Role)
 	if _, ok := outputMap[p.Name]; !ok {
 		zlog.Error().Err(ErrFailInjectAPIKey).Msg("Unable to find output in map")
 		return ErrFailInjectAPIKey
@@ -125,13 +123,17 @@ func (p *Output) prepareElasticsearch(
 			}
 		}
 		if !found {
+			removedOutputName = agentOutputName
+			// a remote output that never got an API key has none to retire
+			if agentOutput.APIKeyID == "" {
+				break
+			}
 			zlog.Info().Str(logger.APIKeyID, agentOutput.APIKeyID).Str(logger.PolicyOutputName, agentOutputName).Msg("Output removed, will retire API key")
 			toRetireAPIKeys = &model.ToRetireAPIKeyIdsItems{
 				ID:        agentOutput.APIKeyID,
-				RetiredAt: time.Now().UTC().Format(time.RFC3339),
+				RetiredAt: ftime.Now(),
 				Output:    agentOutputName,
 			}
-			removedOutputName = agentOutputName
 			break
 		}
 	}
@@ -153,9 +155,11 @@ func (p *Output) prepareElasticsearch(
 			zlog.Error().Err(err).Msg("fail update agent record")
 			return fmt.Errorf("fail update agent record: %w", err)
 		}
+	}
 
+	if removedOutputName != "" {
 		// remove output from agent doc
-		body, err = json.Marshal(map[string]interface{}{
+		body, err := json.Marshal(map[string]interface{}{
 			"script": map[string]interface{}{
 				"lang":   "painless",
 				"source": fmt.Sprintf("ctx._source['outputs'].remove(\"%s\")", removedOutputName),
@@ -181,31 +185,35 @@ func (p *Output) prepareElasticsearch(
 	needUpdateKey := false
 	switch {
 	case output.APIKey == "":
-		zlog.Debug().Msg("must generate api key as default API key is not present")
+		logger.TraceDecision(&zlog, agent.Id, "output_api_key", "mint").Str(logger.PolicyOutputName, p.Name).Msg("must generate api key as default API key is not present")
 		needNewKey = true
 	case hasConfigChanged:
-		zlog.Debug().Msg("must generate api key as remote output config changed")
+		logger.TraceDecision(&zlog, agent.Id, "output_api_key", "mint").Str(logger.PolicyOutputName, p.Name).Msg("must generate api key as remote output config changed")
 		needNewKey = true
-	case p.Role.Sha2 != output.PermissionsHash:
+	case role.Sha2 != output.PermissionsHash:
 		// the is actually the OutputPermissionsHash for the default hash. The Agent
 		// document on ES does not have OutputPermissionsHash for any other output
 		// besides the default one. It seems to me error-prone to rely on the default
 		// output permissions hash to generate new API keys for other outputs.
-		zlog.Debug().Msg("must update api key as policy output permissions changed")
+		logger.TraceDecision(&zlog, agent.Id, "output_api_key", "update").Str(logger.PolicyOutputName, p.Name).Msg("must update api key as policy output permissions changed")
 		needUpdateKey = true
 	default:
-		zlog.Debug().Msg("policy output permissions are the same")
+		logger.TraceDecision(&zlog, agent.Id, "output_api_key", "reuse").Str(logger.PolicyOutputName, p.Name).Msg("policy output permissions are the same")
 	}
 
 	if needUpdateKey {
 		zlog.Debug().
-			RawJSON("roles", p.Role.Raw).
+			RawJSON("roles", role.Raw).
 			Str("oldHash", output.PermissionsHash).
-			Str("newHash", p.Role.Sha2).
+			Str("newHash", role.Sha2).
 			Msg("Generating a new API key")
 
 		// query current api key for roles so we don't lose permissions in the meantime
 		currentRoles, err := fetchAPIKeyRoles(ctx, outputBulker, output.APIKeyID)
+		if err != nil && p.Type == OutputTypeRemoteElasticsearch {
+			return p.prepareDegradedRemote(ctx, zlog, bulker, agent, outputMap, err,
+				fmt.Sprintf("remote ES could not read API key due to error: %v", err))
+		}
 		if err != nil {
 			zlog.Error().
 				Str("apiKeyID", output.APIKeyID).
@@ -213,8 +221,8 @@ func (p *Output) prepareElasticsearch(
 			return err
 		}
 
-		// merge roles with p.Role
-		newRoles, err := mergeRoles(zlog, currentRoles, p.Role)
+		// merge roles with role
+		newRoles, err := mergeRoles(zlog, currentRoles, role)
 		if err != nil {
 			zlog.Error().
 				Str("apiKeyID", output.APIKeyID).
@@ -224,20 +232,24 @@ func (p *Output) prepareElasticsearch(
 
 		// hash provided is only for merging request together and not persisted
 		err = outputBulker.APIKeyUpdate(ctx, output.APIKeyID, newRoles.Sha2, newRoles.Raw)
+		if err != nil && p.Type == OutputTypeRemoteElasticsearch {
+			return p.prepareDegradedRemote(ctx, zlog, bulker, agent, outputMap, err,
+				fmt.Sprintf("remote ES could not update API key due to error: %v", err))
+		}
 		if err != nil {
 			zlog.Error().Err(err).Msg("fail generate output key")
 			zlog.Debug().RawJSON("roles", newRoles.Raw).Str("sha", newRoles.Sha2).Err(err).Msg("roles not updated")
 			return err
 		}
 
-		output.PermissionsHash = p.Role.Sha2 // for the sake of consistency
+		output.PermissionsHash = role.Sha2 // for the sake of consistency
 		zlog.Debug().
-			Str("hash.sha256", p.Role.Sha2).
-			Str("roles", string(p.Role.Raw)).
+			Str("hash.sha256", role.Sha2).
+			Str("roles", string(role.Raw)).
 			Msg("Updating agent record to pick up most recent roles.")
 
 		fields := map[string]interface{}{
-			dl.FieldPolicyOutputPermissionsHash: p.Role.Sha2,
+			dl.FieldPolicyOutputPermissionsHash: role.Sha2,
 		}
 
 		// Using painless script to update permission hash for updated key
@@ -253,44 +265,21 @@ func (p *Output) prepareElasticsearch(
 
 	} else if needNewKey {
 		zlog.Debug().
-			RawJSON("fleet.policy.roles", p.Role.Raw).
+			RawJSON("fleet.policy.roles", role.Raw).
 			Str("fleet.policy.default.oldHash", output.PermissionsHash).
-			Str("fleet.policy.default.newHash", p.Role.Sha2).
+			Str("fleet.policy.default.newHash", role.Sha2).
 			Msg("Generating a new API key")
 
 		ctx := zlog.WithContext(ctx)
 		outputAPIKey, err :=
-			generateOutputAPIKey(ctx, outputBulker, agent.Id, p.Name, p.Role.Raw)
+			generateOutputAPIKey(ctx, outputBulker, agent.Id, p.Name, role.Raw)
 
 		// reporting output health and not returning the error to keep fleet-server running
-		if outputAPIKey == nil && p.Type == OutputTypeRemoteElasticsearch {
-			if err != nil {
-				doc := model.OutputHealth{
-					Output:  p.Name,
-					State:   client.UnitStateDegraded.String(),
-					Message: fmt.Sprintf("remote ES could not create API key due to error: %v", err),
-				}
-				zerolog.Ctx(ctx).Warn().Err(err).Str(logger.PolicyOutputName, p.Name).Msg(doc.Message)
-
-				if err := dl.CreateOutputHealth(ctx, bulker, doc); err != nil {
-					zlog.Error().Err(err).Str(logger.PolicyOutputName, p.Name).Msg("error writing output health")
-				}
-			}
-
-			// replace type remote_elasticsearch with elasticsearch as agent doesn't recognize remote_elasticsearch
-			outputMap[p.Name][FieldOutputType] = OutputTypeElasticsearch
-			// remove the service token from the agent policy sent to the agent
-			delete(outputMap[p.Name], FieldOutputServiceToken)
-			return nil
+		if err != nil && p.Type == OutputTypeRemoteElasticsearch {
+			return p.prepareDegradedRemote(ctx, zlog, bulker, agent, outputMap, err,
+				fmt.Sprintf("remote ES could not create API key due to error: %v", err))
 		} else if p.Type == OutputTypeRemoteElasticsearch {
-			doc := model.OutputHealth{
-				Output:  p.Name,
-				State:   client.UnitStateHealthy.String(),
-				Message: "",
-			}
-			if err := dl.CreateOutputHealth(ctx, bulker, doc); err != nil {
-				zlog.Error().Err(err).Msg("create output health")
-			}
+			reportRemoteOutputHealth(ctx, zlog, bulker, p.Name, client.UnitStateHealthy, "")
 		}
 		if err != nil {
 			return fmt.Errorf("failed generate output API key: %w", err)
@@ -300,23 +289,23 @@ func (p *Output) prepareElasticsearch(
 		// this will need to be updated when multiples remote Elasticsearch output
 		// are supported.
 		zlog.Info().
-			Str("fleet.policy.role.hash.sha256", p.Role.Sha2).
+			Str("fleet.policy.role.hash.sha256", role.Sha2).
 			Str(logger.DefaultOutputAPIKeyID, outputAPIKey.ID).
 			Msg("Updating agent record to pick up default output key.")
 
 		fields := map[string]interface{}{
 			dl.FieldPolicyOutputAPIKey:          outputAPIKey.Agent(),
 			dl.FieldPolicyOutputAPIKeyID:        outputAPIKey.ID,
-			dl.FieldPolicyOutputPermissionsHash: p.Role.Sha2,
+			dl.FieldPolicyOutputPermissionsHash: role.Sha2,
 		}
 
 		if !foundOutput {
-			fields[dl.FiledType] = OutputTypeElasticsearch
+			fields[dl.FieldType] = OutputTypeElasticsearch
 		}
 		if output.APIKeyID != "" {
 			fields[dl.FieldPolicyOutputToRetireAPIKeyIDs] = model.ToRetireAPIKeyIdsItems{
 				ID:        output.APIKeyID,
-				RetiredAt: time.Now().UTC().Format(time.RFC3339),
+				RetiredAt: ftime.Now(),
 				Output:    p.Name,
 			}
 		}
@@ -339,15 +328,11 @@ func (p *Output) prepareElasticsearch(
 		output.Type = OutputTypeElasticsearch
 		output.APIKey = outputAPIKey.Agent()
 		output.APIKeyID = outputAPIKey.ID
-		output.PermissionsHash = p.Role.Sha2 // for the sake of consistency
+		output.PermissionsHash = role.Sha2 // for the sake of consistency
 	}
 
 	if p.Type == OutputTypeRemoteElasticsearch {
-
-		// replace type remote_elasticsearch with elasticsearch as agent doesn't recognize remote_elasticsearch
-		outputMap[p.Name][FieldOutputType] = OutputTypeElasticsearch
-		// remove the service token from the agent policy sent to the agent
-		delete(outputMap[p.Name], FieldOutputServiceToken)
+		prepareRemoteOutputMap(outputMap[p.Name])
 	}
 
 	// Always insert the `api_key` as part of the output block, this is required
@@ -363,6 +348,75 @@ func (p *Output) prepareElasticsearch(
 	return nil
 }
 
+// prepareDegradedRemote prepares a remote elasticsearch output after its cluster failed an API key operation.
+// The output is reported as degraded and sent with the current API key of the agent, if any, rather than failing
+// the delivery of the whole policy. An output without API key is stored in the agent record, so the policy is
+// sent again on a later checkin to retry.
+func (p *Output) prepareDegradedRemote(
+	ctx context.Context,
+	zlog zerolog.Logger,
+	bulker bulk.Bulk,
+	agent *model.Agent,
+	outputMap map[string]map[string]interface{},
+	err error,
+	msg string) error {
+	if _, ok := outputMap[p.Name]; !ok {
+		zlog.Error().Err(ErrFailInjectAPIKey).Msg("Unable to find output in map")
+		return ErrFailInjectAPIKey
+	}
+	zlog.Warn().Err(err).Msg(msg)
+	reportRemoteOutputHealth(ctx, zlog, bulker, p.Name, client.UnitStateDegraded, msg)
+
+	if agent.Outputs == nil {
+		agent.Outputs = map[string]*model.PolicyOutput{}
+	}
+	output, ok := agent.Outputs[p.Name]
+	if !ok {
+		output = &model.PolicyOutput{}
+		agent.Outputs[p.Name] = output
+	}
+	if output.APIKey == "" {
+		body, err := renderUpdatePainlessScript(p.Name, map[string]interface{}{
+			dl.FieldType: OutputTypeElasticsearch,
+		})
+		if err == nil {
+			err = bulker.Update(ctx, dl.FleetAgents, agent.Id, body, bulk.WithRefresh(), bulk.WithRetryOnConflict(3))
+		}
+		if err != nil {
+			zlog.Error().Err(err).Msg("fail update agent record, the API key is retried on the next policy change")
+		}
+		output.Type = OutputTypeElasticsearch
+	}
+
+	prepareRemoteOutputMap(outputMap[p.Name])
+	outputMap[p.Name]["api_key"] = output.APIKey
+	return nil
+}
+
+// prepareRemoteOutputMap rewrites a remote elasticsearch output for the agent.
+func prepareRemoteOutputMap(output map[string]interface{}) {
+	// replace type remote_elasticsearch with elasticsearch as agent doesn't recognize remote_elasticsearch
+	output[FieldOutputType] = OutputTypeElasticsearch
+	// remove the service token from the agent policy sent to the agent
+	delete(output, FieldOutputServiceToken)
+}
+
+// reportRemoteOutputHealth records the health of a remote output in the bulker and writes it to the output health index.
+func reportRemoteOutputHealth(ctx context.Context, zlog zerolog.Logger, bulker bulk.Bulk, outputName string, state client.UnitState, message string) {
+	bulker.SetRemoteOutputHealth(outputName, bulk.OutputHealth{
+		State:   state.String(),
+		Message: message,
+	})
+	doc := model.OutputHealth{
+		Output:  outputName,
+		State:   state.String(),
+		Message: message,
+	}
+	if err := dl.CreateOutputHealth(ctx, bulker, doc); err != nil {
+		zlog.Error().Err(err).Str(logger.PolicyOutputName, outputName).Msg("error writing output health")
+	}
+}
+
 func fetchAPIKeyRoles(ctx context.Context, b bulk.Bulk, apiKeyID string) (*RoleT, error) {
 	res, err := b.APIKeyRead(ctx, apiKeyID, true)
 	if err != nil {
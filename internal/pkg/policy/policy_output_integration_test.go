@@ -24,7 +24,8 @@ import (
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 )
 
-var TestPayload []byte
+// TestPayload is a valid output permission section, the roles of the outputs are used as they are.
+var TestPayload = []byte(`{"_elastic_agent_checks":{"cluster":["monitor"],"indices":[{"names":["logs-*","metrics-*"],"privileges":["auto_configure","create_doc"]}]}}`)
 
 func TestRenderUpdatePainlessScript(t *testing.T) {
 	tts := []struct {
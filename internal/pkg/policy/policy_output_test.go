@@ -10,8 +10,15 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -24,7 +31,8 @@ import (
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 )
 
-var TestPayload []byte
+// TestPayload is a valid output permission section, the roles of the outputs are used as they are.
+var TestPayload = []byte(`{"_elastic_agent_checks":{"cluster":["monitor"],"indices":[{"names":["logs-*","metrics-*"],"privileges":["auto_configure","create_doc"]}]}}`)
 
 func TestPolicyLogstashOutputPrepare(t *testing.T) {
 	logger := testlog.SetLogger(t)
@@ -106,17 +114,36 @@ func TestPolicyKafkaOutputPrepareNoRole(t *testing.T) {
 }
 
 func TestPolicyESOutputPrepareNoRole(t *testing.T) {
-	logger := testlog.SetLogger(t)
-	bulker := ftesting.NewMockBulk()
-	po := Output{
-		Type: OutputTypeElasticsearch,
-		Name: "test output",
-		Role: nil,
+	tests := map[string]*RoleT{
+		"no section":          nil,
+		"index not allowed":   {Sha2: "new-hash", Raw: []byte(`{"test output":{"indices":[{"names":["logs-*",".security-*"],"privileges":["create_doc"]}]}}`)},
+		"read not allowed":    {Sha2: "new-hash", Raw: []byte(`{"test output":{"indices":[{"names":["logs-*"],"privileges":["read"]}]}}`)},
+		"run as":              {Sha2: "new-hash", Raw: []byte(`{"test output":{"cluster":["monitor"],"run_as":["elastic"]}}`)},
+		"invalid descriptors": {Sha2: "new-hash", Raw: []byte(`{"test output":{"indices":"logs-*"}}`)},
 	}
+	for name, role := range tests {
+		t.Run(name, func(t *testing.T) {
+			logger := testlog.SetLogger(t)
+			bulker := ftesting.NewMockBulk()
+			apiKey := bulk.APIKey{ID: "abc", Key: "new-key"}
+			bulker.On("APIKeyCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&apiKey, nil).Once()
+			bulker.On("Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+			po := Output{
+				Type: OutputTypeElasticsearch,
+				Name: "test output",
+				Role: role,
+			}
+			testAgent := &model.Agent{Outputs: map[string]*model.PolicyOutput{}}
 
-	err := po.Prepare(context.Background(), logger, bulker, &model.Agent{}, map[string]map[string]interface{}{})
-	require.NotNil(t, err, "expected prepare to error")
-	bulker.AssertExpectations(t)
+			err := po.Prepare(context.Background(), logger, bulker, testAgent, map[string]map[string]interface{}{"test output": {}})
+			require.NoError(t, err, "expected prepare to fall back to the legacy output role")
+
+			roles := bulker.Calls[0].Arguments.Get(3).([]byte)
+			assert.JSONEq(t, legacyOutputRoleJSON, string(roles))
+			assert.Equal(t, legacyOutputRole.Sha2, testAgent.Outputs[po.Name].PermissionsHash)
+			bulker.AssertExpectations(t)
+		})
+	}
 }
 
 func TestPolicyOutputESPrepare(t *testing.T) {
@@ -298,6 +325,42 @@ func TestPolicyOutputESPrepare(t *testing.T) {
 
 		bulker.AssertExpectations(t)
 	})
+
+	t.Run("Generate API Key with the data stream namespaces of the policy", func(t *testing.T) {
+		logger := testlog.SetLogger(t)
+		bulker := ftesting.NewMockBulk()
+		bulker.On("Update",
+			mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(nil).Once()
+		apiKey := bulk.APIKey{ID: "abc", Key: "new-key"}
+		bulker.On("APIKeyCreate",
+			mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(&apiKey, nil).Once()
+
+		// the nginx integration writes to the production namespace, the agent is in the team-a space
+		raw := `{"nginx-1":{"indices":[{"names":["logs-nginx.access-production","metrics-nginx.stubstatus-production"],"privileges":["auto_configure","create_doc"]}]},` +
+			`"endpoint-1":{"indices":[{"names":[".logs-endpoint.diagnostic.collection-*"],"privileges":["auto_configure","create_doc"]}]}}`
+		output := Output{
+			Type: OutputTypeElasticsearch,
+			Name: "test output",
+			Role: &RoleT{
+				Sha2: "new-hash",
+				Raw:  []byte(raw),
+			},
+		}
+		policyMap := map[string]map[string]interface{}{
+			"test output": map[string]interface{}{},
+		}
+		testAgent := &model.Agent{Namespaces: []string{"team-a"}, Outputs: map[string]*model.PolicyOutput{}}
+
+		err := output.Prepare(context.Background(), logger, bulker, testAgent, policyMap)
+		require.NoError(t, err, "expected prepare to pass")
+
+		roles := bulker.Calls[0].Arguments.Get(3).([]byte)
+		assert.JSONEq(t, raw, string(roles), "the output permissions are not restricted to the agent namespaces")
+		assert.Equal(t, "new-hash", testAgent.Outputs[output.Name].PermissionsHash, "the key is not regenerated for the namespaces")
+		bulker.AssertExpectations(t)
+	})
 }
 
 func TestPolicyRemoteESOutputPrepareNoRole(t *testing.T) {
@@ -550,10 +613,255 @@ func TestPolicyRemoteESOutputPrepare(t *testing.T) {
 			},
 		}
 		testAgent := &model.Agent{Outputs: map[string]*model.PolicyOutput{}}
+		bulker.On("Update", mock.Anything, dl.FleetAgents, mock.Anything, mock.MatchedBy(func(body []byte) bool {
+			return strings.Contains(string(body), `"type":"elasticsearch"`) && !strings.Contains(string(body), "api_key")
+		}), mock.Anything).Return(nil).Once()
 
 		err = output.Prepare(context.Background(), logger, bulker, testAgent, policyMap)
 		require.NoError(t, err, "expected prepare to pass")
 
+		assert.Equal(t, "", policyMap[output.Name]["api_key"], "expected the output without API key")
+		assert.Equal(t, OutputTypeElasticsearch, policyMap["test output"]["type"])
+		assert.Empty(t, policyMap["test output"]["service_token"])
+		assert.Equal(t, client.UnitStateDegraded.String(), bulker.RemoteOutputHealth()[output.Name].State)
+
+		bulker.AssertExpectations(t)
+	})
+
+	t.Run("Report degraded output health on remote client failure", func(t *testing.T) {
+		logger := testlog.SetLogger(t)
+		bulker := ftesting.NewMockBulk()
+		bulker.On("CreateAndGetBulker", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(nil, false, errors.New("failed to get service token from output: test output")).Once()
+		bulker.On("Create", mock.Anything, dl.FleetOutputHealth, mock.Anything, mock.MatchedBy(func(body []byte) bool {
+			var doc model.OutputHealth
+			err := json.Unmarshal(body, &doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return doc.Message == "remote ES client could not be created due to error: failed to get service token from output: test output" &&
+				doc.State == client.UnitStateDegraded.String()
+		}), mock.Anything).Return("", nil).Once()
+		bulker.On("Update", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+
+		output := Output{
+			Type: OutputTypeRemoteElasticsearch,
+			Name: "test output",
+			Role: &RoleT{
+				Sha2: "new-hash",
+				Raw:  TestPayload,
+			},
+		}
+		policyMap := map[string]map[string]interface{}{
+			"test output": map[string]interface{}{
+				"hosts": []interface{}{"http://localhost"},
+				"type":  OutputTypeRemoteElasticsearch,
+			},
+		}
+		testAgent := &model.Agent{}
+
+		err := output.Prepare(context.Background(), logger, bulker, testAgent, policyMap)
+		require.NoError(t, err, "expected prepare to pass")
+
+		assert.Equal(t, "", policyMap[output.Name]["api_key"])
+		assert.Equal(t, OutputTypeElasticsearch, policyMap[output.Name]["type"])
+		require.Contains(t, testAgent.Outputs, output.Name)
+		assert.Empty(t, testAgent.Outputs[output.Name].APIKey)
+
+		bulker.AssertExpectations(t)
+	})
+
+	t.Run("Keep the current API key on API key update failure", func(t *testing.T) {
+		logger := testlog.SetLogger(t)
+		bulker := ftesting.NewMockBulk()
+		apiKey := bulk.APIKey{ID: "test_id", Key: "EXISTING-KEY"}
+
+		outputBulker := ftesting.NewMockBulk()
+		outputBulker.
+			On("APIKeyRead", mock.Anything, mock.Anything, mock.Anything).
+			Return(&bulk.APIKeyMetadata{ID: apiKey.ID, RoleDescriptors: TestPayload}, nil).
+			Once()
+		outputBulker.On("APIKeyUpdate", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(errors.New("error connecting")).Once()
+		bulker.On("CreateAndGetBulker", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(outputBulker, false).Once()
+		bulker.On("Create", mock.Anything, dl.FleetOutputHealth, mock.Anything, mock.Anything, mock.Anything).Return("", nil).Once()
+
+		output := Output{
+			Type: OutputTypeRemoteElasticsearch,
+			Name: "test output",
+			Role: &RoleT{
+				Sha2: "new-hash",
+				Raw:  TestPayload,
+			},
+		}
+		policyMap := map[string]map[string]interface{}{
+			"test output": map[string]interface{}{
+				"hosts":         []interface{}{"http://localhost"},
+				"service_token": "serviceToken1",
+				"type":          OutputTypeRemoteElasticsearch,
+			},
+		}
+		testAgent := &model.Agent{
+			Outputs: map[string]*model.PolicyOutput{
+				output.Name: {
+					APIKey:          apiKey.Agent(),
+					APIKeyID:        apiKey.ID,
+					PermissionsHash: "old-hash",
+					Type:            OutputTypeElasticsearch,
+				},
+			},
+		}
+
+		err := output.Prepare(context.Background(), logger, bulker, testAgent, policyMap)
+		require.NoError(t, err, "expected prepare to pass")
+
+		assert.Equal(t, apiKey.Agent(), policyMap[output.Name]["api_key"])
+		assert.Equal(t, "old-hash", testAgent.Outputs[output.Name].PermissionsHash, "expected the update to be retried")
+		bulker.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 		bulker.AssertExpectations(t)
+		outputBulker.AssertExpectations(t)
+	})
+}
+
+// registryBulk is a mock bulker of the fleet cluster that creates real bulkers for the remote outputs.
+type registryBulk struct {
+	*ftesting.MockBulk
+	registry *bulk.Bulker
+}
+
+func (b *registryBulk) CreateAndGetBulker(ctx context.Context, zlog zerolog.Logger, outputName string, outputMap map[string]map[string]interface{}) (bulk.Bulk, bool, error) {
+	return b.registry.CreateAndGetBulker(ctx, zlog, outputName, outputMap)
+}
+
+// mockCluster is an elasticsearch cluster that creates API keys.
+type mockCluster struct {
+	*httptest.Server
+	mu   sync.Mutex
+	keys []string // authorization of the API key requests
+	down bool
+}
+
+func newMockCluster(t *testing.T, name string) *mockCluster {
+	c := &mockCluster{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if r.URL.Path != "/_security/api_key" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.down {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		c.keys = append(c.keys, r.Header.Get("Authorization"))
+		id := fmt.Sprintf("%s-key-%d", name, len(c.keys))
+		_, _ = fmt.Fprintf(w, `{"id":%q,"name":"agent","api_key":"secret"}`, id)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *mockCluster) setDown(down bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.down = down
+}
+
+func (c *mockCluster) requests() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.keys)
+}
+
+func TestPolicyRemoteESOutputPrepareClusters(t *testing.T) {
+	logger := testlog.SetLogger(t)
+	ctx := logger.WithContext(context.Background())
+	cluster1 := newMockCluster(t, "cluster1")
+	cluster2 := newMockCluster(t, "cluster2")
+
+	bulker := &registryBulk{MockBulk: ftesting.NewMockBulk(), registry: bulk.NewBulker(nil, nil)}
+	t.Cleanup(func() {
+		for _, outputBulker := range bulker.registry.GetBulkerMap() {
+			outputBulker.CancelFn()()
+		}
+	})
+	bulker.On("Update", mock.Anything, dl.FleetAgents, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	bulker.On("Create", mock.Anything, dl.FleetOutputHealth, mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+
+	outputs := []Output{{
+		Type: OutputTypeRemoteElasticsearch,
+		Name: "remote1",
+		Role: &RoleT{Sha2: "hash", Raw: TestPayload},
+	}, {
+		Type: OutputTypeRemoteElasticsearch,
+		Name: "remote2",
+		Role: &RoleT{Sha2: "hash", Raw: TestPayload},
+	}}
+	newPolicyMap := func() map[string]map[string]interface{} {
+		return map[string]map[string]interface{}{
+			"remote1": {
+				"hosts":         []interface{}{cluster1.URL},
+				"service_token": "token1",
+				"type":          OutputTypeRemoteElasticsearch,
+			},
+			"remote2": {
+				"hosts":         []interface{}{cluster2.URL},
+				"service_token": "token2",
+				"type":          OutputTypeRemoteElasticsearch,
+			},
+		}
+	}
+
+	t.Run("keys are created on the cluster of each output", func(t *testing.T) {
+		policyMap := newPolicyMap()
+		agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent1"}}
+		for _, output := range outputs {
+			require.NoError(t, output.Prepare(ctx, logger, bulker, agent, policyMap))
+		}
+
+		assert.Equal(t, []string{"Bearer token1"}, cluster1.requests())
+		assert.Equal(t, []string{"Bearer token2"}, cluster2.requests())
+		assert.Equal(t, "cluster1-key-1", agent.Outputs["remote1"].APIKeyID)
+		assert.Equal(t, "cluster2-key-1", agent.Outputs["remote2"].APIKeyID)
+		assert.Equal(t, "cluster1-key-1:secret", policyMap["remote1"]["api_key"])
+		assert.Equal(t, "cluster2-key-1:secret", policyMap["remote2"]["api_key"])
+		assert.NotContains(t, policyMap["remote1"], FieldOutputServiceToken)
+
+		health := bulker.RemoteOutputHealth()
+		assert.Equal(t, client.UnitStateHealthy.String(), health["remote1"].State)
+		assert.Equal(t, client.UnitStateHealthy.String(), health["remote2"].State)
+	})
+
+	t.Run("an unavailable cluster does not prevent the delivery of the other keys", func(t *testing.T) {
+		cluster2.setDown(true)
+		policyMap := newPolicyMap()
+		agent := &model.Agent{ESDocument: model.ESDocument{Id: "agent2"}}
+		for _, output := range outputs {
+			require.NoError(t, output.Prepare(ctx, logger, bulker, agent, policyMap))
+		}
+
+		assert.Len(t, cluster1.requests(), 2)
+		assert.Len(t, cluster2.requests(), 1)
+		assert.Equal(t, "cluster1-key-2:secret", policyMap["remote1"]["api_key"])
+		assert.Equal(t, "", policyMap["remote2"]["api_key"])
+		assert.Equal(t, OutputTypeElasticsearch, policyMap["remote2"]["type"])
+		assert.Empty(t, agent.Outputs["remote2"].APIKey)
+
+		health := bulker.RemoteOutputHealth()
+		assert.Equal(t, client.UnitStateHealthy.String(), health["remote1"].State)
+		assert.Equal(t, client.UnitStateDegraded.String(), health["remote2"].State)
+
+		t.Run("the key is created when the policy is sent again", func(t *testing.T) {
+			cluster2.setDown(false)
+			policyMap := newPolicyMap()
+			for _, output := range outputs {
+				require.NoError(t, output.Prepare(ctx, logger, bulker, agent, policyMap))
+			}
+
+			assert.Len(t, cluster1.requests(), 2, "expected the key of remote1 to be reused")
+			assert.Equal(t, "cluster2-key-2:secret", policyMap["remote2"]["api_key"])
+			assert.Equal(t, client.UnitStateHealthy.String(), bulker.RemoteOutputHealth()["remote2"].State)
+		})
 	})
 }
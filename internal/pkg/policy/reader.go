@@ -0,0 +1,157 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package policy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+type revisionFetcher func(ctx context.Context, bulker bulk.Bulk, policyID string, revisionIdx int64, opt ...dl.Option) (model.Policy, error)
+
+type revisionKey struct {
+	policyID    string
+	revisionIdx int64
+}
+
+type readerEntry struct {
+	pp      *ParsedPolicy
+	expires time.Time
+}
+
+// Reader reads the policy revisions of the requests that do not get their policy from the monitor.
+// Concurrent reads of a revision share a single fetch from Elasticsearch, and the parsed policy is cached for ttl,
+// or until the monitor finds a newer revision of the policy.
+type Reader struct {
+	bulker bulk.Bulk
+	fetch  revisionFetcher
+
+	group singleflight.Group
+
+	mx      sync.Mutex
+	ttl     time.Duration
+	size    int64 // max number of cached revisions, 0 for no limit
+	entries map[revisionKey]readerEntry
+	// gens is incremented when the revisions of a policy are invalidated, so the fetches started before are not cached.
+	gens map[string]uint64
+	// epoch is incremented when all the revisions are invalidated.
+	epoch uint64
+}
+
+// NewReader returns a Reader caching the policies for ttl.
+func NewReader(bulker bulk.Bulk, ttl time.Duration) *Reader {
+	return &Reader{
+		bulker:  bulker,
+		ttl:     ttl,
+		fetch:   dl.FindPolicyRevision,
+		entries: make(map[revisionKey]readerEntry),
+		gens:    make(map[string]uint64),
+	}
+}
+
+// Get returns the revisionIdx revision of the policy, or its latest revision if revisionIdx is not positive.
+// The returned policy is shared and must not be modified.
+func (r *Reader) Get(ctx context.Context, policyID string, revisionIdx int64) (*ParsedPolicy, error) {
+	if revisionIdx < 0 {
+		revisionIdx = 0
+	}
+	key := revisionKey{policyID: policyID, revisionIdx: revisionIdx}
+
+	r.mx.Lock()
+	entry, ok := r.entries[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(r.entries, key)
+		ok = false
+	}
+	gen, epoch := r.gens[policyID], r.epoch
+	r.mx.Unlock()
+	if ok {
+		return entry.pp, nil
+	}
+
+	// The fetch is shared with the other readers of the revision, it must not be canceled when this request is.
+	fetchCtx := context.WithoutCancel(ctx)
+	v, err, _ := r.group.Do(fmt.Sprintf("%s:%d:%d:%d", policyID, revisionIdx, gen, epoch), func() (interface{}, error) {
+		p, err := r.fetch(fetchCtx, r.bulker, policyID, revisionIdx)
+		if err != nil {
+			return nil, err
+		}
+		pp, err := NewParsedPolicy(fetchCtx, r.bulker, p)
+		if err != nil {
+			return nil, err
+		}
+
+		r.mx.Lock()
+		defer r.mx.Unlock()
+		if r.gens[policyID] == gen && r.epoch == epoch && r.ttl > 0 {
+			r.evict()
+			r.entries[key] = readerEntry{pp: pp, expires: time.Now().Add(r.ttl)}
+		}
+		return pp, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*ParsedPolicy), nil
+}
+
+// Invalidate removes the cached revisions of the policy older than revisionIdx, and its cached latest revision.
+// It is called by the monitor when it finds a new revision of the policy.
+func (r *Reader) Invalidate(policyID string, revisionIdx int64) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.gens[policyID]++
+	for key := range r.entries {
+		if key.policyID == policyID && key.revisionIdx < revisionIdx {
+			delete(r.entries, key)
+		}
+	}
+}
+
+// InvalidateAll removes all the cached revisions.
+// It is called by the monitor when the policies index is read again from the start, after a restore.
+func (r *Reader) InvalidateAll() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.epoch++
+	clear(r.entries)
+}
+
+// ReconfigureCache sets the ttl and the max number of cached revisions, the revisions cached before keep their expiration.
+func (r *Reader) ReconfigureCache(settings config.CacheSettings) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.ttl = settings.TTL
+	r.size = settings.Size
+}
+
+// evict removes the expired revisions once the cache is full, and then any revision until a new one fits.
+// It is called with the mutex held.
+func (r *Reader) evict() {
+	if r.size <= 0 || int64(len(r.entries)) < r.size {
+		return
+	}
+	now := time.Now()
+	for key, entry := range r.entries {
+		if now.After(entry.expires) {
+			delete(r.entries, key)
+		}
+	}
+	for key := range r.entries {
+		if int64(len(r.entries)) < r.size {
+			return
+		}
+		delete(r.entries, key)
+	}
+}
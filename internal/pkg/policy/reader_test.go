@@ -0,0 +1,232 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	mockmonitor "github.com/elastic/fleet-server/v7/internal/pkg/monitor/mock"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+)
+
+// countingFetcher returns the policies of revisions, the latest one when no revision is requested.
+type countingFetcher struct {
+	calls   atomic.Int32
+	release chan struct{}
+
+	mx        sync.Mutex
+	revisions []int64
+}
+
+func (f *countingFetcher) fetch(ctx context.Context, _ bulk.Bulk, policyID string, revisionIdx int64, _ ...dl.Option) (model.Policy, error) {
+	f.calls.Add(1)
+	if f.release != nil {
+		select {
+		case <-f.release:
+		case <-ctx.Done():
+			return model.Policy{}, ctx.Err()
+		}
+	}
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if revisionIdx <= 0 {
+		revisionIdx = f.revisions[len(f.revisions)-1]
+	}
+	var data model.PolicyData
+	if err := json.Unmarshal([]byte(logstashOutputPolicy), &data); err != nil {
+		return model.Policy{}, err
+	}
+	return model.Policy{PolicyID: policyID, RevisionIdx: revisionIdx, Data: &data}, nil
+}
+
+func (f *countingFetcher) addRevision(revisionIdx int64) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.revisions = append(f.revisions, revisionIdx)
+}
+
+func newTestReader(ttl time.Duration, f *countingFetcher) *Reader {
+	r := NewReader(ftesting.NewMockBulk(), ttl)
+	r.fetch = f.fetch
+	return r
+}
+
+func TestReaderCoalescesReads(t *testing.T) {
+	const readers = 1000
+	f := &countingFetcher{release: make(chan struct{}), revisions: []int64{1}}
+	r := newTestReader(time.Minute, f)
+
+	var wg, started sync.WaitGroup
+	results := make([]*ParsedPolicy, readers)
+	errs := make([]error, readers)
+	for i := range readers {
+		wg.Add(1)
+		started.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			results[i], errs[i] = r.Get(context.Background(), "policy-1", 0)
+		}()
+	}
+	started.Wait()
+	require.Eventually(t, func() bool { return f.calls.Load() == 1 }, time.Second, time.Millisecond)
+	close(f.release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), f.calls.Load())
+	for i := range readers {
+		require.NoError(t, errs[i])
+		require.Same(t, results[0], results[i])
+	}
+	require.Equal(t, int64(1), results[0].Policy.RevisionIdx)
+}
+
+func TestReaderInvalidate(t *testing.T) {
+	f := &countingFetcher{revisions: []int64{1}}
+	r := newTestReader(time.Minute, f)
+	ctx := context.Background()
+
+	pp, err := r.Get(ctx, "policy-1", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), pp.Policy.RevisionIdx)
+	_, err = r.Get(ctx, "policy-1", 1)
+	require.NoError(t, err)
+	_, err = r.Get(ctx, "policy-2", 0)
+	require.NoError(t, err)
+	require.Equal(t, int32(3), f.calls.Load())
+
+	// the monitor finds the new revision of policy-1
+	f.addRevision(2)
+	m := NewMonitor(ftesting.NewMockBulk(), mockmonitor.NewMockMonitor(), config.ServerLimits{}, WithReader(r))
+	newPP, err := NewParsedPolicy(ctx, ftesting.NewMockBulk(), model.Policy{PolicyID: "policy-1", RevisionIdx: 2, Data: pp.Policy.Data})
+	require.NoError(t, err)
+	m.(*monitorT).updatePolicy(ctx, newPP)
+
+	pp, err = r.Get(ctx, "policy-1", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), pp.Policy.RevisionIdx)
+	_, err = r.Get(ctx, "policy-1", 1)
+	require.NoError(t, err)
+	require.Equal(t, int32(5), f.calls.Load())
+
+	// the other policies are still cached
+	_, err = r.Get(ctx, "policy-2", 0)
+	require.NoError(t, err)
+	require.Equal(t, int32(5), f.calls.Load())
+}
+
+func TestReaderInvalidateDuringFetch(t *testing.T) {
+	f := &countingFetcher{release: make(chan struct{}), revisions: []int64{1}}
+	r := newTestReader(time.Minute, f)
+	ctx := context.Background()
+
+	done := make(chan *ParsedPolicy)
+	go func() {
+		pp, err := r.Get(ctx, "policy-1", 0)
+		require.NoError(t, err)
+		done <- pp
+	}()
+	require.Eventually(t, func() bool { return f.calls.Load() == 1 }, time.Second, time.Millisecond)
+
+	// the revision fetched before the invalidation is not cached
+	r.Invalidate("policy-1", 2)
+	f.addRevision(2)
+	close(f.release)
+	<-done
+
+	pp, err := r.Get(ctx, "policy-1", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), pp.Policy.RevisionIdx)
+	require.Equal(t, int32(2), f.calls.Load())
+}
+
+func TestReaderTTL(t *testing.T) {
+	f := &countingFetcher{revisions: []int64{1}}
+	r := newTestReader(10*time.Millisecond, f)
+	ctx := context.Background()
+
+	_, err := r.Get(ctx, "policy-1", 0)
+	require.NoError(t, err)
+	_, err = r.Get(ctx, "policy-1", 0)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), f.calls.Load())
+
+	time.Sleep(20 * time.Millisecond)
+	_, err = r.Get(ctx, "policy-1", 0)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), f.calls.Load())
+}
+
+func TestReaderErrorNotCached(t *testing.T) {
+	r := NewReader(ftesting.NewMockBulk(), time.Minute)
+	var calls int
+	r.fetch = func(_ context.Context, _ bulk.Bulk, _ string, _ int64, _ ...dl.Option) (model.Policy, error) {
+		calls++
+		return model.Policy{}, dl.ErrNotFound
+	}
+
+	for range 2 {
+		_, err := r.Get(context.Background(), "policy-1", 0)
+		require.ErrorIs(t, err, dl.ErrNotFound)
+	}
+	require.Equal(t, 2, calls)
+}
+
+func TestReaderReconfigureCache(t *testing.T) {
+	f := &countingFetcher{revisions: []int64{1}}
+	r := newTestReader(time.Minute, f)
+	r.ReconfigureCache(config.CacheSettings{TTL: time.Minute, Size: 2})
+	ctx := context.Background()
+
+	for _, policyID := range []string{"policy-1", "policy-2", "policy-3"} {
+		_, err := r.Get(ctx, policyID, 0)
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(3), f.calls.Load())
+	require.Len(t, r.entries, 2)
+
+	// a zero ttl disables the cache
+	r.ReconfigureCache(config.CacheSettings{})
+	for range 2 {
+		_, err := r.Get(ctx, "policy-4", 0)
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(5), f.calls.Load())
+}
+
+func TestReaderInvalidateAll(t *testing.T) {
+	f := &countingFetcher{revisions: []int64{1}}
+	r := newTestReader(time.Minute, f)
+	ctx := context.Background()
+
+	_, err := r.Get(ctx, "policy-1", 0)
+	require.NoError(t, err)
+	_, err = r.Get(ctx, "policy-2", 0)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), f.calls.Load())
+
+	// the index is restored with another latest revision
+	f.addRevision(3)
+	r.InvalidateAll()
+	pp, err := r.Get(ctx, "policy-1", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), pp.Policy.RevisionIdx)
+	_, err = r.Get(ctx, "policy-2", 0)
+	require.NoError(t, err)
+	require.Equal(t, int32(4), f.calls.Load())
+}
@@ -266,25 +266,20 @@ func reportOutputHealth(ctx context.Context, bulker bulk.Bulk, zlog zerolog.Logg
 		if isOutputCfgOutdated(ctx, bulker, zlog, outputName) {
 			continue
 		}
-		doc := model.OutputHealth{
-			Output:  outputName,
-			State:   client.UnitStateHealthy.String(),
-			Message: "",
-		}
+		state := client.UnitStateHealthy
+		message := ""
 		res, err := outputBulker.Client().Ping(outputBulker.Client().Ping.WithContext(ctx))
 		if err != nil {
-			doc.State = client.UnitStateDegraded.String()
-			doc.Message = fmt.Sprintf("remote ES is not reachable due to error: %s", err.Error())
-			zlog.Error().Err(err).Str(logger.PolicyOutputName, outputName).Msg(doc.Message)
+			state = client.UnitStateDegraded
+			message = fmt.Sprintf("remote ES is not reachable due to error: %s", err.Error())
+			zlog.Error().Err(err).Str(logger.PolicyOutputName, outputName).Msg(message)
 
 		} else if res.StatusCode != 200 {
-			doc.State = client.UnitStateDegraded.String()
-			doc.Message = fmt.Sprintf("remote ES is not reachable due to unexpected status code %d", res.StatusCode)
-			zlog.Error().Err(err).Str(logger.PolicyOutputName, outputName).Msg(doc.Message)
-		}
-		if err := dl.CreateOutputHealth(ctx, bulker, doc); err != nil {
-			zlog.Error().Err(err).Str(logger.PolicyOutputName, outputName).Msg("error writing output health")
+			state = client.UnitStateDegraded
+			message = fmt.Sprintf("remote ES is not reachable due to unexpected status code %d", res.StatusCode)
+			zlog.Error().Err(err).Str(logger.PolicyOutputName, outputName).Msg(message)
 		}
+		reportRemoteOutputHealth(ctx, zlog, bulker, outputName, state, message)
 	}
 }
 
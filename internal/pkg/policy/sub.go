@@ -6,6 +6,7 @@ package policy
 
 import (
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	"github.com/elastic/fleet-server/v7/internal/pkg/waiters"
 )
 
 type subT struct {
@@ -16,7 +17,8 @@ type subT struct {
 	next *subT
 	prev *subT
 
-	ch chan *ParsedPolicy
+	w    *waiters.Waiter[*ParsedPolicy] // set by the monitor on subscribe
+	stop func() bool                    // stops the unlink on context cancellation
 }
 
 func NewSub(policyID, agentID string, revIdx int64) *subT {
@@ -24,7 +26,6 @@ func NewSub(policyID, agentID string, revIdx int64) *subT {
 		policyID: policyID,
 		agentID:  agentID,
 		revIdx:   revIdx,
-		ch:       make(chan *ParsedPolicy, 1),
 	}
 }
 
@@ -82,7 +83,23 @@ func (n *subT) isUpdate(policy *model.Policy) bool {
 
 // Output returns a new policy that needs to be sent based on the current subscription.
 func (n *subT) Output() <-chan *ParsedPolicy {
-	return n.ch
+	if n.w == nil {
+		return nil
+	}
+	return n.w.C()
+}
+
+// preempted returns true if a newer subscription of the agent replaced this one.
+func (n *subT) preempted() bool {
+	if n.w == nil {
+		return false
+	}
+	select {
+	case <-n.w.Preempted():
+		return true
+	default:
+		return false
+	}
 }
 
 type subIterT struct {
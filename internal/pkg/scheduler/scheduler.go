@@ -8,17 +8,22 @@ package scheduler
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"math/rand"
 
+	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/rs/zerolog"
-	"golang.org/x/sync/errgroup"
 )
 
 const (
 	defaultSplayPercent  = 10
 	defaultFirstRunDelay = 10 * time.Second
+	defaultStopTimeout   = 5 * time.Second
 )
 
 // WorkFunc is the type of function a Scheduler can run.
@@ -29,6 +34,17 @@ type Schedule struct {
 	Name     string
 	Interval time.Duration // Time between executions
 	WorkFn   WorkFunc
+
+	// Jitter is the fraction of Interval the executions are randomly spread over, the splay of the scheduler is used if it is zero.
+	Jitter float64
+	// Timeout cancels the context of an execution that takes longer, executions are not limited if it is zero.
+	// The next executions are skipped until an execution that ignores the cancellation returns.
+	Timeout time.Duration
+	// RunOnStart executes WorkFn when the scheduler starts, instead of after the first run delay.
+	RunOnStart bool
+	// StopFn is called when the scheduler stops, once the last execution of WorkFn returned.
+	// Its context is not cancelled with the context of the scheduler, it times out after Timeout or 5s.
+	StopFn WorkFunc
 }
 
 // Scheduler tracks scheduled functions.
@@ -36,8 +52,10 @@ type Scheduler struct {
 	splayPercent  int
 	firstRunDelay time.Duration // Interval to run the scheduled function for the first time since the scheduler started, splayed as well.
 
-	rand      *rand.Rand
-	schedules []Schedule
+	randMu sync.Mutex
+	rand   *rand.Rand
+	jobs   []*job
+	stats  *monitoring.Registry
 }
 
 // OptFunc is a functional option used to configure a scheduler.
@@ -63,6 +81,14 @@ func WithFirstRunDelay(delay time.Duration) OptFunc {
 	}
 }
 
+// WithStats registers the stats of each schedule in reg, under the name of the schedule.
+func WithStats(reg *monitoring.Registry) OptFunc {
+	return func(s *Scheduler) error {
+		s.stats = reg
+		return nil
+	}
+}
+
 // New creates a new Scheduler with the specified schedules.
 // Schedules may not be added to a scheduler after creation.
 func New(schedules []Schedule, opts ...OptFunc) (*Scheduler, error) {
@@ -70,7 +96,6 @@ func New(schedules []Schedule, opts ...OptFunc) (*Scheduler, error) {
 		splayPercent:  defaultSplayPercent,
 		firstRunDelay: defaultFirstRunDelay,
 		rand:          rand.New(rand.NewSource(time.Now().UnixNano())), //nolint:gosec // used for timing offsets
-		schedules:     schedules,
 	}
 
 	for _, opt := range opts {
@@ -80,54 +105,198 @@ func New(schedules []Schedule, opts ...OptFunc) (*Scheduler, error) {
 		}
 	}
 
+	names := make(map[string]bool, len(schedules))
+	for _, schedule := range schedules {
+		if names[schedule.Name] {
+			return nil, fmt.Errorf("duplicate schedule %q", schedule.Name)
+		}
+		names[schedule.Name] = true
+		if schedule.Jitter < 0 || schedule.Jitter >= 1 {
+			return nil, fmt.Errorf("invalid jitter for schedule %q, expected >= 0 and < 1", schedule.Name)
+		}
+		j := &job{Schedule: schedule}
+		if s.stats != nil {
+			j.stats.register(s.stats.NewRegistry(statsName(schedule.Name)))
+		}
+		s.jobs = append(s.jobs, j)
+	}
+
 	return s, nil
 }
 
 // Run executes all scheduled function according to their schedules.
 // Schedule Interval times are guaranteed minium values (if the execution takes a very long time, the scheduler will wait Interval before running the function again).
+// When ctx is cancelled the schedules are stopped one at a time in the order they were passed to New, Run returns once they are all stopped.
 func (s *Scheduler) Run(ctx context.Context) error {
-	g, ctx := errgroup.WithContext(ctx)
-	log := zerolog.Ctx(ctx).With().Str("ctx", "elasticsearch CG scheduler").Logger()
+	log := zerolog.Ctx(ctx).With().Str("ctx", "scheduler").Logger()
 	ctx = log.WithContext(ctx)
 
-	for _, schedule := range s.schedules {
-		g.Go(s.getRunScheduleFunc(ctx, schedule))
+	// the schedules are cancelled by the scheduler, in order, rather than all at once with ctx
+	jobCtx := context.WithoutCancel(ctx)
+	stops := make([]func(), len(s.jobs))
+	for i, j := range s.jobs {
+		ctx, cancel := context.WithCancel(jobCtx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.runJob(ctx, j)
+		}()
+		stops[i] = func() {
+			cancel()
+			<-done
+		}
+	}
+
+	<-ctx.Done()
+	for _, stop := range stops {
+		stop()
 	}
-	return g.Wait()
+	return nil
 }
 
-func (s *Scheduler) getRunScheduleFunc(ctx context.Context, schedule Schedule) func() error {
-	return func() error {
-		log := zerolog.Ctx(ctx).With().Str("schedule", schedule.Name).Logger()
+func (s *Scheduler) runJob(ctx context.Context, j *job) {
+	log := zerolog.Ctx(ctx).With().Str("schedule", j.Name).Logger()
+	ctx = log.WithContext(ctx)
 
-		t := time.NewTimer(s.intervalWithSplay(s.firstRunDelay)) // Initial schedule to run right away with splayed randomly delay
-		defer t.Stop()
+	// the execution in progress, if any
+	var inflight sync.WaitGroup
+	delay := s.intervalWithSplay(s.firstRunDelay, j.Jitter) // Initial schedule to run right away with splayed randomly delay
+	if j.RunOnStart {
+		delay = 0
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
 
-		for {
-			select {
-			case <-ctx.Done():
-				log.Debug().Msg("exiting on context cancel")
-				return nil
-			case <-t.C:
-				runSchedule(ctx, log, schedule)
-				t.Reset(s.intervalWithSplay(schedule.Interval))
-			}
+	for {
+		select {
+		case <-ctx.Done():
+			inflight.Wait()
+			j.stop(ctx, log)
+			log.Debug().Msg("exiting on context cancel")
+			return
+		case <-t.C:
+			j.execute(ctx, log, &inflight)
+			t.Reset(s.intervalWithSplay(j.Interval, j.Jitter))
 		}
 	}
 }
 
-func (s *Scheduler) intervalWithSplay(interval time.Duration) time.Duration {
+// intervalWithSplay returns the interval randomly spread by jitter, or by the splay of the scheduler if jitter is zero.
+func (s *Scheduler) intervalWithSplay(interval time.Duration, jitter float64) time.Duration {
+	s.randMu.Lock()
+	defer s.randMu.Unlock()
+	if jitter > 0 {
+		return time.Duration(float64(interval) * (1 - jitter + 2*jitter*s.rand.Float64()))
+	}
 	percent := 100 - s.splayPercent + s.rand.Intn(2*s.splayPercent+1)
 	return time.Duration(int64(interval) / int64(100.0) * int64(percent))
 }
 
-func runSchedule(ctx context.Context, log zerolog.Logger, schedule Schedule) {
-	log.Debug().Dur("interval", schedule.Interval).Msg("started")
+// job is a schedule run by the scheduler.
+type job struct {
+	Schedule
+	running atomic.Bool
+	stats   jobStats
+}
+
+// execute runs the function of the job and waits for it to return, or for its timeout.
+// The execution is skipped if the previous one did not return yet.
+func (j *job) execute(ctx context.Context, log zerolog.Logger, inflight *sync.WaitGroup) {
+	if !j.running.CompareAndSwap(false, true) {
+		j.stats.skipped.Inc()
+		log.Warn().Msg("scheduler.runSchedule: skipped, the previous execution is still running")
+		return
+	}
+
+	returned := make(chan struct{})
+	inflight.Add(1)
+	go func() {
+		defer inflight.Done()
+		defer close(returned)
+		defer j.running.Store(false)
+		j.run(ctx, log)
+	}()
+
+	if j.Timeout <= 0 {
+		<-returned
+		return
+	}
+	t := time.NewTimer(j.Timeout)
+	defer t.Stop()
+	select {
+	case <-returned:
+	case <-t.C:
+		log.Warn().Dur("timeout", j.Timeout).Msg("scheduler.runSchedule: schedule function did not return after its timeout")
+	}
+}
+
+func (j *job) run(ctx context.Context, log zerolog.Logger) {
+	if j.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.Timeout)
+		defer cancel()
+	}
+	log.Debug().Dur("interval", j.Interval).Msg("started")
 
-	err := schedule.WorkFn(ctx)
+	start := time.Now()
+	err := j.WorkFn(ctx)
+	j.stats.record(start, err)
 	if err != nil {
 		log.Warn().Err(err).Msg("scheduler.runSchedule: failed running schedule function")
 	}
 
 	log.Debug().Msg("finished")
 }
+
+// stop calls the stop function of the job, ctx is cancelled.
+func (j *job) stop(ctx context.Context, log zerolog.Logger) {
+	if j.StopFn == nil {
+		return
+	}
+	timeout := j.Timeout
+	if timeout <= 0 {
+		timeout = defaultStopTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	if err := j.StopFn(ctx); err != nil {
+		log.Warn().Err(err).Msg("scheduler.runSchedule: failed running schedule stop function")
+	}
+}
+
+// jobStats are the stats of the executions of a schedule.
+type jobStats struct {
+	runs         monitoring.Uint
+	failures     monitoring.Uint
+	skipped      monitoring.Uint
+	lastRun      monitoring.Timestamp
+	lastDuration monitoring.Int // in milliseconds
+	lastError    monitoring.String
+}
+
+func (s *jobStats) register(reg *monitoring.Registry) {
+	reg.Add("runs", &s.runs, monitoring.Full)
+	reg.Add("failures", &s.failures, monitoring.Full)
+	reg.Add("skipped", &s.skipped, monitoring.Full)
+	reg.Add("last_run", &s.lastRun, monitoring.Full)
+	reg.Add("last_duration_ms", &s.lastDuration, monitoring.Full)
+	reg.Add("last_error", &s.lastError, monitoring.Full)
+}
+
+// record records an execution that started at start and returned err.
+func (s *jobStats) record(start time.Time, err error) {
+	s.runs.Inc()
+	s.lastRun.Set(start)
+	s.lastDuration.Set(time.Since(start).Milliseconds())
+	if err != nil {
+		s.failures.Inc()
+		s.lastError.Fail(err)
+	} else {
+		s.lastError.Clear()
+	}
+}
+
+// statsName returns the name of the stats registry of a schedule.
+func statsName(name string) string {
+	return strings.NewReplacer(" ", "_", ".", "_").Replace(name)
+}
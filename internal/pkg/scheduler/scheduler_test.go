@@ -9,13 +9,17 @@ package scheduler
 import (
 	"context"
 	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/sleep"
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 
+	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -81,3 +85,114 @@ func TestScheduler(t *testing.T) {
 	}
 
 }
+
+func TestSchedulerOverlap(t *testing.T) {
+	ctx, cancel := context.WithCancel(testlog.SetLogger(t).WithContext(context.Background()))
+	defer cancel()
+
+	var running, maxRunning, calls atomic.Int32
+	release := make(chan struct{})
+	schedules := []Schedule{{
+		Name:     "slow schedule",
+		Interval: 10 * time.Millisecond,
+		Timeout:  10 * time.Millisecond,
+		WorkFn: func(context.Context) error {
+			calls.Add(1)
+			n := running.Add(1)
+			defer running.Add(-1)
+			if n > maxRunning.Load() {
+				maxRunning.Store(n)
+			}
+			// ignore the cancellation of the context, until released
+			<-release
+			return nil
+		},
+	}}
+	reg := monitoring.NewRegistry()
+	sched, err := New(schedules, WithFirstRunDelay(0), WithStats(reg))
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- sched.Run(ctx)
+	}()
+
+	// the executions are skipped while the first one did not return
+	require.Eventually(t, func() bool {
+		return monitoring.CollectFlatSnapshot(reg, monitoring.Full, false).Ints["slow_schedule.skipped"] >= 3
+	}, time.Second, time.Millisecond)
+	require.EqualValues(t, 1, calls.Load())
+
+	close(release)
+	require.Eventually(t, func() bool { return calls.Load() >= 3 }, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.EqualValues(t, 1, maxRunning.Load())
+}
+
+func TestSchedulerStopOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(testlog.SetLogger(t).WithContext(context.Background()))
+
+	var mu sync.Mutex
+	var stopped []string
+	schedule := func(name string) Schedule {
+		return Schedule{
+			Name:       name,
+			Interval:   time.Hour,
+			RunOnStart: true,
+			WorkFn: func(ctx context.Context) error {
+				// the executions in progress return before the schedule is stopped
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			StopFn: func(ctx context.Context) error {
+				require.NoError(t, ctx.Err(), "the stop context is not cancelled")
+				mu.Lock()
+				defer mu.Unlock()
+				stopped = append(stopped, name)
+				return nil
+			},
+		}
+	}
+	reg := monitoring.NewRegistry()
+	sched, err := New([]Schedule{schedule("first"), schedule("second"), schedule("third")}, WithStats(reg))
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- sched.Run(ctx)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	require.Equal(t, []string{"first", "second", "third"}, stopped)
+
+	// the failed executions are recorded in the stats
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	require.EqualValues(t, 1, snapshot.Ints["first.runs"])
+	require.EqualValues(t, 1, snapshot.Ints["first.failures"])
+	require.Equal(t, context.Canceled.Error(), snapshot.Strings["first.last_error"])
+	require.NotEmpty(t, snapshot.Strings["first.last_run"])
+}
+
+func TestSchedulerInvalidSchedules(t *testing.T) {
+	_, err := New([]Schedule{{Name: "jitter", Jitter: 1}})
+	require.ErrorContains(t, err, "invalid jitter")
+
+	_, err = New([]Schedule{{Name: "duplicate"}, {Name: "duplicate"}})
+	require.ErrorContains(t, err, "duplicate schedule")
+}
+
+func TestIntervalWithSplay(t *testing.T) {
+	sched, err := New(nil)
+	require.NoError(t, err)
+	for range 100 {
+		d := sched.intervalWithSplay(time.Minute, 0.5)
+		require.GreaterOrEqual(t, d, 30*time.Second)
+		require.LessOrEqual(t, d, 90*time.Second)
+
+		d = sched.intervalWithSplay(time.Minute, 0)
+		require.GreaterOrEqual(t, d, 54*time.Second)
+		require.LessOrEqual(t, d, 66*time.Second)
+	}
+}
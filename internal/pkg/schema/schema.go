@@ -0,0 +1,168 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package schema validates the decoded API requests with the validate tags of their fields.
+// The request types are generated from the OpenAPI spec, the tags are set with the x-oapi-codegen-extra-tags
+// extension of their properties.
+//
+// The rules of a tag are separated by commas:
+//   - required: the field is set, it is not a nil pointer, an empty string, slice or map or a zero value.
+//   - max=N: a string is at most N bytes long, a slice or a map has at most N elements.
+//   - oneof=A B: a string is one of the space separated values.
+//
+// The rules of a pointer apply to the value it points to, a nil pointer only fails the required rule.
+// The fields of the nested structs, and of the structs of slices, are validated too.
+package schema
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FieldError is the first rule of a request that a field fails.
+type FieldError struct {
+	// Field is the JSON path of the field, such as upgrade_details.target_version.
+	Field string
+	// Rule is the name of the failed rule and Param its parameter.
+	Rule  string
+	Param string
+
+	// elements is set when a max rule is failed by a slice or a map
+	elements bool
+}
+
+func (e *FieldError) Error() string {
+	switch e.Rule {
+	case "required":
+		return e.Field + " missing"
+	case "max":
+		if e.elements {
+			return fmt.Sprintf("%s has more than %s elements", e.Field, e.Param)
+		}
+		return fmt.Sprintf("%s is longer than %s bytes", e.Field, e.Param)
+	case "oneof":
+		return fmt.Sprintf("%s is not one of %s", e.Field, e.Param)
+	}
+	return fmt.Sprintf("%s fails %s=%s", e.Field, e.Rule, e.Param)
+}
+
+// Validate returns a *FieldError for the first field of v, a struct or a pointer to a struct, that fails a rule of
+// its validate tag. It panics on an unknown rule or an invalid parameter, as the tags are set by the spec.
+func Validate(v any) error {
+	return validateValue(reflect.ValueOf(v), "")
+}
+
+func validateValue(v reflect.Value, path string) error {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			fieldPath := join(path, jsonName(f))
+			if err := validateField(v.Field(i), fieldPath, f.Tag.Get("validate")); err != nil {
+				return err
+			}
+			if err := validateValue(v.Field(i), fieldPath); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if !hasFields(v.Type().Elem()) {
+			// the bytes of a json.RawMessage or the strings of a list have no tags
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), path+"."+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateField checks the rules of tag on the value v of the field at path.
+func validateField(v reflect.Value, path, tag string) error {
+	if tag == "" {
+		return nil
+	}
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		if name == "required" {
+			if isEmpty(v) {
+				return &FieldError{Field: path, Rule: name}
+			}
+			continue
+		}
+		for v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.Elem()
+		}
+		switch name {
+		case "max":
+			limit, err := strconv.Atoi(param)
+			if err != nil {
+				panic(fmt.Sprintf("schema: invalid max parameter of %s: %q", path, param))
+			}
+			if v.Len() > limit {
+				return &FieldError{Field: path, Rule: name, Param: param, elements: v.Kind() != reflect.String}
+			}
+		case "oneof":
+			if !slices.Contains(strings.Fields(param), v.String()) {
+				return &FieldError{Field: path, Rule: name, Param: strings.Join(strings.Fields(param), ", ")}
+			}
+		default:
+			panic(fmt.Sprintf("schema: unknown rule of %s: %q", path, rule))
+		}
+	}
+	return nil
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// hasFields returns true if the values of type t can hold struct fields.
+func hasFields(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Array:
+		return true
+	}
+	return false
+}
+
+// jsonName returns the JSON name of the field f.
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return f.Name
+	}
+	return name
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
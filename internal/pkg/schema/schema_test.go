@@ -0,0 +1,86 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package schema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testDetails struct {
+	ActionID string  `json:"action_id" validate:"required,max=8"`
+	Version  *string `json:"version,omitempty" validate:"max=4"`
+}
+
+type testRequest struct {
+	Status   string          `json:"status" validate:"required"`
+	Mode     string          `json:"mode,omitempty" validate:"oneof=fast slow"`
+	Message  string          `json:"message" validate:"max=16"`
+	Tags     []string        `json:"tags,omitempty" validate:"max=2"`
+	Details  *testDetails    `json:"details,omitempty"`
+	Items    []testDetails   `json:"items,omitempty"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+func TestValidate(t *testing.T) {
+	version := func(s string) *string { return &s }
+	tests := []struct {
+		name string
+		req  testRequest
+		err  string
+	}{{
+		name: "valid",
+		req:  testRequest{Status: "online", Mode: "fast", Message: "ok", Tags: []string{"a"}, Details: &testDetails{ActionID: "a-1", Version: version("9.1")}, Metadata: json.RawMessage(`{}`)},
+	}, {
+		name: "required",
+		req:  testRequest{Mode: "fast"},
+		err:  "status missing",
+	}, {
+		name: "oneof",
+		req:  testRequest{Status: "online", Mode: "idle"},
+		err:  "mode is not one of fast, slow",
+	}, {
+		name: "max bytes",
+		req:  testRequest{Status: "online", Mode: "slow", Message: strings.Repeat("m", 17)},
+		err:  "message is longer than 16 bytes",
+	}, {
+		name: "max elements",
+		req:  testRequest{Status: "online", Mode: "slow", Tags: []string{"a", "b", "c"}},
+		err:  "tags has more than 2 elements",
+	}, {
+		name: "nested struct",
+		req:  testRequest{Status: "online", Mode: "slow", Details: &testDetails{ActionID: "a-1", Version: version("9.1.0")}},
+		err:  "details.version is longer than 4 bytes",
+	}, {
+		name: "struct of a slice",
+		req:  testRequest{Status: "online", Mode: "slow", Items: []testDetails{{ActionID: "a-1"}, {}}},
+		err:  "items.1.action_id missing",
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(&tc.req)
+			if tc.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			var fErr *FieldError
+			require.ErrorAs(t, err, &fErr)
+			require.EqualError(t, err, tc.err)
+		})
+	}
+
+	t.Run("unknown rule", func(t *testing.T) {
+		require.Panics(t, func() {
+			_ = Validate(struct {
+				Name string `validate:"min=1"`
+			}{})
+		})
+	})
+}
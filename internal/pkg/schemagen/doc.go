@@ -0,0 +1,15 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package schemagen generates the model structs, the dl field name constants and the index mappings
+// of the fleet documents from the JSON schema files in model/schema.
+//
+// Each file describes one document type, the type name is derived from the file name. The schema
+// extensions used by the generator are:
+//   - x-index: the index of the documents, used in the comment of the mapping.
+//   - x-field-name: the name of the dl constant of a property, without the Field prefix, when the default one is taken.
+//   - x-mapping: the mapping of a property when the one derived from its type does not fit.
+//
+// The files are generated with go generate, see dev-tools/schemagen.
+package schemagen
@@ -0,0 +1,428 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package schemagen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Paths of the schema directory and of the generated files, relative to the repository root.
+const (
+	SchemaDir    = "model/schema"
+	ModelDir     = "internal/pkg/model"
+	FieldsFile   = "internal/pkg/dl/fields.gen.go"
+	MappingsFile = "internal/pkg/es/mapping.gen.go"
+)
+
+const header = `// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Code generated by schemagen. DO NOT EDIT.
+
+`
+
+// esFields are the document metadata fields, they are held by model.ESDocument and are not part of the mapping.
+var esFields = []string{"_id", "_seq_no", "_version"}
+
+// wordMap is the casing of the words of the go names that are not title cased.
+var wordMap = map[string]string{"Api": "API", "Id": "ID"}
+
+type node struct {
+	Ref                  string           `json:"$ref"`
+	Title                string           `json:"title"`
+	Description          string           `json:"description"`
+	Type                 string           `json:"type"`
+	Format               string           `json:"format"`
+	Items                *node            `json:"items"`
+	Properties           map[string]*node `json:"properties"`
+	AdditionalProperties *node            `json:"additionalProperties"`
+	Required             []string         `json:"required"`
+	Definitions          map[string]*node `json:"definitions"`
+
+	XIndex     string          `json:"x-index"`
+	XFieldName string          `json:"x-field-name"`
+	XMapping   json.RawMessage `json:"x-mapping"`
+}
+
+// schema is a document schema with the name of its go type.
+type schema struct {
+	name string
+	file string
+	root *node
+}
+
+type generator struct {
+	root  string
+	files map[string]*node
+}
+
+// Generate reads the schema files of root and returns the content of the generated files by their path relative to root.
+func Generate(root string) (map[string][]byte, error) {
+	g := &generator{root: root, files: make(map[string]*node)}
+
+	paths, err := filepath.Glob(filepath.Join(root, SchemaDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no schema found in %s", filepath.Join(root, SchemaDir))
+	}
+	slices.Sort(paths)
+
+	schemas := make([]schema, 0, len(paths))
+	for _, p := range paths {
+		base := filepath.Base(p)
+		file := path.Join(SchemaDir, base)
+		n, err := g.load(file)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, schema{name: goName(strings.TrimSuffix(base, ".json")), file: file, root: n})
+	}
+
+	out := make(map[string][]byte, len(schemas)+2)
+	for _, s := range schemas {
+		src, err := g.model(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.file, err)
+		}
+		out[path.Join(ModelDir, strings.ToLower(s.name)+".gen.go")] = src
+	}
+	if out[FieldsFile], err = g.fields(schemas); err != nil {
+		return nil, err
+	}
+	if out[MappingsFile], err = g.mappings(schemas); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Write writes the generated files to root.
+func Write(root string, files map[string][]byte) error {
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(name)), src, 0o644); err != nil { //nolint:gosec // generated sources are not secret
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *generator) load(file string) (*node, error) {
+	if n, ok := g.files[file]; ok {
+		return n, nil
+	}
+	b, err := os.ReadFile(filepath.Join(g.root, filepath.FromSlash(file)))
+	if err != nil {
+		return nil, err
+	}
+	var n node
+	if err := json.Unmarshal(b, &n); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	g.files[file] = &n
+	return &n, nil
+}
+
+// resolve returns the definition referenced by ref from file, with its name and the file it is defined in.
+func (g *generator) resolve(file, ref string) (string, *node, string, error) {
+	target, fragment, ok := strings.Cut(ref, "#")
+	if !ok || !strings.HasPrefix(fragment, "/definitions/") {
+		return "", nil, "", fmt.Errorf("unsupported reference %q", ref)
+	}
+	if target != "" {
+		file = path.Join(path.Dir(file), target)
+	}
+	doc, err := g.load(file)
+	if err != nil {
+		return "", nil, "", err
+	}
+	name := strings.TrimPrefix(fragment, "/definitions/")
+	def, ok := doc.Definitions[name]
+	if !ok {
+		return "", nil, "", fmt.Errorf("reference %q not found", ref)
+	}
+	return name, def, file, nil
+}
+
+// goName returns the go name of a schema name, "default_api_key_id" is DefaultAPIKeyID.
+func goName(s string) string {
+	var sb strings.Builder
+	for _, w := range strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		w = strings.ToUpper(w[:1]) + w[1:]
+		if m, ok := wordMap[w]; ok {
+			w = m
+		}
+		sb.WriteString(w)
+	}
+	return sb.String()
+}
+
+type structField struct {
+	name string
+	typ  string
+	tag  string
+	desc string
+}
+
+type structType struct {
+	name   string
+	desc   string
+	esdoc  bool
+	fields []structField
+}
+
+type modelGen struct {
+	*generator
+	file  string
+	types []*structType
+	json  bool
+}
+
+// model returns the go source of the types of s.
+func (g *generator) model(s schema) ([]byte, error) {
+	m := &modelGen{generator: g, file: s.file}
+	if _, err := m.structType(s.name, s.root); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(m.types, func(a, b *structType) int { return strings.Compare(a.name, b.name) })
+
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	buf.WriteString("package model\n\n")
+	if m.json {
+		buf.WriteString("import \"encoding/json\"\n\n")
+	}
+	for _, t := range m.types {
+		fmt.Fprintf(&buf, "// %s\n", strings.TrimSpace(t.name+" "+t.desc))
+		fmt.Fprintf(&buf, "type %s struct {\n", t.name)
+		if t.esdoc {
+			buf.WriteString("ESDocument\n")
+		}
+		for i, f := range t.fields {
+			if f.desc != "" {
+				if i > 0 || t.esdoc {
+					buf.WriteString("\n")
+				}
+				fmt.Fprintf(&buf, "// %s\n", f.desc)
+			}
+			fmt.Fprintf(&buf, "%s %s `json:\"%s\"`\n", f.name, f.typ, f.tag)
+		}
+		buf.WriteString("}\n\n")
+	}
+	return format.Source(buf.Bytes())
+}
+
+// structType adds the struct of the object n to the generated types.
+func (m *modelGen) structType(name string, n *node) (*structType, error) {
+	t := &structType{name: name, desc: n.Description}
+	m.types = append(m.types, t)
+	for prop, p := range n.Properties {
+		if slices.Contains(esFields, prop) {
+			t.esdoc = true
+			continue
+		}
+		fname := goName(prop)
+		typ, err := m.goType(fname, p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", prop, err)
+		}
+		tag := prop
+		if !slices.Contains(n.Required, prop) {
+			tag += ",omitempty"
+		}
+		t.fields = append(t.fields, structField{name: fname, typ: typ, tag: tag, desc: p.Description})
+	}
+	slices.SortFunc(t.fields, func(a, b structField) int { return strings.Compare(a.name, b.name) })
+	return t, nil
+}
+
+// goType returns the go type of the property n, the inline objects are named after the property name.
+func (m *modelGen) goType(name string, n *node) (string, error) {
+	if n.Ref != "" {
+		ref, def, _, err := m.resolve(m.file, n.Ref)
+		if err != nil {
+			return "", err
+		}
+		// The referenced types are generated in schema.go.
+		if def.Type == "array" {
+			return "[]" + goName(ref) + "Items", nil
+		}
+		return "*" + goName(ref), nil
+	}
+	if n.Format == "raw" {
+		m.json = true
+		return "json.RawMessage", nil
+	}
+	switch n.Type {
+	case "string":
+		return "string", nil
+	case "integer":
+		return "int64", nil
+	case "number":
+		return "float64", nil
+	case "boolean":
+		return "bool", nil
+	case "array":
+		if n.Items == nil {
+			return "", fmt.Errorf("array without items")
+		}
+		if n.Items.Type == "object" && n.Items.Ref == "" && n.Items.AdditionalProperties == nil {
+			if _, err := m.structType(name+"Items", n.Items); err != nil {
+				return "", err
+			}
+			return "[]" + name + "Items", nil
+		}
+		typ, err := m.goType(name+"Items", n.Items)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + typ, nil
+	case "object":
+		if n.AdditionalProperties != nil {
+			typ, err := m.goType(name+"Value", n.AdditionalProperties)
+			if err != nil {
+				return "", err
+			}
+			return "map[string]" + typ, nil
+		}
+		if _, err := m.structType(name, n); err != nil {
+			return "", err
+		}
+		return "*" + name, nil
+	}
+	return "", fmt.Errorf("unsupported type %q", n.Type)
+}
+
+// fields returns the go source of the dl constants of the fields of the documents.
+func (g *generator) fields(schemas []schema) ([]byte, error) {
+	consts := make(map[string]string)
+	for _, s := range schemas {
+		for prop, p := range s.root.Properties {
+			if slices.Contains(esFields, prop) {
+				continue
+			}
+			name := p.XFieldName
+			if name == "" {
+				name = goName(prop)
+			}
+			name = "Field" + name
+			if v, ok := consts[name]; ok && v != prop {
+				return nil, fmt.Errorf("%s: %s is both %q and %q, set x-field-name", s.file, name, v, prop)
+			}
+			consts[name] = prop
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	buf.WriteString("package dl\n\n")
+	fmt.Fprintf(&buf, "// Document fields of the %s schemas\n", SchemaDir)
+	buf.WriteString("const (\n")
+	for _, name := range slices.Sorted(maps.Keys(consts)) {
+		fmt.Fprintf(&buf, "%s = %q\n", name, consts[name])
+	}
+	buf.WriteString(")\n")
+	return format.Source(buf.Bytes())
+}
+
+// mappings returns the go source of the es mapping constants of the documents.
+func (g *generator) mappings(schemas []schema) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	buf.WriteString("package es\n\n")
+	for _, s := range schemas {
+		props, err := g.properties(s.file, s.root)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.file, err)
+		}
+		b, err := json.MarshalIndent(map[string]any{"properties": props}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if s.root.XIndex != "" {
+			fmt.Fprintf(&buf, "// Mapping%s is the mapping of the %s index.\n", s.name, s.root.XIndex)
+		} else {
+			fmt.Fprintf(&buf, "// Mapping%s is the mapping of the %s documents.\n", s.name, s.name)
+		}
+		fmt.Fprintf(&buf, "const Mapping%s = `%s`\n\n", s.name, b)
+	}
+	return format.Source(buf.Bytes())
+}
+
+func (g *generator) properties(file string, n *node) (map[string]any, error) {
+	props := make(map[string]any, len(n.Properties))
+	for prop, p := range n.Properties {
+		if slices.Contains(esFields, prop) {
+			continue
+		}
+		m, err := g.mapping(file, p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", prop, err)
+		}
+		props[prop] = m
+	}
+	return props, nil
+}
+
+// mapping returns the es mapping of the property n of file.
+func (g *generator) mapping(file string, n *node) (any, error) {
+	if len(n.XMapping) > 0 {
+		var m any
+		err := json.Unmarshal(n.XMapping, &m)
+		return m, err
+	}
+	if n.Ref != "" {
+		_, def, defFile, err := g.resolve(file, n.Ref)
+		if err != nil {
+			return nil, err
+		}
+		return g.mapping(defFile, def)
+	}
+	if n.Format == "raw" {
+		return map[string]any{"type": "object", "enabled": false}, nil
+	}
+	switch n.Type {
+	case "string":
+		if n.Format == "date-time" {
+			return map[string]any{"type": "date"}, nil
+		}
+		return map[string]any{"type": "keyword"}, nil
+	case "integer":
+		return map[string]any{"type": "long"}, nil
+	case "number":
+		return map[string]any{"type": "double"}, nil
+	case "boolean":
+		return map[string]any{"type": "boolean"}, nil
+	case "array":
+		if n.Items == nil {
+			return nil, fmt.Errorf("array without items")
+		}
+		return g.mapping(file, n.Items)
+	case "object":
+		if n.AdditionalProperties != nil {
+			return map[string]any{"type": "object", "dynamic": true}, nil
+		}
+		if len(n.Properties) == 0 {
+			return map[string]any{"type": "object", "enabled": false}, nil
+		}
+		props, err := g.properties(file, n)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"properties": props}, nil
+	}
+	return nil, fmt.Errorf("no mapping for type %q, set x-mapping", n.Type)
+}
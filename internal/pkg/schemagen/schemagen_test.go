@@ -0,0 +1,56 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package schemagen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const repoRoot = "../../.."
+
+// TestGeneratedFilesUpToDate fails when the checked in files are not the ones generated from model/schema.
+func TestGeneratedFilesUpToDate(t *testing.T) {
+	files, err := Generate(repoRoot)
+	require.NoError(t, err)
+	require.Contains(t, files, FieldsFile)
+	require.Contains(t, files, MappingsFile)
+
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(repoRoot, filepath.FromSlash(name)))
+		require.NoError(t, err, "%s is missing, run go generate", name)
+		assert.Equal(t, string(want), string(got), "%s is out of date, run go generate", name)
+	}
+}
+
+func TestGoName(t *testing.T) {
+	tests := map[string]string{
+		"agent":                 "Agent",
+		"default_api_key_id":    "DefaultAPIKeyID",
+		"@timestamp":            "Timestamp",
+		"to_retire_api_key_ids": "ToRetireAPIKeyIds",
+		"agent-metadata":        "AgentMetadata",
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, goName(in), in)
+	}
+}
+
+func TestGenerateConflictingFields(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, filepath.FromSlash(SchemaDir))
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.json"), []byte(`{"type":"object","properties":{"hash":{"type":"string"}}}`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"type":"object","properties":{"other":{"type":"string","x-field-name":"Hash"}}}`), 0o600))
+
+	_, err := Generate(root)
+	require.ErrorContains(t, err, "FieldHash")
+}
@@ -0,0 +1,60 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package seen
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// hllPrecision is the number of bits of the hash that select a register, the standard error is 1.04/sqrt(2^p), 0.8%.
+	hllPrecision = 14
+	hllRegisters = 1 << hllPrecision
+)
+
+// hll is a HyperLogLog sketch that estimates the number of distinct hashes added to it in a constant 16KiB.
+type hll struct {
+	registers [hllRegisters]uint8
+}
+
+func (h *hll) add(hash uint64) {
+	idx := hash >> (64 - hllPrecision)
+	// the rank is the position of the first set bit in the remaining bits, the guard bit bounds it
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1))) + 1 //nolint:gosec // at most 64-p+1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// merge sets the registers of h to the union of h and o.
+func (h *hll) merge(o *hll) {
+	for i, r := range o.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+// estimate returns the estimated number of distinct hashes.
+func (h *hll) estimate() uint64 {
+	const m = float64(hllRegisters)
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := alpha * m * m / sum
+	// linear counting is more accurate for small cardinalities
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
@@ -0,0 +1,153 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package seen
+
+import (
+	"fmt"
+	"hash/maphash"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+const (
+	bucketSpan = time.Minute
+	numBuckets = 15
+
+	// defaultExactThreshold is the number of agents a bucket keeps in a set, above it the bucket only keeps its sketch.
+	defaultExactThreshold = 4096
+)
+
+// Windows are the periods over which the distinct agents are counted.
+var Windows = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute}
+
+// bucket holds the agents seen during one minute.
+type bucket struct {
+	minute int64
+	exact  map[uint64]struct{} // nil once the bucket has more than exactThreshold agents
+	sketch *hll
+}
+
+// Tracker counts the distinct agents seen during the last 1, 5 and 15 minutes.
+// The count is exact while the agents of the window fit in the sets of the buckets,
+// and estimated with HyperLogLog sketches above that, so the memory stays bounded for any number of agents.
+type Tracker struct {
+	seed           maphash.Seed
+	exactThreshold int
+	now            func() time.Time
+
+	mu      sync.Mutex
+	buckets [numBuckets]bucket
+}
+
+// NewTracker returns an empty tracker.
+func NewTracker() *Tracker {
+	return &Tracker{
+		seed:           maphash.MakeSeed(),
+		exactThreshold: defaultExactThreshold,
+		now:            time.Now,
+	}
+}
+
+// Add records that the agent id was seen.
+// It is safe to call on a nil Tracker.
+func (t *Tracker) Add(id string) {
+	if t == nil {
+		return
+	}
+	hash := maphash.String(t.seed, id)
+	minute := t.now().Unix() / int64(bucketSpan/time.Second)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := &t.buckets[minute%numBuckets]
+	if b.minute != minute || b.sketch == nil {
+		b.minute = minute
+		b.exact = make(map[uint64]struct{})
+		b.sketch = &hll{}
+	}
+	b.sketch.add(hash)
+	if b.exact != nil {
+		b.exact[hash] = struct{}{}
+		if len(b.exact) > t.exactThreshold {
+			b.exact = nil
+		}
+	}
+}
+
+// Count returns the number of distinct agents seen during the last window, rounded up to the minute.
+func (t *Tracker) Count(window time.Duration) uint64 {
+	if t == nil {
+		return 0
+	}
+	n := int64(window / bucketSpan)
+	if n < 1 {
+		n = 1
+	} else if n > numBuckets {
+		n = numBuckets
+	}
+	minute := t.now().Unix() / int64(bucketSpan/time.Second)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var active []*bucket
+	exact := true
+	total := 0
+	for i := range t.buckets {
+		b := &t.buckets[i]
+		if b.sketch == nil || b.minute <= minute-n || b.minute > minute {
+			continue
+		}
+		active = append(active, b)
+		if b.exact == nil {
+			exact = false
+		} else {
+			total += len(b.exact)
+		}
+	}
+	if len(active) == 0 {
+		return 0
+	}
+	if exact && total <= t.exactThreshold {
+		union := make(map[uint64]struct{}, total)
+		for _, b := range active {
+			for h := range b.exact {
+				union[h] = struct{}{}
+			}
+		}
+		return uint64(len(union))
+	}
+	var merged hll
+	for _, b := range active {
+		merged.merge(b.sketch)
+	}
+	return merged.estimate()
+}
+
+// Counts returns the number of distinct agents seen during each of the Windows.
+func (t *Tracker) Counts() []uint64 {
+	counts := make([]uint64, len(Windows))
+	for i, w := range Windows {
+		counts[i] = t.Count(w)
+	}
+	return counts
+}
+
+// WindowName returns the name of the window in the stats and in the documents, such as "5m".
+func WindowName(w time.Duration) string {
+	return fmt.Sprintf("%dm", int(w/time.Minute))
+}
+
+// Register registers the counts as the "distinct" namespace of reg.
+func (t *Tracker) Register(reg *monitoring.Registry) {
+	monitoring.NewFunc(reg, "distinct", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		for i, c := range t.Counts() {
+			monitoring.ReportInt(v, WindowName(Windows[i]), int64(c)) //nolint:gosec // disable G115
+		}
+	})
+}
@@ -0,0 +1,126 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package seen
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestTracker() (*Tracker, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)}
+	t := NewTracker()
+	t.now = clock.now
+	return t, clock
+}
+
+func TestTrackerExactCounts(t *testing.T) {
+	tr, clock := newTestTracker()
+
+	// minute 0: agents 0-9, seen twice
+	for i := 0; i < 20; i++ {
+		tr.Add(fmt.Sprintf("agent-%d", i%10))
+	}
+	require.Equal(t, []uint64{10, 10, 10}, tr.Counts())
+
+	// minute 3: agents 5-14
+	clock.t = clock.t.Add(3 * time.Minute)
+	for i := 5; i < 15; i++ {
+		tr.Add(fmt.Sprintf("agent-%d", i))
+	}
+	require.Equal(t, []uint64{10, 15, 15}, tr.Counts())
+
+	// minute 7: the first minute is out of the 5m window
+	clock.t = clock.t.Add(4 * time.Minute)
+	tr.Add("agent-100")
+	require.Equal(t, []uint64{1, 11, 16}, tr.Counts())
+
+	// minute 22: everything expired
+	clock.t = clock.t.Add(15 * time.Minute)
+	require.Equal(t, []uint64{0, 0, 0}, tr.Counts())
+
+	// a bucket reused after 15 minutes starts empty
+	tr.Add("agent-200")
+	require.Equal(t, []uint64{1, 1, 1}, tr.Counts())
+}
+
+func TestTrackerNil(t *testing.T) {
+	var tr *Tracker
+	tr.Add("agent-1")
+	require.Equal(t, []uint64{0, 0, 0}, tr.Counts())
+}
+
+func TestTrackerLargeScale(t *testing.T) {
+	const agents = 500000
+	tr, clock := newTestTracker()
+
+	// the agents check in spread over 5 minutes, every agent once per minute in the last 2 minutes
+	for m := 0; m < 5; m++ {
+		for i := 0; i < agents/5; i++ {
+			tr.Add(fmt.Sprintf("agent-%d", m*agents/5+i))
+		}
+		if m >= 3 {
+			for i := 0; i < agents; i++ {
+				tr.Add(fmt.Sprintf("agent-%d", i))
+			}
+		}
+		clock.t = clock.t.Add(time.Minute)
+	}
+	clock.t = clock.t.Add(-time.Minute)
+
+	counts := tr.Counts()
+	require.InEpsilon(t, agents, counts[0], 0.03)
+	require.InEpsilon(t, agents, counts[1], 0.03)
+	require.InEpsilon(t, agents, counts[2], 0.03)
+
+	// the memory is bounded by one sketch per bucket, the sets of the large buckets are dropped
+	for i := range tr.buckets {
+		b := &tr.buckets[i]
+		if b.sketch != nil {
+			require.Nil(t, b.exact, "bucket %d", i)
+		}
+	}
+}
+
+func TestHLLAccuracy(t *testing.T) {
+	tr := NewTracker()
+	for _, n := range []int{100, 5000, 100000} {
+		var h hll
+		for i := 0; i < n; i++ {
+			h.add(hashOf(tr, fmt.Sprintf("id-%d-%d", n, i)))
+		}
+		got := float64(h.estimate())
+		require.Less(t, math.Abs(got-float64(n))/float64(n), 0.03, "n=%d estimate=%v", n, got)
+	}
+}
+
+func TestTrackerRegister(t *testing.T) {
+	tr, _ := newTestTracker()
+	tr.Add("agent-1")
+	tr.Add("agent-2")
+
+	reg := monitoring.NewRegistry()
+	tr.Register(reg)
+	snapshot := monitoring.CollectStructSnapshot(reg, monitoring.Full, false)
+	require.Equal(t, map[string]any{"1m": int64(2), "5m": int64(2), "15m": int64(2)}, snapshot["distinct"])
+}
+
+func hashOf(t *Tracker, id string) uint64 {
+	return maphash.String(t.seed, id)
+}
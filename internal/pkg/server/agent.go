@@ -584,6 +584,7 @@ func injectMissingOutputAttributes(ctx context.Context, outMap, bootstrap map[st
 		"proxy_url",
 		"proxy_disable",
 		"proxy_headers",
+		"proxy_bypass",
 	}
 	// keys that will appear under the "ssl" key
 	bootstrapSSLKeys := []string{
@@ -703,7 +704,7 @@ func (a *Agent) esOutputCheck(ctx context.Context, data map[string]interface{})
 			},
 		},
 		false,
-		elasticsearchOptions(false, a.bi)..., // disable instrumentation for output config test
+		elasticsearchOptions(false, a.bi, nil)..., // disable instrumentation for output config test
 	)
 	if err != nil {
 		return err
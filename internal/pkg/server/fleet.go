@@ -18,22 +18,35 @@ import (
 	apmtransport "go.elastic.co/apm/v2/transport"
 
 	"github.com/elastic/elastic-agent-client/v7/pkg/client"
+	libapi "github.com/elastic/elastic-agent-libs/api"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/go-elasticsearch/v8"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/action"
 	"github.com/elastic/fleet-server/v7/internal/pkg/api"
+	"github.com/elastic/fleet-server/v7/internal/pkg/audittrail"
 	"github.com/elastic/fleet-server/v7/internal/pkg/build"
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
 	"github.com/elastic/fleet-server/v7/internal/pkg/cache"
 	"github.com/elastic/fleet-server/v7/internal/pkg/checkin"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/drain"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/esclock"
 	"github.com/elastic/fleet-server/v7/internal/pkg/gc"
+	"github.com/elastic/fleet-server/v7/internal/pkg/instance"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
 	"github.com/elastic/fleet-server/v7/internal/pkg/monitor"
+	"github.com/elastic/fleet-server/v7/internal/pkg/operation"
 	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
 	"github.com/elastic/fleet-server/v7/internal/pkg/profile"
 	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+	"github.com/elastic/fleet-server/v7/internal/pkg/seen"
+	"github.com/elastic/fleet-server/v7/internal/pkg/setup"
+	"github.com/elastic/fleet-server/v7/internal/pkg/signal"
 	"github.com/elastic/fleet-server/v7/internal/pkg/state"
+	"github.com/elastic/fleet-server/v7/internal/pkg/usage"
 	"github.com/elastic/fleet-server/v7/internal/pkg/ver"
 
 	"github.com/hashicorp/go-version"
@@ -48,14 +61,24 @@ type Fleet struct {
 	standAlone bool
 	bi         build.Info
 	verCon     version.Constraints
+	serverVer  *version.Version
 
 	cfgCh    chan *config.Config
 	cache    cache.Cache
 	reporter state.Reporter
+	stats    *monitoring.Registry
+	monAuth  *api.MonitoringAuth
+	drain    *drain.State
+	proxy    *es.ProxyHealth
 
 	// Used for diagnostics reporting
 	l   sync.RWMutex
 	cfg *config.Config
+
+	// reloaders apply the server settings of a reloaded configuration to the running handlers, srvCfg is the
+	// last configuration applied. Both are guarded by l and reset when the server is restarted.
+	reloaders []api.Reloader
+	srvCfg    *config.Server
 }
 
 // NewFleet creates the actual fleet server service.
@@ -64,13 +87,26 @@ func NewFleet(bi build.Info, reporter state.Reporter, standAlone bool) (*Fleet,
 	if err != nil {
 		return nil, err
 	}
+	serverVer, err := api.ParseVersion(bi.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := newStatsRegistry()
+	d := drain.New()
+	d.Register(stats)
 
 	return &Fleet{
 		standAlone: standAlone,
 		bi:         bi,
 		verCon:     verCon,
+		serverVer:  serverVer,
 		cfgCh:      make(chan *config.Config, 1),
 		reporter:   reporter,
+		stats:      stats,
+		monAuth:    api.NewMonitoringAuth(),
+		drain:      d,
+		proxy:      &es.ProxyHealth{},
 	}, nil
 }
 
@@ -93,6 +129,7 @@ func (f *Fleet) Run(ctx context.Context, initCfg *config.Config) error {
 	// that were started in the scope of this function on function exit
 	ctx, cn := context.WithCancel(ctx)
 	defer cn()
+	go f.runDrain(ctx, initCfg, cn)
 
 	log := zerolog.Ctx(ctx)
 	err := initCfg.LoadServerLimits(log)
@@ -101,7 +138,7 @@ func (f *Fleet) Run(ctx context.Context, initCfg *config.Config) error {
 	}
 	cacheCfg := config.CopyCache(initCfg)
 	log.Info().Interface("cfg", cacheCfg).Msg("Setting cache config options")
-	cache, err := cache.New(cacheCfg, cache.WithLog(log))
+	cache, err := cache.New(cacheCfg, cache.WithLog(log), cache.WithStats(f.subsystemStats("cache")))
 	if err != nil {
 		return err
 	}
@@ -169,6 +206,13 @@ LOOP:
 			}
 		}
 
+		// Configure the authentication of the metrics endpoint
+		if curCfg == nil || curCfg.HTTP.Auth != newCfg.HTTP.Auth {
+			if err := f.monAuth.Configure(newCfg.HTTP.Auth); err != nil {
+				return err
+			}
+		}
+
 		// Start or restart profiler
 		if configChangedProfiler(curCfg, newCfg) {
 			if proCancel != nil {
@@ -184,7 +228,7 @@ LOOP:
 			}
 		}
 
-		// Start or restart server
+		// Start or restart server, the settings of config.Server.ApplyInPlace are applied to the running server
 		if configChangedServer(*log, curCfg, newCfg) {
 			if srvCancel != nil {
 				log.Info().Msg("stopping server on configuration change")
@@ -196,10 +240,16 @@ LOOP:
 					log.Warn().Msg("Server stopped expected context cancel error missing.")
 				}
 			}
+			f.l.Lock()
+			f.reloaders, f.srvCfg = nil, nil
+			f.l.Unlock()
 			log.Info().Msg("starting server on configuration change")
 			srvEg, srvCancel = start(ctx, func(ctx context.Context, cfg *config.Config) error {
 				return f.runServer(ctx, cfg)
 			}, newCfg, ech)
+		} else if !reflect.DeepEqual(curCfg.Inputs[0].Server, newCfg.Inputs[0].Server) {
+			log.Info().Msg("applying server configuration in place")
+			f.reloadServer(&newCfg.Inputs[0].Server)
 		}
 
 		curCfg = newCfg
@@ -233,6 +283,42 @@ LOOP:
 	return err
 }
 
+// runDrain starts draining when the process receives a SIGUSR1. Once draining, it calls stop after the draining timeout,
+// so an instance that the operator does not shut down does not stay out of rotation forever. It returns when ctx is cancelled.
+func (f *Fleet) runDrain(ctx context.Context, initCfg *config.Config, stop context.CancelFunc) {
+	log := zerolog.Ctx(ctx)
+	usr1 := signal.HandleDrain(ctx)
+WAIT:
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-usr1:
+			f.drain.Start()
+		case <-f.drain.Started():
+			break WAIT
+		}
+	}
+
+	cfg := f.GetConfig()
+	if cfg == nil {
+		cfg = initCfg
+	}
+	timeout := cfg.Inputs[0].Server.Draining.Timeout
+	log.Info().Time("since", f.drain.Since()).Dur("timeout", timeout).Msg("Draining started, waiting for the shutdown")
+	if timeout <= 0 {
+		return
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+		log.Warn().Dur("timeout", timeout).Msg("Draining timeout reached, stopping Fleet Server")
+		stop()
+	}
+}
+
 func configChangedProfiler(curCfg, newCfg *config.Config) bool {
 	changed := true
 
@@ -251,7 +337,7 @@ func configCacheChanged(curCfg, newCfg *config.Config) bool {
 	if curCfg == nil {
 		return false
 	}
-	return curCfg.Inputs[0].Cache != newCfg.Inputs[0].Cache
+	return !reflect.DeepEqual(curCfg.Inputs[0].Cache, newCfg.Inputs[0].Cache)
 }
 
 func configChangedServer(zlog zerolog.Logger, curCfg, newCfg *config.Config) bool {
@@ -263,7 +349,7 @@ func configChangedServer(zlog zerolog.Logger, curCfg, newCfg *config.Config) boo
 		zlog.Info().Msg("fleet configuration has changed")
 	case !reflect.DeepEqual(curCfg.Output, newCfg.Output):
 		zlog.Info().Msg("output configuration has changed")
-	case !reflect.DeepEqual(curCfg.Inputs[0].Server, newCfg.Inputs[0].Server):
+	case !reflect.DeepEqual(curCfg.Inputs[0].Server.ApplyInPlace(&newCfg.Inputs[0].Server), newCfg.Inputs[0].Server):
 		zlog.Info().Msg("server configuration has changed")
 	default:
 		changed = false
@@ -272,6 +358,29 @@ func configChangedServer(zlog zerolog.Logger, curCfg, newCfg *config.Config) boo
 	return changed
 }
 
+// reloadServer applies the server settings of cfg to the handlers of the running server.
+func (f *Fleet) reloadServer(cfg *config.Server) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.srvCfg = cfg
+	for _, r := range f.reloaders {
+		r.Reload(cfg)
+	}
+}
+
+// registerReloaders sets the handlers the reloaded server settings are applied to. The settings reloaded while the
+// server was starting are applied to them.
+func (f *Fleet) registerReloaders(reloaders ...api.Reloader) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.reloaders = reloaders
+	if f.srvCfg != nil {
+		for _, r := range reloaders {
+			r.Reload(f.srvCfg)
+		}
+	}
+}
+
 func safeWait(log *zerolog.Logger, g *errgroup.Group, to time.Duration) error {
 	var err error
 	waitCh := make(chan error)
@@ -289,6 +398,20 @@ func safeWait(log *zerolog.Logger, g *errgroup.Group, to time.Duration) error {
 	return err
 }
 
+// checkpointOpts returns the options of the index monitor of subsystem that save its checkpoint in the checkpoints index,
+// keyed by the id of the fleet-server and the subsystem. The checkpoint is not saved without a fleet-server id or with
+// a zero checkpoint interval.
+func checkpointOpts(cfg *config.Config, esCli *elasticsearch.Client, subsystem string) []monitor.Option {
+	if cfg.Fleet.Agent.ID == "" || cfg.Inputs[0].Monitor.CheckpointInterval <= 0 {
+		return nil
+	}
+	store := monitor.NewESCheckpointStore(esCli, dl.FleetCheckpoints, cfg.Fleet.Agent.ID+":"+subsystem)
+	return []monitor.Option{
+		monitor.WithCheckpointStore(store),
+		monitor.WithCheckpointInterval(cfg.Inputs[0].Monitor.CheckpointInterval),
+	}
+}
+
 func loggedRunFunc(ctx context.Context, tag string, runfn runFunc) func() error {
 	log := zerolog.Ctx(ctx)
 	return func() error {
@@ -333,14 +456,17 @@ func initRuntime(log *zerolog.Logger, cfg *config.Config) {
 
 func (f *Fleet) initBulker(ctx context.Context, tracer *apm.Tracer, cfg *config.Config) (*bulk.Bulker, error) {
 	es, err := es.NewClient(ctx, cfg, false, elasticsearchOptions(
-		cfg.Inputs[0].Server.Instrumentation.Enabled, f.bi,
+		cfg.Inputs[0].Server.Instrumentation.Enabled, f.bi, f.proxy,
 	)...)
 	if err != nil {
 		return nil, err
 	}
+	for _, w := range cfg.Output.Elasticsearch.Warnings() {
+		zerolog.Ctx(ctx).Warn().Msg(w)
+	}
 
 	bulkOpts := bulk.BulkOptsFromCfg(cfg)
-	bulkOpts = append(bulkOpts, bulk.WithBi(f.bi))
+	bulkOpts = append(bulkOpts, bulk.WithBi(f.bi), bulk.WithStats(f.subsystemStats("bulker")))
 	blk := bulk.NewBulker(es, tracer, bulkOpts...)
 	return blk, nil
 }
@@ -355,7 +481,7 @@ func (f *Fleet) runServer(ctx context.Context, cfg *config.Config) (err error) {
 	}
 
 	// The metricsServer is only enabled if http.enabled is set in the config
-	metricsServer, err := api.InitMetrics(ctx, cfg, f.bi, tracer)
+	metricsServer, err := api.InitMetrics(ctx, cfg, f.bi, tracer, f.stats, f.monAuth)
 	switch {
 	case err != nil:
 		return err
@@ -363,6 +489,7 @@ func (f *Fleet) runServer(ctx context.Context, cfg *config.Config) (err error) {
 		defer func() {
 			_ = metricsServer.Stop()
 		}()
+		api.AttachDrainEndpoint(metricsServer, f.drain, f.monAuth)
 	}
 
 	// Bulker is started in its own context and managed in the scope of this function. This is done so
@@ -419,7 +546,7 @@ func (f *Fleet) runServer(ctx context.Context, cfg *config.Config) (err error) {
 		}()
 	}
 
-	if err = f.runSubsystems(ctx, cfg, g, bulker, tracer); err != nil {
+	if err = f.runSubsystems(ctx, cfg, g, bulker, tracer, metricsServer); err != nil {
 		return err
 	}
 
@@ -429,19 +556,53 @@ func (f *Fleet) runServer(ctx context.Context, cfg *config.Config) (err error) {
 // runSubsystems starts  all other subsystems for fleet-server
 // we assume bulker.Run is called in another goroutine, it's ctx is not the same ctx passed into runSubsystems and used with the passed errgroup.
 // however if the bulker returns an error, the passed errgroup is canceled.
-// runSubsystems will also do an ES version check and run migrations if started in agent-mode
+// runSubsystems waits for ES and verifies the ES cluster and privileges unless skipped, it will also do an ES version check and run migrations if started in agent-mode
 // The started subsystems are:
-// - Elasticsearch GC - cleanup expired fleet actions
+// - Scheduler - runs the background jobs: cleanup of expired fleet actions and stale agent upgrades, checkin and delivery flushes, and the heartbeat
 // - Policy Index Monitor - track new documents in the .fleet-policies index
 // - Policy Monitor - parse .fleet-policies docuuments into usable policies
 // - Policy Self Monitor - report fleet-server health status based on .fleet-policies index
 // - Action Monitor - track new documents in the .fleet-actions index
 // - Action Dispatcher - send actions from the .fleet-actions index to agents that check in
-// - Bulk Checkin handler - batches agent checkin messages to _bulk endpoint, minimizes changed attributes
+// - Bulk Checkin handler - batches agent checkin messages to _bulk endpoint, minimizes changed attributes, flushed by the scheduler
+// - Fleet Server Heartbeat - report this instance in the .fleet-servers index, run by the scheduler
 // - HTTP APIs - start http server on 8220 (default) for external agents, and on 8221 (default) for managing agent in agent-mode or local communications.
-func (f *Fleet) runSubsystems(ctx context.Context, cfg *config.Config, g *errgroup.Group, bulker bulk.Bulk, tracer *apm.Tracer) (err error) {
+// The status endpoint is also served on the metricsServer when the metrics endpoint is enabled.
+func (f *Fleet) runSubsystems(ctx context.Context, cfg *config.Config, g *errgroup.Group, bulker bulk.Bulk, tracer *apm.Tracer, metricsServer *libapi.Server) (err error) {
 	esCli := bulker.Client()
 
+	// Elasticsearch may still be starting, the startup steps that need it are retried while the API reports an unhealthy status.
+	// Other errors of the probe, such as rejected credentials, are left to the verification.
+	waiter := newESWaiter(cfg, f.bi, f.reporter)
+	defer waiter.stop()
+	if err := waiter.run(ctx, func(ctx context.Context) error {
+		if _, err := es.FetchESVersion(ctx, esCli); isESNotReady(err) {
+			return err
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := verifyElasticsearch(ctx, esCli, &cfg.Output.Elasticsearch); err != nil {
+		return err
+	}
+	conflicts, err := verifyMappings(ctx, esCli, &cfg.Output.Elasticsearch)
+	if err != nil {
+		return err
+	}
+	// the features writing the indices with incompatible mappings are disabled, the state is reported as degraded
+	disabled := newDisabledFeatures(conflicts)
+	reporter := f.reporter
+	if !disabled.isEmpty() {
+		zerolog.Ctx(ctx).Warn().
+			Strs("indices", disabled.indices).
+			Strs("operations", disabled.operations).
+			Strs("schedules", disabled.schedules).
+			Msg("Features are disabled by the incompatible mappings")
+		reporter = degradedReporter{Reporter: f.reporter, reason: disabled.reason()}
+	}
+
 	// Version check is not performed in standalone mode because it is expected that
 	// standalone Fleet Server may be running with older versions of Elasticsearch.
 	if !f.standAlone {
@@ -460,87 +621,207 @@ func (f *Fleet) runSubsystems(ctx context.Context, cfg *config.Config, g *errgro
 		loggedMigration := loggedRunFunc(ctx, "Migrations", func(ctx context.Context) error {
 			return dl.Migrate(ctx, bulker)
 		})
-		if err = loggedMigration(); err != nil {
+		if err = waiter.run(ctx, func(context.Context) error { return loggedMigration() }); err != nil {
 			return fmt.Errorf("failed to run subsystems: %w", err)
 		}
 	}
 
-	// Run scheduler for periodic GC/cleanup
-	gcCfg := cfg.Inputs[0].Server.GC
-	sched, err := scheduler.New(gc.Schedules(bulker, gcCfg.ScheduleInterval, gcCfg.CleanupAfterExpiredInterval))
-	if err != nil {
-		return fmt.Errorf("failed to create elasticsearch GC: %w", err)
+	// Bootstrap a default policy and enrollment key when running without Kibana.
+	if f.standAlone && cfg.Inputs[0].Server.StandaloneSetup.Enabled {
+		var res *setup.Result
+		err := waiter.run(ctx, func(ctx context.Context) (err error) {
+			res, err = setup.Run(ctx, bulker, cfg)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to run standalone setup: %w", err)
+		}
+		zerolog.Ctx(ctx).Info().
+			Str(logger.PolicyID, res.PolicyID).
+			Str("enrollment_token", res.EnrollmentToken).
+			Msg("Standalone setup enrollment token")
 	}
-	g.Go(loggedRunFunc(ctx, "Elasticsearch GC", sched.Run))
 
 	// Monitoring es client, longer timeout, no retries
 	monCli, err := es.NewClient(ctx, cfg, true, elasticsearchOptions(
-		cfg.Inputs[0].Server.Instrumentation.Enabled, f.bi,
+		cfg.Inputs[0].Server.Instrumentation.Enabled, f.bi, f.proxy,
 	)...)
 	if err != nil {
 		return err
 	}
 
 	// Policy index monitor
-	pim, err := monitor.New(dl.FleetPolicies, esCli, monCli,
+	pim, err := monitor.New(dl.FleetPolicies, esCli, monCli, append(checkpointOpts(cfg, esCli, "policies"),
 		monitor.WithFetchSize(cfg.Inputs[0].Monitor.FetchSize),
 		monitor.WithPollTimeout(cfg.Inputs[0].Monitor.PollTimeout),
 		monitor.WithAPMTracer(tracer),
 		monitor.WithDebounceTime(cfg.Inputs[0].Monitor.PolicyDebounceTime),
-	)
+		monitor.WithStats(f.subsystemStats("policies_monitor")),
+	)...)
 	if err != nil {
 		return err
 	}
 	g.Go(loggedRunFunc(ctx, "Policy index monitor", pim.Run))
 
 	// Policy monitor
-	pm := policy.NewMonitor(bulker, pim, cfg.Inputs[0].Server.Limits)
+	pr := policy.NewReader(bulker, cfg.Inputs[0].Cache.Settings(config.CachePolicyDoc).TTL)
+	if err := f.cache.Register(config.CachePolicyDoc, pr); err != nil {
+		return err
+	}
+	pm := policy.NewMonitor(bulker, pim, cfg.Inputs[0].Server.Limits, policy.WithReader(pr))
 	g.Go(loggedRunFunc(ctx, "Policy monitor", pm.Run))
 
 	// Policy self monitor
 	var sm policy.SelfMonitor
 	if f.standAlone {
-		sm = policy.NewStandAloneSelfMonitor(bulker, f.reporter)
+		sm = policy.NewStandAloneSelfMonitor(bulker, reporter)
 	} else {
-		sm = policy.NewSelfMonitor(cfg.Fleet, bulker, pim, cfg.Inputs[0].Policy.ID, f.reporter)
+		sm = policy.NewSelfMonitor(cfg.Fleet, bulker, pim, cfg.Inputs[0].Policy.ID, reporter)
+	}
+	if !disabled.isEmpty() {
+		sm = degradedMonitor{sm}
 	}
 	g.Go(loggedRunFunc(ctx, "Policy self monitor", sm.Run))
 
 	// Actions monitoring
-	am, err := monitor.NewSimple(dl.FleetActions, esCli, monCli,
+	am, err := monitor.NewSimple(dl.FleetActions, esCli, monCli, append(checkpointOpts(cfg, esCli, "actions"),
 		monitor.WithExpiration(true),
 		monitor.WithFetchSize(cfg.Inputs[0].Monitor.FetchSize),
 		monitor.WithPollTimeout(cfg.Inputs[0].Monitor.PollTimeout),
 		monitor.WithAPMTracer(tracer),
-	)
+		monitor.WithStats(f.subsystemStats("actions_monitor")),
+	)...)
 	if err != nil {
 		return err
 	}
 	g.Go(loggedRunFunc(ctx, "Action monitor", am.Run))
 
-	ad := action.NewDispatcher(am, cfg.Inputs[0].Server.Limits.ActionLimit.Interval, cfg.Inputs[0].Server.Limits.ActionLimit.Burst)
+	targeted, err := action.NewTargetedActions(bulker)
+	if err != nil {
+		return err
+	}
+	ad := action.NewDispatcher(am, cfg.Inputs[0].Server.Limits.ActionLimit.Interval, cfg.Inputs[0].Server.Limits.ActionLimit.Burst,
+		action.WithMaxSubscriptions(cfg.Inputs[0].Server.Limits.MaxConnections),
+		action.WithCache(f.cache),
+		action.WithTargetedActions(targeted),
+	)
 	g.Go(loggedRunFunc(ctx, "Action dispatcher", ad.Run))
 
-	bc := checkin.NewBulk(bulker)
-	g.Go(loggedRunFunc(ctx, "Bulk checkin", bc.Run))
+	bc := checkin.NewBulk(bulker, checkin.WithStatusDamping(cfg.Inputs[0].Server.StatusDamping))
+	dt, err := action.NewDeliveryTracker(bulker, cfg.Inputs[0].Server.DeliveryTracking)
+	if err != nil {
+		return err
+	}
+	if dt != nil {
+		dt.Register(f.subsystemStats("action_deliveries"))
+	}
 
-	ct, err := api.NewCheckinT(f.verCon, &cfg.Inputs[0].Server, f.cache, bc, pm, am, ad, bulker)
+	agentsSeen := seen.NewTracker()
+	agentsSeen.Register(f.subsystemStats("agents"))
+
+	ct, err := api.NewCheckinT(f.verCon, &cfg.Inputs[0].Server, f.cache, bc, pm, am, ad, bulker,
+		api.WithDeliveryTracker(dt),
+		api.WithTargetedActions(targeted),
+		api.WithCheckinStats(f.subsystemStats("checkin")),
+		api.WithSeenAgents(agentsSeen),
+		api.WithCheckinServerVersion(f.serverVer),
+		api.WithCheckinDrain(f.drain),
+	)
 	if err != nil {
 		return err
 	}
-	et, err := api.NewEnrollerT(f.verCon, &cfg.Inputs[0].Server, bulker, f.cache)
+	resources := usage.New(cfg.Inputs[0].Server.ResourceUsage)
+	resources.Register(f.subsystemStats("resources"))
+	// the progress of the batch operations is stored so an interrupted operation is resumed by any instance,
+	// the operations running on an instance that goes offline are taken over by the instance that flags it
+	ops := operation.NewTracker(operation.NewESStore(bulker, dl.FleetCheckpoints), operation.WithServer(cfg.Fleet.Agent.ID), operation.WithStats(f.subsystemStats("operations")))
+	hb := instance.NewHeartbeat(bulker, cfg, f.bi, instance.WithSelfMonitor(sm), instance.WithConnectedAgents(ct.Connected), instance.WithSeenAgents(agentsSeen), instance.WithDrain(f.drain), instance.WithResourceUsage(resources), instance.WithOperations(ops.Running))
+
+	pol := api.NewPolicyT(&cfg.Inputs[0].Server, bulker, f.cache, pm)
+	pol.RegisterRolloutStats(f.subsystemStats("policy_rollout"))
+
+	// Run scheduler for the background jobs, they are stopped in this order on shutdown.
+	// The pending checkins and delivery markers are flushed first, the document of the instance is marked as stopped last.
+	schedules := []scheduler.Schedule{bc.Schedule()}
+	// the leases, sweeps and timeouts use the elasticsearch time, the clock is sampled first
+	if skewCfg := cfg.Inputs[0].Server.ClockSkew; skewCfg.Enabled {
+		clock := esclock.New(skewCfg, func(ctx context.Context) (time.Time, error) {
+			return es.FetchESTime(ctx, esCli)
+		})
+		clock.Register(f.subsystemStats("clock"))
+		esclock.SetDefault(clock)
+		schedules = append(schedules, clock.Schedule())
+	} else {
+		esclock.SetDefault(nil)
+	}
+	if dt != nil {
+		schedules = append(schedules, dt.Schedule())
+	}
+	gcCfg := cfg.Inputs[0].Server.GC
+	// the sweeps and the orphaned API keys are handled by a single instance, the leader of the fleet servers
+	leader := instance.Leader(bulker, cfg.Fleet.Agent.ID, cfg.Inputs[0].Server.Heartbeat.StaleTimeout,
+		instance.WithLeaderStats(f.subsystemStats("leadership")))
+	// the action TTLs are reloaded in place, the expired results are recorded with the running configuration
+	actionTTL := func() config.Actions {
+		if cur := f.GetConfig(); cur != nil {
+			return cur.Inputs[0].Server.Actions
+		}
+		return cfg.Inputs[0].Server.Actions
+	}
+	gcSchedules := gc.Schedules(bulker, ops, leader, gcCfg.ScheduleInterval, gcCfg.CleanupAfterExpiredInterval, gcCfg.UpgradeTimeout, gcCfg.OfflineTimeout, gcCfg.UnenrollTimeout, cfg.Inputs[0].Server.Retention.UnenrolledAgents, actionTTL)
+	schedules = append(schedules, disabled.enabledSchedules(gcSchedules)...)
+	if gcCfg.APIKeys.Enabled {
+		apiKeys := gc.NewAPIKeys(bulker, gcCfg.APIKeys, gcCfg.ScheduleInterval, leader)
+		apiKeys.Register(f.subsystemStats("gc"))
+		schedules = append(schedules, apiKeys.Schedule())
+	}
+	schedules = append(schedules, pol.RolloutSchedule())
+	if s, ok := ct.CheckinDelaySchedule(); ok {
+		schedules = append(schedules, s)
+	}
+	schedules = append(schedules, instance.Janitor(bulker, cfg.Fleet.Agent.ID, cfg.Inputs[0].Server.Heartbeat.StaleTimeout, ops.TakeOver), hb.Schedule())
+	sched, err := scheduler.New(schedules, scheduler.WithStats(f.subsystemStats("scheduler")))
+	if err != nil {
+		return fmt.Errorf("failed to create scheduler: %w", err)
+	}
+	g.Go(loggedRunFunc(ctx, "Scheduler", sched.Run))
+
+	et, err := api.NewEnrollerT(f.verCon, &cfg.Inputs[0].Server, bulker, f.cache,
+		api.WithEnrollCheckin(bc),
+		api.WithEnrollServerVersion(f.serverVer),
+		api.WithEnrollPolicyReader(pr),
+		api.WithEnrollPolicyMonitor(pm),
+		api.WithEnrollIdempotencyTTL(cfg.Inputs[0].Cache.EnrollIdempotencyTTL),
+		api.WithEnrollDrain(f.drain),
+	)
 	if err != nil {
 		return err
 	}
 
 	at := api.NewArtifactT(&cfg.Inputs[0].Server, bulker, f.cache)
-	ack := api.NewAckT(&cfg.Inputs[0].Server, bulker, f.cache)
-	st := api.NewStatusT(&cfg.Inputs[0].Server, bulker, f.cache, api.WithSelfMonitor(sm), api.WithBuildInfo(f.bi))
+	ack := api.NewAckT(&cfg.Inputs[0].Server, bulker, f.cache, api.WithAckSeenAgents(agentsSeen), api.WithAckCheckin(bc))
+	st := api.NewStatusT(&cfg.Inputs[0].Server, bulker, f.cache, api.WithSelfMonitor(sm), api.WithBuildInfo(f.bi), api.WithDrain(f.drain), api.WithResourceUsage(resources), api.WithProxyHealth(f.proxy))
+	if err := f.cache.Register(config.CacheStatusESHealth, st); err != nil {
+		return err
+	}
+	if metricsServer != nil {
+		api.AttachStatusEndpoint(metricsServer, st, f.monAuth)
+	}
 	ut := api.NewUploadT(&cfg.Inputs[0].Server, bulker, monCli, f.cache) // uses no-retry client for bufferless chunk upload
 	ft := api.NewFileDeliveryT(&cfg.Inputs[0].Server, bulker, monCli, f.cache)
 	pt := api.NewPGPRetrieverT(&cfg.Inputs[0].Server, bulker, f.cache)
 	auditT := api.NewAuditT(&cfg.Inputs[0].Server, bulker, f.cache)
+	act := api.NewActionsT(&cfg.Inputs[0].Server, bulker, f.cache)
+	rt := api.NewReassignT(&cfg.Inputs[0].Server, bulker, pm, ops)
+	tt := api.NewTagsT(&cfg.Inputs[0].Server, bulker)
+
+	trail := audittrail.New(bulker, audittrail.WithStats(f.subsystemStats("audit_trail")))
+	g.Go(loggedRunFunc(ctx, "Audit trail", trail.Run))
 
+	reloaders := []api.Reloader{bulker, ad, pm, ct, et, ack, act, auditT, rt, tt}
+
+	// release the addresses of the unavailable listeners
+	waiter.stop()
 	for _, endpoint := range (&cfg.Inputs[0].Server).BindEndpoints() {
 		apiServer := api.NewServer(endpoint, &cfg.Inputs[0].Server,
 			api.WithCheckin(ct),
@@ -552,12 +833,21 @@ func (f *Fleet) runSubsystems(ctx context.Context, cfg *config.Config, g *errgro
 			api.WithFileDelivery(ft),
 			api.WithPGP(pt),
 			api.WithAudit(auditT),
+			api.WithActions(act),
+			api.WithReassign(rt),
+			api.WithTags(tt),
+			api.WithPolicy(pol),
+			api.WithOperations(api.NewOperationsT(bulker, ops)),
+			api.WithAuditTrail(trail),
 			api.WithTracer(tracer),
+			api.WithDisabledOperations(disabled.operations...),
 		)
+		reloaders = append(reloaders, apiServer)
 		g.Go(loggedRunFunc(ctx, "Http server", func(ctx context.Context) error {
 			return apiServer.Run(ctx)
 		}))
 	}
+	f.registerReloaders(reloaders...)
 
 	return nil
 }
@@ -573,6 +863,42 @@ func (f *Fleet) Reload(ctx context.Context, cfg *config.Config) error {
 
 const envAPMActive = "ELASTIC_APM_ACTIVE"
 
+// ReloadConfig applies the settings of cfg that can be changed at runtime to the running server.
+// Changed settings that require a restart are logged and ignored. An invalid cfg is rejected and the running configuration is kept.
+// The returned configuration is the one the server is reconfigured with.
+func (f *Fleet) ReloadConfig(ctx context.Context, cfg *config.Config) (*config.Config, error) {
+	log := zerolog.Ctx(ctx)
+	cur := f.GetConfig()
+	if cur == nil {
+		return nil, errors.New("fleet server is not running")
+	}
+	if err := cfg.LoadServerLimits(log); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	merged, diff, err := cur.ApplyReload(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if len(diff.RestartRequired) > 0 {
+		log.Warn().Strs("settings", diff.RestartRequired).Msg("Configuration changes require a restart to take effect")
+	}
+	// A generated token of the metrics endpoint is rotated on each reload, a changed authentication is applied by Run.
+	if merged.HTTP.Auth == cur.HTTP.Auth {
+		if err := f.monAuth.Configure(merged.HTTP.Auth); err != nil {
+			return nil, err
+		}
+	}
+	if len(diff.Changed) == 0 {
+		log.Info().Msg("Configuration reload has no settings to apply")
+		return cur, nil
+	}
+	log.Info().Strs("settings", diff.Changed).Msg("Applying configuration reload")
+	if err := f.Reload(ctx, merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
 func (f *Fleet) initTracer(ctx context.Context, cfg config.Instrumentation) (*apm.Tracer, error) {
 	if !cfg.Enabled && os.Getenv(envAPMActive) != "true" {
 		return nil, nil
@@ -612,8 +938,14 @@ func (f *Fleet) initTracer(ctx context.Context, cfg config.Instrumentation) (*ap
 	})
 }
 
-func elasticsearchOptions(instumented bool, bi build.Info) []es.ConfigOption {
+// elasticsearchOptions returns the options of the elasticsearch clients, the health of their proxy is reported to proxy
+// when it is set.
+func elasticsearchOptions(instumented bool, bi build.Info, proxy *es.ProxyHealth) []es.ConfigOption {
 	options := []es.ConfigOption{es.WithUserAgent(kUAFleetServer, bi)}
+	if proxy != nil {
+		// before the instrumentation wraps the transport
+		options = append(options, es.WithProxyHealth(proxy))
+	}
 	if instumented {
 		options = append(options, es.InstrumentRoundTripper())
 	}
@@ -31,6 +31,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/elastic/elastic-agent-client/v7/pkg/client"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/elastic/go-elasticsearch/v8"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/api"
@@ -1330,6 +1331,125 @@ func Test_SmokeTest_CheckinPollTimeout(t *testing.T) {
 	require.GreaterOrEqual(t, dur, time.Minute)
 }
 
+func Test_SmokeTest_CheckinPollReload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Start test server with a long poll that outlasts the reload
+	srv, err := startTestServer(t, ctx, policyData, func(cfg *config.Config) error {
+		cfg.Inputs[0].Server.Timeouts.CheckinLongPoll = 30 * time.Second
+		cfg.Inputs[0].Server.Timeouts.CheckinJitter = 0
+		return nil
+	})
+	require.NoError(t, err)
+	ctx = testlog.SetLogger(t).WithContext(ctx)
+
+	cli := cleanhttp.DefaultClient()
+
+	t.Log("Enroll an agent")
+	enrollResponse := EnrollAgent(t, ctx, srv, enrollBody)
+	agentID := enrollResponse.Item.Id
+	apiKey := enrollResponse.Item.AccessApiKey
+
+	t.Logf("checkin 1: agent %s receives policy", agentID)
+	req, err := http.NewRequestWithContext(ctx, "POST", srv.baseURL()+"/api/fleet/agents/"+agentID+"/checkin", strings.NewReader(checkinBody))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "ApiKey "+apiKey)
+	req.Header.Set("User-Agent", "elastic agent "+serverVersion)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := cli.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var checkinResponse api.CheckinResponse
+	err = json.NewDecoder(res.Body).Decode(&checkinResponse)
+	res.Body.Close()
+	require.NoError(t, err)
+
+	t.Logf("Ack actions for agent %s", agentID)
+	events := make([]api.AckRequest_Events_Item, 0, len(*checkinResponse.Actions))
+	for _, action := range *checkinResponse.Actions {
+		ev := api.AckRequest_Events_Item{}
+		err := ev.FromGenericEvent(api.GenericEvent{
+			ActionId: action.Id,
+			AgentId:  agentID,
+			Message:  "test-message",
+			Type:     api.ACTIONRESULT,
+			Subtype:  api.ACKNOWLEDGED,
+		})
+		require.NoError(t, err)
+		events = append(events, ev)
+	}
+	p, err := json.Marshal(api.AckRequest{Events: events})
+	require.NoError(t, err)
+	req, err = http.NewRequestWithContext(ctx, "POST", srv.baseURL()+"/api/fleet/agents/"+agentID+"/acks", bytes.NewBuffer(p))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "ApiKey "+apiKey)
+	req.Header.Set("User-Agent", "elastic agent "+serverVersion)
+	req.Header.Set("Content-Type", "application/json")
+	res, err = cli.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	checkin := func(ackToken string) (*api.CheckinResponse, time.Duration) {
+		req, err := http.NewRequestWithContext(ctx, "POST", srv.baseURL()+"/api/fleet/agents/"+agentID+"/checkin", strings.NewReader(fmt.Sprintf(`{
+		    "ack_token": "%s",
+		    "status": "online",
+		    "message": "checkin ok"
+		}`, ackToken)))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "ApiKey "+apiKey)
+		req.Header.Set("User-Agent", "elastic agent "+serverVersion)
+		req.Header.Set("Content-Type", "application/json")
+		start := time.Now()
+		res, err := cli.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var checkinResponse api.CheckinResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&checkinResponse))
+		return &checkinResponse, time.Since(start)
+	}
+
+	t.Logf("checkin 2: agent %s waits in the long poll", agentID)
+	type result struct {
+		res *api.CheckinResponse
+		dur time.Duration
+	}
+	polled := make(chan result, 1)
+	go func() {
+		res, dur := checkin(*checkinResponse.AckToken)
+		polled <- result{res, dur}
+	}()
+	require.Eventually(t, func() bool {
+		return monitoring.CollectFlatSnapshot(srv.srv.Stats(), monitoring.Full, false).Ints["checkin.connected"] == 1
+	}, 10*time.Second, 100*time.Millisecond)
+
+	t.Log("Reload configuration with a short checkin long poll")
+	newCfg, err := config.LoadFile("../testing/fleet-server-testing.yml")
+	require.NoError(t, err)
+	newCfg.Inputs[0].Server = srv.cfg.Inputs[0].Server
+	newCfg.Inputs[0].Server.Timeouts.CheckinLongPoll = 10 * time.Second
+	reloaded, err := srv.srv.ReloadConfig(ctx, newCfg)
+	require.NoError(t, err)
+	require.Equal(t, 10*time.Second, reloaded.Inputs[0].Server.Timeouts.CheckinLongPoll)
+	require.Eventually(t, func() bool {
+		cfg := srv.srv.GetConfig()
+		return cfg != nil && cfg.Inputs[0].Server.Timeouts.CheckinLongPoll == 10*time.Second
+	}, 30*time.Second, 100*time.Millisecond)
+
+	// the long poll is not interrupted by the reload, it completes with the timeout it started with
+	r := <-polled
+	t.Logf("checkin 2: agent %s took %s", agentID, r.dur)
+	require.GreaterOrEqual(t, r.dur, 25*time.Second)
+
+	t.Logf("checkin 3: agent %s uses the reloaded long poll", agentID)
+	_, dur := checkin(*r.res.AckToken)
+	t.Logf("checkin 3: agent %s took %s", agentID, dur)
+	require.GreaterOrEqual(t, dur, 5*time.Second)
+	require.Less(t, dur, 25*time.Second)
+}
+
 func Test_SmokeTest_CheckinPollShutdown(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
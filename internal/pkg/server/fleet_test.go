@@ -7,10 +7,14 @@ package server
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/fleet-server/v7/internal/pkg/build"
 	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_configChangedServer(t *testing.T) {
@@ -90,6 +94,127 @@ func Test_configChangedServer(t *testing.T) {
 			Inputs: []config.Input{config.Input{}},
 		},
 		changed: true,
+	}, {
+		name: "checkin timeouts are applied in place",
+		cfg: &config.Config{
+			Fleet: config.Fleet{
+				Agent: config.Agent{
+					ID:      "test-id",
+					Version: "test-version",
+					Logging: config.AgentLogging{
+						Level: "info",
+					},
+				},
+			},
+			Logging: config.Logging{
+				Level: "info",
+			},
+			Inputs: []config.Input{config.Input{Server: config.Server{
+				Timeouts: config.ServerTimeouts{CheckinLongPoll: time.Minute},
+				Limits:   config.ServerLimits{CheckinLimit: config.Limit{Interval: time.Second, Burst: 10}},
+			}}},
+		},
+		changed: false,
+	}, {
+		name: "listener timeouts change",
+		cfg: &config.Config{
+			Fleet: config.Fleet{
+				Agent: config.Agent{
+					ID:      "test-id",
+					Version: "test-version",
+					Logging: config.AgentLogging{
+						Level: "info",
+					},
+				},
+			},
+			Logging: config.Logging{
+				Level: "info",
+			},
+			Inputs: []config.Input{config.Input{Server: config.Server{
+				Timeouts: config.ServerTimeouts{Write: time.Minute},
+			}}},
+		},
+		changed: true,
+	}, {
+		name: "bulk flush settings are applied in place",
+		cfg: &config.Config{
+			Fleet: config.Fleet{
+				Agent: config.Agent{
+					ID:      "test-id",
+					Version: "test-version",
+					Logging: config.AgentLogging{
+						Level: "info",
+					},
+				},
+			},
+			Logging: config.Logging{
+				Level: "info",
+			},
+			Inputs: []config.Input{config.Input{Server: config.Server{
+				Bulk: config.ServerBulk{FlushInterval: time.Second, FlushThresholdCount: 10, FlushThresholdSize: 1024},
+			}}},
+		},
+		changed: false,
+	}, {
+		name: "connection and dispatch limits are applied in place",
+		cfg: &config.Config{
+			Fleet: config.Fleet{
+				Agent: config.Agent{
+					ID:      "test-id",
+					Version: "test-version",
+					Logging: config.AgentLogging{
+						Level: "info",
+					},
+				},
+			},
+			Logging: config.Logging{
+				Level: "info",
+			},
+			Inputs: []config.Input{config.Input{Server: config.Server{
+				Limits: config.ServerLimits{MaxConnections: 10, ActionLimit: config.Limit{Interval: time.Second, Burst: 10}, PolicyLimit: config.Limit{Burst: 5}},
+			}}},
+		},
+		changed: false,
+	}, {
+		name: "action TTLs are applied in place",
+		cfg: &config.Config{
+			Fleet: config.Fleet{
+				Agent: config.Agent{
+					ID:      "test-id",
+					Version: "test-version",
+					Logging: config.AgentLogging{
+						Level: "info",
+					},
+				},
+			},
+			Logging: config.Logging{
+				Level: "info",
+			},
+			Inputs: []config.Input{config.Input{Server: config.Server{
+				Actions: config.Actions{TTL: map[string]time.Duration{"UPGRADE": time.Hour}},
+			}}},
+		},
+		changed: false,
+	}, {
+		name: "bulk pending flushes change",
+		cfg: &config.Config{
+			Fleet: config.Fleet{
+				Agent: config.Agent{
+					ID:      "test-id",
+					Version: "test-version",
+					Logging: config.AgentLogging{
+						Level: "info",
+					},
+				},
+			},
+			Logging: config.Logging{
+				Level: "info",
+			},
+			Inputs: []config.Input{config.Input{Server: config.Server{
+				Bulk: config.ServerBulk{FlushMaxPending: 1},
+			}}},
+		},
+		changed: true,
 	}}
 
 	cfg := &config.Config{
@@ -159,3 +284,28 @@ func Test_initTracer(t *testing.T) {
 		})
 	}
 }
+
+func TestFleetStats(t *testing.T) {
+	bi := build.Info{Version: "8.0.0"}
+	f1, err := NewFleet(bi, nil, false)
+	require.NoError(t, err)
+	f2, err := NewFleet(bi, nil, false)
+	require.NoError(t, err)
+	require.NotSame(t, f1.Stats(), f2.Stats())
+
+	// subsystems register in the registry of their own instance
+	monitoring.NewInt(f1.subsystemStats("bulker"), "pending_items").Set(5)
+	require.NotNil(t, f1.Stats().Get("bulker.pending_items"))
+	require.Nil(t, f2.Stats().Get("bulker"))
+
+	// a restarted subsystem replaces the stats of the previous one
+	monitoring.NewInt(f1.subsystemStats("bulker"), "queue_depth")
+	require.Nil(t, f1.Stats().Get("bulker.pending_items"))
+	require.NotNil(t, f1.Stats().Get("bulker.queue_depth"))
+
+	snapshot := monitoring.CollectStructSnapshot(f1.Stats(), monitoring.Full, false)
+	rt, ok := snapshot["runtime"].(map[string]interface{})
+	require.True(t, ok, "runtime stats missing: %v", snapshot)
+	require.Positive(t, rt["goroutines"])
+	require.Contains(t, rt["memstats"], "heap_inuse")
+}
@@ -0,0 +1,51 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package server
+
+import (
+	"runtime"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// newStatsRegistry returns the stats registry of a fleet server instance.
+// The subsystems register their stats in it when they are created, it is served on the /stats endpoint of the monitoring listener.
+func newStatsRegistry() *monitoring.Registry {
+	reg := monitoring.NewRegistry()
+	monitoring.NewFunc(reg, "runtime", reportRuntime)
+	return reg
+}
+
+// reportRuntime reports the goroutine count and the memory stats of the go runtime.
+func reportRuntime(_ monitoring.Mode, v monitoring.Visitor) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	v.OnRegistryStart()
+	defer v.OnRegistryFinished()
+
+	monitoring.ReportInt(v, "goroutines", int64(runtime.NumGoroutine()))
+	monitoring.ReportNamespace(v, "memstats", func() {
+		monitoring.ReportInt(v, "alloc", int64(ms.Alloc))              //nolint:gosec // disable G115
+		monitoring.ReportInt(v, "total_alloc", int64(ms.TotalAlloc))   //nolint:gosec // disable G115
+		monitoring.ReportInt(v, "sys", int64(ms.Sys))                  //nolint:gosec // disable G115
+		monitoring.ReportInt(v, "heap_inuse", int64(ms.HeapInuse))     //nolint:gosec // disable G115
+		monitoring.ReportInt(v, "heap_objects", int64(ms.HeapObjects)) //nolint:gosec // disable G115
+		monitoring.ReportInt(v, "num_gc", int64(ms.NumGC))
+		monitoring.ReportInt(v, "pause_total_ns", int64(ms.PauseTotalNs)) //nolint:gosec // disable G115
+	})
+}
+
+// subsystemStats returns an empty registry for the stats of the subsystem name.
+// The stats of a previous instance of the subsystem, from before a restart, are replaced.
+func (f *Fleet) subsystemStats(name string) *monitoring.Registry {
+	f.stats.Remove(name)
+	return f.stats.NewRegistry(name)
+}
+
+// Stats returns the stats registry of the fleet server.
+func (f *Fleet) Stats() *monitoring.Registry {
+	return f.stats
+}
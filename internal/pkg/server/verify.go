@@ -0,0 +1,207 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package server
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/elastic/elastic-agent-client/v7/pkg/client"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/rs/zerolog"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/policy"
+	"github.com/elastic/fleet-server/v7/internal/pkg/scheduler"
+	"github.com/elastic/fleet-server/v7/internal/pkg/state"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ver"
+)
+
+// verifyElasticsearch checks on startup that the version of the cluster is at least the configured minimum
+// and that the credentials have the privileges fleet-server requires.
+// The errors list what has to be fixed, misconfigured credentials would otherwise only fail the first requests that need them.
+func verifyElasticsearch(ctx context.Context, esCli *elasticsearch.Client, cfg *config.Elasticsearch) error {
+	zlog := zerolog.Ctx(ctx)
+	if cfg.Verification.Skip {
+		zlog.Warn().Msg("Elasticsearch verification is skipped")
+		return nil
+	}
+
+	esVersion, err := es.FetchESVersion(ctx, esCli)
+	if err != nil {
+		return fmt.Errorf("failed to verify elasticsearch: %w", err)
+	}
+	if err := ver.CheckMinimumVersion(esVersion, cfg.Verification.MinVersion); err != nil {
+		return fmt.Errorf("failed to verify elasticsearch, upgrade elasticsearch or lower output.elasticsearch.verification.min_version: %w", err)
+	}
+	missing, err := es.CheckPrivileges(ctx, esCli)
+	if err != nil {
+		return fmt.Errorf("failed to verify elasticsearch privileges, use --skip-verification if the credentials can not call the has_privileges API: %w", err)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("failed to verify elasticsearch, grant the missing privileges to the fleet-server credentials: %w", &es.MissingPrivilegesError{Privileges: missing})
+	}
+	zlog.Info().Str("elasticsearch_version", esVersion).Msg("Elasticsearch verification successful")
+	return nil
+}
+
+// fleetMappings are the mappings fleet-server expects for the fleet indices it writes, with the features writing
+// each index that are disabled when fleet-server is forced to start with conflicts in its mappings.
+// The operations are named as the routes of the API requests, the schedules as the gc background jobs.
+// The checkins, enrollments and acks also write the indices, they are kept running in a degraded state.
+var fleetMappings = []struct {
+	index      string
+	mapping    string
+	operations []string
+	schedules  []string
+}{
+	{
+		index:      dl.FleetActions,
+		mapping:    es.MappingAction,
+		operations: []string{"createActions"},
+	},
+	{
+		index:      dl.FleetAgents,
+		mapping:    es.MappingAgent,
+		operations: []string{"agentTags", "bulkEnroll", "reassignAgents"},
+		schedules:  []string{"fleet offline agents", "fleet purge unenrolled agents", "fleet stale upgrades", "fleet unenrollments"},
+	},
+	{
+		index:     dl.FleetAgentTombstones,
+		mapping:   es.MappingAgentTombstone,
+		schedules: []string{"fleet purge unenrolled agents"},
+	},
+}
+
+// verifyMappings checks on startup that the mappings of the fleet indices have the fields fleet-server writes, an older
+// cluster would otherwise fail the writes of the new fields hours later. The missing fields are added to the mappings,
+// the indices that do not exist yet are skipped.
+// The fields of another type fail the startup, unless cfg.Verification.Force is set: the conflicts are then returned
+// so the features writing the indices with conflicts are disabled.
+func verifyMappings(ctx context.Context, esCli *elasticsearch.Client, cfg *config.Elasticsearch) ([]es.MappingConflict, error) {
+	zlog := zerolog.Ctx(ctx)
+	if cfg.Verification.Skip {
+		return nil, nil
+	}
+
+	var conflicts []es.MappingConflict
+	for _, m := range fleetMappings {
+		mappings, err := es.FetchMappings(ctx, esCli, m.index)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify the mapping of %s: %w", m.index, err)
+		}
+		// the fields are compared on each index of the alias or data stream, their mappings differ after a rollover
+		for index, mapping := range mappings {
+			diff, err := es.DiffMappings([]byte(m.mapping), mapping)
+			if err != nil {
+				return nil, fmt.Errorf("failed to verify the mapping of %s: %w", index, err)
+			}
+			for _, c := range diff.Conflicts {
+				c.Index = index
+				conflicts = append(conflicts, c)
+			}
+			if diff.Missing == nil {
+				continue
+			}
+			zlog.Info().Str("index", index).Strs("fields", diff.MissingFields).Msg("Adding the missing fields to the mapping")
+			if err := es.PutMapping(ctx, esCli, index, diff.Missing); err != nil {
+				return nil, fmt.Errorf("failed to add the missing fields %s to the mapping of %s: %w", strings.Join(diff.MissingFields, ", "), index, err)
+			}
+		}
+	}
+	if len(conflicts) == 0 {
+		return nil, nil
+	}
+	slices.SortFunc(conflicts, func(a, b es.MappingConflict) int {
+		return cmp.Or(cmp.Compare(a.Index, b.Index), cmp.Compare(a.Field, b.Field))
+	})
+	err := &es.IncompatibleMappingsError{Conflicts: conflicts}
+	if !cfg.Verification.Force {
+		return nil, fmt.Errorf("failed to verify elasticsearch, reindex the fleet indices or use --force to start in degraded mode: %w", err)
+	}
+	zlog.Error().Err(err).Msg("Starting in degraded mode with incompatible mappings")
+	return conflicts, nil
+}
+
+// hasMappingConflicts returns true if one of the conflicts is in an index of name, an alias or a data stream.
+// The indices of an alias are named after it, the backing indices of a data stream after the data stream.
+func hasMappingConflicts(conflicts []es.MappingConflict, name string) bool {
+	return slices.ContainsFunc(conflicts, func(c es.MappingConflict) bool {
+		return strings.HasPrefix(c.Index, name) || strings.HasPrefix(c.Index, ".ds-"+name)
+	})
+}
+
+// disabledFeatures are the features of the fleet indices with mapping conflicts.
+type disabledFeatures struct {
+	indices    []string
+	operations []string
+	schedules  []string
+}
+
+// newDisabledFeatures returns the features writing the fleet indices with conflicts.
+func newDisabledFeatures(conflicts []es.MappingConflict) disabledFeatures {
+	var d disabledFeatures
+	for _, m := range fleetMappings {
+		if !hasMappingConflicts(conflicts, m.index) {
+			continue
+		}
+		d.indices = append(d.indices, m.index)
+		d.operations = append(d.operations, m.operations...)
+		d.schedules = append(d.schedules, m.schedules...)
+	}
+	slices.Sort(d.operations)
+	d.operations = slices.Compact(d.operations)
+	slices.Sort(d.schedules)
+	d.schedules = slices.Compact(d.schedules)
+	return d
+}
+
+// isEmpty returns true if no index has conflicts.
+func (d disabledFeatures) isEmpty() bool {
+	return len(d.indices) == 0
+}
+
+// reason describes the disabled features in the message of the reported state.
+func (d disabledFeatures) reason() string {
+	return "incompatible mappings of " + strings.Join(d.indices, ", ") + " disable " + strings.Join(slices.Concat(d.operations, d.schedules), ", ")
+}
+
+// enabledSchedules returns the schedules that are not disabled.
+func (d disabledFeatures) enabledSchedules(schedules []scheduler.Schedule) []scheduler.Schedule {
+	return slices.DeleteFunc(schedules, func(s scheduler.Schedule) bool {
+		return slices.Contains(d.schedules, s.Name)
+	})
+}
+
+// degradedMonitor is a self monitor that reports its healthy state as degraded, features of fleet-server are disabled.
+type degradedMonitor struct {
+	policy.SelfMonitor
+}
+
+func (m degradedMonitor) State() client.UnitState {
+	if s := m.SelfMonitor.State(); s != client.UnitStateHealthy {
+		return s
+	}
+	return client.UnitStateDegraded
+}
+
+// degradedReporter reports the healthy states as degraded, with the reason in the message.
+type degradedReporter struct {
+	state.Reporter
+	reason string
+}
+
+func (r degradedReporter) UpdateState(s client.UnitState, message string, payload map[string]interface{}) error {
+	if s == client.UnitStateHealthy {
+		s = client.UnitStateDegraded
+		message += ": " + r.reason
+	}
+	return r.Reporter.UpdateState(s, message, payload)
+}
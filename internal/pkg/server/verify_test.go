@@ -0,0 +1,336 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-client/v7/pkg/client"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/gc"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ver"
+)
+
+const allPrivileges = `{"has_all_requested":true}`
+
+// mockES is an elasticsearch cluster that answers the info and has_privileges APIs.
+// The first unavailable info requests fail with a 503.
+// It answers the get mapping API of the indices of mappings, and records the bodies of the put mapping API in puts.
+type mockES struct {
+	version     string
+	privileges  string
+	unavailable int32
+	infoCalls   atomic.Int32
+
+	mappings  map[string]string
+	putStatus int
+	mx        sync.Mutex
+	puts      map[string]string
+}
+
+func (m *mockES) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/":
+		if m.infoCalls.Add(1) <= m.unavailable {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"error":{"type":"unavailable","reason":"starting"}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"version":{"number":%q}}`, m.version)
+	case "/_security/user/_has_privileges":
+		fmt.Fprint(w, m.privileges)
+	default:
+		index, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/"), "/_mapping")
+		switch {
+		case ok && r.Method == http.MethodGet && m.mappings[index] != "":
+			fmt.Fprint(w, m.mappings[index])
+		case ok && r.Method == http.MethodPut && m.putStatus != 0:
+			w.WriteHeader(m.putStatus)
+			fmt.Fprint(w, `{"error":{"type":"security_exception","reason":"action [indices:admin/mapping/put] is unauthorized"}}`)
+		case ok && r.Method == http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			m.mx.Lock()
+			if m.puts == nil {
+				m.puts = make(map[string]string)
+			}
+			m.puts[index] = string(body)
+			m.mx.Unlock()
+			fmt.Fprint(w, `{"acknowledged":true}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func verifyConfig(url string) *config.Config {
+	cfg := &config.Config{}
+	cfg.Output.Elasticsearch.InitDefaults()
+	cfg.Output.Elasticsearch.Hosts = []string{url}
+	cfg.Output.Elasticsearch.MaxRetries = 0
+	cfg.Output.Elasticsearch.Backoff = config.ESBackoff{Init: 10 * time.Millisecond, Max: 10 * time.Millisecond}
+	return cfg
+}
+
+func runVerify(t *testing.T, cfg *config.Config) error {
+	t.Helper()
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	cli, err := es.NewClient(ctx, cfg, false)
+	require.NoError(t, err)
+	return verifyElasticsearch(ctx, cli, &cfg.Output.Elasticsearch)
+}
+
+func Test_verifyElasticsearch(t *testing.T) {
+	t.Run("valid cluster", func(t *testing.T) {
+		srv := httptest.NewServer(&mockES{version: "8.12.0", privileges: allPrivileges})
+		defer srv.Close()
+		require.NoError(t, runVerify(t, verifyConfig(srv.URL)))
+	})
+
+	t.Run("missing privileges", func(t *testing.T) {
+		srv := httptest.NewServer(&mockES{version: "8.12.0", privileges: `{"has_all_requested":false,"cluster":{"monitor":true,"manage_own_api_key":false},"index":{".fleet-*":{"read":true,"write":false,"monitor":true,"create_index":true,"auto_configure":true,"maintenance":true}}}`})
+		defer srv.Close()
+
+		err := runVerify(t, verifyConfig(srv.URL))
+		var privErr *es.MissingPrivilegesError
+		require.ErrorAs(t, err, &privErr)
+		require.Equal(t, []string{"cluster:manage_own_api_key", ".fleet-*:write"}, privErr.Privileges)
+		require.ErrorContains(t, err, "cluster:manage_own_api_key, .fleet-*:write")
+	})
+
+	t.Run("old version", func(t *testing.T) {
+		m := &mockES{version: "7.17.9", privileges: allPrivileges}
+		srv := httptest.NewServer(m)
+		defer srv.Close()
+
+		err := runVerify(t, verifyConfig(srv.URL))
+		require.ErrorIs(t, err, ver.ErrUnsupportedVersion)
+		require.ErrorContains(t, err, "older than the minimum version 8.0.0")
+
+		cfg := verifyConfig(srv.URL)
+		cfg.Output.Elasticsearch.Verification.MinVersion = "7.17.0"
+		require.NoError(t, runVerify(t, cfg))
+	})
+
+	t.Run("rejected credentials", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("X-Elastic-Product", "Elasticsearch")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"type":"security_exception","reason":"unable to authenticate with provided credentials"}}`)
+		}))
+		defer srv.Close()
+
+		err := runVerify(t, verifyConfig(srv.URL))
+		require.ErrorIs(t, err, es.ErrSecurityException)
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("skipped", func(t *testing.T) {
+		srv := httptest.NewServer(&mockES{})
+		srv.Close()
+		cfg := verifyConfig(srv.URL)
+		cfg.Output.Elasticsearch.Verification.Skip = true
+		require.NoError(t, runVerify(t, cfg))
+	})
+}
+
+// getMapping returns the get mapping response of index with mapping.
+func getMapping(index, mapping string) string {
+	return fmt.Sprintf(`{%q:{"mappings":%s}}`, index, mapping)
+}
+
+func Test_verifyMappings(t *testing.T) {
+	runVerifyMappings := func(t *testing.T, cfg *config.Config) ([]es.MappingConflict, error) {
+		t.Helper()
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		cli, err := es.NewClient(ctx, cfg, false)
+		require.NoError(t, err)
+		return verifyMappings(ctx, cli, &cfg.Output.Elasticsearch)
+	}
+	upToDate := map[string]string{
+		dl.FleetActions:         getMapping(".fleet-actions-7", es.MappingAction),
+		dl.FleetAgents:          getMapping(".fleet-agents-7", es.MappingAgent),
+		dl.FleetAgentTombstones: getMapping(".ds-"+dl.FleetAgentTombstones+"-2025.01.01-000001", es.MappingAgentTombstone),
+	}
+	withMapping := func(index, body string) map[string]string {
+		mappings := make(map[string]string, len(upToDate))
+		for k, v := range upToDate {
+			mappings[k] = v
+		}
+		mappings[index] = body
+		return mappings
+	}
+
+	t.Run("up to date", func(t *testing.T) {
+		m := &mockES{mappings: upToDate}
+		srv := httptest.NewServer(m)
+		defer srv.Close()
+		conflicts, err := runVerifyMappings(t, verifyConfig(srv.URL))
+		require.NoError(t, err)
+		require.Empty(t, conflicts)
+		require.Empty(t, m.puts)
+	})
+
+	t.Run("missing indices", func(t *testing.T) {
+		m := &mockES{}
+		srv := httptest.NewServer(m)
+		defer srv.Close()
+		conflicts, err := runVerifyMappings(t, verifyConfig(srv.URL))
+		require.NoError(t, err)
+		require.Empty(t, conflicts)
+		require.Empty(t, m.puts)
+	})
+
+	t.Run("missing fields", func(t *testing.T) {
+		m := &mockES{mappings: withMapping(dl.FleetAgents, getMapping(".fleet-agents-7", `{"properties":{"agent":{"properties":{"id":{"type":"keyword"}}},"active":{"type":"boolean"}}}`))}
+		srv := httptest.NewServer(m)
+		defer srv.Close()
+		conflicts, err := runVerifyMappings(t, verifyConfig(srv.URL))
+		require.NoError(t, err)
+		require.Empty(t, conflicts)
+		require.Len(t, m.puts, 1)
+		require.Contains(t, m.puts[".fleet-agents-7"], `"agent":{"properties":{"version":{"type":"keyword"}}}`)
+		require.NotContains(t, m.puts[".fleet-agents-7"], `"active"`)
+	})
+
+	t.Run("missing fields not added", func(t *testing.T) {
+		m := &mockES{mappings: withMapping(dl.FleetAgents, getMapping(".fleet-agents-7", `{"properties":{}}`)), putStatus: http.StatusForbidden}
+		srv := httptest.NewServer(m)
+		defer srv.Close()
+		_, err := runVerifyMappings(t, verifyConfig(srv.URL))
+		require.ErrorIs(t, err, es.ErrSecurityException)
+		require.ErrorContains(t, err, "to the mapping of .fleet-agents-7")
+	})
+
+	incompatible := withMapping(dl.FleetAgentTombstones, getMapping(".ds-"+dl.FleetAgentTombstones+"-2025.01.01-000001", `{"properties":{"agent_id":{"type":"text"},"unenrolled_at":{"type":"keyword"}}}`))
+
+	t.Run("incompatible fields", func(t *testing.T) {
+		m := &mockES{mappings: incompatible}
+		srv := httptest.NewServer(m)
+		defer srv.Close()
+		_, err := runVerifyMappings(t, verifyConfig(srv.URL))
+		var mappingErr *es.IncompatibleMappingsError
+		require.ErrorAs(t, err, &mappingErr)
+		require.ErrorContains(t, err, "use --force")
+		require.ErrorContains(t, err, ".ds-logs-fleet_server.agent_tombstones-default-2025.01.01-000001 field agent_id is text, expected keyword, "+
+			".ds-logs-fleet_server.agent_tombstones-default-2025.01.01-000001 field unenrolled_at is keyword, expected date")
+	})
+
+	t.Run("forced", func(t *testing.T) {
+		m := &mockES{mappings: incompatible}
+		srv := httptest.NewServer(m)
+		defer srv.Close()
+		cfg := verifyConfig(srv.URL)
+		cfg.Output.Elasticsearch.Verification.Force = true
+		conflicts, err := runVerifyMappings(t, cfg)
+		require.NoError(t, err)
+		require.Len(t, conflicts, 2)
+		require.True(t, hasMappingConflicts(conflicts, dl.FleetAgentTombstones))
+		require.False(t, hasMappingConflicts(conflicts, dl.FleetAgents))
+		// the missing fields of the index with conflicts are still added
+		require.Contains(t, m.puts[".ds-"+dl.FleetAgentTombstones+"-2025.01.01-000001"], `"enrolled_at"`)
+	})
+
+	t.Run("skipped", func(t *testing.T) {
+		srv := httptest.NewServer(&mockES{})
+		srv.Close()
+		cfg := verifyConfig(srv.URL)
+		cfg.Output.Elasticsearch.Verification.Skip = true
+		conflicts, err := runVerifyMappings(t, cfg)
+		require.NoError(t, err)
+		require.Empty(t, conflicts)
+	})
+}
+
+func Test_newDisabledFeatures(t *testing.T) {
+	t.Run("no conflicts", func(t *testing.T) {
+		require.True(t, newDisabledFeatures(nil).isEmpty())
+	})
+
+	t.Run("agents and tombstones", func(t *testing.T) {
+		d := newDisabledFeatures([]es.MappingConflict{
+			{Index: ".fleet-agents-7", Field: "tags"},
+			{Index: ".ds-" + dl.FleetAgentTombstones + "-2025.01.01-000001", Field: "agent_id"},
+		})
+		require.Equal(t, []string{dl.FleetAgents, dl.FleetAgentTombstones}, d.indices)
+		require.Equal(t, []string{"agentTags", "bulkEnroll", "reassignAgents"}, d.operations)
+		require.Equal(t, []string{"fleet offline agents", "fleet purge unenrolled agents", "fleet stale upgrades", "fleet unenrollments"}, d.schedules)
+	})
+
+	t.Run("actions", func(t *testing.T) {
+		d := newDisabledFeatures([]es.MappingConflict{{Index: ".fleet-actions-7", Field: "type"}})
+		require.Equal(t, []string{"createActions"}, d.operations)
+		require.Empty(t, d.schedules)
+		require.Equal(t, "incompatible mappings of .fleet-actions disable createActions", d.reason())
+	})
+
+	t.Run("schedules are the gc schedules", func(t *testing.T) {
+		// every timeout is set so all the schedules are returned
+		schedules := gc.Schedules(nil, nil, nil, time.Minute, "", time.Hour, time.Hour, time.Hour, time.Hour, func() config.Actions { return config.Actions{} })
+		d := newDisabledFeatures([]es.MappingConflict{{Index: ".fleet-agents-7"}, {Index: ".fleet-actions-7"}, {Index: dl.FleetAgentTombstones}})
+		enabled := d.enabledSchedules(slices.Clone(schedules))
+		require.Len(t, enabled, len(schedules)-len(d.schedules))
+		for _, s := range enabled {
+			require.NotContains(t, d.schedules, s.Name)
+		}
+	})
+}
+
+// stateRecorder records the last reported state.
+type stateRecorder struct {
+	state   client.UnitState
+	message string
+}
+
+func (r *stateRecorder) UpdateState(state client.UnitState, message string, _ map[string]interface{}) error {
+	r.state, r.message = state, message
+	return nil
+}
+
+// fixedMonitor is a self monitor in a fixed state.
+type fixedMonitor client.UnitState
+
+func (m fixedMonitor) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (m fixedMonitor) State() client.UnitState {
+	return client.UnitState(m)
+}
+
+func Test_degradedReporter(t *testing.T) {
+	rec := &stateRecorder{}
+	r := degradedReporter{Reporter: rec, reason: "incompatible mappings of .fleet-actions disable createActions"}
+
+	require.NoError(t, r.UpdateState(client.UnitStateStarting, "Waiting on policy", nil))
+	require.Equal(t, client.UnitStateStarting, rec.state)
+	require.Equal(t, "Waiting on policy", rec.message)
+
+	require.NoError(t, r.UpdateState(client.UnitStateHealthy, "Running on policy", nil))
+	require.Equal(t, client.UnitStateDegraded, rec.state)
+	require.Equal(t, "Running on policy: incompatible mappings of .fleet-actions disable createActions", rec.message)
+
+	require.Equal(t, client.UnitStateDegraded, degradedMonitor{fixedMonitor(client.UnitStateHealthy)}.State())
+	require.Equal(t, client.UnitStateFailed, degradedMonitor{fixedMonitor(client.UnitStateFailed)}.State())
+}
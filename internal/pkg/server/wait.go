@@ -0,0 +1,119 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/elastic/elastic-agent-client/v7/pkg/client"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/api"
+	"github.com/elastic/fleet-server/v7/internal/pkg/build"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/state"
+)
+
+// defaultWaitRetryDelay is used between attempts when the output backoff is disabled.
+const defaultWaitRetryDelay = time.Second
+
+// esWaiter retries the startup steps that fail because elasticsearch is not ready yet,
+// for example when fleet-server and elasticsearch are started together.
+// While it waits the API listeners serve an unhealthy status so orchestration probes can report it.
+type esWaiter struct {
+	cfg      *config.Config
+	bi       build.Info
+	reporter state.Reporter
+	deadline time.Time
+	attempt  int
+
+	// unavailable API listeners, started on the first retry
+	cancel context.CancelFunc
+	g      *errgroup.Group
+}
+
+func newESWaiter(cfg *config.Config, bi build.Info, reporter state.Reporter) *esWaiter {
+	return &esWaiter{
+		cfg:      cfg,
+		bi:       bi,
+		reporter: reporter,
+		deadline: time.Now().Add(cfg.Output.Elasticsearch.MaxStartupWait),
+	}
+}
+
+// run runs fn until it succeeds or fails with an error that does not mean that elasticsearch is not ready.
+// It gives up when the max startup wait of the output is exceeded, the wait is shared by all the steps.
+func (w *esWaiter) run(ctx context.Context, fn func(context.Context) error) error {
+	hosts := strings.Join(w.cfg.Output.Elasticsearch.Hosts, ", ")
+	for {
+		err := fn(ctx)
+		if err == nil || !isESNotReady(err) {
+			return err
+		}
+		if time.Now().After(w.deadline) {
+			return fmt.Errorf("gave up waiting for Elasticsearch at %s after %s: %w", hosts, w.cfg.Output.Elasticsearch.MaxStartupWait, err)
+		}
+
+		w.attempt++
+		if w.attempt == 1 {
+			w.serveUnavailable(ctx)
+			if w.reporter != nil {
+				w.reporter.UpdateState(client.UnitStateStarting, "Waiting for Elasticsearch at "+hosts, nil) //nolint:errcheck // unclear on what should we do if updating the status fails?
+			}
+		}
+		delay := w.cfg.Output.Elasticsearch.Backoff.Delay(w.attempt)
+		if delay <= 0 {
+			delay = defaultWaitRetryDelay
+		}
+		zerolog.Ctx(ctx).Warn().Err(err).Int("attempt", w.attempt).Dur("retry_in", delay).
+			Msgf("Waiting for Elasticsearch at %s, attempt %d", hosts, w.attempt)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// serveUnavailable starts API listeners that report a degraded status and fail all other requests.
+func (w *esWaiter) serveUnavailable(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.g, ctx = errgroup.WithContext(ctx)
+	srvCfg := &w.cfg.Inputs[0].Server
+	for _, endpoint := range srvCfg.BindEndpoints() {
+		srv := api.NewUnavailableServer(endpoint, srvCfg, client.UnitStateDegraded, w.bi)
+		w.g.Go(loggedRunFunc(ctx, "Unavailable http server", srv.Run))
+	}
+}
+
+// stop stops the unavailable API listeners, it returns once their addresses can be bound again.
+func (w *esWaiter) stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	_ = w.g.Wait()
+	w.cancel, w.g = nil, nil
+}
+
+// isESNotReady returns true if err is a connection error, a transient error returned by elasticsearch
+// or a missing index that is not created yet.
+func isESNotReady(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return es.IsRetryable(err) || errors.Is(err, es.ErrIndexNotFound)
+}
@@ -0,0 +1,176 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-client/v7/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/build"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+type recordReporter struct {
+	states []client.UnitState
+}
+
+func (r *recordReporter) UpdateState(state client.UnitState, _ string, _ map[string]interface{}) error {
+	r.states = append(r.states, state)
+	return nil
+}
+
+func waitConfig(t *testing.T, url string) *config.Config {
+	t.Helper()
+	port, err := ftesting.FreePort()
+	require.NoError(t, err)
+	cfg := verifyConfig(url)
+	cfg.Output.Elasticsearch.MaxStartupWait = 5 * time.Second
+	cfg.Inputs = []config.Input{{Type: "fleet-server"}}
+	cfg.Inputs[0].Server.InitDefaults()
+	cfg.Inputs[0].Server.Host = "localhost"
+	cfg.Inputs[0].Server.Port = port
+	return cfg
+}
+
+func fetchVersion(cfg *config.Config) func(context.Context) error {
+	return func(ctx context.Context) error {
+		cli, err := es.NewClient(ctx, cfg, false)
+		if err != nil {
+			return err
+		}
+		_, err = es.FetchESVersion(ctx, cli)
+		return err
+	}
+}
+
+func Test_esWaiter(t *testing.T) {
+	t.Run("elasticsearch becomes available", func(t *testing.T) {
+		m := &mockES{version: "8.12.0", unavailable: 3}
+		srv := httptest.NewServer(m)
+		defer srv.Close()
+		cfg := waitConfig(t, srv.URL)
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+		reporter := &recordReporter{}
+		w := newESWaiter(cfg, build.Info{Version: "8.12.0"}, reporter)
+		defer w.stop()
+		statusURL := fmt.Sprintf("http://%s/api/status", cfg.Inputs[0].Server.BindEndpoints()[0])
+		checkinURL := fmt.Sprintf("http://%s/api/fleet/agents/agent-id/checkin", cfg.Inputs[0].Server.BindEndpoints()[0])
+		fetch := fetchVersion(cfg)
+		err := w.run(ctx, func(ctx context.Context) error {
+			if m.infoCalls.Load() == 2 {
+				// the unavailable listener is started after the first failure
+				require.EventuallyWithT(t, func(c *assert.CollectT) {
+					res, err := http.Get(statusURL) //nolint:noctx // test request
+					if !assert.NoError(c, err) {
+						return
+					}
+					defer res.Body.Close()
+					body, _ := io.ReadAll(res.Body)
+					assert.Equal(c, http.StatusServiceUnavailable, res.StatusCode)
+					assert.Contains(c, string(body), `"status":"DEGRADED"`)
+				}, time.Second, 10*time.Millisecond)
+
+				res, err := http.Post(checkinURL, "application/json", nil) //nolint:noctx // test request
+				require.NoError(t, err)
+				defer res.Body.Close()
+				body, _ := io.ReadAll(res.Body)
+				require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+				require.Contains(t, string(body), "ElasticsearchUnavailable")
+			}
+			return fetch(ctx)
+		})
+		require.NoError(t, err)
+		require.Equal(t, int32(4), m.infoCalls.Load())
+		require.Equal(t, 3, w.attempt)
+		require.Equal(t, []client.UnitState{client.UnitStateStarting}, reporter.states)
+
+		// the addresses are released once stopped
+		w.stop()
+		_, err = http.Get(statusURL) //nolint:noctx // test request
+		require.Error(t, err)
+	})
+
+	t.Run("unreachable elasticsearch is retried until the max wait", func(t *testing.T) {
+		srv := httptest.NewServer(&mockES{})
+		srv.Close()
+		cfg := waitConfig(t, srv.URL)
+		cfg.Output.Elasticsearch.MaxStartupWait = 100 * time.Millisecond
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+		w := newESWaiter(cfg, build.Info{}, nil)
+		defer w.stop()
+		start := time.Now()
+		err := w.run(ctx, fetchVersion(cfg))
+		require.ErrorContains(t, err, "gave up waiting for Elasticsearch at "+srv.URL+" after 100ms")
+		require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+		require.Greater(t, w.attempt, 1)
+	})
+
+	t.Run("missing index is retried", func(t *testing.T) {
+		cfg := waitConfig(t, "http://localhost:9200")
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+		w := newESWaiter(cfg, build.Info{}, nil)
+		defer w.stop()
+		var calls int
+		err := w.run(ctx, func(context.Context) error {
+			calls++
+			if calls < 3 {
+				return es.ErrIndexNotFound
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("rejected credentials are not retried", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("X-Elastic-Product", "Elasticsearch")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"type":"security_exception","reason":"unable to authenticate with provided credentials"}}`)
+		}))
+		defer srv.Close()
+		cfg := waitConfig(t, srv.URL)
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+
+		w := newESWaiter(cfg, build.Info{}, nil)
+		defer w.stop()
+		err := w.run(ctx, fetchVersion(cfg))
+		require.ErrorIs(t, err, es.ErrSecurityException)
+		require.Equal(t, int32(1), calls.Load())
+		require.Zero(t, w.attempt)
+	})
+
+	t.Run("cancelled while waiting", func(t *testing.T) {
+		cfg := waitConfig(t, "http://localhost:9200")
+		cfg.Output.Elasticsearch.Backoff = config.ESBackoff{Init: time.Minute, Max: time.Minute}
+		ctx, cancel := context.WithCancel(testlog.SetLogger(t).WithContext(context.Background()))
+
+		w := newESWaiter(cfg, build.Info{}, nil)
+		defer w.stop()
+		err := w.run(ctx, func(context.Context) error {
+			cancel()
+			return es.ErrIndexNotFound
+		})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,184 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package setup bootstraps the documents a stand-alone fleet-server needs when
+// there is no Kibana instance managing the .fleet-* indices.
+package setup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+
+	"github.com/rs/zerolog"
+	"go.elastic.co/apm/v2"
+)
+
+const kEnrollRolesJSON = `
+{
+	"fleet-apikey-enroll": {
+		"cluster": [],
+		"applications": [{
+			"application": "fleet",
+			"privileges": ["no-privileges"],
+			"resources": ["*"]
+		}]
+	}
+}
+`
+
+const defaultOutputName = "default"
+
+// Result describes the documents used by the stand-alone setup.
+type Result struct {
+	PolicyID        string
+	PolicyCreated   bool
+	EnrollmentToken string
+	KeyCreated      bool
+}
+
+// Run creates a default agent policy and an active enrollment API key bound to it.
+// Existing documents are reused so Run may safely be called multiple times.
+func Run(ctx context.Context, bulker bulk.Bulk, cfg *config.Config) (*Result, error) {
+	span, ctx := apm.StartSpan(ctx, "standaloneSetup", "process")
+	defer span.End()
+
+	setupCfg := cfg.Inputs[0].Server.StandaloneSetup
+	if setupCfg.PolicyID == "" {
+		return nil, errors.New("standalone setup requires a policy id")
+	}
+
+	res := &Result{PolicyID: setupCfg.PolicyID}
+
+	created, err := ensurePolicy(ctx, bulker, cfg, setupCfg)
+	if err != nil {
+		return nil, fmt.Errorf("standalone setup policy: %w", err)
+	}
+	res.PolicyCreated = created
+
+	token, created, err := ensureEnrollmentKey(ctx, bulker, setupCfg)
+	if err != nil {
+		return nil, fmt.Errorf("standalone setup enrollment key: %w", err)
+	}
+	res.EnrollmentToken = token
+	res.KeyCreated = created
+
+	zerolog.Ctx(ctx).Info().
+		Str(logger.PolicyID, res.PolicyID).
+		Bool("policy_created", res.PolicyCreated).
+		Bool("key_created", res.KeyCreated).
+		Msg("Standalone setup complete")
+	return res, nil
+}
+
+func ensurePolicy(ctx context.Context, bulker bulk.Bulk, cfg *config.Config, setupCfg config.StandaloneSetup) (bool, error) {
+	policies, err := dl.QueryLatestPolicies(ctx, bulker)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range policies {
+		if p.PolicyID == setupCfg.PolicyID {
+			zerolog.Ctx(ctx).Debug().Str(logger.PolicyID, p.PolicyID).Msg("Standalone policy already exists")
+			return false, nil
+		}
+	}
+
+	data, err := defaultPolicyData(cfg, setupCfg)
+	if err != nil {
+		return false, err
+	}
+	policy := model.Policy{
+		PolicyID:    setupCfg.PolicyID,
+		RevisionIdx: 1,
+		Data:        data,
+		Timestamp:   ftime.Now(),
+	}
+	if _, err := dl.CreatePolicy(ctx, bulker, policy); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func defaultPolicyData(cfg *config.Config, setupCfg config.StandaloneSetup) (*model.PolicyData, error) {
+	agent, err := json.Marshal(map[string]interface{}{
+		"monitoring": map[string]interface{}{
+			"enabled": false,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := json.Marshal(map[string]interface{}{
+		defaultOutputName: map[string]interface{}{
+			"_elastic_agent_checks": map[string]interface{}{
+				"cluster": []string{"monitor"},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	esCfg, err := cfg.Output.Elasticsearch.ToESConfig(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.PolicyData{
+		ID:       setupCfg.PolicyID,
+		Revision: 1,
+		Agent:    agent,
+		Outputs: map[string]map[string]interface{}{
+			defaultOutputName: {
+				"type":  "elasticsearch",
+				"hosts": esCfg.Addresses,
+			},
+		},
+		OutputPermissions: permissions,
+		Inputs:            []map[string]interface{}{},
+	}, nil
+}
+
+func ensureEnrollmentKey(ctx context.Context, bulker bulk.Bulk, setupCfg config.StandaloneSetup) (string, bool, error) {
+	keys, err := dl.FindEnrollmentAPIKeys(ctx, bulker, dl.QueryEnrollmentAPIKeyByPolicyID, dl.FieldPolicyID, setupCfg.PolicyID)
+	if err != nil && !errors.Is(err, es.ErrIndexNotFound) {
+		return "", false, err
+	}
+	for _, key := range keys {
+		if key.Active {
+			zerolog.Ctx(ctx).Debug().Str(logger.PolicyID, setupCfg.PolicyID).Msg("Standalone enrollment key already exists")
+			return key.APIKey, false, nil
+		}
+	}
+
+	meta := apikey.NewMetadata("", "", apikey.TypeEnroll)
+	key, err := bulker.APIKeyCreate(ctx, setupCfg.PolicyName, "", []byte(kEnrollRolesJSON), meta)
+	if err != nil {
+		return "", false, err
+	}
+
+	token := key.Token()
+	rec := model.EnrollmentAPIKey{
+		APIKey:    token,
+		APIKeyID:  key.ID,
+		Active:    true,
+		Name:      setupCfg.PolicyName,
+		PolicyID:  setupCfg.PolicyID,
+		CreatedAt: ftime.Now(),
+	}
+	if _, err := dl.CreateEnrollmentAPIKey(ctx, bulker, rec); err != nil {
+		return "", false, err
+	}
+	return token, true, nil
+}
@@ -0,0 +1,108 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package setup
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/apikey"
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+func testConfig() *config.Config {
+	var srv config.Server
+	srv.InitDefaults()
+	cfg := &config.Config{
+		Inputs: []config.Input{{Server: srv}},
+	}
+	cfg.Output.Elasticsearch.InitDefaults()
+	return cfg
+}
+
+func TestRunCreatesPolicyAndKey(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(t.Context())
+	cfg := testConfig()
+	policyID := cfg.Inputs[0].Server.StandaloneSetup.PolicyID
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetPolicies, mock.Anything, mock.Anything).Return(&es.ResultT{}, nil).Once()
+	bulker.On("Search", mock.Anything, dl.FleetEnrollmentAPIKeys, mock.Anything, mock.Anything).Return((*es.ResultT)(nil), es.ErrIndexNotFound).Once()
+	bulker.On("Create", mock.Anything, dl.FleetPolicies, "", mock.MatchedBy(func(body []byte) bool {
+		var p model.Policy
+		if err := json.Unmarshal(body, &p); err != nil {
+			return false
+		}
+		return p.PolicyID == policyID && p.RevisionIdx == 1 && p.Data != nil &&
+			p.Data.ID == policyID && p.Data.Outputs["default"]["type"] == "elasticsearch"
+	}), mock.Anything).Return("policy-doc", nil).Once()
+	bulker.On("APIKeyCreate", mock.Anything, cfg.Inputs[0].Server.StandaloneSetup.PolicyName, "", mock.Anything, apikey.NewMetadata("", "", apikey.TypeEnroll)).
+		Return(&bulk.APIKey{ID: "key-id", Key: "key"}, nil).Once()
+	token := apikey.APIKey{ID: "key-id", Key: "key"}.Token()
+	bulker.On("Create", mock.Anything, dl.FleetEnrollmentAPIKeys, "", mock.MatchedBy(func(body []byte) bool {
+		var k model.EnrollmentAPIKey
+		if err := json.Unmarshal(body, &k); err != nil {
+			return false
+		}
+		return k.Active && k.APIKeyID == "key-id" && k.APIKey == token && k.PolicyID == policyID
+	}), mock.Anything).Return("key-doc", nil).Once()
+
+	res, err := Run(ctx, bulker, cfg)
+	require.NoError(t, err)
+	require.Equal(t, &Result{
+		PolicyID:        policyID,
+		PolicyCreated:   true,
+		EnrollmentToken: token,
+		KeyCreated:      true,
+	}, res)
+	bulker.AssertExpectations(t)
+}
+
+func TestRunIsIdempotent(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(t.Context())
+	cfg := testConfig()
+	policyID := cfg.Inputs[0].Server.StandaloneSetup.PolicyID
+
+	policy, err := json.Marshal(model.Policy{PolicyID: policyID, RevisionIdx: 1})
+	require.NoError(t, err)
+	key, err := json.Marshal(model.EnrollmentAPIKey{APIKey: "existing-token", APIKeyID: "key-id", Active: true, PolicyID: policyID})
+	require.NoError(t, err)
+
+	bulker := ftesting.NewMockBulk()
+	bulker.On("Search", mock.Anything, dl.FleetPolicies, mock.Anything, mock.Anything).Return(&es.ResultT{
+		Aggregations: map[string]es.Aggregation{
+			dl.FieldPolicyID: {
+				Buckets: []es.Bucket{{
+					Key: policyID,
+					Aggregations: map[string]es.HitsT{
+						dl.FieldRevisionIdx: {Hits: []es.HitT{{Source: policy}}},
+					},
+				}},
+			},
+		},
+	}, nil).Once()
+	bulker.On("Search", mock.Anything, dl.FleetEnrollmentAPIKeys, mock.Anything, mock.Anything).Return(&es.ResultT{
+		HitsT: es.HitsT{Hits: []es.HitT{{Source: key}}},
+	}, nil).Once()
+
+	res, err := Run(ctx, bulker, cfg)
+	require.NoError(t, err)
+	require.Equal(t, &Result{
+		PolicyID:        policyID,
+		EnrollmentToken: "existing-token",
+	}, res)
+	bulker.AssertExpectations(t)
+	bulker.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	bulker.AssertNotCalled(t, "APIKeyCreate", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
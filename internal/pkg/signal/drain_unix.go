@@ -0,0 +1,18 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !windows
+
+package signal
+
+import (
+	"context"
+	"syscall"
+)
+
+// HandleDrain returns a channel that receives a value each time the process receives a SIGUSR1, the request to start draining.
+// The channel is closed when ctx is cancelled.
+func HandleDrain(ctx context.Context) <-chan struct{} {
+	return handle(ctx, "SIGUSR1", syscall.SIGUSR1)
+}
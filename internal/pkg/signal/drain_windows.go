@@ -0,0 +1,15 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build windows
+
+package signal
+
+import "context"
+
+// HandleDrain returns a nil channel, there is no SIGUSR1 on windows. Draining is started with the /drain endpoint of
+// the monitoring listener.
+func HandleDrain(context.Context) <-chan struct{} {
+	return nil
+}
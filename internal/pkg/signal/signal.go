@@ -40,3 +40,39 @@ func HandleInterrupt(ctx context.Context) context.Context {
 
 	return ctx
 }
+
+// HandleHangup returns a channel that receives a value each time the process receives a SIGHUP.
+// The channel is closed when ctx is cancelled.
+func HandleHangup(ctx context.Context) <-chan struct{} {
+	return handle(ctx, "SIGHUP", syscall.SIGHUP)
+}
+
+// handle returns a channel that receives a value each time the process receives sig, name is the name of sig in the logs.
+// The values are coalesced while the channel is not read. The channel is closed when ctx is cancelled.
+func handle(ctx context.Context, name string, sig os.Signal) <-chan struct{} {
+	log := zerolog.Ctx(ctx)
+	ch := make(chan struct{}, 1)
+
+	log.Debug().Msg("Install signal handler for " + name)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, sig)
+
+	go func() {
+		defer close(ch)
+		defer signal.Stop(sigs)
+		for {
+			select {
+			case <-sigs:
+				log.Info().Msg("On " + name)
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
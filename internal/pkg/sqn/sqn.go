@@ -6,14 +6,22 @@
 package sqn
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 )
 
 const UndefinedSeqNo = -1
 
+// tokenPrefix distinguishes SeqNo tokens from document id tokens.
+const tokenPrefix = "sqn:"
+
 var DefaultSeqNo = []int64{UndefinedSeqNo}
 
+// ErrNotToken is returned by ParseToken when the value was not created by Token.
+var ErrNotToken = errors.New("not a seqno token")
+
 // SeqNo abstracts the array of document seq numbers.
 type SeqNo []int64
 
@@ -77,3 +85,82 @@ func (s SeqNo) Clone() SeqNo {
 	copy(r, s)
 	return r
 }
+
+// Parse parses a comma separated list of seq numbers as returned by String.
+func Parse(s string) (SeqNo, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	r := make(SeqNo, len(parts))
+	for i, part := range parts {
+		v, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid seqno %q: %w", s, err)
+		}
+		if v < UndefinedSeqNo {
+			return nil, fmt.Errorf("invalid seqno %q: negative value", s)
+		}
+		r[i] = v
+	}
+	return r, nil
+}
+
+// Token returns SeqNo encoded as an opaque token that can be handed to agents and decoded with ParseToken.
+func (s SeqNo) Token() string {
+	return tokenPrefix + s.String()
+}
+
+// ParseToken decodes a token created by Token.
+// ErrNotToken is returned if the token does not hold a SeqNo.
+func ParseToken(token string) (SeqNo, error) {
+	v, ok := strings.CutPrefix(token, tokenPrefix)
+	if !ok {
+		return nil, ErrNotToken
+	}
+	s, err := Parse(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(s) == 0 {
+		return nil, fmt.Errorf("invalid seqno token %q: empty", token)
+	}
+	return s, nil
+}
+
+// at returns the seq number for the shard at index i, or UndefinedSeqNo if there is none.
+func (s SeqNo) at(i int) int64 {
+	if i < len(s) {
+		return s[i]
+	}
+	return UndefinedSeqNo
+}
+
+// Compare compares the seq numbers shard by shard, shards missing from the shorter SeqNo are treated as UndefinedSeqNo.
+// It returns -1 if s is before o, 1 if s is after o and 0 if they are equal.
+// The first differing shard decides the result.
+func (s SeqNo) Compare(o SeqNo) int {
+	for i := 0; i < max(len(s), len(o)); i++ {
+		a, b := s.at(i), o.at(i)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	}
+	return 0
+}
+
+// Max returns the highest seq number of each shard in s and o.
+func (s SeqNo) Max(o SeqNo) SeqNo {
+	n := max(len(s), len(o))
+	if n == 0 {
+		return nil
+	}
+	r := make(SeqNo, n)
+	for i := range r {
+		r[i] = max(s.at(i), o.at(i))
+	}
+	return r
+}
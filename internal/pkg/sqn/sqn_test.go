@@ -0,0 +1,177 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package sqn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeqNoString(t *testing.T) {
+	tests := []struct {
+		name string
+		seq  SeqNo
+		str  string
+		json string
+	}{
+		{"nil", nil, "", "[]"},
+		{"empty", SeqNo{}, "", "[]"},
+		{"single", SeqNo{42}, "42", "[42]"},
+		{"undefined", SeqNo{UndefinedSeqNo}, "-1", "[-1]"},
+		{"multiple shards", SeqNo{1, 20, 300}, "1,20,300", "[1,20,300]"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.str, tc.seq.String())
+			assert.Equal(t, tc.json, tc.seq.JSONString())
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		str    string
+		seq    SeqNo
+		hasErr bool
+	}{
+		{"empty", "", nil, false},
+		{"single", "42", SeqNo{42}, false},
+		{"zero", "0", SeqNo{0}, false},
+		{"undefined", "-1", SeqNo{UndefinedSeqNo}, false},
+		{"multiple shards", "1,20,300", SeqNo{1, 20, 300}, false},
+		{"spaces", "1, 2", SeqNo{1, 2}, false},
+		{"not a number", "abc", nil, true},
+		{"empty shard", "1,,2", nil, true},
+		{"below undefined", "-2", nil, true},
+		{"overflow", "9223372036854775808", nil, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			seq, err := Parse(tc.str)
+			if tc.hasErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.seq, seq)
+		})
+	}
+}
+
+func TestParseStringRoundTrip(t *testing.T) {
+	for _, seq := range []SeqNo{{0}, {UndefinedSeqNo}, {7}, {3, 0, 9}} {
+		parsed, err := Parse(seq.String())
+		require.NoError(t, err)
+		assert.Equal(t, seq, parsed)
+	}
+}
+
+func TestToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		seq    SeqNo
+		err    error
+		hasErr bool
+	}{
+		{"single", "sqn:42", SeqNo{42}, nil, false},
+		{"multiple shards", "sqn:1,2", SeqNo{1, 2}, nil, false},
+		{"document id", "a5fe4ba4-18d4-4a67-8a3f-ae5f0bc4c1e3", nil, ErrNotToken, true},
+		{"empty", "", nil, ErrNotToken, true},
+		{"no value", "sqn:", nil, nil, true},
+		{"invalid value", "sqn:abc", nil, nil, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			seq, err := ParseToken(tc.token)
+			if tc.hasErr {
+				require.Error(t, err)
+				if tc.err != nil {
+					require.ErrorIs(t, err, tc.err)
+				} else {
+					require.NotErrorIs(t, err, ErrNotToken)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.seq, seq)
+			assert.Equal(t, tc.token, seq.Token())
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   SeqNo
+		result int
+	}{
+		{"both empty", nil, nil, 0},
+		{"equal", SeqNo{5}, SeqNo{5}, 0},
+		{"less", SeqNo{4}, SeqNo{5}, -1},
+		{"greater", SeqNo{6}, SeqNo{5}, 1},
+		{"empty is undefined", nil, SeqNo{UndefinedSeqNo}, 0},
+		{"empty before zero", nil, SeqNo{0}, -1},
+		{"multiple shards equal", SeqNo{1, 2}, SeqNo{1, 2}, 0},
+		{"first shard decides", SeqNo{2, 0}, SeqNo{1, 9}, 1},
+		{"second shard decides", SeqNo{1, 3}, SeqNo{1, 9}, -1},
+		{"missing shard is undefined", SeqNo{1}, SeqNo{1, 0}, -1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.result, tc.a.Compare(tc.b))
+			assert.Equal(t, -tc.result, tc.b.Compare(tc.a))
+		})
+	}
+}
+
+func TestMax(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   SeqNo
+		result SeqNo
+	}{
+		{"both empty", nil, nil, nil},
+		{"one empty", nil, SeqNo{3}, SeqNo{3}},
+		{"single", SeqNo{3}, SeqNo{7}, SeqNo{7}},
+		{"per shard", SeqNo{1, 9}, SeqNo{5, 2}, SeqNo{5, 9}},
+		{"different lengths", SeqNo{4}, SeqNo{1, 2}, SeqNo{4, 2}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.result, tc.a.Max(tc.b))
+			assert.Equal(t, tc.result, tc.b.Max(tc.a))
+		})
+	}
+}
+
+func TestMaxDoesNotModify(t *testing.T) {
+	a := SeqNo{1, 9}
+	b := SeqNo{5, 2}
+	_ = a.Max(b)
+	assert.Equal(t, SeqNo{1, 9}, a)
+	assert.Equal(t, SeqNo{5, 2}, b)
+}
+
+func TestIsSetValue(t *testing.T) {
+	assert.False(t, SeqNo(nil).IsSet())
+	assert.False(t, SeqNo(DefaultSeqNo).IsSet())
+	assert.True(t, SeqNo{0}.IsSet())
+	assert.Equal(t, int64(UndefinedSeqNo), SeqNo(nil).Value())
+	assert.Equal(t, int64(3), SeqNo{3, 4}.Value())
+}
+
+func TestClone(t *testing.T) {
+	assert.Nil(t, SeqNo(nil).Clone())
+	s := SeqNo{1, 2}
+	c := s.Clone()
+	c[0] = 5
+	assert.Equal(t, SeqNo{1, 2}, s)
+}
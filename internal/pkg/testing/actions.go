@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
 	"github.com/elastic/fleet-server/v7/internal/pkg/model"
 	"github.com/elastic/fleet-server/v7/internal/pkg/testing/rnd"
 
@@ -124,8 +125,8 @@ func CreateRandomActions(opts ...CreateActionsOpt) ([]model.Action, error) {
 				Id: xid.New().String(),
 			},
 			ActionID:   uuid.Must(uuid.NewV4()).String(),
-			Timestamp:  timestamp.Format(time.RFC3339),
-			Expiration: expiration.Format(time.RFC3339),
+			Timestamp:  ftime.Format(timestamp),
+			Expiration: ftime.Format(expiration),
 			Type:       "APP_ACTION",
 			InputType:  "osquery",
 			Agents:     aid,
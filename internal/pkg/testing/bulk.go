@@ -6,6 +6,7 @@ package testing
 
 import (
 	"context"
+	"sync"
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/rs/zerolog"
@@ -13,12 +14,16 @@ import (
 	"go.elastic.co/apm/v2"
 
 	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
 	"github.com/elastic/fleet-server/v7/internal/pkg/es"
 )
 
 // MockBulk is a mock bulk interface that uses testify/mock.
 type MockBulk struct {
 	mock.Mock
+
+	healthMu sync.Mutex
+	health   map[string]bulk.OutputHealth
 }
 
 func NewMockBulk() *MockBulk {
@@ -80,6 +85,20 @@ func (m *MockBulk) Search(ctx context.Context, index string, body []byte, opts .
 	return args.Get(0).(*es.ResultT), args.Error(1)
 }
 
+// SearchStream passes the hits of the mocked Search to fn, so the tests mock both searches with Search.
+func (m *MockBulk) SearchStream(ctx context.Context, index string, body []byte, fn func(hit *es.HitT) error, opts ...bulk.Opt) error {
+	res, err := m.Search(ctx, index, body, opts...)
+	if err != nil {
+		return err
+	}
+	for i := range res.Hits {
+		if err := fn(&res.Hits[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (m *MockBulk) Client() *elasticsearch.Client {
 	args := m.Called()
 	return args.Get(0).(*elasticsearch.Client)
@@ -100,7 +119,14 @@ func (m *MockBulk) GetBulkerMap() map[string]bulk.Bulk {
 
 func (m *MockBulk) CreateAndGetBulker(ctx context.Context, zlog zerolog.Logger, outputName string, outputMap map[string]map[string]interface{}) (bulk.Bulk, bool, error) {
 	args := m.Called(ctx, zlog, outputName, outputMap)
-	return args.Get(0).(bulk.Bulk), args.Get(1).(bool), nil
+	var err error
+	if len(args) > 2 {
+		err = args.Error(2)
+	}
+	if args.Get(0) == nil {
+		return nil, args.Get(1).(bool), err
+	}
+	return args.Get(0).(bulk.Bulk), args.Get(1).(bool), err
 }
 
 func (m *MockBulk) CancelFn() context.CancelFunc {
@@ -108,6 +134,8 @@ func (m *MockBulk) CancelFn() context.CancelFunc {
 	return args.Get(0).(context.CancelFunc)
 }
 
+func (m *MockBulk) Reload(*config.Server) {}
+
 func (m *MockBulk) ReadSecrets(ctx context.Context, secretIds []string) (map[string]string, error) {
 	result := make(map[string]string)
 	for _, id := range secretIds {
@@ -121,11 +149,21 @@ func (m *MockBulk) APIKeyCreate(ctx context.Context, name, ttl string, roles []b
 	return args.Get(0).(*bulk.APIKey), args.Error(1)
 }
 
+func (m *MockBulk) APIKeyMCreate(ctx context.Context, reqs []bulk.APIKeyCreateRequest) ([]bulk.APIKeyCreateResult, error) {
+	args := m.Called(ctx, reqs)
+	return args.Get(0).([]bulk.APIKeyCreateResult), args.Error(1)
+}
+
 func (m *MockBulk) APIKeyRead(ctx context.Context, id string, _ bool) (*bulk.APIKeyMetadata, error) {
 	args := m.Called(ctx, id)
 	return args.Get(0).(*bulk.APIKeyMetadata), args.Error(1)
 }
 
+func (m *MockBulk) APIKeyQuery(ctx context.Context, body []byte) ([]bulk.APIKeyQueryHit, error) {
+	args := m.Called(ctx, body)
+	return args.Get(0).([]bulk.APIKeyQueryHit), args.Error(1)
+}
+
 func (m *MockBulk) APIKeyAuth(ctx context.Context, key bulk.APIKey) (*bulk.SecurityInfo, error) {
 	args := m.Called(ctx, key)
 	return args.Get(0).(*bulk.SecurityInfo), args.Error(1)
@@ -157,4 +195,23 @@ func (m *MockBulk) RemoteOutputConfigChanged(zlog zerolog.Logger, name string, n
 	return name == "outdated"
 }
 
+func (m *MockBulk) SetRemoteOutputHealth(outputName string, health bulk.OutputHealth) {
+	m.healthMu.Lock()
+	defer m.healthMu.Unlock()
+	if m.health == nil {
+		m.health = make(map[string]bulk.OutputHealth)
+	}
+	m.health[outputName] = health
+}
+
+func (m *MockBulk) RemoteOutputHealth() map[string]bulk.OutputHealth {
+	m.healthMu.Lock()
+	defer m.healthMu.Unlock()
+	health := make(map[string]bulk.OutputHealth, len(m.health))
+	for k, v := range m.health {
+		health[k] = v
+	}
+	return health
+}
+
 var _ bulk.Bulk = (*MockBulk)(nil)
@@ -25,6 +25,11 @@ func (m *MockCache) Reconfigure(cfg config.Cache) error {
 	return args.Error(0)
 }
 
+func (m *MockCache) Register(name string, r corecache.Reconfigurable) error {
+	args := m.Called(name, r)
+	return args.Error(0)
+}
+
 func (m *MockCache) SetAction(action model.Action) {
 	m.Called(action)
 }
@@ -52,13 +57,13 @@ func (m *MockCache) GetEnrollmentAPIKey(id string) (model.EnrollmentAPIKey, bool
 	return args.Get(0).(model.EnrollmentAPIKey), args.Bool(1)
 }
 
-func (m *MockCache) SetArtifact(artifact model.Artifact) {
+func (m *MockCache) SetArtifact(artifact corecache.Artifact) {
 	m.Called(artifact)
 }
 
-func (m *MockCache) GetArtifact(ident, sha2 string) (model.Artifact, bool) {
+func (m *MockCache) GetArtifact(ident, sha2 string) (corecache.Artifact, bool) {
 	args := m.Called(ident, sha2)
-	return args.Get(0).(model.Artifact), args.Bool(1)
+	return args.Get(0).(corecache.Artifact), args.Bool(1)
 }
 
 func (m *MockCache) SetUpload(id string, info file.Info) {
@@ -78,3 +83,39 @@ func (m *MockCache) GetPGPKey(id string) ([]byte, bool) {
 	args := m.Called(id)
 	return args.Get(0).([]byte), args.Bool(1)
 }
+
+func (m *MockCache) SetEnrollReplay(key string, replay corecache.EnrollReplay) {
+	m.Called(key, replay)
+}
+
+func (m *MockCache) GetEnrollReplay(key string) (corecache.EnrollReplay, bool) {
+	args := m.Called(key)
+	return args.Get(0).(corecache.EnrollReplay), args.Bool(1)
+}
+
+func (m *MockCache) SetNotFound(kind corecache.DocKind, id string) {
+	m.Called(kind, id)
+}
+
+func (m *MockCache) NotFound(kind corecache.DocKind, id string) bool {
+	args := m.Called(kind, id)
+	return args.Bool(0)
+}
+
+func (m *MockCache) InvalidateNotFound(kind corecache.DocKind, id string) {
+	m.Called(kind, id)
+}
+
+func (m *MockCache) SetAgentVersion(v corecache.AgentVersion) {
+	m.Called(v)
+}
+
+func (m *MockCache) GetAgentVersion(agentID string) (corecache.AgentVersion, bool) {
+	args := m.Called(agentID)
+	return args.Get(0).(corecache.AgentVersion), args.Bool(1)
+}
+
+func (m *MockCache) GetAgentVersionByAPIKey(apiKeyID string) (corecache.AgentVersion, bool) {
+	args := m.Called(apiKeyID)
+	return args.Get(0).(corecache.AgentVersion), args.Bool(1)
+}
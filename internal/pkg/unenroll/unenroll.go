@@ -0,0 +1,172 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package unenroll handles the unenrollment of the agents and the invalidation of their API keys.
+//
+// The unenrollment is persisted on the agent document so a partially completed unenrollment can be resumed:
+//
+//  1. unenrolling: unenrollment_started_at is set when the UNENROLL action is created.
+//  2. unenrolled: the agent is inactive, unenrolled_at is set and api_keys_invalidation_pending is true.
+//  3. invalidated: the API keys are invalidated, api_keys_invalidated_at is set and the pending flag is removed.
+//
+// The agent document is updated before the API keys are invalidated. An agent is never locked out while it is still
+// shown active, and the keys of an unenrolled agent stay pending until a retry invalidates them.
+package unenroll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/esclock"
+	"github.com/elastic/fleet-server/v7/internal/pkg/ftime"
+	"github.com/elastic/fleet-server/v7/internal/pkg/logger"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+)
+
+// ReasonTimeout is the unenrolled_reason of the agents that did not ack their UNENROLL action in time.
+const ReasonTimeout = "timeout"
+
+// timeNow is used to get the current time, aligned on the elasticsearch clock. It should be replaced for testing.
+var timeNow = esclock.SkewedNow
+
+// Unenroll moves the agent to the unenrolled state, then invalidates its API keys.
+// An error is returned when the agent document is not updated, the agent is then unchanged and the unenrollment can be retried.
+// The update is conditioned on the state the agent was read with, an agent that was unenrolled meanwhile by another writer is not
+// updated again, only its API keys are invalidated if they were not yet.
+// A failed invalidation is only logged, the keys stay pending on the agent document.
+func Unenroll(ctx context.Context, bulker bulk.Bulk, agent *model.Agent, reason string) error {
+	span, ctx := apm.StartSpan(ctx, "unenroll", "process")
+	defer span.End()
+
+	now := ftime.Format(timeNow())
+	_, err := dl.UpdateAgent(ctx, bulker, agent, func(agent *model.Agent) bulk.UpdateFields {
+		if !agent.Active || agent.UnenrolledAt != "" {
+			return nil
+		}
+		doc := bulk.UpdateFields{
+			dl.FieldActive:                     false,
+			dl.FieldUnenrolledAt:               now,
+			dl.FieldUpdatedAt:                  now,
+			dl.FieldLifecycleState:             model.AgentStateUnenrolled,
+			dl.FieldAPIKeysInvalidationPending: true,
+		}
+		if reason != "" {
+			doc[dl.FieldUnenrolledReason] = reason
+		}
+		return doc
+	})
+	if err != nil {
+		return fmt.Errorf("unenroll update: %w", err)
+	}
+	if agent.APIKeysInvalidatedAt != "" {
+		return nil
+	}
+
+	if err := InvalidatePending(ctx, bulker, agent); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str(logger.AgentID, agent.Id).Msg("API keys of the unenrolled agent remain to be invalidated")
+	}
+	return nil
+}
+
+// InvalidateInactive invalidates the API keys of an agent that was made inactive outside of fleet-server, for instance
+// when it is force unenrolled from Kibana. The keys are marked pending first so a failed invalidation is retried.
+// Nothing is done for an active agent, or once the keys were invalidated.
+func InvalidateInactive(ctx context.Context, bulker bulk.Bulk, agent *model.Agent) error {
+	if agent.Active || agent.APIKeysInvalidatedAt != "" {
+		return nil
+	}
+	if !agent.APIKeysInvalidationPending {
+		if err := update(ctx, bulker, agent.Id, bulk.UpdateFields{dl.FieldAPIKeysInvalidationPending: true}); err != nil {
+			return fmt.Errorf("invalidate inactive update: %w", err)
+		}
+	}
+	return InvalidatePending(ctx, bulker, agent)
+}
+
+// InvalidatePending invalidates the API keys of the unenrolled agent, then records the invalidation on the agent document.
+// The keys stay pending when the invalidation or the update fails, invalidating them again is harmless.
+func InvalidatePending(ctx context.Context, bulker bulk.Bulk, agent *model.Agent) error {
+	span, ctx := apm.StartSpan(ctx, "invalidatePending", "process")
+	defer span.End()
+
+	apiKeys := agent.APIKeyIDs()
+	zerolog.Ctx(ctx).Info().Str(logger.AgentID, agent.Id).Any("fleet.policy.apiKeyIDsToRetire", apiKeys).Msg("invalidate API keys of unenrolled agent")
+	if err := InvalidateAPIKeys(ctx, bulker, apiKeys, ""); err != nil {
+		return err
+	}
+
+	doc := bulk.UpdateFields{
+		dl.FieldAPIKeysInvalidationPending: nil,
+		dl.FieldAPIKeysInvalidatedAt:       ftime.Format(timeNow()),
+	}
+	if err := update(ctx, bulker, agent.Id, doc); err != nil {
+		return fmt.Errorf("invalidate pending update: %w", err)
+	}
+	return nil
+}
+
+// InvalidateAPIKeys invalidates the API keys, except skip, with the bulker of the output that created them.
+// The keys of an output that is no longer found in any policy are orphaned, they are logged but not returned as an error.
+func InvalidateAPIKeys(ctx context.Context, bulker bulk.Bulk, toRetireAPIKeyIDs []model.ToRetireAPIKeyIdsItems, skip string) error {
+	zlog := zerolog.Ctx(ctx)
+	ids := make([]string, 0, len(toRetireAPIKeyIDs))
+	remoteIds := make(map[string][]string)
+	for _, k := range toRetireAPIKeyIDs {
+		if k.ID == skip || k.ID == "" {
+			continue
+		}
+		if k.Output != "" {
+			remoteIds[k.Output] = append(remoteIds[k.Output], k.ID)
+		} else {
+			ids = append(ids, k.ID)
+		}
+	}
+
+	var errs []error
+	if len(ids) > 0 {
+		zlog.Info().Strs("fleet.policy.apiKeyIDsToRetire", ids).Msg("Invalidate old API keys")
+		if err := bulker.APIKeyInvalidate(ctx, ids...); err != nil {
+			zlog.Info().Err(err).Strs("ids", ids).Msg("Failed to invalidate API keys")
+			errs = append(errs, fmt.Errorf("invalidate API keys: %w", err))
+		}
+	}
+	// using remote es bulker to invalidate api key
+	for outputName, outputIds := range remoteIds {
+		outputBulk := bulker.GetBulker(outputName)
+
+		if outputBulk == nil {
+			// read output config from .fleet-policies, not filtering by policy id as agent could be reassigned
+			policy, err := dl.QueryOutputFromPolicy(ctx, bulker, outputName)
+			if err != nil || policy == nil {
+				zlog.Warn().Str(logger.PolicyOutputName, outputName).Any("ids", outputIds).Msg("Output policy not found, API keys will be orphaned")
+				continue
+			}
+			outputBulk, _, err = bulker.CreateAndGetBulker(ctx, *zlog, outputName, policy.Data.Outputs)
+			if err != nil {
+				zlog.Warn().Str(logger.PolicyOutputName, outputName).Any("ids", outputIds).Msg("Failed to recreate output bulker, API keys will be orphaned")
+				errs = append(errs, fmt.Errorf("output %s bulker: %w", outputName, err))
+				continue
+			}
+		}
+		if err := outputBulk.APIKeyInvalidate(ctx, outputIds...); err != nil {
+			zlog.Info().Err(err).Strs("ids", outputIds).Str(logger.PolicyOutputName, outputName).Msg("Failed to invalidate API keys")
+			errs = append(errs, fmt.Errorf("invalidate output %s API keys: %w", outputName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func update(ctx context.Context, bulker bulk.Bulk, agentID string, doc bulk.UpdateFields) error {
+	body, err := doc.Marshal()
+	if err != nil {
+		return err
+	}
+	return bulker.Update(ctx, dl.FleetAgents, agentID, body, bulk.WithRefresh(), bulk.WithRetryOnConflict(3))
+}
@@ -0,0 +1,301 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package unenroll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/bulk"
+	"github.com/elastic/fleet-server/v7/internal/pkg/dl"
+	"github.com/elastic/fleet-server/v7/internal/pkg/es"
+	"github.com/elastic/fleet-server/v7/internal/pkg/model"
+	ftesting "github.com/elastic/fleet-server/v7/internal/pkg/testing"
+	testlog "github.com/elastic/fleet-server/v7/internal/pkg/testing/log"
+)
+
+// step is a call made by the unenrollment: an update of the agent document, or an invalidation of API keys.
+type step struct {
+	doc  map[string]interface{}
+	keys []string
+	err  error
+}
+
+// recordSteps records the agent updates and the API keys invalidations made with bulker in order.
+// The first calls fail with updateErrs and invalidateErrs, the next calls succeed.
+func recordSteps(t *testing.T, bulker *ftesting.MockBulk, updateErrs, invalidateErrs []error) *[]step {
+	t.Helper()
+	var steps []step
+	onUpdate := func(err error) func(mock.Arguments) {
+		return func(args mock.Arguments) {
+			var body struct {
+				Doc map[string]interface{} `json:"doc"`
+			}
+			require.NoError(t, json.Unmarshal(args.Get(3).([]byte), &body))
+			steps = append(steps, step{doc: body.Doc, err: err})
+		}
+	}
+	onInvalidate := func(err error) func(mock.Arguments) {
+		return func(args mock.Arguments) {
+			steps = append(steps, step{keys: args.Get(1).([]string), err: err})
+		}
+	}
+	for _, err := range updateErrs {
+		bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything, mock.Anything).Run(onUpdate(err)).Return(err).Once()
+	}
+	bulker.On("Update", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything, mock.Anything).Run(onUpdate(nil)).Return(nil)
+	for _, err := range invalidateErrs {
+		bulker.On("APIKeyInvalidate", mock.Anything, mock.Anything).Run(onInvalidate(err)).Return(err).Once()
+	}
+	bulker.On("APIKeyInvalidate", mock.Anything, mock.Anything).Run(onInvalidate(nil)).Return(nil)
+	return &steps
+}
+
+func setNow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+}
+
+func newAgent() *model.Agent {
+	return &model.Agent{
+		ESDocument:     model.ESDocument{Id: "agent-1"},
+		Active:         true,
+		AccessAPIKeyID: "access-key",
+		LifecycleState: string(model.AgentStateUnenrolling),
+	}
+}
+
+var (
+	unenrolledDoc = map[string]interface{}{
+		dl.FieldActive:                     false,
+		dl.FieldUnenrolledAt:               "2024-01-01T12:00:00.000Z",
+		dl.FieldUpdatedAt:                  "2024-01-01T12:00:00.000Z",
+		dl.FieldLifecycleState:             string(model.AgentStateUnenrolled),
+		dl.FieldAPIKeysInvalidationPending: true,
+	}
+	invalidatedDoc = map[string]interface{}{
+		dl.FieldAPIKeysInvalidationPending: nil,
+		dl.FieldAPIKeysInvalidatedAt:       "2024-01-01T12:00:00.000Z",
+	}
+)
+
+func withReason(doc map[string]interface{}, reason string) map[string]interface{} {
+	doc = maps.Clone(doc)
+	doc[dl.FieldUnenrolledReason] = reason
+	return doc
+}
+
+func TestUnenroll(t *testing.T) {
+	setNow(t)
+	errFailed := errors.New("failed")
+	errConflict := &es.ErrElastic{Status: http.StatusConflict, Type: "version_conflict_engine_exception"}
+
+	t.Run("ok", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		steps := recordSteps(t, bulker, nil, nil)
+
+		require.NoError(t, Unenroll(ctx, bulker, newAgent(), ""))
+		// the agent is unenrolled before its keys are invalidated
+		assert.Equal(t, []step{
+			{doc: unenrolledDoc},
+			{keys: []string{"access-key"}},
+			{doc: invalidatedDoc},
+		}, *steps)
+	})
+
+	t.Run("reason", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		steps := recordSteps(t, bulker, nil, nil)
+
+		require.NoError(t, Unenroll(ctx, bulker, newAgent(), ReasonTimeout))
+		require.NotEmpty(t, *steps)
+		assert.Equal(t, ReasonTimeout, (*steps)[0].doc[dl.FieldUnenrolledReason])
+	})
+
+	t.Run("unenrolled update fails", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		steps := recordSteps(t, bulker, []error{errFailed}, nil)
+
+		require.ErrorIs(t, Unenroll(ctx, bulker, newAgent(), ""), errFailed)
+		// the keys of the agent that is still active are not invalidated
+		assert.Equal(t, []step{{doc: unenrolledDoc, err: errFailed}}, *steps)
+	})
+
+	t.Run("invalidation fails", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		steps := recordSteps(t, bulker, nil, []error{errFailed})
+
+		require.NoError(t, Unenroll(ctx, bulker, newAgent(), ""))
+		// the keys stay pending on the unenrolled agent
+		assert.Equal(t, []step{
+			{doc: unenrolledDoc},
+			{keys: []string{"access-key"}, err: errFailed},
+		}, *steps)
+	})
+
+	t.Run("unenrolled by another writer", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		steps := recordSteps(t, bulker, []error{errConflict}, nil)
+		bulker.On("ReadRaw", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything).Return(&bulk.MgetResponseItem{
+			SeqNo:       8,
+			PrimaryTerm: 1,
+			Source:      []byte(`{"active":false,"unenrolled_at":"2024-01-01T11:00:00Z","access_api_key_id":"access-key"}`),
+		}, nil).Once()
+		agent := newAgent()
+		agent.SeqNo, agent.PrimaryTerm = 7, 1
+
+		require.NoError(t, Unenroll(ctx, bulker, agent, ReasonTimeout))
+		// the agent is not unenrolled again, its keys are still invalidated
+		assert.Equal(t, []step{
+			{doc: withReason(unenrolledDoc, ReasonTimeout), err: errConflict},
+			{keys: []string{"access-key"}},
+			{doc: invalidatedDoc},
+		}, *steps)
+		assert.Equal(t, "2024-01-01T11:00:00Z", agent.UnenrolledAt)
+	})
+
+	t.Run("keys invalidated by another writer", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		steps := recordSteps(t, bulker, []error{errConflict}, nil)
+		bulker.On("ReadRaw", mock.Anything, dl.FleetAgents, "agent-1", mock.Anything).Return(&bulk.MgetResponseItem{
+			SeqNo:       9,
+			PrimaryTerm: 1,
+			Source:      []byte(`{"active":false,"unenrolled_at":"2024-01-01T11:00:00Z","api_keys_invalidated_at":"2024-01-01T11:00:00Z"}`),
+		}, nil).Once()
+		agent := newAgent()
+		agent.SeqNo, agent.PrimaryTerm = 7, 1
+
+		require.NoError(t, Unenroll(ctx, bulker, agent, ""))
+		assert.Equal(t, []step{{doc: unenrolledDoc, err: errConflict}}, *steps)
+	})
+
+	t.Run("invalidated update fails", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		steps := recordSteps(t, bulker, []error{nil, errFailed}, nil)
+
+		require.NoError(t, Unenroll(ctx, bulker, newAgent(), ""))
+		assert.Equal(t, []step{
+			{doc: unenrolledDoc},
+			{keys: []string{"access-key"}},
+			{doc: invalidatedDoc, err: errFailed},
+		}, *steps)
+	})
+}
+
+func TestInvalidatePendingRetry(t *testing.T) {
+	setNow(t)
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	errFailed := errors.New("failed")
+	bulker := ftesting.NewMockBulk()
+	steps := recordSteps(t, bulker, nil, []error{errFailed})
+
+	agent := newAgent()
+	agent.Active = false
+	agent.APIKeysInvalidationPending = true
+
+	require.ErrorIs(t, InvalidatePending(ctx, bulker, agent), errFailed)
+	require.NoError(t, InvalidatePending(ctx, bulker, agent))
+	assert.Equal(t, []step{
+		{keys: []string{"access-key"}, err: errFailed},
+		{keys: []string{"access-key"}},
+		{doc: invalidatedDoc},
+	}, *steps)
+}
+
+func TestInvalidateInactive(t *testing.T) {
+	setNow(t)
+	errFailed := errors.New("failed")
+
+	t.Run("active agent", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		steps := recordSteps(t, bulker, nil, nil)
+
+		require.NoError(t, InvalidateInactive(ctx, bulker, newAgent()))
+		assert.Empty(t, *steps)
+	})
+
+	t.Run("keys invalidated", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		steps := recordSteps(t, bulker, nil, nil)
+		agent := newAgent()
+		agent.Active = false
+		agent.APIKeysInvalidatedAt = "2024-01-01T11:00:00Z"
+
+		require.NoError(t, InvalidateInactive(ctx, bulker, agent))
+		assert.Empty(t, *steps)
+	})
+
+	t.Run("force unenrolled", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		steps := recordSteps(t, bulker, nil, []error{errFailed})
+		agent := newAgent()
+		agent.Active = false
+
+		// the keys are marked pending before they are invalidated, the failure is left to the GC
+		require.ErrorIs(t, InvalidateInactive(ctx, bulker, agent), errFailed)
+		assert.Equal(t, []step{
+			{doc: map[string]interface{}{dl.FieldAPIKeysInvalidationPending: true}},
+			{keys: []string{"access-key"}, err: errFailed},
+		}, *steps)
+	})
+
+	t.Run("pending update fails", func(t *testing.T) {
+		ctx := testlog.SetLogger(t).WithContext(context.Background())
+		bulker := ftesting.NewMockBulk()
+		steps := recordSteps(t, bulker, []error{errFailed}, nil)
+		agent := newAgent()
+		agent.Active = false
+
+		require.ErrorIs(t, InvalidateInactive(ctx, bulker, agent), errFailed)
+		assert.Equal(t, []step{
+			{doc: map[string]interface{}{dl.FieldAPIKeysInvalidationPending: true}, err: errFailed},
+		}, *steps)
+	})
+}
+
+func TestInvalidateAPIKeysRemoteOutputs(t *testing.T) {
+	ctx := testlog.SetLogger(t).WithContext(context.Background())
+	errFailed := errors.New("failed")
+	bulker := ftesting.NewMockBulk()
+	remote := ftesting.NewMockBulk()
+	bulker.On("APIKeyInvalidate", mock.Anything, []string{"local"}).Return(nil).Once()
+	bulker.On("GetBulker", "remote").Return(remote)
+	remote.On("APIKeyInvalidate", mock.Anything, []string{"remote-key"}).Return(errFailed).Once()
+
+	err := InvalidateAPIKeys(ctx, bulker, []model.ToRetireAPIKeyIdsItems{
+		{ID: "local"},
+		{ID: "skipped"},
+		{ID: "remote-key", Output: "remote"},
+	}, "skipped")
+	require.ErrorIs(t, err, errFailed)
+	bulker.AssertExpectations(t)
+	remote.AssertExpectations(t)
+}
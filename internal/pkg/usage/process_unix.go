@@ -0,0 +1,67 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !windows
+
+package usage
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// sampleProcess sets the CPU time, the resident memory and the file descriptors of u. The resident memory and the
+// open file descriptors are read from /proc, the open file descriptors from /dev/fd where there is no /proc.
+func sampleProcess(u *Usage) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err == nil {
+		u.CPUSeconds = float64(ru.Utime.Nano()+ru.Stime.Nano()) / 1e9
+	}
+	if rss, ok := statmRSS(); ok {
+		u.RSSBytes = rss
+	}
+	if n, ok := countFDs("/proc/self/fd"); ok {
+		u.OpenFDs = n
+	} else if n, ok := countFDs("/dev/fd"); ok {
+		u.OpenFDs = n
+	}
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err == nil && rl.Cur < math.MaxInt64 {
+		u.FDLimit = int64(rl.Cur) //nolint:gosec,unconvert // disable G115, Cur is not an uint64 on every platform
+	}
+}
+
+// statmRSS returns the resident memory from the pages of /proc/self/statm.
+func statmRSS() (int64, bool) {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+	fields := bytes.Fields(data)
+	if len(fields) < 2 {
+		return 0, false
+	}
+	pages, err := strconv.ParseInt(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return pages * int64(os.Getpagesize()), true
+}
+
+// countFDs returns the number of entries of the directory of file descriptors dir, without the one used to read it.
+func countFDs(dir string) (int64, bool) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return 0, false
+	}
+	return int64(len(names) - 1), true
+}
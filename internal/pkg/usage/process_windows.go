@@ -0,0 +1,27 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build windows
+
+package usage
+
+import "syscall"
+
+// sampleProcess sets the CPU time of u. The resident memory is the memory mapped by the runtime and the handles are
+// not counted on windows.
+func sampleProcess(u *Usage) {
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return
+	}
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user); err == nil {
+		// the times are in 100ns units
+		u.CPUSeconds = float64(filetimeTicks(kernel)+filetimeTicks(user)) / 1e7
+	}
+}
+
+func filetimeTicks(ft syscall.Filetime) int64 {
+	return int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)
+}
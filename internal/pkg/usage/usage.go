@@ -0,0 +1,195 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package usage samples the resources used by the fleet server process, reported in the heartbeat document of the
+// instance and in the stats, and checks them against the warning thresholds of the configuration.
+package usage
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+// minSamplePeriod is the minimum time between two samples, the CPU percentage of shorter periods is too noisy.
+const minSamplePeriod = 10 * time.Second
+
+// timeNow is used to get the current time. It should be replaced for testing.
+var timeNow = time.Now
+
+// Usage is a sample of the resources used by the process. The values that are unknown on the platform are -1.
+type Usage struct {
+	// CPUPercent is the percentage of all the CPUs of the host used since the previous sample.
+	CPUPercent float64
+	// CPUSeconds is the user and system CPU time used since the process started.
+	CPUSeconds float64
+	// RSSBytes is the resident memory.
+	RSSBytes int64
+	// Goroutines is the number of live goroutines.
+	Goroutines int64
+	// GCPauseSeconds is the total time the garbage collector stopped the process.
+	GCPauseSeconds float64
+	// OpenFDs is the number of open file descriptors.
+	OpenFDs int64
+	// FDLimit is the soft limit of open file descriptors.
+	FDLimit int64
+}
+
+// Sampler returns a sample of the resources used by the process, without its CPU percentage.
+type Sampler func() Usage
+
+// Collector samples the resources used by the process.
+// The methods of a nil Collector report an unknown usage that exceeds no threshold.
+type Collector struct {
+	cfg    config.ResourceUsage
+	sample Sampler
+	numCPU int
+
+	mx     sync.Mutex
+	last   Usage
+	lastAt time.Time
+}
+
+// Opt is an optional setting for Collector.
+type Opt func(*Collector)
+
+// WithSampler replaces the sampling of the process by fn.
+func WithSampler(fn Sampler) Opt {
+	return func(c *Collector) {
+		c.sample = fn
+	}
+}
+
+// WithNumCPU sets the number of CPUs the CPU percentage is computed over.
+func WithNumCPU(n int) Opt {
+	return func(c *Collector) {
+		c.numCPU = n
+	}
+}
+
+// New returns a collector checking the usage against the thresholds of cfg.
+// The first sample is taken at once, it is the base of the CPU percentage of the next one.
+func New(cfg config.ResourceUsage, opts ...Opt) *Collector {
+	c := &Collector{
+		cfg:    cfg,
+		sample: Sample,
+		numCPU: runtime.NumCPU(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.last = c.sample()
+	c.lastAt = timeNow()
+	return c
+}
+
+// Usage returns the last sample, a new one is taken if it is older than 10s.
+func (c *Collector) Usage() Usage {
+	if c == nil {
+		return unknown
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	now := timeNow()
+	if elapsed := now.Sub(c.lastAt); elapsed >= minSamplePeriod {
+		u := c.sample()
+		u.CPUPercent = -1
+		if u.CPUSeconds >= 0 && c.last.CPUSeconds >= 0 && c.numCPU > 0 {
+			u.CPUPercent = 100 * (u.CPUSeconds - c.last.CPUSeconds) / (elapsed.Seconds() * float64(c.numCPU))
+		}
+		c.last, c.lastAt = u, now
+	}
+	return c.last
+}
+
+// Warnings returns a message for each threshold exceeded by the last sample.
+func (c *Collector) Warnings() []string {
+	if c == nil {
+		return nil
+	}
+	u := c.Usage()
+	var warnings []string
+	if ratio := c.cfg.MaxFDRatio; ratio > 0 && u.OpenFDs >= 0 && u.FDLimit > 0 && float64(u.OpenFDs) > ratio*float64(u.FDLimit) {
+		warnings = append(warnings, fmt.Sprintf("%d open file descriptors exceed %g of the limit of %d", u.OpenFDs, ratio, u.FDLimit))
+	}
+	if limit := int64(c.cfg.MaxRSS); limit > 0 && u.RSSBytes > limit {
+		warnings = append(warnings, fmt.Sprintf("resident memory of %d bytes exceeds %d bytes", u.RSSBytes, limit))
+	}
+	if limit := int64(c.cfg.MaxGoroutines); limit > 0 && u.Goroutines > limit {
+		warnings = append(warnings, fmt.Sprintf("%d goroutines exceed %d", u.Goroutines, limit))
+	}
+	if limit := c.cfg.MaxCPUPercent; limit > 0 && u.CPUPercent > limit {
+		warnings = append(warnings, fmt.Sprintf("CPU usage of %.1f%% exceeds %g%%", u.CPUPercent, limit))
+	}
+	return warnings
+}
+
+// Register registers the usage of the process in reg.
+func (c *Collector) Register(reg *monitoring.Registry) {
+	monitoring.NewFunc(reg, "process", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		u := c.Usage()
+		monitoring.ReportFloat(v, "cpu_pct", u.CPUPercent)
+		monitoring.ReportFloat(v, "cpu_seconds", u.CPUSeconds)
+		monitoring.ReportInt(v, "rss_bytes", u.RSSBytes)
+		monitoring.ReportInt(v, "goroutines", u.Goroutines)
+		monitoring.ReportFloat(v, "gc_pause_seconds", u.GCPauseSeconds)
+		monitoring.ReportInt(v, "open_fds", u.OpenFDs)
+		monitoring.ReportInt(v, "fd_limit", u.FDLimit)
+		monitoring.ReportInt(v, "warnings", int64(len(c.Warnings())))
+	})
+}
+
+var unknown = Usage{CPUPercent: -1, CPUSeconds: -1, RSSBytes: -1, Goroutines: -1, GCPauseSeconds: -1, OpenFDs: -1, FDLimit: -1}
+
+// Sample returns the usage of the process read from the runtime and from the platform.
+func Sample() Usage {
+	u := unknown
+	samples := []metrics.Sample{
+		{Name: "/sched/goroutines:goroutines"},
+		{Name: "/sched/pauses/total/gc:seconds"},
+		{Name: "/memory/classes/total:bytes"},
+	}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		u.Goroutines = int64(samples[0].Value.Uint64()) //nolint:gosec // disable G115
+	}
+	if samples[1].Value.Kind() == metrics.KindFloat64Histogram {
+		u.GCPauseSeconds = histogramSum(samples[1].Value.Float64Histogram())
+	}
+	if samples[2].Value.Kind() == metrics.KindUint64 {
+		// the memory mapped by the runtime, the fallback when the platform does not report the resident memory
+		u.RSSBytes = int64(samples[2].Value.Uint64()) //nolint:gosec // disable G115
+	}
+	sampleProcess(&u)
+	return u
+}
+
+// histogramSum approximates the sum of the values of h with the middle of their bucket.
+func histogramSum(h *metrics.Float64Histogram) float64 {
+	var sum float64
+	for i, n := range h.Counts {
+		if n == 0 {
+			continue
+		}
+		lo, hi := h.Buckets[i], h.Buckets[i+1]
+		v := (lo + hi) / 2
+		switch {
+		case math.IsInf(lo, -1):
+			v = hi
+		case math.IsInf(hi, 1):
+			v = lo
+		}
+		sum += v * float64(n)
+	}
+	return sum
+}
@@ -0,0 +1,111 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package usage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/config"
+)
+
+// stubClock replaces timeNow until the end of the test, the returned func advances it.
+func stubClock(t *testing.T) func(time.Duration) {
+	t.Helper()
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	t.Cleanup(func() { timeNow = time.Now })
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCollectorUsage(t *testing.T) {
+	advance := stubClock(t)
+	sample := Usage{CPUSeconds: 10, RSSBytes: 64 << 20, Goroutines: 50, GCPauseSeconds: 0.5, OpenFDs: 20, FDLimit: 1024}
+	c := New(config.ResourceUsage{}, WithNumCPU(2), WithSampler(func() Usage { return sample }))
+
+	// the first sample has no CPU percentage
+	require.Equal(t, sample, c.Usage())
+
+	// the samples are not taken more than once per period
+	sample.CPUSeconds = 15
+	advance(time.Second)
+	require.Equal(t, 10.0, c.Usage().CPUSeconds)
+
+	// 5s of CPU over 10s on 2 CPUs
+	advance(9 * time.Second)
+	u := c.Usage()
+	require.Equal(t, 15.0, u.CPUSeconds)
+	require.InDelta(t, 25.0, u.CPUPercent, 0.001)
+
+	// the CPU percentage is unknown when the CPU time is
+	sample.CPUSeconds = -1
+	advance(minSamplePeriod)
+	require.Equal(t, -1.0, c.Usage().CPUPercent)
+}
+
+func TestCollectorWarnings(t *testing.T) {
+	advance := stubClock(t)
+	cfg := config.ResourceUsage{}
+	cfg.InitDefaults()
+	cfg.MaxRSS = 1 << 30
+	cfg.MaxGoroutines = 1000
+	cfg.MaxCPUPercent = 80
+	sample := Usage{CPUSeconds: 0, RSSBytes: 64 << 20, Goroutines: 50, OpenFDs: 900, FDLimit: 1024}
+	c := New(cfg, WithNumCPU(1), WithSampler(func() Usage { return sample }))
+	require.Empty(t, c.Warnings())
+
+	sample = Usage{CPUSeconds: 9, RSSBytes: 2 << 30, Goroutines: 5000, OpenFDs: 1000, FDLimit: 1024}
+	advance(minSamplePeriod)
+	require.Equal(t, []string{
+		"1000 open file descriptors exceed 0.9 of the limit of 1024",
+		"resident memory of 2147483648 bytes exceeds 1073741824 bytes",
+		"5000 goroutines exceed 1000",
+		"CPU usage of 90.0% exceeds 80%",
+	}, c.Warnings())
+
+	// the unknown values exceed no threshold
+	sample = Usage{CPUSeconds: -1, RSSBytes: -1, Goroutines: -1, OpenFDs: -1, FDLimit: -1}
+	advance(minSamplePeriod)
+	require.Empty(t, c.Warnings())
+
+	// a zero threshold is disabled
+	sample = Usage{OpenFDs: 1024, FDLimit: 1024}
+	require.Empty(t, New(config.ResourceUsage{}, WithSampler(func() Usage { return sample })).Warnings())
+
+	var nilCollector *Collector
+	require.Empty(t, nilCollector.Warnings())
+	require.Equal(t, int64(-1), nilCollector.Usage().OpenFDs)
+}
+
+func TestCollectorRegister(t *testing.T) {
+	stubClock(t)
+	sample := Usage{CPUSeconds: 3, RSSBytes: 64 << 20, Goroutines: 50, GCPauseSeconds: 0.25, OpenFDs: 1000, FDLimit: 1024}
+	cfg := config.ResourceUsage{}
+	cfg.InitDefaults()
+	c := New(cfg, WithSampler(func() Usage { return sample }))
+
+	reg := monitoring.NewRegistry()
+	c.Register(reg)
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	require.Equal(t, int64(64<<20), snapshot.Ints["process.rss_bytes"])
+	require.Equal(t, int64(50), snapshot.Ints["process.goroutines"])
+	require.Equal(t, int64(1000), snapshot.Ints["process.open_fds"])
+	require.Equal(t, int64(1024), snapshot.Ints["process.fd_limit"])
+	require.Equal(t, int64(1), snapshot.Ints["process.warnings"])
+	require.Equal(t, 0.25, snapshot.Floats["process.gc_pause_seconds"])
+}
+
+func TestSample(t *testing.T) {
+	u := Sample()
+	require.Positive(t, u.Goroutines)
+	require.Positive(t, u.RSSBytes)
+	require.GreaterOrEqual(t, u.GCPauseSeconds, 0.0)
+	require.Equal(t, -1.0, u.CPUPercent)
+}
@@ -43,6 +43,26 @@ func CheckCompatibility(ctx context.Context, esCli *elasticsearch.Client, fleetV
 	return esVersion, checkCompatibility(ctx, fleetVersion, esVersion)
 }
 
+// CheckMinimumVersion returns ErrUnsupportedVersion if the Elasticsearch version is lower than minVersion.
+// Pre-release suffixes are ignored and an empty minVersion is not checked.
+func CheckMinimumVersion(esVersion, minVersion string) error {
+	if minVersion == "" {
+		return nil
+	}
+	minVer, err := parseVersion(minVersion)
+	if err != nil {
+		return err
+	}
+	ver, err := parseVersion(esVersion)
+	if err != nil {
+		return err
+	}
+	if ver.LessThan(minVer) {
+		return fmt.Errorf("%w: elasticsearch %s is older than the minimum version %s", ErrUnsupportedVersion, esVersion, minVersion)
+	}
+	return nil
+}
+
 func checkCompatibility(ctx context.Context, fleetVersion, esVersion string) error {
 	verConst, err := buildVersionConstraint(fleetVersion)
 	if err != nil {
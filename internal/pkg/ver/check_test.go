@@ -106,3 +106,32 @@ func TestCheckCompatibilityInternal(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckMinimumVersion(t *testing.T) {
+	tests := []struct {
+		esVersion  string
+		minVersion string
+		err        error
+	}{
+		{esVersion: "7.17.0", minVersion: ""},
+		{esVersion: "8.0.0", minVersion: "8.0.0"},
+		{esVersion: "8.12.1", minVersion: "8.0.0"},
+		{esVersion: "8.0.0-SNAPSHOT", minVersion: "8.0.0"},
+		{esVersion: "7.17.9", minVersion: "8.0.0", err: ErrUnsupportedVersion},
+		{esVersion: "8.11.0", minVersion: "8.12", err: ErrUnsupportedVersion},
+		{esVersion: "", minVersion: "8.0.0", err: ErrMalformedVersion},
+		{esVersion: "8.0.0", minVersion: "eight", err: ErrMalformedVersion},
+	}
+	for _, tc := range tests {
+		t.Run(tc.esVersion+" "+tc.minVersion, func(t *testing.T) {
+			err := CheckMinimumVersion(tc.esVersion, tc.minVersion)
+			if tc.err == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if !errors.Is(err, tc.err) {
+				t.Errorf("unexpected error kind: %v", err)
+			}
+		})
+	}
+}
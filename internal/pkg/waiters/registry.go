@@ -0,0 +1,154 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+// Package waiters tracks the checkins waiting in the long poll by agent id,
+// so the monitors can wake up the checkins of the agents they have new documents for.
+package waiters
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
+)
+
+// ErrFull is returned by Register when the max number of waiters is registered.
+var ErrFull = fmt.Errorf("%w: too many waiting checkins", limit.ErrMaxLimit)
+
+// Waiter is a checkin of an agent registered in a Registry.
+type Waiter[T any] struct {
+	agentID   string
+	ch        chan T
+	preempted chan struct{}
+	stop      func() bool // stops the unregistration on context cancellation
+}
+
+// AgentID returns the id of the agent of the waiter.
+func (w *Waiter[T]) AgentID() string {
+	return w.agentID
+}
+
+// C returns the channel the notifications for the agent are sent to.
+// It is buffered, a notification is dropped if the previous one was not received.
+func (w *Waiter[T]) C() <-chan T {
+	return w.ch
+}
+
+// Preempted returns a channel that is closed when a newer checkin of the same agent registers.
+// The preempted waiter does not receive notifications anymore.
+func (w *Waiter[T]) Preempted() <-chan struct{} {
+	return w.preempted
+}
+
+// Registry maps agent ids to the waiter of their checkin, an agent has at most one waiter.
+// Registering a new checkin of an agent preempts its previous checkin, which is likely a connection the agent abandoned.
+type Registry[T any] struct {
+	max int
+
+	mx      sync.Mutex
+	waiters map[string]*Waiter[T]
+}
+
+// New returns a registry of at most max waiters, the number of waiters is not limited if max is not positive.
+func New[T any](max int) *Registry[T] {
+	return &Registry[T]{
+		max:     max,
+		waiters: make(map[string]*Waiter[T]),
+	}
+}
+
+// SetMax replaces the max number of waiters, the waiters beyond a lowered max are kept until they are unregistered.
+func (r *Registry[T]) SetMax(max int) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.max = max
+}
+
+// Register registers a waiter for agentID that is unregistered when ctx is done, or by Unregister.
+// The previous waiter of the agent is preempted. ErrFull is returned when the registry is full, and the error of ctx if it is done.
+func (r *Registry[T]) Register(ctx context.Context, agentID string) (*Waiter[T], error) {
+	w := &Waiter[T]{
+		agentID:   agentID,
+		ch:        make(chan T, 1),
+		preempted: make(chan struct{}),
+	}
+	w.stop = context.AfterFunc(ctx, func() { r.remove(w) })
+
+	r.mx.Lock()
+	if err := ctx.Err(); err != nil {
+		// the context may be done before the waiter was added
+		r.mx.Unlock()
+		w.stop()
+		return nil, err
+	}
+	prev, ok := r.waiters[agentID]
+	if !ok && r.max > 0 && len(r.waiters) >= r.max {
+		r.mx.Unlock()
+		w.stop()
+		return nil, ErrFull
+	}
+	r.waiters[agentID] = w
+	if ok {
+		close(prev.preempted)
+	}
+	r.mx.Unlock()
+
+	if ok {
+		prev.stop()
+	}
+	return w, nil
+}
+
+// Unregister removes the waiter, it does nothing if the waiter was already removed or preempted.
+func (r *Registry[T]) Unregister(w *Waiter[T]) {
+	if w == nil {
+		return
+	}
+	w.stop()
+	r.remove(w)
+}
+
+func (r *Registry[T]) remove(w *Waiter[T]) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.waiters[w.agentID] == w {
+		delete(r.waiters, w.agentID)
+	}
+}
+
+// Notify sends v to the waiter of agentID without blocking.
+// It returns false if the agent has no waiter, or if its previous notification was not received yet.
+func (r *Registry[T]) Notify(agentID string, v T) bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	w, ok := r.waiters[agentID]
+	if !ok {
+		return false
+	}
+	select {
+	case w.ch <- v:
+		return true
+	default:
+		return false
+	}
+}
+
+// NotifyAgents sends v to the waiters of agentIDs without blocking, and returns the number of waiters notified.
+func (r *Registry[T]) NotifyAgents(v T, agentIDs ...string) int {
+	n := 0
+	for _, agentID := range agentIDs {
+		if r.Notify(agentID, v) {
+			n++
+		}
+	}
+	return n
+}
+
+// Len returns the number of waiters.
+func (r *Registry[T]) Len() int {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return len(r.waiters)
+}
@@ -0,0 +1,177 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package waiters
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/fleet-server/v7/internal/pkg/limit"
+)
+
+func TestRegistryNotify(t *testing.T) {
+	r := New[string](0)
+	ctx := context.Background()
+
+	w1, err := r.Register(ctx, "agent-1")
+	require.NoError(t, err)
+	w2, err := r.Register(ctx, "agent-2")
+	require.NoError(t, err)
+	require.Equal(t, 2, r.Len())
+	require.Equal(t, "agent-1", w1.AgentID())
+
+	require.Equal(t, 2, r.NotifyAgents("actions", "agent-1", "agent-2", "agent-3"))
+	require.Equal(t, "actions", <-w1.C())
+	require.Equal(t, "actions", <-w2.C())
+
+	// a notification is dropped while the previous one was not received
+	require.True(t, r.Notify("agent-1", "first"))
+	require.False(t, r.Notify("agent-1", "second"))
+	require.Equal(t, "first", <-w1.C())
+
+	r.Unregister(w1)
+	require.False(t, r.Notify("agent-1", "actions"))
+	require.Equal(t, 1, r.Len())
+	r.Unregister(w1)
+	r.Unregister(nil)
+	require.Equal(t, 1, r.Len())
+}
+
+func TestRegistryPreempt(t *testing.T) {
+	r := New[string](0)
+	ctx := context.Background()
+
+	older, err := r.Register(ctx, "agent-1")
+	require.NoError(t, err)
+	newer, err := r.Register(ctx, "agent-1")
+	require.NoError(t, err)
+	require.Equal(t, 1, r.Len())
+
+	select {
+	case <-older.Preempted():
+	default:
+		require.Fail(t, "older waiter is not preempted")
+	}
+	select {
+	case <-newer.Preempted():
+		require.Fail(t, "newer waiter is preempted")
+	default:
+	}
+
+	// the notifications go to the newer waiter, unregistering the older one does not remove it
+	r.Unregister(older)
+	require.True(t, r.Notify("agent-1", "actions"))
+	require.Equal(t, "actions", <-newer.C())
+	require.Empty(t, older.C())
+	require.Equal(t, 1, r.Len())
+}
+
+func TestRegistryContext(t *testing.T) {
+	r := New[string](0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := r.Register(ctx, "agent-1")
+	require.NoError(t, err)
+	cancel()
+	require.Eventually(t, func() bool { return r.Len() == 0 }, time.Second, time.Millisecond)
+
+	_, err = r.Register(ctx, "agent-1")
+	require.ErrorIs(t, err, context.Canceled)
+	require.Zero(t, r.Len())
+
+	// the cancellation of a preempted waiter does not remove the newer one
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	_, err = r.Register(ctx1, "agent-1")
+	require.NoError(t, err)
+	newer, err := r.Register(context.Background(), "agent-1")
+	require.NoError(t, err)
+	cancel1()
+	time.Sleep(10 * time.Millisecond)
+	require.True(t, r.Notify("agent-1", "actions"))
+	require.Equal(t, "actions", <-newer.C())
+}
+
+func TestRegistryMax(t *testing.T) {
+	r := New[string](2)
+	ctx := context.Background()
+
+	w1, err := r.Register(ctx, "agent-1")
+	require.NoError(t, err)
+	_, err = r.Register(ctx, "agent-2")
+	require.NoError(t, err)
+
+	_, err = r.Register(ctx, "agent-3")
+	require.ErrorIs(t, err, ErrFull)
+	require.ErrorIs(t, err, limit.ErrMaxLimit)
+
+	// a newer checkin of a registered agent replaces its waiter
+	_, err = r.Register(ctx, "agent-1")
+	require.NoError(t, err)
+	require.Equal(t, 2, r.Len())
+
+	r.Unregister(w1)
+	require.Equal(t, 2, r.Len())
+
+	// a raised max takes effect for the next registrations
+	r.SetMax(3)
+	_, err = r.Register(ctx, "agent-3")
+	require.NoError(t, err)
+	r.SetMax(0)
+	_, err = r.Register(ctx, "agent-4")
+	require.NoError(t, err)
+	require.Equal(t, 4, r.Len())
+}
+
+func TestRegistryStress(t *testing.T) {
+	const (
+		ops    = 10000
+		agents = 100
+	)
+	r := New[int](agents)
+
+	var wg sync.WaitGroup
+	for i := range ops {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			agentID := fmt.Sprintf("agent-%d", rand.IntN(agents)) //nolint:gosec // not used for security
+			switch i % 3 {
+			case 0:
+				// checkin waiting until it is notified, preempted or its context is cancelled
+				ctx, cancel := context.WithTimeout(context.Background(), time.Duration(rand.IntN(5))*time.Millisecond) //nolint:gosec // not used for security
+				defer cancel()
+				w, err := r.Register(ctx, agentID)
+				if err != nil {
+					require.ErrorIs(t, err, context.DeadlineExceeded)
+					return
+				}
+				select {
+				case <-w.C():
+				case <-w.Preempted():
+				case <-ctx.Done():
+				}
+			case 1:
+				// checkin that unregisters itself
+				w, err := r.Register(context.Background(), agentID)
+				require.NoError(t, err)
+				r.Notify(agentID, i)
+				r.Unregister(w)
+			default:
+				r.NotifyAgents(i, agentID, fmt.Sprintf("agent-%d", rand.IntN(agents))) //nolint:gosec // not used for security
+			}
+		}()
+	}
+	wg.Wait()
+
+	// every waiter is eventually removed
+	require.Eventually(t, func() bool { return r.Len() == 0 }, time.Second, time.Millisecond)
+}
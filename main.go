@@ -3,6 +3,8 @@
 // you may not use this file except in compliance with the Elastic License.
 
 //go:generate schema-generate -esdoc -s -cm "{\"Api\": \"API\", \"Id\": \"ID\"}" -o internal/pkg/model/schema.go -p model model/schema.json
+//go:generate go run ./dev-tools/schemagen
+//go:generate go run ./dev-tools/openapigen
 //go:generate oapi-codegen --config model/oapi-cfg.yml model/openapi.yml
 //go:generate oapi-codegen -generate types -package api -o pkg/api/types.gen.go  model/openapi.yml
 //go:generate oapi-codegen -generate client -package api -o pkg/api/client.gen.go  model/openapi.yml
@@ -19,21 +21,10 @@ import (
 
 	"github.com/elastic/fleet-server/v7/cmd/fleet"
 	"github.com/elastic/fleet-server/v7/internal/pkg/build"
-	"github.com/elastic/fleet-server/v7/version"
-)
-
-var (
-	Version   string = version.DefaultVersion
-	Commit    string
-	BuildTime string
 )
 
 func main() {
-	cmd := fleet.NewCommand(build.Info{
-		Version:   Version,
-		Commit:    Commit,
-		BuildTime: build.Time(BuildTime),
-	})
+	cmd := fleet.NewCommand(build.Current())
 	if err := cmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
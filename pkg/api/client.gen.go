@@ -96,11 +96,29 @@ type ClientInterface interface {
 	// GetPGPKey request
 	GetPGPKey(ctx context.Context, major int, minor int, patch int, params *GetPGPKeyParams, reqEditors ...RequestEditorFn) (*http.Response, error)
 
+	// CreateActionsWithBody request with any body
+	CreateActionsWithBody(ctx context.Context, params *CreateActionsParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error)
+
+	CreateActions(ctx context.Context, params *CreateActionsParams, body CreateActionsJSONRequestBody, reqEditors ...RequestEditorFn) (*http.Response, error)
+
+	// GetActionResults request
+	GetActionResults(ctx context.Context, id string, params *GetActionResultsParams, reqEditors ...RequestEditorFn) (*http.Response, error)
+
+	// BulkEnrollWithBody request with any body
+	BulkEnrollWithBody(ctx context.Context, params *BulkEnrollParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error)
+
+	BulkEnroll(ctx context.Context, params *BulkEnrollParams, body BulkEnrollJSONRequestBody, reqEditors ...RequestEditorFn) (*http.Response, error)
+
 	// AgentEnrollWithBody request with any body
 	AgentEnrollWithBody(ctx context.Context, params *AgentEnrollParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error)
 
 	AgentEnroll(ctx context.Context, params *AgentEnrollParams, body AgentEnrollJSONRequestBody, reqEditors ...RequestEditorFn) (*http.Response, error)
 
+	// ReassignAgentsWithBody request with any body
+	ReassignAgentsWithBody(ctx context.Context, params *ReassignAgentsParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error)
+
+	ReassignAgents(ctx context.Context, params *ReassignAgentsParams, body ReassignAgentsJSONRequestBody, reqEditors ...RequestEditorFn) (*http.Response, error)
+
 	// AgentAcksWithBody request with any body
 	AgentAcksWithBody(ctx context.Context, id string, params *AgentAcksParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error)
 
@@ -116,12 +134,32 @@ type ClientInterface interface {
 
 	AgentCheckin(ctx context.Context, id string, params *AgentCheckinParams, body AgentCheckinJSONRequestBody, reqEditors ...RequestEditorFn) (*http.Response, error)
 
+	// AddAgentTagsWithBody request with any body
+	AddAgentTagsWithBody(ctx context.Context, id string, params *AddAgentTagsParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error)
+
+	AddAgentTags(ctx context.Context, id string, params *AddAgentTagsParams, body AddAgentTagsJSONRequestBody, reqEditors ...RequestEditorFn) (*http.Response, error)
+
+	// RemoveAgentTag request
+	RemoveAgentTag(ctx context.Context, id string, tag string, params *RemoveAgentTagParams, reqEditors ...RequestEditorFn) (*http.Response, error)
+
 	// Artifact request
 	Artifact(ctx context.Context, id string, sha2 string, params *ArtifactParams, reqEditors ...RequestEditorFn) (*http.Response, error)
 
 	// GetFile request
 	GetFile(ctx context.Context, id string, params *GetFileParams, reqEditors ...RequestEditorFn) (*http.Response, error)
 
+	// Healthz request
+	Healthz(ctx context.Context, reqEditors ...RequestEditorFn) (*http.Response, error)
+
+	// GetOperation request
+	GetOperation(ctx context.Context, id string, params *GetOperationParams, reqEditors ...RequestEditorFn) (*http.Response, error)
+
+	// GetPolicyRollout request
+	GetPolicyRollout(ctx context.Context, id string, params *GetPolicyRolloutParams, reqEditors ...RequestEditorFn) (*http.Response, error)
+
+	// GetPolicy request
+	GetPolicy(ctx context.Context, id string, revision int64, params *GetPolicyParams, reqEditors ...RequestEditorFn) (*http.Response, error)
+
 	// UploadBeginWithBody request with any body
 	UploadBeginWithBody(ctx context.Context, params *UploadBeginParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error)
 
@@ -151,6 +189,66 @@ func (c *Client) GetPGPKey(ctx context.Context, major int, minor int, patch int,
 	return c.Client.Do(req)
 }
 
+func (c *Client) CreateActionsWithBody(ctx context.Context, params *CreateActionsParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewCreateActionsRequestWithBody(c.Server, params, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
+func (c *Client) CreateActions(ctx context.Context, params *CreateActionsParams, body CreateActionsJSONRequestBody, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewCreateActionsRequest(c.Server, params, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
+func (c *Client) GetActionResults(ctx context.Context, id string, params *GetActionResultsParams, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewGetActionResultsRequest(c.Server, id, params)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
+func (c *Client) BulkEnrollWithBody(ctx context.Context, params *BulkEnrollParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewBulkEnrollRequestWithBody(c.Server, params, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
+func (c *Client) BulkEnroll(ctx context.Context, params *BulkEnrollParams, body BulkEnrollJSONRequestBody, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewBulkEnrollRequest(c.Server, params, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
 func (c *Client) AgentEnrollWithBody(ctx context.Context, params *AgentEnrollParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error) {
 	req, err := NewAgentEnrollRequestWithBody(c.Server, params, contentType, body)
 	if err != nil {
@@ -175,6 +273,30 @@ func (c *Client) AgentEnroll(ctx context.Context, params *AgentEnrollParams, bod
 	return c.Client.Do(req)
 }
 
+func (c *Client) ReassignAgentsWithBody(ctx context.Context, params *ReassignAgentsParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewReassignAgentsRequestWithBody(c.Server, params, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
+func (c *Client) ReassignAgents(ctx context.Context, params *ReassignAgentsParams, body ReassignAgentsJSONRequestBody, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewReassignAgentsRequest(c.Server, params, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
 func (c *Client) AgentAcksWithBody(ctx context.Context, id string, params *AgentAcksParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error) {
 	req, err := NewAgentAcksRequestWithBody(c.Server, id, params, contentType, body)
 	if err != nil {
@@ -247,6 +369,42 @@ func (c *Client) AgentCheckin(ctx context.Context, id string, params *AgentCheck
 	return c.Client.Do(req)
 }
 
+func (c *Client) AddAgentTagsWithBody(ctx context.Context, id string, params *AddAgentTagsParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewAddAgentTagsRequestWithBody(c.Server, id, params, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
+func (c *Client) AddAgentTags(ctx context.Context, id string, params *AddAgentTagsParams, body AddAgentTagsJSONRequestBody, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewAddAgentTagsRequest(c.Server, id, params, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
+func (c *Client) RemoveAgentTag(ctx context.Context, id string, tag string, params *RemoveAgentTagParams, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewRemoveAgentTagRequest(c.Server, id, tag, params)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
 func (c *Client) Artifact(ctx context.Context, id string, sha2 string, params *ArtifactParams, reqEditors ...RequestEditorFn) (*http.Response, error) {
 	req, err := NewArtifactRequest(c.Server, id, sha2, params)
 	if err != nil {
@@ -271,6 +429,54 @@ func (c *Client) GetFile(ctx context.Context, id string, params *GetFileParams,
 	return c.Client.Do(req)
 }
 
+func (c *Client) Healthz(ctx context.Context, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewHealthzRequest(c.Server)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
+func (c *Client) GetOperation(ctx context.Context, id string, params *GetOperationParams, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewGetOperationRequest(c.Server, id, params)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
+func (c *Client) GetPolicyRollout(ctx context.Context, id string, params *GetPolicyRolloutParams, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewGetPolicyRolloutRequest(c.Server, id, params)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
+func (c *Client) GetPolicy(ctx context.Context, id string, revision int64, params *GetPolicyParams, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewGetPolicyRequest(c.Server, id, revision, params)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
 func (c *Client) UploadBeginWithBody(ctx context.Context, params *UploadBeginParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error) {
 	req, err := NewUploadBeginRequestWithBody(c.Server, params, contentType, body)
 	if err != nil {
@@ -417,19 +623,19 @@ func NewGetPGPKeyRequest(server string, major int, minor int, patch int, params
 	return req, nil
 }
 
-// NewAgentEnrollRequest calls the generic AgentEnroll builder with application/json body
-func NewAgentEnrollRequest(server string, params *AgentEnrollParams, body AgentEnrollJSONRequestBody) (*http.Request, error) {
+// NewCreateActionsRequest calls the generic CreateActions builder with application/json body
+func NewCreateActionsRequest(server string, params *CreateActionsParams, body CreateActionsJSONRequestBody) (*http.Request, error) {
 	var bodyReader io.Reader
 	buf, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
 	bodyReader = bytes.NewReader(buf)
-	return NewAgentEnrollRequestWithBody(server, params, "application/json", bodyReader)
+	return NewCreateActionsRequestWithBody(server, params, "application/json", bodyReader)
 }
 
-// NewAgentEnrollRequestWithBody generates requests for AgentEnroll with any type of body
-func NewAgentEnrollRequestWithBody(server string, params *AgentEnrollParams, contentType string, body io.Reader) (*http.Request, error) {
+// NewCreateActionsRequestWithBody generates requests for CreateActions with any type of body
+func NewCreateActionsRequestWithBody(server string, params *CreateActionsParams, contentType string, body io.Reader) (*http.Request, error) {
 	var err error
 
 	serverURL, err := url.Parse(server)
@@ -437,7 +643,7 @@ func NewAgentEnrollRequestWithBody(server string, params *AgentEnrollParams, con
 		return nil, err
 	}
 
-	operationPath := fmt.Sprintf("/api/fleet/agents/enroll")
+	operationPath := fmt.Sprintf("/api/fleet/agents/actions")
 	if operationPath[0] == '/' {
 		operationPath = "." + operationPath
 	}
@@ -456,35 +662,26 @@ func NewAgentEnrollRequestWithBody(server string, params *AgentEnrollParams, con
 
 	if params != nil {
 
-		var headerParam0 string
-
-		headerParam0, err = runtime.StyleParamWithLocation("simple", false, "User-Agent", runtime.ParamLocationHeader, params.UserAgent)
-		if err != nil {
-			return nil, err
-		}
-
-		req.Header.Set("User-Agent", headerParam0)
-
 		if params.XRequestId != nil {
-			var headerParam1 string
+			var headerParam0 string
 
-			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
+			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
 			if err != nil {
 				return nil, err
 			}
 
-			req.Header.Set("X-Request-Id", headerParam1)
+			req.Header.Set("X-Request-Id", headerParam0)
 		}
 
 		if params.ElasticApiVersion != nil {
-			var headerParam2 string
+			var headerParam1 string
 
-			headerParam2, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
+			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
 			if err != nil {
 				return nil, err
 			}
 
-			req.Header.Set("elastic-api-version", headerParam2)
+			req.Header.Set("elastic-api-version", headerParam1)
 		}
 
 	}
@@ -492,19 +689,8 @@ func NewAgentEnrollRequestWithBody(server string, params *AgentEnrollParams, con
 	return req, nil
 }
 
-// NewAgentAcksRequest calls the generic AgentAcks builder with application/json body
-func NewAgentAcksRequest(server string, id string, params *AgentAcksParams, body AgentAcksJSONRequestBody) (*http.Request, error) {
-	var bodyReader io.Reader
-	buf, err := json.Marshal(body)
-	if err != nil {
-		return nil, err
-	}
-	bodyReader = bytes.NewReader(buf)
-	return NewAgentAcksRequestWithBody(server, id, params, "application/json", bodyReader)
-}
-
-// NewAgentAcksRequestWithBody generates requests for AgentAcks with any type of body
-func NewAgentAcksRequestWithBody(server string, id string, params *AgentAcksParams, contentType string, body io.Reader) (*http.Request, error) {
+// NewGetActionResultsRequest generates requests for GetActionResults
+func NewGetActionResultsRequest(server string, id string, params *GetActionResultsParams) (*http.Request, error) {
 	var err error
 
 	var pathParam0 string
@@ -519,7 +705,7 @@ func NewAgentAcksRequestWithBody(server string, id string, params *AgentAcksPara
 		return nil, err
 	}
 
-	operationPath := fmt.Sprintf("/api/fleet/agents/%s/acks", pathParam0)
+	operationPath := fmt.Sprintf("/api/fleet/agents/actions/%s/results", pathParam0)
 	if operationPath[0] == '/' {
 		operationPath = "." + operationPath
 	}
@@ -529,13 +715,65 @@ func NewAgentAcksRequestWithBody(server string, id string, params *AgentAcksPara
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", queryURL.String(), body)
+	if params != nil {
+		queryValues := queryURL.Query()
+
+		if params.Agents != nil {
+
+			if queryFrag, err := runtime.StyleParamWithLocation("form", true, "agents", runtime.ParamLocationQuery, *params.Agents); err != nil {
+				return nil, err
+			} else if parsed, err := url.ParseQuery(queryFrag); err != nil {
+				return nil, err
+			} else {
+				for k, v := range parsed {
+					for _, v2 := range v {
+						queryValues.Add(k, v2)
+					}
+				}
+			}
+
+		}
+
+		if params.From != nil {
+
+			if queryFrag, err := runtime.StyleParamWithLocation("form", true, "from", runtime.ParamLocationQuery, *params.From); err != nil {
+				return nil, err
+			} else if parsed, err := url.ParseQuery(queryFrag); err != nil {
+				return nil, err
+			} else {
+				for k, v := range parsed {
+					for _, v2 := range v {
+						queryValues.Add(k, v2)
+					}
+				}
+			}
+
+		}
+
+		if params.Size != nil {
+
+			if queryFrag, err := runtime.StyleParamWithLocation("form", true, "size", runtime.ParamLocationQuery, *params.Size); err != nil {
+				return nil, err
+			} else if parsed, err := url.ParseQuery(queryFrag); err != nil {
+				return nil, err
+			} else {
+				for k, v := range parsed {
+					for _, v2 := range v {
+						queryValues.Add(k, v2)
+					}
+				}
+			}
+
+		}
+
+		queryURL.RawQuery = queryValues.Encode()
+	}
+
+	req, err := http.NewRequest("GET", queryURL.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("Content-Type", contentType)
-
 	if params != nil {
 
 		if params.XRequestId != nil {
@@ -565,34 +803,27 @@ func NewAgentAcksRequestWithBody(server string, id string, params *AgentAcksPara
 	return req, nil
 }
 
-// NewAuditUnenrollRequest calls the generic AuditUnenroll builder with application/json body
-func NewAuditUnenrollRequest(server string, id string, params *AuditUnenrollParams, body AuditUnenrollJSONRequestBody) (*http.Request, error) {
+// NewBulkEnrollRequest calls the generic BulkEnroll builder with application/json body
+func NewBulkEnrollRequest(server string, params *BulkEnrollParams, body BulkEnrollJSONRequestBody) (*http.Request, error) {
 	var bodyReader io.Reader
 	buf, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
 	bodyReader = bytes.NewReader(buf)
-	return NewAuditUnenrollRequestWithBody(server, id, params, "application/json", bodyReader)
+	return NewBulkEnrollRequestWithBody(server, params, "application/json", bodyReader)
 }
 
-// NewAuditUnenrollRequestWithBody generates requests for AuditUnenroll with any type of body
-func NewAuditUnenrollRequestWithBody(server string, id string, params *AuditUnenrollParams, contentType string, body io.Reader) (*http.Request, error) {
+// NewBulkEnrollRequestWithBody generates requests for BulkEnroll with any type of body
+func NewBulkEnrollRequestWithBody(server string, params *BulkEnrollParams, contentType string, body io.Reader) (*http.Request, error) {
 	var err error
 
-	var pathParam0 string
-
-	pathParam0, err = runtime.StyleParamWithLocation("simple", false, "id", runtime.ParamLocationPath, id)
-	if err != nil {
-		return nil, err
-	}
-
 	serverURL, err := url.Parse(server)
 	if err != nil {
 		return nil, err
 	}
 
-	operationPath := fmt.Sprintf("/api/fleet/agents/%s/audit/unenroll", pathParam0)
+	operationPath := fmt.Sprintf("/api/fleet/agents/bulk_enroll")
 	if operationPath[0] == '/' {
 		operationPath = "." + operationPath
 	}
@@ -638,34 +869,27 @@ func NewAuditUnenrollRequestWithBody(server string, id string, params *AuditUnen
 	return req, nil
 }
 
-// NewAgentCheckinRequest calls the generic AgentCheckin builder with application/json body
-func NewAgentCheckinRequest(server string, id string, params *AgentCheckinParams, body AgentCheckinJSONRequestBody) (*http.Request, error) {
+// NewAgentEnrollRequest calls the generic AgentEnroll builder with application/json body
+func NewAgentEnrollRequest(server string, params *AgentEnrollParams, body AgentEnrollJSONRequestBody) (*http.Request, error) {
 	var bodyReader io.Reader
 	buf, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
 	bodyReader = bytes.NewReader(buf)
-	return NewAgentCheckinRequestWithBody(server, id, params, "application/json", bodyReader)
+	return NewAgentEnrollRequestWithBody(server, params, "application/json", bodyReader)
 }
 
-// NewAgentCheckinRequestWithBody generates requests for AgentCheckin with any type of body
-func NewAgentCheckinRequestWithBody(server string, id string, params *AgentCheckinParams, contentType string, body io.Reader) (*http.Request, error) {
+// NewAgentEnrollRequestWithBody generates requests for AgentEnroll with any type of body
+func NewAgentEnrollRequestWithBody(server string, params *AgentEnrollParams, contentType string, body io.Reader) (*http.Request, error) {
 	var err error
 
-	var pathParam0 string
-
-	pathParam0, err = runtime.StyleParamWithLocation("simple", false, "id", runtime.ParamLocationPath, id)
-	if err != nil {
-		return nil, err
-	}
-
 	serverURL, err := url.Parse(server)
 	if err != nil {
 		return nil, err
 	}
 
-	operationPath := fmt.Sprintf("/api/fleet/agents/%s/checkin", pathParam0)
+	operationPath := fmt.Sprintf("/api/fleet/agents/enroll")
 	if operationPath[0] == '/' {
 		operationPath = "." + operationPath
 	}
@@ -684,46 +908,112 @@ func NewAgentCheckinRequestWithBody(server string, id string, params *AgentCheck
 
 	if params != nil {
 
-		if params.AcceptEncoding != nil {
-			var headerParam0 string
+		var headerParam0 string
 
-			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "Accept-Encoding", runtime.ParamLocationHeader, *params.AcceptEncoding)
+		headerParam0, err = runtime.StyleParamWithLocation("simple", false, "User-Agent", runtime.ParamLocationHeader, params.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("User-Agent", headerParam0)
+
+		if params.XRequestId != nil {
+			var headerParam1 string
+
+			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
 			if err != nil {
 				return nil, err
 			}
 
-			req.Header.Set("Accept-Encoding", headerParam0)
+			req.Header.Set("X-Request-Id", headerParam1)
 		}
 
-		var headerParam1 string
+		if params.ElasticApiVersion != nil {
+			var headerParam2 string
 
-		headerParam1, err = runtime.StyleParamWithLocation("simple", false, "User-Agent", runtime.ParamLocationHeader, params.UserAgent)
-		if err != nil {
-			return nil, err
+			headerParam2, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("elastic-api-version", headerParam2)
 		}
 
-		req.Header.Set("User-Agent", headerParam1)
+		if params.IdempotencyKey != nil {
+			var headerParam3 string
+
+			headerParam3, err = runtime.StyleParamWithLocation("simple", false, "Idempotency-Key", runtime.ParamLocationHeader, *params.IdempotencyKey)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("Idempotency-Key", headerParam3)
+		}
+
+	}
+
+	return req, nil
+}
+
+// NewReassignAgentsRequest calls the generic ReassignAgents builder with application/json body
+func NewReassignAgentsRequest(server string, params *ReassignAgentsParams, body ReassignAgentsJSONRequestBody) (*http.Request, error) {
+	var bodyReader io.Reader
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	bodyReader = bytes.NewReader(buf)
+	return NewReassignAgentsRequestWithBody(server, params, "application/json", bodyReader)
+}
+
+// NewReassignAgentsRequestWithBody generates requests for ReassignAgents with any type of body
+func NewReassignAgentsRequestWithBody(server string, params *ReassignAgentsParams, contentType string, body io.Reader) (*http.Request, error) {
+	var err error
+
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+
+	operationPath := fmt.Sprintf("/api/fleet/agents/reassign")
+	if operationPath[0] == '/' {
+		operationPath = "." + operationPath
+	}
+
+	queryURL, err := serverURL.Parse(operationPath)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", queryURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", contentType)
+
+	if params != nil {
 
 		if params.XRequestId != nil {
-			var headerParam2 string
+			var headerParam0 string
 
-			headerParam2, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
+			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
 			if err != nil {
 				return nil, err
 			}
 
-			req.Header.Set("X-Request-Id", headerParam2)
+			req.Header.Set("X-Request-Id", headerParam0)
 		}
 
 		if params.ElasticApiVersion != nil {
-			var headerParam3 string
+			var headerParam1 string
 
-			headerParam3, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
+			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
 			if err != nil {
 				return nil, err
 			}
 
-			req.Header.Set("elastic-api-version", headerParam3)
+			req.Header.Set("elastic-api-version", headerParam1)
 		}
 
 	}
@@ -731,20 +1021,24 @@ func NewAgentCheckinRequestWithBody(server string, id string, params *AgentCheck
 	return req, nil
 }
 
-// NewArtifactRequest generates requests for Artifact
-func NewArtifactRequest(server string, id string, sha2 string, params *ArtifactParams) (*http.Request, error) {
-	var err error
-
-	var pathParam0 string
-
-	pathParam0, err = runtime.StyleParamWithLocation("simple", false, "id", runtime.ParamLocationPath, id)
+// NewAgentAcksRequest calls the generic AgentAcks builder with application/json body
+func NewAgentAcksRequest(server string, id string, params *AgentAcksParams, body AgentAcksJSONRequestBody) (*http.Request, error) {
+	var bodyReader io.Reader
+	buf, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
+	bodyReader = bytes.NewReader(buf)
+	return NewAgentAcksRequestWithBody(server, id, params, "application/json", bodyReader)
+}
 
-	var pathParam1 string
+// NewAgentAcksRequestWithBody generates requests for AgentAcks with any type of body
+func NewAgentAcksRequestWithBody(server string, id string, params *AgentAcksParams, contentType string, body io.Reader) (*http.Request, error) {
+	var err error
 
-	pathParam1, err = runtime.StyleParamWithLocation("simple", false, "sha2", runtime.ParamLocationPath, sha2)
+	var pathParam0 string
+
+	pathParam0, err = runtime.StyleParamWithLocation("simple", false, "id", runtime.ParamLocationPath, id)
 	if err != nil {
 		return nil, err
 	}
@@ -754,7 +1048,7 @@ func NewArtifactRequest(server string, id string, sha2 string, params *ArtifactP
 		return nil, err
 	}
 
-	operationPath := fmt.Sprintf("/api/fleet/artifacts/%s/%s", pathParam0, pathParam1)
+	operationPath := fmt.Sprintf("/api/fleet/agents/%s/acks", pathParam0)
 	if operationPath[0] == '/' {
 		operationPath = "." + operationPath
 	}
@@ -764,11 +1058,13 @@ func NewArtifactRequest(server string, id string, sha2 string, params *ArtifactP
 		return nil, err
 	}
 
-	req, err := http.NewRequest("GET", queryURL.String(), nil)
+	req, err := http.NewRequest("POST", queryURL.String(), body)
 	if err != nil {
 		return nil, err
 	}
 
+	req.Header.Add("Content-Type", contentType)
+
 	if params != nil {
 
 		if params.XRequestId != nil {
@@ -798,8 +1094,19 @@ func NewArtifactRequest(server string, id string, sha2 string, params *ArtifactP
 	return req, nil
 }
 
-// NewGetFileRequest generates requests for GetFile
-func NewGetFileRequest(server string, id string, params *GetFileParams) (*http.Request, error) {
+// NewAuditUnenrollRequest calls the generic AuditUnenroll builder with application/json body
+func NewAuditUnenrollRequest(server string, id string, params *AuditUnenrollParams, body AuditUnenrollJSONRequestBody) (*http.Request, error) {
+	var bodyReader io.Reader
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	bodyReader = bytes.NewReader(buf)
+	return NewAuditUnenrollRequestWithBody(server, id, params, "application/json", bodyReader)
+}
+
+// NewAuditUnenrollRequestWithBody generates requests for AuditUnenroll with any type of body
+func NewAuditUnenrollRequestWithBody(server string, id string, params *AuditUnenrollParams, contentType string, body io.Reader) (*http.Request, error) {
 	var err error
 
 	var pathParam0 string
@@ -814,7 +1121,7 @@ func NewGetFileRequest(server string, id string, params *GetFileParams) (*http.R
 		return nil, err
 	}
 
-	operationPath := fmt.Sprintf("/api/fleet/file/%s", pathParam0)
+	operationPath := fmt.Sprintf("/api/fleet/agents/%s/audit/unenroll", pathParam0)
 	if operationPath[0] == '/' {
 		operationPath = "." + operationPath
 	}
@@ -824,33 +1131,35 @@ func NewGetFileRequest(server string, id string, params *GetFileParams) (*http.R
 		return nil, err
 	}
 
-	req, err := http.NewRequest("GET", queryURL.String(), nil)
+	req, err := http.NewRequest("POST", queryURL.String(), body)
 	if err != nil {
 		return nil, err
 	}
 
+	req.Header.Add("Content-Type", contentType)
+
 	if params != nil {
 
-		if params.ElasticApiVersion != nil {
+		if params.XRequestId != nil {
 			var headerParam0 string
 
-			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
+			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
 			if err != nil {
 				return nil, err
 			}
 
-			req.Header.Set("elastic-api-version", headerParam0)
+			req.Header.Set("X-Request-Id", headerParam0)
 		}
 
-		if params.XRequestId != nil {
+		if params.ElasticApiVersion != nil {
 			var headerParam1 string
 
-			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
+			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
 			if err != nil {
 				return nil, err
 			}
 
-			req.Header.Set("X-Request-Id", headerParam1)
+			req.Header.Set("elastic-api-version", headerParam1)
 		}
 
 	}
@@ -858,27 +1167,34 @@ func NewGetFileRequest(server string, id string, params *GetFileParams) (*http.R
 	return req, nil
 }
 
-// NewUploadBeginRequest calls the generic UploadBegin builder with application/json body
-func NewUploadBeginRequest(server string, params *UploadBeginParams, body UploadBeginJSONRequestBody) (*http.Request, error) {
+// NewAgentCheckinRequest calls the generic AgentCheckin builder with application/json body
+func NewAgentCheckinRequest(server string, id string, params *AgentCheckinParams, body AgentCheckinJSONRequestBody) (*http.Request, error) {
 	var bodyReader io.Reader
 	buf, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
 	bodyReader = bytes.NewReader(buf)
-	return NewUploadBeginRequestWithBody(server, params, "application/json", bodyReader)
+	return NewAgentCheckinRequestWithBody(server, id, params, "application/json", bodyReader)
 }
 
-// NewUploadBeginRequestWithBody generates requests for UploadBegin with any type of body
-func NewUploadBeginRequestWithBody(server string, params *UploadBeginParams, contentType string, body io.Reader) (*http.Request, error) {
+// NewAgentCheckinRequestWithBody generates requests for AgentCheckin with any type of body
+func NewAgentCheckinRequestWithBody(server string, id string, params *AgentCheckinParams, contentType string, body io.Reader) (*http.Request, error) {
 	var err error
 
+	var pathParam0 string
+
+	pathParam0, err = runtime.StyleParamWithLocation("simple", false, "id", runtime.ParamLocationPath, id)
+	if err != nil {
+		return nil, err
+	}
+
 	serverURL, err := url.Parse(server)
 	if err != nil {
 		return nil, err
 	}
 
-	operationPath := fmt.Sprintf("/api/fleet/uploads")
+	operationPath := fmt.Sprintf("/api/fleet/agents/%s/checkin", pathParam0)
 	if operationPath[0] == '/' {
 		operationPath = "." + operationPath
 	}
@@ -897,26 +1213,46 @@ func NewUploadBeginRequestWithBody(server string, params *UploadBeginParams, con
 
 	if params != nil {
 
-		if params.XRequestId != nil {
+		if params.AcceptEncoding != nil {
 			var headerParam0 string
 
-			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
+			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "Accept-Encoding", runtime.ParamLocationHeader, *params.AcceptEncoding)
 			if err != nil {
 				return nil, err
 			}
 
-			req.Header.Set("X-Request-Id", headerParam0)
+			req.Header.Set("Accept-Encoding", headerParam0)
+		}
+
+		var headerParam1 string
+
+		headerParam1, err = runtime.StyleParamWithLocation("simple", false, "User-Agent", runtime.ParamLocationHeader, params.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("User-Agent", headerParam1)
+
+		if params.XRequestId != nil {
+			var headerParam2 string
+
+			headerParam2, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("X-Request-Id", headerParam2)
 		}
 
 		if params.ElasticApiVersion != nil {
-			var headerParam1 string
+			var headerParam3 string
 
-			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
+			headerParam3, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
 			if err != nil {
 				return nil, err
 			}
 
-			req.Header.Set("elastic-api-version", headerParam1)
+			req.Header.Set("elastic-api-version", headerParam3)
 		}
 
 	}
@@ -924,19 +1260,19 @@ func NewUploadBeginRequestWithBody(server string, params *UploadBeginParams, con
 	return req, nil
 }
 
-// NewUploadCompleteRequest calls the generic UploadComplete builder with application/json body
-func NewUploadCompleteRequest(server string, id string, params *UploadCompleteParams, body UploadCompleteJSONRequestBody) (*http.Request, error) {
+// NewAddAgentTagsRequest calls the generic AddAgentTags builder with application/json body
+func NewAddAgentTagsRequest(server string, id string, params *AddAgentTagsParams, body AddAgentTagsJSONRequestBody) (*http.Request, error) {
 	var bodyReader io.Reader
 	buf, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
 	bodyReader = bytes.NewReader(buf)
-	return NewUploadCompleteRequestWithBody(server, id, params, "application/json", bodyReader)
+	return NewAddAgentTagsRequestWithBody(server, id, params, "application/json", bodyReader)
 }
 
-// NewUploadCompleteRequestWithBody generates requests for UploadComplete with any type of body
-func NewUploadCompleteRequestWithBody(server string, id string, params *UploadCompleteParams, contentType string, body io.Reader) (*http.Request, error) {
+// NewAddAgentTagsRequestWithBody generates requests for AddAgentTags with any type of body
+func NewAddAgentTagsRequestWithBody(server string, id string, params *AddAgentTagsParams, contentType string, body io.Reader) (*http.Request, error) {
 	var err error
 
 	var pathParam0 string
@@ -951,7 +1287,7 @@ func NewUploadCompleteRequestWithBody(server string, id string, params *UploadCo
 		return nil, err
 	}
 
-	operationPath := fmt.Sprintf("/api/fleet/uploads/%s", pathParam0)
+	operationPath := fmt.Sprintf("/api/fleet/agents/%s/tags", pathParam0)
 	if operationPath[0] == '/' {
 		operationPath = "." + operationPath
 	}
@@ -997,8 +1333,8 @@ func NewUploadCompleteRequestWithBody(server string, id string, params *UploadCo
 	return req, nil
 }
 
-// NewUploadChunkRequestWithBody generates requests for UploadChunk with any type of body
-func NewUploadChunkRequestWithBody(server string, id string, chunkNum int, params *UploadChunkParams, contentType string, body io.Reader) (*http.Request, error) {
+// NewRemoveAgentTagRequest generates requests for RemoveAgentTag
+func NewRemoveAgentTagRequest(server string, id string, tag string, params *RemoveAgentTagParams) (*http.Request, error) {
 	var err error
 
 	var pathParam0 string
@@ -1010,7 +1346,7 @@ func NewUploadChunkRequestWithBody(server string, id string, chunkNum int, param
 
 	var pathParam1 string
 
-	pathParam1, err = runtime.StyleParamWithLocation("simple", false, "chunkNum", runtime.ParamLocationPath, chunkNum)
+	pathParam1, err = runtime.StyleParamWithLocation("simple", false, "tag", runtime.ParamLocationPath, tag)
 	if err != nil {
 		return nil, err
 	}
@@ -1020,7 +1356,7 @@ func NewUploadChunkRequestWithBody(server string, id string, chunkNum int, param
 		return nil, err
 	}
 
-	operationPath := fmt.Sprintf("/api/fleet/uploads/%s/%s", pathParam0, pathParam1)
+	operationPath := fmt.Sprintf("/api/fleet/agents/%s/tags/%s", pathParam0, pathParam1)
 	if operationPath[0] == '/' {
 		operationPath = "." + operationPath
 	}
@@ -1030,44 +1366,33 @@ func NewUploadChunkRequestWithBody(server string, id string, chunkNum int, param
 		return nil, err
 	}
 
-	req, err := http.NewRequest("PUT", queryURL.String(), body)
+	req, err := http.NewRequest("DELETE", queryURL.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("Content-Type", contentType)
-
 	if params != nil {
 
-		var headerParam0 string
-
-		headerParam0, err = runtime.StyleParamWithLocation("simple", false, "X-Chunk-SHA2", runtime.ParamLocationHeader, params.XChunkSHA2)
-		if err != nil {
-			return nil, err
-		}
-
-		req.Header.Set("X-Chunk-SHA2", headerParam0)
-
 		if params.XRequestId != nil {
-			var headerParam1 string
+			var headerParam0 string
 
-			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
+			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
 			if err != nil {
 				return nil, err
 			}
 
-			req.Header.Set("X-Request-Id", headerParam1)
+			req.Header.Set("X-Request-Id", headerParam0)
 		}
 
 		if params.ElasticApiVersion != nil {
-			var headerParam2 string
+			var headerParam1 string
 
-			headerParam2, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
+			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
 			if err != nil {
 				return nil, err
 			}
 
-			req.Header.Set("elastic-api-version", headerParam2)
+			req.Header.Set("elastic-api-version", headerParam1)
 		}
 
 	}
@@ -1075,16 +1400,30 @@ func NewUploadChunkRequestWithBody(server string, id string, chunkNum int, param
 	return req, nil
 }
 
-// NewStatusRequest generates requests for Status
-func NewStatusRequest(server string, params *StatusParams) (*http.Request, error) {
+// NewArtifactRequest generates requests for Artifact
+func NewArtifactRequest(server string, id string, sha2 string, params *ArtifactParams) (*http.Request, error) {
 	var err error
 
+	var pathParam0 string
+
+	pathParam0, err = runtime.StyleParamWithLocation("simple", false, "id", runtime.ParamLocationPath, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var pathParam1 string
+
+	pathParam1, err = runtime.StyleParamWithLocation("simple", false, "sha2", runtime.ParamLocationPath, sha2)
+	if err != nil {
+		return nil, err
+	}
+
 	serverURL, err := url.Parse(server)
 	if err != nil {
 		return nil, err
 	}
 
-	operationPath := fmt.Sprintf("/api/status")
+	operationPath := fmt.Sprintf("/api/fleet/artifacts/%s/%s", pathParam0, pathParam1)
 	if operationPath[0] == '/' {
 		operationPath = "." + operationPath
 	}
@@ -1123,557 +1462,2058 @@ func NewStatusRequest(server string, params *StatusParams) (*http.Request, error
 			req.Header.Set("elastic-api-version", headerParam1)
 		}
 
-	}
+		if params.IfNoneMatch != nil {
+			var headerParam2 string
 
-	return req, nil
-}
+			headerParam2, err = runtime.StyleParamWithLocation("simple", false, "If-None-Match", runtime.ParamLocationHeader, *params.IfNoneMatch)
+			if err != nil {
+				return nil, err
+			}
 
-func (c *Client) applyEditors(ctx context.Context, req *http.Request, additionalEditors []RequestEditorFn) error {
-	for _, r := range c.RequestEditors {
-		if err := r(ctx, req); err != nil {
-			return err
-		}
-	}
-	for _, r := range additionalEditors {
-		if err := r(ctx, req); err != nil {
-			return err
+			req.Header.Set("If-None-Match", headerParam2)
 		}
+
 	}
-	return nil
-}
 
-// ClientWithResponses builds on ClientInterface to offer response payloads
-type ClientWithResponses struct {
-	ClientInterface
+	return req, nil
 }
 
-// NewClientWithResponses creates a new ClientWithResponses, which wraps
-// Client with return type handling
-func NewClientWithResponses(server string, opts ...ClientOption) (*ClientWithResponses, error) {
-	client, err := NewClient(server, opts...)
+// NewGetFileRequest generates requests for GetFile
+func NewGetFileRequest(server string, id string, params *GetFileParams) (*http.Request, error) {
+	var err error
+
+	var pathParam0 string
+
+	pathParam0, err = runtime.StyleParamWithLocation("simple", false, "id", runtime.ParamLocationPath, id)
 	if err != nil {
 		return nil, err
 	}
-	return &ClientWithResponses{client}, nil
-}
 
-// WithBaseURL overrides the baseURL.
-func WithBaseURL(baseURL string) ClientOption {
-	return func(c *Client) error {
-		newBaseURL, err := url.Parse(baseURL)
-		if err != nil {
-			return err
-		}
-		c.Server = newBaseURL.String()
-		return nil
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return nil, err
 	}
-}
 
-// ClientWithResponsesInterface is the interface specification for the client with responses above.
-type ClientWithResponsesInterface interface {
-	// GetPGPKeyWithResponse request
-	GetPGPKeyWithResponse(ctx context.Context, major int, minor int, patch int, params *GetPGPKeyParams, reqEditors ...RequestEditorFn) (*GetPGPKeyResponse, error)
+	operationPath := fmt.Sprintf("/api/fleet/file/%s", pathParam0)
+	if operationPath[0] == '/' {
+		operationPath = "." + operationPath
+	}
 
-	// AgentEnrollWithBodyWithResponse request with any body
-	AgentEnrollWithBodyWithResponse(ctx context.Context, params *AgentEnrollParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AgentEnrollResponse, error)
+	queryURL, err := serverURL.Parse(operationPath)
+	if err != nil {
+		return nil, err
+	}
 
-	AgentEnrollWithResponse(ctx context.Context, params *AgentEnrollParams, body AgentEnrollJSONRequestBody, reqEditors ...RequestEditorFn) (*AgentEnrollResponse, error)
+	req, err := http.NewRequest("GET", queryURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
 
-	// AgentAcksWithBodyWithResponse request with any body
-	AgentAcksWithBodyWithResponse(ctx context.Context, id string, params *AgentAcksParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AgentAcksResponse, error)
+	if params != nil {
 
-	AgentAcksWithResponse(ctx context.Context, id string, params *AgentAcksParams, body AgentAcksJSONRequestBody, reqEditors ...RequestEditorFn) (*AgentAcksResponse, error)
+		if params.ElasticApiVersion != nil {
+			var headerParam0 string
 
-	// AuditUnenrollWithBodyWithResponse request with any body
-	AuditUnenrollWithBodyWithResponse(ctx context.Context, id string, params *AuditUnenrollParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AuditUnenrollResponse, error)
+			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
+			if err != nil {
+				return nil, err
+			}
 
-	AuditUnenrollWithResponse(ctx context.Context, id string, params *AuditUnenrollParams, body AuditUnenrollJSONRequestBody, reqEditors ...RequestEditorFn) (*AuditUnenrollResponse, error)
+			req.Header.Set("elastic-api-version", headerParam0)
+		}
 
-	// AgentCheckinWithBodyWithResponse request with any body
-	AgentCheckinWithBodyWithResponse(ctx context.Context, id string, params *AgentCheckinParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AgentCheckinResponse, error)
+		if params.XRequestId != nil {
+			var headerParam1 string
 
-	AgentCheckinWithResponse(ctx context.Context, id string, params *AgentCheckinParams, body AgentCheckinJSONRequestBody, reqEditors ...RequestEditorFn) (*AgentCheckinResponse, error)
+			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
+			if err != nil {
+				return nil, err
+			}
 
-	// ArtifactWithResponse request
-	ArtifactWithResponse(ctx context.Context, id string, sha2 string, params *ArtifactParams, reqEditors ...RequestEditorFn) (*ArtifactResponse, error)
+			req.Header.Set("X-Request-Id", headerParam1)
+		}
 
-	// GetFileWithResponse request
-	GetFileWithResponse(ctx context.Context, id string, params *GetFileParams, reqEditors ...RequestEditorFn) (*GetFileResponse, error)
+	}
 
-	// UploadBeginWithBodyWithResponse request with any body
-	UploadBeginWithBodyWithResponse(ctx context.Context, params *UploadBeginParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*UploadBeginResponse, error)
+	return req, nil
+}
 
-	UploadBeginWithResponse(ctx context.Context, params *UploadBeginParams, body UploadBeginJSONRequestBody, reqEditors ...RequestEditorFn) (*UploadBeginResponse, error)
+// NewHealthzRequest generates requests for Healthz
+func NewHealthzRequest(server string) (*http.Request, error) {
+	var err error
 
-	// UploadCompleteWithBodyWithResponse request with any body
-	UploadCompleteWithBodyWithResponse(ctx context.Context, id string, params *UploadCompleteParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*UploadCompleteResponse, error)
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
 
-	UploadCompleteWithResponse(ctx context.Context, id string, params *UploadCompleteParams, body UploadCompleteJSONRequestBody, reqEditors ...RequestEditorFn) (*UploadCompleteResponse, error)
+	operationPath := fmt.Sprintf("/api/fleet/healthz")
+	if operationPath[0] == '/' {
+		operationPath = "." + operationPath
+	}
 
-	// UploadChunkWithBodyWithResponse request with any body
-	UploadChunkWithBodyWithResponse(ctx context.Context, id string, chunkNum int, params *UploadChunkParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*UploadChunkResponse, error)
+	queryURL, err := serverURL.Parse(operationPath)
+	if err != nil {
+		return nil, err
+	}
 
-	// StatusWithResponse request
-	StatusWithResponse(ctx context.Context, params *StatusParams, reqEditors ...RequestEditorFn) (*StatusResponse, error)
-}
+	req, err := http.NewRequest("GET", queryURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
 
-type GetPGPKeyResponse struct {
-	Body         []byte
-	HTTPResponse *http.Response
-	JSON400      *BadRequest
+	return req, nil
 }
 
-// Status returns HTTPResponse.Status
-func (r GetPGPKeyResponse) Status() string {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.Status
+// NewGetOperationRequest generates requests for GetOperation
+func NewGetOperationRequest(server string, id string, params *GetOperationParams) (*http.Request, error) {
+	var err error
+
+	var pathParam0 string
+
+	pathParam0, err = runtime.StyleParamWithLocation("simple", false, "id", runtime.ParamLocationPath, id)
+	if err != nil {
+		return nil, err
 	}
-	return http.StatusText(0)
-}
 
-// StatusCode returns HTTPResponse.StatusCode
-func (r GetPGPKeyResponse) StatusCode() int {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.StatusCode
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return nil, err
 	}
-	return 0
-}
 
-type AgentEnrollResponse struct {
-	Body         []byte
-	HTTPResponse *http.Response
-	JSON200      *EnrollResponse
-	JSON400      *BadRequest
-	JSON401      *KeyNotEnabled
-	JSON408      *Deadline
-	JSON500      *InternalServerError
-	JSON503      *Unavailable
-}
+	operationPath := fmt.Sprintf("/api/fleet/operations/%s", pathParam0)
+	if operationPath[0] == '/' {
+		operationPath = "." + operationPath
+	}
 
-// Status returns HTTPResponse.Status
-func (r AgentEnrollResponse) Status() string {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.Status
+	queryURL, err := serverURL.Parse(operationPath)
+	if err != nil {
+		return nil, err
 	}
-	return http.StatusText(0)
-}
 
-// StatusCode returns HTTPResponse.StatusCode
-func (r AgentEnrollResponse) StatusCode() int {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.StatusCode
+	req, err := http.NewRequest("GET", queryURL.String(), nil)
+	if err != nil {
+		return nil, err
 	}
-	return 0
-}
 
-type AgentAcksResponse struct {
-	Body         []byte
-	HTTPResponse *http.Response
-	JSON200      *AckResponse
-	JSON400      *BadRequest
-	JSON401      *KeyNotEnabled
-	JSON403      *Forbidden
-	JSON404      *AgentNotFound
-	JSON408      *Deadline
-	JSON500      *InternalServerError
-	JSON503      *Unavailable
-}
+	if params != nil {
 
-// Status returns HTTPResponse.Status
-func (r AgentAcksResponse) Status() string {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.Status
-	}
-	return http.StatusText(0)
-}
+		if params.XRequestId != nil {
+			var headerParam0 string
 
-// StatusCode returns HTTPResponse.StatusCode
-func (r AgentAcksResponse) StatusCode() int {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.StatusCode
-	}
-	return 0
-}
+			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
+			if err != nil {
+				return nil, err
+			}
 
-type AuditUnenrollResponse struct {
-	Body         []byte
-	HTTPResponse *http.Response
-	JSON400      *BadRequest
-	JSON401      *KeyNotEnabled
-	JSON409      *Conflict
-	JSON500      *InternalServerError
-	JSON503      *Unavailable
-}
+			req.Header.Set("X-Request-Id", headerParam0)
+		}
 
-// Status returns HTTPResponse.Status
-func (r AuditUnenrollResponse) Status() string {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.Status
-	}
-	return http.StatusText(0)
-}
+		if params.ElasticApiVersion != nil {
+			var headerParam1 string
+
+			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("elastic-api-version", headerParam1)
+		}
 
-// StatusCode returns HTTPResponse.StatusCode
-func (r AuditUnenrollResponse) StatusCode() int {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.StatusCode
 	}
-	return 0
+
+	return req, nil
 }
 
-type AgentCheckinResponse struct {
-	Body         []byte
-	HTTPResponse *http.Response
-	JSON200      *CheckinResponse
-	JSON400      *BadRequest
-	JSON401      *KeyNotEnabled
-	JSON403      *Forbidden
-	JSON404      *AgentNotFound
-	JSON408      *Deadline
-	JSON500      *InternalServerError
-	JSON503      *Unavailable
-}
+// NewGetPolicyRolloutRequest generates requests for GetPolicyRollout
+func NewGetPolicyRolloutRequest(server string, id string, params *GetPolicyRolloutParams) (*http.Request, error) {
+	var err error
 
-// Status returns HTTPResponse.Status
-func (r AgentCheckinResponse) Status() string {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.Status
-	}
-	return http.StatusText(0)
-}
+	var pathParam0 string
 
-// StatusCode returns HTTPResponse.StatusCode
-func (r AgentCheckinResponse) StatusCode() int {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.StatusCode
+	pathParam0, err = runtime.StyleParamWithLocation("simple", false, "id", runtime.ParamLocationPath, id)
+	if err != nil {
+		return nil, err
 	}
-	return 0
-}
 
-type ArtifactResponse struct {
-	Body         []byte
-	HTTPResponse *http.Response
-	JSON400      *BadRequest
-	JSON401      *KeyNotEnabled
-	JSON404      *AgentNotFound
-	JSON408      *Deadline
-	JSON428      *Throttle
-	JSON500      *InternalServerError
-	JSON503      *Unavailable
-}
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
 
-// Status returns HTTPResponse.Status
-func (r ArtifactResponse) Status() string {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.Status
+	operationPath := fmt.Sprintf("/api/fleet/policies/%s/rollout", pathParam0)
+	if operationPath[0] == '/' {
+		operationPath = "." + operationPath
 	}
-	return http.StatusText(0)
-}
 
-// StatusCode returns HTTPResponse.StatusCode
-func (r ArtifactResponse) StatusCode() int {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.StatusCode
+	queryURL, err := serverURL.Parse(operationPath)
+	if err != nil {
+		return nil, err
 	}
-	return 0
-}
 
-type GetFileResponse struct {
-	Body         []byte
-	HTTPResponse *http.Response
-	JSON400      *BadRequest
-	JSON401      *KeyNotEnabled
-	JSON403      *Error
-	JSON500      *InternalServerError
-	JSON503      *Unavailable
-}
+	if params != nil {
+		queryValues := queryURL.Query()
 
-// Status returns HTTPResponse.Status
-func (r GetFileResponse) Status() string {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.Status
-	}
-	return http.StatusText(0)
-}
+		if params.StuckThreshold != nil {
 
-// StatusCode returns HTTPResponse.StatusCode
-func (r GetFileResponse) StatusCode() int {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.StatusCode
-	}
-	return 0
-}
+			if queryFrag, err := runtime.StyleParamWithLocation("form", true, "stuck_threshold", runtime.ParamLocationQuery, *params.StuckThreshold); err != nil {
+				return nil, err
+			} else if parsed, err := url.ParseQuery(queryFrag); err != nil {
+				return nil, err
+			} else {
+				for k, v := range parsed {
+					for _, v2 := range v {
+						queryValues.Add(k, v2)
+					}
+				}
+			}
 
-type UploadBeginResponse struct {
-	Body         []byte
-	HTTPResponse *http.Response
-	JSON200      *UploadBeginAPIResponse
-	JSON400      *BadRequest
-	JSON401      *KeyNotEnabled
-	JSON403      *Forbidden
-	JSON408      *Deadline
-	JSON500      *InternalServerError
-	JSON503      *Unavailable
-}
+		}
 
-// Status returns HTTPResponse.Status
-func (r UploadBeginResponse) Status() string {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.Status
+		queryURL.RawQuery = queryValues.Encode()
 	}
-	return http.StatusText(0)
-}
 
-// StatusCode returns HTTPResponse.StatusCode
-func (r UploadBeginResponse) StatusCode() int {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.StatusCode
+	req, err := http.NewRequest("GET", queryURL.String(), nil)
+	if err != nil {
+		return nil, err
 	}
-	return 0
-}
 
-type UploadCompleteResponse struct {
-	Body         []byte
-	HTTPResponse *http.Response
-	JSON200      *struct {
-		Status *string `json:"status,omitempty"`
-	}
-	JSON400 *BadRequest
-	JSON401 *KeyNotEnabled
-	JSON403 *Forbidden
-	JSON408 *Deadline
-	JSON500 *InternalServerError
-	JSON503 *Unavailable
-}
+	if params != nil {
 
-// Status returns HTTPResponse.Status
-func (r UploadCompleteResponse) Status() string {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.Status
-	}
-	return http.StatusText(0)
-}
+		if params.XRequestId != nil {
+			var headerParam0 string
 
-// StatusCode returns HTTPResponse.StatusCode
-func (r UploadCompleteResponse) StatusCode() int {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.StatusCode
-	}
-	return 0
-}
+			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
+			if err != nil {
+				return nil, err
+			}
 
-type UploadChunkResponse struct {
-	Body         []byte
-	HTTPResponse *http.Response
-	JSON400      *BadRequest
-	JSON401      *KeyNotEnabled
-	JSON403      *Forbidden
-	JSON408      *Deadline
-	JSON500      *InternalServerError
-	JSON503      *Unavailable
-}
+			req.Header.Set("X-Request-Id", headerParam0)
+		}
 
-// Status returns HTTPResponse.Status
-func (r UploadChunkResponse) Status() string {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.Status
-	}
-	return http.StatusText(0)
-}
+		if params.ElasticApiVersion != nil {
+			var headerParam1 string
 
-// StatusCode returns HTTPResponse.StatusCode
-func (r UploadChunkResponse) StatusCode() int {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.StatusCode
-	}
-	return 0
-}
+			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
+			if err != nil {
+				return nil, err
+			}
 
-type StatusResponse struct {
-	Body         []byte
-	HTTPResponse *http.Response
-	JSON200      *StatusAPIResponse
-	JSON400      *BadRequest
-	JSON503      *StatusAPIResponse
-}
+			req.Header.Set("elastic-api-version", headerParam1)
+		}
 
-// Status returns HTTPResponse.Status
-func (r StatusResponse) Status() string {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.Status
 	}
-	return http.StatusText(0)
-}
 
-// StatusCode returns HTTPResponse.StatusCode
-func (r StatusResponse) StatusCode() int {
-	if r.HTTPResponse != nil {
-		return r.HTTPResponse.StatusCode
-	}
-	return 0
+	return req, nil
 }
 
-// GetPGPKeyWithResponse request returning *GetPGPKeyResponse
-func (c *ClientWithResponses) GetPGPKeyWithResponse(ctx context.Context, major int, minor int, patch int, params *GetPGPKeyParams, reqEditors ...RequestEditorFn) (*GetPGPKeyResponse, error) {
-	rsp, err := c.GetPGPKey(ctx, major, minor, patch, params, reqEditors...)
-	if err != nil {
-		return nil, err
-	}
-	return ParseGetPGPKeyResponse(rsp)
-}
+// NewGetPolicyRequest generates requests for GetPolicy
+func NewGetPolicyRequest(server string, id string, revision int64, params *GetPolicyParams) (*http.Request, error) {
+	var err error
 
-// AgentEnrollWithBodyWithResponse request with arbitrary body returning *AgentEnrollResponse
-func (c *ClientWithResponses) AgentEnrollWithBodyWithResponse(ctx context.Context, params *AgentEnrollParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AgentEnrollResponse, error) {
-	rsp, err := c.AgentEnrollWithBody(ctx, params, contentType, body, reqEditors...)
-	if err != nil {
-		return nil, err
-	}
-	return ParseAgentEnrollResponse(rsp)
-}
+	var pathParam0 string
 
-func (c *ClientWithResponses) AgentEnrollWithResponse(ctx context.Context, params *AgentEnrollParams, body AgentEnrollJSONRequestBody, reqEditors ...RequestEditorFn) (*AgentEnrollResponse, error) {
-	rsp, err := c.AgentEnroll(ctx, params, body, reqEditors...)
+	pathParam0, err = runtime.StyleParamWithLocation("simple", false, "id", runtime.ParamLocationPath, id)
 	if err != nil {
 		return nil, err
 	}
-	return ParseAgentEnrollResponse(rsp)
-}
 
-// AgentAcksWithBodyWithResponse request with arbitrary body returning *AgentAcksResponse
-func (c *ClientWithResponses) AgentAcksWithBodyWithResponse(ctx context.Context, id string, params *AgentAcksParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AgentAcksResponse, error) {
-	rsp, err := c.AgentAcksWithBody(ctx, id, params, contentType, body, reqEditors...)
+	var pathParam1 string
+
+	pathParam1, err = runtime.StyleParamWithLocation("simple", false, "revision", runtime.ParamLocationPath, revision)
 	if err != nil {
 		return nil, err
 	}
-	return ParseAgentAcksResponse(rsp)
-}
 
-func (c *ClientWithResponses) AgentAcksWithResponse(ctx context.Context, id string, params *AgentAcksParams, body AgentAcksJSONRequestBody, reqEditors ...RequestEditorFn) (*AgentAcksResponse, error) {
-	rsp, err := c.AgentAcks(ctx, id, params, body, reqEditors...)
+	serverURL, err := url.Parse(server)
 	if err != nil {
 		return nil, err
 	}
-	return ParseAgentAcksResponse(rsp)
-}
 
-// AuditUnenrollWithBodyWithResponse request with arbitrary body returning *AuditUnenrollResponse
-func (c *ClientWithResponses) AuditUnenrollWithBodyWithResponse(ctx context.Context, id string, params *AuditUnenrollParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AuditUnenrollResponse, error) {
-	rsp, err := c.AuditUnenrollWithBody(ctx, id, params, contentType, body, reqEditors...)
-	if err != nil {
-		return nil, err
+	operationPath := fmt.Sprintf("/api/fleet/policies/%s/%s", pathParam0, pathParam1)
+	if operationPath[0] == '/' {
+		operationPath = "." + operationPath
 	}
-	return ParseAuditUnenrollResponse(rsp)
-}
 
-func (c *ClientWithResponses) AuditUnenrollWithResponse(ctx context.Context, id string, params *AuditUnenrollParams, body AuditUnenrollJSONRequestBody, reqEditors ...RequestEditorFn) (*AuditUnenrollResponse, error) {
-	rsp, err := c.AuditUnenroll(ctx, id, params, body, reqEditors...)
+	queryURL, err := serverURL.Parse(operationPath)
 	if err != nil {
 		return nil, err
 	}
-	return ParseAuditUnenrollResponse(rsp)
-}
 
-// AgentCheckinWithBodyWithResponse request with arbitrary body returning *AgentCheckinResponse
-func (c *ClientWithResponses) AgentCheckinWithBodyWithResponse(ctx context.Context, id string, params *AgentCheckinParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AgentCheckinResponse, error) {
-	rsp, err := c.AgentCheckinWithBody(ctx, id, params, contentType, body, reqEditors...)
+	req, err := http.NewRequest("GET", queryURL.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	return ParseAgentCheckinResponse(rsp)
-}
 
-func (c *ClientWithResponses) AgentCheckinWithResponse(ctx context.Context, id string, params *AgentCheckinParams, body AgentCheckinJSONRequestBody, reqEditors ...RequestEditorFn) (*AgentCheckinResponse, error) {
-	rsp, err := c.AgentCheckin(ctx, id, params, body, reqEditors...)
+	if params != nil {
+
+		if params.XRequestId != nil {
+			var headerParam0 string
+
+			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("X-Request-Id", headerParam0)
+		}
+
+		if params.ElasticApiVersion != nil {
+			var headerParam1 string
+
+			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("elastic-api-version", headerParam1)
+		}
+
+		if params.IfNoneMatch != nil {
+			var headerParam2 string
+
+			headerParam2, err = runtime.StyleParamWithLocation("simple", false, "If-None-Match", runtime.ParamLocationHeader, *params.IfNoneMatch)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("If-None-Match", headerParam2)
+		}
+
+	}
+
+	return req, nil
+}
+
+// NewUploadBeginRequest calls the generic UploadBegin builder with application/json body
+func NewUploadBeginRequest(server string, params *UploadBeginParams, body UploadBeginJSONRequestBody) (*http.Request, error) {
+	var bodyReader io.Reader
+	buf, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
-	return ParseAgentCheckinResponse(rsp)
+	bodyReader = bytes.NewReader(buf)
+	return NewUploadBeginRequestWithBody(server, params, "application/json", bodyReader)
 }
 
-// ArtifactWithResponse request returning *ArtifactResponse
-func (c *ClientWithResponses) ArtifactWithResponse(ctx context.Context, id string, sha2 string, params *ArtifactParams, reqEditors ...RequestEditorFn) (*ArtifactResponse, error) {
-	rsp, err := c.Artifact(ctx, id, sha2, params, reqEditors...)
+// NewUploadBeginRequestWithBody generates requests for UploadBegin with any type of body
+func NewUploadBeginRequestWithBody(server string, params *UploadBeginParams, contentType string, body io.Reader) (*http.Request, error) {
+	var err error
+
+	serverURL, err := url.Parse(server)
 	if err != nil {
 		return nil, err
 	}
-	return ParseArtifactResponse(rsp)
-}
 
-// GetFileWithResponse request returning *GetFileResponse
-func (c *ClientWithResponses) GetFileWithResponse(ctx context.Context, id string, params *GetFileParams, reqEditors ...RequestEditorFn) (*GetFileResponse, error) {
-	rsp, err := c.GetFile(ctx, id, params, reqEditors...)
+	operationPath := fmt.Sprintf("/api/fleet/uploads")
+	if operationPath[0] == '/' {
+		operationPath = "." + operationPath
+	}
+
+	queryURL, err := serverURL.Parse(operationPath)
 	if err != nil {
 		return nil, err
 	}
-	return ParseGetFileResponse(rsp)
-}
 
-// UploadBeginWithBodyWithResponse request with arbitrary body returning *UploadBeginResponse
-func (c *ClientWithResponses) UploadBeginWithBodyWithResponse(ctx context.Context, params *UploadBeginParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*UploadBeginResponse, error) {
-	rsp, err := c.UploadBeginWithBody(ctx, params, contentType, body, reqEditors...)
+	req, err := http.NewRequest("POST", queryURL.String(), body)
 	if err != nil {
 		return nil, err
 	}
-	return ParseUploadBeginResponse(rsp)
+
+	req.Header.Add("Content-Type", contentType)
+
+	if params != nil {
+
+		if params.XRequestId != nil {
+			var headerParam0 string
+
+			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("X-Request-Id", headerParam0)
+		}
+
+		if params.ElasticApiVersion != nil {
+			var headerParam1 string
+
+			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("elastic-api-version", headerParam1)
+		}
+
+	}
+
+	return req, nil
 }
 
-func (c *ClientWithResponses) UploadBeginWithResponse(ctx context.Context, params *UploadBeginParams, body UploadBeginJSONRequestBody, reqEditors ...RequestEditorFn) (*UploadBeginResponse, error) {
-	rsp, err := c.UploadBegin(ctx, params, body, reqEditors...)
+// NewUploadCompleteRequest calls the generic UploadComplete builder with application/json body
+func NewUploadCompleteRequest(server string, id string, params *UploadCompleteParams, body UploadCompleteJSONRequestBody) (*http.Request, error) {
+	var bodyReader io.Reader
+	buf, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
-	return ParseUploadBeginResponse(rsp)
+	bodyReader = bytes.NewReader(buf)
+	return NewUploadCompleteRequestWithBody(server, id, params, "application/json", bodyReader)
 }
 
-// UploadCompleteWithBodyWithResponse request with arbitrary body returning *UploadCompleteResponse
-func (c *ClientWithResponses) UploadCompleteWithBodyWithResponse(ctx context.Context, id string, params *UploadCompleteParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*UploadCompleteResponse, error) {
-	rsp, err := c.UploadCompleteWithBody(ctx, id, params, contentType, body, reqEditors...)
+// NewUploadCompleteRequestWithBody generates requests for UploadComplete with any type of body
+func NewUploadCompleteRequestWithBody(server string, id string, params *UploadCompleteParams, contentType string, body io.Reader) (*http.Request, error) {
+	var err error
+
+	var pathParam0 string
+
+	pathParam0, err = runtime.StyleParamWithLocation("simple", false, "id", runtime.ParamLocationPath, id)
 	if err != nil {
 		return nil, err
 	}
-	return ParseUploadCompleteResponse(rsp)
+
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+
+	operationPath := fmt.Sprintf("/api/fleet/uploads/%s", pathParam0)
+	if operationPath[0] == '/' {
+		operationPath = "." + operationPath
+	}
+
+	queryURL, err := serverURL.Parse(operationPath)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", queryURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", contentType)
+
+	if params != nil {
+
+		if params.XRequestId != nil {
+			var headerParam0 string
+
+			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("X-Request-Id", headerParam0)
+		}
+
+		if params.ElasticApiVersion != nil {
+			var headerParam1 string
+
+			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("elastic-api-version", headerParam1)
+		}
+
+	}
+
+	return req, nil
 }
 
-func (c *ClientWithResponses) UploadCompleteWithResponse(ctx context.Context, id string, params *UploadCompleteParams, body UploadCompleteJSONRequestBody, reqEditors ...RequestEditorFn) (*UploadCompleteResponse, error) {
-	rsp, err := c.UploadComplete(ctx, id, params, body, reqEditors...)
+// NewUploadChunkRequestWithBody generates requests for UploadChunk with any type of body
+func NewUploadChunkRequestWithBody(server string, id string, chunkNum int, params *UploadChunkParams, contentType string, body io.Reader) (*http.Request, error) {
+	var err error
+
+	var pathParam0 string
+
+	pathParam0, err = runtime.StyleParamWithLocation("simple", false, "id", runtime.ParamLocationPath, id)
 	if err != nil {
 		return nil, err
 	}
-	return ParseUploadCompleteResponse(rsp)
+
+	var pathParam1 string
+
+	pathParam1, err = runtime.StyleParamWithLocation("simple", false, "chunkNum", runtime.ParamLocationPath, chunkNum)
+	if err != nil {
+		return nil, err
+	}
+
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+
+	operationPath := fmt.Sprintf("/api/fleet/uploads/%s/%s", pathParam0, pathParam1)
+	if operationPath[0] == '/' {
+		operationPath = "." + operationPath
+	}
+
+	queryURL, err := serverURL.Parse(operationPath)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("PUT", queryURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", contentType)
+
+	if params != nil {
+
+		var headerParam0 string
+
+		headerParam0, err = runtime.StyleParamWithLocation("simple", false, "X-Chunk-SHA2", runtime.ParamLocationHeader, params.XChunkSHA2)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("X-Chunk-SHA2", headerParam0)
+
+		if params.XRequestId != nil {
+			var headerParam1 string
+
+			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("X-Request-Id", headerParam1)
+		}
+
+		if params.ElasticApiVersion != nil {
+			var headerParam2 string
+
+			headerParam2, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("elastic-api-version", headerParam2)
+		}
+
+	}
+
+	return req, nil
 }
 
-// UploadChunkWithBodyWithResponse request with arbitrary body returning *UploadChunkResponse
-func (c *ClientWithResponses) UploadChunkWithBodyWithResponse(ctx context.Context, id string, chunkNum int, params *UploadChunkParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*UploadChunkResponse, error) {
-	rsp, err := c.UploadChunkWithBody(ctx, id, chunkNum, params, contentType, body, reqEditors...)
-	if err != nil {
-		return nil, err
+// NewStatusRequest generates requests for Status
+func NewStatusRequest(server string, params *StatusParams) (*http.Request, error) {
+	var err error
+
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+
+	operationPath := fmt.Sprintf("/api/status")
+	if operationPath[0] == '/' {
+		operationPath = "." + operationPath
+	}
+
+	queryURL, err := serverURL.Parse(operationPath)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", queryURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if params != nil {
+
+		if params.XRequestId != nil {
+			var headerParam0 string
+
+			headerParam0, err = runtime.StyleParamWithLocation("simple", false, "X-Request-Id", runtime.ParamLocationHeader, *params.XRequestId)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("X-Request-Id", headerParam0)
+		}
+
+		if params.ElasticApiVersion != nil {
+			var headerParam1 string
+
+			headerParam1, err = runtime.StyleParamWithLocation("simple", false, "elastic-api-version", runtime.ParamLocationHeader, *params.ElasticApiVersion)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("elastic-api-version", headerParam1)
+		}
+
+	}
+
+	return req, nil
+}
+
+func (c *Client) applyEditors(ctx context.Context, req *http.Request, additionalEditors []RequestEditorFn) error {
+	for _, r := range c.RequestEditors {
+		if err := r(ctx, req); err != nil {
+			return err
+		}
+	}
+	for _, r := range additionalEditors {
+		if err := r(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClientWithResponses builds on ClientInterface to offer response payloads
+type ClientWithResponses struct {
+	ClientInterface
+}
+
+// NewClientWithResponses creates a new ClientWithResponses, which wraps
+// Client with return type handling
+func NewClientWithResponses(server string, opts ...ClientOption) (*ClientWithResponses, error) {
+	client, err := NewClient(server, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientWithResponses{client}, nil
+}
+
+// WithBaseURL overrides the baseURL.
+func WithBaseURL(baseURL string) ClientOption {
+	return func(c *Client) error {
+		newBaseURL, err := url.Parse(baseURL)
+		if err != nil {
+			return err
+		}
+		c.Server = newBaseURL.String()
+		return nil
+	}
+}
+
+// ClientWithResponsesInterface is the interface specification for the client with responses above.
+type ClientWithResponsesInterface interface {
+	// GetPGPKeyWithResponse request
+	GetPGPKeyWithResponse(ctx context.Context, major int, minor int, patch int, params *GetPGPKeyParams, reqEditors ...RequestEditorFn) (*GetPGPKeyResponse, error)
+
+	// CreateActionsWithBodyWithResponse request with any body
+	CreateActionsWithBodyWithResponse(ctx context.Context, params *CreateActionsParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*CreateActionsResponse, error)
+
+	CreateActionsWithResponse(ctx context.Context, params *CreateActionsParams, body CreateActionsJSONRequestBody, reqEditors ...RequestEditorFn) (*CreateActionsResponse, error)
+
+	// GetActionResultsWithResponse request
+	GetActionResultsWithResponse(ctx context.Context, id string, params *GetActionResultsParams, reqEditors ...RequestEditorFn) (*GetActionResultsResponse, error)
+
+	// BulkEnrollWithBodyWithResponse request with any body
+	BulkEnrollWithBodyWithResponse(ctx context.Context, params *BulkEnrollParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*BulkEnrollResponse, error)
+
+	BulkEnrollWithResponse(ctx context.Context, params *BulkEnrollParams, body BulkEnrollJSONRequestBody, reqEditors ...RequestEditorFn) (*BulkEnrollResponse, error)
+
+	// AgentEnrollWithBodyWithResponse request with any body
+	AgentEnrollWithBodyWithResponse(ctx context.Context, params *AgentEnrollParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AgentEnrollResponse, error)
+
+	AgentEnrollWithResponse(ctx context.Context, params *AgentEnrollParams, body AgentEnrollJSONRequestBody, reqEditors ...RequestEditorFn) (*AgentEnrollResponse, error)
+
+	// ReassignAgentsWithBodyWithResponse request with any body
+	ReassignAgentsWithBodyWithResponse(ctx context.Context, params *ReassignAgentsParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*ReassignAgentsResponse, error)
+
+	ReassignAgentsWithResponse(ctx context.Context, params *ReassignAgentsParams, body ReassignAgentsJSONRequestBody, reqEditors ...RequestEditorFn) (*ReassignAgentsResponse, error)
+
+	// AgentAcksWithBodyWithResponse request with any body
+	AgentAcksWithBodyWithResponse(ctx context.Context, id string, params *AgentAcksParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AgentAcksResponse, error)
+
+	AgentAcksWithResponse(ctx context.Context, id string, params *AgentAcksParams, body AgentAcksJSONRequestBody, reqEditors ...RequestEditorFn) (*AgentAcksResponse, error)
+
+	// AuditUnenrollWithBodyWithResponse request with any body
+	AuditUnenrollWithBodyWithResponse(ctx context.Context, id string, params *AuditUnenrollParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AuditUnenrollResponse, error)
+
+	AuditUnenrollWithResponse(ctx context.Context, id string, params *AuditUnenrollParams, body AuditUnenrollJSONRequestBody, reqEditors ...RequestEditorFn) (*AuditUnenrollResponse, error)
+
+	// AgentCheckinWithBodyWithResponse request with any body
+	AgentCheckinWithBodyWithResponse(ctx context.Context, id string, params *AgentCheckinParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AgentCheckinResponse, error)
+
+	AgentCheckinWithResponse(ctx context.Context, id string, params *AgentCheckinParams, body AgentCheckinJSONRequestBody, reqEditors ...RequestEditorFn) (*AgentCheckinResponse, error)
+
+	// AddAgentTagsWithBodyWithResponse request with any body
+	AddAgentTagsWithBodyWithResponse(ctx context.Context, id string, params *AddAgentTagsParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AddAgentTagsResponse, error)
+
+	AddAgentTagsWithResponse(ctx context.Context, id string, params *AddAgentTagsParams, body AddAgentTagsJSONRequestBody, reqEditors ...RequestEditorFn) (*AddAgentTagsResponse, error)
+
+	// RemoveAgentTagWithResponse request
+	RemoveAgentTagWithResponse(ctx context.Context, id string, tag string, params *RemoveAgentTagParams, reqEditors ...RequestEditorFn) (*RemoveAgentTagResponse, error)
+
+	// ArtifactWithResponse request
+	ArtifactWithResponse(ctx context.Context, id string, sha2 string, params *ArtifactParams, reqEditors ...RequestEditorFn) (*ArtifactResponse, error)
+
+	// GetFileWithResponse request
+	GetFileWithResponse(ctx context.Context, id string, params *GetFileParams, reqEditors ...RequestEditorFn) (*GetFileResponse, error)
+
+	// HealthzWithResponse request
+	HealthzWithResponse(ctx context.Context, reqEditors ...RequestEditorFn) (*HealthzResponse, error)
+
+	// GetOperationWithResponse request
+	GetOperationWithResponse(ctx context.Context, id string, params *GetOperationParams, reqEditors ...RequestEditorFn) (*GetOperationResponse, error)
+
+	// GetPolicyRolloutWithResponse request
+	GetPolicyRolloutWithResponse(ctx context.Context, id string, params *GetPolicyRolloutParams, reqEditors ...RequestEditorFn) (*GetPolicyRolloutResponse, error)
+
+	// GetPolicyWithResponse request
+	GetPolicyWithResponse(ctx context.Context, id string, revision int64, params *GetPolicyParams, reqEditors ...RequestEditorFn) (*GetPolicyResponse, error)
+
+	// UploadBeginWithBodyWithResponse request with any body
+	UploadBeginWithBodyWithResponse(ctx context.Context, params *UploadBeginParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*UploadBeginResponse, error)
+
+	UploadBeginWithResponse(ctx context.Context, params *UploadBeginParams, body UploadBeginJSONRequestBody, reqEditors ...RequestEditorFn) (*UploadBeginResponse, error)
+
+	// UploadCompleteWithBodyWithResponse request with any body
+	UploadCompleteWithBodyWithResponse(ctx context.Context, id string, params *UploadCompleteParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*UploadCompleteResponse, error)
+
+	UploadCompleteWithResponse(ctx context.Context, id string, params *UploadCompleteParams, body UploadCompleteJSONRequestBody, reqEditors ...RequestEditorFn) (*UploadCompleteResponse, error)
+
+	// UploadChunkWithBodyWithResponse request with any body
+	UploadChunkWithBodyWithResponse(ctx context.Context, id string, chunkNum int, params *UploadChunkParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*UploadChunkResponse, error)
+
+	// StatusWithResponse request
+	StatusWithResponse(ctx context.Context, params *StatusParams, reqEditors ...RequestEditorFn) (*StatusResponse, error)
+}
+
+type GetPGPKeyResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON400      *BadRequest
+}
+
+// Status returns HTTPResponse.Status
+func (r GetPGPKeyResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r GetPGPKeyResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type CreateActionsResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *CreateActionsAPIResponse
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON403      *Forbidden
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r CreateActionsResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r CreateActionsResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type GetActionResultsResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *ActionResultsResponse
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON403      *Forbidden
+	JSON404      *ActionNotFound
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r GetActionResultsResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r GetActionResultsResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type BulkEnrollResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON403      *Forbidden
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r BulkEnrollResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r BulkEnrollResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type AgentEnrollResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *EnrollResponse
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON408      *Deadline
+	JSON409      *Conflict
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r AgentEnrollResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r AgentEnrollResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type ReassignAgentsResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *ReassignAgentsAPIResponse
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON403      *Forbidden
+	JSON409      *Conflict
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r ReassignAgentsResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r ReassignAgentsResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type AgentAcksResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *AckResponse
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON403      *Forbidden
+	JSON404      *AgentNotFound
+	JSON408      *Deadline
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r AgentAcksResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r AgentAcksResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type AuditUnenrollResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON409      *Conflict
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r AuditUnenrollResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r AuditUnenrollResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type AgentCheckinResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *CheckinResponse
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON403      *Forbidden
+	JSON404      *AgentNotFound
+	JSON408      *Deadline
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r AgentCheckinResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r AgentCheckinResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type AddAgentTagsResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *AgentTagsResponse
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON403      *Forbidden
+	JSON404      *AgentNotFound
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r AddAgentTagsResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r AddAgentTagsResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type RemoveAgentTagResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *AgentTagsResponse
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON403      *Forbidden
+	JSON404      *AgentNotFound
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r RemoveAgentTagResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r RemoveAgentTagResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type ArtifactResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON404      *AgentNotFound
+	JSON408      *Deadline
+	JSON428      *Throttle
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r ArtifactResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r ArtifactResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type GetFileResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON403      *Error
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r GetFileResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r GetFileResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type HealthzResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+}
+
+// Status returns HTTPResponse.Status
+func (r HealthzResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r HealthzResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type GetOperationResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *OperationResponse
+	JSON401      *KeyNotEnabled
+	JSON403      *Forbidden
+	JSON404      *OperationNotFound
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r GetOperationResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r GetOperationResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type GetPolicyRolloutResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *PolicyRolloutResponse
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON403      *Forbidden
+	JSON404      *PolicyNotFound
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r GetPolicyRolloutResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r GetPolicyRolloutResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type GetPolicyResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *PolicyData
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON403      *Forbidden
+	JSON404      *PolicyNotFound
+	JSON408      *Deadline
+	JSON428      *Throttle
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r GetPolicyResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r GetPolicyResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type UploadBeginResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *UploadBeginAPIResponse
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON403      *Forbidden
+	JSON408      *Deadline
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r UploadBeginResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r UploadBeginResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type UploadCompleteResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *struct {
+		Status *string `json:"status,omitempty"`
+	}
+	JSON400 *BadRequest
+	JSON401 *KeyNotEnabled
+	JSON403 *Forbidden
+	JSON408 *Deadline
+	JSON500 *InternalServerError
+	JSON503 *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r UploadCompleteResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r UploadCompleteResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type UploadChunkResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON400      *BadRequest
+	JSON401      *KeyNotEnabled
+	JSON403      *Forbidden
+	JSON408      *Deadline
+	JSON500      *InternalServerError
+	JSON503      *Unavailable
+}
+
+// Status returns HTTPResponse.Status
+func (r UploadChunkResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r UploadChunkResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+type StatusResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *StatusAPIResponse
+	JSON400      *BadRequest
+	JSON503      *StatusAPIResponse
+}
+
+// Status returns HTTPResponse.Status
+func (r StatusResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r StatusResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
+// GetPGPKeyWithResponse request returning *GetPGPKeyResponse
+func (c *ClientWithResponses) GetPGPKeyWithResponse(ctx context.Context, major int, minor int, patch int, params *GetPGPKeyParams, reqEditors ...RequestEditorFn) (*GetPGPKeyResponse, error) {
+	rsp, err := c.GetPGPKey(ctx, major, minor, patch, params, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGetPGPKeyResponse(rsp)
+}
+
+// CreateActionsWithBodyWithResponse request with arbitrary body returning *CreateActionsResponse
+func (c *ClientWithResponses) CreateActionsWithBodyWithResponse(ctx context.Context, params *CreateActionsParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*CreateActionsResponse, error) {
+	rsp, err := c.CreateActionsWithBody(ctx, params, contentType, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCreateActionsResponse(rsp)
+}
+
+func (c *ClientWithResponses) CreateActionsWithResponse(ctx context.Context, params *CreateActionsParams, body CreateActionsJSONRequestBody, reqEditors ...RequestEditorFn) (*CreateActionsResponse, error) {
+	rsp, err := c.CreateActions(ctx, params, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCreateActionsResponse(rsp)
+}
+
+// GetActionResultsWithResponse request returning *GetActionResultsResponse
+func (c *ClientWithResponses) GetActionResultsWithResponse(ctx context.Context, id string, params *GetActionResultsParams, reqEditors ...RequestEditorFn) (*GetActionResultsResponse, error) {
+	rsp, err := c.GetActionResults(ctx, id, params, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGetActionResultsResponse(rsp)
+}
+
+// BulkEnrollWithBodyWithResponse request with arbitrary body returning *BulkEnrollResponse
+func (c *ClientWithResponses) BulkEnrollWithBodyWithResponse(ctx context.Context, params *BulkEnrollParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*BulkEnrollResponse, error) {
+	rsp, err := c.BulkEnrollWithBody(ctx, params, contentType, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBulkEnrollResponse(rsp)
+}
+
+func (c *ClientWithResponses) BulkEnrollWithResponse(ctx context.Context, params *BulkEnrollParams, body BulkEnrollJSONRequestBody, reqEditors ...RequestEditorFn) (*BulkEnrollResponse, error) {
+	rsp, err := c.BulkEnroll(ctx, params, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBulkEnrollResponse(rsp)
+}
+
+// AgentEnrollWithBodyWithResponse request with arbitrary body returning *AgentEnrollResponse
+func (c *ClientWithResponses) AgentEnrollWithBodyWithResponse(ctx context.Context, params *AgentEnrollParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AgentEnrollResponse, error) {
+	rsp, err := c.AgentEnrollWithBody(ctx, params, contentType, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAgentEnrollResponse(rsp)
+}
+
+func (c *ClientWithResponses) AgentEnrollWithResponse(ctx context.Context, params *AgentEnrollParams, body AgentEnrollJSONRequestBody, reqEditors ...RequestEditorFn) (*AgentEnrollResponse, error) {
+	rsp, err := c.AgentEnroll(ctx, params, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAgentEnrollResponse(rsp)
+}
+
+// ReassignAgentsWithBodyWithResponse request with arbitrary body returning *ReassignAgentsResponse
+func (c *ClientWithResponses) ReassignAgentsWithBodyWithResponse(ctx context.Context, params *ReassignAgentsParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*ReassignAgentsResponse, error) {
+	rsp, err := c.ReassignAgentsWithBody(ctx, params, contentType, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseReassignAgentsResponse(rsp)
+}
+
+func (c *ClientWithResponses) ReassignAgentsWithResponse(ctx context.Context, params *ReassignAgentsParams, body ReassignAgentsJSONRequestBody, reqEditors ...RequestEditorFn) (*ReassignAgentsResponse, error) {
+	rsp, err := c.ReassignAgents(ctx, params, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseReassignAgentsResponse(rsp)
+}
+
+// AgentAcksWithBodyWithResponse request with arbitrary body returning *AgentAcksResponse
+func (c *ClientWithResponses) AgentAcksWithBodyWithResponse(ctx context.Context, id string, params *AgentAcksParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AgentAcksResponse, error) {
+	rsp, err := c.AgentAcksWithBody(ctx, id, params, contentType, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAgentAcksResponse(rsp)
+}
+
+func (c *ClientWithResponses) AgentAcksWithResponse(ctx context.Context, id string, params *AgentAcksParams, body AgentAcksJSONRequestBody, reqEditors ...RequestEditorFn) (*AgentAcksResponse, error) {
+	rsp, err := c.AgentAcks(ctx, id, params, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAgentAcksResponse(rsp)
+}
+
+// AuditUnenrollWithBodyWithResponse request with arbitrary body returning *AuditUnenrollResponse
+func (c *ClientWithResponses) AuditUnenrollWithBodyWithResponse(ctx context.Context, id string, params *AuditUnenrollParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AuditUnenrollResponse, error) {
+	rsp, err := c.AuditUnenrollWithBody(ctx, id, params, contentType, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAuditUnenrollResponse(rsp)
+}
+
+func (c *ClientWithResponses) AuditUnenrollWithResponse(ctx context.Context, id string, params *AuditUnenrollParams, body AuditUnenrollJSONRequestBody, reqEditors ...RequestEditorFn) (*AuditUnenrollResponse, error) {
+	rsp, err := c.AuditUnenroll(ctx, id, params, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAuditUnenrollResponse(rsp)
+}
+
+// AgentCheckinWithBodyWithResponse request with arbitrary body returning *AgentCheckinResponse
+func (c *ClientWithResponses) AgentCheckinWithBodyWithResponse(ctx context.Context, id string, params *AgentCheckinParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AgentCheckinResponse, error) {
+	rsp, err := c.AgentCheckinWithBody(ctx, id, params, contentType, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAgentCheckinResponse(rsp)
+}
+
+func (c *ClientWithResponses) AgentCheckinWithResponse(ctx context.Context, id string, params *AgentCheckinParams, body AgentCheckinJSONRequestBody, reqEditors ...RequestEditorFn) (*AgentCheckinResponse, error) {
+	rsp, err := c.AgentCheckin(ctx, id, params, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAgentCheckinResponse(rsp)
+}
+
+// AddAgentTagsWithBodyWithResponse request with arbitrary body returning *AddAgentTagsResponse
+func (c *ClientWithResponses) AddAgentTagsWithBodyWithResponse(ctx context.Context, id string, params *AddAgentTagsParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*AddAgentTagsResponse, error) {
+	rsp, err := c.AddAgentTagsWithBody(ctx, id, params, contentType, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAddAgentTagsResponse(rsp)
+}
+
+func (c *ClientWithResponses) AddAgentTagsWithResponse(ctx context.Context, id string, params *AddAgentTagsParams, body AddAgentTagsJSONRequestBody, reqEditors ...RequestEditorFn) (*AddAgentTagsResponse, error) {
+	rsp, err := c.AddAgentTags(ctx, id, params, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAddAgentTagsResponse(rsp)
+}
+
+// RemoveAgentTagWithResponse request returning *RemoveAgentTagResponse
+func (c *ClientWithResponses) RemoveAgentTagWithResponse(ctx context.Context, id string, tag string, params *RemoveAgentTagParams, reqEditors ...RequestEditorFn) (*RemoveAgentTagResponse, error) {
+	rsp, err := c.RemoveAgentTag(ctx, id, tag, params, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRemoveAgentTagResponse(rsp)
+}
+
+// ArtifactWithResponse request returning *ArtifactResponse
+func (c *ClientWithResponses) ArtifactWithResponse(ctx context.Context, id string, sha2 string, params *ArtifactParams, reqEditors ...RequestEditorFn) (*ArtifactResponse, error) {
+	rsp, err := c.Artifact(ctx, id, sha2, params, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseArtifactResponse(rsp)
+}
+
+// GetFileWithResponse request returning *GetFileResponse
+func (c *ClientWithResponses) GetFileWithResponse(ctx context.Context, id string, params *GetFileParams, reqEditors ...RequestEditorFn) (*GetFileResponse, error) {
+	rsp, err := c.GetFile(ctx, id, params, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGetFileResponse(rsp)
+}
+
+// HealthzWithResponse request returning *HealthzResponse
+func (c *ClientWithResponses) HealthzWithResponse(ctx context.Context, reqEditors ...RequestEditorFn) (*HealthzResponse, error) {
+	rsp, err := c.Healthz(ctx, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseHealthzResponse(rsp)
+}
+
+// GetOperationWithResponse request returning *GetOperationResponse
+func (c *ClientWithResponses) GetOperationWithResponse(ctx context.Context, id string, params *GetOperationParams, reqEditors ...RequestEditorFn) (*GetOperationResponse, error) {
+	rsp, err := c.GetOperation(ctx, id, params, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGetOperationResponse(rsp)
+}
+
+// GetPolicyRolloutWithResponse request returning *GetPolicyRolloutResponse
+func (c *ClientWithResponses) GetPolicyRolloutWithResponse(ctx context.Context, id string, params *GetPolicyRolloutParams, reqEditors ...RequestEditorFn) (*GetPolicyRolloutResponse, error) {
+	rsp, err := c.GetPolicyRollout(ctx, id, params, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGetPolicyRolloutResponse(rsp)
+}
+
+// GetPolicyWithResponse request returning *GetPolicyResponse
+func (c *ClientWithResponses) GetPolicyWithResponse(ctx context.Context, id string, revision int64, params *GetPolicyParams, reqEditors ...RequestEditorFn) (*GetPolicyResponse, error) {
+	rsp, err := c.GetPolicy(ctx, id, revision, params, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGetPolicyResponse(rsp)
+}
+
+// UploadBeginWithBodyWithResponse request with arbitrary body returning *UploadBeginResponse
+func (c *ClientWithResponses) UploadBeginWithBodyWithResponse(ctx context.Context, params *UploadBeginParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*UploadBeginResponse, error) {
+	rsp, err := c.UploadBeginWithBody(ctx, params, contentType, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseUploadBeginResponse(rsp)
+}
+
+func (c *ClientWithResponses) UploadBeginWithResponse(ctx context.Context, params *UploadBeginParams, body UploadBeginJSONRequestBody, reqEditors ...RequestEditorFn) (*UploadBeginResponse, error) {
+	rsp, err := c.UploadBegin(ctx, params, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseUploadBeginResponse(rsp)
+}
+
+// UploadCompleteWithBodyWithResponse request with arbitrary body returning *UploadCompleteResponse
+func (c *ClientWithResponses) UploadCompleteWithBodyWithResponse(ctx context.Context, id string, params *UploadCompleteParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*UploadCompleteResponse, error) {
+	rsp, err := c.UploadCompleteWithBody(ctx, id, params, contentType, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseUploadCompleteResponse(rsp)
+}
+
+func (c *ClientWithResponses) UploadCompleteWithResponse(ctx context.Context, id string, params *UploadCompleteParams, body UploadCompleteJSONRequestBody, reqEditors ...RequestEditorFn) (*UploadCompleteResponse, error) {
+	rsp, err := c.UploadComplete(ctx, id, params, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseUploadCompleteResponse(rsp)
+}
+
+// UploadChunkWithBodyWithResponse request with arbitrary body returning *UploadChunkResponse
+func (c *ClientWithResponses) UploadChunkWithBodyWithResponse(ctx context.Context, id string, chunkNum int, params *UploadChunkParams, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*UploadChunkResponse, error) {
+	rsp, err := c.UploadChunkWithBody(ctx, id, chunkNum, params, contentType, body, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseUploadChunkResponse(rsp)
+}
+
+// StatusWithResponse request returning *StatusResponse
+func (c *ClientWithResponses) StatusWithResponse(ctx context.Context, params *StatusParams, reqEditors ...RequestEditorFn) (*StatusResponse, error) {
+	rsp, err := c.Status(ctx, params, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseStatusResponse(rsp)
+}
+
+// ParseGetPGPKeyResponse parses an HTTP response from a GetPGPKeyWithResponse call
+func ParseGetPGPKeyResponse(rsp *http.Response) (*GetPGPKeyResponse, error) {
+	bodyBytes, err := io.ReadAll(rsp.Body)
+	defer func() { _ = rsp.Body.Close() }()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &GetPGPKeyResponse{
+		Body:         bodyBytes,
+		HTTPResponse: rsp,
+	}
+
+	switch {
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 400:
+		var dest BadRequest
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON400 = &dest
+
+	}
+
+	return response, nil
+}
+
+// ParseCreateActionsResponse parses an HTTP response from a CreateActionsWithResponse call
+func ParseCreateActionsResponse(rsp *http.Response) (*CreateActionsResponse, error) {
+	bodyBytes, err := io.ReadAll(rsp.Body)
+	defer func() { _ = rsp.Body.Close() }()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &CreateActionsResponse{
+		Body:         bodyBytes,
+		HTTPResponse: rsp,
+	}
+
+	switch {
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 200:
+		var dest CreateActionsAPIResponse
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON200 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 400:
+		var dest BadRequest
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON400 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest KeyNotEnabled
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest Forbidden
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON403 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
+		var dest InternalServerError
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON500 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 503:
+		var dest Unavailable
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON503 = &dest
+
+	}
+
+	return response, nil
+}
+
+// ParseGetActionResultsResponse parses an HTTP response from a GetActionResultsWithResponse call
+func ParseGetActionResultsResponse(rsp *http.Response) (*GetActionResultsResponse, error) {
+	bodyBytes, err := io.ReadAll(rsp.Body)
+	defer func() { _ = rsp.Body.Close() }()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &GetActionResultsResponse{
+		Body:         bodyBytes,
+		HTTPResponse: rsp,
+	}
+
+	switch {
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 200:
+		var dest ActionResultsResponse
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON200 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 400:
+		var dest BadRequest
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON400 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest KeyNotEnabled
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest Forbidden
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON403 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 404:
+		var dest ActionNotFound
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON404 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
+		var dest InternalServerError
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON500 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 503:
+		var dest Unavailable
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON503 = &dest
+
+	}
+
+	return response, nil
+}
+
+// ParseBulkEnrollResponse parses an HTTP response from a BulkEnrollWithResponse call
+func ParseBulkEnrollResponse(rsp *http.Response) (*BulkEnrollResponse, error) {
+	bodyBytes, err := io.ReadAll(rsp.Body)
+	defer func() { _ = rsp.Body.Close() }()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &BulkEnrollResponse{
+		Body:         bodyBytes,
+		HTTPResponse: rsp,
+	}
+
+	switch {
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 400:
+		var dest BadRequest
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON400 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest KeyNotEnabled
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest Forbidden
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON403 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
+		var dest InternalServerError
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON500 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 503:
+		var dest Unavailable
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON503 = &dest
+
+	}
+
+	return response, nil
+}
+
+// ParseAgentEnrollResponse parses an HTTP response from a AgentEnrollWithResponse call
+func ParseAgentEnrollResponse(rsp *http.Response) (*AgentEnrollResponse, error) {
+	bodyBytes, err := io.ReadAll(rsp.Body)
+	defer func() { _ = rsp.Body.Close() }()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &AgentEnrollResponse{
+		Body:         bodyBytes,
+		HTTPResponse: rsp,
+	}
+
+	switch {
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 200:
+		var dest EnrollResponse
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON200 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 400:
+		var dest BadRequest
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON400 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest KeyNotEnabled
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 408:
+		var dest Deadline
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON408 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 409:
+		var dest Conflict
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON409 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
+		var dest InternalServerError
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON500 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 503:
+		var dest Unavailable
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON503 = &dest
+
+	}
+
+	return response, nil
+}
+
+// ParseReassignAgentsResponse parses an HTTP response from a ReassignAgentsWithResponse call
+func ParseReassignAgentsResponse(rsp *http.Response) (*ReassignAgentsResponse, error) {
+	bodyBytes, err := io.ReadAll(rsp.Body)
+	defer func() { _ = rsp.Body.Close() }()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ReassignAgentsResponse{
+		Body:         bodyBytes,
+		HTTPResponse: rsp,
+	}
+
+	switch {
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 200:
+		var dest ReassignAgentsAPIResponse
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON200 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 400:
+		var dest BadRequest
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON400 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest KeyNotEnabled
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest Forbidden
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON403 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 409:
+		var dest Conflict
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON409 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
+		var dest InternalServerError
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON500 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 503:
+		var dest Unavailable
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON503 = &dest
+
+	}
+
+	return response, nil
+}
+
+// ParseAgentAcksResponse parses an HTTP response from a AgentAcksWithResponse call
+func ParseAgentAcksResponse(rsp *http.Response) (*AgentAcksResponse, error) {
+	bodyBytes, err := io.ReadAll(rsp.Body)
+	defer func() { _ = rsp.Body.Close() }()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &AgentAcksResponse{
+		Body:         bodyBytes,
+		HTTPResponse: rsp,
+	}
+
+	switch {
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 200:
+		var dest AckResponse
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON200 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 400:
+		var dest BadRequest
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON400 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest KeyNotEnabled
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest Forbidden
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON403 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 404:
+		var dest AgentNotFound
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON404 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 408:
+		var dest Deadline
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON408 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
+		var dest InternalServerError
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON500 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 503:
+		var dest Unavailable
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON503 = &dest
+
+	}
+
+	return response, nil
+}
+
+// ParseAuditUnenrollResponse parses an HTTP response from a AuditUnenrollWithResponse call
+func ParseAuditUnenrollResponse(rsp *http.Response) (*AuditUnenrollResponse, error) {
+	bodyBytes, err := io.ReadAll(rsp.Body)
+	defer func() { _ = rsp.Body.Close() }()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &AuditUnenrollResponse{
+		Body:         bodyBytes,
+		HTTPResponse: rsp,
+	}
+
+	switch {
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 400:
+		var dest BadRequest
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON400 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest KeyNotEnabled
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 409:
+		var dest Conflict
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON409 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
+		var dest InternalServerError
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON500 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 503:
+		var dest Unavailable
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON503 = &dest
+
 	}
-	return ParseUploadChunkResponse(rsp)
-}
 
-// StatusWithResponse request returning *StatusResponse
-func (c *ClientWithResponses) StatusWithResponse(ctx context.Context, params *StatusParams, reqEditors ...RequestEditorFn) (*StatusResponse, error) {
-	rsp, err := c.Status(ctx, params, reqEditors...)
-	if err != nil {
-		return nil, err
-	}
-	return ParseStatusResponse(rsp)
+	return response, nil
 }
 
-// ParseGetPGPKeyResponse parses an HTTP response from a GetPGPKeyWithResponse call
-func ParseGetPGPKeyResponse(rsp *http.Response) (*GetPGPKeyResponse, error) {
+// ParseAgentCheckinResponse parses an HTTP response from a AgentCheckinWithResponse call
+func ParseAgentCheckinResponse(rsp *http.Response) (*AgentCheckinResponse, error) {
 	bodyBytes, err := io.ReadAll(rsp.Body)
 	defer func() { _ = rsp.Body.Close() }()
 	if err != nil {
 		return nil, err
 	}
 
-	response := &GetPGPKeyResponse{
+	response := &AgentCheckinResponse{
 		Body:         bodyBytes,
 		HTTPResponse: rsp,
 	}
 
 	switch {
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 200:
+		var dest CheckinResponse
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON200 = &dest
+
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 400:
 		var dest BadRequest
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
@@ -1681,27 +3521,69 @@ func ParseGetPGPKeyResponse(rsp *http.Response) (*GetPGPKeyResponse, error) {
 		}
 		response.JSON400 = &dest
 
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest KeyNotEnabled
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest Forbidden
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON403 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 404:
+		var dest AgentNotFound
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON404 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 408:
+		var dest Deadline
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON408 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
+		var dest InternalServerError
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON500 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 503:
+		var dest Unavailable
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON503 = &dest
+
 	}
 
 	return response, nil
 }
 
-// ParseAgentEnrollResponse parses an HTTP response from a AgentEnrollWithResponse call
-func ParseAgentEnrollResponse(rsp *http.Response) (*AgentEnrollResponse, error) {
+// ParseAddAgentTagsResponse parses an HTTP response from a AddAgentTagsWithResponse call
+func ParseAddAgentTagsResponse(rsp *http.Response) (*AddAgentTagsResponse, error) {
 	bodyBytes, err := io.ReadAll(rsp.Body)
 	defer func() { _ = rsp.Body.Close() }()
 	if err != nil {
 		return nil, err
 	}
 
-	response := &AgentEnrollResponse{
+	response := &AddAgentTagsResponse{
 		Body:         bodyBytes,
 		HTTPResponse: rsp,
 	}
 
 	switch {
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 200:
-		var dest EnrollResponse
+		var dest AgentTagsResponse
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
 			return nil, err
 		}
@@ -1721,12 +3603,19 @@ func ParseAgentEnrollResponse(rsp *http.Response) (*AgentEnrollResponse, error)
 		}
 		response.JSON401 = &dest
 
-	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 408:
-		var dest Deadline
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest Forbidden
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
 			return nil, err
 		}
-		response.JSON408 = &dest
+		response.JSON403 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 404:
+		var dest AgentNotFound
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON404 = &dest
 
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
 		var dest InternalServerError
@@ -1747,22 +3636,22 @@ func ParseAgentEnrollResponse(rsp *http.Response) (*AgentEnrollResponse, error)
 	return response, nil
 }
 
-// ParseAgentAcksResponse parses an HTTP response from a AgentAcksWithResponse call
-func ParseAgentAcksResponse(rsp *http.Response) (*AgentAcksResponse, error) {
+// ParseRemoveAgentTagResponse parses an HTTP response from a RemoveAgentTagWithResponse call
+func ParseRemoveAgentTagResponse(rsp *http.Response) (*RemoveAgentTagResponse, error) {
 	bodyBytes, err := io.ReadAll(rsp.Body)
 	defer func() { _ = rsp.Body.Close() }()
 	if err != nil {
 		return nil, err
 	}
 
-	response := &AgentAcksResponse{
+	response := &RemoveAgentTagResponse{
 		Body:         bodyBytes,
 		HTTPResponse: rsp,
 	}
 
 	switch {
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 200:
-		var dest AckResponse
+		var dest AgentTagsResponse
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
 			return nil, err
 		}
@@ -1796,6 +3685,60 @@ func ParseAgentAcksResponse(rsp *http.Response) (*AgentAcksResponse, error) {
 		}
 		response.JSON404 = &dest
 
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
+		var dest InternalServerError
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON500 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 503:
+		var dest Unavailable
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON503 = &dest
+
+	}
+
+	return response, nil
+}
+
+// ParseArtifactResponse parses an HTTP response from a ArtifactWithResponse call
+func ParseArtifactResponse(rsp *http.Response) (*ArtifactResponse, error) {
+	bodyBytes, err := io.ReadAll(rsp.Body)
+	defer func() { _ = rsp.Body.Close() }()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ArtifactResponse{
+		Body:         bodyBytes,
+		HTTPResponse: rsp,
+	}
+
+	switch {
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 400:
+		var dest BadRequest
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON400 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest KeyNotEnabled
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 404:
+		var dest AgentNotFound
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON404 = &dest
+
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 408:
 		var dest Deadline
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
@@ -1803,6 +3746,13 @@ func ParseAgentAcksResponse(rsp *http.Response) (*AgentAcksResponse, error) {
 		}
 		response.JSON408 = &dest
 
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 428:
+		var dest Throttle
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON428 = &dest
+
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
 		var dest InternalServerError
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
@@ -1822,15 +3772,15 @@ func ParseAgentAcksResponse(rsp *http.Response) (*AgentAcksResponse, error) {
 	return response, nil
 }
 
-// ParseAuditUnenrollResponse parses an HTTP response from a AuditUnenrollWithResponse call
-func ParseAuditUnenrollResponse(rsp *http.Response) (*AuditUnenrollResponse, error) {
+// ParseGetFileResponse parses an HTTP response from a GetFileWithResponse call
+func ParseGetFileResponse(rsp *http.Response) (*GetFileResponse, error) {
 	bodyBytes, err := io.ReadAll(rsp.Body)
 	defer func() { _ = rsp.Body.Close() }()
 	if err != nil {
 		return nil, err
 	}
 
-	response := &AuditUnenrollResponse{
+	response := &GetFileResponse{
 		Body:         bodyBytes,
 		HTTPResponse: rsp,
 	}
@@ -1850,12 +3800,12 @@ func ParseAuditUnenrollResponse(rsp *http.Response) (*AuditUnenrollResponse, err
 		}
 		response.JSON401 = &dest
 
-	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 409:
-		var dest Conflict
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest Error
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
 			return nil, err
 		}
-		response.JSON409 = &dest
+		response.JSON403 = &dest
 
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
 		var dest InternalServerError
@@ -1876,34 +3826,43 @@ func ParseAuditUnenrollResponse(rsp *http.Response) (*AuditUnenrollResponse, err
 	return response, nil
 }
 
-// ParseAgentCheckinResponse parses an HTTP response from a AgentCheckinWithResponse call
-func ParseAgentCheckinResponse(rsp *http.Response) (*AgentCheckinResponse, error) {
+// ParseHealthzResponse parses an HTTP response from a HealthzWithResponse call
+func ParseHealthzResponse(rsp *http.Response) (*HealthzResponse, error) {
 	bodyBytes, err := io.ReadAll(rsp.Body)
 	defer func() { _ = rsp.Body.Close() }()
 	if err != nil {
 		return nil, err
 	}
 
-	response := &AgentCheckinResponse{
+	response := &HealthzResponse{
+		Body:         bodyBytes,
+		HTTPResponse: rsp,
+	}
+
+	return response, nil
+}
+
+// ParseGetOperationResponse parses an HTTP response from a GetOperationWithResponse call
+func ParseGetOperationResponse(rsp *http.Response) (*GetOperationResponse, error) {
+	bodyBytes, err := io.ReadAll(rsp.Body)
+	defer func() { _ = rsp.Body.Close() }()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &GetOperationResponse{
 		Body:         bodyBytes,
 		HTTPResponse: rsp,
 	}
 
 	switch {
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 200:
-		var dest CheckinResponse
+		var dest OperationResponse
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
 			return nil, err
 		}
 		response.JSON200 = &dest
 
-	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 400:
-		var dest BadRequest
-		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
-			return nil, err
-		}
-		response.JSON400 = &dest
-
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
 		var dest KeyNotEnabled
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
@@ -1919,19 +3878,12 @@ func ParseAgentCheckinResponse(rsp *http.Response) (*AgentCheckinResponse, error
 		response.JSON403 = &dest
 
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 404:
-		var dest AgentNotFound
+		var dest OperationNotFound
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
 			return nil, err
 		}
 		response.JSON404 = &dest
 
-	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 408:
-		var dest Deadline
-		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
-			return nil, err
-		}
-		response.JSON408 = &dest
-
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
 		var dest InternalServerError
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
@@ -1951,20 +3903,27 @@ func ParseAgentCheckinResponse(rsp *http.Response) (*AgentCheckinResponse, error
 	return response, nil
 }
 
-// ParseArtifactResponse parses an HTTP response from a ArtifactWithResponse call
-func ParseArtifactResponse(rsp *http.Response) (*ArtifactResponse, error) {
+// ParseGetPolicyRolloutResponse parses an HTTP response from a GetPolicyRolloutWithResponse call
+func ParseGetPolicyRolloutResponse(rsp *http.Response) (*GetPolicyRolloutResponse, error) {
 	bodyBytes, err := io.ReadAll(rsp.Body)
 	defer func() { _ = rsp.Body.Close() }()
 	if err != nil {
 		return nil, err
 	}
 
-	response := &ArtifactResponse{
+	response := &GetPolicyRolloutResponse{
 		Body:         bodyBytes,
 		HTTPResponse: rsp,
 	}
 
 	switch {
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 200:
+		var dest PolicyRolloutResponse
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON200 = &dest
+
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 400:
 		var dest BadRequest
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
@@ -1979,26 +3938,19 @@ func ParseArtifactResponse(rsp *http.Response) (*ArtifactResponse, error) {
 		}
 		response.JSON401 = &dest
 
-	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 404:
-		var dest AgentNotFound
-		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
-			return nil, err
-		}
-		response.JSON404 = &dest
-
-	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 408:
-		var dest Deadline
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest Forbidden
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
 			return nil, err
 		}
-		response.JSON408 = &dest
+		response.JSON403 = &dest
 
-	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 428:
-		var dest Throttle
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 404:
+		var dest PolicyNotFound
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
 			return nil, err
 		}
-		response.JSON428 = &dest
+		response.JSON404 = &dest
 
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
 		var dest InternalServerError
@@ -2019,20 +3971,27 @@ func ParseArtifactResponse(rsp *http.Response) (*ArtifactResponse, error) {
 	return response, nil
 }
 
-// ParseGetFileResponse parses an HTTP response from a GetFileWithResponse call
-func ParseGetFileResponse(rsp *http.Response) (*GetFileResponse, error) {
+// ParseGetPolicyResponse parses an HTTP response from a GetPolicyWithResponse call
+func ParseGetPolicyResponse(rsp *http.Response) (*GetPolicyResponse, error) {
 	bodyBytes, err := io.ReadAll(rsp.Body)
 	defer func() { _ = rsp.Body.Close() }()
 	if err != nil {
 		return nil, err
 	}
 
-	response := &GetFileResponse{
+	response := &GetPolicyResponse{
 		Body:         bodyBytes,
 		HTTPResponse: rsp,
 	}
 
 	switch {
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 200:
+		var dest PolicyData
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON200 = &dest
+
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 400:
 		var dest BadRequest
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
@@ -2048,12 +4007,33 @@ func ParseGetFileResponse(rsp *http.Response) (*GetFileResponse, error) {
 		response.JSON401 = &dest
 
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
-		var dest Error
+		var dest Forbidden
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
 			return nil, err
 		}
 		response.JSON403 = &dest
 
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 404:
+		var dest PolicyNotFound
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON404 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 408:
+		var dest Deadline
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON408 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 428:
+		var dest Throttle
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON428 = &dest
+
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
 		var dest InternalServerError
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
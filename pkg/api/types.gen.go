@@ -16,8 +16,10 @@ import (
 )
 
 const (
-	AgentApiKeyScopes = "agentApiKey.Scopes"
-	ApiKeyScopes      = "apiKey.Scopes"
+	AdminApiKeyScopes  = "adminApiKey.Scopes"
+	AgentApiKeyScopes  = "agentApiKey.Scopes"
+	ApiKeyScopes       = "apiKey.Scopes"
+	ServiceTokenScopes = "serviceToken.Scopes"
 )
 
 // Defines values for ActionType.
@@ -156,6 +158,7 @@ type AckResponse struct {
 // AckResponseItem The results of processing an acknowledgement event.
 type AckResponseItem struct {
 	// Message HTTP status text.
+	// The ignored acks of policy change actions are successful with the reason as message, policy_superseded for an action of another policy than the agent's, or policy_revision_stale for an older revision than the agent's. The acks of the actions of an unknown type are recorded with the message action_type_unsupported.
 	Message *string `json:"message,omitempty"`
 
 	// Status An HTTP status code that indicates if the event was processed successfully or not.
@@ -208,6 +211,21 @@ type Action_Data struct {
 // ActionType The action type. If fleet-server encounters an action that does not have a type listed below it will be filtered out and an error will be logged.
 type ActionType string
 
+// ActionAgentResult The status of an action for an agent.
+type ActionAgentResult struct {
+	// AgentId The agent ID.
+	AgentId string `json:"agent_id"`
+
+	// CompletedAt The time of the latest result of the agent.
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	// Error The error the agent reported.
+	Error *string `json:"error,omitempty"`
+
+	// Status The status of the action for the agent, one of acked, failed, pending or expired.
+	Status string `json:"status"`
+}
+
 // ActionCancel The CANCEL action data.
 type ActionCancel struct {
 	TargetId string `json:"target_id"`
@@ -218,6 +236,10 @@ type ActionInputAction = map[string]interface{}
 
 // ActionPolicyChange The POLICY_CHANGE action data.
 type ActionPolicyChange struct {
+	// Fetch Set when the policy revision is larger than the max size of the policies delivered in the checkin responses.
+	// The policy of the action then only holds the id, revision, outputs and secret_paths of the policy, the agent fetches the other fields from the policy endpoint.
+	Fetch *PolicyFetch `json:"fetch,omitempty"`
+
 	// Policy The full policy that an agent should run after combining with local configuration/env vars.
 	Policy PolicyData `json:"policy"`
 }
@@ -236,6 +258,34 @@ type ActionRequestDiagnostics struct {
 // ActionRequestDiagnosticsAdditionalMetrics defines model for ActionRequestDiagnostics.AdditionalMetrics.
 type ActionRequestDiagnosticsAdditionalMetrics string
 
+// ActionResultsResponse The results of an action aggregated over the agents it targets.
+// The agents that have not responded are pending until the action expires.
+type ActionResultsResponse struct {
+	// Acked The number of agents that completed the action.
+	Acked int `json:"acked"`
+
+	// ActionId The action ID.
+	ActionId string `json:"action_id"`
+
+	// Agents The status of each agent the action targets, ordered by agent ID. Only included when requested.
+	Agents *[]ActionAgentResult `json:"agents,omitempty"`
+
+	// Expired The number of agents that did not complete the action before it expired.
+	Expired int `json:"expired"`
+
+	// Failed The number of agents that reported an error.
+	Failed int `json:"failed"`
+
+	// NextFrom The offset of the next page of agents, not set on the last page.
+	NextFrom *int `json:"next_from,omitempty"`
+
+	// Pending The number of agents that have not responded to the action yet.
+	Pending int `json:"pending"`
+
+	// Total The number of agents the action targets.
+	Total int `json:"total"`
+}
+
 // ActionSettings The SETTINGS action data.
 type ActionSettings struct {
 	LogLevel *ActionSettingsLogLevel `json:"log_level,omitempty"`
@@ -256,6 +306,19 @@ type ActionSignature struct {
 // ActionUnenroll The UNENROLL action data.
 type ActionUnenroll = interface{}
 
+// ActionTarget A filter of the agents an action targets, evaluated when each agent checks in until the action expires.
+// An agent matches when it satisfies all the set predicates, the action is delivered to each matching agent once.
+type ActionTarget struct {
+	// PolicyId The ID of the policy the agent is assigned to.
+	PolicyId *string `json:"policy_id,omitempty"`
+
+	// Tags The tags the agent must all have.
+	Tags *[]string `json:"tags,omitempty"`
+
+	// Version The semver constraint the agent version must satisfy, the pre-release of the agent version is ignored.
+	Version *string `json:"version,omitempty"`
+}
+
 // ActionUpgrade the UPGRADE action data.
 type ActionUpgrade struct {
 	// SourceUri The source of the upgrade artifact.
@@ -265,6 +328,74 @@ type ActionUpgrade struct {
 	Version string `json:"version"`
 }
 
+// ActionV2 An action for an elastic-agent, in the checkin responses of the 2025-06-01 API version.
+// The data of the action is in the field named after its type in lower case, UNENROLL actions have no data.
+type ActionV2 struct {
+	// AgentId The agent ID.
+	AgentId string `json:"agent_id"`
+
+	// Cancel The CANCEL action data.
+	Cancel *ActionCancel `json:"cancel,omitempty"`
+
+	// CreatedAt Time when the action was created.
+	CreatedAt string `json:"created_at"`
+
+	// Expiration The latest start time for the action. Actions will be dropped by the agent if execution has not started by this time. Used for scheduled actions.
+	Expiration *string `json:"expiration,omitempty"`
+
+	// Id The action ID.
+	Id string `json:"id"`
+
+	// InputAction The INPUT_ACTION action data.
+	InputAction *ActionInputAction `json:"input_action,omitempty"`
+
+	// InputType The input type of the action for actions with type `INPUT_ACTION`.
+	InputType *string `json:"input_type,omitempty"`
+
+	// PolicyChange The POLICY_CHANGE action data.
+	PolicyChange *ActionPolicyChange `json:"policy_change,omitempty"`
+
+	// PolicyReassign The POLICY_REASSIGN action data.
+	PolicyReassign *ActionPolicyReassign `json:"policy_reassign,omitempty"`
+
+	// RequestDiagnostics The REQUEST_DIAGNOSTICS action data.
+	RequestDiagnostics *ActionRequestDiagnostics `json:"request_diagnostics,omitempty"`
+
+	// Settings The SETTINGS action data.
+	Settings *ActionSettings `json:"settings,omitempty"`
+
+	// Signed Optional action signing data.
+	Signed *ActionSignature `json:"signed,omitempty"`
+
+	// StartTime The earliest execution time for the action. Agent will not execute the action before this time. Used for scheduled actions.
+	StartTime *string `json:"start_time,omitempty"`
+
+	// Timeout The timeout value (in seconds) for actions with type `INPUT_ACTION`.
+	Timeout *int64 `json:"timeout,omitempty"`
+
+	// Traceparent APM traceparent for the action.
+	Traceparent *string `json:"traceparent,omitempty"`
+
+	// Type The action type, one of the types of action.
+	Type string `json:"type"`
+
+	// Upgrade the UPGRADE action data.
+	Upgrade *ActionUpgrade `json:"upgrade,omitempty"`
+}
+
+// AgentTagsRequest Request to add tags to an agent.
+type AgentTagsRequest struct {
+	// Tags The tags to add to the agent, tags the agent already has are ignored.
+	// The number of tags of an agent and the length of a tag are capped by the server.
+	Tags []string `json:"tags"`
+}
+
+// AgentTagsResponse The tags of an agent after they were updated.
+type AgentTagsResponse struct {
+	// Tags The tags of the agent.
+	Tags []string `json:"tags"`
+}
+
 // AuditUnenrollRequest Request to add unenroll audit information to an agent document.
 type AuditUnenrollRequest struct {
 	// Reason The unenroll reason
@@ -277,15 +408,81 @@ type AuditUnenrollRequest struct {
 // AuditUnenrollRequestReason The unenroll reason
 type AuditUnenrollRequestReason string
 
+// BulkEnrollAgent An agent of a bulk enroll request.
+type BulkEnrollAgent struct {
+	// Id The ID of the agent, generated when not set.
+	// The enrollment of the agent fails if an agent with the same ID exists, the agents are not replaced.
+	Id *string `json:"id,omitempty"`
+
+	// Metadata Metadata associated with the agent that is enrolling to fleet.
+	Metadata *EnrollMetadata `json:"metadata,omitempty"`
+
+	// PolicyId The ID of the policy the agent is enrolled in.
+	PolicyId string `json:"policy_id"`
+}
+
+// BulkEnrollRequest A request to enroll a batch of agents, for the provisioning systems registering many agents at once.
+type BulkEnrollRequest struct {
+	// Agents The agents to enroll.
+	// The number of agents is capped by the server.limits.max_bulk_enroll_agents setting.
+	Agents []BulkEnrollAgent `json:"agents"`
+}
+
+// BulkEnrollResult The result of the enrollment of an agent of a bulk enroll request.
+// The response is a stream of results, a JSON object per line, in batches of the order of the request.
+type BulkEnrollResult struct {
+	// AccessApiKey The ApiKey token that fleet-server has generated for the agent.
+	AccessApiKey *string `json:"access_api_key,omitempty"`
+
+	// AccessApiKeyId The id of the ApiKey that fleet-server has generated for the agent.
+	AccessApiKeyId *string `json:"access_api_key_id,omitempty"`
+
+	// Error The error type, when the agent is not enrolled.
+	Error *string `json:"error,omitempty"`
+
+	// Id The ID of the agent.
+	Id string `json:"id"`
+
+	// Index The position of the agent in the request.
+	Index int `json:"index"`
+
+	// Message The error message, when the agent is not enrolled.
+	Message *string `json:"message,omitempty"`
+
+	// PolicyId The ID of the policy of the agent.
+	PolicyId string `json:"policy_id"`
+
+	// Status The HTTP status of the enrollment of the agent, 201 when the agent is enrolled.
+	Status int `json:"status"`
+}
+
+// CheckinComponent A component the agent is running with its health and the health of its units.
+type CheckinComponent struct {
+	// Id The component ID.
+	Id string `json:"id"`
+
+	// Message The component status message.
+	Message *string `json:"message,omitempty"`
+
+	// Status The component status, for example HEALTHY, DEGRADED or FAILED.
+	Status string `json:"status"`
+
+	// Type The component type, for example filebeat or metricbeat.
+	Type  *string        `json:"type,omitempty"`
+	Units *[]CheckinUnit `json:"units,omitempty"`
+}
+
 // CheckinRequest defines model for checkinRequest.
 type CheckinRequest struct {
 	// AckToken The ack_token form a previous response if the agent has checked in before.
 	// Translated to a sequence number in fleet-server in order to retrieve any new actions for the agent from the last checkin.
-	AckToken *string `json:"ack_token,omitempty"`
+	AckToken *string `json:"ack_token,omitempty" validate:"max=512"`
 
-	// Components An embedded JSON object that holds component information that the agent is running.
+	// Components An embedded JSON array of the components the agent is running, each item is a checkinComponent.
 	// Defined in fleet-server as a `json.RawMessage`, defined as an object in the elastic-agent.
 	// fleet-server will update the components in an agent record if they differ from this object.
+	// The components are stored sorted by ID with their messages and total size capped, the components over the cap are replaced by a component with the fleet-server-truncated ID.
+	// The worst status of the components is reflected in the checkin status of the agent.
 	Components *json.RawMessage `json:"components,omitempty"`
 
 	// LocalMetadata An embedded JSON object that holds meta-data values.
@@ -295,7 +492,7 @@ type CheckinRequest struct {
 	LocalMetadata *json.RawMessage `json:"local_metadata,omitempty"`
 
 	// Message State message, may be overridden or use the error message of a failing component.
-	Message string `json:"message"`
+	Message string `json:"message" validate:"max=16384"`
 
 	// PollTimeout An optional timeout value that informs fleet-server of when a client will time out on it's checkin request.
 	// If not specified fleet-server will use the timeout values specified in the config (defaults to 5m polling and a 10m write timeout).
@@ -304,7 +501,8 @@ type CheckinRequest struct {
 	PollTimeout *string `json:"poll_timeout,omitempty"`
 
 	// Status The agent state, inferred from agent control protocol states.
-	Status CheckinRequestStatus `json:"status"`
+	// The statuses outside of the enum are accepted and logged, the agents may report states fleet-server does not know yet.
+	Status CheckinRequestStatus `json:"status" validate:"required"`
 
 	// UpgradeDetails Additional details describing the status of an UPGRADE action delivered by the client (agent) on checkin.
 	UpgradeDetails *UpgradeDetails `json:"upgrade_details,omitempty"`
@@ -323,6 +521,79 @@ type CheckinResponse struct {
 
 	// Actions A list of actions that the agent must execute.
 	Actions *[]Action `json:"actions,omitempty"`
+
+	// NextCheckinDelay The delay the agent should wait before its next checkin, as a duration such as 30s.
+	// The server raises it with its load to spread the checkins of the agents out.
+	NextCheckinDelay *string `json:"next_checkin_delay,omitempty"`
+}
+
+// CheckinResponseV2 The checkin response sent to the agents that negotiate the 2025-06-01 API version.
+// It holds the same fields as checkinResponse, the data of each action is in the field of its type.
+type CheckinResponseV2 struct {
+	// AckToken The acknowlegment token used to indicate action delivery.
+	AckToken *string `json:"ack_token,omitempty"`
+
+	// Action The action result. Set to "checkin".
+	Action string `json:"action"`
+
+	// Actions A list of actions that the agent must execute.
+	Actions *[]ActionV2 `json:"actions,omitempty"`
+
+	// NextCheckinDelay The delay the agent should wait before its next checkin, as a duration such as 30s.
+	// The server raises it with its load to spread the checkins of the agents out.
+	NextCheckinDelay *string `json:"next_checkin_delay,omitempty"`
+}
+
+// CheckinUnit A unit of a component, an input or an output, with its health.
+type CheckinUnit struct {
+	// Id The unit ID.
+	Id string `json:"id"`
+
+	// Message The unit status message.
+	Message *string `json:"message,omitempty"`
+
+	// Status The unit status, for example HEALTHY, DEGRADED or FAILED.
+	Status string `json:"status"`
+
+	// Type The unit type, input or output.
+	Type *string `json:"type,omitempty"`
+}
+
+// CreateActionsRequest Request to create an action targeting a list of agents, all agents enrolled in a policy, all agents with a tag, or the agents matching a filter.
+type CreateActionsRequest struct {
+	// Agents The IDs of the agents the action targets. Mutually exclusive with policy_id, tag and target.
+	Agents *[]string `json:"agents,omitempty"`
+
+	// Data The action payload.
+	// Defined in fleet-server as a `json.RawMessage`; the contents depend on the action type.
+	Data *json.RawMessage `json:"data,omitempty"`
+
+	// Expiration The action expiration date/time. Agents will not receive the action after this time.
+	Expiration *time.Time `json:"expiration,omitempty"`
+
+	// InputType The input type the action should be routed to, used with INPUT_ACTION actions.
+	InputType *string `json:"input_type,omitempty"`
+
+	// PolicyId The ID of a policy; the action targets all active agents enrolled in it. Mutually exclusive with agents, tag and target.
+	// The number of targeted agents is capped by the server.limits.max_action_targets setting.
+	PolicyId *string `json:"policy_id,omitempty"`
+
+	// Tag A tag; the action targets all active agents with the tag. Mutually exclusive with agents, policy_id and target.
+	// The number of targeted agents is capped by the server.limits.max_action_targets setting.
+	Tag *string `json:"tag,omitempty"`
+
+	// Target A filter of the agents the action targets. Mutually exclusive with agents, policy_id and tag.
+	// A single action document is created, it is not capped by the server.limits.max_action_targets setting.
+	Target *ActionTarget `json:"target,omitempty"`
+
+	// Type The action type.
+	Type string `json:"type"`
+}
+
+// CreateActionsAPIResponse Response to a create actions request.
+type CreateActionsAPIResponse struct {
+	// ActionIds The ID of the created action, it is shared by the action documents the targeted agents are split into.
+	ActionIds []string `json:"action_ids"`
 }
 
 // DiagnosticsEvent defines model for diagnosticsEvent.
@@ -342,6 +613,9 @@ type DiagnosticsEvent struct {
 	// For some actions (such as UPGRADE actions) it may result in the action being marked as failed.
 	Error *string `json:"error,omitempty"`
 
+	// ErrorCode An optional code that categorizes the error. Only used when the error attribute is set.
+	ErrorCode *string `json:"error_code,omitempty"`
+
 	// Message An acknowlegement message. The elastic-agent inserts the action ID and action type into this message.
 	Message string `json:"message"`
 
@@ -378,7 +652,7 @@ type EnrollMetadata struct {
 
 	// Tags User provided tags for the agent.
 	// fleet-server will pass the tags to the agent record on enrollment.
-	Tags []string `json:"tags"`
+	Tags []string `json:"tags" validate:"max=100"`
 
 	// UserProvided An embedded JSON object that holds user-provided meta-data values.
 	// Defined in fleet-server as a `json.RawMessage`.
@@ -394,14 +668,14 @@ type EnrollRequest struct {
 	// EnrollmentId The enrollment ID of the agent.
 	// To replace an agent on enroll fail.
 	// The existing agent with a matching enrollment_id will be deleted if it never checked in. The new agent will be enrolled with the enrollment_id.
-	EnrollmentId *string `json:"enrollment_id,omitempty"`
+	EnrollmentId *string `json:"enrollment_id,omitempty" validate:"max=512"`
 
 	// Id The ID of the agent.
 	// This is the ID that will be used to reference this agent, if no ID is passed one will be generated.
 	// If another agent is enrolled with the same ID the other agent will no longer be able to communicate,
 	// this new agent is considered a replacement of the other agent. The other agent will be able to continue
 	// sending data to ES.
-	Id *string `json:"id,omitempty"`
+	Id *string `json:"id,omitempty" validate:"max=512"`
 
 	// Metadata Metadata associated with the agent that is enrolling to fleet.
 	Metadata EnrollMetadata `json:"metadata"`
@@ -409,14 +683,14 @@ type EnrollRequest struct {
 	// ReplaceToken The replacement token of the agent.
 	// Provided when an agent could replace an existing agent. This token must match the original enrollment of
 	// that agent otherwise it will not be able to enroll.
-	ReplaceToken *string `json:"replace_token,omitempty"`
+	ReplaceToken *string `json:"replace_token,omitempty" validate:"max=1024"`
 
 	// SharedId The shared ID of the agent.
 	// To support pre-existing installs.
 	//
 	// Never implemented.
 	// Deprecated:
-	SharedId *string `json:"shared_id,omitempty"`
+	SharedId *string `json:"shared_id,omitempty" validate:"max=512"`
 
 	// Type The enrollment type of the agent.
 	// The agent only supports the PERMANENT value.
@@ -528,18 +802,21 @@ type EventType string
 // GenericEvent A generic ack event for an action. Includes an optional error attribute.
 type GenericEvent struct {
 	// ActionId The action ID.
-	ActionId string `json:"action_id"`
+	ActionId string `json:"action_id" validate:"max=512"`
 
 	// AgentId The ID of the agent that executed the action.
-	AgentId string `json:"agent_id"`
+	AgentId string `json:"agent_id" validate:"max=512"`
 
 	// Error An error message.
 	// If this is non-empty an error has occured when executing the action.
 	// For some actions (such as UPGRADE actions) it may result in the action being marked as failed.
 	Error *string `json:"error,omitempty"`
 
+	// ErrorCode An optional code that categorizes the error. Only used when the error attribute is set.
+	ErrorCode *string `json:"error_code,omitempty"`
+
 	// Message An acknowlegement message. The elastic-agent inserts the action ID and action type into this message.
-	Message string `json:"message"`
+	Message string `json:"message" validate:"max=16384"`
 
 	// Subtype The subtype of the ack event.
 	// The elastic-agent will only generate ACKNOWLEDGED events.
@@ -588,6 +865,9 @@ type InputEvent struct {
 	// For some actions (such as UPGRADE actions) it may result in the action being marked as failed.
 	Error *string `json:"error,omitempty"`
 
+	// ErrorCode An optional code that categorizes the error. Only used when the error attribute is set.
+	ErrorCode *string `json:"error_code,omitempty"`
+
 	// Message An acknowlegement message. The elastic-agent inserts the action ID and action type into this message.
 	Message string `json:"message"`
 
@@ -616,6 +896,45 @@ type InputEvent struct {
 	Type EventType `json:"type"`
 }
 
+// OperationResponse The progress of a batch operation updating agents.
+type OperationResponse struct {
+	// Cursor The position of the next page of agents.
+	Cursor *string `json:"cursor,omitempty"`
+
+	// Error The error that interrupted the operation.
+	Error *string `json:"error,omitempty"`
+
+	// Failed The number of agents that could not be updated.
+	Failed int `json:"failed"`
+
+	// Filter The parameters selecting the agents of the operation.
+	Filter *map[string]string `json:"filter,omitempty"`
+
+	// Id The operation ID.
+	Id string `json:"id"`
+
+	// Resumed The number of times the operation was resumed after an interruption.
+	Resumed *int `json:"resumed,omitempty"`
+
+	// StartedAt The time the operation started.
+	StartedAt time.Time `json:"started_at"`
+
+	// Status The status of the operation, running or completed. An interrupted operation stays running until it is resumed.
+	Status string `json:"status"`
+
+	// Succeeded The number of agents that were updated.
+	Succeeded int `json:"succeeded"`
+
+	// Total The number of agents processed.
+	Total int `json:"total"`
+
+	// Type The type of the operation, one of offline_agents, stale_unenrollments or reassign.
+	Type string `json:"type"`
+
+	// UpdatedAt The time of the last progress of the operation.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 // PolicyData The full policy that an agent should run after combining with local configuration/env vars.
 type PolicyData struct {
 	// Agent Agent configuration details associated with the policy. May include configuration toggling monitoring, uninstallation protection, etc.
@@ -646,11 +965,114 @@ type PolicyData struct {
 	Signed *ActionSignature `json:"signed,omitempty" yaml:"signed"`
 }
 
+// PolicyFetch Set when the policy revision is larger than the max size of the policies delivered in the checkin responses.
+// The policy of the action then only holds the id, revision, outputs and secret_paths of the policy, the agent fetches the other fields from the policy endpoint.
+type PolicyFetch struct {
+	// Path The path of the policy endpoint serving the revision.
+	Path string `json:"path"`
+
+	// Size The size of the policy returned by the policy endpoint, in bytes.
+	Size int `json:"size"`
+}
+
+// PolicyRevisionAgents The number of agents on a revision of a policy.
+type PolicyRevisionAgents struct {
+	// Agents The number of agents on the revision.
+	Agents int `json:"agents"`
+
+	// RevisionIdx The revision of the policy.
+	RevisionIdx int64 `json:"revision_idx"`
+}
+
+// PolicyRolloutAgent An agent that is not on the latest revision of its policy.
+type PolicyRolloutAgent struct {
+	// AgentId The agent ID.
+	AgentId string `json:"agent_id"`
+
+	// LastCheckin The time of the last checkin of the agent.
+	LastCheckin *time.Time `json:"last_checkin,omitempty"`
+
+	// RevisionIdx The revision of the policy the agent runs.
+	RevisionIdx int64 `json:"revision_idx"`
+}
+
+// PolicyRolloutResponse The distribution of the active agents of a policy over its revisions during a rollout.
+// The counts are aggregated periodically and may be up to a minute old.
+type PolicyRolloutResponse struct {
+	// PolicyId The policy ID.
+	PolicyId string `json:"policy_id"`
+
+	// RevisionCreatedAt The time the latest revision of the policy was created.
+	RevisionCreatedAt *time.Time `json:"revision_created_at,omitempty"`
+
+	// RevisionIdx The latest revision of the policy.
+	RevisionIdx int64 `json:"revision_idx"`
+
+	// Revisions The number of agents on each revision of the policy, the latest revision first.
+	Revisions []PolicyRevisionAgents `json:"revisions"`
+
+	// Stuck The number of agents that are on an older revision than the latest one for longer than the stuck threshold.
+	Stuck int `json:"stuck"`
+
+	// StuckAgents The stuck agents on the oldest revisions, up to 100 agents.
+	StuckAgents []PolicyRolloutAgent `json:"stuck_agents"`
+
+	// Total The number of active agents enrolled in the policy.
+	Total int `json:"total"`
+
+	// UpdatedAt The time the agents were counted.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ReassignAgentsRequest Request to assign a list of agents, or the agents enrolled in a policy, to another policy.
+type ReassignAgentsRequest struct {
+	// Agents The IDs of the agents to reassign. Mutually exclusive with source_policy_id.
+	Agents *[]string `json:"agents,omitempty"`
+
+	// OperationId The ID of the operation tracking the progress of the request, generated when not set.
+	// A request retried with the ID of an interrupted operation resumes it from its last page of agents,
+	// and a request retried with the ID of a completed operation returns its outcome without updating the agents again.
+	OperationId *string `json:"operation_id,omitempty"`
+
+	// PolicyId The ID of the policy the agents are assigned to. The policy must exist.
+	PolicyId string `json:"policy_id"`
+
+	// SourcePolicyId The ID of a policy; all active agents enrolled in it are reassigned. Mutually exclusive with agents.
+	SourcePolicyId *string `json:"source_policy_id,omitempty"`
+
+	// Tag Only reassign the agents of source_policy_id with the tag.
+	Tag *string `json:"tag,omitempty"`
+}
+
+// ReassignAgentsAPIResponse The outcome of a reassign agents request.
+type ReassignAgentsAPIResponse struct {
+	// Failed The number of agents that could not be updated.
+	Failed int `json:"failed"`
+
+	// OperationId The ID of the operation tracking the progress of the request.
+	OperationId string `json:"operation_id"`
+
+	// PolicyId The ID of the policy the agents are assigned to.
+	PolicyId string `json:"policy_id"`
+
+	// Reassigned The number of agents that were assigned to the policy.
+	Reassigned int `json:"reassigned"`
+
+	// Total The number of agents the request selected.
+	Total int `json:"total"`
+}
+
 // StatusAPIResponse Status response information.
 type StatusAPIResponse struct {
 	// Name Service name.
 	Name string `json:"name"`
 
+	// Outputs Health of the remote Elasticsearch outputs by name, included in the response to an authorized status request.
+	Outputs *map[string]StatusResponseOutput `json:"outputs,omitempty"`
+
+	// Proxy Health of the proxy in front of Elasticsearch included in the response to an authorized status request.
+	Proxy *StatusResponseProxy `json:"proxy,omitempty"`
+
 	// Status A Unit state that fleet-server may report.
 	// Unit state is defined in the elastic-agent-client specification.
 	Status StatusResponseStatus `json:"status"`
@@ -659,6 +1081,33 @@ type StatusAPIResponse struct {
 	Version *StatusResponseVersion `json:"version,omitempty"`
 }
 
+// StatusResponseOutput Health of a remote Elasticsearch output included in the response to an authorized status request.
+type StatusResponseOutput struct {
+	// Message The reason of a degraded state.
+	Message *string `json:"message,omitempty"`
+
+	// State The last known health state of the output, healthy or degraded.
+	State string `json:"state"`
+
+	// UpdatedAt The date-time the state was last reported.
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// StatusResponseProxy Health of the proxy in front of Elasticsearch included in the response to an authorized status request.
+type StatusResponseProxy struct {
+	// Message The failure of the proxy of a degraded state.
+	Message *string `json:"message,omitempty"`
+
+	// State The health state of the proxy reported by the last request sent through it, healthy or degraded.
+	State string `json:"state"`
+
+	// UpdatedAt The date-time the state was last reported.
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+
+	// Url The URL of the proxy, without its password.
+	Url string `json:"url"`
+}
+
 // StatusResponseStatus A Unit state that fleet-server may report.
 // Unit state is defined in the elastic-agent-client specification.
 type StatusResponseStatus string
@@ -688,6 +1137,9 @@ type UpgradeEvent struct {
 	// For some actions (such as UPGRADE actions) it may result in the action being marked as failed.
 	Error *string `json:"error,omitempty"`
 
+	// ErrorCode An optional code that categorizes the error. Only used when the error attribute is set.
+	ErrorCode *string `json:"error_code,omitempty"`
+
 	// Message An acknowlegement message. The elastic-agent inserts the action ID and action type into this message.
 	Message string `json:"message"`
 
@@ -727,7 +1179,7 @@ type UpgradeEvent struct {
 // UpgradeDetails Additional details describing the status of an UPGRADE action delivered by the client (agent) on checkin.
 type UpgradeDetails struct {
 	// ActionId The upgrade action ID the details are associated with.
-	ActionId string `json:"action_id"`
+	ActionId string `json:"action_id" validate:"max=512"`
 
 	// Metadata Upgrade status metadata. Determined by state.
 	Metadata *UpgradeDetails_Metadata `json:"metadata,omitempty"`
@@ -736,7 +1188,7 @@ type UpgradeDetails struct {
 	State UpgradeDetailsState `json:"state"`
 
 	// TargetVersion The version the agent should upgrade to.
-	TargetVersion string `json:"target_version"`
+	TargetVersion string `json:"target_version" validate:"max=256"`
 }
 
 // UpgradeDetails_Metadata Upgrade status metadata. Determined by state.
@@ -840,12 +1292,18 @@ type UploadCompleteRequest struct {
 // ApiVersion defines model for apiVersion.
 type ApiVersion = string
 
+// IfNoneMatch defines model for ifNoneMatch.
+type IfNoneMatch = string
+
 // RequestId defines model for requestId.
 type RequestId = string
 
 // UserAgent defines model for userAgent.
 type UserAgent = string
 
+// ActionNotFound Error processing request.
+type ActionNotFound = Error
+
 // AgentNotFound Error processing request.
 type AgentNotFound = Error
 
@@ -867,6 +1325,12 @@ type InternalServerError = Error
 // KeyNotEnabled Error processing request.
 type KeyNotEnabled = Error
 
+// OperationNotFound Error processing request.
+type OperationNotFound = Error
+
+// PolicyNotFound Error processing request.
+type PolicyNotFound = Error
+
 // Throttle Error processing request.
 type Throttle = Error
 
@@ -882,6 +1346,42 @@ type GetPGPKeyParams struct {
 	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
 }
 
+// CreateActionsParams defines parameters for CreateActions.
+type CreateActionsParams struct {
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+}
+
+// GetActionResultsParams defines parameters for GetActionResults.
+type GetActionResultsParams struct {
+	// Agents Include the status of each agent in the response.
+	Agents *bool `form:"agents,omitempty" json:"agents,omitempty"`
+
+	// From The offset of the first agent to include.
+	From *int `form:"from,omitempty" json:"from,omitempty"`
+
+	// Size The max number of agents to include.
+	Size *int `form:"size,omitempty" json:"size,omitempty"`
+
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+}
+
+// BulkEnrollParams defines parameters for BulkEnroll.
+type BulkEnrollParams struct {
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+}
+
 // AgentEnrollParams defines parameters for AgentEnroll.
 type AgentEnrollParams struct {
 	// UserAgent The user-agent header that is sent.
@@ -894,6 +1394,20 @@ type AgentEnrollParams struct {
 
 	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
 	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+
+	// IdempotencyKey A key identifying the enrollment, for clients that retry the enroll request after a timeout.
+	// A retry with the same key and body replays the enrollment of the same agent instead of enrolling another one, until the key expires.
+	// A request reusing the key with a different body, or while a request with the same key is in progress, is rejected with a 409.
+	IdempotencyKey *string `json:"Idempotency-Key,omitempty"`
+}
+
+// ReassignAgentsParams defines parameters for ReassignAgents.
+type ReassignAgentsParams struct {
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
 }
 
 // AgentAcksParams defines parameters for AgentAcks.
@@ -933,6 +1447,24 @@ type AgentCheckinParams struct {
 	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
 }
 
+// AddAgentTagsParams defines parameters for AddAgentTags.
+type AddAgentTagsParams struct {
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+}
+
+// RemoveAgentTagParams defines parameters for RemoveAgentTag.
+type RemoveAgentTagParams struct {
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+}
+
 // ArtifactParams defines parameters for Artifact.
 type ArtifactParams struct {
 	// XRequestId The request tracking ID for APM.
@@ -940,6 +1472,10 @@ type ArtifactParams struct {
 
 	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
 	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+
+	// IfNoneMatch The entity tags of the response the client already has, as sent in the ETag header of a previous response.
+	// A response matching one of them is replaced by a 304 without body. Malformed entity tags are ignored.
+	IfNoneMatch *IfNoneMatch `json:"If-None-Match,omitempty"`
 }
 
 // GetFileParams defines parameters for GetFile.
@@ -951,6 +1487,40 @@ type GetFileParams struct {
 	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
 }
 
+// GetOperationParams defines parameters for GetOperation.
+type GetOperationParams struct {
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+}
+
+// GetPolicyRolloutParams defines parameters for GetPolicyRollout.
+type GetPolicyRolloutParams struct {
+	// StuckThreshold The time after which the agents that are not on the latest revision of the policy are stuck, as a duration such as 15m.
+	StuckThreshold *string `form:"stuck_threshold,omitempty" json:"stuck_threshold,omitempty"`
+
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+}
+
+// GetPolicyParams defines parameters for GetPolicy.
+type GetPolicyParams struct {
+	// XRequestId The request tracking ID for APM.
+	XRequestId *RequestId `json:"X-Request-Id,omitempty"`
+
+	// ElasticApiVersion The API version to use, format should be "YYYY-MM-DD"
+	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
+
+	// IfNoneMatch The entity tags of the response the client already has, as sent in the ETag header of a previous response.
+	// A response matching one of them is replaced by a 304 without body. Malformed entity tags are ignored.
+	IfNoneMatch *IfNoneMatch `json:"If-None-Match,omitempty"`
+}
+
 // UploadBeginParams defines parameters for UploadBegin.
 type UploadBeginParams struct {
 	// XRequestId The request tracking ID for APM.
@@ -990,9 +1560,18 @@ type StatusParams struct {
 	ElasticApiVersion *ApiVersion `json:"elastic-api-version,omitempty"`
 }
 
+// CreateActionsJSONRequestBody defines body for CreateActions for application/json ContentType.
+type CreateActionsJSONRequestBody = CreateActionsRequest
+
+// BulkEnrollJSONRequestBody defines body for BulkEnroll for application/json ContentType.
+type BulkEnrollJSONRequestBody = BulkEnrollRequest
+
 // AgentEnrollJSONRequestBody defines body for AgentEnroll for application/json ContentType.
 type AgentEnrollJSONRequestBody = EnrollRequest
 
+// ReassignAgentsJSONRequestBody defines body for ReassignAgents for application/json ContentType.
+type ReassignAgentsJSONRequestBody = ReassignAgentsRequest
+
 // AgentAcksJSONRequestBody defines body for AgentAcks for application/json ContentType.
 type AgentAcksJSONRequestBody = AckRequest
 
@@ -1002,6 +1581,9 @@ type AuditUnenrollJSONRequestBody = AuditUnenrollRequest
 // AgentCheckinJSONRequestBody defines body for AgentCheckin for application/json ContentType.
 type AgentCheckinJSONRequestBody = CheckinRequest
 
+// AddAgentTagsJSONRequestBody defines body for AddAgentTags for application/json ContentType.
+type AddAgentTagsJSONRequestBody = AgentTagsRequest
+
 // UploadBeginJSONRequestBody defines body for UploadBegin for application/json ContentType.
 type UploadBeginJSONRequestBody = UploadBeginRequest
 